@@ -0,0 +1,264 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	//"github.com/ibrahimozekici/lora-api/go/v3/ns"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+var (
+	cleanupInactiveDevices    int
+	cleanupOrphanedQueueItems bool
+	cleanupSessions           int
+	cleanupEvents             int
+	cleanupDryRun             bool
+	cleanupYes                bool
+)
+
+// errCleanupDryRun is returned within the cleanup transaction to roll back
+// the changes on a dry-run.
+var errCleanupDryRun = errors.New("dry-run")
+
+// cleanupResult defines the number of purged rows of a cleanup task.
+type cleanupResult struct {
+	name  string
+	count int64
+}
+
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup",
+	Short: "Purge inactive devices and stale data",
+	Long: `Purge inactive devices and stale data.
+Each flag enables a cleanup task:
+  --inactive-devices     devices not seen for the given number of days (also
+                         removed from the network-server)
+  --orphaned-queue-items stored objects and expiries of device-queue items
+                         which are no longer in the network-server queue
+  --sessions             device-session history older than the given number of
+                         days (the active session is kept) and multicast
+                         sessions which ended before
+  --events               frame-logs and device metrics older than the given
+                         number of days
+With --dry-run, the number of rows that would be purged is printed without
+making any changes.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if cleanupInactiveDevices == 0 && !cleanupOrphanedQueueItems && cleanupSessions == 0 && cleanupEvents == 0 {
+			return errors.New("set at least one of --inactive-devices, --orphaned-queue-items, --sessions or --events")
+		}
+
+		if err := storage.Setup(config.C); err != nil {
+			return errors.Wrap(err, "setup storage error")
+		}
+
+		if err := networkserver.Setup(config.C); err != nil {
+			return errors.Wrap(err, "setup networkserver error")
+		}
+
+		ctx := context.Background()
+		now := time.Now()
+		var results []cleanupResult
+
+		if cleanupInactiveDevices > 0 {
+			n, err := cleanupDevices(ctx, now.Add(-cleanupDays(cleanupInactiveDevices)))
+			if err != nil {
+				return err
+			}
+			results = append(results, cleanupResult{"inactive devices", n})
+		}
+
+		// the database cleanup tasks are executed within a transaction, which
+		// is rolled back on a dry-run
+		err := storage.Transaction(func(tx sqlx.Ext) error {
+			if cleanupOrphanedQueueItems {
+				n, err := cleanupDeviceQueueItemData(ctx, tx)
+				if err != nil {
+					return errors.Wrap(err, "cleanup device-queue item data error")
+				}
+				results = append(results, cleanupResult{"orphaned device-queue item data", n})
+			}
+
+			if cleanupSessions > 0 {
+				before := now.Add(-cleanupDays(cleanupSessions))
+
+				n, err := storage.DeleteDeviceSessionsBefore(ctx, tx, before)
+				if err != nil {
+					return errors.Wrap(err, "delete device-sessions error")
+				}
+				results = append(results, cleanupResult{"device-sessions", n})
+
+				n, err = storage.DeleteExpiredRemoteMulticastClassCSessions(ctx, tx, before)
+				if err != nil {
+					return errors.Wrap(err, "delete remote multicast sessions error")
+				}
+				results = append(results, cleanupResult{"remote multicast sessions", n})
+			}
+
+			if cleanupEvents > 0 {
+				before := now.Add(-cleanupDays(cleanupEvents))
+
+				// the frame-logs and metrics might be stored in the
+				// organization schemas
+				var frames, metrics int64
+				err := storage.ForEachOrganization(ctx, tx, func(db sqlx.Ext) error {
+					n, err := storage.DeleteAllDeviceFrameLogsBefore(ctx, db, before)
+					if err != nil {
+						return errors.Wrap(err, "delete frame-logs error")
+					}
+					frames += n
+
+					n, err = storage.DeleteAllDeviceMetricsBefore(ctx, db, before)
+					if err != nil {
+						return errors.Wrap(err, "delete device metrics error")
+					}
+					metrics += n
+
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				results = append(results, cleanupResult{"frame-logs", frames}, cleanupResult{"device metrics", metrics})
+			}
+
+			if cleanupDryRun {
+				return errCleanupDryRun
+			}
+			return nil
+		})
+		if err != nil && err != errCleanupDryRun {
+			return err
+		}
+
+		verb := "purged"
+		if cleanupDryRun {
+			verb = "to purge"
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "TASK\t%s\n", strings.ToUpper(verb))
+		for _, r := range results {
+			fmt.Fprintf(w, "%s\t%d\n", r.name, r.count)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if cleanupDryRun {
+			fmt.Println("\ndry-run: no changes were made")
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	cleanupCmd.Flags().IntVar(&cleanupInactiveDevices, "inactive-devices", 0, "delete devices not seen for the given number of days (0 = disabled)")
+	cleanupCmd.Flags().BoolVar(&cleanupOrphanedQueueItems, "orphaned-queue-items", false, "delete the data of device-queue items which are no longer queued")
+	cleanupCmd.Flags().IntVar(&cleanupSessions, "sessions", 0, "delete sessions older than the given number of days (0 = disabled)")
+	cleanupCmd.Flags().IntVar(&cleanupEvents, "events", 0, "delete frame-logs and device metrics older than the given number of days (0 = disabled)")
+	cleanupCmd.Flags().BoolVar(&cleanupDryRun, "dry-run", false, "print what would be purged without making any changes")
+	cleanupCmd.Flags().BoolVar(&cleanupYes, "yes", false, "do not ask for confirmation before deleting devices")
+}
+
+func cleanupDays(days int) time.Duration {
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// cleanupDevices deletes the devices which have not been seen since the
+// given timestamp. As the devices are also deleted from the network-server,
+// the deletion must be confirmed unless --yes is set. On a dry-run, only the
+// number of devices is returned.
+func cleanupDevices(ctx context.Context, before time.Time) (int64, error) {
+	devEUIs, err := storage.GetInactiveDevices(ctx, storage.DB(), before)
+	if err != nil {
+		return 0, errors.Wrap(err, "get inactive devices error")
+	}
+
+	if cleanupDryRun || len(devEUIs) == 0 {
+		return int64(len(devEUIs)), nil
+	}
+
+	if !cleanupYes {
+		fmt.Printf("%d device(s) not seen since %s will be deleted, including their data and the devices on the network-server.\n", len(devEUIs), before.Format("2006-01-02"))
+		fmt.Print("This can not be undone. Type 'yes' to continue: ")
+		answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return 0, errors.Wrap(err, "read confirmation error")
+		}
+		if strings.TrimSpace(answer) != "yes" {
+			return 0, errors.New("aborted")
+		}
+	}
+
+	var count int64
+	for _, devEUI := range devEUIs {
+		err := storage.Transaction(func(tx sqlx.Ext) error {
+			return storage.DeleteDevice(ctx, tx, devEUI)
+		})
+		if err != nil {
+			if errors.Cause(err) == storage.ErrDoesNotExist {
+				continue
+			}
+			return count, errors.Wrapf(err, "delete device %s error", devEUI)
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// cleanupDeviceQueueItemData deletes the stored objects and expiries of the
+// device-queue items which are no longer in the device-queue of the
+// network-server (e.g. flushed by the network-server).
+func cleanupDeviceQueueItemData(ctx context.Context, db sqlx.Ext) (int64, error) {
+	devEUIs, err := storage.GetDevicesWithDeviceQueueItemData(ctx, db)
+	if err != nil {
+		return 0, errors.Wrap(err, "get devices error")
+	}
+
+	var count int64
+	for _, devEUI := range devEUIs {
+		n, err := storage.GetNetworkServerForDevEUI(ctx, db, devEUI)
+		if err != nil {
+			return 0, errors.Wrap(err, "get network-server error")
+		}
+
+		nsClient, err := networkserver.GetPool().Get(n.Server, []byte(n.CACert), []byte(n.TLSCert), []byte(n.TLSKey))
+		if err != nil {
+			return 0, errors.Wrap(err, "get network-server client error")
+		}
+
+		resp, err := nsClient.GetDeviceQueueItemsForDevEUI(ctx, &ns.GetDeviceQueueItemsForDevEUIRequest{
+			DevEui: devEUI[:],
+		})
+		if err != nil {
+			return 0, errors.Wrapf(err, "get device-queue items %s error", devEUI)
+		}
+
+		var fCnts []uint32
+		for _, qi := range resp.Items {
+			fCnts = append(fCnts, qi.FCnt)
+		}
+
+		c, err := storage.DeleteDeviceQueueItemDataExcept(ctx, db, devEUI, fCnts)
+		if err != nil {
+			return 0, errors.Wrapf(err, "delete device-queue item data %s error", devEUI)
+		}
+		count += c
+	}
+
+	return count, nil
+}
@@ -0,0 +1,288 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/integration/mqtt"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+var (
+	configCheckConnectivity bool
+	configCheckTimeout      time.Duration
+)
+
+var configCheckCmd = &cobra.Command{
+	Use:   "configcheck",
+	Short: "Validate the configuration and (optionally) test connectivity",
+	Long: `Validate the configuration and (optionally) test connectivity.
+The configuration file is parsed and the required fields are validated. When
+--connectivity is set, the connection to PostgreSQL, Redis, the MQTT broker
+(when the mqtt integration is enabled) and the network-servers is tested.
+The command exits with a non-zero exit code when one of the checks fails.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		checks := configChecks(config.C)
+		if configCheckConnectivity {
+			checks = append(checks, connectivityChecks(config.C, configCheckTimeout)...)
+		}
+
+		if n := runConfigChecks(os.Stdout, checks); n != 0 {
+			return fmt.Errorf("%d configuration check(s) failed", n)
+		}
+		return nil
+	},
+}
+
+func init() {
+	configCheckCmd.Flags().BoolVar(&configCheckConnectivity, "connectivity", false, "test the connectivity to PostgreSQL, Redis, MQTT and the network-servers")
+	configCheckCmd.Flags().DurationVar(&configCheckTimeout, "timeout", 5*time.Second, "timeout of each connectivity check")
+}
+
+// configCheck defines a single configuration check.
+type configCheck struct {
+	name  string
+	check func() error
+}
+
+// runConfigChecks runs the given checks and prints the result of each check
+// to w. It returns the number of failed checks.
+func runConfigChecks(w io.Writer, checks []configCheck) int {
+	var failed int
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			failed++
+			fmt.Fprintf(w, "error\t%s: %s\n", c.name, err)
+		} else {
+			fmt.Fprintf(w, "ok\t%s\n", c.name)
+		}
+	}
+
+	fmt.Fprintf(w, "\n%d check(s), %d failed\n", len(checks), failed)
+	return failed
+}
+
+// configChecks returns the checks validating the given configuration.
+func configChecks(c config.Config) []configCheck {
+	return []configCheck{
+		{"general.log_format", func() error {
+			return checkOneOf(c.General.LogFormat, "text", "json")
+		}},
+		{"postgresql.dsn", func() error {
+			return checkRequired(c.PostgreSQL.DSN)
+		}},
+		{"postgresql tls", func() error {
+			return checkTLSFiles(c.PostgreSQL.CACert, c.PostgreSQL.TLSCert, c.PostgreSQL.TLSKey)
+		}},
+		{"redis.servers", func() error {
+			if len(c.Redis.Servers) == 0 {
+				return errors.New("at least one redis server must be configured")
+			}
+			return nil
+		}},
+		{"application_server.id", func() error {
+			if _, err := uuid.FromString(c.ApplicationServer.ID); err != nil {
+				return errors.Wrap(err, "must be a valid UUID")
+			}
+			return nil
+		}},
+		{"application_server.api", func() error {
+			if err := checkBind(c.ApplicationServer.API.Bind); err != nil {
+				return errors.Wrap(err, "bind")
+			}
+			return checkTLSFiles(c.ApplicationServer.API.CACert, c.ApplicationServer.API.TLSCert, c.ApplicationServer.API.TLSKey)
+		}},
+		{"application_server.external_api", func() error {
+			if err := checkBind(c.ApplicationServer.ExternalAPI.Bind); err != nil {
+				return errors.Wrap(err, "bind")
+			}
+			if c.ApplicationServer.ExternalAPI.JWTSecret == "" {
+				return errors.New("jwt_secret must be set (e.g. generate one using: openssl rand -base64 32)")
+			}
+			return checkTLSFiles("", c.ApplicationServer.ExternalAPI.TLSCert, c.ApplicationServer.ExternalAPI.TLSKey)
+		}},
+		{"application_server.integration.marshaler", func() error {
+			return checkOneOf(c.ApplicationServer.Integration.Marshaler, "json_v3", "json", "protobuf")
+		}},
+		{"application_server.integration.enabled", func() error {
+			for _, name := range c.ApplicationServer.Integration.Enabled {
+				if err := checkOneOf(name, "aws_sns", "azure_service_bus", "mqtt", "gcp_pub_sub", "kafka", "postgresql", "amqp"); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{"application_server.integration.mqtt", func() error {
+			if !configIntegrationEnabled(c, "mqtt") {
+				return nil
+			}
+			if c.ApplicationServer.Integration.MQTT.Server == "" {
+				return errors.New("server must be set when the mqtt integration is enabled")
+			}
+			mc := c.ApplicationServer.Integration.MQTT
+			return checkTLSFiles(mc.CACert, mc.TLSCert, mc.TLSKey)
+		}},
+		{"join_server", func() error {
+			if err := checkBind(c.JoinServer.Bind); err != nil {
+				return errors.Wrap(err, "bind")
+			}
+			return checkTLSFiles(c.JoinServer.CACert, c.JoinServer.TLSCert, c.JoinServer.TLSKey)
+		}},
+		{"metrics.timezone", func() error {
+			if _, err := time.LoadLocation(c.Metrics.Timezone); err != nil {
+				return errors.Wrap(err, "must be a valid IANA time-zone name")
+			}
+			return nil
+		}},
+		{"metrics.redis.aggregation_intervals", func() error {
+			for _, agg := range c.Metrics.Redis.AggregationIntervals {
+				if err := checkOneOf(strings.ToUpper(agg), "MINUTE", "HOUR", "DAY", "MONTH"); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+	}
+}
+
+// connectivityChecks returns the checks testing the connectivity to the
+// services configured in the given configuration.
+func connectivityChecks(c config.Config, timeout time.Duration) []configCheck {
+	checks := []configCheck{
+		{"postgresql connection", func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			return storage.CheckPostgreSQL(ctx, c)
+		}},
+		{"redis connection", func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			return storage.CheckRedis(ctx, c)
+		}},
+	}
+
+	if configIntegrationEnabled(c, "mqtt") {
+		checks = append(checks, configCheck{"mqtt connection", func() error {
+			return mqtt.CheckConnection(c.ApplicationServer.Integration.MQTT, timeout)
+		}})
+	}
+
+	checks = append(checks, configCheck{"network-server connections", func() error {
+		return checkNetworkServers(c, timeout)
+	}})
+
+	return checks
+}
+
+// checkNetworkServers tests the connection to each network-server stored in
+// the database.
+func checkNetworkServers(c config.Config, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// SetupPostgreSQL retries until the database is reachable, therefore
+	// the connection is tested first.
+	if err := storage.CheckPostgreSQL(ctx, c); err != nil {
+		return errors.New("skipped, postgresql is not reachable")
+	}
+	if err := storage.SetupPostgreSQL(c); err != nil {
+		return errors.Wrap(err, "setup postgresql error")
+	}
+	if err := networkserver.Setup(c); err != nil {
+		return errors.Wrap(err, "setup networkserver error")
+	}
+
+	nss, err := storage.GetNetworkServers(ctx, storage.DB(), storage.NetworkServerFilters{
+		Limit: 1000,
+	})
+	if err != nil {
+		return errors.Wrap(err, "get network-servers error")
+	}
+
+	var failed []string
+	for _, n := range nss {
+		nsClient, err := networkserver.GetPool().Get(n.Server, []byte(n.CACert), []byte(n.TLSCert), []byte(n.TLSKey))
+		if err == nil {
+			_, err = nsClient.GetVersion(ctx, &empty.Empty{})
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s (%s): %s", n.Name, n.Server, err))
+		}
+	}
+
+	if len(failed) != 0 {
+		return fmt.Errorf("%d of %d network-server(s) unreachable: %s", len(failed), len(nss), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// configIntegrationEnabled returns true when the given global integration
+// is enabled.
+func configIntegrationEnabled(c config.Config, name string) bool {
+	for _, n := range c.ApplicationServer.Integration.Enabled {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRequired returns an error when the given value is empty.
+func checkRequired(v string) error {
+	if v == "" {
+		return errors.New("must be set")
+	}
+	return nil
+}
+
+// checkOneOf returns an error when the given value is not one of the given
+// valid values.
+func checkOneOf(v string, valid ...string) error {
+	for _, vv := range valid {
+		if v == vv {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid value '%s', expected one of: %s", v, strings.Join(valid, ", "))
+}
+
+// checkBind returns an error when the given bind is not in the
+// [host]:port format.
+func checkBind(bind string) error {
+	if _, _, err := net.SplitHostPort(bind); err != nil {
+		return fmt.Errorf("invalid value '%s', expected [host]:port", bind)
+	}
+	return nil
+}
+
+// checkTLSFiles returns an error when the TLS certificate and key are not
+// both set or when one of the configured files can not be read.
+func checkTLSFiles(caCert, tlsCert, tlsKey string) error {
+	if (tlsCert == "") != (tlsKey == "") {
+		return errors.New("tls_cert and tls_key must both be set")
+	}
+
+	for _, f := range []string{caCert, tlsCert, tlsKey} {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			return errors.Wrap(err, "read file error")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/kms"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+var deviceKeysCmd = &cobra.Command{
+	Use:   "device-keys",
+	Short: "Manage the encryption of the device root keys",
+}
+
+var deviceKeysRotateDataKeyCmd = &cobra.Command{
+	Use:   "rotate-data-key",
+	Short: "Create a new data-key and re-encrypt all device root keys with it",
+	Long: `Create a new data-key and re-encrypt all device root keys with it.
+Device root keys which are stored unencrypted will be encrypted.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := setupDeviceKeysEncryption(); err != nil {
+			return err
+		}
+
+		n, err := storage.RotateDeviceKeysDataKey(context.Background(), storage.DB())
+		if err != nil {
+			return errors.Wrap(err, "rotate data-key error")
+		}
+
+		fmt.Printf("%d device-keys re-encrypted\n", n)
+		return nil
+	},
+}
+
+var deviceKeysRewrapCmd = &cobra.Command{
+	Use:   "rewrap",
+	Short: "Re-wrap the data-keys using the current key of the kms provider",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := setupDeviceKeysEncryption(); err != nil {
+			return err
+		}
+
+		n, err := storage.RewrapDeviceKeysDataKeys(context.Background(), storage.DB())
+		if err != nil {
+			return errors.Wrap(err, "rewrap data-keys error")
+		}
+
+		fmt.Printf("%d data-keys re-wrapped\n", n)
+		return nil
+	},
+}
+
+func init() {
+	deviceKeysCmd.AddCommand(deviceKeysRotateDataKeyCmd)
+	deviceKeysCmd.AddCommand(deviceKeysRewrapCmd)
+}
+
+func setupDeviceKeysEncryption() error {
+	if config.C.KMS.Provider == "" {
+		return errors.New("kms.provider must be configured")
+	}
+
+	if err := kms.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup kms error")
+	}
+
+	if err := storage.SetupPostgreSQL(config.C); err != nil {
+		return errors.Wrap(err, "setup postgresql error")
+	}
+
+	return nil
+}
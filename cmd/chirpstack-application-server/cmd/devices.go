@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/deviceio"
+	"github.com/ibrahimozekici/app-server2/internal/hsm"
+	"github.com/ibrahimozekici/app-server2/internal/kms"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+var (
+	devicesFormat          string
+	devicesFile            string
+	devicesAPI             string
+	devicesToken           string
+	devicesApplicationID   int64
+	devicesOrganizationID  int64
+	devicesDeviceProfileID string
+	devicesKeys            bool
+)
+
+var devicesCmd = &cobra.Command{
+	Use:   "devices",
+	Short: "Export and import devices",
+	Long: `Export and import devices as CSV or JSON.
+By default the database (and network-server) configured in the configuration
+file is used. When --api and --token are set, the external API of the given
+application-server is used instead.`,
+}
+
+var devicesExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the devices to a CSV or JSON file",
+	Long: `Export the devices to a CSV or JSON file.
+The export can be filtered by organization or application. The device root
+keys are only exported when --keys is set, keys stored in the HSM can not be
+exported.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := devicesFileFormat()
+		if err != nil {
+			return err
+		}
+
+		b, err := devicesBackend()
+		if err != nil {
+			return err
+		}
+
+		out := io.Writer(os.Stdout)
+		if devicesFile != "-" {
+			f, err := os.Create(devicesFile)
+			if err != nil {
+				return errors.Wrap(err, "create file error")
+			}
+			defer f.Close()
+			out = f
+		}
+
+		var n int
+		w := deviceio.NewWriter(out, format)
+		err = b.Export(context.Background(), deviceio.Filters{
+			OrganizationID: devicesOrganizationID,
+			ApplicationID:  devicesApplicationID,
+		}, devicesKeys, func(r deviceio.Record) error {
+			n++
+			return w.Write(r)
+		})
+		if err != nil {
+			return errors.Wrap(err, "export devices error")
+		}
+		if err := w.Close(); err != nil {
+			return errors.Wrap(err, "write file error")
+		}
+
+		fmt.Fprintf(os.Stderr, "%d devices exported\n", n)
+		return nil
+	},
+}
+
+var devicesImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import the devices from a CSV or JSON file",
+	Long: `Import the devices from a CSV or JSON file.
+The file uses the same format as the export. The application and
+device-profile of the imported devices can be overridden, e.g. when migrating
+to a different application-server. Devices which already exist are skipped.
+The command exits with a non-zero exit code when a device could not be
+imported.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := devicesFileFormat()
+		if err != nil {
+			return err
+		}
+
+		b, err := devicesBackend()
+		if err != nil {
+			return err
+		}
+
+		in := io.Reader(os.Stdin)
+		if devicesFile != "-" {
+			f, err := os.Open(devicesFile)
+			if err != nil {
+				return errors.Wrap(err, "open file error")
+			}
+			defer f.Close()
+			in = f
+		}
+
+		var imported, skipped, failed int
+		r := deviceio.NewReader(in, format)
+		for {
+			rec, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return errors.Wrapf(err, "read record %d error", imported+skipped+failed+1)
+			}
+
+			if devicesApplicationID != 0 {
+				rec.ApplicationID = devicesApplicationID
+			}
+			if devicesDeviceProfileID != "" {
+				rec.DeviceProfileID = devicesDeviceProfileID
+			}
+
+			err = b.Import(context.Background(), rec)
+			switch {
+			case err == nil:
+				imported++
+			case errors.Cause(err) == storage.ErrAlreadyExists:
+				skipped++
+				fmt.Fprintf(os.Stderr, "%s: device already exists, skipping\n", rec.DevEUI)
+			default:
+				failed++
+				fmt.Fprintf(os.Stderr, "%s: %s\n", rec.DevEUI, err)
+			}
+		}
+
+		fmt.Printf("%d devices imported, %d skipped, %d failed\n", imported, skipped, failed)
+
+		if failed != 0 {
+			return fmt.Errorf("%d device(s) could not be imported", failed)
+		}
+		return nil
+	},
+}
+
+func init() {
+	devicesCmd.PersistentFlags().StringVar(&devicesFormat, "format", "", "file format, csv or json (default: based on the file extension, else csv)")
+	devicesCmd.PersistentFlags().StringVar(&devicesAPI, "api", "", "use the external API of the given application-server (e.g. https://localhost:8080) instead of the database")
+	devicesCmd.PersistentFlags().StringVar(&devicesToken, "token", "", "API key or user token, used together with --api")
+	devicesCmd.PersistentFlags().Int64Var(&devicesApplicationID, "application-id", 0, "export: filter by application, import: import into the given application")
+
+	devicesExportCmd.Flags().StringVarP(&devicesFile, "output", "o", "-", "output file (- for stdout)")
+	devicesExportCmd.Flags().Int64Var(&devicesOrganizationID, "organization-id", 0, "filter by organization")
+	devicesExportCmd.Flags().BoolVar(&devicesKeys, "keys", false, "include the device root keys")
+
+	devicesImportCmd.Flags().StringVarP(&devicesFile, "input", "i", "-", "input file (- for stdin)")
+	devicesImportCmd.Flags().StringVar(&devicesDeviceProfileID, "device-profile-id", "", "import using the given device-profile")
+
+	devicesCmd.AddCommand(devicesExportCmd)
+	devicesCmd.AddCommand(devicesImportCmd)
+}
+
+// devicesFileFormat returns the format set by --format or else the format
+// matching the file extension.
+func devicesFileFormat() (deviceio.Format, error) {
+	if devicesFormat != "" {
+		return deviceio.ParseFormat(devicesFormat)
+	}
+
+	if strings.ToLower(filepath.Ext(devicesFile)) == ".json" {
+		return deviceio.JSON, nil
+	}
+	return deviceio.CSV, nil
+}
+
+// devicesBackend returns the API backend when --api is set, else the
+// database backend.
+func devicesBackend() (deviceio.Backend, error) {
+	if devicesAPI != "" {
+		if devicesToken == "" {
+			return nil, errors.New("--token must be set when using --api")
+		}
+		return deviceio.NewAPIBackend(devicesAPI, devicesToken), nil
+	}
+
+	if err := kms.Setup(config.C); err != nil {
+		return nil, errors.Wrap(err, "setup kms error")
+	}
+
+	if err := hsm.Setup(config.C); err != nil {
+		return nil, errors.Wrap(err, "setup hsm error")
+	}
+
+	if err := storage.Setup(config.C); err != nil {
+		return nil, errors.Wrap(err, "setup storage error")
+	}
+
+	if err := networkserver.Setup(config.C); err != nil {
+		return nil, errors.Wrap(err, "setup networkserver error")
+	}
+
+	return deviceio.NewDatabaseBackend(), nil
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+var (
+	healthcheckURL      string
+	healthcheckLiveness bool
+	healthcheckTimeout  time.Duration
+)
+
+var healthcheckCmd = &cobra.Command{
+	Use:   "healthcheck",
+	Short: "Check the readiness of the local application-server",
+	Long: `Check the readiness of the local application-server.
+The readiness endpoint (/readyz) of the monitoring server configured in the
+configuration file is requested (or the liveness endpoint /healthz when
+--liveness is set). This requires the monitoring bind and the
+healthcheck_endpoint to be configured. The command exits with a non-zero exit
+code when the application-server is not ready, e.g. for use as Docker
+HEALTHCHECK or Kubernetes exec probe:
+
+  HEALTHCHECK CMD ["chirpstack-application-server", "healthcheck"]`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		url := healthcheckURL
+		if url == "" {
+			var err error
+			url, err = healthcheckEndpointURL(config.C.Monitoring.Bind, healthcheckLiveness)
+			if err != nil {
+				return err
+			}
+		}
+
+		client := http.Client{
+			Timeout: healthcheckTimeout,
+		}
+
+		resp, err := client.Get(url)
+		if err != nil {
+			return errors.Wrap(err, "healthcheck request error")
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+			return fmt.Errorf("healthcheck failed (status: %d): %s", resp.StatusCode, strings.TrimSpace(string(b)))
+		}
+
+		fmt.Println("OK")
+		return nil
+	},
+}
+
+func init() {
+	healthcheckCmd.Flags().StringVar(&healthcheckURL, "url", "", "endpoint url (default: based on the monitoring bind in the configuration file)")
+	healthcheckCmd.Flags().BoolVar(&healthcheckLiveness, "liveness", false, "check the liveness endpoint instead of the readiness endpoint")
+	healthcheckCmd.Flags().DurationVar(&healthcheckTimeout, "timeout", 5*time.Second, "request timeout")
+}
+
+// healthcheckEndpointURL returns the url of the readiness (or liveness)
+// endpoint for the given monitoring bind. When the bind does not specify a
+// host or binds to all interfaces, the loopback address is used.
+func healthcheckEndpointURL(bind string, liveness bool) (string, error) {
+	if bind == "" {
+		return "", errors.New("monitoring bind is not configured, set --url")
+	}
+
+	host, port, err := net.SplitHostPort(bind)
+	if err != nil {
+		return "", errors.Wrap(err, "parse monitoring bind error")
+	}
+
+	switch host {
+	case "", "0.0.0.0":
+		host = "127.0.0.1"
+	case "::":
+		host = "::1"
+	}
+
+	path := "/readyz"
+	if liveness {
+		path = "/healthz"
+	}
+
+	return "http://" + net.JoinHostPort(host, port) + path, nil
+}
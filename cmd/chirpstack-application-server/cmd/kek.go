@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/kms"
+)
+
+var kekCmd = &cobra.Command{
+	Use:   "kek",
+	Short: "Manage the join-server key encryption keys",
+}
+
+var kekWrapCmd = &cobra.Command{
+	Use:   "wrap [KEK]",
+	Short: "Wrap the given hex encoded KEK using the kms provider",
+	Long: `Wrap the given hex encoded KEK using the kms provider.
+The printed value can be used as wrapped_kek in the KEK set.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if config.C.KMS.Provider == "" {
+			return errors.New("kms.provider must be configured")
+		}
+
+		if err := kms.Setup(config.C); err != nil {
+			return errors.Wrap(err, "setup kms error")
+		}
+
+		b, err := hex.DecodeString(args[0])
+		if err != nil {
+			return errors.Wrap(err, "decode hex encoded kek error")
+		}
+
+		wrapped, err := kms.GetKeyWrapper().Wrap(context.Background(), b)
+		if err != nil {
+			return errors.Wrap(err, "wrap kek error")
+		}
+
+		fmt.Println(base64.StdEncoding.EncodeToString(wrapped))
+		return nil
+	},
+}
+
+func init() {
+	kekCmd.AddCommand(kekWrapCmd)
+}
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/spf13/cobra"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+var (
+	migrateMax        int
+	migrateSteps      int
+	migrateDryRun     bool
+	migrateDryRunCopy bool
+	migrateYes        bool
+	migrateNamespace  string
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Manage the PostgreSQL database migrations",
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Print the applied and pending database migrations",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := storage.SetupPostgreSQL(config.C); err != nil {
+			return errors.Wrap(err, "setup postgresql error")
+		}
+
+		namespaces := []string{migrateNamespace}
+		if migrateNamespace == "" {
+			namespaces = append(namespaces, storage.GetMigrationNamespaces()...)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAMESPACE\tMIGRATION\tAPPLIED AT")
+		var pending int
+		for _, namespace := range namespaces {
+			items, err := storage.GetMigrationStatus(storage.DB().DB.DB, namespace)
+			if err != nil {
+				return errors.Wrap(err, "get migration status error")
+			}
+
+			name := namespace
+			if name == "" {
+				name = "-"
+			}
+
+			for _, item := range items {
+				appliedAt := "pending"
+				if item.AppliedAt != nil {
+					appliedAt = item.AppliedAt.Format("2006-01-02 15:04:05 -0700")
+				} else {
+					pending++
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", name, item.ID, appliedAt)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		fmt.Printf("\n%d migration(s) pending\n", pending)
+		return nil
+	},
+}
+
+var migrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Apply the pending database migrations",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runMigrate(migrate.Up, migrateMax, false)
+	},
+}
+
+var migrateDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Roll back the given number of database migrations",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if migrateSteps <= 0 {
+			return errors.New("--steps must be set to a value greater than 0")
+		}
+		return runMigrate(migrate.Down, migrateSteps, !migrateYes)
+	},
+}
+
+func init() {
+	for _, c := range []*cobra.Command{migrateUpCmd, migrateDownCmd} {
+		c.Flags().BoolVar(&migrateDryRun, "dry-run", false, "execute the migrations within a transaction which is rolled back")
+		c.Flags().BoolVar(&migrateDryRunCopy, "dry-run-copy", false, "execute the migrations against a (temporary) copy of the database")
+	}
+	migrateUpCmd.Flags().IntVar(&migrateMax, "max", 0, "max. number of migrations to apply (0 = all)")
+	migrateDownCmd.Flags().IntVar(&migrateSteps, "steps", 0, "number of migrations to roll back")
+	migrateDownCmd.Flags().BoolVar(&migrateYes, "yes", false, "do not ask for confirmation")
+	migrateCmd.PersistentFlags().StringVar(&migrateNamespace, "namespace", "", "migration namespace of a registered module (default: chirpstack-application-server migrations)")
+
+	migrateCmd.AddCommand(migrateStatusCmd)
+	migrateCmd.AddCommand(migrateUpCmd)
+	migrateCmd.AddCommand(migrateDownCmd)
+}
+
+func runMigrate(dir migrate.MigrationDirection, max int, confirm bool) error {
+	if err := storage.SetupPostgreSQL(config.C); err != nil {
+		return errors.Wrap(err, "setup postgresql error")
+	}
+	db := storage.DB().DB.DB
+
+	verb, done := "apply", "applied"
+	if dir == migrate.Down {
+		verb, done = "roll back", "rolled back"
+	}
+
+	if migrateDryRun || migrateDryRunCopy {
+		var ids []string
+		var err error
+		if migrateDryRunCopy {
+			ids, err = storage.DryRunMigrationsOnCopy(migrateNamespace, dir, max)
+		} else {
+			ids, err = storage.DryRunMigrations(db, migrateNamespace, dir, max)
+		}
+		for _, id := range ids {
+			fmt.Printf("ok\t%s\n", id)
+		}
+		if err != nil {
+			return errors.Wrap(err, "dry-run error")
+		}
+		fmt.Printf("\ndry-run: %d migration(s) can be %s, no changes were made\n", len(ids), done)
+		return nil
+	}
+
+	ids, err := storage.PlanMigrations(db, migrateNamespace, dir, max)
+	if err != nil {
+		return errors.Wrap(err, "plan migrations error")
+	}
+	if len(ids) == 0 {
+		fmt.Println("no migrations to " + verb)
+		return nil
+	}
+
+	fmt.Printf("the following migration(s) will be %s:\n", done)
+	for _, id := range ids {
+		fmt.Printf("\t%s\n", id)
+	}
+
+	if confirm {
+		fmt.Print("\nRolling back migrations can not be undone and might remove data. Type 'yes' to continue: ")
+		answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return errors.Wrap(err, "read confirmation error")
+		}
+		if strings.TrimSpace(answer) != "yes" {
+			return errors.New("aborted")
+		}
+	}
+
+	var n int
+	if dir == migrate.Down {
+		n, err = storage.MigrateDown(db, migrateNamespace, max)
+	} else {
+		n, err = storage.MigrateUp(db, migrateNamespace, max)
+	}
+	if err != nil {
+		return errors.Wrap(err, "migrate error")
+	}
+
+	if dir == migrate.Up && migrateNamespace != "" {
+		if err := storage.SeedHook(context.Background(), migrateNamespace); err != nil {
+			return errors.Wrap(err, "seed error")
+		}
+	}
+
+	fmt.Printf("%d migration(s) %s\n", n, done)
+	return nil
+}
@@ -2,14 +2,19 @@ package cmd
 
 import (
 	"bytes"
+	"encoding/json"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/secrets"
 	"github.com/go-redis/redis/v7"
+	"github.com/pkg/errors"
 	"github.com/spf13/viper"
 
 	log "github.com/sirupsen/logrus"
@@ -17,6 +22,7 @@ import (
 )
 
 var cfgFile string
+var cfgDir string
 var version string
 
 var rootCmd = &cobra.Command{
@@ -25,23 +31,37 @@ var rootCmd = &cobra.Command{
 	Long: `ChirpStack Application Server is an open-source Application Server, part of the ChirpStack LoRaWAN Network Server stack.
 	> documentation & support: https://www.chirpstack.io/application-server
 	> source & copyright information: https://github.com/ibrahimozekici/app-server2`,
-	RunE: run,
+	PersistentPreRunE: resolveSecrets,
+	RunE:              run,
 }
 
 func init() {
 	cobra.OnInitialize(initConfig)
 	rootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "", "path to configuration file (optional)")
+	rootCmd.PersistentFlags().StringVar(&cfgDir, "config-dir", "", "path to configuration directory, the *.toml files are merged in lexical order after the configuration file (optional)")
 	rootCmd.PersistentFlags().Int("log-level", 4, "debug=5, info=4, error=2, fatal=1, panic=0")
 
 	// bind flag to config vars
 	viper.BindPFlag("general.log_level", rootCmd.PersistentFlags().Lookup("log-level"))
 
 	// defaults
+	viper.SetDefault("general.log_format", "text")
 	viper.SetDefault("general.grpc_default_resolver_scheme", "passthrough")
 	viper.SetDefault("general.password_hash_iterations", 100000)
+	viper.SetDefault("general.shutdown_timeout", 30*time.Second)
+	viper.SetDefault("general.log_forwarding.syslog.app_name", "chirpstack-application-server")
+	viper.SetDefault("general.log_forwarding.syslog.facility", 1)
+	viper.SetDefault("general.log_forwarding.loki.labels", map[string]string{"job": "chirpstack-application-server"})
+	viper.SetDefault("general.log_forwarding.loki.batch_size", 1000)
+	viper.SetDefault("general.log_forwarding.loki.batch_interval", time.Second)
 	viper.SetDefault("postgresql.dsn", "postgres://localhost/chirpstack_as?sslmode=disable")
 	viper.SetDefault("postgresql.automigrate", true)
 	viper.SetDefault("postgresql.max_idle_connections", 2)
+	viper.SetDefault("postgresql.partitioning.interval", "DAY")
+	viper.SetDefault("postgresql.partitioning.premake", 3)
+	viper.SetDefault("postgresql.partitioning.check_interval", time.Hour)
+	viper.SetDefault("postgresql.batch_writes.interval", time.Second)
+	viper.SetDefault("postgresql.index_maintenance.analyze_interval", 24*time.Hour)
 	viper.SetDefault("redis.servers", []string{"localhost:6379"})
 	viper.SetDefault("application_server.api.public_host", "localhost:8001")
 	viper.SetDefault("application_server.id", "6d5db27e-4ce2-4b2b-b5d7-91f069397978")
@@ -64,6 +84,38 @@ func init() {
 	viper.SetDefault("application_server.integration.amqp.event_routing_key_template", "application.{{ .ApplicationID }}.device.{{ .DevEUI }}.event.{{ .EventType }}")
 	viper.SetDefault("application_server.integration.enabled", []string{"mqtt"})
 	viper.SetDefault("application_server.codec.js.max_execution_time", 100*time.Millisecond)
+	viper.SetDefault("application_server.codec.js.max_memory", 64*1024*1024)
+	viper.SetDefault("application_server.codec.js.max_input_size", 64*1024)
+	viper.SetDefault("application_server.codec.js.max_output_size", 64*1024)
+	viper.SetDefault("application_server.codec.lua.max_execution_time", 100*time.Millisecond)
+	viper.SetDefault("application_server.codec.external_grpc.timeout", time.Second)
+	viper.SetDefault("application_server.codec.external_grpc.circuit_breaker.failure_threshold", 5)
+	viper.SetDefault("application_server.codec.external_grpc.circuit_breaker.open_duration", 30*time.Second)
+	viper.SetDefault("application_server.downlink_validation.validators", []string{"fport", "schema", "max_payload_size"})
+	viper.SetDefault("application_server.confirmed_downlink_retry.interval", time.Second)
+	viper.SetDefault("application_server.confirmed_downlink_retry.batch_size", 100)
+	viper.SetDefault("application_server.device_queue_expiry.interval", time.Second)
+	viper.SetDefault("application_server.device_queue_expiry.batch_size", 100)
+	viper.SetDefault("application_server.downlink_rate_limit.device.interval", time.Minute)
+	viper.SetDefault("application_server.downlink_rate_limit.application.interval", time.Minute)
+	viper.SetDefault("application_server.downlink_deduplication.window", time.Minute)
+	viper.SetDefault("application_server.downlink_rule.min_cooldown", 10*time.Second)
+	viper.SetDefault("application_server.downlink_rule.max_triggers", 10)
+	viper.SetDefault("application_server.downlink_rule.trigger_interval", time.Hour)
+	viper.SetDefault("application_server.automation.interval", time.Minute)
+	viper.SetDefault("application_server.automation.batch_size", 100)
+	viper.SetDefault("application_server.automation.min_cooldown", 10*time.Second)
+	viper.SetDefault("application_server.automation.execution_retention", 30*24*time.Hour)
+	viper.SetDefault("application_server.reports.interval", 5*time.Minute)
+	viper.SetDefault("application_server.reports.batch_size", 10)
+	viper.SetDefault("application_server.irrigation.interval", time.Minute)
+	viper.SetDefault("application_server.irrigation.batch_size", 100)
+	viper.SetDefault("application_server.leader_election.enabled", true)
+	viper.SetDefault("application_server.leader_election.lock_ttl", 30*time.Second)
+	viper.SetDefault("application_server.uplink_pipeline.enabled", true)
+	viper.SetDefault("application_server.uplink_pipeline.workers", 16)
+	viper.SetDefault("application_server.uplink_pipeline.queue_size", 100)
+	viper.SetDefault("application_server.uplink_pipeline.enqueue_timeout", 100*time.Millisecond)
 
 	viper.SetDefault("application_server.remote_multicast_setup.sync_interval", time.Second)
 	viper.SetDefault("application_server.remote_multicast_setup.sync_retries", 3)
@@ -73,6 +125,38 @@ func init() {
 	viper.SetDefault("application_server.fragmentation_session.sync_retries", 3)
 	viper.SetDefault("application_server.fragmentation_session.sync_batch_size", 100)
 
+	viper.SetDefault("application_server.fuota_deployment.gateway_selection_window", 24*time.Hour)
+
+	viper.SetDefault("application_server.firmware_campaign.sync_interval", time.Second)
+	viper.SetDefault("application_server.firmware_campaign.sync_batch_size", 100)
+
+	viper.SetDefault("application_server.retention.cleanup_interval", time.Hour)
+	viper.SetDefault("application_server.usage.flush_interval", 5*time.Minute)
+	viper.SetDefault("postgresql.transaction_max_retries", 3)
+	viper.SetDefault("postgresql.transaction_retry_backoff", 50*time.Millisecond)
+	viper.SetDefault("application_server.archive.interval", time.Hour)
+	viper.SetDefault("application_server.cache.size", 10000)
+	viper.SetDefault("application_server.cache.ttl", time.Minute)
+	viper.SetDefault("network_server.region_sync_interval", 10*time.Minute)
+	viper.SetDefault("network_server.retry.max_attempts", 3)
+	viper.SetDefault("network_server.retry.initial_backoff", 100*time.Millisecond)
+	viper.SetDefault("network_server.retry.max_backoff", time.Second)
+	viper.SetDefault("network_server.client.keepalive_timeout", 20*time.Second)
+	viper.SetDefault("network_server.client.connect_timeout", 5*time.Second)
+	viper.SetDefault("network_server.client.backoff_base_delay", time.Second)
+	viper.SetDefault("network_server.client.backoff_max_delay", 30*time.Second)
+	viper.SetDefault("network_server.client.health_check_interval", 10*time.Second)
+	viper.SetDefault("kms.vault.mount", "transit")
+	viper.SetDefault("secrets.refresh_interval", 5*time.Minute)
+	viper.SetDefault("join_server.hsm.key_label_prefix", "lora-as")
+	viper.SetDefault("join_server.kek.refresh_interval", 5*time.Minute)
+	viper.SetDefault("join_server.brute_force_protection.device.interval", 10*time.Minute)
+	viper.SetDefault("join_server.brute_force_protection.device.block_duration", 30*time.Minute)
+	viper.SetDefault("join_server.brute_force_protection.join_eui.interval", 10*time.Minute)
+	viper.SetDefault("join_server.brute_force_protection.join_eui.block_duration", 30*time.Minute)
+	viper.SetDefault("join_server.brute_force_protection.sender.interval", 10*time.Minute)
+	viper.SetDefault("join_server.brute_force_protection.sender.block_duration", 30*time.Minute)
+
 	viper.SetDefault("metrics.timezone", "Local")
 	viper.SetDefault("metrics.redis.aggregation_intervals", []string{"MINUTE", "HOUR", "DAY", "MONTH"})
 	viper.SetDefault("metrics.redis.minute_aggregation_ttl", time.Hour*2)
@@ -80,8 +164,25 @@ func init() {
 	viper.SetDefault("metrics.redis.day_aggregation_ttl", time.Hour*24*90)
 	viper.SetDefault("metrics.redis.month_aggregation_ttl", time.Hour*24*730)
 
+	viper.SetDefault("monitoring.healthcheck_timeout", 2*time.Second)
+	viper.SetDefault("monitoring.slow_query_threshold", time.Second)
+	viper.SetDefault("monitoring.slow_integration_threshold", 5*time.Second)
+	viper.SetDefault("monitoring.tracing.sample_ratio", 1.0)
+	viper.SetDefault("monitoring.tracing.service_name", "chirpstack-application-server")
+	viper.SetDefault("monitoring.alerting.check_interval", time.Minute)
+	viper.SetDefault("monitoring.alerting.repeat_interval", time.Hour)
+	viper.SetDefault("monitoring.alerting.integration_failure_threshold", 100)
+	viper.SetDefault("monitoring.alerting.redis_latency_threshold", 100*time.Millisecond)
+
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(configCheckCmd)
+	rootCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(deviceKeysCmd)
+	rootCmd.AddCommand(kekCmd)
+	rootCmd.AddCommand(devicesCmd)
+	rootCmd.AddCommand(cleanupCmd)
+	rootCmd.AddCommand(healthcheckCmd)
 }
 
 // Execute executes the root command.
@@ -93,6 +194,14 @@ func Execute(v string) {
 }
 
 func initConfig() {
+	// a directory given as configuration file is handled as configuration
+	// directory
+	if cfgFile != "" && cfgDir == "" {
+		if fi, err := os.Stat(cfgFile); err == nil && fi.IsDir() {
+			cfgDir, cfgFile = cfgFile, ""
+		}
+	}
+
 	if cfgFile != "" {
 		b, err := ioutil.ReadFile(cfgFile)
 		if err != nil {
@@ -102,7 +211,7 @@ func initConfig() {
 		if err := viper.ReadConfig(bytes.NewBuffer(b)); err != nil {
 			log.WithError(err).WithField("config", cfgFile).Fatal("error loading config file")
 		}
-	} else {
+	} else if cfgDir == "" {
 		viper.SetConfigName("chirpstack-application-server")
 		viper.AddConfigPath(".")
 		viper.AddConfigPath("$HOME/.config/chirpstack-application-server")
@@ -117,6 +226,12 @@ func initConfig() {
 		}
 	}
 
+	if cfgDir != "" {
+		if err := mergeConfigDir(cfgDir); err != nil {
+			log.WithError(err).WithField("config_dir", cfgDir).Fatal("error loading config directory")
+		}
+	}
+
 	for _, pair := range os.Environ() {
 		d := strings.SplitN(pair, "=", 2)
 		if strings.Contains(d[0], ".") {
@@ -152,6 +267,52 @@ func initConfig() {
 	}
 }
 
+// mergeConfigDir merges the *.toml files in the given directory into the
+// configuration, in lexical order of the filenames. Tables are merged, such
+// that a file only needs to contain the settings which it overrides (e.g.
+// 00-base.toml, 10-production.toml). Arrays are replaced.
+func mergeConfigDir(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.toml"))
+	if err != nil {
+		return errors.Wrap(err, "list config files error")
+	}
+	if len(files) == 0 {
+		return errors.New("config directory does not contain any *.toml files")
+	}
+	sort.Strings(files)
+
+	viper.SetConfigType("toml")
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return errors.Wrap(err, "read config file error")
+		}
+
+		if err := viper.MergeConfig(bytes.NewBuffer(b)); err != nil {
+			return errors.Wrapf(err, "merge config file %s error", f)
+		}
+
+		log.WithField("config", f).Info("merged config file")
+	}
+
+	return nil
+}
+
+// resolveSecrets resolves the secret references in the configuration. This
+// is skipped for the configfile command, such that the printed configuration
+// contains the references instead of the resolved secrets.
+func resolveSecrets(cmd *cobra.Command, args []string) error {
+	if cmd == configCmd || cmd == versionCmd || cmd == healthcheckCmd {
+		return nil
+	}
+
+	if err := secrets.Resolve(&config.C); err != nil {
+		return errors.Wrap(err, "resolve config secrets error")
+	}
+
+	return nil
+}
+
 func viperBindEnvs(iface interface{}, parts ...string) {
 	ifv := reflect.ValueOf(iface)
 	ift := reflect.TypeOf(iface)
@@ -174,7 +335,34 @@ func viperBindEnvs(iface interface{}, parts ...string) {
 			// bind the double underscore version.
 			keyDot := strings.Join(append(parts, tv), ".")
 			keyUnderscore := strings.Join(append(parts, tv), "__")
-			viper.BindEnv(keyDot, strings.ToUpper(keyUnderscore))
+			env := strings.ToUpper(keyUnderscore)
+			viper.BindEnv(keyDot, env)
+
+			if v.Kind() == reflect.Slice || v.Kind() == reflect.Map {
+				if err := viperSetJSONEnv(keyDot, env); err != nil {
+					log.WithError(err).Fatal("env variable error")
+				}
+			}
 		}
 	}
 }
+
+// viperSetJSONEnv sets the given key to the decoded value of the given env
+// variable when it holds a JSON array or object. This makes it possible to
+// override slices (including arrays of tables) and maps, e.g.
+// JOIN_SERVER__KEK__SET='[{"label": "kek-1", "kek": "..."}]'. Other values
+// are handled by the env binding, e.g. comma separated slice values.
+func viperSetJSONEnv(key, env string) error {
+	val := strings.TrimSpace(os.Getenv(env))
+	if !strings.HasPrefix(val, "[") && !strings.HasPrefix(val, "{") {
+		return nil
+	}
+
+	var out interface{}
+	if err := json.Unmarshal([]byte(val), &out); err != nil {
+		return errors.Wrapf(err, "decode json value of %s error", env)
+	}
+	viper.Set(key, out)
+
+	return nil
+}
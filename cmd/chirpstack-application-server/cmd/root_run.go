@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
@@ -12,44 +13,102 @@ import (
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc/resolver"
 
+	"github.com/ibrahimozekici/app-server2/internal/alerting"
 	"github.com/ibrahimozekici/app-server2/internal/api"
 	"github.com/ibrahimozekici/app-server2/internal/applayer/fragmentation"
 	"github.com/ibrahimozekici/app-server2/internal/applayer/multicastsetup"
+	"github.com/ibrahimozekici/app-server2/internal/archive"
+	"github.com/ibrahimozekici/app-server2/internal/automation"
 	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	externalcodec "github.com/ibrahimozekici/app-server2/internal/codec/external"
 	jscodec "github.com/ibrahimozekici/app-server2/internal/codec/js"
+	luacodec "github.com/ibrahimozekici/app-server2/internal/codec/lua"
 	"github.com/ibrahimozekici/app-server2/internal/config"
 	"github.com/ibrahimozekici/app-server2/internal/downlink"
+	"github.com/ibrahimozekici/app-server2/internal/downlink/validation"
+	"github.com/ibrahimozekici/app-server2/internal/eventlog"
+	"github.com/ibrahimozekici/app-server2/internal/events/uplink"
 	"github.com/ibrahimozekici/app-server2/internal/fuota"
+	"github.com/ibrahimozekici/app-server2/internal/fwcampaign"
 	"github.com/ibrahimozekici/app-server2/internal/gwping"
+	"github.com/ibrahimozekici/app-server2/internal/hsm"
 	"github.com/ibrahimozekici/app-server2/internal/integration"
+	"github.com/ibrahimozekici/app-server2/internal/irrigation"
+	"github.com/ibrahimozekici/app-server2/internal/kek"
+	"github.com/ibrahimozekici/app-server2/internal/kms"
+	"github.com/ibrahimozekici/app-server2/internal/leader"
+	"github.com/ibrahimozekici/app-server2/internal/logging/forward"
 	"github.com/ibrahimozekici/app-server2/internal/migrations/code"
 	"github.com/ibrahimozekici/app-server2/internal/monitoring"
+	"github.com/ibrahimozekici/app-server2/internal/region"
+	"github.com/ibrahimozekici/app-server2/internal/report"
+	"github.com/ibrahimozekici/app-server2/internal/retention"
+	"github.com/ibrahimozekici/app-server2/internal/secrets"
 	"github.com/ibrahimozekici/app-server2/internal/storage"
+	"github.com/ibrahimozekici/app-server2/internal/systemd"
+	"github.com/ibrahimozekici/app-server2/internal/tracing"
+	"github.com/ibrahimozekici/app-server2/internal/usage"
 )
 
 func run(cmd *cobra.Command, args []string) error {
+	if ok, err := runService(serve); ok {
+		return err
+	}
+
+	sigChan := make(chan os.Signal)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	return serve(sigChan)
+}
+
+// serve starts the application-server and blocks until it has been stopped
+// by a signal received on the given channel. A second signal stops the
+// application-server immediately.
+func serve(sigChan chan os.Signal) error {
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	tasks := []func() error{
 		setLogLevel,
+		setLogFormat,
 		setSyslog,
+		setLogForwarding,
 		setGRPCResolver,
 		printStartMessage,
+		setupTracing,
+		setupKMS,
+		setupKEK,
+		setupHSM,
 		setupStorage,
+		setupSecrets,
+		setupLeaderElection,
+		setupPartitioning,
+		setupIndexMaintenance,
 		setupNetworkServer,
+		setupRegion,
 		migrateGatewayStats,
 		migrateToClusterKeys,
+		setupEventLog,
 		setupIntegration,
 		setupCodec,
+		setupDownlinkValidation,
 		handleDataDownPayloads,
+		setupDownlink,
 		startGatewayPing,
 		setupMulticastSetup,
 		setupFragmentation,
 		setupFUOTA,
+		setupFirmwareCampaign,
+		setupRetention,
+		setupUsage,
+		setupArchive,
+		setupAutomation,
+		setupReports,
+		setupIrrigation,
+		setupUplink,
 		setupAPI,
 		setupMonitoring,
+		setupAlerting,
 	}
 
 	for _, t := range tasks {
@@ -58,13 +117,18 @@ func run(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	sigChan := make(chan os.Signal)
+	if _, err := systemd.Notify(systemd.Ready); err != nil {
+		log.WithError(err).Error("systemd notify error")
+	}
+
 	exitChan := make(chan struct{})
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	log.WithField("signal", <-sigChan).Info("signal received")
 	go func() {
 		log.Warning("stopping chirpstack-application-server")
-		// todo: handle graceful shutdown?
+		if _, err := systemd.Notify(systemd.Stopping); err != nil {
+			log.WithError(err).Error("systemd notify error")
+		}
+		shutdown()
 		exitChan <- struct{}{}
 	}()
 	select {
@@ -76,11 +140,76 @@ func run(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// shutdown drains the application-server. First the API endpoints stop
+// accepting new requests, then the in-flight uplinks, integration events and
+// queued device events are handled, the batched writes are flushed and
+// finally the leadership of the background jobs is released.
+func shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), config.C.General.ShutdownTimeout)
+	defer cancel()
+
+	monitoring.SetDraining()
+
+	log.Info("stopping api endpoints")
+	if err := api.Shutdown(ctx); err != nil {
+		log.WithError(err).Error("api shutdown error")
+	}
+
+	log.Info("handling in-flight uplinks and integration events")
+	drained := make(chan struct{})
+	go func() {
+		uplink.Wait()
+		if err := integration.Close(); err != nil {
+			log.WithError(err).Error("close integrations error")
+		}
+		if err := eventlog.Close(); err != nil {
+			log.WithError(err).Error("close event-log error")
+		}
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		log.Warning("shutdown timeout, not all uplinks, integration and device events have been handled")
+	}
+
+	log.Info("flushing batched writes")
+	storage.CloseBatchWriter()
+
+	log.Info("resigning leadership")
+	if err := leader.Resign(ctx); err != nil {
+		log.WithError(err).Error("resign leadership error")
+	}
+
+	if err := tracing.Shutdown(context.Background()); err != nil {
+		log.WithError(err).Error("tracing shutdown error")
+	}
+}
+
 func setLogLevel() error {
 	log.SetLevel(log.Level(uint8(config.C.General.LogLevel)))
 	return nil
 }
 
+func setLogFormat() error {
+	switch config.C.General.LogFormat {
+	case "", "text":
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return errors.Errorf("unknown log_format: %s", config.C.General.LogFormat)
+	}
+	return nil
+}
+
+func setLogForwarding() error {
+	if err := forward.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup log forwarding error")
+	}
+	return nil
+}
+
 func setGRPCResolver() error {
 	resolver.SetDefaultScheme(config.C.General.GRPCDefaultResolverScheme)
 	return nil
@@ -94,6 +223,37 @@ func printStartMessage() error {
 	return nil
 }
 
+func setupTracing() error {
+	if err := tracing.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup tracing error")
+	}
+	return nil
+}
+
+func setupKMS() error {
+	if err := kms.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup kms error")
+	}
+
+	return nil
+}
+
+func setupKEK() error {
+	if err := kek.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup kek error")
+	}
+
+	return nil
+}
+
+func setupHSM() error {
+	if err := hsm.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup hsm error")
+	}
+
+	return nil
+}
+
 func setupStorage() error {
 	if err := storage.Setup(config.C); err != nil {
 		return errors.Wrap(err, "setup storage error")
@@ -102,6 +262,49 @@ func setupStorage() error {
 	return nil
 }
 
+func setupSecrets() error {
+	// in-flight queries are given one minute to complete before the
+	// connection pool using the previous credentials is closed
+	secrets.OnRotate("postgresql.dsn", func(dsn string) error {
+		return storage.RotatePostgreSQLDSN(dsn, time.Minute)
+	})
+
+	if err := secrets.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup secrets error")
+	}
+
+	return nil
+}
+
+func setupLeaderElection() error {
+	if err := leader.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup leader election error")
+	}
+	return nil
+}
+
+func setupPartitioning() error {
+	if err := storage.MaintainPartitions(context.Background(), storage.DB(), time.Now()); err != nil {
+		return errors.Wrap(err, "maintain partitions error")
+	}
+
+	go storage.PartitionMaintenanceLoop(leader.Do)
+
+	return nil
+}
+
+func setupIndexMaintenance() error {
+	go storage.IndexMaintenanceLoop(leader.Do)
+	return nil
+}
+
+func setupEventLog() error {
+	if err := eventlog.Setup(); err != nil {
+		return errors.Wrap(err, "setup event-log error")
+	}
+	return nil
+}
+
 func setupIntegration() error {
 	if err := integration.Setup(config.C); err != nil {
 		return errors.Wrap(err, "setup integration error")
@@ -115,6 +318,22 @@ func setupCodec() error {
 		return errors.Wrap(err, "setup codec error")
 	}
 
+	if err := luacodec.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup lua codec error")
+	}
+
+	if err := externalcodec.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup external codec error")
+	}
+
+	return nil
+}
+
+func setupDownlinkValidation() error {
+	if err := validation.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup downlink validation error")
+	}
+
 	return nil
 }
 
@@ -125,6 +344,13 @@ func setupNetworkServer() error {
 	return nil
 }
 
+func setupRegion() error {
+	if err := region.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup region error")
+	}
+	return nil
+}
+
 func migrateGatewayStats() error {
 	if err := code.Migrate("migrate_gw_stats", code.MigrateGatewayStats); err != nil {
 		return errors.Wrap(err, "migration error")
@@ -145,6 +371,13 @@ func handleDataDownPayloads() error {
 	return nil
 }
 
+func setupDownlink() error {
+	if err := downlink.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup downlink error")
+	}
+	return nil
+}
+
 func setupAPI() error {
 	if err := api.Setup(config.C); err != nil {
 		return errors.Wrap(err, "setup api error")
@@ -179,9 +412,72 @@ func setupFUOTA() error {
 	return nil
 }
 
+func setupFirmwareCampaign() error {
+	if err := fwcampaign.Setup(config.C); err != nil {
+		return errors.Wrap(err, "firmware campaign setup error")
+	}
+	return nil
+}
+
+func setupRetention() error {
+	if err := retention.Setup(config.C); err != nil {
+		return errors.Wrap(err, "retention setup error")
+	}
+	return nil
+}
+
+func setupUsage() error {
+	if err := usage.Setup(config.C); err != nil {
+		return errors.Wrap(err, "usage setup error")
+	}
+	return nil
+}
+
+func setupArchive() error {
+	if err := archive.Setup(config.C); err != nil {
+		return errors.Wrap(err, "archive setup error")
+	}
+	return nil
+}
+
+func setupAutomation() error {
+	if err := automation.Setup(config.C); err != nil {
+		return errors.Wrap(err, "automation setup error")
+	}
+	return nil
+}
+
+func setupReports() error {
+	if err := report.Setup(config.C); err != nil {
+		return errors.Wrap(err, "reports setup error")
+	}
+	return nil
+}
+
+func setupIrrigation() error {
+	if err := irrigation.Setup(config.C); err != nil {
+		return errors.Wrap(err, "irrigation setup error")
+	}
+	return nil
+}
+
+func setupUplink() error {
+	if err := uplink.Setup(config.C); err != nil {
+		return errors.Wrap(err, "uplink setup error")
+	}
+	return nil
+}
+
 func setupMonitoring() error {
 	if err := monitoring.Setup(config.C); err != nil {
 		return errors.Wrap(err, "setup monitoring error")
 	}
 	return nil
 }
+
+func setupAlerting() error {
+	if err := alerting.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup alerting error")
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+// +build !windows
+
+package cmd
+
+import (
+	"os"
+)
+
+// runService runs the application-server as a Windows service. On other
+// platforms the application-server is never started as a service.
+func runService(serve func(chan os.Signal) error) (bool, error) {
+	return false, nil
+}
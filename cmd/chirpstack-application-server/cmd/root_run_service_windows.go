@@ -0,0 +1,83 @@
+// +build windows
+
+package cmd
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows/svc"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+// serviceName is the name of the Windows service and of the Event Log
+// source.
+const serviceName = "chirpstack-application-server"
+
+// runService runs the application-server as a Windows service when it has
+// been started by the service control manager. It returns false when the
+// application-server is running interactively.
+func runService(serve func(chan os.Signal) error) (bool, error) {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return true, errors.Wrap(err, "determine windows service error")
+	}
+	if !isService {
+		return false, nil
+	}
+
+	if err := svc.Run(serviceName, &windowsService{serve: serve}); err != nil {
+		return true, errors.Wrap(err, "run windows service error")
+	}
+	return true, nil
+}
+
+// windowsService implements the svc.Handler interface. Stop and shutdown
+// requests of the service control manager are translated into a SIGTERM,
+// so that the application-server is drained as on other platforms.
+type windowsService struct {
+	serve func(chan os.Signal) error
+}
+
+// Execute implements the svc.Handler interface.
+func (s *windowsService) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+
+	sigChan := make(chan os.Signal, 2)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.serve(sigChan)
+	}()
+
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				log.WithError(err).Error("windows service error")
+				return false, 1
+			}
+			return false, 0
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				changes <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{
+					State:    svc.StopPending,
+					WaitHint: uint32((config.C.General.ShutdownTimeout + 5*time.Second) / time.Millisecond),
+				}
+
+				select {
+				case sigChan <- syscall.SIGTERM:
+				default:
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// +build windows
+
+package cmd
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows/svc/eventlog"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+// eventLogID is the event id of the log messages written to the Event Log.
+const eventLogID = 1
+
+func setSyslog() error {
+	if !config.C.General.LogToSyslog {
+		return nil
+	}
+
+	l, err := eventlog.Open(serviceName)
+	if err != nil {
+		return errors.Wrap(err, "open event log error")
+	}
+
+	log.AddHook(&eventLogHook{log: l})
+
+	return nil
+}
+
+// eventLogHook writes the log messages to the Windows Event Log. As the Event
+// Log only knows the error, warning and info types, debug messages are
+// written as info.
+type eventLogHook struct {
+	log *eventlog.Log
+}
+
+// Levels implements the logrus.Hook interface.
+func (h *eventLogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire implements the logrus.Hook interface.
+func (h *eventLogHook) Fire(e *log.Entry) error {
+	line, err := e.String()
+	if err != nil {
+		return err
+	}
+
+	switch e.Level {
+	case log.PanicLevel, log.FatalLevel, log.ErrorLevel:
+		return h.log.Error(eventLogID, line)
+	case log.WarnLevel:
+		return h.log.Warning(eventLogID, line)
+	default:
+		return h.log.Info(eventLogID, line)
+	}
+}
@@ -0,0 +1,119 @@
+// +build windows
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+var serviceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Install or uninstall the Windows service",
+}
+
+var serviceInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Install the Windows service",
+	Long: `Install the Windows service.
+The service is started automatically at boot and uses the configuration file
+(and configuration directory) given by --config (and --config-dir). To write
+the log messages to the Windows Event Log, set log_to_syslog=true in the
+configuration file.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if cfgFile == "" && cfgDir == "" {
+			return errors.New("--config or --config-dir must be set")
+		}
+
+		exe, err := os.Executable()
+		if err != nil {
+			return errors.Wrap(err, "get executable path error")
+		}
+
+		var serviceArgs []string
+		if cfgFile != "" {
+			p, err := filepath.Abs(cfgFile)
+			if err != nil {
+				return errors.Wrap(err, "get config file path error")
+			}
+			serviceArgs = append(serviceArgs, "--config", p)
+		}
+		if cfgDir != "" {
+			p, err := filepath.Abs(cfgDir)
+			if err != nil {
+				return errors.Wrap(err, "get config directory path error")
+			}
+			serviceArgs = append(serviceArgs, "--config-dir", p)
+		}
+
+		m, err := mgr.Connect()
+		if err != nil {
+			return errors.Wrap(err, "connect to service manager error")
+		}
+		defer m.Disconnect()
+
+		if s, err := m.OpenService(serviceName); err == nil {
+			s.Close()
+			return errors.Errorf("service %s already exists", serviceName)
+		}
+
+		s, err := m.CreateService(serviceName, exe, mgr.Config{
+			DisplayName: "ChirpStack Application Server",
+			Description: "ChirpStack Application Server, part of the ChirpStack LoRaWAN Network Server stack.",
+			StartType:   mgr.StartAutomatic,
+		}, serviceArgs...)
+		if err != nil {
+			return errors.Wrap(err, "create service error")
+		}
+		defer s.Close()
+
+		if err := eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+			s.Delete()
+			return errors.Wrap(err, "install event log source error")
+		}
+
+		fmt.Printf("service %s installed\n", serviceName)
+		return nil
+	},
+}
+
+var serviceUninstallCmd = &cobra.Command{
+	Use:   "uninstall",
+	Short: "Uninstall the Windows service",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		m, err := mgr.Connect()
+		if err != nil {
+			return errors.Wrap(err, "connect to service manager error")
+		}
+		defer m.Disconnect()
+
+		s, err := m.OpenService(serviceName)
+		if err != nil {
+			return errors.Wrapf(err, "open service %s error", serviceName)
+		}
+		defer s.Close()
+
+		if err := s.Delete(); err != nil {
+			return errors.Wrap(err, "delete service error")
+		}
+
+		if err := eventlog.Remove(serviceName); err != nil {
+			return errors.Wrap(err, "remove event log source error")
+		}
+
+		fmt.Printf("service %s uninstalled\n", serviceName)
+		return nil
+	},
+}
+
+func init() {
+	serviceCmd.AddCommand(serviceInstallCmd)
+	serviceCmd.AddCommand(serviceUninstallCmd)
+	rootCmd.AddCommand(serviceCmd)
+}
@@ -0,0 +1,221 @@
+// Package alerting sends operator alerts when internal subsystems of the
+// application-server degrade. These alerts are about the application-server
+// itself and are not related to device alarms.
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gofrs/uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+)
+
+// Status defines the alert status.
+type Status string
+
+// Available alert statuses.
+const (
+	StatusFiring   Status = "firing"
+	StatusResolved Status = "resolved"
+)
+
+// Alert defines an operator alert.
+type Alert struct {
+	Name     string     `json:"name"`
+	Status   Status     `json:"status"`
+	Summary  string     `json:"summary"`
+	Hostname string     `json:"hostname"`
+	StartsAt time.Time  `json:"startsAt"`
+	EndsAt   *time.Time `json:"endsAt,omitempty"`
+}
+
+// Notifier defines the interface for sending alerts.
+type Notifier interface {
+	Notify(ctx context.Context, a Alert) error
+}
+
+// check defines a named condition. The condition is failing when the check
+// returns an error.
+type check struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// alertState holds the state of a firing alert.
+type alertState struct {
+	startsAt   time.Time
+	lastSentAt time.Time
+}
+
+// manager evaluates the checks and sends an alert when a check starts
+// failing, repeats it every repeatInterval while the check keeps failing and
+// sends a resolved alert once the check recovers.
+type manager struct {
+	notifiers      []Notifier
+	checks         func(ctx context.Context) []check
+	repeatInterval time.Duration
+	timeout        time.Duration
+	hostname       string
+	firing         map[string]*alertState
+}
+
+// Setup configures the package and starts the check loop. When no webhook
+// or e-mail recipients are configured, alerting is disabled.
+func Setup(conf config.Config) error {
+	c := conf.Monitoring.Alerting
+
+	var notifiers []Notifier
+	if c.Webhook.URL != "" {
+		notifiers = append(notifiers, &webhookNotifier{
+			url:     c.Webhook.URL,
+			headers: c.Webhook.Headers,
+		})
+	}
+	if len(c.Email.To) != 0 {
+		notifiers = append(notifiers, &emailNotifier{
+			server:   c.Email.Server,
+			username: c.Email.Username,
+			password: c.Email.Password,
+			from:     c.Email.From,
+			to:       c.Email.To,
+		})
+	}
+
+	if len(notifiers) == 0 {
+		return nil
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.WithError(err).Warning("alerting: get hostname error")
+	}
+
+	m := &manager{
+		notifiers:      notifiers,
+		checks:         defaultChecks(c.IntegrationFailureThreshold, c.RedisLatencyThreshold),
+		repeatInterval: c.RepeatInterval,
+		timeout:        c.CheckInterval / 2,
+		hostname:       hostname,
+		firing:         make(map[string]*alertState),
+	}
+
+	log.WithFields(log.Fields{
+		"check_interval": c.CheckInterval,
+		"notifiers":      len(notifiers),
+	}).Info("alerting: starting check loop")
+
+	go m.loop(c.CheckInterval)
+
+	return nil
+}
+
+func (m *manager) loop(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		ctxID, err := uuid.NewV4()
+		if err != nil {
+			log.WithError(err).Error("new uuid error")
+		}
+
+		ctx := context.Background()
+		ctx = context.WithValue(ctx, logging.ContextIDKey, ctxID)
+
+		m.run(ctx, time.Now())
+	}
+}
+
+// run evaluates all checks and sends the resulting alerts.
+func (m *manager) run(ctx context.Context, now time.Time) {
+	seen := make(map[string]struct{})
+
+	for _, c := range m.checks(ctx) {
+		seen[c.name] = struct{}{}
+
+		err := m.runCheck(ctx, c)
+		state, firing := m.firing[c.name]
+
+		switch {
+		case err != nil && !firing:
+			state = &alertState{startsAt: now}
+			m.firing[c.name] = state
+			fallthrough
+		case err != nil && now.Sub(state.lastSentAt) >= m.repeatInterval:
+			if m.notify(ctx, Alert{
+				Name:     c.name,
+				Status:   StatusFiring,
+				Summary:  err.Error(),
+				Hostname: m.hostname,
+				StartsAt: state.startsAt,
+			}) {
+				state.lastSentAt = now
+			}
+		case err == nil && firing:
+			m.resolve(ctx, c.name, state, now)
+		}
+	}
+
+	// checks which no longer exist (e.g. a removed network-server) are
+	// resolved, as these will never recover otherwise
+	for name, state := range m.firing {
+		if _, ok := seen[name]; !ok {
+			m.resolve(ctx, name, state, now)
+		}
+	}
+}
+
+// runCheck runs the given check within the configured timeout.
+func (m *manager) runCheck(ctx context.Context, c check) error {
+	if m.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.timeout)
+		defer cancel()
+	}
+
+	return c.check(ctx)
+}
+
+func (m *manager) resolve(ctx context.Context, name string, state *alertState, now time.Time) {
+	delete(m.firing, name)
+
+	m.notify(ctx, Alert{
+		Name:     name,
+		Status:   StatusResolved,
+		Summary:  "the condition has recovered",
+		Hostname: m.hostname,
+		StartsAt: state.startsAt,
+		EndsAt:   &now,
+	})
+}
+
+// notify sends the alert to all notifiers. It returns true when at least
+// one of the notifiers succeeded.
+func (m *manager) notify(ctx context.Context, a Alert) bool {
+	log.WithFields(log.Fields{
+		"name":    a.Name,
+		"status":  a.Status,
+		"summary": a.Summary,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Warning("alerting: sending alert")
+
+	var sent bool
+	for _, n := range m.notifiers {
+		if err := n.Notify(ctx, a); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"name":     a.Name,
+				"notifier": fmt.Sprintf("%T", n),
+				"ctx_id":   ctx.Value(logging.ContextIDKey),
+			}).Error("alerting: send alert error")
+			continue
+		}
+		sent = true
+	}
+
+	return sent
+}
@@ -0,0 +1,117 @@
+package alerting
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testNotifier struct {
+	alerts []Alert
+}
+
+func (n *testNotifier) Notify(ctx context.Context, a Alert) error {
+	n.alerts = append(n.alerts, a)
+	return nil
+}
+
+func TestManager(t *testing.T) {
+	assert := require.New(t)
+
+	var redisErr, nsErr error
+	nsExists := true
+
+	n := &testNotifier{}
+	m := &manager{
+		notifiers: []Notifier{n},
+		checks: func(ctx context.Context) []check {
+			checks := []check{
+				{name: "redis_latency", check: func(ctx context.Context) error { return redisErr }},
+			}
+			if nsExists {
+				checks = append(checks, check{name: "network_server ns", check: func(ctx context.Context) error { return nsErr }})
+			}
+			return checks
+		},
+		repeatInterval: time.Hour,
+		hostname:       "test",
+		firing:         make(map[string]*alertState),
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+
+	t.Run("All checks pass", func(t *testing.T) {
+		m.run(ctx, now)
+		assert.Len(n.alerts, 0)
+	})
+
+	t.Run("Check starts failing", func(t *testing.T) {
+		redisErr = errors.New("redis ping took 2s")
+		m.run(ctx, now)
+		assert.Len(n.alerts, 1)
+		assert.Equal(Alert{
+			Name:     "redis_latency",
+			Status:   StatusFiring,
+			Summary:  "redis ping took 2s",
+			Hostname: "test",
+			StartsAt: now,
+		}, n.alerts[0])
+	})
+
+	t.Run("Check keeps failing within repeat interval", func(t *testing.T) {
+		m.run(ctx, now.Add(time.Minute))
+		assert.Len(n.alerts, 1)
+	})
+
+	t.Run("Check keeps failing after repeat interval", func(t *testing.T) {
+		m.run(ctx, now.Add(time.Hour))
+		assert.Len(n.alerts, 2)
+		assert.Equal(StatusFiring, n.alerts[1].Status)
+		assert.Equal(now, n.alerts[1].StartsAt)
+	})
+
+	t.Run("Check recovers", func(t *testing.T) {
+		redisErr = nil
+		end := now.Add(2 * time.Hour)
+		m.run(ctx, end)
+		assert.Len(n.alerts, 3)
+		assert.Equal(StatusResolved, n.alerts[2].Status)
+		assert.Equal(now, n.alerts[2].StartsAt)
+		assert.Equal(&end, n.alerts[2].EndsAt)
+		assert.Len(m.firing, 0)
+	})
+
+	t.Run("Removed check is resolved", func(t *testing.T) {
+		nsErr = errors.New("unreachable")
+		m.run(ctx, now)
+		assert.Len(n.alerts, 4)
+		assert.Equal("network_server ns", n.alerts[3].Name)
+		assert.Equal(StatusFiring, n.alerts[3].Status)
+
+		nsExists = false
+		m.run(ctx, now)
+		assert.Len(n.alerts, 5)
+		assert.Equal("network_server ns", n.alerts[4].Name)
+		assert.Equal(StatusResolved, n.alerts[4].Status)
+	})
+}
+
+func TestIntegrationFailuresCheck(t *testing.T) {
+	assert := require.New(t)
+	ctx := context.Background()
+
+	var failed uint64 = 10
+	c := integrationFailuresCheck(func() uint64 { return failed }, 5)
+
+	failed += 5
+	assert.NoError(c(ctx))
+
+	failed += 6
+	assert.Error(c(ctx))
+
+	assert.NoError(c(ctx))
+}
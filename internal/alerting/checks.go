@@ -0,0 +1,127 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/pkg/errors"
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/integration/multi"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// maxNetworkServers defines the max. number of network-servers that are
+// checked.
+const maxNetworkServers = 100
+
+// defaultChecks returns the checks of the internal subsystems.
+func defaultChecks(integrationFailureThreshold int, redisLatencyThreshold time.Duration) func(ctx context.Context) []check {
+	integrationFailures := integrationFailuresCheck(multi.FailedDeliveries, integrationFailureThreshold)
+
+	return func(ctx context.Context) []check {
+		checks := []check{
+			{name: "integration_failures", check: integrationFailures},
+			{name: "migrations_pending", check: migrationsPendingCheck},
+			{name: "redis_latency", check: redisLatencyCheck(redisLatencyThreshold)},
+		}
+
+		return append(checks, networkServerChecks(ctx)...)
+	}
+}
+
+// integrationFailuresCheck fails when more than threshold events could not
+// be delivered to the integrations since the previous check. As these
+// events are not retried, a growing number of failed deliveries means
+// that events are being lost.
+func integrationFailuresCheck(failedDeliveries func() uint64, threshold int) func(ctx context.Context) error {
+	last := failedDeliveries()
+
+	return func(ctx context.Context) error {
+		current := failedDeliveries()
+		n := current - last
+		last = current
+
+		if threshold > 0 && n > uint64(threshold) {
+			return fmt.Errorf("%d events failed to deliver to the integrations since the previous check (threshold: %d)", n, threshold)
+		}
+		return nil
+	}
+}
+
+// migrationsPendingCheck fails when there are schema migrations (including
+// the migration hook namespaces) which have not been applied.
+func migrationsPendingCheck(ctx context.Context) error {
+	var pending []string
+	for _, ns := range append([]string{""}, storage.GetMigrationNamespaces()...) {
+		ids, err := storage.PlanMigrations(storage.DB().DB.DB, ns, migrate.Up, 0)
+		if err != nil {
+			return errors.Wrap(err, "plan migrations error")
+		}
+		pending = append(pending, ids...)
+	}
+
+	if len(pending) != 0 {
+		return fmt.Errorf("%d schema migrations are pending: %s", len(pending), strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// redisLatencyCheck fails when the Redis ping fails or takes longer than
+// the given threshold.
+func redisLatencyCheck(threshold time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		start := time.Now()
+		if err := storage.RedisClient().DoContext(ctx, "ping").Err(); err != nil {
+			return errors.Wrap(err, "redis ping error")
+		}
+
+		if d := time.Since(start); threshold > 0 && d > threshold {
+			return fmt.Errorf("redis ping took %s (threshold: %s)", d, threshold)
+		}
+		return nil
+	}
+}
+
+// networkServerChecks returns a check for each network-server, failing when
+// the network-server is unreachable.
+func networkServerChecks(ctx context.Context) []check {
+	nss, err := storage.GetNetworkServers(ctx, storage.DB(), storage.NetworkServerFilters{
+		Limit: maxNetworkServers,
+	})
+	if err != nil {
+		return []check{
+			{
+				name: "network_server",
+				check: func(ctx context.Context) error {
+					return errors.Wrap(err, "get network-servers error")
+				},
+			},
+		}
+	}
+
+	var checks []check
+	for _, n := range nss {
+		n := n
+		checks = append(checks, check{
+			name: fmt.Sprintf("network_server %s", n.Name),
+			check: func(ctx context.Context) error {
+				nsClient, err := networkserver.GetPool().Get(n.Server, []byte(n.CACert), []byte(n.TLSCert), []byte(n.TLSKey))
+				if err != nil {
+					return errors.Wrap(err, "get network-server client error")
+				}
+
+				if _, err := nsClient.GetVersion(ctx, &empty.Empty{}); err != nil {
+					return errors.Wrapf(err, "network-server %s (%s) is unreachable", n.Name, n.Server)
+				}
+				return nil
+			},
+		})
+	}
+
+	return checks
+}
@@ -0,0 +1,105 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// notifyTimeout defines the max. duration for sending a webhook alert.
+const notifyTimeout = 10 * time.Second
+
+// webhookNotifier posts the alerts as JSON to the configured url.
+type webhookNotifier struct {
+	url     string
+	headers map[string]string
+}
+
+// Notify implements the Notifier interface.
+func (n *webhookNotifier) Notify(ctx context.Context, a Alert) error {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return errors.Wrap(err, "marshal json error")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest("POST", n.url, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "new request error")
+	}
+	req = req.WithContext(ctx)
+
+	for k, v := range n.headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "http request error")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("expected 2xx response, got: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// emailNotifier sends the alerts by e-mail.
+type emailNotifier struct {
+	server   string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+// Notify implements the Notifier interface.
+func (n *emailNotifier) Notify(ctx context.Context, a Alert) error {
+	var auth smtp.Auth
+	if n.username != "" {
+		host, _, err := net.SplitHostPort(n.server)
+		if err != nil {
+			return errors.Wrap(err, "split host port error")
+		}
+		auth = smtp.PlainAuth("", n.username, n.password, host)
+	}
+
+	if err := smtp.SendMail(n.server, auth, n.from, n.to, emailMessage(n.from, n.to, a)); err != nil {
+		return errors.Wrap(err, "send mail error")
+	}
+
+	return nil
+}
+
+// emailMessage returns the e-mail message (headers and body) of the alert.
+func emailMessage(from string, to []string, a Alert) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: [%s] %s on %s\r\n", strings.ToUpper(string(a.Status)), a.Name, a.Hostname)
+	fmt.Fprintf(&b, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&b, "\r\n")
+	fmt.Fprintf(&b, "Alert: %s\r\n", a.Name)
+	fmt.Fprintf(&b, "Status: %s\r\n", a.Status)
+	fmt.Fprintf(&b, "Host: %s\r\n", a.Hostname)
+	fmt.Fprintf(&b, "Started at: %s\r\n", a.StartsAt.Format(time.RFC3339))
+	if a.EndsAt != nil {
+		fmt.Fprintf(&b, "Ended at: %s\r\n", a.EndsAt.Format(time.RFC3339))
+	}
+	fmt.Fprintf(&b, "\r\n%s\r\n", a.Summary)
+
+	return b.Bytes()
+}
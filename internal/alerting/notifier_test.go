@@ -0,0 +1,66 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	assert := require.New(t)
+
+	var received Alert
+	var auth string
+	status := http.StatusOK
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	n := &webhookNotifier{
+		url:     server.URL,
+		headers: map[string]string{"Authorization": "Bearer secret"},
+	}
+
+	a := Alert{
+		Name:     "redis_latency",
+		Status:   StatusFiring,
+		Summary:  "redis ping took 2s",
+		Hostname: "test",
+		StartsAt: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	assert.NoError(n.Notify(context.Background(), a))
+	assert.Equal(a, received)
+	assert.Equal("Bearer secret", auth)
+
+	status = http.StatusInternalServerError
+	assert.Error(n.Notify(context.Background(), a))
+}
+
+func TestEmailMessage(t *testing.T) {
+	assert := require.New(t)
+
+	end := time.Date(2020, 1, 1, 1, 0, 0, 0, time.UTC)
+	msg := string(emailMessage("as@example.com", []string{"ops@example.com", "oncall@example.com"}, Alert{
+		Name:     "migrations_pending",
+		Status:   StatusResolved,
+		Summary:  "the condition has recovered",
+		Hostname: "test",
+		StartsAt: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndsAt:   &end,
+	}))
+
+	assert.True(strings.HasPrefix(msg, "From: as@example.com\r\nTo: ops@example.com, oncall@example.com\r\nSubject: [RESOLVED] migrations_pending on test\r\n"))
+	assert.Contains(msg, "Ended at: 2020-01-01T01:00:00Z\r\n")
+	assert.Contains(msg, "\r\n\r\nAlert: migrations_pending\r\n")
+}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"context"
+
 	"github.com/pkg/errors"
 
 	"github.com/ibrahimozekici/app-server2/internal/api/as"
@@ -25,3 +27,23 @@ func Setup(conf config.Config) error {
 
 	return nil
 }
+
+// Shutdown stops the API endpoints from accepting new requests and waits for
+// the pending requests to complete. The application-server api is stopped
+// last, such that the uplinks received from the network-server are handled
+// as long as possible.
+func Shutdown(ctx context.Context) error {
+	if err := external.Shutdown(ctx); err != nil {
+		return errors.Wrap(err, "shutdown external api error")
+	}
+
+	if err := js.Shutdown(ctx); err != nil {
+		return errors.Wrap(err, "shutdown join-server api error")
+	}
+
+	if err := as.Shutdown(ctx); err != nil {
+		return errors.Wrap(err, "shutdown application-server api error")
+	}
+
+	return nil
+}
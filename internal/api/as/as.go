@@ -19,11 +19,15 @@ import (
 	// "github.com/ibrahimozekici/lora-api/go/v3/as"
 	pb //"github.com/ibrahimozekici/lora-api/go/v3/as/integration"
 	"github.com/ibrahimozekici/app-server2/internal/api/helpers"
+	"github.com/ibrahimozekici/app-server2/internal/automation"
 	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/downlink"
 	"github.com/ibrahimozekici/app-server2/internal/events/uplink"
 	"github.com/ibrahimozekici/app-server2/internal/gwping"
 	"github.com/ibrahimozekici/app-server2/internal/integration"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
 	"github.com/ibrahimozekici/app-server2/internal/storage"
+	"github.com/ibrahimozekici/app-server2/internal/tracing"
 	//"github.com/brocaar/lorawan"
 )
 
@@ -32,6 +36,8 @@ var (
 	caCert  string
 	tlsCert string
 	tlsKey  string
+
+	server *grpc.Server
 )
 
 // Setup configures the package.
@@ -49,6 +55,7 @@ func Setup(conf config.Config) error {
 	}).Info("api/as: starting application-server api")
 
 	grpcOpts := helpers.GetgRPCServerOptions()
+	grpcOpts = append(grpcOpts, grpc.ChainUnaryInterceptor(tracing.UnaryServerInterceptor()))
 	if caCert != "" && tlsCert != "" && tlsKey != "" {
 		creds, err := helpers.GetTransportCredentials(caCert, tlsCert, tlsKey, true)
 		if err != nil {
@@ -56,7 +63,7 @@ func Setup(conf config.Config) error {
 		}
 		grpcOpts = append(grpcOpts, grpc.Creds(creds))
 	}
-	server := grpc.NewServer(grpcOpts...)
+	server = grpc.NewServer(grpcOpts...)
 	as.RegisterApplicationServerServiceServer(server, NewApplicationServerAPI())
 
 	ln, err := net.Listen("tcp", bind)
@@ -68,6 +75,30 @@ func Setup(conf config.Config) error {
 	return nil
 }
 
+// Shutdown stops the application-server api. It stops accepting new
+// connections and blocks until the pending requests (e.g. uplinks) have been
+// handled. When the context is cancelled first, the pending requests are
+// cancelled.
+func Shutdown(ctx context.Context) error {
+	if server == nil {
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		server.Stop()
+		return ctx.Err()
+	}
+}
+
 // ApplicationServerAPI implements the as.ApplicationServerServer interface.
 type ApplicationServerAPI struct {
 }
@@ -77,9 +108,15 @@ func NewApplicationServerAPI() *ApplicationServerAPI {
 	return &ApplicationServerAPI{}
 }
 
-// HandleUplinkData handles incoming (uplink) data.
+// HandleUplinkData handles incoming (uplink) data. When the uplink pipeline
+// is enabled, the uplink is queued and this returns before it has been
+// handled. ResourceExhausted is returned when the uplink was dropped because
+// the queue is full.
 func (a *ApplicationServerAPI) HandleUplinkData(ctx context.Context, req *as.HandleUplinkDataRequest) (*empty.Empty, error) {
-	if err := uplink.Handle(ctx, *req); err != nil {
+	if err := uplink.Enqueue(ctx, *req); err != nil {
+		if err == uplink.ErrQueueFull {
+			return nil, grpc.Errorf(codes.ResourceExhausted, "handle uplink data error: %s", err)
+		}
 		return nil, grpc.Errorf(codes.Internal, "handle uplink data error: %s", err)
 	}
 
@@ -94,18 +131,25 @@ func (a *ApplicationServerAPI) HandleDownlinkACK(ctx context.Context, req *as.Ha
 	d, err := storage.GetDevice(ctx, storage.DB(), devEUI, false, true)
 	if err != nil {
 		errStr := fmt.Sprintf("get device error: %s", err)
-		log.WithField("dev_eui", devEUI).Error(errStr)
+		log.WithFields(log.Fields{
+			"dev_eui": devEUI,
+			"ctx_id":  ctx.Value(logging.ContextIDKey),
+		}).Error(errStr)
 		return nil, grpc.Errorf(codes.Internal, errStr)
 	}
 	app, err := storage.GetApplication(ctx, storage.DB(), d.ApplicationID)
 	if err != nil {
 		errStr := fmt.Sprintf("get application error: %s", err)
-		log.WithField("id", d.ApplicationID).Error(errStr)
+		log.WithFields(log.Fields{
+			"id":     d.ApplicationID,
+			"ctx_id": ctx.Value(logging.ContextIDKey),
+		}).Error(errStr)
 		return nil, grpc.Errorf(codes.Internal, errStr)
 	}
 
 	log.WithFields(log.Fields{
 		"dev_eui": devEUI,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
 	}).Info("downlink device-queue item acknowledged")
 
 	pl := pb.AckEvent{
@@ -134,7 +178,21 @@ func (a *ApplicationServerAPI) HandleDownlinkACK(ctx context.Context, req *as.Ha
 
 	err = integration.ForApplicationID(app.ID).HandleAckEvent(ctx, vars, pl)
 	if err != nil {
-		log.WithError(err).Error("send ack event error")
+		log.WithError(err).WithField("ctx_id", ctx.Value(logging.ContextIDKey)).Error("send ack event error")
+	}
+
+	if err := downlink.HandleDownlinkAck(ctx, app, d, req.FCnt, req.Acknowledged); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"dev_eui": devEUI,
+			"ctx_id":  ctx.Value(logging.ContextIDKey),
+		}).Error("handle downlink ack error")
+	}
+
+	if err := downlink.HandleConfirmedDownlinkACK(ctx, app, d, req.FCnt, req.Acknowledged); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"dev_eui": devEUI,
+			"ctx_id":  ctx.Value(logging.ContextIDKey),
+		}).Error("handle confirmed downlink ack error")
 	}
 
 	return &empty.Empty{}, nil
@@ -148,18 +206,25 @@ func (a *ApplicationServerAPI) HandleTxAck(ctx context.Context, req *as.HandleTx
 	d, err := storage.GetDevice(ctx, storage.DB(), devEUI, false, true)
 	if err != nil {
 		errStr := fmt.Sprintf("get device error: %s", err)
-		log.WithField("dev_eui", devEUI).Error(errStr)
+		log.WithFields(log.Fields{
+			"dev_eui": devEUI,
+			"ctx_id":  ctx.Value(logging.ContextIDKey),
+		}).Error(errStr)
 		return nil, grpc.Errorf(codes.Internal, errStr)
 	}
 	app, err := storage.GetApplication(ctx, storage.DB(), d.ApplicationID)
 	if err != nil {
 		errStr := fmt.Sprintf("get application error: %s", err)
-		log.WithField("id", d.ApplicationID).Error(errStr)
+		log.WithFields(log.Fields{
+			"id":     d.ApplicationID,
+			"ctx_id": ctx.Value(logging.ContextIDKey),
+		}).Error(errStr)
 		return nil, grpc.Errorf(codes.Internal, errStr)
 	}
 
 	log.WithFields(log.Fields{
 		"dev_eui": devEUI,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
 	}).Info("downlink tx acknowledged by gateway")
 
 	pl := pb.TxAckEvent{
@@ -187,7 +252,21 @@ func (a *ApplicationServerAPI) HandleTxAck(ctx context.Context, req *as.HandleTx
 
 	err = integration.ForApplicationID(app.ID).HandleTxAckEvent(ctx, vars, pl)
 	if err != nil {
-		log.WithError(err).Error("send tx ack event error")
+		log.WithError(err).WithField("ctx_id", ctx.Value(logging.ContextIDKey)).Error("send tx ack event error")
+	}
+
+	if err := storage.IncrementOrganizationUsage(ctx, app.OrganizationID, storage.UsageDownlinks, 1); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"dev_eui": devEUI,
+			"ctx_id":  ctx.Value(logging.ContextIDKey),
+		}).Error("meter downlink usage error")
+	}
+
+	if err := downlink.HandleDownlinkTxAck(ctx, app, d, req.FCnt); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"dev_eui": devEUI,
+			"ctx_id":  ctx.Value(logging.ContextIDKey),
+		}).Error("handle downlink tx ack error")
 	}
 
 	return &empty.Empty{}, nil
@@ -201,20 +280,27 @@ func (a *ApplicationServerAPI) HandleError(ctx context.Context, req *as.HandleEr
 	d, err := storage.GetDevice(ctx, storage.DB(), devEUI, false, true)
 	if err != nil {
 		errStr := fmt.Sprintf("get device error: %s", err)
-		log.WithField("dev_eui", devEUI).Error(errStr)
+		log.WithFields(log.Fields{
+			"dev_eui": devEUI,
+			"ctx_id":  ctx.Value(logging.ContextIDKey),
+		}).Error(errStr)
 		return nil, grpc.Errorf(codes.Internal, errStr)
 	}
 
 	app, err := storage.GetApplication(ctx, storage.DB(), d.ApplicationID)
 	if err != nil {
 		errStr := fmt.Sprintf("get application error: %s", err)
-		log.WithField("id", d.ApplicationID).Error(errStr)
+		log.WithFields(log.Fields{
+			"id":     d.ApplicationID,
+			"ctx_id": ctx.Value(logging.ContextIDKey),
+		}).Error(errStr)
 		return nil, grpc.Errorf(codes.Internal, errStr)
 	}
 
 	log.WithFields(log.Fields{
 		"type":    req.Type,
 		"dev_eui": devEUI,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
 	}).Error(req.Error)
 
 	var errType pb.ErrorType
@@ -263,7 +349,7 @@ func (a *ApplicationServerAPI) HandleError(ctx context.Context, req *as.HandleEr
 	err = integration.ForApplicationID(app.ID).HandleErrorEvent(ctx, vars, pl)
 	if err != nil {
 		errStr := fmt.Sprintf("send error notification to integration error: %s", err)
-		log.Error(errStr)
+		log.WithField("ctx_id", ctx.Value(logging.ContextIDKey)).Error(errStr)
 		return nil, grpc.Errorf(codes.Internal, errStr)
 	}
 
@@ -294,10 +380,10 @@ func (a *ApplicationServerAPI) SetDeviceStatus(ctx context.Context, req *as.SetD
 	var d storage.Device
 	var err error
 
-	err = storage.Transaction(func(tx sqlx.Ext) error {
+	err = storage.TransactionWithRetry(func(tx sqlx.Ext) error {
 		d, err = storage.GetDevice(ctx, tx, devEUI, true, true)
 		if err != nil {
-			return helpers.ErrToRPCError(errors.Wrap(err, "get device error"))
+			return errors.Wrap(err, "get device error")
 		}
 
 		marg := int(req.Margin)
@@ -315,13 +401,13 @@ func (a *ApplicationServerAPI) SetDeviceStatus(ctx context.Context, req *as.SetD
 		}
 
 		if err = storage.UpdateDevice(ctx, tx, &d, true); err != nil {
-			return helpers.ErrToRPCError(errors.Wrap(err, "update device error"))
+			return errors.Wrap(err, "update device error")
 		}
 
 		return nil
 	})
 	if err != nil {
-		return nil, err
+		return nil, helpers.ErrToRPCError(err)
 	}
 
 	app, err := storage.GetApplication(ctx, storage.DB(), d.ApplicationID)
@@ -360,6 +446,20 @@ func (a *ApplicationServerAPI) SetDeviceStatus(ctx context.Context, req *as.SetD
 		return nil, helpers.ErrToRPCError(errors.Wrap(err, "send status notification to handler error"))
 	}
 
+	// the automation actions might take a while (e.g. webhooks), this must
+	// not block the network-server
+	bgCtx := context.Background()
+	bgCtx = context.WithValue(bgCtx, logging.ContextIDKey, ctx.Value(logging.ContextIDKey))
+
+	go func(d storage.Device, margin int) {
+		if err := automation.HandleDeviceStatus(bgCtx, d, d.DeviceStatusBattery, margin); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"dev_eui": d.DevEUI,
+				"ctx_id":  bgCtx.Value(logging.ContextIDKey),
+			}).Error("handle device-status automations error")
+		}
+	}(d, int(req.Margin))
+
 	return &empty.Empty{}, nil
 }
 
@@ -375,10 +475,10 @@ func (a *ApplicationServerAPI) SetDeviceLocation(ctx context.Context, req *as.Se
 	var d storage.Device
 	var err error
 
-	err = storage.Transaction(func(tx sqlx.Ext) error {
+	err = storage.TransactionWithRetry(func(tx sqlx.Ext) error {
 		d, err = storage.GetDevice(ctx, tx, devEUI, true, true)
 		if err != nil {
-			return helpers.ErrToRPCError(errors.Wrap(err, "get device error"))
+			return errors.Wrap(err, "get device error")
 		}
 
 		d.Latitude = &req.Location.Latitude
@@ -386,13 +486,13 @@ func (a *ApplicationServerAPI) SetDeviceLocation(ctx context.Context, req *as.Se
 		d.Altitude = &req.Location.Altitude
 
 		if err = storage.UpdateDevice(ctx, tx, &d, true); err != nil {
-			return helpers.ErrToRPCError(errors.Wrap(err, "update device error"))
+			return errors.Wrap(err, "update device error")
 		}
 
 		return nil
 	})
 	if err != nil {
-		return nil, err
+		return nil, helpers.ErrToRPCError(err)
 	}
 
 	app, err := storage.GetApplication(ctx, storage.DB(), d.ApplicationID)
@@ -439,10 +539,10 @@ func (a *ApplicationServerAPI) HandleGatewayStats(ctx context.Context, req *as.H
 
 	ts := time.Now()
 
-	err := storage.Transaction(func(tx sqlx.Ext) error {
+	err := storage.TransactionWithRetry(func(tx sqlx.Ext) error {
 		gw, err := storage.GetGateway(ctx, tx, gatewayID, true)
 		if err != nil {
-			return helpers.ErrToRPCError(errors.Wrap(err, "get gateway error"))
+			return errors.Wrap(err, "get gateway error")
 		}
 
 		if gw.FirstSeenAt == nil {
@@ -464,13 +564,13 @@ func (a *ApplicationServerAPI) HandleGatewayStats(ctx context.Context, req *as.H
 		}
 
 		if err := storage.UpdateGateway(ctx, tx, &gw); err != nil {
-			return helpers.ErrToRPCError(errors.Wrap(err, "update gateway error"))
+			return errors.Wrap(err, "update gateway error")
 		}
 
 		return nil
 	})
 	if err != nil {
-		return nil, err
+		return nil, helpers.ErrToRPCError(err)
 	}
 
 	metrics := storage.MetricsRecord{
@@ -207,6 +207,11 @@ func (ts *ASTestSuite) TestApplicationServer() {
 			plJoin := <-h.SendJoinNotificationChan
 			assert.Equal([]byte{1, 2, 3, 4}, plJoin.DevAddr)
 
+			plSession := <-h.SendIntegrationNotificationChan
+			assert.Equal("join", plSession.IntegrationName)
+			assert.Equal("session", plSession.EventType)
+			assert.Contains(plSession.ObjectJson, `"devAddr":"01020304"`)
+
 			d, err := storage.GetDevice(context.Background(), storage.DB(), d.DevEUI, false, true)
 			assert.NoError(err)
 			assert.Equal(lorawan.DevAddr{0x01, 0x02, 0x03, 0x04}, d.DevAddr)
@@ -302,7 +307,7 @@ func (ts *ASTestSuite) TestApplicationServer() {
 							}
 						}
 					`
-				assert.NoError(storage.UpdateApplication(context.Background(), storage.DB(), app))
+				assert.NoError(storage.UpdateApplication(context.Background(), storage.DB(), &app))
 
 				_, err := api.HandleUplinkData(ctx, &req)
 				assert.NoError(err)
@@ -110,6 +110,8 @@ func (a *ApplicationAPI) Get(ctx context.Context, req *pb.GetApplicationRequest)
 		},
 	}
 
+	helpers.SetVersionHeader(ctx, app.UpdatedAt)
+
 	return &resp, nil
 }
 
@@ -125,11 +127,6 @@ func (a *ApplicationAPI) Update(ctx context.Context, req *pb.UpdateApplicationRe
 		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
 	}
 
-	app, err := storage.GetApplication(ctx, storage.DB(), req.Application.Id)
-	if err != nil {
-		return nil, helpers.ErrToRPCError(err)
-	}
-
 	spID, err := uuid.FromString(req.Application.ServiceProfileId)
 	if err != nil {
 		return nil, helpers.ErrToRPCError(err)
@@ -140,19 +137,32 @@ func (a *ApplicationAPI) Update(ctx context.Context, req *pb.UpdateApplicationRe
 		return nil, helpers.ErrToRPCError(err)
 	}
 
-	if sp.OrganizationID != app.OrganizationID {
-		return nil, grpc.Errorf(codes.InvalidArgument, "application and service-profile must be under the same organization")
-	}
+	// The application is locked for update so that the version check and
+	// the update can't interleave with a concurrent update.
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		app, err := storage.GetApplicationForUpdate(ctx, tx, req.Application.Id)
+		if err != nil {
+			return helpers.ErrToRPCError(err)
+		}
 
-	// update the fields
-	app.Name = req.Application.Name
-	app.Description = req.Application.Description
-	app.ServiceProfileID = spID
-	app.PayloadCodec = codec.Type(req.Application.PayloadCodec)
-	app.PayloadEncoderScript = req.Application.PayloadEncoderScript
-	app.PayloadDecoderScript = req.Application.PayloadDecoderScript
+		if err := helpers.CheckVersion(ctx, app.UpdatedAt); err != nil {
+			return err
+		}
 
-	err = storage.UpdateApplication(ctx, storage.DB(), app)
+		if sp.OrganizationID != app.OrganizationID {
+			return grpc.Errorf(codes.InvalidArgument, "application and service-profile must be under the same organization")
+		}
+
+		// update the fields
+		app.Name = req.Application.Name
+		app.Description = req.Application.Description
+		app.ServiceProfileID = spID
+		app.PayloadCodec = codec.Type(req.Application.PayloadCodec)
+		app.PayloadEncoderScript = req.Application.PayloadEncoderScript
+		app.PayloadDecoderScript = req.Application.PayloadDecoderScript
+
+		return storage.UpdateApplication(ctx, tx, &app)
+	})
 	if err != nil {
 		return nil, helpers.ErrToRPCError(err)
 	}
@@ -0,0 +1,332 @@
+package external
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// The application overview API implements the protocol of the Grafana JSON
+// datasource (https://grafana.com/grafana/plugins/simpod-json-datasource/),
+// such that the operational aggregates of an application can be added to a
+// Grafana dashboard without scraping internal endpoints. Configure the
+// datasource with the following URL and an API key as bearer token:
+//
+//   https://<host>/api/applications/<id>/overview
+//
+// The following targets are available (the target names are stable):
+//   * frames_per_minute: received uplink frames per minute (time series)
+//   * join_requests: join- and rejoin-requests handled by the join-server
+//     (time series)
+//   * join_accepts: accepted join- and rejoin-requests (time series)
+//   * join_success_rate: percentage of accepted join- and rejoin-requests
+//     (time series)
+//   * gateway_rssi: per gateway RSSI distribution of the received uplink
+//     frames, based on the device frame-log (table)
+//
+// The time series are based on the aggregated metrics, the resolution
+// (minute, hour, day or month) depends on the requested time range and
+// interval. Only the configured metrics aggregation intervals are available.
+
+// Application overview targets.
+const (
+	overviewFramesPerMinute = "frames_per_minute"
+	overviewJoinRequests    = "join_requests"
+	overviewJoinAccepts     = "join_accepts"
+	overviewJoinSuccessRate = "join_success_rate"
+	overviewGatewayRSSI     = "gateway_rssi"
+)
+
+// overviewMaxDataPoints defines the max. number of data points of a time
+// series, when not set by the request.
+const overviewMaxDataPoints = 1000
+
+var overviewTargets = []string{
+	overviewFramesPerMinute,
+	overviewJoinRequests,
+	overviewJoinAccepts,
+	overviewJoinSuccessRate,
+	overviewGatewayRSSI,
+}
+
+var overviewAggregations = []struct {
+	interval storage.AggregationInterval
+	duration time.Duration
+}{
+	{storage.AggregationMinute, time.Minute},
+	{storage.AggregationHour, time.Hour},
+	{storage.AggregationDay, 24 * time.Hour},
+	{storage.AggregationMonth, 31 * 24 * time.Hour},
+}
+
+// OverviewQueryRequest defines the (Grafana) query request.
+type OverviewQueryRequest struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	IntervalMS    int64 `json:"intervalMs"`
+	MaxDataPoints int   `json:"maxDataPoints"`
+	Targets       []struct {
+		Target string `json:"target"`
+		RefID  string `json:"refId"`
+	} `json:"targets"`
+}
+
+// OverviewTimeSeries defines a time series response. Each data point is
+// defined as [value, unix timestamp in milliseconds].
+type OverviewTimeSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// OverviewTableColumn defines a table column.
+type OverviewTableColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+// OverviewTable defines a table response.
+type OverviewTable struct {
+	Type    string                `json:"type"`
+	Columns []OverviewTableColumn `json:"columns"`
+	Rows    [][]interface{}       `json:"rows"`
+}
+
+// OverviewMetric defines a metric returned by the metrics endpoint.
+type OverviewMetric struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+// ApplicationOverviewAPI exports the application overview related functions.
+type ApplicationOverviewAPI struct {
+	validator auth.Validator
+}
+
+// NewApplicationOverviewAPI creates a new ApplicationOverviewAPI.
+func NewApplicationOverviewAPI(validator auth.Validator) *ApplicationOverviewAPI {
+	return &ApplicationOverviewAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *ApplicationOverviewAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/applications/{id}/overview", a.Test).Methods("GET")
+	r.HandleFunc("/api/applications/{id}/overview/", a.Test).Methods("GET")
+	r.HandleFunc("/api/applications/{id}/overview/search", a.Search).Methods("POST")
+	r.HandleFunc("/api/applications/{id}/overview/metrics", a.Metrics).Methods("POST")
+	r.HandleFunc("/api/applications/{id}/overview/query", a.Query).Methods("POST")
+}
+
+// Test is used by the datasource to test the connection.
+func (a *ApplicationOverviewAPI) Test(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.validate(w, r); !ok {
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// Search returns the available targets.
+func (a *ApplicationOverviewAPI) Search(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.validate(w, r); !ok {
+		return
+	}
+
+	httpWriteJSON(w, overviewTargets)
+}
+
+// Metrics returns the available targets (newer datasource versions).
+func (a *ApplicationOverviewAPI) Metrics(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.validate(w, r); !ok {
+		return
+	}
+
+	out := make([]OverviewMetric, 0, len(overviewTargets))
+	for _, t := range overviewTargets {
+		out = append(out, OverviewMetric{Label: t, Value: t})
+	}
+
+	httpWriteJSON(w, out)
+}
+
+// Query returns the data of the requested targets.
+func (a *ApplicationOverviewAPI) Query(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	id, ok := a.validate(w, r)
+	if !ok {
+		return
+	}
+
+	var req OverviewQueryRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if req.Range.From.IsZero() || req.Range.To.IsZero() || !req.Range.From.Before(req.Range.To) {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "range: from must be before to"))
+		return
+	}
+
+	agg := overviewAggregation(req.Range.From, req.Range.To, time.Duration(req.IntervalMS)*time.Millisecond, req.MaxDataPoints)
+
+	var metrics []storage.MetricsRecord
+	out := make([]interface{}, 0, len(req.Targets))
+
+	for _, t := range req.Targets {
+		switch t.Target {
+		case overviewFramesPerMinute, overviewJoinRequests, overviewJoinAccepts, overviewJoinSuccessRate:
+			if metrics == nil {
+				var err error
+				metrics, err = storage.GetMetrics(ctx, agg, storage.ApplicationMetricsName(id), req.Range.From, req.Range.To)
+				if err != nil {
+					httpWriteError(w, err)
+					return
+				}
+			}
+
+			out = append(out, overviewTimeSeries(t.Target, agg, metrics))
+		case overviewGatewayRSSI:
+			app, err := storage.GetApplication(ctx, storage.DB(), id)
+			if err != nil {
+				httpWriteError(w, err)
+				return
+			}
+
+			var dist []storage.GatewayRSSIDistribution
+			err = storage.ForOrganization(ctx, storage.DB(), app.OrganizationID, func(db sqlx.Ext) error {
+				var err error
+				dist, err = storage.GetGatewayRSSIDistribution(ctx, db, id, req.Range.From, req.Range.To)
+				return err
+			})
+			if err != nil {
+				httpWriteError(w, err)
+				return
+			}
+
+			out = append(out, overviewGatewayRSSITable(dist))
+		default:
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "unknown target: %s", t.Target))
+			return
+		}
+	}
+
+	httpWriteJSON(w, out)
+}
+
+// validate validates the access to the application and returns its ID.
+func (a *ApplicationOverviewAPI) validate(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := httpInt64Var(r, "id")
+	if err != nil {
+		httpWriteError(w, err)
+		return 0, false
+	}
+
+	if err := httpValidate(httpContext(r), a.validator,
+		auth.ValidateApplicationAccess(id, auth.Read),
+	); err != nil {
+		httpWriteError(w, err)
+		return 0, false
+	}
+
+	return id, true
+}
+
+// overviewAggregation returns the smallest aggregation interval which is
+// not smaller than the requested interval and which does not exceed the max.
+// number of data points for the given time range.
+func overviewAggregation(from, to time.Time, interval time.Duration, maxDataPoints int) storage.AggregationInterval {
+	if maxDataPoints <= 0 {
+		maxDataPoints = overviewMaxDataPoints
+	}
+
+	for _, a := range overviewAggregations {
+		if a.duration >= interval && int(to.Sub(from)/a.duration) <= maxDataPoints {
+			return a.interval
+		}
+	}
+
+	return storage.AggregationMonth
+}
+
+// overviewTimeSeries returns the time series of the given target.
+func overviewTimeSeries(target string, agg storage.AggregationInterval, metrics []storage.MetricsRecord) OverviewTimeSeries {
+	out := OverviewTimeSeries{
+		Target:     target,
+		Datapoints: make([][2]float64, 0, len(metrics)),
+	}
+
+	for _, m := range metrics {
+		var v float64
+
+		switch target {
+		case overviewFramesPerMinute:
+			v = m.Metrics[storage.ApplicationMetricRXCount] / overviewBucketMinutes(agg, m.Time)
+		case overviewJoinRequests:
+			v = m.Metrics[storage.ApplicationMetricJoinRequestCount]
+		case overviewJoinAccepts:
+			v = m.Metrics[storage.ApplicationMetricJoinAcceptCount]
+		case overviewJoinSuccessRate:
+			// the success rate is undefined without join-requests
+			requests := m.Metrics[storage.ApplicationMetricJoinRequestCount]
+			if requests == 0 {
+				continue
+			}
+			v = m.Metrics[storage.ApplicationMetricJoinAcceptCount] / requests * 100
+		}
+
+		out.Datapoints = append(out.Datapoints, [2]float64{v, float64(m.Time.UnixNano() / int64(time.Millisecond))})
+	}
+
+	return out
+}
+
+// overviewBucketMinutes returns the number of minutes of the aggregation
+// bucket starting at the given time.
+func overviewBucketMinutes(agg storage.AggregationInterval, ts time.Time) float64 {
+	switch agg {
+	case storage.AggregationHour:
+		return 60
+	case storage.AggregationDay:
+		return ts.AddDate(0, 0, 1).Sub(ts).Minutes()
+	case storage.AggregationMonth:
+		return ts.AddDate(0, 1, 0).Sub(ts).Minutes()
+	default:
+		return 1
+	}
+}
+
+// overviewGatewayRSSITable returns the RSSI distribution as table.
+func overviewGatewayRSSITable(dist []storage.GatewayRSSIDistribution) OverviewTable {
+	out := OverviewTable{
+		Type: "table",
+		Columns: []OverviewTableColumn{
+			{Text: "Gateway ID", Type: "string"},
+			{Text: "Frames", Type: "number"},
+			{Text: "Min RSSI", Type: "number"},
+			{Text: "P10 RSSI", Type: "number"},
+			{Text: "Median RSSI", Type: "number"},
+			{Text: "P90 RSSI", Type: "number"},
+			{Text: "Max RSSI", Type: "number"},
+			{Text: "Avg RSSI", Type: "number"},
+		},
+		Rows: make([][]interface{}, 0, len(dist)),
+	}
+
+	for _, d := range dist {
+		out.Rows = append(out.Rows, []interface{}{d.GatewayID, d.Count, d.Min, d.P10, d.P50, d.P90, d.Max, d.Avg})
+	}
+
+	return out
+}
@@ -0,0 +1,118 @@
+package external
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+func (ts *APITestSuite) TestApplicationOverview() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewApplicationOverviewAPI(validator).Register(r)
+
+	storage.RedisClient().FlushAll()
+	assert.NoError(storage.SetAggregationIntervals([]storage.AggregationInterval{storage.AggregationMinute}))
+	storage.SetMetricsTTL(time.Hour, time.Hour, time.Hour, time.Hour)
+
+	now := time.Now().Truncate(time.Minute)
+	assert.NoError(storage.SaveMetrics(ctx, storage.ApplicationMetricsName(1), storage.MetricsRecord{
+		Time: now,
+		Metrics: map[string]float64{
+			storage.ApplicationMetricRXCount:          10,
+			storage.ApplicationMetricJoinRequestCount: 4,
+			storage.ApplicationMetricJoinAcceptCount:  3,
+		},
+	}))
+
+	ts.T().Run("Test connection", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "GET", "/api/applications/1/overview", nil)
+		assert.Equal(http.StatusOK, rec.Code)
+	})
+
+	ts.T().Run("Search", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "POST", "/api/applications/1/overview/search", `{"target": ""}`)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var targets []string
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&targets))
+		assert.Equal(overviewTargets, targets)
+	})
+
+	ts.T().Run("Query unknown target", func(t *testing.T) {
+		assert := require.New(t)
+
+		body := fmt.Sprintf(`{"range": {"from": "%s", "to": "%s"}, "targets": [{"target": "foo"}]}`,
+			now.Add(-time.Hour).Format(time.RFC3339), now.Add(time.Minute).Format(time.RFC3339))
+		rec := httpTestRequest(r, "POST", "/api/applications/1/overview/query", body)
+		assert.Equal(http.StatusBadRequest, rec.Code)
+	})
+
+	ts.T().Run("Query", func(t *testing.T) {
+		assert := require.New(t)
+
+		body := fmt.Sprintf(`{"range": {"from": "%s", "to": "%s"}, "intervalMs": 60000, "targets": [{"target": "frames_per_minute"}, {"target": "join_success_rate"}, {"target": "gateway_rssi"}]}`,
+			now.Add(-time.Hour).Format(time.RFC3339), now.Add(time.Minute).Format(time.RFC3339))
+		rec := httpTestRequest(r, "POST", "/api/applications/1/overview/query", body)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp []json.RawMessage
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Len(resp, 3)
+
+		var frames OverviewTimeSeries
+		assert.NoError(json.Unmarshal(resp[0], &frames))
+		assert.Equal("frames_per_minute", frames.Target)
+		assert.Len(frames.Datapoints, 62)
+		assert.Equal([2]float64{10, float64(now.UnixNano() / int64(time.Millisecond))}, frames.Datapoints[60])
+
+		var joins OverviewTimeSeries
+		assert.NoError(json.Unmarshal(resp[1], &joins))
+		assert.Equal([][2]float64{{75, float64(now.UnixNano() / int64(time.Millisecond))}}, joins.Datapoints)
+
+		var rssi OverviewTable
+		assert.NoError(json.Unmarshal(resp[2], &rssi))
+		assert.Equal("table", rssi.Type)
+		assert.Len(rssi.Rows, 0)
+	})
+}
+
+func TestOverviewAggregation(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		Name          string
+		From          time.Time
+		Interval      time.Duration
+		MaxDataPoints int
+		Expected      storage.AggregationInterval
+	}{
+		{"last hour", now.Add(-time.Hour), 10 * time.Second, 0, storage.AggregationMinute},
+		{"last hour, hour interval", now.Add(-time.Hour), time.Hour, 0, storage.AggregationHour},
+		{"last 7 days", now.AddDate(0, 0, -7), time.Minute, 0, storage.AggregationHour},
+		{"last 7 days, limited data points", now.AddDate(0, 0, -7), time.Minute, 100, storage.AggregationDay},
+		{"last 10 years", now.AddDate(-10, 0, 0), time.Minute, 100, storage.AggregationMonth},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(tst.Expected, overviewAggregation(tst.From, now, tst.Interval, tst.MaxDataPoints))
+		})
+	}
+}
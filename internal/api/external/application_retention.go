@@ -0,0 +1,142 @@
+package external
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// ApplicationRetention defines the application data-retention policy.
+// The retention values are in days, 0 means no retention limit.
+type ApplicationRetention struct {
+	ApplicationID int64      `json:"applicationID,string"`
+	EventsDays    int        `json:"eventsDays"`
+	MetricsDays   int        `json:"metricsDays"`
+	FramesDays    int        `json:"framesDays"`
+	LocationsDays int        `json:"locationsDays"`
+	CreatedAt     *time.Time `json:"createdAt"`
+	UpdatedAt     *time.Time `json:"updatedAt"`
+}
+
+// GetApplicationRetentionResponse defines the get retention response.
+type GetApplicationRetentionResponse struct {
+	Retention ApplicationRetention `json:"retention"`
+}
+
+// UpdateApplicationRetentionRequest defines the update retention request.
+type UpdateApplicationRetentionRequest struct {
+	Retention ApplicationRetention `json:"retention"`
+}
+
+// ApplicationRetentionAPI exports the application data-retention related
+// functions.
+type ApplicationRetentionAPI struct {
+	validator auth.Validator
+}
+
+// NewApplicationRetentionAPI creates a new ApplicationRetentionAPI.
+func NewApplicationRetentionAPI(validator auth.Validator) *ApplicationRetentionAPI {
+	return &ApplicationRetentionAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *ApplicationRetentionAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/applications/{id}/retention", a.Get).Methods("GET")
+	r.HandleFunc("/api/applications/{id}/retention", a.Update).Methods("PUT")
+}
+
+// Get returns the retention policy of the given application.
+func (a *ApplicationRetentionAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	id, err := httpInt64Var(r, "id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(id, auth.Read),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if _, err := storage.GetApplication(ctx, storage.DB(), id); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := GetApplicationRetentionResponse{
+		Retention: ApplicationRetention{
+			ApplicationID: id,
+		},
+	}
+
+	ret, err := storage.GetApplicationRetention(ctx, storage.DB(), id)
+	if err != nil && err != storage.ErrDoesNotExist {
+		httpWriteError(w, err)
+		return
+	}
+	if err == nil {
+		resp.Retention = ApplicationRetention{
+			ApplicationID: ret.ApplicationID,
+			EventsDays:    ret.EventsDays,
+			MetricsDays:   ret.MetricsDays,
+			FramesDays:    ret.FramesDays,
+			LocationsDays: ret.LocationsDays,
+			CreatedAt:     &ret.CreatedAt,
+			UpdatedAt:     &ret.UpdatedAt,
+		}
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// Update updates the retention policy of the given application.
+func (a *ApplicationRetentionAPI) Update(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	id, err := httpInt64Var(r, "id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(id, auth.Update),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req UpdateApplicationRetentionRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if _, err := storage.GetApplication(ctx, storage.DB(), id); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := storage.UpdateApplicationRetention(ctx, storage.DB(), &storage.ApplicationRetention{
+		ApplicationID: id,
+		EventsDays:    req.Retention.EventsDays,
+		MetricsDays:   req.Retention.MetricsDays,
+		FramesDays:    req.Retention.FramesDays,
+		LocationsDays: req.Retention.LocationsDays,
+	}); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
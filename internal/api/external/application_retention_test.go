@@ -0,0 +1,119 @@
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+func (ts *APITestSuite) TestApplicationRetention() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewApplicationRetentionAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := storage.Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	path := fmt.Sprintf("/api/applications/%d/retention", app.ID)
+
+	get := func(t *testing.T) GetApplicationRetentionResponse {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "GET", path, nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp GetApplicationRetentionResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		return resp
+	}
+
+	ts.T().Run("Get not configured", func(t *testing.T) {
+		assert := require.New(t)
+
+		resp := get(t)
+		assert.Equal(ApplicationRetention{
+			ApplicationID: app.ID,
+		}, resp.Retention)
+	})
+
+	ts.T().Run("Update", func(t *testing.T) {
+		assert := require.New(t)
+
+		b, err := json.Marshal(UpdateApplicationRetentionRequest{
+			Retention: ApplicationRetention{
+				EventsDays:    30,
+				MetricsDays:   90,
+				FramesDays:    7,
+				LocationsDays: 14,
+			},
+		})
+		assert.NoError(err)
+
+		rec := httpTestRequest(r, "PUT", path, b)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		resp := get(t)
+		assert.Equal(app.ID, resp.Retention.ApplicationID)
+		assert.Equal(30, resp.Retention.EventsDays)
+		assert.Equal(90, resp.Retention.MetricsDays)
+		assert.Equal(7, resp.Retention.FramesDays)
+		assert.Equal(14, resp.Retention.LocationsDays)
+		assert.NotNil(resp.Retention.CreatedAt)
+	})
+
+	ts.T().Run("Update invalid", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "PUT", path, []byte(`{"retention": {"framesDays": -1}}`))
+		assert.Equal(http.StatusBadRequest, rec.Code)
+	})
+
+	ts.T().Run("Get unknown application", func(t *testing.T) {
+		assert := require.New(t)
+
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/applications/%d/retention", app.ID+1), nil)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		assert.Equal(http.StatusNotFound, rec.Code)
+	})
+}
@@ -0,0 +1,211 @@
+package external
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// slaDefaultTarget defines the default SLA target (percentage).
+const slaDefaultTarget = 99.0
+
+// SLAErrorBudget defines the error budget for the SLA target. Allowed is
+// the number of misses allowed by the target, Used the actual number of
+// misses. A negative Remaining means that the SLA target was not met.
+type SLAErrorBudget struct {
+	Allowed          int64   `json:"allowed"`
+	Used             int64   `json:"used"`
+	Remaining        int64   `json:"remaining"`
+	RemainingPercent float64 `json:"remainingPercent"`
+}
+
+// SLAUplinkReport defines the uplink delivery statistics.
+type SLAUplinkReport struct {
+	Devices      int64          `json:"devices"`
+	Expected     int64          `json:"expected"`
+	Received     int64          `json:"received"`
+	DeliveryRate float64        `json:"deliveryRate"`
+	ErrorBudget  SLAErrorBudget `json:"errorBudget"`
+}
+
+// SLAIntegrationReport defines the integration delivery statistics.
+type SLAIntegrationReport struct {
+	Events      int64          `json:"events"`
+	Failed      int64          `json:"failed"`
+	SuccessRate float64        `json:"successRate"`
+	ErrorBudget SLAErrorBudget `json:"errorBudget"`
+}
+
+// SLAReport defines the monthly SLA report of an application.
+type SLAReport struct {
+	ApplicationID int64                `json:"applicationID,string"`
+	Month         string               `json:"month"`
+	Start         time.Time            `json:"start"`
+	End           time.Time            `json:"end"`
+	Target        float64              `json:"target"`
+	Uplinks       SLAUplinkReport      `json:"uplinks"`
+	Integrations  SLAIntegrationReport `json:"integrations"`
+}
+
+// GetSLAReportResponse defines the SLA report response.
+type GetSLAReportResponse struct {
+	Report SLAReport `json:"report"`
+}
+
+// ApplicationSLAAPI exports the application SLA reporting related functions.
+type ApplicationSLAAPI struct {
+	validator auth.Validator
+}
+
+// NewApplicationSLAAPI creates a new ApplicationSLAAPI.
+func NewApplicationSLAAPI(validator auth.Validator) *ApplicationSLAAPI {
+	return &ApplicationSLAAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *ApplicationSLAAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/applications/{id}/sla", a.Get).Methods("GET")
+}
+
+// Get returns the SLA report of the given application for the requested
+// month (YYYY-MM, default the current month) and SLA target (percentage,
+// default 99).
+//
+// The expected uplinks are based on the uplink interval of the
+// device-profiles of the current devices (deleted devices are not taken
+// into account). The received uplinks and integration events are based on
+// the monthly aggregated metrics of the application.
+func (a *ApplicationSLAAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	id, err := httpInt64Var(r, "id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(id, auth.Read),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	target := slaDefaultTarget
+
+	q := r.URL.Query()
+	if v := q.Get("month"); v != "" {
+		t, err := time.ParseInLocation("2006-01", v, now.Location())
+		if err != nil {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "month: %s", err))
+			return
+		}
+		start = t
+	}
+	if v := q.Get("target"); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil || t <= 0 || t > 100 {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "target must be between 0 and 100"))
+			return
+		}
+		target = t
+	}
+
+	if start.After(now) {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "month must not be in the future"))
+		return
+	}
+
+	end := start.AddDate(0, 1, 0)
+	if end.After(now) {
+		end = now
+	}
+
+	exp, err := storage.GetApplicationUplinkExpectation(ctx, storage.DB(), id, start, end)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	metrics, err := storage.GetMetrics(ctx, storage.AggregationMonth, storage.ApplicationMetricsName(id), start, start)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var received, events, failed int64
+	for _, m := range metrics {
+		received += int64(m.Metrics[storage.ApplicationMetricRXCount])
+		events += int64(m.Metrics[storage.ApplicationMetricIntegrationEventCount])
+		failed += int64(m.Metrics[storage.ApplicationMetricIntegrationEventErrorCount])
+	}
+
+	missed := exp.ExpectedUplinks - received
+	if missed < 0 {
+		missed = 0
+	}
+
+	httpWriteJSON(w, GetSLAReportResponse{
+		Report: SLAReport{
+			ApplicationID: id,
+			Month:         start.Format("2006-01"),
+			Start:         start,
+			End:           end,
+			Target:        target,
+			Uplinks: SLAUplinkReport{
+				Devices:      exp.Devices,
+				Expected:     exp.ExpectedUplinks,
+				Received:     received,
+				DeliveryRate: slaRate(exp.ExpectedUplinks-missed, exp.ExpectedUplinks),
+				ErrorBudget:  slaErrorBudget(target, exp.ExpectedUplinks, missed),
+			},
+			Integrations: SLAIntegrationReport{
+				Events:      events,
+				Failed:      failed,
+				SuccessRate: slaRate(events-failed, events),
+				ErrorBudget: slaErrorBudget(target, events, failed),
+			},
+		},
+	})
+}
+
+// slaRate returns n as percentage of total, 100 when total is 0.
+func slaRate(n, total int64) float64 {
+	if total == 0 {
+		return 100
+	}
+	return float64(n) / float64(total) * 100
+}
+
+// slaErrorBudget returns the error budget for the given target, total and
+// number of misses.
+func slaErrorBudget(target float64, total, used int64) SLAErrorBudget {
+	allowed := int64(math.Floor(float64(total) * (100 - target) / 100))
+
+	out := SLAErrorBudget{
+		Allowed:          allowed,
+		Used:             used,
+		Remaining:        allowed - used,
+		RemainingPercent: 100,
+	}
+
+	if allowed > 0 {
+		out.RemainingPercent = float64(allowed-used) / float64(allowed) * 100
+	} else if used > 0 {
+		out.RemainingPercent = -100
+	}
+
+	return out
+}
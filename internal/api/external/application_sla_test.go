@@ -0,0 +1,91 @@
+package external
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+func (ts *APITestSuite) TestApplicationSLA() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewApplicationSLAAPI(validator).Register(r)
+
+	storage.RedisClient().FlushAll()
+	assert.NoError(storage.SetAggregationIntervals([]storage.AggregationInterval{storage.AggregationMonth}))
+	storage.SetMetricsTTL(time.Hour, time.Hour, time.Hour, time.Hour)
+
+	month := time.Date(2020, 2, 1, 0, 0, 0, 0, time.Local)
+	assert.NoError(storage.SaveMetrics(ctx, storage.ApplicationMetricsName(1), storage.MetricsRecord{
+		Time: month.Add(48 * time.Hour),
+		Metrics: map[string]float64{
+			storage.ApplicationMetricRXCount:                    10,
+			storage.ApplicationMetricIntegrationEventCount:      100,
+			storage.ApplicationMetricIntegrationEventErrorCount: 2,
+		},
+	}))
+
+	ts.T().Run("Invalid month", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "GET", "/api/applications/1/sla?month=2020-13", nil)
+		assert.Equal(http.StatusBadRequest, rec.Code)
+	})
+
+	ts.T().Run("Invalid target", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "GET", "/api/applications/1/sla?target=101", nil)
+		assert.Equal(http.StatusBadRequest, rec.Code)
+	})
+
+	ts.T().Run("Get", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "GET", "/api/applications/1/sla?month=2020-02", nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp GetSLAReportResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+
+		assert.EqualValues(1, resp.Report.ApplicationID)
+		assert.Equal("2020-02", resp.Report.Month)
+		assert.True(resp.Report.Start.Equal(month))
+		assert.True(resp.Report.End.Equal(month.AddDate(0, 1, 0)))
+		assert.Equal(99.0, resp.Report.Target)
+
+		assert.EqualValues(10, resp.Report.Uplinks.Received)
+
+		assert.Equal(SLAIntegrationReport{
+			Events:      100,
+			Failed:      2,
+			SuccessRate: 98,
+			ErrorBudget: SLAErrorBudget{
+				Allowed:          1,
+				Used:             2,
+				Remaining:        -1,
+				RemainingPercent: -100,
+			},
+		}, resp.Report.Integrations)
+	})
+}
+
+func TestSLAErrorBudget(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal(SLAErrorBudget{Allowed: 10, Used: 5, Remaining: 5, RemainingPercent: 50}, slaErrorBudget(99, 1000, 5))
+	assert.Equal(SLAErrorBudget{RemainingPercent: 100}, slaErrorBudget(99, 0, 0))
+	assert.Equal(SLAErrorBudget{Used: 1, Remaining: -1, RemainingPercent: -100}, slaErrorBudget(100, 50, 1))
+	assert.Equal(100.0, slaRate(0, 0))
+	assert.Equal(50.0, slaRate(1, 2))
+}
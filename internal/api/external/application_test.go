@@ -9,8 +9,10 @@ import (
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 
 	pb //"github.com/ibrahimozekici/lora-api/go/v3/as/external/api"
+	"github.com/ibrahimozekici/app-server2/internal/api/helpers"
 	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
 	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
 	"github.com/ibrahimozekici/app-server2/internal/storage"
@@ -222,6 +224,37 @@ func (ts *APITestSuite) TestApplication() {
 			}, app)
 		})
 
+		t.Run("Update with version", func(t *testing.T) {
+			assert := require.New(t)
+
+			app, err := storage.GetApplication(context.Background(), storage.DB(), createResp.Id)
+			assert.NoError(err)
+
+			req := pb.UpdateApplicationRequest{
+				Application: &pb.Application{
+					Id:                   createResp.Id,
+					Name:                 "test-app-updated",
+					Description:          "An updated test description",
+					ServiceProfileId:     spID.String(),
+					PayloadCodec:         "CUSTOM_JS",
+					PayloadEncoderScript: "Encode2() {}",
+					PayloadDecoderScript: "Decode2() {}",
+				},
+			}
+
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("if-match", helpers.FormatVersion(app.UpdatedAt)))
+			_, err = api.Update(ctx, &req)
+			assert.NoError(err)
+
+			// the version is now stale
+			_, err = api.Update(ctx, &req)
+			assert.Equal(codes.Aborted, grpc.Code(err))
+
+			ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("if-match", "foo"))
+			_, err = api.Update(ctx, &req)
+			assert.Equal(codes.InvalidArgument, grpc.Code(err))
+		})
+
 		t.Run("HTTPIntegration", func(t *testing.T) {
 			t.Run("Create", func(t *testing.T) {
 				assert := require.New(t)
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"database/sql"
 	"strings"
 
 	"github.com/gofrs/uuid"
@@ -2046,6 +2047,35 @@ func ValidateAPIKeyAccess(flag Flag, id uuid.UUID) ValidatorFunc {
 	}
 }
 
+// ValidateDeviceGroupAccess validates if the client has access to the given
+// device group (through the application of the group).
+func ValidateDeviceGroupAccess(id uuid.UUID, flag Flag) ValidatorFunc {
+	return validateApplicationResourceAccess(flag, "select application_id from device_group where id = $1", id)
+}
+
+// ValidateIrrigationScheduleAccess validates if the client has access to the
+// given irrigation schedule (through the application of the schedule).
+func ValidateIrrigationScheduleAccess(id uuid.UUID, flag Flag) ValidatorFunc {
+	return validateApplicationResourceAccess(flag, "select application_id from irrigation_schedule where id = $1", id)
+}
+
+// validateApplicationResourceAccess validates if the client has access to
+// the application of the resource, which application id is returned by the
+// given query. When the resource does not exist, the access is validated
+// against a non-existing application, so that only global admin users and
+// API keys are granted access. This way the validation does not reveal which
+// resources exist.
+func validateApplicationResourceAccess(flag Flag, query string, args ...interface{}) ValidatorFunc {
+	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+		var applicationID int64
+		if err := sqlx.Get(db, &applicationID, query, args...); err != nil && err != sql.ErrNoRows {
+			return false, errors.Wrap(err, "select error")
+		}
+
+		return ValidateApplicationAccess(applicationID, flag)(db, claims)
+	}
+}
+
 func executeQuery(db sqlx.Queryer, query string, where [][]string, args ...interface{}) (bool, error) {
 	var ors []string
 	for _, ands := range where {
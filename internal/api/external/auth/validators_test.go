@@ -11,6 +11,7 @@ import (
 
 	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
 	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/irrigation/schedule"
 	"github.com/ibrahimozekici/app-server2/internal/storage"
 	"github.com/ibrahimozekici/app-server2/internal/test"
 	//"github.com/brocaar/lorawan"
@@ -715,6 +716,147 @@ func (ts *ValidatorTestSuite) TestApplication() {
 	})
 }
 
+func (ts *ValidatorTestSuite) TestDeviceGroup() {
+	assert := require.New(ts.T())
+
+	users := []struct {
+		id       int64
+		username string
+		isActive bool
+		isAdmin  bool
+	}{
+		{username: "activeAdmin", isActive: true, isAdmin: true},
+		{username: "activeUser", isActive: true, isAdmin: false},
+	}
+
+	for i, user := range users {
+		id, err := ts.CreateUser(user.username, user.isActive, user.isAdmin)
+		assert.NoError(err)
+		users[i].id = id
+	}
+
+	orgUsers := []struct {
+		id             int64
+		organizationID int64
+		username       string
+		isAdmin        bool
+	}{
+		{organizationID: ts.organizations[0].ID, username: "org0ActiveUser", isAdmin: false},
+		{organizationID: ts.organizations[0].ID, username: "org0ActiveUserAdmin", isAdmin: true},
+		{organizationID: ts.organizations[1].ID, username: "org1ActiveUserAdmin", isAdmin: true},
+	}
+	for i, orgUser := range orgUsers {
+		id, err := ts.CreateUser(orgUser.username, true, false)
+		assert.NoError(err)
+		orgUsers[i].id = id
+
+		err = storage.CreateOrganizationUser(context.Background(), storage.DB(), orgUser.organizationID, id, orgUser.isAdmin, false, false)
+		assert.NoError(err)
+	}
+
+	sp := storage.ServiceProfile{Name: "test-sp-1", NetworkServerID: ts.networkServers[0].ID, OrganizationID: ts.organizations[0].ID}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, _ := uuid.FromBytes(sp.ServiceProfile.Id)
+
+	app := storage.Application{OrganizationID: ts.organizations[0].ID, Name: "application-1", ServiceProfileID: spID}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	dg := storage.DeviceGroup{ApplicationID: app.ID, Name: "group", Type: storage.DeviceGroupTypeManual}
+	assert.NoError(storage.CreateDeviceGroup(context.Background(), storage.DB(), &dg))
+
+	apiKeys := []storage.APIKey{
+		{Name: "admin", IsAdmin: true},
+		{Name: "app", ApplicationID: &app.ID},
+		{Name: "empty"},
+	}
+	for i := range apiKeys {
+		_, err := storage.CreateAPIKey(context.Background(), storage.DB(), &apiKeys[i])
+		assert.NoError(err)
+	}
+
+	unknownID, err := uuid.NewV4()
+	assert.NoError(err)
+
+	ts.T().Run("DeviceGroupAccess", func(t *testing.T) {
+		tests := []validatorTest{
+			{
+				Name:       "global admin users can read, update and delete",
+				Validators: []ValidatorFunc{ValidateDeviceGroupAccess(dg.ID, Read), ValidateDeviceGroupAccess(dg.ID, Update), ValidateDeviceGroupAccess(dg.ID, Delete)},
+				Claims:     Claims{UserID: users[0].id},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "organization admin users can read, update and delete",
+				Validators: []ValidatorFunc{ValidateDeviceGroupAccess(dg.ID, Read), ValidateDeviceGroupAccess(dg.ID, Update), ValidateDeviceGroupAccess(dg.ID, Delete)},
+				Claims:     Claims{UserID: orgUsers[1].id},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "organization users can read",
+				Validators: []ValidatorFunc{ValidateDeviceGroupAccess(dg.ID, Read)},
+				Claims:     Claims{UserID: orgUsers[0].id},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "organization users can not update or delete",
+				Validators: []ValidatorFunc{ValidateDeviceGroupAccess(dg.ID, Update), ValidateDeviceGroupAccess(dg.ID, Delete)},
+				Claims:     Claims{UserID: orgUsers[0].id},
+				ExpectedOK: false,
+			},
+			{
+				Name:       "admin users of an other organization can not read",
+				Validators: []ValidatorFunc{ValidateDeviceGroupAccess(dg.ID, Read)},
+				Claims:     Claims{UserID: orgUsers[2].id},
+				ExpectedOK: false,
+			},
+			{
+				Name:       "normal users can not read",
+				Validators: []ValidatorFunc{ValidateDeviceGroupAccess(dg.ID, Read)},
+				Claims:     Claims{UserID: users[1].id},
+				ExpectedOK: false,
+			},
+			{
+				Name:       "normal users can not read a non-existing group",
+				Validators: []ValidatorFunc{ValidateDeviceGroupAccess(unknownID, Read)},
+				Claims:     Claims{UserID: users[1].id},
+				ExpectedOK: false,
+			},
+			{
+				Name:       "global admin users can read a non-existing group",
+				Validators: []ValidatorFunc{ValidateDeviceGroupAccess(unknownID, Read)},
+				Claims:     Claims{UserID: users[0].id},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "admin api key can read, update and delete",
+				Validators: []ValidatorFunc{ValidateDeviceGroupAccess(dg.ID, Read), ValidateDeviceGroupAccess(dg.ID, Update), ValidateDeviceGroupAccess(dg.ID, Delete)},
+				Claims:     Claims{APIKeyID: apiKeys[0].ID},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "application api key can read, update and delete",
+				Validators: []ValidatorFunc{ValidateDeviceGroupAccess(dg.ID, Read), ValidateDeviceGroupAccess(dg.ID, Update), ValidateDeviceGroupAccess(dg.ID, Delete)},
+				Claims:     Claims{APIKeyID: apiKeys[1].ID},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "application api key can not read a non-existing group",
+				Validators: []ValidatorFunc{ValidateDeviceGroupAccess(unknownID, Read)},
+				Claims:     Claims{APIKeyID: apiKeys[1].ID},
+				ExpectedOK: false,
+			},
+			{
+				Name:       "empty api key can not read",
+				Validators: []ValidatorFunc{ValidateDeviceGroupAccess(dg.ID, Read)},
+				Claims:     Claims{APIKeyID: apiKeys[2].ID},
+				ExpectedOK: false,
+			},
+		}
+
+		ts.RunTests(t, tests)
+	})
+}
+
 func (ts *ValidatorTestSuite) TestDevice() {
 	assert := require.New(ts.T())
 
@@ -986,6 +1128,148 @@ func (ts *ValidatorTestSuite) TestDevice() {
 	})
 }
 
+func (ts *ValidatorTestSuite) TestIrrigationSchedule() {
+	assert := require.New(ts.T())
+
+	users := []struct {
+		id       int64
+		username string
+		isActive bool
+		isAdmin  bool
+	}{
+		{username: "activeAdmin", isActive: true, isAdmin: true},
+		{username: "activeUser", isActive: true, isAdmin: false},
+	}
+
+	for i, user := range users {
+		id, err := ts.CreateUser(user.username, user.isActive, user.isAdmin)
+		assert.NoError(err)
+		users[i].id = id
+	}
+
+	orgUsers := []struct {
+		id             int64
+		organizationID int64
+		username       string
+		isAdmin        bool
+	}{
+		{organizationID: ts.organizations[0].ID, username: "org0ActiveUser", isAdmin: false},
+		{organizationID: ts.organizations[0].ID, username: "org0ActiveUserAdmin", isAdmin: true},
+		{organizationID: ts.organizations[1].ID, username: "org1ActiveUserAdmin", isAdmin: true},
+	}
+	for i, orgUser := range orgUsers {
+		id, err := ts.CreateUser(orgUser.username, true, false)
+		assert.NoError(err)
+		orgUsers[i].id = id
+
+		err = storage.CreateOrganizationUser(context.Background(), storage.DB(), orgUser.organizationID, id, orgUser.isAdmin, false, false)
+		assert.NoError(err)
+	}
+
+	sp := storage.ServiceProfile{Name: "test-sp-1", NetworkServerID: ts.networkServers[0].ID, OrganizationID: ts.organizations[0].ID}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, _ := uuid.FromBytes(sp.ServiceProfile.Id)
+
+	app := storage.Application{OrganizationID: ts.organizations[0].ID, Name: "application-1", ServiceProfileID: spID}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	is := storage.IrrigationSchedule{
+		ApplicationID: app.ID,
+		Name:          "field 1",
+		Enabled:       true,
+		Schedule:      schedule.Schedule{Type: schedule.CronSchedule, Cron: "0 6 * * *"},
+		StartDownlink: schedule.Downlink{FPort: 10, Object: `{"valve": "open"}`},
+		Duration:      time.Hour,
+	}
+	assert.NoError(storage.CreateIrrigationSchedule(context.Background(), storage.DB(), &is))
+
+	apiKeys := []storage.APIKey{
+		{Name: "admin", IsAdmin: true},
+		{Name: "app", ApplicationID: &app.ID},
+		{Name: "empty"},
+	}
+	for i := range apiKeys {
+		_, err := storage.CreateAPIKey(context.Background(), storage.DB(), &apiKeys[i])
+		assert.NoError(err)
+	}
+
+	unknownID, err := uuid.NewV4()
+	assert.NoError(err)
+
+	ts.T().Run("IrrigationScheduleAccess", func(t *testing.T) {
+		tests := []validatorTest{
+			{
+				Name:       "global admin users can read, update and delete",
+				Validators: []ValidatorFunc{ValidateIrrigationScheduleAccess(is.ID, Read), ValidateIrrigationScheduleAccess(is.ID, Update), ValidateIrrigationScheduleAccess(is.ID, Delete)},
+				Claims:     Claims{UserID: users[0].id},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "organization admin users can read, update and delete",
+				Validators: []ValidatorFunc{ValidateIrrigationScheduleAccess(is.ID, Read), ValidateIrrigationScheduleAccess(is.ID, Update), ValidateIrrigationScheduleAccess(is.ID, Delete)},
+				Claims:     Claims{UserID: orgUsers[1].id},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "organization users can read",
+				Validators: []ValidatorFunc{ValidateIrrigationScheduleAccess(is.ID, Read)},
+				Claims:     Claims{UserID: orgUsers[0].id},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "organization users can not update or delete",
+				Validators: []ValidatorFunc{ValidateIrrigationScheduleAccess(is.ID, Update), ValidateIrrigationScheduleAccess(is.ID, Delete)},
+				Claims:     Claims{UserID: orgUsers[0].id},
+				ExpectedOK: false,
+			},
+			{
+				Name:       "admin users of an other organization can not read",
+				Validators: []ValidatorFunc{ValidateIrrigationScheduleAccess(is.ID, Read)},
+				Claims:     Claims{UserID: orgUsers[2].id},
+				ExpectedOK: false,
+			},
+			{
+				Name:       "normal users can not read a non-existing schedule",
+				Validators: []ValidatorFunc{ValidateIrrigationScheduleAccess(unknownID, Read)},
+				Claims:     Claims{UserID: users[1].id},
+				ExpectedOK: false,
+			},
+			{
+				Name:       "global admin users can read a non-existing schedule",
+				Validators: []ValidatorFunc{ValidateIrrigationScheduleAccess(unknownID, Read)},
+				Claims:     Claims{UserID: users[0].id},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "admin api key can read, update and delete",
+				Validators: []ValidatorFunc{ValidateIrrigationScheduleAccess(is.ID, Read), ValidateIrrigationScheduleAccess(is.ID, Update), ValidateIrrigationScheduleAccess(is.ID, Delete)},
+				Claims:     Claims{APIKeyID: apiKeys[0].ID},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "application api key can read, update and delete",
+				Validators: []ValidatorFunc{ValidateIrrigationScheduleAccess(is.ID, Read), ValidateIrrigationScheduleAccess(is.ID, Update), ValidateIrrigationScheduleAccess(is.ID, Delete)},
+				Claims:     Claims{APIKeyID: apiKeys[1].ID},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "application api key can not read a non-existing schedule",
+				Validators: []ValidatorFunc{ValidateIrrigationScheduleAccess(unknownID, Read)},
+				Claims:     Claims{APIKeyID: apiKeys[1].ID},
+				ExpectedOK: false,
+			},
+			{
+				Name:       "empty api key can not read",
+				Validators: []ValidatorFunc{ValidateIrrigationScheduleAccess(is.ID, Read)},
+				Claims:     Claims{APIKeyID: apiKeys[2].ID},
+				ExpectedOK: false,
+			},
+		}
+
+		ts.RunTests(t, tests)
+	})
+}
+
 func (ts *ValidatorTestSuite) TestDeviceProfile() {
 	assert := require.New(ts.T())
 
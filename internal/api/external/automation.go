@@ -0,0 +1,799 @@
+package external
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq/hstore"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+const (
+	// automationExecutionListMaxLimit defines the max. number of executions
+	// returned by a single list request.
+	automationExecutionListMaxLimit = 1000
+
+	// automationAlarmListMaxLimit defines the max. number of alarms
+	// returned by a single list request.
+	automationAlarmListMaxLimit = 1000
+)
+
+// Automation defines an automation which executes its actions for a device
+// of the application when its trigger fires for that device. When
+// DeviceTags is set, the automation only applies to the devices having all
+// of these tags. When Alarm or Escalation is set, each execution raises an
+// alarm for the device, which is recorded in the alarm history. When
+// Escalation is set, the alarm is escalated until it is acknowledged or
+// resolved. Dampening prevents notification storms (consecutive violations,
+// renotify interval and grouped notifications per zone). Severity (INFO,
+// WARNING or CRITICAL, WARNING when empty) is copied to the raised alarms,
+// which are also notified over the notification routes of the organization
+// matching this severity.
+type Automation struct {
+	ID              string            `json:"id"`
+	ApplicationID   int64             `json:"applicationID,string"`
+	Name            string            `json:"name"`
+	Enabled         bool              `json:"enabled"`
+	DeviceTags      map[string]string `json:"deviceTags"`
+	Trigger         spec.Trigger      `json:"trigger"`
+	Actions         spec.Actions      `json:"actions"`
+	Alarm           bool              `json:"alarm"`
+	Severity        spec.Severity     `json:"severity"`
+	Escalation      spec.Escalation   `json:"escalation"`
+	Dampening       spec.Dampening    `json:"dampening"`
+	CooldownSeconds float64           `json:"cooldownSeconds"`
+	ScheduledAt     *time.Time        `json:"scheduledAt,omitempty"`
+	CreatedAt       time.Time         `json:"createdAt"`
+	UpdatedAt       time.Time         `json:"updatedAt"`
+}
+
+// AutomationExecution defines an execution of the actions of an automation
+// for a device, with the result of each action.
+type AutomationExecution struct {
+	ID        int64            `json:"id,string"`
+	CreatedAt time.Time        `json:"createdAt"`
+	DevEUI    lorawan.EUI64    `json:"devEUI"`
+	Trigger   spec.TriggerType `json:"trigger"`
+	Success   bool             `json:"success"`
+	Results   spec.Results     `json:"results"`
+}
+
+// AutomationAlarm defines an alarm raised by an automation. TriggerValue
+// holds the value which fired the trigger (e.g. the decoded uplink object)
+// and ResolveValue the value which resolved the alarm. EscalationLevel holds
+// the number of executed escalation steps.
+type AutomationAlarm struct {
+	ID                    string          `json:"id"`
+	AutomationID          string          `json:"automationID"`
+	AutomationName        string          `json:"automationName,omitempty"`
+	DevEUI                lorawan.EUI64   `json:"devEUI"`
+	DeviceName            string          `json:"deviceName,omitempty"`
+	CreatedAt             time.Time       `json:"createdAt"`
+	TriggerValue          json.RawMessage `json:"triggerValue"`
+	Severity              spec.Severity   `json:"severity"`
+	EscalationLevel       int             `json:"escalationLevel"`
+	EscalatedAt           *time.Time      `json:"escalatedAt,omitempty"`
+	NextEscalationAt      *time.Time      `json:"nextEscalationAt,omitempty"`
+	ResolvedAt            *time.Time      `json:"resolvedAt,omitempty"`
+	ResolveValue          json.RawMessage `json:"resolveValue,omitempty"`
+	AcknowledgedAt        *time.Time      `json:"acknowledgedAt,omitempty"`
+	AcknowledgedBy        string          `json:"acknowledgedBy,omitempty"`
+	AcknowledgmentComment string          `json:"acknowledgmentComment,omitempty"`
+}
+
+// AutomationAlarmNotification defines a notification sent for an alarm.
+// Recipients holds the e-mail addresses, the phone numbers or the webhook
+// URL (in-app notifications do not have recipients).
+type AutomationAlarmNotification struct {
+	CreatedAt       time.Time       `json:"createdAt"`
+	EscalationLevel int             `json:"escalationLevel"`
+	ActionType      spec.ActionType `json:"actionType"`
+	Recipients      []string        `json:"recipients"`
+	Error           string          `json:"error,omitempty"`
+}
+
+// CreateAutomationRequest defines the create automation request.
+type CreateAutomationRequest struct {
+	Automation Automation `json:"automation"`
+}
+
+// CreateAutomationResponse defines the create automation response.
+type CreateAutomationResponse struct {
+	ID string `json:"id"`
+}
+
+// GetAutomationResponse defines the get automation response.
+type GetAutomationResponse struct {
+	Automation Automation `json:"automation"`
+}
+
+// UpdateAutomationRequest defines the update automation request.
+type UpdateAutomationRequest struct {
+	Automation Automation `json:"automation"`
+}
+
+// ListAutomationResponse defines the list automations response.
+type ListAutomationResponse struct {
+	Result []Automation `json:"result"`
+}
+
+// ListAutomationExecutionsResponse defines the list automation executions
+// response.
+type ListAutomationExecutionsResponse struct {
+	TotalCount int                   `json:"totalCount"`
+	Result     []AutomationExecution `json:"result"`
+}
+
+// ListAutomationAlarmsResponse defines the list automation alarms response.
+type ListAutomationAlarmsResponse struct {
+	TotalCount int               `json:"totalCount"`
+	Result     []AutomationAlarm `json:"result"`
+}
+
+// GetAutomationAlarmResponse defines the get automation alarm response.
+type GetAutomationAlarmResponse struct {
+	Alarm         AutomationAlarm               `json:"alarm"`
+	Notifications []AutomationAlarmNotification `json:"notifications"`
+}
+
+// AcknowledgeAutomationAlarmRequest defines the (optional) acknowledge
+// automation alarm request.
+type AcknowledgeAutomationAlarmRequest struct {
+	Comment string `json:"comment"`
+}
+
+// AutomationAPI exports the automation related functions.
+type AutomationAPI struct {
+	validator auth.Validator
+}
+
+// NewAutomationAPI creates a new AutomationAPI.
+func NewAutomationAPI(validator auth.Validator) *AutomationAPI {
+	return &AutomationAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *AutomationAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/applications/{application_id}/automations", a.Create).Methods("POST")
+	r.HandleFunc("/api/applications/{application_id}/automations", a.List).Methods("GET")
+	r.HandleFunc("/api/automations/{id}", a.Get).Methods("GET")
+	r.HandleFunc("/api/automations/{id}", a.Update).Methods("PUT")
+	r.HandleFunc("/api/automations/{id}", a.Delete).Methods("DELETE")
+	r.HandleFunc("/api/automations/{id}/executions", a.ListExecutions).Methods("GET")
+	r.HandleFunc("/api/automations/{id}/alarms", a.ListAlarms).Methods("GET")
+	r.HandleFunc("/api/automation-alarms/{id}", a.GetAlarm).Methods("GET")
+	r.HandleFunc("/api/automation-alarms/{id}/acknowledge", a.AcknowledgeAlarm).Methods("POST")
+	r.HandleFunc("/api/applications/{application_id}/alarm-history", a.ListAlarmHistory).Methods("GET")
+}
+
+// Create creates the given automation for the application.
+func (a *AutomationAPI) Create(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(applicationID, auth.Update),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req CreateAutomationRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := validateAutomation(ctx, applicationID, req.Automation); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	am := storage.Automation{
+		ApplicationID: applicationID,
+	}
+	automationToStorage(req.Automation, &am)
+
+	if err := storage.CreateAutomation(ctx, storage.DB(), &am); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, CreateAutomationResponse{
+		ID: am.ID.String(),
+	})
+}
+
+// List lists the automations of the application.
+func (a *AutomationAPI) List(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(applicationID, auth.Read),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	automations, err := storage.GetAutomations(ctx, storage.DB(), applicationID)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListAutomationResponse{
+		Result: []Automation{},
+	}
+	for _, am := range automations {
+		resp.Result = append(resp.Result, automationFromStorage(am))
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// Get returns the automation.
+func (a *AutomationAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	am, err := a.getAutomation(ctx, r, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, GetAutomationResponse{
+		Automation: automationFromStorage(am),
+	})
+}
+
+// Update updates the automation.
+func (a *AutomationAPI) Update(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	var req UpdateAutomationRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	am, err := a.getAutomation(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := validateAutomation(ctx, am.ApplicationID, req.Automation); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	automationToStorage(req.Automation, &am)
+
+	if err := storage.UpdateAutomation(ctx, storage.DB(), &am); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// Delete deletes the automation.
+func (a *AutomationAPI) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	am, err := a.getAutomation(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := storage.DeleteAutomation(ctx, storage.DB(), am.ID); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// ListExecutions lists the execution history of the automation, most
+// recent first.
+func (a *AutomationAPI) ListExecutions(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	limit, offset, err := httpLimitOffset(r, automationExecutionListMaxLimit)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	am, err := a.getAutomation(ctx, r, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	count, err := storage.GetAutomationExecutionCount(ctx, storage.DB(), am.ID)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	executions, err := storage.GetAutomationExecutions(ctx, storage.DB(), am.ID, limit, offset)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListAutomationExecutionsResponse{
+		TotalCount: count,
+		Result:     []AutomationExecution{},
+	}
+	for _, e := range executions {
+		results := e.Results
+		if results == nil {
+			results = spec.Results{}
+		}
+
+		resp.Result = append(resp.Result, AutomationExecution{
+			ID:        e.ID,
+			CreatedAt: e.CreatedAt,
+			DevEUI:    e.DevEUI,
+			Trigger:   e.TriggerType,
+			Success:   e.Success,
+			Results:   results,
+		})
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// ListAlarms lists the alarms of the automation, most recent first. When
+// the openOnly query parameter is set to true, only the open (unacknowledged
+// and unresolved) alarms are returned.
+func (a *AutomationAPI) ListAlarms(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	limit, offset, err := httpLimitOffset(r, automationAlarmListMaxLimit)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var openOnly bool
+	if v := r.URL.Query().Get("openOnly"); v != "" {
+		openOnly, err = strconv.ParseBool(v)
+		if err != nil {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "openOnly: %s", err))
+			return
+		}
+	}
+
+	am, err := a.getAutomation(ctx, r, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	filters := storage.AutomationAlarmFilters{
+		AutomationID: am.ID,
+		OpenOnly:     openOnly,
+		Limit:        limit,
+		Offset:       offset,
+	}
+
+	count, err := storage.GetAutomationAlarmCount(ctx, storage.DB(), filters)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	alarms, err := storage.GetAutomationAlarms(ctx, storage.DB(), filters)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListAutomationAlarmsResponse{
+		TotalCount: count,
+		Result:     []AutomationAlarm{},
+	}
+	for _, alarm := range alarms {
+		resp.Result = append(resp.Result, automationAlarmListItemFromStorage(alarm))
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// ListAlarmHistory lists the alarms of all automations of the application,
+// most recent first. The history can be filtered using the automationID,
+// devEUI, state (ACTIVE, RESOLVED, UNACKNOWLEDGED or ACKNOWLEDGED),
+// severity (INFO, WARNING or CRITICAL), start and end (RFC3339 timestamps of
+// the time the alarm was raised) query parameters.
+func (a *AutomationAPI) ListAlarmHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	limit, offset, err := httpLimitOffset(r, automationAlarmListMaxLimit)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(applicationID, auth.Read),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	filters, err := alarmHistoryFilters(r)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+	filters.ApplicationID = applicationID
+	filters.Limit = limit
+	filters.Offset = offset
+
+	count, err := storage.GetAutomationAlarmCount(ctx, storage.DB(), filters)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	alarms, err := storage.GetAutomationAlarms(ctx, storage.DB(), filters)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListAutomationAlarmsResponse{
+		TotalCount: count,
+		Result:     []AutomationAlarm{},
+	}
+	for _, alarm := range alarms {
+		resp.Result = append(resp.Result, automationAlarmListItemFromStorage(alarm))
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// GetAlarm returns the automation alarm and the notifications sent for the
+// alarm.
+func (a *AutomationAPI) GetAlarm(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	alarm, err := a.getAlarm(ctx, r, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	notifications, err := storage.GetAutomationAlarmNotifications(ctx, storage.DB(), alarm.ID)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := GetAutomationAlarmResponse{
+		Alarm:         automationAlarmFromStorage(alarm),
+		Notifications: []AutomationAlarmNotification{},
+	}
+	for _, n := range notifications {
+		resp.Notifications = append(resp.Notifications, AutomationAlarmNotification{
+			CreatedAt:       n.CreatedAt,
+			EscalationLevel: n.EscalationLevel,
+			ActionType:      n.ActionType,
+			Recipients:      n.Recipients,
+			Error:           n.Error,
+		})
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// AcknowledgeAlarm acknowledges the automation alarm, which stops its
+// escalation. The request body, containing the acknowledgment comment, is
+// optional.
+func (a *AutomationAPI) AcknowledgeAlarm(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	var req AcknowledgeAutomationAlarmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "decode json error: %s", err))
+		return
+	}
+
+	alarm, err := a.getAlarm(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	acknowledgedBy, err := getInvokedBy(ctx, a.validator)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := storage.AcknowledgeAutomationAlarm(ctx, storage.DB(), alarm.ID, acknowledgedBy, req.Comment); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// getAutomation returns the automation of the id route variable and
+// validates that the client has the requested access to its application.
+func (a *AutomationAPI) getAutomation(ctx context.Context, r *http.Request, flag auth.Flag) (storage.Automation, error) {
+	id, err := httpUUIDVar(r, "id")
+	if err != nil {
+		return storage.Automation{}, err
+	}
+
+	am, err := storage.GetAutomation(ctx, storage.DB(), id)
+	if err != nil {
+		return am, err
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(am.ApplicationID, flag),
+	); err != nil {
+		return am, err
+	}
+
+	return am, nil
+}
+
+// getAlarm returns the automation alarm of the id route variable and
+// validates that the client has the requested access to the application of
+// its automation.
+func (a *AutomationAPI) getAlarm(ctx context.Context, r *http.Request, flag auth.Flag) (storage.AutomationAlarm, error) {
+	id, err := httpUUIDVar(r, "id")
+	if err != nil {
+		return storage.AutomationAlarm{}, err
+	}
+
+	alarm, err := storage.GetAutomationAlarm(ctx, storage.DB(), id)
+	if err != nil {
+		return alarm, err
+	}
+
+	am, err := storage.GetAutomation(ctx, storage.DB(), alarm.AutomationID)
+	if err != nil {
+		return alarm, err
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(am.ApplicationID, flag),
+	); err != nil {
+		return alarm, err
+	}
+
+	return alarm, nil
+}
+
+// alarmHistoryFilters returns the alarm history filters of the query
+// parameters.
+func alarmHistoryFilters(r *http.Request) (storage.AutomationAlarmFilters, error) {
+	var filters storage.AutomationAlarmFilters
+	var err error
+
+	q := r.URL.Query()
+
+	if v := q.Get("automationID"); v != "" {
+		filters.AutomationID, err = uuid.FromString(v)
+		if err != nil {
+			return filters, grpc.Errorf(codes.InvalidArgument, "automationID: %s", err)
+		}
+	}
+
+	if v := q.Get("devEUI"); v != "" {
+		if err := filters.DevEUI.UnmarshalText([]byte(v)); err != nil {
+			return filters, grpc.Errorf(codes.InvalidArgument, "devEUI: %s", err)
+		}
+	}
+
+	if v := q.Get("state"); v != "" {
+		filters.State = storage.AutomationAlarmState(v)
+		switch filters.State {
+		case storage.AutomationAlarmActive, storage.AutomationAlarmResolved, storage.AutomationAlarmUnacknowledged, storage.AutomationAlarmAcknowledged:
+		default:
+			return filters, grpc.Errorf(codes.InvalidArgument, "invalid state: %s", v)
+		}
+	}
+
+	if v := q.Get("severity"); v != "" {
+		filters.Severity = spec.Severity(v)
+		if err := filters.Severity.Validate(); err != nil {
+			return filters, grpc.Errorf(codes.InvalidArgument, "severity: %s", err)
+		}
+	}
+
+	if v := q.Get("start"); v != "" {
+		filters.Start, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filters, grpc.Errorf(codes.InvalidArgument, "start: %s", err)
+		}
+	}
+
+	if v := q.Get("end"); v != "" {
+		filters.End, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filters, grpc.Errorf(codes.InvalidArgument, "end: %s", err)
+		}
+	}
+
+	return filters, nil
+}
+
+// validateAutomation validates the trigger, actions, severity and escalation
+// chain, so that the client receives the reason why these are invalid. The threshold
+// profile of a THRESHOLD trigger must belong to the organization of the
+// application.
+func validateAutomation(ctx context.Context, applicationID int64, in Automation) error {
+	if err := in.Trigger.Validate(); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "trigger: %s", err)
+	}
+
+	if in.Trigger.Type == spec.ThresholdTrigger {
+		p, err := storage.GetThresholdProfile(ctx, storage.DB(), *in.Trigger.ThresholdProfileID)
+		if err != nil {
+			if errors.Cause(err) == storage.ErrDoesNotExist {
+				return grpc.Errorf(codes.InvalidArgument, "trigger: threshold profile does not exist")
+			}
+			return err
+		}
+
+		app, err := storage.GetApplication(ctx, storage.DB(), applicationID)
+		if err != nil {
+			return err
+		}
+
+		if p.OrganizationID != app.OrganizationID {
+			return grpc.Errorf(codes.InvalidArgument, "trigger: threshold profile does not belong to the organization")
+		}
+	}
+
+	if err := in.Actions.Validate(); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "actions: %s", err)
+	}
+
+	if in.Severity != "" {
+		if err := in.Severity.Validate(); err != nil {
+			return grpc.Errorf(codes.InvalidArgument, "severity: %s", err)
+		}
+	}
+
+	if err := in.Escalation.Validate(); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "escalation: %s", err)
+	}
+
+	if err := in.Dampening.Validate(in.Trigger.Type); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "dampening: %s", err)
+	}
+
+	return nil
+}
+
+func automationToStorage(in Automation, out *storage.Automation) {
+	out.Name = in.Name
+	out.Enabled = in.Enabled
+	out.DeviceTags = hstore.Hstore{
+		Map: make(map[string]sql.NullString),
+	}
+	for k, v := range in.DeviceTags {
+		out.DeviceTags.Map[k] = sql.NullString{String: v, Valid: true}
+	}
+	out.Trigger = in.Trigger
+	out.Actions = in.Actions
+	out.Alarm = in.Alarm
+	out.Severity = in.Severity
+	out.Escalation = in.Escalation
+	out.Dampening = in.Dampening
+	out.Cooldown = time.Duration(in.CooldownSeconds * float64(time.Second))
+}
+
+func automationFromStorage(am storage.Automation) Automation {
+	actions := am.Actions
+	if actions == nil {
+		actions = spec.Actions{}
+	}
+
+	escalation := am.Escalation
+	if escalation == nil {
+		escalation = spec.Escalation{}
+	}
+
+	out := Automation{
+		ID:              am.ID.String(),
+		ApplicationID:   am.ApplicationID,
+		Name:            am.Name,
+		Enabled:         am.Enabled,
+		DeviceTags:      make(map[string]string),
+		Trigger:         am.Trigger,
+		Actions:         actions,
+		Alarm:           am.Alarm,
+		Severity:        am.Severity,
+		Escalation:      escalation,
+		Dampening:       am.Dampening,
+		CooldownSeconds: am.Cooldown.Seconds(),
+		ScheduledAt:     am.ScheduledAt,
+		CreatedAt:       am.CreatedAt,
+		UpdatedAt:       am.UpdatedAt,
+	}
+
+	for k, v := range am.DeviceTags.Map {
+		if v.Valid {
+			out.DeviceTags[k] = v.String
+		}
+	}
+
+	return out
+}
+
+func automationAlarmFromStorage(alarm storage.AutomationAlarm) AutomationAlarm {
+	out := AutomationAlarm{
+		ID:                    alarm.ID.String(),
+		AutomationID:          alarm.AutomationID.String(),
+		DevEUI:                alarm.DevEUI,
+		CreatedAt:             alarm.CreatedAt,
+		TriggerValue:          alarm.TriggerValue,
+		Severity:              alarm.Severity,
+		EscalationLevel:       alarm.EscalationLevel,
+		EscalatedAt:           alarm.EscalatedAt,
+		NextEscalationAt:      alarm.NextEscalationAt,
+		ResolvedAt:            alarm.ResolvedAt,
+		AcknowledgedAt:        alarm.AcknowledgedAt,
+		AcknowledgedBy:        alarm.AcknowledgedBy,
+		AcknowledgmentComment: alarm.AcknowledgmentComment,
+	}
+
+	// the resolve value is an empty object until the alarm is resolved
+	if alarm.ResolvedAt != nil {
+		out.ResolveValue = alarm.ResolveValue
+	}
+
+	return out
+}
+
+func automationAlarmListItemFromStorage(item storage.AutomationAlarmListItem) AutomationAlarm {
+	out := automationAlarmFromStorage(item.AutomationAlarm)
+	out.AutomationName = item.AutomationName
+	out.DeviceName = item.DeviceName
+
+	return out
+}
@@ -0,0 +1,309 @@
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/downlink/rule"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *APITestSuite) TestAutomation() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewAutomationAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := storage.Application{
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+		Name:             "test-app",
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	dp := storage.DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(storage.CreateDeviceProfile(context.Background(), storage.DB(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	am := Automation{
+		Name:    "dry-soil",
+		Enabled: true,
+		DeviceTags: map[string]string{
+			"site": "greenhouse",
+		},
+		Trigger: spec.Trigger{
+			Type: spec.UplinkTrigger,
+			Conditions: rule.Conditions{
+				{Path: "moisture", Operator: rule.LessThan, Value: json.RawMessage(`20`)},
+			},
+		},
+		Actions: spec.Actions{
+			{Type: spec.DownlinkAction, FPort: 10, Object: `{"valve": "open"}`},
+			{Type: spec.TagAction, Key: "state", Value: "irrigating"},
+		},
+		Escalation: spec.Escalation{
+			{DelaySeconds: 900, Action: spec.Action{Type: spec.WebhookAction, URL: "https://example.com/pager"}},
+		},
+		CooldownSeconds: 3600,
+	}
+
+	var id string
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/automations", app.ID), CreateAutomationRequest{
+			Automation: am,
+		})
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp CreateAutomationResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		id = resp.ID
+
+		t.Run("Get", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "GET", "/api/automations/"+id, nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			var resp GetAutomationResponse
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal("dry-soil", resp.Automation.Name)
+			assert.Equal(app.ID, resp.Automation.ApplicationID)
+			assert.Equal(am.DeviceTags, resp.Automation.DeviceTags)
+			assert.Equal(am.Trigger, resp.Automation.Trigger)
+			assert.Equal(am.Actions, resp.Automation.Actions)
+			assert.Equal(float64(3600), resp.Automation.CooldownSeconds)
+		})
+
+		t.Run("List", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "GET", fmt.Sprintf("/api/applications/%d/automations", app.ID), nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			var resp ListAutomationResponse
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Len(resp.Result, 1)
+		})
+
+		t.Run("Update", func(t *testing.T) {
+			assert := require.New(t)
+
+			am.Enabled = false
+			rec := httpTestRequest(r, "PUT", "/api/automations/"+id, UpdateAutomationRequest{
+				Automation: am,
+			})
+			assert.Equal(http.StatusOK, rec.Code)
+
+			aGet, err := storage.GetAutomation(context.Background(), storage.DB(), uuid.FromStringOrNil(id))
+			assert.NoError(err)
+			assert.False(aGet.Enabled)
+		})
+
+		t.Run("List executions", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(storage.CreateAutomationExecution(context.Background(), storage.DB(), &storage.AutomationExecution{
+				AutomationID: uuid.FromStringOrNil(id),
+				CreatedAt:    time.Now(),
+				DevEUI:       lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+				TriggerType:  spec.UplinkTrigger,
+				Success:      false,
+				Results: spec.Results{
+					{Type: spec.DownlinkAction, Error: "rate limit exceeded"},
+					{Type: spec.TagAction},
+				},
+			}))
+
+			rec := httpTestRequest(r, "GET", "/api/automations/"+id+"/executions?limit=10", nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			var resp ListAutomationExecutionsResponse
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal(1, resp.TotalCount)
+			assert.Len(resp.Result, 1)
+			assert.Equal(lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}, resp.Result[0].DevEUI)
+			assert.False(resp.Result[0].Success)
+			assert.Len(resp.Result[0].Results, 2)
+
+			rec = httpTestRequest(r, "GET", "/api/automations/"+id+"/executions?limit=0", nil)
+			assert.Equal(http.StatusBadRequest, rec.Code)
+		})
+		t.Run("Alarms", func(t *testing.T) {
+			assert := require.New(t)
+
+			d := storage.Device{
+				DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+				ApplicationID:   app.ID,
+				DeviceProfileID: dpID,
+				Name:            "pump-1",
+			}
+			assert.NoError(storage.CreateDevice(context.Background(), storage.DB(), &d))
+
+			alarm := storage.AutomationAlarm{
+				AutomationID: uuid.FromStringOrNil(id),
+				DevEUI:       d.DevEUI,
+			}
+			assert.NoError(storage.CreateAutomationAlarm(context.Background(), storage.DB(), &alarm))
+
+			rec := httpTestRequest(r, "GET", "/api/automations/"+id+"/alarms?openOnly=true", nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			var resp ListAutomationAlarmsResponse
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal(1, resp.TotalCount)
+			assert.Len(resp.Result, 1)
+			assert.Equal(alarm.ID.String(), resp.Result[0].ID)
+			assert.Nil(resp.Result[0].AcknowledgedAt)
+
+			validator.returnSubject = "user"
+			validator.returnUser = storage.User{Email: "operator@example.com"}
+
+			rec = httpTestRequest(r, "POST", "/api/automation-alarms/"+alarm.ID.String()+"/acknowledge", AcknowledgeAutomationAlarmRequest{
+				Comment: "pump restarted",
+			})
+			assert.Equal(http.StatusOK, rec.Code)
+
+			// already acknowledged
+			rec = httpTestRequest(r, "POST", "/api/automation-alarms/"+alarm.ID.String()+"/acknowledge", nil)
+			assert.Equal(http.StatusBadRequest, rec.Code)
+
+			rec = httpTestRequest(r, "GET", "/api/automation-alarms/"+alarm.ID.String(), nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			var getResp GetAutomationAlarmResponse
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&getResp))
+			assert.NotNil(getResp.Alarm.AcknowledgedAt)
+			assert.Equal("operator@example.com", getResp.Alarm.AcknowledgedBy)
+			assert.Equal("pump restarted", getResp.Alarm.AcknowledgmentComment)
+			assert.Len(getResp.Notifications, 0)
+
+			rec = httpTestRequest(r, "GET", "/api/automations/"+id+"/alarms?openOnly=true", nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			resp = ListAutomationAlarmsResponse{}
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal(0, resp.TotalCount)
+
+			rec = httpTestRequest(r, "GET", "/api/automations/"+id+"/alarms?openOnly=maybe", nil)
+			assert.Equal(http.StatusBadRequest, rec.Code)
+		})
+
+		t.Run("Alarm history", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "GET", fmt.Sprintf("/api/applications/%d/alarm-history?state=ACKNOWLEDGED&devEUI=0102030405060708&limit=10", app.ID), nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			var resp ListAutomationAlarmsResponse
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal(1, resp.TotalCount)
+			assert.Len(resp.Result, 1)
+			assert.Equal("dry-soil", resp.Result[0].AutomationName)
+			assert.Equal("pump-1", resp.Result[0].DeviceName)
+
+			rec = httpTestRequest(r, "GET", fmt.Sprintf("/api/applications/%d/alarm-history?state=RESOLVED&limit=10", app.ID), nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			resp = ListAutomationAlarmsResponse{}
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal(0, resp.TotalCount)
+
+			rec = httpTestRequest(r, "GET", fmt.Sprintf("/api/applications/%d/alarm-history?start=%s&limit=10", app.ID, time.Now().Add(time.Hour).UTC().Format(time.RFC3339)), nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			resp = ListAutomationAlarmsResponse{}
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal(0, resp.TotalCount)
+
+			rec = httpTestRequest(r, "GET", fmt.Sprintf("/api/applications/%d/alarm-history?state=SNOOZED&limit=10", app.ID), nil)
+			assert.Equal(http.StatusBadRequest, rec.Code)
+
+			rec = httpTestRequest(r, "GET", fmt.Sprintf("/api/applications/%d/alarm-history?start=yesterday&limit=10", app.ID), nil)
+			assert.Equal(http.StatusBadRequest, rec.Code)
+		})
+	})
+
+	ts.T().Run("Create invalid", func(t *testing.T) {
+		assert := require.New(t)
+
+		invalid := am
+		invalid.Name = "invalid"
+		invalid.Trigger = spec.Trigger{
+			Type:            spec.ScheduleTrigger,
+			IntervalSeconds: 10,
+		}
+
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/automations", app.ID), CreateAutomationRequest{
+			Automation: invalid,
+		})
+		assert.Equal(http.StatusBadRequest, rec.Code)
+
+		var resp httpErrorBody
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal("trigger: interval must be at least 1m0s", resp.Message)
+
+		invalid.Trigger = am.Trigger
+		invalid.Actions = spec.Actions{
+			{Type: spec.WebhookAction, URL: "ftp://example.com"},
+		}
+
+		rec = httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/automations", app.ID), CreateAutomationRequest{
+			Automation: invalid,
+		})
+		assert.Equal(http.StatusBadRequest, rec.Code)
+	})
+
+	ts.T().Run("Delete", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "DELETE", "/api/automations/"+id, nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		rec = httpTestRequest(r, "GET", "/api/automations/"+id, nil)
+		assert.Equal(http.StatusNotFound, rec.Code)
+	})
+}
@@ -0,0 +1,494 @@
+package external
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/codec"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// codecListMaxLimit defines the max. number of codecs returned by a single
+// list request.
+const codecListMaxLimit = 100
+
+// Codec defines a codec of the codec library.
+type Codec struct {
+	ID             string     `json:"id"`
+	OrganizationID int64      `json:"organizationID,string"`
+	Name           string     `json:"name"`
+	Description    string     `json:"description"`
+	PayloadCodec   codec.Type `json:"payloadCodec"`
+	CurrentVersion int        `json:"currentVersion"`
+	LatestVersion  int        `json:"latestVersion"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+}
+
+// CodecVersion defines a published version of a codec.
+type CodecVersion struct {
+	Version              int       `json:"version"`
+	Description          string    `json:"description"`
+	PayloadEncoderScript string    `json:"payloadEncoderScript"`
+	PayloadDecoderScript string    `json:"payloadDecoderScript"`
+	CreatedAt            time.Time `json:"createdAt"`
+}
+
+// CreateCodecRequest defines the create codec request.
+type CreateCodecRequest struct {
+	Codec Codec `json:"codec"`
+}
+
+// CreateCodecResponse defines the create codec response.
+type CreateCodecResponse struct {
+	ID string `json:"id"`
+}
+
+// GetCodecResponse defines the get codec response. Version is the current
+// version and is omitted when no version has been published yet.
+type GetCodecResponse struct {
+	Codec   Codec         `json:"codec"`
+	Version *CodecVersion `json:"version,omitempty"`
+}
+
+// UpdateCodecRequest defines the update codec request.
+type UpdateCodecRequest struct {
+	Codec Codec `json:"codec"`
+}
+
+// ListCodecResponse defines the list codecs response.
+type ListCodecResponse struct {
+	TotalCount int     `json:"totalCount,string"`
+	Result     []Codec `json:"result"`
+}
+
+// ListCodecVersionsResponse defines the list codec versions response.
+type ListCodecVersionsResponse struct {
+	Result []CodecVersion `json:"result"`
+}
+
+// PublishCodecVersionRequest defines the publish codec version request.
+type PublishCodecVersionRequest struct {
+	Description          string `json:"description"`
+	PayloadEncoderScript string `json:"payloadEncoderScript"`
+	PayloadDecoderScript string `json:"payloadDecoderScript"`
+}
+
+// PublishCodecVersionResponse defines the publish codec version response.
+type PublishCodecVersionResponse struct {
+	Version int `json:"version"`
+}
+
+// RollbackCodecRequest defines the rollback codec request.
+type RollbackCodecRequest struct {
+	Version int `json:"version"`
+}
+
+// UpdateDeviceProfileCodecLibraryRequest defines the request to set the
+// library codec of a device-profile. An empty codecID removes the
+// reference.
+type UpdateDeviceProfileCodecLibraryRequest struct {
+	CodecID string `json:"codecID"`
+}
+
+// CodecLibraryAPI exports the codec library related functions.
+type CodecLibraryAPI struct {
+	validator auth.Validator
+}
+
+// NewCodecLibraryAPI creates a new CodecLibraryAPI.
+func NewCodecLibraryAPI(validator auth.Validator) *CodecLibraryAPI {
+	return &CodecLibraryAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *CodecLibraryAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/codecs", a.Create).Methods("POST")
+	r.HandleFunc("/api/codecs", a.List).Methods("GET")
+	r.HandleFunc("/api/codecs/{id}", a.Get).Methods("GET")
+	r.HandleFunc("/api/codecs/{id}", a.Update).Methods("PUT")
+	r.HandleFunc("/api/codecs/{id}", a.Delete).Methods("DELETE")
+	r.HandleFunc("/api/codecs/{id}/versions", a.ListVersions).Methods("GET")
+	r.HandleFunc("/api/codecs/{id}/versions", a.PublishVersion).Methods("POST")
+	r.HandleFunc("/api/codecs/{id}/rollback", a.Rollback).Methods("POST")
+	r.HandleFunc("/api/device-profiles/{id}/codec-library", a.UpdateDeviceProfile).Methods("PUT")
+}
+
+// Create creates the given codec.
+func (a *CodecLibraryAPI) Create(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	var req CreateCodecRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateDeviceProfilesAccess(auth.Create, req.Codec.OrganizationID, 0),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	c := storage.Codec{
+		OrganizationID: req.Codec.OrganizationID,
+		Name:           req.Codec.Name,
+		Description:    req.Codec.Description,
+		PayloadCodec:   req.Codec.PayloadCodec,
+	}
+	if err := storage.CreateCodec(ctx, storage.DB(), &c); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, CreateCodecResponse{
+		ID: c.ID.String(),
+	})
+}
+
+// List lists the codecs of the given organization.
+func (a *CodecLibraryAPI) List(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+	q := r.URL.Query()
+
+	organizationID, err := strconv.ParseInt(q.Get("organizationID"), 10, 64)
+	if err != nil || organizationID == 0 {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "organizationID must be given"))
+		return
+	}
+
+	limit := codecListMaxLimit
+	if v := q.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > codecListMaxLimit {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", codecListMaxLimit))
+			return
+		}
+		limit = l
+	}
+
+	var offset int
+	if v := q.Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "offset must be greater than or equal to 0"))
+			return
+		}
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateDeviceProfilesAccess(auth.List, organizationID, 0),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	count, err := storage.GetCodecCount(ctx, storage.DB(), organizationID)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	codecs, err := storage.GetCodecs(ctx, storage.DB(), organizationID, limit, offset)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListCodecResponse{
+		TotalCount: count,
+		Result:     []Codec{},
+	}
+	for _, c := range codecs {
+		resp.Result = append(resp.Result, codecFromStorage(c))
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// Get returns the codec and its current version.
+func (a *CodecLibraryAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	c, err := a.getCodec(ctx, r, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := GetCodecResponse{
+		Codec: codecFromStorage(c),
+	}
+
+	if c.CurrentVersion != 0 {
+		v, err := storage.GetCodecVersion(ctx, storage.DB(), c.ID, c.CurrentVersion)
+		if err != nil {
+			httpWriteError(w, err)
+			return
+		}
+		cv := codecVersionFromStorage(v)
+		resp.Version = &cv
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// Update updates the name, description and payload codec of the codec.
+func (a *CodecLibraryAPI) Update(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	var req UpdateCodecRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	c, err := a.getCodec(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	c.Name = req.Codec.Name
+	c.Description = req.Codec.Description
+	c.PayloadCodec = req.Codec.PayloadCodec
+
+	if err := storage.UpdateCodec(ctx, storage.DB(), &c); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// Delete deletes the codec. This fails when the codec is still used by
+// device-profiles.
+func (a *CodecLibraryAPI) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	c, err := a.getCodec(ctx, r, auth.Delete)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := storage.DeleteCodec(ctx, storage.DB(), c.ID); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// ListVersions lists the published versions of the codec, newest first.
+func (a *CodecLibraryAPI) ListVersions(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	c, err := a.getCodec(ctx, r, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	versions, err := storage.GetCodecVersions(ctx, storage.DB(), c.ID)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListCodecVersionsResponse{
+		Result: []CodecVersion{},
+	}
+	for _, v := range versions {
+		resp.Result = append(resp.Result, codecVersionFromStorage(v))
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// PublishVersion publishes a new version of the codec. The new version
+// becomes the current version of the codec and is used directly by all
+// device-profiles referencing the codec.
+func (a *CodecLibraryAPI) PublishVersion(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	var req PublishCodecVersionRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	c, err := a.getCodec(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := codec.ValidateDecoderScript(c.PayloadCodec, req.PayloadDecoderScript); err != nil {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "payloadDecoderScript: %s", err))
+		return
+	}
+
+	v := storage.CodecVersion{
+		CodecID:       c.ID,
+		Description:   req.Description,
+		EncoderScript: req.PayloadEncoderScript,
+		DecoderScript: req.PayloadDecoderScript,
+	}
+	if err := storage.PublishCodecVersion(ctx, storage.DB(), &v); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, PublishCodecVersionResponse{
+		Version: v.Version,
+	})
+}
+
+// Rollback sets the current version of the codec to a previously published
+// version.
+func (a *CodecLibraryAPI) Rollback(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	var req RollbackCodecRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	c, err := a.getCodec(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := storage.RollbackCodec(ctx, storage.DB(), c.ID, req.Version); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// UpdateDeviceProfile sets the library codec used by the given
+// device-profile. The codec must belong to the organization of the
+// device-profile.
+func (a *CodecLibraryAPI) UpdateDeviceProfile(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	id, err := httpUUIDVar(r, "id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req UpdateDeviceProfileCodecLibraryRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateDeviceProfileAccess(auth.Update, id),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var codecID *uuid.UUID
+	if req.CodecID != "" {
+		cID, err := uuid.FromString(req.CodecID)
+		if err != nil {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "codecID: %s", err))
+			return
+		}
+
+		dp, err := storage.GetDeviceProfile(ctx, storage.DB(), id, false, true)
+		if err != nil {
+			httpWriteError(w, err)
+			return
+		}
+
+		c, err := storage.GetCodec(ctx, storage.DB(), cID)
+		if err != nil {
+			httpWriteError(w, err)
+			return
+		}
+
+		if c.OrganizationID != dp.OrganizationID {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "codec and device-profile must be under the same organization"))
+			return
+		}
+
+		codecID = &cID
+	}
+
+	if err := storage.UpdateDeviceProfileCodecID(ctx, storage.DB(), id, codecID); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// getCodec returns the codec of the id route variable and validates that
+// the client has the requested access to it. Reading requires access to the
+// organization, modifying requires the same access as for modifying
+// device-profiles.
+func (a *CodecLibraryAPI) getCodec(ctx context.Context, r *http.Request, flag auth.Flag) (storage.Codec, error) {
+	id, err := httpUUIDVar(r, "id")
+	if err != nil {
+		return storage.Codec{}, err
+	}
+
+	c, err := storage.GetCodec(ctx, storage.DB(), id)
+	if err != nil {
+		return c, err
+	}
+
+	validator := auth.ValidateOrganizationAccess(auth.Read, c.OrganizationID)
+	if flag != auth.Read {
+		validator = auth.ValidateDeviceProfilesAccess(auth.Create, c.OrganizationID, 0)
+	}
+
+	if err := a.validator.Validate(ctx, validator); err != nil {
+		return c, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	return c, nil
+}
+
+func codecFromStorage(c storage.Codec) Codec {
+	return Codec{
+		ID:             c.ID.String(),
+		OrganizationID: c.OrganizationID,
+		Name:           c.Name,
+		Description:    c.Description,
+		PayloadCodec:   c.PayloadCodec,
+		CurrentVersion: c.CurrentVersion,
+		LatestVersion:  c.LatestVersion,
+		CreatedAt:      c.CreatedAt,
+		UpdatedAt:      c.UpdatedAt,
+	}
+}
+
+func codecVersionFromStorage(v storage.CodecVersion) CodecVersion {
+	return CodecVersion{
+		Version:              v.Version,
+		Description:          v.Description,
+		PayloadEncoderScript: v.EncoderScript,
+		PayloadDecoderScript: v.DecoderScript,
+		CreatedAt:            v.CreatedAt,
+	}
+}
@@ -0,0 +1,153 @@
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/codec"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+func (ts *APITestSuite) TestCodecLibrary() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewCodecLibraryAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	dp := storage.DeviceProfile{
+		Name:            "test-dp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateDeviceProfile(context.Background(), storage.DB(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	do := func(t *testing.T, method, path string, body, resp interface{}) int {
+		assert := require.New(t)
+
+		var b []byte
+		if body != nil {
+			var err error
+			b, err = json.Marshal(body)
+			assert.NoError(err)
+		}
+
+		rec := httpTestRequest(r, method, path, b)
+
+		if resp != nil && rec.Code == http.StatusOK {
+			assert.NoError(json.NewDecoder(rec.Body).Decode(resp))
+		}
+		return rec.Code
+	}
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		var createResp CreateCodecResponse
+		assert.Equal(http.StatusOK, do(t, "POST", "/api/codecs", CreateCodecRequest{
+			Codec: Codec{
+				OrganizationID: org.ID,
+				Name:           "test-codec",
+				PayloadCodec:   codec.CustomJSType,
+			},
+		}, &createResp))
+		path := fmt.Sprintf("/api/codecs/%s", createResp.ID)
+
+		t.Run("List", func(t *testing.T) {
+			assert := require.New(t)
+
+			var resp ListCodecResponse
+			assert.Equal(http.StatusOK, do(t, "GET", fmt.Sprintf("/api/codecs?organizationID=%d", org.ID), nil, &resp))
+			assert.Equal(1, resp.TotalCount)
+			assert.Len(resp.Result, 1)
+			assert.Equal("test-codec", resp.Result[0].Name)
+
+			assert.Equal(http.StatusBadRequest, do(t, "GET", "/api/codecs", nil, nil))
+		})
+
+		t.Run("Publish", func(t *testing.T) {
+			assert := require.New(t)
+
+			for i, script := range []string{"decoder-v1", "decoder-v2"} {
+				var resp PublishCodecVersionResponse
+				assert.Equal(http.StatusOK, do(t, "POST", path+"/versions", PublishCodecVersionRequest{
+					PayloadDecoderScript: script,
+				}, &resp))
+				assert.Equal(i+1, resp.Version)
+			}
+
+			var resp GetCodecResponse
+			assert.Equal(http.StatusOK, do(t, "GET", path, nil, &resp))
+			assert.Equal(2, resp.Codec.CurrentVersion)
+			assert.NotNil(resp.Version)
+			assert.Equal("decoder-v2", resp.Version.PayloadDecoderScript)
+
+			var versionsResp ListCodecVersionsResponse
+			assert.Equal(http.StatusOK, do(t, "GET", path+"/versions", nil, &versionsResp))
+			assert.Len(versionsResp.Result, 2)
+		})
+
+		t.Run("Rollback", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.Equal(http.StatusOK, do(t, "POST", path+"/rollback", RollbackCodecRequest{Version: 1}, nil))
+			assert.Equal(http.StatusNotFound, do(t, "POST", path+"/rollback", RollbackCodecRequest{Version: 5}, nil))
+
+			var resp GetCodecResponse
+			assert.Equal(http.StatusOK, do(t, "GET", path, nil, &resp))
+			assert.Equal(1, resp.Codec.CurrentVersion)
+			assert.Equal("decoder-v1", resp.Version.PayloadDecoderScript)
+		})
+
+		t.Run("Device-profile", func(t *testing.T) {
+			assert := require.New(t)
+
+			dpPath := fmt.Sprintf("/api/device-profiles/%s/codec-library", dpID)
+			assert.Equal(http.StatusOK, do(t, "PUT", dpPath, UpdateDeviceProfileCodecLibraryRequest{CodecID: createResp.ID}, nil))
+
+			dp, err := storage.GetDeviceProfile(context.Background(), storage.DB(), dpID, false, true)
+			assert.NoError(err)
+			assert.NotNil(dp.CodecID)
+			assert.Equal(createResp.ID, dp.CodecID.String())
+
+			assert.Equal(http.StatusBadRequest, do(t, "DELETE", path, nil, nil))
+
+			assert.Equal(http.StatusOK, do(t, "PUT", dpPath, UpdateDeviceProfileCodecLibraryRequest{}, nil))
+			dp, err = storage.GetDeviceProfile(context.Background(), storage.DB(), dpID, false, true)
+			assert.NoError(err)
+			assert.Nil(dp.CodecID)
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.Equal(http.StatusOK, do(t, "DELETE", path, nil, nil))
+			assert.Equal(http.StatusNotFound, do(t, "GET", path, nil, nil))
+		})
+	})
+}
@@ -0,0 +1,291 @@
+package external
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+const (
+	// contactEventListMaxLimit defines the max. number of contact events
+	// returned by a single list request.
+	contactEventListMaxLimit = 1000
+
+	// contactStatsDefaultDays defines the number of days of the daily
+	// contact statistics when no start is given.
+	contactStatsDefaultDays = 7
+
+	// contactStatsMaxRange defines the max. time range of the daily contact
+	// statistics.
+	contactStatsMaxRange = 93 * 24 * time.Hour
+)
+
+// ContactState defines the current state of a contact sensor of a device.
+type ContactState struct {
+	Name      string    `json:"name"`
+	Open      bool      `json:"open"`
+	ChangedAt time.Time `json:"changedAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// ContactEvent defines the opening or closing of a contact sensor. For a
+// close event, OpenSeconds holds the duration the contact has been open.
+type ContactEvent struct {
+	ID          int64         `json:"id,string"`
+	DevEUI      lorawan.EUI64 `json:"devEUI"`
+	DeviceName  string        `json:"deviceName"`
+	Name        string        `json:"name"`
+	Time        time.Time     `json:"time"`
+	Open        bool          `json:"open"`
+	OpenSeconds *float64      `json:"openSeconds,omitempty"`
+}
+
+// ContactDailyStats defines the statistics of a contact sensor for a single
+// day.
+type ContactDailyStats struct {
+	Name               string    `json:"name"`
+	Date               time.Time `json:"date"`
+	OpenCount          int       `json:"openCount"`
+	OpenSeconds        float64   `json:"openSeconds"`
+	LongestOpenSeconds float64   `json:"longestOpenSeconds"`
+}
+
+// ListContactStatesResponse defines the list contact states response.
+type ListContactStatesResponse struct {
+	Result []ContactState `json:"result"`
+}
+
+// ListContactEventsResponse defines the list contact events response.
+type ListContactEventsResponse struct {
+	TotalCount int            `json:"totalCount"`
+	Result     []ContactEvent `json:"result"`
+}
+
+// GetContactStatsResponse defines the get contact statistics response.
+type GetContactStatsResponse struct {
+	Result []ContactDailyStats `json:"result"`
+}
+
+// ContactAPI exports the contact sensor related functions.
+type ContactAPI struct {
+	validator auth.Validator
+}
+
+// NewContactAPI creates a new ContactAPI.
+func NewContactAPI(validator auth.Validator) *ContactAPI {
+	return &ContactAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *ContactAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/devices/{dev_eui}/contacts", a.ListStates).Methods("GET")
+	r.HandleFunc("/api/devices/{dev_eui}/contact-events", a.ListDeviceEvents).Methods("GET")
+	r.HandleFunc("/api/devices/{dev_eui}/contact-stats", a.GetStats).Methods("GET")
+	r.HandleFunc("/api/applications/{application_id}/contact-events", a.ListApplicationEvents).Methods("GET")
+}
+
+// ListStates lists the current states of the contact sensors of the device,
+// sorted by name.
+func (a *ContactAPI) ListStates(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpValidateDevice(httpContext(r), r, a.validator, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	states, err := storage.GetContactStates(ctx, storage.DB(), devEUI)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListContactStatesResponse{
+		Result: []ContactState{},
+	}
+	for _, s := range states {
+		resp.Result = append(resp.Result, ContactState{
+			Name:      s.Name,
+			Open:      s.Open,
+			ChangedAt: s.ChangedAt,
+			UpdatedAt: s.UpdatedAt,
+		})
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// ListDeviceEvents lists the contact events of the device, most recent
+// first. The events can be filtered by the name of the contact and by time
+// using the start and end (RFC3339) query parameters.
+func (a *ContactAPI) ListDeviceEvents(w http.ResponseWriter, r *http.Request) {
+	devEUI, err := httpValidateDevice(httpContext(r), r, a.validator, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	a.listEvents(w, r, storage.ContactEventFilters{
+		DevEUI: devEUI,
+	})
+}
+
+// ListApplicationEvents lists the contact events of the devices of the
+// application, most recent first. The events can be filtered like the
+// device events.
+func (a *ContactAPI) ListApplicationEvents(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(applicationID, auth.Read),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	a.listEvents(w, r, storage.ContactEventFilters{
+		ApplicationID: applicationID,
+	})
+}
+
+// GetStats returns the daily statistics (number of openings, total and
+// longest open duration) of the contact sensors of the device, for the days
+// from start until end (RFC3339 query parameters). By default, this returns
+// the statistics of the last 7 days, including today.
+func (a *ContactAPI) GetStats(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpValidateDevice(httpContext(r), r, a.validator, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	end := time.Now()
+	start := end.AddDate(0, 0, -(contactStatsDefaultDays - 1))
+
+	q := r.URL.Query()
+	if v := q.Get("start"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "start: %s", err))
+			return
+		}
+		start = t
+	}
+	if v := q.Get("end"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "end: %s", err))
+			return
+		}
+		end = t
+	}
+
+	if !end.After(start) {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "end must be after start"))
+		return
+	}
+	if end.Sub(start) > contactStatsMaxRange {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "max. time range is %d days", contactStatsMaxRange/(24*time.Hour)))
+		return
+	}
+
+	stats, err := storage.GetContactDailyStats(ctx, storage.DB(), devEUI, start, end)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := GetContactStatsResponse{
+		Result: []ContactDailyStats{},
+	}
+	for _, s := range stats {
+		resp.Result = append(resp.Result, ContactDailyStats{
+			Name:               s.Name,
+			Date:               s.Date,
+			OpenCount:          s.OpenCount,
+			OpenSeconds:        s.OpenSeconds,
+			LongestOpenSeconds: s.LongestOpenSeconds,
+		})
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// listEvents writes the contact events matching the given filters and the
+// name, start, end, limit and offset query parameters.
+func (a *ContactAPI) listEvents(w http.ResponseWriter, r *http.Request, filters storage.ContactEventFilters) {
+	ctx := httpContext(r)
+
+	limit, offset, err := httpLimitOffset(r, contactEventListMaxLimit)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+	filters.Limit = limit
+	filters.Offset = offset
+
+	q := r.URL.Query()
+	filters.Name = q.Get("name")
+	if v := q.Get("start"); v != "" {
+		filters.Start, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "start: %s", err))
+			return
+		}
+	}
+	if v := q.Get("end"); v != "" {
+		filters.End, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "end: %s", err))
+			return
+		}
+	}
+
+	count, err := storage.GetContactEventCount(ctx, storage.DB(), filters)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	items, err := storage.GetContactEvents(ctx, storage.DB(), filters)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListContactEventsResponse{
+		TotalCount: count,
+		Result:     []ContactEvent{},
+	}
+	for _, e := range items {
+		resp.Result = append(resp.Result, ContactEvent{
+			ID:          e.ID,
+			DevEUI:      e.DevEUI,
+			DeviceName:  e.DeviceName,
+			Name:        e.Name,
+			Time:        e.Time,
+			Open:        e.Open,
+			OpenSeconds: e.OpenSeconds,
+		})
+	}
+
+	httpWriteJSON(w, resp)
+}
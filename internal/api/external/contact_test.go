@@ -0,0 +1,165 @@
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *APITestSuite) TestContact() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewContactAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := storage.Application{
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+		Name:             "test-app",
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	dp := storage.DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(storage.CreateDeviceProfile(context.Background(), storage.DB(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	d := storage.Device{
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "loading-dock",
+	}
+	assert.NoError(storage.CreateDevice(context.Background(), storage.DB(), &d))
+
+	now := time.Now()
+	states := []struct {
+		Name string
+		Open bool
+		Time time.Time
+	}{
+		{"door", true, now.Add(-30 * time.Minute)},
+		{"door", false, now.Add(-20 * time.Minute)},
+		{"door", true, now.Add(-10 * time.Minute)},
+		{"window", true, now.Add(-5 * time.Minute)},
+	}
+	for _, s := range states {
+		_, err := storage.UpdateContactState(context.Background(), storage.DB(), d.DevEUI, s.Name, s.Open, s.Time)
+		assert.NoError(err)
+	}
+
+	get := func(assert *require.Assertions, path string, v interface{}) {
+		rec := httpTestRequest(r, "GET", path, nil)
+		assert.Equal(http.StatusOK, rec.Code)
+		assert.NoError(json.NewDecoder(rec.Body).Decode(v))
+	}
+
+	ts.T().Run("List states", func(t *testing.T) {
+		assert := require.New(t)
+
+		var resp ListContactStatesResponse
+		get(assert, "/api/devices/0102030405060708/contacts", &resp)
+		assert.Len(resp.Result, 2)
+		assert.Equal("door", resp.Result[0].Name)
+		assert.True(resp.Result[0].Open)
+		assert.Equal("window", resp.Result[1].Name)
+	})
+
+	ts.T().Run("List device events", func(t *testing.T) {
+		assert := require.New(t)
+
+		var resp ListContactEventsResponse
+		get(assert, "/api/devices/0102030405060708/contact-events?limit=10&name=door", &resp)
+		assert.Equal(3, resp.TotalCount)
+		assert.Len(resp.Result, 3)
+		assert.True(resp.Result[0].Open)
+		assert.False(resp.Result[1].Open)
+		assert.NotNil(resp.Result[1].OpenSeconds)
+		assert.InDelta(600, *resp.Result[1].OpenSeconds, 0.001)
+		assert.Equal("loading-dock", resp.Result[1].DeviceName)
+	})
+
+	ts.T().Run("List application events", func(t *testing.T) {
+		assert := require.New(t)
+
+		var resp ListContactEventsResponse
+		get(assert, fmt.Sprintf("/api/applications/%d/contact-events?limit=10", app.ID), &resp)
+		assert.Equal(4, resp.TotalCount)
+		assert.Equal("window", resp.Result[0].Name)
+	})
+
+	ts.T().Run("Get stats", func(t *testing.T) {
+		assert := require.New(t)
+
+		var resp GetContactStatsResponse
+		get(assert, "/api/devices/0102030405060708/contact-stats", &resp)
+
+		var openCount int
+		for _, s := range resp.Result {
+			openCount += s.OpenCount
+		}
+		assert.Equal(3, openCount)
+	})
+
+	ts.T().Run("Invalid", func(t *testing.T) {
+		tests := []struct {
+			Name         string
+			Path         string
+			ExpectedCode int
+		}{
+			{"invalid DevEUI", "/api/devices/foo/contacts", http.StatusBadRequest},
+			{"invalid start", "/api/devices/0102030405060708/contact-events?start=yesterday", http.StatusBadRequest},
+			{"end before start", "/api/devices/0102030405060708/contact-stats?start=2020-01-02T00:00:00Z&end=2020-01-01T00:00:00Z", http.StatusBadRequest},
+			{"time range too long", "/api/devices/0102030405060708/contact-stats?start=2020-01-01T00:00:00Z&end=2021-01-01T00:00:00Z", http.StatusBadRequest},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.Name, func(t *testing.T) {
+				assert := require.New(t)
+
+				rec := httpTestRequest(r, "GET", tst.Path, nil)
+				assert.Equal(tst.ExpectedCode, rec.Code)
+			})
+		}
+	})
+}
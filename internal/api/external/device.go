@@ -82,6 +82,16 @@ func (a *DeviceAPI) Create(ctx context.Context, req *pb.CreateDeviceRequest) (*e
 		return nil, grpc.Errorf(codes.InvalidArgument, "device-profile and application must be under the same organization")
 	}
 
+	// Validate that the device-profile is on the network-server of the
+	// application, as the device operations are routed by device-profile.
+	n, err := storage.GetNetworkServerForApplicationID(ctx, storage.DB(), app.ID)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+	if n.ID != dp.NetworkServerID {
+		return nil, grpc.Errorf(codes.InvalidArgument, "device-profile and application must be on the same network-server")
+	}
+
 	// Set Device struct.
 	d := storage.Device{
 		DevEUI:            devEUI,
@@ -206,6 +216,8 @@ func (a *DeviceAPI) Get(ctx context.Context, req *pb.GetDeviceRequest) (*pb.GetD
 		}
 	}
 
+	helpers.SetVersionHeader(ctx, d.UpdatedAt)
+
 	return &resp, nil
 }
 
@@ -335,10 +347,29 @@ func (a *DeviceAPI) Update(ctx context.Context, req *pb.UpdateDeviceRequest) (*e
 		return nil, grpc.Errorf(codes.InvalidArgument, "device-profile and application must be under the same organization")
 	}
 
-	err = storage.Transaction(func(tx sqlx.Ext) error {
-		d, err := storage.GetDevice(ctx, tx, devEUI, true, false)
+	// Validate that the device-profile is on the network-server of the
+	// application, as the device operations are routed by device-profile.
+	n, err := storage.GetNetworkServerForApplicationID(ctx, storage.DB(), app.ID)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+	if n.ID != dp.NetworkServerID {
+		return nil, grpc.Errorf(codes.InvalidArgument, "device-profile and application must be on the same network-server")
+	}
+
+	// the transaction only executes database queries, so that it can be
+	// retried on a conflict with a concurrent update of the same device, the
+	// network-server is updated after the transaction has been committed
+	var d storage.Device
+	err = storage.TransactionWithRetry(func(tx sqlx.Ext) error {
+		var err error
+		d, err = storage.GetDevice(ctx, tx, devEUI, true, true)
 		if err != nil {
-			return helpers.ErrToRPCError(err)
+			return err
+		}
+
+		if err := helpers.CheckVersion(ctx, d.UpdatedAt); err != nil {
+			return err
 		}
 
 		// If the device is moved to a different application, validate that
@@ -348,12 +379,12 @@ func (a *DeviceAPI) Update(ctx context.Context, req *pb.UpdateDeviceRequest) (*e
 		if req.Device.ApplicationId != d.ApplicationID {
 			appOld, err := storage.GetApplication(ctx, tx, d.ApplicationID)
 			if err != nil {
-				return helpers.ErrToRPCError(err)
+				return err
 			}
 
 			appNew, err := storage.GetApplication(ctx, tx, req.Device.ApplicationId)
 			if err != nil {
-				return helpers.ErrToRPCError(err)
+				return err
 			}
 
 			if appOld.ServiceProfileID != appNew.ServiceProfileID {
@@ -383,14 +414,14 @@ func (a *DeviceAPI) Update(ctx context.Context, req *pb.UpdateDeviceRequest) (*e
 			d.Tags.Map[k] = sql.NullString{String: v, Valid: true}
 		}
 
-		if err := storage.UpdateDevice(ctx, tx, &d, false); err != nil {
-			return helpers.ErrToRPCError(err)
-		}
-
-		return nil
+		return storage.UpdateDevice(ctx, tx, &d, true)
 	})
 	if err != nil {
-		return nil, err
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	if err := storage.UpdateDeviceOnNetworkServer(ctx, storage.DB(), d); err != nil {
+		return nil, helpers.ErrToRPCError(err)
 	}
 
 	return &empty.Empty{}, nil
@@ -408,15 +439,28 @@ func (a *DeviceAPI) Delete(ctx context.Context, req *pb.DeleteDeviceRequest) (*e
 		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
 	}
 
-	// as this also performs a remote call to delete the node from the
-	// network-server, wrap it in a transaction
-	err := storage.Transaction(func(tx sqlx.Ext) error {
-		return storage.DeleteDevice(ctx, tx, eui)
+	// the transaction only executes database queries, so that it can be
+	// retried on a conflict with a concurrent transaction, the device is
+	// deleted from the network-server after the transaction has been
+	// committed
+	var n storage.NetworkServer
+	err := storage.TransactionWithRetry(func(tx sqlx.Ext) error {
+		var err error
+		n, err = storage.GetNetworkServerForDevEUI(ctx, tx, eui)
+		if err != nil {
+			return err
+		}
+
+		return storage.DeleteDeviceLocal(ctx, tx, eui)
 	})
 	if err != nil {
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	if err := storage.DeleteDeviceOnNetworkServer(ctx, n, eui); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
 	return &empty.Empty{}, nil
 }
 
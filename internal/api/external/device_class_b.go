@@ -0,0 +1,201 @@
+package external
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	//"github.com/ibrahimozekici/lora-api/go/v3/ns"
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/downlink"
+	"github.com/ibrahimozekici/app-server2/internal/downlink/classb"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+// ClassBQueueItem defines a device-queue item with its estimated Class-B
+// delivery window.
+type ClassBQueueItem struct {
+	FCnt       uint32    `json:"fCnt"`
+	FPort      uint32    `json:"fPort"`
+	Confirmed  bool      `json:"confirmed"`
+	Position   int       `json:"position"`
+	EarliestAt time.Time `json:"earliestAt"`
+	LatestAt   time.Time `json:"latestAt"`
+}
+
+// GetDeviceClassBResponse defines the Class-B scheduling information of a
+// device.
+type GetDeviceClassBResponse struct {
+	SupportsClassB   bool    `json:"supportsClassB"`
+	ClassBTimeout    uint32  `json:"classBTimeout"`
+	PingSlotPeriod   uint32  `json:"pingSlotPeriod"`
+	PingSlotInterval float64 `json:"pingSlotInterval"`
+	PingNb           uint32  `json:"pingNb"`
+	PingSlotDR       uint32  `json:"pingSlotDR"`
+	PingSlotFreq     uint32  `json:"pingSlotFreq"`
+
+	// BeaconLocked is nil when the device never reported its beacon-lock
+	// status.
+	BeaconLocked          *bool      `json:"beaconLocked"`
+	BeaconStatusUpdatedAt *time.Time `json:"beaconStatusUpdatedAt"`
+
+	Queue []ClassBQueueItem `json:"queue"`
+}
+
+// UpdateDeviceBeaconStatusRequest defines the request to report the Class-B
+// beacon-lock status of a device.
+type UpdateDeviceBeaconStatusRequest struct {
+	BeaconLocked bool `json:"beaconLocked"`
+}
+
+// DeviceClassBAPI exports the Class-B scheduling related functions.
+type DeviceClassBAPI struct {
+	validator auth.Validator
+}
+
+// NewDeviceClassBAPI creates a new DeviceClassBAPI.
+func NewDeviceClassBAPI(validator auth.Validator) *DeviceClassBAPI {
+	return &DeviceClassBAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *DeviceClassBAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/devices/{dev_eui}/class-b", a.Get).Methods("GET")
+	r.HandleFunc("/api/devices/{dev_eui}/class-b/beacon-status", a.UpdateBeaconStatus).Methods("PUT")
+}
+
+// Get returns the Class-B ping-slot parameters of the device, its last
+// reported beacon-lock status and for each pending device-queue item the
+// estimated window in which it will be sent. The estimates assume that the
+// device is locked to the beacon.
+func (a *DeviceClassBAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpEUI64Var(r, "dev_eui")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateNodeAccess(devEUI, auth.Read),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	d, err := storage.GetDevice(ctx, storage.DB(), devEUI, false, true)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	dp, err := storage.GetDeviceProfile(ctx, storage.DB(), d.DeviceProfileID, false, false)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := GetDeviceClassBResponse{
+		SupportsClassB:   dp.DeviceProfile.SupportsClassB,
+		ClassBTimeout:    dp.DeviceProfile.ClassBTimeout,
+		PingSlotPeriod:   dp.DeviceProfile.PingSlotPeriod,
+		PingSlotInterval: classb.PingSlotInterval(dp.DeviceProfile.PingSlotPeriod).Seconds(),
+		PingNb:           classb.PingNb(dp.DeviceProfile.PingSlotPeriod),
+		PingSlotDR:       dp.DeviceProfile.PingSlotDr,
+		PingSlotFreq:     dp.DeviceProfile.PingSlotFreq,
+		Queue:            []ClassBQueueItem{},
+	}
+
+	s, err := storage.GetDeviceClassBStatus(ctx, storage.DB(), devEUI)
+	if err == nil {
+		resp.BeaconLocked = &s.BeaconLocked
+		resp.BeaconStatusUpdatedAt = &s.UpdatedAt
+	} else if err != storage.ErrDoesNotExist {
+		httpWriteError(w, err)
+		return
+	}
+
+	if !dp.DeviceProfile.SupportsClassB {
+		httpWriteJSON(w, resp)
+		return
+	}
+
+	n, err := storage.GetNetworkServerForDevEUI(ctx, storage.DB(), devEUI)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	nsClient, err := networkserver.GetPool().Get(n.Server, []byte(n.CACert), []byte(n.TLSCert), []byte(n.TLSKey))
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	queueResp, err := nsClient.GetDeviceQueueItemsForDevEUI(ctx, &ns.GetDeviceQueueItemsForDevEUIRequest{
+		DevEui: devEUI[:],
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	now := time.Now()
+	for i, qi := range queueResp.Items {
+		est := classb.EstimateLatency(dp.DeviceProfile.PingSlotPeriod, i)
+		resp.Queue = append(resp.Queue, ClassBQueueItem{
+			FCnt:       qi.FCnt,
+			FPort:      qi.FPort,
+			Confirmed:  qi.Confirmed,
+			Position:   i,
+			EarliestAt: now.Add(est.Min),
+			LatestAt:   now.Add(est.Max),
+		})
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// UpdateBeaconStatus stores the Class-B beacon-lock status as reported by the
+// device (e.g. decoded from the uplink by an integration). On a status change,
+// a beacon-lock status event is sent to the integrations.
+func (a *DeviceClassBAPI) UpdateBeaconStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpEUI64Var(r, "dev_eui")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateNodeAccess(devEUI, auth.Update),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req UpdateDeviceBeaconStatusRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if _, err := storage.GetDevice(ctx, storage.DB(), devEUI, false, true); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := downlink.HandleBeaconLockStatus(ctx, devEUI, req.BeaconLocked); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
@@ -0,0 +1,170 @@
+package external
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	//"github.com/ibrahimozekici/lora-api/go/v3/ns"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/integration"
+	integrationmock "github.com/ibrahimozekici/app-server2/internal/integration/mock"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *APITestSuite) TestDeviceClassB() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	h := integrationmock.New()
+	integration.SetMockIntegration(h)
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewDeviceClassBAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	dp := storage.DeviceProfile{
+		Name:            "test-dp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+		DeviceProfile: ns.DeviceProfile{
+			SupportsClassB: true,
+			PingSlotPeriod: 32,
+			PingSlotDr:     3,
+			PingSlotFreq:   869525000,
+		},
+	}
+	assert.NoError(storage.CreateDeviceProfile(context.Background(), storage.DB(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	nsClient.GetDeviceProfileResponse = ns.GetDeviceProfileResponse{
+		DeviceProfile: &dp.DeviceProfile,
+	}
+
+	app := storage.Application{
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+		Name:             "test-app",
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	d := storage.Device{
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "test-node",
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+	}
+	assert.NoError(storage.CreateDevice(context.Background(), storage.DB(), &d))
+
+	get := func() GetDeviceClassBResponse {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", fmt.Sprintf("/api/devices/%s/class-b", d.DevEUI), nil))
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp GetDeviceClassBResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		return resp
+	}
+
+	updateBeaconStatus := func(locked bool) {
+		b, err := json.Marshal(UpdateDeviceBeaconStatusRequest{BeaconLocked: locked})
+		assert.NoError(err)
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("PUT", fmt.Sprintf("/api/devices/%s/class-b/beacon-status", d.DevEUI), bytes.NewReader(b)))
+		assert.Equal(http.StatusOK, rec.Code)
+	}
+
+	ts.T().Run("Get", func(t *testing.T) {
+		assert := require.New(t)
+
+		nsClient.GetDeviceQueueItemsForDevEUIResponse = ns.GetDeviceQueueItemsForDevEUIResponse{
+			Items: []*ns.DeviceQueueItem{
+				{DevEui: d.DevEUI[:], FCnt: 10, FPort: 1},
+				{DevEui: d.DevEUI[:], FCnt: 11, FPort: 2, Confirmed: true},
+			},
+		}
+
+		resp := get()
+		<-nsClient.GetDeviceQueueItemsForDevEUIChan
+
+		assert.True(resp.SupportsClassB)
+		assert.EqualValues(32, resp.PingSlotPeriod)
+		assert.EqualValues(128, resp.PingNb)
+		assert.InDelta(0.96, resp.PingSlotInterval, 0.001)
+		assert.EqualValues(3, resp.PingSlotDR)
+		assert.EqualValues(869525000, resp.PingSlotFreq)
+		assert.Nil(resp.BeaconLocked)
+
+		assert.Len(resp.Queue, 2)
+		assert.EqualValues(10, resp.Queue[0].FCnt)
+		assert.Equal(0, resp.Queue[0].Position)
+		assert.EqualValues(11, resp.Queue[1].FCnt)
+		assert.Equal(1, resp.Queue[1].Position)
+		assert.True(resp.Queue[1].Confirmed)
+		assert.True(resp.Queue[1].EarliestAt.After(resp.Queue[0].EarliestAt))
+		assert.True(resp.Queue[1].LatestAt.After(resp.Queue[1].EarliestAt))
+	})
+
+	ts.T().Run("UpdateBeaconStatus", func(t *testing.T) {
+		assert := require.New(t)
+
+		updateBeaconStatus(true)
+
+		pl := <-h.SendIntegrationNotificationChan
+		assert.Equal("class_b", pl.IntegrationName)
+		assert.Equal("beacon_lock", pl.EventType)
+		assert.Contains(pl.ObjectJson, `"beaconLocked":true`)
+
+		t.Run("Unchanged status does not send an event", func(t *testing.T) {
+			assert := require.New(t)
+
+			updateBeaconStatus(true)
+			assert.Len(h.SendIntegrationNotificationChan, 0)
+		})
+
+		t.Run("Get returns the beacon status", func(t *testing.T) {
+			assert := require.New(t)
+
+			resp := get()
+			<-nsClient.GetDeviceQueueItemsForDevEUIChan
+
+			assert.NotNil(resp.BeaconLocked)
+			assert.True(*resp.BeaconLocked)
+			assert.NotNil(resp.BeaconStatusUpdatedAt)
+		})
+	})
+}
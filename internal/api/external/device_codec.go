@@ -0,0 +1,123 @@
+package external
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/codec"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+// DeviceCodec defines the codec of a device. When PayloadCodec is set, it
+// overrides the codec of the device-profile.
+type DeviceCodec struct {
+	PayloadCodec         codec.Type `json:"payloadCodec"`
+	PayloadEncoderScript string     `json:"payloadEncoderScript"`
+	PayloadDecoderScript string     `json:"payloadDecoderScript"`
+}
+
+// GetDeviceCodecResponse defines the get device codec response.
+type GetDeviceCodecResponse struct {
+	Codec DeviceCodec `json:"codec"`
+}
+
+// UpdateDeviceCodecRequest defines the update device codec request.
+type UpdateDeviceCodecRequest struct {
+	Codec DeviceCodec `json:"codec"`
+}
+
+// DeviceCodecAPI exports the device codec related functions.
+type DeviceCodecAPI struct {
+	validator auth.Validator
+}
+
+// NewDeviceCodecAPI creates a new DeviceCodecAPI.
+func NewDeviceCodecAPI(validator auth.Validator) *DeviceCodecAPI {
+	return &DeviceCodecAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *DeviceCodecAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/devices/{dev_eui}/codec", a.Get).Methods("GET")
+	r.HandleFunc("/api/devices/{dev_eui}/codec", a.Update).Methods("PUT")
+}
+
+// Get returns the codec of the given device.
+func (a *DeviceCodecAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpEUI64Var(r, "dev_eui")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateNodeAccess(devEUI, auth.Read),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	d, err := storage.GetDevice(ctx, storage.DB(), devEUI, false, true)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, GetDeviceCodecResponse{
+		Codec: DeviceCodec{
+			PayloadCodec:         d.PayloadCodec,
+			PayloadEncoderScript: d.PayloadEncoderScript,
+			PayloadDecoderScript: d.PayloadDecoderScript,
+		},
+	})
+}
+
+// Update updates the codec of the given device. An empty payloadCodec
+// removes the override, in which case the device-profile codec is used.
+func (a *DeviceCodecAPI) Update(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpEUI64Var(r, "dev_eui")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateNodeAccess(devEUI, auth.Update),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req UpdateDeviceCodecRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := codec.ValidateDecoderScript(req.Codec.PayloadCodec, req.Codec.PayloadDecoderScript); err != nil {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "payloadDecoderScript: %s", err))
+		return
+	}
+
+	if err := storage.UpdateDeviceCodec(ctx, storage.DB(), devEUI, storage.DeviceCodec{
+		Type:          req.Codec.PayloadCodec,
+		EncoderScript: req.Codec.PayloadEncoderScript,
+		DecoderScript: req.Codec.PayloadDecoderScript,
+	}); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
@@ -0,0 +1,125 @@
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/codec"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+func (ts *APITestSuite) TestDeviceCodec() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewDeviceCodecAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	dp := storage.DeviceProfile{
+		Name:            "test-dp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateDeviceProfile(context.Background(), storage.DB(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	app := storage.Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	d := storage.Device{
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "test-node",
+		DevEUI:          [8]byte{1, 2, 3, 4, 5, 6, 7, 8},
+	}
+	assert.NoError(storage.CreateDevice(context.Background(), storage.DB(), &d))
+
+	path := fmt.Sprintf("/api/devices/%s/codec", d.DevEUI)
+
+	get := func(t *testing.T) GetDeviceCodecResponse {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "GET", path, nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp GetDeviceCodecResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		return resp
+	}
+
+	update := func(t *testing.T, c DeviceCodec) int {
+		assert := require.New(t)
+
+		b, err := json.Marshal(UpdateDeviceCodecRequest{Codec: c})
+		assert.NoError(err)
+
+		rec := httpTestRequest(r, "PUT", path, b)
+		return rec.Code
+	}
+
+	ts.T().Run("Get not configured", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Equal(DeviceCodec{}, get(t).Codec)
+	})
+
+	ts.T().Run("Update", func(t *testing.T) {
+		assert := require.New(t)
+
+		c := DeviceCodec{
+			PayloadCodec:         codec.CustomJSType,
+			PayloadDecoderScript: "function Decode() {}",
+			PayloadEncoderScript: "function Encode() {}",
+		}
+		assert.Equal(http.StatusOK, update(t, c))
+		assert.Equal(c, get(t).Codec)
+	})
+
+	ts.T().Run("Update invalid", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Equal(http.StatusBadRequest, update(t, DeviceCodec{PayloadCodec: "FOO"}))
+	})
+
+	ts.T().Run("Remove", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.Equal(http.StatusOK, update(t, DeviceCodec{}))
+		assert.Equal(DeviceCodec{}, get(t).Codec)
+	})
+}
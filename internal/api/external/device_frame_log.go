@@ -0,0 +1,152 @@
+package external
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/archive"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+// deviceFrameLogMaxLimit defines the max. number of frames returned by a
+// single request.
+const deviceFrameLogMaxLimit = 1000
+
+// DeviceFrame defines a stored (uplink) frame of a device.
+type DeviceFrame struct {
+	ReceivedAt      time.Time       `json:"receivedAt"`
+	DevAddr         string          `json:"devAddr"`
+	FCnt            uint32          `json:"fCnt"`
+	FPort           uint8           `json:"fPort"`
+	DR              int             `json:"dr"`
+	ADR             bool            `json:"adr"`
+	ConfirmedUplink bool            `json:"confirmedUplink"`
+	Data            []byte          `json:"data"`
+	Object          json.RawMessage `json:"object,omitempty"`
+	RXInfo          json.RawMessage `json:"rxInfo,omitempty"`
+}
+
+// ListDeviceFramesResponse defines the list device frames response.
+//
+// Archived is set when (a part of) the requested time range is before the
+// archive cutoff, in which case the frames of that part are no longer
+// stored locally but in the object storage archive.
+type ListDeviceFramesResponse struct {
+	Result         []DeviceFrame `json:"result"`
+	Archived       bool          `json:"archived"`
+	ArchivedBefore *time.Time    `json:"archivedBefore,omitempty"`
+}
+
+// DeviceFrameLogAPI exports the device frame-log related functions.
+type DeviceFrameLogAPI struct {
+	validator auth.Validator
+}
+
+// NewDeviceFrameLogAPI creates a new DeviceFrameLogAPI.
+func NewDeviceFrameLogAPI(validator auth.Validator) *DeviceFrameLogAPI {
+	return &DeviceFrameLogAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *DeviceFrameLogAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/devices/{dev_eui}/frames", a.List).Methods("GET")
+}
+
+// List returns the frames of the given device within the requested time
+// range (start and end as RFC3339 timestamps). When not set, the frames of
+// the last 24 hours are returned.
+func (a *DeviceFrameLogAPI) List(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpEUI64Var(r, "dev_eui")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateNodeAccess(devEUI, auth.Read),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	now := time.Now()
+	end := now
+	start := now.Add(-24 * time.Hour)
+	limit := deviceFrameLogMaxLimit
+
+	q := r.URL.Query()
+	if v := q.Get("start"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "start: %s", err))
+			return
+		}
+		start = t
+	}
+	if v := q.Get("end"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "end: %s", err))
+			return
+		}
+		end = t
+	}
+	if v := q.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l <= 0 || l > deviceFrameLogMaxLimit {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", deviceFrameLogMaxLimit))
+			return
+		}
+		limit = l
+	}
+
+	var frames []storage.DeviceFrameLog
+	err = storage.ForDeviceOrganization(ctx, storage.DB(), devEUI, func(db sqlx.Ext) error {
+		var err error
+		frames, err = storage.GetDeviceFrameLogs(ctx, db, devEUI, start, end, limit)
+		return err
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListDeviceFramesResponse{
+		Result: make([]DeviceFrame, 0, len(frames)),
+	}
+
+	if archivedBefore, ok := archive.FramesArchivedBefore(now); ok && start.Before(archivedBefore) {
+		resp.Archived = true
+		resp.ArchivedBefore = &archivedBefore
+	}
+
+	for _, f := range frames {
+		resp.Result = append(resp.Result, DeviceFrame{
+			ReceivedAt:      f.ReceivedAt,
+			DevAddr:         f.DevAddr.String(),
+			FCnt:            f.FCnt,
+			FPort:           f.FPort,
+			DR:              f.DR,
+			ADR:             f.ADR,
+			ConfirmedUplink: f.ConfirmedUplink,
+			Data:            f.Data,
+			Object:          f.Object,
+			RXInfo:          f.RXInfo,
+		})
+	}
+
+	httpWriteJSON(w, resp)
+}
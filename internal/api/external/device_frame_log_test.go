@@ -0,0 +1,42 @@
+package external
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func (ts *APITestSuite) TestDeviceFrameLog() {
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewDeviceFrameLogAPI(validator).Register(r)
+
+	ts.T().Run("Invalid DevEUI", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "GET", "/api/devices/foo/frames", nil)
+		assert.Equal(http.StatusBadRequest, rec.Code)
+	})
+
+	ts.T().Run("Invalid time range", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "GET", "/api/devices/0102030405060708/frames?start=yesterday", nil)
+		assert.Equal(http.StatusBadRequest, rec.Code)
+	})
+
+	ts.T().Run("List", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "GET", "/api/devices/0102030405060708/frames?start=2020-01-01T00:00:00Z&limit=10", nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp ListDeviceFramesResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Len(resp.Result, 0)
+		assert.False(resp.Archived)
+	})
+}
@@ -0,0 +1,834 @@
+package external
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq/hstore"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb //"github.com/ibrahimozekici/lora-api/go/v3/as/external/api"
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/api/helpers"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+const (
+	// deviceGroupListMaxLimit defines the max. number of device groups or
+	// group devices returned by a single list request.
+	deviceGroupListMaxLimit = 1000
+
+	// deviceGroupBulkMaxDevices defines the max. number of devices of a
+	// group on which a bulk operation can be performed.
+	deviceGroupBulkMaxDevices = 1000
+
+	// deviceGroupDefaultWindow defines the default window of the group
+	// metrics.
+	deviceGroupDefaultWindow = 24 * time.Hour
+
+	// deviceGroupMaxWindow defines the max. window of the group metrics.
+	deviceGroupMaxWindow = 31 * 24 * time.Hour
+
+	// deviceGroupMaxMeasurements defines the max. number of measurements
+	// which can be selected by a single metrics request.
+	deviceGroupMaxMeasurements = 32
+)
+
+// DeviceGroup defines a named group of devices of an application. Type must
+// be MANUAL (the devices are added and removed explicitly) or TAG (the group
+// contains all the devices of the application having all DeviceTags).
+type DeviceGroup struct {
+	ID            string                  `json:"id"`
+	ApplicationID int64                   `json:"applicationID,string"`
+	Name          string                  `json:"name"`
+	Description   string                  `json:"description"`
+	Type          storage.DeviceGroupType `json:"type"`
+	DeviceTags    map[string]string       `json:"deviceTags"`
+	CreatedAt     time.Time               `json:"createdAt"`
+	UpdatedAt     time.Time               `json:"updatedAt"`
+}
+
+// DeviceGroupDevice defines a device of a device group.
+type DeviceGroupDevice struct {
+	DevEUI          string            `json:"devEUI"`
+	Name            string            `json:"name"`
+	DeviceProfileID string            `json:"deviceProfileID"`
+	Tags            map[string]string `json:"tags"`
+	LastSeenAt      *time.Time        `json:"lastSeenAt,omitempty"`
+}
+
+// DeviceGroupOperationResult defines the result of a bulk operation for a
+// single device. Error is set when the operation failed for this device.
+type DeviceGroupOperationResult struct {
+	DevEUI        string `json:"devEUI"`
+	Error         string `json:"error,omitempty"`
+	FCnt          uint32 `json:"fCnt,omitempty"`
+	CorrelationID string `json:"correlationID,omitempty"`
+}
+
+// DeviceGroupOperationResponse defines the response of a bulk operation.
+type DeviceGroupOperationResponse struct {
+	Total  int                          `json:"total"`
+	Failed int                          `json:"failed"`
+	Result []DeviceGroupOperationResult `json:"result"`
+}
+
+// CreateDeviceGroupRequest defines the create device group request.
+type CreateDeviceGroupRequest struct {
+	DeviceGroup DeviceGroup `json:"deviceGroup"`
+}
+
+// CreateDeviceGroupResponse defines the create device group response.
+type CreateDeviceGroupResponse struct {
+	ID string `json:"id"`
+}
+
+// GetDeviceGroupResponse defines the get device group response.
+type GetDeviceGroupResponse struct {
+	DeviceGroup DeviceGroup `json:"deviceGroup"`
+	Devices     int         `json:"devices"`
+}
+
+// UpdateDeviceGroupRequest defines the update device group request.
+type UpdateDeviceGroupRequest struct {
+	DeviceGroup DeviceGroup `json:"deviceGroup"`
+}
+
+// ListDeviceGroupsResponse defines the list device groups response.
+type ListDeviceGroupsResponse struct {
+	TotalCount int           `json:"totalCount"`
+	Result     []DeviceGroup `json:"result"`
+}
+
+// ListDeviceGroupDevicesResponse defines the list device group devices
+// response.
+type ListDeviceGroupDevicesResponse struct {
+	TotalCount int                 `json:"totalCount"`
+	Result     []DeviceGroupDevice `json:"result"`
+}
+
+// DeviceGroupDownlinkRequest defines the request to enqueue a downlink for
+// all devices of the group. Either Data or Object must be set, Object is
+// encoded using the codec of each device.
+type DeviceGroupDownlinkRequest struct {
+	FPort     uint32          `json:"fPort"`
+	Confirmed bool            `json:"confirmed"`
+	Data      []byte          `json:"data"`
+	Object    json.RawMessage `json:"object"`
+}
+
+// DeviceGroupTagsRequest defines the request to update the tags of all
+// devices of the group. The Set tags are added or overwritten, the Remove
+// tags are removed.
+type DeviceGroupTagsRequest struct {
+	Set    map[string]string `json:"set"`
+	Remove []string          `json:"remove"`
+}
+
+// DeviceGroupDeviceProfileRequest defines the request to change the
+// device-profile of all devices of the group.
+type DeviceGroupDeviceProfileRequest struct {
+	DeviceProfileID string `json:"deviceProfileID"`
+}
+
+// DeviceGroupStatus defines the status summary of the devices of a group.
+// ActiveDevices holds the number of devices seen within the window.
+type DeviceGroupStatus struct {
+	Devices         int        `json:"devices"`
+	ActiveDevices   int        `json:"activeDevices"`
+	LastSeenAt      *time.Time `json:"lastSeenAt,omitempty"`
+	MinBatteryLevel *float64   `json:"minBatteryLevel,omitempty"`
+	AvgBatteryLevel *float64   `json:"avgBatteryLevel,omitempty"`
+}
+
+// DeviceGroupMeasurement defines the aggregated values of a measurement of
+// the devices of a group, over the requested window.
+type DeviceGroupMeasurement struct {
+	Name     string    `json:"name"`
+	Devices  int       `json:"devices"`
+	Count    int       `json:"count"`
+	Min      float64   `json:"min"`
+	Max      float64   `json:"max"`
+	Avg      float64   `json:"avg"`
+	Latest   float64   `json:"latest"`
+	LatestAt time.Time `json:"latestAt"`
+}
+
+// GetDeviceGroupMetricsResponse defines the get device group metrics
+// response.
+type GetDeviceGroupMetricsResponse struct {
+	Start        time.Time                `json:"start"`
+	End          time.Time                `json:"end"`
+	Status       DeviceGroupStatus        `json:"status"`
+	Measurements []DeviceGroupMeasurement `json:"measurements"`
+}
+
+// DeviceGroupAPI exports the device group related functions (the device
+// group service). Bulk operations are performed synchronously, device by
+// device, and return the result for each device of the group.
+type DeviceGroupAPI struct {
+	validator auth.Validator
+}
+
+// NewDeviceGroupAPI creates a new DeviceGroupAPI.
+func NewDeviceGroupAPI(validator auth.Validator) *DeviceGroupAPI {
+	return &DeviceGroupAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *DeviceGroupAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/applications/{application_id}/device-groups", a.Create).Methods("POST")
+	r.HandleFunc("/api/applications/{application_id}/device-groups", a.List).Methods("GET")
+	r.HandleFunc("/api/device-groups/{id}", a.Get).Methods("GET")
+	r.HandleFunc("/api/device-groups/{id}", a.Update).Methods("PUT")
+	r.HandleFunc("/api/device-groups/{id}", a.Delete).Methods("DELETE")
+	r.HandleFunc("/api/device-groups/{id}/devices", a.ListDevices).Methods("GET")
+	r.HandleFunc("/api/device-groups/{id}/devices/{dev_eui}", a.AddDevice).Methods("POST")
+	r.HandleFunc("/api/device-groups/{id}/devices/{dev_eui}", a.RemoveDevice).Methods("DELETE")
+	r.HandleFunc("/api/device-groups/{id}/downlink", a.Downlink).Methods("POST")
+	r.HandleFunc("/api/device-groups/{id}/tags", a.UpdateTags).Methods("POST")
+	r.HandleFunc("/api/device-groups/{id}/device-profile", a.UpdateDeviceProfile).Methods("POST")
+	r.HandleFunc("/api/device-groups/{id}/metrics", a.GetMetrics).Methods("GET")
+}
+
+// Create creates the given device group for the application.
+func (a *DeviceGroupAPI) Create(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(applicationID, auth.Update),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req CreateDeviceGroupRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	g := storage.DeviceGroup{
+		ApplicationID: applicationID,
+	}
+	deviceGroupToStorage(req.DeviceGroup, &g)
+
+	if err := storage.CreateDeviceGroup(ctx, storage.DB(), &g); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, CreateDeviceGroupResponse{
+		ID: g.ID.String(),
+	})
+}
+
+// List lists the device groups of the application, sorted by name.
+func (a *DeviceGroupAPI) List(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	limit, offset, err := httpLimitOffset(r, deviceGroupListMaxLimit)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(applicationID, auth.Read),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	filters := storage.DeviceGroupFilters{
+		ApplicationID: applicationID,
+		Limit:         limit,
+		Offset:        offset,
+	}
+
+	count, err := storage.GetDeviceGroupCount(ctx, storage.DB(), filters)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	groups, err := storage.GetDeviceGroups(ctx, storage.DB(), filters)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListDeviceGroupsResponse{
+		TotalCount: count,
+		Result:     []DeviceGroup{},
+	}
+	for _, g := range groups {
+		resp.Result = append(resp.Result, deviceGroupFromStorage(g))
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// Get returns the device group and its number of devices.
+func (a *DeviceGroupAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	g, err := a.getDeviceGroup(ctx, r, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	count, err := storage.GetDeviceGroupDeviceCount(ctx, storage.DB(), g)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, GetDeviceGroupResponse{
+		DeviceGroup: deviceGroupFromStorage(g),
+		Devices:     count,
+	})
+}
+
+// Update updates the device group. When the group is changed into a TAG
+// group, its manually added devices are removed.
+func (a *DeviceGroupAPI) Update(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	var req UpdateDeviceGroupRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	g, err := a.getDeviceGroup(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	deviceGroupToStorage(req.DeviceGroup, &g)
+
+	if err := storage.UpdateDeviceGroup(ctx, storage.DB(), &g); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// Delete deletes the device group. The devices of the group are not
+// affected.
+func (a *DeviceGroupAPI) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	g, err := a.getDeviceGroup(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := storage.DeleteDeviceGroup(ctx, storage.DB(), g.ID); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// ListDevices lists the devices of the device group, sorted by name.
+func (a *DeviceGroupAPI) ListDevices(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	limit, offset, err := httpLimitOffset(r, deviceGroupListMaxLimit)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	g, err := a.getDeviceGroup(ctx, r, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	count, err := storage.GetDeviceGroupDeviceCount(ctx, storage.DB(), g)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	devices, err := storage.GetDeviceGroupDevices(ctx, storage.DB(), g, limit, offset)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListDeviceGroupDevicesResponse{
+		TotalCount: count,
+		Result:     []DeviceGroupDevice{},
+	}
+	for _, d := range devices {
+		item := DeviceGroupDevice{
+			DevEUI:          d.DevEUI.String(),
+			Name:            d.Name,
+			DeviceProfileID: d.DeviceProfileID.String(),
+			Tags:            make(map[string]string),
+			LastSeenAt:      d.LastSeenAt,
+		}
+		for k, v := range d.Tags.Map {
+			if v.Valid {
+				item.Tags[k] = v.String
+			}
+		}
+		resp.Result = append(resp.Result, item)
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// AddDevice adds the device to the (MANUAL) device group. The device must
+// belong to the application of the group.
+func (a *DeviceGroupAPI) AddDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	g, devEUI, err := a.getDeviceGroupDevice(ctx, r)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := storage.AddDeviceGroupDevice(ctx, storage.DB(), g, devEUI); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// RemoveDevice removes the device from the (MANUAL) device group.
+func (a *DeviceGroupAPI) RemoveDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	g, devEUI, err := a.getDeviceGroupDevice(ctx, r)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := storage.RemoveDeviceGroupDevice(ctx, storage.DB(), g, devEUI); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// Downlink enqueues the given downlink for each device of the group.
+func (a *DeviceGroupAPI) Downlink(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	var req DeviceGroupDownlinkRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if req.FPort == 0 {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "fPort must be > 0"))
+		return
+	}
+
+	object := string(req.Object)
+	if object == "null" {
+		object = ""
+	}
+
+	if len(req.Data) == 0 && object == "" {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "data or object must be set"))
+		return
+	}
+
+	g, err := a.getDeviceGroup(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	devices, err := getDeviceGroupBulkDevices(ctx, g)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := newDeviceGroupOperationResponse(len(devices))
+	for _, d := range devices {
+		fCnt, correlationID, err := enqueueDeviceQueueItem(ctx, d.DevEUI, &pb.DeviceQueueItem{
+			DevEui:     d.DevEUI.String(),
+			Confirmed:  req.Confirmed,
+			FPort:      req.FPort,
+			Data:       req.Data,
+			JsonObject: object,
+		}, nil)
+
+		res := DeviceGroupOperationResult{
+			DevEUI: d.DevEUI.String(),
+		}
+		if err == nil {
+			res.FCnt = fCnt
+			res.CorrelationID = correlationID.String()
+		}
+		resp.add(res, err)
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// UpdateTags updates the tags of each device of the group. Note that
+// updating the tags could change the devices of TAG groups.
+func (a *DeviceGroupAPI) UpdateTags(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	var req DeviceGroupTagsRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if len(req.Set) == 0 && len(req.Remove) == 0 {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "set or remove must be set"))
+		return
+	}
+
+	g, err := a.getDeviceGroup(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	devices, err := getDeviceGroupBulkDevices(ctx, g)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := newDeviceGroupOperationResponse(len(devices))
+	for _, d := range devices {
+		err := storage.Transaction(func(tx sqlx.Ext) error {
+			d, err := storage.GetDevice(ctx, tx, d.DevEUI, true, true)
+			if err != nil {
+				return err
+			}
+
+			if d.Tags.Map == nil {
+				d.Tags = hstore.Hstore{Map: make(map[string]sql.NullString)}
+			}
+			for _, k := range req.Remove {
+				delete(d.Tags.Map, k)
+			}
+			for k, v := range req.Set {
+				d.Tags.Map[k] = sql.NullString{String: v, Valid: true}
+			}
+
+			return storage.UpdateDevice(ctx, tx, &d, true)
+		})
+
+		resp.add(DeviceGroupOperationResult{DevEUI: d.DevEUI.String()}, err)
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// UpdateDeviceProfile changes the device-profile of each device of the
+// group. The device-profile must be under the same organization as the
+// application of the group.
+func (a *DeviceGroupAPI) UpdateDeviceProfile(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	var req DeviceGroupDeviceProfileRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	dpID, err := uuid.FromString(req.DeviceProfileID)
+	if err != nil {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "deviceProfileID: %s", err))
+		return
+	}
+
+	g, err := a.getDeviceGroup(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	app, err := storage.GetApplication(ctx, storage.DB(), g.ApplicationID)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	dp, err := storage.GetDeviceProfile(ctx, storage.DB(), dpID, false, true)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if app.OrganizationID != dp.OrganizationID {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "device-profile and application must be under the same organization"))
+		return
+	}
+
+	devices, err := getDeviceGroupBulkDevices(ctx, g)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := newDeviceGroupOperationResponse(len(devices))
+	for _, d := range devices {
+		err := storage.Transaction(func(tx sqlx.Ext) error {
+			d, err := storage.GetDevice(ctx, tx, d.DevEUI, true, false)
+			if err != nil {
+				return err
+			}
+
+			d.DeviceProfileID = dpID
+
+			return storage.UpdateDevice(ctx, tx, &d, false)
+		})
+
+		resp.add(DeviceGroupOperationResult{DevEUI: d.DevEUI.String()}, err)
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// GetMetrics returns the status summary and the aggregated measurements of
+// the devices of the group. The measurement query parameter (which can be
+// repeated) selects the measurements to aggregate, by default all
+// measurements are aggregated. The window query parameter (e.g. 1h) sets
+// the aggregation window, by default 24h.
+func (a *DeviceGroupAPI) GetMetrics(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	q := r.URL.Query()
+
+	window := deviceGroupDefaultWindow
+	if v := q.Get("window"); v != "" {
+		var err error
+		window, err = time.ParseDuration(v)
+		if err != nil || window <= 0 || window > deviceGroupMaxWindow {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "window must be a duration between 0s and %s", deviceGroupMaxWindow))
+			return
+		}
+	}
+
+	measurements := q["measurement"]
+	if len(measurements) > deviceGroupMaxMeasurements {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "max. number of measurements is %d", deviceGroupMaxMeasurements))
+		return
+	}
+
+	g, err := a.getDeviceGroup(ctx, r, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	app, err := storage.GetApplication(ctx, storage.DB(), g.ApplicationID)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	end := time.Now()
+	start := end.Add(-window)
+
+	st, err := storage.GetDeviceGroupStatus(ctx, storage.DB(), g, start)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var items []storage.DeviceGroupMeasurement
+	err = storage.ForOrganization(ctx, storage.DB(), app.OrganizationID, func(db sqlx.Ext) error {
+		var err error
+		items, err = storage.GetDeviceGroupMeasurements(ctx, db, g, start, end, measurements)
+		return err
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := GetDeviceGroupMetricsResponse{
+		Start: start,
+		End:   end,
+		Status: DeviceGroupStatus{
+			Devices:         st.Devices,
+			ActiveDevices:   st.ActiveDevices,
+			LastSeenAt:      st.LastSeenAt,
+			MinBatteryLevel: st.MinBatteryLevel,
+			AvgBatteryLevel: st.AvgBatteryLevel,
+		},
+		Measurements: []DeviceGroupMeasurement{},
+	}
+	for _, m := range items {
+		resp.Measurements = append(resp.Measurements, DeviceGroupMeasurement{
+			Name:     m.Name,
+			Devices:  m.Devices,
+			Count:    m.Count,
+			Min:      m.Min,
+			Max:      m.Max,
+			Avg:      m.Avg,
+			Latest:   m.Latest,
+			LatestAt: m.LatestAt,
+		})
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// getDeviceGroup validates that the client has the requested access to the
+// device group of the id route variable and returns it. The access is
+// validated before the group is fetched, so that unauthorized clients can't
+// tell which groups exist.
+func (a *DeviceGroupAPI) getDeviceGroup(ctx context.Context, r *http.Request, flag auth.Flag) (storage.DeviceGroup, error) {
+	id, err := httpUUIDVar(r, "id")
+	if err != nil {
+		return storage.DeviceGroup{}, err
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateDeviceGroupAccess(id, flag),
+	); err != nil {
+		return storage.DeviceGroup{}, err
+	}
+
+	return storage.GetDeviceGroup(ctx, storage.DB(), id)
+}
+
+// getDeviceGroupDevice returns the device group and the device of the id and
+// dev_eui route variables, validating that the client has update access to
+// the application of the group and that the device belongs to it.
+func (a *DeviceGroupAPI) getDeviceGroupDevice(ctx context.Context, r *http.Request) (storage.DeviceGroup, lorawan.EUI64, error) {
+	devEUI, err := httpEUI64Var(r, "dev_eui")
+	if err != nil {
+		return storage.DeviceGroup{}, devEUI, err
+	}
+
+	g, err := a.getDeviceGroup(ctx, r, auth.Update)
+	if err != nil {
+		return g, devEUI, err
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateNodeAccess(devEUI, auth.Read),
+	); err != nil {
+		return g, devEUI, err
+	}
+
+	d, err := storage.GetDevice(ctx, storage.DB(), devEUI, false, true)
+	if err != nil {
+		return g, devEUI, err
+	}
+
+	if d.ApplicationID != g.ApplicationID {
+		return g, devEUI, grpc.Errorf(codes.InvalidArgument, "device and device group must be under the same application")
+	}
+
+	return g, devEUI, nil
+}
+
+// getDeviceGroupBulkDevices returns the devices of the group for a bulk
+// operation. An error is returned when the group holds more than
+// deviceGroupBulkMaxDevices devices.
+func getDeviceGroupBulkDevices(ctx context.Context, g storage.DeviceGroup) ([]storage.Device, error) {
+	devices, err := storage.GetDeviceGroupDevices(ctx, storage.DB(), g, deviceGroupBulkMaxDevices+1, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(devices) > deviceGroupBulkMaxDevices {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "bulk operations are limited to groups of max. %d devices", deviceGroupBulkMaxDevices)
+	}
+
+	return devices, nil
+}
+
+func newDeviceGroupOperationResponse(total int) DeviceGroupOperationResponse {
+	return DeviceGroupOperationResponse{
+		Total:  total,
+		Result: make([]DeviceGroupOperationResult, 0, total),
+	}
+}
+
+// add adds the result of the operation for a single device. When err is
+// set, the result is recorded as failed.
+func (r *DeviceGroupOperationResponse) add(res DeviceGroupOperationResult, err error) {
+	if err != nil {
+		res.Error = status.Convert(helpers.ErrToRPCError(err)).Message()
+		r.Failed++
+	}
+
+	r.Result = append(r.Result, res)
+}
+
+func deviceGroupToStorage(in DeviceGroup, out *storage.DeviceGroup) {
+	out.Name = in.Name
+	out.Description = in.Description
+	out.Type = in.Type
+	out.DeviceTags = hstore.Hstore{
+		Map: make(map[string]sql.NullString),
+	}
+	for k, v := range in.DeviceTags {
+		out.DeviceTags.Map[k] = sql.NullString{String: v, Valid: true}
+	}
+}
+
+func deviceGroupFromStorage(g storage.DeviceGroup) DeviceGroup {
+	out := DeviceGroup{
+		ID:            g.ID.String(),
+		ApplicationID: g.ApplicationID,
+		Name:          g.Name,
+		Description:   g.Description,
+		Type:          g.Type,
+		DeviceTags:    make(map[string]string),
+		CreatedAt:     g.CreatedAt,
+		UpdatedAt:     g.UpdatedAt,
+	}
+
+	for k, v := range g.DeviceTags.Map {
+		if v.Valid {
+			out.DeviceTags[k] = v.String
+		}
+	}
+
+	return out
+}
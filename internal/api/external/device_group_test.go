@@ -0,0 +1,346 @@
+package external
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq/hstore"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	//"github.com/ibrahimozekici/lora-api/go/v3/ns"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *APITestSuite) TestDeviceGroup() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	nsClient.GetNextDownlinkFCntForDevEUIResponse = ns.GetNextDownlinkFCntForDevEUIResponse{
+		FCnt: 7,
+	}
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewDeviceGroupAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	otherOrg := storage.Organization{
+		Name: "other-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &otherOrg))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	var dpIDs []uuid.UUID
+	for _, orgID := range []int64{org.ID, org.ID, otherOrg.ID} {
+		dp := storage.DeviceProfile{
+			Name:            "test-dp",
+			NetworkServerID: n.ID,
+			OrganizationID:  orgID,
+		}
+		assert.NoError(storage.CreateDeviceProfile(context.Background(), storage.DB(), &dp))
+		dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+		assert.NoError(err)
+		dpIDs = append(dpIDs, dpID)
+	}
+
+	app := storage.Application{
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+		Name:             "test-app",
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	devices := []storage.Device{
+		{DevEUI: lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 1}, Name: "valve-1"},
+		{DevEUI: lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 2}, Name: "valve-2"},
+		{DevEUI: lorawan.EUI64{2, 2, 2, 2, 2, 2, 2, 1}, Name: "sensor-1"},
+	}
+	for i := range devices {
+		devices[i].ApplicationID = app.ID
+		devices[i].DeviceProfileID = dpIDs[0]
+		devices[i].DevAddr = lorawan.DevAddr{1, 2, 3, byte(i)}
+		devices[i].Tags = hstore.Hstore{
+			Map: map[string]sql.NullString{
+				"type": {String: devices[i].Name[:len(devices[i].Name)-2], Valid: true},
+			},
+		}
+		assert.NoError(storage.CreateDevice(context.Background(), storage.DB(), &devices[i]))
+	}
+
+	var manualID, tagID string
+
+	ts.T().Run("Create invalid", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/device-groups", app.ID), CreateDeviceGroupRequest{
+			DeviceGroup: DeviceGroup{
+				Name: "valves",
+				Type: storage.DeviceGroupTypeTag,
+			},
+		})
+		assert.Equal(http.StatusBadRequest, rec.Code)
+	})
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/device-groups", app.ID), CreateDeviceGroupRequest{
+			DeviceGroup: DeviceGroup{
+				Name: "pilot",
+				Type: storage.DeviceGroupTypeManual,
+			},
+		})
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp CreateDeviceGroupResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		manualID = resp.ID
+
+		rec = httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/device-groups", app.ID), CreateDeviceGroupRequest{
+			DeviceGroup: DeviceGroup{
+				Name:       "valves",
+				Type:       storage.DeviceGroupTypeTag,
+				DeviceTags: map[string]string{"type": "valve"},
+			},
+		})
+		assert.Equal(http.StatusOK, rec.Code)
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		tagID = resp.ID
+	})
+
+	ts.T().Run("Get", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "GET", "/api/device-groups/"+tagID, nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp GetDeviceGroupResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(app.ID, resp.DeviceGroup.ApplicationID)
+		assert.Equal("valves", resp.DeviceGroup.Name)
+		assert.Equal(map[string]string{"type": "valve"}, resp.DeviceGroup.DeviceTags)
+		assert.Equal(2, resp.Devices)
+
+		rec = httpTestRequest(r, "GET", "/api/device-groups/"+uuid.Must(uuid.NewV4()).String(), nil)
+		assert.Equal(http.StatusNotFound, rec.Code)
+	})
+
+	ts.T().Run("List", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "GET", fmt.Sprintf("/api/applications/%d/device-groups?limit=10", app.ID), nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp ListDeviceGroupsResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(2, resp.TotalCount)
+		assert.Len(resp.Result, 2)
+		assert.Equal("pilot", resp.Result[0].Name)
+		assert.Equal("valves", resp.Result[1].Name)
+	})
+
+	ts.T().Run("Devices", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "POST", "/api/device-groups/"+manualID+"/devices/"+devices[2].DevEUI.String(), nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		rec = httpTestRequest(r, "POST", "/api/device-groups/"+tagID+"/devices/"+devices[2].DevEUI.String(), nil)
+		assert.Equal(http.StatusBadRequest, rec.Code)
+
+		rec = httpTestRequest(r, "POST", "/api/device-groups/"+manualID+"/devices/0303030303030303", nil)
+		assert.Equal(http.StatusNotFound, rec.Code)
+
+		rec = httpTestRequest(r, "GET", "/api/device-groups/"+manualID+"/devices?limit=10", nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp ListDeviceGroupDevicesResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(1, resp.TotalCount)
+		assert.Equal(devices[2].DevEUI.String(), resp.Result[0].DevEUI)
+		assert.Equal(map[string]string{"type": "sensor"}, resp.Result[0].Tags)
+
+		t.Run("Remove", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "DELETE", "/api/device-groups/"+manualID+"/devices/"+devices[2].DevEUI.String(), nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			rec = httpTestRequest(r, "DELETE", "/api/device-groups/"+manualID+"/devices/"+devices[2].DevEUI.String(), nil)
+			assert.Equal(http.StatusNotFound, rec.Code)
+		})
+	})
+
+	ts.T().Run("Downlink", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "POST", "/api/device-groups/"+tagID+"/downlink", DeviceGroupDownlinkRequest{
+			FPort: 10,
+		})
+		assert.Equal(http.StatusBadRequest, rec.Code)
+
+		rec = httpTestRequest(r, "POST", "/api/device-groups/"+tagID+"/downlink", DeviceGroupDownlinkRequest{
+			FPort: 10,
+			Data:  []byte{1, 2, 3},
+		})
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp DeviceGroupOperationResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(2, resp.Total)
+		assert.Equal(0, resp.Failed)
+		assert.Len(resp.Result, 2)
+		assert.Equal(devices[0].DevEUI.String(), resp.Result[0].DevEUI)
+		assert.EqualValues(7, resp.Result[0].FCnt)
+		assert.NotEqual("", resp.Result[0].CorrelationID)
+
+		for _, d := range devices[:2] {
+			req := <-nsClient.CreateDeviceQueueItemChan
+			assert.Equal(d.DevEUI[:], req.Item.DevEui)
+			assert.EqualValues(10, req.Item.FPort)
+		}
+	})
+
+	ts.T().Run("Update tags", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "POST", "/api/device-groups/"+tagID+"/tags", DeviceGroupTagsRequest{
+			Set: map[string]string{"zone": "north"},
+		})
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp DeviceGroupOperationResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(2, resp.Total)
+		assert.Equal(0, resp.Failed)
+
+		d, err := storage.GetDevice(context.Background(), storage.DB(), devices[1].DevEUI, false, true)
+		assert.NoError(err)
+		assert.Equal("north", d.Tags.Map["zone"].String)
+		assert.Equal("valve", d.Tags.Map["type"].String)
+
+		d, err = storage.GetDevice(context.Background(), storage.DB(), devices[2].DevEUI, false, true)
+		assert.NoError(err)
+		_, ok := d.Tags.Map["zone"]
+		assert.False(ok)
+
+		rec = httpTestRequest(r, "POST", "/api/device-groups/"+tagID+"/tags", DeviceGroupTagsRequest{
+			Remove: []string{"zone"},
+		})
+		assert.Equal(http.StatusOK, rec.Code)
+
+		d, err = storage.GetDevice(context.Background(), storage.DB(), devices[1].DevEUI, false, true)
+		assert.NoError(err)
+		_, ok = d.Tags.Map["zone"]
+		assert.False(ok)
+	})
+
+	ts.T().Run("Update device-profile", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "POST", "/api/device-groups/"+tagID+"/device-profile", DeviceGroupDeviceProfileRequest{
+			DeviceProfileID: dpIDs[2].String(),
+		})
+		assert.Equal(http.StatusBadRequest, rec.Code)
+
+		rec = httpTestRequest(r, "POST", "/api/device-groups/"+tagID+"/device-profile", DeviceGroupDeviceProfileRequest{
+			DeviceProfileID: dpIDs[1].String(),
+		})
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp DeviceGroupOperationResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(2, resp.Total)
+		assert.Equal(0, resp.Failed)
+
+		for _, dev := range devices[:2] {
+			d, err := storage.GetDevice(context.Background(), storage.DB(), dev.DevEUI, false, true)
+			assert.NoError(err)
+			assert.Equal(dpIDs[1], d.DeviceProfileID)
+
+			req := <-nsClient.UpdateDeviceChan
+			assert.Equal(dpIDs[1].Bytes(), req.Device.DeviceProfileId)
+		}
+
+		d, err := storage.GetDevice(context.Background(), storage.DB(), devices[2].DevEUI, false, true)
+		assert.NoError(err)
+		assert.Equal(dpIDs[0], d.DeviceProfileID)
+	})
+
+	ts.T().Run("Metrics", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "GET", "/api/device-groups/"+tagID+"/metrics?window=1y", nil)
+		assert.Equal(http.StatusBadRequest, rec.Code)
+
+		rec = httpTestRequest(r, "GET", "/api/device-groups/"+tagID+"/metrics?window=1h&measurement=pressure", nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp GetDeviceGroupMetricsResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(2, resp.Status.Devices)
+		assert.Equal(0, resp.Status.ActiveDevices)
+		assert.Len(resp.Measurements, 0)
+	})
+
+	ts.T().Run("Update", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "PUT", "/api/device-groups/"+manualID, UpdateDeviceGroupRequest{
+			DeviceGroup: DeviceGroup{
+				Name:        "pilot",
+				Description: "sensors of the pilot",
+				Type:        storage.DeviceGroupTypeTag,
+				DeviceTags:  map[string]string{"type": "sensor"},
+			},
+		})
+		assert.Equal(http.StatusOK, rec.Code)
+
+		rec = httpTestRequest(r, "GET", "/api/device-groups/"+manualID, nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp GetDeviceGroupResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal("sensors of the pilot", resp.DeviceGroup.Description)
+		assert.Equal(storage.DeviceGroupTypeTag, resp.DeviceGroup.Type)
+		assert.Equal(1, resp.Devices)
+	})
+
+	ts.T().Run("Delete", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "DELETE", "/api/device-groups/"+manualID, nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		rec = httpTestRequest(r, "DELETE", "/api/device-groups/"+manualID, nil)
+		assert.Equal(http.StatusNotFound, rec.Code)
+	})
+}
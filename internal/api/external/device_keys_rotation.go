@@ -0,0 +1,317 @@
+package external
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+// deviceKeysRotationLogLimit defines the number of rotation log entries
+// returned.
+const deviceKeysRotationLogLimit = 100
+
+// StageDeviceKeysRequest defines the stage device-keys request. The AppKey
+// is only used by LoRaWAN 1.1 devices, for LoRaWAN 1.0.x devices the AppKey
+// must be set as NwkKey.
+type StageDeviceKeysRequest struct {
+	NwkKey    string `json:"nwkKey"`
+	AppKey    string `json:"appKey"`
+	GenAppKey string `json:"genAppKey"`
+}
+
+// StageApplicationDeviceKeysRequest defines the request for staging the keys
+// of multiple devices of an application.
+type StageApplicationDeviceKeysRequest struct {
+	Devices []StageApplicationDeviceKeysItem `json:"devices"`
+}
+
+// StageApplicationDeviceKeysItem defines the keys to stage for a device.
+type StageApplicationDeviceKeysItem struct {
+	DevEUI string `json:"devEUI"`
+	StageDeviceKeysRequest
+}
+
+// StageApplicationDeviceKeysResponse defines the response for staging the
+// keys of multiple devices of an application.
+type StageApplicationDeviceKeysResponse struct {
+	Staged int `json:"staged"`
+}
+
+// DeviceKeysRotationLogItem defines a device-keys rotation log entry.
+type DeviceKeysRotationLogItem struct {
+	CreatedAt time.Time `json:"createdAt"`
+	Action    string    `json:"action"`
+	InvokedBy string    `json:"invokedBy"`
+}
+
+// GetDeviceKeysRotationResponse defines the device-keys rotation status.
+// StagedAt is set when keys are staged which become effective on the next
+// join of the device using these keys. RollbackAvailable is set when the
+// keys replaced by the last rotation can be restored.
+type GetDeviceKeysRotationResponse struct {
+	StagedAt          *time.Time                  `json:"stagedAt"`
+	RollbackAvailable bool                        `json:"rollbackAvailable"`
+	Log               []DeviceKeysRotationLogItem `json:"log"`
+}
+
+// DeviceKeysRotationAPI exports the device-keys rotation related functions.
+type DeviceKeysRotationAPI struct {
+	validator auth.Validator
+}
+
+// NewDeviceKeysRotationAPI creates a new DeviceKeysRotationAPI.
+func NewDeviceKeysRotationAPI(validator auth.Validator) *DeviceKeysRotationAPI {
+	return &DeviceKeysRotationAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *DeviceKeysRotationAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/devices/{dev_eui}/keys/rotation", a.Get).Methods("GET")
+	r.HandleFunc("/api/devices/{dev_eui}/keys/rotation", a.Stage).Methods("POST")
+	r.HandleFunc("/api/devices/{dev_eui}/keys/rotation", a.Cancel).Methods("DELETE")
+	r.HandleFunc("/api/devices/{dev_eui}/keys/rotation/rollback", a.Rollback).Methods("POST")
+	r.HandleFunc("/api/applications/{id}/keys/rotation", a.StageForApplication).Methods("POST")
+}
+
+// Get returns the rotation status and audit log of the device-keys.
+func (a *DeviceKeysRotationAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpValidateDevice(httpContext(r), r, a.validator, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	status, err := storage.GetDeviceKeysRotationStatus(ctx, storage.DB(), devEUI)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	entries, err := storage.GetDeviceKeysRotationLog(ctx, storage.DB(), devEUI, deviceKeysRotationLogLimit)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := GetDeviceKeysRotationResponse{
+		StagedAt:          status.StagedAt,
+		RollbackAvailable: status.PreviousAt != nil,
+		Log:               make([]DeviceKeysRotationLogItem, 0, len(entries)),
+	}
+
+	for _, e := range entries {
+		resp.Log = append(resp.Log, DeviceKeysRotationLogItem{
+			CreatedAt: e.CreatedAt,
+			Action:    e.Action,
+			InvokedBy: e.InvokedBy,
+		})
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// Stage stages new keys for the device, which become effective on the next
+// join of the device using these keys.
+func (a *DeviceKeysRotationAPI) Stage(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpValidateDevice(httpContext(r), r, a.validator, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req StageDeviceKeysRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	dk, err := req.deviceKeys(devEUI)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	invokedBy, err := getInvokedBy(ctx, a.validator)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		return storage.StageDeviceKeys(ctx, tx, dk, invokedBy)
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// Cancel deletes the staged keys of the device.
+func (a *DeviceKeysRotationAPI) Cancel(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpValidateDevice(httpContext(r), r, a.validator, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	invokedBy, err := getInvokedBy(ctx, a.validator)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		return storage.CancelStagedDeviceKeys(ctx, tx, devEUI, invokedBy)
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// Rollback restores the keys that were replaced by the last rotation of the
+// device.
+func (a *DeviceKeysRotationAPI) Rollback(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpValidateDevice(httpContext(r), r, a.validator, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	invokedBy, err := getInvokedBy(ctx, a.validator)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		return storage.RollbackDeviceKeys(ctx, tx, devEUI, invokedBy)
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// StageForApplication stages new keys for the given devices of the
+// application. Either the keys of all devices are staged or none.
+func (a *DeviceKeysRotationAPI) StageForApplication(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "id: %s", err))
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(id, auth.Update),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req StageApplicationDeviceKeysRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var keys []storage.DeviceKeys
+	for _, item := range req.Devices {
+		var devEUI lorawan.EUI64
+		if err := devEUI.UnmarshalText([]byte(item.DevEUI)); err != nil {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "dev_eui: %s", err))
+			return
+		}
+
+		dk, err := item.deviceKeys(devEUI)
+		if err != nil {
+			httpWriteError(w, err)
+			return
+		}
+		keys = append(keys, dk)
+	}
+
+	invokedBy, err := getInvokedBy(ctx, a.validator)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		for _, dk := range keys {
+			d, err := storage.GetDevice(ctx, tx, dk.DevEUI, false, true)
+			if err != nil {
+				return errors.Wrapf(err, "get device error, dev_eui: %s", dk.DevEUI)
+			}
+			if d.ApplicationID != id {
+				return grpc.Errorf(codes.InvalidArgument, "device %s does not belong to application %d", dk.DevEUI, id)
+			}
+
+			if err := storage.StageDeviceKeys(ctx, tx, dk, invokedBy); err != nil {
+				return errors.Wrapf(err, "stage device-keys error, dev_eui: %s", dk.DevEUI)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, StageApplicationDeviceKeysResponse{
+		Staged: len(keys),
+	})
+}
+
+func (req StageDeviceKeysRequest) deviceKeys(devEUI lorawan.EUI64) (storage.DeviceKeys, error) {
+	dk := storage.DeviceKeys{
+		DevEUI: devEUI,
+	}
+
+	if err := dk.NwkKey.UnmarshalText([]byte(req.NwkKey)); err != nil {
+		return dk, grpc.Errorf(codes.InvalidArgument, "nwkKey: %s", err)
+	}
+
+	// appKey is not used for LoRaWAN 1.0
+	if req.AppKey != "" {
+		if err := dk.AppKey.UnmarshalText([]byte(req.AppKey)); err != nil {
+			return dk, grpc.Errorf(codes.InvalidArgument, "appKey: %s", err)
+		}
+	}
+
+	if req.GenAppKey != "" {
+		if err := dk.GenAppKey.UnmarshalText([]byte(req.GenAppKey)); err != nil {
+			return dk, grpc.Errorf(codes.InvalidArgument, "genAppKey: %s", err)
+		}
+	}
+
+	return dk, nil
+}
@@ -0,0 +1,129 @@
+package external
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/linkquality"
+	"github.com/ibrahimozekici/app-server2/internal/region"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+// linkQualityMaxFrames defines the max. number of frames which are analyzed
+// by a single request.
+const linkQualityMaxFrames = 10000
+
+// linkQualityDefaultRange defines the default time range of the analysis.
+const linkQualityDefaultRange = 7 * 24 * time.Hour
+
+// GetDeviceLinkQualityResponse defines the device link-quality response.
+type GetDeviceLinkQualityResponse struct {
+	Report linkquality.Report `json:"report"`
+}
+
+// DeviceLinkQualityAPI exports the device link-quality related functions.
+type DeviceLinkQualityAPI struct {
+	validator auth.Validator
+}
+
+// NewDeviceLinkQualityAPI creates a new DeviceLinkQualityAPI.
+func NewDeviceLinkQualityAPI(validator auth.Validator) *DeviceLinkQualityAPI {
+	return &DeviceLinkQualityAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *DeviceLinkQualityAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/devices/{dev_eui}/link-quality", a.Get).Methods("GET")
+}
+
+// Get returns the link-quality report of the given device, based on the
+// frames received within the requested time range (start and end as RFC3339
+// timestamps). When not set, the frames of the last 7 days are analyzed.
+func (a *DeviceLinkQualityAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpEUI64Var(r, "dev_eui")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateNodeAccess(devEUI, auth.Read),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	end := time.Now()
+	start := end.Add(-linkQualityDefaultRange)
+
+	q := r.URL.Query()
+	if v := q.Get("start"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "start: %s", err))
+			return
+		}
+		start = t
+	}
+	if v := q.Get("end"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "end: %s", err))
+			return
+		}
+		end = t
+	}
+
+	var frames []storage.DeviceFrameLog
+	err = storage.ForDeviceOrganization(ctx, storage.DB(), devEUI, func(db sqlx.Ext) error {
+		var err error
+		frames, err = storage.GetDeviceFrameLogs(ctx, db, devEUI, start, end, linkQualityMaxFrames)
+		return err
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var opts linkquality.Options
+
+	// without the region, the link margin can not be calculated but the
+	// other statistics are still available
+	n, err := storage.GetNetworkServerForDevEUI(ctx, storage.DB(), devEUI)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+	if reg, err := region.GetOrSync(ctx, storage.DB(), n.ID); err != nil {
+		log.WithError(err).WithField("dev_eui", devEUI).Warning("api/external: get network-server region error")
+	} else {
+		opts.SpreadFactors = make(map[int]int)
+		for _, dr := range reg.DataRates {
+			if dr.SpreadFactor != 0 {
+				opts.SpreadFactors[dr.DR] = dr.SpreadFactor
+			}
+		}
+	}
+
+	report, err := linkquality.Analyze(frames, opts)
+	if err != nil {
+		httpWriteError(w, grpc.Errorf(codes.Internal, "analyze link-quality error: %s", err))
+		return
+	}
+
+	httpWriteJSON(w, GetDeviceLinkQualityResponse{
+		Report: report,
+	})
+}
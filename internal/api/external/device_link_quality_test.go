@@ -0,0 +1,46 @@
+package external
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func (ts *APITestSuite) TestDeviceLinkQuality() {
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewDeviceLinkQualityAPI(validator).Register(r)
+
+	tests := []struct {
+		Name         string
+		Path         string
+		ExpectedCode int
+	}{
+		{
+			Name:         "invalid DevEUI",
+			Path:         "/api/devices/foo/link-quality",
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			Name:         "invalid time range",
+			Path:         "/api/devices/0102030405060708/link-quality?start=yesterday",
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			Name:         "device does not exist",
+			Path:         "/api/devices/0102030405060708/link-quality",
+			ExpectedCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tst := range tests {
+		ts.T().Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "GET", tst.Path, nil)
+			assert.Equal(tst.ExpectedCode, rec.Code)
+		})
+	}
+}
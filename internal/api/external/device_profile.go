@@ -47,6 +47,10 @@ func (a *DeviceProfileServiceAPI) Create(ctx context.Context, req *pb.CreateDevi
 		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
 	}
 
+	if err := codec.ValidateDecoderScript(codec.Type(req.DeviceProfile.PayloadCodec), req.DeviceProfile.PayloadDecoderScript); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "payloadDecoderScript: %s", err)
+	}
+
 	var err error
 	var uplinkInterval time.Duration
 	if req.DeviceProfile.UplinkInterval != nil {
@@ -177,6 +181,8 @@ func (a *DeviceProfileServiceAPI) Get(ctx context.Context, req *pb.GetDeviceProf
 		resp.DeviceProfile.Tags[k] = v.String
 	}
 
+	helpers.SetVersionHeader(ctx, dp.UpdatedAt)
+
 	return &resp, nil
 }
 
@@ -197,6 +203,10 @@ func (a *DeviceProfileServiceAPI) Update(ctx context.Context, req *pb.UpdateDevi
 		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
 	}
 
+	if err := codec.ValidateDecoderScript(codec.Type(req.DeviceProfile.PayloadCodec), req.DeviceProfile.PayloadDecoderScript); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "payloadDecoderScript: %s", err)
+	}
+
 	// As this also performs a remote call to update the device-profile
 	// on the network-server, wrap it in a transaction.
 	// This also locks the local device-profile record in the database.
@@ -206,6 +216,10 @@ func (a *DeviceProfileServiceAPI) Update(ctx context.Context, req *pb.UpdateDevi
 			return err
 		}
 
+		if err := helpers.CheckVersion(ctx, dp.UpdatedAt); err != nil {
+			return err
+		}
+
 		var uplinkInterval time.Duration
 		if req.DeviceProfile.UplinkInterval != nil {
 			uplinkInterval, err = ptypes.Duration(req.DeviceProfile.UplinkInterval)
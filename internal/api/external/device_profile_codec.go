@@ -0,0 +1,195 @@
+package external
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq/hstore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/codec"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// codecTestMaxSamples defines the max. number of uplink + downlink samples
+// of a single codec test request.
+const codecTestMaxSamples = 50
+
+// CodecTestUplink defines an uplink sample to decode.
+type CodecTestUplink struct {
+	FPort uint8  `json:"fPort"`
+	Data  []byte `json:"data"`
+}
+
+// CodecTestDownlink defines a downlink sample (object) to encode.
+type CodecTestDownlink struct {
+	FPort  uint8           `json:"fPort"`
+	Object json.RawMessage `json:"object"`
+}
+
+// TestDeviceProfileCodecRequest defines the codec test request.
+//
+// When PayloadCodec is set, the given codec and scripts are tested instead
+// of the codec configured in the device-profile, such that a codec can be
+// tested before it is saved.
+type TestDeviceProfileCodecRequest struct {
+	PayloadCodec         codec.Type          `json:"payloadCodec"`
+	PayloadDecoderScript string              `json:"payloadDecoderScript"`
+	PayloadEncoderScript string              `json:"payloadEncoderScript"`
+	Variables            map[string]string   `json:"variables"`
+	Uplinks              []CodecTestUplink   `json:"uplinks"`
+	Downlinks            []CodecTestDownlink `json:"downlinks"`
+}
+
+// CodecTestUplinkResult defines the decode result of an uplink sample.
+type CodecTestUplinkResult struct {
+	FPort  uint8           `json:"fPort"`
+	Object json.RawMessage `json:"object,omitempty"`
+	Logs   []string        `json:"logs"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// CodecTestDownlinkResult defines the encode result of a downlink sample.
+type CodecTestDownlinkResult struct {
+	FPort uint8    `json:"fPort"`
+	Data  []byte   `json:"data,omitempty"`
+	Logs  []string `json:"logs"`
+	Error string   `json:"error,omitempty"`
+}
+
+// TestDeviceProfileCodecResponse defines the codec test response.
+type TestDeviceProfileCodecResponse struct {
+	Uplinks   []CodecTestUplinkResult   `json:"uplinks"`
+	Downlinks []CodecTestDownlinkResult `json:"downlinks"`
+}
+
+// DeviceProfileCodecAPI exports the device-profile codec related functions.
+type DeviceProfileCodecAPI struct {
+	validator auth.Validator
+}
+
+// NewDeviceProfileCodecAPI creates a new DeviceProfileCodecAPI.
+func NewDeviceProfileCodecAPI(validator auth.Validator) *DeviceProfileCodecAPI {
+	return &DeviceProfileCodecAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *DeviceProfileCodecAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/device-profiles/{id}/codec/test", a.Test).Methods("POST")
+}
+
+// Test runs the decoder and encoder of the given device-profile against the
+// given samples and returns the output, the logged messages and errors.
+// The samples are not related to any device, nothing is enqueued or
+// forwarded to the integrations.
+func (a *DeviceProfileCodecAPI) Test(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	id, err := httpUUIDVar(r, "id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req TestDeviceProfileCodecRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	// testing scripts other than the stored scripts is restricted to the
+	// users which are able to update these
+	flag := auth.Read
+	if req.PayloadCodec != codec.None {
+		flag = auth.Update
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateDeviceProfileAccess(flag, id),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if len(req.Uplinks)+len(req.Downlinks) > codecTestMaxSamples {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "max. number of samples is %d", codecTestMaxSamples))
+		return
+	}
+
+	if req.PayloadCodec == codec.None {
+		dp, err := storage.GetDeviceProfile(ctx, storage.DB(), id, false, true)
+		if err != nil {
+			httpWriteError(w, err)
+			return
+		}
+
+		// use the codec which is used for the uplinks and downlinks, e.g.
+		// the current version of the codec library codec
+		c, err := storage.ResolvePayloadCodec(ctx, storage.DB(), storage.Application{}, dp, storage.Device{})
+		if err != nil {
+			httpWriteError(w, err)
+			return
+		}
+
+		req.PayloadCodec = c.Type
+		req.PayloadDecoderScript = c.DecoderScript
+		req.PayloadEncoderScript = c.EncoderScript
+	}
+
+	if req.PayloadCodec == codec.None {
+		httpWriteError(w, grpc.Errorf(codes.FailedPrecondition, "no payload codec configured"))
+		return
+	}
+
+	vars := hstore.Hstore{
+		Map: make(map[string]sql.NullString),
+	}
+	for k, v := range req.Variables {
+		vars.Map[k] = sql.NullString{String: v, Valid: true}
+	}
+
+	resp := TestDeviceProfileCodecResponse{
+		Uplinks:   make([]CodecTestUplinkResult, 0, len(req.Uplinks)),
+		Downlinks: make([]CodecTestDownlinkResult, 0, len(req.Downlinks)),
+	}
+
+	for _, ul := range req.Uplinks {
+		res := CodecTestUplinkResult{
+			FPort: ul.FPort,
+		}
+
+		b, logs, err := codec.BinaryToJSONWithLogs(req.PayloadCodec, ul.FPort, vars, req.PayloadDecoderScript, ul.Data)
+		res.Logs = logs
+		if err != nil {
+			res.Error = err.Error()
+		} else {
+			res.Object = json.RawMessage(b)
+		}
+
+		resp.Uplinks = append(resp.Uplinks, res)
+	}
+
+	for _, dl := range req.Downlinks {
+		res := CodecTestDownlinkResult{
+			FPort: dl.FPort,
+		}
+
+		b, logs, err := codec.JSONToBinaryWithLogs(req.PayloadCodec, dl.FPort, vars, req.PayloadEncoderScript, []byte(dl.Object))
+		res.Logs = logs
+		if err != nil {
+			res.Error = err.Error()
+		} else {
+			res.Data = b
+		}
+
+		resp.Downlinks = append(resp.Downlinks, res)
+	}
+
+	httpWriteJSON(w, resp)
+}
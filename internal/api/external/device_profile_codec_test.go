@@ -0,0 +1,180 @@
+package external
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/codec"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+func (ts *APITestSuite) TestDeviceProfileCodec() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewDeviceProfileCodecAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	dp := storage.DeviceProfile{
+		Name:            "test-dp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+		PayloadCodec:    codec.CustomJSType,
+		PayloadDecoderScript: `
+			function Decode(fPort, bytes, variables) {
+				console.log("decoding", bytes.length, "bytes");
+				if (bytes.length == 0) {
+					throw "empty payload";
+				}
+				return {"value": bytes[0] * parseInt(variables.factor)};
+			}
+		`,
+		PayloadEncoderScript: `
+			function Encode(fPort, obj) {
+				return [obj.value];
+			}
+		`,
+	}
+	assert.NoError(storage.CreateDeviceProfile(context.Background(), storage.DB(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	path := fmt.Sprintf("/api/device-profiles/%s/codec/test", dpID)
+
+	test := func(t *testing.T, req TestDeviceProfileCodecRequest, code int) TestDeviceProfileCodecResponse {
+		assert := require.New(t)
+
+		b, err := json.Marshal(req)
+		assert.NoError(err)
+
+		httpReq := httptest.NewRequest("POST", path, bytes.NewReader(b))
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httpReq)
+		assert.Equal(code, rec.Code)
+
+		var resp TestDeviceProfileCodecResponse
+		if code == http.StatusOK {
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		}
+		return resp
+	}
+
+	ts.T().Run("Stored codec", func(t *testing.T) {
+		assert := require.New(t)
+
+		resp := test(t, TestDeviceProfileCodecRequest{
+			Variables: map[string]string{"factor": "2"},
+			Uplinks: []CodecTestUplink{
+				{FPort: 10, Data: []byte{5}},
+				{FPort: 10, Data: []byte{}},
+			},
+			Downlinks: []CodecTestDownlink{
+				{FPort: 20, Object: json.RawMessage(`{"value": 3}`)},
+			},
+		}, http.StatusOK)
+
+		assert.Equal([]CodecTestUplinkResult{
+			{
+				FPort:  10,
+				Object: json.RawMessage(`{"value":10}`),
+				Logs:   []string{"decoding 1 bytes"},
+			},
+			{
+				FPort: 10,
+				Logs:  []string{"decoding 0 bytes"},
+				Error: "execute js error: js vm error: empty payload",
+			},
+		}, resp.Uplinks)
+
+		assert.Equal([]CodecTestDownlinkResult{
+			{
+				FPort: 20,
+				Data:  []byte{3},
+			},
+		}, resp.Downlinks)
+	})
+
+	ts.T().Run("Given codec", func(t *testing.T) {
+		assert := require.New(t)
+
+		resp := test(t, TestDeviceProfileCodecRequest{
+			PayloadCodec: codec.CustomLuaType,
+			PayloadDecoderScript: `
+				function Decode(fPort, bytes)
+					return { value = bytes[1] + 1 }
+				end
+			`,
+			Uplinks: []CodecTestUplink{
+				{FPort: 10, Data: []byte{5}},
+			},
+		}, http.StatusOK)
+
+		assert.Len(resp.Uplinks, 1)
+		assert.Equal(json.RawMessage(`{"value":6}`), resp.Uplinks[0].Object)
+	})
+
+	ts.T().Run("Library codec", func(t *testing.T) {
+		assert := require.New(t)
+
+		c := storage.Codec{
+			OrganizationID: org.ID,
+			Name:           "test-codec",
+			PayloadCodec:   codec.CustomJSType,
+		}
+		assert.NoError(storage.CreateCodec(context.Background(), storage.DB(), &c))
+		assert.NoError(storage.PublishCodecVersion(context.Background(), storage.DB(), &storage.CodecVersion{
+			CodecID: c.ID,
+			DecoderScript: `
+				function Decode(fPort, bytes) {
+					return {"library": bytes[0]};
+				}
+			`,
+		}))
+		assert.NoError(storage.UpdateDeviceProfileCodecID(context.Background(), storage.DB(), dpID, &c.ID))
+
+		resp := test(t, TestDeviceProfileCodecRequest{
+			Uplinks: []CodecTestUplink{
+				{FPort: 10, Data: []byte{5}},
+			},
+		}, http.StatusOK)
+
+		assert.Len(resp.Uplinks, 1)
+		assert.Equal(json.RawMessage(`{"library":5}`), resp.Uplinks[0].Object)
+	})
+
+	ts.T().Run("Too many samples", func(t *testing.T) {
+		test(t, TestDeviceProfileCodecRequest{
+			Uplinks: make([]CodecTestUplink, codecTestMaxSamples+1),
+		}, http.StatusBadRequest)
+	})
+
+	ts.T().Run("Unknown device-profile", func(t *testing.T) {
+		path = fmt.Sprintf("/api/device-profiles/%s/codec/test", uuid.Must(uuid.NewV4()))
+		test(t, TestDeviceProfileCodecRequest{}, http.StatusNotFound)
+	})
+}
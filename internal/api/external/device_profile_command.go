@@ -0,0 +1,233 @@
+package external
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb //"github.com/ibrahimozekici/lora-api/go/v3/as/external/api"
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/downlink/command"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+// GetDeviceProfileCommandsResponse defines the get device-profile commands
+// response.
+type GetDeviceProfileCommandsResponse struct {
+	Commands command.Catalog `json:"commands"`
+}
+
+// UpdateDeviceProfileCommandsRequest defines the update device-profile
+// commands request.
+type UpdateDeviceProfileCommandsRequest struct {
+	Commands command.Catalog `json:"commands"`
+}
+
+// SendDeviceCommandRequest defines the send device command request.
+type SendDeviceCommandRequest struct {
+	Parameters map[string]json.RawMessage `json:"parameters"`
+}
+
+// SendDeviceCommandResponse defines the send device command response.
+type SendDeviceCommandResponse struct {
+	FCnt          uint32 `json:"fCnt"`
+	CorrelationID string `json:"correlationID"`
+}
+
+// DeviceProfileCommandAPI exports the device-profile command catalog related
+// functions.
+type DeviceProfileCommandAPI struct {
+	validator auth.Validator
+}
+
+// NewDeviceProfileCommandAPI creates a new DeviceProfileCommandAPI.
+func NewDeviceProfileCommandAPI(validator auth.Validator) *DeviceProfileCommandAPI {
+	return &DeviceProfileCommandAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *DeviceProfileCommandAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/device-profiles/{id}/commands", a.Get).Methods("GET")
+	r.HandleFunc("/api/device-profiles/{id}/commands", a.Update).Methods("PUT")
+	r.HandleFunc("/api/devices/{dev_eui}/commands", a.GetForDevice).Methods("GET")
+	r.HandleFunc("/api/devices/{dev_eui}/commands/{name}", a.Send).Methods("POST")
+}
+
+// Get returns the command catalog of the given device-profile.
+func (a *DeviceProfileCommandAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	id, err := httpUUIDVar(r, "id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateDeviceProfileAccess(auth.Read, id),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	dp, err := storage.GetDeviceProfile(ctx, storage.DB(), id, false, true)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, GetDeviceProfileCommandsResponse{
+		Commands: commandCatalog(dp.Commands),
+	})
+}
+
+// Update replaces the command catalog of the given device-profile.
+func (a *DeviceProfileCommandAPI) Update(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	id, err := httpUUIDVar(r, "id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateDeviceProfileAccess(auth.Update, id),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req UpdateDeviceProfileCommandsRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := req.Commands.Validate(); err != nil {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "commands: %s", err))
+		return
+	}
+
+	if err := storage.UpdateDeviceProfileCommands(ctx, storage.DB(), id, req.Commands); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// GetForDevice returns the command catalog of the device-profile of the
+// given device, e.g. for generating a command UI.
+func (a *DeviceProfileCommandAPI) GetForDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpEUI64Var(r, "dev_eui")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateNodeAccess(devEUI, auth.Read),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	d, err := storage.GetDevice(ctx, storage.DB(), devEUI, false, true)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	dp, err := storage.GetDeviceProfile(ctx, storage.DB(), d.DeviceProfileID, false, true)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, GetDeviceProfileCommandsResponse{
+		Commands: commandCatalog(dp.Commands),
+	})
+}
+
+// Send validates the given parameters against the command of the
+// device-profile of the device and enqueues the resulting object, which is
+// encoded by the payload encoder of the device.
+func (a *DeviceProfileCommandAPI) Send(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpEUI64Var(r, "dev_eui")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateDeviceQueueAccess(devEUI, auth.Create),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req SendDeviceCommandRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	d, err := storage.GetDevice(ctx, storage.DB(), devEUI, false, true)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	dp, err := storage.GetDeviceProfile(ctx, storage.DB(), d.DeviceProfileID, false, true)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	cmd, ok := dp.Commands.Get(name)
+	if !ok {
+		httpWriteError(w, grpc.Errorf(codes.NotFound, "command %s does not exist", name))
+		return
+	}
+
+	obj, err := cmd.Render(req.Parameters)
+	if err != nil {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "%s: %s", name, err))
+		return
+	}
+
+	fCnt, correlationID, err := enqueueDeviceQueueItem(ctx, devEUI, &pb.DeviceQueueItem{
+		DevEui:     devEUI.String(),
+		Confirmed:  cmd.Confirmed,
+		FPort:      uint32(cmd.FPort),
+		JsonObject: string(obj),
+	}, nil)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, SendDeviceCommandResponse{
+		FCnt:          fCnt,
+		CorrelationID: correlationID.String(),
+	})
+}
+
+func commandCatalog(c command.Catalog) command.Catalog {
+	if c == nil {
+		return command.Catalog{}
+	}
+	return c
+}
@@ -0,0 +1,181 @@
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	//"github.com/ibrahimozekici/lora-api/go/v3/ns"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/codec"
+	"github.com/ibrahimozekici/app-server2/internal/downlink/command"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *APITestSuite) TestDeviceProfileCommand() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	nsClient.GetNextDownlinkFCntForDevEUIResponse = ns.GetNextDownlinkFCntForDevEUIResponse{
+		FCnt: 12,
+	}
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewDeviceProfileCommandAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	dp := storage.DeviceProfile{
+		Name:            "test-dp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+		PayloadCodec:    codec.CustomJSType,
+		PayloadEncoderScript: `
+			function Encode(fPort, obj) {
+				return [obj.interval / 60, obj.led ? 1 : 0];
+			}
+		`,
+	}
+	assert.NoError(storage.CreateDeviceProfile(context.Background(), storage.DB(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	app := storage.Application{
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+		Name:             "test-app",
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	d := storage.Device{
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "test-node",
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		DevAddr:         lorawan.DevAddr{1, 2, 3, 4},
+		AppSKey:         lorawan.AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 1, 2, 3, 4, 5, 6, 7, 8},
+	}
+	assert.NoError(storage.CreateDevice(context.Background(), storage.DB(), &d))
+
+	min := float64(60)
+	catalog := command.Catalog{
+		{
+			Name:  "set-interval",
+			FPort: 10,
+			Parameters: []command.Parameter{
+				{Name: "interval", Type: command.Integer, Min: &min},
+				{Name: "led", Type: command.Boolean, Default: json.RawMessage(`false`)},
+			},
+			Object: `{"interval": "{{ interval }}", "led": "{{ led }}"}`,
+		},
+	}
+
+	ts.T().Run("Get not configured", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "GET", fmt.Sprintf("/api/device-profiles/%s/commands", dpID), nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp GetDeviceProfileCommandsResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(command.Catalog{}, resp.Commands)
+	})
+
+	ts.T().Run("Update invalid", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "PUT", fmt.Sprintf("/api/device-profiles/%s/commands", dpID), UpdateDeviceProfileCommandsRequest{
+			Commands: command.Catalog{{Name: "reboot", Object: `{}`}},
+		})
+		assert.Equal(http.StatusBadRequest, rec.Code)
+	})
+
+	ts.T().Run("Update", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "PUT", fmt.Sprintf("/api/device-profiles/%s/commands", dpID), UpdateDeviceProfileCommandsRequest{
+			Commands: catalog,
+		})
+		assert.Equal(http.StatusOK, rec.Code)
+
+		t.Run("Get for device", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "GET", fmt.Sprintf("/api/devices/%s/commands", d.DevEUI), nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			var resp GetDeviceProfileCommandsResponse
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal(catalog, resp.Commands)
+		})
+
+		t.Run("Send", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/devices/%s/commands/set-interval", d.DevEUI), SendDeviceCommandRequest{
+				Parameters: map[string]json.RawMessage{
+					"interval": json.RawMessage(`600`),
+				},
+			})
+			assert.Equal(http.StatusOK, rec.Code)
+
+			var resp SendDeviceCommandResponse
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.EqualValues(12, resp.FCnt)
+
+			b, err := lorawan.EncryptFRMPayload(d.AppSKey, false, d.DevAddr, 12, []byte{10, 0})
+			assert.NoError(err)
+
+			req := <-nsClient.CreateDeviceQueueItemChan
+			assert.EqualValues(10, req.Item.FPort)
+			assert.Equal(b, req.Item.FrmPayload)
+		})
+
+		t.Run("Send invalid parameter", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/devices/%s/commands/set-interval", d.DevEUI), SendDeviceCommandRequest{
+				Parameters: map[string]json.RawMessage{
+					"interval": json.RawMessage(`30`),
+				},
+			})
+			assert.Equal(http.StatusBadRequest, rec.Code)
+			assert.Len(nsClient.CreateDeviceQueueItemChan, 0)
+		})
+
+		t.Run("Send unknown command", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/devices/%s/commands/reboot", d.DevEUI), SendDeviceCommandRequest{})
+			assert.Equal(http.StatusNotFound, rec.Code)
+		})
+	})
+}
@@ -0,0 +1,110 @@
+package external
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// GetDeviceProfileConfirmedDownlinkRetryResponse defines the get
+// device-profile confirmed downlink retry response.
+type GetDeviceProfileConfirmedDownlinkRetryResponse struct {
+	Policy storage.ConfirmedDownlinkRetryPolicy `json:"policy"`
+}
+
+// UpdateDeviceProfileConfirmedDownlinkRetryRequest defines the update
+// device-profile confirmed downlink retry request.
+type UpdateDeviceProfileConfirmedDownlinkRetryRequest struct {
+	Policy storage.ConfirmedDownlinkRetryPolicy `json:"policy"`
+}
+
+// DeviceProfileConfirmedDownlinkRetryAPI exports the device-profile
+// confirmed downlink retry related functions.
+type DeviceProfileConfirmedDownlinkRetryAPI struct {
+	validator auth.Validator
+}
+
+// NewDeviceProfileConfirmedDownlinkRetryAPI creates a new DeviceProfileConfirmedDownlinkRetryAPI.
+func NewDeviceProfileConfirmedDownlinkRetryAPI(validator auth.Validator) *DeviceProfileConfirmedDownlinkRetryAPI {
+	return &DeviceProfileConfirmedDownlinkRetryAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *DeviceProfileConfirmedDownlinkRetryAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/device-profiles/{id}/confirmed-downlink-retry", a.Get).Methods("GET")
+	r.HandleFunc("/api/device-profiles/{id}/confirmed-downlink-retry", a.Update).Methods("PUT")
+}
+
+// Get returns the confirmed downlink retry policy of the given
+// device-profile.
+func (a *DeviceProfileConfirmedDownlinkRetryAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	id, err := httpUUIDVar(r, "id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateDeviceProfileAccess(auth.Read, id),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	dp, err := storage.GetDeviceProfile(ctx, storage.DB(), id, false, true)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, GetDeviceProfileConfirmedDownlinkRetryResponse{
+		Policy: dp.ConfirmedDownlinkRetry,
+	})
+}
+
+// Update replaces the confirmed downlink retry policy of the given
+// device-profile. A maxRetries of 0 disables the retry of confirmed
+// downlinks.
+func (a *DeviceProfileConfirmedDownlinkRetryAPI) Update(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	id, err := httpUUIDVar(r, "id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateDeviceProfileAccess(auth.Update, id),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req UpdateDeviceProfileConfirmedDownlinkRetryRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := req.Policy.Validate(); err != nil {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "policy: %s", err))
+		return
+	}
+
+	if err := storage.UpdateDeviceProfileConfirmedDownlinkRetry(ctx, storage.DB(), id, req.Policy); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
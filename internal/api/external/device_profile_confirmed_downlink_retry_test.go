@@ -0,0 +1,63 @@
+package external
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+func (ts *APITestSuite) TestDeviceProfileConfirmedDownlinkRetry() {
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewDeviceProfileConfirmedDownlinkRetryAPI(validator).Register(r)
+
+	_, dpID := createTestDeviceProfile(ts.T())
+
+	path := fmt.Sprintf("/api/device-profiles/%s/confirmed-downlink-retry", dpID)
+
+	get := func(t *testing.T) GetDeviceProfileConfirmedDownlinkRetryResponse {
+		var resp GetDeviceProfileConfirmedDownlinkRetryResponse
+		httpTestDecode(t, httpTestRequest(r, "GET", path, nil), http.StatusOK, &resp)
+		return resp
+	}
+
+	update := func(p storage.ConfirmedDownlinkRetryPolicy) int {
+		return httpTestRequest(r, "PUT", path, UpdateDeviceProfileConfirmedDownlinkRetryRequest{Policy: p}).Code
+	}
+
+	ts.T().Run("Get not configured", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Equal(storage.ConfirmedDownlinkRetryPolicy{}, get(t).Policy)
+	})
+
+	ts.T().Run("Update", func(t *testing.T) {
+		assert := require.New(t)
+
+		p := storage.ConfirmedDownlinkRetryPolicy{
+			MaxRetries:     3,
+			BackoffFrames:  2,
+			BackoffSeconds: 60,
+		}
+		assert.Equal(http.StatusOK, update(p))
+		assert.Equal(p, get(t).Policy)
+	})
+
+	ts.T().Run("Update invalid", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Equal(http.StatusBadRequest, update(storage.ConfirmedDownlinkRetryPolicy{
+			MaxRetries: -1,
+		}))
+	})
+
+	ts.T().Run("Remove", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.Equal(http.StatusOK, update(storage.ConfirmedDownlinkRetryPolicy{}))
+		assert.Equal(storage.ConfirmedDownlinkRetryPolicy{}, get(t).Policy)
+	})
+}
@@ -0,0 +1,108 @@
+package external
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/downlink/validation"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// GetDeviceProfileDownlinkValidationResponse defines the get device-profile
+// downlink validation response.
+type GetDeviceProfileDownlinkValidationResponse struct {
+	Rules validation.Rules `json:"rules"`
+}
+
+// UpdateDeviceProfileDownlinkValidationRequest defines the update
+// device-profile downlink validation request.
+type UpdateDeviceProfileDownlinkValidationRequest struct {
+	Rules validation.Rules `json:"rules"`
+}
+
+// DeviceProfileDownlinkValidationAPI exports the device-profile downlink
+// validation related functions.
+type DeviceProfileDownlinkValidationAPI struct {
+	validator auth.Validator
+}
+
+// NewDeviceProfileDownlinkValidationAPI creates a new DeviceProfileDownlinkValidationAPI.
+func NewDeviceProfileDownlinkValidationAPI(validator auth.Validator) *DeviceProfileDownlinkValidationAPI {
+	return &DeviceProfileDownlinkValidationAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *DeviceProfileDownlinkValidationAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/device-profiles/{id}/downlink-validation", a.Get).Methods("GET")
+	r.HandleFunc("/api/device-profiles/{id}/downlink-validation", a.Update).Methods("PUT")
+}
+
+// Get returns the downlink validation rules of the given device-profile.
+func (a *DeviceProfileDownlinkValidationAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	id, err := httpUUIDVar(r, "id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateDeviceProfileAccess(auth.Read, id),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	dp, err := storage.GetDeviceProfile(ctx, storage.DB(), id, false, true)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, GetDeviceProfileDownlinkValidationResponse{
+		Rules: dp.DownlinkValidation,
+	})
+}
+
+// Update replaces the downlink validation rules of the given device-profile.
+func (a *DeviceProfileDownlinkValidationAPI) Update(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	id, err := httpUUIDVar(r, "id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateDeviceProfileAccess(auth.Update, id),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req UpdateDeviceProfileDownlinkValidationRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := req.Rules.Validate(); err != nil {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "rules: %s", err))
+		return
+	}
+
+	if err := storage.UpdateDeviceProfileDownlinkValidation(ctx, storage.DB(), id, req.Rules); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
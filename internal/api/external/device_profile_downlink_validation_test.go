@@ -0,0 +1,64 @@
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/downlink/validation"
+)
+
+func (ts *APITestSuite) TestDeviceProfileDownlinkValidation() {
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewDeviceProfileDownlinkValidationAPI(validator).Register(r)
+
+	_, dpID := createTestDeviceProfile(ts.T())
+
+	path := fmt.Sprintf("/api/device-profiles/%s/downlink-validation", dpID)
+
+	get := func(t *testing.T) GetDeviceProfileDownlinkValidationResponse {
+		var resp GetDeviceProfileDownlinkValidationResponse
+		httpTestDecode(t, httpTestRequest(r, "GET", path, nil), http.StatusOK, &resp)
+		return resp
+	}
+
+	update := func(rules validation.Rules) int {
+		return httpTestRequest(r, "PUT", path, UpdateDeviceProfileDownlinkValidationRequest{Rules: rules}).Code
+	}
+
+	ts.T().Run("Get not configured", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Equal(validation.Rules{}, get(t).Rules)
+	})
+
+	ts.T().Run("Update", func(t *testing.T) {
+		assert := require.New(t)
+
+		rules := validation.Rules{
+			FPorts:         []int{10, 20},
+			Schema:         json.RawMessage(`{"type":"object","required":["mode"]}`),
+			MaxPayloadSize: 11,
+		}
+		assert.Equal(http.StatusOK, update(rules))
+		assert.Equal(rules, get(t).Rules)
+	})
+
+	ts.T().Run("Update invalid", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Equal(http.StatusBadRequest, update(validation.Rules{
+			FPorts: []int{0},
+		}))
+	})
+
+	ts.T().Run("Remove", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.Equal(http.StatusOK, update(validation.Rules{}))
+		assert.Equal(validation.Rules{}, get(t).Rules)
+	})
+}
@@ -0,0 +1,115 @@
+package external
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/measurement"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// GetDeviceProfileMeasurementsResponse defines the get device-profile
+// measurements response.
+type GetDeviceProfileMeasurementsResponse struct {
+	Measurements measurement.Definitions `json:"measurements"`
+}
+
+// UpdateDeviceProfileMeasurementsRequest defines the update device-profile
+// measurements request.
+type UpdateDeviceProfileMeasurementsRequest struct {
+	Measurements measurement.Definitions `json:"measurements"`
+}
+
+// DeviceProfileMeasurementAPI exports the device-profile measurement related
+// functions.
+type DeviceProfileMeasurementAPI struct {
+	validator auth.Validator
+}
+
+// NewDeviceProfileMeasurementAPI creates a new DeviceProfileMeasurementAPI.
+func NewDeviceProfileMeasurementAPI(validator auth.Validator) *DeviceProfileMeasurementAPI {
+	return &DeviceProfileMeasurementAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *DeviceProfileMeasurementAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/device-profiles/{id}/measurements", a.Get).Methods("GET")
+	r.HandleFunc("/api/device-profiles/{id}/measurements", a.Update).Methods("PUT")
+}
+
+// Get returns the measurement definitions of the given device-profile.
+func (a *DeviceProfileMeasurementAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	id, err := httpUUIDVar(r, "id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateDeviceProfileAccess(auth.Read, id),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	dp, err := storage.GetDeviceProfile(ctx, storage.DB(), id, false, true)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	m := dp.Measurements
+	if m == nil {
+		m = measurement.Definitions{}
+	}
+
+	httpWriteJSON(w, GetDeviceProfileMeasurementsResponse{
+		Measurements: m,
+	})
+}
+
+// Update replaces the measurement definitions of the given device-profile.
+// An empty set removes the mapping, in which case all numeric fields of the
+// decoded object are stored as device metrics.
+func (a *DeviceProfileMeasurementAPI) Update(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	id, err := httpUUIDVar(r, "id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateDeviceProfileAccess(auth.Update, id),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req UpdateDeviceProfileMeasurementsRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := req.Measurements.Validate(); err != nil {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "measurements: %s", err))
+		return
+	}
+
+	if err := storage.UpdateDeviceProfileMeasurements(ctx, storage.DB(), id, req.Measurements); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
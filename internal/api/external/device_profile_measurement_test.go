@@ -0,0 +1,62 @@
+package external
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/measurement"
+)
+
+func (ts *APITestSuite) TestDeviceProfileMeasurement() {
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewDeviceProfileMeasurementAPI(validator).Register(r)
+
+	_, dpID := createTestDeviceProfile(ts.T())
+
+	path := fmt.Sprintf("/api/device-profiles/%s/measurements", dpID)
+
+	get := func(t *testing.T) GetDeviceProfileMeasurementsResponse {
+		var resp GetDeviceProfileMeasurementsResponse
+		httpTestDecode(t, httpTestRequest(r, "GET", path, nil), http.StatusOK, &resp)
+		return resp
+	}
+
+	update := func(m measurement.Definitions) int {
+		return httpTestRequest(r, "PUT", path, UpdateDeviceProfileMeasurementsRequest{Measurements: m}).Code
+	}
+
+	ts.T().Run("Get not configured", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Equal(measurement.Definitions{}, get(t).Measurements)
+	})
+
+	ts.T().Run("Update", func(t *testing.T) {
+		assert := require.New(t)
+
+		m := measurement.Definitions{
+			"temperature":  {Name: "temperature", Kind: measurement.Gauge, Unit: "°C"},
+			"sensor.count": {Name: "pulse_count", Kind: measurement.Counter},
+		}
+		assert.Equal(http.StatusOK, update(m))
+		assert.Equal(m, get(t).Measurements)
+	})
+
+	ts.T().Run("Update invalid", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Equal(http.StatusBadRequest, update(measurement.Definitions{
+			"temperature": {Name: "temperature", Kind: "HISTOGRAM"},
+		}))
+	})
+
+	ts.T().Run("Remove", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.Equal(http.StatusOK, update(nil))
+		assert.Equal(measurement.Definitions{}, get(t).Measurements)
+	})
+}
@@ -1,12 +1,18 @@
 package external
 
 import (
+	"encoding/json"
+	"time"
+
+	"github.com/gofrs/uuid"
 	"github.com/golang/protobuf/ptypes/empty"
 	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 
 	pb //"github.com/ibrahimozekici/lora-api/go/v3/as/external/api"
 	//"github.com/ibrahimozekici/lora-api/go/v3/ns"
@@ -14,6 +20,8 @@ import (
 	"github.com/ibrahimozekici/app-server2/internal/api/helpers"
 	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
 	"github.com/ibrahimozekici/app-server2/internal/codec"
+	"github.com/ibrahimozekici/app-server2/internal/downlink"
+	"github.com/ibrahimozekici/app-server2/internal/downlink/validation"
 	"github.com/ibrahimozekici/app-server2/internal/storage"
 	//"github.com/brocaar/lorawan"
 )
@@ -32,8 +40,6 @@ func NewDeviceQueueAPI(validator auth.Validator) *DeviceQueueAPI {
 
 // Enqueue adds the given item to the device-queue.
 func (d *DeviceQueueAPI) Enqueue(ctx context.Context, req *pb.EnqueueDeviceQueueItemRequest) (*pb.EnqueueDeviceQueueItemResponse, error) {
-	var fCnt uint32
-
 	if req.DeviceQueueItem == nil {
 		return nil, grpc.Errorf(codes.InvalidArgument, "queue_item must not be nil")
 	}
@@ -52,53 +58,17 @@ func (d *DeviceQueueAPI) Enqueue(ctx context.Context, req *pb.EnqueueDeviceQueue
 		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
 	}
 
-	if err := storage.Transaction(func(tx sqlx.Ext) error {
-		// Lock the device to avoid concurrent enqueue actions for the same
-		// device as this would result in re-use of the same frame-counter.
-		dev, err := storage.GetDevice(ctx, tx, devEUI, true, true)
-		if err != nil {
-			return helpers.ErrToRPCError(err)
-		}
-
-		// if JSON object is set, try to encode it to bytes
-		if req.DeviceQueueItem.JsonObject != "" && req.DeviceQueueItem.JsonObject != "null" {
-			app, err := storage.GetApplication(ctx, storage.DB(), dev.ApplicationID)
-			if err != nil {
-				return helpers.ErrToRPCError(err)
-			}
-
-			dp, err := storage.GetDeviceProfile(ctx, storage.DB(), dev.DeviceProfileID, false, true)
-			if err != nil {
-				log.WithError(err).WithField("id", dev.DeviceProfileID).Error("get device-profile error")
-				return grpc.Errorf(codes.Internal, "get device-profile error: %s", err)
-			}
-
-			// TODO: in the next major release, remove this and always use the
-			// device-profile codec fields.
-			payloadCodec := app.PayloadCodec
-			payloadEncoderScript := app.PayloadEncoderScript
-
-			if dp.PayloadCodec != "" {
-				payloadCodec = dp.PayloadCodec
-				payloadEncoderScript = dp.PayloadEncoderScript
-			}
-
-			req.DeviceQueueItem.Data, err = codec.JSONToBinary(payloadCodec, uint8(req.DeviceQueueItem.FPort), dev.Variables, payloadEncoderScript, []byte(req.DeviceQueueItem.JsonObject))
-			if err != nil {
-				return helpers.ErrToRPCError(err)
-			}
-		}
-
-		fCnt, err = storage.EnqueueDownlinkPayload(ctx, tx, devEUI, req.DeviceQueueItem.Confirmed, uint8(req.DeviceQueueItem.FPort), req.DeviceQueueItem.Data)
-		if err != nil {
-			return grpc.Errorf(codes.Internal, "enqueue downlink payload error: %s", err)
-		}
-
-		return nil
-	}); err != nil {
+	fCnt, correlationID, err := enqueueDeviceQueueItem(ctx, devEUI, req.DeviceQueueItem, nil)
+	if err != nil {
 		return nil, err
 	}
 
+	// the response message has no correlation ID field, it is returned as
+	// header (Grpc-Metadata-Correlation-Id when using the REST API)
+	if err := grpc.SetHeader(ctx, metadata.Pairs("correlation-id", correlationID.String())); err != nil {
+		log.WithError(err).Error("set correlation-id header error")
+	}
+
 	return &pb.EnqueueDeviceQueueItemResponse{
 		FCnt: fCnt,
 	}, nil
@@ -133,6 +103,19 @@ func (d *DeviceQueueAPI) Flush(ctx context.Context, req *pb.FlushDeviceQueueRequ
 		return nil, err
 	}
 
+	// flushed downlinks must not be retried
+	if err := storage.DeleteConfirmedDownlinksForDevice(ctx, storage.DB(), devEUI); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	if err := storage.DeleteDeviceQueueItemExpiriesForDevice(ctx, storage.DB(), devEUI); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	if err := storage.DeleteDeviceQueueItemObjectsForDevice(ctx, storage.DB(), devEUI); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
 	return &empty.Empty{}, nil
 }
 
@@ -174,20 +157,221 @@ func (d *DeviceQueueAPI) List(ctx context.Context, req *pb.ListDeviceQueueItemsR
 	resp := pb.ListDeviceQueueItemsResponse{
 		TotalCount: queueItemsResp.TotalCount,
 	}
+	objects, err := d.getDeviceQueueItemObjects(ctx, devEUI, queueItemsResp.Items, !req.CountOnly)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	var c *storage.DeviceCodec
+
 	for _, qi := range queueItemsResp.Items {
 		b, err := lorawan.EncryptFRMPayload(device.AppSKey, false, device.DevAddr, qi.FCnt, qi.FrmPayload)
 		if err != nil {
 			return nil, helpers.ErrToRPCError(err)
 		}
 
-		resp.DeviceQueueItems = append(resp.DeviceQueueItems, &pb.DeviceQueueItem{
+		item := pb.DeviceQueueItem{
 			DevEui:    devEUI.String(),
 			Confirmed: qi.Confirmed,
 			FPort:     qi.FPort,
 			FCnt:      qi.FCnt,
 			Data:      b,
-		})
+		}
+
+		// Set the decoded representation of the item. This is the object
+		// from which the payload was encoded or, when the item was enqueued
+		// as raw payload, the payload decoded by the codec of the device.
+		if o, ok := objects[qi.FCnt]; ok && uint32(o.FPort) == qi.FPort {
+			item.JsonObject = string(o.Object)
+		} else {
+			if c == nil {
+				dc, err := getDeviceQueueCodec(ctx, device)
+				if err != nil {
+					return nil, helpers.ErrToRPCError(err)
+				}
+				c = &dc
+			}
+
+			if c.Type != codec.None {
+				obj, err := codec.BinaryToJSON(c.Type, uint8(qi.FPort), device.Variables, c.DecoderScript, b)
+				if err == nil {
+					item.JsonObject = string(obj)
+				} else {
+					log.WithError(err).WithFields(log.Fields{
+						"dev_eui": devEUI,
+						"f_cnt":   qi.FCnt,
+					}).Debug("api/external: decode device-queue item preview error")
+				}
+			}
+		}
+
+		resp.DeviceQueueItems = append(resp.DeviceQueueItems, &item)
 	}
 
 	return &resp, nil
 }
+
+// getDeviceQueueItemObjects returns the stored source objects of the given
+// device-queue items, by frame-counter. When prune is set (the given items
+// are the complete device-queue), the objects of the items which are no
+// longer in the device-queue are removed.
+func (d *DeviceQueueAPI) getDeviceQueueItemObjects(ctx context.Context, devEUI lorawan.EUI64, items []*ns.DeviceQueueItem, prune bool) (map[uint32]storage.DeviceQueueItemObject, error) {
+	if prune {
+		var fCnts []uint32
+		for _, qi := range items {
+			fCnts = append(fCnts, qi.FCnt)
+		}
+
+		if err := storage.DeleteDeviceQueueItemObjectsExcept(ctx, storage.DB(), devEUI, fCnts); err != nil {
+			return nil, err
+		}
+	}
+
+	objects, err := storage.GetDeviceQueueItemObjects(ctx, storage.DB(), devEUI)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[uint32]storage.DeviceQueueItemObject, len(objects))
+	for _, o := range objects {
+		out[o.FCnt] = o
+	}
+
+	return out, nil
+}
+
+// getDeviceQueueCodec returns the payload codec of the given device.
+func getDeviceQueueCodec(ctx context.Context, device storage.Device) (storage.DeviceCodec, error) {
+	app, err := storage.GetApplication(ctx, storage.DB(), device.ApplicationID)
+	if err != nil {
+		return storage.DeviceCodec{}, err
+	}
+
+	dp, err := storage.GetDeviceProfile(ctx, storage.DB(), device.DeviceProfileID, false, true)
+	if err != nil {
+		return storage.DeviceCodec{}, err
+	}
+
+	return storage.ResolvePayloadCodec(ctx, storage.DB(), app, dp, device)
+}
+
+// enqueueDeviceQueueItem encodes and validates the given item and adds it to
+// the device-queue. When expiresAt is set, the item is removed from the
+// device-queue when it has not been sent before this timestamp. It returns
+// the frame-counter and the correlation ID of the enqueued item.
+func enqueueDeviceQueueItem(ctx context.Context, devEUI lorawan.EUI64, item *pb.DeviceQueueItem, expiresAt *time.Time) (uint32, uuid.UUID, error) {
+	var fCnt uint32
+
+	correlationID, err := uuid.NewV4()
+	if err != nil {
+		return 0, correlationID, grpc.Errorf(codes.Internal, "new uuid error: %s", err)
+	}
+
+	releaseRateLimit := func() {}
+
+	if err := storage.Transaction(func(tx sqlx.Ext) error {
+		// Lock the device to avoid concurrent enqueue actions for the same
+		// device as this would result in re-use of the same frame-counter.
+		dev, err := storage.GetDevice(ctx, tx, devEUI, true, true)
+		if err != nil {
+			return helpers.ErrToRPCError(err)
+		}
+
+		dp, err := storage.GetDeviceProfile(ctx, storage.DB(), dev.DeviceProfileID, false, true)
+		if err != nil {
+			log.WithError(err).WithField("id", dev.DeviceProfileID).Error("get device-profile error")
+			return grpc.Errorf(codes.Internal, "get device-profile error: %s", err)
+		}
+
+		// if JSON object is set, try to encode it to bytes
+		if item.JsonObject != "" && item.JsonObject != "null" {
+			if err := downlink.ValidateObject(dp, uint8(item.FPort), []byte(item.JsonObject)); err != nil {
+				return validationErrToRPCError(err)
+			}
+
+			app, err := storage.GetApplication(ctx, storage.DB(), dev.ApplicationID)
+			if err != nil {
+				return helpers.ErrToRPCError(err)
+			}
+
+			c, err := storage.ResolvePayloadCodec(ctx, storage.DB(), app, dp, dev)
+			if err != nil {
+				return helpers.ErrToRPCError(err)
+			}
+
+			item.Data, err = codec.JSONToBinary(c.Type, uint8(item.FPort), dev.Variables, c.EncoderScript, []byte(item.JsonObject))
+			if err != nil {
+				return helpers.ErrToRPCError(err)
+			}
+		}
+
+		if err := downlink.ValidatePayload(ctx, storage.DB(), dp, dev, uint8(item.FPort), item.Data); err != nil {
+			return validationErrToRPCError(err)
+		}
+
+		// in coalesce mode, the pending identical downlink is returned
+		dup, err := storage.CheckDownlinkDuplicate(ctx, tx, devEUI, item.Confirmed, uint8(item.FPort), item.Data)
+		if err != nil {
+			return helpers.ErrToRPCError(err)
+		}
+		if dup != nil {
+			fCnt = dup.FCnt
+			correlationID = dup.ID
+			return nil
+		}
+
+		release, err := storage.CheckDownlinkRateLimit(ctx, dev.ApplicationID, devEUI)
+		if err != nil {
+			return helpers.ErrToRPCError(err)
+		}
+		releaseRateLimit = release
+
+		fCnt, err = storage.EnqueueCorrelatedDownlinkPayload(ctx, tx, devEUI, correlationID, item.Confirmed, uint8(item.FPort), item.Data)
+		if err != nil {
+			return grpc.Errorf(codes.Internal, "enqueue downlink payload error: %s", err)
+		}
+
+		if err := storage.SetDownlinkDeduplicationKey(ctx, devEUI, item.Confirmed, uint8(item.FPort), item.Data, correlationID); err != nil {
+			return helpers.ErrToRPCError(err)
+		}
+
+		if item.JsonObject != "" && item.JsonObject != "null" {
+			if err := storage.CreateDeviceQueueItemObject(ctx, tx, &storage.DeviceQueueItemObject{
+				DevEUI: devEUI,
+				FCnt:   fCnt,
+				FPort:  uint8(item.FPort),
+				Object: json.RawMessage(item.JsonObject),
+			}); err != nil {
+				return helpers.ErrToRPCError(err)
+			}
+		}
+
+		if expiresAt != nil {
+			if err := storage.CreateDeviceQueueItemExpiry(ctx, tx, &storage.DeviceQueueItemExpiry{
+				DevEUI:    devEUI,
+				FCnt:      fCnt,
+				FPort:     uint8(item.FPort),
+				ExpiresAt: *expiresAt,
+			}); err != nil {
+				return helpers.ErrToRPCError(err)
+			}
+		}
+
+		return nil
+	}); err != nil {
+		// the downlink has not been enqueued
+		releaseRateLimit()
+		return 0, correlationID, err
+	}
+
+	return fCnt, correlationID, nil
+}
+
+// validationErrToRPCError returns an InvalidArgument error containing the
+// validation details when the downlink did not pass validation.
+func validationErrToRPCError(err error) error {
+	if validation.IsError(err) {
+		return grpc.Errorf(codes.InvalidArgument, "downlink validation failed: %s", errors.Cause(err))
+	}
+	return helpers.ErrToRPCError(err)
+}
@@ -0,0 +1,102 @@
+package external
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb //"github.com/ibrahimozekici/lora-api/go/v3/as/external/api"
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	//"github.com/brocaar/lorawan"
+)
+
+// EnqueueExpiringDeviceQueueItemRequest defines the request to enqueue a
+// downlink which expires when it has not been sent before ExpiresAt.
+type EnqueueExpiringDeviceQueueItemRequest struct {
+	Confirmed  bool      `json:"confirmed"`
+	FPort      uint32    `json:"fPort"`
+	Data       []byte    `json:"data"`
+	JSONObject string    `json:"jsonObject"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// EnqueueExpiringDeviceQueueItemResponse defines the response of enqueueing
+// an expiring downlink.
+type EnqueueExpiringDeviceQueueItemResponse struct {
+	FCnt          uint32 `json:"fCnt"`
+	CorrelationID string `json:"correlationID"`
+}
+
+// DeviceQueueExpiryAPI exports the device-queue functions for downlinks with
+// an expiry timestamp.
+type DeviceQueueExpiryAPI struct {
+	validator auth.Validator
+}
+
+// NewDeviceQueueExpiryAPI creates a new DeviceQueueExpiryAPI.
+func NewDeviceQueueExpiryAPI(validator auth.Validator) *DeviceQueueExpiryAPI {
+	return &DeviceQueueExpiryAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *DeviceQueueExpiryAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/devices/{dev_eui}/queue/expiring", a.Enqueue).Methods("POST")
+}
+
+// Enqueue adds the given item to the device-queue. When the item has not been
+// sent before its expiry, it is removed from the device-queue and an error
+// event is sent to the integrations.
+func (a *DeviceQueueExpiryAPI) Enqueue(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpEUI64Var(r, "dev_eui")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateDeviceQueueAccess(devEUI, auth.Create),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req EnqueueExpiringDeviceQueueItemRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if req.FPort == 0 {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "fPort must be > 0"))
+		return
+	}
+
+	if !req.ExpiresAt.After(time.Now()) {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "expiresAt must be in the future"))
+		return
+	}
+
+	fCnt, correlationID, err := enqueueDeviceQueueItem(ctx, devEUI, &pb.DeviceQueueItem{
+		DevEui:     devEUI.String(),
+		Confirmed:  req.Confirmed,
+		FPort:      req.FPort,
+		Data:       req.Data,
+		JsonObject: req.JSONObject,
+	}, &req.ExpiresAt)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, EnqueueExpiringDeviceQueueItemResponse{
+		FCnt:          fCnt,
+		CorrelationID: correlationID.String(),
+	})
+}
@@ -0,0 +1,144 @@
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	//"github.com/ibrahimozekici/lora-api/go/v3/ns"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *APITestSuite) TestDeviceQueueExpiry() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	nsClient.GetNextDownlinkFCntForDevEUIResponse = ns.GetNextDownlinkFCntForDevEUIResponse{
+		FCnt: 12,
+	}
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewDeviceQueueExpiryAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	dp := storage.DeviceProfile{
+		Name:            "test-dp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateDeviceProfile(context.Background(), storage.DB(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	app := storage.Application{
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+		Name:             "test-app",
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	d := storage.Device{
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "test-node",
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		DevAddr:         lorawan.DevAddr{1, 2, 3, 4},
+		AppSKey:         lorawan.AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 1, 2, 3, 4, 5, 6, 7, 8},
+	}
+	assert.NoError(storage.CreateDevice(context.Background(), storage.DB(), &d))
+
+	path := fmt.Sprintf("/api/devices/%s/queue/expiring", d.DevEUI)
+
+	enqueue := func(req EnqueueExpiringDeviceQueueItemRequest) *httptest.ResponseRecorder {
+		b, err := json.Marshal(req)
+		assert.NoError(err)
+
+		rec := httpTestRequest(r, "POST", path, b)
+		return rec
+	}
+
+	ts.T().Run("Enqueue", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := enqueue(EnqueueExpiringDeviceQueueItemRequest{
+			FPort:     10,
+			Data:      []byte{1, 2, 3, 4},
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp EnqueueExpiringDeviceQueueItemResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.EqualValues(12, resp.FCnt)
+
+		req := <-nsClient.CreateDeviceQueueItemChan
+		assert.EqualValues(10, req.Item.FPort)
+		assert.EqualValues(12, req.Item.FCnt)
+
+		t.Run("The expiry has been stored", func(t *testing.T) {
+			assert := require.New(t)
+
+			_, err := storage.DB().Exec("update device_queue_item_expiry set expires_at = now() - interval '1 second'")
+			assert.NoError(err)
+
+			items, err := storage.GetExpiredDeviceQueueItems(context.Background(), storage.DB(), 10)
+			assert.NoError(err)
+			assert.Len(items, 1)
+			assert.EqualValues(12, items[0].FCnt)
+			assert.EqualValues(10, items[0].FPort)
+		})
+	})
+
+	ts.T().Run("Expiry in the past", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := enqueue(EnqueueExpiringDeviceQueueItemRequest{
+			FPort:     10,
+			Data:      []byte{1, 2, 3, 4},
+			ExpiresAt: time.Now().Add(-time.Minute),
+		})
+		assert.Equal(http.StatusBadRequest, rec.Code)
+	})
+
+	ts.T().Run("Invalid fPort", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := enqueue(EnqueueExpiringDeviceQueueItemRequest{
+			Data:      []byte{1, 2, 3, 4},
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+		assert.Equal(http.StatusBadRequest, rec.Code)
+	})
+}
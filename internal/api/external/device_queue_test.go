@@ -91,7 +91,7 @@ func (ts *APITestSuite) TestDownlinkQueue() {
 					];
 				}
 			`
-		assert.NoError(storage.UpdateApplication(context.Background(), storage.DB(), app))
+		assert.NoError(storage.UpdateApplication(context.Background(), storage.DB(), &app))
 
 		t.Run("Enqueue with raw JSON", func(t *testing.T) {
 			assert := require.New(t)
@@ -194,14 +194,59 @@ func (ts *APITestSuite) TestDownlinkQueue() {
 		assert.EqualValues(1, resp.TotalCount)
 		assert.Len(resp.DeviceQueueItems, 1)
 		assert.Equal(&pb.DeviceQueueItem{
-			DevEui:    d.DevEUI.String(),
-			Confirmed: true,
-			FPort:     10,
-			FCnt:      12,
-			Data:      []byte{1, 2, 3, 4},
+			DevEui:     d.DevEUI.String(),
+			Confirmed:  true,
+			FPort:      10,
+			FCnt:       12,
+			Data:       []byte{1, 2, 3, 4},
+			JsonObject: `{"Bytes": [4, 3, 2, 1]}`,
 		}, resp.DeviceQueueItems[0])
 	})
 
+	ts.T().Run("List with decoded device-queue item", func(t *testing.T) {
+		assert := require.New(t)
+
+		dp.PayloadDecoderScript = `
+				function Decode(fPort, bytes) {
+					return {
+						"interval": bytes[0]
+					};
+				}
+			`
+		assert.NoError(storage.UpdateDeviceProfile(context.Background(), storage.DB(), &dp))
+
+		b, err := lorawan.EncryptFRMPayload(d.AppSKey, false, d.DevAddr, 13, []byte{1, 2, 3, 4})
+		assert.NoError(err)
+
+		nsClient.GetDeviceQueueItemsForDevEUIResponse = ns.GetDeviceQueueItemsForDevEUIResponse{
+			Items: []*ns.DeviceQueueItem{
+				{
+					DevAddr:    d.DevAddr[:],
+					DevEui:     d.DevEUI[:],
+					FrmPayload: b,
+					FCnt:       13,
+					FPort:      10,
+				},
+			},
+			TotalCount: 1,
+		}
+
+		resp, err := api.List(context.Background(), &pb.ListDeviceQueueItemsRequest{
+			DevEui: d.DevEUI.String(),
+		})
+		assert.NoError(err)
+		<-nsClient.GetDeviceQueueItemsForDevEUIChan
+
+		// the item was enqueued as raw payload, it is decoded by the codec
+		assert.Len(resp.DeviceQueueItems, 1)
+		assert.JSONEq(`{"interval": 1}`, resp.DeviceQueueItems[0].JsonObject)
+
+		// the object of the sent item (fCnt 12) has been removed
+		objects, err := storage.GetDeviceQueueItemObjects(context.Background(), storage.DB(), d.DevEUI)
+		assert.NoError(err)
+		assert.Len(objects, 0)
+	})
+
 	ts.T().Run("Flush", func(t *testing.T) {
 		assert := require.New(t)
 
@@ -0,0 +1,120 @@
+package external
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+// deviceSessionMaxLimit defines the max. number of sessions returned by a
+// single request.
+const deviceSessionMaxLimit = 100
+
+// DeviceSessionItem defines a session established by the join-server.
+type DeviceSessionItem struct {
+	CreatedAt  time.Time `json:"createdAt"`
+	JoinType   string    `json:"joinType"`
+	SenderID   string    `json:"senderID"`
+	MACVersion string    `json:"macVersion"`
+	DevAddr    string    `json:"devAddr"`
+	JoinNonce  int       `json:"joinNonce"`
+	DevNonce   int       `json:"devNonce"`
+	RJCount    int       `json:"rjCount"`
+}
+
+// ListDeviceSessionsResponse defines the list device sessions response.
+// Counts contains the total number of sessions per join type (join,
+// rejoin_0, rejoin_1 and rejoin_2).
+type ListDeviceSessionsResponse struct {
+	Counts map[string]int      `json:"counts"`
+	Result []DeviceSessionItem `json:"result"`
+}
+
+// DeviceSessionAPI exports the device session history related functions.
+type DeviceSessionAPI struct {
+	validator auth.Validator
+}
+
+// NewDeviceSessionAPI creates a new DeviceSessionAPI.
+func NewDeviceSessionAPI(validator auth.Validator) *DeviceSessionAPI {
+	return &DeviceSessionAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *DeviceSessionAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/devices/{dev_eui}/sessions", a.List).Methods("GET")
+}
+
+// List returns the session history of the given device, newest first.
+func (a *DeviceSessionAPI) List(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpEUI64Var(r, "dev_eui")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateNodeAccess(devEUI, auth.Read),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	limit := deviceSessionMaxLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l <= 0 || l > deviceSessionMaxLimit {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", deviceSessionMaxLimit))
+			return
+		}
+		limit = l
+	}
+
+	sessions, err := storage.GetDeviceSessions(ctx, storage.DB(), devEUI, limit)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	counts, err := storage.GetDeviceSessionCounts(ctx, storage.DB(), devEUI)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListDeviceSessionsResponse{
+		Counts: make(map[string]int),
+		Result: make([]DeviceSessionItem, 0, len(sessions)),
+	}
+
+	for _, c := range counts {
+		resp.Counts[string(c.JoinType)] = c.Count
+	}
+
+	for _, s := range sessions {
+		resp.Result = append(resp.Result, DeviceSessionItem{
+			CreatedAt:  s.CreatedAt,
+			JoinType:   string(s.JoinType),
+			SenderID:   s.SenderID,
+			MACVersion: s.MACVersion,
+			DevAddr:    s.DevAddr.String(),
+			JoinNonce:  s.JoinNonce,
+			DevNonce:   s.DevNonce,
+			RJCount:    s.RJCount,
+		})
+	}
+
+	httpWriteJSON(w, resp)
+}
@@ -0,0 +1,81 @@
+package external
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// GetDownlinkDeliveryResponse defines the delivery status of the downlink
+// with the requested correlation ID. TxAckAt is set once the downlink has
+// been sent by the gateway, AckAt and Acknowledged once a confirmed downlink
+// has been (negatively) acknowledged by the device.
+type GetDownlinkDeliveryResponse struct {
+	CorrelationID string     `json:"correlationID"`
+	DevEUI        string     `json:"devEUI"`
+	FCnt          uint32     `json:"fCnt"`
+	FPort         uint8      `json:"fPort"`
+	Confirmed     bool       `json:"confirmed"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	TxAckAt       *time.Time `json:"txAckAt"`
+	AckAt         *time.Time `json:"ackAt"`
+	Acknowledged  *bool      `json:"acknowledged"`
+}
+
+// DownlinkCorrelationAPI exports the downlink delivery related functions.
+type DownlinkCorrelationAPI struct {
+	validator auth.Validator
+}
+
+// NewDownlinkCorrelationAPI creates a new DownlinkCorrelationAPI.
+func NewDownlinkCorrelationAPI(validator auth.Validator) *DownlinkCorrelationAPI {
+	return &DownlinkCorrelationAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *DownlinkCorrelationAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/downlinks/{correlation_id}", a.Get).Methods("GET")
+}
+
+// Get returns the delivery status of the downlink with the given correlation
+// ID.
+func (a *DownlinkCorrelationAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	id, err := httpUUIDVar(r, "correlation_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	dc, err := storage.GetDownlinkCorrelation(ctx, storage.DB(), id)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateDeviceQueueAccess(dc.DevEUI, auth.List),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, GetDownlinkDeliveryResponse{
+		CorrelationID: dc.ID.String(),
+		DevEUI:        dc.DevEUI.String(),
+		FCnt:          dc.FCnt,
+		FPort:         dc.FPort,
+		Confirmed:     dc.Confirmed,
+		CreatedAt:     dc.CreatedAt,
+		TxAckAt:       dc.TxAckAt,
+		AckAt:         dc.AckAt,
+		Acknowledged:  dc.Acknowledged,
+	})
+}
@@ -0,0 +1,161 @@
+package external
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	//"github.com/ibrahimozekici/lora-api/go/v3/ns"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/downlink"
+	"github.com/ibrahimozekici/app-server2/internal/integration"
+	integrationmock "github.com/ibrahimozekici/app-server2/internal/integration/mock"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *APITestSuite) TestDownlinkCorrelation() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	nsClient.GetNextDownlinkFCntForDevEUIResponse = ns.GetNextDownlinkFCntForDevEUIResponse{
+		FCnt: 12,
+	}
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	h := integrationmock.New()
+	integration.SetMockIntegration(h)
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewDeviceQueueExpiryAPI(validator).Register(r)
+	NewDownlinkCorrelationAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	dp := storage.DeviceProfile{
+		Name:            "test-dp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateDeviceProfile(context.Background(), storage.DB(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	app := storage.Application{
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+		Name:             "test-app",
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	d := storage.Device{
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "test-node",
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		DevAddr:         lorawan.DevAddr{1, 2, 3, 4},
+		AppSKey:         lorawan.AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 1, 2, 3, 4, 5, 6, 7, 8},
+	}
+	assert.NoError(storage.CreateDevice(context.Background(), storage.DB(), &d))
+
+	b, err := json.Marshal(EnqueueExpiringDeviceQueueItemRequest{
+		Confirmed: true,
+		FPort:     10,
+		Data:      []byte{1, 2, 3, 4},
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	assert.NoError(err)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("POST", fmt.Sprintf("/api/devices/%s/queue/expiring", d.DevEUI), bytes.NewReader(b)))
+	assert.Equal(http.StatusOK, rec.Code)
+	<-nsClient.CreateDeviceQueueItemChan
+
+	var enqueueResp EnqueueExpiringDeviceQueueItemResponse
+	assert.NoError(json.NewDecoder(rec.Body).Decode(&enqueueResp))
+	assert.NotEqual("", enqueueResp.CorrelationID)
+
+	get := func() GetDownlinkDeliveryResponse {
+		rec := httpTestRequest(r, "GET", "/api/downlinks/"+enqueueResp.CorrelationID, nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp GetDownlinkDeliveryResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		return resp
+	}
+
+	ts.T().Run("Enqueued", func(t *testing.T) {
+		assert := require.New(t)
+
+		resp := get()
+		assert.Equal(d.DevEUI.String(), resp.DevEUI)
+		assert.EqualValues(12, resp.FCnt)
+		assert.True(resp.Confirmed)
+		assert.Nil(resp.TxAckAt)
+		assert.Nil(resp.AckAt)
+	})
+
+	ts.T().Run("Tx ack", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(downlink.HandleDownlinkTxAck(context.Background(), app, d, 12))
+
+		pl := <-h.SendIntegrationNotificationChan
+		assert.Equal("downlink", pl.IntegrationName)
+		assert.Equal("tx_ack", pl.EventType)
+		assert.Contains(pl.ObjectJson, `"correlationID":"`+enqueueResp.CorrelationID+`"`)
+
+		assert.NotNil(get().TxAckAt)
+	})
+
+	ts.T().Run("Ack", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(downlink.HandleDownlinkAck(context.Background(), app, d, 12, true))
+
+		pl := <-h.SendIntegrationNotificationChan
+		assert.Equal("ack", pl.EventType)
+		assert.Contains(pl.ObjectJson, `"acknowledged":true`)
+
+		resp := get()
+		assert.NotNil(resp.AckAt)
+		assert.NotNil(resp.Acknowledged)
+		assert.True(*resp.Acknowledged)
+	})
+
+	ts.T().Run("Unknown correlation ID", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "GET", "/api/downlinks/"+uuid.Must(uuid.NewV4()).String(), nil)
+		assert.Equal(http.StatusNotFound, rec.Code)
+	})
+}
@@ -0,0 +1,261 @@
+package external
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/downlink/rule"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// DownlinkRule defines a rule which enqueues the configured downlink when the
+// decoded object of an uplink matches all conditions.
+type DownlinkRule struct {
+	ID              string          `json:"id"`
+	ApplicationID   int64           `json:"applicationID,string"`
+	Name            string          `json:"name"`
+	Enabled         bool            `json:"enabled"`
+	UplinkFPort     uint8           `json:"uplinkFPort"`
+	Conditions      rule.Conditions `json:"conditions"`
+	FPort           uint8           `json:"fPort"`
+	Confirmed       bool            `json:"confirmed"`
+	Object          string          `json:"object"`
+	CooldownSeconds float64         `json:"cooldownSeconds"`
+	CreatedAt       time.Time       `json:"createdAt"`
+	UpdatedAt       time.Time       `json:"updatedAt"`
+}
+
+// CreateDownlinkRuleRequest defines the create downlink rule request.
+type CreateDownlinkRuleRequest struct {
+	DownlinkRule DownlinkRule `json:"downlinkRule"`
+}
+
+// CreateDownlinkRuleResponse defines the create downlink rule response.
+type CreateDownlinkRuleResponse struct {
+	ID string `json:"id"`
+}
+
+// GetDownlinkRuleResponse defines the get downlink rule response.
+type GetDownlinkRuleResponse struct {
+	DownlinkRule DownlinkRule `json:"downlinkRule"`
+}
+
+// UpdateDownlinkRuleRequest defines the update downlink rule request.
+type UpdateDownlinkRuleRequest struct {
+	DownlinkRule DownlinkRule `json:"downlinkRule"`
+}
+
+// ListDownlinkRuleResponse defines the list downlink rules response.
+type ListDownlinkRuleResponse struct {
+	Result []DownlinkRule `json:"result"`
+}
+
+// DownlinkRuleAPI exports the downlink rule related functions.
+type DownlinkRuleAPI struct {
+	validator auth.Validator
+}
+
+// NewDownlinkRuleAPI creates a new DownlinkRuleAPI.
+func NewDownlinkRuleAPI(validator auth.Validator) *DownlinkRuleAPI {
+	return &DownlinkRuleAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *DownlinkRuleAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/applications/{application_id}/downlink-rules", a.Create).Methods("POST")
+	r.HandleFunc("/api/applications/{application_id}/downlink-rules", a.List).Methods("GET")
+	r.HandleFunc("/api/downlink-rules/{id}", a.Get).Methods("GET")
+	r.HandleFunc("/api/downlink-rules/{id}", a.Update).Methods("PUT")
+	r.HandleFunc("/api/downlink-rules/{id}", a.Delete).Methods("DELETE")
+}
+
+// Create creates the given downlink rule for the application.
+func (a *DownlinkRuleAPI) Create(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(applicationID, auth.Update),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req CreateDownlinkRuleRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	dr := storage.DownlinkRule{
+		ApplicationID: applicationID,
+	}
+	downlinkRuleToStorage(req.DownlinkRule, &dr)
+
+	if err := storage.CreateDownlinkRule(ctx, storage.DB(), &dr); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, CreateDownlinkRuleResponse{
+		ID: dr.ID.String(),
+	})
+}
+
+// List lists the downlink rules of the application.
+func (a *DownlinkRuleAPI) List(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(applicationID, auth.Read),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	rules, err := storage.GetDownlinkRules(ctx, storage.DB(), applicationID)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListDownlinkRuleResponse{
+		Result: []DownlinkRule{},
+	}
+	for _, dr := range rules {
+		resp.Result = append(resp.Result, downlinkRuleFromStorage(dr))
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// Get returns the downlink rule.
+func (a *DownlinkRuleAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	dr, err := a.getDownlinkRule(ctx, r, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, GetDownlinkRuleResponse{
+		DownlinkRule: downlinkRuleFromStorage(dr),
+	})
+}
+
+// Update updates the downlink rule.
+func (a *DownlinkRuleAPI) Update(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	var req UpdateDownlinkRuleRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	dr, err := a.getDownlinkRule(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	downlinkRuleToStorage(req.DownlinkRule, &dr)
+
+	if err := storage.UpdateDownlinkRule(ctx, storage.DB(), &dr); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// Delete deletes the downlink rule.
+func (a *DownlinkRuleAPI) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	dr, err := a.getDownlinkRule(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := storage.DeleteDownlinkRule(ctx, storage.DB(), dr.ID); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// getDownlinkRule returns the downlink rule of the id route variable and
+// validates that the client has the requested access to its application.
+func (a *DownlinkRuleAPI) getDownlinkRule(ctx context.Context, r *http.Request, flag auth.Flag) (storage.DownlinkRule, error) {
+	id, err := httpUUIDVar(r, "id")
+	if err != nil {
+		return storage.DownlinkRule{}, err
+	}
+
+	dr, err := storage.GetDownlinkRule(ctx, storage.DB(), id)
+	if err != nil {
+		return dr, err
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(dr.ApplicationID, flag),
+	); err != nil {
+		return dr, err
+	}
+
+	return dr, nil
+}
+
+func downlinkRuleToStorage(in DownlinkRule, out *storage.DownlinkRule) {
+	out.Name = in.Name
+	out.Enabled = in.Enabled
+	out.UplinkFPort = in.UplinkFPort
+	out.Conditions = in.Conditions
+	out.FPort = in.FPort
+	out.Confirmed = in.Confirmed
+	out.Object = in.Object
+	out.Cooldown = time.Duration(in.CooldownSeconds * float64(time.Second))
+}
+
+func downlinkRuleFromStorage(dr storage.DownlinkRule) DownlinkRule {
+	conditions := dr.Conditions
+	if conditions == nil {
+		conditions = rule.Conditions{}
+	}
+
+	return DownlinkRule{
+		ID:              dr.ID.String(),
+		ApplicationID:   dr.ApplicationID,
+		Name:            dr.Name,
+		Enabled:         dr.Enabled,
+		UplinkFPort:     dr.UplinkFPort,
+		Conditions:      conditions,
+		FPort:           dr.FPort,
+		Confirmed:       dr.Confirmed,
+		Object:          dr.Object,
+		CooldownSeconds: dr.Cooldown.Seconds(),
+		CreatedAt:       dr.CreatedAt,
+		UpdatedAt:       dr.UpdatedAt,
+	}
+}
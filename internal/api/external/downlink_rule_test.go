@@ -0,0 +1,147 @@
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/downlink/rule"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+func (ts *APITestSuite) TestDownlinkRule() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewDownlinkRuleAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := storage.Application{
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+		Name:             "test-app",
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	dr := DownlinkRule{
+		Name:        "close-valve",
+		Enabled:     true,
+		UplinkFPort: 2,
+		Conditions: rule.Conditions{
+			{Path: "moisture", Operator: rule.GreaterThan, Value: json.RawMessage(`40`)},
+		},
+		FPort:           10,
+		Object:          `{"valve": "closed"}`,
+		CooldownSeconds: 3600,
+	}
+
+	var id string
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/downlink-rules", app.ID), CreateDownlinkRuleRequest{
+			DownlinkRule: dr,
+		})
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp CreateDownlinkRuleResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		id = resp.ID
+
+		t.Run("Get", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "GET", "/api/downlink-rules/"+id, nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			var resp GetDownlinkRuleResponse
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal("close-valve", resp.DownlinkRule.Name)
+			assert.Equal(app.ID, resp.DownlinkRule.ApplicationID)
+			assert.Equal(dr.Conditions, resp.DownlinkRule.Conditions)
+			assert.Equal(float64(3600), resp.DownlinkRule.CooldownSeconds)
+		})
+
+		t.Run("List", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "GET", fmt.Sprintf("/api/applications/%d/downlink-rules", app.ID), nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			var resp ListDownlinkRuleResponse
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Len(resp.Result, 1)
+		})
+
+		t.Run("Update", func(t *testing.T) {
+			assert := require.New(t)
+
+			dr.Enabled = false
+			rec := httpTestRequest(r, "PUT", "/api/downlink-rules/"+id, UpdateDownlinkRuleRequest{
+				DownlinkRule: dr,
+			})
+			assert.Equal(http.StatusOK, rec.Code)
+
+			rGet, err := storage.GetDownlinkRule(context.Background(), storage.DB(), uuid.FromStringOrNil(id))
+			assert.NoError(err)
+			assert.False(rGet.Enabled)
+		})
+	})
+
+	ts.T().Run("Create invalid conditions", func(t *testing.T) {
+		assert := require.New(t)
+
+		invalid := dr
+		invalid.Name = "invalid"
+		invalid.Conditions = rule.Conditions{
+			{Path: "moisture", Operator: "BETWEEN"},
+		}
+
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/downlink-rules", app.ID), CreateDownlinkRuleRequest{
+			DownlinkRule: invalid,
+		})
+		assert.Equal(http.StatusBadRequest, rec.Code)
+	})
+
+	ts.T().Run("Delete", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "DELETE", "/api/downlink-rules/"+id, nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		rec = httpTestRequest(r, "GET", "/api/downlink-rules/"+id, nil)
+		assert.Equal(http.StatusNotFound, rec.Code)
+	})
+}
@@ -0,0 +1,463 @@
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb //"github.com/ibrahimozekici/lora-api/go/v3/as/external/api"
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/downlink/template"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+// downlinkTemplateInvocationListMaxLimit defines the max. number of
+// invocations returned by a single list request.
+const downlinkTemplateInvocationListMaxLimit = 100
+
+// DownlinkTemplate defines a named downlink template of an application.
+type DownlinkTemplate struct {
+	ID             string    `json:"id"`
+	ApplicationID  int64     `json:"applicationID,string"`
+	Name           string    `json:"name"`
+	Description    string    `json:"description"`
+	FPort          uint8     `json:"fPort"`
+	Confirmed      bool      `json:"confirmed"`
+	ObjectTemplate string    `json:"objectTemplate"`
+	Parameters     []string  `json:"parameters"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// DownlinkTemplateInvocation defines an invocation of a downlink template.
+type DownlinkTemplateInvocation struct {
+	DevEUI     string          `json:"devEUI"`
+	FCnt       uint32          `json:"fCnt"`
+	Parameters json.RawMessage `json:"parameters"`
+	InvokedBy  string          `json:"invokedBy"`
+	CreatedAt  time.Time       `json:"createdAt"`
+}
+
+// CreateDownlinkTemplateRequest defines the create downlink template request.
+type CreateDownlinkTemplateRequest struct {
+	DownlinkTemplate DownlinkTemplate `json:"downlinkTemplate"`
+}
+
+// CreateDownlinkTemplateResponse defines the create downlink template
+// response.
+type CreateDownlinkTemplateResponse struct {
+	ID string `json:"id"`
+}
+
+// GetDownlinkTemplateResponse defines the get downlink template response.
+type GetDownlinkTemplateResponse struct {
+	DownlinkTemplate DownlinkTemplate `json:"downlinkTemplate"`
+}
+
+// UpdateDownlinkTemplateRequest defines the update downlink template request.
+type UpdateDownlinkTemplateRequest struct {
+	DownlinkTemplate DownlinkTemplate `json:"downlinkTemplate"`
+}
+
+// ListDownlinkTemplateResponse defines the list downlink templates response.
+type ListDownlinkTemplateResponse struct {
+	Result []DownlinkTemplate `json:"result"`
+}
+
+// InvokeDownlinkTemplateRequest defines the request to enqueue the downlink
+// of a template for the given device.
+type InvokeDownlinkTemplateRequest struct {
+	DevEUI     string                     `json:"devEUI"`
+	Parameters map[string]json.RawMessage `json:"parameters"`
+}
+
+// InvokeDownlinkTemplateResponse defines the invoke downlink template
+// response.
+type InvokeDownlinkTemplateResponse struct {
+	FCnt          uint32 `json:"fCnt"`
+	CorrelationID string `json:"correlationID"`
+}
+
+// ListDownlinkTemplateInvocationsResponse defines the list downlink template
+// invocations response.
+type ListDownlinkTemplateInvocationsResponse struct {
+	TotalCount int                          `json:"totalCount,string"`
+	Result     []DownlinkTemplateInvocation `json:"result"`
+}
+
+// DownlinkTemplateAPI exports the downlink template related functions.
+type DownlinkTemplateAPI struct {
+	validator auth.Validator
+}
+
+// NewDownlinkTemplateAPI creates a new DownlinkTemplateAPI.
+func NewDownlinkTemplateAPI(validator auth.Validator) *DownlinkTemplateAPI {
+	return &DownlinkTemplateAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *DownlinkTemplateAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/applications/{application_id}/downlink-templates", a.Create).Methods("POST")
+	r.HandleFunc("/api/applications/{application_id}/downlink-templates", a.List).Methods("GET")
+	r.HandleFunc("/api/downlink-templates/{id}", a.Get).Methods("GET")
+	r.HandleFunc("/api/downlink-templates/{id}", a.Update).Methods("PUT")
+	r.HandleFunc("/api/downlink-templates/{id}", a.Delete).Methods("DELETE")
+	r.HandleFunc("/api/downlink-templates/{id}/invoke", a.Invoke).Methods("POST")
+	r.HandleFunc("/api/downlink-templates/{id}/invocations", a.ListInvocations).Methods("GET")
+}
+
+// Create creates the given downlink template for the application.
+func (a *DownlinkTemplateAPI) Create(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(applicationID, auth.Update),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req CreateDownlinkTemplateRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	t := storage.DownlinkTemplate{
+		ApplicationID:  applicationID,
+		Name:           req.DownlinkTemplate.Name,
+		Description:    req.DownlinkTemplate.Description,
+		FPort:          req.DownlinkTemplate.FPort,
+		Confirmed:      req.DownlinkTemplate.Confirmed,
+		ObjectTemplate: req.DownlinkTemplate.ObjectTemplate,
+	}
+	if err := storage.CreateDownlinkTemplate(ctx, storage.DB(), &t); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, CreateDownlinkTemplateResponse{
+		ID: t.ID.String(),
+	})
+}
+
+// List lists the downlink templates of the application.
+func (a *DownlinkTemplateAPI) List(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(applicationID, auth.Read),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	templates, err := storage.GetDownlinkTemplates(ctx, storage.DB(), applicationID)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListDownlinkTemplateResponse{
+		Result: []DownlinkTemplate{},
+	}
+	for _, t := range templates {
+		resp.Result = append(resp.Result, downlinkTemplateFromStorage(t))
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// Get returns the downlink template.
+func (a *DownlinkTemplateAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	t, err := a.getDownlinkTemplate(ctx, r, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, GetDownlinkTemplateResponse{
+		DownlinkTemplate: downlinkTemplateFromStorage(t),
+	})
+}
+
+// Update updates the downlink template.
+func (a *DownlinkTemplateAPI) Update(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	var req UpdateDownlinkTemplateRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	t, err := a.getDownlinkTemplate(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	t.Name = req.DownlinkTemplate.Name
+	t.Description = req.DownlinkTemplate.Description
+	t.FPort = req.DownlinkTemplate.FPort
+	t.Confirmed = req.DownlinkTemplate.Confirmed
+	t.ObjectTemplate = req.DownlinkTemplate.ObjectTemplate
+
+	if err := storage.UpdateDownlinkTemplate(ctx, storage.DB(), &t); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// Delete deletes the downlink template.
+func (a *DownlinkTemplateAPI) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	t, err := a.getDownlinkTemplate(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := storage.DeleteDownlinkTemplate(ctx, storage.DB(), t.ID); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// Invoke renders the downlink template using the given parameters and
+// enqueues the result for the given device, which must belong to the
+// application of the template. Each invocation is recorded, including the
+// parameters and the subject that invoked it.
+func (a *DownlinkTemplateAPI) Invoke(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	var req InvokeDownlinkTemplateRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var devEUI lorawan.EUI64
+	if err := devEUI.UnmarshalText([]byte(req.DevEUI)); err != nil {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "devEUI: %s", err))
+		return
+	}
+
+	id, err := httpUUIDVar(r, "id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateDeviceQueueAccess(devEUI, auth.Create),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	t, err := storage.GetDownlinkTemplate(ctx, storage.DB(), id)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	d, err := storage.GetDevice(ctx, storage.DB(), devEUI, false, true)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if d.ApplicationID != t.ApplicationID {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "device and downlink template must be under the same application"))
+		return
+	}
+
+	obj, err := template.Render(t.ObjectTemplate, req.Parameters)
+	if err != nil {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "render template error: %s", err))
+		return
+	}
+
+	params, err := json.Marshal(req.Parameters)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	invokedBy, err := getInvokedBy(ctx, a.validator)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	fCnt, correlationID, err := enqueueDeviceQueueItem(ctx, devEUI, &pb.DeviceQueueItem{
+		DevEui:     devEUI.String(),
+		Confirmed:  t.Confirmed,
+		FPort:      uint32(t.FPort),
+		JsonObject: string(obj),
+	}, nil)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := storage.CreateDownlinkTemplateInvocation(ctx, storage.DB(), &storage.DownlinkTemplateInvocation{
+		DownlinkTemplateID: t.ID,
+		DevEUI:             devEUI,
+		FCnt:               fCnt,
+		Parameters:         params,
+		InvokedBy:          invokedBy,
+	}); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, InvokeDownlinkTemplateResponse{
+		FCnt:          fCnt,
+		CorrelationID: correlationID.String(),
+	})
+}
+
+// ListInvocations lists the invocations of the downlink template, newest
+// first.
+func (a *DownlinkTemplateAPI) ListInvocations(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	limit, offset, err := httpLimitOffset(r, downlinkTemplateInvocationListMaxLimit)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	t, err := a.getDownlinkTemplate(ctx, r, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	count, err := storage.GetDownlinkTemplateInvocationCount(ctx, storage.DB(), t.ID)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	invocations, err := storage.GetDownlinkTemplateInvocations(ctx, storage.DB(), t.ID, limit, offset)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListDownlinkTemplateInvocationsResponse{
+		TotalCount: count,
+		Result:     []DownlinkTemplateInvocation{},
+	}
+	for _, i := range invocations {
+		resp.Result = append(resp.Result, DownlinkTemplateInvocation{
+			DevEUI:     i.DevEUI.String(),
+			FCnt:       i.FCnt,
+			Parameters: i.Parameters,
+			InvokedBy:  i.InvokedBy,
+			CreatedAt:  i.CreatedAt,
+		})
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// getDownlinkTemplate returns the downlink template of the id route variable
+// and validates that the client has the requested access to its application.
+func (a *DownlinkTemplateAPI) getDownlinkTemplate(ctx context.Context, r *http.Request, flag auth.Flag) (storage.DownlinkTemplate, error) {
+	id, err := httpUUIDVar(r, "id")
+	if err != nil {
+		return storage.DownlinkTemplate{}, err
+	}
+
+	t, err := storage.GetDownlinkTemplate(ctx, storage.DB(), id)
+	if err != nil {
+		return t, err
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(t.ApplicationID, flag),
+	); err != nil {
+		return t, err
+	}
+
+	return t, nil
+}
+
+// getInvokedBy returns the description of the subject performing the request,
+// which is recorded for auditing.
+func getInvokedBy(ctx context.Context, validator auth.Validator) (string, error) {
+	sub, err := validator.GetSubject(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	switch sub {
+	case auth.SubjectUser:
+		user, err := validator.GetUser(ctx)
+		if err != nil {
+			return "", err
+		}
+		return user.Email, nil
+	case auth.SubjectAPIKey:
+		id, err := validator.GetAPIKeyID(ctx)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("api_key:%s", id), nil
+	default:
+		return sub, nil
+	}
+}
+
+func downlinkTemplateFromStorage(t storage.DownlinkTemplate) DownlinkTemplate {
+	// the template has been validated on create / update
+	params, _ := template.Placeholders(t.ObjectTemplate)
+	if params == nil {
+		params = []string{}
+	}
+
+	return DownlinkTemplate{
+		ID:             t.ID.String(),
+		ApplicationID:  t.ApplicationID,
+		Name:           t.Name,
+		Description:    t.Description,
+		FPort:          t.FPort,
+		Confirmed:      t.Confirmed,
+		ObjectTemplate: t.ObjectTemplate,
+		Parameters:     params,
+		CreatedAt:      t.CreatedAt,
+		UpdatedAt:      t.UpdatedAt,
+	}
+}
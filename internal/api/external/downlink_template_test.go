@@ -0,0 +1,199 @@
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	//"github.com/ibrahimozekici/lora-api/go/v3/ns"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/codec"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *APITestSuite) TestDownlinkTemplate() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	nsClient.GetNextDownlinkFCntForDevEUIResponse = ns.GetNextDownlinkFCntForDevEUIResponse{
+		FCnt: 12,
+	}
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewDownlinkTemplateAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	dp := storage.DeviceProfile{
+		Name:            "test-dp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+		PayloadCodec:    codec.CustomJSType,
+		PayloadEncoderScript: `
+			function Encode(fPort, obj) {
+				return [obj.interval];
+			}
+		`,
+	}
+	assert.NoError(storage.CreateDeviceProfile(context.Background(), storage.DB(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	app := storage.Application{
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+		Name:             "test-app",
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	d := storage.Device{
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "test-node",
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		DevAddr:         lorawan.DevAddr{1, 2, 3, 4},
+		AppSKey:         lorawan.AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 1, 2, 3, 4, 5, 6, 7, 8},
+	}
+	assert.NoError(storage.CreateDevice(context.Background(), storage.DB(), &d))
+
+	var id string
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/downlink-templates", app.ID), CreateDownlinkTemplateRequest{
+			DownlinkTemplate: DownlinkTemplate{
+				Name:           "set-interval",
+				FPort:          10,
+				ObjectTemplate: `{"interval": "{{ interval }}"}`,
+			},
+		})
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp CreateDownlinkTemplateResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		id = resp.ID
+
+		t.Run("Get", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "GET", "/api/downlink-templates/"+id, nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			var resp GetDownlinkTemplateResponse
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal("set-interval", resp.DownlinkTemplate.Name)
+			assert.Equal(app.ID, resp.DownlinkTemplate.ApplicationID)
+			assert.Equal([]string{"interval"}, resp.DownlinkTemplate.Parameters)
+		})
+
+		t.Run("List", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "GET", fmt.Sprintf("/api/applications/%d/downlink-templates", app.ID), nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			var resp ListDownlinkTemplateResponse
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Len(resp.Result, 1)
+		})
+	})
+
+	ts.T().Run("Create invalid template", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/downlink-templates", app.ID), CreateDownlinkTemplateRequest{
+			DownlinkTemplate: DownlinkTemplate{
+				Name:           "invalid",
+				FPort:          10,
+				ObjectTemplate: `{"interval": `,
+			},
+		})
+		assert.Equal(http.StatusBadRequest, rec.Code)
+	})
+
+	ts.T().Run("Invoke", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "POST", "/api/downlink-templates/"+id+"/invoke", InvokeDownlinkTemplateRequest{
+			DevEUI: d.DevEUI.String(),
+			Parameters: map[string]json.RawMessage{
+				"interval": json.RawMessage(`60`),
+			},
+		})
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp InvokeDownlinkTemplateResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.EqualValues(12, resp.FCnt)
+
+		b, err := lorawan.EncryptFRMPayload(d.AppSKey, false, d.DevAddr, 12, []byte{60})
+		assert.NoError(err)
+
+		req := <-nsClient.CreateDeviceQueueItemChan
+		assert.EqualValues(10, req.Item.FPort)
+		assert.Equal(b, req.Item.FrmPayload)
+
+		t.Run("ListInvocations", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "GET", "/api/downlink-templates/"+id+"/invocations", nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			var resp ListDownlinkTemplateInvocationsResponse
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal(1, resp.TotalCount)
+			assert.Equal(d.DevEUI.String(), resp.Result[0].DevEUI)
+			assert.EqualValues(12, resp.Result[0].FCnt)
+			assert.JSONEq(`{"interval": 60}`, string(resp.Result[0].Parameters))
+		})
+
+		t.Run("Missing parameter", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "POST", "/api/downlink-templates/"+id+"/invoke", InvokeDownlinkTemplateRequest{
+				DevEUI: d.DevEUI.String(),
+			})
+			assert.Equal(http.StatusBadRequest, rec.Code)
+			assert.Len(nsClient.CreateDeviceQueueItemChan, 0)
+		})
+	})
+
+	ts.T().Run("Delete", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "DELETE", "/api/downlink-templates/"+id, nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		rec = httpTestRequest(r, "GET", "/api/downlink-templates/"+id, nil)
+		assert.Equal(http.StatusNotFound, rec.Code)
+	})
+}
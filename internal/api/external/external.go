@@ -47,6 +47,8 @@ var (
 	corsAllowOrigin string
 
 	applicationServerID uuid.UUID
+
+	server *http.Server
 )
 
 // Setup configures the API package.
@@ -76,6 +78,17 @@ func Setup(conf config.Config) error {
 	return setupAPI(conf)
 }
 
+// Shutdown stops the API server. It stops accepting new connections and
+// blocks until the pending requests have been handled or the context is
+// cancelled.
+func Shutdown(ctx context.Context) error {
+	if server == nil {
+		return nil
+	}
+
+	return server.Shutdown(ctx)
+}
+
 func setupAPI(conf config.Config) error {
 	validator := auth.NewJWTValidator(storage.DB(), "HS256", jwtSecret)
 	rpID, err := uuid.FromString(conf.ApplicationServer.ID)
@@ -129,6 +142,11 @@ func setupAPI(conf config.Config) error {
 	})
 
 	// start the API server
+	server = &http.Server{
+		Addr:    bind,
+		Handler: h2c.NewHandler(handler, &http2.Server{}),
+	}
+
 	go func() {
 		log.WithFields(log.Fields{
 			"bind":     bind,
@@ -136,15 +154,14 @@ func setupAPI(conf config.Config) error {
 			"tls-key":  tlsKey,
 		}).Info("api/external: starting api server")
 
+		var err error
 		if tlsCert == "" || tlsKey == "" {
-			log.Fatal(http.ListenAndServe(bind, h2c.NewHandler(handler, &http2.Server{})))
+			err = server.ListenAndServe()
 		} else {
-			log.Fatal(http.ListenAndServeTLS(
-				bind,
-				tlsCert,
-				tlsKey,
-				h2c.NewHandler(handler, &http2.Server{}),
-			))
+			err = server.ListenAndServeTLS(tlsCert, tlsKey)
+		}
+		if err != http.ErrServerClosed {
+			log.Fatal(err)
 		}
 	}()
 
@@ -152,7 +169,7 @@ func setupAPI(conf config.Config) error {
 	time.Sleep(time.Millisecond * 100)
 
 	// setup the HTTP handler
-	clientHTTPHandler, err = setupHTTPAPI(conf)
+	clientHTTPHandler, err = setupHTTPAPI(conf, validator)
 	if err != nil {
 		return err
 	}
@@ -160,8 +177,9 @@ func setupAPI(conf config.Config) error {
 	return nil
 }
 
-func setupHTTPAPI(conf config.Config) (http.Handler, error) {
+func setupHTTPAPI(conf config.Config, validator auth.Validator) (http.Handler, error) {
 	r := mux.NewRouter()
+	r.Use(prometheusMiddleware(conf.Monitoring.PrometheusAPITimingHistogram || conf.Metrics.Prometheus.APITimingHistogram))
 
 	// setup json api handler
 	jsonHandler, err := getJSONGateway(context.Background())
@@ -169,6 +187,54 @@ func setupHTTPAPI(conf config.Config) (http.Handler, error) {
 		return nil, err
 	}
 
+	// these must be registered before the grpc-gateway handler, as it
+	// handles all requests under the /api prefix
+	NewApplicationRetentionAPI(validator).Register(r)
+	NewDeviceFrameLogAPI(validator).Register(r)
+	NewDeviceCodecAPI(validator).Register(r)
+	NewDeviceProfileCodecAPI(validator).Register(r)
+	NewCodecLibraryAPI(validator).Register(r)
+	NewDeviceProfileMeasurementAPI(validator).Register(r)
+	NewDeviceProfileDownlinkValidationAPI(validator).Register(r)
+	NewDeviceProfileConfirmedDownlinkRetryAPI(validator).Register(r)
+	NewDeviceQueueExpiryAPI(validator).Register(r)
+	NewMulticastGroupGatewaySelectionAPI(validator, conf.ApplicationServer.FUOTADeployment.GatewaySelectionWindow).Register(r)
+	NewDeviceClassBAPI(validator).Register(r)
+	NewDownlinkTemplateAPI(validator).Register(r)
+	NewFirmwareCampaignAPI(validator).Register(r)
+	NewDownlinkCorrelationAPI(validator).Register(r)
+	NewDownlinkRuleAPI(validator).Register(r)
+	NewDeviceProfileCommandAPI(validator).Register(r)
+	NewDeviceSessionAPI(validator).Register(r)
+	NewDeviceKeysRotationAPI(validator).Register(r)
+	NewOrganizationUsageAPI(validator).Register(r)
+	NewApplicationOverviewAPI(validator).Register(r)
+	NewApplicationSLAAPI(validator).Register(r)
+	NewSystemLoadAPI(validator).Register(r)
+	NewNetworkServerRegionAPI(validator).Register(r)
+	NewDeviceLinkQualityAPI(validator).Register(r)
+	NewRelayAPI(validator).Register(r)
+	NewGatewayCoverageAPI(validator).Register(r)
+	NewGatewayDiversityAPI(validator).Register(r)
+	NewAutomationAPI(validator).Register(r)
+	NewMaintenanceWindowAPI(validator).Register(r)
+	NewZoneAPI(validator).Register(r)
+	NewThresholdProfileAPI(validator).Register(r)
+	NewReportSubscriptionAPI(validator).Register(r)
+	NewDeviceGroupAPI(validator).Register(r)
+	NewGeofenceAPI(validator).Register(r)
+	NewMapAPI(validator).Register(r)
+	NewNotificationRouteAPI(validator).Register(r)
+	NewInAppNotificationAPI(validator).Register(r)
+	NewContactAPI(validator).Register(r)
+	NewMeterAPI(validator).Register(r)
+	NewIrrigationAPI(validator).Register(r)
+
+	if conf.ApplicationServer.ExternalAPI.V4Compat {
+		log.WithField("path", v4CompatPrefix+"/api").Info("api/external: registering chirpstack v4 compatibility api")
+		NewV4CompatAPI(validator).Register(r)
+	}
+
 	log.WithField("path", "/api").Info("api/external: registering rest api handler and documentation endpoint")
 	r.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
 		data, err := static.Asset("swagger/index.html")
@@ -0,0 +1,371 @@
+package external
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+// firmwareCampaignListMaxLimit defines the max. number of firmware campaigns
+// or campaign devices returned by a single list request.
+const firmwareCampaignListMaxLimit = 100
+
+// FirmwareCampaign defines a firmware update campaign for unicast devices.
+type FirmwareCampaign struct {
+	ID              string     `json:"id"`
+	ApplicationID   int64      `json:"applicationID,string"`
+	Name            string     `json:"name"`
+	FPort           uint8      `json:"fPort"`
+	ChunkSize       int        `json:"chunkSize"`
+	SpreadingFactor int        `json:"spreadingFactor"`
+	Bandwidth       int        `json:"bandwidth"`
+	DutyCycle       float64    `json:"dutyCycle"`
+	ChunkInterval   float64    `json:"chunkIntervalSeconds"`
+	State           string     `json:"state"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
+	CompletedAt     *time.Time `json:"completedAt"`
+}
+
+// FirmwareCampaignProgress defines the progress of a firmware campaign.
+type FirmwareCampaignProgress struct {
+	DevicePendingCount int `json:"devicePendingCount"`
+	DeviceSuccessCount int `json:"deviceSuccessCount"`
+	DeviceErrorCount   int `json:"deviceErrorCount"`
+	ChunksSent         int `json:"chunksSent"`
+	ChunksTotal        int `json:"chunksTotal"`
+}
+
+// FirmwareCampaignDevice defines the status of a device within a firmware
+// campaign.
+type FirmwareCampaignDevice struct {
+	DevEUI         string     `json:"devEUI"`
+	DeviceName     string     `json:"deviceName"`
+	State          string     `json:"state"`
+	ChunksSent     int        `json:"chunksSent"`
+	ChunkCount     int        `json:"chunkCount"`
+	NextChunkAfter time.Time  `json:"nextChunkAfter"`
+	ErrorMessage   string     `json:"errorMessage"`
+	CompletedAt    *time.Time `json:"completedAt"`
+}
+
+// CreateFirmwareCampaignRequest defines the create firmware campaign request.
+// The ChunkInterval (in seconds) is raised to the min. interval required by
+// the duty-cycle.
+type CreateFirmwareCampaignRequest struct {
+	Name            string   `json:"name"`
+	FPort           uint8    `json:"fPort"`
+	Payload         []byte   `json:"payload"`
+	ChunkSize       int      `json:"chunkSize"`
+	SpreadingFactor int      `json:"spreadingFactor"`
+	Bandwidth       int      `json:"bandwidth"`
+	DutyCycle       float64  `json:"dutyCycle"`
+	ChunkInterval   float64  `json:"chunkIntervalSeconds"`
+	DevEUIs         []string `json:"devEUIs"`
+}
+
+// CreateFirmwareCampaignResponse defines the create firmware campaign
+// response.
+type CreateFirmwareCampaignResponse struct {
+	ID string `json:"id"`
+}
+
+// GetFirmwareCampaignResponse defines the get firmware campaign response.
+type GetFirmwareCampaignResponse struct {
+	FirmwareCampaign FirmwareCampaign         `json:"firmwareCampaign"`
+	Progress         FirmwareCampaignProgress `json:"progress"`
+}
+
+// ListFirmwareCampaignResponse defines the list firmware campaigns response.
+type ListFirmwareCampaignResponse struct {
+	TotalCount int                `json:"totalCount,string"`
+	Result     []FirmwareCampaign `json:"result"`
+}
+
+// ListFirmwareCampaignDevicesResponse defines the list firmware campaign
+// devices response.
+type ListFirmwareCampaignDevicesResponse struct {
+	TotalCount int                      `json:"totalCount,string"`
+	Result     []FirmwareCampaignDevice `json:"result"`
+}
+
+// FirmwareCampaignAPI exports the firmware campaign related functions.
+type FirmwareCampaignAPI struct {
+	validator auth.Validator
+}
+
+// NewFirmwareCampaignAPI creates a new FirmwareCampaignAPI.
+func NewFirmwareCampaignAPI(validator auth.Validator) *FirmwareCampaignAPI {
+	return &FirmwareCampaignAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *FirmwareCampaignAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/applications/{application_id}/firmware-campaigns", a.Create).Methods("POST")
+	r.HandleFunc("/api/applications/{application_id}/firmware-campaigns", a.List).Methods("GET")
+	r.HandleFunc("/api/firmware-campaigns/{id}", a.Get).Methods("GET")
+	r.HandleFunc("/api/firmware-campaigns/{id}", a.Delete).Methods("DELETE")
+	r.HandleFunc("/api/firmware-campaigns/{id}/devices", a.ListDevices).Methods("GET")
+}
+
+// Create creates the given firmware campaign for the given devices, which
+// must belong to the application.
+func (a *FirmwareCampaignAPI) Create(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(applicationID, auth.Update),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req CreateFirmwareCampaignRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var devEUIs []lorawan.EUI64
+	for _, s := range req.DevEUIs {
+		var devEUI lorawan.EUI64
+		if err := devEUI.UnmarshalText([]byte(s)); err != nil {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "devEUIs: %s", err))
+			return
+		}
+
+		d, err := storage.GetDevice(ctx, storage.DB(), devEUI, false, true)
+		if err != nil {
+			httpWriteError(w, err)
+			return
+		}
+		if d.ApplicationID != applicationID {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "device %s does not belong to the application", devEUI))
+			return
+		}
+
+		devEUIs = append(devEUIs, devEUI)
+	}
+
+	fc := storage.FirmwareCampaign{
+		ApplicationID:   applicationID,
+		Name:            req.Name,
+		FPort:           req.FPort,
+		Payload:         req.Payload,
+		ChunkSize:       req.ChunkSize,
+		SpreadingFactor: req.SpreadingFactor,
+		Bandwidth:       req.Bandwidth,
+		DutyCycle:       req.DutyCycle,
+		ChunkInterval:   time.Duration(req.ChunkInterval * float64(time.Second)),
+	}
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		return storage.CreateFirmwareCampaign(ctx, tx, &fc, devEUIs)
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, CreateFirmwareCampaignResponse{
+		ID: fc.ID.String(),
+	})
+}
+
+// List lists the firmware campaigns of the application.
+func (a *FirmwareCampaignAPI) List(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	limit, offset, err := httpLimitOffset(r, firmwareCampaignListMaxLimit)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(applicationID, auth.Read),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	count, err := storage.GetFirmwareCampaignCount(ctx, storage.DB(), applicationID)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	campaigns, err := storage.GetFirmwareCampaigns(ctx, storage.DB(), applicationID, limit, offset)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListFirmwareCampaignResponse{
+		TotalCount: count,
+		Result:     []FirmwareCampaign{},
+	}
+	for _, fc := range campaigns {
+		resp.Result = append(resp.Result, firmwareCampaignFromStorage(fc))
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// Get returns the firmware campaign and its progress.
+func (a *FirmwareCampaignAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	fc, err := a.getFirmwareCampaign(ctx, r, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	p, err := storage.GetFirmwareCampaignProgress(ctx, storage.DB(), fc.ID)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, GetFirmwareCampaignResponse{
+		FirmwareCampaign: firmwareCampaignFromStorage(fc),
+		Progress: FirmwareCampaignProgress{
+			DevicePendingCount: p.DevicePendingCount,
+			DeviceSuccessCount: p.DeviceSuccessCount,
+			DeviceErrorCount:   p.DeviceErrorCount,
+			ChunksSent:         p.ChunksSent,
+			ChunksTotal:        p.ChunksTotal,
+		},
+	})
+}
+
+// Delete deletes (and thereby cancels) the firmware campaign.
+func (a *FirmwareCampaignAPI) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	fc, err := a.getFirmwareCampaign(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := storage.DeleteFirmwareCampaign(ctx, storage.DB(), fc.ID); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// ListDevices lists the devices of the firmware campaign, including the
+// per-device progress.
+func (a *FirmwareCampaignAPI) ListDevices(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	limit, offset, err := httpLimitOffset(r, firmwareCampaignListMaxLimit)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	fc, err := a.getFirmwareCampaign(ctx, r, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	count, err := storage.GetFirmwareCampaignDeviceCount(ctx, storage.DB(), fc.ID)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	devices, err := storage.GetFirmwareCampaignDevices(ctx, storage.DB(), fc.ID, limit, offset)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListFirmwareCampaignDevicesResponse{
+		TotalCount: count,
+		Result:     []FirmwareCampaignDevice{},
+	}
+	for _, d := range devices {
+		resp.Result = append(resp.Result, FirmwareCampaignDevice{
+			DevEUI:         d.DevEUI.String(),
+			DeviceName:     d.DeviceName,
+			State:          string(d.State),
+			ChunksSent:     d.NextChunk,
+			ChunkCount:     d.ChunkCount,
+			NextChunkAfter: d.NextChunkAfter,
+			ErrorMessage:   d.ErrorMessage,
+			CompletedAt:    d.CompletedAt,
+		})
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// getFirmwareCampaign returns the firmware campaign of the id route variable
+// and validates that the client has the requested access to its application.
+func (a *FirmwareCampaignAPI) getFirmwareCampaign(ctx context.Context, r *http.Request, flag auth.Flag) (storage.FirmwareCampaign, error) {
+	id, err := httpUUIDVar(r, "id")
+	if err != nil {
+		return storage.FirmwareCampaign{}, err
+	}
+
+	fc, err := storage.GetFirmwareCampaign(ctx, storage.DB(), id)
+	if err != nil {
+		return fc, err
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(fc.ApplicationID, flag),
+	); err != nil {
+		return fc, err
+	}
+
+	return fc, nil
+}
+
+func firmwareCampaignFromStorage(fc storage.FirmwareCampaign) FirmwareCampaign {
+	return FirmwareCampaign{
+		ID:              fc.ID.String(),
+		ApplicationID:   fc.ApplicationID,
+		Name:            fc.Name,
+		FPort:           fc.FPort,
+		ChunkSize:       fc.ChunkSize,
+		SpreadingFactor: fc.SpreadingFactor,
+		Bandwidth:       fc.Bandwidth,
+		DutyCycle:       fc.DutyCycle,
+		ChunkInterval:   fc.ChunkInterval.Seconds(),
+		State:           string(fc.State),
+		CreatedAt:       fc.CreatedAt,
+		UpdatedAt:       fc.UpdatedAt,
+		CompletedAt:     fc.CompletedAt,
+	}
+}
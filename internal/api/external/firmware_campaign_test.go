@@ -0,0 +1,172 @@
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *APITestSuite) TestFirmwareCampaign() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewFirmwareCampaignAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	dp := storage.DeviceProfile{
+		Name:            "test-dp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateDeviceProfile(context.Background(), storage.DB(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	app := storage.Application{
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+		Name:             "test-app",
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	app2 := storage.Application{
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+		Name:             "test-app-2",
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app2))
+
+	d := storage.Device{
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "test-node",
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+	}
+	assert.NoError(storage.CreateDevice(context.Background(), storage.DB(), &d))
+
+	req := CreateFirmwareCampaignRequest{
+		Name:            "firmware-v2",
+		FPort:           200,
+		Payload:         []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+		ChunkSize:       9,
+		SpreadingFactor: 9,
+		Bandwidth:       125,
+		DutyCycle:       0.01,
+		ChunkInterval:   1,
+		DevEUIs:         []string{d.DevEUI.String()},
+	}
+
+	ts.T().Run("Create for device of other application", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/firmware-campaigns", app2.ID), req)
+		assert.Equal(http.StatusBadRequest, rec.Code)
+	})
+
+	ts.T().Run("Create invalid", func(t *testing.T) {
+		assert := require.New(t)
+
+		req := req
+		req.Bandwidth = 100
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/firmware-campaigns", app.ID), req)
+		assert.Equal(http.StatusBadRequest, rec.Code)
+	})
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/firmware-campaigns", app.ID), req)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp CreateFirmwareCampaignResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		id := resp.ID
+
+		t.Run("Get", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "GET", "/api/firmware-campaigns/"+id, nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			var resp GetFirmwareCampaignResponse
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal("firmware-v2", resp.FirmwareCampaign.Name)
+			assert.Equal("RUNNING", resp.FirmwareCampaign.State)
+			assert.True(resp.FirmwareCampaign.ChunkInterval > 1)
+			assert.Equal(FirmwareCampaignProgress{
+				DevicePendingCount: 1,
+				ChunksTotal:        2,
+			}, resp.Progress)
+		})
+
+		t.Run("List", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "GET", fmt.Sprintf("/api/applications/%d/firmware-campaigns", app.ID), nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			var resp ListFirmwareCampaignResponse
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal(1, resp.TotalCount)
+			assert.Len(resp.Result, 1)
+		})
+
+		t.Run("List devices", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "GET", "/api/firmware-campaigns/"+id+"/devices", nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			var resp ListFirmwareCampaignDevicesResponse
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal(1, resp.TotalCount)
+			assert.Equal(d.DevEUI.String(), resp.Result[0].DevEUI)
+			assert.Equal("PENDING", resp.Result[0].State)
+			assert.Equal(2, resp.Result[0].ChunkCount)
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "DELETE", "/api/firmware-campaigns/"+id, nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			rec = httpTestRequest(r, "GET", "/api/firmware-campaigns/"+id, nil)
+			assert.Equal(http.StatusNotFound, rec.Code)
+		})
+	})
+}
@@ -0,0 +1,130 @@
+package external
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/coverage"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+// gatewayCoverageDefaultRange defines the default time range of the uplinks
+// used for the coverage estimation.
+const gatewayCoverageDefaultRange = 30 * 24 * time.Hour
+
+// gatewayCoverageDefaultCellSize defines the default cell size (meters).
+const gatewayCoverageDefaultCellSize = 250
+
+// GatewayCoverageAPI exports the gateway coverage related functions.
+type GatewayCoverageAPI struct {
+	validator auth.Validator
+}
+
+// NewGatewayCoverageAPI creates a new GatewayCoverageAPI.
+func NewGatewayCoverageAPI(validator auth.Validator) *GatewayCoverageAPI {
+	return &GatewayCoverageAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *GatewayCoverageAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/gateways/{gateway_id}/coverage", a.Get).Methods("GET")
+}
+
+// Get returns the estimated coverage of the given gateway as GeoJSON feature
+// collection, based on the uplinks of the located devices of the gateway
+// organization received within the requested time range (start and end as
+// RFC3339 timestamps, by default the last 30 days). The cell size (meters)
+// can be set using the cellSize query parameter.
+func (a *GatewayCoverageAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	gatewayID, err := httpEUI64Var(r, "gateway_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateGatewayAccess(auth.Read, gatewayID),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	end := time.Now()
+	start := end.Add(-gatewayCoverageDefaultRange)
+	opts := coverage.Options{
+		CellSize: gatewayCoverageDefaultCellSize,
+	}
+
+	q := r.URL.Query()
+	if v := q.Get("start"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "start: %s", err))
+			return
+		}
+		start = t
+	}
+	if v := q.Get("end"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "end: %s", err))
+			return
+		}
+		end = t
+	}
+	if v := q.Get("cellSize"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 10 || f > 10000 {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "cellSize must be between 10 and 10000 meters"))
+			return
+		}
+		opts.CellSize = f
+	}
+
+	gw, err := storage.GetGateway(ctx, storage.DB(), gatewayID, false)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var items []storage.GatewayCoverageSample
+	err = storage.ForOrganization(ctx, storage.DB(), gw.OrganizationID, func(db sqlx.Ext) error {
+		var err error
+		items, err = storage.GetGatewayCoverageSamples(ctx, db, gatewayID, gw.OrganizationID, start, end)
+		return err
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	samples := make([]coverage.Sample, 0, len(items))
+	for _, item := range items {
+		samples = append(samples, coverage.Sample{
+			Location: coverage.Location{
+				Latitude:  item.Latitude,
+				Longitude: item.Longitude,
+			},
+			Frames:  item.Frames,
+			RSSI:    item.AvgRSSI,
+			LoRaSNR: item.AvgLoRaSNR,
+		})
+	}
+
+	httpWriteJSON(w, coverage.Estimate(coverage.Location{
+		Latitude:  gw.Latitude,
+		Longitude: gw.Longitude,
+	}, samples, opts))
+}
@@ -0,0 +1,107 @@
+package external
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/coverage"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *APITestSuite) TestGatewayCoverage() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewGatewayCoverageAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	gw := storage.Gateway{
+		MAC:             lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		Name:            "test-gw",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+		Latitude:        52.1,
+		Longitude:       4.2,
+	}
+	assert.NoError(storage.CreateGateway(context.Background(), storage.DB(), &gw))
+
+	tests := []struct {
+		Name         string
+		Path         string
+		ExpectedCode int
+	}{
+		{
+			Name:         "invalid gateway ID",
+			Path:         "/api/gateways/foo/coverage",
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			Name:         "invalid time range",
+			Path:         "/api/gateways/0102030405060708/coverage?start=yesterday",
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			Name:         "invalid cell size",
+			Path:         "/api/gateways/0102030405060708/coverage?cellSize=1",
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			Name:         "gateway does not exist",
+			Path:         "/api/gateways/0807060504030201/coverage",
+			ExpectedCode: http.StatusNotFound,
+		},
+		{
+			Name:         "no uplinks",
+			Path:         "/api/gateways/0102030405060708/coverage?cellSize=500",
+			ExpectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tst := range tests {
+		ts.T().Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "GET", tst.Path, nil)
+			assert.Equal(tst.ExpectedCode, rec.Code)
+
+			if tst.ExpectedCode != http.StatusOK {
+				return
+			}
+
+			var fc struct {
+				Type     string `json:"type"`
+				Features []struct {
+					Geometry   coverage.Geometry          `json:"geometry"`
+					Properties coverage.GatewayProperties `json:"properties"`
+				} `json:"features"`
+			}
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&fc))
+			assert.Equal("FeatureCollection", fc.Type)
+			assert.Len(fc.Features, 1)
+			assert.Equal("Point", fc.Features[0].Geometry.Type)
+			assert.Equal(0, fc.Features[0].Properties.Devices)
+		})
+	}
+}
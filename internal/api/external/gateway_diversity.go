@@ -0,0 +1,211 @@
+package external
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/diversity"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+// gatewayDiversityMaxFrames defines the max. number of device frames which
+// are analyzed by a single request.
+const gatewayDiversityMaxFrames = 10000
+
+// gatewayDiversityDefaultRange defines the default time range of the
+// statistics.
+const gatewayDiversityDefaultRange = 7 * 24 * time.Hour
+
+// GetDeviceGatewayDiversityResponse defines the device gateway-diversity
+// response.
+type GetDeviceGatewayDiversityResponse struct {
+	Start time.Time             `json:"start"`
+	End   time.Time             `json:"end"`
+	Stats diversity.DeviceStats `json:"stats"`
+}
+
+// GatewayRedundancyDevice defines the frames of a device received by the
+// gateway and the frames for which the gateway was the sole receiver.
+type GatewayRedundancyDevice struct {
+	DevEUI             lorawan.EUI64 `json:"devEUI"`
+	Frames             int           `json:"frames"`
+	SoleReceiverFrames int           `json:"soleReceiverFrames"`
+}
+
+// GetGatewayRedundancyResponse defines the gateway redundancy response.
+// SoleReceiverDevices is the number of devices of which all received frames
+// were received by this gateway only, these devices are likely to lose
+// coverage when the gateway is decommissioned.
+type GetGatewayRedundancyResponse struct {
+	Start               time.Time                 `json:"start"`
+	End                 time.Time                 `json:"end"`
+	Frames              int                       `json:"frames"`
+	SoleReceiverFrames  int                       `json:"soleReceiverFrames"`
+	SoleReceiverDevices int                       `json:"soleReceiverDevices"`
+	Devices             []GatewayRedundancyDevice `json:"devices"`
+}
+
+// GatewayDiversityAPI exports the gateway-diversity related functions.
+type GatewayDiversityAPI struct {
+	validator auth.Validator
+}
+
+// NewGatewayDiversityAPI creates a new GatewayDiversityAPI.
+func NewGatewayDiversityAPI(validator auth.Validator) *GatewayDiversityAPI {
+	return &GatewayDiversityAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *GatewayDiversityAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/devices/{dev_eui}/gateway-diversity", a.GetDevice).Methods("GET")
+	r.HandleFunc("/api/gateways/{gateway_id}/redundancy", a.GetGateway).Methods("GET")
+}
+
+// GetDevice returns for the given device how many gateways received each
+// frame and per gateway the number of frames for which it was the sole
+// receiver, within the requested time range (start and end as RFC3339
+// timestamps, by default the last 7 days).
+func (a *GatewayDiversityAPI) GetDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpEUI64Var(r, "dev_eui")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateNodeAccess(devEUI, auth.Read),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	start, end, err := gatewayDiversityTimeRange(r)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var frames []storage.DeviceFrameLog
+	err = storage.ForDeviceOrganization(ctx, storage.DB(), devEUI, func(db sqlx.Ext) error {
+		var err error
+		frames, err = storage.GetDeviceFrameLogs(ctx, db, devEUI, start, end, gatewayDiversityMaxFrames)
+		return err
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	stats, err := diversity.Device(frames)
+	if err != nil {
+		httpWriteError(w, grpc.Errorf(codes.Internal, "gateway-diversity error: %s", err))
+		return
+	}
+
+	httpWriteJSON(w, GetDeviceGatewayDiversityResponse{
+		Start: start,
+		End:   end,
+		Stats: stats,
+	})
+}
+
+// GetGateway returns for the devices of the gateway organization the frames
+// received by the given gateway and for how many of these it was the sole
+// receiver, within the requested time range (start and end as RFC3339
+// timestamps, by default the last 7 days).
+func (a *GatewayDiversityAPI) GetGateway(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	gatewayID, err := httpEUI64Var(r, "gateway_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateGatewayAccess(auth.Read, gatewayID),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	start, end, err := gatewayDiversityTimeRange(r)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	gw, err := storage.GetGateway(ctx, storage.DB(), gatewayID, false)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var items []storage.GatewayRedundancyDevice
+	err = storage.ForOrganization(ctx, storage.DB(), gw.OrganizationID, func(db sqlx.Ext) error {
+		var err error
+		items, err = storage.GetGatewayRedundancyDevices(ctx, db, gatewayID, gw.OrganizationID, start, end)
+		return err
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := GetGatewayRedundancyResponse{
+		Start:   start,
+		End:     end,
+		Devices: []GatewayRedundancyDevice{},
+	}
+	for _, item := range items {
+		resp.Frames += item.Frames
+		resp.SoleReceiverFrames += item.SoleReceiverFrames
+		if item.SoleReceiverFrames == item.Frames {
+			resp.SoleReceiverDevices++
+		}
+
+		resp.Devices = append(resp.Devices, GatewayRedundancyDevice{
+			DevEUI:             item.DevEUI,
+			Frames:             item.Frames,
+			SoleReceiverFrames: item.SoleReceiverFrames,
+		})
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// gatewayDiversityTimeRange returns the start and end query parameters,
+// defaulting to the last 7 days.
+func gatewayDiversityTimeRange(r *http.Request) (time.Time, time.Time, error) {
+	end := time.Now()
+	start := end.Add(-gatewayDiversityDefaultRange)
+
+	q := r.URL.Query()
+	if v := q.Get("start"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return start, end, grpc.Errorf(codes.InvalidArgument, "start: %s", err)
+		}
+		start = t
+	}
+	if v := q.Get("end"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return start, end, grpc.Errorf(codes.InvalidArgument, "end: %s", err)
+		}
+		end = t
+	}
+
+	return start, end, nil
+}
@@ -0,0 +1,99 @@
+package external
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *APITestSuite) TestGatewayDiversity() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewGatewayDiversityAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	gw := storage.Gateway{
+		MAC:             lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		Name:            "test-gw",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(storage.CreateGateway(context.Background(), storage.DB(), &gw))
+
+	tests := []struct {
+		Name         string
+		Path         string
+		ExpectedCode int
+	}{
+		{
+			Name:         "invalid DevEUI",
+			Path:         "/api/devices/foo/gateway-diversity",
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			Name:         "invalid device time range",
+			Path:         "/api/devices/0102030405060708/gateway-diversity?end=tomorrow",
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			Name:         "device without frames",
+			Path:         "/api/devices/0102030405060708/gateway-diversity",
+			ExpectedCode: http.StatusOK,
+		},
+		{
+			Name:         "invalid gateway ID",
+			Path:         "/api/gateways/foo/redundancy",
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			Name:         "gateway does not exist",
+			Path:         "/api/gateways/0807060504030201/redundancy",
+			ExpectedCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tst := range tests {
+		ts.T().Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "GET", tst.Path, nil)
+			assert.Equal(tst.ExpectedCode, rec.Code)
+		})
+	}
+
+	ts.T().Run("Gateway without frames", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "GET", "/api/gateways/0102030405060708/redundancy", nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp GetGatewayRedundancyResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(0, resp.Frames)
+		assert.Equal([]GatewayRedundancyDevice{}, resp.Devices)
+	})
+}
@@ -0,0 +1,265 @@
+package external
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/geojson"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// geofenceListMaxLimit defines the max. number of geofences returned by a
+// single list request.
+const geofenceListMaxLimit = 1000
+
+// Geofence defines a named area of an application. The boundary is a
+// polygon ring of [longitude, latitude] positions (GeoJSON order). When
+// Zone is set, the geofence is used as boundary of the zone on the map (see
+// MapAPI).
+type Geofence struct {
+	ID            string       `json:"id"`
+	ApplicationID int64        `json:"applicationID,string"`
+	Name          string       `json:"name"`
+	Zone          string       `json:"zone"`
+	Boundary      geojson.Ring `json:"boundary"`
+	CreatedAt     time.Time    `json:"createdAt"`
+	UpdatedAt     time.Time    `json:"updatedAt"`
+}
+
+// CreateGeofenceRequest defines the create geofence request.
+type CreateGeofenceRequest struct {
+	Geofence Geofence `json:"geofence"`
+}
+
+// CreateGeofenceResponse defines the create geofence response.
+type CreateGeofenceResponse struct {
+	ID string `json:"id"`
+}
+
+// GetGeofenceResponse defines the get geofence response.
+type GetGeofenceResponse struct {
+	Geofence Geofence `json:"geofence"`
+}
+
+// UpdateGeofenceRequest defines the update geofence request.
+type UpdateGeofenceRequest struct {
+	Geofence Geofence `json:"geofence"`
+}
+
+// ListGeofencesResponse defines the list geofences response.
+type ListGeofencesResponse struct {
+	TotalCount int        `json:"totalCount"`
+	Result     []Geofence `json:"result"`
+}
+
+// GeofenceAPI exports the geofence related functions.
+type GeofenceAPI struct {
+	validator auth.Validator
+}
+
+// NewGeofenceAPI creates a new GeofenceAPI.
+func NewGeofenceAPI(validator auth.Validator) *GeofenceAPI {
+	return &GeofenceAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *GeofenceAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/applications/{application_id}/geofences", a.Create).Methods("POST")
+	r.HandleFunc("/api/applications/{application_id}/geofences", a.List).Methods("GET")
+	r.HandleFunc("/api/geofences/{id}", a.Get).Methods("GET")
+	r.HandleFunc("/api/geofences/{id}", a.Update).Methods("PUT")
+	r.HandleFunc("/api/geofences/{id}", a.Delete).Methods("DELETE")
+}
+
+// Create creates the given geofence for the application.
+func (a *GeofenceAPI) Create(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(applicationID, auth.Update),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req CreateGeofenceRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	g := storage.Geofence{
+		ApplicationID: applicationID,
+		Name:          req.Geofence.Name,
+		Zone:          req.Geofence.Zone,
+		Boundary:      req.Geofence.Boundary,
+	}
+
+	if err := storage.CreateGeofence(ctx, storage.DB(), &g); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, CreateGeofenceResponse{
+		ID: g.ID.String(),
+	})
+}
+
+// List lists the geofences of the application, sorted by name.
+func (a *GeofenceAPI) List(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	limit, offset, err := httpLimitOffset(r, geofenceListMaxLimit)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(applicationID, auth.Read),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	filters := storage.GeofenceFilters{
+		ApplicationID: applicationID,
+		Limit:         limit,
+		Offset:        offset,
+	}
+
+	count, err := storage.GetGeofenceCount(ctx, storage.DB(), filters)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	geofences, err := storage.GetGeofences(ctx, storage.DB(), filters)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListGeofencesResponse{
+		TotalCount: count,
+		Result:     []Geofence{},
+	}
+	for _, g := range geofences {
+		resp.Result = append(resp.Result, geofenceFromStorage(g))
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// Get returns the geofence.
+func (a *GeofenceAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	g, err := a.getGeofence(ctx, r, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, GetGeofenceResponse{
+		Geofence: geofenceFromStorage(g),
+	})
+}
+
+// Update updates the geofence.
+func (a *GeofenceAPI) Update(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	var req UpdateGeofenceRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	g, err := a.getGeofence(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	g.Name = req.Geofence.Name
+	g.Zone = req.Geofence.Zone
+	g.Boundary = req.Geofence.Boundary
+
+	if err := storage.UpdateGeofence(ctx, storage.DB(), &g); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// Delete deletes the geofence.
+func (a *GeofenceAPI) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	g, err := a.getGeofence(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := storage.DeleteGeofence(ctx, storage.DB(), g.ID); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// getGeofence returns the geofence of the id route variable and validates
+// that the client has the requested access to its application.
+func (a *GeofenceAPI) getGeofence(ctx context.Context, r *http.Request, flag auth.Flag) (storage.Geofence, error) {
+	id, err := httpUUIDVar(r, "id")
+	if err != nil {
+		return storage.Geofence{}, err
+	}
+
+	g, err := storage.GetGeofence(ctx, storage.DB(), id)
+	if err != nil {
+		return g, err
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(g.ApplicationID, flag),
+	); err != nil {
+		return g, err
+	}
+
+	return g, nil
+}
+
+func geofenceFromStorage(g storage.Geofence) Geofence {
+	return Geofence{
+		ID:            g.ID.String(),
+		ApplicationID: g.ApplicationID,
+		Name:          g.Name,
+		Zone:          g.Zone,
+		Boundary:      g.Boundary,
+		CreatedAt:     g.CreatedAt,
+		UpdatedAt:     g.UpdatedAt,
+	}
+}
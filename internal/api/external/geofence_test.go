@@ -0,0 +1,150 @@
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/geojson"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+func (ts *APITestSuite) TestGeofence() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewGeofenceAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := storage.Application{
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+		Name:             "test-app",
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	boundary := geojson.Ring{{4.25, 51.5}, {4.5, 51.5}, {4.5, 51.75}, {4.25, 51.75}}
+
+	ts.T().Run("Create invalid", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/geofences", app.ID), CreateGeofenceRequest{
+			Geofence: Geofence{
+				Name:     "farm",
+				Boundary: geojson.Ring{{4.25, 51.5}, {4.5, 51.5}},
+			},
+		})
+		assert.Equal(http.StatusBadRequest, rec.Code)
+	})
+
+	var id string
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/geofences", app.ID), CreateGeofenceRequest{
+			Geofence: Geofence{
+				Name:     "greenhouse",
+				Zone:     "greenhouse",
+				Boundary: boundary,
+			},
+		})
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp CreateGeofenceResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		id = resp.ID
+	})
+
+	ts.T().Run("Get", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "GET", "/api/geofences/"+id, nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp GetGeofenceResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(app.ID, resp.Geofence.ApplicationID)
+		assert.Equal("greenhouse", resp.Geofence.Name)
+		assert.Equal("greenhouse", resp.Geofence.Zone)
+		assert.Equal(boundary, resp.Geofence.Boundary)
+
+		rec = httpTestRequest(r, "GET", "/api/geofences/"+uuid.Must(uuid.NewV4()).String(), nil)
+		assert.Equal(http.StatusNotFound, rec.Code)
+	})
+
+	ts.T().Run("List", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "GET", fmt.Sprintf("/api/applications/%d/geofences?limit=10", app.ID), nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp ListGeofencesResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(1, resp.TotalCount)
+		assert.Len(resp.Result, 1)
+		assert.Equal(id, resp.Result[0].ID)
+	})
+
+	ts.T().Run("Update", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "PUT", "/api/geofences/"+id, UpdateGeofenceRequest{
+			Geofence: Geofence{
+				Name:     "north field",
+				Boundary: boundary[:3],
+			},
+		})
+		assert.Equal(http.StatusOK, rec.Code)
+
+		rec = httpTestRequest(r, "GET", "/api/geofences/"+id, nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp GetGeofenceResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal("north field", resp.Geofence.Name)
+		assert.Equal("", resp.Geofence.Zone)
+		assert.Len(resp.Geofence.Boundary, 3)
+	})
+
+	ts.T().Run("Delete", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "DELETE", "/api/geofences/"+id, nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		rec = httpTestRequest(r, "DELETE", "/api/geofences/"+id, nil)
+		assert.Equal(http.StatusNotFound, rec.Code)
+	})
+}
@@ -0,0 +1,153 @@
+package external
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/api/helpers"
+	//"github.com/brocaar/lorawan"
+)
+
+// The endpoints in this file are served as plain JSON over HTTP (next to the
+// grpc-gateway), as these are not part of the upstream API definitions.
+
+// httpErrorBody mirrors the error format of the grpc-gateway.
+type httpErrorBody struct {
+	Error   string `json:"error"`
+	Code    int32  `json:"code"`
+	Message string `json:"message"`
+}
+
+// httpContext returns the request context containing the authorization
+// header as gRPC metadata, so that the request can be validated by the
+// auth.Validator in the same way as the gRPC requests.
+func httpContext(r *http.Request) context.Context {
+	token := r.Header.Get("Grpc-Metadata-Authorization")
+	if token == "" {
+		token = r.Header.Get("Authorization")
+	}
+
+	return metadata.NewIncomingContext(r.Context(), metadata.Pairs("authorization", token))
+}
+
+// httpInt64Var returns the named (int64) route variable.
+func httpInt64Var(r *http.Request, name string) (int64, error) {
+	v, err := strconv.ParseInt(mux.Vars(r)[name], 10, 64)
+	if err != nil {
+		return 0, grpc.Errorf(codes.InvalidArgument, "%s: %s", name, err)
+	}
+	return v, nil
+}
+
+// httpUUIDVar returns the named (UUID) route variable.
+func httpUUIDVar(r *http.Request, name string) (uuid.UUID, error) {
+	v, err := uuid.FromString(mux.Vars(r)[name])
+	if err != nil {
+		return v, grpc.Errorf(codes.InvalidArgument, "%s: %s", name, err)
+	}
+	return v, nil
+}
+
+// httpEUI64Var returns the named (EUI64) route variable.
+func httpEUI64Var(r *http.Request, name string) (lorawan.EUI64, error) {
+	var v lorawan.EUI64
+	if err := v.UnmarshalText([]byte(mux.Vars(r)[name])); err != nil {
+		return v, grpc.Errorf(codes.InvalidArgument, "%s: %s", name, err)
+	}
+	return v, nil
+}
+
+// httpValidate validates the request context against the given validator
+// functions, in the same way as the gRPC requests.
+func httpValidate(ctx context.Context, v auth.Validator, funcs ...auth.ValidatorFunc) error {
+	if err := v.Validate(ctx, funcs...); err != nil {
+		return grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+	return nil
+}
+
+// httpValidateDevice returns the DevEUI of the dev_eui route variable and
+// validates that the client has the given access to the device.
+func httpValidateDevice(ctx context.Context, r *http.Request, v auth.Validator, flag auth.Flag) (lorawan.EUI64, error) {
+	devEUI, err := httpEUI64Var(r, "dev_eui")
+	if err != nil {
+		return devEUI, err
+	}
+
+	if err := httpValidate(ctx, v, auth.ValidateNodeAccess(devEUI, flag)); err != nil {
+		return devEUI, err
+	}
+
+	return devEUI, nil
+}
+
+// httpLimitOffset returns the limit and offset query parameters. The limit
+// defaults to maxLimit when not set.
+func httpLimitOffset(r *http.Request, maxLimit int) (int, int, error) {
+	q := r.URL.Query()
+
+	limit := maxLimit
+	if v := q.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > maxLimit {
+			return 0, 0, grpc.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", maxLimit)
+		}
+		limit = l
+	}
+
+	var offset int
+	if v := q.Get("offset"); v != "" {
+		o, err := strconv.Atoi(v)
+		if err != nil || o < 0 {
+			return 0, 0, grpc.Errorf(codes.InvalidArgument, "offset must be greater than or equal to 0")
+		}
+		offset = o
+	}
+
+	return limit, offset, nil
+}
+
+// httpDecodeJSON decodes the request body into v.
+func httpDecodeJSON(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "decode json error: %s", err)
+	}
+	return nil
+}
+
+// httpWriteJSON writes v as JSON response.
+func httpWriteJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.WithError(err).Error("api/external: encode json response error")
+	}
+}
+
+// httpWriteError writes the given error as JSON response. The HTTP status is
+// derived from the gRPC code of the error.
+func httpWriteError(w http.ResponseWriter, err error) {
+	st := status.Convert(helpers.ErrToRPCError(err))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(runtime.HTTPStatusFromCode(st.Code()))
+
+	if err := json.NewEncoder(w).Encode(httpErrorBody{
+		Error:   st.Message(),
+		Code:    int32(st.Code()),
+		Message: st.Message(),
+	}); err != nil {
+		log.WithError(err).Error("api/external: encode json error response error")
+	}
+}
@@ -0,0 +1,139 @@
+package external
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+// httpTestRequest serves the given request using the given handler. The body
+// can be nil, a raw JSON string or []byte, or a value which is JSON encoded.
+func httpTestRequest(h http.Handler, method, path string, body interface{}) *httptest.ResponseRecorder {
+	var r io.Reader
+	switch v := body.(type) {
+	case nil:
+	case string:
+		r = bytes.NewBufferString(v)
+	case []byte:
+		r = bytes.NewReader(v)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			panic(err)
+		}
+		r = bytes.NewReader(b)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, r))
+	return rec
+}
+
+// httpTestDecode asserts that the response has the given status code and
+// decodes its body into v.
+func httpTestDecode(t *testing.T, rec *httptest.ResponseRecorder, code int, v interface{}) {
+	assert := require.New(t)
+	assert.Equal(code, rec.Code, rec.Body.String())
+	assert.NoError(json.NewDecoder(rec.Body).Decode(v))
+}
+
+// createTestDeviceProfile creates an organization, network-server (using
+// the mock network-server client) and device-profile.
+func createTestDeviceProfile(t *testing.T) (storage.DeviceProfile, uuid.UUID) {
+	assert := require.New(t)
+
+	networkserver.SetPool(mock.NewPool(mock.NewClient()))
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	dp := storage.DeviceProfile{
+		Name:            "test-dp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateDeviceProfile(context.Background(), storage.DB(), &dp))
+
+	id, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	return dp, id
+}
+
+func TestHTTPRouteVars(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/{id}/{dev_eui}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := httpUUIDVar(r, "id")
+		if err != nil {
+			httpWriteError(w, err)
+			return
+		}
+
+		devEUI, err := httpEUI64Var(r, "dev_eui")
+		if err != nil {
+			httpWriteError(w, err)
+			return
+		}
+
+		httpWriteJSON(w, map[string]string{"id": id.String(), "devEUI": devEUI.String()})
+	})
+
+	t.Run("Valid", func(t *testing.T) {
+		var resp map[string]string
+		rec := httpTestRequest(r, "GET", "/bf1fd42d-2d2a-4bd9-9c5d-5b1b2b22a2d5/0102030405060708", nil)
+		httpTestDecode(t, rec, http.StatusOK, &resp)
+		require.Equal(t, map[string]string{
+			"id":     "bf1fd42d-2d2a-4bd9-9c5d-5b1b2b22a2d5",
+			"devEUI": lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}.String(),
+		}, resp)
+	})
+
+	t.Run("Invalid id", func(t *testing.T) {
+		var resp httpErrorBody
+		rec := httpTestRequest(r, "GET", "/foo/0102030405060708", nil)
+		httpTestDecode(t, rec, http.StatusBadRequest, &resp)
+		require.Contains(t, resp.Message, "id: ")
+	})
+
+	t.Run("Invalid dev_eui", func(t *testing.T) {
+		var resp httpErrorBody
+		rec := httpTestRequest(r, "GET", "/bf1fd42d-2d2a-4bd9-9c5d-5b1b2b22a2d5/foo", nil)
+		httpTestDecode(t, rec, http.StatusBadRequest, &resp)
+		require.Contains(t, resp.Message, "dev_eui: ")
+	})
+}
+
+func TestHTTPValidate(t *testing.T) {
+	assert := require.New(t)
+
+	assert.NoError(httpValidate(context.Background(), &TestValidator{}))
+
+	err := httpValidate(context.Background(), &TestValidator{returnError: errors.New("invalid token")})
+	assert.Error(err)
+
+	rec := httptest.NewRecorder()
+	httpWriteError(rec, err)
+	assert.Equal(http.StatusUnauthorized, rec.Code)
+}
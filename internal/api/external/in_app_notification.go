@@ -0,0 +1,219 @@
+package external
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+// inAppNotificationListMaxLimit defines the max. number of in-app
+// notifications returned by a single list request.
+const inAppNotificationListMaxLimit = 1000
+
+// InAppNotification defines a notification shown within the application,
+// stored by an IN_APP automation action or notification route. AlarmID and
+// DevEUI are set when the notification relates to an alarm or device.
+type InAppNotification struct {
+	ID        int64          `json:"id,string"`
+	AlarmID   string         `json:"alarmID,omitempty"`
+	DevEUI    *lorawan.EUI64 `json:"devEUI,omitempty"`
+	CreatedAt time.Time      `json:"createdAt"`
+	Severity  spec.Severity  `json:"severity"`
+	Subject   string         `json:"subject"`
+	Message   string         `json:"message"`
+	ReadAt    *time.Time     `json:"readAt,omitempty"`
+}
+
+// ListInAppNotificationsResponse defines the list in-app notifications
+// response. UnreadCount holds the number of unread notifications of the
+// application, regardless of the filters.
+type ListInAppNotificationsResponse struct {
+	TotalCount  int                 `json:"totalCount"`
+	UnreadCount int                 `json:"unreadCount"`
+	Result      []InAppNotification `json:"result"`
+}
+
+// MarkInAppNotificationsReadRequest defines the mark in-app notifications
+// read request. When IDs is empty, all the notifications of the application
+// are marked as read.
+type MarkInAppNotificationsReadRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// MarkInAppNotificationsReadResponse defines the mark in-app notifications
+// read response.
+type MarkInAppNotificationsReadResponse struct {
+	Count int64 `json:"count"`
+}
+
+// InAppNotificationAPI exports the in-app notification related functions.
+type InAppNotificationAPI struct {
+	validator auth.Validator
+}
+
+// NewInAppNotificationAPI creates a new InAppNotificationAPI.
+func NewInAppNotificationAPI(validator auth.Validator) *InAppNotificationAPI {
+	return &InAppNotificationAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *InAppNotificationAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/applications/{application_id}/in-app-notifications", a.List).Methods("GET")
+	r.HandleFunc("/api/applications/{application_id}/in-app-notifications/read", a.MarkRead).Methods("POST")
+}
+
+// List lists the in-app notifications of the application, most recent
+// first. When the unreadOnly query parameter is set to true, only the unread
+// notifications are returned. The severity query parameter filters the
+// notifications by severity (INFO, WARNING or CRITICAL).
+func (a *InAppNotificationAPI) List(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	limit, offset, err := httpLimitOffset(r, inAppNotificationListMaxLimit)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(applicationID, auth.Read),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	filters := storage.InAppNotificationFilters{
+		ApplicationID: applicationID,
+		Limit:         limit,
+		Offset:        offset,
+	}
+
+	q := r.URL.Query()
+
+	if v := q.Get("unreadOnly"); v != "" {
+		filters.UnreadOnly, err = strconv.ParseBool(v)
+		if err != nil {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "unreadOnly: %s", err))
+			return
+		}
+	}
+
+	if v := q.Get("severity"); v != "" {
+		filters.Severity = spec.Severity(v)
+		if err := filters.Severity.Validate(); err != nil {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "severity: %s", err))
+			return
+		}
+	}
+
+	count, err := storage.GetInAppNotificationCount(ctx, storage.DB(), filters)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	unread, err := storage.GetInAppNotificationCount(ctx, storage.DB(), storage.InAppNotificationFilters{
+		ApplicationID: applicationID,
+		UnreadOnly:    true,
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	notifications, err := storage.GetInAppNotifications(ctx, storage.DB(), filters)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListInAppNotificationsResponse{
+		TotalCount:  count,
+		UnreadCount: unread,
+		Result:      []InAppNotification{},
+	}
+	for _, n := range notifications {
+		resp.Result = append(resp.Result, inAppNotificationFromStorage(n))
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// MarkRead marks the given in-app notifications of the application as read.
+func (a *InAppNotificationAPI) MarkRead(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(applicationID, auth.Update),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req MarkInAppNotificationsReadRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var ids []int64
+	for _, v := range req.IDs {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "ids: %s", err))
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	count, err := storage.MarkInAppNotificationsRead(ctx, storage.DB(), applicationID, ids, time.Now())
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, MarkInAppNotificationsReadResponse{
+		Count: count,
+	})
+}
+
+func inAppNotificationFromStorage(n storage.InAppNotification) InAppNotification {
+	out := InAppNotification{
+		ID:        n.ID,
+		DevEUI:    n.DevEUI,
+		CreatedAt: n.CreatedAt,
+		Severity:  n.Severity,
+		Subject:   n.Subject,
+		Message:   n.Message,
+		ReadAt:    n.ReadAt,
+	}
+
+	if n.AlarmID != nil {
+		out.AlarmID = n.AlarmID.String()
+	}
+
+	return out
+}
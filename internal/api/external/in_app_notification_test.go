@@ -0,0 +1,122 @@
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+func (ts *APITestSuite) TestInAppNotification() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewInAppNotificationAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := storage.Application{
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+		Name:             "test-app",
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	now := time.Now()
+	notifications := []storage.InAppNotification{
+		{ApplicationID: app.ID, CreatedAt: now.Add(-time.Minute), Severity: spec.InfoSeverity, Subject: "[INFO] door opened"},
+		{ApplicationID: app.ID, CreatedAt: now, Severity: spec.CriticalSeverity, Subject: "[CRITICAL] cold room"},
+	}
+	for i := range notifications {
+		assert.NoError(storage.CreateInAppNotification(context.Background(), storage.DB(), &notifications[i]))
+	}
+
+	list := func(assert *require.Assertions, query string) ListInAppNotificationsResponse {
+		rec := httpTestRequest(r, "GET", fmt.Sprintf("/api/applications/%d/in-app-notifications?limit=10%s", app.ID, query), nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp ListInAppNotificationsResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		return resp
+	}
+
+	ts.T().Run("List", func(t *testing.T) {
+		assert := require.New(t)
+
+		resp := list(assert, "")
+		assert.Equal(2, resp.TotalCount)
+		assert.Equal(2, resp.UnreadCount)
+		assert.Len(resp.Result, 2)
+		assert.Equal(notifications[1].ID, resp.Result[0].ID)
+		assert.Equal("[CRITICAL] cold room", resp.Result[0].Subject)
+
+		resp = list(assert, "&severity=INFO")
+		assert.Equal(1, resp.TotalCount)
+		assert.Equal(2, resp.UnreadCount)
+		assert.Equal(notifications[0].ID, resp.Result[0].ID)
+
+		rec := httpTestRequest(r, "GET", fmt.Sprintf("/api/applications/%d/in-app-notifications?severity=FATAL", app.ID), nil)
+		assert.Equal(http.StatusBadRequest, rec.Code)
+	})
+
+	ts.T().Run("Mark read", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/in-app-notifications/read", app.ID), MarkInAppNotificationsReadRequest{
+			IDs: []string{strconv.FormatInt(notifications[1].ID, 10)},
+		})
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp MarkInAppNotificationsReadResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.EqualValues(1, resp.Count)
+
+		listResp := list(assert, "&unreadOnly=true")
+		assert.Equal(1, listResp.TotalCount)
+		assert.Equal(1, listResp.UnreadCount)
+		assert.Equal(notifications[0].ID, listResp.Result[0].ID)
+
+		// all notifications
+		rec = httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/in-app-notifications/read", app.ID), MarkInAppNotificationsReadRequest{})
+		assert.Equal(http.StatusOK, rec.Code)
+
+		listResp = list(assert, "")
+		assert.Equal(2, listResp.TotalCount)
+		assert.Equal(0, listResp.UnreadCount)
+		assert.NotNil(listResp.Result[0].ReadAt)
+	})
+}
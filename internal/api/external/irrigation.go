@@ -0,0 +1,536 @@
+package external
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/irrigation"
+	"github.com/ibrahimozekici/app-server2/internal/irrigation/schedule"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+const (
+	// irrigationScheduleListMaxLimit defines the max. number of irrigation
+	// schedules returned by a single list request.
+	irrigationScheduleListMaxLimit = 1000
+
+	// irrigationExecutionListMaxLimit defines the max. number of executions
+	// returned by a single list request.
+	irrigationExecutionListMaxLimit = 1000
+
+	// irrigationScheduleMaxDevices defines the max. number of devices of an
+	// irrigation schedule.
+	irrigationScheduleMaxDevices = 100
+)
+
+// IrrigationSchedule defines an irrigation schedule of an application. At
+// each activation of the Schedule (a CRON expression or relative to the
+// SUNRISE or SUNSET at a location), the StartDownlink is enqueued to the
+// devices (e.g. valves or relays) of the schedule. When StopDownlink is set,
+// it is enqueued DurationSeconds after the start. The {{ duration }}
+// placeholder of the downlink objects is replaced by DurationSeconds.
+//
+// NextRunAt, StopAt (the pending stop while irrigating) and PausedUntil are
+// read-only, see the override endpoints.
+type IrrigationSchedule struct {
+	ID              string             `json:"id"`
+	ApplicationID   int64              `json:"applicationID,string"`
+	Name            string             `json:"name"`
+	Enabled         bool               `json:"enabled"`
+	Schedule        schedule.Schedule  `json:"schedule"`
+	StartDownlink   schedule.Downlink  `json:"startDownlink"`
+	StopDownlink    *schedule.Downlink `json:"stopDownlink,omitempty"`
+	DurationSeconds int64              `json:"durationSeconds"`
+	DevEUIs         []lorawan.EUI64    `json:"devEUIs"`
+	NextRunAt       *time.Time         `json:"nextRunAt,omitempty"`
+	StopAt          *time.Time         `json:"stopAt,omitempty"`
+	PausedUntil     *time.Time         `json:"pausedUntil,omitempty"`
+	CreatedAt       time.Time          `json:"createdAt"`
+	UpdatedAt       time.Time          `json:"updatedAt"`
+}
+
+// IrrigationExecution defines a start or stop downlink enqueued for a device
+// of an irrigation schedule. Source is SCHEDULE or MANUAL (override).
+type IrrigationExecution struct {
+	ID        int64                    `json:"id,string"`
+	CreatedAt time.Time                `json:"createdAt"`
+	DevEUI    lorawan.EUI64            `json:"devEUI"`
+	Action    storage.IrrigationAction `json:"action"`
+	Source    storage.IrrigationSource `json:"source"`
+	Success   bool                     `json:"success"`
+	Error     string                   `json:"error,omitempty"`
+}
+
+// CreateIrrigationScheduleRequest defines the create irrigation schedule
+// request.
+type CreateIrrigationScheduleRequest struct {
+	IrrigationSchedule IrrigationSchedule `json:"irrigationSchedule"`
+}
+
+// CreateIrrigationScheduleResponse defines the create irrigation schedule
+// response.
+type CreateIrrigationScheduleResponse struct {
+	ID string `json:"id"`
+}
+
+// GetIrrigationScheduleResponse defines the get irrigation schedule
+// response.
+type GetIrrigationScheduleResponse struct {
+	IrrigationSchedule IrrigationSchedule `json:"irrigationSchedule"`
+}
+
+// UpdateIrrigationScheduleRequest defines the update irrigation schedule
+// request.
+type UpdateIrrigationScheduleRequest struct {
+	IrrigationSchedule IrrigationSchedule `json:"irrigationSchedule"`
+}
+
+// ListIrrigationSchedulesResponse defines the list irrigation schedules
+// response. The DevEUIs of the schedules are not returned (see Get).
+type ListIrrigationSchedulesResponse struct {
+	TotalCount int                  `json:"totalCount"`
+	Result     []IrrigationSchedule `json:"result"`
+}
+
+// ListIrrigationExecutionsResponse defines the list irrigation executions
+// response.
+type ListIrrigationExecutionsResponse struct {
+	TotalCount int                   `json:"totalCount"`
+	Result     []IrrigationExecution `json:"result"`
+}
+
+// StartIrrigationRequest defines the (optional) start irrigation request.
+// When DurationSeconds is set, it overrides the duration of the schedule.
+type StartIrrigationRequest struct {
+	DurationSeconds int64 `json:"durationSeconds"`
+}
+
+// PauseIrrigationScheduleRequest defines the pause irrigation schedule
+// request.
+type PauseIrrigationScheduleRequest struct {
+	Until time.Time `json:"until"`
+}
+
+// IrrigationAPI exports the irrigation schedule related functions.
+type IrrigationAPI struct {
+	validator auth.Validator
+}
+
+// NewIrrigationAPI creates a new IrrigationAPI.
+func NewIrrigationAPI(validator auth.Validator) *IrrigationAPI {
+	return &IrrigationAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *IrrigationAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/applications/{application_id}/irrigation-schedules", a.Create).Methods("POST")
+	r.HandleFunc("/api/applications/{application_id}/irrigation-schedules", a.List).Methods("GET")
+	r.HandleFunc("/api/irrigation-schedules/{id}", a.Get).Methods("GET")
+	r.HandleFunc("/api/irrigation-schedules/{id}", a.Update).Methods("PUT")
+	r.HandleFunc("/api/irrigation-schedules/{id}", a.Delete).Methods("DELETE")
+	r.HandleFunc("/api/irrigation-schedules/{id}/executions", a.ListExecutions).Methods("GET")
+	r.HandleFunc("/api/irrigation-schedules/{id}/start", a.Start).Methods("POST")
+	r.HandleFunc("/api/irrigation-schedules/{id}/stop", a.Stop).Methods("POST")
+	r.HandleFunc("/api/irrigation-schedules/{id}/pause", a.Pause).Methods("POST")
+	r.HandleFunc("/api/irrigation-schedules/{id}/pause", a.Resume).Methods("DELETE")
+}
+
+// Create creates the given irrigation schedule for the application.
+func (a *IrrigationAPI) Create(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(applicationID, auth.Update),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req CreateIrrigationScheduleRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if len(req.IrrigationSchedule.DevEUIs) > irrigationScheduleMaxDevices {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "max. %d devices are allowed", irrigationScheduleMaxDevices))
+		return
+	}
+
+	s := storage.IrrigationSchedule{
+		ApplicationID: applicationID,
+	}
+	irrigationScheduleToStorage(req.IrrigationSchedule, &s)
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		if err := storage.CreateIrrigationSchedule(ctx, tx, &s); err != nil {
+			return err
+		}
+		return storage.SetIrrigationScheduleDevices(ctx, tx, s, req.IrrigationSchedule.DevEUIs)
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, CreateIrrigationScheduleResponse{
+		ID: s.ID.String(),
+	})
+}
+
+// List lists the irrigation schedules of the application. When the devEUI
+// query parameter is set, only the schedules of this device are returned.
+func (a *IrrigationAPI) List(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	limit, offset, err := httpLimitOffset(r, irrigationScheduleListMaxLimit)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	filters := storage.IrrigationScheduleFilters{
+		ApplicationID: applicationID,
+		Limit:         limit,
+		Offset:        offset,
+	}
+
+	if v := r.URL.Query().Get("devEUI"); v != "" {
+		if err := filters.DevEUI.UnmarshalText([]byte(v)); err != nil {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "devEUI: %s", err))
+			return
+		}
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(applicationID, auth.Read),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	count, err := storage.GetIrrigationScheduleCount(ctx, storage.DB(), filters)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	items, err := storage.GetIrrigationSchedules(ctx, storage.DB(), filters)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListIrrigationSchedulesResponse{
+		TotalCount: count,
+		Result:     []IrrigationSchedule{},
+	}
+	for _, s := range items {
+		resp.Result = append(resp.Result, irrigationScheduleFromStorage(s, nil))
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// Get returns the irrigation schedule.
+func (a *IrrigationAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	s, err := a.getIrrigationSchedule(ctx, r, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	devices, err := storage.GetIrrigationScheduleDevices(ctx, storage.DB(), s.ID)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, GetIrrigationScheduleResponse{
+		IrrigationSchedule: irrigationScheduleFromStorage(s, devices),
+	})
+}
+
+// Update updates the irrigation schedule and replaces its devices.
+func (a *IrrigationAPI) Update(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	var req UpdateIrrigationScheduleRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if len(req.IrrigationSchedule.DevEUIs) > irrigationScheduleMaxDevices {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "max. %d devices are allowed", irrigationScheduleMaxDevices))
+		return
+	}
+
+	s, err := a.getIrrigationSchedule(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	irrigationScheduleToStorage(req.IrrigationSchedule, &s)
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		if err := storage.UpdateIrrigationSchedule(ctx, tx, &s); err != nil {
+			return err
+		}
+		return storage.SetIrrigationScheduleDevices(ctx, tx, s, req.IrrigationSchedule.DevEUIs)
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// Delete deletes the irrigation schedule. Note that a pending stop downlink
+// is not enqueued.
+func (a *IrrigationAPI) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	s, err := a.getIrrigationSchedule(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := storage.DeleteIrrigationSchedule(ctx, storage.DB(), s.ID); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// ListExecutions lists the execution log of the irrigation schedule, most
+// recent first.
+func (a *IrrigationAPI) ListExecutions(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	limit, offset, err := httpLimitOffset(r, irrigationExecutionListMaxLimit)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	s, err := a.getIrrigationSchedule(ctx, r, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	count, err := storage.GetIrrigationExecutionCount(ctx, storage.DB(), s.ID)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	executions, err := storage.GetIrrigationExecutions(ctx, storage.DB(), s.ID, limit, offset)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListIrrigationExecutionsResponse{
+		TotalCount: count,
+		Result:     []IrrigationExecution{},
+	}
+	for _, e := range executions {
+		resp.Result = append(resp.Result, IrrigationExecution{
+			ID:        e.ID,
+			CreatedAt: e.CreatedAt,
+			DevEUI:    e.DevEUI,
+			Action:    e.Action,
+			Source:    e.Source,
+			Success:   e.Success,
+			Error:     e.Error,
+		})
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// Start starts the irrigation immediately (manual override), regardless of
+// the schedule, pause and enabled state. See StartIrrigationRequest for the
+// optional duration.
+func (a *IrrigationAPI) Start(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	var req StartIrrigationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "decode json error: %s", err))
+		return
+	}
+
+	s, err := a.getIrrigationSchedule(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if req.DurationSeconds != 0 {
+		s.Duration = time.Duration(req.DurationSeconds) * time.Second
+		if err := s.Validate(); err != nil {
+			httpWriteError(w, err)
+			return
+		}
+	}
+
+	if err := irrigation.Start(ctx, s); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// Stop stops the irrigation immediately (manual override) by enqueueing the
+// stop downlink. The schedule must have a stop downlink.
+func (a *IrrigationAPI) Stop(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	s, err := a.getIrrigationSchedule(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := irrigation.Stop(ctx, s); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// Pause skips the scheduled activations until the given time, e.g. after
+// rainfall. A running irrigation is not stopped.
+func (a *IrrigationAPI) Pause(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	var req PauseIrrigationScheduleRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if !req.Until.After(time.Now()) {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "until must be in the future"))
+		return
+	}
+
+	s, err := a.getIrrigationSchedule(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := storage.SetIrrigationSchedulePausedUntil(ctx, storage.DB(), s.ID, &req.Until); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// Resume resumes the paused irrigation schedule.
+func (a *IrrigationAPI) Resume(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	s, err := a.getIrrigationSchedule(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := storage.SetIrrigationSchedulePausedUntil(ctx, storage.DB(), s.ID, nil); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// getIrrigationSchedule validates that the client has the requested access
+// to the irrigation schedule of the id route variable and returns it. The
+// access is validated before the schedule is fetched, so that unauthorized
+// clients can't tell which schedules exist.
+func (a *IrrigationAPI) getIrrigationSchedule(ctx context.Context, r *http.Request, flag auth.Flag) (storage.IrrigationSchedule, error) {
+	id, err := httpUUIDVar(r, "id")
+	if err != nil {
+		return storage.IrrigationSchedule{}, err
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateIrrigationScheduleAccess(id, flag),
+	); err != nil {
+		return storage.IrrigationSchedule{}, err
+	}
+
+	return storage.GetIrrigationSchedule(ctx, storage.DB(), id, false)
+}
+
+func irrigationScheduleToStorage(in IrrigationSchedule, s *storage.IrrigationSchedule) {
+	s.Name = in.Name
+	s.Enabled = in.Enabled
+	s.Schedule = in.Schedule
+	s.StartDownlink = in.StartDownlink
+	s.StopDownlink = in.StopDownlink
+	s.Duration = time.Duration(in.DurationSeconds) * time.Second
+}
+
+func irrigationScheduleFromStorage(s storage.IrrigationSchedule, devices []storage.Device) IrrigationSchedule {
+	out := IrrigationSchedule{
+		ID:              s.ID.String(),
+		ApplicationID:   s.ApplicationID,
+		Name:            s.Name,
+		Enabled:         s.Enabled,
+		Schedule:        s.Schedule,
+		StartDownlink:   s.StartDownlink,
+		StopDownlink:    s.StopDownlink,
+		DurationSeconds: int64(s.Duration / time.Second),
+		DevEUIs:         []lorawan.EUI64{},
+		NextRunAt:       s.NextRunAt,
+		StopAt:          s.StopAt,
+		PausedUntil:     s.PausedUntil,
+		CreatedAt:       s.CreatedAt,
+		UpdatedAt:       s.UpdatedAt,
+	}
+
+	for _, d := range devices {
+		out.DevEUIs = append(out.DevEUIs, d.DevEUI)
+	}
+
+	return out
+}
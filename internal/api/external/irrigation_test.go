@@ -0,0 +1,271 @@
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/irrigation/schedule"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *APITestSuite) TestIrrigation() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewIrrigationAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := storage.Application{
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+		Name:             "test-app",
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	dp := storage.DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(storage.CreateDeviceProfile(context.Background(), storage.DB(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	d := storage.Device{
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "valve-1",
+	}
+	assert.NoError(storage.CreateDevice(context.Background(), storage.DB(), &d))
+
+	s := IrrigationSchedule{
+		Name:            "field 1",
+		Enabled:         true,
+		Schedule:        schedule.Schedule{Type: schedule.SunSchedule, Event: schedule.Sunrise, OffsetMinutes: -30, Latitude: 52.37, Longitude: 4.89},
+		StartDownlink:   schedule.Downlink{FPort: 10, Object: `{"valve": "open", "seconds": "{{ duration }}"}`},
+		StopDownlink:    &schedule.Downlink{FPort: 10, Object: `{"valve": "close"}`},
+		DurationSeconds: 1800,
+		DevEUIs:         []lorawan.EUI64{d.DevEUI},
+	}
+
+	ts.T().Run("Create invalid", func(t *testing.T) {
+		assert := require.New(t)
+
+		invalid := s
+		invalid.Schedule = schedule.Schedule{Type: schedule.CronSchedule, Cron: "0 6 * *"}
+
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/irrigation-schedules", app.ID), CreateIrrigationScheduleRequest{
+			IrrigationSchedule: invalid,
+		})
+		assert.Equal(http.StatusBadRequest, rec.Code)
+
+		var resp httpErrorBody
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal("cron: expected 5 fields, got 4: invalid irrigation schedule", resp.Error)
+
+		// unknown device
+		invalid = s
+		invalid.DevEUIs = []lorawan.EUI64{{8, 7, 6, 5, 4, 3, 2, 1}}
+
+		rec = httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/irrigation-schedules", app.ID), CreateIrrigationScheduleRequest{
+			IrrigationSchedule: invalid,
+		})
+		assert.Equal(http.StatusBadRequest, rec.Code)
+	})
+
+	var id string
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/irrigation-schedules", app.ID), CreateIrrigationScheduleRequest{
+			IrrigationSchedule: s,
+		})
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp CreateIrrigationScheduleResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		id = resp.ID
+
+		t.Run("Get", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "GET", "/api/irrigation-schedules/"+id, nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			var resp GetIrrigationScheduleResponse
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal("field 1", resp.IrrigationSchedule.Name)
+			assert.Equal(app.ID, resp.IrrigationSchedule.ApplicationID)
+			assert.Equal(s.Schedule, resp.IrrigationSchedule.Schedule)
+			assert.Equal(s.StartDownlink, resp.IrrigationSchedule.StartDownlink)
+			assert.Equal(s.StopDownlink, resp.IrrigationSchedule.StopDownlink)
+			assert.Equal(int64(1800), resp.IrrigationSchedule.DurationSeconds)
+			assert.Equal(s.DevEUIs, resp.IrrigationSchedule.DevEUIs)
+			assert.NotNil(resp.IrrigationSchedule.NextRunAt)
+			assert.Nil(resp.IrrigationSchedule.StopAt)
+		})
+
+		t.Run("List", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "GET", fmt.Sprintf("/api/applications/%d/irrigation-schedules?limit=10&devEUI=%s", app.ID, d.DevEUI), nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			var resp ListIrrigationSchedulesResponse
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal(1, resp.TotalCount)
+			assert.Len(resp.Result, 1)
+
+			rec = httpTestRequest(r, "GET", fmt.Sprintf("/api/applications/%d/irrigation-schedules?limit=10&devEUI=0807060504030201", app.ID), nil)
+			assert.Equal(http.StatusOK, rec.Code)
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal(0, resp.TotalCount)
+		})
+
+		t.Run("Update", func(t *testing.T) {
+			assert := require.New(t)
+
+			s.Schedule = schedule.Schedule{Type: schedule.CronSchedule, Cron: "0 6 * * 1-5"}
+			s.DevEUIs = nil
+			rec := httpTestRequest(r, "PUT", "/api/irrigation-schedules/"+id, UpdateIrrigationScheduleRequest{
+				IrrigationSchedule: s,
+			})
+			assert.Equal(http.StatusOK, rec.Code)
+
+			sGet, err := storage.GetIrrigationSchedule(context.Background(), storage.DB(), uuid.FromStringOrNil(id), false)
+			assert.NoError(err)
+			assert.Equal(s.Schedule, sGet.Schedule)
+
+			devices, err := storage.GetIrrigationScheduleDevices(context.Background(), storage.DB(), sGet.ID)
+			assert.NoError(err)
+			assert.Len(devices, 0)
+
+			s.DevEUIs = []lorawan.EUI64{d.DevEUI}
+			rec = httpTestRequest(r, "PUT", "/api/irrigation-schedules/"+id, UpdateIrrigationScheduleRequest{
+				IrrigationSchedule: s,
+			})
+			assert.Equal(http.StatusOK, rec.Code)
+		})
+
+		t.Run("Manual override", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "POST", "/api/irrigation-schedules/"+id+"/start", StartIrrigationRequest{DurationSeconds: 10})
+			assert.Equal(http.StatusBadRequest, rec.Code)
+
+			rec = httpTestRequest(r, "POST", "/api/irrigation-schedules/"+id+"/start", StartIrrigationRequest{DurationSeconds: 600})
+			assert.Equal(http.StatusOK, rec.Code)
+
+			sGet, err := storage.GetIrrigationSchedule(context.Background(), storage.DB(), uuid.FromStringOrNil(id), false)
+			assert.NoError(err)
+			assert.NotNil(sGet.StopAt)
+			assert.WithinDuration(time.Now().Add(10*time.Minute), *sGet.StopAt, time.Minute)
+
+			rec = httpTestRequest(r, "POST", "/api/irrigation-schedules/"+id+"/stop", nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			sGet, err = storage.GetIrrigationSchedule(context.Background(), storage.DB(), uuid.FromStringOrNil(id), false)
+			assert.NoError(err)
+			assert.Nil(sGet.StopAt)
+		})
+
+		t.Run("List executions", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "GET", "/api/irrigation-schedules/"+id+"/executions?limit=10", nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			var resp ListIrrigationExecutionsResponse
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal(2, resp.TotalCount)
+			assert.Equal(storage.IrrigationStop, resp.Result[0].Action)
+			assert.Equal(storage.IrrigationSourceManual, resp.Result[0].Source)
+			assert.Equal(d.DevEUI, resp.Result[0].DevEUI)
+			assert.Equal(storage.IrrigationStart, resp.Result[1].Action)
+		})
+
+		t.Run("Pause", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "POST", "/api/irrigation-schedules/"+id+"/pause", PauseIrrigationScheduleRequest{Until: time.Now().Add(-time.Hour)})
+			assert.Equal(http.StatusBadRequest, rec.Code)
+
+			rec = httpTestRequest(r, "POST", "/api/irrigation-schedules/"+id+"/pause", PauseIrrigationScheduleRequest{Until: time.Now().Add(48 * time.Hour)})
+			assert.Equal(http.StatusOK, rec.Code)
+
+			sGet, err := storage.GetIrrigationSchedule(context.Background(), storage.DB(), uuid.FromStringOrNil(id), false)
+			assert.NoError(err)
+			assert.True(sGet.Paused(time.Now()))
+
+			rec = httpTestRequest(r, "DELETE", "/api/irrigation-schedules/"+id+"/pause", nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			sGet, err = storage.GetIrrigationSchedule(context.Background(), storage.DB(), uuid.FromStringOrNil(id), false)
+			assert.NoError(err)
+			assert.Nil(sGet.PausedUntil)
+		})
+
+		t.Run("Stop without stop downlink", func(t *testing.T) {
+			assert := require.New(t)
+
+			s.StopDownlink = nil
+			rec := httpTestRequest(r, "PUT", "/api/irrigation-schedules/"+id, UpdateIrrigationScheduleRequest{
+				IrrigationSchedule: s,
+			})
+			assert.Equal(http.StatusOK, rec.Code)
+
+			rec = httpTestRequest(r, "POST", "/api/irrigation-schedules/"+id+"/stop", nil)
+			assert.Equal(http.StatusBadRequest, rec.Code)
+
+			var resp httpErrorBody
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal("irrigation schedule has no stop downlink", resp.Error)
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "DELETE", "/api/irrigation-schedules/"+id, nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			rec = httpTestRequest(r, "GET", "/api/irrigation-schedules/"+id, nil)
+			assert.Equal(http.StatusNotFound, rec.Code)
+		})
+	})
+}
@@ -0,0 +1,391 @@
+package external
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq/hstore"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+// maintenanceWindowListMaxLimit defines the max. number of maintenance
+// windows returned by a single list request.
+const maintenanceWindowListMaxLimit = 1000
+
+// MaintenanceWindow defines a maintenance window during which the
+// automations of the application are suppressed for the devices of the
+// window. When DevEUI is set, the window only applies to this device, when
+// DeviceTags is set, the window only applies to the devices having all of
+// these tags, else it applies to all devices of the application.
+// Recurrence must be NONE, DAILY or WEEKLY.
+type MaintenanceWindow struct {
+	ID              string                        `json:"id"`
+	ApplicationID   int64                         `json:"applicationID,string"`
+	Name            string                        `json:"name"`
+	DevEUI          *lorawan.EUI64                `json:"devEUI,omitempty"`
+	DeviceTags      map[string]string             `json:"deviceTags"`
+	StartsAt        time.Time                     `json:"startsAt"`
+	DurationSeconds float64                       `json:"durationSeconds"`
+	Recurrence      storage.MaintenanceRecurrence `json:"recurrence"`
+	Until           *time.Time                    `json:"until,omitempty"`
+	CreatedAt       time.Time                     `json:"createdAt"`
+	UpdatedAt       time.Time                     `json:"updatedAt"`
+}
+
+// ActiveMaintenanceWindow defines a maintenance window which is currently
+// active, with the end of its current occurrence.
+type ActiveMaintenanceWindow struct {
+	Window      MaintenanceWindow `json:"window"`
+	ActiveUntil time.Time         `json:"activeUntil"`
+}
+
+// CreateMaintenanceWindowRequest defines the create maintenance window
+// request.
+type CreateMaintenanceWindowRequest struct {
+	Window MaintenanceWindow `json:"window"`
+}
+
+// CreateMaintenanceWindowResponse defines the create maintenance window
+// response.
+type CreateMaintenanceWindowResponse struct {
+	ID string `json:"id"`
+}
+
+// GetMaintenanceWindowResponse defines the get maintenance window response.
+type GetMaintenanceWindowResponse struct {
+	Window MaintenanceWindow `json:"window"`
+}
+
+// UpdateMaintenanceWindowRequest defines the update maintenance window
+// request.
+type UpdateMaintenanceWindowRequest struct {
+	Window MaintenanceWindow `json:"window"`
+}
+
+// ListMaintenanceWindowsResponse defines the list maintenance windows
+// response.
+type ListMaintenanceWindowsResponse struct {
+	TotalCount int                 `json:"totalCount"`
+	Result     []MaintenanceWindow `json:"result"`
+}
+
+// ListActiveMaintenanceWindowsResponse defines the list active maintenance
+// windows response.
+type ListActiveMaintenanceWindowsResponse struct {
+	Result []ActiveMaintenanceWindow `json:"result"`
+}
+
+// MaintenanceWindowAPI exports the maintenance window related functions.
+type MaintenanceWindowAPI struct {
+	validator auth.Validator
+}
+
+// NewMaintenanceWindowAPI creates a new MaintenanceWindowAPI.
+func NewMaintenanceWindowAPI(validator auth.Validator) *MaintenanceWindowAPI {
+	return &MaintenanceWindowAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *MaintenanceWindowAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/applications/{application_id}/maintenance-windows", a.Create).Methods("POST")
+	r.HandleFunc("/api/applications/{application_id}/maintenance-windows", a.List).Methods("GET")
+	r.HandleFunc("/api/applications/{application_id}/maintenance-windows/active", a.ListActive).Methods("GET")
+	r.HandleFunc("/api/maintenance-windows/{id}", a.Get).Methods("GET")
+	r.HandleFunc("/api/maintenance-windows/{id}", a.Update).Methods("PUT")
+	r.HandleFunc("/api/maintenance-windows/{id}", a.Delete).Methods("DELETE")
+}
+
+// Create creates the given maintenance window for the application.
+func (a *MaintenanceWindowAPI) Create(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(applicationID, auth.Update),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req CreateMaintenanceWindowRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := validateMaintenanceWindowDevice(ctx, applicationID, req.Window.DevEUI); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	mw := storage.MaintenanceWindow{
+		ApplicationID: applicationID,
+	}
+	maintenanceWindowToStorage(req.Window, &mw)
+
+	if err := storage.CreateMaintenanceWindow(ctx, storage.DB(), &mw); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, CreateMaintenanceWindowResponse{
+		ID: mw.ID.String(),
+	})
+}
+
+// List lists the maintenance windows of the application, the most recently
+// started first.
+func (a *MaintenanceWindowAPI) List(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	limit, offset, err := httpLimitOffset(r, maintenanceWindowListMaxLimit)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(applicationID, auth.Read),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	filters := storage.MaintenanceWindowFilters{
+		ApplicationID: applicationID,
+		Limit:         limit,
+		Offset:        offset,
+	}
+
+	count, err := storage.GetMaintenanceWindowCount(ctx, storage.DB(), filters)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	windows, err := storage.GetMaintenanceWindows(ctx, storage.DB(), filters)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListMaintenanceWindowsResponse{
+		TotalCount: count,
+		Result:     []MaintenanceWindow{},
+	}
+	for _, mw := range windows {
+		resp.Result = append(resp.Result, maintenanceWindowFromStorage(mw))
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// ListActive lists the maintenance windows of the application which are
+// currently active, i.e. the active silences.
+func (a *MaintenanceWindowAPI) ListActive(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(applicationID, auth.Read),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	now := time.Now()
+	windows, err := storage.GetActiveMaintenanceWindows(ctx, storage.DB(), applicationID, now)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListActiveMaintenanceWindowsResponse{
+		Result: []ActiveMaintenanceWindow{},
+	}
+	for _, mw := range windows {
+		// the window could have ended in the meantime
+		end, ok := mw.ActiveUntil(now)
+		if !ok {
+			continue
+		}
+
+		resp.Result = append(resp.Result, ActiveMaintenanceWindow{
+			Window:      maintenanceWindowFromStorage(mw),
+			ActiveUntil: end,
+		})
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// Get returns the maintenance window.
+func (a *MaintenanceWindowAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	mw, err := a.getMaintenanceWindow(ctx, r, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, GetMaintenanceWindowResponse{
+		Window: maintenanceWindowFromStorage(mw),
+	})
+}
+
+// Update updates the maintenance window.
+func (a *MaintenanceWindowAPI) Update(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	var req UpdateMaintenanceWindowRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	mw, err := a.getMaintenanceWindow(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := validateMaintenanceWindowDevice(ctx, mw.ApplicationID, req.Window.DevEUI); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	maintenanceWindowToStorage(req.Window, &mw)
+
+	if err := storage.UpdateMaintenanceWindow(ctx, storage.DB(), &mw); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// Delete deletes the maintenance window.
+func (a *MaintenanceWindowAPI) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	mw, err := a.getMaintenanceWindow(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := storage.DeleteMaintenanceWindow(ctx, storage.DB(), mw.ID); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// getMaintenanceWindow returns the maintenance window of the id route
+// variable and validates that the client has the requested access to its
+// application.
+func (a *MaintenanceWindowAPI) getMaintenanceWindow(ctx context.Context, r *http.Request, flag auth.Flag) (storage.MaintenanceWindow, error) {
+	id, err := httpUUIDVar(r, "id")
+	if err != nil {
+		return storage.MaintenanceWindow{}, err
+	}
+
+	mw, err := storage.GetMaintenanceWindow(ctx, storage.DB(), id)
+	if err != nil {
+		return mw, err
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(mw.ApplicationID, flag),
+	); err != nil {
+		return mw, err
+	}
+
+	return mw, nil
+}
+
+// validateMaintenanceWindowDevice validates that the device of the
+// maintenance window (if any) belongs to the application.
+func validateMaintenanceWindowDevice(ctx context.Context, applicationID int64, devEUI *lorawan.EUI64) error {
+	if devEUI == nil {
+		return nil
+	}
+
+	d, err := storage.GetDevice(ctx, storage.DB(), *devEUI, false, true)
+	if err != nil {
+		return err
+	}
+
+	if d.ApplicationID != applicationID {
+		return grpc.Errorf(codes.InvalidArgument, "devEUI: device does not belong to the application")
+	}
+
+	return nil
+}
+
+func maintenanceWindowToStorage(in MaintenanceWindow, out *storage.MaintenanceWindow) {
+	out.Name = in.Name
+	out.DevEUI = in.DevEUI
+	out.DeviceTags = hstore.Hstore{
+		Map: make(map[string]sql.NullString),
+	}
+	for k, v := range in.DeviceTags {
+		out.DeviceTags.Map[k] = sql.NullString{String: v, Valid: true}
+	}
+	out.StartsAt = in.StartsAt
+	out.Duration = time.Duration(in.DurationSeconds * float64(time.Second))
+	out.Recurrence = in.Recurrence
+	out.Until = in.Until
+}
+
+func maintenanceWindowFromStorage(mw storage.MaintenanceWindow) MaintenanceWindow {
+	out := MaintenanceWindow{
+		ID:              mw.ID.String(),
+		ApplicationID:   mw.ApplicationID,
+		Name:            mw.Name,
+		DevEUI:          mw.DevEUI,
+		DeviceTags:      make(map[string]string),
+		StartsAt:        mw.StartsAt,
+		DurationSeconds: mw.Duration.Seconds(),
+		Recurrence:      mw.Recurrence,
+		Until:           mw.Until,
+		CreatedAt:       mw.CreatedAt,
+		UpdatedAt:       mw.UpdatedAt,
+	}
+
+	for k, v := range mw.DeviceTags.Map {
+		if v.Valid {
+			out.DeviceTags[k] = v.String
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,192 @@
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *APITestSuite) TestMaintenanceWindow() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewMaintenanceWindowAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := storage.Application{
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+		Name:             "test-app",
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	app2 := storage.Application{
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+		Name:             "test-app-2",
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app2))
+
+	dp := storage.DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(storage.CreateDeviceProfile(context.Background(), storage.DB(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	d := storage.Device{
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "pump-1",
+	}
+	assert.NoError(storage.CreateDevice(context.Background(), storage.DB(), &d))
+
+	mw := MaintenanceWindow{
+		Name:            "replace-battery",
+		DevEUI:          &d.DevEUI,
+		StartsAt:        time.Now().Add(-time.Minute).Round(time.Second),
+		DurationSeconds: 3600,
+		Recurrence:      storage.MaintenanceRecurrenceNone,
+	}
+
+	var id string
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/maintenance-windows", app.ID), CreateMaintenanceWindowRequest{
+			Window: mw,
+		})
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp CreateMaintenanceWindowResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		id = resp.ID
+
+		t.Run("Get", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "GET", "/api/maintenance-windows/"+id, nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			var resp GetMaintenanceWindowResponse
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal("replace-battery", resp.Window.Name)
+			assert.Equal(app.ID, resp.Window.ApplicationID)
+			assert.Equal(&d.DevEUI, resp.Window.DevEUI)
+			assert.True(mw.StartsAt.Equal(resp.Window.StartsAt))
+			assert.Equal(float64(3600), resp.Window.DurationSeconds)
+			assert.Equal(storage.MaintenanceRecurrenceNone, resp.Window.Recurrence)
+		})
+
+		t.Run("List", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "GET", fmt.Sprintf("/api/applications/%d/maintenance-windows?limit=10", app.ID), nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			var resp ListMaintenanceWindowsResponse
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal(1, resp.TotalCount)
+			assert.Len(resp.Result, 1)
+			assert.Equal(id, resp.Result[0].ID)
+		})
+
+		t.Run("List active", func(t *testing.T) {
+			assert := require.New(t)
+
+			rec := httpTestRequest(r, "GET", fmt.Sprintf("/api/applications/%d/maintenance-windows/active", app.ID), nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			var resp ListActiveMaintenanceWindowsResponse
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Len(resp.Result, 1)
+			assert.Equal(id, resp.Result[0].Window.ID)
+			assert.True(mw.StartsAt.Add(time.Hour).Equal(resp.Result[0].ActiveUntil))
+		})
+
+		t.Run("Update", func(t *testing.T) {
+			assert := require.New(t)
+
+			mw.StartsAt = mw.StartsAt.Add(24 * time.Hour)
+			rec := httpTestRequest(r, "PUT", "/api/maintenance-windows/"+id, UpdateMaintenanceWindowRequest{
+				Window: mw,
+			})
+			assert.Equal(http.StatusOK, rec.Code)
+
+			rec = httpTestRequest(r, "GET", fmt.Sprintf("/api/applications/%d/maintenance-windows/active", app.ID), nil)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			var resp ListActiveMaintenanceWindowsResponse
+			assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Len(resp.Result, 0)
+		})
+	})
+
+	ts.T().Run("Create invalid", func(t *testing.T) {
+		assert := require.New(t)
+
+		// device of an other application
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/maintenance-windows", app2.ID), CreateMaintenanceWindowRequest{
+			Window: mw,
+		})
+		assert.Equal(http.StatusBadRequest, rec.Code)
+
+		invalid := mw
+		invalid.DurationSeconds = 2 * 86400
+		invalid.Recurrence = storage.MaintenanceRecurrenceDaily
+
+		rec = httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/maintenance-windows", app.ID), CreateMaintenanceWindowRequest{
+			Window: invalid,
+		})
+		assert.Equal(http.StatusBadRequest, rec.Code)
+	})
+
+	ts.T().Run("Delete", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "DELETE", "/api/maintenance-windows/"+id, nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		rec = httpTestRequest(r, "GET", "/api/maintenance-windows/"+id, nil)
+		assert.Equal(http.StatusNotFound, rec.Code)
+	})
+}
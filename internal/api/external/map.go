@@ -0,0 +1,355 @@
+package external
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/geojson"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// Map layers.
+const (
+	mapLayerGeofences = "geofences"
+	mapLayerZones     = "zones"
+	mapLayerGateways  = "gateways"
+	mapLayerDevices   = "devices"
+)
+
+// Map feature statuses.
+const (
+	mapStatusOK        = "ok"
+	mapStatusAlarm     = "alarm"
+	mapStatusActive    = "active"
+	mapStatusInactive  = "inactive"
+	mapStatusOnline    = "online"
+	mapStatusOffline   = "offline"
+	mapStatusNeverSeen = "never_seen"
+)
+
+const (
+	// mapDefaultWindow defines the default window within which a device
+	// must have been seen to be active.
+	mapDefaultWindow = 24 * time.Hour
+
+	// mapMaxWindow defines the max. activity window.
+	mapMaxWindow = 31 * 24 * time.Hour
+
+	// mapMaxDevices, mapMaxGateways and mapMaxGeofences define the max.
+	// number of features of each layer.
+	mapMaxDevices   = 10000
+	mapMaxGateways  = 1000
+	mapMaxGeofences = 1000
+)
+
+// MapGeofenceProperties defines the properties of a geofence (polygon)
+// feature. Devices holds the number of located devices within the
+// geofence, the status is alarm when any of these devices has open alarms.
+type MapGeofenceProperties struct {
+	Kind    string `json:"kind"`
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Zone    string `json:"zone,omitempty"`
+	Devices int    `json:"devices"`
+	Status  string `json:"status"`
+}
+
+// MapZoneProperties defines the properties of a zone (polygon) feature.
+// Boundary is geofence when the zone boundary is defined by a geofence, or
+// devices when it is the area spanned by the located devices of the zone.
+// The status is alarm when the zone has open alarms.
+type MapZoneProperties struct {
+	Kind            string     `json:"kind"`
+	Zone            string     `json:"zone"`
+	Boundary        string     `json:"boundary"`
+	Devices         int        `json:"devices"`
+	LastSeenAt      *time.Time `json:"lastSeenAt,omitempty"`
+	OpenAlarms      int        `json:"openAlarms"`
+	EscalatedAlarms int        `json:"escalatedAlarms"`
+	Status          string     `json:"status"`
+}
+
+// MapGatewayProperties defines the properties of a gateway (point) feature.
+// The status is online, offline or never_seen.
+type MapGatewayProperties struct {
+	Kind       string     `json:"kind"`
+	GatewayID  string     `json:"gatewayID"`
+	Name       string     `json:"name"`
+	LastSeenAt *time.Time `json:"lastSeenAt,omitempty"`
+	Status     string     `json:"status"`
+}
+
+// MapDeviceProperties defines the properties of a device (point) feature.
+// The status is alarm when the device has open alarms, else active,
+// inactive or never_seen.
+type MapDeviceProperties struct {
+	Kind         string     `json:"kind"`
+	DevEUI       string     `json:"devEUI"`
+	Name         string     `json:"name"`
+	Zone         string     `json:"zone,omitempty"`
+	LastSeenAt   *time.Time `json:"lastSeenAt,omitempty"`
+	BatteryLevel *float32   `json:"batteryLevel,omitempty"`
+	OpenAlarms   int        `json:"openAlarms"`
+	Status       string     `json:"status"`
+}
+
+// MapAPI exports the map layer related functions.
+type MapAPI struct {
+	validator auth.Validator
+}
+
+// NewMapAPI creates a new MapAPI.
+func NewMapAPI(validator auth.Validator) *MapAPI {
+	return &MapAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *MapAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/applications/{application_id}/map", a.Get).Methods("GET")
+}
+
+// Get returns the map of the application as GeoJSON feature collection, so
+// that it can be rendered directly by map frontends and GIS tools. The
+// features are ordered by layer: geofences, zones, gateways (of the
+// organization of the application) and devices. The kind property holds
+// the layer of the feature, the status property its status.
+//
+// The layer query parameter (which can be repeated) selects the layers, by
+// default all layers are returned. The tag query parameter sets the device
+// tag defining the zones (by default "zone"). The window query parameter
+// (e.g. 1h) sets the window within which a device must have been seen to be
+// active, by default 24h. Zones without geofence are drawn as the area
+// spanned by their located devices and are omitted when these do not span
+// an area.
+func (a *MapAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(applicationID, auth.Read),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+
+	layers := map[string]bool{
+		mapLayerGeofences: true,
+		mapLayerZones:     true,
+		mapLayerGateways:  true,
+		mapLayerDevices:   true,
+	}
+	if len(q["layer"]) != 0 {
+		for k := range layers {
+			layers[k] = false
+		}
+		for _, l := range q["layer"] {
+			if _, ok := layers[l]; !ok {
+				httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "layer must be one of %s, %s, %s or %s", mapLayerGeofences, mapLayerZones, mapLayerGateways, mapLayerDevices))
+				return
+			}
+			layers[l] = true
+		}
+	}
+
+	tagKey := zoneDefaultTagKey
+	if v := q.Get("tag"); v != "" {
+		tagKey = v
+	}
+
+	window := mapDefaultWindow
+	if v := q.Get("window"); v != "" {
+		window, err = time.ParseDuration(v)
+		if err != nil || window <= 0 || window > mapMaxWindow {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "window must be a duration between 0s and %s", mapMaxWindow))
+			return
+		}
+	}
+	activeSince := time.Now().Add(-window)
+
+	app, err := storage.GetApplication(ctx, storage.DB(), applicationID)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var geofences []storage.Geofence
+	if layers[mapLayerGeofences] || layers[mapLayerZones] {
+		geofences, err = storage.GetGeofences(ctx, storage.DB(), storage.GeofenceFilters{
+			ApplicationID: applicationID,
+			Limit:         mapMaxGeofences,
+		})
+		if err != nil {
+			httpWriteError(w, err)
+			return
+		}
+	}
+
+	var devices []storage.MapDevice
+	if layers[mapLayerGeofences] || layers[mapLayerZones] || layers[mapLayerDevices] {
+		devices, err = storage.GetMapDevices(ctx, storage.DB(), applicationID, mapMaxDevices)
+		if err != nil {
+			httpWriteError(w, err)
+			return
+		}
+	}
+
+	fc := geojson.NewFeatureCollection()
+
+	if layers[mapLayerGeofences] {
+		for _, g := range geofences {
+			props := MapGeofenceProperties{
+				Kind:   "geofence",
+				ID:     g.ID.String(),
+				Name:   g.Name,
+				Zone:   g.Zone,
+				Status: mapStatusOK,
+			}
+
+			for _, d := range devices {
+				if !g.Boundary.Contains(geojson.NewPosition(d.Latitude, d.Longitude)) {
+					continue
+				}
+
+				props.Devices++
+				if d.OpenAlarms > 0 {
+					props.Status = mapStatusAlarm
+				}
+			}
+
+			fc.Add("geofence:"+g.ID.String(), geojson.Polygon(g.Boundary), props)
+		}
+	}
+
+	if layers[mapLayerZones] {
+		filters := storage.ZoneFilters{
+			ApplicationID: applicationID,
+			TagKey:        tagKey,
+		}
+
+		zones, err := storage.GetZones(ctx, storage.DB(), filters)
+		if err != nil {
+			httpWriteError(w, err)
+			return
+		}
+
+		alarms, err := storage.GetZoneAlarmSummaries(ctx, storage.DB(), filters)
+		if err != nil {
+			httpWriteError(w, err)
+			return
+		}
+
+		zoneAlarms := make(map[string]storage.ZoneAlarmSummary, len(alarms))
+		for _, s := range alarms {
+			zoneAlarms[s.Zone] = s
+		}
+
+		// the geofences are sorted by name, the first one of a zone is used
+		zoneGeofences := make(map[string]geojson.Ring)
+		for _, g := range geofences {
+			if _, ok := zoneGeofences[g.Zone]; g.Zone != "" && !ok {
+				zoneGeofences[g.Zone] = g.Boundary
+			}
+		}
+
+		zonePositions := make(map[string][]geojson.Position)
+		for _, d := range devices {
+			if zone := d.Tags.Map[tagKey]; zone.Valid {
+				zonePositions[zone.String] = append(zonePositions[zone.String], geojson.NewPosition(d.Latitude, d.Longitude))
+			}
+		}
+
+		for _, z := range zones {
+			props := MapZoneProperties{
+				Kind:            "zone",
+				Zone:            z.Zone,
+				Boundary:        "geofence",
+				Devices:         z.Devices,
+				LastSeenAt:      z.LastSeenAt,
+				OpenAlarms:      zoneAlarms[z.Zone].OpenAlarms,
+				EscalatedAlarms: zoneAlarms[z.Zone].EscalatedAlarms,
+				Status:          mapStatusOK,
+			}
+			if props.OpenAlarms > 0 {
+				props.Status = mapStatusAlarm
+			}
+
+			boundary, ok := zoneGeofences[z.Zone]
+			if !ok {
+				props.Boundary = "devices"
+				boundary = geojson.ConvexHull(zonePositions[z.Zone])
+			}
+			if boundary == nil {
+				continue
+			}
+
+			fc.Add("zone:"+z.Zone, geojson.Polygon(boundary), props)
+		}
+	}
+
+	if layers[mapLayerGateways] {
+		gateways, err := storage.GetMapGateways(ctx, storage.DB(), app.OrganizationID, mapMaxGateways)
+		if err != nil {
+			httpWriteError(w, err)
+			return
+		}
+
+		for _, gw := range gateways {
+			props := MapGatewayProperties{
+				Kind:       "gateway",
+				GatewayID:  gw.MAC.String(),
+				Name:       gw.Name,
+				LastSeenAt: gw.LastSeenAt,
+				Status:     mapStatusOnline,
+			}
+			switch {
+			case gw.LastSeenAt == nil:
+				props.Status = mapStatusNeverSeen
+			case !gw.Online:
+				props.Status = mapStatusOffline
+			}
+
+			fc.Add("gateway:"+gw.MAC.String(), geojson.Point(geojson.NewPosition(gw.Latitude, gw.Longitude)), props)
+		}
+	}
+
+	if layers[mapLayerDevices] {
+		for _, d := range devices {
+			props := MapDeviceProperties{
+				Kind:         "device",
+				DevEUI:       d.DevEUI.String(),
+				Name:         d.Name,
+				Zone:         d.Tags.Map[tagKey].String,
+				LastSeenAt:   d.LastSeenAt,
+				BatteryLevel: d.BatteryLevel,
+				OpenAlarms:   d.OpenAlarms,
+				Status:       mapStatusActive,
+			}
+			switch {
+			case d.OpenAlarms > 0:
+				props.Status = mapStatusAlarm
+			case d.LastSeenAt == nil:
+				props.Status = mapStatusNeverSeen
+			case d.LastSeenAt.Before(activeSince):
+				props.Status = mapStatusInactive
+			}
+
+			fc.Add("device:"+d.DevEUI.String(), geojson.Point(geojson.NewPosition(d.Latitude, d.Longitude)), props)
+		}
+	}
+
+	httpWriteJSON(w, fc)
+}
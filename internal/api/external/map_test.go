@@ -0,0 +1,229 @@
+package external
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq/hstore"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/geojson"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *APITestSuite) TestMap() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewMapAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	dp := storage.DeviceProfile{
+		Name:            "test-dp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateDeviceProfile(context.Background(), storage.DB(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	app := storage.Application{
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+		Name:             "test-app",
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	zoneTags := func(zone string) hstore.Hstore {
+		return hstore.Hstore{
+			Map: map[string]sql.NullString{
+				"zone": {String: zone, Valid: true},
+			},
+		}
+	}
+	float := func(f float64) *float64 { return &f }
+	now := time.Now()
+	longAgo := now.Add(-48 * time.Hour)
+
+	// the field devices span a triangle, the greenhouse has a geofence
+	devices := []storage.Device{
+		{DevEUI: lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 1}, Name: "field-1", Tags: zoneTags("field"), Latitude: float(51.0), Longitude: float(4.0), LastSeenAt: &now},
+		{DevEUI: lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 2}, Name: "field-2", Tags: zoneTags("field"), Latitude: float(51.0), Longitude: float(4.1), LastSeenAt: &longAgo},
+		{DevEUI: lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 3}, Name: "field-3", Tags: zoneTags("field"), Latitude: float(51.1), Longitude: float(4.0)},
+		{DevEUI: lorawan.EUI64{2, 2, 2, 2, 2, 2, 2, 1}, Name: "greenhouse-1", Tags: zoneTags("greenhouse"), Latitude: float(52.05), Longitude: float(5.05), LastSeenAt: &now},
+		{DevEUI: lorawan.EUI64{3, 3, 3, 3, 3, 3, 3, 1}, Name: "shed-1", Tags: zoneTags("shed"), Latitude: float(53.0), Longitude: float(6.0)},
+		{DevEUI: lorawan.EUI64{4, 4, 4, 4, 4, 4, 4, 1}, Name: "not-located", Tags: zoneTags("shed")},
+	}
+	for i := range devices {
+		devices[i].ApplicationID = app.ID
+		devices[i].DeviceProfileID = dpID
+		assert.NoError(storage.CreateDevice(context.Background(), storage.DB(), &devices[i]))
+	}
+
+	a := storage.Automation{
+		ApplicationID: app.ID,
+		Name:          "offline",
+		Enabled:       true,
+		Trigger: spec.Trigger{
+			Type:           spec.DeviceOfflineTrigger,
+			TimeoutSeconds: 3600,
+		},
+		Actions: spec.Actions{
+			{Type: spec.WebhookAction, URL: "http://localhost/hook"},
+		},
+	}
+	assert.NoError(storage.CreateAutomation(context.Background(), storage.DB(), &a))
+	assert.NoError(storage.CreateAutomationAlarm(context.Background(), storage.DB(), &storage.AutomationAlarm{
+		AutomationID: a.ID,
+		DevEUI:       devices[3].DevEUI,
+		CreatedAt:    now,
+	}))
+
+	g := storage.Geofence{
+		ApplicationID: app.ID,
+		Name:          "greenhouse",
+		Zone:          "greenhouse",
+		Boundary:      geojson.Ring{{5, 52}, {5.1, 52}, {5.1, 52.1}, {5, 52.1}},
+	}
+	assert.NoError(storage.CreateGeofence(context.Background(), storage.DB(), &g))
+
+	gw := storage.Gateway{
+		MAC:             lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		Name:            "test-gw",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+		Latitude:        51.05,
+		Longitude:       4.05,
+		LastSeenAt:      &now,
+	}
+	assert.NoError(storage.CreateGateway(context.Background(), storage.DB(), &gw))
+
+	type feature struct {
+		ID       string `json:"id"`
+		Geometry struct {
+			Type        string          `json:"type"`
+			Coordinates json.RawMessage `json:"coordinates"`
+		} `json:"geometry"`
+		Properties map[string]interface{} `json:"properties"`
+	}
+
+	get := func(path string) (int, []feature) {
+		rec := httpTestRequest(r, "GET", path, nil)
+		if rec.Code != http.StatusOK {
+			return rec.Code, nil
+		}
+
+		var fc struct {
+			Type     string    `json:"type"`
+			Features []feature `json:"features"`
+		}
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&fc))
+		assert.Equal("FeatureCollection", fc.Type)
+		return rec.Code, fc.Features
+	}
+
+	ts.T().Run("Invalid layer", func(t *testing.T) {
+		assert := require.New(t)
+
+		code, _ := get(fmt.Sprintf("/api/applications/%d/map?layer=roads", app.ID))
+		assert.Equal(http.StatusBadRequest, code)
+	})
+
+	ts.T().Run("All layers", func(t *testing.T) {
+		assert := require.New(t)
+
+		code, features := get(fmt.Sprintf("/api/applications/%d/map", app.ID))
+		assert.Equal(http.StatusOK, code)
+
+		var ids []string
+		for _, f := range features {
+			ids = append(ids, f.ID)
+		}
+		assert.Equal([]string{
+			"geofence:" + g.ID.String(),
+			"zone:field",
+			"zone:greenhouse",
+			"gateway:0102030405060708",
+			"device:0101010101010101",
+			"device:0101010101010102",
+			"device:0101010101010103",
+			"device:0202020202020201",
+			"device:0303030303030301",
+		}, ids)
+
+		geofence := features[0]
+		assert.Equal("Polygon", geofence.Geometry.Type)
+		assert.JSONEq(`[[[5, 52], [5.1, 52], [5.1, 52.1], [5, 52.1], [5, 52]]]`, string(geofence.Geometry.Coordinates))
+		assert.EqualValues(1, geofence.Properties["devices"])
+		assert.Equal("alarm", geofence.Properties["status"])
+
+		field := features[1]
+		assert.Equal("devices", field.Properties["boundary"])
+		assert.EqualValues(3, field.Properties["devices"])
+		assert.Equal("ok", field.Properties["status"])
+		assert.JSONEq(`[[[4, 51], [4.1, 51], [4, 51.1], [4, 51]]]`, string(field.Geometry.Coordinates))
+
+		greenhouse := features[2]
+		assert.Equal("geofence", greenhouse.Properties["boundary"])
+		assert.EqualValues(1, greenhouse.Properties["openAlarms"])
+		assert.Equal("alarm", greenhouse.Properties["status"])
+
+		gateway := features[3]
+		assert.Equal("Point", gateway.Geometry.Type)
+		assert.JSONEq(`[4.05, 51.05]`, string(gateway.Geometry.Coordinates))
+		assert.Equal("online", gateway.Properties["status"])
+
+		var statuses []interface{}
+		for _, f := range features[4:] {
+			statuses = append(statuses, f.Properties["status"])
+		}
+		assert.Equal([]interface{}{"active", "inactive", "never_seen", "alarm", "never_seen"}, statuses)
+		assert.Equal("field", features[4].Properties["zone"])
+	})
+
+	ts.T().Run("Selected layers", func(t *testing.T) {
+		assert := require.New(t)
+
+		code, features := get(fmt.Sprintf("/api/applications/%d/map?layer=gateways&layer=zones&window=72h", app.ID))
+		assert.Equal(http.StatusOK, code)
+		assert.Len(features, 3)
+		for _, f := range features {
+			assert.NotEqual("device", f.Properties["kind"])
+			assert.NotEqual("geofence", f.Properties["kind"])
+		}
+	})
+}
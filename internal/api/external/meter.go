@@ -0,0 +1,268 @@
+package external
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/measurement"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+const (
+	// meterConsumptionMaxDayRange defines the max. time range of the daily
+	// consumption.
+	meterConsumptionMaxDayRange = 93 * 24 * time.Hour
+
+	// meterConsumptionMaxMonthRange defines the max. time range of the
+	// monthly consumption.
+	meterConsumptionMaxMonthRange = 2 * 366 * 24 * time.Hour
+)
+
+// Meter defines the last reading of a meter (COUNTER measurement) of a
+// device.
+type Meter struct {
+	Name  string    `json:"name"`
+	Unit  string    `json:"unit,omitempty"`
+	Value float64   `json:"value"`
+	Time  time.Time `json:"time"`
+}
+
+// MeterConsumption defines the consumption of a meter of a device within the
+// day or month starting at Date. Readings holds the number of readings
+// within this period.
+type MeterConsumption struct {
+	DevEUI      lorawan.EUI64 `json:"devEUI"`
+	DeviceName  string        `json:"deviceName"`
+	Name        string        `json:"name"`
+	Unit        string        `json:"unit,omitempty"`
+	Date        time.Time     `json:"date"`
+	Consumption float64       `json:"consumption"`
+	Readings    int           `json:"readings"`
+}
+
+// ListMetersResponse defines the list meters response.
+type ListMetersResponse struct {
+	Result []Meter `json:"result"`
+}
+
+// GetMeterConsumptionResponse defines the get meter consumption response.
+// Total holds the total consumption per meter name.
+type GetMeterConsumptionResponse struct {
+	Total  map[string]float64 `json:"total"`
+	Result []MeterConsumption `json:"result"`
+}
+
+// MeterAPI exports the meter consumption related functions.
+type MeterAPI struct {
+	validator auth.Validator
+}
+
+// NewMeterAPI creates a new MeterAPI.
+func NewMeterAPI(validator auth.Validator) *MeterAPI {
+	return &MeterAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *MeterAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/devices/{dev_eui}/meters", a.List).Methods("GET")
+	r.HandleFunc("/api/devices/{dev_eui}/consumption", a.GetDeviceConsumption).Methods("GET")
+	r.HandleFunc("/api/applications/{application_id}/consumption", a.GetApplicationConsumption).Methods("GET")
+}
+
+// List lists the last readings of the meters of the device, sorted by name.
+func (a *MeterAPI) List(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpValidateDevice(httpContext(r), r, a.validator, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	units, err := meterUnits(ctx, devEUI)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	states, err := storage.GetMeterStates(ctx, storage.DB(), devEUI)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListMetersResponse{
+		Result: []Meter{},
+	}
+	for _, s := range states {
+		resp.Result = append(resp.Result, Meter{
+			Name:  s.Name,
+			Unit:  units[s.Name],
+			Value: s.Value,
+			Time:  s.Time,
+		})
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// GetDeviceConsumption returns the consumption of the meters of the device.
+// See consumption for the query parameters.
+func (a *MeterAPI) GetDeviceConsumption(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpValidateDevice(httpContext(r), r, a.validator, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	units, err := meterUnits(ctx, devEUI)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	a.consumption(w, r, storage.MeterConsumptionFilters{
+		DevEUI: devEUI,
+	}, units)
+}
+
+// GetApplicationConsumption returns the consumption of the meters of the
+// devices of the application. See consumption for the query parameters.
+func (a *MeterAPI) GetApplicationConsumption(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(applicationID, auth.Read),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	a.consumption(w, r, storage.MeterConsumptionFilters{
+		ApplicationID: applicationID,
+	}, nil)
+}
+
+// consumption writes the consumption matching the given filters and the
+// query parameters: the interval (DAY or MONTH, by default DAY), the name of
+// the meter and the start and end (RFC3339) of the time range. The days from
+// the day of start until the day of end (excluding) are returned, by default
+// the last 30 days and today or the last 12 months and the current month.
+// The given units (by meter name) are set when not nil.
+func (a *MeterAPI) consumption(w http.ResponseWriter, r *http.Request, filters storage.MeterConsumptionFilters, units map[string]string) {
+	ctx := httpContext(r)
+	q := r.URL.Query()
+
+	interval := storage.AggregationDay
+	if v := q.Get("interval"); v != "" {
+		interval = storage.AggregationInterval(v)
+	}
+
+	now := time.Now()
+	filters.End = now.AddDate(0, 0, 1)
+
+	var maxRange time.Duration
+	switch interval {
+	case storage.AggregationDay:
+		filters.Start = now.AddDate(0, 0, -30)
+		maxRange = meterConsumptionMaxDayRange
+	case storage.AggregationMonth:
+		filters.Start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).AddDate(0, -11, 0)
+		maxRange = meterConsumptionMaxMonthRange
+	default:
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "interval: must be %s or %s", storage.AggregationDay, storage.AggregationMonth))
+		return
+	}
+
+	filters.Name = q.Get("name")
+	if v := q.Get("start"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "start: %s", err))
+			return
+		}
+		filters.Start = t
+	}
+	if v := q.Get("end"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "end: %s", err))
+			return
+		}
+		filters.End = t
+	}
+
+	if !filters.End.After(filters.Start) {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "end must be after start"))
+		return
+	}
+	if filters.End.Sub(filters.Start) > maxRange {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "max. time range is %d days", maxRange/(24*time.Hour)))
+		return
+	}
+
+	items, err := storage.GetMeterConsumption(ctx, storage.DB(), filters, interval)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := GetMeterConsumptionResponse{
+		Total:  make(map[string]float64),
+		Result: []MeterConsumption{},
+	}
+	for _, c := range items {
+		resp.Total[c.Name] += c.Consumption
+		resp.Result = append(resp.Result, MeterConsumption{
+			DevEUI:      c.DevEUI,
+			DeviceName:  c.DeviceName,
+			Name:        c.Name,
+			Unit:        units[c.Name],
+			Date:        c.Date,
+			Consumption: c.Consumption,
+			Readings:    c.Readings,
+		})
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// meterUnits returns the units of the meters of the device, by meter name,
+// as configured in the measurements of the device-profile.
+func meterUnits(ctx context.Context, devEUI lorawan.EUI64) (map[string]string, error) {
+	d, err := storage.GetDevice(ctx, storage.DB(), devEUI, false, true)
+	if err != nil {
+		return nil, err
+	}
+
+	dp, err := storage.GetDeviceProfile(ctx, storage.DB(), d.DeviceProfileID, false, true)
+	if err != nil {
+		return nil, err
+	}
+
+	units := make(map[string]string)
+	for _, def := range dp.Measurements {
+		if def.Kind == measurement.Counter {
+			units[def.Name] = def.Unit
+		}
+	}
+
+	return units, nil
+}
@@ -0,0 +1,153 @@
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/measurement"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *APITestSuite) TestMeter() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewMeterAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := storage.Application{
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+		Name:             "test-app",
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	dp := storage.DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(storage.CreateDeviceProfile(context.Background(), storage.DB(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	d := storage.Device{
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "water-meter",
+	}
+	assert.NoError(storage.CreateDevice(context.Background(), storage.DB(), &d))
+
+	defs := measurement.Definitions{
+		"water": {Name: "water", Kind: measurement.Counter, Unit: "m³", Factor: 0.001},
+	}
+	assert.NoError(storage.UpdateDeviceProfileMeasurements(context.Background(), storage.DB(), dpID, defs))
+
+	now := time.Now()
+	for i, v := range []float64{1000, 1500, 2500} {
+		m := measurement.Measurement{Name: "water", Kind: measurement.Counter, Factor: 0.001, Value: v}
+		_, err := storage.AddMeterReading(context.Background(), storage.DB(), d.DevEUI, m, now.Add(time.Duration(i-3)*time.Minute))
+		assert.NoError(err)
+	}
+
+	get := func(assert *require.Assertions, path string, v interface{}) {
+		rec := httpTestRequest(r, "GET", path, nil)
+		assert.Equal(http.StatusOK, rec.Code)
+		assert.NoError(json.NewDecoder(rec.Body).Decode(v))
+	}
+
+	ts.T().Run("List", func(t *testing.T) {
+		assert := require.New(t)
+
+		var resp ListMetersResponse
+		get(assert, "/api/devices/0102030405060708/meters", &resp)
+		assert.Len(resp.Result, 1)
+		assert.Equal("water", resp.Result[0].Name)
+		assert.Equal("m³", resp.Result[0].Unit)
+		assert.Equal(2500.0, resp.Result[0].Value)
+	})
+
+	ts.T().Run("Device consumption", func(t *testing.T) {
+		assert := require.New(t)
+
+		for _, interval := range []string{"DAY", "MONTH"} {
+			var resp GetMeterConsumptionResponse
+			get(assert, "/api/devices/0102030405060708/consumption?interval="+interval, &resp)
+			assert.NotEmpty(resp.Result)
+			assert.Equal("m³", resp.Result[0].Unit)
+			assert.Equal("water-meter", resp.Result[0].DeviceName)
+			assert.InDelta(1.5, resp.Total["water"], 0.0001)
+		}
+	})
+
+	ts.T().Run("Application consumption", func(t *testing.T) {
+		assert := require.New(t)
+
+		var resp GetMeterConsumptionResponse
+		get(assert, fmt.Sprintf("/api/applications/%d/consumption?name=water", app.ID), &resp)
+		assert.NotEmpty(resp.Result)
+		assert.InDelta(1.5, resp.Total["water"], 0.0001)
+
+		get(assert, fmt.Sprintf("/api/applications/%d/consumption?name=gas", app.ID), &resp)
+		assert.Len(resp.Result, 0)
+	})
+
+	ts.T().Run("Invalid", func(t *testing.T) {
+		tests := []struct {
+			Name         string
+			Path         string
+			ExpectedCode int
+		}{
+			{"invalid DevEUI", "/api/devices/foo/meters", http.StatusBadRequest},
+			{"invalid interval", "/api/devices/0102030405060708/consumption?interval=HOUR", http.StatusBadRequest},
+			{"invalid start", "/api/devices/0102030405060708/consumption?start=yesterday", http.StatusBadRequest},
+			{"end before start", "/api/devices/0102030405060708/consumption?start=2020-01-02T00:00:00Z&end=2020-01-01T00:00:00Z", http.StatusBadRequest},
+			{"time range too long", "/api/devices/0102030405060708/consumption?start=2020-01-01T00:00:00Z&end=2021-01-01T00:00:00Z", http.StatusBadRequest},
+			{"device does not exist", "/api/devices/0807060504030201/meters", http.StatusNotFound},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.Name, func(t *testing.T) {
+				assert := require.New(t)
+
+				rec := httpTestRequest(r, "GET", tst.Path, nil)
+				assert.Equal(tst.ExpectedCode, rec.Code)
+			})
+		}
+	})
+}
@@ -0,0 +1,140 @@
+package external
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/multicast"
+	"github.com/ibrahimozekici/app-server2/internal/multicast/gwselect"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+// MulticastGroupGateway defines a selected gateway and the devices of the
+// multicast-group it covers.
+type MulticastGroupGateway struct {
+	GatewayID string   `json:"gatewayID"`
+	DevEUIs   []string `json:"devEUIs"`
+}
+
+// GetMulticastGroupGatewaySelectionResponse defines the get multicast-group
+// gateway selection response.
+type GetMulticastGroupGatewaySelectionResponse struct {
+	Since            time.Time               `json:"since"`
+	Gateways         []MulticastGroupGateway `json:"gateways"`
+	UncoveredDevEUIs []string                `json:"uncoveredDevEUIs"`
+}
+
+// MulticastGroupGatewaySelectionAPI exports the multicast-group gateway
+// selection related functions.
+type MulticastGroupGatewaySelectionAPI struct {
+	validator auth.Validator
+	window    time.Duration
+}
+
+// NewMulticastGroupGatewaySelectionAPI creates a new
+// MulticastGroupGatewaySelectionAPI. The window is the default gateway
+// selection window.
+func NewMulticastGroupGatewaySelectionAPI(validator auth.Validator, window time.Duration) *MulticastGroupGatewaySelectionAPI {
+	return &MulticastGroupGatewaySelectionAPI{
+		validator: validator,
+		window:    window,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *MulticastGroupGatewaySelectionAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/multicast-groups/{id}/gateway-selection", a.Get).Methods("GET")
+}
+
+// Get returns the minimal set of gateways covering all the devices of the
+// given multicast-group, based on the uplinks received within the selection
+// window. The window (duration, e.g. 12h), minLoRaSNR and minRSSI can be
+// set as query parameters.
+func (a *MulticastGroupGatewaySelectionAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	id, err := httpUUIDVar(r, "id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateMulticastGroupAccess(auth.Read, id),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	window := a.window
+	var filter gwselect.Filter
+
+	q := r.URL.Query()
+	if v := q.Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "window must be a positive duration"))
+			return
+		}
+		window = d
+	}
+	if v := q.Get("minLoRaSNR"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "minLoRaSNR: %s", err))
+			return
+		}
+		filter.MinLoRaSNR = &f
+	}
+	if v := q.Get("minRSSI"); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "minRSSI: %s", err))
+			return
+		}
+		filter.MinRSSI = &i
+	}
+
+	// make sure the multicast-group exists
+	if _, err := storage.GetMulticastGroup(ctx, storage.DB(), id, false, true); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	since := time.Now().Add(-window)
+	sel, err := multicast.SelectGateways(ctx, storage.DB(), id, since, filter)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := GetMulticastGroupGatewaySelectionResponse{
+		Since:            since,
+		Gateways:         make([]MulticastGroupGateway, 0, len(sel.Gateways)),
+		UncoveredDevEUIs: make([]string, 0, len(sel.Uncovered)),
+	}
+
+	for _, gw := range sel.Gateways {
+		mgw := MulticastGroupGateway{
+			GatewayID: lorawan.EUI64(gw.GatewayID).String(),
+			DevEUIs:   make([]string, 0, len(gw.DevEUIs)),
+		}
+		for _, devEUI := range gw.DevEUIs {
+			mgw.DevEUIs = append(mgw.DevEUIs, lorawan.EUI64(devEUI).String())
+		}
+		resp.Gateways = append(resp.Gateways, mgw)
+	}
+
+	for _, devEUI := range sel.Uncovered {
+		resp.UncoveredDevEUIs = append(resp.UncoveredDevEUIs, lorawan.EUI64(devEUI).String())
+	}
+
+	httpWriteJSON(w, resp)
+}
@@ -0,0 +1,57 @@
+package external
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/region"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// NetworkServerRegionAPI exports the network-server region related
+// functions.
+type NetworkServerRegionAPI struct {
+	validator auth.Validator
+}
+
+// NewNetworkServerRegionAPI creates a new NetworkServerRegionAPI.
+func NewNetworkServerRegionAPI(validator auth.Validator) *NetworkServerRegionAPI {
+	return &NetworkServerRegionAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *NetworkServerRegionAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/network-servers/{id}/region", a.Get).Methods("GET")
+}
+
+// Get returns the region metadata (channel-plan, data-rates and max. payload
+// sizes) of the given network-server. When the region has not been
+// synchronized yet, it is retrieved from the network-server.
+func (a *NetworkServerRegionAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	id, err := httpInt64Var(r, "id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateNetworkServerAccess(auth.Read, id),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	reg, err := region.GetOrSync(ctx, storage.DB(), id)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, reg)
+}
@@ -0,0 +1,58 @@
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	// "github.com/ibrahimozekici/lora-api/go/v3/common"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/region"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+func (ts *APITestSuite) TestNetworkServerRegion() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	nsClient.GetVersionResponse.Region = common.Region_EU868
+	nsClient.GetVersionResponse.Version = "3.14.0"
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewNetworkServerRegionAPI(validator).Register(r)
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/network-servers/%d/region", n.ID), nil)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(http.StatusOK, rec.Code)
+
+	var resp region.Region
+	assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(n.ID, resp.NetworkServerID)
+	assert.Equal("EU868", resp.Region)
+	assert.Equal("3.14.0", resp.Version)
+	assert.Len(resp.UplinkChannels, 3)
+	assert.NotEmpty(resp.DataRates)
+
+	_, ok := region.Get(n.ID)
+	assert.True(ok)
+
+	req = httptest.NewRequest("GET", "/api/network-servers/0/region", nil)
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,340 @@
+package external
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// notificationRouteListMaxLimit defines the max. number of notification
+// routes returned by a single list request.
+const notificationRouteListMaxLimit = 1000
+
+// NotificationRoute defines a notification route of an organization. The
+// alarms raised by the automations with a severity of at least MinSeverity
+// (INFO, WARNING or CRITICAL) are notified over the Channel (EMAIL, SMS,
+// WEBHOOK or IN_APP) to the Recipients (e-mail addresses, phone numbers or
+// webhook URLs, IN_APP does not have recipients). When ApplicationID is set
+// (non-zero), the route only applies to the alarms of that application.
+type NotificationRoute struct {
+	ID             string        `json:"id"`
+	OrganizationID int64         `json:"organizationID,string"`
+	ApplicationID  int64         `json:"applicationID,string"`
+	Name           string        `json:"name"`
+	MinSeverity    spec.Severity `json:"minSeverity"`
+	Channel        spec.Channel  `json:"channel"`
+	Recipients     []string      `json:"recipients"`
+	CreatedAt      time.Time     `json:"createdAt"`
+	UpdatedAt      time.Time     `json:"updatedAt"`
+}
+
+// CreateNotificationRouteRequest defines the create notification route
+// request.
+type CreateNotificationRouteRequest struct {
+	Route NotificationRoute `json:"route"`
+}
+
+// CreateNotificationRouteResponse defines the create notification route
+// response.
+type CreateNotificationRouteResponse struct {
+	ID string `json:"id"`
+}
+
+// GetNotificationRouteResponse defines the get notification route response.
+type GetNotificationRouteResponse struct {
+	Route NotificationRoute `json:"route"`
+}
+
+// UpdateNotificationRouteRequest defines the update notification route
+// request.
+type UpdateNotificationRouteRequest struct {
+	Route NotificationRoute `json:"route"`
+}
+
+// ListNotificationRoutesResponse defines the list notification routes
+// response.
+type ListNotificationRoutesResponse struct {
+	TotalCount int                 `json:"totalCount"`
+	Result     []NotificationRoute `json:"result"`
+}
+
+// NotificationRouteAPI exports the notification route related functions.
+type NotificationRouteAPI struct {
+	validator auth.Validator
+}
+
+// NewNotificationRouteAPI creates a new NotificationRouteAPI.
+func NewNotificationRouteAPI(validator auth.Validator) *NotificationRouteAPI {
+	return &NotificationRouteAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *NotificationRouteAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/organizations/{organization_id}/notification-routes", a.Create).Methods("POST")
+	r.HandleFunc("/api/organizations/{organization_id}/notification-routes", a.List).Methods("GET")
+	r.HandleFunc("/api/notification-routes/{id}", a.Get).Methods("GET")
+	r.HandleFunc("/api/notification-routes/{id}", a.Update).Methods("PUT")
+	r.HandleFunc("/api/notification-routes/{id}", a.Delete).Methods("DELETE")
+}
+
+// Create creates the given notification route for the organization. This
+// requires organization admin access.
+func (a *NotificationRouteAPI) Create(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	organizationID, err := httpInt64Var(r, "organization_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateIsOrganizationAdmin(organizationID),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req CreateNotificationRouteRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := validateNotificationRoute(ctx, organizationID, req.Route); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	rt := storage.NotificationRoute{
+		OrganizationID: organizationID,
+	}
+	notificationRouteToStorage(req.Route, &rt)
+
+	if err := storage.CreateNotificationRoute(ctx, storage.DB(), &rt); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, CreateNotificationRouteResponse{
+		ID: rt.ID.String(),
+	})
+}
+
+// List lists the notification routes of the organization, sorted by name.
+func (a *NotificationRouteAPI) List(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	limit, offset, err := httpLimitOffset(r, notificationRouteListMaxLimit)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	organizationID, err := httpInt64Var(r, "organization_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateOrganizationAccess(auth.Read, organizationID),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	filters := storage.NotificationRouteFilters{
+		OrganizationID: organizationID,
+		Limit:          limit,
+		Offset:         offset,
+	}
+
+	count, err := storage.GetNotificationRouteCount(ctx, storage.DB(), filters)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	routes, err := storage.GetNotificationRoutes(ctx, storage.DB(), filters)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListNotificationRoutesResponse{
+		TotalCount: count,
+		Result:     []NotificationRoute{},
+	}
+	for _, rt := range routes {
+		resp.Result = append(resp.Result, notificationRouteFromStorage(rt))
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// Get returns the notification route.
+func (a *NotificationRouteAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	rt, err := a.getNotificationRoute(ctx, r, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, GetNotificationRouteResponse{
+		Route: notificationRouteFromStorage(rt),
+	})
+}
+
+// Update updates the notification route.
+func (a *NotificationRouteAPI) Update(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	var req UpdateNotificationRouteRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	rt, err := a.getNotificationRoute(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := validateNotificationRoute(ctx, rt.OrganizationID, req.Route); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	notificationRouteToStorage(req.Route, &rt)
+
+	if err := storage.UpdateNotificationRoute(ctx, storage.DB(), &rt); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// Delete deletes the notification route.
+func (a *NotificationRouteAPI) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	rt, err := a.getNotificationRoute(ctx, r, auth.Delete)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := storage.DeleteNotificationRoute(ctx, storage.DB(), rt.ID); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// getNotificationRoute returns the notification route of the id route
+// variable and validates that the client has the requested access to its
+// organization. Modifying a route requires organization admin access.
+func (a *NotificationRouteAPI) getNotificationRoute(ctx context.Context, r *http.Request, flag auth.Flag) (storage.NotificationRoute, error) {
+	id, err := httpUUIDVar(r, "id")
+	if err != nil {
+		return storage.NotificationRoute{}, err
+	}
+
+	rt, err := storage.GetNotificationRoute(ctx, storage.DB(), id)
+	if err != nil {
+		return rt, err
+	}
+
+	validator := auth.ValidateIsOrganizationAdmin(rt.OrganizationID)
+	if flag == auth.Read {
+		validator = auth.ValidateOrganizationAccess(auth.Read, rt.OrganizationID)
+	}
+
+	if err := a.validator.Validate(ctx, validator); err != nil {
+		return rt, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	return rt, nil
+}
+
+// validateNotificationRoute validates the min. severity, channel and
+// recipients, so that the client receives the reason why these are invalid.
+// The application of the route must belong to the organization.
+func validateNotificationRoute(ctx context.Context, organizationID int64, in NotificationRoute) error {
+	if err := in.MinSeverity.Validate(); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "minSeverity: %s", err)
+	}
+
+	if err := in.Channel.Validate(in.Recipients); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "channel: %s", err)
+	}
+
+	if in.ApplicationID == 0 {
+		return nil
+	}
+
+	app, err := storage.GetApplication(ctx, storage.DB(), in.ApplicationID)
+	if err != nil {
+		if errors.Cause(err) == storage.ErrDoesNotExist {
+			return grpc.Errorf(codes.InvalidArgument, "application does not exist")
+		}
+		return err
+	}
+
+	if app.OrganizationID != organizationID {
+		return grpc.Errorf(codes.InvalidArgument, "application does not belong to the organization")
+	}
+
+	return nil
+}
+
+func notificationRouteToStorage(in NotificationRoute, out *storage.NotificationRoute) {
+	out.ApplicationID = nil
+	if in.ApplicationID != 0 {
+		applicationID := in.ApplicationID
+		out.ApplicationID = &applicationID
+	}
+	out.Name = in.Name
+	out.MinSeverity = in.MinSeverity
+	out.Channel = in.Channel
+	out.Recipients = in.Recipients
+}
+
+func notificationRouteFromStorage(rt storage.NotificationRoute) NotificationRoute {
+	out := NotificationRoute{
+		ID:             rt.ID.String(),
+		OrganizationID: rt.OrganizationID,
+		Name:           rt.Name,
+		MinSeverity:    rt.MinSeverity,
+		Channel:        rt.Channel,
+		Recipients:     []string(rt.Recipients),
+		CreatedAt:      rt.CreatedAt,
+		UpdatedAt:      rt.UpdatedAt,
+	}
+
+	if rt.ApplicationID != nil {
+		out.ApplicationID = *rt.ApplicationID
+	}
+	if out.Recipients == nil {
+		out.Recipients = []string{}
+	}
+
+	return out
+}
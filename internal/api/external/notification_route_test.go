@@ -0,0 +1,176 @@
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+func (ts *APITestSuite) TestNotificationRoute() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewNotificationRouteAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	org2 := storage.Organization{
+		Name: "test-org-2",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org2))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org2.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := storage.Application{
+		OrganizationID:   org2.ID,
+		ServiceProfileID: spID,
+		Name:             "test-app",
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	route := NotificationRoute{
+		Name:        "on-call",
+		MinSeverity: spec.CriticalSeverity,
+		Channel:     spec.SMSChannel,
+		Recipients:  []string{"+31612345678"},
+	}
+
+	ts.T().Run("Create invalid", func(t *testing.T) {
+		assert := require.New(t)
+
+		invalid := route
+		invalid.Recipients = []string{"0612345678"}
+
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/organizations/%d/notification-routes", org.ID), CreateNotificationRouteRequest{
+			Route: invalid,
+		})
+		assert.Equal(http.StatusBadRequest, rec.Code)
+
+		var resp httpErrorBody
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal("channel: invalid phone number: '0612345678'", resp.Error)
+
+		// the application belongs to an other organization
+		invalid = route
+		invalid.ApplicationID = app.ID
+
+		rec = httpTestRequest(r, "POST", fmt.Sprintf("/api/organizations/%d/notification-routes", org.ID), CreateNotificationRouteRequest{
+			Route: invalid,
+		})
+		assert.Equal(http.StatusBadRequest, rec.Code)
+	})
+
+	var id string
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/organizations/%d/notification-routes", org.ID), CreateNotificationRouteRequest{
+			Route: route,
+		})
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp CreateNotificationRouteResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		id = resp.ID
+	})
+
+	ts.T().Run("Get", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "GET", "/api/notification-routes/"+id, nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp GetNotificationRouteResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(org.ID, resp.Route.OrganizationID)
+		assert.Equal(int64(0), resp.Route.ApplicationID)
+		assert.Equal("on-call", resp.Route.Name)
+		assert.Equal(spec.CriticalSeverity, resp.Route.MinSeverity)
+		assert.Equal(spec.SMSChannel, resp.Route.Channel)
+		assert.Equal(route.Recipients, resp.Route.Recipients)
+
+		rec = httpTestRequest(r, "GET", "/api/notification-routes/"+uuid.Must(uuid.NewV4()).String(), nil)
+		assert.Equal(http.StatusNotFound, rec.Code)
+	})
+
+	ts.T().Run("List", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "GET", fmt.Sprintf("/api/organizations/%d/notification-routes?limit=10", org.ID), nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp ListNotificationRoutesResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(1, resp.TotalCount)
+		assert.Len(resp.Result, 1)
+
+		rec = httpTestRequest(r, "GET", fmt.Sprintf("/api/organizations/%d/notification-routes?limit=10", org2.ID), nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		resp = ListNotificationRoutesResponse{}
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(0, resp.TotalCount)
+	})
+
+	ts.T().Run("Update", func(t *testing.T) {
+		assert := require.New(t)
+
+		updated := route
+		updated.Channel = spec.InAppChannel
+		updated.Recipients = nil
+		updated.MinSeverity = spec.InfoSeverity
+
+		rec := httpTestRequest(r, "PUT", "/api/notification-routes/"+id, UpdateNotificationRouteRequest{
+			Route: updated,
+		})
+		assert.Equal(http.StatusOK, rec.Code)
+
+		rt, err := storage.GetNotificationRoute(context.Background(), storage.DB(), uuid.FromStringOrNil(id))
+		assert.NoError(err)
+		assert.Equal(spec.InAppChannel, rt.Channel)
+		assert.Equal(spec.InfoSeverity, rt.MinSeverity)
+		assert.Len(rt.Recipients, 0)
+	})
+
+	ts.T().Run("Delete", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "DELETE", "/api/notification-routes/"+id, nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		rec = httpTestRequest(r, "DELETE", "/api/notification-routes/"+id, nil)
+		assert.Equal(http.StatusNotFound, rec.Code)
+	})
+}
@@ -0,0 +1,173 @@
+package external
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// organizationUsageMaxDays defines the max. number of days that can be
+// exported by a single request.
+const organizationUsageMaxDays = 366
+
+// OrganizationUsageRecord defines the usage of an organization for a day.
+type OrganizationUsageRecord struct {
+	OrganizationID   int64  `json:"organizationID"`
+	Date             string `json:"date"`
+	Uplinks          int64  `json:"uplinks"`
+	Downlinks        int64  `json:"downlinks"`
+	StoredBytes      int64  `json:"storedBytes"`
+	GeolocationCalls int64  `json:"geolocationCalls"`
+}
+
+// OrganizationUsageResponse defines the organization usage response.
+type OrganizationUsageResponse struct {
+	Result []OrganizationUsageRecord `json:"result"`
+}
+
+// OrganizationUsageAPI exports the organization usage (billing) related
+// functions.
+type OrganizationUsageAPI struct {
+	validator auth.Validator
+}
+
+// NewOrganizationUsageAPI creates a new OrganizationUsageAPI.
+func NewOrganizationUsageAPI(validator auth.Validator) *OrganizationUsageAPI {
+	return &OrganizationUsageAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *OrganizationUsageAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/organizations/{id}/usage", a.Get).Methods("GET")
+	r.HandleFunc("/api/usage", a.List).Methods("GET")
+}
+
+// Get returns the daily usage of the given organization.
+func (a *OrganizationUsageAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	id, err := httpInt64Var(r, "id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateIsOrganizationAdmin(id),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	a.export(w, r, id)
+}
+
+// List returns the daily usage of all organizations. This requires global
+// admin access.
+func (a *OrganizationUsageAPI) List(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateOrganizationsAccess(auth.Create),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	a.export(w, r, 0)
+}
+
+// export writes the usage records within the requested date range (start and
+// end as YYYY-MM-DD, inclusive) as JSON or, when format=csv is set, as CSV.
+// When not set, the usage of the last 30 days is returned.
+func (a *OrganizationUsageAPI) export(w http.ResponseWriter, r *http.Request, organizationID int64) {
+	ctx := httpContext(r)
+
+	end := time.Now().UTC()
+	start := end.AddDate(0, 0, -30)
+
+	q := r.URL.Query()
+	if v := q.Get("start"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "start: %s", err))
+			return
+		}
+		start = t
+	}
+	if v := q.Get("end"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "end: %s", err))
+			return
+		}
+		end = t
+	}
+	if end.Before(start) {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "end must be after start"))
+		return
+	}
+	if end.Sub(start) > organizationUsageMaxDays*24*time.Hour {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "date range must not exceed %d days", organizationUsageMaxDays))
+		return
+	}
+
+	format := q.Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "format must be json or csv"))
+		return
+	}
+
+	usage, err := storage.GetOrganizationUsage(ctx, storage.DB(), organizationID, start, end)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := OrganizationUsageResponse{
+		Result: make([]OrganizationUsageRecord, 0, len(usage)),
+	}
+	for _, u := range usage {
+		resp.Result = append(resp.Result, OrganizationUsageRecord{
+			OrganizationID:   u.OrganizationID,
+			Date:             u.Date.Format("2006-01-02"),
+			Uplinks:          u.Uplinks,
+			Downlinks:        u.Downlinks,
+			StoredBytes:      u.StoredBytes,
+			GeolocationCalls: u.GeolocationCalls,
+		})
+	}
+
+	if format != "csv" {
+		httpWriteJSON(w, resp)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="usage_%s_%s.csv"`, start.Format("20060102"), end.Format("20060102")))
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"organization_id", "date", "uplinks", "downlinks", "stored_bytes", "geolocation_calls"})
+	for _, u := range resp.Result {
+		cw.Write([]string{
+			strconv.FormatInt(u.OrganizationID, 10),
+			u.Date,
+			strconv.FormatInt(u.Uplinks, 10),
+			strconv.FormatInt(u.Downlinks, 10),
+			strconv.FormatInt(u.StoredBytes, 10),
+			strconv.FormatInt(u.GeolocationCalls, 10),
+		})
+	}
+	cw.Flush()
+}
@@ -0,0 +1,70 @@
+package external
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+func (ts *APITestSuite) TestOrganizationUsage() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewOrganizationUsageAPI(validator).Register(r)
+
+	org := storage.Organization{Name: "usage-org"}
+	assert.NoError(storage.CreateOrganization(ctx, storage.DB(), &org))
+
+	storage.RedisClient().FlushAll()
+	assert.NoError(storage.IncrementOrganizationUsage(ctx, org.ID, storage.UsageUplinks, 5))
+	assert.NoError(storage.FlushOrganizationUsage(ctx, storage.DB(), time.Now()))
+
+	ts.T().Run("Invalid date", func(t *testing.T) {
+		assert := require.New(t)
+
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/organizations/%d/usage?start=yesterday", org.ID), nil)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		assert.Equal(http.StatusBadRequest, rec.Code)
+	})
+
+	ts.T().Run("JSON", func(t *testing.T) {
+		assert := require.New(t)
+
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/organizations/%d/usage", org.ID), nil)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp OrganizationUsageResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Len(resp.Result, 1)
+		assert.Equal(org.ID, resp.Result[0].OrganizationID)
+		assert.EqualValues(5, resp.Result[0].Uplinks)
+	})
+
+	ts.T().Run("CSV", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "GET", "/api/usage?format=csv", nil)
+		assert.Equal(http.StatusOK, rec.Code)
+		assert.Equal("text/csv", rec.Header().Get("Content-Type"))
+
+		records, err := csv.NewReader(rec.Body).ReadAll()
+		assert.NoError(err)
+		assert.True(len(records) >= 2)
+		assert.Equal("organization_id", records[0][0])
+	})
+}
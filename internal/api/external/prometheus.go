@@ -0,0 +1,74 @@
+package external
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	reqCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "api_external_http_request_count",
+		Help: "The number of REST API requests (per method, route and status code).",
+	}, []string{"method", "route", "status_code"})
+
+	reqTimer = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "api_external_http_request_duration_seconds",
+		Help: "The duration of serving REST API requests (per method, route and status code).",
+	}, []string{"method", "route", "status_code"})
+)
+
+// prometheusMiddleware returns a middleware which measures the REST API
+// requests. The route template (e.g. /api/devices/{dev_eui}/sessions) is used
+// as label, to limit the number of label values.
+func prometheusMiddleware(timingHistogram bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			sw := statusWriter{ResponseWriter: w}
+			next.ServeHTTP(&sw, r)
+
+			var route string
+			if cr := mux.CurrentRoute(r); cr != nil {
+				route, _ = cr.GetPathTemplate()
+			}
+
+			labels := prometheus.Labels{"method": r.Method, "route": route, "status_code": strconv.FormatInt(int64(sw.status), 10)}
+			reqCount.With(labels).Inc()
+
+			if timingHistogram {
+				reqTimer.With(labels).Observe(float64(time.Since(start)) / float64(time.Second))
+			}
+		})
+	}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = 200
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements the http.Flusher interface, which is used by the
+// streaming endpoints of the JSON gateway.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
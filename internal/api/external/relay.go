@@ -0,0 +1,564 @@
+package external
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/relay"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+// Relay defines the configuration of a relay (LoRaWAN TS011). The second
+// channel is disabled when secondChannelFreq is 0.
+type Relay struct {
+	DevEUI                 lorawan.EUI64 `json:"devEUI"`
+	Enabled                bool          `json:"enabled"`
+	CADPeriodicity         uint8         `json:"cadPeriodicity"`
+	DefaultChannelIndex    uint8         `json:"defaultChannelIndex"`
+	SecondChannelFreq      uint32        `json:"secondChannelFreq"`
+	SecondChannelDR        uint8         `json:"secondChannelDR"`
+	SecondChannelACKOffset uint8         `json:"secondChannelACKOffset"`
+	CreatedAt              time.Time     `json:"createdAt"`
+	UpdatedAt              time.Time     `json:"updatedAt"`
+}
+
+// GetRelayResponse defines the get relay response.
+type GetRelayResponse struct {
+	Relay Relay `json:"relay"`
+}
+
+// SetRelayRequest defines the request to create or update a relay.
+type SetRelayRequest struct {
+	Relay Relay `json:"relay"`
+}
+
+// RelayDevice defines an end-device attached to a relay.
+type RelayDevice struct {
+	DevEUI    lorawan.EUI64 `json:"devEUI"`
+	Name      string        `json:"name"`
+	CreatedAt time.Time     `json:"createdAt"`
+}
+
+// AddRelayDeviceRequest defines the request to attach an end-device to a
+// relay.
+type AddRelayDeviceRequest struct {
+	DevEUI lorawan.EUI64 `json:"devEUI"`
+}
+
+// ListRelayDeviceResponse defines the list relay devices response.
+type ListRelayDeviceResponse struct {
+	Result []RelayDevice `json:"result"`
+}
+
+// RelayFilter defines a join-request filter rule of a relay. The action is
+// 0 (no rule), 1 (forward) or 2 (filter).
+type RelayFilter struct {
+	Index     uint8          `json:"index"`
+	Action    uint8          `json:"action"`
+	JoinEUI   *lorawan.EUI64 `json:"joinEUI"`
+	DevEUI    *lorawan.EUI64 `json:"devEUI"`
+	CreatedAt time.Time      `json:"createdAt"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+}
+
+// SetRelayFilterRequest defines the request to create or update a relay
+// filter rule.
+type SetRelayFilterRequest struct {
+	Filter RelayFilter `json:"filter"`
+}
+
+// ListRelayFilterResponse defines the list relay filters response.
+type ListRelayFilterResponse struct {
+	Result []RelayFilter `json:"result"`
+}
+
+// RelayEndDevice defines the relay configuration of a relay capable
+// end-device. The mode is 0 (disabled), 1 (enabled), 2 (dynamic) or 3
+// (end-device controlled).
+type RelayEndDevice struct {
+	DevEUI                 lorawan.EUI64 `json:"devEUI"`
+	Mode                   uint8         `json:"mode"`
+	SmartEnableLevel       uint8         `json:"smartEnableLevel"`
+	Backoff                uint8         `json:"backoff"`
+	SecondChannelFreq      uint32        `json:"secondChannelFreq"`
+	SecondChannelDR        uint8         `json:"secondChannelDR"`
+	SecondChannelACKOffset uint8         `json:"secondChannelACKOffset"`
+	CreatedAt              time.Time     `json:"createdAt"`
+	UpdatedAt              time.Time     `json:"updatedAt"`
+}
+
+// GetRelayEndDeviceResponse defines the get relay end-device response.
+type GetRelayEndDeviceResponse struct {
+	EndDevice RelayEndDevice `json:"endDevice"`
+}
+
+// SetRelayEndDeviceRequest defines the request to set the relay
+// configuration of an end-device.
+type SetRelayEndDeviceRequest struct {
+	EndDevice RelayEndDevice `json:"endDevice"`
+}
+
+// RelayAPI exports the relay related functions. Changes to the relay (or
+// relay end-device) configuration are sent to the device as MAC-commands.
+type RelayAPI struct {
+	validator auth.Validator
+}
+
+// NewRelayAPI creates a new RelayAPI.
+func NewRelayAPI(validator auth.Validator) *RelayAPI {
+	return &RelayAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *RelayAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/devices/{dev_eui}/relay", a.Get).Methods("GET")
+	r.HandleFunc("/api/devices/{dev_eui}/relay", a.Set).Methods("PUT")
+	r.HandleFunc("/api/devices/{dev_eui}/relay", a.Delete).Methods("DELETE")
+	r.HandleFunc("/api/devices/{dev_eui}/relay/devices", a.ListDevices).Methods("GET")
+	r.HandleFunc("/api/devices/{dev_eui}/relay/devices", a.AddDevice).Methods("POST")
+	r.HandleFunc("/api/devices/{dev_eui}/relay/devices/{device_dev_eui}", a.RemoveDevice).Methods("DELETE")
+	r.HandleFunc("/api/devices/{dev_eui}/relay/filters", a.ListFilters).Methods("GET")
+	r.HandleFunc("/api/devices/{dev_eui}/relay/filters/{index}", a.SetFilter).Methods("PUT")
+	r.HandleFunc("/api/devices/{dev_eui}/relay/filters/{index}", a.DeleteFilter).Methods("DELETE")
+	r.HandleFunc("/api/devices/{dev_eui}/relay-end-device", a.GetEndDevice).Methods("GET")
+	r.HandleFunc("/api/devices/{dev_eui}/relay-end-device", a.SetEndDevice).Methods("PUT")
+	r.HandleFunc("/api/devices/{dev_eui}/relay-end-device", a.DeleteEndDevice).Methods("DELETE")
+}
+
+// Get returns the relay configuration of the device.
+func (a *RelayAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpValidateDevice(ctx, r, a.validator, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	rl, err := storage.GetRelay(ctx, storage.DB(), devEUI)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, GetRelayResponse{
+		Relay: Relay{
+			DevEUI:                 rl.DevEUI,
+			Enabled:                rl.Enabled,
+			CADPeriodicity:         rl.CADPeriodicity,
+			DefaultChannelIndex:    rl.DefaultChannelIndex,
+			SecondChannelFreq:      rl.SecondChannelFreq,
+			SecondChannelDR:        rl.SecondChannelDR,
+			SecondChannelACKOffset: rl.SecondChannelACKOffset,
+			CreatedAt:              rl.CreatedAt,
+			UpdatedAt:              rl.UpdatedAt,
+		},
+	})
+}
+
+// Set creates or updates the relay configuration of the device and enqueues
+// the RelayConfReq MAC-command.
+func (a *RelayAPI) Set(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpValidateDevice(ctx, r, a.validator, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req SetRelayRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	rl := storage.Relay{
+		DevEUI:                 devEUI,
+		Enabled:                req.Relay.Enabled,
+		CADPeriodicity:         req.Relay.CADPeriodicity,
+		DefaultChannelIndex:    req.Relay.DefaultChannelIndex,
+		SecondChannelFreq:      req.Relay.SecondChannelFreq,
+		SecondChannelDR:        req.Relay.SecondChannelDR,
+		SecondChannelACKOffset: req.Relay.SecondChannelACKOffset,
+	}
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		if err := storage.UpdateRelay(ctx, tx, &rl); err != nil {
+			if err != storage.ErrDoesNotExist {
+				return err
+			}
+			if err := storage.CreateRelay(ctx, tx, &rl); err != nil {
+				return err
+			}
+		}
+
+		return relay.SendRelayConf(ctx, tx, rl)
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// Delete deletes the relay configuration of the device, including its
+// attached end-devices and filters. The relay is stopped by enqueueing a
+// RelayConfReq MAC-command.
+func (a *RelayAPI) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpValidateDevice(ctx, r, a.validator, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		if err := storage.DeleteRelay(ctx, tx, devEUI); err != nil {
+			return err
+		}
+
+		return relay.SendRelayConf(ctx, tx, storage.Relay{DevEUI: devEUI})
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// ListDevices lists the end-devices attached to the relay.
+func (a *RelayAPI) ListDevices(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpValidateDevice(ctx, r, a.validator, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	items, err := storage.GetRelayDevices(ctx, storage.DB(), devEUI)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListRelayDeviceResponse{
+		Result: []RelayDevice{},
+	}
+	for _, item := range items {
+		resp.Result = append(resp.Result, RelayDevice{
+			DevEUI:    item.DevEUI,
+			Name:      item.DeviceName,
+			CreatedAt: item.CreatedAt,
+		})
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// AddDevice attaches the given end-device to the relay. The end-device must
+// belong to the same application as the relay.
+func (a *RelayAPI) AddDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpValidateDevice(ctx, r, a.validator, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req AddRelayDeviceRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	rd, err := storage.GetDevice(ctx, storage.DB(), devEUI, false, true)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	d, err := storage.GetDevice(ctx, storage.DB(), req.DevEUI, false, true)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if d.ApplicationID != rd.ApplicationID {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "device must belong to the application of the relay"))
+		return
+	}
+
+	if err := storage.AddRelayDevice(ctx, storage.DB(), devEUI, req.DevEUI); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// RemoveDevice detaches the given end-device from the relay.
+func (a *RelayAPI) RemoveDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpValidateDevice(ctx, r, a.validator, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	deviceDevEUI, err := httpEUI64Var(r, "device_dev_eui")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := storage.RemoveRelayDevice(ctx, storage.DB(), devEUI, deviceDevEUI); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// ListFilters lists the filter rules of the relay.
+func (a *RelayAPI) ListFilters(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpValidateDevice(ctx, r, a.validator, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	filters, err := storage.GetRelayFilters(ctx, storage.DB(), devEUI)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListRelayFilterResponse{
+		Result: []RelayFilter{},
+	}
+	for _, f := range filters {
+		resp.Result = append(resp.Result, RelayFilter{
+			Index:     f.Index,
+			Action:    f.Action,
+			JoinEUI:   f.JoinEUI,
+			DevEUI:    f.DevEUI,
+			CreatedAt: f.CreatedAt,
+			UpdatedAt: f.UpdatedAt,
+		})
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// SetFilter creates or updates the filter rule at the given index and
+// enqueues the FilterListReq MAC-command.
+func (a *RelayAPI) SetFilter(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpValidateDevice(ctx, r, a.validator, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	index, err := relayFilterIndex(r)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req SetRelayFilterRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	f := storage.RelayFilter{
+		RelayDevEUI: devEUI,
+		Index:       index,
+		Action:      req.Filter.Action,
+		JoinEUI:     req.Filter.JoinEUI,
+		DevEUI:      req.Filter.DevEUI,
+	}
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		if err := storage.SetRelayFilter(ctx, tx, &f); err != nil {
+			return err
+		}
+
+		return relay.SendFilter(ctx, tx, f)
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// DeleteFilter deletes the filter rule at the given index and clears it on
+// the relay by enqueueing the FilterListReq MAC-command.
+func (a *RelayAPI) DeleteFilter(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpValidateDevice(ctx, r, a.validator, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	index, err := relayFilterIndex(r)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		if err := storage.DeleteRelayFilter(ctx, tx, devEUI, index); err != nil {
+			return err
+		}
+
+		return relay.SendFilter(ctx, tx, storage.RelayFilter{
+			RelayDevEUI: devEUI,
+			Index:       index,
+			Action:      storage.RelayFilterNoRule,
+		})
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// GetEndDevice returns the relay configuration of the end-device.
+func (a *RelayAPI) GetEndDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpValidateDevice(ctx, r, a.validator, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	d, err := storage.GetRelayEndDevice(ctx, storage.DB(), devEUI)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, GetRelayEndDeviceResponse{
+		EndDevice: RelayEndDevice{
+			DevEUI:                 d.DevEUI,
+			Mode:                   d.Mode,
+			SmartEnableLevel:       d.SmartEnableLevel,
+			Backoff:                d.Backoff,
+			SecondChannelFreq:      d.SecondChannelFreq,
+			SecondChannelDR:        d.SecondChannelDR,
+			SecondChannelACKOffset: d.SecondChannelACKOffset,
+			CreatedAt:              d.CreatedAt,
+			UpdatedAt:              d.UpdatedAt,
+		},
+	})
+}
+
+// SetEndDevice sets the relay configuration of the end-device and enqueues
+// the EndDeviceConfReq MAC-command.
+func (a *RelayAPI) SetEndDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpValidateDevice(ctx, r, a.validator, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req SetRelayEndDeviceRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	d := storage.RelayEndDevice{
+		DevEUI:                 devEUI,
+		Mode:                   req.EndDevice.Mode,
+		SmartEnableLevel:       req.EndDevice.SmartEnableLevel,
+		Backoff:                req.EndDevice.Backoff,
+		SecondChannelFreq:      req.EndDevice.SecondChannelFreq,
+		SecondChannelDR:        req.EndDevice.SecondChannelDR,
+		SecondChannelACKOffset: req.EndDevice.SecondChannelACKOffset,
+	}
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		if err := storage.SetRelayEndDevice(ctx, tx, &d); err != nil {
+			return err
+		}
+
+		return relay.SendEndDeviceConf(ctx, tx, d)
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// DeleteEndDevice deletes the relay configuration of the end-device. Relay
+// mode is disabled on the end-device by enqueueing the EndDeviceConfReq
+// MAC-command.
+func (a *RelayAPI) DeleteEndDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	devEUI, err := httpValidateDevice(ctx, r, a.validator, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		if err := storage.DeleteRelayEndDevice(ctx, tx, devEUI); err != nil {
+			return err
+		}
+
+		return relay.SendEndDeviceConf(ctx, tx, storage.RelayEndDevice{
+			DevEUI: devEUI,
+			Mode:   storage.RelayEndDeviceDisabled,
+		})
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// relayFilterIndex returns the filter index of the index route variable.
+func relayFilterIndex(r *http.Request) (uint8, error) {
+	index, err := strconv.ParseUint(mux.Vars(r)["index"], 10, 8)
+	if err != nil || index >= storage.RelayMaxFilters {
+		return 0, grpc.Errorf(codes.InvalidArgument, "index must be between 0 and %d", storage.RelayMaxFilters-1)
+	}
+	return uint8(index), nil
+}
@@ -0,0 +1,233 @@
+package external
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	//"github.com/ibrahimozekici/lora-api/go/v3/ns"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/relay"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *APITestSuite) TestRelay() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewRelayAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	dp := storage.DeviceProfile{
+		Name:            "test-dp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateDeviceProfile(context.Background(), storage.DB(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	var devices []storage.Device
+	for i, name := range []string{"test-app", "test-app-2"} {
+		app := storage.Application{
+			OrganizationID:   org.ID,
+			ServiceProfileID: spID,
+			Name:             name,
+		}
+		assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+		for j := 0; j < 2; j++ {
+			d := storage.Device{
+				ApplicationID:   app.ID,
+				DeviceProfileID: dpID,
+				Name:            fmt.Sprintf("test-node-%d-%d", i, j),
+				DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, byte(i), byte(j)},
+			}
+			assert.NoError(storage.CreateDevice(context.Background(), storage.DB(), &d))
+			devices = append(devices, d)
+		}
+	}
+	relayDevEUI := devices[0].DevEUI
+
+	do := func(t *testing.T, method, path string, body interface{}, expectedCode int, resp interface{}) {
+		assert := require.New(t)
+
+		var b bytes.Buffer
+		if body != nil {
+			assert.NoError(json.NewEncoder(&b).Encode(body))
+		}
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(method, path, &b))
+		assert.Equal(expectedCode, rec.Code, rec.Body.String())
+
+		if resp != nil {
+			assert.NoError(json.NewDecoder(rec.Body).Decode(resp))
+		}
+	}
+
+	path := fmt.Sprintf("/api/devices/%s/relay", relayDevEUI)
+
+	ts.T().Run("Relay", func(t *testing.T) {
+		assert := require.New(t)
+
+		do(t, "GET", path, nil, http.StatusNotFound, nil)
+
+		rl := Relay{
+			Enabled:                true,
+			CADPeriodicity:         2,
+			DefaultChannelIndex:    1,
+			SecondChannelFreq:      868100000,
+			SecondChannelDR:        3,
+			SecondChannelACKOffset: 2,
+		}
+		do(t, "PUT", path, SetRelayRequest{Relay: rl}, http.StatusOK, nil)
+
+		req := <-nsClient.CreateMACCommandQueueItemChan
+		assert.Equal(ns.CreateMACCommandQueueItemRequest{
+			DevEui:   relayDevEUI[:],
+			Cid:      uint32(relay.RelayConfReq),
+			Commands: [][]byte{{0x40, 0x9a, 0x2a, 0x28, 0x76, 0x84}},
+		}, req)
+
+		var resp GetRelayResponse
+		do(t, "GET", path, nil, http.StatusOK, &resp)
+		assert.Equal(relayDevEUI, resp.Relay.DevEUI)
+		assert.True(resp.Relay.Enabled)
+		assert.EqualValues(868100000, resp.Relay.SecondChannelFreq)
+
+		t.Run("Update", func(t *testing.T) {
+			assert := require.New(t)
+
+			rl.Enabled = false
+			do(t, "PUT", path, SetRelayRequest{Relay: rl}, http.StatusOK, nil)
+			<-nsClient.CreateMACCommandQueueItemChan
+
+			var resp GetRelayResponse
+			do(t, "GET", path, nil, http.StatusOK, &resp)
+			assert.False(resp.Relay.Enabled)
+		})
+
+		t.Run("Invalid", func(t *testing.T) {
+			do(t, "PUT", path, SetRelayRequest{Relay: Relay{CADPeriodicity: 6}}, http.StatusBadRequest, nil)
+		})
+	})
+
+	ts.T().Run("Devices", func(t *testing.T) {
+		assert := require.New(t)
+
+		do(t, "POST", path+"/devices", AddRelayDeviceRequest{DevEUI: devices[1].DevEUI}, http.StatusOK, nil)
+
+		// device of an other application
+		do(t, "POST", path+"/devices", AddRelayDeviceRequest{DevEUI: devices[2].DevEUI}, http.StatusBadRequest, nil)
+
+		var resp ListRelayDeviceResponse
+		do(t, "GET", path+"/devices", nil, http.StatusOK, &resp)
+		assert.Len(resp.Result, 1)
+		assert.Equal(devices[1].DevEUI, resp.Result[0].DevEUI)
+		assert.Equal(devices[1].Name, resp.Result[0].Name)
+
+		do(t, "DELETE", fmt.Sprintf("%s/devices/%s", path, devices[1].DevEUI), nil, http.StatusOK, nil)
+		do(t, "DELETE", fmt.Sprintf("%s/devices/%s", path, devices[1].DevEUI), nil, http.StatusNotFound, nil)
+	})
+
+	ts.T().Run("Filters", func(t *testing.T) {
+		assert := require.New(t)
+
+		joinEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+
+		do(t, "PUT", path+"/filters/16", SetRelayFilterRequest{}, http.StatusBadRequest, nil)
+
+		do(t, "PUT", path+"/filters/1", SetRelayFilterRequest{Filter: RelayFilter{
+			Action:  storage.RelayFilterForward,
+			JoinEUI: &joinEUI,
+		}}, http.StatusOK, nil)
+
+		req := <-nsClient.CreateMACCommandQueueItemChan
+		assert.Equal(uint32(relay.FilterListReq), req.Cid)
+		assert.Equal([][]byte{{0x42, 0x11, 8, 7, 6, 5, 4, 3, 2, 1}}, req.Commands)
+
+		var resp ListRelayFilterResponse
+		do(t, "GET", path+"/filters", nil, http.StatusOK, &resp)
+		assert.Len(resp.Result, 1)
+		assert.EqualValues(1, resp.Result[0].Index)
+		assert.Equal(&joinEUI, resp.Result[0].JoinEUI)
+		assert.Nil(resp.Result[0].DevEUI)
+
+		do(t, "DELETE", path+"/filters/1", nil, http.StatusOK, nil)
+		req = <-nsClient.CreateMACCommandQueueItemChan
+		assert.Equal([][]byte{{0x42, 0x01}}, req.Commands)
+	})
+
+	ts.T().Run("Delete", func(t *testing.T) {
+		assert := require.New(t)
+
+		do(t, "DELETE", path, nil, http.StatusOK, nil)
+		req := <-nsClient.CreateMACCommandQueueItemChan
+		assert.Equal([][]byte{{0x40, 0x00, 0x00, 0x00, 0x00, 0x00}}, req.Commands)
+
+		do(t, "GET", path, nil, http.StatusNotFound, nil)
+	})
+
+	ts.T().Run("End-device", func(t *testing.T) {
+		assert := require.New(t)
+
+		path := fmt.Sprintf("/api/devices/%s/relay-end-device", devices[1].DevEUI)
+
+		do(t, "GET", path, nil, http.StatusNotFound, nil)
+
+		do(t, "PUT", path, SetRelayEndDeviceRequest{EndDevice: RelayEndDevice{
+			Mode:             storage.RelayEndDeviceDynamic,
+			SmartEnableLevel: 1,
+			Backoff:          5,
+		}}, http.StatusOK, nil)
+
+		req := <-nsClient.CreateMACCommandQueueItemChan
+		assert.Equal(uint32(relay.EndDeviceConfReq), req.Cid)
+		assert.Equal([][]byte{{0x41, 0x09, 0x00, 0x0a, 0x00, 0x00, 0x00}}, req.Commands)
+
+		var resp GetRelayEndDeviceResponse
+		do(t, "GET", path, nil, http.StatusOK, &resp)
+		assert.Equal(storage.RelayEndDeviceDynamic, resp.EndDevice.Mode)
+		assert.EqualValues(5, resp.EndDevice.Backoff)
+
+		do(t, "DELETE", path, nil, http.StatusOK, nil)
+		req = <-nsClient.CreateMACCommandQueueItemChan
+		assert.Equal([][]byte{{0x41, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}}, req.Commands)
+
+		do(t, "GET", path, nil, http.StatusNotFound, nil)
+	})
+}
@@ -0,0 +1,330 @@
+package external
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// reportSubscriptionListMaxLimit defines the max. number of report
+// subscriptions returned by a single list request.
+const reportSubscriptionListMaxLimit = 1000
+
+// ReportSubscription defines the subscription of the user to the scheduled
+// e-mail report of an application. When Zone is set, the report only
+// covers the devices of the zone (see ZoneAPI), using the ZoneTagKey device
+// tag (by default "zone"). When Measurements is empty, all measurements are
+// reported.
+type ReportSubscription struct {
+	ID            string                 `json:"id"`
+	ApplicationID int64                  `json:"applicationID,string"`
+	Name          string                 `json:"name"`
+	Enabled       bool                   `json:"enabled"`
+	Schedule      storage.ReportSchedule `json:"schedule"`
+	Format        storage.ReportFormat   `json:"format"`
+	ZoneTagKey    string                 `json:"zoneTagKey"`
+	Zone          string                 `json:"zone"`
+	Measurements  []string               `json:"measurements"`
+	NextRunAt     time.Time              `json:"nextRunAt"`
+	LastRunAt     *time.Time             `json:"lastRunAt,omitempty"`
+	LastError     string                 `json:"lastError,omitempty"`
+	CreatedAt     time.Time              `json:"createdAt"`
+	UpdatedAt     time.Time              `json:"updatedAt"`
+}
+
+// CreateReportSubscriptionRequest defines the create report subscription
+// request.
+type CreateReportSubscriptionRequest struct {
+	Subscription ReportSubscription `json:"subscription"`
+}
+
+// CreateReportSubscriptionResponse defines the create report subscription
+// response.
+type CreateReportSubscriptionResponse struct {
+	ID string `json:"id"`
+}
+
+// GetReportSubscriptionResponse defines the get report subscription
+// response.
+type GetReportSubscriptionResponse struct {
+	Subscription ReportSubscription `json:"subscription"`
+}
+
+// UpdateReportSubscriptionRequest defines the update report subscription
+// request.
+type UpdateReportSubscriptionRequest struct {
+	Subscription ReportSubscription `json:"subscription"`
+}
+
+// ListReportSubscriptionsResponse defines the list report subscriptions
+// response.
+type ListReportSubscriptionsResponse struct {
+	TotalCount int                  `json:"totalCount"`
+	Result     []ReportSubscription `json:"result"`
+}
+
+// ReportSubscriptionAPI exports the report subscription related functions.
+// Report subscriptions are personal, a user can only see and manage its own
+// subscriptions and the reports are sent to the e-mail address of the user.
+type ReportSubscriptionAPI struct {
+	validator auth.Validator
+}
+
+// NewReportSubscriptionAPI creates a new ReportSubscriptionAPI.
+func NewReportSubscriptionAPI(validator auth.Validator) *ReportSubscriptionAPI {
+	return &ReportSubscriptionAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *ReportSubscriptionAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/applications/{application_id}/report-subscriptions", a.Create).Methods("POST")
+	r.HandleFunc("/api/applications/{application_id}/report-subscriptions", a.List).Methods("GET")
+	r.HandleFunc("/api/report-subscriptions/{id}", a.Get).Methods("GET")
+	r.HandleFunc("/api/report-subscriptions/{id}", a.Update).Methods("PUT")
+	r.HandleFunc("/api/report-subscriptions/{id}", a.Delete).Methods("DELETE")
+}
+
+// Create subscribes the user to the report of the application.
+func (a *ReportSubscriptionAPI) Create(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	user, err := a.getUser(ctx, applicationID)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req CreateReportSubscriptionRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	s := storage.ReportSubscription{
+		UserID:        user.ID,
+		ApplicationID: applicationID,
+	}
+	reportSubscriptionToStorage(req.Subscription, &s)
+
+	if err := storage.CreateReportSubscription(ctx, storage.DB(), &s); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, CreateReportSubscriptionResponse{
+		ID: s.ID.String(),
+	})
+}
+
+// List lists the report subscriptions of the user for the application,
+// sorted by name.
+func (a *ReportSubscriptionAPI) List(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	limit, offset, err := httpLimitOffset(r, reportSubscriptionListMaxLimit)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	user, err := a.getUser(ctx, applicationID)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	filters := storage.ReportSubscriptionFilters{
+		UserID:        user.ID,
+		ApplicationID: applicationID,
+		Limit:         limit,
+		Offset:        offset,
+	}
+
+	count, err := storage.GetReportSubscriptionCount(ctx, storage.DB(), filters)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	subs, err := storage.GetReportSubscriptions(ctx, storage.DB(), filters)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListReportSubscriptionsResponse{
+		TotalCount: count,
+		Result:     []ReportSubscription{},
+	}
+	for _, s := range subs {
+		resp.Result = append(resp.Result, reportSubscriptionFromStorage(s))
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// Get returns the report subscription.
+func (a *ReportSubscriptionAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	s, err := a.getReportSubscription(ctx, r)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, GetReportSubscriptionResponse{
+		Subscription: reportSubscriptionFromStorage(s),
+	})
+}
+
+// Update updates the report subscription. The next run is re-scheduled
+// based on the (updated) schedule.
+func (a *ReportSubscriptionAPI) Update(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	var req UpdateReportSubscriptionRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	s, err := a.getReportSubscription(ctx, r)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	reportSubscriptionToStorage(req.Subscription, &s)
+
+	if err := storage.UpdateReportSubscription(ctx, storage.DB(), &s); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// Delete deletes the report subscription.
+func (a *ReportSubscriptionAPI) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	s, err := a.getReportSubscription(ctx, r)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := storage.DeleteReportSubscription(ctx, storage.DB(), s.ID); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// getUser validates that the client has read access to the application and
+// returns the user. As the reports are sent by e-mail to the user, API keys
+// can not be used to manage report subscriptions.
+func (a *ReportSubscriptionAPI) getUser(ctx context.Context, applicationID int64) (storage.User, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(applicationID, auth.Read),
+	); err != nil {
+		return storage.User{}, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	sub, err := a.validator.GetSubject(ctx)
+	if err != nil {
+		return storage.User{}, err
+	}
+
+	if sub != auth.SubjectUser {
+		return storage.User{}, grpc.Errorf(codes.Unauthenticated, "report subscriptions can only be managed by users")
+	}
+
+	return a.validator.GetUser(ctx)
+}
+
+// getReportSubscription returns the report subscription of the id route
+// variable. Subscriptions of other users are reported as not existing.
+func (a *ReportSubscriptionAPI) getReportSubscription(ctx context.Context, r *http.Request) (storage.ReportSubscription, error) {
+	id, err := httpUUIDVar(r, "id")
+	if err != nil {
+		return storage.ReportSubscription{}, err
+	}
+
+	s, err := storage.GetReportSubscription(ctx, storage.DB(), id)
+	if err != nil {
+		return s, err
+	}
+
+	user, err := a.getUser(ctx, s.ApplicationID)
+	if err != nil {
+		return s, err
+	}
+
+	if s.UserID != user.ID {
+		return s, storage.ErrDoesNotExist
+	}
+
+	return s, nil
+}
+
+func reportSubscriptionToStorage(in ReportSubscription, out *storage.ReportSubscription) {
+	out.Name = in.Name
+	out.Enabled = in.Enabled
+	out.Schedule = in.Schedule
+	out.Format = in.Format
+	out.ZoneTagKey = in.ZoneTagKey
+	out.Zone = in.Zone
+	out.Measurements = in.Measurements
+
+	if out.Zone != "" && out.ZoneTagKey == "" {
+		out.ZoneTagKey = zoneDefaultTagKey
+	}
+}
+
+func reportSubscriptionFromStorage(s storage.ReportSubscription) ReportSubscription {
+	measurements := []string(s.Measurements)
+	if measurements == nil {
+		measurements = []string{}
+	}
+
+	return ReportSubscription{
+		ID:            s.ID.String(),
+		ApplicationID: s.ApplicationID,
+		Name:          s.Name,
+		Enabled:       s.Enabled,
+		Schedule:      s.Schedule,
+		Format:        s.Format,
+		ZoneTagKey:    s.ZoneTagKey,
+		Zone:          s.Zone,
+		Measurements:  measurements,
+		NextRunAt:     s.NextRunAt,
+		LastRunAt:     s.LastRunAt,
+		LastError:     s.LastError,
+		CreatedAt:     s.CreatedAt,
+		UpdatedAt:     s.UpdatedAt,
+	}
+}
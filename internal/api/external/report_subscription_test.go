@@ -0,0 +1,199 @@
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+func (ts *APITestSuite) TestReportSubscription() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := storage.Application{
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+		Name:             "test-app",
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	users := []storage.User{
+		{IsActive: true, Email: "farmer@example.com"},
+		{IsActive: true, Email: "operator@example.com"},
+	}
+	for i := range users {
+		assert.NoError(storage.CreateUser(context.Background(), storage.DB(), &users[i]))
+	}
+
+	validator := &TestValidator{
+		returnSubject: auth.SubjectUser,
+		returnUser:    users[0],
+	}
+	r := mux.NewRouter()
+	NewReportSubscriptionAPI(validator).Register(r)
+
+	rs := ReportSubscription{
+		Name:         "greenhouse daily",
+		Enabled:      true,
+		Schedule:     storage.ReportScheduleDaily,
+		Format:       storage.ReportFormatCSV,
+		Zone:         "greenhouse",
+		Measurements: []string{"temperature", "humidity"},
+	}
+
+	ts.T().Run("Create invalid", func(t *testing.T) {
+		assert := require.New(t)
+
+		invalid := rs
+		invalid.Format = "XLS"
+
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/report-subscriptions", app.ID), CreateReportSubscriptionRequest{
+			Subscription: invalid,
+		})
+		assert.Equal(http.StatusBadRequest, rec.Code)
+	})
+
+	ts.T().Run("Create using API key", func(t *testing.T) {
+		assert := require.New(t)
+
+		validator.returnSubject = auth.SubjectAPIKey
+		defer func() { validator.returnSubject = auth.SubjectUser }()
+
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/report-subscriptions", app.ID), CreateReportSubscriptionRequest{
+			Subscription: rs,
+		})
+		assert.Equal(http.StatusUnauthorized, rec.Code)
+	})
+
+	var id string
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/report-subscriptions", app.ID), CreateReportSubscriptionRequest{
+			Subscription: rs,
+		})
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp CreateReportSubscriptionResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		id = resp.ID
+	})
+
+	ts.T().Run("Get", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "GET", "/api/report-subscriptions/"+id, nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp GetReportSubscriptionResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(app.ID, resp.Subscription.ApplicationID)
+		assert.Equal("greenhouse daily", resp.Subscription.Name)
+		assert.Equal("zone", resp.Subscription.ZoneTagKey)
+		assert.Equal(rs.Measurements, resp.Subscription.Measurements)
+		assert.False(resp.Subscription.NextRunAt.IsZero())
+
+		rec = httpTestRequest(r, "GET", "/api/report-subscriptions/"+uuid.Must(uuid.NewV4()).String(), nil)
+		assert.Equal(http.StatusNotFound, rec.Code)
+	})
+
+	ts.T().Run("Other user", func(t *testing.T) {
+		assert := require.New(t)
+
+		validator.returnUser = users[1]
+		defer func() { validator.returnUser = users[0] }()
+
+		rec := httpTestRequest(r, "GET", "/api/report-subscriptions/"+id, nil)
+		assert.Equal(http.StatusNotFound, rec.Code)
+
+		rec = httpTestRequest(r, "GET", fmt.Sprintf("/api/applications/%d/report-subscriptions?limit=10", app.ID), nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp ListReportSubscriptionsResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(0, resp.TotalCount)
+		assert.Len(resp.Result, 0)
+	})
+
+	ts.T().Run("List", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "GET", fmt.Sprintf("/api/applications/%d/report-subscriptions?limit=10", app.ID), nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp ListReportSubscriptionsResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(1, resp.TotalCount)
+		assert.Len(resp.Result, 1)
+		assert.Equal(id, resp.Result[0].ID)
+	})
+
+	ts.T().Run("Update", func(t *testing.T) {
+		assert := require.New(t)
+
+		updated := rs
+		updated.Schedule = storage.ReportScheduleWeekly
+		updated.Format = storage.ReportFormatPDF
+		updated.Zone = ""
+		updated.Measurements = nil
+
+		rec := httpTestRequest(r, "PUT", "/api/report-subscriptions/"+id, UpdateReportSubscriptionRequest{
+			Subscription: updated,
+		})
+		assert.Equal(http.StatusOK, rec.Code)
+
+		rec = httpTestRequest(r, "GET", "/api/report-subscriptions/"+id, nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp GetReportSubscriptionResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(storage.ReportScheduleWeekly, resp.Subscription.Schedule)
+		assert.Equal(storage.ReportFormatPDF, resp.Subscription.Format)
+		assert.Equal("", resp.Subscription.Zone)
+		assert.Equal([]string{}, resp.Subscription.Measurements)
+	})
+
+	ts.T().Run("Delete", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "DELETE", "/api/report-subscriptions/"+id, nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		rec = httpTestRequest(r, "DELETE", "/api/report-subscriptions/"+id, nil)
+		assert.Equal(http.StatusNotFound, rec.Code)
+	})
+}
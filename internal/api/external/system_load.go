@@ -0,0 +1,120 @@
+package external
+
+import (
+	"net/http"
+	"runtime"
+	"sort"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/eventlog"
+	"github.com/ibrahimozekici/app-server2/internal/integration/multi"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// IntegrationLoad defines the delivery statistics of an integration.
+type IntegrationLoad struct {
+	Integration string `json:"integration"`
+	InFlight    int64  `json:"inFlight"`
+	Delivered   int64  `json:"delivered"`
+	Failed      int64  `json:"failed"`
+}
+
+// EventLogLoad defines the device event-log load.
+type EventLogLoad struct {
+	Devices     int   `json:"devices"`
+	Subscribers int64 `json:"subscribers"`
+}
+
+// SchedulerBacklog defines the downlink scheduler backlog.
+type SchedulerBacklog struct {
+	ConfirmedDownlinkRetries int64 `json:"confirmedDownlinkRetries"`
+	ExpiredDeviceQueueItems  int64 `json:"expiredDeviceQueueItems"`
+	FirmwareCampaignDevices  int64 `json:"firmwareCampaignDevices"`
+	FUOTADeployments         int64 `json:"fuotaDeployments"`
+}
+
+// GetSystemLoadResponse defines the system load response.
+type GetSystemLoadResponse struct {
+	Integrations     []IntegrationLoad `json:"integrations"`
+	EventLog         EventLogLoad      `json:"eventLog"`
+	SchedulerBacklog SchedulerBacklog  `json:"schedulerBacklog"`
+	Goroutines       int               `json:"goroutines"`
+}
+
+// SystemLoadAPI exports the system load related functions.
+type SystemLoadAPI struct {
+	validator auth.Validator
+}
+
+// NewSystemLoadAPI creates a new SystemLoadAPI.
+func NewSystemLoadAPI(validator auth.Validator) *SystemLoadAPI {
+	return &SystemLoadAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *SystemLoadAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/internal/system-load", a.Get).Methods("GET")
+}
+
+// Get returns the current system load: the in-flight deliveries per
+// integration, the device event-log subscriptions and the backlog of the
+// downlink schedulers. The integration statistics are local to the
+// application-server instance serving the request. This is restricted to
+// global admin users.
+func (a *SystemLoadAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateOrganizationsAccess(auth.Create),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	backlog, err := storage.GetSchedulerBacklog(ctx, storage.DB())
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	el, err := eventlog.GetSubscriptionStats()
+	if err != nil {
+		httpWriteError(w, grpc.Errorf(codes.Internal, "get event-log stats error: %s", err))
+		return
+	}
+
+	resp := GetSystemLoadResponse{
+		Integrations: []IntegrationLoad{},
+		EventLog: EventLogLoad{
+			Devices:     el.Devices,
+			Subscribers: el.Subscribers,
+		},
+		SchedulerBacklog: SchedulerBacklog{
+			ConfirmedDownlinkRetries: backlog.ConfirmedDownlinkRetries,
+			ExpiredDeviceQueueItems:  backlog.ExpiredDeviceQueueItems,
+			FirmwareCampaignDevices:  backlog.FirmwareCampaignDevices,
+			FUOTADeployments:         backlog.FUOTADeployments,
+		},
+		Goroutines: runtime.NumGoroutine(),
+	}
+
+	for name, s := range multi.Stats() {
+		resp.Integrations = append(resp.Integrations, IntegrationLoad{
+			Integration: name,
+			InFlight:    s.InFlight,
+			Delivered:   s.Delivered,
+			Failed:      s.Failed,
+		})
+	}
+	sort.Slice(resp.Integrations, func(i, j int) bool {
+		return resp.Integrations[i].Integration < resp.Integrations[j].Integration
+	})
+
+	httpWriteJSON(w, resp)
+}
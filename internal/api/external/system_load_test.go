@@ -0,0 +1,26 @@
+package external
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func (ts *APITestSuite) TestSystemLoad() {
+	assert := require.New(ts.T())
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewSystemLoadAPI(validator).Register(r)
+
+	rec := httpTestRequest(r, "GET", "/api/internal/system-load", nil)
+	assert.Equal(http.StatusOK, rec.Code)
+
+	var resp GetSystemLoadResponse
+	assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(SchedulerBacklog{}, resp.SchedulerBacklog)
+	assert.NotNil(resp.Integrations)
+	assert.True(resp.Goroutines > 0)
+}
@@ -0,0 +1,324 @@
+package external
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// thresholdProfileListMaxLimit defines the max. number of threshold
+// profiles returned by a single list request.
+const thresholdProfileListMaxLimit = 1000
+
+// ThresholdProfile defines a named set of thresholds of an organization.
+// The profile is attached to devices and zones by creating an automation
+// with a THRESHOLD trigger referring to the profile (selecting the devices
+// by its device tags). Updating the thresholds updates the limits of all
+// these automations.
+type ThresholdProfile struct {
+	ID             string          `json:"id"`
+	OrganizationID int64           `json:"organizationID,string"`
+	Name           string          `json:"name"`
+	Description    string          `json:"description"`
+	Thresholds     spec.Thresholds `json:"thresholds"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	UpdatedAt      time.Time       `json:"updatedAt"`
+}
+
+// CreateThresholdProfileRequest defines the create threshold profile
+// request.
+type CreateThresholdProfileRequest struct {
+	Profile ThresholdProfile `json:"profile"`
+}
+
+// CreateThresholdProfileResponse defines the create threshold profile
+// response.
+type CreateThresholdProfileResponse struct {
+	ID string `json:"id"`
+}
+
+// GetThresholdProfileResponse defines the get threshold profile response.
+type GetThresholdProfileResponse struct {
+	Profile ThresholdProfile `json:"profile"`
+}
+
+// UpdateThresholdProfileRequest defines the update threshold profile
+// request.
+type UpdateThresholdProfileRequest struct {
+	Profile ThresholdProfile `json:"profile"`
+}
+
+// ListThresholdProfilesResponse defines the list threshold profiles
+// response.
+type ListThresholdProfilesResponse struct {
+	TotalCount int                `json:"totalCount"`
+	Result     []ThresholdProfile `json:"result"`
+}
+
+// ThresholdProfileAPI exports the threshold profile related functions.
+type ThresholdProfileAPI struct {
+	validator auth.Validator
+}
+
+// NewThresholdProfileAPI creates a new ThresholdProfileAPI.
+func NewThresholdProfileAPI(validator auth.Validator) *ThresholdProfileAPI {
+	return &ThresholdProfileAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *ThresholdProfileAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/organizations/{organization_id}/threshold-profiles", a.Create).Methods("POST")
+	r.HandleFunc("/api/organizations/{organization_id}/threshold-profiles", a.List).Methods("GET")
+	r.HandleFunc("/api/threshold-profiles/{id}", a.Get).Methods("GET")
+	r.HandleFunc("/api/threshold-profiles/{id}", a.Update).Methods("PUT")
+	r.HandleFunc("/api/threshold-profiles/{id}", a.Delete).Methods("DELETE")
+	r.HandleFunc("/api/threshold-profiles/{id}/automations", a.ListAutomations).Methods("GET")
+}
+
+// Create creates the given threshold profile for the organization. This
+// requires organization admin access.
+func (a *ThresholdProfileAPI) Create(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	organizationID, err := httpInt64Var(r, "organization_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateIsOrganizationAdmin(organizationID),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req CreateThresholdProfileRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := req.Profile.Thresholds.Validate(); err != nil {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "thresholds: %s", err))
+		return
+	}
+
+	p := storage.ThresholdProfile{
+		OrganizationID: organizationID,
+	}
+	thresholdProfileToStorage(req.Profile, &p)
+
+	if err := storage.CreateThresholdProfile(ctx, storage.DB(), &p); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, CreateThresholdProfileResponse{
+		ID: p.ID.String(),
+	})
+}
+
+// List lists the threshold profiles of the organization, sorted by name.
+func (a *ThresholdProfileAPI) List(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	limit, offset, err := httpLimitOffset(r, thresholdProfileListMaxLimit)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	organizationID, err := httpInt64Var(r, "organization_id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateOrganizationAccess(auth.Read, organizationID),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	filters := storage.ThresholdProfileFilters{
+		OrganizationID: organizationID,
+		Limit:          limit,
+		Offset:         offset,
+	}
+
+	count, err := storage.GetThresholdProfileCount(ctx, storage.DB(), filters)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	profiles, err := storage.GetThresholdProfiles(ctx, storage.DB(), filters)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListThresholdProfilesResponse{
+		TotalCount: count,
+		Result:     []ThresholdProfile{},
+	}
+	for _, p := range profiles {
+		resp.Result = append(resp.Result, thresholdProfileFromStorage(p))
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// Get returns the threshold profile.
+func (a *ThresholdProfileAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	p, err := a.getThresholdProfile(ctx, r, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, GetThresholdProfileResponse{
+		Profile: thresholdProfileFromStorage(p),
+	})
+}
+
+// Update updates the threshold profile. The new thresholds apply to all the
+// automations using the profile.
+func (a *ThresholdProfileAPI) Update(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	var req UpdateThresholdProfileRequest
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	p, err := a.getThresholdProfile(ctx, r, auth.Update)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := req.Profile.Thresholds.Validate(); err != nil {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "thresholds: %s", err))
+		return
+	}
+
+	thresholdProfileToStorage(req.Profile, &p)
+
+	if err := storage.UpdateThresholdProfile(ctx, storage.DB(), &p); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// Delete deletes the threshold profile. A profile which is used by an
+// automation can not be deleted.
+func (a *ThresholdProfileAPI) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	p, err := a.getThresholdProfile(ctx, r, auth.Delete)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := storage.DeleteThresholdProfile(ctx, storage.DB(), p.ID); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// ListAutomations lists the automations using the threshold profile, i.e.
+// the devices and zones to which the profile is attached.
+func (a *ThresholdProfileAPI) ListAutomations(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	p, err := a.getThresholdProfile(ctx, r, auth.Read)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	automations, err := storage.GetThresholdProfileAutomations(ctx, storage.DB(), p.ID)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp := ListAutomationResponse{
+		Result: []Automation{},
+	}
+	for _, am := range automations {
+		resp.Result = append(resp.Result, automationFromStorage(am))
+	}
+
+	httpWriteJSON(w, resp)
+}
+
+// getThresholdProfile returns the threshold profile of the id route
+// variable and validates that the client has the requested access to its
+// organization. Modifying a profile requires organization admin access.
+func (a *ThresholdProfileAPI) getThresholdProfile(ctx context.Context, r *http.Request, flag auth.Flag) (storage.ThresholdProfile, error) {
+	id, err := httpUUIDVar(r, "id")
+	if err != nil {
+		return storage.ThresholdProfile{}, err
+	}
+
+	p, err := storage.GetThresholdProfile(ctx, storage.DB(), id)
+	if err != nil {
+		return p, err
+	}
+
+	validator := auth.ValidateIsOrganizationAdmin(p.OrganizationID)
+	if flag == auth.Read {
+		validator = auth.ValidateOrganizationAccess(auth.Read, p.OrganizationID)
+	}
+
+	if err := a.validator.Validate(ctx, validator); err != nil {
+		return p, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	return p, nil
+}
+
+func thresholdProfileToStorage(in ThresholdProfile, out *storage.ThresholdProfile) {
+	out.Name = in.Name
+	out.Description = in.Description
+	out.Thresholds = in.Thresholds
+}
+
+func thresholdProfileFromStorage(p storage.ThresholdProfile) ThresholdProfile {
+	thresholds := p.Thresholds
+	if thresholds == nil {
+		thresholds = spec.Thresholds{}
+	}
+
+	return ThresholdProfile{
+		ID:             p.ID.String(),
+		OrganizationID: p.OrganizationID,
+		Name:           p.Name,
+		Description:    p.Description,
+		Thresholds:     thresholds,
+		CreatedAt:      p.CreatedAt,
+		UpdatedAt:      p.UpdatedAt,
+	}
+}
@@ -0,0 +1,237 @@
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+func (ts *APITestSuite) TestThresholdProfile() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewThresholdProfileAPI(validator).Register(r)
+	NewAutomationAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	org2 := storage.Organization{
+		Name: "test-org-2",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org2))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := storage.Application{
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+		Name:             "test-app",
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	min := float64(2)
+	max := float64(8)
+	tp := ThresholdProfile{
+		Name:        "cold room",
+		Description: "Vaccine storage",
+		Thresholds: spec.Thresholds{
+			{Path: "temperature", Min: &min, Max: &max},
+		},
+	}
+
+	ts.T().Run("Create invalid", func(t *testing.T) {
+		assert := require.New(t)
+
+		invalid := tp
+		invalid.Thresholds = spec.Thresholds{{Path: "temperature"}}
+
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/organizations/%d/threshold-profiles", org.ID), CreateThresholdProfileRequest{
+			Profile: invalid,
+		})
+		assert.Equal(http.StatusBadRequest, rec.Code)
+
+		var resp httpErrorBody
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal("thresholds: threshold 0: min or max is required", resp.Error)
+	})
+
+	var id string
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/organizations/%d/threshold-profiles", org.ID), CreateThresholdProfileRequest{
+			Profile: tp,
+		})
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp CreateThresholdProfileResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		id = resp.ID
+	})
+
+	ts.T().Run("Get", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "GET", "/api/threshold-profiles/"+id, nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp GetThresholdProfileResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(org.ID, resp.Profile.OrganizationID)
+		assert.Equal("cold room", resp.Profile.Name)
+		assert.Equal(tp.Thresholds, resp.Profile.Thresholds)
+
+		rec = httpTestRequest(r, "GET", "/api/threshold-profiles/"+uuid.Must(uuid.NewV4()).String(), nil)
+		assert.Equal(http.StatusNotFound, rec.Code)
+	})
+
+	ts.T().Run("List", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := httpTestRequest(r, "GET", fmt.Sprintf("/api/organizations/%d/threshold-profiles?limit=10", org.ID), nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp ListThresholdProfilesResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(1, resp.TotalCount)
+		assert.Len(resp.Result, 1)
+
+		rec = httpTestRequest(r, "GET", fmt.Sprintf("/api/organizations/%d/threshold-profiles?limit=10", org2.ID), nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		resp = ListThresholdProfilesResponse{}
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(0, resp.TotalCount)
+	})
+
+	ts.T().Run("Update", func(t *testing.T) {
+		assert := require.New(t)
+
+		max := float64(6)
+		updated := tp
+		updated.Thresholds = spec.Thresholds{
+			{Path: "temperature", Min: &min, Max: &max},
+		}
+
+		rec := httpTestRequest(r, "PUT", "/api/threshold-profiles/"+id, UpdateThresholdProfileRequest{
+			Profile: updated,
+		})
+		assert.Equal(http.StatusOK, rec.Code)
+
+		p, err := storage.GetThresholdProfile(context.Background(), storage.DB(), uuid.FromStringOrNil(id))
+		assert.NoError(err)
+		assert.Equal(float64(6), *p.Thresholds[0].Max)
+	})
+
+	ts.T().Run("Automations", func(t *testing.T) {
+		assert := require.New(t)
+
+		profileID := uuid.FromStringOrNil(id)
+		am := Automation{
+			Name:    "cold-room",
+			Enabled: true,
+			DeviceTags: map[string]string{
+				"zone": "cold-room-1",
+			},
+			Trigger: spec.Trigger{
+				Type:               spec.ThresholdTrigger,
+				ThresholdProfileID: &profileID,
+			},
+			Actions: spec.Actions{
+				{Type: spec.WebhookAction, URL: "http://localhost/hook"},
+			},
+		}
+
+		rec := httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/automations", app.ID), CreateAutomationRequest{
+			Automation: am,
+		})
+		assert.Equal(http.StatusOK, rec.Code)
+
+		rec = httpTestRequest(r, "GET", "/api/threshold-profiles/"+id+"/automations", nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp ListAutomationResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Len(resp.Result, 1)
+		assert.Equal("cold-room", resp.Result[0].Name)
+
+		// the profile is in use
+		rec = httpTestRequest(r, "DELETE", "/api/threshold-profiles/"+id, nil)
+		assert.Equal(http.StatusBadRequest, rec.Code)
+
+		// profile of an other organization
+		p := storage.ThresholdProfile{
+			OrganizationID: org2.ID,
+			Name:           "other",
+			Thresholds:     tp.Thresholds,
+		}
+		assert.NoError(storage.CreateThresholdProfile(context.Background(), storage.DB(), &p))
+		am.Trigger.ThresholdProfileID = &p.ID
+
+		rec = httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/automations", app.ID), CreateAutomationRequest{
+			Automation: am,
+		})
+		assert.Equal(http.StatusBadRequest, rec.Code)
+
+		var errResp httpErrorBody
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&errResp))
+		assert.Equal("trigger: threshold profile does not belong to the organization", errResp.Error)
+
+		// unknown profile
+		unknown := uuid.Must(uuid.NewV4())
+		am.Trigger.ThresholdProfileID = &unknown
+
+		rec = httpTestRequest(r, "POST", fmt.Sprintf("/api/applications/%d/automations", app.ID), CreateAutomationRequest{
+			Automation: am,
+		})
+		assert.Equal(http.StatusBadRequest, rec.Code)
+	})
+
+	ts.T().Run("Delete", func(t *testing.T) {
+		assert := require.New(t)
+
+		automations, err := storage.GetThresholdProfileAutomations(context.Background(), storage.DB(), uuid.FromStringOrNil(id))
+		assert.NoError(err)
+		for _, am := range automations {
+			assert.NoError(storage.DeleteAutomation(context.Background(), storage.DB(), am.ID))
+		}
+
+		rec := httpTestRequest(r, "DELETE", "/api/threshold-profiles/"+id, nil)
+		assert.Equal(http.StatusOK, rec.Code)
+
+		rec = httpTestRequest(r, "DELETE", "/api/threshold-profiles/"+id, nil)
+		assert.Equal(http.StatusNotFound, rec.Code)
+	})
+}
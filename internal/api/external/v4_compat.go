@@ -0,0 +1,918 @@
+package external
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb //"github.com/ibrahimozekici/lora-api/go/v3/as/external/api"
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// The v4 compatibility API exposes a subset of the ChirpStack v4 REST API
+// (https://github.com/chirpstack/chirpstack-rest-api), mapped onto the data
+// model of this application-server, such that tooling written for the v4 REST
+// API can be used by configuring the following base URL:
+//
+//   https://<host>/v4
+//
+// The v4 objects are mapped as follows:
+//   * tenants are organizations (read-only)
+//   * applications are applications, the (v4 unknown) service-profile is
+//     given by the serviceProfileId field or is the only service-profile of
+//     the organization
+//   * devices and device keys are devices and device keys
+//   * device-profiles are device-profiles (read-only)
+//   * gateways are gateways (read-only)
+//
+// The numeric ids of this application-server are exposed as (v4) string ids.
+// Only the v4 REST API is implemented. The v4 gRPC services are not
+// available, as these require the v4 protobuf definitions which this
+// application-server does not depend on. Tooling using the v4 gRPC API
+// (e.g. the gRPC based SDKs and Terraform provider) is not supported.
+
+// v4CompatPrefix defines the path prefix of the v4 compatibility API.
+const v4CompatPrefix = "/v4"
+
+// v4CompatListMaxLimit defines the max. number of items returned by the list
+// endpoints.
+const v4CompatListMaxLimit = 1000
+
+// V4Tenant defines the v4 tenant.
+type V4Tenant struct {
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	Description     string `json:"description"`
+	CanHaveGateways bool   `json:"canHaveGateways"`
+	MaxGatewayCount uint32 `json:"maxGatewayCount"`
+	MaxDeviceCount  uint32 `json:"maxDeviceCount"`
+}
+
+// V4TenantListItem defines the v4 tenant list item.
+type V4TenantListItem struct {
+	ID              string     `json:"id"`
+	CreatedAt       *time.Time `json:"createdAt"`
+	UpdatedAt       *time.Time `json:"updatedAt"`
+	Name            string     `json:"name"`
+	CanHaveGateways bool       `json:"canHaveGateways"`
+}
+
+// V4Application defines the v4 application. ServiceProfileID is not part of
+// the v4 API.
+type V4Application struct {
+	ID               string `json:"id"`
+	Name             string `json:"name"`
+	Description      string `json:"description"`
+	TenantID         string `json:"tenantId"`
+	ServiceProfileID string `json:"serviceProfileId,omitempty"`
+}
+
+// V4ApplicationListItem defines the v4 application list item.
+type V4ApplicationListItem struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// V4Device defines the v4 device.
+type V4Device struct {
+	DevEUI          string            `json:"devEui"`
+	Name            string            `json:"name"`
+	Description     string            `json:"description"`
+	ApplicationID   string            `json:"applicationId"`
+	DeviceProfileID string            `json:"deviceProfileId"`
+	SkipFCntCheck   bool              `json:"skipFcntCheck"`
+	IsDisabled      bool              `json:"isDisabled"`
+	Variables       map[string]string `json:"variables"`
+	Tags            map[string]string `json:"tags"`
+}
+
+// V4DeviceStatus defines the v4 device status.
+type V4DeviceStatus struct {
+	Margin              int32   `json:"margin"`
+	ExternalPowerSource bool    `json:"externalPowerSource"`
+	BatteryLevel        float32 `json:"batteryLevel"`
+}
+
+// V4DeviceListItem defines the v4 device list item.
+type V4DeviceListItem struct {
+	DevEUI            string          `json:"devEui"`
+	LastSeenAt        *time.Time      `json:"lastSeenAt"`
+	Name              string          `json:"name"`
+	Description       string          `json:"description"`
+	DeviceProfileID   string          `json:"deviceProfileId"`
+	DeviceProfileName string          `json:"deviceProfileName"`
+	DeviceStatus      *V4DeviceStatus `json:"deviceStatus"`
+}
+
+// V4DeviceKeys defines the v4 device keys. For LoRaWAN 1.0.x devices, the
+// AppKey is stored as NwkKey (as in v4).
+type V4DeviceKeys struct {
+	NwkKey    string `json:"nwkKey"`
+	AppKey    string `json:"appKey"`
+	GenAppKey string `json:"genAppKey"`
+}
+
+// V4DeviceProfile defines the v4 device-profile.
+type V4DeviceProfile struct {
+	ID                 string            `json:"id"`
+	TenantID           string            `json:"tenantId"`
+	Name               string            `json:"name"`
+	Region             string            `json:"region"`
+	MacVersion         string            `json:"macVersion"`
+	RegParamsRevision  string            `json:"regParamsRevision"`
+	PayloadCodecScript string            `json:"payloadCodecScript"`
+	SupportsOTAA       bool              `json:"supportsOtaa"`
+	SupportsClassB     bool              `json:"supportsClassB"`
+	SupportsClassC     bool              `json:"supportsClassC"`
+	ClassBTimeout      uint32            `json:"classBTimeout"`
+	ClassCTimeout      uint32            `json:"classCTimeout"`
+	Tags               map[string]string `json:"tags"`
+}
+
+// V4DeviceProfileListItem defines the v4 device-profile list item.
+type V4DeviceProfileListItem struct {
+	ID        string     `json:"id"`
+	CreatedAt *time.Time `json:"createdAt"`
+	UpdatedAt *time.Time `json:"updatedAt"`
+	Name      string     `json:"name"`
+}
+
+// V4Location defines the v4 location.
+type V4Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude"`
+}
+
+// V4Gateway defines the v4 gateway.
+type V4Gateway struct {
+	GatewayID   string            `json:"gatewayId"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Location    V4Location        `json:"location"`
+	TenantID    string            `json:"tenantId"`
+	Tags        map[string]string `json:"tags"`
+	Metadata    map[string]string `json:"metadata"`
+}
+
+// V4GatewayListItem defines the v4 gateway list item.
+type V4GatewayListItem struct {
+	TenantID    string     `json:"tenantId"`
+	GatewayID   string     `json:"gatewayId"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Location    V4Location `json:"location"`
+	CreatedAt   *time.Time `json:"createdAt"`
+	UpdatedAt   *time.Time `json:"updatedAt"`
+	LastSeenAt  *time.Time `json:"lastSeenAt"`
+}
+
+// V4ListResponse defines the v4 list response.
+type V4ListResponse struct {
+	TotalCount int64       `json:"totalCount"`
+	Result     interface{} `json:"result"`
+}
+
+// V4CompatAPI exports the ChirpStack v4 compatibility endpoints.
+type V4CompatAPI struct {
+	validator      auth.Validator
+	organizations  *OrganizationAPI
+	applications   *ApplicationAPI
+	devices        *DeviceAPI
+	deviceProfiles *DeviceProfileServiceAPI
+	gateways       *GatewayAPI
+}
+
+// NewV4CompatAPI creates a new V4CompatAPI.
+func NewV4CompatAPI(validator auth.Validator) *V4CompatAPI {
+	return &V4CompatAPI{
+		validator:      validator,
+		organizations:  NewOrganizationAPI(validator),
+		applications:   NewApplicationAPI(validator),
+		devices:        NewDeviceAPI(validator),
+		deviceProfiles: NewDeviceProfileServiceAPI(validator),
+		gateways:       NewGatewayAPI(validator),
+	}
+}
+
+// Register registers the API endpoints.
+func (a *V4CompatAPI) Register(r *mux.Router) {
+	s := r.PathPrefix(v4CompatPrefix + "/api").Subrouter()
+
+	s.HandleFunc("/tenants", a.ListTenants).Methods("GET")
+	s.HandleFunc("/tenants/{id}", a.GetTenant).Methods("GET")
+
+	s.HandleFunc("/applications", a.ListApplications).Methods("GET")
+	s.HandleFunc("/applications", a.CreateApplication).Methods("POST")
+	s.HandleFunc("/applications/{id}", a.GetApplication).Methods("GET")
+	s.HandleFunc("/applications/{id}", a.UpdateApplication).Methods("PUT")
+	s.HandleFunc("/applications/{id}", a.DeleteApplication).Methods("DELETE")
+
+	s.HandleFunc("/devices", a.ListDevices).Methods("GET")
+	s.HandleFunc("/devices", a.CreateDevice).Methods("POST")
+	s.HandleFunc("/devices/{dev_eui}", a.GetDevice).Methods("GET")
+	s.HandleFunc("/devices/{dev_eui}", a.UpdateDevice).Methods("PUT")
+	s.HandleFunc("/devices/{dev_eui}", a.DeleteDevice).Methods("DELETE")
+	s.HandleFunc("/devices/{dev_eui}/keys", a.GetDeviceKeys).Methods("GET")
+	s.HandleFunc("/devices/{dev_eui}/keys", a.CreateDeviceKeys).Methods("POST")
+	s.HandleFunc("/devices/{dev_eui}/keys", a.UpdateDeviceKeys).Methods("PUT")
+	s.HandleFunc("/devices/{dev_eui}/keys", a.DeleteDeviceKeys).Methods("DELETE")
+
+	s.HandleFunc("/device-profiles", a.ListDeviceProfiles).Methods("GET")
+	s.HandleFunc("/device-profiles/{id}", a.GetDeviceProfile).Methods("GET")
+
+	s.HandleFunc("/gateways", a.ListGateways).Methods("GET")
+	s.HandleFunc("/gateways/{id}", a.GetGateway).Methods("GET")
+}
+
+// GetTenant returns the organization as v4 tenant.
+func (a *V4CompatAPI) GetTenant(w http.ResponseWriter, r *http.Request) {
+	id, err := httpInt64Var(r, "id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp, err := a.organizations.Get(httpContext(r), &pb.GetOrganizationRequest{Id: id})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	org := resp.GetOrganization()
+	httpWriteJSON(w, struct {
+		Tenant    V4Tenant   `json:"tenant"`
+		CreatedAt *time.Time `json:"createdAt"`
+		UpdatedAt *time.Time `json:"updatedAt"`
+	}{
+		Tenant: V4Tenant{
+			ID:              strconv.FormatInt(org.Id, 10),
+			Name:            v4TenantName(org.Name, org.DisplayName),
+			CanHaveGateways: org.CanHaveGateways,
+			MaxGatewayCount: org.MaxGatewayCount,
+			MaxDeviceCount:  org.MaxDeviceCount,
+		},
+		CreatedAt: v4Time(resp.CreatedAt),
+		UpdatedAt: v4Time(resp.UpdatedAt),
+	})
+}
+
+// ListTenants returns the organizations as v4 tenants.
+func (a *V4CompatAPI) ListTenants(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := httpLimitOffset(r, v4CompatListMaxLimit)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp, err := a.organizations.List(httpContext(r), &pb.ListOrganizationRequest{
+		Limit:  int64(limit),
+		Offset: int64(offset),
+		Search: r.URL.Query().Get("search"),
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	items := make([]V4TenantListItem, 0, len(resp.Result))
+	for _, org := range resp.Result {
+		items = append(items, V4TenantListItem{
+			ID:              strconv.FormatInt(org.Id, 10),
+			CreatedAt:       v4Time(org.CreatedAt),
+			UpdatedAt:       v4Time(org.UpdatedAt),
+			Name:            v4TenantName(org.Name, org.DisplayName),
+			CanHaveGateways: org.CanHaveGateways,
+		})
+	}
+
+	httpWriteJSON(w, V4ListResponse{TotalCount: resp.TotalCount, Result: items})
+}
+
+// CreateApplication creates the given v4 application.
+func (a *V4CompatAPI) CreateApplication(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	var req struct {
+		Application V4Application `json:"application"`
+	}
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	orgID, err := v4ParseID("tenantId", req.Application.TenantID)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationsAccess(auth.Create, orgID),
+	); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	spID := req.Application.ServiceProfileID
+	if spID == "" {
+		sps, err := storage.GetServiceProfiles(ctx, storage.DB(), storage.ServiceProfileFilters{
+			OrganizationID: orgID,
+			Limit:          2,
+		})
+		if err != nil {
+			httpWriteError(w, err)
+			return
+		}
+		if len(sps) != 1 {
+			httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "serviceProfileId must be set when the tenant does not have exactly one service-profile"))
+			return
+		}
+		spID = sps[0].ServiceProfileID.String()
+	}
+
+	resp, err := a.applications.Create(ctx, &pb.CreateApplicationRequest{
+		Application: &pb.Application{
+			Name:             req.Application.Name,
+			Description:      req.Application.Description,
+			OrganizationId:   orgID,
+			ServiceProfileId: spID,
+		},
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct {
+		ID string `json:"id"`
+	}{
+		ID: strconv.FormatInt(resp.Id, 10),
+	})
+}
+
+// GetApplication returns the v4 application.
+func (a *V4CompatAPI) GetApplication(w http.ResponseWriter, r *http.Request) {
+	id, err := httpInt64Var(r, "id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp, err := a.applications.Get(httpContext(r), &pb.GetApplicationRequest{Id: id})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	app := resp.GetApplication()
+	httpWriteJSON(w, struct {
+		Application V4Application `json:"application"`
+	}{
+		Application: V4Application{
+			ID:               strconv.FormatInt(app.Id, 10),
+			Name:             app.Name,
+			Description:      app.Description,
+			TenantID:         strconv.FormatInt(app.OrganizationId, 10),
+			ServiceProfileID: app.ServiceProfileId,
+		},
+	})
+}
+
+// UpdateApplication updates the name and description of the v4 application.
+func (a *V4CompatAPI) UpdateApplication(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	id, err := httpInt64Var(r, "id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	var req struct {
+		Application V4Application `json:"application"`
+	}
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp, err := a.applications.Get(ctx, &pb.GetApplicationRequest{Id: id})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	app := resp.GetApplication()
+	app.Name = req.Application.Name
+	app.Description = req.Application.Description
+
+	if _, err := a.applications.Update(ctx, &pb.UpdateApplicationRequest{Application: app}); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// DeleteApplication deletes the v4 application.
+func (a *V4CompatAPI) DeleteApplication(w http.ResponseWriter, r *http.Request) {
+	id, err := httpInt64Var(r, "id")
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if _, err := a.applications.Delete(httpContext(r), &pb.DeleteApplicationRequest{Id: id}); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// ListApplications returns the v4 applications of the given tenant.
+func (a *V4CompatAPI) ListApplications(w http.ResponseWriter, r *http.Request) {
+	orgID, err := v4ParseID("tenantId", r.URL.Query().Get("tenantId"))
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	limit, offset, err := httpLimitOffset(r, v4CompatListMaxLimit)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp, err := a.applications.List(httpContext(r), &pb.ListApplicationRequest{
+		Limit:          int64(limit),
+		Offset:         int64(offset),
+		OrganizationId: orgID,
+		Search:         r.URL.Query().Get("search"),
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	items := make([]V4ApplicationListItem, 0, len(resp.Result))
+	for _, app := range resp.Result {
+		items = append(items, V4ApplicationListItem{
+			ID:          strconv.FormatInt(app.Id, 10),
+			Name:        app.Name,
+			Description: app.Description,
+		})
+	}
+
+	httpWriteJSON(w, V4ListResponse{TotalCount: resp.TotalCount, Result: items})
+}
+
+// CreateDevice creates the given v4 device.
+func (a *V4CompatAPI) CreateDevice(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Device V4Device `json:"device"`
+	}
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	appID, err := v4ParseID("applicationId", req.Device.ApplicationID)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if _, err := a.devices.Create(httpContext(r), &pb.CreateDeviceRequest{
+		Device: &pb.Device{
+			DevEui:          req.Device.DevEUI,
+			Name:            req.Device.Name,
+			Description:     req.Device.Description,
+			ApplicationId:   appID,
+			DeviceProfileId: req.Device.DeviceProfileID,
+			SkipFCntCheck:   req.Device.SkipFCntCheck,
+			IsDisabled:      req.Device.IsDisabled,
+			Variables:       req.Device.Variables,
+			Tags:            req.Device.Tags,
+		},
+	}); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// GetDevice returns the v4 device.
+func (a *V4CompatAPI) GetDevice(w http.ResponseWriter, r *http.Request) {
+	resp, err := a.devices.Get(httpContext(r), &pb.GetDeviceRequest{DevEui: mux.Vars(r)["dev_eui"]})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	d := resp.GetDevice()
+	out := struct {
+		Device       V4Device        `json:"device"`
+		LastSeenAt   *time.Time      `json:"lastSeenAt"`
+		DeviceStatus *V4DeviceStatus `json:"deviceStatus"`
+	}{
+		Device: V4Device{
+			DevEUI:          d.DevEui,
+			Name:            d.Name,
+			Description:     d.Description,
+			ApplicationID:   strconv.FormatInt(d.ApplicationId, 10),
+			DeviceProfileID: d.DeviceProfileId,
+			SkipFCntCheck:   d.SkipFCntCheck,
+			IsDisabled:      d.IsDisabled,
+			Variables:       d.Variables,
+			Tags:            d.Tags,
+		},
+		LastSeenAt: v4Time(resp.LastSeenAt),
+	}
+
+	// 256 means that the device-status is not available
+	if resp.DeviceStatusMargin != 256 {
+		out.DeviceStatus = &V4DeviceStatus{
+			Margin:              resp.DeviceStatusMargin,
+			ExternalPowerSource: resp.DeviceStatusBattery == 0,
+		}
+		if resp.DeviceStatusBattery > 0 && resp.DeviceStatusBattery < 255 {
+			out.DeviceStatus.BatteryLevel = float32(resp.DeviceStatusBattery) / 254 * 100
+		}
+	}
+
+	httpWriteJSON(w, out)
+}
+
+// UpdateDevice updates the given v4 device. The application of a device can
+// not be changed.
+func (a *V4CompatAPI) UpdateDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	var req struct {
+		Device V4Device `json:"device"`
+	}
+	if err := httpDecodeJSON(r, &req); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp, err := a.devices.Get(ctx, &pb.GetDeviceRequest{DevEui: mux.Vars(r)["dev_eui"]})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	d := resp.GetDevice()
+	d.Name = req.Device.Name
+	d.Description = req.Device.Description
+	d.DeviceProfileId = req.Device.DeviceProfileID
+	d.SkipFCntCheck = req.Device.SkipFCntCheck
+	d.IsDisabled = req.Device.IsDisabled
+	d.Variables = req.Device.Variables
+	d.Tags = req.Device.Tags
+
+	if _, err := a.devices.Update(ctx, &pb.UpdateDeviceRequest{Device: d}); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// DeleteDevice deletes the v4 device.
+func (a *V4CompatAPI) DeleteDevice(w http.ResponseWriter, r *http.Request) {
+	if _, err := a.devices.Delete(httpContext(r), &pb.DeleteDeviceRequest{DevEui: mux.Vars(r)["dev_eui"]}); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// ListDevices returns the v4 devices of the given application.
+func (a *V4CompatAPI) ListDevices(w http.ResponseWriter, r *http.Request) {
+	appID, err := v4ParseID("applicationId", r.URL.Query().Get("applicationId"))
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	limit, offset, err := httpLimitOffset(r, v4CompatListMaxLimit)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp, err := a.devices.List(httpContext(r), &pb.ListDeviceRequest{
+		Limit:         int64(limit),
+		Offset:        int64(offset),
+		ApplicationId: appID,
+		Search:        r.URL.Query().Get("search"),
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	items := make([]V4DeviceListItem, 0, len(resp.Result))
+	for _, d := range resp.Result {
+		item := V4DeviceListItem{
+			DevEUI:            d.DevEui,
+			LastSeenAt:        v4Time(d.LastSeenAt),
+			Name:              d.Name,
+			Description:       d.Description,
+			DeviceProfileID:   d.DeviceProfileId,
+			DeviceProfileName: d.DeviceProfileName,
+		}
+		if d.DeviceStatusMargin != 256 {
+			item.DeviceStatus = &V4DeviceStatus{
+				Margin:              d.DeviceStatusMargin,
+				ExternalPowerSource: d.DeviceStatusExternalPowerSource,
+			}
+			if !d.DeviceStatusBatteryLevelUnavailable {
+				item.DeviceStatus.BatteryLevel = d.DeviceStatusBatteryLevel
+			}
+		}
+		items = append(items, item)
+	}
+
+	httpWriteJSON(w, V4ListResponse{TotalCount: resp.TotalCount, Result: items})
+}
+
+// GetDeviceKeys returns the v4 device keys.
+func (a *V4CompatAPI) GetDeviceKeys(w http.ResponseWriter, r *http.Request) {
+	resp, err := a.devices.GetKeys(httpContext(r), &pb.GetDeviceKeysRequest{DevEui: mux.Vars(r)["dev_eui"]})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	keys := resp.GetDeviceKeys()
+	httpWriteJSON(w, struct {
+		DeviceKeys V4DeviceKeys `json:"deviceKeys"`
+	}{
+		DeviceKeys: V4DeviceKeys{
+			NwkKey:    keys.GetNwkKey(),
+			AppKey:    keys.GetAppKey(),
+			GenAppKey: keys.GetGenAppKey(),
+		},
+	})
+}
+
+// CreateDeviceKeys creates the given v4 device keys.
+func (a *V4CompatAPI) CreateDeviceKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := v4DecodeDeviceKeys(r)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if _, err := a.devices.CreateKeys(httpContext(r), &pb.CreateDeviceKeysRequest{DeviceKeys: keys}); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// UpdateDeviceKeys updates the given v4 device keys.
+func (a *V4CompatAPI) UpdateDeviceKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := v4DecodeDeviceKeys(r)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	if _, err := a.devices.UpdateKeys(httpContext(r), &pb.UpdateDeviceKeysRequest{DeviceKeys: keys}); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// DeleteDeviceKeys deletes the v4 device keys.
+func (a *V4CompatAPI) DeleteDeviceKeys(w http.ResponseWriter, r *http.Request) {
+	if _, err := a.devices.DeleteKeys(httpContext(r), &pb.DeleteDeviceKeysRequest{DevEui: mux.Vars(r)["dev_eui"]}); err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, struct{}{})
+}
+
+// GetDeviceProfile returns the v4 device-profile.
+func (a *V4CompatAPI) GetDeviceProfile(w http.ResponseWriter, r *http.Request) {
+	resp, err := a.deviceProfiles.Get(httpContext(r), &pb.GetDeviceProfileRequest{Id: mux.Vars(r)["id"]})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	dp := resp.GetDeviceProfile()
+	httpWriteJSON(w, struct {
+		DeviceProfile V4DeviceProfile `json:"deviceProfile"`
+		CreatedAt     *time.Time      `json:"createdAt"`
+		UpdatedAt     *time.Time      `json:"updatedAt"`
+	}{
+		DeviceProfile: V4DeviceProfile{
+			ID:                 dp.Id,
+			TenantID:           strconv.FormatInt(dp.OrganizationId, 10),
+			Name:               dp.Name,
+			Region:             dp.RfRegion,
+			MacVersion:         dp.MacVersion,
+			RegParamsRevision:  dp.RegParamsRevision,
+			PayloadCodecScript: dp.PayloadDecoderScript,
+			SupportsOTAA:       dp.SupportsJoin,
+			SupportsClassB:     dp.SupportsClassB,
+			SupportsClassC:     dp.SupportsClassC,
+			ClassBTimeout:      dp.ClassBTimeout,
+			ClassCTimeout:      dp.ClassCTimeout,
+			Tags:               dp.Tags,
+		},
+		CreatedAt: v4Time(resp.CreatedAt),
+		UpdatedAt: v4Time(resp.UpdatedAt),
+	})
+}
+
+// ListDeviceProfiles returns the v4 device-profiles of the given tenant.
+func (a *V4CompatAPI) ListDeviceProfiles(w http.ResponseWriter, r *http.Request) {
+	orgID, err := v4ParseID("tenantId", r.URL.Query().Get("tenantId"))
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	limit, offset, err := httpLimitOffset(r, v4CompatListMaxLimit)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp, err := a.deviceProfiles.List(httpContext(r), &pb.ListDeviceProfileRequest{
+		Limit:          int64(limit),
+		Offset:         int64(offset),
+		OrganizationId: orgID,
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	items := make([]V4DeviceProfileListItem, 0, len(resp.Result))
+	for _, dp := range resp.Result {
+		items = append(items, V4DeviceProfileListItem{
+			ID:        dp.Id,
+			CreatedAt: v4Time(dp.CreatedAt),
+			UpdatedAt: v4Time(dp.UpdatedAt),
+			Name:      dp.Name,
+		})
+	}
+
+	httpWriteJSON(w, V4ListResponse{TotalCount: resp.TotalCount, Result: items})
+}
+
+// GetGateway returns the v4 gateway.
+func (a *V4CompatAPI) GetGateway(w http.ResponseWriter, r *http.Request) {
+	resp, err := a.gateways.Get(httpContext(r), &pb.GetGatewayRequest{Id: mux.Vars(r)["id"]})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	gw := resp.GetGateway()
+	httpWriteJSON(w, struct {
+		Gateway    V4Gateway  `json:"gateway"`
+		CreatedAt  *time.Time `json:"createdAt"`
+		UpdatedAt  *time.Time `json:"updatedAt"`
+		LastSeenAt *time.Time `json:"lastSeenAt"`
+	}{
+		Gateway: V4Gateway{
+			GatewayID:   gw.Id,
+			Name:        gw.Name,
+			Description: gw.Description,
+			Location: V4Location{
+				Latitude:  gw.GetLocation().GetLatitude(),
+				Longitude: gw.GetLocation().GetLongitude(),
+				Altitude:  gw.GetLocation().GetAltitude(),
+			},
+			TenantID: strconv.FormatInt(gw.OrganizationId, 10),
+			Tags:     gw.Tags,
+			Metadata: gw.Metadata,
+		},
+		CreatedAt:  v4Time(resp.CreatedAt),
+		UpdatedAt:  v4Time(resp.UpdatedAt),
+		LastSeenAt: v4Time(resp.LastSeenAt),
+	})
+}
+
+// ListGateways returns the v4 gateways, optionally filtered by tenant.
+func (a *V4CompatAPI) ListGateways(w http.ResponseWriter, r *http.Request) {
+	var orgID int64
+	if v := r.URL.Query().Get("tenantId"); v != "" {
+		var err error
+		orgID, err = v4ParseID("tenantId", v)
+		if err != nil {
+			httpWriteError(w, err)
+			return
+		}
+	}
+
+	limit, offset, err := httpLimitOffset(r, v4CompatListMaxLimit)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	resp, err := a.gateways.List(httpContext(r), &pb.ListGatewayRequest{
+		Limit:          int32(limit),
+		Offset:         int32(offset),
+		OrganizationId: orgID,
+		Search:         r.URL.Query().Get("search"),
+	})
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	items := make([]V4GatewayListItem, 0, len(resp.Result))
+	for _, gw := range resp.Result {
+		items = append(items, V4GatewayListItem{
+			TenantID:    strconv.FormatInt(gw.OrganizationId, 10),
+			GatewayID:   gw.Id,
+			Name:        gw.Name,
+			Description: gw.Description,
+			Location: V4Location{
+				Latitude:  gw.GetLocation().GetLatitude(),
+				Longitude: gw.GetLocation().GetLongitude(),
+				Altitude:  gw.GetLocation().GetAltitude(),
+			},
+			CreatedAt:  v4Time(gw.CreatedAt),
+			UpdatedAt:  v4Time(gw.UpdatedAt),
+			LastSeenAt: v4Time(gw.LastSeenAt),
+		})
+	}
+
+	httpWriteJSON(w, V4ListResponse{TotalCount: resp.TotalCount, Result: items})
+}
+
+// v4DecodeDeviceKeys decodes the v4 device keys request. The DevEUI is taken
+// from the route.
+func v4DecodeDeviceKeys(r *http.Request) (*pb.DeviceKeys, error) {
+	var req struct {
+		DeviceKeys V4DeviceKeys `json:"deviceKeys"`
+	}
+	if err := httpDecodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+
+	return &pb.DeviceKeys{
+		DevEui:    mux.Vars(r)["dev_eui"],
+		NwkKey:    req.DeviceKeys.NwkKey,
+		AppKey:    req.DeviceKeys.AppKey,
+		GenAppKey: req.DeviceKeys.GenAppKey,
+	}, nil
+}
+
+// v4ParseID parses the given (v4) string id into a numeric id.
+func v4ParseID(name, id string) (int64, error) {
+	v, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return 0, grpc.Errorf(codes.InvalidArgument, "%s: %s", name, err)
+	}
+	return v, nil
+}
+
+// v4TenantName returns the display name of the organization, or its name
+// when no display name is set.
+func v4TenantName(name, displayName string) string {
+	if displayName != "" {
+		return displayName
+	}
+	return name
+}
+
+// v4Time converts the given timestamp, it returns nil when the timestamp is
+// not set.
+func v4Time(ts *timestamp.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+
+	t, err := ptypes.Timestamp(ts)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
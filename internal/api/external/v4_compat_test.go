@@ -0,0 +1,176 @@
+package external
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+func (ts *APITestSuite) TestV4Compat() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewV4CompatAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name:            "test-org",
+		DisplayName:     "Test organization",
+		CanHaveGateways: true,
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	tenantID := strconv.FormatInt(org.ID, 10)
+
+	do := func(t *testing.T, method, path string, body interface{}, expectedCode int, resp interface{}) {
+		assert := require.New(t)
+
+		var b bytes.Buffer
+		if body != nil {
+			assert.NoError(json.NewEncoder(&b).Encode(body))
+		}
+
+		req := httptest.NewRequest(method, path, &b)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		assert.Equal(expectedCode, rec.Code, rec.Body.String())
+
+		if resp != nil {
+			assert.NoError(json.NewDecoder(rec.Body).Decode(resp))
+		}
+	}
+
+	ts.T().Run("Get tenant", func(t *testing.T) {
+		assert := require.New(t)
+
+		var resp struct {
+			Tenant V4Tenant `json:"tenant"`
+		}
+		do(t, "GET", "/v4/api/tenants/"+tenantID, nil, http.StatusOK, &resp)
+		assert.Equal(V4Tenant{
+			ID:              tenantID,
+			Name:            "Test organization",
+			CanHaveGateways: true,
+		}, resp.Tenant)
+	})
+
+	ts.T().Run("Applications", func(t *testing.T) {
+		assert := require.New(t)
+
+		var createResp struct {
+			ID string `json:"id"`
+		}
+		do(t, "POST", "/v4/api/applications", map[string]interface{}{
+			"application": map[string]interface{}{
+				"name":        "test-app",
+				"description": "test application",
+				"tenantId":    tenantID,
+			},
+		}, http.StatusOK, &createResp)
+		assert.NotEqual("", createResp.ID)
+
+		path := "/v4/api/applications/" + createResp.ID
+
+		t.Run("Get", func(t *testing.T) {
+			assert := require.New(t)
+
+			var resp struct {
+				Application V4Application `json:"application"`
+			}
+			do(t, "GET", path, nil, http.StatusOK, &resp)
+			assert.Equal("test-app", resp.Application.Name)
+			assert.Equal("test application", resp.Application.Description)
+			assert.Equal(tenantID, resp.Application.TenantID)
+			assert.Equal(spID.String(), resp.Application.ServiceProfileID)
+		})
+
+		t.Run("Update", func(t *testing.T) {
+			assert := require.New(t)
+
+			do(t, "PUT", path, map[string]interface{}{
+				"application": map[string]interface{}{
+					"name":        "test-app-updated",
+					"description": "updated application",
+				},
+			}, http.StatusOK, nil)
+
+			var resp struct {
+				Application V4Application `json:"application"`
+			}
+			do(t, "GET", path, nil, http.StatusOK, &resp)
+			assert.Equal("test-app-updated", resp.Application.Name)
+			assert.Equal("updated application", resp.Application.Description)
+		})
+
+		t.Run("List", func(t *testing.T) {
+			assert := require.New(t)
+
+			var resp struct {
+				TotalCount int64                   `json:"totalCount"`
+				Result     []V4ApplicationListItem `json:"result"`
+			}
+			do(t, "GET", "/v4/api/applications?tenantId="+tenantID+"&limit=10", nil, http.StatusOK, &resp)
+			assert.EqualValues(1, resp.TotalCount)
+			assert.Equal([]V4ApplicationListItem{
+				{ID: createResp.ID, Name: "test-app-updated", Description: "updated application"},
+			}, resp.Result)
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			do(t, "DELETE", path, nil, http.StatusOK, nil)
+			do(t, "GET", path, nil, http.StatusNotFound, nil)
+		})
+	})
+
+	ts.T().Run("Create application without unique service-profile", func(t *testing.T) {
+		assert := require.New(t)
+
+		sp2 := storage.ServiceProfile{
+			Name:            "test-sp-2",
+			OrganizationID:  org.ID,
+			NetworkServerID: n.ID,
+		}
+		assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp2))
+
+		do(t, "POST", "/v4/api/applications", map[string]interface{}{
+			"application": map[string]interface{}{
+				"name":     "test-app-2",
+				"tenantId": tenantID,
+			},
+		}, http.StatusBadRequest, nil)
+	})
+
+	ts.T().Run("Invalid tenant id", func(t *testing.T) {
+		do(t, "GET", "/v4/api/applications?tenantId=abc", nil, http.StatusBadRequest, nil)
+	})
+}
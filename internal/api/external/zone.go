@@ -0,0 +1,279 @@
+package external
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/ibrahimozekici/app-server2/internal/api/external/auth"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+const (
+	// zoneDefaultTagKey defines the device tag defining the zones, when not
+	// set by the request.
+	zoneDefaultTagKey = "zone"
+
+	// zoneDefaultWindow defines the default window of the aggregated
+	// measurements.
+	zoneDefaultWindow = 24 * time.Hour
+
+	// zoneMaxWindow defines the max. window of the aggregated measurements.
+	zoneMaxWindow = 31 * 24 * time.Hour
+
+	// zoneMaxMeasurements defines the max. number of measurements which can
+	// be selected by a single request.
+	zoneMaxMeasurements = 32
+)
+
+// ZoneMeasurement defines the aggregated values of a measurement of the
+// devices within a zone, over the requested window. Latest holds the most
+// recent value of any device within the zone.
+type ZoneMeasurement struct {
+	Name     string    `json:"name"`
+	Count    int       `json:"count"`
+	Min      float64   `json:"min"`
+	Max      float64   `json:"max"`
+	Avg      float64   `json:"avg"`
+	Latest   float64   `json:"latest"`
+	LatestAt time.Time `json:"latestAt"`
+}
+
+// ZoneAlarms defines the summary of the open (unacknowledged and unresolved)
+// automation alarms of the devices within a zone.
+type ZoneAlarms struct {
+	Open         int        `json:"open"`
+	Escalated    int        `json:"escalated"`
+	OldestOpenAt *time.Time `json:"oldestOpenAt,omitempty"`
+}
+
+// ZoneOverview defines the overview of a zone.
+type ZoneOverview struct {
+	Zone         string            `json:"zone"`
+	Devices      int               `json:"devices"`
+	LastSeenAt   *time.Time        `json:"lastSeenAt,omitempty"`
+	Measurements []ZoneMeasurement `json:"measurements"`
+	Alarms       ZoneAlarms        `json:"alarms"`
+}
+
+// ListZonesResponse defines the list zones response.
+type ListZonesResponse struct {
+	TagKey string         `json:"tagKey"`
+	Start  time.Time      `json:"start"`
+	End    time.Time      `json:"end"`
+	Result []ZoneOverview `json:"result"`
+}
+
+// GetZoneResponse defines the get zone response.
+type GetZoneResponse struct {
+	TagKey string       `json:"tagKey"`
+	Start  time.Time    `json:"start"`
+	End    time.Time    `json:"end"`
+	Zone   ZoneOverview `json:"zone"`
+}
+
+// ZoneAPI exports the zone dashboard related functions. A zone is the group
+// of devices of an application sharing the same value for the zone device
+// tag (by default "zone", this can be overridden using the tag query
+// parameter).
+type ZoneAPI struct {
+	validator auth.Validator
+}
+
+// NewZoneAPI creates a new ZoneAPI.
+func NewZoneAPI(validator auth.Validator) *ZoneAPI {
+	return &ZoneAPI{
+		validator: validator,
+	}
+}
+
+// Register registers the API endpoints.
+func (a *ZoneAPI) Register(r *mux.Router) {
+	r.HandleFunc("/api/applications/{application_id}/zones", a.List).Methods("GET")
+	r.HandleFunc("/api/applications/{application_id}/zones/{zone}", a.Get).Methods("GET")
+}
+
+// List returns the overview of all zones of the application. The
+// measurement query parameter (which can be repeated) selects the
+// measurements to aggregate, by default all measurements are aggregated.
+// The window query parameter (e.g. 1h) sets the aggregation window, by
+// default 24h.
+func (a *ZoneAPI) List(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	filters, app, err := a.zoneFilters(ctx, r)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	zones, err := getZoneOverviews(ctx, app, filters)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	httpWriteJSON(w, ListZonesResponse{
+		TagKey: filters.TagKey,
+		Start:  filters.Start,
+		End:    filters.End,
+		Result: zones,
+	})
+}
+
+// Get returns the overview of a single zone, see List for the query
+// parameters.
+func (a *ZoneAPI) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := httpContext(r)
+
+	filters, app, err := a.zoneFilters(ctx, r)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	filters.Zone = mux.Vars(r)["zone"]
+	if filters.Zone == "" {
+		httpWriteError(w, grpc.Errorf(codes.InvalidArgument, "zone must not be empty"))
+		return
+	}
+
+	zones, err := getZoneOverviews(ctx, app, filters)
+	if err != nil {
+		httpWriteError(w, err)
+		return
+	}
+
+	// a zone only exists as long as it has devices
+	if len(zones) == 0 {
+		httpWriteError(w, storage.ErrDoesNotExist)
+		return
+	}
+
+	httpWriteJSON(w, GetZoneResponse{
+		TagKey: filters.TagKey,
+		Start:  filters.Start,
+		End:    filters.End,
+		Zone:   zones[0],
+	})
+}
+
+// zoneFilters validates the access to the application and returns the
+// application and the filters of the request.
+func (a *ZoneAPI) zoneFilters(ctx context.Context, r *http.Request) (storage.ZoneFilters, storage.Application, error) {
+	var filters storage.ZoneFilters
+
+	applicationID, err := httpInt64Var(r, "application_id")
+	if err != nil {
+		return filters, storage.Application{}, err
+	}
+
+	if err := httpValidate(ctx, a.validator,
+		auth.ValidateApplicationAccess(applicationID, auth.Read),
+	); err != nil {
+		return filters, storage.Application{}, err
+	}
+
+	q := r.URL.Query()
+
+	filters.ApplicationID = applicationID
+	filters.TagKey = zoneDefaultTagKey
+	if v := q.Get("tag"); v != "" {
+		filters.TagKey = v
+	}
+
+	window := zoneDefaultWindow
+	if v := q.Get("window"); v != "" {
+		window, err = time.ParseDuration(v)
+		if err != nil || window <= 0 || window > zoneMaxWindow {
+			return filters, storage.Application{}, grpc.Errorf(codes.InvalidArgument, "window must be a duration between 0s and %s", zoneMaxWindow)
+		}
+	}
+	filters.End = time.Now()
+	filters.Start = filters.End.Add(-window)
+
+	filters.Measurements = q["measurement"]
+	if len(filters.Measurements) > zoneMaxMeasurements {
+		return filters, storage.Application{}, grpc.Errorf(codes.InvalidArgument, "max. number of measurements is %d", zoneMaxMeasurements)
+	}
+
+	app, err := storage.GetApplication(ctx, storage.DB(), applicationID)
+	if err != nil {
+		return filters, app, err
+	}
+
+	return filters, app, nil
+}
+
+// getZoneOverviews returns the overviews of the zones matching the given
+// filters.
+func getZoneOverviews(ctx context.Context, app storage.Application, filters storage.ZoneFilters) ([]ZoneOverview, error) {
+	zones, err := storage.GetZones(ctx, storage.DB(), filters)
+	if err != nil {
+		return nil, err
+	}
+
+	var measurements []storage.ZoneMeasurement
+	err = storage.ForOrganization(ctx, storage.DB(), app.OrganizationID, func(db sqlx.Ext) error {
+		var err error
+		measurements, err = storage.GetZoneMeasurements(ctx, db, filters)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	alarms, err := storage.GetZoneAlarmSummaries(ctx, storage.DB(), filters)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]ZoneOverview, 0, len(zones))
+	index := make(map[string]int, len(zones))
+	for i, z := range zones {
+		index[z.Zone] = i
+		out = append(out, ZoneOverview{
+			Zone:         z.Zone,
+			Devices:      z.Devices,
+			LastSeenAt:   z.LastSeenAt,
+			Measurements: []ZoneMeasurement{},
+		})
+	}
+
+	for _, m := range measurements {
+		i, ok := index[m.Zone]
+		if !ok {
+			continue
+		}
+
+		out[i].Measurements = append(out[i].Measurements, ZoneMeasurement{
+			Name:     m.Name,
+			Count:    m.Count,
+			Min:      m.Min,
+			Max:      m.Max,
+			Avg:      m.Avg,
+			Latest:   m.Latest,
+			LatestAt: m.LatestAt,
+		})
+	}
+
+	for _, s := range alarms {
+		i, ok := index[s.Zone]
+		if !ok {
+			continue
+		}
+
+		out[i].Alarms = ZoneAlarms{
+			Open:         s.OpenAlarms,
+			Escalated:    s.EscalatedAlarms,
+			OldestOpenAt: s.OldestOpenAt,
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,139 @@
+package external
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq/hstore"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *APITestSuite) TestZone() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	r := mux.NewRouter()
+	NewZoneAPI(validator).Register(r)
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := storage.Application{
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+		Name:             "test-app",
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	dp := storage.DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(storage.CreateDeviceProfile(context.Background(), storage.DB(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	d := storage.Device{
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "greenhouse-1",
+		Tags: hstore.Hstore{
+			Map: map[string]sql.NullString{
+				"zone": {String: "greenhouse", Valid: true},
+			},
+		},
+	}
+	assert.NoError(storage.CreateDevice(context.Background(), storage.DB(), &d))
+
+	now := time.Now()
+	assert.NoError(storage.CreateDeviceMetrics(context.Background(), storage.DB(), []storage.DeviceMetric{
+		{DevEUI: d.DevEUI, ApplicationID: app.ID, Time: now.Add(-2 * time.Hour), Name: "temperature", Value: 18},
+		{DevEUI: d.DevEUI, ApplicationID: app.ID, Time: now.Add(-time.Minute), Name: "temperature", Value: 21},
+		{DevEUI: d.DevEUI, ApplicationID: app.ID, Time: now.Add(-time.Minute), Name: "humidity", Value: 80},
+	}))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httpTestRequest(r, "GET", path, nil)
+		return rec
+	}
+
+	ts.T().Run("List", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := get(fmt.Sprintf("/api/applications/%d/zones?window=1h&measurement=temperature", app.ID))
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp ListZonesResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal("zone", resp.TagKey)
+		assert.Len(resp.Result, 1)
+		assert.Equal("greenhouse", resp.Result[0].Zone)
+		assert.Equal(1, resp.Result[0].Devices)
+		assert.Len(resp.Result[0].Measurements, 1)
+		assert.Equal("temperature", resp.Result[0].Measurements[0].Name)
+		assert.Equal(1, resp.Result[0].Measurements[0].Count)
+		assert.Equal(float64(21), resp.Result[0].Measurements[0].Latest)
+		assert.Equal(0, resp.Result[0].Alarms.Open)
+	})
+
+	ts.T().Run("Get", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := get(fmt.Sprintf("/api/applications/%d/zones/greenhouse", app.ID))
+		assert.Equal(http.StatusOK, rec.Code)
+
+		var resp GetZoneResponse
+		assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal("greenhouse", resp.Zone.Zone)
+		assert.Len(resp.Zone.Measurements, 2)
+		assert.Equal(2, resp.Zone.Measurements[1].Count)
+
+		rec = get(fmt.Sprintf("/api/applications/%d/zones/field", app.ID))
+		assert.Equal(http.StatusNotFound, rec.Code)
+	})
+
+	ts.T().Run("Invalid", func(t *testing.T) {
+		assert := require.New(t)
+
+		rec := get(fmt.Sprintf("/api/applications/%d/zones?window=1y", app.ID))
+		assert.Equal(http.StatusBadRequest, rec.Code)
+
+		rec = get(fmt.Sprintf("/api/applications/%d/zones?window=768h", app.ID))
+		assert.Equal(http.StatusBadRequest, rec.Code)
+	})
+}
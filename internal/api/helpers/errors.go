@@ -33,6 +33,77 @@ var errToCode = map[error]codes.Code{
 	storage.ErrFUOTADeploymentInvalidName:      codes.InvalidArgument,
 	storage.ErrFUOTADeploymentNullPayload:      codes.InvalidArgument,
 	storage.ErrAPIKeyInvalidName:               codes.InvalidArgument,
+	storage.ErrApplicationRetentionInvalidDays: codes.InvalidArgument,
+	storage.ErrTransactionConflict:             codes.Aborted,
+	storage.ErrObjectModified:                  codes.Aborted,
+	storage.ErrDeviceInvalidPayloadCodec:       codes.InvalidArgument,
+	storage.ErrCodecInvalidName:                codes.InvalidArgument,
+	storage.ErrDeviceDownlinkRateLimit:         codes.ResourceExhausted,
+	storage.ErrApplicationDownlinkRateLimit:    codes.ResourceExhausted,
+	storage.ErrDownlinkDuplicate:               codes.AlreadyExists,
+	storage.ErrDownlinkTemplateInvalidName:     codes.InvalidArgument,
+	storage.ErrDownlinkTemplateInvalidFPort:    codes.InvalidArgument,
+	storage.ErrDownlinkTemplateInvalidObject:   codes.InvalidArgument,
+	storage.ErrFirmwareCampaignInvalidName:     codes.InvalidArgument,
+	storage.ErrFirmwareCampaignInvalidFPort:    codes.InvalidArgument,
+	storage.ErrFirmwareCampaignNullPayload:     codes.InvalidArgument,
+	storage.ErrFirmwareCampaignInvalidChunk:    codes.InvalidArgument,
+	storage.ErrFirmwareCampaignInvalidDataRate: codes.InvalidArgument,
+	storage.ErrFirmwareCampaignInvalidDuty:     codes.InvalidArgument,
+	storage.ErrFirmwareCampaignNoDevices:       codes.InvalidArgument,
+	storage.ErrDownlinkRuleInvalidName:         codes.InvalidArgument,
+	storage.ErrDownlinkRuleInvalidUplinkFPort:  codes.InvalidArgument,
+	storage.ErrDownlinkRuleInvalidConditions:   codes.InvalidArgument,
+	storage.ErrDownlinkRuleInvalidFPort:        codes.InvalidArgument,
+	storage.ErrDownlinkRuleInvalidObject:       codes.InvalidArgument,
+	storage.ErrDownlinkRuleInvalidCooldown:     codes.InvalidArgument,
+	storage.ErrRelayInvalidCADPeriodicity:      codes.InvalidArgument,
+	storage.ErrRelayInvalidDefaultChannel:      codes.InvalidArgument,
+	storage.ErrRelayInvalidSecondChannel:       codes.InvalidArgument,
+	storage.ErrRelayInvalidDevice:              codes.InvalidArgument,
+	storage.ErrRelayMaxDevices:                 codes.FailedPrecondition,
+	storage.ErrRelayInvalidFilterIndex:         codes.InvalidArgument,
+	storage.ErrRelayInvalidFilterAction:        codes.InvalidArgument,
+	storage.ErrRelayInvalidFilterEUI:           codes.InvalidArgument,
+	storage.ErrRelayEndDeviceInvalidMode:       codes.InvalidArgument,
+	storage.ErrRelayEndDeviceInvalidSmartLevel: codes.InvalidArgument,
+	storage.ErrRelayEndDeviceInvalidBackoff:    codes.InvalidArgument,
+	storage.ErrAutomationInvalidName:           codes.InvalidArgument,
+	storage.ErrAutomationInvalidTrigger:        codes.InvalidArgument,
+	storage.ErrAutomationInvalidActions:        codes.InvalidArgument,
+	storage.ErrAutomationInvalidCooldown:       codes.InvalidArgument,
+	storage.ErrAutomationInvalidEscalation:     codes.InvalidArgument,
+	storage.ErrAutomationInvalidDampening:      codes.InvalidArgument,
+	storage.ErrAutomationInvalidSeverity:       codes.InvalidArgument,
+	storage.ErrAutomationAlarmAcknowledged:     codes.FailedPrecondition,
+	storage.ErrMaintenanceInvalidName:          codes.InvalidArgument,
+	storage.ErrMaintenanceInvalidScope:         codes.InvalidArgument,
+	storage.ErrMaintenanceInvalidDuration:      codes.InvalidArgument,
+	storage.ErrMaintenanceInvalidRecurrence:    codes.InvalidArgument,
+	storage.ErrThresholdProfileInvalidName:     codes.InvalidArgument,
+	storage.ErrThresholdProfileInvalidLimits:   codes.InvalidArgument,
+	storage.ErrThresholdProfileInUse:           codes.FailedPrecondition,
+	storage.ErrNotificationRouteInvalidName:    codes.InvalidArgument,
+	storage.ErrNotificationRouteInvalidLevel:   codes.InvalidArgument,
+	storage.ErrNotificationRouteInvalidChannel: codes.InvalidArgument,
+	storage.ErrReportInvalidName:               codes.InvalidArgument,
+	storage.ErrReportInvalidSchedule:           codes.InvalidArgument,
+	storage.ErrReportInvalidFormat:             codes.InvalidArgument,
+	storage.ErrReportInvalidZone:               codes.InvalidArgument,
+	storage.ErrReportInvalidMeasurements:       codes.InvalidArgument,
+	storage.ErrDeviceGroupInvalidName:          codes.InvalidArgument,
+	storage.ErrDeviceGroupInvalidType:          codes.InvalidArgument,
+	storage.ErrDeviceGroupInvalidTags:          codes.InvalidArgument,
+	storage.ErrDeviceGroupNotManual:            codes.FailedPrecondition,
+	storage.ErrGeofenceInvalidName:             codes.InvalidArgument,
+	storage.ErrGeofenceInvalidZone:             codes.InvalidArgument,
+	storage.ErrGeofenceInvalidBoundary:         codes.InvalidArgument,
+	storage.ErrIrrigationInvalidName:           codes.InvalidArgument,
+	storage.ErrIrrigationInvalidSchedule:       codes.InvalidArgument,
+	storage.ErrIrrigationInvalidDownlink:       codes.InvalidArgument,
+	storage.ErrIrrigationInvalidDuration:       codes.InvalidArgument,
+	storage.ErrIrrigationInvalidDevices:        codes.InvalidArgument,
+	storage.ErrIrrigationNoStopDownlink:        codes.FailedPrecondition,
 	http.ErrInvalidHeaderName:                  codes.InvalidArgument,
 	influxdb.ErrInvalidPrecision:               codes.InvalidArgument,
 }
@@ -0,0 +1,72 @@
+package helpers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+const (
+	// versionHeader contains the version of the returned object. Through
+	// the REST interface, this is returned as Grpc-Metadata-Etag header.
+	versionHeader = "etag"
+
+	// ifMatchHeader contains the version of the object the client expects
+	// to update. Through the REST interface, the If-Match header is
+	// forwarded with the grpc-gateway metadata prefix.
+	ifMatchHeader = "if-match"
+)
+
+// FormatVersion returns the version string for the given updated at
+// timestamp of an object.
+func FormatVersion(updatedAt time.Time) string {
+	return updatedAt.UTC().Format(time.RFC3339Nano)
+}
+
+// SetVersionHeader returns the version of the object to the client. It is
+// a no-op when the context is not the context of a gRPC call.
+func SetVersionHeader(ctx context.Context, updatedAt time.Time) {
+	_ = grpc.SetHeader(ctx, metadata.Pairs(versionHeader, FormatVersion(updatedAt)))
+}
+
+// CheckVersion validates the version given by the client (if any) against
+// the updated at timestamp of the stored object. It returns an Aborted
+// error when the object has been modified since the client retrieved it.
+// When no version is given, no check is performed.
+func CheckVersion(ctx context.Context, updatedAt time.Time) error {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	val := md.Get(ifMatchHeader)
+	if len(val) == 0 {
+		val = md.Get(runtime.MetadataPrefix + ifMatchHeader)
+	}
+	if len(val) == 0 {
+		return nil
+	}
+
+	version := strings.Trim(strings.TrimPrefix(val[0], "W/"), `"`)
+	if version == "" || version == "*" {
+		return nil
+	}
+
+	expected, err := time.Parse(time.RFC3339Nano, version)
+	if err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "invalid %s version: %s", ifMatchHeader, err)
+	}
+
+	if !expected.Equal(updatedAt) {
+		return ErrToRPCError(storage.ErrObjectModified)
+	}
+
+	return nil
+}
@@ -0,0 +1,207 @@
+package js
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+
+	pb //"github.com/ibrahimozekici/lora-api/go/v3/as/integration"
+	"github.com/ibrahimozekici/app-server2/internal/integration"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+	//"github.com/brocaar/lorawan/backend"
+)
+
+const (
+	joinIntegrationName   = "join"
+	joinBlockedEventType  = "blocked"
+	joinBlockedResultDesc = "too many failed join-requests, retry later"
+)
+
+var (
+	joinFailureCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "api_joinserver_join_failure_count",
+		Help: "The number of failed join- and rejoin-requests (per message-type and result code)",
+	}, []string{"message_type", "result_code"})
+
+	joinBlockCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "api_joinserver_join_block_count",
+		Help: "The number of times join-requests were blocked after too many failures (per scope)",
+	}, []string{"scope"})
+
+	joinRefusedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "api_joinserver_join_refused_count",
+		Help: "The number of join- and rejoin-requests refused because of a block (per scope)",
+	}, []string{"scope"})
+)
+
+// joinFailureResultCodes defines the result codes which are counted as
+// failed join-request. Other result codes (e.g. caused by a database error)
+// do not indicate abuse.
+var joinFailureResultCodes = map[backend.ResultCode]struct{}{
+	backend.MICFailed:        {},
+	backend.JoinReqFailed:    {},
+	backend.UnknownDevEUI:    {},
+	backend.FrameReplayed:    {},
+	backend.MalformedRequest: {},
+}
+
+// bruteForceHandler counts the failed join- and rejoin-requests per DevEUI,
+// JoinEUI and sender and refuses the requests of which the DevEUI, JoinEUI or
+// sender is blocked after too many failures.
+type bruteForceHandler struct {
+	handler http.Handler
+}
+
+func (h *bruteForceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.WithError(err).Error("api/js: read request body error")
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	req, ok := parseSessionRequest(b)
+	if !ok {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	keys := []storage.JoinFailureKey{
+		{Scope: storage.JoinFailureScopeDevice, ID: req.devEUI.String()},
+		{Scope: storage.JoinFailureScopeSender, ID: req.basePL.SenderID},
+	}
+	if req.joinEUI != (lorawan.EUI64{}) {
+		keys = append(keys, storage.JoinFailureKey{Scope: storage.JoinFailureScopeJoinEUI, ID: req.joinEUI.String()})
+	}
+
+	block, blocked, err := storage.GetJoinBlock(r.Context(), keys)
+	if err != nil {
+		log.WithError(err).WithField("dev_eui", req.devEUI).Error("api/js: get join block error")
+	}
+	if blocked {
+		joinRefusedCount.With(prometheus.Labels{"scope": string(block.Scope)}).Inc()
+
+		log.WithFields(log.Fields{
+			"dev_eui": req.devEUI,
+			"scope":   block.Scope,
+			"id":      block.ID,
+			"until":   block.Until,
+		}).Warning("api/js: join-request refused, too many failed join-requests")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(backend.BasePayloadResult{
+			BasePayload: answerBasePayload(req.basePL),
+			Result: backend.Result{
+				ResultCode:  backend.Other,
+				Description: joinBlockedResultDesc,
+			},
+		})
+		return
+	}
+
+	bw := bodyWriter{ResponseWriter: w}
+	h.handler.ServeHTTP(&bw, r)
+
+	var ans backend.BasePayloadResult
+	if err := json.Unmarshal(bw.body.Bytes(), &ans); err != nil {
+		return
+	}
+	if _, ok := joinFailureResultCodes[ans.Result.ResultCode]; !ok {
+		return
+	}
+
+	joinFailureCount.With(prometheus.Labels{
+		"message_type": string(req.basePL.MessageType),
+		"result_code":  string(ans.Result.ResultCode),
+	}).Inc()
+
+	blocks, err := storage.RegisterJoinFailure(r.Context(), keys)
+	if err != nil {
+		log.WithError(err).WithField("dev_eui", req.devEUI).Error("api/js: register join failure error")
+		return
+	}
+
+	for _, block := range blocks {
+		joinBlockCount.With(prometheus.Labels{"scope": string(block.Scope)}).Inc()
+
+		if block.Scope != storage.JoinFailureScopeDevice {
+			continue
+		}
+
+		if err := sendJoinBlockedEvent(r.Context(), req.devEUI, block); err != nil {
+			log.WithError(err).WithField("dev_eui", req.devEUI).Error("api/js: send join blocked event error")
+		}
+	}
+}
+
+// sendJoinBlockedEvent sends an alarm event to the integrations of the
+// application of the device, when the device exists.
+func sendJoinBlockedEvent(ctx context.Context, devEUI lorawan.EUI64, block storage.JoinBlock) error {
+	d, err := storage.GetDevice(ctx, storage.DB(), devEUI, false, true)
+	if err != nil {
+		if errors.Cause(err) == storage.ErrDoesNotExist {
+			return nil
+		}
+		return errors.Wrap(err, "get device error")
+	}
+
+	app, err := storage.GetApplication(ctx, storage.DB(), d.ApplicationID)
+	if err != nil {
+		return errors.Wrap(err, "get application error")
+	}
+
+	b, err := json.Marshal(struct {
+		Scope        storage.JoinFailureScope `json:"scope"`
+		BlockedUntil time.Time                `json:"blockedUntil"`
+	}{
+		Scope:        block.Scope,
+		BlockedUntil: block.Until,
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshal json error")
+	}
+
+	pl := pb.IntegrationEvent{
+		ApplicationId:   uint64(app.ID),
+		ApplicationName: app.Name,
+		DeviceName:      d.Name,
+		DevEui:          d.DevEUI[:],
+		Tags:            make(map[string]string),
+		IntegrationName: joinIntegrationName,
+		EventType:       joinBlockedEventType,
+		ObjectJson:      string(b),
+	}
+
+	for k, v := range d.Tags.Map {
+		if v.Valid {
+			pl.Tags[k] = v.String
+		}
+	}
+
+	vars := make(map[string]string)
+	for k, v := range d.Variables.Map {
+		if v.Valid {
+			vars[k] = v.String
+		}
+	}
+
+	if err := integration.ForApplicationID(app.ID).HandleIntegrationEvent(ctx, vars, pl); err != nil {
+		return errors.Wrap(err, "send integration event error")
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package js
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	//"github.com/brocaar/lorawan/backend"
+)
+
+// client defines a network-server which is allowed to use the join-server
+// API, identified by its NetID and client-certificate.
+type client struct {
+	netID string
+	names []string
+	pool  *x509.CertPool
+}
+
+// clientAuthMiddleware validates that the client-certificate of the request
+// belongs to the network-server identified by the SenderID of the request.
+type clientAuthMiddleware struct {
+	handler http.Handler
+	clients []client
+}
+
+func (h *clientAuthMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var b []byte
+	if r.Body != nil {
+		var err error
+		b, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.WithError(err).Error("api/js: read request body error")
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	}
+
+	var basePL backend.BasePayload
+	if err := json.Unmarshal(b, &basePL); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := authorizeClient(h.clients, r.TLS, basePL.SenderID); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"sender_id":   basePL.SenderID,
+			"remote_addr": r.RemoteAddr,
+		}).Warning("api/js: client rejected")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(backend.BasePayloadResult{
+			BasePayload: answerBasePayload(basePL),
+			Result: backend.Result{
+				ResultCode:  backend.UnknownSender,
+				Description: err.Error(),
+			},
+		})
+		return
+	}
+
+	h.handler.ServeHTTP(w, r)
+}
+
+// getClients returns the configured network-server clients. Each client
+// has its own CA pool, the certificate of a client is only validated against
+// the CA certificate of that client.
+func getClients(conf config.Config) ([]client, error) {
+	var out []client
+
+	for _, c := range conf.JoinServer.Client {
+		if c.NetID == "" || c.CACert == "" {
+			return nil, errors.New("net_id and ca_cert must be set for each client")
+		}
+
+		b, err := ioutil.ReadFile(c.CACert)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read ca certificate error, net_id: %s", c.NetID)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("append ca certificate error, net_id: %s", c.NetID)
+		}
+
+		names := c.Names
+		if len(names) == 0 {
+			names = []string{c.NetID}
+		}
+
+		out = append(out, client{
+			netID: c.NetID,
+			names: names,
+			pool:  pool,
+		})
+	}
+
+	return out, nil
+}
+
+// authorizeClient validates that the client-certificate of the given
+// connection is signed by the CA of the client with the given NetID and
+// that its common name, DNS or URI SAN matches one of the names of the
+// client.
+func authorizeClient(clients []client, state *tls.ConnectionState, senderID string) error {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return errors.New("client-certificate required")
+	}
+
+	leaf := state.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	for _, c := range clients {
+		if !strings.EqualFold(c.netID, senderID) {
+			continue
+		}
+
+		_, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         c.pool,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		if err != nil {
+			continue
+		}
+
+		if certificateMatchesNames(leaf, c.names) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("client-certificate is not authorized for sender_id %s", senderID)
+}
+
+func certificateMatchesNames(cert *x509.Certificate, names []string) bool {
+	var certNames []string
+	certNames = append(certNames, cert.Subject.CommonName)
+	certNames = append(certNames, cert.DNSNames...)
+	for _, u := range cert.URIs {
+		certNames = append(certNames, u.String())
+	}
+
+	for _, name := range names {
+		for _, certName := range certNames {
+			if certName != "" && strings.EqualFold(name, certName) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
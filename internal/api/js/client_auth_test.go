@@ -0,0 +1,136 @@
+package js
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCertificate(t *testing.T, cn string, dnsNames []string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	assert := require.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+		tmpl.ExtKeyUsage = nil
+		parent = &tmpl
+		parentKey = key
+	}
+
+	b, err := x509.CreateCertificate(rand.Reader, &tmpl, parent, &key.PublicKey, parentKey)
+	assert.NoError(err)
+
+	cert, err := x509.ParseCertificate(b)
+	assert.NoError(err)
+
+	return cert, key
+}
+
+func TestAuthorizeClient(t *testing.T) {
+	caA, caAKey := newTestCertificate(t, "ca-a", nil, nil, nil)
+	caB, caBKey := newTestCertificate(t, "ca-b", nil, nil, nil)
+
+	poolA := x509.NewCertPool()
+	poolA.AddCert(caA)
+	poolB := x509.NewCertPool()
+	poolB.AddCert(caB)
+
+	clients := []client{
+		{
+			netID: "010203",
+			names: []string{"010203"},
+			pool:  poolA,
+		},
+		{
+			netID: "040506",
+			names: []string{"ns.example.com"},
+			pool:  poolB,
+		},
+	}
+
+	certA, _ := newTestCertificate(t, "010203", nil, caA, caAKey)
+	certB, _ := newTestCertificate(t, "ns-b", []string{"ns.example.com"}, caB, caBKey)
+	certBWrongName, _ := newTestCertificate(t, "ns-b", []string{"other.example.com"}, caB, caBKey)
+	certAAsB, _ := newTestCertificate(t, "ns-b", []string{"ns.example.com"}, caA, caAKey)
+
+	tests := []struct {
+		Name          string
+		State         *tls.ConnectionState
+		SenderID      string
+		ExpectedError string
+	}{
+		{
+			Name:          "no client-certificate",
+			SenderID:      "010203",
+			ExpectedError: "client-certificate required",
+		},
+		{
+			Name:     "common name matches netid",
+			State:    &tls.ConnectionState{PeerCertificates: []*x509.Certificate{certA}},
+			SenderID: "010203",
+		},
+		{
+			Name:     "dns san matches",
+			State:    &tls.ConnectionState{PeerCertificates: []*x509.Certificate{certB}},
+			SenderID: "040506",
+		},
+		{
+			Name:          "valid certificate for other netid",
+			State:         &tls.ConnectionState{PeerCertificates: []*x509.Certificate{certA}},
+			SenderID:      "040506",
+			ExpectedError: "client-certificate is not authorized for sender_id 040506",
+		},
+		{
+			Name:          "unknown netid",
+			State:         &tls.ConnectionState{PeerCertificates: []*x509.Certificate{certA}},
+			SenderID:      "070809",
+			ExpectedError: "client-certificate is not authorized for sender_id 070809",
+		},
+		{
+			Name:          "dns san does not match",
+			State:         &tls.ConnectionState{PeerCertificates: []*x509.Certificate{certBWrongName}},
+			SenderID:      "040506",
+			ExpectedError: "client-certificate is not authorized for sender_id 040506",
+		},
+		{
+			Name:          "matching name signed by ca of other client",
+			State:         &tls.ConnectionState{PeerCertificates: []*x509.Certificate{certAAsB}},
+			SenderID:      "040506",
+			ExpectedError: "client-certificate is not authorized for sender_id 040506",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			err := authorizeClient(clients, tst.State, tst.SenderID)
+			if tst.ExpectedError != "" {
+				assert.EqualError(err, tst.ExpectedError)
+			} else {
+				assert.NoError(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package js
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	//"github.com/brocaar/lorawan"
+	//"github.com/brocaar/lorawan/backend"
+)
+
+const (
+	defaultForwardTimeout = 5 * time.Second
+
+	// forwardMaxIdleConns defines the max. number of idle (keep-alive)
+	// connections per forward target. The http.Transport default of 2 causes
+	// new connections (and TLS handshakes) to be created for most requests
+	// when multiple join-requests are forwarded concurrently.
+	forwardMaxIdleConns = 100
+	forwardIdleTimeout  = 90 * time.Second
+
+	// forwardInitialBackoff and forwardMaxBackoff define the backoff after
+	// which a forward target is retried after it could not be reached.
+	forwardInitialBackoff = time.Second
+	forwardMaxBackoff     = 30 * time.Second
+)
+
+// forwardTarget defines an external join-server handling the join-requests
+// for the given JoinEUI range.
+type forwardTarget struct {
+	joinEUIFrom lorawan.EUI64
+	joinEUITo   lorawan.EUI64
+	server      string
+	client      *http.Client
+	backoff     *forwardBackoff
+}
+
+// forwardBackoff keeps track of the failed requests to a forward target.
+// After a failed request, the requests to the target fail immediately until
+// the backoff has passed, instead of each waiting for the request timeout.
+// The backoff doubles on each consecutive failure.
+type forwardBackoff struct {
+	sync.Mutex
+	failures int
+	retryAt  time.Time
+}
+
+// available returns nil when a request can be made to the target.
+func (b *forwardBackoff) available() error {
+	b.Lock()
+	defer b.Unlock()
+
+	if d := time.Until(b.retryAt); d > 0 {
+		return errors.Errorf("join-server unavailable, retry in %s", d.Round(time.Millisecond))
+	}
+	return nil
+}
+
+// record records the outcome of a request to the target.
+func (b *forwardBackoff) record(err error) {
+	b.Lock()
+	defer b.Unlock()
+
+	if err == nil {
+		b.failures = 0
+		b.retryAt = time.Time{}
+		return
+	}
+
+	backoff := forwardInitialBackoff
+	for i := 0; i < b.failures && backoff < forwardMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > forwardMaxBackoff {
+		backoff = forwardMaxBackoff
+	}
+	b.failures++
+	b.retryAt = time.Now().Add(backoff)
+}
+
+// forwardHandler forwards the join-requests of which the JoinEUI is within
+// the range of a forward target to the external join-server and relays its
+// answer. All other requests are handled by the wrapped handler.
+type forwardHandler struct {
+	handler http.Handler
+	targets []forwardTarget
+}
+
+func (h *forwardHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.WithError(err).Error("api/js: read request body error")
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	var jr backend.JoinReqPayload
+	if err := json.Unmarshal(b, &jr); err != nil || jr.MessageType != backend.JoinReq {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	var phy lorawan.PHYPayload
+	if err := phy.UnmarshalBinary(jr.PHYPayload[:]); err != nil {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+	jrPL, ok := phy.MACPayload.(*lorawan.JoinRequestPayload)
+	if !ok {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	target, ok := h.getTarget(jrPL.JoinEUI)
+	if !ok {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui":  jrPL.DevEUI,
+		"join_eui": jrPL.JoinEUI,
+		"server":   target.server,
+	}).Info("api/js: forwarding join-request to external join-server")
+
+	if err := target.forward(w, b); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"dev_eui":  jrPL.DevEUI,
+			"join_eui": jrPL.JoinEUI,
+			"server":   target.server,
+		}).Error("api/js: forward join-request error")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.BasePayloadResult{
+			BasePayload: answerBasePayload(jr.BasePayload),
+			Result: backend.Result{
+				ResultCode:  backend.JoinReqFailed,
+				Description: err.Error(),
+			},
+		})
+	}
+}
+
+func (h *forwardHandler) getTarget(joinEUI lorawan.EUI64) (forwardTarget, bool) {
+	for _, t := range h.targets {
+		if bytes.Compare(joinEUI[:], t.joinEUIFrom[:]) >= 0 && bytes.Compare(joinEUI[:], t.joinEUITo[:]) <= 0 {
+			return t, true
+		}
+	}
+
+	return forwardTarget{}, false
+}
+
+func (t forwardTarget) forward(w http.ResponseWriter, b []byte) error {
+	if err := t.backoff.available(); err != nil {
+		return err
+	}
+
+	resp, err := t.client.Post(t.server, "application/json", bytes.NewReader(b))
+	t.backoff.record(err)
+	if err != nil {
+		return errors.Wrap(err, "http request error")
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		log.WithError(err).Error("api/js: relay join-answer error")
+	}
+
+	return nil
+}
+
+// getForwardTargets returns the configured forward targets.
+func getForwardTargets(conf config.Config) ([]forwardTarget, error) {
+	var out []forwardTarget
+
+	for _, f := range conf.JoinServer.Forward {
+		var t forwardTarget
+
+		if err := t.joinEUIFrom.UnmarshalText([]byte(f.JoinEUIFrom)); err != nil {
+			return nil, errors.Wrap(err, "decode join_eui_from error")
+		}
+		if err := t.joinEUITo.UnmarshalText([]byte(f.JoinEUITo)); err != nil {
+			return nil, errors.Wrap(err, "decode join_eui_to error")
+		}
+		if bytes.Compare(t.joinEUIFrom[:], t.joinEUITo[:]) > 0 {
+			return nil, errors.New("join_eui_from must be less than or equal to join_eui_to")
+		}
+		if f.Server == "" {
+			return nil, errors.New("server must be set")
+		}
+
+		tlsConfig, err := forwardTLSConfig(f.CACert, f.TLSCert, f.TLSKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "tls config error, server: %s", f.Server)
+		}
+
+		timeout := f.Timeout
+		if timeout == 0 {
+			timeout = defaultForwardTimeout
+		}
+
+		t.server = f.Server
+		t.client = &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				TLSClientConfig:     tlsConfig,
+				MaxIdleConns:        forwardMaxIdleConns,
+				MaxIdleConnsPerHost: forwardMaxIdleConns,
+				IdleConnTimeout:     forwardIdleTimeout,
+				TLSHandshakeTimeout: timeout,
+			},
+		}
+		t.backoff = &forwardBackoff{}
+
+		out = append(out, t)
+	}
+
+	return out, nil
+}
+
+func forwardTLSConfig(caCert, tlsCert, tlsKey string) (*tls.Config, error) {
+	var tlsConfig tls.Config
+
+	if caCert != "" {
+		b, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, errors.Wrap(err, "read ca certificate error")
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(b) {
+			return nil, errors.New("append ca certificate error")
+		}
+	}
+
+	if tlsCert != "" || tlsKey != "" {
+		cert, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "load x509 keypair error")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &tlsConfig, nil
+}
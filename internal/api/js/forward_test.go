@@ -0,0 +1,167 @@
+package js
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	//"github.com/brocaar/lorawan"
+	//"github.com/brocaar/lorawan/backend"
+)
+
+func TestForwardHandler(t *testing.T) {
+	assert := require.New(t)
+
+	var forwarded [][]byte
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		forwarded = append(forwarded, b)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"MessageType":"JoinAns","Result":{"ResultCode":"Success"}}`))
+	}))
+	defer external.Close()
+
+	var local int
+	localHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		local++
+		w.WriteHeader(http.StatusOK)
+	})
+
+	var conf config.Config
+	conf.JoinServer.Forward = []config.JoinServerForward{
+		{
+			JoinEUIFrom: "0102030400000000",
+			JoinEUITo:   "01020304ffffffff",
+			Server:      external.URL,
+		},
+	}
+
+	targets, err := getForwardTargets(conf)
+	assert.NoError(err)
+
+	server := httptest.NewServer(&forwardHandler{
+		handler: localHandler,
+		targets: targets,
+	})
+	defer server.Close()
+
+	joinReq := func(joinEUI lorawan.EUI64) []byte {
+		phy := lorawan.PHYPayload{
+			MHDR: lorawan.MHDR{
+				MType: lorawan.JoinRequest,
+				Major: lorawan.LoRaWANR1,
+			},
+			MACPayload: &lorawan.JoinRequestPayload{
+				DevEUI:   lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 1},
+				JoinEUI:  joinEUI,
+				DevNonce: 1,
+			},
+		}
+		phyB, err := phy.MarshalBinary()
+		assert.NoError(err)
+
+		b, err := json.Marshal(backend.JoinReqPayload{
+			BasePayload: backend.BasePayload{
+				ProtocolVersion: backend.ProtocolVersion1_0,
+				SenderID:        "010203",
+				ReceiverID:      joinEUI.String(),
+				TransactionID:   1234,
+				MessageType:     backend.JoinReq,
+			},
+			MACVersion: "1.0.3",
+			PHYPayload: backend.HEXBytes(phyB),
+			DevEUI:     lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 1},
+		})
+		assert.NoError(err)
+		return b
+	}
+
+	t.Run("JoinEUI in range", func(t *testing.T) {
+		assert := require.New(t)
+
+		b := joinReq(lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8})
+		resp, err := http.Post(server.URL, "application/json", bytes.NewReader(b))
+		assert.NoError(err)
+		assert.Equal(http.StatusOK, resp.StatusCode)
+
+		var ans backend.JoinAnsPayload
+		assert.NoError(json.NewDecoder(resp.Body).Decode(&ans))
+		assert.Equal(backend.Success, ans.Result.ResultCode)
+
+		assert.Len(forwarded, 1)
+		assert.Equal(b, forwarded[0])
+		assert.Equal(0, local)
+	})
+
+	t.Run("JoinEUI out of range", func(t *testing.T) {
+		assert := require.New(t)
+
+		b := joinReq(lorawan.EUI64{1, 2, 3, 5, 0, 0, 0, 0})
+		resp, err := http.Post(server.URL, "application/json", bytes.NewReader(b))
+		assert.NoError(err)
+		assert.Equal(http.StatusOK, resp.StatusCode)
+
+		assert.Len(forwarded, 1)
+		assert.Equal(1, local)
+	})
+
+	t.Run("External join-server unavailable", func(t *testing.T) {
+		assert := require.New(t)
+
+		external.Close()
+
+		b := joinReq(lorawan.EUI64{1, 2, 3, 4, 0, 0, 0, 0})
+		resp, err := http.Post(server.URL, "application/json", bytes.NewReader(b))
+		assert.NoError(err)
+
+		var ans backend.JoinAnsPayload
+		assert.NoError(json.NewDecoder(resp.Body).Decode(&ans))
+		assert.Equal(backend.JoinReqFailed, ans.Result.ResultCode)
+		assert.Equal(backend.JoinAns, ans.MessageType)
+
+		t.Run("Backoff", func(t *testing.T) {
+			assert := require.New(t)
+
+			resp, err := http.Post(server.URL, "application/json", bytes.NewReader(b))
+			assert.NoError(err)
+
+			var ans backend.JoinAnsPayload
+			assert.NoError(json.NewDecoder(resp.Body).Decode(&ans))
+			assert.Equal(backend.JoinReqFailed, ans.Result.ResultCode)
+			assert.Contains(ans.Result.Description, "join-server unavailable")
+		})
+	})
+}
+
+func TestForwardBackoff(t *testing.T) {
+	assert := require.New(t)
+
+	var b forwardBackoff
+	assert.NoError(b.available())
+
+	b.record(errors.New("connection refused"))
+	assert.Error(b.available())
+	assert.Equal(1, b.failures)
+	assert.WithinDuration(time.Now().Add(forwardInitialBackoff), b.retryAt, 100*time.Millisecond)
+
+	b.record(errors.New("connection refused"))
+	assert.WithinDuration(time.Now().Add(2*forwardInitialBackoff), b.retryAt, 100*time.Millisecond)
+
+	for i := 0; i < 100; i++ {
+		b.record(errors.New("connection refused"))
+	}
+	assert.WithinDuration(time.Now().Add(forwardMaxBackoff), b.retryAt, 100*time.Millisecond)
+
+	b.record(nil)
+	assert.NoError(b.available())
+	assert.Equal(0, b.failures)
+}
@@ -0,0 +1,273 @@
+package js
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/hsm"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+	//"github.com/brocaar/lorawan/backend"
+)
+
+var errHSMMICFailed = errors.New("invalid mic")
+
+// hsmHandler handles the join-requests of devices of which the keys are
+// stored in the HSM. All other requests are forwarded to the wrapped
+// join-server handler.
+type hsmHandler struct {
+	handler http.Handler
+	conf    config.Config
+}
+
+func (h *hsmHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ks := hsm.GetKeyStore()
+	if ks == nil || r.Body == nil {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.WithError(err).Error("api/js: read request body error")
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	var basePL backend.BasePayload
+	if err := json.Unmarshal(b, &basePL); err != nil {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	var devEUI lorawan.EUI64
+	var macVersion string
+	var jr backend.JoinReqPayload
+
+	switch basePL.MessageType {
+	case backend.JoinReq:
+		if err := json.Unmarshal(b, &jr); err != nil {
+			h.handler.ServeHTTP(w, r)
+			return
+		}
+		devEUI = jr.DevEUI
+		macVersion = jr.MACVersion
+	case backend.RejoinReq:
+		var rjr backend.RejoinReqPayload
+		if err := json.Unmarshal(b, &rjr); err != nil {
+			h.handler.ServeHTTP(w, r)
+			return
+		}
+		devEUI = rjr.DevEUI
+		macVersion = rjr.MACVersion
+	default:
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	dk, err := storage.GetDeviceKeys(r.Context(), storage.DB(), devEUI)
+	if err != nil || !dk.HSM {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	var ans interface{}
+	if basePL.MessageType == backend.JoinReq && strings.HasPrefix(macVersion, "1.0") {
+		ans = h.handleJoinReq(r.Context(), ks, jr, dk)
+	} else {
+		log.WithFields(log.Fields{
+			"dev_eui":      devEUI,
+			"message_type": basePL.MessageType,
+			"mac_version":  macVersion,
+		}).Error("api/js: message-type or mac-version not supported for hsm stored keys")
+
+		ans = backend.BasePayloadResult{
+			BasePayload: answerBasePayload(basePL),
+			Result: backend.Result{
+				ResultCode:  backend.Other,
+				Description: "message-type or mac-version not supported for hsm stored keys",
+			},
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ans); err != nil {
+		log.WithError(err).Error("api/js: encode answer error")
+	}
+}
+
+// handleJoinReq handles the LoRaWAN 1.0.x join-request. The MIC validation,
+// the session-key derivation and the join-accept MIC and encryption are
+// performed by the HSM using the NwkKey.
+func (h *hsmHandler) handleJoinReq(ctx context.Context, ks hsm.KeyStore, jr backend.JoinReqPayload, dk storage.DeviceKeys) backend.JoinAnsPayload {
+	ans := backend.JoinAnsPayload{
+		BasePayloadResult: backend.BasePayloadResult{
+			BasePayload: answerBasePayload(jr.BasePayload),
+			Result: backend.Result{
+				ResultCode: backend.Success,
+			},
+		},
+	}
+
+	if err := h.joinReq(ctx, ks, jr, dk, &ans); err != nil {
+		log.WithError(err).WithField("dev_eui", jr.DevEUI).Error("api/js: handle join-request using hsm error")
+
+		ans.Result.ResultCode = backend.Other
+		if errors.Cause(err) == errHSMMICFailed {
+			ans.Result.ResultCode = backend.MICFailed
+		}
+		ans.Result.Description = err.Error()
+	}
+
+	return ans
+}
+
+func (h *hsmHandler) joinReq(ctx context.Context, ks hsm.KeyStore, jr backend.JoinReqPayload, dk storage.DeviceKeys, ans *backend.JoinAnsPayload) error {
+	label := hsm.KeyLabel(dk.DevEUI, hsm.NwkKey)
+
+	var phy lorawan.PHYPayload
+	if err := phy.UnmarshalBinary(jr.PHYPayload[:]); err != nil {
+		return errors.Wrap(err, "unmarshal phypayload error")
+	}
+	jrPL, ok := phy.MACPayload.(*lorawan.JoinRequestPayload)
+	if !ok {
+		return errors.New("expected *lorawan.JoinRequestPayload")
+	}
+
+	// validate mic
+	if len(jr.PHYPayload) < 4 {
+		return errors.New("phypayload too short")
+	}
+	mic, err := ks.CMAC(ctx, label, jr.PHYPayload[:len(jr.PHYPayload)-4])
+	if err != nil {
+		return errors.Wrap(err, "calculate mic error")
+	}
+	if subtle.ConstantTimeCompare(mic[:4], phy.MIC[:]) != 1 {
+		return errHSMMICFailed
+	}
+
+	// join-nonce
+	if dk.JoinNonce == (1<<24)-1 {
+		return errors.New("join-nonce overflow")
+	}
+	dk.JoinNonce++
+	if err := storage.UpdateDeviceKeys(ctx, storage.DB(), &dk); err != nil {
+		return errors.Wrap(err, "update device-keys error")
+	}
+	if err := storage.SetDeviceJoinRequestTime(ctx, dk.DevEUI, time.Now()); err != nil {
+		log.WithError(err).WithField("dev_eui", dk.DevEUI).Error("api/js: set join-request time error")
+	}
+
+	var netID lorawan.NetID
+	if err := netID.UnmarshalText([]byte(jr.SenderID)); err != nil {
+		return errors.Wrap(err, "unmarshal netid error")
+	}
+
+	var cFList *lorawan.CFList
+	if len(jr.CFList[:]) != 0 {
+		cFList = &lorawan.CFList{}
+		if err := cFList.UnmarshalBinary(jr.CFList[:]); err != nil {
+			return errors.Wrap(err, "unmarshal cflist error")
+		}
+	}
+
+	// join-accept
+	jaPHY := lorawan.PHYPayload{
+		MHDR: lorawan.MHDR{
+			MType: lorawan.JoinAccept,
+			Major: lorawan.LoRaWANR1,
+		},
+		MACPayload: &lorawan.JoinAcceptPayload{
+			JoinNonce:  lorawan.JoinNonce(dk.JoinNonce),
+			HomeNetID:  netID,
+			DevAddr:    jr.DevAddr,
+			DLSettings: jr.DLSettings,
+			RXDelay:    uint8(jr.RxDelay),
+			CFList:     cFList,
+		},
+	}
+	b, err := jaPHY.MarshalBinary()
+	if err != nil {
+		return errors.Wrap(err, "marshal join-accept error")
+	}
+
+	mic, err = ks.CMAC(ctx, label, b[:len(b)-4])
+	if err != nil {
+		return errors.Wrap(err, "calculate join-accept mic error")
+	}
+	copy(b[len(b)-4:], mic[:4])
+
+	// the join-accept is encrypted using the aes decrypt operation
+	enc, err := ks.Decrypt(ctx, label, b[1:])
+	if err != nil {
+		return errors.Wrap(err, "encrypt join-accept error")
+	}
+	ans.PHYPayload = backend.HEXBytes(append(b[:1], enc...))
+
+	// session-keys
+	nwkSKey, err := deriveHSMSessionKey(ctx, ks, label, 0x01, dk.JoinNonce, netID, jrPL.DevNonce)
+	if err != nil {
+		return errors.Wrap(err, "derive nwk_s_key error")
+	}
+	appSKey, err := deriveHSMSessionKey(ctx, ks, label, 0x02, dk.JoinNonce, netID, jrPL.DevNonce)
+	if err != nil {
+		return errors.Wrap(err, "derive app_s_key error")
+	}
+
+	netIDKEK, err := getKEKByLabel(jr.SenderID)
+	if err != nil {
+		return errors.Wrap(err, "get kek error")
+	}
+	ans.NwkSKey, err = backend.NewKeyEnvelope(jr.SenderID, netIDKEK, nwkSKey)
+	if err != nil {
+		return errors.Wrap(err, "new key envelope error")
+	}
+
+	asKEKLabel := h.conf.JoinServer.KEK.ASKEKLabel
+	asKEK, err := getKEKByLabel(asKEKLabel)
+	if err != nil {
+		return errors.Wrap(err, "get kek error")
+	}
+	ans.AppSKey, err = backend.NewKeyEnvelope(asKEKLabel, asKEK, appSKey)
+	if err != nil {
+		return errors.Wrap(err, "new key envelope error")
+	}
+
+	return nil
+}
+
+// deriveHSMSessionKey derives the LoRaWAN 1.0.x session-key of the given
+// type: aes128_encrypt(NwkKey, type | JoinNonce | NetID | DevNonce | pad16).
+func deriveHSMSessionKey(ctx context.Context, ks hsm.KeyStore, label string, typ byte, joinNonce int, netID lorawan.NetID, devNonce lorawan.DevNonce) (lorawan.AES128Key, error) {
+	var key lorawan.AES128Key
+	b := make([]byte, 16)
+
+	b[0] = typ
+
+	// all fields are little-endian
+	jnB := make([]byte, 4)
+	binary.LittleEndian.PutUint32(jnB, uint32(joinNonce))
+	copy(b[1:4], jnB[:3])
+	for i := range netID {
+		b[4+i] = netID[len(netID)-1-i]
+	}
+	binary.LittleEndian.PutUint16(b[7:9], uint16(devNonce))
+
+	out, err := ks.Encrypt(ctx, label, b)
+	if err != nil {
+		return key, err
+	}
+	copy(key[:], out)
+
+	return key, nil
+}
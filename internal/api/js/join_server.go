@@ -4,16 +4,18 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/hex"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/kek"
 	"github.com/ibrahimozekici/app-server2/internal/storage"
 	//"github.com/brocaar/lorawan"
+	//"github.com/brocaar/lorawan/backend"
 	//"github.com/brocaar/lorawan/backend/joinserver"
 )
 
@@ -22,6 +24,8 @@ var (
 	caCert  string
 	tlsCert string
 	tlsKey  string
+
+	server *http.Server
 )
 
 // Setup configures the package.
@@ -43,16 +47,47 @@ func Setup(conf config.Config) error {
 		return errors.Wrap(err, "get join-server handler error")
 	}
 
-	server := http.Server{
+	server = &http.Server{
 		Handler:   handler,
 		Addr:      bind,
 		TLSConfig: &tls.Config{},
 	}
 
+	if len(conf.JoinServer.Client) != 0 {
+		if tlsCert == "" || tlsKey == "" {
+			return errors.New("tls_cert and tls_key must be set when clients are configured")
+		}
+
+		caCertPool := x509.NewCertPool()
+		for _, c := range conf.JoinServer.Client {
+			b, err := ioutil.ReadFile(c.CACert)
+			if err != nil {
+				return errors.Wrap(err, "read client ca certificate error")
+			}
+			if !caCertPool.AppendCertsFromPEM(b) {
+				return errors.New("append client ca certificate error")
+			}
+		}
+
+		server.TLSConfig.ClientCAs = caCertPool
+		server.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+
+		log.WithField("clients", len(conf.JoinServer.Client)).Info("api/js: join-server is configured with per network-server client-certificate authentication")
+
+		go func() {
+			if err := server.ListenAndServeTLS(tlsCert, tlsKey); err != http.ErrServerClosed {
+				log.WithError(err).Fatal("api/js: join-server api error")
+			}
+		}()
+
+		return nil
+	}
+
 	if caCert == "" && tlsCert == "" && tlsKey == "" {
 		go func() {
-			err := server.ListenAndServe()
-			log.WithError(err).Fatal("join-server api error")
+			if err := server.ListenAndServe(); err != http.ErrServerClosed {
+				log.WithError(err).Fatal("join-server api error")
+			}
 		}()
 		return nil
 	}
@@ -77,12 +112,25 @@ func Setup(conf config.Config) error {
 	}
 
 	go func() {
-		err := server.ListenAndServeTLS(tlsCert, tlsKey)
-		log.WithError(err).Fatal("api/js: join-server api error")
+		if err := server.ListenAndServeTLS(tlsCert, tlsKey); err != http.ErrServerClosed {
+			log.WithError(err).Fatal("api/js: join-server api error")
+		}
 	}()
 
 	return nil
 }
+
+// Shutdown stops the join-server api. It stops accepting new connections and
+// blocks until the pending requests have been handled or the context is
+// cancelled.
+func Shutdown(ctx context.Context) error {
+	if server == nil {
+		return nil
+	}
+
+	return server.Shutdown(ctx)
+}
+
 func getHandler(conf config.Config) (http.Handler, error) {
 	jsConf := joinserver.HandlerConfig{
 		Logger: log.StandardLogger(),
@@ -96,30 +144,28 @@ func getHandler(conf config.Config) (http.Handler, error) {
 				return joinserver.DeviceKeys{}, errors.New("join-nonce overflow")
 			}
 			dk.JoinNonce++
-			if err := storage.UpdateDeviceKeys(context.TODO(), storage.DB(), &dk); err != nil {
-				return joinserver.DeviceKeys{}, errors.Wrap(err, "update device-keys error")
-			}
 
-			return joinserver.DeviceKeys{
+			// the keys are copied before the update, as these are replaced
+			// by null keys when imported into the hsm
+			keys := joinserver.DeviceKeys{
 				DevEUI:    dk.DevEUI,
 				NwkKey:    dk.NwkKey,
 				AppKey:    dk.AppKey,
 				JoinNonce: dk.JoinNonce,
-			}, nil
-		},
-		GetKEKByLabelFunc: func(label string) ([]byte, error) {
-			for _, kek := range conf.JoinServer.KEK.Set {
-				if label == kek.Label {
-					b, err := hex.DecodeString(kek.KEK)
-					if err != nil {
-						return nil, errors.Wrap(err, "decode hex encoded kek error")
-					}
-
-					return b, nil
-				}
 			}
 
-			return nil, nil
+			if err := storage.UpdateDeviceKeys(context.TODO(), storage.DB(), &dk); err != nil {
+				return joinserver.DeviceKeys{}, errors.Wrap(err, "update device-keys error")
+			}
+
+			if err := storage.SetDeviceJoinRequestTime(context.TODO(), devEUI, time.Now()); err != nil {
+				log.WithError(err).WithField("dev_eui", devEUI).Error("api/js: set join-request time error")
+			}
+
+			return keys, nil
+		},
+		GetKEKByLabelFunc: func(label string) ([]byte, error) {
+			return getKEKByLabel(label)
 		},
 		GetASKEKLabelByDevEUIFunc: func(devEUI lorawan.EUI64) (string, error) {
 			return conf.JoinServer.KEK.ASKEKLabel, nil
@@ -154,8 +200,78 @@ func getHandler(conf config.Config) (http.Handler, error) {
 		return nil, errors.Wrap(err, "new join-server handler error")
 	}
 
+	var h http.Handler = &hsmHandler{
+		handler: handler,
+		conf:    conf,
+	}
+
+	h = &keyRotationHandler{
+		handler: h,
+	}
+
+	h = &sessionHandler{
+		handler: h,
+	}
+
+	if bf := conf.JoinServer.BruteForceProtection; bf.Device.MaxFailures != 0 || bf.JoinEUI.MaxFailures != 0 || bf.Sender.MaxFailures != 0 {
+		h = &bruteForceHandler{
+			handler: h,
+		}
+	}
+
+	if len(conf.JoinServer.Forward) != 0 {
+		targets, err := getForwardTargets(conf)
+		if err != nil {
+			return nil, errors.Wrap(err, "get forward targets error")
+		}
+
+		h = &forwardHandler{
+			handler: h,
+			targets: targets,
+		}
+	}
+
+	if len(conf.JoinServer.Client) != 0 {
+		clients, err := getClients(conf)
+		if err != nil {
+			return nil, errors.Wrap(err, "get clients error")
+		}
+
+		h = &clientAuthMiddleware{
+			handler: h,
+			clients: clients,
+		}
+	}
+
 	return &prometheusMiddleware{
-		handler:         handler,
+		handler:         h,
 		timingHistogram: conf.Metrics.Prometheus.APITimingHistogram,
 	}, nil
 }
+
+func getKEKByLabel(label string) ([]byte, error) {
+	b, _ := kek.GetKEK(label)
+	return b, nil
+}
+
+// answerBasePayload returns the base payload of the answer to the given
+// request.
+func answerBasePayload(req backend.BasePayload) backend.BasePayload {
+	var mt backend.MessageType
+	switch req.MessageType {
+	case backend.JoinReq:
+		mt = backend.JoinAns
+	case backend.RejoinReq:
+		mt = backend.RejoinAns
+	case backend.HomeNSReq:
+		mt = backend.HomeNSAns
+	}
+
+	return backend.BasePayload{
+		ProtocolVersion: req.ProtocolVersion,
+		SenderID:        req.ReceiverID,
+		ReceiverID:      req.SenderID,
+		TransactionID:   req.TransactionID,
+		MessageType:     mt,
+	}
+}
@@ -18,6 +18,8 @@ import (
 	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
 	nsmock "github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
 	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/hsm"
+	hsmmock "github.com/ibrahimozekici/app-server2/internal/hsm/mock"
 	"github.com/ibrahimozekici/app-server2/internal/integration"
 	"github.com/ibrahimozekici/app-server2/internal/integration/mock"
 	"github.com/ibrahimozekici/app-server2/internal/storage"
@@ -448,6 +450,101 @@ func TestJoinServerAPI(t *testing.T) {
 		})
 	})
 
+	t.Run("JoinReq LW10 HSM", func(t *testing.T) {
+		assert := require.New(t)
+
+		ks := hsmmock.New()
+		hsm.SetKeyStore(ks)
+		defer hsm.SetKeyStore(nil)
+
+		hsmDK := dk
+		assert.NoError(storage.UpdateDeviceKeys(context.Background(), storage.DB(), &hsmDK))
+		assert.Len(ks.Keys, 2)
+
+		hsmDK, err := storage.GetDeviceKeys(context.Background(), storage.DB(), d.DevEUI)
+		assert.NoError(err)
+		assert.True(hsmDK.HSM)
+		assert.Equal(lorawan.AES128Key{}, hsmDK.NwkKey)
+		assert.Equal(lorawan.AES128Key{}, hsmDK.AppKey)
+
+		jrPHY := lorawan.PHYPayload{
+			MHDR: lorawan.MHDR{
+				MType: lorawan.JoinRequest,
+				Major: lorawan.LoRaWANR1,
+			},
+			MACPayload: &lorawan.JoinRequestPayload{
+				DevEUI:   d.DevEUI,
+				JoinEUI:  lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1},
+				DevNonce: 258,
+			},
+		}
+		assert.NoError(jrPHY.SetUplinkJoinMIC(dk.NwkKey))
+		jrPHYBytes, err := jrPHY.MarshalBinary()
+		assert.NoError(err)
+
+		joinReqPayload := backend.JoinReqPayload{
+			BasePayload: backend.BasePayload{
+				ProtocolVersion: backend.ProtocolVersion1_0,
+				SenderID:        "010203",
+				ReceiverID:      "0807060504030201",
+				TransactionID:   1234,
+				MessageType:     backend.JoinReq,
+			},
+			MACVersion: "1.0.2",
+			PHYPayload: backend.HEXBytes(jrPHYBytes),
+			DevEUI:     d.DevEUI,
+			DevAddr:    lorawan.DevAddr{1, 2, 3, 4},
+			DLSettings: lorawan.DLSettings{
+				RX2DataRate: 5,
+				RX1DROffset: 1,
+			},
+			RxDelay: 1,
+			CFList:  backend.HEXBytes(cFListB),
+		}
+		joinReqPayloadJSON, err := json.Marshal(joinReqPayload)
+		assert.NoError(err)
+
+		req, err := http.NewRequest("POST", server.URL, bytes.NewReader(joinReqPayloadJSON))
+		assert.NoError(err)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(err)
+		assert.Equal(resp.StatusCode, http.StatusOK)
+
+		var joinAnsPayload backend.JoinAnsPayload
+		assert.NoError(json.NewDecoder(resp.Body).Decode(&joinAnsPayload))
+		assert.Equal(backend.JoinAnsPayload{
+			BasePayloadResult: backend.BasePayloadResult{
+				BasePayload: backend.BasePayload{
+					ProtocolVersion: backend.ProtocolVersion1_0,
+					SenderID:        "0807060504030201",
+					ReceiverID:      "010203",
+					TransactionID:   1234,
+					MessageType:     backend.JoinAns,
+				},
+				Result: backend.Result{
+					ResultCode: backend.Success,
+				},
+			},
+			PHYPayload: backend.HEXBytes([]byte{32, 38, 244, 178, 71, 240, 165, 215, 228, 106, 114, 14, 97, 200, 188, 203, 197, 23, 159, 69, 102, 225, 133, 237, 104, 137, 88, 155, 177, 169, 198, 140, 192}),
+			NwkSKey: &backend.KeyEnvelope{
+				AESKey: []byte{223, 83, 195, 95, 48, 52, 204, 206, 208, 255, 53, 76, 112, 222, 4, 223},
+			},
+			AppSKey: &backend.KeyEnvelope{
+				AESKey: []byte{146, 123, 156, 145, 17, 131, 207, 254, 76, 178, 255, 75, 117, 84, 95, 109},
+			},
+		}, joinAnsPayload)
+
+		hsmDK, err = storage.GetDeviceKeys(context.Background(), storage.DB(), d.DevEUI)
+		assert.NoError(err)
+		assert.Equal(65536, hsmDK.JoinNonce)
+		assert.True(hsmDK.HSM)
+
+		assert.NoError(storage.DeleteDeviceKeys(context.Background(), storage.DB(), d.DevEUI))
+		assert.Len(ks.Keys, 0)
+		assert.NoError(storage.CreateDeviceKeys(context.Background(), storage.DB(), &hsmDK))
+	})
+
 	t.Run("HomeNSReq", func(t *testing.T) {
 		t.Run("Known DevEUI", func(t *testing.T) {
 			assert := require.New(t)
@@ -0,0 +1,81 @@
+package js
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+	//"github.com/brocaar/lorawan/backend"
+)
+
+// keyRotationHandler activates the staged keys of a device when it sends a
+// join-request signed using the staged NwkKey, before the join-request is
+// handled by the wrapped handler. Join-requests signed using the current
+// keys are handled using the current keys.
+type keyRotationHandler struct {
+	handler http.Handler
+}
+
+func (h *keyRotationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.WithError(err).Error("api/js: read request body error")
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	var jr backend.JoinReqPayload
+	if err := json.Unmarshal(b, &jr); err != nil || jr.MessageType != backend.JoinReq {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	var phy lorawan.PHYPayload
+	if err := phy.UnmarshalBinary(jr.PHYPayload[:]); err != nil {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+	jrPL, ok := phy.MACPayload.(*lorawan.JoinRequestPayload)
+	if !ok {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	staged, err := storage.GetStagedDeviceKeys(r.Context(), storage.DB(), jrPL.DevEUI)
+	if err != nil {
+		if err != storage.ErrDoesNotExist {
+			log.WithError(err).WithField("dev_eui", jrPL.DevEUI).Error("api/js: get staged device-keys error")
+		}
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	// the join-request mic is calculated using the NwkKey, for LoRaWAN 1.0.x
+	// devices the AppKey is stored as NwkKey
+	ok, err = phy.ValidateUplinkJoinMIC(staged.NwkKey)
+	if err != nil || !ok {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		return storage.ActivateStagedDeviceKeys(r.Context(), tx, jrPL.DevEUI)
+	})
+	if err != nil {
+		log.WithError(err).WithField("dev_eui", jrPL.DevEUI).Error("api/js: activate staged device-keys error")
+	} else {
+		log.WithField("dev_eui", jrPL.DevEUI).Info("api/js: join-request signed using staged device-keys, keys activated")
+	}
+
+	h.handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,236 @@
+package js
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+	//"github.com/brocaar/lorawan/backend"
+)
+
+// sessionRequest contains the session related fields of a join- or
+// rejoin-request.
+type sessionRequest struct {
+	basePL     backend.BasePayload
+	devEUI     lorawan.EUI64
+	joinEUI    lorawan.EUI64
+	devAddr    lorawan.DevAddr
+	macVersion string
+	joinType   storage.JoinType
+	devNonce   int
+	rjCount    int
+}
+
+// sessionHandler validates the RJcount1 of rejoin-requests type 1 and stores
+// the session history of each successful join- and rejoin-request handled
+// by the wrapped handler.
+type sessionHandler struct {
+	handler http.Handler
+}
+
+func (h *sessionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.WithError(err).Error("api/js: read request body error")
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	req, ok := parseSessionRequest(b)
+	if !ok {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	if req.joinType == storage.JoinTypeRejoin1 {
+		if err := validateRJCount1(r.Context(), req.devEUI, req.rjCount); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"dev_eui":  req.devEUI,
+				"rj_count": req.rjCount,
+			}).Warning("api/js: rejoin-request type 1 rejected")
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(backend.BasePayloadResult{
+				BasePayload: answerBasePayload(req.basePL),
+				Result: backend.Result{
+					ResultCode:  backend.FrameReplayed,
+					Description: err.Error(),
+				},
+			})
+			return
+		}
+	}
+
+	bw := bodyWriter{ResponseWriter: w}
+	h.handler.ServeHTTP(&bw, r)
+
+	var ans backend.BasePayloadResult
+	if err := json.Unmarshal(bw.body.Bytes(), &ans); err != nil {
+		return
+	}
+
+	if err := saveJoinMetrics(r.Context(), req.devEUI, ans.Result.ResultCode == backend.Success); err != nil {
+		log.WithError(err).WithField("dev_eui", req.devEUI).Error("api/js: save join metrics error")
+	}
+
+	if ans.Result.ResultCode != backend.Success {
+		return
+	}
+
+	if err := storeSession(r.Context(), req); err != nil {
+		log.WithError(err).WithField("dev_eui", req.devEUI).Error("api/js: store device session error")
+	}
+}
+
+// parseSessionRequest parses the given join- or rejoin-request. It returns
+// false when the payload is not a (valid) join- or rejoin-request.
+func parseSessionRequest(b []byte) (sessionRequest, bool) {
+	var basePL backend.BasePayload
+	if err := json.Unmarshal(b, &basePL); err != nil {
+		return sessionRequest{}, false
+	}
+
+	var phy lorawan.PHYPayload
+	req := sessionRequest{
+		basePL: basePL,
+	}
+
+	switch basePL.MessageType {
+	case backend.JoinReq:
+		var jr backend.JoinReqPayload
+		if err := json.Unmarshal(b, &jr); err != nil {
+			return sessionRequest{}, false
+		}
+		if err := phy.UnmarshalBinary(jr.PHYPayload[:]); err != nil {
+			return sessionRequest{}, false
+		}
+		pl, ok := phy.MACPayload.(*lorawan.JoinRequestPayload)
+		if !ok {
+			return sessionRequest{}, false
+		}
+
+		req.devEUI = pl.DevEUI
+		req.joinEUI = pl.JoinEUI
+		req.devAddr = jr.DevAddr
+		req.macVersion = jr.MACVersion
+		req.joinType = storage.JoinTypeJoin
+		req.devNonce = int(pl.DevNonce)
+	case backend.RejoinReq:
+		var rr backend.RejoinReqPayload
+		if err := json.Unmarshal(b, &rr); err != nil {
+			return sessionRequest{}, false
+		}
+		if err := phy.UnmarshalBinary(rr.PHYPayload[:]); err != nil {
+			return sessionRequest{}, false
+		}
+
+		switch pl := phy.MACPayload.(type) {
+		case *lorawan.RejoinRequestType02Payload:
+			req.devEUI = pl.DevEUI
+			req.rjCount = int(pl.RJCount0)
+			req.joinType = storage.JoinTypeRejoin0
+			if pl.RejoinType == lorawan.RejoinRequestType2 {
+				req.joinType = storage.JoinTypeRejoin2
+			}
+		case *lorawan.RejoinRequestType1Payload:
+			req.devEUI = pl.DevEUI
+			req.joinEUI = pl.JoinEUI
+			req.rjCount = int(pl.RJCount1)
+			req.joinType = storage.JoinTypeRejoin1
+		default:
+			return sessionRequest{}, false
+		}
+
+		req.devAddr = rr.DevAddr
+		req.macVersion = rr.MACVersion
+	default:
+		return sessionRequest{}, false
+	}
+
+	return req, true
+}
+
+// validateRJCount1 validates that the given RJcount1 is greater than the
+// RJcount1 of the last rejoin-request type 1 since the last join of the
+// device, as the join-server must reject replayed type 1 rejoin-requests.
+func validateRJCount1(ctx context.Context, devEUI lorawan.EUI64, rjCount int) error {
+	last, err := storage.GetLastRJCount1(ctx, storage.DB(), devEUI)
+	if err != nil {
+		if err == storage.ErrDoesNotExist {
+			return nil
+		}
+		return errors.Wrap(err, "get last rjcount1 error")
+	}
+
+	if rjCount <= last {
+		return errors.Errorf("rjcount1 %d must be greater than %d", rjCount, last)
+	}
+
+	return nil
+}
+
+func storeSession(ctx context.Context, req sessionRequest) error {
+	dk, err := storage.GetDeviceKeys(ctx, storage.DB(), req.devEUI)
+	if err != nil {
+		return errors.Wrap(err, "get device-keys error")
+	}
+
+	return storage.CreateDeviceSession(ctx, storage.DB(), &storage.DeviceSession{
+		DevEUI:     req.devEUI,
+		JoinType:   req.joinType,
+		SenderID:   req.basePL.SenderID,
+		MACVersion: req.macVersion,
+		DevAddr:    req.devAddr,
+		JoinNonce:  dk.JoinNonce,
+		DevNonce:   req.devNonce,
+		RJCount:    req.rjCount,
+	})
+}
+
+// saveJoinMetrics stores the aggregated join metrics of the application of
+// the device. Unknown devices are ignored.
+func saveJoinMetrics(ctx context.Context, devEUI lorawan.EUI64, accepted bool) error {
+	d, err := storage.GetDevice(ctx, storage.DB(), devEUI, false, true)
+	if err != nil {
+		if errors.Cause(err) == storage.ErrDoesNotExist {
+			return nil
+		}
+		return errors.Wrap(err, "get device error")
+	}
+
+	metrics := map[string]float64{
+		storage.ApplicationMetricJoinRequestCount: 1,
+	}
+	if accepted {
+		metrics[storage.ApplicationMetricJoinAcceptCount] = 1
+	}
+
+	return storage.SaveMetrics(ctx, storage.ApplicationMetricsName(d.ApplicationID), storage.MetricsRecord{
+		Time:    time.Now(),
+		Metrics: metrics,
+	})
+}
+
+// bodyWriter keeps a copy of the response body written to the wrapped
+// ResponseWriter.
+type bodyWriter struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
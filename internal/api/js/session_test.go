@@ -0,0 +1,142 @@
+package js
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+	//"github.com/brocaar/lorawan/backend"
+)
+
+func TestParseSessionRequest(t *testing.T) {
+	devEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	devAddr := lorawan.DevAddr{1, 2, 3, 4}
+
+	marshalPHY := func(t *testing.T, phy lorawan.PHYPayload) backend.HEXBytes {
+		b, err := phy.MarshalBinary()
+		require.NoError(t, err)
+		return backend.HEXBytes(b)
+	}
+
+	rejoinReq := func(t *testing.T, pl lorawan.Payload) []byte {
+		b, err := json.Marshal(backend.RejoinReqPayload{
+			BasePayload: backend.BasePayload{
+				ProtocolVersion: backend.ProtocolVersion1_0,
+				SenderID:        "010203",
+				ReceiverID:      "0807060504030201",
+				MessageType:     backend.RejoinReq,
+			},
+			MACVersion: "1.1.0",
+			PHYPayload: marshalPHY(t, lorawan.PHYPayload{
+				MHDR: lorawan.MHDR{
+					MType: lorawan.RejoinRequest,
+					Major: lorawan.LoRaWANR1,
+				},
+				MACPayload: pl,
+			}),
+			DevEUI:  devEUI,
+			DevAddr: devAddr,
+		})
+		require.NoError(t, err)
+		return b
+	}
+
+	t.Run("Join-request", func(t *testing.T) {
+		assert := require.New(t)
+
+		b, err := json.Marshal(backend.JoinReqPayload{
+			BasePayload: backend.BasePayload{
+				ProtocolVersion: backend.ProtocolVersion1_0,
+				SenderID:        "010203",
+				ReceiverID:      "0807060504030201",
+				MessageType:     backend.JoinReq,
+			},
+			MACVersion: "1.0.3",
+			PHYPayload: marshalPHY(t, lorawan.PHYPayload{
+				MHDR: lorawan.MHDR{
+					MType: lorawan.JoinRequest,
+					Major: lorawan.LoRaWANR1,
+				},
+				MACPayload: &lorawan.JoinRequestPayload{
+					DevEUI:   devEUI,
+					JoinEUI:  lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1},
+					DevNonce: 258,
+				},
+			}),
+			DevEUI:  devEUI,
+			DevAddr: devAddr,
+		})
+		assert.NoError(err)
+
+		req, ok := parseSessionRequest(b)
+		assert.True(ok)
+		assert.Equal(devEUI, req.devEUI)
+		assert.Equal(devAddr, req.devAddr)
+		assert.Equal("1.0.3", req.macVersion)
+		assert.Equal(storage.JoinTypeJoin, req.joinType)
+		assert.Equal(258, req.devNonce)
+	})
+
+	t.Run("Rejoin-request type 0", func(t *testing.T) {
+		assert := require.New(t)
+
+		req, ok := parseSessionRequest(rejoinReq(t, &lorawan.RejoinRequestType02Payload{
+			RejoinType: lorawan.RejoinRequestType0,
+			NetID:      lorawan.NetID{1, 2, 3},
+			DevEUI:     devEUI,
+			RJCount0:   7,
+		}))
+		assert.True(ok)
+		assert.Equal(storage.JoinTypeRejoin0, req.joinType)
+		assert.Equal(7, req.rjCount)
+		assert.Equal(devAddr, req.devAddr)
+	})
+
+	t.Run("Rejoin-request type 1", func(t *testing.T) {
+		assert := require.New(t)
+
+		req, ok := parseSessionRequest(rejoinReq(t, &lorawan.RejoinRequestType1Payload{
+			RejoinType: lorawan.RejoinRequestType1,
+			JoinEUI:    lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1},
+			DevEUI:     devEUI,
+			RJCount1:   3,
+		}))
+		assert.True(ok)
+		assert.Equal(storage.JoinTypeRejoin1, req.joinType)
+		assert.Equal(3, req.rjCount)
+	})
+
+	t.Run("Rejoin-request type 2", func(t *testing.T) {
+		assert := require.New(t)
+
+		req, ok := parseSessionRequest(rejoinReq(t, &lorawan.RejoinRequestType02Payload{
+			RejoinType: lorawan.RejoinRequestType2,
+			NetID:      lorawan.NetID{1, 2, 3},
+			DevEUI:     devEUI,
+			RJCount0:   9,
+		}))
+		assert.True(ok)
+		assert.Equal(storage.JoinTypeRejoin2, req.joinType)
+		assert.Equal(9, req.rjCount)
+	})
+
+	t.Run("Other message-type", func(t *testing.T) {
+		assert := require.New(t)
+
+		b, err := json.Marshal(backend.HomeNSReqPayload{
+			BasePayload: backend.BasePayload{
+				ProtocolVersion: backend.ProtocolVersion1_0,
+				SenderID:        "010203",
+				MessageType:     backend.HomeNSReq,
+			},
+			DevEUI: devEUI,
+		})
+		assert.NoError(err)
+
+		_, ok := parseSessionRequest(b)
+		assert.False(ok)
+	})
+}
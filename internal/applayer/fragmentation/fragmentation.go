@@ -11,6 +11,7 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/leader"
 	"github.com/ibrahimozekici/app-server2/internal/logging"
 	"github.com/ibrahimozekici/app-server2/internal/storage"
 	//"github.com/brocaar/lorawan"
@@ -45,8 +46,10 @@ func SyncRemoteFragmentationSessionsLoop() {
 		ctx := context.Background()
 		ctx = context.WithValue(ctx, logging.ContextIDKey, ctxID)
 
-		err = storage.Transaction(func(tx sqlx.Ext) error {
-			return syncRemoteFragmentationSessions(ctx, tx)
+		err = leader.Do(ctx, "remote_fragmentation_session", func(ctx context.Context) error {
+			return storage.Transaction(func(tx sqlx.Ext) error {
+				return syncRemoteFragmentationSessions(ctx, tx)
+			})
 		})
 		if err != nil {
 			log.WithError(err).Error("sync remote fragmentation setup error")
@@ -11,6 +11,7 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/leader"
 	"github.com/ibrahimozekici/app-server2/internal/logging"
 	"github.com/ibrahimozekici/app-server2/internal/storage"
 	//"github.com/brocaar/lorawan"
@@ -47,8 +48,10 @@ func SyncRemoteMulticastSetupLoop() {
 		ctx := context.Background()
 		ctx = context.WithValue(ctx, logging.ContextIDKey, ctxID)
 
-		err = storage.Transaction(func(tx sqlx.Ext) error {
-			return syncRemoteMulticastSetup(ctx, tx)
+		err = leader.Do(ctx, "remote_multicast_setup", func(ctx context.Context) error {
+			return storage.Transaction(func(tx sqlx.Ext) error {
+				return syncRemoteMulticastSetup(ctx, tx)
+			})
 		})
 
 		if err != nil {
@@ -70,8 +73,10 @@ func SyncRemoteMulticastClassCSessionLoop() {
 		ctx := context.Background()
 		ctx = context.WithValue(ctx, logging.ContextIDKey, ctxID)
 
-		err = storage.Transaction(func(tx sqlx.Ext) error {
-			return syncRemoteMulticastClassCSession(ctx, tx)
+		err = leader.Do(ctx, "remote_multicast_class_c_session", func(ctx context.Context) error {
+			return storage.Transaction(func(tx sqlx.Ext) error {
+				return syncRemoteMulticastClassCSession(ctx, tx)
+			})
 		})
 
 		if err != nil {
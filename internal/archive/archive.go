@@ -0,0 +1,240 @@
+// Package archive exports the device frame-logs and events which are older
+// than the configured number of days to object storage.
+package archive
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/integration"
+	"github.com/ibrahimozekici/app-server2/internal/integration/models"
+	"github.com/ibrahimozekici/app-server2/internal/leader"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+var (
+	framesDays int
+	eventsDays int
+	interval   = time.Hour
+	prefix     string
+
+	// archiveHandlers returns the integrations storing events, this can be
+	// overwritten for testing.
+	archiveHandlers = integration.ArchiveHandlers
+
+	// uploadObject stores the given object, this can be overwritten for
+	// testing.
+	uploadObject func(ctx context.Context, key string, b []byte) error
+)
+
+// Setup configures the package and starts the archive loop.
+func Setup(conf config.Config) error {
+	c := conf.ApplicationServer.Archive
+
+	framesDays = c.FramesDays
+	eventsDays = c.EventsDays
+	prefix = c.S3.Prefix
+	if c.Interval > 0 {
+		interval = c.Interval
+	}
+
+	if framesDays <= 0 && eventsDays <= 0 {
+		return nil
+	}
+
+	upload, err := newS3Uploader(conf)
+	if err != nil {
+		return errors.Wrap(err, "new s3 uploader error")
+	}
+	uploadObject = upload
+
+	log.WithFields(log.Fields{
+		"frames_days": framesDays,
+		"events_days": eventsDays,
+		"bucket":      c.S3.Bucket,
+	}).Info("archive: archiving to object storage enabled")
+
+	go archiveLoop()
+
+	return nil
+}
+
+// FramesArchivedBefore returns the timestamp before which the frame-logs
+// have been moved to the archive. It returns false when archiving of the
+// frame-logs is disabled.
+func FramesArchivedBefore(now time.Time) (time.Time, bool) {
+	if framesDays <= 0 {
+		return time.Time{}, false
+	}
+	return before(now, framesDays), true
+}
+
+func archiveLoop() {
+	for {
+		ctxID, err := uuid.NewV4()
+		if err != nil {
+			log.WithError(err).Error("new uuid error")
+		}
+
+		ctx := context.Background()
+		ctx = context.WithValue(ctx, logging.ContextIDKey, ctxID)
+
+		err = leader.Do(ctx, "archive", func(ctx context.Context) error {
+			return Archive(ctx, storage.DB(), time.Now())
+		})
+		if err != nil {
+			log.WithError(err).Error("archive: archive error")
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Archive exports the frame-logs and events which are older than the
+// configured number of days and removes them from the database. The data is
+// exported in windows of max. one hour, each window is written to a separate
+// Parquet object.
+//
+// In case of schema_per_organization, only the frame-logs stored in the
+// public schema are archived.
+func Archive(ctx context.Context, db sqlx.Ext, now time.Time) error {
+	if framesDays > 0 {
+		if err := archiveFrames(ctx, db, before(now, framesDays)); err != nil {
+			return errors.Wrap(err, "archive frame-logs error")
+		}
+	}
+
+	if eventsDays > 0 {
+		for _, h := range archiveHandlers() {
+			for _, table := range h.ArchiveEventTables() {
+				if err := archiveEvents(ctx, h, table, before(now, eventsDays)); err != nil {
+					return errors.Wrapf(err, "archive %s events error", table)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func archiveFrames(ctx context.Context, db sqlx.Ext, cutoff time.Time) error {
+	for {
+		oldest, err := storage.GetOldestDeviceFrameLogTime(ctx, db)
+		if err != nil {
+			if err == storage.ErrDoesNotExist {
+				return nil
+			}
+			return errors.Wrap(err, "get oldest frame-log error")
+		}
+		if !oldest.Before(cutoff) {
+			return nil
+		}
+
+		start, end := archiveWindow(oldest, cutoff)
+
+		rows, err := storage.GetDeviceFrameLogArchiveRows(ctx, db, start, end)
+		if err != nil {
+			return errors.Wrap(err, "get frame-logs error")
+		}
+
+		records := make([]record, 0, len(rows))
+		for _, row := range rows {
+			records = append(records, newRecord(row.DevEUI.String(), row.ApplicationID, row.ReceivedAt, row.Data))
+		}
+
+		if err := writeObject(ctx, objectKey("frames", start, end), records); err != nil {
+			return err
+		}
+
+		if _, err := storage.DeleteDeviceFrameLogsForRange(ctx, db, start, end); err != nil {
+			return errors.Wrap(err, "delete frame-logs error")
+		}
+	}
+}
+
+func archiveEvents(ctx context.Context, h models.ArchiveHandler, table string, cutoff time.Time) error {
+	for {
+		oldest, err := h.GetOldestEventTime(ctx, table)
+		if err != nil {
+			return errors.Wrap(err, "get oldest event error")
+		}
+		if oldest == nil || !oldest.Before(cutoff) {
+			return nil
+		}
+
+		start, end := archiveWindow(*oldest, cutoff)
+
+		events, err := h.GetArchiveEvents(ctx, table, start, end)
+		if err != nil {
+			return errors.Wrap(err, "get events error")
+		}
+
+		records := make([]record, 0, len(events))
+		for _, e := range events {
+			records = append(records, newRecord(e.DevEUI.String(), e.ApplicationID, e.ReceivedAt, e.Data))
+		}
+
+		if err := writeObject(ctx, objectKey("events/"+table, start, end), records); err != nil {
+			return err
+		}
+
+		if _, err := h.DeleteEvents(ctx, table, start, end); err != nil {
+			return errors.Wrap(err, "delete events error")
+		}
+	}
+}
+
+func writeObject(ctx context.Context, key string, records []record) error {
+	b, err := encodeParquet(records)
+	if err != nil {
+		return errors.Wrap(err, "encode parquet error")
+	}
+
+	if err := uploadObject(ctx, key, b); err != nil {
+		return errors.Wrap(err, "upload object error")
+	}
+
+	log.WithFields(log.Fields{
+		"key":    key,
+		"count":  len(records),
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("archive: object uploaded")
+
+	return nil
+}
+
+// archiveWindow returns the time range to export, starting at the oldest
+// item until the end of its hour (or the cutoff, whatever comes first).
+func archiveWindow(oldest, cutoff time.Time) (time.Time, time.Time) {
+	end := oldest.Truncate(time.Hour).Add(time.Hour)
+	if end.After(cutoff) {
+		end = cutoff
+	}
+	return oldest, end
+}
+
+// objectKey returns the object key for the given kind and time range,
+// e.g. 'frames/2020/01/30/20200130T120000Z-20200130T130000Z.parquet'.
+func objectKey(kind string, start, end time.Time) string {
+	start = start.UTC()
+	end = end.UTC()
+
+	return fmt.Sprintf("%s%s/%s/%s-%s.parquet",
+		prefix,
+		kind,
+		start.Format("2006/01/02"),
+		start.Format("20060102T150405Z"),
+		end.Format("20060102T150405Z"),
+	)
+}
+
+func before(now time.Time, days int) time.Time {
+	return now.AddDate(0, 0, -days)
+}
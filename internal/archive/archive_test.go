@@ -0,0 +1,228 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	nsmock "github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/integration/models"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	"github.com/ibrahimozekici/app-server2/internal/test"
+	//"github.com/brocaar/lorawan"
+)
+
+type timeRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+type testArchiveHandler struct {
+	events  []models.ArchiveEvent
+	deleted []timeRange
+}
+
+func (h *testArchiveHandler) ArchiveEventTables() []string {
+	return []string{"device_up"}
+}
+
+func (h *testArchiveHandler) GetOldestEventTime(ctx context.Context, table string) (*time.Time, error) {
+	if len(h.events) == 0 {
+		return nil, nil
+	}
+	ts := h.events[0].ReceivedAt
+	return &ts, nil
+}
+
+func (h *testArchiveHandler) GetArchiveEvents(ctx context.Context, table string, start, end time.Time) ([]models.ArchiveEvent, error) {
+	var out []models.ArchiveEvent
+	for _, e := range h.events {
+		if !e.ReceivedAt.Before(start) && e.ReceivedAt.Before(end) {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+func (h *testArchiveHandler) DeleteEvents(ctx context.Context, table string, start, end time.Time) (int64, error) {
+	h.deleted = append(h.deleted, timeRange{start, end})
+
+	var keep []models.ArchiveEvent
+	for _, e := range h.events {
+		if e.ReceivedAt.Before(start) || !e.ReceivedAt.Before(end) {
+			keep = append(keep, e)
+		}
+	}
+	count := int64(len(h.events) - len(keep))
+	h.events = keep
+	return count, nil
+}
+
+type ArchiveTestSuite struct {
+	suite.Suite
+
+	tx *storage.TxLogger
+
+	Application storage.Application
+}
+
+func (ts *ArchiveTestSuite) SetupSuite() {
+	assert := require.New(ts.T())
+	conf := test.GetConfig()
+	assert.NoError(storage.Setup(conf))
+	test.MustResetDB(storage.DB().DB)
+}
+
+func (ts *ArchiveTestSuite) TearDownTest() {
+	ts.tx.Rollback()
+}
+
+func (ts *ArchiveTestSuite) SetupTest() {
+	assert := require.New(ts.T())
+	var err error
+	ts.tx, err = storage.DB().Beginx()
+	assert.NoError(err)
+
+	networkserver.SetPool(nsmock.NewPool(nsmock.NewClient()))
+
+	n := storage.NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), ts.tx, &n))
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), ts.tx, &org))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), ts.tx, &sp))
+	var spID uuid.UUID
+	copy(spID[:], sp.ServiceProfile.Id)
+
+	ts.Application = storage.Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), ts.tx, &ts.Application))
+}
+
+func (ts *ArchiveTestSuite) TestArchive() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+	now := time.Date(2020, 1, 30, 12, 30, 0, 0, time.UTC)
+
+	assert.NoError(storage.MaintainPartitions(ctx, ts.tx, now.AddDate(0, 0, -3)))
+
+	devEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	for _, receivedAt := range []time.Time{
+		now.AddDate(0, 0, -2),
+		now.AddDate(0, 0, -2).Add(10 * time.Minute),
+		now.AddDate(0, 0, -2).Add(time.Hour),
+		now,
+	} {
+		assert.NoError(storage.CreateDeviceFrameLog(ctx, ts.tx, storage.DeviceFrameLog{
+			DevEUI:        devEUI,
+			ApplicationID: ts.Application.ID,
+			ReceivedAt:    receivedAt,
+		}))
+	}
+
+	h := testArchiveHandler{
+		events: []models.ArchiveEvent{
+			{DevEUI: devEUI, ApplicationID: ts.Application.ID, ReceivedAt: now.AddDate(0, 0, -10), Data: []byte(`{}`)},
+			{DevEUI: devEUI, ApplicationID: ts.Application.ID, ReceivedAt: now, Data: []byte(`{}`)},
+		},
+	}
+	archiveHandlers = func() []models.ArchiveHandler {
+		return []models.ArchiveHandler{&h}
+	}
+
+	objects := make(map[string][]byte)
+	uploadObject = func(ctx context.Context, key string, b []byte) error {
+		objects[key] = b
+		return nil
+	}
+
+	framesDays = 1
+	eventsDays = 7
+	defer func() {
+		framesDays = 0
+		eventsDays = 0
+	}()
+
+	assert.NoError(Archive(ctx, ts.tx, now))
+
+	ts.T().Run("Objects uploaded", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Len(objects, 3)
+		for _, key := range []string{
+			"frames/2020/01/28/20200128T123000Z-20200128T130000Z.parquet",
+			"frames/2020/01/28/20200128T133000Z-20200128T140000Z.parquet",
+			"events/device_up/2020/01/20/20200120T123000Z-20200120T130000Z.parquet",
+		} {
+			b, ok := objects[key]
+			assert.True(ok, key)
+			assert.True(bytes.HasPrefix(b, []byte("PAR1")))
+		}
+	})
+
+	ts.T().Run("Frames removed", func(t *testing.T) {
+		assert := require.New(t)
+		frames, err := storage.GetDeviceFrameLogs(ctx, ts.tx, devEUI, now.AddDate(0, 0, -3), now.Add(time.Second), 10)
+		assert.NoError(err)
+		assert.Len(frames, 1)
+	})
+
+	ts.T().Run("Events removed", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Len(h.events, 1)
+		assert.Len(h.deleted, 1)
+	})
+
+	ts.T().Run("Frames archived before", func(t *testing.T) {
+		assert := require.New(t)
+		archivedBefore, ok := FramesArchivedBefore(now)
+		assert.True(ok)
+		assert.Equal(now.AddDate(0, 0, -1), archivedBefore)
+	})
+}
+
+func TestArchive(t *testing.T) {
+	suite.Run(t, new(ArchiveTestSuite))
+}
+
+func TestArchiveWindow(t *testing.T) {
+	assert := require.New(t)
+	cutoff := time.Date(2020, 1, 30, 12, 30, 0, 0, time.UTC)
+
+	start, end := archiveWindow(time.Date(2020, 1, 30, 10, 15, 0, 0, time.UTC), cutoff)
+	assert.Equal(time.Date(2020, 1, 30, 10, 15, 0, 0, time.UTC), start)
+	assert.Equal(time.Date(2020, 1, 30, 11, 0, 0, 0, time.UTC), end)
+
+	_, end = archiveWindow(time.Date(2020, 1, 30, 12, 15, 0, 0, time.UTC), cutoff)
+	assert.Equal(cutoff, end)
+}
+
+func TestEncodeParquet(t *testing.T) {
+	assert := require.New(t)
+
+	b, err := encodeParquet([]record{
+		newRecord("0102030405060708", 1, time.Now(), []byte(`{"f_cnt":10}`)),
+	})
+	assert.NoError(err)
+	assert.True(bytes.HasPrefix(b, []byte("PAR1")))
+	assert.True(bytes.HasSuffix(b, []byte("PAR1")))
+}
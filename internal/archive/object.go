@@ -0,0 +1,95 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/pkg/errors"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+// record defines the Parquet schema of the archived frame-logs and events.
+// The data column contains the complete database row encoded as JSON.
+type record struct {
+	DevEUI        string `parquet:"name=dev_eui, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ApplicationID int64  `parquet:"name=application_id, type=INT64"`
+	ReceivedAt    int64  `parquet:"name=received_at, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	Data          string `parquet:"name=data, type=BYTE_ARRAY, convertedtype=JSON"`
+}
+
+func newRecord(devEUI string, applicationID int64, receivedAt time.Time, data json.RawMessage) record {
+	return record{
+		DevEUI:        devEUI,
+		ApplicationID: applicationID,
+		ReceivedAt:    receivedAt.UnixNano() / int64(time.Millisecond),
+		Data:          string(data),
+	}
+}
+
+// encodeParquet returns the given records as Snappy compressed Parquet file.
+func encodeParquet(records []record) ([]byte, error) {
+	var buf bytes.Buffer
+
+	pw, err := writer.NewParquetWriterFromWriter(&buf, new(record), 1)
+	if err != nil {
+		return nil, errors.Wrap(err, "new parquet writer error")
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+
+	for _, r := range records {
+		if err := pw.Write(r); err != nil {
+			return nil, errors.Wrap(err, "write record error")
+		}
+	}
+
+	if err := pw.WriteStop(); err != nil {
+		return nil, errors.Wrap(err, "write stop error")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// newS3Uploader returns a function uploading objects to the configured
+// S3 bucket.
+func newS3Uploader(conf config.Config) (func(ctx context.Context, key string, b []byte) error, error) {
+	c := conf.ApplicationServer.Archive.S3
+	if c.Bucket == "" {
+		return nil, errors.New("s3 bucket must be set")
+	}
+
+	awsConf := aws.Config{
+		Region:           aws.String(c.Region),
+		S3ForcePathStyle: aws.Bool(c.ForcePathStyle),
+	}
+	if c.Endpoint != "" {
+		awsConf.Endpoint = aws.String(c.Endpoint)
+	}
+	if c.AccessKeyID != "" || c.SecretAccessKey != "" {
+		awsConf.Credentials = credentials.NewStaticCredentials(c.AccessKeyID, c.SecretAccessKey, "")
+	}
+
+	sess, err := session.NewSession(&awsConf)
+	if err != nil {
+		return nil, errors.Wrap(err, "new session error")
+	}
+	uploader := s3manager.NewUploader(sess)
+
+	return func(ctx context.Context, key string, b []byte) error {
+		_, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+			Bucket:      aws.String(c.Bucket),
+			Key:         aws.String(key),
+			Body:        bytes.NewReader(b),
+			ContentType: aws.String("application/vnd.apache.parquet"),
+		})
+		return err
+	}, nil
+}
@@ -0,0 +1,315 @@
+package automation
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq/hstore"
+	"github.com/pkg/errors"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/downlink"
+	"github.com/ibrahimozekici/app-server2/internal/integration/models"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// webhookTimeout defines the max. duration of a webhook (or SMS gateway)
+// request.
+const webhookTimeout = 10 * time.Second
+
+// actionHandler executes an action for the given device.
+type actionHandler func(ctx context.Context, action spec.Action, d storage.Device, ev Event) error
+
+// emailConfig holds the SMTP configuration of the notification actions.
+type emailConfig struct {
+	server   string
+	username string
+	password string
+	from     string
+}
+
+// smsConfig holds the SMS gateway configuration of the SMS actions.
+type smsConfig struct {
+	url      string
+	username string
+	password string
+	from     string
+}
+
+var (
+	email emailConfig
+	sms   smsConfig
+
+	// actionHandlers contains the handler per action type, this can be
+	// overwritten for testing.
+	actionHandlers = map[spec.ActionType]actionHandler{
+		spec.DownlinkAction:     handleDownlink,
+		spec.NotificationAction: handleNotification,
+		spec.WebhookAction:      handleWebhook,
+		spec.TagAction:          handleTag,
+		spec.SMSAction:          handleSMS,
+		spec.InAppAction:        handleInApp,
+	}
+
+	// sendMail sends the e-mail, this can be overwritten for testing.
+	sendMail = smtp.SendMail
+)
+
+// handleDownlink enqueues the downlink object for the device.
+func handleDownlink(ctx context.Context, action spec.Action, d storage.Device, ev Event) error {
+	return downlink.EnqueueDataDownPayload(ctx, models.DataDownPayload{
+		ApplicationID: d.ApplicationID,
+		DevEUI:        d.DevEUI,
+		Confirmed:     action.Confirmed,
+		FPort:         action.FPort,
+		Object:        json.RawMessage(action.Object),
+	})
+}
+
+// handleNotification sends the notification e-mail.
+func handleNotification(ctx context.Context, action spec.Action, d storage.Device, ev Event) error {
+	if email.server == "" {
+		return errors.New("e-mail is not configured")
+	}
+
+	var auth smtp.Auth
+	if email.username != "" {
+		host, _, err := net.SplitHostPort(email.server)
+		if err != nil {
+			return errors.Wrap(err, "split host port error")
+		}
+		auth = smtp.PlainAuth("", email.username, email.password, host)
+	}
+
+	if err := sendMail(email.server, auth, email.from, action.To, notificationMessage(email.from, action, ev)); err != nil {
+		return errors.Wrap(err, "send mail error")
+	}
+
+	return nil
+}
+
+// notificationMessage returns the e-mail message (headers and body) of the
+// notification. A grouped notification lists the devices of the zone.
+func notificationMessage(from string, action spec.Action, ev Event) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(action.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", action.Subject)
+	fmt.Fprintf(&b, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&b, "\r\n")
+	if action.Message != "" {
+		fmt.Fprintf(&b, "%s\r\n\r\n", action.Message)
+	}
+	fmt.Fprintf(&b, "Automation: %s\r\n", ev.AutomationName)
+	fmt.Fprintf(&b, "Trigger: %s\r\n", ev.Trigger)
+	if ev.Severity != "" {
+		fmt.Fprintf(&b, "Severity: %s\r\n", ev.Severity)
+	}
+	if len(ev.Grouped) != 0 {
+		fmt.Fprintf(&b, "Zone: %s\r\n", ev.Zone)
+		fmt.Fprintf(&b, "Time: %s\r\n", ev.Time.Format(time.RFC3339))
+		fmt.Fprintf(&b, "Devices (%d):\r\n", len(ev.Grouped))
+		for _, gev := range ev.Grouped {
+			fmt.Fprintf(&b, "- %s (%s) at %s\r\n", gev.DeviceName, gev.DevEUI, gev.Time.Format(time.RFC3339))
+		}
+		return b.Bytes()
+	}
+	fmt.Fprintf(&b, "Device: %s (%s)\r\n", ev.DeviceName, ev.DevEUI)
+	fmt.Fprintf(&b, "Time: %s\r\n", ev.Time.Format(time.RFC3339))
+	if len(ev.Object) != 0 {
+		fmt.Fprintf(&b, "Object: %s\r\n", ev.Object)
+	}
+	if len(ev.Exceeded) != 0 {
+		fmt.Fprintf(&b, "Exceeded thresholds: %s\r\n", strings.Join(ev.Exceeded, ", "))
+	}
+	if ev.BatteryLevel != nil {
+		fmt.Fprintf(&b, "Battery level: %.2f%%\r\n", *ev.BatteryLevel)
+	}
+	if ev.Margin != nil {
+		fmt.Fprintf(&b, "Margin: %d dB\r\n", *ev.Margin)
+	}
+	if ev.LastSeenAt != nil {
+		fmt.Fprintf(&b, "Last seen at: %s\r\n", ev.LastSeenAt.Format(time.RFC3339))
+	}
+	if ev.Contact != "" {
+		fmt.Fprintf(&b, "Contact: %s\r\n", ev.Contact)
+	}
+	if ev.OpenSince != nil {
+		fmt.Fprintf(&b, "Open since: %s\r\n", ev.OpenSince.Format(time.RFC3339))
+	}
+	if ev.AlarmID != nil {
+		fmt.Fprintf(&b, "Alarm: %s\r\n", ev.AlarmID)
+	}
+	if ev.EscalationLevel != 0 {
+		fmt.Fprintf(&b, "Escalation level: %d\r\n", ev.EscalationLevel)
+	}
+
+	return b.Bytes()
+}
+
+// handleWebhook posts the event as JSON to the webhook url.
+func handleWebhook(ctx context.Context, action spec.Action, d storage.Device, ev Event) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return errors.Wrap(err, "marshal json error")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest("POST", action.URL, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "new request error")
+	}
+	req = req.WithContext(ctx)
+
+	for k, v := range action.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "http request error")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("expected 2xx response, got: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// handleSMS sends the text message to every recipient through the SMS
+// gateway.
+func handleSMS(ctx context.Context, action spec.Action, d storage.Device, ev Event) error {
+	if sms.url == "" {
+		return errors.New("sms is not configured")
+	}
+
+	for _, to := range action.To {
+		if err := sendSMS(ctx, to, smsMessage(action, ev)); err != nil {
+			return errors.Wrapf(err, "send sms to %s error", to)
+		}
+	}
+
+	return nil
+}
+
+// sendSMS posts the text message as form to the SMS gateway.
+func sendSMS(ctx context.Context, to, body string) error {
+	form := url.Values{
+		"To":   []string{to},
+		"From": []string{sms.from},
+		"Body": []string{body},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest("POST", sms.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return errors.Wrap(err, "new request error")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if sms.username != "" {
+		req.SetBasicAuth(sms.username, sms.password)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "http request error")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("expected 2xx response, got: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// smsMessage returns the text message of the SMS action: the message of the
+// action followed by the summary of the event.
+func smsMessage(action spec.Action, ev Event) string {
+	if action.Message != "" {
+		return action.Message + "\n" + eventSummary(ev)
+	}
+	return eventSummary(ev)
+}
+
+// eventSummary returns a single line summary of the event, e.g.
+// "[CRITICAL] cold room: sensor-1 (0102030405060708)".
+func eventSummary(ev Event) string {
+	if len(ev.Grouped) != 0 {
+		return fmt.Sprintf("[%s] %s: %d devices in zone %s", ev.Severity, ev.AutomationName, len(ev.Grouped), ev.Zone)
+	}
+	return fmt.Sprintf("[%s] %s: %s (%s)", ev.Severity, ev.AutomationName, ev.DeviceName, ev.DevEUI)
+}
+
+// handleInApp stores the in-app notification for the application of the
+// device. The summary of the event is used when the action does not have a
+// subject. A grouped notification does not relate to a single device.
+func handleInApp(ctx context.Context, action spec.Action, d storage.Device, ev Event) error {
+	n := storage.InAppNotification{
+		ApplicationID: ev.ApplicationID,
+		AlarmID:       ev.AlarmID,
+		Severity:      ev.Severity,
+		Subject:       action.Subject,
+		Message:       action.Message,
+	}
+	if n.Subject == "" {
+		n.Subject = eventSummary(ev)
+	}
+	if r := []rune(n.Subject); len(r) > 200 {
+		n.Subject = string(r[:200])
+	}
+	if len(ev.Grouped) == 0 {
+		devEUI := ev.DevEUI
+		n.DevEUI = &devEUI
+	}
+
+	if err := storage.CreateInAppNotification(ctx, storage.DB(), &n); err != nil {
+		return errors.Wrap(err, "create in-app notification error")
+	}
+
+	return nil
+}
+
+// handleTag sets or removes the device tag.
+func handleTag(ctx context.Context, action spec.Action, d storage.Device, ev Event) error {
+	return storage.Transaction(func(tx sqlx.Ext) error {
+		d, err := storage.GetDevice(ctx, tx, d.DevEUI, true, true)
+		if err != nil {
+			return errors.Wrap(err, "get device error")
+		}
+
+		if d.Tags.Map == nil {
+			d.Tags = hstore.Hstore{Map: make(map[string]sql.NullString)}
+		}
+
+		if action.Remove {
+			delete(d.Tags.Map, action.Key)
+		} else {
+			d.Tags.Map[action.Key] = sql.NullString{String: action.Value, Valid: true}
+		}
+
+		if err := storage.UpdateDevice(ctx, tx, &d, true); err != nil {
+			return errors.Wrap(err, "update device error")
+		}
+
+		return nil
+	})
+}
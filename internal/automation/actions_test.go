@@ -0,0 +1,186 @@
+package automation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+func testEvent() Event {
+	margin := 3
+	return Event{
+		AutomationID:   uuid.Must(uuid.FromString("0b8dd3a8-6ea6-4c45-8bbe-c5a3c2dc0b2f")),
+		AutomationName: "low-margin",
+		ApplicationID:  1,
+		Trigger:        spec.AlarmTrigger,
+		Severity:       spec.CriticalSeverity,
+		DevEUI:         lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		DeviceName:     "greenhouse-1",
+		Margin:         &margin,
+		Time:           time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestHandleWebhook(t *testing.T) {
+	assert := require.New(t)
+
+	var received Event
+	var auth string
+	status := http.StatusOK
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	action := spec.Action{
+		Type:    spec.WebhookAction,
+		URL:     server.URL,
+		Headers: map[string]string{"Authorization": "Bearer secret"},
+	}
+	ev := testEvent()
+
+	assert.NoError(handleWebhook(context.Background(), action, storage.Device{}, ev))
+	assert.Equal("Bearer secret", auth)
+	assert.Equal(ev, received)
+
+	status = http.StatusInternalServerError
+	assert.EqualError(handleWebhook(context.Background(), action, storage.Device{}, ev), "expected 2xx response, got: 500")
+}
+
+func TestHandleNotification(t *testing.T) {
+	defer func() {
+		email = emailConfig{}
+		sendMail = smtp.SendMail
+	}()
+
+	action := spec.Action{
+		Type:    spec.NotificationAction,
+		To:      []string{"farmer@example.com", "operator@example.com"},
+		Subject: "Low link margin",
+		Message: "Check the antenna of the device.",
+	}
+
+	t.Run("Not configured", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.EqualError(handleNotification(context.Background(), action, storage.Device{}, testEvent()), "e-mail is not configured")
+	})
+
+	t.Run("Send", func(t *testing.T) {
+		assert := require.New(t)
+
+		email = emailConfig{
+			server: "localhost:25",
+			from:   "automation@example.com",
+		}
+
+		var to []string
+		var msg []byte
+		sendMail = func(addr string, a smtp.Auth, from string, t []string, m []byte) error {
+			to = t
+			msg = m
+			return nil
+		}
+
+		assert.NoError(handleNotification(context.Background(), action, storage.Device{}, testEvent()))
+		assert.Equal(action.To, to)
+
+		body := string(msg)
+		assert.True(strings.Contains(body, "To: farmer@example.com, operator@example.com\r\n"))
+		assert.True(strings.Contains(body, "Subject: Low link margin\r\n"))
+		assert.True(strings.Contains(body, "Check the antenna of the device.\r\n"))
+		assert.True(strings.Contains(body, "Severity: CRITICAL\r\n"))
+		assert.True(strings.Contains(body, "Device: greenhouse-1 (0102030405060708)\r\n"))
+		assert.True(strings.Contains(body, "Margin: 3 dB\r\n"))
+	})
+
+	t.Run("Grouped", func(t *testing.T) {
+		assert := require.New(t)
+
+		ev := testEvent()
+		ev2 := testEvent()
+		ev2.DevEUI = lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 9}
+		ev2.DeviceName = "greenhouse-2"
+
+		body := string(notificationMessage("automation@example.com", action, Event{
+			AutomationName: "low-margin",
+			Trigger:        spec.AlarmTrigger,
+			Zone:           "greenhouse",
+			Grouped:        []Event{ev, ev2},
+			Time:           time.Date(2020, 1, 1, 0, 5, 0, 0, time.UTC),
+		}))
+		assert.True(strings.Contains(body, "Zone: greenhouse\r\n"))
+		assert.True(strings.Contains(body, "Devices (2):\r\n"))
+		assert.True(strings.Contains(body, "- greenhouse-1 (0102030405060708) at 2020-01-01T00:00:00Z\r\n"))
+		assert.True(strings.Contains(body, "- greenhouse-2 (0102030405060709) at 2020-01-01T00:00:00Z\r\n"))
+		assert.False(strings.Contains(body, "Margin:"))
+	})
+}
+
+func TestHandleSMS(t *testing.T) {
+	defer func() {
+		sms = smsConfig{}
+	}()
+
+	action := spec.Action{
+		Type:    spec.SMSAction,
+		To:      []string{"+31612345678", "+31687654321"},
+		Message: "Check the antenna.",
+	}
+
+	t.Run("Not configured", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.EqualError(handleSMS(context.Background(), action, storage.Device{}, testEvent()), "sms is not configured")
+	})
+
+	t.Run("Send", func(t *testing.T) {
+		assert := require.New(t)
+
+		var to, from, body []string
+		var username, password string
+		status := http.StatusCreated
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			to = append(to, r.PostForm.Get("To"))
+			from = append(from, r.PostForm.Get("From"))
+			body = append(body, r.PostForm.Get("Body"))
+			username, password, _ = r.BasicAuth()
+			w.WriteHeader(status)
+		}))
+		defer server.Close()
+
+		sms = smsConfig{
+			url:      server.URL,
+			username: "account",
+			password: "secret",
+			from:     "+3120000000",
+		}
+
+		assert.NoError(handleSMS(context.Background(), action, storage.Device{}, testEvent()))
+		assert.Equal(action.To, to)
+		assert.Equal([]string{"+3120000000", "+3120000000"}, from)
+		assert.Equal("Check the antenna.\n[CRITICAL] low-margin: greenhouse-1 (0102030405060708)", body[0])
+		assert.Equal("account", username)
+		assert.Equal("secret", password)
+
+		status = http.StatusBadRequest
+		assert.EqualError(handleSMS(context.Background(), action, storage.Device{}, testEvent()), "send sms to +31612345678 error: expected 2xx response, got: 400")
+	})
+}
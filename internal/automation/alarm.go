@@ -0,0 +1,144 @@
+package automation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// raiseAlarm raises an alarm for the given automation and device, storing
+// the trigger values of the event. When the automation has an escalation
+// chain, the first escalation step is due after its delay. It returns nil
+// when the device already has an open alarm for the automation.
+func raiseAlarm(ctx context.Context, a storage.Automation, d storage.Device, ev Event) (*uuid.UUID, error) {
+	alarm := storage.AutomationAlarm{
+		AutomationID: a.ID,
+		DevEUI:       d.DevEUI,
+		CreatedAt:    ev.Time,
+		TriggerValue: alarmValue(ev),
+		Severity:     a.Severity,
+	}
+
+	if len(a.Escalation) != 0 {
+		next := ev.Time.Add(a.Escalation[0].Delay())
+		alarm.NextEscalationAt = &next
+	}
+
+	if err := storage.CreateAutomationAlarm(ctx, storage.DB(), &alarm); err != nil {
+		if errors.Cause(err) == storage.ErrAlreadyExists {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "create automation alarm error")
+	}
+
+	return &alarm.ID, nil
+}
+
+// resolveAlarms resolves the alarms of the given automation and device, as
+// the trigger no longer matches the event.
+func resolveAlarms(ctx context.Context, a storage.Automation, d storage.Device, ev Event) error {
+	if !a.RaisesAlarm() {
+		return nil
+	}
+
+	if _, err := storage.ResolveAutomationAlarms(ctx, storage.DB(), a.ID, d.DevEUI, time.Now(), alarmValue(ev)); err != nil {
+		return errors.Wrap(err, "resolve automation alarms error")
+	}
+
+	return nil
+}
+
+// alarmValue returns the trigger values of the event (the decoded object
+// and exceeded thresholds, device-status, last seen time or open contact), as
+// stored with the alarm.
+func alarmValue(ev Event) json.RawMessage {
+	b, err := json.Marshal(struct {
+		Object       json.RawMessage `json:"object,omitempty"`
+		Exceeded     []string        `json:"exceeded,omitempty"`
+		BatteryLevel *float32        `json:"batteryLevel,omitempty"`
+		Margin       *int            `json:"margin,omitempty"`
+		LastSeenAt   *time.Time      `json:"lastSeenAt,omitempty"`
+		Contact      string          `json:"contact,omitempty"`
+		OpenSince    *time.Time      `json:"openSince,omitempty"`
+	}{
+		Object:       ev.Object,
+		Exceeded:     ev.Exceeded,
+		BatteryLevel: ev.BatteryLevel,
+		Margin:       ev.Margin,
+		LastSeenAt:   ev.LastSeenAt,
+		Contact:      ev.Contact,
+		OpenSince:    ev.OpenSince,
+	})
+	if err != nil {
+		log.WithError(err).Error("automation: marshal alarm value error")
+		return nil
+	}
+
+	return b
+}
+
+// recordNotification records the notification action (see
+// spec.Action.Notifies) executed for the alarm of the event, such that the
+// alarm history contains who has been notified. Other actions and events
+// without alarm are ignored.
+func recordNotification(ctx context.Context, action spec.Action, ev Event, actionErr string) error {
+	if ev.AlarmID == nil {
+		return nil
+	}
+
+	n := storage.AutomationAlarmNotification{
+		AlarmID:         *ev.AlarmID,
+		CreatedAt:       time.Now(),
+		EscalationLevel: ev.EscalationLevel,
+		ActionType:      action.Type,
+		Error:           actionErr,
+	}
+
+	switch action.Type {
+	case spec.NotificationAction:
+		n.Recipients = pq.StringArray(action.To)
+	case spec.WebhookAction:
+		n.Recipients = pq.StringArray{action.URL}
+	case spec.SMSAction:
+		n.Recipients = pq.StringArray(action.To)
+	case spec.InAppAction:
+		n.Recipients = pq.StringArray{}
+	default:
+		return nil
+	}
+
+	return storage.CreateAutomationAlarmNotification(ctx, storage.DB(), &n)
+}
+
+// alarmActions returns the actions of the automation, followed by the
+// actions of the notification routes matching the severity of the
+// automation when an alarm has been raised.
+func alarmActions(ctx context.Context, a storage.Automation, alarm bool) (spec.Actions, error) {
+	if !alarm {
+		return a.Actions, nil
+	}
+
+	routes, err := storage.GetNotificationRoutesForAlarm(ctx, storage.DB(), a.ApplicationID, a.Severity)
+	if err != nil {
+		return nil, errors.Wrap(err, "get notification routes error")
+	}
+
+	actions := make(spec.Actions, len(a.Actions))
+	copy(actions, a.Actions)
+
+	subject := fmt.Sprintf("[%s] %s", a.Severity, a.Name)
+	for _, r := range routes {
+		actions = append(actions, r.Actions(subject)...)
+	}
+
+	return actions, nil
+}
@@ -0,0 +1,581 @@
+// Package automation implements the automations engine. It evaluates the
+// triggers of the automations (uplink condition, threshold profile,
+// schedule, device offline, device-status alarm and contact open) and
+// executes the actions of the automations of which the trigger fired
+// (downlink, notification, webhook, tag change, SMS and in-app
+// notification).
+// Each execution is stored in the execution history of the automation. The
+// execution of an automation with the alarm flag or an escalation chain
+// raises an alarm, which is resolved once the trigger condition no longer
+// holds for the device and which is escalated until it is acknowledged or
+// resolved. Raised alarms are also notified over the notification routes of
+// the organization matching the severity of the automation.
+package automation
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/leader"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+var (
+	interval           = time.Minute
+	batchSize          = 100
+	executionRetention time.Duration
+)
+
+// Event defines the event of a fired trigger. This is the payload posted
+// by the webhook action. Depending on the trigger type, it contains the
+// decoded object of the uplink (and the paths of the exceeded thresholds),
+// the reported device-status, the time the device was last seen or the
+// Contact which has been open since OpenSince. AlarmID is set when the
+// automation raised an alarm and EscalationLevel when the event is sent by an escalation step. Severity
+// holds the severity of the automation. The
+// event of a grouped notification holds the Zone and the Grouped events of
+// the devices of the zone, its device fields are not set.
+type Event struct {
+	AutomationID    uuid.UUID        `json:"automationID"`
+	AutomationName  string           `json:"automationName"`
+	ApplicationID   int64            `json:"applicationID,string"`
+	Trigger         spec.TriggerType `json:"trigger"`
+	Severity        spec.Severity    `json:"severity"`
+	DevEUI          lorawan.EUI64    `json:"devEUI"`
+	DeviceName      string           `json:"deviceName"`
+	Object          json.RawMessage  `json:"object,omitempty"`
+	Exceeded        []string         `json:"exceeded,omitempty"`
+	BatteryLevel    *float32         `json:"batteryLevel,omitempty"`
+	Margin          *int             `json:"margin,omitempty"`
+	LastSeenAt      *time.Time       `json:"lastSeenAt,omitempty"`
+	Contact         string           `json:"contact,omitempty"`
+	OpenSince       *time.Time       `json:"openSince,omitempty"`
+	AlarmID         *uuid.UUID       `json:"alarmID,omitempty"`
+	EscalationLevel int              `json:"escalationLevel,omitempty"`
+	Zone            string           `json:"zone,omitempty"`
+	Grouped         []Event          `json:"grouped,omitempty"`
+	Time            time.Time        `json:"time"`
+}
+
+// Setup configures the package and starts the loop evaluating the
+// schedule, device offline and contact open triggers and the alarm
+// escalations.
+func Setup(conf config.Config) error {
+	c := conf.ApplicationServer.Automation
+
+	if c.Interval > 0 {
+		interval = c.Interval
+	}
+	if c.BatchSize > 0 {
+		batchSize = c.BatchSize
+	}
+	executionRetention = c.ExecutionRetention
+
+	email = emailConfig{
+		server:   c.Email.Server,
+		username: c.Email.Username,
+		password: c.Email.Password,
+		from:     c.Email.From,
+	}
+
+	sms = smsConfig{
+		url:      c.SMS.URL,
+		username: c.SMS.Username,
+		password: c.SMS.Password,
+		from:     c.SMS.From,
+	}
+
+	go loop()
+
+	return nil
+}
+
+func loop() {
+	for {
+		time.Sleep(interval)
+
+		ctxID, err := uuid.NewV4()
+		if err != nil {
+			log.WithError(err).Error("new uuid error")
+		}
+
+		ctx := context.Background()
+		ctx = context.WithValue(ctx, logging.ContextIDKey, ctxID)
+
+		err = leader.Do(ctx, "automation", func(ctx context.Context) error {
+			return Run(ctx, time.Now())
+		})
+		if err != nil {
+			log.WithError(err).Error("automation: run error")
+		}
+	}
+}
+
+// Run evaluates the schedule, device offline and contact open triggers,
+// executes the actions of the fired automations, escalates the
+// unacknowledged alarms, sends the due grouped notifications and removes the
+// expired executions.
+func Run(ctx context.Context, now time.Time) error {
+	if err := runSchedules(ctx, now); err != nil {
+		return errors.Wrap(err, "run schedules error")
+	}
+
+	if err := runDeviceOffline(ctx, now); err != nil {
+		return errors.Wrap(err, "run device offline error")
+	}
+
+	if err := runContactOpen(ctx, now); err != nil {
+		return errors.Wrap(err, "run contact open error")
+	}
+
+	if err := runEscalations(ctx, now); err != nil {
+		return errors.Wrap(err, "run escalations error")
+	}
+
+	if err := runNotificationGroups(ctx, now); err != nil {
+		return errors.Wrap(err, "run notification groups error")
+	}
+
+	if executionRetention > 0 {
+		count, err := storage.DeleteAutomationExecutionsBefore(ctx, storage.DB(), now.Add(-executionRetention))
+		if err != nil {
+			return errors.Wrap(err, "delete automation executions error")
+		}
+		if count != 0 {
+			log.WithFields(log.Fields{
+				"count":  count,
+				"ctx_id": ctx.Value(logging.ContextIDKey),
+			}).Info("automation: expired executions removed")
+		}
+	}
+
+	return nil
+}
+
+// HandleUplink executes the automations of the device application with an
+// UPLINK or THRESHOLD trigger matching the given uplink. It resolves the
+// DEVICE_OFFLINE alarms of the device and the UPLINK and THRESHOLD alarms
+// of which the conditions no longer match.
+func HandleUplink(ctx context.Context, d storage.Device, fPort uint8, objectJSON []byte) error {
+	now := time.Now()
+	if _, err := storage.ResolveDeviceAutomationAlarms(ctx, storage.DB(), d.DevEUI, spec.DeviceOfflineTrigger, now, alarmValue(Event{
+		LastSeenAt: &now,
+	})); err != nil {
+		return errors.Wrap(err, "resolve device offline alarms error")
+	}
+
+	if len(objectJSON) == 0 {
+		return nil
+	}
+
+	automations, err := storage.GetEnabledAutomationsForTrigger(ctx, storage.DB(), d.ApplicationID, spec.UplinkTrigger)
+	if err != nil {
+		return errors.Wrap(err, "get automations error")
+	}
+
+	for _, a := range automations {
+		if !a.MatchDevice(d) {
+			continue
+		}
+
+		ev := Event{
+			Object: json.RawMessage(objectJSON),
+		}
+
+		ok, err := a.Trigger.MatchUplink(fPort, objectJSON)
+		if err != nil {
+			return errors.Wrap(err, "match uplink error")
+		}
+		if !ok {
+			// uplinks on an other fPort do not resolve the alarm
+			if a.Trigger.MatchFPort(fPort) {
+				if err := recovered(ctx, a, d, ev); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if err := fire(ctx, a, d, ev); err != nil {
+			return err
+		}
+	}
+
+	if err := handleThresholds(ctx, d, fPort, objectJSON); err != nil {
+		return errors.Wrap(err, "handle thresholds error")
+	}
+
+	return nil
+}
+
+// HandleDeviceStatus executes the automations of the device application
+// with an ALARM trigger matching the given device-status and resolves the
+// alarms of the automations which no longer match. The batteryLevel is nil
+// when the battery level is not available.
+func HandleDeviceStatus(ctx context.Context, d storage.Device, batteryLevel *float32, margin int) error {
+	automations, err := storage.GetEnabledAutomationsForTrigger(ctx, storage.DB(), d.ApplicationID, spec.AlarmTrigger)
+	if err != nil {
+		return errors.Wrap(err, "get automations error")
+	}
+
+	for _, a := range automations {
+		if !a.MatchDevice(d) {
+			continue
+		}
+
+		ev := Event{
+			BatteryLevel: batteryLevel,
+			Margin:       &margin,
+		}
+
+		if !a.Trigger.MatchStatus(batteryLevel, margin) {
+			if err := recovered(ctx, a, d, ev); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := fire(ctx, a, d, ev); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fire executes the given automation unless the device is in maintenance,
+// the number of consecutive violations of the trigger has not been reached
+// yet or the automation is in its cooldown period for the device.
+func fire(ctx context.Context, a storage.Automation, d storage.Device, ev Event) error {
+	suppressed, err := inMaintenance(ctx, a, d, time.Now())
+	if err != nil {
+		return err
+	}
+	if suppressed {
+		return nil
+	}
+
+	ok, err := storage.CheckAutomationViolations(ctx, a, d.DevEUI)
+	if err != nil {
+		return errors.Wrap(err, "check automation violations error")
+	}
+	if !ok {
+		return nil
+	}
+
+	ok, err = storage.CheckAutomationCooldown(ctx, a, d.DevEUI)
+	if err != nil {
+		return errors.Wrap(err, "check automation cooldown error")
+	}
+	if !ok {
+		return nil
+	}
+
+	if err := execute(ctx, a, d, ev); err != nil {
+		return errors.Wrap(err, "execute automation error")
+	}
+
+	return nil
+}
+
+func runSchedules(ctx context.Context, now time.Time) error {
+	for {
+		var automations []storage.Automation
+
+		// the scheduled time is updated within the transaction, the actions
+		// are executed afterwards so that these do not hold the locks
+		err := storage.Transaction(func(tx sqlx.Ext) error {
+			var err error
+			automations, err = storage.GetScheduledAutomations(ctx, tx, now, batchSize)
+			if err != nil {
+				return errors.Wrap(err, "get scheduled automations error")
+			}
+
+			for _, a := range automations {
+				if err := storage.SetAutomationScheduledAt(ctx, tx, a.ID, now); err != nil {
+					return errors.Wrap(err, "set automation scheduled at error")
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, a := range automations {
+			devices, err := storage.GetAutomationDevices(ctx, storage.DB(), a)
+			if err != nil {
+				return errors.Wrap(err, "get automation devices error")
+			}
+
+			for _, d := range devices {
+				suppressed, err := inMaintenance(ctx, a, d, now)
+				if err != nil {
+					return err
+				}
+				if suppressed {
+					continue
+				}
+
+				if err := execute(ctx, a, d, Event{}); err != nil {
+					return errors.Wrap(err, "execute automation error")
+				}
+			}
+		}
+
+		if len(automations) < batchSize {
+			return nil
+		}
+	}
+}
+
+func runDeviceOffline(ctx context.Context, now time.Time) error {
+	automations, err := storage.GetAllEnabledAutomationsForTrigger(ctx, storage.DB(), spec.DeviceOfflineTrigger)
+	if err != nil {
+		return errors.Wrap(err, "get automations error")
+	}
+
+	for _, a := range automations {
+		// devices which were already offline when the automation was
+		// configured are ignored, as are devices of which the execution
+		// might already have been removed from the history
+		since := a.UpdatedAt.Add(-a.Trigger.Timeout())
+		if executionRetention > 0 && since.Before(now.Add(-executionRetention)) {
+			since = now.Add(-executionRetention)
+		}
+
+		devices, err := storage.GetAutomationOfflineDevices(ctx, storage.DB(), a, now, since)
+		if err != nil {
+			return errors.Wrap(err, "get automation offline devices error")
+		}
+
+		for _, d := range devices {
+			// the trigger fires once the maintenance has ended when the
+			// device is still offline by then
+			suppressed, err := inMaintenance(ctx, a, d, now)
+			if err != nil {
+				return err
+			}
+			if suppressed {
+				continue
+			}
+
+			// an other instance might be handling the same device
+			ok, err := storage.CheckAutomationOffline(ctx, a, d.DevEUI, *d.LastSeenAt)
+			if err != nil {
+				return errors.Wrap(err, "check automation offline error")
+			}
+			if !ok {
+				continue
+			}
+
+			if err := execute(ctx, a, d, Event{
+				LastSeenAt: d.LastSeenAt,
+			}); err != nil {
+				return errors.Wrap(err, "execute automation error")
+			}
+		}
+	}
+
+	return nil
+}
+
+func runContactOpen(ctx context.Context, now time.Time) error {
+	automations, err := storage.GetAllEnabledAutomationsForTrigger(ctx, storage.DB(), spec.ContactOpenTrigger)
+	if err != nil {
+		return errors.Wrap(err, "get automations error")
+	}
+
+	for _, a := range automations {
+		contacts, err := storage.GetAutomationOpenContacts(ctx, storage.DB(), a, now)
+		if err != nil {
+			return errors.Wrap(err, "get automation open contacts error")
+		}
+
+		for _, c := range contacts {
+			// the trigger fires once the maintenance has ended when the
+			// contact is still open by then
+			suppressed, err := inMaintenance(ctx, a, c.Device, now)
+			if err != nil {
+				return err
+			}
+			if suppressed {
+				continue
+			}
+
+			ok, err := storage.CheckAutomationContactOpen(ctx, a, c.Device.DevEUI, c.Name, c.ChangedAt)
+			if err != nil {
+				return errors.Wrap(err, "check automation contact open error")
+			}
+			if !ok {
+				continue
+			}
+
+			openSince := c.ChangedAt
+			if err := execute(ctx, a, c.Device, Event{
+				Contact:   c.Name,
+				OpenSince: &openSince,
+			}); err != nil {
+				return errors.Wrap(err, "execute automation error")
+			}
+		}
+	}
+
+	return nil
+}
+
+// HandleContactClosed resolves the alarms of the automations of the device
+// application with a CONTACT_OPEN trigger for the given contact, which has
+// been closed.
+func HandleContactClosed(ctx context.Context, d storage.Device, name string, openSince time.Time) error {
+	automations, err := storage.GetEnabledAutomationsForTrigger(ctx, storage.DB(), d.ApplicationID, spec.ContactOpenTrigger)
+	if err != nil {
+		return errors.Wrap(err, "get automations error")
+	}
+
+	for _, a := range automations {
+		if !a.MatchDevice(d) {
+			continue
+		}
+		if a.Trigger.ContactName != "" && a.Trigger.ContactName != name {
+			continue
+		}
+
+		if err := recovered(ctx, a, d, Event{
+			Contact:   name,
+			OpenSince: &openSince,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// inMaintenance returns true when the device is in maintenance at the given
+// time, in which case the automation is suppressed for the device.
+func inMaintenance(ctx context.Context, a storage.Automation, d storage.Device, now time.Time) (bool, error) {
+	end, ok, err := storage.GetDeviceMaintenanceEnd(ctx, storage.DB(), d, now)
+	if err != nil {
+		return false, errors.Wrap(err, "get device maintenance end error")
+	}
+
+	if ok {
+		log.WithFields(log.Fields{
+			"automation_id":   a.ID,
+			"dev_eui":         d.DevEUI,
+			"maintenance_end": end,
+			"ctx_id":          ctx.Value(logging.ContextIDKey),
+		}).Info("automation: suppressed by maintenance window")
+	}
+
+	return ok, nil
+}
+
+// execute executes the actions of the automation for the given device and
+// stores the execution. A failing action does not prevent the execution of
+// the other actions. When the automation raises alarms, an alarm is raised
+// for the device unless it already has an open alarm, the raised alarm is
+// also notified over the notification routes of its severity. The
+// notification actions are skipped when dampened (see dampen).
+func execute(ctx context.Context, a storage.Automation, d storage.Device, ev Event) error {
+	ev = newEvent(a, d, ev)
+
+	if a.RaisesAlarm() {
+		alarmID, err := raiseAlarm(ctx, a, d, ev)
+		if err != nil {
+			return errors.Wrap(err, "raise alarm error")
+		}
+		ev.AlarmID = alarmID
+	}
+
+	actions, err := alarmActions(ctx, a, ev.AlarmID != nil)
+	if err != nil {
+		return err
+	}
+
+	mode, err := dampen(ctx, a, d, actions, ev)
+	if err != nil {
+		return errors.Wrap(err, "dampen notifications error")
+	}
+
+	fields := log.Fields{
+		"automation_id": a.ID,
+		"dev_eui":       d.DevEUI,
+		"trigger":       a.Trigger.Type,
+		"ctx_id":        ctx.Value(logging.ContextIDKey),
+	}
+
+	log.WithFields(fields).Info("automation: trigger fired")
+
+	e := storage.AutomationExecution{
+		AutomationID: a.ID,
+		CreatedAt:    ev.Time,
+		DevEUI:       d.DevEUI,
+		TriggerType:  a.Trigger.Type,
+		Success:      true,
+		Results:      make(spec.Results, 0, len(actions)),
+	}
+
+	for _, action := range actions {
+		res := spec.Result{
+			Type: action.Type,
+		}
+
+		if action.Notifies() && mode != notifySend {
+			res.Suppressed = mode == notifySuppress
+			res.Grouped = mode == notifyGroup
+			e.Results = append(e.Results, res)
+			continue
+		}
+
+		handler, ok := actionHandlers[action.Type]
+		if !ok {
+			res.Error = "unknown action type"
+		} else if err := handler(ctx, action, d, ev); err != nil {
+			log.WithError(err).WithFields(fields).WithField("action", action.Type).Error("automation: action error")
+			res.Error = err.Error()
+		}
+
+		if res.Error != "" {
+			e.Success = false
+		}
+		e.Results = append(e.Results, res)
+
+		if err := recordNotification(ctx, action, ev, res.Error); err != nil {
+			return errors.Wrap(err, "record alarm notification error")
+		}
+	}
+
+	if err := storage.CreateAutomationExecution(ctx, storage.DB(), &e); err != nil {
+		return errors.Wrap(err, "create automation execution error")
+	}
+
+	return nil
+}
+
+// newEvent returns the given event completed with the automation and device
+// details.
+func newEvent(a storage.Automation, d storage.Device, ev Event) Event {
+	ev.AutomationID = a.ID
+	ev.AutomationName = a.Name
+	ev.ApplicationID = a.ApplicationID
+	ev.Trigger = a.Trigger.Type
+	ev.Severity = a.Severity
+	ev.DevEUI = d.DevEUI
+	ev.DeviceName = d.Name
+	ev.Time = time.Now()
+
+	return ev
+}
@@ -0,0 +1,764 @@
+package automation
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/lib/pq/hstore"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	nsmock "github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/downlink/rule"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	"github.com/ibrahimozekici/app-server2/internal/test"
+	//"github.com/brocaar/lorawan"
+)
+
+type AutomationTestSuite struct {
+	suite.Suite
+
+	Device storage.Device
+	events []Event
+	fail   bool
+}
+
+func (ts *AutomationTestSuite) SetupSuite() {
+	assert := require.New(ts.T())
+	conf := test.GetConfig()
+	assert.NoError(storage.Setup(conf))
+
+	actionHandlers = map[spec.ActionType]actionHandler{
+		spec.WebhookAction: func(ctx context.Context, action spec.Action, d storage.Device, ev Event) error {
+			ts.events = append(ts.events, ev)
+			if ts.fail {
+				return errors.New("webhook error")
+			}
+			return nil
+		},
+		spec.InAppAction: handleInApp,
+	}
+}
+
+func (ts *AutomationTestSuite) SetupTest() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	test.MustResetDB(storage.DB().DB)
+	storage.RedisClient().FlushAll()
+	ts.events = nil
+	ts.fail = false
+
+	networkserver.SetPool(nsmock.NewPool(nsmock.NewClient()))
+
+	n := storage.NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(ctx, storage.DB(), &n))
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(ctx, storage.DB(), &org))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(ctx, storage.DB(), &sp))
+	var spID uuid.UUID
+	copy(spID[:], sp.ServiceProfile.Id)
+
+	dp := storage.DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(storage.CreateDeviceProfile(ctx, storage.DB(), &dp))
+	var dpID uuid.UUID
+	copy(dpID[:], dp.DeviceProfile.Id)
+
+	app := storage.Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(storage.CreateApplication(ctx, storage.DB(), &app))
+
+	ts.Device = storage.Device{
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "greenhouse-1",
+	}
+	assert.NoError(storage.CreateDevice(ctx, storage.DB(), &ts.Device))
+}
+
+func (ts *AutomationTestSuite) createAutomation(trigger spec.Trigger) storage.Automation {
+	assert := require.New(ts.T())
+
+	a := storage.Automation{
+		ApplicationID: ts.Device.ApplicationID,
+		Name:          string(trigger.Type),
+		Enabled:       true,
+		Trigger:       trigger,
+		Actions: spec.Actions{
+			{Type: spec.WebhookAction, URL: "http://localhost/hook"},
+		},
+		Cooldown: time.Hour,
+	}
+	assert.NoError(storage.CreateAutomation(context.Background(), storage.DB(), &a))
+
+	return a
+}
+
+func (ts *AutomationTestSuite) executions(a storage.Automation) []storage.AutomationExecution {
+	assert := require.New(ts.T())
+
+	items, err := storage.GetAutomationExecutions(context.Background(), storage.DB(), a.ID, 10, 0)
+	assert.NoError(err)
+
+	return items
+}
+
+func (ts *AutomationTestSuite) TestHandleUplink() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	a := ts.createAutomation(spec.Trigger{
+		Type: spec.UplinkTrigger,
+		Conditions: rule.Conditions{
+			{Path: "moisture", Operator: rule.LessThan, Value: json.RawMessage(`20`)},
+		},
+	})
+
+	assert.NoError(HandleUplink(ctx, ts.Device, 2, []byte(`{"moisture": 25}`)))
+	assert.Len(ts.events, 0)
+
+	assert.NoError(HandleUplink(ctx, ts.Device, 2, []byte(`{"moisture": 15}`)))
+	assert.Len(ts.events, 1)
+	assert.Equal(a.ID, ts.events[0].AutomationID)
+	assert.Equal(spec.UplinkTrigger, ts.events[0].Trigger)
+	assert.Equal(ts.Device.DevEUI, ts.events[0].DevEUI)
+	assert.JSONEq(`{"moisture": 15}`, string(ts.events[0].Object))
+
+	// cooldown
+	assert.NoError(HandleUplink(ctx, ts.Device, 2, []byte(`{"moisture": 10}`)))
+	assert.Len(ts.events, 1)
+
+	executions := ts.executions(a)
+	assert.Len(executions, 1)
+	assert.True(executions[0].Success)
+	assert.Equal(spec.Results{{Type: spec.WebhookAction}}, executions[0].Results)
+}
+
+func (ts *AutomationTestSuite) TestHandleDeviceStatus() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	battery := float32(20)
+	a := ts.createAutomation(spec.Trigger{
+		Type:              spec.AlarmTrigger,
+		BatteryLevelBelow: &battery,
+	})
+
+	high := float32(80)
+	assert.NoError(HandleDeviceStatus(ctx, ts.Device, &high, 10))
+	assert.Len(ts.events, 0)
+
+	ts.fail = true
+	low := float32(10)
+	assert.NoError(HandleDeviceStatus(ctx, ts.Device, &low, 10))
+	assert.Len(ts.events, 1)
+	assert.Equal(&low, ts.events[0].BatteryLevel)
+
+	executions := ts.executions(a)
+	assert.Len(executions, 1)
+	assert.False(executions[0].Success)
+	assert.Equal(spec.Results{{Type: spec.WebhookAction, Error: "webhook error"}}, executions[0].Results)
+}
+
+func (ts *AutomationTestSuite) TestRunSchedule() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+	now := time.Now()
+
+	a := ts.createAutomation(spec.Trigger{
+		Type:            spec.ScheduleTrigger,
+		IntervalSeconds: 3600,
+	})
+
+	assert.NoError(Run(ctx, now))
+	assert.Len(ts.events, 1)
+	assert.Equal(spec.ScheduleTrigger, ts.events[0].Trigger)
+
+	assert.NoError(Run(ctx, now.Add(30*time.Minute)))
+	assert.Len(ts.events, 1)
+
+	assert.NoError(Run(ctx, now.Add(time.Hour)))
+	assert.Len(ts.events, 2)
+
+	assert.Len(ts.executions(a), 2)
+}
+
+func (ts *AutomationTestSuite) TestRunDeviceOffline() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+	now := time.Now()
+
+	a := ts.createAutomation(spec.Trigger{
+		Type:           spec.DeviceOfflineTrigger,
+		TimeoutSeconds: 3600,
+	})
+
+	lastSeenAt := now.Add(-10 * time.Minute)
+	assert.NoError(storage.UpdateDeviceLastSeenAndDR(ctx, storage.DB(), ts.Device.DevEUI, lastSeenAt, 0))
+
+	assert.NoError(Run(ctx, now))
+	assert.Len(ts.events, 0)
+
+	assert.NoError(Run(ctx, now.Add(time.Hour)))
+	assert.Len(ts.events, 1)
+	assert.Equal(spec.DeviceOfflineTrigger, ts.events[0].Trigger)
+	assert.True(ts.events[0].LastSeenAt.Equal(lastSeenAt))
+
+	// fires once while the device stays offline
+	assert.NoError(Run(ctx, now.Add(2*time.Hour)))
+	assert.Len(ts.events, 1)
+
+	assert.Len(ts.executions(a), 1)
+}
+
+func (ts *AutomationTestSuite) TestRunContactOpen() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+	now := time.Now()
+
+	a := ts.createAutomation(spec.Trigger{
+		Type:           spec.ContactOpenTrigger,
+		TimeoutSeconds: 600,
+		ContactName:    "door",
+	})
+	a.Alarm = true
+	assert.NoError(storage.UpdateAutomation(ctx, storage.DB(), &a))
+
+	openedAt := now.Add(-5 * time.Minute)
+	for _, name := range []string{"door", "hatch"} {
+		_, err := storage.UpdateContactState(ctx, storage.DB(), ts.Device.DevEUI, name, true, openedAt)
+		assert.NoError(err)
+	}
+
+	assert.NoError(Run(ctx, now))
+	assert.Len(ts.events, 0)
+
+	// only the door matches the trigger
+	assert.NoError(Run(ctx, now.Add(10*time.Minute)))
+	assert.Len(ts.events, 1)
+	assert.Equal(spec.ContactOpenTrigger, ts.events[0].Trigger)
+	assert.Equal("door", ts.events[0].Contact)
+	assert.True(ts.events[0].OpenSince.Equal(openedAt))
+	assert.NotNil(ts.events[0].AlarmID)
+	alarmID := *ts.events[0].AlarmID
+
+	// fires once while the contact stays open
+	assert.NoError(Run(ctx, now.Add(20*time.Minute)))
+	assert.Len(ts.events, 1)
+
+	// closing the hatch does not resolve the alarm
+	assert.NoError(HandleContactClosed(ctx, ts.Device, "hatch", openedAt))
+	alarm, err := storage.GetAutomationAlarm(ctx, storage.DB(), alarmID)
+	assert.NoError(err)
+	assert.Nil(alarm.ResolvedAt)
+
+	assert.NoError(HandleContactClosed(ctx, ts.Device, "door", openedAt))
+	alarm, err = storage.GetAutomationAlarm(ctx, storage.DB(), alarmID)
+	assert.NoError(err)
+	assert.NotNil(alarm.ResolvedAt)
+
+	assert.Len(ts.executions(a), 1)
+}
+
+func (ts *AutomationTestSuite) TestEscalation() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	webhook := spec.Action{Type: spec.WebhookAction, URL: "http://localhost/hook"}
+	a := ts.createAutomation(spec.Trigger{
+		Type: spec.UplinkTrigger,
+		Conditions: rule.Conditions{
+			{Path: "moisture", Operator: rule.LessThan, Value: json.RawMessage(`20`)},
+		},
+	})
+	a.Escalation = spec.Escalation{
+		{DelaySeconds: 60, Action: webhook},
+		{DelaySeconds: 120, Action: webhook},
+	}
+	assert.NoError(storage.UpdateAutomation(ctx, storage.DB(), &a))
+
+	assert.NoError(HandleUplink(ctx, ts.Device, 2, []byte(`{"moisture": 15}`)))
+	now := time.Now()
+	assert.Len(ts.events, 1)
+	assert.NotNil(ts.events[0].AlarmID)
+	alarmID := *ts.events[0].AlarmID
+
+	ts.T().Run("Escalate", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(Run(ctx, now))
+		assert.Len(ts.events, 1)
+
+		assert.NoError(Run(ctx, now.Add(time.Minute)))
+		assert.Len(ts.events, 2)
+		assert.Equal(&alarmID, ts.events[1].AlarmID)
+		assert.Equal(1, ts.events[1].EscalationLevel)
+
+		assert.NoError(Run(ctx, now.Add(2*time.Minute)))
+		assert.Len(ts.events, 2)
+
+		assert.NoError(Run(ctx, now.Add(3*time.Minute)))
+		assert.Len(ts.events, 3)
+		assert.Equal(2, ts.events[2].EscalationLevel)
+
+		// the escalation chain has been completed
+		assert.NoError(Run(ctx, now.Add(time.Hour)))
+		assert.Len(ts.events, 3)
+
+		alarm, err := storage.GetAutomationAlarm(ctx, storage.DB(), alarmID)
+		assert.NoError(err)
+		assert.Equal(2, alarm.EscalationLevel)
+		assert.Nil(alarm.NextEscalationAt)
+		assert.Nil(alarm.AcknowledgedAt)
+	})
+
+	ts.T().Run("Acknowledge", func(t *testing.T) {
+		assert := require.New(t)
+		ts.events = nil
+
+		assert.NoError(storage.AcknowledgeAutomationAlarm(ctx, storage.DB(), alarmID, "operator@example.com", ""))
+		assert.NoError(storage.RedisClient().FlushAll().Err())
+
+		// a new alarm is raised once the previous one was acknowledged
+		assert.NoError(HandleUplink(ctx, ts.Device, 2, []byte(`{"moisture": 15}`)))
+		now := time.Now()
+		assert.Len(ts.events, 1)
+		assert.NotNil(ts.events[0].AlarmID)
+		assert.NotEqual(alarmID, *ts.events[0].AlarmID)
+
+		assert.NoError(storage.AcknowledgeAutomationAlarm(ctx, storage.DB(), *ts.events[0].AlarmID, "operator@example.com", ""))
+
+		assert.NoError(Run(ctx, now.Add(time.Hour)))
+		assert.Len(ts.events, 1)
+	})
+}
+
+func (ts *AutomationTestSuite) TestAlarmHistory() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	a := ts.createAutomation(spec.Trigger{
+		Type:  spec.UplinkTrigger,
+		FPort: 2,
+		Conditions: rule.Conditions{
+			{Path: "temperature", Operator: rule.GreaterThan, Value: json.RawMessage(`8`)},
+		},
+	})
+	a.Alarm = true
+	assert.NoError(storage.UpdateAutomation(ctx, storage.DB(), &a))
+
+	assert.NoError(HandleUplink(ctx, ts.Device, 2, []byte(`{"temperature": 9.5}`)))
+	assert.Len(ts.events, 1)
+	assert.NotNil(ts.events[0].AlarmID)
+	alarmID := *ts.events[0].AlarmID
+
+	alarm, err := storage.GetAutomationAlarm(ctx, storage.DB(), alarmID)
+	assert.NoError(err)
+	assert.JSONEq(`{"object": {"temperature": 9.5}}`, string(alarm.TriggerValue))
+	assert.Nil(alarm.NextEscalationAt)
+	assert.Nil(alarm.ResolvedAt)
+
+	notifications, err := storage.GetAutomationAlarmNotifications(ctx, storage.DB(), alarmID)
+	assert.NoError(err)
+	assert.Len(notifications, 1)
+	assert.Equal(spec.WebhookAction, notifications[0].ActionType)
+	assert.Equal([]string{"http://localhost/hook"}, []string(notifications[0].Recipients))
+	assert.Equal(0, notifications[0].EscalationLevel)
+
+	// uplinks on an other fPort do not resolve the alarm
+	assert.NoError(HandleUplink(ctx, ts.Device, 3, []byte(`{"temperature": 6}`)))
+	alarm, err = storage.GetAutomationAlarm(ctx, storage.DB(), alarmID)
+	assert.NoError(err)
+	assert.Nil(alarm.ResolvedAt)
+
+	assert.NoError(HandleUplink(ctx, ts.Device, 2, []byte(`{"temperature": 6}`)))
+	assert.Len(ts.events, 1)
+
+	alarm, err = storage.GetAutomationAlarm(ctx, storage.DB(), alarmID)
+	assert.NoError(err)
+	assert.NotNil(alarm.ResolvedAt)
+	assert.JSONEq(`{"object": {"temperature": 6}}`, string(alarm.ResolveValue))
+	assert.Nil(alarm.AcknowledgedAt)
+}
+
+func (ts *AutomationTestSuite) TestThresholds() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	app, err := storage.GetApplication(ctx, storage.DB(), ts.Device.ApplicationID)
+	assert.NoError(err)
+
+	min := float64(2)
+	max := float64(8)
+	p := storage.ThresholdProfile{
+		OrganizationID: app.OrganizationID,
+		Name:           "cold room",
+		Thresholds: spec.Thresholds{
+			{Path: "temperature", Min: &min, Max: &max},
+		},
+	}
+	assert.NoError(storage.CreateThresholdProfile(ctx, storage.DB(), &p))
+
+	a := ts.createAutomation(spec.Trigger{
+		Type:               spec.ThresholdTrigger,
+		ThresholdProfileID: &p.ID,
+	})
+	a.Alarm = true
+	assert.NoError(storage.UpdateAutomation(ctx, storage.DB(), &a))
+
+	assert.NoError(HandleUplink(ctx, ts.Device, 2, []byte(`{"temperature": 5}`)))
+	assert.Len(ts.events, 0)
+
+	assert.NoError(HandleUplink(ctx, ts.Device, 2, []byte(`{"temperature": 9}`)))
+	assert.Len(ts.events, 1)
+	assert.Equal(spec.ThresholdTrigger, ts.events[0].Trigger)
+	assert.Equal([]string{"temperature"}, ts.events[0].Exceeded)
+	assert.NotNil(ts.events[0].AlarmID)
+	alarmID := *ts.events[0].AlarmID
+
+	// uplinks without the values of the profile do not resolve the alarm
+	assert.NoError(HandleUplink(ctx, ts.Device, 2, []byte(`{"battery": 3.3}`)))
+	alarm, err := storage.GetAutomationAlarm(ctx, storage.DB(), alarmID)
+	assert.NoError(err)
+	assert.Nil(alarm.ResolvedAt)
+
+	assert.NoError(HandleUplink(ctx, ts.Device, 2, []byte(`{"temperature": 7}`)))
+	alarm, err = storage.GetAutomationAlarm(ctx, storage.DB(), alarmID)
+	assert.NoError(err)
+	assert.NotNil(alarm.ResolvedAt)
+
+	// changing the profile changes the limits of the automation
+	assert.NoError(storage.RedisClient().FlushAll().Err())
+	max = 6
+	assert.NoError(storage.UpdateThresholdProfile(ctx, storage.DB(), &p))
+
+	assert.NoError(HandleUplink(ctx, ts.Device, 2, []byte(`{"temperature": 7}`)))
+	assert.Len(ts.events, 2)
+	assert.Equal([]string{"temperature"}, ts.events[1].Exceeded)
+}
+
+func (ts *AutomationTestSuite) TestMaintenance() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	webhook := spec.Action{Type: spec.WebhookAction, URL: "http://localhost/hook"}
+	a := ts.createAutomation(spec.Trigger{
+		Type: spec.UplinkTrigger,
+		Conditions: rule.Conditions{
+			{Path: "moisture", Operator: rule.LessThan, Value: json.RawMessage(`20`)},
+		},
+	})
+	a.Escalation = spec.Escalation{
+		{DelaySeconds: 60, Action: webhook},
+	}
+	assert.NoError(storage.UpdateAutomation(ctx, storage.DB(), &a))
+
+	w := storage.MaintenanceWindow{
+		ApplicationID: ts.Device.ApplicationID,
+		Name:          "sensor-calibration",
+		DevEUI:        &ts.Device.DevEUI,
+		StartsAt:      time.Now().Add(-time.Minute).Round(time.Second),
+		Duration:      time.Hour,
+		Recurrence:    storage.MaintenanceRecurrenceNone,
+	}
+	assert.NoError(storage.CreateMaintenanceWindow(ctx, storage.DB(), &w))
+
+	ts.T().Run("Suppressed", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(HandleUplink(ctx, ts.Device, 2, []byte(`{"moisture": 15}`)))
+		assert.Len(ts.events, 0)
+		assert.Len(ts.executions(a), 0)
+	})
+
+	ts.T().Run("Escalation postponed", func(t *testing.T) {
+		assert := require.New(t)
+
+		// alarm raised before the maintenance window started
+		next := time.Now()
+		alarm := storage.AutomationAlarm{
+			AutomationID:     a.ID,
+			DevEUI:           ts.Device.DevEUI,
+			NextEscalationAt: &next,
+		}
+		assert.NoError(storage.CreateAutomationAlarm(ctx, storage.DB(), &alarm))
+
+		assert.NoError(Run(ctx, next))
+		assert.Len(ts.events, 0)
+
+		alarm, err := storage.GetAutomationAlarm(ctx, storage.DB(), alarm.ID)
+		assert.NoError(err)
+		assert.Equal(0, alarm.EscalationLevel)
+		assert.True(alarm.NextEscalationAt.Equal(w.StartsAt.Add(w.Duration)))
+
+		assert.NoError(Run(ctx, *alarm.NextEscalationAt))
+		assert.Len(ts.events, 1)
+		assert.Equal(1, ts.events[0].EscalationLevel)
+	})
+
+	ts.T().Run("Maintenance ended", func(t *testing.T) {
+		assert := require.New(t)
+		ts.events = nil
+
+		assert.NoError(storage.DeleteMaintenanceWindow(ctx, storage.DB(), w.ID))
+
+		assert.NoError(HandleUplink(ctx, ts.Device, 2, []byte(`{"moisture": 15}`)))
+		assert.Len(ts.events, 1)
+	})
+}
+
+func (ts *AutomationTestSuite) TestDampening() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	conditions := rule.Conditions{
+		{Path: "temperature", Operator: rule.GreaterThan, Value: json.RawMessage(`8`)},
+	}
+
+	ts.T().Run("Consecutive violations", func(t *testing.T) {
+		assert := require.New(t)
+		ts.events = nil
+
+		a := ts.createAutomation(spec.Trigger{
+			Type:       spec.UplinkTrigger,
+			Conditions: conditions,
+		})
+		a.Dampening = spec.Dampening{
+			ConsecutiveViolations: 3,
+		}
+		assert.NoError(storage.UpdateAutomation(ctx, storage.DB(), &a))
+
+		assert.NoError(HandleUplink(ctx, ts.Device, 2, []byte(`{"temperature": 9}`)))
+		assert.NoError(HandleUplink(ctx, ts.Device, 2, []byte(`{"temperature": 9}`)))
+		assert.Len(ts.events, 0)
+
+		// a recovered value resets the violations
+		assert.NoError(HandleUplink(ctx, ts.Device, 2, []byte(`{"temperature": 6}`)))
+		assert.NoError(HandleUplink(ctx, ts.Device, 2, []byte(`{"temperature": 9}`)))
+		assert.NoError(HandleUplink(ctx, ts.Device, 2, []byte(`{"temperature": 9}`)))
+		assert.Len(ts.events, 0)
+
+		assert.NoError(HandleUplink(ctx, ts.Device, 2, []byte(`{"temperature": 9}`)))
+		assert.Len(ts.events, 1)
+
+		assert.NoError(storage.DeleteAutomation(ctx, storage.DB(), a.ID))
+	})
+
+	ts.T().Run("Renotify interval", func(t *testing.T) {
+		assert := require.New(t)
+		ts.events = nil
+
+		a := ts.createAutomation(spec.Trigger{
+			Type:       spec.UplinkTrigger,
+			Conditions: conditions,
+		})
+		a.Alarm = true
+		a.Cooldown = 0
+		a.Dampening = spec.Dampening{
+			RenotifySeconds: 3600,
+		}
+		assert.NoError(storage.UpdateAutomation(ctx, storage.DB(), &a))
+
+		assert.NoError(HandleUplink(ctx, ts.Device, 2, []byte(`{"temperature": 9}`)))
+		assert.NoError(HandleUplink(ctx, ts.Device, 2, []byte(`{"temperature": 6}`)))
+		assert.NoError(HandleUplink(ctx, ts.Device, 2, []byte(`{"temperature": 9}`)))
+		assert.Len(ts.events, 1)
+
+		// the alarm is raised, the notification is suppressed
+		executions := ts.executions(a)
+		assert.Len(executions, 2)
+		assert.True(executions[0].Results[0].Suppressed)
+		assert.False(executions[1].Results[0].Suppressed)
+
+		count, err := storage.GetAutomationAlarmCount(ctx, storage.DB(), storage.AutomationAlarmFilters{
+			AutomationID: a.ID,
+		})
+		assert.NoError(err)
+		assert.Equal(2, count)
+
+		assert.NoError(storage.DeleteAutomation(ctx, storage.DB(), a.ID))
+	})
+
+	ts.T().Run("Grouped", func(t *testing.T) {
+		assert := require.New(t)
+		ts.events = nil
+
+		var devices []storage.Device
+		for i := byte(1); i <= 2; i++ {
+			d := storage.Device{
+				DevEUI:          lorawan.EUI64{2, 2, 2, 2, 2, 2, 2, i},
+				ApplicationID:   ts.Device.ApplicationID,
+				DeviceProfileID: ts.Device.DeviceProfileID,
+				Name:            fmt.Sprintf("cold-room-%d", i),
+				Tags: hstore.Hstore{
+					Map: map[string]sql.NullString{
+						"zone": {String: "cold-room", Valid: true},
+					},
+				},
+			}
+			assert.NoError(storage.CreateDevice(ctx, storage.DB(), &d))
+			devices = append(devices, d)
+		}
+
+		a := ts.createAutomation(spec.Trigger{
+			Type:       spec.UplinkTrigger,
+			Conditions: conditions,
+		})
+		a.Alarm = true
+		a.Dampening = spec.Dampening{
+			GroupTagKey:  "zone",
+			GroupSeconds: 300,
+		}
+		assert.NoError(storage.UpdateAutomation(ctx, storage.DB(), &a))
+
+		for _, d := range devices {
+			assert.NoError(HandleUplink(ctx, d, 2, []byte(`{"temperature": 12}`)))
+		}
+		now := time.Now()
+
+		// devices without zone are notified immediately
+		assert.NoError(HandleUplink(ctx, ts.Device, 2, []byte(`{"temperature": 12}`)))
+		assert.Len(ts.events, 1)
+		assert.Equal(ts.Device.DevEUI, ts.events[0].DevEUI)
+
+		assert.NoError(Run(ctx, now))
+		assert.Len(ts.events, 1)
+
+		assert.NoError(Run(ctx, now.Add(5*time.Minute)))
+		assert.Len(ts.events, 2)
+
+		ev := ts.events[1]
+		assert.Equal("cold-room", ev.Zone)
+		assert.Equal(a.ID, ev.AutomationID)
+		assert.Len(ev.Grouped, 2)
+		assert.Equal("cold-room-1", ev.Grouped[0].DeviceName)
+		assert.Equal("cold-room-2", ev.Grouped[1].DeviceName)
+
+		for _, gev := range ev.Grouped {
+			assert.NotNil(gev.AlarmID)
+			notifications, err := storage.GetAutomationAlarmNotifications(ctx, storage.DB(), *gev.AlarmID)
+			assert.NoError(err)
+			assert.Len(notifications, 1)
+		}
+
+		assert.NoError(Run(ctx, now.Add(10*time.Minute)))
+		assert.Len(ts.events, 2)
+	})
+}
+
+func (ts *AutomationTestSuite) TestNotificationRoutes() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	app, err := storage.GetApplication(ctx, storage.DB(), ts.Device.ApplicationID)
+	assert.NoError(err)
+
+	routes := []storage.NotificationRoute{
+		{
+			OrganizationID: app.OrganizationID,
+			Name:           "in-app",
+			MinSeverity:    spec.InfoSeverity,
+			Channel:        spec.InAppChannel,
+		},
+		{
+			OrganizationID: app.OrganizationID,
+			ApplicationID:  &app.ID,
+			Name:           "on-call",
+			MinSeverity:    spec.CriticalSeverity,
+			Channel:        spec.WebhookChannel,
+			Recipients:     []string{"http://localhost/on-call"},
+		},
+	}
+	for i := range routes {
+		assert.NoError(storage.CreateNotificationRoute(ctx, storage.DB(), &routes[i]))
+	}
+
+	conditions := rule.Conditions{
+		{Path: "temperature", Operator: rule.GreaterThan, Value: json.RawMessage(`8`)},
+	}
+
+	tests := []struct {
+		severity spec.Severity
+		events   int
+		inApp    int
+	}{
+		{spec.WarningSeverity, 1, 1},
+		{spec.CriticalSeverity, 2, 2},
+	}
+
+	for _, tst := range tests {
+		ts.T().Run(string(tst.severity), func(t *testing.T) {
+			assert := require.New(t)
+			ts.events = nil
+
+			a := ts.createAutomation(spec.Trigger{
+				Type:       spec.UplinkTrigger,
+				Conditions: conditions,
+			})
+			a.Alarm = true
+			a.Severity = tst.severity
+			assert.NoError(storage.UpdateAutomation(ctx, storage.DB(), &a))
+
+			assert.NoError(HandleUplink(ctx, ts.Device, 2, []byte(`{"temperature": 9}`)))
+			assert.Len(ts.events, tst.events)
+			assert.Equal(tst.severity, ts.events[0].Severity)
+
+			alarms, err := storage.GetAutomationAlarms(ctx, storage.DB(), storage.AutomationAlarmFilters{
+				AutomationID: a.ID,
+				Limit:        10,
+			})
+			assert.NoError(err)
+			assert.Len(alarms, 1)
+			assert.Equal(tst.severity, alarms[0].Severity)
+
+			// the automation webhook, the in-app and the on-call notifications
+			notifications, err := storage.GetAutomationAlarmNotifications(ctx, storage.DB(), alarms[0].ID)
+			assert.NoError(err)
+			assert.Len(notifications, tst.events+1)
+
+			items, err := storage.GetInAppNotifications(ctx, storage.DB(), storage.InAppNotificationFilters{
+				ApplicationID: app.ID,
+				Limit:         10,
+			})
+			assert.NoError(err)
+			assert.Len(items, tst.inApp)
+			assert.Equal(tst.severity, items[0].Severity)
+			assert.Equal(alarms[0].ID, *items[0].AlarmID)
+			assert.Equal(fmt.Sprintf("[%s] UPLINK", tst.severity), items[0].Subject)
+
+			assert.NoError(storage.DeleteAutomation(ctx, storage.DB(), a.ID))
+		})
+	}
+}
+
+func TestAutomation(t *testing.T) {
+	suite.Run(t, new(AutomationTestSuite))
+}
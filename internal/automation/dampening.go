@@ -0,0 +1,219 @@
+package automation
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// notifyMode defines how the notification actions of an execution are
+// handled.
+type notifyMode int
+
+// Available notify modes.
+const (
+	notifySend notifyMode = iota
+	notifySuppress
+	notifyGroup
+)
+
+// recovered handles an event for which the trigger of the automation no
+// longer matches for the device: the consecutive violations are reset and
+// the alarms are resolved.
+func recovered(ctx context.Context, a storage.Automation, d storage.Device, ev Event) error {
+	if err := storage.ResetAutomationViolations(ctx, a, d.DevEUI); err != nil {
+		return errors.Wrap(err, "reset automation violations error")
+	}
+
+	return resolveAlarms(ctx, a, d, ev)
+}
+
+// dampen returns how the notification actions (of the given actions) of the
+// execution of the automation for the device must be handled. The
+// notifications are suppressed when the device has been notified within the
+// renotify interval. When the device belongs to a zone (see groupZone), the
+// event is added to the grouped notification of the zone, which is sent by
+// runNotificationGroups.
+func dampen(ctx context.Context, a storage.Automation, d storage.Device, actions spec.Actions, ev Event) (notifyMode, error) {
+	var notifies bool
+	for _, action := range actions {
+		if action.Notifies() {
+			notifies = true
+		}
+	}
+	if !notifies {
+		return notifySend, nil
+	}
+
+	ok, err := storage.CheckAutomationRenotify(ctx, a, d.DevEUI)
+	if err != nil {
+		return notifySend, errors.Wrap(err, "check automation renotify error")
+	}
+	if !ok {
+		return notifySuppress, nil
+	}
+
+	zone := groupZone(a, d)
+	if zone == "" {
+		return notifySend, nil
+	}
+
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return notifySend, errors.Wrap(err, "marshal json error")
+	}
+
+	if err := storage.AddAutomationNotificationGroupEvent(ctx, storage.DB(), a.ID, zone, ev.Time.Add(a.Dampening.GroupWindow()), b); err != nil {
+		return notifySend, errors.Wrap(err, "add automation notification group event error")
+	}
+
+	return notifyGroup, nil
+}
+
+// groupZone returns the zone of the device of which the notifications are
+// grouped, this is the value of the group tag of the dampening. It returns
+// an empty string when the notifications are not grouped.
+func groupZone(a storage.Automation, d storage.Device) string {
+	if a.Dampening.GroupTagKey == "" {
+		return ""
+	}
+
+	return d.Tags.Map[a.Dampening.GroupTagKey].String
+}
+
+// runNotificationGroups sends the grouped notifications which are due.
+func runNotificationGroups(ctx context.Context, now time.Time) error {
+	for {
+		var groups []storage.AutomationNotificationGroup
+
+		// the groups are removed within the transaction, the notifications
+		// are sent afterwards so that these do not hold the locks
+		err := storage.Transaction(func(tx sqlx.Ext) error {
+			var err error
+			groups, err = storage.GetDueAutomationNotificationGroups(ctx, tx, now, batchSize)
+			if err != nil {
+				return errors.Wrap(err, "get due automation notification groups error")
+			}
+
+			for _, g := range groups {
+				if err := storage.DeleteAutomationNotificationGroup(ctx, tx, g.ID); err != nil {
+					return errors.Wrap(err, "delete automation notification group error")
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, g := range groups {
+			if err := sendNotificationGroup(ctx, g); err != nil {
+				return err
+			}
+		}
+
+		if len(groups) < batchSize {
+			return nil
+		}
+	}
+}
+
+// sendNotificationGroup executes the notification actions of the
+// automation once for all the events of the group. A group with a single
+// event is sent as the event of that device. As the group has already been
+// removed, a failing action is only logged. The groups of a disabled
+// automation are dropped.
+func sendNotificationGroup(ctx context.Context, g storage.AutomationNotificationGroup) error {
+	a, err := storage.GetAutomation(ctx, storage.DB(), g.AutomationID)
+	if err != nil {
+		// the automation has been deleted since
+		if errors.Cause(err) == storage.ErrDoesNotExist {
+			return nil
+		}
+		return errors.Wrap(err, "get automation error")
+	}
+
+	var events []Event
+	if err := json.Unmarshal(g.Events, &events); err != nil {
+		return errors.Wrap(err, "unmarshal events error")
+	}
+
+	if !a.Enabled || len(events) == 0 {
+		return nil
+	}
+
+	var alarm bool
+	for _, e := range events {
+		if e.AlarmID != nil {
+			alarm = true
+		}
+	}
+
+	actions, err := alarmActions(ctx, a, alarm)
+	if err != nil {
+		return err
+	}
+
+	ev := events[0]
+	if len(events) > 1 {
+		ev = Event{
+			AutomationID:   a.ID,
+			AutomationName: a.Name,
+			ApplicationID:  a.ApplicationID,
+			Trigger:        a.Trigger.Type,
+			Severity:       a.Severity,
+			Zone:           g.Zone,
+			Grouped:        events,
+			Time:           time.Now(),
+		}
+	}
+	d := storage.Device{
+		DevEUI:        ev.DevEUI,
+		ApplicationID: a.ApplicationID,
+		Name:          ev.DeviceName,
+	}
+
+	fields := log.Fields{
+		"automation_id": a.ID,
+		"zone":          g.Zone,
+		"events":        len(events),
+		"ctx_id":        ctx.Value(logging.ContextIDKey),
+	}
+
+	log.WithFields(fields).Info("automation: sending grouped notification")
+
+	for _, action := range actions {
+		if !action.Notifies() {
+			continue
+		}
+
+		handler, ok := actionHandlers[action.Type]
+		if !ok {
+			log.WithFields(fields).WithField("action", action.Type).Error("automation: unknown action type")
+			continue
+		}
+
+		var actionErr string
+		if err := handler(ctx, action, d, ev); err != nil {
+			log.WithError(err).WithFields(fields).WithField("action", action.Type).Error("automation: action error")
+			actionErr = err.Error()
+		}
+
+		for _, e := range events {
+			if err := recordNotification(ctx, action, e, actionErr); err != nil {
+				log.WithError(err).WithFields(fields).Error("automation: record alarm notification error")
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package automation
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// escalation holds an escalation step which is due for an alarm.
+type escalation struct {
+	alarm      storage.AutomationAlarm
+	automation storage.Automation
+	device     storage.Device
+	step       spec.EscalationStep
+}
+
+// runEscalations executes the escalation steps which are due for the open
+// alarms. The escalation of the alarms of a disabled
+// automation is stopped, the escalation of the alarms of a device in
+// maintenance is postponed until the end of the maintenance.
+func runEscalations(ctx context.Context, now time.Time) error {
+	for {
+		var count int
+		var escalations []escalation
+
+		// the escalation state is updated within the transaction, the
+		// actions are executed afterwards so that these do not hold the
+		// locks
+		err := storage.Transaction(func(tx sqlx.Ext) error {
+			alarms, err := storage.GetDueAutomationAlarms(ctx, tx, now, batchSize)
+			if err != nil {
+				return errors.Wrap(err, "get due automation alarms error")
+			}
+			count = len(alarms)
+
+			for _, alarm := range alarms {
+				a, err := storage.GetAutomation(ctx, tx, alarm.AutomationID)
+				if err != nil {
+					return errors.Wrap(err, "get automation error")
+				}
+
+				d, err := storage.GetDevice(ctx, tx, alarm.DevEUI, false, true)
+				if err != nil {
+					return errors.Wrap(err, "get device error")
+				}
+
+				end, maintenance, err := storage.GetDeviceMaintenanceEnd(ctx, tx, d, now)
+				if err != nil {
+					return errors.Wrap(err, "get device maintenance end error")
+				}
+				if maintenance {
+					alarm.NextEscalationAt = &end
+					if err := storage.UpdateAutomationAlarmEscalation(ctx, tx, &alarm); err != nil {
+						return errors.Wrap(err, "update automation alarm escalation error")
+					}
+					continue
+				}
+
+				alarm.NextEscalationAt = nil
+
+				// the escalation chain might have been shortened since the
+				// alarm was raised
+				if a.Enabled && alarm.EscalationLevel < len(a.Escalation) {
+					escalations = append(escalations, escalation{
+						alarm:      alarm,
+						automation: a,
+						device:     d,
+						step:       a.Escalation[alarm.EscalationLevel],
+					})
+
+					alarm.EscalationLevel++
+					alarm.EscalatedAt = &now
+					if alarm.EscalationLevel < len(a.Escalation) {
+						next := now.Add(a.Escalation[alarm.EscalationLevel].Delay())
+						alarm.NextEscalationAt = &next
+					}
+				}
+
+				if err := storage.UpdateAutomationAlarmEscalation(ctx, tx, &alarm); err != nil {
+					return errors.Wrap(err, "update automation alarm escalation error")
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, e := range escalations {
+			escalate(ctx, e)
+		}
+
+		if count < batchSize {
+			return nil
+		}
+	}
+}
+
+// escalate executes the action of the escalation step. As the next step is
+// already scheduled, a failing action is only logged.
+func escalate(ctx context.Context, e escalation) {
+	d := e.device
+	ev := newEvent(e.automation, d, Event{
+		AlarmID:         &e.alarm.ID,
+		EscalationLevel: e.alarm.EscalationLevel + 1,
+	})
+
+	fields := log.Fields{
+		"automation_id":    e.automation.ID,
+		"alarm_id":         e.alarm.ID,
+		"dev_eui":          d.DevEUI,
+		"escalation_level": ev.EscalationLevel,
+		"ctx_id":           ctx.Value(logging.ContextIDKey),
+	}
+
+	log.WithFields(fields).Info("automation: escalating alarm")
+
+	handler, ok := actionHandlers[e.step.Action.Type]
+	if !ok {
+		log.WithFields(fields).WithField("action", e.step.Action.Type).Error("automation: unknown escalation action type")
+		return
+	}
+
+	var actionErr string
+	if err := handler(ctx, e.step.Action, d, ev); err != nil {
+		log.WithError(err).WithFields(fields).WithField("action", e.step.Action.Type).Error("automation: escalation action error")
+		actionErr = err.Error()
+	}
+
+	if err := recordNotification(ctx, e.step.Action, ev, actionErr); err != nil {
+		log.WithError(err).WithFields(fields).Error("automation: record alarm notification error")
+	}
+}
@@ -0,0 +1,92 @@
+package spec
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// maxConsecutiveViolations defines the max. number of consecutive
+	// violations before the trigger fires.
+	maxConsecutiveViolations = 100
+
+	// maxGroupWindow defines the max. window within which the
+	// notifications of a zone are grouped.
+	maxGroupWindow = time.Hour
+)
+
+// Dampening defines how an automation prevents notification storms, e.g.
+// when a cold room fails and all its sensors exceed their limits at once:
+//
+// ConsecutiveViolations defines the number of consecutive uplinks or
+// device-status reports matching the trigger before the trigger fires. It
+// only applies to the UPLINK, THRESHOLD and ALARM triggers, 0 or 1 fires on
+// the first violation.
+//
+// RenotifySeconds defines the min. interval between the notifications (see
+// Action.Notifies) sent for a device. The other actions are still executed
+// and alarms are still raised.
+//
+// When GroupTagKey is set, the notifications of the devices having the same
+// value for this tag (e.g. "zone") are collapsed into a single grouped
+// notification, sent GroupSeconds after the first device of the zone
+// fired. Devices without this tag are notified immediately.
+type Dampening struct {
+	ConsecutiveViolations int    `json:"consecutiveViolations,omitempty"`
+	RenotifySeconds       int64  `json:"renotifySeconds,omitempty"`
+	GroupTagKey           string `json:"groupTagKey,omitempty"`
+	GroupSeconds          int64  `json:"groupSeconds,omitempty"`
+}
+
+// Validate validates the dampening for the given trigger type. An empty
+// dampening is valid.
+func (d Dampening) Validate(triggerType TriggerType) error {
+	if d.ConsecutiveViolations < 0 || d.ConsecutiveViolations > maxConsecutiveViolations {
+		return fmt.Errorf("consecutive violations must be between 0 and %d", maxConsecutiveViolations)
+	}
+	if d.ConsecutiveViolations > 1 && triggerType != UplinkTrigger && triggerType != ThresholdTrigger && triggerType != AlarmTrigger {
+		return fmt.Errorf("consecutive violations only apply to the %s, %s and %s triggers", UplinkTrigger, ThresholdTrigger, AlarmTrigger)
+	}
+
+	if d.RenotifySeconds < 0 {
+		return errors.New("renotify interval must not be negative")
+	}
+
+	if strings.TrimSpace(d.GroupTagKey) == "" && d.GroupSeconds != 0 {
+		return errors.New("group tag key is required")
+	}
+	if d.GroupTagKey != "" && (d.GroupSeconds <= 0 || d.GroupWindow() > maxGroupWindow) {
+		return fmt.Errorf("group window must be between 0s and %s", maxGroupWindow)
+	}
+
+	return nil
+}
+
+// Renotify returns the min. interval between the notifications of a device.
+func (d Dampening) Renotify() time.Duration {
+	return time.Duration(d.RenotifySeconds) * time.Second
+}
+
+// GroupWindow returns the window within which the notifications of a zone
+// are grouped.
+func (d Dampening) GroupWindow() time.Duration {
+	return time.Duration(d.GroupSeconds) * time.Second
+}
+
+// Value implements the driver.Valuer interface.
+func (d Dampening) Value() (driver.Value, error) {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (d *Dampening) Scan(src interface{}) error {
+	return scanJSON(src, d)
+}
@@ -0,0 +1,93 @@
+package spec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDampeningValidate(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Dampening     Dampening
+		TriggerType   TriggerType
+		ExpectedError string
+	}{
+		{
+			Name:        "empty",
+			TriggerType: ScheduleTrigger,
+		},
+		{
+			Name: "valid",
+			Dampening: Dampening{
+				ConsecutiveViolations: 3,
+				RenotifySeconds:       3600,
+				GroupTagKey:           "zone",
+				GroupSeconds:          300,
+			},
+			TriggerType: ThresholdTrigger,
+		},
+		{
+			Name: "too many consecutive violations",
+			Dampening: Dampening{
+				ConsecutiveViolations: 101,
+			},
+			TriggerType:   UplinkTrigger,
+			ExpectedError: "consecutive violations must be between 0 and 100",
+		},
+		{
+			Name: "consecutive violations of device offline trigger",
+			Dampening: Dampening{
+				ConsecutiveViolations: 2,
+			},
+			TriggerType:   DeviceOfflineTrigger,
+			ExpectedError: "consecutive violations only apply to the UPLINK, THRESHOLD and ALARM triggers",
+		},
+		{
+			Name: "negative renotify interval",
+			Dampening: Dampening{
+				RenotifySeconds: -1,
+			},
+			TriggerType:   AlarmTrigger,
+			ExpectedError: "renotify interval must not be negative",
+		},
+		{
+			Name: "group window without tag key",
+			Dampening: Dampening{
+				GroupSeconds: 300,
+			},
+			TriggerType:   UplinkTrigger,
+			ExpectedError: "group tag key is required",
+		},
+		{
+			Name: "group window too long",
+			Dampening: Dampening{
+				GroupTagKey:  "zone",
+				GroupSeconds: 7200,
+			},
+			TriggerType:   UplinkTrigger,
+			ExpectedError: "group window must be between 0s and 1h0m0s",
+		},
+		{
+			Name: "group tag key without window",
+			Dampening: Dampening{
+				GroupTagKey: "zone",
+			},
+			TriggerType:   UplinkTrigger,
+			ExpectedError: "group window must be between 0s and 1h0m0s",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			err := tst.Dampening.Validate(tst.TriggerType)
+			if tst.ExpectedError != "" {
+				assert.EqualError(err, tst.ExpectedError)
+				return
+			}
+			assert.NoError(err)
+		})
+	}
+}
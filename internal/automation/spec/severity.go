@@ -0,0 +1,93 @@
+package spec
+
+import "fmt"
+
+// Severity defines the severity of the alarms raised by an automation.
+type Severity string
+
+// Available severities, in increasing order.
+const (
+	InfoSeverity     Severity = "INFO"
+	WarningSeverity  Severity = "WARNING"
+	CriticalSeverity Severity = "CRITICAL"
+)
+
+// severityLevels contains the level of each severity.
+var severityLevels = map[Severity]int{
+	InfoSeverity:     1,
+	WarningSeverity:  2,
+	CriticalSeverity: 3,
+}
+
+// Validate validates the severity.
+func (s Severity) Validate() error {
+	if _, ok := severityLevels[s]; !ok {
+		return fmt.Errorf("severity must be %s, %s or %s", InfoSeverity, WarningSeverity, CriticalSeverity)
+	}
+
+	return nil
+}
+
+// AtLeast returns true when the severity is at least the given severity.
+func (s Severity) AtLeast(min Severity) bool {
+	return severityLevels[s] >= severityLevels[min]
+}
+
+// Channel defines the channel of a notification route.
+type Channel string
+
+// Available channels.
+const (
+	EmailChannel   Channel = "EMAIL"
+	SMSChannel     Channel = "SMS"
+	WebhookChannel Channel = "WEBHOOK"
+	InAppChannel   Channel = "IN_APP"
+)
+
+// Actions returns the actions delivering a notification with the given
+// subject over the channel to the recipients: e-mail addresses for EMAIL,
+// phone numbers for SMS and URLs for WEBHOOK (one action per URL). IN_APP
+// does not have recipients. It returns nil for an unknown channel.
+func (c Channel) Actions(recipients []string, subject string) Actions {
+	switch c {
+	case EmailChannel:
+		return Actions{{Type: NotificationAction, To: recipients, Subject: subject}}
+	case SMSChannel:
+		return Actions{{Type: SMSAction, To: recipients}}
+	case WebhookChannel:
+		var out Actions
+		for _, u := range recipients {
+			out = append(out, Action{Type: WebhookAction, URL: u})
+		}
+		return out
+	case InAppChannel:
+		return Actions{{Type: InAppAction, Subject: subject}}
+	default:
+		return nil
+	}
+}
+
+// Validate validates the channel and its recipients.
+func (c Channel) Validate(recipients []string) error {
+	switch c {
+	case EmailChannel, SMSChannel:
+	case WebhookChannel:
+		if len(recipients) == 0 || len(recipients) > maxRecipients {
+			return fmt.Errorf("between 1 and %d recipients are required", maxRecipients)
+		}
+	case InAppChannel:
+		if len(recipients) != 0 {
+			return fmt.Errorf("%s does not have recipients", InAppChannel)
+		}
+	default:
+		return fmt.Errorf("channel must be %s, %s, %s or %s", EmailChannel, SMSChannel, WebhookChannel, InAppChannel)
+	}
+
+	for _, a := range c.Actions(recipients, "notification") {
+		if err := a.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package spec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeverity(t *testing.T) {
+	assert := require.New(t)
+
+	assert.NoError(CriticalSeverity.Validate())
+	assert.EqualError(Severity("MAJOR").Validate(), "severity must be INFO, WARNING or CRITICAL")
+
+	assert.True(CriticalSeverity.AtLeast(WarningSeverity))
+	assert.True(WarningSeverity.AtLeast(WarningSeverity))
+	assert.False(InfoSeverity.AtLeast(WarningSeverity))
+}
+
+func TestChannelValidate(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Channel       Channel
+		Recipients    []string
+		ExpectedError string
+	}{
+		{
+			Name:       "valid email",
+			Channel:    EmailChannel,
+			Recipients: []string{"operator@example.com"},
+		},
+		{
+			Name:          "invalid email",
+			Channel:       EmailChannel,
+			Recipients:    []string{"operator"},
+			ExpectedError: "invalid recipient: 'operator'",
+		},
+		{
+			Name:       "valid sms",
+			Channel:    SMSChannel,
+			Recipients: []string{"+31612345678", "+14155550100"},
+		},
+		{
+			Name:          "sms without recipients",
+			Channel:       SMSChannel,
+			ExpectedError: "between 1 and 10 recipients are required",
+		},
+		{
+			Name:       "valid webhook",
+			Channel:    WebhookChannel,
+			Recipients: []string{"https://example.com/pager"},
+		},
+		{
+			Name:          "webhook without recipients",
+			Channel:       WebhookChannel,
+			ExpectedError: "between 1 and 10 recipients are required",
+		},
+		{
+			Name:          "invalid webhook url",
+			Channel:       WebhookChannel,
+			Recipients:    []string{"example.com"},
+			ExpectedError: "url must be a valid http(s) url",
+		},
+		{
+			Name:    "valid in-app",
+			Channel: InAppChannel,
+		},
+		{
+			Name:          "in-app with recipients",
+			Channel:       InAppChannel,
+			Recipients:    []string{"operator@example.com"},
+			ExpectedError: "IN_APP does not have recipients",
+		},
+		{
+			Name:          "invalid channel",
+			Channel:       "PAGER",
+			ExpectedError: "channel must be EMAIL, SMS, WEBHOOK or IN_APP",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			err := tst.Channel.Validate(tst.Recipients)
+			if tst.ExpectedError != "" {
+				assert.EqualError(err, tst.ExpectedError)
+				return
+			}
+			assert.NoError(err)
+		})
+	}
+}
+
+func TestChannelActions(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal(Actions{
+		{Type: WebhookAction, URL: "https://example.com/a"},
+		{Type: WebhookAction, URL: "https://example.com/b"},
+	}, WebhookChannel.Actions([]string{"https://example.com/a", "https://example.com/b"}, "alarm"))
+
+	assert.Equal(Actions{
+		{Type: NotificationAction, To: []string{"operator@example.com"}, Subject: "alarm"},
+	}, EmailChannel.Actions([]string{"operator@example.com"}, "alarm"))
+}
@@ -0,0 +1,460 @@
+// Package spec implements the triggers and actions of the automations. An
+// automation executes its actions for a device when the trigger of the
+// automation fires for that device.
+package spec
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/ibrahimozekici/app-server2/internal/downlink/rule"
+	"github.com/ibrahimozekici/app-server2/internal/downlink/template"
+)
+
+// TriggerType defines the trigger type.
+type TriggerType string
+
+// Available trigger types.
+const (
+	UplinkTrigger        TriggerType = "UPLINK"
+	ScheduleTrigger      TriggerType = "SCHEDULE"
+	DeviceOfflineTrigger TriggerType = "DEVICE_OFFLINE"
+	AlarmTrigger         TriggerType = "ALARM"
+	ThresholdTrigger     TriggerType = "THRESHOLD"
+	ContactOpenTrigger   TriggerType = "CONTACT_OPEN"
+)
+
+// ActionType defines the action type.
+type ActionType string
+
+// Available action types.
+const (
+	DownlinkAction     ActionType = "DOWNLINK"
+	NotificationAction ActionType = "NOTIFICATION"
+	WebhookAction      ActionType = "WEBHOOK"
+	TagAction          ActionType = "TAG"
+	SMSAction          ActionType = "SMS"
+	InAppAction        ActionType = "IN_APP"
+)
+
+const (
+	// maxActions defines the max. number of actions of an automation.
+	maxActions = 8
+
+	// maxRecipients defines the max. number of notification recipients.
+	maxRecipients = 10
+
+	// maxEscalationSteps defines the max. number of escalation steps of an
+	// automation.
+	maxEscalationSteps = 8
+
+	// minInterval defines the min. schedule interval, offline timeout and
+	// contact open duration.
+	minInterval = time.Minute
+)
+
+// phoneNumberRegexp matches phone numbers in E.164 format.
+var phoneNumberRegexp = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// Trigger defines when the actions of an automation are executed. Only the
+// fields of the trigger type are used:
+//
+// UPLINK fires when the decoded object of an uplink matches all the
+// conditions. An FPort of 0 matches uplinks on any fPort.
+//
+// SCHEDULE fires every IntervalSeconds for each device of the automation.
+//
+// DEVICE_OFFLINE fires once when a device has not been seen for
+// TimeoutSeconds. It fires again after the device has been seen again.
+//
+// ALARM fires when the device-status reported by a device has a battery
+// level (in percent) below BatteryLevelBelow or a link margin (in dB) below
+// MarginBelow. At least one of these must be set.
+//
+// THRESHOLD fires when a value of the decoded object of an uplink is outside
+// the limits of the threshold profile ThresholdProfileID. This way the
+// limits can be changed for all automations using the profile at once. An
+// FPort of 0 matches uplinks on any fPort.
+//
+// CONTACT_OPEN fires once when a contact sensor (a CONTACT measurement of the
+// device-profile, e.g. a door) has been open for TimeoutSeconds. When
+// ContactName is set, only the contact with this measurement name is
+// evaluated. It fires again after the contact has been closed and opened.
+type Trigger struct {
+	Type TriggerType `json:"type"`
+
+	FPort      uint8           `json:"fPort,omitempty"`
+	Conditions rule.Conditions `json:"conditions,omitempty"`
+
+	IntervalSeconds int64 `json:"intervalSeconds,omitempty"`
+
+	TimeoutSeconds int64 `json:"timeoutSeconds,omitempty"`
+
+	BatteryLevelBelow *float32 `json:"batteryLevelBelow,omitempty"`
+	MarginBelow       *int     `json:"marginBelow,omitempty"`
+
+	ThresholdProfileID *uuid.UUID `json:"thresholdProfileID,omitempty"`
+
+	ContactName string `json:"contactName,omitempty"`
+}
+
+// Validate validates the trigger.
+func (t Trigger) Validate() error {
+	switch t.Type {
+	case UplinkTrigger:
+		if t.FPort > 223 {
+			return errors.New("uplink fPort must be between 0 (any) and 223")
+		}
+		if err := t.Conditions.Validate(); err != nil {
+			return fmt.Errorf("conditions: %s", err)
+		}
+	case ScheduleTrigger:
+		if t.Interval() < minInterval {
+			return fmt.Errorf("interval must be at least %s", minInterval)
+		}
+	case DeviceOfflineTrigger:
+		if t.Timeout() < minInterval {
+			return fmt.Errorf("timeout must be at least %s", minInterval)
+		}
+	case AlarmTrigger:
+		if t.BatteryLevelBelow == nil && t.MarginBelow == nil {
+			return errors.New("battery level or margin threshold is required")
+		}
+		if t.BatteryLevelBelow != nil && (*t.BatteryLevelBelow <= 0 || *t.BatteryLevelBelow > 100) {
+			return errors.New("battery level threshold must be between 0 and 100")
+		}
+	case ThresholdTrigger:
+		if t.FPort > 223 {
+			return errors.New("uplink fPort must be between 0 (any) and 223")
+		}
+		if t.ThresholdProfileID == nil || *t.ThresholdProfileID == uuid.Nil {
+			return errors.New("threshold profile is required")
+		}
+	case ContactOpenTrigger:
+		if t.Timeout() < minInterval {
+			return fmt.Errorf("open duration must be at least %s", minInterval)
+		}
+	default:
+		return fmt.Errorf("invalid trigger type: '%s'", t.Type)
+	}
+
+	return nil
+}
+
+// Interval returns the schedule interval.
+func (t Trigger) Interval() time.Duration {
+	return time.Duration(t.IntervalSeconds) * time.Second
+}
+
+// Timeout returns the device offline timeout or the max. open duration of
+// the CONTACT_OPEN trigger.
+func (t Trigger) Timeout() time.Duration {
+	return time.Duration(t.TimeoutSeconds) * time.Second
+}
+
+// MatchUplink returns true when the UPLINK trigger matches the given uplink.
+func (t Trigger) MatchUplink(fPort uint8, objectJSON []byte) (bool, error) {
+	if t.Type != UplinkTrigger || !t.MatchFPort(fPort) {
+		return false, nil
+	}
+
+	return t.Conditions.MatchJSON(objectJSON)
+}
+
+// MatchFPort returns true when the UPLINK or THRESHOLD trigger applies to
+// uplinks on the given fPort.
+func (t Trigger) MatchFPort(fPort uint8) bool {
+	return t.FPort == 0 || t.FPort == fPort
+}
+
+// MatchStatus returns true when the ALARM trigger matches the given
+// device-status. The batteryLevel is nil when the battery level is not
+// available (e.g. external power-source).
+func (t Trigger) MatchStatus(batteryLevel *float32, margin int) bool {
+	if t.Type != AlarmTrigger {
+		return false
+	}
+
+	if t.BatteryLevelBelow != nil && batteryLevel != nil && *batteryLevel < *t.BatteryLevelBelow {
+		return true
+	}
+
+	if t.MarginBelow != nil && margin < *t.MarginBelow {
+		return true
+	}
+
+	return false
+}
+
+// Value implements the driver.Valuer interface.
+func (t Trigger) Value() (driver.Value, error) {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (t *Trigger) Scan(src interface{}) error {
+	return scanJSON(src, t)
+}
+
+// Action defines an action of an automation. Only the fields of the action
+// type are used:
+//
+// DOWNLINK enqueues the Object (JSON object encoded by the codec) for the
+// device on the given FPort.
+//
+// NOTIFICATION sends an e-mail with the given Subject and Message to the
+// given recipients.
+//
+// WEBHOOK posts the automation event as JSON to the given URL.
+//
+// TAG sets the device tag Key to the given Value, or removes the tag when
+// Remove is set.
+//
+// SMS sends a text message to the given recipients (phone numbers in E.164
+// format, e.g. +31612345678).
+//
+// IN_APP stores an in-app notification for the application, with the given
+// Subject and Message (both optional).
+type Action struct {
+	Type ActionType `json:"type"`
+
+	FPort     uint8  `json:"fPort,omitempty"`
+	Confirmed bool   `json:"confirmed,omitempty"`
+	Object    string `json:"object,omitempty"`
+
+	To      []string `json:"to,omitempty"`
+	Subject string   `json:"subject,omitempty"`
+	Message string   `json:"message,omitempty"`
+
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+
+	Key    string `json:"key,omitempty"`
+	Value  string `json:"value,omitempty"`
+	Remove bool   `json:"remove,omitempty"`
+}
+
+// Validate validates the action.
+func (a Action) Validate() error {
+	switch a.Type {
+	case DownlinkAction:
+		if a.FPort == 0 || a.FPort > 223 {
+			return errors.New("fPort must be between 1 and 223")
+		}
+		if err := template.Validate(a.Object); err != nil {
+			return errors.New("object must be a valid JSON object")
+		}
+	case NotificationAction:
+		if len(a.To) == 0 || len(a.To) > maxRecipients {
+			return fmt.Errorf("between 1 and %d recipients are required", maxRecipients)
+		}
+		for _, to := range a.To {
+			if _, err := mail.ParseAddress(to); err != nil {
+				return fmt.Errorf("invalid recipient: '%s'", to)
+			}
+		}
+		if strings.TrimSpace(a.Subject) == "" || strings.ContainsAny(a.Subject, "\r\n") {
+			return errors.New("invalid subject")
+		}
+	case WebhookAction:
+		u, err := url.Parse(a.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("url must be a valid http(s) url")
+		}
+	case TagAction:
+		if strings.TrimSpace(a.Key) == "" {
+			return errors.New("tag key is required")
+		}
+	case SMSAction:
+		if len(a.To) == 0 || len(a.To) > maxRecipients {
+			return fmt.Errorf("between 1 and %d recipients are required", maxRecipients)
+		}
+		for _, to := range a.To {
+			if !phoneNumberRegexp.MatchString(to) {
+				return fmt.Errorf("invalid phone number: '%s'", to)
+			}
+		}
+	case InAppAction:
+		if strings.ContainsAny(a.Subject, "\r\n") {
+			return errors.New("invalid subject")
+		}
+	default:
+		return fmt.Errorf("invalid action type: '%s'", a.Type)
+	}
+
+	return nil
+}
+
+// Notifies returns true for the NOTIFICATION, WEBHOOK, SMS and IN_APP
+// actions, which notify the users of the automation.
+func (a Action) Notifies() bool {
+	switch a.Type {
+	case NotificationAction, WebhookAction, SMSAction, InAppAction:
+		return true
+	default:
+		return false
+	}
+}
+
+// Actions contains the actions of an automation, which are executed in
+// order.
+type Actions []Action
+
+// Validate validates the actions.
+func (a Actions) Validate() error {
+	if len(a) == 0 || len(a) > maxActions {
+		return fmt.Errorf("between 1 and %d actions are required", maxActions)
+	}
+
+	for i, action := range a {
+		if err := action.Validate(); err != nil {
+			return fmt.Errorf("action %d: %s", i, err)
+		}
+	}
+
+	return nil
+}
+
+// Value implements the driver.Valuer interface.
+func (a Actions) Value() (driver.Value, error) {
+	if a == nil {
+		return "[]", nil
+	}
+
+	b, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (a *Actions) Scan(src interface{}) error {
+	return scanJSON(src, a)
+}
+
+// EscalationStep defines a step of an escalation chain. The Action (a
+// NOTIFICATION or WEBHOOK action) is executed when the alarm has not been
+// acknowledged within DelaySeconds after the previous step, or after the
+// alarm was raised in case of the first step.
+type EscalationStep struct {
+	DelaySeconds int64  `json:"delaySeconds"`
+	Action       Action `json:"action"`
+}
+
+// Delay returns the delay of the escalation step.
+func (s EscalationStep) Delay() time.Duration {
+	return time.Duration(s.DelaySeconds) * time.Second
+}
+
+// Validate validates the escalation step.
+func (s EscalationStep) Validate() error {
+	if s.Delay() < minInterval {
+		return fmt.Errorf("delay must be at least %s", minInterval)
+	}
+
+	if s.Action.Type != NotificationAction && s.Action.Type != WebhookAction {
+		return fmt.Errorf("action must be a %s or %s action", NotificationAction, WebhookAction)
+	}
+
+	return s.Action.Validate()
+}
+
+// Escalation contains the escalation chain of an automation. When set,
+// each execution of the automation raises an alarm for the device, which
+// is escalated step by step until it is acknowledged.
+type Escalation []EscalationStep
+
+// Validate validates the escalation chain. An empty chain is valid.
+func (e Escalation) Validate() error {
+	if len(e) > maxEscalationSteps {
+		return fmt.Errorf("at most %d escalation steps are allowed", maxEscalationSteps)
+	}
+
+	for i, step := range e {
+		if err := step.Validate(); err != nil {
+			return fmt.Errorf("step %d: %s", i, err)
+		}
+	}
+
+	return nil
+}
+
+// Value implements the driver.Valuer interface.
+func (e Escalation) Value() (driver.Value, error) {
+	if e == nil {
+		return "[]", nil
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (e *Escalation) Scan(src interface{}) error {
+	return scanJSON(src, e)
+}
+
+// Result defines the result of an executed action. Error is empty when the
+// action succeeded. Suppressed is set when the notification was not sent
+// because of the renotify interval, Grouped when it was added to the
+// grouped notification of the zone of the device (see Dampening).
+type Result struct {
+	Type       ActionType `json:"type"`
+	Error      string     `json:"error,omitempty"`
+	Suppressed bool       `json:"suppressed,omitempty"`
+	Grouped    bool       `json:"grouped,omitempty"`
+}
+
+// Results contains the results of the executed actions.
+type Results []Result
+
+// Value implements the driver.Valuer interface.
+func (r Results) Value() (driver.Value, error) {
+	if r == nil {
+		return "[]", nil
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (r *Results) Scan(src interface{}) error {
+	return scanJSON(src, r)
+}
+
+func scanJSON(src interface{}, v interface{}) error {
+	var b []byte
+	switch src := src.(type) {
+	case []byte:
+		b = src
+	case string:
+		b = []byte(src)
+	case nil:
+		return nil
+	default:
+		return fmt.Errorf("expected []byte, got %T", src)
+	}
+
+	return json.Unmarshal(b, v)
+}
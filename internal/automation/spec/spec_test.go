@@ -0,0 +1,371 @@
+package spec
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/downlink/rule"
+)
+
+func TestTriggerValidate(t *testing.T) {
+	battery := float32(20)
+	invalidBattery := float32(120)
+	margin := 5
+	profileID := uuid.Must(uuid.NewV4())
+
+	tests := []struct {
+		Name          string
+		Trigger       Trigger
+		ExpectedError string
+	}{
+		{
+			Name: "valid uplink",
+			Trigger: Trigger{
+				Type: UplinkTrigger,
+				Conditions: rule.Conditions{
+					{Path: "moisture", Operator: rule.LessThan, Value: json.RawMessage(`20`)},
+				},
+			},
+		},
+		{
+			Name: "uplink without conditions",
+			Trigger: Trigger{
+				Type: UplinkTrigger,
+			},
+			ExpectedError: "conditions: at least one condition is required",
+		},
+		{
+			Name: "valid schedule",
+			Trigger: Trigger{
+				Type:            ScheduleTrigger,
+				IntervalSeconds: 3600,
+			},
+		},
+		{
+			Name: "schedule interval too short",
+			Trigger: Trigger{
+				Type:            ScheduleTrigger,
+				IntervalSeconds: 10,
+			},
+			ExpectedError: "interval must be at least 1m0s",
+		},
+		{
+			Name: "device offline timeout too short",
+			Trigger: Trigger{
+				Type: DeviceOfflineTrigger,
+			},
+			ExpectedError: "timeout must be at least 1m0s",
+		},
+		{
+			Name: "valid alarm",
+			Trigger: Trigger{
+				Type:              AlarmTrigger,
+				BatteryLevelBelow: &battery,
+				MarginBelow:       &margin,
+			},
+		},
+		{
+			Name: "alarm without thresholds",
+			Trigger: Trigger{
+				Type: AlarmTrigger,
+			},
+			ExpectedError: "battery level or margin threshold is required",
+		},
+		{
+			Name: "alarm invalid battery level",
+			Trigger: Trigger{
+				Type:              AlarmTrigger,
+				BatteryLevelBelow: &invalidBattery,
+			},
+			ExpectedError: "battery level threshold must be between 0 and 100",
+		},
+		{
+			Name: "valid threshold",
+			Trigger: Trigger{
+				Type:               ThresholdTrigger,
+				ThresholdProfileID: &profileID,
+			},
+		},
+		{
+			Name: "threshold without profile",
+			Trigger: Trigger{
+				Type:               ThresholdTrigger,
+				ThresholdProfileID: &uuid.Nil,
+			},
+			ExpectedError: "threshold profile is required",
+		},
+		{
+			Name: "valid contact open",
+			Trigger: Trigger{
+				Type:           ContactOpenTrigger,
+				TimeoutSeconds: 600,
+				ContactName:    "door",
+			},
+		},
+		{
+			Name: "contact open duration too short",
+			Trigger: Trigger{
+				Type:           ContactOpenTrigger,
+				TimeoutSeconds: 30,
+			},
+			ExpectedError: "open duration must be at least 1m0s",
+		},
+		{
+			Name: "invalid type",
+			Trigger: Trigger{
+				Type: "CRON",
+			},
+			ExpectedError: "invalid trigger type: 'CRON'",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			err := tst.Trigger.Validate()
+			if tst.ExpectedError != "" {
+				assert.EqualError(err, tst.ExpectedError)
+				return
+			}
+			assert.NoError(err)
+		})
+	}
+}
+
+func TestActionsValidate(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Actions       Actions
+		ExpectedError string
+	}{
+		{
+			Name: "valid",
+			Actions: Actions{
+				{Type: DownlinkAction, FPort: 10, Object: `{"valve": "open"}`},
+				{Type: NotificationAction, To: []string{"farmer@example.com"}, Subject: "Low moisture"},
+				{Type: WebhookAction, URL: "https://example.com/hook"},
+				{Type: TagAction, Key: "state", Value: "dry"},
+				{Type: SMSAction, To: []string{"+31612345678"}},
+				{Type: InAppAction, Subject: "Low moisture"},
+			},
+		},
+		{
+			Name:          "no actions",
+			ExpectedError: "between 1 and 8 actions are required",
+		},
+		{
+			Name: "invalid downlink fPort",
+			Actions: Actions{
+				{Type: DownlinkAction, Object: `{}`},
+			},
+			ExpectedError: "action 0: fPort must be between 1 and 223",
+		},
+		{
+			Name: "invalid downlink object",
+			Actions: Actions{
+				{Type: DownlinkAction, FPort: 10, Object: `[]`},
+			},
+			ExpectedError: "action 0: object must be a valid JSON object",
+		},
+		{
+			Name: "invalid recipient",
+			Actions: Actions{
+				{Type: NotificationAction, To: []string{"farmer"}, Subject: "Low moisture"},
+			},
+			ExpectedError: "action 0: invalid recipient: 'farmer'",
+		},
+		{
+			Name: "invalid subject",
+			Actions: Actions{
+				{Type: NotificationAction, To: []string{"farmer@example.com"}, Subject: "Low\r\nBcc: other@example.com"},
+			},
+			ExpectedError: "action 0: invalid subject",
+		},
+		{
+			Name: "invalid webhook url",
+			Actions: Actions{
+				{Type: WebhookAction, URL: "ftp://example.com"},
+			},
+			ExpectedError: "action 0: url must be a valid http(s) url",
+		},
+		{
+			Name: "tag without key",
+			Actions: Actions{
+				{Type: TagAction, Remove: true},
+			},
+			ExpectedError: "action 0: tag key is required",
+		},
+		{
+			Name: "sms without recipients",
+			Actions: Actions{
+				{Type: SMSAction},
+			},
+			ExpectedError: "action 0: between 1 and 10 recipients are required",
+		},
+		{
+			Name: "invalid phone number",
+			Actions: Actions{
+				{Type: SMSAction, To: []string{"0612345678"}},
+			},
+			ExpectedError: "action 0: invalid phone number: '0612345678'",
+		},
+		{
+			Name: "invalid in-app subject",
+			Actions: Actions{
+				{Type: InAppAction, Subject: "Low\nmoisture"},
+			},
+			ExpectedError: "action 0: invalid subject",
+		},
+		{
+			Name: "invalid type",
+			Actions: Actions{
+				{Type: "FAX"},
+			},
+			ExpectedError: "action 0: invalid action type: 'FAX'",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			err := tst.Actions.Validate()
+			if tst.ExpectedError != "" {
+				assert.EqualError(err, tst.ExpectedError)
+				return
+			}
+			assert.NoError(err)
+		})
+	}
+}
+
+func TestEscalationValidate(t *testing.T) {
+	notification := Action{Type: NotificationAction, To: []string{"operator@example.com"}, Subject: "Alarm not acknowledged"}
+
+	tests := []struct {
+		Name          string
+		Escalation    Escalation
+		ExpectedError string
+	}{
+		{
+			Name: "no steps",
+		},
+		{
+			Name: "valid",
+			Escalation: Escalation{
+				{DelaySeconds: 900, Action: notification},
+				{DelaySeconds: 1800, Action: Action{Type: WebhookAction, URL: "https://example.com/pager"}},
+			},
+		},
+		{
+			Name: "delay too short",
+			Escalation: Escalation{
+				{DelaySeconds: 30, Action: notification},
+			},
+			ExpectedError: "step 0: delay must be at least 1m0s",
+		},
+		{
+			Name: "invalid action type",
+			Escalation: Escalation{
+				{DelaySeconds: 900, Action: notification},
+				{DelaySeconds: 900, Action: Action{Type: TagAction, Key: "state"}},
+			},
+			ExpectedError: "step 1: action must be a NOTIFICATION or WEBHOOK action",
+		},
+		{
+			Name: "invalid action",
+			Escalation: Escalation{
+				{DelaySeconds: 900, Action: Action{Type: NotificationAction, Subject: "Alarm"}},
+			},
+			ExpectedError: "step 0: between 1 and 10 recipients are required",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			err := tst.Escalation.Validate()
+			if tst.ExpectedError != "" {
+				assert.EqualError(err, tst.ExpectedError)
+				return
+			}
+			assert.NoError(err)
+		})
+	}
+}
+
+func TestTriggerMatch(t *testing.T) {
+	t.Run("Uplink", func(t *testing.T) {
+		assert := require.New(t)
+
+		tr := Trigger{
+			Type:  UplinkTrigger,
+			FPort: 2,
+			Conditions: rule.Conditions{
+				{Path: "moisture", Operator: rule.LessThan, Value: json.RawMessage(`20`)},
+			},
+		}
+
+		ok, err := tr.MatchUplink(2, []byte(`{"moisture": 15}`))
+		assert.NoError(err)
+		assert.True(ok)
+
+		ok, err = tr.MatchUplink(2, []byte(`{"moisture": 25}`))
+		assert.NoError(err)
+		assert.False(ok)
+
+		ok, err = tr.MatchUplink(3, []byte(`{"moisture": 15}`))
+		assert.NoError(err)
+		assert.False(ok)
+
+		tr.FPort = 0
+		ok, err = tr.MatchUplink(3, []byte(`{"moisture": 15}`))
+		assert.NoError(err)
+		assert.True(ok)
+	})
+
+	t.Run("Status", func(t *testing.T) {
+		assert := require.New(t)
+
+		battery := float32(20)
+		margin := 5
+		tr := Trigger{
+			Type:              AlarmTrigger,
+			BatteryLevelBelow: &battery,
+			MarginBelow:       &margin,
+		}
+
+		low := float32(10)
+		high := float32(90)
+
+		assert.True(tr.MatchStatus(&low, 10))
+		assert.True(tr.MatchStatus(&high, 2))
+		assert.True(tr.MatchStatus(nil, 2))
+		assert.False(tr.MatchStatus(&high, 10))
+		assert.False(tr.MatchStatus(nil, 10))
+
+		tr.Type = UplinkTrigger
+		assert.False(tr.MatchStatus(&low, 2))
+	})
+}
+
+func TestValueScan(t *testing.T) {
+	assert := require.New(t)
+
+	in := Actions{
+		{Type: WebhookAction, URL: "https://example.com/hook", Headers: map[string]string{"Authorization": "Bearer secret"}},
+		{Type: TagAction, Key: "state", Remove: true},
+	}
+
+	v, err := in.Value()
+	assert.NoError(err)
+
+	var out Actions
+	assert.NoError(out.Scan(v))
+	assert.Equal(in, out)
+}
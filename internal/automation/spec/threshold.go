@@ -0,0 +1,105 @@
+package spec
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ibrahimozekici/app-server2/internal/downlink/rule"
+)
+
+// maxThresholds defines the max. number of thresholds of a threshold
+// profile.
+const maxThresholds = 32
+
+// Threshold defines the limits of a value of the decoded object. Path is
+// the (dot separated) path of the value, e.g. "temperature". The value must
+// be at least Min and at most Max, at least one of these must be set.
+type Threshold struct {
+	Path string   `json:"path"`
+	Min  *float64 `json:"min,omitempty"`
+	Max  *float64 `json:"max,omitempty"`
+}
+
+// Thresholds contains the thresholds of a threshold profile, e.g. a cold
+// room profile with a temperature between 2 and 8 and a humidity below 70.
+type Thresholds []Threshold
+
+// Validate validates the thresholds.
+func (t Thresholds) Validate() error {
+	if len(t) == 0 || len(t) > maxThresholds {
+		return fmt.Errorf("between 1 and %d thresholds are required", maxThresholds)
+	}
+
+	paths := make(map[string]struct{}, len(t))
+	for i, th := range t {
+		if !rule.ValidPath(th.Path) {
+			return fmt.Errorf("threshold %d: invalid path: '%s'", i, th.Path)
+		}
+		if _, ok := paths[th.Path]; ok {
+			return fmt.Errorf("threshold %d: duplicate path: '%s'", i, th.Path)
+		}
+		paths[th.Path] = struct{}{}
+
+		if th.Min == nil && th.Max == nil {
+			return fmt.Errorf("threshold %d: min or max is required", i)
+		}
+		if th.Min != nil && th.Max != nil && *th.Min > *th.Max {
+			return fmt.Errorf("threshold %d: min must not be greater than max", i)
+		}
+	}
+
+	return nil
+}
+
+// Exceeded returns the paths of the thresholds exceeded by the given JSON
+// object. Thresholds of which the value is missing from the object (or is
+// not a number) are skipped, ok is false when no threshold could be
+// evaluated. In that case the object does not tell if the limits are
+// respected, e.g. when a device sends different types of uplinks.
+func (t Thresholds) Exceeded(objectJSON []byte) (exceeded []string, ok bool, err error) {
+	if len(objectJSON) == 0 {
+		return nil, false, nil
+	}
+
+	var obj interface{}
+	if err := json.Unmarshal(objectJSON, &obj); err != nil {
+		return nil, false, err
+	}
+
+	for _, th := range t {
+		v, found := rule.Lookup(obj, th.Path)
+		if !found {
+			continue
+		}
+		f, isNumber := v.(float64)
+		if !isNumber {
+			continue
+		}
+
+		ok = true
+		if (th.Min != nil && f < *th.Min) || (th.Max != nil && f > *th.Max) {
+			exceeded = append(exceeded, th.Path)
+		}
+	}
+
+	return exceeded, ok, nil
+}
+
+// Value implements the driver.Valuer interface.
+func (t Thresholds) Value() (driver.Value, error) {
+	if t == nil {
+		return "[]", nil
+	}
+
+	b, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (t *Thresholds) Scan(src interface{}) error {
+	return scanJSON(src, t)
+}
@@ -0,0 +1,138 @@
+package spec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestThresholdsValidate(t *testing.T) {
+	min := float64(2)
+	max := float64(8)
+
+	tests := []struct {
+		Name          string
+		Thresholds    Thresholds
+		ExpectedError string
+	}{
+		{
+			Name: "valid",
+			Thresholds: Thresholds{
+				{Path: "temperature", Min: &min, Max: &max},
+				{Path: "humidity", Max: &max},
+			},
+		},
+		{
+			Name:          "no thresholds",
+			ExpectedError: "between 1 and 32 thresholds are required",
+		},
+		{
+			Name: "invalid path",
+			Thresholds: Thresholds{
+				{Path: "sensor..temperature", Min: &min},
+			},
+			ExpectedError: "threshold 0: invalid path: 'sensor..temperature'",
+		},
+		{
+			Name: "duplicate path",
+			Thresholds: Thresholds{
+				{Path: "temperature", Min: &min},
+				{Path: "temperature", Max: &max},
+			},
+			ExpectedError: "threshold 1: duplicate path: 'temperature'",
+		},
+		{
+			Name: "no limits",
+			Thresholds: Thresholds{
+				{Path: "temperature"},
+			},
+			ExpectedError: "threshold 0: min or max is required",
+		},
+		{
+			Name: "min greater than max",
+			Thresholds: Thresholds{
+				{Path: "temperature", Min: &max, Max: &min},
+			},
+			ExpectedError: "threshold 0: min must not be greater than max",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			err := tst.Thresholds.Validate()
+			if tst.ExpectedError != "" {
+				assert.EqualError(err, tst.ExpectedError)
+				return
+			}
+			assert.NoError(err)
+		})
+	}
+}
+
+func TestThresholdsExceeded(t *testing.T) {
+	min := float64(2)
+	max := float64(8)
+	humidity := float64(70)
+
+	th := Thresholds{
+		{Path: "temperature", Min: &min, Max: &max},
+		{Path: "sensor.humidity", Max: &humidity},
+	}
+
+	tests := []struct {
+		Name             string
+		Object           string
+		ExpectedExceeded []string
+		ExpectedOK       bool
+	}{
+		{
+			Name:       "within limits",
+			Object:     `{"temperature": 4.5, "sensor": {"humidity": 60}}`,
+			ExpectedOK: true,
+		},
+		{
+			Name:             "below min",
+			Object:           `{"temperature": 1.5}`,
+			ExpectedExceeded: []string{"temperature"},
+			ExpectedOK:       true,
+		},
+		{
+			Name:             "above max",
+			Object:           `{"temperature": 9, "sensor": {"humidity": 75}}`,
+			ExpectedExceeded: []string{"temperature", "sensor.humidity"},
+			ExpectedOK:       true,
+		},
+		{
+			Name:       "limits are inclusive",
+			Object:     `{"temperature": 8}`,
+			ExpectedOK: true,
+		},
+		{
+			Name:   "values missing",
+			Object: `{"battery": 3.3, "temperature": "n/a"}`,
+		},
+		{
+			Name: "no object",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			exceeded, ok, err := th.Exceeded([]byte(tst.Object))
+			assert.NoError(err)
+			assert.Equal(tst.ExpectedExceeded, exceeded)
+			assert.Equal(tst.ExpectedOK, ok)
+		})
+	}
+
+	t.Run("invalid object", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, _, err := th.Exceeded([]byte(`{`))
+		assert.Error(err)
+	})
+}
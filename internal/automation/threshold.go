@@ -0,0 +1,82 @@
+package automation
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// handleThresholds executes the automations of the device application with
+// a THRESHOLD trigger of which the threshold profile is exceeded by the
+// given uplink. The alarms of these automations are resolved once the
+// uplink values are back within the limits of the profile.
+func handleThresholds(ctx context.Context, d storage.Device, fPort uint8, objectJSON []byte) error {
+	automations, err := storage.GetEnabledAutomationsForTrigger(ctx, storage.DB(), d.ApplicationID, spec.ThresholdTrigger)
+	if err != nil {
+		return errors.Wrap(err, "get automations error")
+	}
+
+	// automations often share the same profile
+	profiles := make(map[uuid.UUID]spec.Thresholds)
+
+	for _, a := range automations {
+		if !a.MatchDevice(d) || !a.Trigger.MatchFPort(fPort) || a.Trigger.ThresholdProfileID == nil {
+			continue
+		}
+
+		profileID := *a.Trigger.ThresholdProfileID
+		thresholds, ok := profiles[profileID]
+		if !ok {
+			p, err := storage.GetThresholdProfile(ctx, storage.DB(), profileID)
+			if err != nil {
+				if errors.Cause(err) == storage.ErrDoesNotExist {
+					log.WithFields(log.Fields{
+						"automation_id":        a.ID,
+						"threshold_profile_id": profileID,
+						"ctx_id":               ctx.Value(logging.ContextIDKey),
+					}).Warning("automation: threshold profile does not exist")
+					continue
+				}
+				return errors.Wrap(err, "get threshold profile error")
+			}
+
+			thresholds = p.Thresholds
+			profiles[profileID] = thresholds
+		}
+
+		exceeded, ok, err := thresholds.Exceeded(objectJSON)
+		if err != nil {
+			return errors.Wrap(err, "evaluate thresholds error")
+		}
+
+		// the uplink does not contain any of the values of the profile
+		if !ok {
+			continue
+		}
+
+		ev := Event{
+			Object:   json.RawMessage(objectJSON),
+			Exceeded: exceeded,
+		}
+
+		if len(exceeded) == 0 {
+			if err := recovered(ctx, a, d, ev); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := fire(ctx, a, d, ev); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
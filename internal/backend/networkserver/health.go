@@ -0,0 +1,151 @@
+package networkserver
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	healthGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "network_server_healthy",
+		Help: "Set to 1 when the last API request to the network-server succeeded, 0 otherwise (per network-server).",
+	}, []string{"server"})
+
+	healthErrorCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "network_server_unavailable_count",
+		Help: "The number of API requests failed because the network-server was unavailable (per network-server).",
+	}, []string{"server"})
+
+	connectionStateGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "network_server_connection_state",
+		Help: "The state of the connection to the network-server: 0 = idle, 1 = connecting, 2 = ready, 3 = transient failure, 4 = shutdown (per network-server).",
+	}, []string{"server"})
+)
+
+var health = healthTracker{
+	servers: make(map[string]*Health),
+}
+
+// Health defines the health of a network-server, based on the outcome of the
+// API requests to this network-server.
+type Health struct {
+	Server              string    `json:"server"`
+	Healthy             bool      `json:"healthy"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	LastError           string    `json:"lastError,omitempty"`
+	LastSuccessAt       time.Time `json:"lastSuccessAt,omitempty"`
+	LastFailureAt       time.Time `json:"lastFailureAt,omitempty"`
+}
+
+// GetHealth returns the health of the given network-server (hostname:port).
+// It returns false when no API request has been made to this network-server.
+func GetHealth(server string) (Health, bool) {
+	return health.get(server)
+}
+
+// GetHealthAll returns the health of all network-servers to which API
+// requests have been made, sorted by server.
+func GetHealthAll() []Health {
+	return health.all()
+}
+
+type healthTracker struct {
+	sync.RWMutex
+	servers map[string]*Health
+}
+
+func (t *healthTracker) get(server string) (Health, bool) {
+	t.RLock()
+	defer t.RUnlock()
+
+	h, ok := t.servers[server]
+	if !ok {
+		return Health{}, false
+	}
+	return *h, true
+}
+
+func (t *healthTracker) all() []Health {
+	t.RLock()
+	defer t.RUnlock()
+
+	out := make([]Health, 0, len(t.servers))
+	for _, h := range t.servers {
+		out = append(out, *h)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Server < out[j].Server
+	})
+
+	return out
+}
+
+// record records the outcome of an API request to the given server. Only
+// errors indicating that the network-server could not be reached mark the
+// network-server as unhealthy, e.g. a NotFound error is a valid response.
+func (t *healthTracker) record(server string, err error) {
+	t.Lock()
+	defer t.Unlock()
+
+	h, ok := t.servers[server]
+	if !ok {
+		h = &Health{Server: server}
+		t.servers[server] = h
+	}
+
+	if isUnavailableError(err) {
+		if h.Healthy || h.ConsecutiveFailures == 0 {
+			log.WithError(err).WithField("server", server).Warning("network-server: network-server is unavailable")
+		}
+
+		h.Healthy = false
+		h.ConsecutiveFailures++
+		h.LastError = err.Error()
+		h.LastFailureAt = time.Now()
+
+		healthGauge.WithLabelValues(server).Set(0)
+		healthErrorCounter.WithLabelValues(server).Inc()
+		return
+	}
+
+	if !h.Healthy && h.ConsecutiveFailures != 0 {
+		log.WithField("server", server).Info("network-server: network-server is available again")
+	}
+
+	h.Healthy = true
+	h.ConsecutiveFailures = 0
+	h.LastSuccessAt = time.Now()
+
+	healthGauge.WithLabelValues(server).Set(1)
+}
+
+func isUnavailableError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// healthUnaryClientInterceptor records the health of the server to which the
+// API request is made (the network-server or its standby).
+func healthUnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	health.record(cc.Target(), err)
+	return err
+}
@@ -0,0 +1,66 @@
+package networkserver
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHealthTracker(t *testing.T) {
+	assert := require.New(t)
+
+	tr := healthTracker{
+		servers: make(map[string]*Health),
+	}
+
+	_, ok := tr.get("ns-eu:8000")
+	assert.False(ok)
+
+	tr.record("ns-eu:8000", nil)
+	tr.record("ns-us:8000", status.Error(codes.Unavailable, "connection refused"))
+	tr.record("ns-us:8000", status.Error(codes.DeadlineExceeded, "timeout"))
+
+	h, ok := tr.get("ns-eu:8000")
+	assert.True(ok)
+	assert.True(h.Healthy)
+	assert.Equal(0, h.ConsecutiveFailures)
+	assert.False(h.LastSuccessAt.IsZero())
+
+	h, ok = tr.get("ns-us:8000")
+	assert.True(ok)
+	assert.False(h.Healthy)
+	assert.Equal(2, h.ConsecutiveFailures)
+	assert.Equal("rpc error: code = DeadlineExceeded desc = timeout", h.LastError)
+
+	t.Run("Non-connectivity errors do not affect the health", func(t *testing.T) {
+		assert := require.New(t)
+
+		tr.record("ns-eu:8000", status.Error(codes.NotFound, "object does not exist"))
+		tr.record("ns-eu:8000", errors.New("unknown error"))
+
+		h, _ := tr.get("ns-eu:8000")
+		assert.True(h.Healthy)
+	})
+
+	t.Run("Recovery", func(t *testing.T) {
+		assert := require.New(t)
+
+		tr.record("ns-us:8000", nil)
+
+		h, _ := tr.get("ns-us:8000")
+		assert.True(h.Healthy)
+		assert.Equal(0, h.ConsecutiveFailures)
+	})
+
+	t.Run("All", func(t *testing.T) {
+		assert := require.New(t)
+
+		all := tr.all()
+		assert.Len(all, 2)
+		assert.Equal("ns-eu:8000", all[0].Server)
+		assert.Equal("ns-us:8000", all[1].Server)
+	})
+}
@@ -2,9 +2,9 @@ package networkserver
 
 import (
 	"bytes"
-	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"io/ioutil"
 	"sync"
 	"time"
 
@@ -12,8 +12,13 @@ import (
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
 	"google.golang.org/grpc/balancer/roundrobin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
 
 	// "github.com/ibrahimozekici/lora-api/go/v3/ns"
 	"github.com/ibrahimozekici/app-server2/internal/config"
@@ -28,18 +33,100 @@ type Pool interface {
 }
 
 type client struct {
-	client     ns.NetworkServerServiceClient
-	clientConn *grpc.ClientConn
-	caCert     []byte
-	tlsCert    []byte
-	tlsKey     []byte
+	client      ns.NetworkServerServiceClient
+	clientConn  *grpc.ClientConn
+	standbyConn *grpc.ClientConn
+	caCert      []byte
+	tlsCert     []byte
+	tlsKey      []byte
 }
 
 // Setup configures the networkserver package.
 func Setup(conf config.Config) error {
-	p = &pool{
+	pp := pool{
 		clients: make(map[string]client),
+		retry: retryConfig{
+			maxAttempts:    conf.NetworkServer.Retry.MaxAttempts,
+			initialBackoff: conf.NetworkServer.Retry.InitialBackoff,
+			maxBackoff:     conf.NetworkServer.Retry.MaxBackoff,
+		},
+		standbys: make(map[string]string),
+		tls:      make(map[string]tlsFiles),
+	}
+
+	for _, f := range conf.NetworkServer.Failover {
+		if f.Server == "" || f.Standby == "" {
+			return errors.New("network_server.failover server and standby must be set")
+		}
+		pp.standbys[f.Server] = f.Standby
+	}
+
+	for _, c := range conf.NetworkServer.TLS {
+		if c.Server == "" {
+			return errors.New("network_server.tls server must be set")
+		}
+
+		var files tlsFiles
+		for _, f := range []struct {
+			path string
+			b    *[]byte
+		}{
+			{c.CACert, &files.caCert},
+			{c.TLSCert, &files.tlsCert},
+			{c.TLSKey, &files.tlsKey},
+		} {
+			if f.path == "" {
+				continue
+			}
+
+			b, err := ioutil.ReadFile(f.path)
+			if err != nil {
+				return errors.Wrapf(err, "read network-server %s tls file error", c.Server)
+			}
+			*f.b = b
+		}
+		files.serverName = c.ServerName
+
+		pp.tls[c.Server] = files
+	}
+
+	cc := conf.NetworkServer.Client
+	if cc.KeepaliveTime != 0 {
+		pp.dialOpts = append(pp.dialOpts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                cc.KeepaliveTime,
+			Timeout:             cc.KeepaliveTimeout,
+			PermitWithoutStream: cc.KeepalivePermitWithoutStream,
+		}))
+	}
+
+	var callOpts []grpc.CallOption
+	if cc.MaxRecvMsgSize != 0 {
+		callOpts = append(callOpts, grpc.MaxCallRecvMsgSize(cc.MaxRecvMsgSize))
+	}
+	if cc.MaxSendMsgSize != 0 {
+		callOpts = append(callOpts, grpc.MaxCallSendMsgSize(cc.MaxSendMsgSize))
 	}
+	if len(callOpts) != 0 {
+		pp.dialOpts = append(pp.dialOpts, grpc.WithDefaultCallOptions(callOpts...))
+	}
+
+	pp.connectParams = grpc.ConnectParams{
+		Backoff:           backoff.DefaultConfig,
+		MinConnectTimeout: cc.ConnectTimeout,
+	}
+	if cc.BackoffBaseDelay != 0 {
+		pp.connectParams.Backoff.BaseDelay = cc.BackoffBaseDelay
+	}
+	if cc.BackoffMaxDelay != 0 {
+		pp.connectParams.Backoff.MaxDelay = cc.BackoffMaxDelay
+	}
+
+	p = &pp
+
+	if cc.HealthCheckInterval != 0 {
+		go pp.healthCheckLoop(cc.HealthCheckInterval)
+	}
+
 	return nil
 }
 
@@ -55,16 +142,42 @@ func SetPool(pp Pool) {
 
 type pool struct {
 	sync.RWMutex
-	clients map[string]client
+	clients       map[string]client
+	retry         retryConfig
+	standbys      map[string]string
+	tls           map[string]tlsFiles
+	dialOpts      []grpc.DialOption
+	connectParams grpc.ConnectParams
+}
+
+// tlsFiles holds the TLS configuration of a network-server, as configured
+// in the configuration file.
+type tlsFiles struct {
+	caCert     []byte
+	tlsCert    []byte
+	tlsKey     []byte
+	serverName string
 }
 
 // Get returns a NetworkServerClient for the given server (hostname:ip).
+// The connection to the network-server is created on the first call and is
+// re-used by all subsequent calls. Creating the connection does not block,
+// when the network-server can not be reached the API requests fail with an
+// Unavailable error while the connection is re-created in the background.
 func (p *pool) Get(hostname string, caCert, tlsCert, tlsKey []byte) (ns.NetworkServerServiceClient, error) {
+	p.RLock()
+	c, ok := p.clients[hostname]
+	p.RUnlock()
+
+	if ok && bytes.Equal(c.caCert, caCert) && bytes.Equal(c.tlsCert, tlsCert) && bytes.Equal(c.tlsKey, tlsKey) {
+		return c.client, nil
+	}
+
 	defer p.Unlock()
 	p.Lock()
 
 	var connect bool
-	c, ok := p.clients[hostname]
+	c, ok = p.clients[hostname]
 	if !ok {
 		connect = true
 	}
@@ -73,21 +186,26 @@ func (p *pool) Get(hostname string, caCert, tlsCert, tlsKey []byte) (ns.NetworkS
 	// try to cloe the connection and re-connect
 	if ok && (!bytes.Equal(c.caCert, caCert) || !bytes.Equal(c.tlsCert, tlsCert) || !bytes.Equal(c.tlsKey, tlsKey)) {
 		c.clientConn.Close()
+		if c.standbyConn != nil {
+			c.standbyConn.Close()
+		}
 		delete(p.clients, hostname)
 		connect = true
 	}
 
 	if connect {
-		clientConn, nsClient, err := p.createClient(hostname, caCert, tlsCert, tlsKey)
+		clientConn, standbyConn, nsClient, err := p.createClient(hostname, caCert, tlsCert, tlsKey)
 		if err != nil {
+			health.record(hostname, status.Error(codes.Unavailable, err.Error()))
 			return nil, errors.Wrap(err, "create network-server api client error")
 		}
 		c = client{
-			client:     nsClient,
-			clientConn: clientConn,
-			caCert:     caCert,
-			tlsCert:    tlsCert,
-			tlsKey:     tlsKey,
+			client:      nsClient,
+			clientConn:  clientConn,
+			standbyConn: standbyConn,
+			caCert:      caCert,
+			tlsCert:     tlsCert,
+			tlsKey:      tlsKey,
 		}
 		p.clients[hostname] = c
 	}
@@ -95,51 +213,116 @@ func (p *pool) Get(hostname string, caCert, tlsCert, tlsKey []byte) (ns.NetworkS
 	return c.client, nil
 }
 
-func (p *pool) createClient(hostname string, caCert, tlsCert, tlsKey []byte) (*grpc.ClientConn, ns.NetworkServerServiceClient, error) {
+func (p *pool) createClient(hostname string, caCert, tlsCert, tlsKey []byte) (*grpc.ClientConn, *grpc.ClientConn, ns.NetworkServerServiceClient, error) {
 	logrusEntry := log.NewEntry(log.StandardLogger())
 	logrusOpts := []grpc_logrus.Option{
 		grpc_logrus.WithLevels(grpc_logrus.DefaultCodeToLevel),
 	}
 
-	nsOpts := []grpc.DialOption{
-		grpc.WithBlock(),
-		grpc.WithUnaryInterceptor(
-			logging.UnaryClientCtxIDInterceptor,
-		),
-		grpc.WithStreamInterceptor(
-			grpc_logrus.StreamClientInterceptor(logrusEntry, logrusOpts...),
-		),
-		grpc.WithBalancerName(roundrobin.Name),
+	// the certificates of the network-server settings take precedence over
+	// the certificates of the configuration file
+	tlsFiles := p.tls[hostname]
+	if len(caCert) == 0 && len(tlsCert) == 0 && len(tlsKey) == 0 {
+		caCert, tlsCert, tlsKey = tlsFiles.caCert, tlsFiles.tlsCert, tlsFiles.tlsKey
 	}
 
+	var transportOpt grpc.DialOption
 	if len(caCert) == 0 && len(tlsCert) == 0 && len(tlsKey) == 0 {
-		nsOpts = append(nsOpts, grpc.WithInsecure())
+		transportOpt = grpc.WithInsecure()
 		log.WithField("server", hostname).Warning("creating insecure network-server client")
 	} else {
 		log.WithField("server", hostname).Info("creating network-server client")
-		cert, err := tls.X509KeyPair(tlsCert, tlsKey)
-		if err != nil {
-			return nil, nil, errors.Wrap(err, "load x509 keypair error")
+		tlsConfig := tls.Config{
+			ServerName: tlsFiles.serverName,
 		}
 
-		caCertPool := x509.NewCertPool()
-		if !caCertPool.AppendCertsFromPEM(caCert) {
-			return nil, nil, errors.Wrap(err, "append ca cert to pool error")
+		if len(tlsCert) != 0 || len(tlsKey) != 0 {
+			cert, err := tls.X509KeyPair(tlsCert, tlsKey)
+			if err != nil {
+				return nil, nil, nil, errors.Wrap(err, "load x509 keypair error")
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
 		}
 
-		nsOpts = append(nsOpts, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
-			Certificates: []tls.Certificate{cert},
-			RootCAs:      caCertPool,
-		})))
+		if len(caCert) != 0 {
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+				return nil, nil, nil, errors.New("append ca cert to pool error")
+			}
+		}
+
+		transportOpt = grpc.WithTransportCredentials(credentials.NewTLS(&tlsConfig))
+	}
+
+	// the connections are created in the background, such that the requests
+	// fail over to the standby (or fail immediately) when the network-server
+	// can not be reached, instead of blocking until the dial times out.
+	var standbyConn *grpc.ClientConn
+	if standby, ok := p.standbys[hostname]; ok {
+		log.WithFields(log.Fields{
+			"server":  hostname,
+			"standby": standby,
+		}).Info("creating standby network-server client")
+
+		var err error
+		standbyConn, err = grpc.Dial(standby, append([]grpc.DialOption{transportOpt, grpc.WithBalancerName(roundrobin.Name), grpc.WithConnectParams(p.connectParams)}, p.dialOpts...)...)
+		if err != nil {
+			return nil, nil, nil, errors.Wrap(err, "dial standby network-server api error")
+		}
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
-	defer cancel()
+	nsOpts := []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(
+			logging.UnaryClientCtxIDInterceptor,
+			retryUnaryClientInterceptor(p.retry, standbyConn),
+			healthUnaryClientInterceptor,
+		),
+		grpc.WithStreamInterceptor(
+			grpc_logrus.StreamClientInterceptor(logrusEntry, logrusOpts...),
+		),
+		grpc.WithBalancerName(roundrobin.Name),
+		grpc.WithConnectParams(p.connectParams),
+		transportOpt,
+	}
+	nsOpts = append(nsOpts, p.dialOpts...)
 
-	nsClient, err := grpc.DialContext(ctx, hostname, nsOpts...)
+	nsClient, err := grpc.Dial(hostname, nsOpts...)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "dial network-server api error")
+		if standbyConn != nil {
+			standbyConn.Close()
+		}
+		return nil, nil, nil, errors.Wrap(err, "dial network-server api error")
 	}
 
-	return nsClient, ns.NewNetworkServerServiceClient(nsClient), nil
+	return nsClient, standbyConn, ns.NewNetworkServerServiceClient(nsClient), nil
+}
+
+// healthCheckLoop checks the connections of the pool at the given interval.
+func (p *pool) healthCheckLoop(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		p.checkConnections()
+	}
+}
+
+// checkConnections checks the state of the network-server connections. A
+// failed connection marks the network-server as unhealthy, such that the
+// requests are sent to the standby without retrying, and a ready connection
+// marks it as healthy again. Note that failed connections are re-connected
+// by gRPC in the background, using the configured backoff.
+func (p *pool) checkConnections() {
+	p.RLock()
+	defer p.RUnlock()
+
+	for hostname, c := range p.clients {
+		state := c.clientConn.GetState()
+		connectionStateGauge.WithLabelValues(hostname).Set(float64(state))
+
+		switch state {
+		case connectivity.Ready:
+			health.record(hostname, nil)
+		case connectivity.TransientFailure:
+			health.record(hostname, status.Error(codes.Unavailable, "connection is in transient failure"))
+		}
+	}
 }
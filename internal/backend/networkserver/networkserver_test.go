@@ -0,0 +1,131 @@
+package networkserver
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/connectivity"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+func TestSetup(t *testing.T) {
+	t.Run("Client options", func(t *testing.T) {
+		assert := require.New(t)
+
+		var conf config.Config
+		conf.NetworkServer.Client.KeepaliveTime = 30 * time.Second
+		conf.NetworkServer.Client.KeepaliveTimeout = 10 * time.Second
+		conf.NetworkServer.Client.MaxRecvMsgSize = 16 << 20
+
+		assert.NoError(Setup(conf))
+		assert.Len(p.(*pool).dialOpts, 2)
+	})
+
+	t.Run("TLS files", func(t *testing.T) {
+		assert := require.New(t)
+
+		dir, err := ioutil.TempDir("", "ns-tls")
+		assert.NoError(err)
+		defer os.RemoveAll(dir)
+
+		caCert := filepath.Join(dir, "ca.pem")
+		assert.NoError(ioutil.WriteFile(caCert, []byte("ca"), 0600))
+
+		var conf config.Config
+		conf.NetworkServer.TLS = []config.NetworkServerTLS{
+			{Server: "ns:8000", CACert: caCert, ServerName: "ns.internal"},
+		}
+
+		assert.NoError(Setup(conf))
+		assert.Equal(tlsFiles{
+			caCert:     []byte("ca"),
+			serverName: "ns.internal",
+		}, p.(*pool).tls["ns:8000"])
+
+		conf.NetworkServer.TLS[0].TLSCert = filepath.Join(dir, "missing.pem")
+		assert.Error(Setup(conf))
+	})
+
+	t.Run("Invalid failover", func(t *testing.T) {
+		assert := require.New(t)
+
+		var conf config.Config
+		conf.NetworkServer.Failover = []config.NetworkServerFailover{
+			{Server: "ns:8000"},
+		}
+
+		assert.Error(Setup(conf))
+	})
+}
+
+func TestPool(t *testing.T) {
+	assert := require.New(t)
+
+	// an address on which nothing is listening
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	hostname := ln.Addr().String()
+	assert.NoError(ln.Close())
+
+	var conf config.Config
+	conf.NetworkServer.Client.ConnectTimeout = 100 * time.Millisecond
+	conf.NetworkServer.Client.BackoffBaseDelay = 10 * time.Millisecond
+	conf.NetworkServer.Client.BackoffMaxDelay = 100 * time.Millisecond
+	assert.NoError(Setup(conf))
+
+	pp := p.(*pool)
+	assert.Equal(10*time.Millisecond, pp.connectParams.Backoff.BaseDelay)
+	assert.Equal(100*time.Millisecond, pp.connectParams.Backoff.MaxDelay)
+
+	t.Run("Get does not block", func(t *testing.T) {
+		assert := require.New(t)
+
+		start := time.Now()
+		c1, err := pp.Get(hostname, nil, nil, nil)
+		assert.NoError(err)
+		assert.True(time.Since(start) < 100*time.Millisecond)
+
+		c2, err := pp.Get(hostname, nil, nil, nil)
+		assert.NoError(err)
+		assert.True(c1 == c2)
+		assert.Len(pp.clients, 1)
+	})
+
+	t.Run("Certificates changed", func(t *testing.T) {
+		assert := require.New(t)
+
+		conn := pp.clients[hostname].clientConn
+		_, err := pp.Get(hostname, nil, nil, []byte("key"))
+		assert.Error(err)
+		assert.Equal(connectivity.Shutdown, conn.GetState())
+		assert.Len(pp.clients, 0)
+
+		_, err = pp.Get(hostname, nil, nil, nil)
+		assert.NoError(err)
+	})
+
+	t.Run("Health check", func(t *testing.T) {
+		assert := require.New(t)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		conn := pp.clients[hostname].clientConn
+		for state := conn.GetState(); state != connectivity.TransientFailure; state = conn.GetState() {
+			assert.True(conn.WaitForStateChange(ctx, state))
+		}
+
+		pp.checkConnections()
+
+		h, ok := GetHealth(hostname)
+		assert.True(ok)
+		assert.False(h.Healthy)
+	})
+}
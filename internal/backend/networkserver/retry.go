@@ -0,0 +1,99 @@
+package networkserver
+
+import (
+	"context"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// retryConfig defines the retry configuration of the network-server API
+// requests.
+type retryConfig struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+// retryMethods defines the (non Get / List) API methods which are safe to
+// retry. Other methods (e.g. enqueueing a downlink) could be executed twice
+// when the network-server handled the request but the response got lost.
+var retryMethods = map[string]struct{}{
+	"FlushDeviceQueueForDevEUI":            {},
+	"FlushMulticastQueueForMulticastGroup": {},
+}
+
+// isRetryableMethod returns true when the given API method is safe to retry.
+func isRetryableMethod(method string) bool {
+	name := path.Base(method)
+	if strings.HasPrefix(name, "Get") || strings.HasPrefix(name, "List") {
+		return true
+	}
+	_, ok := retryMethods[name]
+	return ok
+}
+
+// retryUnaryClientInterceptor retries the retryable API requests with an
+// exponential backoff when the network-server is unavailable. When still
+// unavailable and a standby connection is given, the request is sent to the
+// standby network-server. In case the network-server is already known to be
+// unavailable, the request is sent to the standby without retrying.
+func retryUnaryClientInterceptor(conf retryConfig, standby *grpc.ClientConn) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		attempts := 1
+		if isRetryableMethod(method) && conf.maxAttempts > 1 {
+			attempts = conf.maxAttempts
+		}
+		if standby != nil {
+			if h, ok := health.get(cc.Target()); ok && !h.Healthy {
+				attempts = 1
+			}
+		}
+
+		backoff := conf.initialBackoff
+		var err error
+
+		for i := 0; i < attempts; i++ {
+			if i != 0 {
+				select {
+				case <-time.After(backoff):
+				case <-ctx.Done():
+					return err
+				}
+
+				backoff *= 2
+				if conf.maxBackoff != 0 && backoff > conf.maxBackoff {
+					backoff = conf.maxBackoff
+				}
+			}
+
+			err = invoker(ctx, method, req, reply, cc, opts...)
+			if status.Code(err) != codes.Unavailable {
+				return err
+			}
+
+			log.WithError(err).WithFields(log.Fields{
+				"server":  cc.Target(),
+				"method":  method,
+				"attempt": i + 1,
+			}).Debug("network-server: network-server is unavailable")
+		}
+
+		if standby == nil {
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"server":  cc.Target(),
+			"standby": standby.Target(),
+			"method":  method,
+		}).Warning("network-server: failing over to standby network-server")
+
+		return invoker(ctx, method, req, reply, standby, opts...)
+	}
+}
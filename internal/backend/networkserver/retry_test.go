@@ -0,0 +1,132 @@
+package networkserver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsRetryableMethod(t *testing.T) {
+	tests := []struct {
+		Method   string
+		Expected bool
+	}{
+		{"/ns.NetworkServerService/GetDeviceActivation", true},
+		{"/ns.NetworkServerService/GetDeviceQueueItemsForDevEUI", true},
+		{"/ns.NetworkServerService/ListGatewayProfiles", true},
+		{"/ns.NetworkServerService/FlushDeviceQueueForDevEUI", true},
+		{"/ns.NetworkServerService/CreateDeviceQueueItem", false},
+		{"/ns.NetworkServerService/ActivateDevice", false},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Method, func(t *testing.T) {
+			require.Equal(t, tst.Expected, isRetryableMethod(tst.Method))
+		})
+	}
+}
+
+func TestRetryUnaryClientInterceptor(t *testing.T) {
+	primary, err := grpc.Dial("primary:8000", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer primary.Close()
+
+	standby, err := grpc.Dial("standby:8000", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer standby.Close()
+
+	conf := retryConfig{
+		maxAttempts: 3,
+	}
+
+	// invoker returns the given errors for the primary, in order, and records
+	// the servers to which the requests are made
+	invoker := func(errs []error, servers *[]string) grpc.UnaryInvoker {
+		return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			*servers = append(*servers, cc.Target())
+			if cc.Target() == "standby:8000" || len(errs) == 0 {
+				return nil
+			}
+			err := errs[0]
+			errs = errs[1:]
+			return err
+		}
+	}
+
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+
+	tests := []struct {
+		Name            string
+		Method          string
+		Standby         *grpc.ClientConn
+		Errors          []error
+		ExpectedError   bool
+		ExpectedServers []string
+	}{
+		{
+			Name:            "success",
+			Method:          "/ns.NetworkServerService/GetDeviceActivation",
+			ExpectedServers: []string{"primary:8000"},
+		},
+		{
+			Name:            "retry succeeds",
+			Method:          "/ns.NetworkServerService/GetDeviceActivation",
+			Errors:          []error{unavailable, unavailable},
+			ExpectedServers: []string{"primary:8000", "primary:8000", "primary:8000"},
+		},
+		{
+			Name:            "retries exceeded",
+			Method:          "/ns.NetworkServerService/GetDeviceActivation",
+			Errors:          []error{unavailable, unavailable, unavailable},
+			ExpectedError:   true,
+			ExpectedServers: []string{"primary:8000", "primary:8000", "primary:8000"},
+		},
+		{
+			Name:            "other errors are not retried",
+			Method:          "/ns.NetworkServerService/GetDeviceActivation",
+			Errors:          []error{status.Error(codes.NotFound, "object does not exist")},
+			ExpectedError:   true,
+			ExpectedServers: []string{"primary:8000"},
+		},
+		{
+			Name:            "non-retryable method",
+			Method:          "/ns.NetworkServerService/CreateDeviceQueueItem",
+			Errors:          []error{unavailable},
+			ExpectedError:   true,
+			ExpectedServers: []string{"primary:8000"},
+		},
+		{
+			Name:            "failover to standby",
+			Method:          "/ns.NetworkServerService/FlushDeviceQueueForDevEUI",
+			Standby:         standby,
+			Errors:          []error{unavailable, unavailable, unavailable},
+			ExpectedServers: []string{"primary:8000", "primary:8000", "primary:8000", "standby:8000"},
+		},
+		{
+			Name:            "failover of non-retryable method",
+			Method:          "/ns.NetworkServerService/CreateDeviceQueueItem",
+			Standby:         standby,
+			Errors:          []error{unavailable},
+			ExpectedServers: []string{"primary:8000", "standby:8000"},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			var servers []string
+			err := retryUnaryClientInterceptor(conf, tst.Standby)(context.Background(), tst.Method, nil, nil, primary, invoker(tst.Errors, &servers))
+			if tst.ExpectedError {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+			assert.Equal(tst.ExpectedServers, servers)
+		})
+	}
+}
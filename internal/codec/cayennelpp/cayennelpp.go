@@ -6,7 +6,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"sort"
+	"strconv"
 
 	"github.com/pkg/errors"
 )
@@ -23,8 +25,16 @@ const (
 	lppHumiditySensor    byte = 104
 	lppAccelerometer     byte = 113
 	lppBarometer         byte = 115
+	lppVoltage           byte = 116
+	lppCurrent           byte = 117
+	lppPercentage        byte = 120
+	lppConcentration     byte = 125
+	lppPower             byte = 128
+	lppUnixTime          byte = 133
 	lppGyrometer         byte = 134
+	lppColour            byte = 135
 	lppGPSLocation       byte = 136
+	lppSwitch            byte = 142
 )
 
 type accelerometer struct {
@@ -45,6 +55,12 @@ type gpsLocation struct {
 	Altitude  float64 `json:"altitude"`
 }
 
+type colour struct {
+	R uint8 `json:"r"`
+	G uint8 `json:"g"`
+	B uint8 `json:"b"`
+}
+
 type cayenneLPP struct {
 	DigitalInput      map[byte]uint8         `json:"digitalInput,omitempty" influxdb:"digital_input"`
 	DigitalOutput     map[byte]uint8         `json:"digitalOutput,omitempty" influxdb:"digital_output"`
@@ -58,6 +74,14 @@ type cayenneLPP struct {
 	Barometer         map[byte]float64       `json:"barometer,omitempty" influxdb:"barometer"`
 	Gyrometer         map[byte]gyrometer     `json:"gyrometer,omitempty" influxdb:"gyrometer"`
 	GPSLocation       map[byte]gpsLocation   `json:"gpsLocation,omitempty" influxdb:"gps_location"`
+	Voltage           map[byte]float64       `json:"voltage,omitempty" influxdb:"voltage"`
+	Current           map[byte]float64       `json:"current,omitempty" influxdb:"current"`
+	Percentage        map[byte]uint8         `json:"percentage,omitempty" influxdb:"percentage"`
+	Concentration     map[byte]uint16        `json:"concentration,omitempty" influxdb:"concentration"`
+	Power             map[byte]uint16        `json:"power,omitempty" influxdb:"power"`
+	UnixTime          map[byte]uint32        `json:"unixTime,omitempty" influxdb:"unix_time"`
+	Colour            map[byte]colour        `json:"colour,omitempty" influxdb:"colour"`
+	Switch            map[byte]uint8         `json:"switch,omitempty" influxdb:"switch"`
 }
 
 // BinaryToJSON encodes the given binary payload to JSON.
@@ -102,6 +126,22 @@ func BinaryToJSON(b []byte) ([]byte, error) {
 			err = lppGyrometerDecode(buf[0], r, &lpp)
 		case lppGPSLocation:
 			err = lppGPSLocationDecode(buf[0], r, &lpp)
+		case lppVoltage:
+			err = lppVoltageDecode(buf[0], r, &lpp)
+		case lppCurrent:
+			err = lppCurrentDecode(buf[0], r, &lpp)
+		case lppPercentage:
+			err = lppPercentageDecode(buf[0], r, &lpp)
+		case lppConcentration:
+			err = lppConcentrationDecode(buf[0], r, &lpp)
+		case lppPower:
+			err = lppPowerDecode(buf[0], r, &lpp)
+		case lppUnixTime:
+			err = lppUnixTimeDecode(buf[0], r, &lpp)
+		case lppColour:
+			err = lppColourDecode(buf[0], r, &lpp)
+		case lppSwitch:
+			err = lppSwitchDecode(buf[0], r, &lpp)
 		default:
 			return nil, fmt.Errorf("invalid data type: %d", buf[1])
 		}
@@ -114,10 +154,39 @@ func BinaryToJSON(b []byte) ([]byte, error) {
 	return json.Marshal(lpp)
 }
 
+// BinaryToJSONChannelArrays encodes the given binary payload to JSON. Unlike
+// BinaryToJSON, the values of each type are returned as an array in which the
+// index is the channel, e.g. {"temperatureSensor": [null, 21.5]} for a
+// single temperature on channel 1. This is the array form accepted by
+// JSONToBinary.
+func BinaryToJSONChannelArrays(b []byte) ([]byte, error) {
+	b, err := BinaryToJSON(b)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err = channelObjectsToArrays(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "json marshal error")
+	}
+
+	return b, nil
+}
+
 // JSONToBinary encodes the given JSON payload to binary.
+//
+// The values of each type can be given as an object keyed by channel (as
+// returned by BinaryToJSON), or as an array in which the index is the
+// channel. Null array items are skipped, e.g. [null, 21.5] only encodes
+// channel 1.
 func JSONToBinary(b []byte) ([]byte, error) {
 	var lpp cayenneLPP
 
+	b, err := channelArraysToObjects(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "json unmarshal error")
+	}
+
 	if err := json.Unmarshal(b, &lpp); err != nil {
 		return nil, errors.Wrap(err, "json unmarshal error")
 	}
@@ -271,9 +340,181 @@ func JSONToBinary(b []byte) ([]byte, error) {
 		}
 	}
 
+	// Voltage
+	channels = make([]uint8, 0, len(lpp.Voltage))
+	for k := range lpp.Voltage {
+		channels = append(channels, k)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	for _, c := range channels {
+		if err := lppVoltageEncode(c, w, lpp.Voltage[c]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Current
+	channels = make([]uint8, 0, len(lpp.Current))
+	for k := range lpp.Current {
+		channels = append(channels, k)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	for _, c := range channels {
+		if err := lppCurrentEncode(c, w, lpp.Current[c]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Percentage
+	channels = make([]uint8, 0, len(lpp.Percentage))
+	for k := range lpp.Percentage {
+		channels = append(channels, k)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	for _, c := range channels {
+		if err := lppPercentageEncode(c, w, lpp.Percentage[c]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Concentration
+	channels = make([]uint8, 0, len(lpp.Concentration))
+	for k := range lpp.Concentration {
+		channels = append(channels, k)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	for _, c := range channels {
+		if err := lppConcentrationEncode(c, w, lpp.Concentration[c]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Power
+	channels = make([]uint8, 0, len(lpp.Power))
+	for k := range lpp.Power {
+		channels = append(channels, k)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	for _, c := range channels {
+		if err := lppPowerEncode(c, w, lpp.Power[c]); err != nil {
+			return nil, err
+		}
+	}
+
+	// UnixTime
+	channels = make([]uint8, 0, len(lpp.UnixTime))
+	for k := range lpp.UnixTime {
+		channels = append(channels, k)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	for _, c := range channels {
+		if err := lppUnixTimeEncode(c, w, lpp.UnixTime[c]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Colour
+	channels = make([]uint8, 0, len(lpp.Colour))
+	for k := range lpp.Colour {
+		channels = append(channels, k)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	for _, c := range channels {
+		if err := lppColourEncode(c, w, lpp.Colour[c]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Switch
+	channels = make([]uint8, 0, len(lpp.Switch))
+	for k := range lpp.Switch {
+		channels = append(channels, k)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	for _, c := range channels {
+		if err := lppSwitchEncode(c, w, lpp.Switch[c]); err != nil {
+			return nil, err
+		}
+	}
+
 	return w.Bytes(), nil
 }
 
+// channelArraysToObjects converts the type values given as array into an
+// object keyed by the array index (channel).
+func channelArraysToObjects(b []byte) ([]byte, error) {
+	var types map[string]json.RawMessage
+	if err := json.Unmarshal(b, &types); err != nil {
+		return nil, err
+	}
+
+	for k, v := range types {
+		var values []json.RawMessage
+		if err := json.Unmarshal(v, &values); err != nil {
+			// not an array
+			continue
+		}
+
+		if len(values) > 256 {
+			return nil, fmt.Errorf("%s: max. 256 channels", k)
+		}
+
+		obj := make(map[string]json.RawMessage)
+		for i, val := range values {
+			if string(val) == "null" {
+				continue
+			}
+			obj[strconv.Itoa(i)] = val
+		}
+
+		objB, err := json.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		types[k] = objB
+	}
+
+	return json.Marshal(types)
+}
+
+// channelObjectsToArrays converts the type values given as object keyed by
+// channel into an array in which the index is the channel. Channels without
+// value are set to null.
+func channelObjectsToArrays(b []byte) ([]byte, error) {
+	var types map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(b, &types); err != nil {
+		return nil, err
+	}
+
+	out := make(map[string][]json.RawMessage, len(types))
+	for k, v := range types {
+		values := make([]json.RawMessage, 0, len(v))
+		for c, val := range v {
+			channel, err := strconv.ParseUint(c, 10, 8)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s: invalid channel", k)
+			}
+			for int(channel) >= len(values) {
+				values = append(values, json.RawMessage("null"))
+			}
+			values[channel] = val
+		}
+		out[k] = values
+	}
+
+	return json.Marshal(out)
+}
+
+// scaleUint16 returns the given value multiplied by the given factor and
+// rounded to the nearest integer. An error is returned when the result does
+// not fit in an uint16.
+func scaleUint16(v, factor float64) (uint16, error) {
+	s := math.Round(v * factor)
+	if math.IsNaN(s) || s < 0 || s > math.MaxUint16 {
+		return 0, fmt.Errorf("value %v out of range (0 - %v)", v, math.MaxUint16/factor)
+	}
+	return uint16(s), nil
+}
+
 func lppDigitalInputDecode(channel uint8, r io.Reader, out *cayenneLPP) error {
 	var b uint8
 	if err := binary.Read(r, binary.BigEndian, &b); err != nil {
@@ -480,8 +721,12 @@ func lppBarometerDecode(channel uint8, r io.Reader, out *cayenneLPP) error {
 }
 
 func lppBarometerEncode(channel uint8, w io.Writer, data float64) error {
+	v, err := scaleUint16(data, 10)
+	if err != nil {
+		return errors.Wrap(err, "barometer")
+	}
 	w.Write([]byte{channel, lppBarometer})
-	if err := binary.Write(w, binary.BigEndian, uint16(data*10)); err != nil {
+	if err := binary.Write(w, binary.BigEndian, v); err != nil {
 		return errors.Wrap(err, "write uint16 error")
 	}
 	return nil
@@ -560,3 +805,175 @@ func lppGPSLocationEncode(channel uint8, w io.Writer, data gpsLocation) error {
 	}
 	return nil
 }
+
+func lppVoltageDecode(channel uint8, r io.Reader, out *cayenneLPP) error {
+	var v uint16
+	if err := binary.Read(r, binary.BigEndian, &v); err != nil {
+		return errors.Wrap(err, "read uint16 error")
+	}
+	if out.Voltage == nil {
+		out.Voltage = make(map[uint8]float64)
+	}
+	out.Voltage[channel] = float64(v) / 100
+	return nil
+}
+
+func lppVoltageEncode(channel uint8, w io.Writer, data float64) error {
+	v, err := scaleUint16(data, 100)
+	if err != nil {
+		return errors.Wrap(err, "voltage")
+	}
+	w.Write([]byte{channel, lppVoltage})
+	if err := binary.Write(w, binary.BigEndian, v); err != nil {
+		return errors.Wrap(err, "write uint16 error")
+	}
+	return nil
+}
+
+func lppCurrentDecode(channel uint8, r io.Reader, out *cayenneLPP) error {
+	var v uint16
+	if err := binary.Read(r, binary.BigEndian, &v); err != nil {
+		return errors.Wrap(err, "read uint16 error")
+	}
+	if out.Current == nil {
+		out.Current = make(map[uint8]float64)
+	}
+	out.Current[channel] = float64(v) / 1000
+	return nil
+}
+
+func lppCurrentEncode(channel uint8, w io.Writer, data float64) error {
+	v, err := scaleUint16(data, 1000)
+	if err != nil {
+		return errors.Wrap(err, "current")
+	}
+	w.Write([]byte{channel, lppCurrent})
+	if err := binary.Write(w, binary.BigEndian, v); err != nil {
+		return errors.Wrap(err, "write uint16 error")
+	}
+	return nil
+}
+
+func lppPercentageDecode(channel uint8, r io.Reader, out *cayenneLPP) error {
+	var b uint8
+	if err := binary.Read(r, binary.BigEndian, &b); err != nil {
+		return errors.Wrap(err, "read uint8 error")
+	}
+	if out.Percentage == nil {
+		out.Percentage = make(map[uint8]uint8)
+	}
+	out.Percentage[channel] = b
+	return nil
+}
+
+func lppPercentageEncode(channel uint8, w io.Writer, data uint8) error {
+	w.Write([]byte{channel, lppPercentage})
+	if err := binary.Write(w, binary.BigEndian, data); err != nil {
+		return errors.Wrap(err, "write uint8 error")
+	}
+	return nil
+}
+
+func lppConcentrationDecode(channel uint8, r io.Reader, out *cayenneLPP) error {
+	var v uint16
+	if err := binary.Read(r, binary.BigEndian, &v); err != nil {
+		return errors.Wrap(err, "read uint16 error")
+	}
+	if out.Concentration == nil {
+		out.Concentration = make(map[uint8]uint16)
+	}
+	out.Concentration[channel] = v
+	return nil
+}
+
+func lppConcentrationEncode(channel uint8, w io.Writer, data uint16) error {
+	w.Write([]byte{channel, lppConcentration})
+	if err := binary.Write(w, binary.BigEndian, data); err != nil {
+		return errors.Wrap(err, "write uint16 error")
+	}
+	return nil
+}
+
+func lppPowerDecode(channel uint8, r io.Reader, out *cayenneLPP) error {
+	var v uint16
+	if err := binary.Read(r, binary.BigEndian, &v); err != nil {
+		return errors.Wrap(err, "read uint16 error")
+	}
+	if out.Power == nil {
+		out.Power = make(map[uint8]uint16)
+	}
+	out.Power[channel] = v
+	return nil
+}
+
+func lppPowerEncode(channel uint8, w io.Writer, data uint16) error {
+	w.Write([]byte{channel, lppPower})
+	if err := binary.Write(w, binary.BigEndian, data); err != nil {
+		return errors.Wrap(err, "write uint16 error")
+	}
+	return nil
+}
+
+func lppUnixTimeDecode(channel uint8, r io.Reader, out *cayenneLPP) error {
+	var v uint32
+	if err := binary.Read(r, binary.BigEndian, &v); err != nil {
+		return errors.Wrap(err, "read uint32 error")
+	}
+	if out.UnixTime == nil {
+		out.UnixTime = make(map[uint8]uint32)
+	}
+	out.UnixTime[channel] = v
+	return nil
+}
+
+func lppUnixTimeEncode(channel uint8, w io.Writer, data uint32) error {
+	w.Write([]byte{channel, lppUnixTime})
+	if err := binary.Write(w, binary.BigEndian, data); err != nil {
+		return errors.Wrap(err, "write uint32 error")
+	}
+	return nil
+}
+
+func lppColourDecode(channel uint8, r io.Reader, out *cayenneLPP) error {
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return errors.Wrap(err, "read error")
+	}
+	if out.Colour == nil {
+		out.Colour = make(map[uint8]colour)
+	}
+	out.Colour[channel] = colour{
+		R: buf[0],
+		G: buf[1],
+		B: buf[2],
+	}
+	return nil
+}
+
+func lppColourEncode(channel uint8, w io.Writer, data colour) error {
+	w.Write([]byte{channel, lppColour})
+	if _, err := w.Write([]byte{data.R, data.G, data.B}); err != nil {
+		return errors.Wrap(err, "write error")
+	}
+	return nil
+}
+
+func lppSwitchDecode(channel uint8, r io.Reader, out *cayenneLPP) error {
+	var b uint8
+	if err := binary.Read(r, binary.BigEndian, &b); err != nil {
+		return errors.Wrap(err, "read uint8 error")
+	}
+	if out.Switch == nil {
+		out.Switch = make(map[uint8]uint8)
+	}
+	out.Switch[channel] = b
+	return nil
+}
+
+func lppSwitchEncode(channel uint8, w io.Writer, data uint8) error {
+	w.Write([]byte{channel, lppSwitch})
+	if err := binary.Write(w, binary.BigEndian, data); err != nil {
+		return errors.Wrap(err, "write uint8 error")
+	}
+	return nil
+}
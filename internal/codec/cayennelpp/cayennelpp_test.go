@@ -132,6 +132,85 @@ func TestCayenneLPP(t *testing.T) {
 				},
 			},
 		},
+		{
+			Name:  "2 voltage sensors",
+			Bytes: []byte{3, 116, 1, 74, 5, 116, 9, 196},
+			Struct: cayenneLPP{
+				Voltage: map[byte]float64{
+					3: 3.3,
+					5: 25,
+				},
+			},
+		},
+		{
+			Name:  "2 current sensors",
+			Bytes: []byte{3, 117, 0, 250, 5, 117, 39, 16},
+			Struct: cayenneLPP{
+				Current: map[byte]float64{
+					3: 0.25,
+					5: 10,
+				},
+			},
+		},
+		{
+			Name:  "2 percentage sensors",
+			Bytes: []byte{3, 120, 55, 5, 120, 100},
+			Struct: cayenneLPP{
+				Percentage: map[byte]uint8{
+					3: 55,
+					5: 100,
+				},
+			},
+		},
+		{
+			Name:  "2 concentration sensors",
+			Bytes: []byte{3, 125, 1, 144, 5, 125, 3, 232},
+			Struct: cayenneLPP{
+				Concentration: map[byte]uint16{
+					3: 400,
+					5: 1000,
+				},
+			},
+		},
+		{
+			Name:  "2 power sensors",
+			Bytes: []byte{3, 128, 0, 100, 5, 128, 7, 208},
+			Struct: cayenneLPP{
+				Power: map[byte]uint16{
+					3: 100,
+					5: 2000,
+				},
+			},
+		},
+		{
+			Name:  "unix time",
+			Bytes: []byte{1, 133, 95, 94, 16, 0},
+			Struct: cayenneLPP{
+				UnixTime: map[byte]uint32{
+					1: 1600000000,
+				},
+			},
+		},
+		{
+			Name:  "2 colours",
+			Bytes: []byte{3, 135, 255, 128, 0, 5, 135, 0, 0, 255},
+			Struct: cayenneLPP{
+				Colour: map[byte]colour{
+					3: {R: 255, G: 128, B: 0},
+					5: {R: 0, G: 0, B: 255},
+				},
+			},
+		},
+		{
+			Name:  "2 switches",
+			Bytes: []byte{3, 142, 1, 5, 142, 0},
+			Struct: cayenneLPP{
+				Switch: map[byte]uint8{
+					3: 1,
+					5: 0,
+				},
+			},
+		},
 	}
 
 	for _, tst := range tests {
@@ -162,3 +241,149 @@ func TestCayenneLPP(t *testing.T) {
 		})
 	}
 }
+
+func TestCayenneLPPChannelArrays(t *testing.T) {
+	tests := []struct {
+		Name          string
+		JSON          string
+		ExpectedBytes []byte
+		ExpectedError bool
+	}{
+		{
+			Name:          "temperature array",
+			JSON:          `{"temperatureSensor": [27.2, null, 25.5]}`,
+			ExpectedBytes: []byte{0, 103, 1, 16, 2, 103, 0, 255},
+		},
+		{
+			Name:          "array and object",
+			JSON:          `{"digitalOutput": [null, 1], "switch": {"3": 1}}`,
+			ExpectedBytes: []byte{1, 1, 1, 3, 142, 1},
+		},
+		{
+			Name:          "colour array",
+			JSON:          `{"colour": [{"r": 255, "g": 128, "b": 0}]}`,
+			ExpectedBytes: []byte{0, 135, 255, 128, 0},
+		},
+		{
+			Name:          "invalid json",
+			JSON:          `[1, 2, 3]`,
+			ExpectedError: true,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			b, err := JSONToBinary([]byte(tst.JSON))
+			if tst.ExpectedError {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tst.ExpectedBytes, b)
+		})
+	}
+}
+
+func TestCayenneLPPChannelArraysDecode(t *testing.T) {
+	tests := []struct {
+		Name         string
+		Bytes        []byte
+		ExpectedJSON string
+	}{
+		{
+			Name:         "temperature array",
+			Bytes:        []byte{0, 103, 1, 16, 2, 103, 0, 255},
+			ExpectedJSON: `{"temperatureSensor":[27.2,null,25.5]}`,
+		},
+		{
+			Name:         "multiple types",
+			Bytes:        []byte{1, 1, 1, 3, 142, 1},
+			ExpectedJSON: `{"digitalOutput":[null,1],"switch":[null,null,null,1]}`,
+		},
+		{
+			Name:         "colour",
+			Bytes:        []byte{0, 135, 255, 128, 0},
+			ExpectedJSON: `{"colour":[{"r":255,"g":128,"b":0}]}`,
+		},
+		{
+			Name:         "empty",
+			ExpectedJSON: `{}`,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			jsonB, err := BinaryToJSONChannelArrays(tst.Bytes)
+			assert.NoError(err)
+			assert.Equal(tst.ExpectedJSON, string(jsonB))
+
+			// the array form must encode to the same payload
+			b, err := JSONToBinary(jsonB)
+			assert.NoError(err)
+			assert.Equal(len(tst.Bytes), len(b))
+			if len(tst.Bytes) > 0 {
+				assert.Equal(tst.Bytes, b)
+			}
+		})
+	}
+}
+
+func TestCayenneLPPOutOfRange(t *testing.T) {
+	tests := []struct {
+		Name          string
+		JSON          string
+		ExpectedError string
+	}{
+		{
+			Name:          "negative voltage",
+			JSON:          `{"voltage": {"1": -1}}`,
+			ExpectedError: "voltage: value -1 out of range (0 - 655.35)",
+		},
+		{
+			Name:          "voltage too large",
+			JSON:          `{"voltage": {"1": 700}}`,
+			ExpectedError: "voltage: value 700 out of range (0 - 655.35)",
+		},
+		{
+			Name:          "negative current",
+			JSON:          `{"current": {"1": -0.5}}`,
+			ExpectedError: "current: value -0.5 out of range (0 - 65.535)",
+		},
+		{
+			Name:          "current too large",
+			JSON:          `{"current": {"1": 66}}`,
+			ExpectedError: "current: value 66 out of range (0 - 65.535)",
+		},
+		{
+			Name:          "negative barometer",
+			JSON:          `{"barometer": {"1": -10}}`,
+			ExpectedError: "barometer: value -10 out of range (0 - 6553.5)",
+		},
+		{
+			Name:          "barometer too large",
+			JSON:          `{"barometer": {"1": 7000}}`,
+			ExpectedError: "barometer: value 7000 out of range (0 - 6553.5)",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			_, err := JSONToBinary([]byte(tst.JSON))
+			assert.EqualError(err, tst.ExpectedError)
+		})
+	}
+
+	t.Run("max values", func(t *testing.T) {
+		assert := require.New(t)
+
+		b, err := JSONToBinary([]byte(`{"voltage": {"1": 655.35}, "current": {"2": 65.535}}`))
+		assert.NoError(err)
+		assert.Equal([]byte{1, 116, 255, 255, 2, 117, 255, 255}, b)
+	})
+}
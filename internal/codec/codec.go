@@ -2,9 +2,13 @@ package codec
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/ibrahimozekici/app-server2/internal/codec/cayennelpp"
+	"github.com/ibrahimozekici/app-server2/internal/codec/declarative"
+	"github.com/ibrahimozekici/app-server2/internal/codec/external"
 	"github.com/ibrahimozekici/app-server2/internal/codec/js"
+	"github.com/ibrahimozekici/app-server2/internal/codec/lua"
 	"github.com/lib/pq/hstore"
 )
 
@@ -13,13 +17,47 @@ type Type string
 
 // Available codec types.
 const (
-	None                = ""
-	CayenneLPPType Type = "CAYENNE_LPP"
-	CustomJSType   Type = "CUSTOM_JS"
+	None                    = ""
+	CayenneLPPType     Type = "CAYENNE_LPP"
+	CustomJSType       Type = "CUSTOM_JS"
+	CustomJSES2015Type Type = "CUSTOM_JS_ES2015"
+	CustomLuaType      Type = "CUSTOM_LUA"
+	ExternalGRPCType   Type = "EXTERNAL_GRPC"
+	DeclarativeType    Type = "DECLARATIVE"
+)
+
+// CayenneLPPChannelArraysVariable defines the device variable which, when set
+// to "true", makes the Cayenne LPP codec return the values of each type as
+// an array in which the index is the channel.
+const CayenneLPPChannelArraysVariable = "cayenne_lpp_channel_arrays"
+
+// Limits of the messages logged by a script when testing a codec.
+const (
+	maxLogLines      = 100
+	maxLogLineLength = 1024
 )
 
 // BinaryToJSON encodes the given binary payload to JSON.
 func BinaryToJSON(t Type, fPort uint8, variables hstore.Hstore, decodeScript string, b []byte) ([]byte, error) {
+	start := time.Now()
+	out, err := binaryToJSON(t, fPort, variables, decodeScript, b, nil)
+	codecDuration(t, "decode").Observe(float64(time.Since(start)) / float64(time.Second))
+	if err != nil {
+		codecErrorCounter(t, "decode").Inc()
+	}
+	return out, err
+}
+
+// BinaryToJSONWithLogs is equal to BinaryToJSON, but also returns the
+// messages logged by the script (e.g. using console.log). This is intended
+// for testing codecs and returns the logged messages on error too.
+func BinaryToJSONWithLogs(t Type, fPort uint8, variables hstore.Hstore, decodeScript string, b []byte) ([]byte, []string, error) {
+	var l logCollector
+	out, err := binaryToJSON(t, fPort, variables, decodeScript, b, l.log)
+	return out, l.lines, err
+}
+
+func binaryToJSON(t Type, fPort uint8, variables hstore.Hstore, decodeScript string, b []byte, logFn func(string)) ([]byte, error) {
 	vars := make(map[string]string)
 	for k, v := range variables.Map {
 		if v.Valid {
@@ -29,9 +67,20 @@ func BinaryToJSON(t Type, fPort uint8, variables hstore.Hstore, decodeScript str
 
 	switch t {
 	case CayenneLPPType:
+		if vars[CayenneLPPChannelArraysVariable] == "true" {
+			return cayennelpp.BinaryToJSONChannelArrays(b)
+		}
 		return cayennelpp.BinaryToJSON(b)
 	case CustomJSType:
-		return js.BinaryToJSON(fPort, vars, decodeScript, b)
+		return js.BinaryToJSONWithLog(fPort, vars, decodeScript, b, logFn)
+	case CustomJSES2015Type:
+		return js.BinaryToJSONES2015(fPort, vars, decodeScript, b, logFn)
+	case CustomLuaType:
+		return lua.BinaryToJSONWithLog(fPort, vars, decodeScript, b, logFn)
+	case ExternalGRPCType:
+		return external.BinaryToJSON(fPort, vars, decodeScript, b)
+	case DeclarativeType:
+		return declarative.BinaryToJSON(fPort, decodeScript, b)
 	default:
 		return nil, fmt.Errorf("unknown codec type: %s", t)
 	}
@@ -39,6 +88,25 @@ func BinaryToJSON(t Type, fPort uint8, variables hstore.Hstore, decodeScript str
 
 // JSONToBinary encodes the given JSON to binary.
 func JSONToBinary(t Type, fPort uint8, variables hstore.Hstore, encodeScript string, jsonB []byte) ([]byte, error) {
+	start := time.Now()
+	out, err := jsonToBinary(t, fPort, variables, encodeScript, jsonB, nil)
+	codecDuration(t, "encode").Observe(float64(time.Since(start)) / float64(time.Second))
+	if err != nil {
+		codecErrorCounter(t, "encode").Inc()
+	}
+	return out, err
+}
+
+// JSONToBinaryWithLogs is equal to JSONToBinary, but also returns the
+// messages logged by the script (e.g. using console.log). This is intended
+// for testing codecs and returns the logged messages on error too.
+func JSONToBinaryWithLogs(t Type, fPort uint8, variables hstore.Hstore, encodeScript string, jsonB []byte) ([]byte, []string, error) {
+	var l logCollector
+	out, err := jsonToBinary(t, fPort, variables, encodeScript, jsonB, l.log)
+	return out, l.lines, err
+}
+
+func jsonToBinary(t Type, fPort uint8, variables hstore.Hstore, encodeScript string, jsonB []byte, logFn func(string)) ([]byte, error) {
 	vars := make(map[string]string)
 	for k, v := range variables.Map {
 		if v.Valid {
@@ -50,8 +118,42 @@ func JSONToBinary(t Type, fPort uint8, variables hstore.Hstore, encodeScript str
 	case CayenneLPPType:
 		return cayennelpp.JSONToBinary(jsonB)
 	case CustomJSType:
-		return js.JSONToBinary(fPort, vars, encodeScript, jsonB)
+		return js.JSONToBinaryWithLog(fPort, vars, encodeScript, jsonB, logFn)
+	case CustomJSES2015Type:
+		return js.JSONToBinaryES2015(fPort, vars, encodeScript, jsonB, logFn)
+	case CustomLuaType:
+		return lua.JSONToBinaryWithLog(fPort, vars, encodeScript, jsonB, logFn)
+	case ExternalGRPCType:
+		return external.JSONToBinary(fPort, vars, encodeScript, jsonB)
+	case DeclarativeType:
+		return declarative.JSONToBinary(fPort, encodeScript, jsonB)
 	default:
 		return nil, fmt.Errorf("unknown codec type: %s", t)
 	}
 }
+
+// ValidateDecoderScript validates the decoder script of the given codec
+// type. Only the declarative codec template is validated, scripts are only
+// validated on execution.
+func ValidateDecoderScript(t Type, decodeScript string) error {
+	if t == DeclarativeType {
+		return declarative.Validate(decodeScript)
+	}
+	return nil
+}
+
+// logCollector collects the logged messages, up to maxLogLines messages
+// of at most maxLogLineLength bytes.
+type logCollector struct {
+	lines []string
+}
+
+func (l *logCollector) log(s string) {
+	if len(l.lines) >= maxLogLines {
+		return
+	}
+	if len(s) > maxLogLineLength {
+		s = s[:maxLogLineLength]
+	}
+	l.lines = append(l.lines, s)
+}
@@ -0,0 +1,168 @@
+package codec
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq/hstore"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBinaryToJSONWithLogs(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Type          Type
+		Script        string
+		ExpectedJSON  string
+		ExpectedLogs  []string
+		ExpectedError string
+	}{
+		{
+			Name: "js",
+			Type: CustomJSType,
+			Script: `
+				function Decode(fPort, bytes) {
+					console.log("fPort", fPort, "length", bytes.length);
+					return {"value": bytes[0]};
+				}
+			`,
+			ExpectedJSON: `{"value":5}`,
+			ExpectedLogs: []string{"fPort 10 length 1"},
+		},
+		{
+			Name: "js error",
+			Type: CustomJSType,
+			Script: `
+				function Decode(fPort, bytes) {
+					console.log("before error");
+					throw "decode failed";
+				}
+			`,
+			ExpectedLogs:  []string{"before error"},
+			ExpectedError: "execute js error: js vm error: decode failed",
+		},
+		{
+			Name: "lua",
+			Type: CustomLuaType,
+			Script: `
+				function Decode(fPort, bytes)
+					print("fPort", fPort)
+					return { value = bytes[1] }
+				end
+			`,
+			ExpectedJSON: `{"value":5}`,
+			ExpectedLogs: []string{"fPort\t10"},
+		},
+		{
+			Name:         "cayenne lpp",
+			Type:         CayenneLPPType,
+			ExpectedJSON: `{}`,
+		},
+		{
+			Name:         "declarative",
+			Type:         DeclarativeType,
+			Script:       `fields: [{name: value, offset: 0, type: uint8}]`,
+			ExpectedJSON: `{"value":5}`,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			b := []byte{5}
+			if tst.Type == CayenneLPPType {
+				b = nil
+			}
+
+			out, logs, err := BinaryToJSONWithLogs(tst.Type, 10, hstore.Hstore{}, tst.Script, b)
+			assert.Equal(tst.ExpectedLogs, logs)
+			if tst.ExpectedError != "" {
+				assert.EqualError(err, tst.ExpectedError)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tst.ExpectedJSON, string(out))
+		})
+	}
+}
+
+func TestBinaryToJSONCayenneLPPChannelArrays(t *testing.T) {
+	assert := require.New(t)
+
+	b := []byte{1, 103, 0, 255}
+
+	out, err := BinaryToJSON(CayenneLPPType, 10, hstore.Hstore{}, "", b)
+	assert.NoError(err)
+	assert.Equal(`{"temperatureSensor":{"1":25.5}}`, string(out))
+
+	vars := hstore.Hstore{
+		Map: map[string]sql.NullString{
+			CayenneLPPChannelArraysVariable: {String: "true", Valid: true},
+		},
+	}
+	out, err = BinaryToJSON(CayenneLPPType, 10, vars, "", b)
+	assert.NoError(err)
+	assert.Equal(`{"temperatureSensor":[null,25.5]}`, string(out))
+}
+
+func TestJSONToBinaryWithLogs(t *testing.T) {
+	assert := require.New(t)
+
+	out, logs, err := JSONToBinaryWithLogs(CustomJSType, 10, hstore.Hstore{}, `
+		function Encode(fPort, obj) {
+			console.log("value", obj.value);
+			return [obj.value];
+		}
+	`, []byte(`{"value":5}`))
+	assert.NoError(err)
+	assert.Equal([]byte{5}, out)
+	assert.Equal([]string{"value 5"}, logs)
+}
+
+func TestValidateDecoderScript(t *testing.T) {
+	assert := require.New(t)
+
+	assert.NoError(ValidateDecoderScript(CustomJSType, "not validated"))
+	assert.NoError(ValidateDecoderScript(DeclarativeType, `fields: [{name: value, offset: 0, type: uint8}]`))
+	assert.Error(ValidateDecoderScript(DeclarativeType, `fields: [{name: value, offset: 0, type: foo}]`))
+}
+
+func TestLogCollector(t *testing.T) {
+	assert := require.New(t)
+
+	var l logCollector
+	for i := 0; i < maxLogLines+10; i++ {
+		l.log(strings.Repeat("x", maxLogLineLength+10))
+	}
+
+	assert.Len(l.lines, maxLogLines)
+	for _, line := range l.lines {
+		assert.Len(line, maxLogLineLength)
+	}
+}
+
+func TestBinaryToJSONMetrics(t *testing.T) {
+	assert := require.New(t)
+
+	errCount := codecErrorCounter(CustomJSType, "decode")
+	before := testutil.ToFloat64(errCount)
+
+	_, err := BinaryToJSON(CustomJSType, 10, hstore.Hstore{}, `
+		function Decode(fPort, bytes) {
+			return {"value": bytes[0]};
+		}
+	`, []byte{5})
+	assert.NoError(err)
+	assert.Equal(before, testutil.ToFloat64(errCount))
+
+	_, err = BinaryToJSON(CustomJSType, 10, hstore.Hstore{}, `
+		function Decode(fPort, bytes) {
+			throw "decode failed";
+		}
+	`, []byte{5})
+	assert.Error(err)
+	assert.Equal(before+1, testutil.ToFloat64(errCount))
+}
@@ -0,0 +1,436 @@
+// Package declarative implements a codec which decodes the payload using a
+// template describing the payload layout, instead of executing a script.
+//
+// The template is given as YAML or JSON, e.g.:
+//
+//	endianness: big
+//	fields:
+//	  - name: temperature
+//	    offset: 0
+//	    type: int16
+//	    scale: 0.01
+//	  - name: humidity
+//	    offset: 2
+//	    type: uint8
+//	    scale: 0.5
+//	  - name: battery_low
+//	    offset: 3
+//	    type: bool
+//	    bit: 7
+//	ports:
+//	  - fPort: 2
+//	    fields:
+//	      - name: interval
+//	        offset: 0
+//	        type: uint16
+//
+// The fields of the ports item matching the uplink fPort are used, the
+// top-level fields are used for all other fPorts.
+package declarative
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Field types.
+const (
+	typeUint8   = "uint8"
+	typeInt8    = "int8"
+	typeUint16  = "uint16"
+	typeInt16   = "int16"
+	typeUint24  = "uint24"
+	typeInt24   = "int24"
+	typeUint32  = "uint32"
+	typeInt32   = "int32"
+	typeFloat32 = "float32"
+	typeFloat64 = "float64"
+	typeBool    = "bool"
+	typeHex     = "hex"
+	typeString  = "string"
+)
+
+// Endianness values.
+const (
+	bigEndian    = "big"
+	littleEndian = "little"
+)
+
+// maxFields defines the max. number of fields of a template.
+const maxFields = 256
+
+// typeSizes contains the size in bytes of the fixed-size types.
+var typeSizes = map[string]int{
+	typeUint8:   1,
+	typeInt8:    1,
+	typeUint16:  2,
+	typeInt16:   2,
+	typeUint24:  3,
+	typeInt24:   3,
+	typeUint32:  4,
+	typeInt32:   4,
+	typeFloat32: 4,
+	typeFloat64: 8,
+	typeBool:    1,
+}
+
+// Template defines the payload layout.
+type Template struct {
+	// Endianness of the multi-byte fields (big or little, default big).
+	Endianness string `yaml:"endianness"`
+
+	// Fields contained by the payload.
+	Fields []Field `yaml:"fields"`
+
+	// Ports contains the fPort specific fields.
+	Ports []Port `yaml:"ports"`
+}
+
+// Port defines the fields of the payloads sent on the given fPort.
+type Port struct {
+	FPort  uint8   `yaml:"fPort"`
+	Fields []Field `yaml:"fields"`
+}
+
+// Field defines a single payload field.
+type Field struct {
+	// Name of the field in the decoded object.
+	Name string `yaml:"name"`
+
+	// Offset in bytes from the start of the payload.
+	Offset int `yaml:"offset"`
+
+	// Type of the field.
+	Type string `yaml:"type"`
+
+	// Endianness overrides the template endianness for this field.
+	Endianness string `yaml:"endianness"`
+
+	// Length in bytes, only used (and required) by the hex and string types.
+	Length int `yaml:"length"`
+
+	// Bit and Bits extract Bits bits (default 1), starting at bit Bit
+	// (0 = least significant bit) from an unsigned integer or bool field.
+	Bit  *int `yaml:"bit"`
+	Bits int  `yaml:"bits"`
+
+	// Scale and Add are applied to numeric fields: value * scale + add.
+	// A scale of 0 is interpreted as 1.
+	Scale float64 `yaml:"scale"`
+	Add   float64 `yaml:"add"`
+
+	// Optional fields are omitted when the payload is too short, instead of
+	// returning an error.
+	Optional bool `yaml:"optional"`
+}
+
+// Parse parses and validates the given template (YAML or JSON).
+func Parse(template string) (Template, error) {
+	var t Template
+	if err := yaml.UnmarshalStrict([]byte(template), &t); err != nil {
+		return t, errors.Wrap(err, "parse template error")
+	}
+
+	if err := t.Validate(); err != nil {
+		return t, err
+	}
+
+	return t, nil
+}
+
+// Validate validates the template.
+func (t Template) Validate() error {
+	if err := validateEndianness(t.Endianness); err != nil {
+		return err
+	}
+
+	if len(t.Fields) == 0 && len(t.Ports) == 0 {
+		return errors.New("template must contain at least one field")
+	}
+
+	if err := validateFields(t.Fields); err != nil {
+		return err
+	}
+
+	ports := make(map[uint8]struct{})
+	for _, p := range t.Ports {
+		if _, ok := ports[p.FPort]; ok {
+			return fmt.Errorf("fPort %d: duplicate fPort", p.FPort)
+		}
+		ports[p.FPort] = struct{}{}
+
+		if len(p.Fields) == 0 {
+			return fmt.Errorf("fPort %d: must contain at least one field", p.FPort)
+		}
+
+		if err := validateFields(p.Fields); err != nil {
+			return errors.Wrapf(err, "fPort %d", p.FPort)
+		}
+	}
+
+	return nil
+}
+
+func validateFields(fields []Field) error {
+	if len(fields) > maxFields {
+		return fmt.Errorf("max. number of fields is %d", maxFields)
+	}
+
+	names := make(map[string]struct{})
+	for i, f := range fields {
+		if err := f.validate(); err != nil {
+			return errors.Wrapf(err, "field %d", i)
+		}
+
+		if _, ok := names[f.Name]; ok {
+			return fmt.Errorf("field %d: duplicate name: %s", i, f.Name)
+		}
+		names[f.Name] = struct{}{}
+	}
+
+	return nil
+}
+
+func (f Field) validate() error {
+	if strings.TrimSpace(f.Name) == "" {
+		return errors.New("name must be set")
+	}
+
+	if f.Offset < 0 {
+		return errors.New("offset must be greater than or equal to 0")
+	}
+
+	if err := validateEndianness(f.Endianness); err != nil {
+		return err
+	}
+
+	switch f.Type {
+	case typeHex, typeString:
+		if f.Length <= 0 {
+			return fmt.Errorf("length must be set for type %s", f.Type)
+		}
+	case "":
+		return errors.New("type must be set")
+	default:
+		if _, ok := typeSizes[f.Type]; !ok {
+			return fmt.Errorf("unknown type: %s", f.Type)
+		}
+		if f.Length != 0 {
+			return fmt.Errorf("length can not be set for type %s", f.Type)
+		}
+	}
+
+	if f.Bit != nil || f.Bits != 0 {
+		switch f.Type {
+		case typeUint8, typeUint16, typeUint24, typeUint32, typeBool:
+		default:
+			return fmt.Errorf("bit extraction is not supported for type %s", f.Type)
+		}
+
+		bit, bits := f.bitRange()
+		if bit < 0 || bits < 1 || bit+bits > typeSizes[f.Type]*8 {
+			return fmt.Errorf("bit range exceeds the size of type %s", f.Type)
+		}
+		if f.Type == typeBool && bits != 1 {
+			return errors.New("bits must be 1 for type bool")
+		}
+	}
+
+	if f.Scale != 0 || f.Add != 0 {
+		switch f.Type {
+		case typeBool, typeHex, typeString:
+			return fmt.Errorf("scale and add are not supported for type %s", f.Type)
+		}
+	}
+
+	return nil
+}
+
+func validateEndianness(e string) error {
+	switch e {
+	case "", bigEndian, littleEndian:
+		return nil
+	default:
+		return fmt.Errorf("unknown endianness: %s", e)
+	}
+}
+
+// bitRange returns the start bit and number of bits to extract.
+func (f Field) bitRange() (int, int) {
+	var bit int
+	if f.Bit != nil {
+		bit = *f.Bit
+	}
+
+	bits := f.Bits
+	if bits == 0 {
+		bits = 1
+	}
+
+	return bit, bits
+}
+
+// Validate validates the given template.
+func Validate(template string) error {
+	_, err := Parse(template)
+	return err
+}
+
+// BinaryToJSON decodes the given binary payload to JSON using the given
+// template.
+func BinaryToJSON(fPort uint8, template string, b []byte) ([]byte, error) {
+	t, err := Parse(template)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := t.Decode(fPort, b)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(out)
+}
+
+// JSONToBinary returns an error, as the declarative codec only supports
+// decoding.
+func JSONToBinary(fPort uint8, template string, b []byte) ([]byte, error) {
+	return nil, errors.New("encoding is not supported by the declarative codec")
+}
+
+// Decode decodes the given payload into an object.
+func (t Template) Decode(fPort uint8, b []byte) (map[string]interface{}, error) {
+	fields := t.Fields
+	for _, p := range t.Ports {
+		if p.FPort == fPort {
+			fields = p.Fields
+			break
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no fields defined for fPort %d", fPort)
+	}
+
+	out := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		size := f.Length
+		if size == 0 {
+			size = typeSizes[f.Type]
+		}
+
+		if f.Offset+size > len(b) {
+			if f.Optional {
+				continue
+			}
+			return nil, fmt.Errorf("field %s: payload too short, expected at least %d bytes, got %d", f.Name, f.Offset+size, len(b))
+		}
+
+		endianness := f.Endianness
+		if endianness == "" {
+			endianness = t.Endianness
+		}
+
+		v, err := f.decode(b[f.Offset:f.Offset+size], endianness)
+		if err != nil {
+			return nil, errors.Wrapf(err, "field %s", f.Name)
+		}
+		out[f.Name] = v
+	}
+
+	return out, nil
+}
+
+func (f Field) decode(b []byte, endianness string) (interface{}, error) {
+	var order binary.ByteOrder = binary.BigEndian
+	if endianness == littleEndian {
+		order = binary.LittleEndian
+	}
+
+	switch f.Type {
+	case typeHex:
+		return hex.EncodeToString(b), nil
+	case typeString:
+		return strings.TrimRight(string(b), "\x00"), nil
+	case typeBool:
+		if f.Bit == nil {
+			return b[0] != 0, nil
+		}
+		return f.extractBits(uint64(b[0])) != 0, nil
+	case typeFloat32:
+		v := math.Float32frombits(order.Uint32(b))
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return nil, fmt.Errorf("unsupported number: %f", v)
+		}
+		// format using the float32 precision to avoid conversion noise
+		f64, _ := strconv.ParseFloat(strconv.FormatFloat(float64(v), 'g', -1, 32), 64)
+		return f.apply(f64), nil
+	case typeFloat64:
+		v := math.Float64frombits(order.Uint64(b))
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("unsupported number: %f", v)
+		}
+		return f.apply(v), nil
+	}
+
+	// integer types
+	raw := readUint(b, order)
+	if f.Bit != nil || f.Bits != 0 {
+		return f.apply(float64(f.extractBits(raw))), nil
+	}
+
+	switch f.Type {
+	case typeInt8, typeInt16, typeInt24, typeInt32:
+		return f.apply(float64(signExtend(raw, len(b)*8))), nil
+	default:
+		return f.apply(float64(raw)), nil
+	}
+}
+
+func (f Field) extractBits(v uint64) uint64 {
+	bit, bits := f.bitRange()
+	return (v >> uint(bit)) & (1<<uint(bits) - 1)
+}
+
+// apply applies the scale and add values. The result is rounded to 12
+// significant digits, so that e.g. 2345 * 0.01 results in 23.45.
+func (f Field) apply(v float64) float64 {
+	if f.Scale == 0 && f.Add == 0 {
+		return v
+	}
+
+	scale := f.Scale
+	if scale == 0 {
+		scale = 1
+	}
+
+	v = v*scale + f.Add
+	v, _ = strconv.ParseFloat(strconv.FormatFloat(v, 'g', 12, 64), 64)
+	return v
+}
+
+// readUint reads the given (1 - 4 bytes) unsigned integer.
+func readUint(b []byte, order binary.ByteOrder) uint64 {
+	var v uint64
+	for i := range b {
+		if order == binary.LittleEndian {
+			v |= uint64(b[i]) << uint(8*i)
+		} else {
+			v = v<<8 | uint64(b[i])
+		}
+	}
+	return v
+}
+
+func signExtend(v uint64, bits int) int64 {
+	shift := uint(64 - bits)
+	return int64(v<<shift) >> shift
+}
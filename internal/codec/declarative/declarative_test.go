@@ -0,0 +1,222 @@
+package declarative
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBinaryToJSON(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Template      string
+		FPort         uint8
+		Payload       []byte
+		ExpectedJSON  string
+		ExpectedError string
+	}{
+		{
+			Name: "yaml template",
+			Template: `
+endianness: big
+fields:
+  - name: temperature
+    offset: 0
+    type: int16
+    scale: 0.01
+  - name: humidity
+    offset: 2
+    type: uint8
+    scale: 0.5
+  - name: battery_low
+    offset: 3
+    type: bool
+    bit: 7
+  - name: mode
+    offset: 3
+    type: uint8
+    bit: 0
+    bits: 3
+`,
+			FPort:        1,
+			Payload:      []byte{0xf6, 0xd7, 0x79, 0x85},
+			ExpectedJSON: `{"battery_low":true,"humidity":60.5,"mode":5,"temperature":-23.45}`,
+		},
+		{
+			Name:         "json template",
+			Template:     `{"fields": [{"name": "counter", "offset": 0, "type": "uint32", "endianness": "little"}]}`,
+			FPort:        1,
+			Payload:      []byte{0x01, 0x02, 0x03, 0x04},
+			ExpectedJSON: `{"counter":67305985}`,
+		},
+		{
+			Name: "all types",
+			Template: `
+fields:
+  - {name: u8, offset: 0, type: uint8}
+  - {name: i8, offset: 1, type: int8}
+  - {name: u24, offset: 2, type: uint24}
+  - {name: i24, offset: 5, type: int24}
+  - {name: f32, offset: 8, type: float32}
+  - {name: id, offset: 12, type: hex, length: 2}
+  - {name: label, offset: 14, type: string, length: 4}
+  - {name: offset, offset: 0, type: uint8, scale: 2, add: -10}
+`,
+			FPort:        1,
+			Payload:      []byte{0xff, 0xff, 0x01, 0x02, 0x03, 0xff, 0xff, 0xfe, 0x41, 0xbc, 0x00, 0x00, 0xab, 0xcd, 'a', 'b', 0, 0},
+			ExpectedJSON: `{"f32":23.5,"i24":-2,"i8":-1,"id":"abcd","label":"ab","offset":500,"u24":66051,"u8":255}`,
+		},
+		{
+			Name: "fPort fields",
+			Template: `
+fields:
+  - {name: temperature, offset: 0, type: int16, scale: 0.1}
+ports:
+  - fPort: 2
+    fields:
+      - {name: interval, offset: 0, type: uint16}
+`,
+			FPort:        2,
+			Payload:      []byte{0x01, 0x00},
+			ExpectedJSON: `{"interval":256}`,
+		},
+		{
+			Name: "fPort without fields",
+			Template: `
+ports:
+  - fPort: 2
+    fields:
+      - {name: interval, offset: 0, type: uint16}
+`,
+			FPort:         1,
+			Payload:       []byte{0x01, 0x00},
+			ExpectedError: "no fields defined for fPort 1",
+		},
+		{
+			Name: "optional field",
+			Template: `
+fields:
+  - {name: temperature, offset: 0, type: int16}
+  - {name: extra, offset: 2, type: uint8, optional: true}
+`,
+			FPort:        1,
+			Payload:      []byte{0x00, 0x10},
+			ExpectedJSON: `{"temperature":16}`,
+		},
+		{
+			Name: "payload too short",
+			Template: `
+fields:
+  - {name: temperature, offset: 0, type: int16}
+`,
+			FPort:         1,
+			Payload:       []byte{0x00},
+			ExpectedError: "field temperature: payload too short, expected at least 2 bytes, got 1",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			b, err := BinaryToJSON(tst.FPort, tst.Template, tst.Payload)
+			if tst.ExpectedError != "" {
+				assert.EqualError(err, tst.ExpectedError)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tst.ExpectedJSON, string(b))
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Template      string
+		ExpectedError string
+	}{
+		{
+			Name:          "no fields",
+			Template:      `endianness: big`,
+			ExpectedError: "template must contain at least one field",
+		},
+		{
+			Name:          "unknown type",
+			Template:      `fields: [{name: a, offset: 0, type: uint128}]`,
+			ExpectedError: "field 0: unknown type: uint128",
+		},
+		{
+			Name:          "missing type",
+			Template:      `fields: [{name: a, offset: 0}]`,
+			ExpectedError: "field 0: type must be set",
+		},
+		{
+			Name:          "missing name",
+			Template:      `fields: [{offset: 0, type: uint8}]`,
+			ExpectedError: "field 0: name must be set",
+		},
+		{
+			Name:          "duplicate name",
+			Template:      `fields: [{name: a, offset: 0, type: uint8}, {name: a, offset: 1, type: uint8}]`,
+			ExpectedError: "field 1: duplicate name: a",
+		},
+		{
+			Name:          "negative offset",
+			Template:      `fields: [{name: a, offset: -1, type: uint8}]`,
+			ExpectedError: "field 0: offset must be greater than or equal to 0",
+		},
+		{
+			Name:          "invalid endianness",
+			Template:      `fields: [{name: a, offset: 0, type: uint16, endianness: middle}]`,
+			ExpectedError: "field 0: unknown endianness: middle",
+		},
+		{
+			Name:          "hex without length",
+			Template:      `fields: [{name: a, offset: 0, type: hex}]`,
+			ExpectedError: "field 0: length must be set for type hex",
+		},
+		{
+			Name:          "bits out of range",
+			Template:      `fields: [{name: a, offset: 0, type: uint8, bit: 6, bits: 3}]`,
+			ExpectedError: "field 0: bit range exceeds the size of type uint8",
+		},
+		{
+			Name:          "bits on signed type",
+			Template:      `fields: [{name: a, offset: 0, type: int8, bit: 1}]`,
+			ExpectedError: "field 0: bit extraction is not supported for type int8",
+		},
+		{
+			Name:          "scale on string",
+			Template:      `fields: [{name: a, offset: 0, type: string, length: 2, scale: 2}]`,
+			ExpectedError: "field 0: scale and add are not supported for type string",
+		},
+		{
+			Name:          "duplicate fPort",
+			Template:      `ports: [{fPort: 1, fields: [{name: a, offset: 0, type: uint8}]}, {fPort: 1, fields: [{name: a, offset: 0, type: uint8}]}]`,
+			ExpectedError: "fPort 1: duplicate fPort",
+		},
+		{
+			Name:     "valid",
+			Template: `fields: [{name: a, offset: 0, type: uint8}]`,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			err := Validate(tst.Template)
+			if tst.ExpectedError != "" {
+				assert.EqualError(err, tst.ExpectedError)
+				return
+			}
+			assert.NoError(err)
+		})
+	}
+
+	t.Run("unknown key", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Error(Validate(`fields: [{name: a, offset: 0, type: uint8, sclae: 2}]`))
+	})
+}
@@ -0,0 +1,396 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: codec.proto
+
+package external
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type DecodeRequest struct {
+	// Codec identifier, as configured in the device-profile.
+	Codec string `protobuf:"bytes,1,opt,name=codec,proto3" json:"codec,omitempty"`
+	// FPort of the uplink.
+	FPort uint32 `protobuf:"varint,2,opt,name=f_port,json=fPort,proto3" json:"f_port,omitempty"`
+	// Binary payload.
+	Data []byte `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	// Device variables.
+	Variables            map[string]string `protobuf:"bytes,4,rep,name=variables,proto3" json:"variables,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *DecodeRequest) Reset()         { *m = DecodeRequest{} }
+func (m *DecodeRequest) String() string { return proto.CompactTextString(m) }
+func (*DecodeRequest) ProtoMessage()    {}
+func (*DecodeRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_9610d574777ab505, []int{0}
+}
+
+func (m *DecodeRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DecodeRequest.Unmarshal(m, b)
+}
+func (m *DecodeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DecodeRequest.Marshal(b, m, deterministic)
+}
+func (m *DecodeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DecodeRequest.Merge(m, src)
+}
+func (m *DecodeRequest) XXX_Size() int {
+	return xxx_messageInfo_DecodeRequest.Size(m)
+}
+func (m *DecodeRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DecodeRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DecodeRequest proto.InternalMessageInfo
+
+func (m *DecodeRequest) GetCodec() string {
+	if m != nil {
+		return m.Codec
+	}
+	return ""
+}
+
+func (m *DecodeRequest) GetFPort() uint32 {
+	if m != nil {
+		return m.FPort
+	}
+	return 0
+}
+
+func (m *DecodeRequest) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func (m *DecodeRequest) GetVariables() map[string]string {
+	if m != nil {
+		return m.Variables
+	}
+	return nil
+}
+
+type DecodeResponse struct {
+	// Decoded object (JSON encoded).
+	ObjectJson           string   `protobuf:"bytes,1,opt,name=object_json,json=objectJson,proto3" json:"object_json,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DecodeResponse) Reset()         { *m = DecodeResponse{} }
+func (m *DecodeResponse) String() string { return proto.CompactTextString(m) }
+func (*DecodeResponse) ProtoMessage()    {}
+func (*DecodeResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_9610d574777ab505, []int{1}
+}
+
+func (m *DecodeResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DecodeResponse.Unmarshal(m, b)
+}
+func (m *DecodeResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DecodeResponse.Marshal(b, m, deterministic)
+}
+func (m *DecodeResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DecodeResponse.Merge(m, src)
+}
+func (m *DecodeResponse) XXX_Size() int {
+	return xxx_messageInfo_DecodeResponse.Size(m)
+}
+func (m *DecodeResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_DecodeResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DecodeResponse proto.InternalMessageInfo
+
+func (m *DecodeResponse) GetObjectJson() string {
+	if m != nil {
+		return m.ObjectJson
+	}
+	return ""
+}
+
+type EncodeRequest struct {
+	// Codec identifier, as configured in the device-profile.
+	Codec string `protobuf:"bytes,1,opt,name=codec,proto3" json:"codec,omitempty"`
+	// FPort of the downlink.
+	FPort uint32 `protobuf:"varint,2,opt,name=f_port,json=fPort,proto3" json:"f_port,omitempty"`
+	// Object to encode (JSON encoded).
+	ObjectJson string `protobuf:"bytes,3,opt,name=object_json,json=objectJson,proto3" json:"object_json,omitempty"`
+	// Device variables.
+	Variables            map[string]string `protobuf:"bytes,4,rep,name=variables,proto3" json:"variables,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *EncodeRequest) Reset()         { *m = EncodeRequest{} }
+func (m *EncodeRequest) String() string { return proto.CompactTextString(m) }
+func (*EncodeRequest) ProtoMessage()    {}
+func (*EncodeRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_9610d574777ab505, []int{2}
+}
+
+func (m *EncodeRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_EncodeRequest.Unmarshal(m, b)
+}
+func (m *EncodeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_EncodeRequest.Marshal(b, m, deterministic)
+}
+func (m *EncodeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_EncodeRequest.Merge(m, src)
+}
+func (m *EncodeRequest) XXX_Size() int {
+	return xxx_messageInfo_EncodeRequest.Size(m)
+}
+func (m *EncodeRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_EncodeRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_EncodeRequest proto.InternalMessageInfo
+
+func (m *EncodeRequest) GetCodec() string {
+	if m != nil {
+		return m.Codec
+	}
+	return ""
+}
+
+func (m *EncodeRequest) GetFPort() uint32 {
+	if m != nil {
+		return m.FPort
+	}
+	return 0
+}
+
+func (m *EncodeRequest) GetObjectJson() string {
+	if m != nil {
+		return m.ObjectJson
+	}
+	return ""
+}
+
+func (m *EncodeRequest) GetVariables() map[string]string {
+	if m != nil {
+		return m.Variables
+	}
+	return nil
+}
+
+type EncodeResponse struct {
+	// Binary payload.
+	Data                 []byte   `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *EncodeResponse) Reset()         { *m = EncodeResponse{} }
+func (m *EncodeResponse) String() string { return proto.CompactTextString(m) }
+func (*EncodeResponse) ProtoMessage()    {}
+func (*EncodeResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_9610d574777ab505, []int{3}
+}
+
+func (m *EncodeResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_EncodeResponse.Unmarshal(m, b)
+}
+func (m *EncodeResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_EncodeResponse.Marshal(b, m, deterministic)
+}
+func (m *EncodeResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_EncodeResponse.Merge(m, src)
+}
+func (m *EncodeResponse) XXX_Size() int {
+	return xxx_messageInfo_EncodeResponse.Size(m)
+}
+func (m *EncodeResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_EncodeResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_EncodeResponse proto.InternalMessageInfo
+
+func (m *EncodeResponse) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*DecodeRequest)(nil), "external.DecodeRequest")
+	proto.RegisterMapType((map[string]string)(nil), "external.DecodeRequest.VariablesEntry")
+	proto.RegisterType((*DecodeResponse)(nil), "external.DecodeResponse")
+	proto.RegisterType((*EncodeRequest)(nil), "external.EncodeRequest")
+	proto.RegisterMapType((map[string]string)(nil), "external.EncodeRequest.VariablesEntry")
+	proto.RegisterType((*EncodeResponse)(nil), "external.EncodeResponse")
+}
+
+func init() {
+	proto.RegisterFile("codec.proto", fileDescriptor_9610d574777ab505)
+}
+
+var fileDescriptor_9610d574777ab505 = []byte{
+	// 311 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xbc, 0x52, 0x4d, 0x4b, 0xc3, 0x40,
+	0x10, 0xed, 0x36, 0x6d, 0xb1, 0xd3, 0x0f, 0x64, 0x51, 0x0c, 0xbd, 0x18, 0x82, 0x48, 0x4e, 0x01,
+	0xeb, 0x45, 0x44, 0x4f, 0x76, 0x2f, 0x9e, 0x64, 0x05, 0xaf, 0x65, 0x93, 0x4e, 0xa1, 0x35, 0xec,
+	0xc6, 0xdd, 0x6d, 0xb0, 0xff, 0xc1, 0x9f, 0xe7, 0x1f, 0xf0, 0x9f, 0x48, 0x92, 0x86, 0x92, 0x48,
+	0x11, 0x3c, 0x78, 0x9b, 0xc9, 0xcc, 0x9b, 0xf7, 0xf2, 0xde, 0xc2, 0x20, 0x56, 0x0b, 0x8c, 0xc3,
+	0x54, 0x2b, 0xab, 0xe8, 0x11, 0xbe, 0x5b, 0xd4, 0x52, 0x24, 0xfe, 0x27, 0x81, 0xd1, 0x0c, 0xf3,
+	0x19, 0xc7, 0xb7, 0x0d, 0x1a, 0x4b, 0x4f, 0xa0, 0x5b, 0xac, 0xba, 0xc4, 0x23, 0x41, 0x9f, 0x97,
+	0x0d, 0x3d, 0x85, 0xde, 0x72, 0x9e, 0x2a, 0x6d, 0xdd, 0xb6, 0x47, 0x82, 0x11, 0xef, 0x2e, 0x9f,
+	0x94, 0xb6, 0x94, 0x42, 0x67, 0x21, 0xac, 0x70, 0x1d, 0x8f, 0x04, 0x43, 0x5e, 0xd4, 0x74, 0x06,
+	0xfd, 0x4c, 0xe8, 0x95, 0x88, 0x12, 0x34, 0x6e, 0xc7, 0x73, 0x82, 0xc1, 0xf4, 0x32, 0xac, 0x08,
+	0xc3, 0x1a, 0x59, 0xf8, 0x52, 0x2d, 0x32, 0x69, 0xf5, 0x96, 0xef, 0x81, 0x93, 0x3b, 0x18, 0xd7,
+	0x87, 0xf4, 0x18, 0x9c, 0x57, 0xdc, 0xee, 0x64, 0xe5, 0x65, 0x2e, 0x35, 0x13, 0xc9, 0x06, 0x0b,
+	0x4d, 0x7d, 0x5e, 0x36, 0xb7, 0xed, 0x1b, 0xe2, 0x5f, 0xc1, 0xb8, 0x22, 0x32, 0xa9, 0x92, 0x06,
+	0xe9, 0x39, 0x0c, 0x54, 0xb4, 0xc6, 0xd8, 0xce, 0xd7, 0x46, 0xc9, 0xdd, 0x15, 0x28, 0x3f, 0x3d,
+	0x1a, 0x25, 0xfd, 0x2f, 0x02, 0x23, 0x26, 0xff, 0xec, 0x44, 0xe3, 0xbe, 0xd3, 0xbc, 0xff, 0x8b,
+	0x2d, 0x4c, 0xfe, 0x87, 0x2d, 0x17, 0x30, 0x66, 0xb2, 0x66, 0x4b, 0x15, 0x20, 0xd9, 0x07, 0x38,
+	0xfd, 0x20, 0x30, 0x7c, 0xc8, 0xff, 0xf5, 0x19, 0x75, 0xb6, 0x8a, 0x91, 0xde, 0x43, 0xaf, 0x74,
+	0x93, 0x9e, 0x1d, 0x08, 0x72, 0xe2, 0xfe, 0x1c, 0x94, 0x0c, 0x7e, 0x2b, 0x87, 0x33, 0xd9, 0x84,
+	0x33, 0x79, 0x00, 0x5e, 0x17, 0xe8, 0xb7, 0xa2, 0x5e, 0xf1, 0x66, 0xaf, 0xbf, 0x07, 0x00, 0xd1,
+	0xb7, 0xfe, 0x63, 0xc2, 0x02, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConnInterface
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion6
+
+// CodecServiceClient is the client API for CodecService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type CodecServiceClient interface {
+	// Decode decodes the given binary payload into an object.
+	Decode(ctx context.Context, in *DecodeRequest, opts ...grpc.CallOption) (*DecodeResponse, error)
+	// Encode encodes the given object into a binary payload.
+	Encode(ctx context.Context, in *EncodeRequest, opts ...grpc.CallOption) (*EncodeResponse, error)
+}
+
+type codecServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCodecServiceClient(cc grpc.ClientConnInterface) CodecServiceClient {
+	return &codecServiceClient{cc}
+}
+
+func (c *codecServiceClient) Decode(ctx context.Context, in *DecodeRequest, opts ...grpc.CallOption) (*DecodeResponse, error) {
+	out := new(DecodeResponse)
+	err := c.cc.Invoke(ctx, "/external.CodecService/Decode", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *codecServiceClient) Encode(ctx context.Context, in *EncodeRequest, opts ...grpc.CallOption) (*EncodeResponse, error) {
+	out := new(EncodeResponse)
+	err := c.cc.Invoke(ctx, "/external.CodecService/Encode", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CodecServiceServer is the server API for CodecService service.
+type CodecServiceServer interface {
+	// Decode decodes the given binary payload into an object.
+	Decode(context.Context, *DecodeRequest) (*DecodeResponse, error)
+	// Encode encodes the given object into a binary payload.
+	Encode(context.Context, *EncodeRequest) (*EncodeResponse, error)
+}
+
+// UnimplementedCodecServiceServer can be embedded to have forward compatible implementations.
+type UnimplementedCodecServiceServer struct {
+}
+
+func (*UnimplementedCodecServiceServer) Decode(ctx context.Context, req *DecodeRequest) (*DecodeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Decode not implemented")
+}
+func (*UnimplementedCodecServiceServer) Encode(ctx context.Context, req *EncodeRequest) (*EncodeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Encode not implemented")
+}
+
+func RegisterCodecServiceServer(s *grpc.Server, srv CodecServiceServer) {
+	s.RegisterService(&_CodecService_serviceDesc, srv)
+}
+
+func _CodecService_Decode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DecodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CodecServiceServer).Decode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/external.CodecService/Decode",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CodecServiceServer).Decode(ctx, req.(*DecodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CodecService_Encode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EncodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CodecServiceServer).Encode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/external.CodecService/Encode",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CodecServiceServer).Encode(ctx, req.(*EncodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _CodecService_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "external.CodecService",
+	HandlerType: (*CodecServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Decode",
+			Handler:    _CodecService_Decode_Handler,
+		},
+		{
+			MethodName: "Encode",
+			Handler:    _CodecService_Encode_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "codec.proto",
+}
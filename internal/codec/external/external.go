@@ -0,0 +1,266 @@
+//go:generate protoc -I=. --go_out=plugins=grpc:. codec.proto
+
+// Package external implements a codec which forwards the decode and encode
+// calls to an external codec service over gRPC (see codec.proto).
+package external
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+)
+
+// ErrCircuitOpen is returned when the circuit breaker is open because of
+// consecutive codec service failures.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+var (
+	clientMux sync.RWMutex
+	client    CodecServiceClient
+
+	timeout = time.Second
+	breaker circuitBreaker
+)
+
+// Setup configures the external codec. When no server is configured, the
+// external codec is disabled.
+func Setup(conf config.Config) error {
+	c := conf.ApplicationServer.Codec.ExternalGRPC
+
+	if c.Timeout > 0 {
+		timeout = c.Timeout
+	}
+	breaker.setup(c.CircuitBreaker.FailureThreshold, c.CircuitBreaker.OpenDuration)
+
+	if c.Server == "" {
+		SetClient(nil)
+		return nil
+	}
+
+	opts := []grpc.DialOption{
+		grpc.WithUnaryInterceptor(
+			logging.UnaryClientCtxIDInterceptor,
+		),
+	}
+
+	if c.CACert == "" && c.TLSCert == "" && c.TLSKey == "" {
+		opts = append(opts, grpc.WithInsecure())
+		log.WithField("server", c.Server).Warning("codec/external: creating insecure codec service client")
+	} else {
+		tlsConfig, err := clientTLSConfig(c.CACert, c.TLSCert, c.TLSKey)
+		if err != nil {
+			return errors.Wrap(err, "codec/external: tls config error")
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	}
+
+	// the connection is established in the background (non-blocking dial),
+	// so that an unavailable codec service does not block the startup
+	conn, err := grpc.Dial(c.Server, opts...)
+	if err != nil {
+		return errors.Wrap(err, "codec/external: dial codec service error")
+	}
+
+	SetClient(NewCodecServiceClient(conn))
+	log.WithField("server", c.Server).Info("codec/external: codec service client configured")
+
+	return nil
+}
+
+// SetClient sets the codec service client.
+func SetClient(c CodecServiceClient) {
+	clientMux.Lock()
+	defer clientMux.Unlock()
+	client = c
+}
+
+// BinaryToJSON decodes the given binary payload to JSON using the external
+// codec service. The codec identifier is forwarded to the codec service.
+func BinaryToJSON(fPort uint8, variables map[string]string, codec string, b []byte) ([]byte, error) {
+	c, err := getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var resp *DecodeResponse
+	err = call(func(ctx context.Context) error {
+		var err error
+		resp, err = c.Decode(ctx, &DecodeRequest{
+			Codec:     codec,
+			FPort:     uint32(fPort),
+			Data:      b,
+			Variables: variables,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "decode error")
+	}
+
+	if !json.Valid([]byte(resp.ObjectJson)) {
+		return nil, errors.New("codec service returned invalid json")
+	}
+
+	return []byte(resp.ObjectJson), nil
+}
+
+// JSONToBinary encodes the given JSON payload to binary using the external
+// codec service. The codec identifier is forwarded to the codec service.
+func JSONToBinary(fPort uint8, variables map[string]string, codec string, jsonB []byte) ([]byte, error) {
+	c, err := getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var resp *EncodeResponse
+	err = call(func(ctx context.Context) error {
+		var err error
+		resp, err = c.Encode(ctx, &EncodeRequest{
+			Codec:      codec,
+			FPort:      uint32(fPort),
+			ObjectJson: string(jsonB),
+			Variables:  variables,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "encode error")
+	}
+
+	return resp.Data, nil
+}
+
+func getClient() (CodecServiceClient, error) {
+	clientMux.RLock()
+	defer clientMux.RUnlock()
+
+	if client == nil {
+		return nil, errors.New("no external codec service configured")
+	}
+	return client, nil
+}
+
+// call executes the given function using the configured timeout, guarded
+// by the circuit breaker.
+func call(f func(ctx context.Context) error) error {
+	if !breaker.allow(time.Now()) {
+		return ErrCircuitOpen
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := f(ctx)
+	breaker.done(err, time.Now())
+	return err
+}
+
+// circuitBreaker opens after the given number of consecutive failures of
+// the codec service. While open, calls fail immediately. After the open
+// duration, calls are allowed again and a next failure re-opens the circuit.
+type circuitBreaker struct {
+	sync.Mutex
+
+	threshold    int
+	openDuration time.Duration
+
+	failures  int
+	openUntil time.Time
+}
+
+func (cb *circuitBreaker) setup(threshold int, openDuration time.Duration) {
+	cb.Lock()
+	defer cb.Unlock()
+
+	cb.threshold = threshold
+	cb.openDuration = openDuration
+	cb.failures = 0
+	cb.openUntil = time.Time{}
+}
+
+func (cb *circuitBreaker) allow(now time.Time) bool {
+	cb.Lock()
+	defer cb.Unlock()
+
+	return cb.threshold <= 0 || !now.Before(cb.openUntil)
+}
+
+func (cb *circuitBreaker) done(err error, now time.Time) {
+	cb.Lock()
+	defer cb.Unlock()
+
+	if cb.threshold <= 0 {
+		return
+	}
+
+	if !isServiceFailure(err) {
+		cb.failures = 0
+		return
+	}
+
+	cb.failures++
+	if cb.failures >= cb.threshold {
+		if now.After(cb.openUntil) {
+			log.WithFields(log.Fields{
+				"failures":      cb.failures,
+				"open_duration": cb.openDuration,
+			}).Warning("codec/external: circuit breaker opened")
+		}
+		cb.openUntil = now.Add(cb.openDuration)
+	}
+}
+
+// isServiceFailure returns true when the error indicates that the codec
+// service is failing, as opposed to the payload that could not be decoded
+// or encoded.
+func isServiceFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return true
+	default:
+		return false
+	}
+}
+
+func clientTLSConfig(caCert, tlsCert, tlsKey string) (*tls.Config, error) {
+	var tlsConfig tls.Config
+
+	if caCert != "" {
+		b, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, errors.Wrap(err, "read ca certificate error")
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(b) {
+			return nil, errors.New("append ca certificate error")
+		}
+	}
+
+	if tlsCert != "" || tlsKey != "" {
+		cert, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "load x509 keypair error")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &tlsConfig, nil
+}
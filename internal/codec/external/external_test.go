@@ -0,0 +1,204 @@
+package external
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type testCodecService struct {
+	UnimplementedCodecServiceServer
+	sync.Mutex
+
+	decodeRequest *DecodeRequest
+	encodeRequest *EncodeRequest
+	objectJSON    string
+	delay         time.Duration
+	err           error
+}
+
+func (s *testCodecService) set(f func(s *testCodecService)) {
+	s.Lock()
+	defer s.Unlock()
+	f(s)
+}
+
+func (s *testCodecService) Decode(ctx context.Context, req *DecodeRequest) (*DecodeResponse, error) {
+	s.Lock()
+	s.decodeRequest = req
+	delay, objectJSON, err := s.delay, s.objectJSON, s.err
+	s.Unlock()
+
+	time.Sleep(delay)
+	if err != nil {
+		return nil, err
+	}
+	return &DecodeResponse{ObjectJson: objectJSON}, nil
+}
+
+func (s *testCodecService) Encode(ctx context.Context, req *EncodeRequest) (*EncodeResponse, error) {
+	s.Lock()
+	s.encodeRequest = req
+	delay, err := s.delay, s.err
+	s.Unlock()
+
+	time.Sleep(delay)
+	if err != nil {
+		return nil, err
+	}
+	return &EncodeResponse{Data: []byte{1, 2, 3}}, nil
+}
+
+func TestExternalCodec(t *testing.T) {
+	assert := require.New(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+
+	service := testCodecService{}
+	server := grpc.NewServer()
+	RegisterCodecServiceServer(server, &service)
+	go server.Serve(ln)
+	defer server.Stop()
+
+	conn, err := grpc.Dial(ln.Addr().String(), grpc.WithInsecure())
+	assert.NoError(err)
+	defer conn.Close()
+
+	SetClient(NewCodecServiceClient(conn))
+	defer SetClient(nil)
+
+	timeout = 100 * time.Millisecond
+	breaker.setup(0, 0)
+
+	vars := map[string]string{"calibration": "1.5"}
+
+	t.Run("decode", func(t *testing.T) {
+		assert := require.New(t)
+		service.set(func(s *testCodecService) { s.objectJSON = `{"temperature":21.5}` })
+
+		b, err := BinaryToJSON(10, vars, "acme-sensor", []byte{1, 2})
+		assert.NoError(err)
+		assert.Equal(`{"temperature":21.5}`, string(b))
+
+		service.set(func(s *testCodecService) {
+			assert.Equal("acme-sensor", s.decodeRequest.Codec)
+			assert.EqualValues(10, s.decodeRequest.FPort)
+			assert.Equal([]byte{1, 2}, s.decodeRequest.Data)
+			assert.Equal(vars, s.decodeRequest.Variables)
+		})
+	})
+
+	t.Run("decode invalid json", func(t *testing.T) {
+		assert := require.New(t)
+		service.set(func(s *testCodecService) { s.objectJSON = `{"temperature":` })
+
+		_, err := BinaryToJSON(10, vars, "acme-sensor", []byte{1, 2})
+		assert.EqualError(err, "codec service returned invalid json")
+	})
+
+	t.Run("encode", func(t *testing.T) {
+		assert := require.New(t)
+
+		b, err := JSONToBinary(20, vars, "acme-sensor", []byte(`{"on":true}`))
+		assert.NoError(err)
+		assert.Equal([]byte{1, 2, 3}, b)
+
+		service.set(func(s *testCodecService) {
+			assert.Equal("acme-sensor", s.encodeRequest.Codec)
+			assert.EqualValues(20, s.encodeRequest.FPort)
+			assert.Equal(`{"on":true}`, s.encodeRequest.ObjectJson)
+		})
+	})
+
+	t.Run("codec service error", func(t *testing.T) {
+		assert := require.New(t)
+		service.set(func(s *testCodecService) { s.err = status.Error(codes.InvalidArgument, "invalid payload") })
+		defer service.set(func(s *testCodecService) { s.err = nil })
+
+		_, err := BinaryToJSON(10, vars, "acme-sensor", []byte{1, 2})
+		assert.Equal(codes.InvalidArgument, status.Code(errors.Cause(err)))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		assert := require.New(t)
+		service.set(func(s *testCodecService) { s.delay = 200 * time.Millisecond })
+		defer service.set(func(s *testCodecService) { s.delay = 0 })
+
+		_, err := BinaryToJSON(10, vars, "acme-sensor", []byte{1, 2})
+		assert.Equal(codes.DeadlineExceeded, status.Code(errors.Cause(err)))
+	})
+
+	t.Run("circuit breaker", func(t *testing.T) {
+		assert := require.New(t)
+		service.set(func(s *testCodecService) { s.err = status.Error(codes.Unavailable, "unavailable") })
+		defer service.set(func(s *testCodecService) { s.err = nil })
+
+		breaker.setup(2, time.Minute)
+		defer breaker.setup(0, 0)
+
+		for i := 0; i < 2; i++ {
+			_, err := BinaryToJSON(10, vars, "acme-sensor", []byte{1, 2})
+			assert.Equal(codes.Unavailable, status.Code(errors.Cause(err)))
+		}
+
+		service.set(func(s *testCodecService) { s.err = nil })
+		_, err := BinaryToJSON(10, vars, "acme-sensor", []byte{1, 2})
+		assert.Equal(ErrCircuitOpen, errors.Cause(err))
+	})
+}
+
+func TestExternalCodecNotConfigured(t *testing.T) {
+	assert := require.New(t)
+
+	SetClient(nil)
+	_, err := BinaryToJSON(10, nil, "acme-sensor", []byte{1, 2})
+	assert.EqualError(err, "no external codec service configured")
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	assert := require.New(t)
+
+	var cb circuitBreaker
+	cb.setup(2, time.Minute)
+
+	now := time.Now()
+	failure := status.Error(codes.Unavailable, "unavailable")
+
+	// payload errors do not count as failures
+	cb.done(failure, now)
+	cb.done(status.Error(codes.InvalidArgument, "invalid"), now)
+	cb.done(failure, now)
+	assert.True(cb.allow(now))
+
+	// consecutive failures open the circuit
+	cb.done(failure, now)
+	assert.False(cb.allow(now))
+	assert.False(cb.allow(now.Add(59 * time.Second)))
+
+	// after the open duration, calls are allowed again
+	assert.True(cb.allow(now.Add(time.Minute)))
+
+	// a success closes the circuit, a next failure re-opens it
+	cb.done(failure, now.Add(time.Minute))
+	assert.False(cb.allow(now.Add(time.Minute)))
+
+	cb.done(nil, now.Add(2*time.Minute))
+	assert.True(cb.allow(now.Add(2 * time.Minute)))
+	cb.done(failure, now.Add(2*time.Minute))
+	assert.True(cb.allow(now.Add(2 * time.Minute)))
+
+	// disabled
+	cb.setup(0, time.Minute)
+	cb.done(failure, now)
+	cb.done(failure, now)
+	assert.True(cb.allow(now))
+}
@@ -0,0 +1,73 @@
+package js
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/pkg/errors"
+)
+
+// executeGoja executes the given script using the goja engine, which
+// supports ES2015+ syntax (let / const, arrow functions, template literals,
+// ...). The same limits apply as to the otto engine.
+func executeGoja(script string, vars map[string]interface{}, logFn func(string)) (out interface{}, err error) {
+	defer func() {
+		if caught := recover(); caught != nil {
+			err = fmt.Errorf("%s", caught)
+		}
+	}()
+
+	vm := goja.New()
+	vm.SetMaxCallStackSize(32)
+
+	for k, v := range vars {
+		// expose the bytes as a JS array, so that all the Array methods
+		// are available
+		if b, ok := v.([]byte); ok {
+			items := make([]interface{}, len(b))
+			for i := range b {
+				items[i] = b[i]
+			}
+			v = vm.NewArray(items...)
+		}
+
+		if err := vm.Set(k, v); err != nil {
+			return nil, errors.Wrap(err, "set variable error")
+		}
+	}
+
+	if logFn == nil {
+		logFn = logDebug
+	}
+	console := vm.NewObject()
+	if err := console.Set("log", func(call goja.FunctionCall) goja.Value {
+		args := make([]string, len(call.Arguments))
+		for i, arg := range call.Arguments {
+			args[i] = arg.String()
+		}
+		logFn(strings.Join(args, " "))
+		return goja.Undefined()
+	}); err != nil {
+		return nil, errors.Wrap(err, "set console error")
+	}
+	if err := vm.Set("console", console); err != nil {
+		return nil, errors.Wrap(err, "set console error")
+	}
+
+	stop := enforceLimits(func(err error) {
+		vm.Interrupt(err)
+	})
+	defer stop()
+
+	val, err := vm.RunString(script)
+	if err != nil {
+		if ie, ok := err.(*goja.InterruptedError); ok {
+			countKilled(ie.Value())
+			return nil, fmt.Errorf("%s", ie.Value())
+		}
+		return nil, errors.Wrap(err, "js vm error")
+	}
+
+	return val.Export(), nil
+}
@@ -0,0 +1,165 @@
+package js
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestES2015Decode(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Script        string
+		Payload       []byte
+		FPort         uint8
+		Variables     map[string]string
+		ExpectedJSON  string
+		ExpectedError string
+	}{
+		{
+			Name: "es2015 syntax",
+			Script: `
+				const decodeTemp = (b) => ((b[0] << 8) | b[1]) / 10;
+
+				function Decode(fPort, bytes, variables) {
+					let [, , on] = bytes;
+					return {
+						temp: decodeTemp(bytes),
+						on: on === 1,
+						label: ` + "`port ${fPort}`" + `,
+						sum: bytes.reduce((a, b) => a + b, 0),
+						unit: variables.unit,
+					};
+				}
+			`,
+			Payload:      []byte{0, 215, 1},
+			FPort:        10,
+			Variables:    map[string]string{"unit": "C"},
+			ExpectedJSON: `{"label":"port 10","on":true,"sum":216,"temp":21.5,"unit":"C"}`,
+		},
+		{
+			Name:          "function error",
+			Script:        ``,
+			Payload:       []byte{1},
+			FPort:         10,
+			ExpectedError: "execute js error: js vm error: ReferenceError: Decode is not defined at <eval>:3:7(4)",
+		},
+		{
+			Name: "function timeout",
+			Script: `
+				function Decode(fPort, bytes) {
+					while(true) {}
+				}
+			`,
+			Payload:       []byte{1},
+			FPort:         10,
+			ExpectedError: "execute js error: execution timeout",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			jsonB, err := BinaryToJSONES2015(tst.FPort, tst.Variables, tst.Script, tst.Payload, nil)
+			if tst.ExpectedError != "" {
+				assert.EqualError(err, tst.ExpectedError)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tst.ExpectedJSON, string(jsonB))
+		})
+	}
+}
+
+func TestES2015Encode(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Script        string
+		JSON          string
+		ExpectedBytes []byte
+		ExpectedError string
+	}{
+		{
+			Name: "es2015 syntax",
+			Script: `
+				const Encode = (fPort, obj) => [obj.a, ...obj.list.map((v) => v * 2), fPort];
+			`,
+			JSON:          `{"a": 1, "list": [2, 3]}`,
+			ExpectedBytes: []byte{1, 4, 6, 10},
+		},
+		{
+			Name: "return invalid bytes",
+			Script: `
+				function Encode(fPort, obj) {
+					return [256];
+				}
+			`,
+			JSON:          `{}`,
+			ExpectedError: "array value must be in byte range (0 - 255), got: 256",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			b, err := JSONToBinaryES2015(10, nil, tst.Script, []byte(tst.JSON), nil)
+			if tst.ExpectedError != "" {
+				assert.EqualError(err, tst.ExpectedError)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tst.ExpectedBytes, b)
+		})
+	}
+}
+
+func TestES2015Log(t *testing.T) {
+	assert := require.New(t)
+
+	var logs []string
+	_, err := BinaryToJSONES2015(10, nil, `
+		function Decode(fPort, bytes) {
+			console.log("fPort", fPort, `+"`${bytes.length} bytes`"+`);
+			return {};
+		}
+	`, []byte{1, 2}, func(s string) { logs = append(logs, s) })
+	assert.NoError(err)
+	assert.Equal([]string{"fPort 10 2 bytes"}, logs)
+
+	t.Run("Without log function", func(t *testing.T) {
+		assert := require.New(t)
+
+		for _, execute := range []func(uint8, map[string]string, string, []byte, func(string)) ([]byte, error){BinaryToJSONES2015, BinaryToJSONWithLog} {
+			_, err := execute(10, nil, `
+				function Decode(fPort, bytes) {
+					console.log("fPort", fPort);
+					return {};
+				}
+			`, []byte{1, 2}, nil)
+			assert.NoError(err)
+		}
+	})
+}
+
+func TestES2015MemoryLimit(t *testing.T) {
+	assert := require.New(t)
+
+	defer func(d time.Duration, m uint64) {
+		maxExecutionTime, maxMemory = d, m
+	}(maxExecutionTime, maxMemory)
+	maxExecutionTime = 10 * time.Second
+	maxMemory = 1024 * 1024
+
+	_, err := BinaryToJSONES2015(10, nil, `
+		function Decode(fPort, bytes) {
+			const a = [];
+			while(true) {
+				a.push("abcdefghijklmnopqrstuvwxyz" + a.length);
+			}
+		}
+	`, []byte{1}, nil)
+	assert.EqualError(err, "execute js error: memory limit exceeded")
+}
@@ -4,25 +4,65 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"strings"
 	"time"
 
 	"github.com/ibrahimozekici/app-server2/internal/config"
 	"github.com/pkg/errors"
 	"github.com/robertkrimen/otto"
+	log "github.com/sirupsen/logrus"
 )
 
 var (
 	maxExecutionTime = 10 * time.Millisecond
+	maxMemory        uint64
+	maxInputSize     int
+	maxOutputSize    int
+)
+
+// Errors returned when a resource limit has been exceeded.
+var (
+	errExecutionTimeout   = errors.New("execution timeout")
+	errMemoryLimit        = errors.New("memory limit exceeded")
+	errInputSizeExceeded  = errors.New("input size limit exceeded")
+	errOutputSizeExceeded = errors.New("output size limit exceeded")
 )
 
 // Setup configures the JS codec.
 func Setup(conf config.Config) error {
 	maxExecutionTime = conf.ApplicationServer.Codec.JS.MaxExecutionTime
+	maxMemory = uint64(conf.ApplicationServer.Codec.JS.MaxMemory)
+	maxInputSize = conf.ApplicationServer.Codec.JS.MaxInputSize
+	maxOutputSize = conf.ApplicationServer.Codec.JS.MaxOutputSize
 	return nil
 }
 
 // BinaryToJSON encodes the given binary payload to JSON.
 func BinaryToJSON(fPort uint8, variables map[string]string, decodeScript string, b []byte) ([]byte, error) {
+	return BinaryToJSONWithLog(fPort, variables, decodeScript, b, nil)
+}
+
+// BinaryToJSONWithLog is equal to BinaryToJSON, but the messages logged by
+// the script using console.log are passed to the given log function.
+func BinaryToJSONWithLog(fPort uint8, variables map[string]string, decodeScript string, b []byte, logFn func(string)) ([]byte, error) {
+	return binaryToJSON(executeJS, fPort, variables, decodeScript, b, logFn)
+}
+
+// BinaryToJSONES2015 is equal to BinaryToJSONWithLog, but executes the
+// script using the ES2015+ capable (goja) engine.
+func BinaryToJSONES2015(fPort uint8, variables map[string]string, decodeScript string, b []byte, logFn func(string)) ([]byte, error) {
+	return binaryToJSON(executeGoja, fPort, variables, decodeScript, b, logFn)
+}
+
+// executeFunc executes the given script using the given variables and returns
+// the (exported) value of the last expression.
+type executeFunc func(script string, vars map[string]interface{}, logFn func(string)) (interface{}, error)
+
+func binaryToJSON(execute executeFunc, fPort uint8, variables map[string]string, decodeScript string, b []byte, logFn func(string)) ([]byte, error) {
+	if err := checkInputSize(len(b)); err != nil {
+		return nil, errors.Wrap(err, "execute js error")
+	}
+
 	decodeScript = decodeScript + "\n\nDecode(fPort, bytes, variables);\n"
 
 	vars := make(map[string]interface{})
@@ -31,16 +71,45 @@ func BinaryToJSON(fPort uint8, variables map[string]string, decodeScript string,
 	vars["bytes"] = b
 	vars["variables"] = variables
 
-	v, err := executeJS(decodeScript, vars)
+	v, err := execute(decodeScript, vars, logFn)
 	if err != nil {
 		return nil, errors.Wrap(err, "execute js error")
 	}
 
-	return json.Marshal(v)
+	out, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkOutputSize(len(out)); err != nil {
+		return nil, errors.Wrap(err, "execute js error")
+	}
+
+	return out, nil
 }
 
 // JSONToBinary encodes the given JSON payload to binary.
 func JSONToBinary(fPort uint8, variables map[string]string, encodeScript string, b []byte) ([]byte, error) {
+	return JSONToBinaryWithLog(fPort, variables, encodeScript, b, nil)
+}
+
+// JSONToBinaryWithLog is equal to JSONToBinary, but the messages logged by
+// the script using console.log are passed to the given log function.
+func JSONToBinaryWithLog(fPort uint8, variables map[string]string, encodeScript string, b []byte, logFn func(string)) ([]byte, error) {
+	return jsonToBinary(executeJS, fPort, variables, encodeScript, b, logFn)
+}
+
+// JSONToBinaryES2015 is equal to JSONToBinaryWithLog, but executes the
+// script using the ES2015+ capable (goja) engine.
+func JSONToBinaryES2015(fPort uint8, variables map[string]string, encodeScript string, b []byte, logFn func(string)) ([]byte, error) {
+	return jsonToBinary(executeGoja, fPort, variables, encodeScript, b, logFn)
+}
+
+func jsonToBinary(execute executeFunc, fPort uint8, variables map[string]string, encodeScript string, b []byte, logFn func(string)) ([]byte, error) {
+	if err := checkInputSize(len(b)); err != nil {
+		return nil, errors.Wrap(err, "execute js error")
+	}
+
 	var v interface{}
 	if err := json.Unmarshal(b, &v); err != nil {
 		return nil, errors.Wrap(err, "unmarshal json error")
@@ -54,17 +123,52 @@ func JSONToBinary(fPort uint8, variables map[string]string, encodeScript string,
 	vars["obj"] = v
 	vars["variables"] = variables
 
-	v, err := executeJS(encodeScript, vars)
+	v, err := execute(encodeScript, vars, logFn)
 	if err != nil {
 		return nil, errors.Wrap(err, "execute js error")
 	}
 
-	return interfaceToByteSlice(v)
+	out, err := interfaceToByteSlice(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkOutputSize(len(out)); err != nil {
+		return nil, errors.Wrap(err, "execute js error")
+	}
+
+	return out, nil
+}
+
+// logDebug logs the console.log output when the script is not executed
+// with a log function (e.g. outside the codec test sandbox), so that scripts
+// calling console.log do not fail.
+func logDebug(msg string) {
+	log.WithField("output", msg).Debug("codec/js: console.log")
+}
+
+// checkInputSize bounds the size of the values passed to the script, so that
+// oversized payloads are rejected before the script is executed.
+func checkInputSize(size int) error {
+	if maxInputSize > 0 && size > maxInputSize {
+		jsKilledCount("input_size").Inc()
+		return errInputSizeExceeded
+	}
+	return nil
+}
+
+func checkOutputSize(size int) error {
+	if maxOutputSize > 0 && size > maxOutputSize {
+		jsKilledCount("output_size").Inc()
+		return errOutputSizeExceeded
+	}
+	return nil
 }
 
-func executeJS(script string, vars map[string]interface{}) (out interface{}, err error) {
+func executeJS(script string, vars map[string]interface{}, logFn func(string)) (out interface{}, err error) {
 	defer func() {
 		if caught := recover(); caught != nil {
+			countKilled(caught)
 			err = fmt.Errorf("%s", caught)
 		}
 	}()
@@ -79,12 +183,31 @@ func executeJS(script string, vars map[string]interface{}) (out interface{}, err
 		}
 	}
 
-	go func() {
-		time.Sleep(maxExecutionTime)
-		vm.Interrupt <- func() {
-			panic(errors.New("execution timeout"))
+	if logFn == nil {
+		logFn = logDebug
+	}
+	if err := vm.Set("console", map[string]interface{}{
+		"log": func(call otto.FunctionCall) otto.Value {
+			args := make([]string, len(call.ArgumentList))
+			for i, arg := range call.ArgumentList {
+				args[i] = arg.String()
+			}
+			logFn(strings.Join(args, " "))
+			return otto.UndefinedValue()
+		},
+	}); err != nil {
+		return nil, errors.Wrap(err, "set console error")
+	}
+
+	// abort the execution by panicking within the vm, the panic is
+	// recovered above
+	stop := enforceLimits(func(err error) {
+		select {
+		case vm.Interrupt <- func() { panic(err) }:
+		default:
 		}
-	}()
+	})
+	defer stop()
 
 	var val otto.Value
 	val, err = vm.Run(script)
@@ -96,6 +219,34 @@ func executeJS(script string, vars map[string]interface{}) (out interface{}, err
 	return val.Export()
 }
 
+// enforceLimits calls kill when the execution exceeds the max. execution
+// time or memory. The returned function must be called when the execution
+// has completed.
+func enforceLimits(kill func(error)) func() {
+	timer := time.AfterFunc(maxExecutionTime, func() { kill(errExecutionTimeout) })
+
+	unwatch := func() {}
+	if maxMemory > 0 {
+		unwatch = watchdog.watch(maxMemory, func() { kill(errMemoryLimit) })
+	}
+
+	return func() {
+		timer.Stop()
+		unwatch()
+	}
+}
+
+// countKilled increments the killed metric when the given error is caused by
+// exceeding the max. execution time or memory.
+func countKilled(err interface{}) {
+	switch err {
+	case errExecutionTimeout:
+		jsKilledCount("execution_time").Inc()
+	case errMemoryLimit:
+		jsKilledCount("memory").Inc()
+	}
+}
+
 func interfaceToByteSlice(obj interface{}) ([]byte, error) {
 	if obj == nil {
 		return nil, errors.New("value must not be nil")
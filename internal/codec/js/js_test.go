@@ -1,7 +1,9 @@
 package js
 
 import (
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
@@ -202,3 +204,123 @@ func TestJSEncode(t *testing.T) {
 		})
 	}
 }
+
+func TestJSLimits(t *testing.T) {
+	defer func(d time.Duration, m uint64, i, o int) {
+		maxExecutionTime, maxMemory, maxInputSize, maxOutputSize = d, m, i, o
+	}(maxExecutionTime, maxMemory, maxInputSize, maxOutputSize)
+
+	t.Run("memory limit", func(t *testing.T) {
+		assert := require.New(t)
+		maxExecutionTime = 10 * time.Second
+		maxMemory = 1024 * 1024
+		defer func() { maxMemory = 0 }()
+
+		_, err := BinaryToJSON(10, nil, `
+			function Decode(fPort, bytes) {
+				var a = [];
+				while(true) {
+					a.push("abcdefghijklmnopqrstuvwxyz" + a.length);
+				}
+			}
+		`, []byte{1})
+		assert.EqualError(err, "execute js error: memory limit exceeded")
+	})
+
+	t.Run("decode input size limit", func(t *testing.T) {
+		assert := require.New(t)
+		maxInputSize = 2
+		defer func() { maxInputSize = 0 }()
+
+		_, err := BinaryToJSON(10, nil, `
+			function Decode(fPort, bytes) {
+				return {};
+			}
+		`, []byte{1, 2, 3})
+		assert.EqualError(err, "execute js error: input size limit exceeded")
+	})
+
+	t.Run("encode input size limit", func(t *testing.T) {
+		assert := require.New(t)
+		maxInputSize = 10
+		defer func() { maxInputSize = 0 }()
+
+		_, err := JSONToBinary(10, nil, `
+			function Encode(fPort, obj) {
+				return [];
+			}
+		`, []byte(`{"value": "this exceeds the input size"}`))
+		assert.EqualError(err, "execute js error: input size limit exceeded")
+	})
+
+	t.Run("decode output size limit", func(t *testing.T) {
+		assert := require.New(t)
+		maxOutputSize = 10
+
+		_, err := BinaryToJSON(10, nil, `
+			function Decode(fPort, bytes) {
+				return {"value": "this exceeds the output size"};
+			}
+		`, []byte{1})
+		assert.EqualError(err, "execute js error: output size limit exceeded")
+	})
+
+	t.Run("encode output size limit", func(t *testing.T) {
+		assert := require.New(t)
+		maxOutputSize = 2
+
+		_, err := JSONToBinary(10, nil, `
+			function Encode(fPort, obj) {
+				return [1, 2, 3];
+			}
+		`, []byte(`{}`))
+		assert.EqualError(err, "execute js error: output size limit exceeded")
+	})
+}
+
+func TestMemoryWatchdog(t *testing.T) {
+	assert := require.New(t)
+
+	var heapMux sync.Mutex
+	var heap uint64 = 1000
+
+	w := memoryWatchdog{
+		interval: time.Millisecond,
+		readHeap: func() uint64 {
+			heapMux.Lock()
+			defer heapMux.Unlock()
+			return heap
+		},
+	}
+
+	exceeded := make(chan int, 2)
+	unwatch1 := w.watch(500, func() { exceeded <- 1 })
+	unwatch2 := w.watch(100, func() { exceeded <- 2 })
+
+	heapMux.Lock()
+	heap = 1200
+	heapMux.Unlock()
+
+	select {
+	case id := <-exceeded:
+		assert.Equal(2, id)
+	case <-time.After(time.Second):
+		t.Fatal("expected limit to be exceeded")
+	}
+
+	unwatch2()
+	unwatch1()
+
+	// the sampling stops when no executions are watched
+	assert.Eventually(func() bool {
+		w.Lock()
+		defer w.Unlock()
+		return !w.running
+	}, time.Second, time.Millisecond)
+
+	select {
+	case id := <-exceeded:
+		t.Fatalf("unexpected exceeded callback: %d", id)
+	default:
+	}
+}
@@ -0,0 +1,98 @@
+package js
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// memoryCheckInterval defines the interval in which the heap usage is
+// sampled while scripts are running.
+const memoryCheckInterval = 10 * time.Millisecond
+
+var watchdog = memoryWatchdog{
+	interval: memoryCheckInterval,
+	readHeap: func() uint64 {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		return ms.HeapAlloc
+	},
+}
+
+// memoryWatchdog samples the heap usage of the process while scripts are
+// running. As the Go runtime does not expose the memory usage per goroutine,
+// the heap growth since the start of each execution is compared against its
+// limit. Concurrent executions (and other allocations) are accounted too,
+// thus the limit is an upper bound for the memory a single script is able
+// to allocate. Sampling only happens while at least one script is running.
+type memoryWatchdog struct {
+	sync.Mutex
+
+	interval time.Duration
+	readHeap func() uint64
+
+	running    bool
+	heap       uint64
+	nextID     uint64
+	executions map[uint64]watchedExecution
+}
+
+type watchedExecution struct {
+	baseline uint64
+	limit    uint64
+	exceeded func()
+}
+
+// watch starts watching an execution. The exceeded function is called
+// (once) when the heap growth exceeds the given limit. The returned function
+// must be called when the execution has completed.
+func (w *memoryWatchdog) watch(limit uint64, exceeded func()) func() {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.executions == nil {
+		w.executions = make(map[uint64]watchedExecution)
+	}
+
+	if !w.running {
+		w.heap = w.readHeap()
+		w.running = true
+		go w.loop()
+	}
+
+	w.nextID++
+	id := w.nextID
+	w.executions[id] = watchedExecution{
+		baseline: w.heap,
+		limit:    limit,
+		exceeded: exceeded,
+	}
+
+	return func() {
+		w.Lock()
+		defer w.Unlock()
+		delete(w.executions, id)
+	}
+}
+
+func (w *memoryWatchdog) loop() {
+	for {
+		time.Sleep(w.interval)
+
+		w.Lock()
+		if len(w.executions) == 0 {
+			w.running = false
+			w.Unlock()
+			return
+		}
+
+		w.heap = w.readHeap()
+		for id, e := range w.executions {
+			if w.heap > e.baseline && w.heap-e.baseline > e.limit {
+				e.exceeded()
+				delete(w.executions, id)
+			}
+		}
+		w.Unlock()
+	}
+}
@@ -0,0 +1,17 @@
+package js
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	kc = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "codec_js_killed_count",
+		Help: "The number of JS codec executions aborted because of exceeding a resource limit (per limit).",
+	}, []string{"limit"})
+)
+
+func jsKilledCount(limit string) prometheus.Counter {
+	return kc.With(prometheus.Labels{"limit": limit})
+}
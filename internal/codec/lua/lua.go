@@ -0,0 +1,324 @@
+package lua
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	glua "github.com/yuin/gopher-lua"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+var (
+	maxExecutionTime = 10 * time.Millisecond
+)
+
+// maxDepth defines the max. nesting depth of the converted values, this
+// also protects against tables referencing themselves.
+const maxDepth = 32
+
+// unsafeGlobals contains the globals of the base library which give access
+// to the filesystem or allow loading code.
+var unsafeGlobals = []string{
+	"collectgarbage",
+	"dofile",
+	"load",
+	"loadfile",
+	"loadstring",
+	"module",
+	"require",
+}
+
+// Setup configures the Lua codec.
+func Setup(conf config.Config) error {
+	maxExecutionTime = conf.ApplicationServer.Codec.Lua.MaxExecutionTime
+	return nil
+}
+
+// BinaryToJSON encodes the given binary payload to JSON. The script must
+// define a Decode(fPort, bytes, variables) function, returning a table.
+// Note that bytes is a Lua table and thus indexed from 1.
+func BinaryToJSON(fPort uint8, variables map[string]string, decodeScript string, b []byte) ([]byte, error) {
+	return BinaryToJSONWithLog(fPort, variables, decodeScript, b, nil)
+}
+
+// BinaryToJSONWithLog is equal to BinaryToJSON, but the messages printed by
+// the script using print are passed to the given log function.
+func BinaryToJSONWithLog(fPort uint8, variables map[string]string, decodeScript string, b []byte, logFn func(string)) ([]byte, error) {
+	bytes := make([]interface{}, len(b))
+	for i := range b {
+		bytes[i] = float64(b[i])
+	}
+
+	v, err := executeLua(decodeScript, "Decode", logFn, float64(fPort), bytes, stringMap(variables))
+	if err != nil {
+		return nil, errors.Wrap(err, "execute lua error")
+	}
+
+	return json.Marshal(v)
+}
+
+// JSONToBinary encodes the given JSON payload to binary. The script must
+// define an Encode(fPort, obj, variables) function, returning a table of
+// bytes.
+func JSONToBinary(fPort uint8, variables map[string]string, encodeScript string, b []byte) ([]byte, error) {
+	return JSONToBinaryWithLog(fPort, variables, encodeScript, b, nil)
+}
+
+// JSONToBinaryWithLog is equal to JSONToBinary, but the messages printed by
+// the script using print are passed to the given log function.
+func JSONToBinaryWithLog(fPort uint8, variables map[string]string, encodeScript string, b []byte, logFn func(string)) ([]byte, error) {
+	var obj interface{}
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return nil, errors.Wrap(err, "unmarshal json error")
+	}
+
+	v, err := executeLua(encodeScript, "Encode", logFn, float64(fPort), obj, stringMap(variables))
+	if err != nil {
+		return nil, errors.Wrap(err, "execute lua error")
+	}
+
+	return interfaceToByteSlice(v)
+}
+
+// logDebug logs the print output when the script is not executed with a
+// log function (e.g. outside the codec test sandbox).
+func logDebug(msg string) {
+	log.WithField("output", msg).Debug("codec/lua: print")
+}
+
+func executeLua(script, fn string, logFn func(string), args ...interface{}) (out interface{}, err error) {
+	defer func() {
+		if caught := recover(); caught != nil {
+			err = fmt.Errorf("%s", caught)
+		}
+	}()
+
+	ls := glua.NewState(glua.Options{
+		SkipOpenLibs:  true,
+		CallStackSize: 32,
+	})
+	defer ls.Close()
+
+	for _, lib := range []struct {
+		name string
+		fn   glua.LGFunction
+	}{
+		{glua.BaseLibName, glua.OpenBase},
+		{glua.TabLibName, glua.OpenTable},
+		{glua.StringLibName, glua.OpenString},
+		{glua.MathLibName, glua.OpenMath},
+	} {
+		if err := ls.CallByParam(glua.P{
+			Fn:      ls.NewFunction(lib.fn),
+			Protect: true,
+		}, glua.LString(lib.name)); err != nil {
+			return nil, errors.Wrap(err, "open lib error")
+		}
+	}
+
+	for _, name := range unsafeGlobals {
+		ls.SetGlobal(name, glua.LNil)
+	}
+
+	// the print function of the base library writes to stdout
+	if logFn == nil {
+		logFn = logDebug
+	}
+	ls.SetGlobal("print", ls.NewFunction(func(ls *glua.LState) int {
+		args := make([]string, ls.GetTop())
+		for i := range args {
+			args[i] = ls.ToStringMeta(ls.Get(i + 1)).String()
+		}
+		logFn(strings.Join(args, "\t"))
+		return 0
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), maxExecutionTime)
+	defer cancel()
+	ls.SetContext(ctx)
+
+	if err := ls.DoString(script); err != nil {
+		return nil, luaError(ctx, err)
+	}
+
+	f := ls.GetGlobal(fn)
+	if f.Type() != glua.LTFunction {
+		return nil, fmt.Errorf("lua vm error: '%s' is not defined", fn)
+	}
+
+	var largs []glua.LValue
+	for _, arg := range args {
+		v, err := toLua(ls, arg, 0)
+		if err != nil {
+			return nil, errors.Wrap(err, "set variable error")
+		}
+		largs = append(largs, v)
+	}
+
+	if err := ls.CallByParam(glua.P{
+		Fn:      f,
+		NRet:    1,
+		Protect: true,
+	}, largs...); err != nil {
+		return nil, luaError(ctx, err)
+	}
+
+	ret := ls.Get(-1)
+	ls.Pop(1)
+
+	return fromLua(ret, 0)
+}
+
+func luaError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return errors.New("execution timeout")
+	}
+	return errors.Wrap(err, "lua vm error")
+}
+
+// toLua converts the given (JSON decoded) value to a Lua value.
+func toLua(ls *glua.LState, v interface{}, depth int) (glua.LValue, error) {
+	if depth > maxDepth {
+		return nil, errors.New("max. depth exceeded")
+	}
+
+	switch v := v.(type) {
+	case nil:
+		return glua.LNil, nil
+	case bool:
+		return glua.LBool(v), nil
+	case float64:
+		return glua.LNumber(v), nil
+	case string:
+		return glua.LString(v), nil
+	case []interface{}:
+		tbl := ls.NewTable()
+		for _, el := range v {
+			lv, err := toLua(ls, el, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			tbl.Append(lv)
+		}
+		return tbl, nil
+	case map[string]interface{}:
+		tbl := ls.NewTable()
+		for k, el := range v {
+			lv, err := toLua(ls, el, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			tbl.RawSetString(k, lv)
+		}
+		return tbl, nil
+	default:
+		return nil, fmt.Errorf("unsupported type: %T", v)
+	}
+}
+
+// fromLua converts the given Lua value to a value which can be encoded as
+// JSON. Tables with only the keys 1..n are converted to an array, other
+// tables are converted to an object.
+func fromLua(v glua.LValue, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.New("max. depth exceeded")
+	}
+
+	switch v := v.(type) {
+	case *glua.LNilType:
+		return nil, nil
+	case glua.LBool:
+		return bool(v), nil
+	case glua.LNumber:
+		f := float64(v)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("unsupported number: %f", f)
+		}
+		return f, nil
+	case glua.LString:
+		return string(v), nil
+	case *glua.LTable:
+		var count int
+		v.ForEach(func(glua.LValue, glua.LValue) { count++ })
+
+		if n := v.MaxN(); n > 0 && n == count {
+			out := make([]interface{}, n)
+			for i := 1; i <= n; i++ {
+				el, err := fromLua(v.RawGetInt(i), depth+1)
+				if err != nil {
+					return nil, err
+				}
+				out[i-1] = el
+			}
+			return out, nil
+		}
+
+		out := make(map[string]interface{})
+		var err error
+		v.ForEach(func(key, val glua.LValue) {
+			if err != nil {
+				return
+			}
+			var el interface{}
+			el, err = fromLua(val, depth+1)
+			out[key.String()] = el
+		})
+		if err != nil {
+			return nil, err
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unsupported type: %s", v.Type())
+	}
+}
+
+func stringMap(m map[string]string) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+func interfaceToByteSlice(obj interface{}) ([]byte, error) {
+	if obj == nil {
+		return nil, errors.New("value must not be nil")
+	}
+
+	// an empty table is converted to an object
+	if m, ok := obj.(map[string]interface{}); ok && len(m) == 0 {
+		return []byte{}, nil
+	}
+
+	s, ok := obj.([]interface{})
+	if !ok {
+		return nil, errors.New("value must be an array")
+	}
+
+	var out []byte
+	for _, el := range s {
+		v, ok := el.(float64)
+		if !ok {
+			return nil, fmt.Errorf("array value must be an array of numbers, got: %T", el)
+		}
+
+		b := int64(v)
+		if float64(b) != v {
+			return nil, fmt.Errorf("array value must be in byte range (0 - 255), got: %f", v)
+		}
+		if b < 0 || b > 255 {
+			return nil, fmt.Errorf("array value must be in byte range (0 - 255), got: %d", b)
+		}
+
+		out = append(out, byte(b))
+	}
+
+	return out, nil
+}
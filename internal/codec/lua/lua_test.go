@@ -0,0 +1,252 @@
+package lua
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLuaDecode(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Script        string
+		Payload       []byte
+		FPort         uint8
+		Variables     map[string]string
+		ExpectedJSON  string
+		ExpectedError error
+	}{
+		{
+			Name: "valid function",
+			Script: `
+					function Decode(port, bytes)
+						return {
+							port = port,
+							on = bytes[1] == 1,
+							values = {bytes[2], bytes[3]}
+						}
+					end
+				`,
+			Payload:      []byte{1, 2, 3},
+			FPort:        3,
+			ExpectedJSON: `{"on":true,"port":3,"values":[2,3]}`,
+		},
+		{
+			Name:          "function error",
+			Script:        ``,
+			Payload:       []byte{1},
+			FPort:         3,
+			ExpectedError: errors.New("execute lua error: lua vm error: 'Decode' is not defined"),
+		},
+		{
+			Name: "function timeout",
+			Script: `
+					function Decode(fPort, bytes)
+						while true do end
+					end
+				`,
+			Payload:       []byte{1},
+			FPort:         3,
+			ExpectedError: errors.New("execute lua error: execution timeout"),
+		},
+		{
+			Name: "variables",
+			Script: `
+					function Decode(port, bytes, variables)
+						return {
+							port = port,
+							calibration = tonumber(variables["calibration"])
+						}
+					end
+				`,
+			Payload: []byte{1},
+			FPort:   3,
+			Variables: map[string]string{
+				"calibration": "1.123",
+			},
+			ExpectedJSON: `{"calibration":1.123,"port":3}`,
+		},
+		{
+			Name: "string and math libraries",
+			Script: `
+					function Decode(port, bytes)
+						return {
+							hex = string.format("%02x%02x", bytes[1], bytes[2]),
+							max = math.max(bytes[1], bytes[2])
+						}
+					end
+				`,
+			Payload:      []byte{10, 255},
+			FPort:        1,
+			ExpectedJSON: `{"hex":"0aff","max":255}`,
+		},
+		{
+			Name: "unsafe functions are not available",
+			Script: `
+					function Decode(port, bytes)
+						return { os = os == nil, io = io == nil, dofile = dofile == nil, require = require == nil }
+					end
+				`,
+			Payload:      []byte{1},
+			FPort:        1,
+			ExpectedJSON: `{"dofile":true,"io":true,"os":true,"require":true}`,
+		},
+		{
+			Name: "self referencing table",
+			Script: `
+					function Decode(port, bytes)
+						local t = {}
+						t.self = t
+						return t
+					end
+				`,
+			Payload:       []byte{1},
+			FPort:         1,
+			ExpectedError: errors.New("execute lua error: max. depth exceeded"),
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			jsonB, err := BinaryToJSON(tst.FPort, tst.Variables, tst.Script, tst.Payload)
+			if tst.ExpectedError != nil {
+				assert.Equal(tst.ExpectedError.Error(), err.Error())
+				return
+			}
+			assert.NoError(err)
+
+			assert.Equal(tst.ExpectedJSON, string(jsonB))
+		})
+	}
+}
+
+func TestLuaEncode(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Script        string
+		JSON          string
+		FPort         uint8
+		Variables     map[string]string
+		ExpectedBytes []byte
+		ExpectedError error
+	}{
+		{
+			Name: "valid function",
+			Script: `
+					function Encode(fPort, obj)
+						return { obj.Temp, fPort }
+					end
+				`,
+			FPort:         10,
+			JSON:          `{"Temp": 20}`,
+			ExpectedBytes: []byte{20, 10},
+		},
+		{
+			Name: "empty table",
+			Script: `
+					function Encode(fPort, obj)
+						return {}
+					end
+				`,
+			FPort:         10,
+			JSON:          `{"Temp": 20}`,
+			ExpectedBytes: []byte{},
+		},
+		{
+			Name: "return float array",
+			Script: `
+					function Encode(fPort, obj)
+						return { 1.123, 2.234 }
+					end
+				`,
+			FPort:         10,
+			JSON:          `{"Temp": 20}`,
+			ExpectedError: errors.New("array value must be in byte range (0 - 255), got: 1.123000"),
+		},
+		{
+			Name: "return invalid bytes",
+			Script: `
+					function Encode(fPort, obj)
+						return { 256, 123 }
+					end
+				`,
+			FPort:         10,
+			JSON:          `{"Temp": 20}`,
+			ExpectedError: errors.New("array value must be in byte range (0 - 255), got: 256"),
+		},
+		{
+			Name:          "invalid function",
+			Script:        ``,
+			FPort:         10,
+			JSON:          `{"Temp": 20}`,
+			ExpectedError: errors.New("execute lua error: lua vm error: 'Encode' is not defined"),
+		},
+		{
+			Name: "function timeout",
+			Script: `
+					function Encode(fPort, obj)
+						while true do end
+					end
+				`,
+			FPort:         10,
+			JSON:          `{"Temp": 20}`,
+			ExpectedError: errors.New("execute lua error: execution timeout"),
+		},
+		{
+			Name: "variables",
+			Script: `
+					function Encode(fPort, obj, variables)
+						return { obj.Temp - tonumber(variables["calibration"]), fPort }
+					end
+				`,
+			FPort: 10,
+			Variables: map[string]string{
+				"calibration": "5",
+			},
+			JSON:          `{"Temp": 20}`,
+			ExpectedBytes: []byte{15, 10},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			b, err := JSONToBinary(tst.FPort, tst.Variables, tst.Script, []byte(tst.JSON))
+			if tst.ExpectedError != nil {
+				assert.Equal(tst.ExpectedError.Error(), err.Error())
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tst.ExpectedBytes, b)
+		})
+	}
+}
+
+func TestLuaLog(t *testing.T) {
+	script := `
+		function Decode(fPort, bytes)
+			print("fPort", fPort)
+			return {}
+		end
+	`
+
+	t.Run("With log function", func(t *testing.T) {
+		assert := require.New(t)
+
+		var logs []string
+		_, err := BinaryToJSONWithLog(10, nil, script, []byte{1}, func(s string) { logs = append(logs, s) })
+		assert.NoError(err)
+		assert.Equal([]string{"fPort\t10"}, logs)
+	})
+
+	t.Run("Without log function", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := BinaryToJSON(10, nil, script, []byte{1})
+		assert.NoError(err)
+	})
+}
@@ -0,0 +1,27 @@
+package codec
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	cd = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "codec_execution_duration_seconds",
+		Help:    "The duration of the codec executions (per codec type and operation).",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"codec", "operation"})
+
+	ce = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "codec_execution_error_count",
+		Help: "The number of failed codec executions (per codec type and operation).",
+	}, []string{"codec", "operation"})
+)
+
+func codecDuration(t Type, op string) prometheus.Observer {
+	return cd.With(prometheus.Labels{"codec": string(t), "operation": op})
+}
+
+func codecErrorCounter(t Type, op string) prometheus.Counter {
+	return ce.With(prometheus.Labels{"codec": string(t), "operation": op})
+}
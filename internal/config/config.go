@@ -7,28 +7,88 @@ import (
 // Config defines the configuration structure.
 type Config struct {
 	General struct {
-		LogLevel                  int    `mapstructure:"log_level"`
-		LogToSyslog               bool   `mapstructure:"log_to_syslog"`
-		PasswordHashIterations    int    `mapstructure:"password_hash_iterations"`
-		GRPCDefaultResolverScheme string `mapstructure:"grpc_default_resolver_scheme"`
+		LogLevel                  int           `mapstructure:"log_level"`
+		LogFormat                 string        `mapstructure:"log_format"`
+		LogToSyslog               bool          `mapstructure:"log_to_syslog"`
+		PasswordHashIterations    int           `mapstructure:"password_hash_iterations"`
+		GRPCDefaultResolverScheme string        `mapstructure:"grpc_default_resolver_scheme"`
+		ShutdownTimeout           time.Duration `mapstructure:"shutdown_timeout"`
+
+		LogForwarding struct {
+			Syslog struct {
+				Server   string `mapstructure:"server"`
+				TLS      bool   `mapstructure:"tls"`
+				CACert   string `mapstructure:"ca_cert"`
+				TLSCert  string `mapstructure:"tls_cert"`
+				TLSKey   string `mapstructure:"tls_key"`
+				AppName  string `mapstructure:"app_name"`
+				Facility int    `mapstructure:"facility"`
+			} `mapstructure:"syslog"`
+
+			Loki struct {
+				URL           string            `mapstructure:"url"`
+				Username      string            `mapstructure:"username"`
+				Password      string            `mapstructure:"password"`
+				TenantID      string            `mapstructure:"tenant_id"`
+				Labels        map[string]string `mapstructure:"labels"`
+				BatchSize     int               `mapstructure:"batch_size"`
+				BatchInterval time.Duration     `mapstructure:"batch_interval"`
+			} `mapstructure:"loki"`
+		} `mapstructure:"log_forwarding"`
 	} `mapstructure:"general"`
 
 	PostgreSQL struct {
 		DSN                string `mapstructure:"dsn"`
 		Automigrate        bool
-		MaxOpenConnections int `mapstructure:"max_open_connections"`
-		MaxIdleConnections int `mapstructure:"max_idle_connections"`
+		MaxOpenConnections int           `mapstructure:"max_open_connections"`
+		MaxIdleConnections int           `mapstructure:"max_idle_connections"`
+		ConnMaxLifetime    time.Duration `mapstructure:"conn_max_lifetime"`
+		StatementTimeout   time.Duration `mapstructure:"statement_timeout"`
+		SSLMode            string        `mapstructure:"ssl_mode"`
+		CACert             string        `mapstructure:"ca_cert"`
+		TLSCert            string        `mapstructure:"tls_cert"`
+		TLSKey             string        `mapstructure:"tls_key"`
+		TLSServerName      string        `mapstructure:"tls_server_name"`
+
+		SchemaPerOrganization bool `mapstructure:"schema_per_organization"`
+		PgBouncerMode         bool `mapstructure:"pgbouncer_mode"`
+
+		TransactionMaxRetries   int           `mapstructure:"transaction_max_retries"`
+		TransactionRetryBackoff time.Duration `mapstructure:"transaction_retry_backoff"`
+
+		Partitioning struct {
+			Interval      string        `mapstructure:"interval"`
+			Premake       int           `mapstructure:"premake"`
+			Retention     time.Duration `mapstructure:"retention"`
+			CheckInterval time.Duration `mapstructure:"check_interval"`
+		} `mapstructure:"partitioning"`
+
+		BatchWrites struct {
+			Size        int           `mapstructure:"size"`
+			Interval    time.Duration `mapstructure:"interval"`
+			MaxBuffered int           `mapstructure:"max_buffered"`
+		} `mapstructure:"batch_writes"`
+
+		IndexMaintenance struct {
+			AnalyzeInterval time.Duration `mapstructure:"analyze_interval"`
+			ReindexInterval time.Duration `mapstructure:"reindex_interval"`
+		} `mapstructure:"index_maintenance"`
 	} `mapstructure:"postgresql"`
 
 	Redis struct {
-		URL        string   `mapstructure:"url"` // deprecated
-		Servers    []string `mapstructure:"servers"`
-		Cluster    bool     `mapstructure:"cluster"`
-		MasterName string   `mapstructure:"master_name"`
-		PoolSize   int      `mapstructure:"pool_size"`
-		Password   string   `mapstructure:"password"`
-		Database   int      `mapstructure:"database"`
-		TLSEnabled bool     `mapstructure:"tls_enabled"`
+		URL           string   `mapstructure:"url"` // deprecated
+		Servers       []string `mapstructure:"servers"`
+		Cluster       bool     `mapstructure:"cluster"`
+		MasterName    string   `mapstructure:"master_name"`
+		PoolSize      int      `mapstructure:"pool_size"`
+		Password      string   `mapstructure:"password"`
+		Database      int      `mapstructure:"database"`
+		TLSEnabled    bool     `mapstructure:"tls_enabled"`
+		CACert        string   `mapstructure:"ca_cert"`
+		TLSCert       string   `mapstructure:"tls_cert"`
+		TLSKey        string   `mapstructure:"tls_key"`
+		TLSServerName string   `mapstructure:"tls_server_name"`
+		KeyPrefix     string   `mapstructure:"key_prefix"`
 	} `mapstructure:"redis"`
 
 	ApplicationServer struct {
@@ -51,9 +111,108 @@ type Config struct {
 		Codec struct {
 			JS struct {
 				MaxExecutionTime time.Duration `mapstructure:"max_execution_time"`
+				MaxMemory        int64         `mapstructure:"max_memory"`
+				MaxInputSize     int           `mapstructure:"max_input_size"`
+				MaxOutputSize    int           `mapstructure:"max_output_size"`
 			} `mapstructure:"js"`
+
+			Lua struct {
+				MaxExecutionTime time.Duration `mapstructure:"max_execution_time"`
+			} `mapstructure:"lua"`
+
+			ExternalGRPC struct {
+				Server         string        `mapstructure:"server"`
+				CACert         string        `mapstructure:"ca_cert"`
+				TLSCert        string        `mapstructure:"tls_cert"`
+				TLSKey         string        `mapstructure:"tls_key"`
+				Timeout        time.Duration `mapstructure:"timeout"`
+				CircuitBreaker struct {
+					FailureThreshold int           `mapstructure:"failure_threshold"`
+					OpenDuration     time.Duration `mapstructure:"open_duration"`
+				} `mapstructure:"circuit_breaker"`
+			} `mapstructure:"external_grpc"`
 		} `mapstructure:"codec"`
 
+		DownlinkValidation struct {
+			Validators []string `mapstructure:"validators"`
+		} `mapstructure:"downlink_validation"`
+
+		ConfirmedDownlinkRetry struct {
+			Interval  time.Duration `mapstructure:"interval"`
+			BatchSize int           `mapstructure:"batch_size"`
+		} `mapstructure:"confirmed_downlink_retry"`
+
+		DeviceQueueExpiry struct {
+			Interval  time.Duration `mapstructure:"interval"`
+			BatchSize int           `mapstructure:"batch_size"`
+		} `mapstructure:"device_queue_expiry"`
+
+		DownlinkRateLimit struct {
+			Device      DownlinkRateLimit `mapstructure:"device"`
+			Application DownlinkRateLimit `mapstructure:"application"`
+		} `mapstructure:"downlink_rate_limit"`
+
+		DownlinkDeduplication struct {
+			Mode   string        `mapstructure:"mode"`
+			Window time.Duration `mapstructure:"window"`
+		} `mapstructure:"downlink_deduplication"`
+
+		DownlinkRule struct {
+			MinCooldown     time.Duration `mapstructure:"min_cooldown"`
+			MaxTriggers     int           `mapstructure:"max_triggers"`
+			TriggerInterval time.Duration `mapstructure:"trigger_interval"`
+		} `mapstructure:"downlink_rule"`
+
+		Automation struct {
+			Interval           time.Duration `mapstructure:"interval"`
+			BatchSize          int           `mapstructure:"batch_size"`
+			MinCooldown        time.Duration `mapstructure:"min_cooldown"`
+			ExecutionRetention time.Duration `mapstructure:"execution_retention"`
+
+			Email struct {
+				Server   string `mapstructure:"server"`
+				Username string `mapstructure:"username"`
+				Password string `mapstructure:"password"`
+				From     string `mapstructure:"from"`
+			} `mapstructure:"email"`
+
+			SMS struct {
+				URL      string `mapstructure:"url"`
+				Username string `mapstructure:"username"`
+				Password string `mapstructure:"password"`
+				From     string `mapstructure:"from"`
+			} `mapstructure:"sms"`
+		} `mapstructure:"automation"`
+
+		Reports struct {
+			Interval  time.Duration `mapstructure:"interval"`
+			BatchSize int           `mapstructure:"batch_size"`
+
+			Email struct {
+				Server   string `mapstructure:"server"`
+				Username string `mapstructure:"username"`
+				Password string `mapstructure:"password"`
+				From     string `mapstructure:"from"`
+			} `mapstructure:"email"`
+		} `mapstructure:"reports"`
+
+		Irrigation struct {
+			Interval  time.Duration `mapstructure:"interval"`
+			BatchSize int           `mapstructure:"batch_size"`
+		} `mapstructure:"irrigation"`
+
+		LeaderElection struct {
+			Enabled bool          `mapstructure:"enabled"`
+			LockTTL time.Duration `mapstructure:"lock_ttl"`
+		} `mapstructure:"leader_election"`
+
+		UplinkPipeline struct {
+			Enabled        bool          `mapstructure:"enabled"`
+			Workers        int           `mapstructure:"workers"`
+			QueueSize      int           `mapstructure:"queue_size"`
+			EnqueueTimeout time.Duration `mapstructure:"enqueue_timeout"`
+		} `mapstructure:"uplink_pipeline"`
+
 		Integration struct {
 			Marshaler       string                      `mapstructure:"marshaler"`
 			Backend         string                      `mapstructure:"backend"` // deprecated
@@ -81,6 +240,7 @@ type Config struct {
 			TLSKey          string `mapstructure:"tls_key"`
 			JWTSecret       string `mapstructure:"jwt_secret"`
 			CORSAllowOrigin string `mapstructure:"cors_allow_origin"`
+			V4Compat        bool   `mapstructure:"v4_compat"`
 		} `mapstructure:"external_api"`
 
 		RemoteMulticastSetup struct {
@@ -96,32 +256,172 @@ type Config struct {
 		} `mapstructure:"fragmentation_session"`
 
 		FUOTADeployment struct {
-			McGroupID int `mapstructure:"mc_group_id"`
-			FragIndex int `mapstructure:"frag_index"`
+			McGroupID              int           `mapstructure:"mc_group_id"`
+			FragIndex              int           `mapstructure:"frag_index"`
+			GatewaySelectionWindow time.Duration `mapstructure:"gateway_selection_window"`
 		} `mapstructure:"fuota_deployment"`
 
+		FirmwareCampaign struct {
+			SyncInterval  time.Duration `mapstructure:"sync_interval"`
+			SyncBatchSize int           `mapstructure:"sync_batch_size"`
+		} `mapstructure:"firmware_campaign"`
+
+		Retention struct {
+			CleanupInterval time.Duration `mapstructure:"cleanup_interval"`
+		} `mapstructure:"retention"`
+
+		Usage struct {
+			FlushInterval time.Duration `mapstructure:"flush_interval"`
+		} `mapstructure:"usage"`
+
+		Archive struct {
+			FramesDays int           `mapstructure:"frames_days"`
+			EventsDays int           `mapstructure:"events_days"`
+			Interval   time.Duration `mapstructure:"interval"`
+
+			S3 struct {
+				Bucket          string `mapstructure:"bucket"`
+				Prefix          string `mapstructure:"prefix"`
+				Region          string `mapstructure:"region"`
+				Endpoint        string `mapstructure:"endpoint"`
+				AccessKeyID     string `mapstructure:"access_key_id"`
+				SecretAccessKey string `mapstructure:"secret_access_key"`
+				ForcePathStyle  bool   `mapstructure:"force_path_style"`
+			} `mapstructure:"s3"`
+		} `mapstructure:"archive"`
+
+		Cache struct {
+			Size int           `mapstructure:"size"`
+			TTL  time.Duration `mapstructure:"ttl"`
+		} `mapstructure:"cache"`
+
 		Branding struct {
 			Footer       string
 			Registration string
 		} `mapstructure:"branding"`
 	} `mapstructure:"application_server"`
 
+	NetworkServer struct {
+		RegionSyncInterval time.Duration `mapstructure:"region_sync_interval"`
+
+		Retry struct {
+			MaxAttempts    int           `mapstructure:"max_attempts"`
+			InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+			MaxBackoff     time.Duration `mapstructure:"max_backoff"`
+		} `mapstructure:"retry"`
+
+		Failover []NetworkServerFailover `mapstructure:"failover"`
+
+		Client struct {
+			KeepaliveTime                time.Duration `mapstructure:"keepalive_time"`
+			KeepaliveTimeout             time.Duration `mapstructure:"keepalive_timeout"`
+			KeepalivePermitWithoutStream bool          `mapstructure:"keepalive_permit_without_stream"`
+			MaxRecvMsgSize               int           `mapstructure:"max_recv_msg_size"`
+			MaxSendMsgSize               int           `mapstructure:"max_send_msg_size"`
+			ConnectTimeout               time.Duration `mapstructure:"connect_timeout"`
+			BackoffBaseDelay             time.Duration `mapstructure:"backoff_base_delay"`
+			BackoffMaxDelay              time.Duration `mapstructure:"backoff_max_delay"`
+			HealthCheckInterval          time.Duration `mapstructure:"health_check_interval"`
+		} `mapstructure:"client"`
+
+		TLS []NetworkServerTLS `mapstructure:"tls"`
+	} `mapstructure:"network_server"`
+
 	JoinServer struct {
 		Bind    string
 		CACert  string `mapstructure:"ca_cert"`
 		TLSCert string `mapstructure:"tls_cert"`
 		TLSKey  string `mapstructure:"tls_key"`
 
+		Client []struct {
+			NetID  string   `mapstructure:"net_id"`
+			CACert string   `mapstructure:"ca_cert"`
+			Names  []string `mapstructure:"names"`
+		} `mapstructure:"client"`
+
+		Forward []JoinServerForward `mapstructure:"forward"`
+
 		KEK struct {
-			ASKEKLabel string `mapstructure:"as_kek_label"`
+			ASKEKLabel      string        `mapstructure:"as_kek_label"`
+			SetFile         string        `mapstructure:"set_file"`
+			RefreshInterval time.Duration `mapstructure:"refresh_interval"`
 
-			Set []struct {
-				Label string `mapstructure:"label"`
-				KEK   string `mapstructure:"kek"`
-			}
+			Set []KEK `mapstructure:"set"`
+
+			Vault struct {
+				Address string `mapstructure:"address"`
+				Token   string `mapstructure:"token"`
+				Path    string `mapstructure:"path"`
+				CACert  string `mapstructure:"ca_cert"`
+			} `mapstructure:"vault"`
 		} `mapstructure:"kek"`
+
+		HSM struct {
+			Module         string `mapstructure:"module"`
+			TokenLabel     string `mapstructure:"token_label"`
+			PIN            string `mapstructure:"pin"`
+			KeyLabelPrefix string `mapstructure:"key_label_prefix"`
+		} `mapstructure:"hsm"`
+
+		BruteForceProtection struct {
+			Device  JoinFailureLimit `mapstructure:"device"`
+			JoinEUI JoinFailureLimit `mapstructure:"join_eui"`
+			Sender  JoinFailureLimit `mapstructure:"sender"`
+		} `mapstructure:"brute_force_protection"`
 	} `mapstructure:"join_server"`
 
+	KMS struct {
+		Provider string `mapstructure:"provider"`
+
+		AWS struct {
+			Region          string `mapstructure:"region"`
+			Endpoint        string `mapstructure:"endpoint"`
+			AccessKeyID     string `mapstructure:"access_key_id"`
+			SecretAccessKey string `mapstructure:"secret_access_key"`
+			KeyID           string `mapstructure:"key_id"`
+		} `mapstructure:"aws"`
+
+		GCP struct {
+			CredentialsFile string `mapstructure:"credentials_file"`
+			KeyName         string `mapstructure:"key_name"`
+		} `mapstructure:"gcp"`
+
+		Vault struct {
+			Address string `mapstructure:"address"`
+			Token   string `mapstructure:"token"`
+			Mount   string `mapstructure:"mount"`
+			KeyName string `mapstructure:"key_name"`
+			CACert  string `mapstructure:"ca_cert"`
+		} `mapstructure:"vault"`
+
+		Azure struct {
+			VaultURL     string `mapstructure:"vault_url"`
+			KeyName      string `mapstructure:"key_name"`
+			KeyVersion   string `mapstructure:"key_version"`
+			Algorithm    string `mapstructure:"algorithm"`
+			TenantID     string `mapstructure:"tenant_id"`
+			ClientID     string `mapstructure:"client_id"`
+			ClientSecret string `mapstructure:"client_secret"`
+		} `mapstructure:"azure"`
+	} `mapstructure:"kms"`
+
+	Secrets struct {
+		RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+
+		Vault struct {
+			Address string `mapstructure:"address"`
+			Token   string `mapstructure:"token"`
+			CACert  string `mapstructure:"ca_cert"`
+		} `mapstructure:"vault"`
+
+		AWS struct {
+			Region          string `mapstructure:"region"`
+			Endpoint        string `mapstructure:"endpoint"`
+			AccessKeyID     string `mapstructure:"access_key_id"`
+			SecretAccessKey string `mapstructure:"secret_access_key"`
+		} `mapstructure:"aws"`
+	} `mapstructure:"secrets"`
+
 	Metrics struct {
 		Timezone string `mapstructure:"timezone"`
 		Redis    struct {
@@ -139,10 +439,45 @@ type Config struct {
 	} `mapstructure:"metrics"`
 
 	Monitoring struct {
-		Bind                         string `mapstructure:"bind"`
-		PrometheusEndpoint           bool   `mapstructure:"prometheus_endpoint"`
-		PrometheusAPITimingHistogram bool   `mapstructure:"prometheus_api_timing_histogram"`
-		HealthcheckEndpoint          bool   `mapstructure:"healthcheck_endpoint"`
+		Bind                         string        `mapstructure:"bind"`
+		PrometheusEndpoint           bool          `mapstructure:"prometheus_endpoint"`
+		PrometheusAPITimingHistogram bool          `mapstructure:"prometheus_api_timing_histogram"`
+		HealthcheckEndpoint          bool          `mapstructure:"healthcheck_endpoint"`
+		HealthcheckTimeout           time.Duration `mapstructure:"healthcheck_timeout"`
+		SlowQueryThreshold           time.Duration `mapstructure:"slow_query_threshold"`
+		SlowIntegrationThreshold     time.Duration `mapstructure:"slow_integration_threshold"`
+
+		Diagnostics struct {
+			Bind  string `mapstructure:"bind"`
+			Token string `mapstructure:"token"`
+		} `mapstructure:"diagnostics"`
+
+		Tracing struct {
+			OTLPEndpoint string  `mapstructure:"otlp_endpoint"`
+			Insecure     bool    `mapstructure:"insecure"`
+			SampleRatio  float64 `mapstructure:"sample_ratio"`
+			ServiceName  string  `mapstructure:"service_name"`
+		} `mapstructure:"tracing"`
+
+		Alerting struct {
+			CheckInterval               time.Duration `mapstructure:"check_interval"`
+			RepeatInterval              time.Duration `mapstructure:"repeat_interval"`
+			IntegrationFailureThreshold int           `mapstructure:"integration_failure_threshold"`
+			RedisLatencyThreshold       time.Duration `mapstructure:"redis_latency_threshold"`
+
+			Webhook struct {
+				URL     string            `mapstructure:"url"`
+				Headers map[string]string `mapstructure:"headers"`
+			} `mapstructure:"webhook"`
+
+			Email struct {
+				Server   string   `mapstructure:"server"`
+				Username string   `mapstructure:"username"`
+				Password string   `mapstructure:"password"`
+				From     string   `mapstructure:"from"`
+				To       []string `mapstructure:"to"`
+			} `mapstructure:"email"`
+		} `mapstructure:"alerting"`
 	} `mapstructure:"monitoring"`
 }
 
@@ -233,6 +568,60 @@ type IntegrationKafkaConfig struct {
 	Algorithm        string   `mapstructure:"algorithm"`
 }
 
+// DownlinkRateLimit holds a downlink rate limit configuration.
+type DownlinkRateLimit struct {
+	MaxDownlinks int           `mapstructure:"max_downlinks"`
+	Interval     time.Duration `mapstructure:"interval"`
+}
+
+// JoinFailureLimit holds the max. number of failed join-requests within the
+// given interval, after which join-requests are refused for the block
+// duration.
+type JoinFailureLimit struct {
+	MaxFailures   int           `mapstructure:"max_failures"`
+	Interval      time.Duration `mapstructure:"interval"`
+	BlockDuration time.Duration `mapstructure:"block_duration"`
+}
+
+// KEK holds a key encryption key configuration. Either the hex encoded KEK
+// or the base64 encoded KEK, wrapped by the configured kms provider, must be
+// set.
+type KEK struct {
+	Label      string `mapstructure:"label" json:"label"`
+	KEK        string `mapstructure:"kek" json:"kek"`
+	WrappedKEK string `mapstructure:"wrapped_kek" json:"wrappedKEK"`
+}
+
+// NetworkServerFailover holds the standby network-server to which the API
+// requests are sent when the given network-server is unavailable.
+type NetworkServerFailover struct {
+	Server  string `mapstructure:"server"`
+	Standby string `mapstructure:"standby"`
+}
+
+// NetworkServerTLS holds the TLS configuration (file paths) of the API
+// client of the given network-server. This is used when no certificates are
+// configured in the network-server settings.
+type NetworkServerTLS struct {
+	Server     string `mapstructure:"server"`
+	CACert     string `mapstructure:"ca_cert"`
+	TLSCert    string `mapstructure:"tls_cert"`
+	TLSKey     string `mapstructure:"tls_key"`
+	ServerName string `mapstructure:"server_name"`
+}
+
+// JoinServerForward holds the configuration of an external join-server to
+// which the join-requests for the given JoinEUI range are forwarded.
+type JoinServerForward struct {
+	JoinEUIFrom string        `mapstructure:"join_eui_from"`
+	JoinEUITo   string        `mapstructure:"join_eui_to"`
+	Server      string        `mapstructure:"server"`
+	CACert      string        `mapstructure:"ca_cert"`
+	TLSCert     string        `mapstructure:"tls_cert"`
+	TLSKey      string        `mapstructure:"tls_key"`
+	Timeout     time.Duration `mapstructure:"timeout"`
+}
+
 // AzurePublishMode defines the publish-mode type.
 type AzurePublishMode string
 
@@ -0,0 +1,55 @@
+// Package contact implements the handling of binary contact sensors (e.g.
+// door and window sensors), configured as CONTACT measurements of the
+// device-profile. It tracks the state of each contact, records the open and
+// close events (with the open duration) and resolves the CONTACT_OPEN alarms
+// once the contact has been closed. The "left open" alarms are raised by the
+// automation engine.
+package contact
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation"
+	"github.com/ibrahimozekici/app-server2/internal/measurement"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// HandleUplink updates the contact states of the device with the CONTACT
+// measurements of the uplink, received at the given time. Other
+// measurements are ignored.
+func HandleUplink(ctx context.Context, d storage.Device, measurements []measurement.Measurement, t time.Time) error {
+	for _, m := range measurements {
+		if m.Kind != measurement.Contact {
+			continue
+		}
+
+		var ev *storage.ContactEvent
+		err := storage.Transaction(func(tx sqlx.Ext) error {
+			var err error
+			ev, err = storage.UpdateContactState(ctx, tx, d.DevEUI, m.Name, m.IsOpen(), t)
+			return err
+		})
+		if err != nil {
+			return errors.Wrap(err, "update contact state error")
+		}
+
+		if ev == nil || ev.Open {
+			continue
+		}
+
+		var openSince time.Time
+		if ev.OpenSeconds != nil {
+			openSince = ev.Time.Add(-time.Duration(*ev.OpenSeconds * float64(time.Second)))
+		}
+
+		if err := automation.HandleContactClosed(ctx, d, m.Name, openSince); err != nil {
+			return errors.Wrap(err, "handle contact closed error")
+		}
+	}
+
+	return nil
+}
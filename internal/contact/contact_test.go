@@ -0,0 +1,125 @@
+package contact
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	nsmock "github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/measurement"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	"github.com/ibrahimozekici/app-server2/internal/test"
+	//"github.com/brocaar/lorawan"
+)
+
+type ContactTestSuite struct {
+	suite.Suite
+
+	Device storage.Device
+}
+
+func (ts *ContactTestSuite) SetupSuite() {
+	assert := require.New(ts.T())
+	conf := test.GetConfig()
+	assert.NoError(storage.Setup(conf))
+}
+
+func (ts *ContactTestSuite) SetupTest() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	test.MustResetDB(storage.DB().DB)
+	storage.RedisClient().FlushAll()
+
+	networkserver.SetPool(nsmock.NewPool(nsmock.NewClient()))
+
+	n := storage.NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(ctx, storage.DB(), &n))
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(ctx, storage.DB(), &org))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(ctx, storage.DB(), &sp))
+	var spID uuid.UUID
+	copy(spID[:], sp.ServiceProfile.Id)
+
+	dp := storage.DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(storage.CreateDeviceProfile(ctx, storage.DB(), &dp))
+	var dpID uuid.UUID
+	copy(dpID[:], dp.DeviceProfile.Id)
+
+	app := storage.Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(storage.CreateApplication(ctx, storage.DB(), &app))
+
+	ts.Device = storage.Device{
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "loading-dock",
+	}
+	assert.NoError(storage.CreateDevice(ctx, storage.DB(), &ts.Device))
+}
+
+func (ts *ContactTestSuite) TestHandleUplink() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Millisecond)
+
+	contact := func(open bool) []measurement.Measurement {
+		v := 0.0
+		if open {
+			v = 1
+		}
+		return []measurement.Measurement{
+			{Path: "door", Name: "door", Kind: measurement.Contact, Value: v},
+			{Path: "temperature", Name: "temperature", Kind: measurement.Gauge, Value: 21.5},
+		}
+	}
+
+	assert.NoError(HandleUplink(ctx, ts.Device, contact(true), now.Add(-10*time.Minute)))
+	assert.NoError(HandleUplink(ctx, ts.Device, contact(true), now.Add(-5*time.Minute)))
+	assert.NoError(HandleUplink(ctx, ts.Device, contact(false), now))
+
+	states, err := storage.GetContactStates(ctx, storage.DB(), ts.Device.DevEUI)
+	assert.NoError(err)
+	assert.Len(states, 1)
+	assert.Equal("door", states[0].Name)
+	assert.False(states[0].Open)
+
+	events, err := storage.GetContactEvents(ctx, storage.DB(), storage.ContactEventFilters{
+		DevEUI: ts.Device.DevEUI,
+		Limit:  10,
+	})
+	assert.NoError(err)
+	assert.Len(events, 2)
+	assert.False(events[0].Open)
+	assert.Equal(600.0, *events[0].OpenSeconds)
+	assert.True(events[1].Open)
+}
+
+func TestContact(t *testing.T) {
+	suite.Run(t, new(ContactTestSuite))
+}
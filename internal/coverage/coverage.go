@@ -0,0 +1,241 @@
+// Package coverage implements the coverage estimation of a gateway, based on
+// the RX metadata of the uplinks of located devices received by the gateway.
+// The devices are binned into square cells of which the average RSSI and SNR
+// are returned as GeoJSON, such that the estimated coverage can be rendered
+// as heat-map.
+package coverage
+
+import (
+	"math"
+	"sort"
+)
+
+// Quality classes of a cell, based on the average RSSI.
+const (
+	QualityGood = "good"
+	QualityFair = "fair"
+	QualityPoor = "poor"
+)
+
+const (
+	// metersPerDegree defines the (approximate) number of meters per degree
+	// latitude.
+	metersPerDegree = 111320.0
+
+	// earthRadius defines the mean earth radius (meters).
+	earthRadius = 6371000.0
+
+	// goodRSSI and fairRSSI define the min. average RSSI (dBm) of a cell
+	// with good and fair coverage.
+	goodRSSI = -100.0
+	fairRSSI = -115.0
+)
+
+// Location defines a location.
+type Location struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// Sample defines the (averaged) reception by the gateway of the uplinks of a
+// device at the given location.
+type Sample struct {
+	Location Location
+	Frames   int
+	RSSI     float64
+	LoRaSNR  float64
+}
+
+// Options defines the estimation options.
+type Options struct {
+	// CellSize defines the size (meters) of the cells.
+	CellSize float64
+}
+
+// FeatureCollection defines a GeoJSON feature collection.
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+// Feature defines a GeoJSON feature.
+type Feature struct {
+	Type       string      `json:"type"`
+	Geometry   Geometry    `json:"geometry"`
+	Properties interface{} `json:"properties"`
+}
+
+// Geometry defines a GeoJSON geometry. The coordinates are in longitude,
+// latitude order.
+type Geometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// GatewayProperties defines the properties of the gateway (point) feature.
+// MaxDistance is the distance (meters) to the farthest device received by
+// the gateway.
+type GatewayProperties struct {
+	Kind        string  `json:"kind"`
+	Devices     int     `json:"devices"`
+	Frames      int     `json:"frames"`
+	MaxDistance float64 `json:"maxDistance"`
+}
+
+// CellProperties defines the properties of a cell (polygon) feature. The
+// RSSI and SNR are the averages of the frames received within the cell.
+// Distance is the distance (meters) between the gateway and the center of
+// the cell.
+type CellProperties struct {
+	Kind     string  `json:"kind"`
+	Devices  int     `json:"devices"`
+	Frames   int     `json:"frames"`
+	RSSI     float64 `json:"rssi"`
+	LoRaSNR  float64 `json:"loRaSNR"`
+	Quality  string  `json:"quality"`
+	Distance float64 `json:"distance"`
+}
+
+type cellKey struct {
+	lat int64
+	lon int64
+}
+
+type cell struct {
+	devices int
+	frames  int
+	rssi    float64 // sum, weighted by frames
+	snr     float64 // sum, weighted by frames
+}
+
+// Estimate returns the estimated coverage of the gateway at the given
+// location as GeoJSON. The first feature is the gateway, followed by the
+// cells (ordered from south-west to north-east) containing at least one
+// device. The cells are aligned to a grid which is scaled for the latitude
+// of the gateway.
+func Estimate(gateway Location, samples []Sample, opts Options) FeatureCollection {
+	dLat := opts.CellSize / metersPerDegree
+	dLon := opts.CellSize / (metersPerDegree * math.Max(math.Cos(gateway.Latitude*math.Pi/180), 0.01))
+
+	gw := GatewayProperties{
+		Kind: "gateway",
+	}
+	cells := make(map[cellKey]*cell)
+
+	for _, s := range samples {
+		if s.Frames <= 0 {
+			continue
+		}
+
+		gw.Devices++
+		gw.Frames += s.Frames
+		gw.MaxDistance = math.Max(gw.MaxDistance, Distance(gateway, s.Location))
+
+		key := cellKey{
+			lat: int64(math.Floor(s.Location.Latitude / dLat)),
+			lon: int64(math.Floor(s.Location.Longitude / dLon)),
+		}
+
+		c, ok := cells[key]
+		if !ok {
+			c = &cell{}
+			cells[key] = c
+		}
+
+		c.devices++
+		c.frames += s.Frames
+		c.rssi += s.RSSI * float64(s.Frames)
+		c.snr += s.LoRaSNR * float64(s.Frames)
+	}
+
+	keys := make([]cellKey, 0, len(cells))
+	for k := range cells {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].lat != keys[j].lat {
+			return keys[i].lat < keys[j].lat
+		}
+		return keys[i].lon < keys[j].lon
+	})
+
+	gw.MaxDistance = math.Round(gw.MaxDistance)
+
+	fc := FeatureCollection{
+		Type: "FeatureCollection",
+		Features: []Feature{
+			{
+				Type: "Feature",
+				Geometry: Geometry{
+					Type:        "Point",
+					Coordinates: []float64{round(gateway.Longitude, 6), round(gateway.Latitude, 6)},
+				},
+				Properties: gw,
+			},
+		},
+	}
+
+	for _, k := range keys {
+		c := cells[k]
+
+		lat0 := float64(k.lat) * dLat
+		lon0 := float64(k.lon) * dLon
+		lat1 := lat0 + dLat
+		lon1 := lon0 + dLon
+
+		rssi := c.rssi / float64(c.frames)
+
+		fc.Features = append(fc.Features, Feature{
+			Type: "Feature",
+			Geometry: Geometry{
+				Type: "Polygon",
+				Coordinates: [][][]float64{{
+					{round(lon0, 6), round(lat0, 6)},
+					{round(lon1, 6), round(lat0, 6)},
+					{round(lon1, 6), round(lat1, 6)},
+					{round(lon0, 6), round(lat1, 6)},
+					{round(lon0, 6), round(lat0, 6)},
+				}},
+			},
+			Properties: CellProperties{
+				Kind:     "cell",
+				Devices:  c.devices,
+				Frames:   c.frames,
+				RSSI:     round(rssi, 1),
+				LoRaSNR:  round(c.snr/float64(c.frames), 1),
+				Quality:  quality(rssi),
+				Distance: math.Round(Distance(gateway, Location{Latitude: lat0 + dLat/2, Longitude: lon0 + dLon/2})),
+			},
+		})
+	}
+
+	return fc
+}
+
+// Distance returns the great-circle distance (meters) between the given
+// locations.
+func Distance(a, b Location) float64 {
+	lat1 := a.Latitude * math.Pi / 180
+	lat2 := b.Latitude * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+
+	h := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin(dLon/2), 2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+func quality(rssi float64) string {
+	switch {
+	case rssi >= goodRSSI:
+		return QualityGood
+	case rssi >= fairRSSI:
+		return QualityFair
+	default:
+		return QualityPoor
+	}
+}
+
+func round(v float64, decimals int) float64 {
+	p := math.Pow(10, float64(decimals))
+	return math.Round(v*p) / p
+}
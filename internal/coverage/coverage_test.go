@@ -0,0 +1,86 @@
+package coverage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimate(t *testing.T) {
+	gateway := Location{}
+
+	// at the equator, cells of 1113.2 meters are 0.01 degree
+	opts := Options{CellSize: 1113.2}
+
+	t.Run("No samples", func(t *testing.T) {
+		assert := require.New(t)
+
+		fc := Estimate(gateway, nil, opts)
+		assert.Equal("FeatureCollection", fc.Type)
+		assert.Len(fc.Features, 1)
+		assert.Equal(GatewayProperties{Kind: "gateway"}, fc.Features[0].Properties)
+	})
+
+	t.Run("Samples", func(t *testing.T) {
+		assert := require.New(t)
+
+		fc := Estimate(gateway, []Sample{
+			{Location: Location{Latitude: 0.005, Longitude: 0.005}, Frames: 2, RSSI: -90, LoRaSNR: 5},
+			{Location: Location{Latitude: 0.006, Longitude: 0.002}, Frames: 2, RSSI: -110, LoRaSNR: -5},
+			{Location: Location{Latitude: 0.015, Longitude: -0.005}, Frames: 1, RSSI: -120, LoRaSNR: -10},
+			{Location: Location{Latitude: 0.5, Longitude: 0.5}, Frames: 0},
+		}, opts)
+		assert.Len(fc.Features, 3)
+
+		gw := fc.Features[0]
+		assert.Equal(Geometry{Type: "Point", Coordinates: []float64{0, 0}}, gw.Geometry)
+		gwProps := gw.Properties.(GatewayProperties)
+		assert.Equal(3, gwProps.Devices)
+		assert.Equal(5, gwProps.Frames)
+		assert.InDelta(1758, gwProps.MaxDistance, 1)
+
+		c1 := fc.Features[1]
+		assert.Equal(Geometry{
+			Type: "Polygon",
+			Coordinates: [][][]float64{{
+				{0, 0}, {0.01, 0}, {0.01, 0.01}, {0, 0.01}, {0, 0},
+			}},
+		}, c1.Geometry)
+		c1Props := c1.Properties.(CellProperties)
+		assert.Equal(2, c1Props.Devices)
+		assert.Equal(4, c1Props.Frames)
+		assert.Equal(-100.0, c1Props.RSSI)
+		assert.Equal(0.0, c1Props.LoRaSNR)
+		assert.Equal(QualityGood, c1Props.Quality)
+		assert.InDelta(786, c1Props.Distance, 1)
+
+		c2 := fc.Features[2]
+		assert.Equal(Geometry{
+			Type: "Polygon",
+			Coordinates: [][][]float64{{
+				{-0.01, 0.01}, {0, 0.01}, {0, 0.02}, {-0.01, 0.02}, {-0.01, 0.01},
+			}},
+		}, c2.Geometry)
+		c2Props := c2.Properties.(CellProperties)
+		assert.Equal(1, c2Props.Devices)
+		assert.Equal(-120.0, c2Props.RSSI)
+		assert.Equal(QualityPoor, c2Props.Quality)
+	})
+}
+
+func TestQuality(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal(QualityGood, quality(-100))
+	assert.Equal(QualityFair, quality(-100.1))
+	assert.Equal(QualityFair, quality(-115))
+	assert.Equal(QualityPoor, quality(-115.1))
+}
+
+func TestDistance(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal(0.0, Distance(Location{Latitude: 52, Longitude: 4}, Location{Latitude: 52, Longitude: 4}))
+	assert.InDelta(111195, Distance(Location{}, Location{Longitude: 1}), 1)
+	assert.InDelta(111195, Distance(Location{}, Location{Latitude: 1}), 1)
+}
@@ -0,0 +1,269 @@
+package deviceio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// apiPageSize defines the number of items retrieved per list request.
+const apiPageSize = 100
+
+// apiTimeout defines the timeout of a single API request.
+const apiTimeout = 30 * time.Second
+
+// APIBackend implements the Backend interface using the (JSON) external API
+// of an application-server. As the device and its keys are created using
+// two requests, a device might be created without keys when the second
+// request fails.
+type APIBackend struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+// NewAPIBackend creates a new APIBackend. The server is the base URL of the
+// external API (e.g. https://localhost:8080) and the token an API key or
+// user JWT token.
+func NewAPIBackend(server, token string) *APIBackend {
+	return &APIBackend{
+		server: strings.TrimRight(server, "/"),
+		token:  token,
+		client: &http.Client{
+			Timeout: apiTimeout,
+		},
+	}
+}
+
+type apiDevice struct {
+	DevEUI            string            `json:"devEUI"`
+	Name              string            `json:"name"`
+	ApplicationID     int64             `json:"applicationID,string"`
+	Description       string            `json:"description"`
+	DeviceProfileID   string            `json:"deviceProfileID"`
+	SkipFCntCheck     bool              `json:"skipFCntCheck"`
+	ReferenceAltitude float64           `json:"referenceAltitude"`
+	Variables         map[string]string `json:"variables"`
+	Tags              map[string]string `json:"tags"`
+	IsDisabled        bool              `json:"isDisabled"`
+}
+
+type apiDeviceKeys struct {
+	DevEUI    string `json:"devEUI"`
+	NwkKey    string `json:"nwkKey"`
+	AppKey    string `json:"appKey"`
+	GenAppKey string `json:"genAppKey"`
+}
+
+type apiListResponse struct {
+	Result []struct {
+		ID     int64  `json:"id,string"`
+		DevEUI string `json:"devEUI"`
+	} `json:"result"`
+}
+
+// Export calls f for each device matching the given filters. Either the
+// organization or application filter must be set.
+func (b *APIBackend) Export(ctx context.Context, filters Filters, keys bool, f func(Record) error) error {
+	var appIDs []int64
+
+	switch {
+	case filters.ApplicationID != 0:
+		appIDs = []int64{filters.ApplicationID}
+	case filters.OrganizationID != 0:
+		err := b.list(ctx, "/api/applications", url.Values{"organizationID": {fmt.Sprintf("%d", filters.OrganizationID)}}, func(resp apiListResponse) {
+			for _, r := range resp.Result {
+				appIDs = append(appIDs, r.ID)
+			}
+		})
+		if err != nil {
+			return errors.Wrap(err, "list applications error")
+		}
+	default:
+		return errors.New("the organization or application filter must be set when using the api")
+	}
+
+	for _, appID := range appIDs {
+		var devEUIs []string
+		err := b.list(ctx, "/api/devices", url.Values{"applicationID": {fmt.Sprintf("%d", appID)}}, func(resp apiListResponse) {
+			for _, r := range resp.Result {
+				devEUIs = append(devEUIs, r.DevEUI)
+			}
+		})
+		if err != nil {
+			return errors.Wrap(err, "list devices error")
+		}
+
+		for _, devEUI := range devEUIs {
+			var resp struct {
+				Device apiDevice `json:"device"`
+			}
+			if err := b.do(ctx, http.MethodGet, "/api/devices/"+devEUI, nil, &resp); err != nil {
+				return errors.Wrapf(err, "get device %s error", devEUI)
+			}
+
+			d := resp.Device
+			r := Record{
+				DevEUI:            d.DevEUI,
+				ApplicationID:     d.ApplicationID,
+				DeviceProfileID:   d.DeviceProfileID,
+				Name:              d.Name,
+				Description:       d.Description,
+				SkipFCntCheck:     d.SkipFCntCheck,
+				ReferenceAltitude: d.ReferenceAltitude,
+				IsDisabled:        d.IsDisabled,
+				Variables:         d.Variables,
+				Tags:              d.Tags,
+			}
+
+			if keys {
+				var resp struct {
+					DeviceKeys apiDeviceKeys `json:"deviceKeys"`
+				}
+				err := b.do(ctx, http.MethodGet, "/api/devices/"+devEUI+"/keys", nil, &resp)
+				if err != nil && errors.Cause(err) != storage.ErrDoesNotExist {
+					return errors.Wrapf(err, "get device-keys %s error", devEUI)
+				}
+				if err == nil {
+					r.Keys = &Keys{
+						NwkKey:    resp.DeviceKeys.NwkKey,
+						AppKey:    resp.DeviceKeys.AppKey,
+						GenAppKey: resp.DeviceKeys.GenAppKey,
+					}
+				}
+			}
+
+			if err := f(r); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Import creates the given device and its root keys (if set).
+func (b *APIBackend) Import(ctx context.Context, r Record) error {
+	req := struct {
+		Device apiDevice `json:"device"`
+	}{
+		Device: apiDevice{
+			DevEUI:            r.DevEUI,
+			Name:              r.Name,
+			ApplicationID:     r.ApplicationID,
+			Description:       r.Description,
+			DeviceProfileID:   r.DeviceProfileID,
+			SkipFCntCheck:     r.SkipFCntCheck,
+			ReferenceAltitude: r.ReferenceAltitude,
+			Variables:         r.Variables,
+			Tags:              r.Tags,
+			IsDisabled:        r.IsDisabled,
+		},
+	}
+
+	if err := b.do(ctx, http.MethodPost, "/api/devices", req, nil); err != nil {
+		return err
+	}
+
+	if r.Keys == nil {
+		return nil
+	}
+
+	keysReq := struct {
+		DeviceKeys apiDeviceKeys `json:"deviceKeys"`
+	}{
+		DeviceKeys: apiDeviceKeys{
+			DevEUI:    r.DevEUI,
+			NwkKey:    r.Keys.NwkKey,
+			AppKey:    r.Keys.AppKey,
+			GenAppKey: r.Keys.GenAppKey,
+		},
+	}
+
+	if err := b.do(ctx, http.MethodPost, "/api/devices/"+r.DevEUI+"/keys", keysReq, nil); err != nil {
+		return errors.Wrap(err, "create device-keys error")
+	}
+
+	return nil
+}
+
+// list calls f for each page of the given list endpoint.
+func (b *APIBackend) list(ctx context.Context, path string, params url.Values, f func(apiListResponse)) error {
+	for offset := 0; ; offset += apiPageSize {
+		params.Set("limit", fmt.Sprintf("%d", apiPageSize))
+		params.Set("offset", fmt.Sprintf("%d", offset))
+
+		var resp apiListResponse
+		if err := b.do(ctx, http.MethodGet, path+"?"+params.Encode(), nil, &resp); err != nil {
+			return err
+		}
+
+		f(resp)
+
+		if len(resp.Result) < apiPageSize {
+			return nil
+		}
+	}
+}
+
+// do performs the given API request. The request (when not nil) and
+// response (when not nil) are JSON encoded. A 404 and 409 status are
+// returned as storage.ErrDoesNotExist and storage.ErrAlreadyExists.
+func (b *APIBackend) do(ctx context.Context, method, path string, req, resp interface{}) error {
+	var body io.Reader
+	if req != nil {
+		bb, err := json.Marshal(req)
+		if err != nil {
+			return errors.Wrap(err, "marshal request error")
+		}
+		body = bytes.NewReader(bb)
+	}
+
+	r, err := http.NewRequest(method, b.server+path, body)
+	if err != nil {
+		return errors.Wrap(err, "new request error")
+	}
+	r = r.WithContext(ctx)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Grpc-Metadata-Authorization", "Bearer "+b.token)
+
+	res, err := b.client.Do(r)
+	if err != nil {
+		return errors.Wrap(err, "http request error")
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return storage.ErrDoesNotExist
+	case http.StatusConflict:
+		return storage.ErrAlreadyExists
+	default:
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(res.Body).Decode(&e)
+		return fmt.Errorf("api returned status %d: %s", res.StatusCode, e.Error)
+	}
+
+	if resp == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
+		return errors.Wrap(err, "decode response error")
+	}
+
+	return nil
+}
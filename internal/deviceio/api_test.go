@@ -0,0 +1,104 @@
+package deviceio
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+func TestAPIBackend(t *testing.T) {
+	assert := require.New(t)
+
+	var created []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Grpc-Metadata-Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api/devices":
+			assert.Equal("1", r.URL.Query().Get("applicationID"))
+			w.Write([]byte(`{"totalCount": "1", "result": [{"devEUI": "0102030405060708"}]}`))
+		case "GET /api/devices/0102030405060708":
+			w.Write([]byte(`{"device": {"devEUI": "0102030405060708", "name": "device-1", "applicationID": "1", "deviceProfileID": "e4ad5b4d-3fd6-4d57-a29e-2e1b4b4c0d3a", "skipFCntCheck": true, "variables": {}, "tags": {"floor": "1"}}}`))
+		case "GET /api/devices/0102030405060708/keys":
+			w.Write([]byte(`{"deviceKeys": {"nwkKey": "01020304050607080102030405060708", "appKey": "00000000000000000000000000000000", "genAppKey": "00000000000000000000000000000000"}}`))
+		case "POST /api/devices":
+			var req struct {
+				Device apiDevice `json:"device"`
+			}
+			assert.NoError(json.NewDecoder(r.Body).Decode(&req))
+			if req.Device.DevEUI == "0807060504030201" {
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte(`{"error": "object already exists"}`))
+				return
+			}
+			created = append(created, req.Device.DevEUI)
+			w.Write([]byte(`{}`))
+		case "POST /api/devices/0102030405060708/keys":
+			created = append(created, "keys")
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	b := NewAPIBackend(server.URL+"/", "token")
+
+	t.Run("Export", func(t *testing.T) {
+		assert := require.New(t)
+
+		var records []Record
+		assert.NoError(b.Export(context.Background(), Filters{ApplicationID: 1}, true, func(r Record) error {
+			records = append(records, r)
+			return nil
+		}))
+
+		assert.Equal([]Record{
+			{
+				DevEUI:          "0102030405060708",
+				ApplicationID:   1,
+				DeviceProfileID: "e4ad5b4d-3fd6-4d57-a29e-2e1b4b4c0d3a",
+				Name:            "device-1",
+				SkipFCntCheck:   true,
+				Variables:       map[string]string{},
+				Tags:            map[string]string{"floor": "1"},
+				Keys: &Keys{
+					NwkKey:    "01020304050607080102030405060708",
+					AppKey:    "00000000000000000000000000000000",
+					GenAppKey: "00000000000000000000000000000000",
+				},
+			},
+		}, records)
+	})
+
+	t.Run("Export without filter", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Error(b.Export(context.Background(), Filters{}, false, func(r Record) error { return nil }))
+	})
+
+	t.Run("Import", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(b.Import(context.Background(), Record{
+			DevEUI:          "0102030405060708",
+			ApplicationID:   1,
+			DeviceProfileID: "e4ad5b4d-3fd6-4d57-a29e-2e1b4b4c0d3a",
+			Keys: &Keys{
+				NwkKey: "01020304050607080102030405060708",
+			},
+		}))
+		assert.Equal([]string{"0102030405060708", "keys"}, created)
+
+		err := b.Import(context.Background(), Record{DevEUI: "0807060504030201"})
+		assert.Equal(storage.ErrAlreadyExists, err)
+	})
+}
@@ -0,0 +1,223 @@
+package deviceio
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq/hstore"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+// databaseExportPageSize defines the number of devices retrieved per query
+// on export.
+const databaseExportPageSize = 100
+
+// DatabaseBackend implements the Backend interface using the storage
+// package. The storage, network-server and (when the keys are encrypted)
+// kms packages must be setup.
+type DatabaseBackend struct{}
+
+// NewDatabaseBackend creates a new DatabaseBackend.
+func NewDatabaseBackend() *DatabaseBackend {
+	return &DatabaseBackend{}
+}
+
+// Export calls f for each device matching the given filters.
+func (b *DatabaseBackend) Export(ctx context.Context, filters Filters, keys bool, f func(Record) error) error {
+	for offset := 0; ; offset += databaseExportPageSize {
+		devices, err := storage.GetDevices(ctx, storage.DB(), storage.DeviceFilters{
+			OrganizationID: filters.OrganizationID,
+			ApplicationID:  filters.ApplicationID,
+			Limit:          databaseExportPageSize,
+			Offset:         offset,
+		})
+		if err != nil {
+			return errors.Wrap(err, "get devices error")
+		}
+
+		for _, item := range devices {
+			// the device is retrieved again to include the network-server
+			// device data
+			d, err := storage.GetDevice(ctx, storage.DB(), item.DevEUI, false, false)
+			if err != nil {
+				return errors.Wrapf(err, "get device %s error", item.DevEUI)
+			}
+
+			r := Record{
+				DevEUI:            d.DevEUI.String(),
+				ApplicationID:     d.ApplicationID,
+				DeviceProfileID:   d.DeviceProfileID.String(),
+				Name:              d.Name,
+				Description:       d.Description,
+				SkipFCntCheck:     d.SkipFCntCheck,
+				ReferenceAltitude: d.ReferenceAltitude,
+				IsDisabled:        d.IsDisabled,
+				Variables:         hstoreToMap(d.Variables),
+				Tags:              hstoreToMap(d.Tags),
+			}
+
+			if keys {
+				r.Keys, err = b.getKeys(ctx, d.DevEUI)
+				if err != nil {
+					return errors.Wrapf(err, "get device-keys %s error", d.DevEUI)
+				}
+			}
+
+			if err := f(r); err != nil {
+				return err
+			}
+		}
+
+		if len(devices) < databaseExportPageSize {
+			return nil
+		}
+	}
+}
+
+// Import creates the given device and its root keys (if set).
+func (b *DatabaseBackend) Import(ctx context.Context, r Record) error {
+	var d storage.Device
+	if err := d.DevEUI.UnmarshalText([]byte(r.DevEUI)); err != nil {
+		return errors.Wrap(err, "decode dev_eui error")
+	}
+
+	dpID, err := uuid.FromString(r.DeviceProfileID)
+	if err != nil {
+		return errors.Wrap(err, "decode device_profile_id error")
+	}
+
+	var dk *storage.DeviceKeys
+	if r.Keys != nil {
+		dk, err = decodeKeys(d.DevEUI, *r.Keys)
+		if err != nil {
+			return err
+		}
+	}
+
+	d.ApplicationID = r.ApplicationID
+	d.DeviceProfileID = dpID
+	d.Name = r.Name
+	d.Description = r.Description
+	d.SkipFCntCheck = r.SkipFCntCheck
+	d.ReferenceAltitude = r.ReferenceAltitude
+	d.IsDisabled = r.IsDisabled
+	d.Variables = mapToHstore(r.Variables)
+	d.Tags = mapToHstore(r.Tags)
+
+	if d.Name == "" {
+		d.Name = d.DevEUI.String()
+	}
+
+	app, err := storage.GetApplication(ctx, storage.DB(), d.ApplicationID)
+	if err != nil {
+		return errors.Wrap(err, "get application error")
+	}
+	dp, err := storage.GetDeviceProfile(ctx, storage.DB(), dpID, false, true)
+	if err != nil {
+		return errors.Wrap(err, "get device-profile error")
+	}
+	if app.OrganizationID != dp.OrganizationID {
+		return errors.New("device-profile and application must be under the same organization")
+	}
+
+	return storage.Transaction(func(tx sqlx.Ext) error {
+		org, err := storage.GetOrganization(ctx, tx, app.OrganizationID, true)
+		if err != nil {
+			return errors.Wrap(err, "get organization error")
+		}
+
+		if org.MaxDeviceCount != 0 {
+			count, err := storage.GetDeviceCount(ctx, tx, storage.DeviceFilters{OrganizationID: app.OrganizationID})
+			if err != nil {
+				return errors.Wrap(err, "get device count error")
+			}
+
+			if count >= org.MaxDeviceCount {
+				return storage.ErrOrganizationMaxDeviceCount
+			}
+		}
+
+		if err := storage.CreateDevice(ctx, tx, &d); err != nil {
+			return err
+		}
+
+		if dk != nil {
+			if err := storage.CreateDeviceKeys(ctx, tx, dk); err != nil {
+				return errors.Wrap(err, "create device-keys error")
+			}
+		}
+
+		return nil
+	})
+}
+
+func (b *DatabaseBackend) getKeys(ctx context.Context, devEUI lorawan.EUI64) (*Keys, error) {
+	dk, err := storage.GetDeviceKeys(ctx, storage.DB(), devEUI)
+	if err != nil {
+		if errors.Cause(err) == storage.ErrDoesNotExist {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// keys stored in the HSM can not be exported
+	if dk.HSM {
+		log.WithField("dev_eui", devEUI).Warning("deviceio: device-keys are stored in hsm, skipping keys")
+		return nil, nil
+	}
+
+	return &Keys{
+		NwkKey:    dk.NwkKey.String(),
+		AppKey:    dk.AppKey.String(),
+		GenAppKey: dk.GenAppKey.String(),
+	}, nil
+}
+
+// decodeKeys decodes the given keys. Only the NwkKey is required.
+func decodeKeys(devEUI lorawan.EUI64, k Keys) (*storage.DeviceKeys, error) {
+	dk := storage.DeviceKeys{
+		DevEUI: devEUI,
+	}
+
+	if err := dk.NwkKey.UnmarshalText([]byte(k.NwkKey)); err != nil {
+		return nil, errors.Wrap(err, "decode nwk_key error")
+	}
+	if k.AppKey != "" {
+		if err := dk.AppKey.UnmarshalText([]byte(k.AppKey)); err != nil {
+			return nil, errors.Wrap(err, "decode app_key error")
+		}
+	}
+	if k.GenAppKey != "" {
+		if err := dk.GenAppKey.UnmarshalText([]byte(k.GenAppKey)); err != nil {
+			return nil, errors.Wrap(err, "decode gen_app_key error")
+		}
+	}
+
+	return &dk, nil
+}
+
+func hstoreToMap(h hstore.Hstore) map[string]string {
+	out := make(map[string]string)
+	for k, v := range h.Map {
+		if v.Valid {
+			out[k] = v.String
+		}
+	}
+	return out
+}
+
+func mapToHstore(m map[string]string) hstore.Hstore {
+	h := hstore.Hstore{
+		Map: make(map[string]sql.NullString),
+	}
+	for k, v := range m {
+		h.Map[k] = sql.NullString{String: v, Valid: true}
+	}
+	return h
+}
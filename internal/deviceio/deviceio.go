@@ -0,0 +1,344 @@
+// Package deviceio implements the export and import of devices (and their
+// root keys) as CSV or JSON, either directly against the database or using
+// the external API.
+package deviceio
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Format defines the file format.
+type Format string
+
+// Supported formats.
+const (
+	CSV  Format = "csv"
+	JSON Format = "json"
+)
+
+// csvHeader defines the CSV columns. Variables and tags are encoded as JSON
+// object. The key columns are empty when the keys are not exported.
+var csvHeader = []string{
+	"dev_eui",
+	"application_id",
+	"device_profile_id",
+	"name",
+	"description",
+	"skip_fcnt_check",
+	"reference_altitude",
+	"is_disabled",
+	"variables",
+	"tags",
+	"nwk_key",
+	"app_key",
+	"gen_app_key",
+}
+
+// Record defines an exported device. The DevEUI and keys are HEX encoded.
+type Record struct {
+	DevEUI            string            `json:"devEUI"`
+	ApplicationID     int64             `json:"applicationID"`
+	DeviceProfileID   string            `json:"deviceProfileID"`
+	Name              string            `json:"name"`
+	Description       string            `json:"description"`
+	SkipFCntCheck     bool              `json:"skipFCntCheck"`
+	ReferenceAltitude float64           `json:"referenceAltitude"`
+	IsDisabled        bool              `json:"isDisabled"`
+	Variables         map[string]string `json:"variables,omitempty"`
+	Tags              map[string]string `json:"tags,omitempty"`
+	Keys              *Keys             `json:"keys,omitempty"`
+}
+
+// Keys defines the root keys of a device.
+type Keys struct {
+	NwkKey    string `json:"nwkKey"`
+	AppKey    string `json:"appKey"`
+	GenAppKey string `json:"genAppKey"`
+}
+
+// Filters defines the filters for exporting devices. Empty values are not
+// used as filter.
+type Filters struct {
+	OrganizationID int64
+	ApplicationID  int64
+}
+
+// Backend defines the interface of the device source and destination.
+type Backend interface {
+	// Export calls f for each device matching the given filters. When keys
+	// is set, the root keys of the device are included (if set).
+	Export(ctx context.Context, filters Filters, keys bool, f func(Record) error) error
+
+	// Import creates the given device and its root keys (if set). It
+	// returns storage.ErrAlreadyExists when the device already exists.
+	Import(ctx context.Context, r Record) error
+}
+
+// ParseFormat parses the given format.
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case CSV, JSON:
+		return Format(s), nil
+	default:
+		return "", fmt.Errorf("unknown format: %s (expected csv or json)", s)
+	}
+}
+
+// Writer writes records in the configured format.
+type Writer struct {
+	format Format
+	w      io.Writer
+	csv    *csv.Writer
+	n      int
+}
+
+// NewWriter creates a new Writer. Close must be called after the last
+// record has been written.
+func NewWriter(w io.Writer, f Format) *Writer {
+	return &Writer{
+		format: f,
+		w:      w,
+		csv:    csv.NewWriter(w),
+	}
+}
+
+// Write writes the given record.
+func (w *Writer) Write(r Record) error {
+	defer func() { w.n++ }()
+
+	if w.format == JSON {
+		b, err := json.MarshalIndent(r, "  ", "  ")
+		if err != nil {
+			return errors.Wrap(err, "marshal json error")
+		}
+
+		sep := ",\n  "
+		if w.n == 0 {
+			sep = "[\n  "
+		}
+
+		if _, err := io.WriteString(w.w, sep); err != nil {
+			return err
+		}
+		_, err = w.w.Write(b)
+		return err
+	}
+
+	if w.n == 0 {
+		if err := w.csv.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+
+	variables, err := marshalMap(r.Variables)
+	if err != nil {
+		return errors.Wrap(err, "marshal variables error")
+	}
+	tags, err := marshalMap(r.Tags)
+	if err != nil {
+		return errors.Wrap(err, "marshal tags error")
+	}
+
+	var keys Keys
+	if r.Keys != nil {
+		keys = *r.Keys
+	}
+
+	return w.csv.Write([]string{
+		r.DevEUI,
+		strconv.FormatInt(r.ApplicationID, 10),
+		r.DeviceProfileID,
+		r.Name,
+		r.Description,
+		strconv.FormatBool(r.SkipFCntCheck),
+		strconv.FormatFloat(r.ReferenceAltitude, 'f', -1, 64),
+		strconv.FormatBool(r.IsDisabled),
+		variables,
+		tags,
+		keys.NwkKey,
+		keys.AppKey,
+		keys.GenAppKey,
+	})
+}
+
+// Close completes the output, it does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.format == JSON {
+		s := "\n]\n"
+		if w.n == 0 {
+			s = "[]\n"
+		}
+		_, err := io.WriteString(w.w, s)
+		return err
+	}
+
+	if w.n == 0 {
+		w.csv.Write(csvHeader)
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+// Reader reads records in the configured format.
+type Reader struct {
+	format  Format
+	json    *json.Decoder
+	csv     *csv.Reader
+	started bool
+
+	// columns maps the CSV column name to its index.
+	columns map[string]int
+}
+
+// NewReader creates a new Reader.
+func NewReader(r io.Reader, f Format) *Reader {
+	return &Reader{
+		format: f,
+		json:   json.NewDecoder(r),
+		csv:    csv.NewReader(r),
+	}
+}
+
+// Read returns the next record. It returns io.EOF when all records have
+// been read.
+func (r *Reader) Read() (Record, error) {
+	if r.format == JSON {
+		return r.readJSON()
+	}
+	return r.readCSV()
+}
+
+func (r *Reader) readJSON() (Record, error) {
+	var rec Record
+
+	// consume the opening bracket of the array
+	if !r.started {
+		t, err := r.json.Token()
+		if err != nil {
+			return rec, errors.Wrap(err, "read json error")
+		}
+		if d, ok := t.(json.Delim); !ok || d != '[' {
+			return rec, errors.New("json must contain an array of devices")
+		}
+		r.started = true
+	}
+
+	if !r.json.More() {
+		return rec, io.EOF
+	}
+
+	if err := r.json.Decode(&rec); err != nil {
+		return rec, errors.Wrap(err, "decode json error")
+	}
+
+	return rec, nil
+}
+
+func (r *Reader) readCSV() (Record, error) {
+	var rec Record
+
+	if r.columns == nil {
+		header, err := r.csv.Read()
+		if err != nil {
+			if err == io.EOF {
+				return rec, err
+			}
+			return rec, errors.Wrap(err, "read csv header error")
+		}
+
+		r.columns = make(map[string]int)
+		for i, c := range header {
+			r.columns[c] = i
+		}
+
+		for _, c := range []string{"dev_eui", "application_id", "device_profile_id"} {
+			if _, ok := r.columns[c]; !ok {
+				return rec, fmt.Errorf("csv column %s is missing", c)
+			}
+		}
+	}
+
+	row, err := r.csv.Read()
+	if err != nil {
+		if err == io.EOF {
+			return rec, err
+		}
+		return rec, errors.Wrap(err, "read csv error")
+	}
+
+	col := func(name string) string {
+		i, ok := r.columns[name]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return row[i]
+	}
+
+	rec.DevEUI = col("dev_eui")
+	rec.DeviceProfileID = col("device_profile_id")
+	rec.Name = col("name")
+	rec.Description = col("description")
+
+	if rec.ApplicationID, err = strconv.ParseInt(col("application_id"), 10, 64); err != nil {
+		return rec, errors.Wrap(err, "parse application_id error")
+	}
+	if rec.SkipFCntCheck, err = parseBool(col("skip_fcnt_check")); err != nil {
+		return rec, errors.Wrap(err, "parse skip_fcnt_check error")
+	}
+	if rec.IsDisabled, err = parseBool(col("is_disabled")); err != nil {
+		return rec, errors.Wrap(err, "parse is_disabled error")
+	}
+	if s := col("reference_altitude"); s != "" {
+		if rec.ReferenceAltitude, err = strconv.ParseFloat(s, 64); err != nil {
+			return rec, errors.Wrap(err, "parse reference_altitude error")
+		}
+	}
+	if rec.Variables, err = unmarshalMap(col("variables")); err != nil {
+		return rec, errors.Wrap(err, "parse variables error")
+	}
+	if rec.Tags, err = unmarshalMap(col("tags")); err != nil {
+		return rec, errors.Wrap(err, "parse tags error")
+	}
+
+	keys := Keys{
+		NwkKey:    col("nwk_key"),
+		AppKey:    col("app_key"),
+		GenAppKey: col("gen_app_key"),
+	}
+	if keys != (Keys{}) {
+		rec.Keys = &keys
+	}
+
+	return rec, nil
+}
+
+func parseBool(s string) (bool, error) {
+	if s == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(s)
+}
+
+func marshalMap(m map[string]string) (string, error) {
+	if len(m) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(m)
+	return string(b), err
+}
+
+func unmarshalMap(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var m map[string]string
+	err := json.Unmarshal([]byte(s), &m)
+	return m, err
+}
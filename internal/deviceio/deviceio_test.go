@@ -0,0 +1,95 @@
+package deviceio
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterReader(t *testing.T) {
+	records := []Record{
+		{
+			DevEUI:            "0102030405060708",
+			ApplicationID:     1,
+			DeviceProfileID:   "e4ad5b4d-3fd6-4d57-a29e-2e1b4b4c0d3a",
+			Name:              "device-1",
+			Description:       "device, with \"quotes\"",
+			SkipFCntCheck:     true,
+			ReferenceAltitude: 10.5,
+			Variables:         map[string]string{"token": "secret"},
+			Tags:              map[string]string{"floor": "1"},
+			Keys: &Keys{
+				NwkKey:    "01020304050607080102030405060708",
+				AppKey:    "00000000000000000000000000000000",
+				GenAppKey: "00000000000000000000000000000000",
+			},
+		},
+		{
+			DevEUI:          "0807060504030201",
+			ApplicationID:   2,
+			DeviceProfileID: "e4ad5b4d-3fd6-4d57-a29e-2e1b4b4c0d3a",
+			Name:            "device-2",
+			IsDisabled:      true,
+		},
+	}
+
+	for _, f := range []Format{CSV, JSON} {
+		t.Run(string(f), func(t *testing.T) {
+			assert := require.New(t)
+
+			var buf bytes.Buffer
+			w := NewWriter(&buf, f)
+			for _, r := range records {
+				assert.NoError(w.Write(r))
+			}
+			assert.NoError(w.Close())
+
+			var out []Record
+			r := NewReader(&buf, f)
+			for {
+				rec, err := r.Read()
+				if err == io.EOF {
+					break
+				}
+				assert.NoError(err)
+				out = append(out, rec)
+			}
+
+			assert.Equal(records, out)
+		})
+
+		t.Run(string(f)+" empty", func(t *testing.T) {
+			assert := require.New(t)
+
+			var buf bytes.Buffer
+			assert.NoError(NewWriter(&buf, f).Close())
+
+			_, err := NewReader(&buf, f).Read()
+			assert.Equal(io.EOF, err)
+		})
+	}
+
+	t.Run("CSV column order", func(t *testing.T) {
+		assert := require.New(t)
+
+		r := NewReader(strings.NewReader("name,device_profile_id,application_id,dev_eui\ndevice-1,e4ad5b4d-3fd6-4d57-a29e-2e1b4b4c0d3a,3,0102030405060708\n"), CSV)
+		rec, err := r.Read()
+		assert.NoError(err)
+		assert.Equal(Record{
+			DevEUI:          "0102030405060708",
+			ApplicationID:   3,
+			DeviceProfileID: "e4ad5b4d-3fd6-4d57-a29e-2e1b4b4c0d3a",
+			Name:            "device-1",
+		}, rec)
+	})
+
+	t.Run("CSV missing column", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := NewReader(strings.NewReader("dev_eui,name\n0102030405060708,device-1\n"), CSV).Read()
+		assert.EqualError(err, "csv column application_id is missing")
+	})
+}
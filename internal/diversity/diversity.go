@@ -0,0 +1,110 @@
+// Package diversity implements the gateway-diversity statistics of a device,
+// based on the RX metadata of the received uplink frames: how many gateways
+// received each frame and for each gateway how many frames it received as
+// the only (sole) receiver. This quantifies the gateway redundancy, e.g.
+// before decommissioning a gateway.
+package diversity
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// DeviceStats defines the gateway-diversity statistics of a device.
+// Distribution contains the number of frames per number of receiving
+// gateways.
+type DeviceStats struct {
+	Frames       int            `json:"frames"`
+	AvgGateways  float64        `json:"avgGateways"`
+	MinGateways  int            `json:"minGateways"`
+	MaxGateways  int            `json:"maxGateways"`
+	Distribution map[int]int    `json:"distribution"`
+	Gateways     []GatewayStats `json:"gateways"`
+}
+
+// GatewayStats defines the number of frames of the device received by the
+// gateway and the number of frames for which it was the sole receiver.
+type GatewayStats struct {
+	GatewayID          string `json:"gatewayID"`
+	Frames             int    `json:"frames"`
+	SoleReceiverFrames int    `json:"soleReceiverFrames"`
+}
+
+// Device returns the gateway-diversity statistics for the given frames.
+// Frames without RX metadata are ignored. The gateways are sorted by the
+// number of received frames (descending).
+func Device(frames []storage.DeviceFrameLog) (DeviceStats, error) {
+	s := DeviceStats{
+		Distribution: make(map[int]int),
+		Gateways:     []GatewayStats{},
+	}
+
+	gateways := make(map[string]*GatewayStats)
+	var total int
+
+	for _, f := range frames {
+		if len(f.RXInfo) == 0 {
+			continue
+		}
+
+		var rxInfo []storage.DeviceFrameLogRXInfo
+		if err := json.Unmarshal(f.RXInfo, &rxInfo); err != nil {
+			return DeviceStats{}, errors.Wrap(err, "unmarshal rx-info error")
+		}
+
+		// a gateway can report the same frame more than once (e.g. multiple
+		// antennas)
+		ids := make(map[string]struct{})
+		for _, rx := range rxInfo {
+			ids[rx.GatewayID.String()] = struct{}{}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		n := len(ids)
+		if s.Frames == 0 || n < s.MinGateways {
+			s.MinGateways = n
+		}
+		if n > s.MaxGateways {
+			s.MaxGateways = n
+		}
+		s.Frames++
+		s.Distribution[n]++
+		total += n
+
+		for id := range ids {
+			gw, ok := gateways[id]
+			if !ok {
+				gw = &GatewayStats{GatewayID: id}
+				gateways[id] = gw
+			}
+
+			gw.Frames++
+			if n == 1 {
+				gw.SoleReceiverFrames++
+			}
+		}
+	}
+
+	if s.Frames != 0 {
+		s.AvgGateways = math.Round(float64(total)/float64(s.Frames)*100) / 100
+	}
+
+	for _, gw := range gateways {
+		s.Gateways = append(s.Gateways, *gw)
+	}
+	sort.Slice(s.Gateways, func(i, j int) bool {
+		if s.Gateways[i].Frames != s.Gateways[j].Frames {
+			return s.Gateways[i].Frames > s.Gateways[j].Frames
+		}
+		return s.Gateways[i].GatewayID < s.Gateways[j].GatewayID
+	})
+
+	return s, nil
+}
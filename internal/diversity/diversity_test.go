@@ -0,0 +1,73 @@
+package diversity
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+func TestDevice(t *testing.T) {
+	gw1 := lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 1}
+	gw2 := lorawan.EUI64{2, 2, 2, 2, 2, 2, 2, 2}
+	gw3 := lorawan.EUI64{3, 3, 3, 3, 3, 3, 3, 3}
+
+	frame := func(t *testing.T, gateways ...lorawan.EUI64) storage.DeviceFrameLog {
+		var rxInfo []storage.DeviceFrameLogRXInfo
+		for _, id := range gateways {
+			rxInfo = append(rxInfo, storage.DeviceFrameLogRXInfo{GatewayID: id})
+		}
+
+		b, err := json.Marshal(rxInfo)
+		require.NoError(t, err)
+
+		return storage.DeviceFrameLog{RXInfo: b}
+	}
+
+	t.Run("No frames", func(t *testing.T) {
+		assert := require.New(t)
+
+		s, err := Device(nil)
+		assert.NoError(err)
+		assert.Equal(DeviceStats{
+			Distribution: map[int]int{},
+			Gateways:     []GatewayStats{},
+		}, s)
+	})
+
+	t.Run("Frames", func(t *testing.T) {
+		assert := require.New(t)
+
+		s, err := Device([]storage.DeviceFrameLog{
+			frame(t, gw1, gw2),
+			frame(t, gw1),
+			frame(t, gw1, gw1),
+			frame(t, gw3, gw2, gw1),
+			frame(t),
+			{},
+		})
+		assert.NoError(err)
+		assert.Equal(DeviceStats{
+			Frames:       4,
+			AvgGateways:  1.75,
+			MinGateways:  1,
+			MaxGateways:  3,
+			Distribution: map[int]int{1: 2, 2: 1, 3: 1},
+			Gateways: []GatewayStats{
+				{GatewayID: gw1.String(), Frames: 4, SoleReceiverFrames: 2},
+				{GatewayID: gw2.String(), Frames: 2},
+				{GatewayID: gw3.String(), Frames: 1},
+			},
+		}, s)
+	})
+
+	t.Run("Invalid rx-info", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := Device([]storage.DeviceFrameLog{{RXInfo: []byte("{")}})
+		assert.Error(err)
+	})
+}
@@ -0,0 +1,96 @@
+package downlink
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	pb //"github.com/ibrahimozekici/lora-api/go/v3/as/integration"
+	"github.com/ibrahimozekici/app-server2/internal/integration"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+// Integration event name and type of the Class-B beacon-lock status events.
+const (
+	classBIntegrationName = "class_b"
+	beaconLockEventType   = "beacon_lock"
+)
+
+// beaconLockEvent defines the object of the beacon-lock status event.
+type beaconLockEvent struct {
+	BeaconLocked bool      `json:"beaconLocked"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// HandleBeaconLockStatus stores the reported Class-B beacon-lock status of the
+// device. When the status has changed, a beacon-lock status event is sent to
+// the integrations, as Class-B downlinks are not delivered until the device
+// has (re)acquired the beacon.
+func HandleBeaconLockStatus(ctx context.Context, devEUI lorawan.EUI64, locked bool) error {
+	s := storage.DeviceClassBStatus{
+		DevEUI:       devEUI,
+		BeaconLocked: locked,
+	}
+
+	changed, err := storage.SetDeviceClassBStatus(ctx, storage.DB(), &s)
+	if err != nil {
+		return errors.Wrap(err, "set device class-b status error")
+	}
+
+	if !changed {
+		return nil
+	}
+
+	d, err := storage.GetDevice(ctx, storage.DB(), devEUI, false, true)
+	if err != nil {
+		return errors.Wrap(err, "get device error")
+	}
+
+	app, err := storage.GetApplication(ctx, storage.DB(), d.ApplicationID)
+	if err != nil {
+		return errors.Wrap(err, "get application error")
+	}
+
+	b, err := json.Marshal(beaconLockEvent{
+		BeaconLocked: s.BeaconLocked,
+		UpdatedAt:    s.UpdatedAt,
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshal json error")
+	}
+
+	pl := pb.IntegrationEvent{
+		ApplicationId:   uint64(app.ID),
+		ApplicationName: app.Name,
+		DeviceName:      d.Name,
+		DevEui:          d.DevEUI[:],
+		Tags:            make(map[string]string),
+		IntegrationName: classBIntegrationName,
+		EventType:       beaconLockEventType,
+		ObjectJson:      string(b),
+	}
+
+	for k, v := range d.Tags.Map {
+		if v.Valid {
+			pl.Tags[k] = v.String
+		}
+	}
+
+	vars := make(map[string]string)
+	for k, v := range d.Variables.Map {
+		if v.Valid {
+			vars[k] = v.String
+		}
+	}
+
+	if err := integration.ForApplicationID(app.ID).HandleIntegrationEvent(ctx, vars, pl); err != nil {
+		log.WithError(err).WithField("ctx_id", ctx.Value(logging.ContextIDKey)).Error("send beacon-lock status event to integration error")
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+// Package classb implements the Class-B ping-slot timing calculations, used to
+// estimate when a queued Class-B downlink will be sent to the device.
+package classb
+
+import (
+	"time"
+)
+
+// Class-B timing parameters as defined by the LoRaWAN specification.
+const (
+	BeaconPeriod   = 128 * time.Second
+	BeaconReserved = 2120 * time.Millisecond
+	BeaconGuard    = 3 * time.Second
+	PingSlotLen    = 30 * time.Millisecond
+
+	// pingSlotCount is the number of ping-slots within a beacon period.
+	pingSlotCount = 4096
+)
+
+// PingSlotInterval returns the interval between two ping-slots of the device
+// for the given ping-slot period (in number of ping-slots, as configured in
+// the device-profile).
+func PingSlotInterval(pingSlotPeriod uint32) time.Duration {
+	return time.Duration(pingSlotPeriod) * PingSlotLen
+}
+
+// PingNb returns the number of ping-slots opened by the device within one
+// beacon period for the given ping-slot period. It returns 0 when the
+// ping-slot period is not set.
+func PingNb(pingSlotPeriod uint32) uint32 {
+	if pingSlotPeriod == 0 {
+		return 0
+	}
+	return pingSlotCount / pingSlotPeriod
+}
+
+// Estimate defines the estimated latency before a queued downlink is sent.
+type Estimate struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+// EstimateLatency returns the estimated latency for the downlink at the given
+// (zero-based) position in the device-queue. As only one downlink can be sent
+// per ping-slot, every item in front of the downlink delays it by one
+// ping-slot interval. The maximum includes the beacon-reserved and
+// beacon-guard time, during which no ping-slots are opened, for every beacon
+// boundary that could be crossed.
+func EstimateLatency(pingSlotPeriod uint32, position int) Estimate {
+	interval := PingSlotInterval(pingSlotPeriod)
+	if interval == 0 || position < 0 {
+		return Estimate{}
+	}
+
+	min := time.Duration(position) * interval
+	max := time.Duration(position+1) * interval
+
+	beacons := max/BeaconPeriod + 1
+	max += beacons * (BeaconReserved + BeaconGuard)
+
+	return Estimate{
+		Min: min,
+		Max: max,
+	}
+}
@@ -0,0 +1,61 @@
+package classb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPingNb(t *testing.T) {
+	assert := require.New(t)
+
+	assert.EqualValues(0, PingNb(0))
+	assert.EqualValues(128, PingNb(32))
+	assert.EqualValues(1, PingNb(4096))
+}
+
+func TestEstimateLatency(t *testing.T) {
+	tests := []struct {
+		Name           string
+		PingSlotPeriod uint32
+		Position       int
+		Expected       Estimate
+	}{
+		{
+			Name: "ping-slot period not set",
+		},
+		{
+			Name:           "first item, every second",
+			PingSlotPeriod: 32,
+			Expected: Estimate{
+				Max: 960*time.Millisecond + BeaconReserved + BeaconGuard,
+			},
+		},
+		{
+			Name:           "third item, every second",
+			PingSlotPeriod: 32,
+			Position:       2,
+			Expected: Estimate{
+				Min: 1920 * time.Millisecond,
+				Max: 2880*time.Millisecond + BeaconReserved + BeaconGuard,
+			},
+		},
+		{
+			Name:           "second item, every 128 seconds",
+			PingSlotPeriod: 4096,
+			Position:       1,
+			Expected: Estimate{
+				Min: 122880 * time.Millisecond,
+				Max: 245760*time.Millisecond + 2*(BeaconReserved+BeaconGuard),
+			},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(tst.Expected, EstimateLatency(tst.PingSlotPeriod, tst.Position))
+		})
+	}
+}
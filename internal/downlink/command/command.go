@@ -0,0 +1,291 @@
+// Package command implements the command catalog of a device-profile. A
+// command defines a named downlink with typed parameters. On sending a
+// command, the parameters are validated and mapped into the object template
+// of the command, which is then passed to the payload encoder.
+package command
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/ibrahimozekici/app-server2/internal/downlink/template"
+)
+
+// ParameterType defines the type of a command parameter.
+type ParameterType string
+
+// Available parameter types.
+const (
+	Integer ParameterType = "INTEGER"
+	Float   ParameterType = "FLOAT"
+	Boolean ParameterType = "BOOLEAN"
+	String  ParameterType = "STRING"
+	Enum    ParameterType = "ENUM"
+)
+
+const (
+	// maxCommands defines the max. number of commands of a catalog.
+	maxCommands = 64
+
+	// maxParameters defines the max. number of parameters of a command.
+	maxParameters = 32
+)
+
+var (
+	nameRegexp          = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_-]{0,99}$`)
+	parameterNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]{0,99}$`)
+)
+
+// Parameter defines a typed command parameter. A parameter without Default
+// is required. Min and Max apply to INTEGER and FLOAT parameters, Values
+// defines the allowed values of an ENUM parameter.
+type Parameter struct {
+	Name        string          `json:"name"`
+	Type        ParameterType   `json:"type"`
+	Description string          `json:"description,omitempty"`
+	Default     json.RawMessage `json:"default,omitempty"`
+	Min         *float64        `json:"min,omitempty"`
+	Max         *float64        `json:"max,omitempty"`
+	Values      []string        `json:"values,omitempty"`
+}
+
+// Command defines a named command. Object is the template of the object
+// passed to the payload encoder, in which each parameter is referenced
+// by a {{ name }} placeholder.
+type Command struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	FPort       uint8       `json:"fPort"`
+	Confirmed   bool        `json:"confirmed"`
+	Parameters  []Parameter `json:"parameters"`
+	Object      string      `json:"object"`
+}
+
+// Catalog contains the commands of a device-profile.
+type Catalog []Command
+
+// Validate validates the command catalog.
+func (c Catalog) Validate() error {
+	if len(c) > maxCommands {
+		return fmt.Errorf("max. number of commands is %d", maxCommands)
+	}
+
+	names := make(map[string]struct{})
+	for _, cmd := range c {
+		if _, ok := names[cmd.Name]; ok {
+			return fmt.Errorf("%s: duplicate command name", cmd.Name)
+		}
+		names[cmd.Name] = struct{}{}
+
+		if err := cmd.Validate(); err != nil {
+			return errors.Wrap(err, cmd.Name)
+		}
+	}
+
+	return nil
+}
+
+// Get returns the command with the given name.
+func (c Catalog) Get(name string) (Command, bool) {
+	for _, cmd := range c {
+		if cmd.Name == name {
+			return cmd, true
+		}
+	}
+
+	return Command{}, false
+}
+
+// Value implements the driver.Valuer interface.
+func (c Catalog) Value() (driver.Value, error) {
+	if c == nil {
+		return "[]", nil
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (c *Catalog) Scan(src interface{}) error {
+	var b []byte
+	switch src := src.(type) {
+	case []byte:
+		b = src
+	case string:
+		b = []byte(src)
+	case nil:
+		*c = nil
+		return nil
+	default:
+		return fmt.Errorf("expected []byte, got %T", src)
+	}
+
+	return json.Unmarshal(b, c)
+}
+
+// Validate validates the command.
+func (c Command) Validate() error {
+	if !nameRegexp.MatchString(c.Name) {
+		return fmt.Errorf("invalid name: '%s'", c.Name)
+	}
+
+	if c.FPort == 0 || c.FPort > 223 {
+		return errors.New("fPort must be between 1 and 223")
+	}
+
+	if len(c.Parameters) > maxParameters {
+		return fmt.Errorf("max. number of parameters is %d", maxParameters)
+	}
+
+	if err := template.Validate(c.Object); err != nil {
+		return errors.Wrap(err, "object")
+	}
+
+	placeholders, err := template.Placeholders(c.Object)
+	if err != nil {
+		return errors.Wrap(err, "object")
+	}
+	used := make(map[string]struct{}, len(placeholders))
+	for _, name := range placeholders {
+		used[name] = struct{}{}
+	}
+
+	params := make(map[string]struct{})
+	for _, p := range c.Parameters {
+		if _, ok := params[p.Name]; ok {
+			return fmt.Errorf("parameter %s: duplicate parameter name", p.Name)
+		}
+		params[p.Name] = struct{}{}
+
+		if _, ok := used[p.Name]; !ok {
+			return fmt.Errorf("parameter %s: not used by the object", p.Name)
+		}
+
+		if err := p.Validate(); err != nil {
+			return errors.Wrapf(err, "parameter %s", p.Name)
+		}
+	}
+
+	for _, name := range placeholders {
+		if _, ok := params[name]; !ok {
+			return fmt.Errorf("object: placeholder %s is not a parameter", name)
+		}
+	}
+
+	return nil
+}
+
+// Render validates the given parameter values, sets the default value of
+// each parameter which is not given and returns the object to pass to the
+// payload encoder.
+func (c Command) Render(values map[string]json.RawMessage) ([]byte, error) {
+	params := make(map[string]json.RawMessage, len(c.Parameters))
+
+	for _, p := range c.Parameters {
+		v, ok := values[p.Name]
+		if !ok {
+			if len(p.Default) == 0 {
+				return nil, fmt.Errorf("parameter %s is required", p.Name)
+			}
+			v = p.Default
+		}
+
+		if err := p.check(v); err != nil {
+			return nil, errors.Wrapf(err, "parameter %s", p.Name)
+		}
+		params[p.Name] = v
+	}
+
+	for name := range values {
+		if _, ok := params[name]; !ok {
+			return nil, fmt.Errorf("unknown parameter %s", name)
+		}
+	}
+
+	return template.Render(c.Object, params)
+}
+
+// Validate validates the parameter definition.
+func (p Parameter) Validate() error {
+	if !parameterNameRegexp.MatchString(p.Name) {
+		return fmt.Errorf("invalid name: '%s'", p.Name)
+	}
+
+	switch p.Type {
+	case Integer, Float:
+		if p.Min != nil && p.Max != nil && *p.Min > *p.Max {
+			return errors.New("min must be less than or equal to max")
+		}
+	case Enum:
+		if len(p.Values) == 0 {
+			return errors.New("enum must define at least one value")
+		}
+	case Boolean, String:
+	default:
+		return fmt.Errorf("invalid type: '%s'", p.Type)
+	}
+
+	if len(p.Default) != 0 {
+		if err := p.check(p.Default); err != nil {
+			return errors.Wrap(err, "default")
+		}
+	}
+
+	return nil
+}
+
+// check validates the given value against the parameter definition.
+func (p Parameter) check(raw json.RawMessage) error {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return errors.Wrap(err, "decode value error")
+	}
+
+	switch p.Type {
+	case Integer, Float:
+		f, ok := v.(float64)
+		if !ok {
+			return errors.New("value must be a number")
+		}
+		if p.Type == Integer && f != math.Trunc(f) {
+			return errors.New("value must be an integer")
+		}
+		if p.Min != nil && f < *p.Min {
+			return fmt.Errorf("value must be greater than or equal to %v", *p.Min)
+		}
+		if p.Max != nil && f > *p.Max {
+			return fmt.Errorf("value must be less than or equal to %v", *p.Max)
+		}
+	case Boolean:
+		if _, ok := v.(bool); !ok {
+			return errors.New("value must be a boolean")
+		}
+	case String:
+		if _, ok := v.(string); !ok {
+			return errors.New("value must be a string")
+		}
+	case Enum:
+		s, ok := v.(string)
+		if !ok {
+			return errors.New("value must be a string")
+		}
+		for _, val := range p.Values {
+			if s == val {
+				return nil
+			}
+		}
+		return fmt.Errorf("value must be one of: %s", strings.Join(p.Values, ", "))
+	}
+
+	return nil
+}
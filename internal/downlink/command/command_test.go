@@ -0,0 +1,182 @@
+package command
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func float(f float64) *float64 {
+	return &f
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Catalog       Catalog
+		ExpectedError string
+	}{
+		{
+			Name: "valid",
+			Catalog: Catalog{
+				{
+					Name:  "set-interval",
+					FPort: 10,
+					Parameters: []Parameter{
+						{Name: "interval", Type: Integer, Min: float(60), Max: float(86400), Default: json.RawMessage(`3600`)},
+						{Name: "mode", Type: Enum, Values: []string{"eco", "normal"}},
+					},
+					Object: `{"interval": "{{ interval }}", "mode": "{{ mode }}"}`,
+				},
+				{
+					Name:   "reboot",
+					FPort:  11,
+					Object: `{"reboot": true}`,
+				},
+			},
+		},
+		{
+			Name: "duplicate command",
+			Catalog: Catalog{
+				{Name: "reboot", FPort: 11, Object: `{}`},
+				{Name: "reboot", FPort: 12, Object: `{}`},
+			},
+			ExpectedError: "reboot: duplicate command name",
+		},
+		{
+			Name: "invalid fPort",
+			Catalog: Catalog{
+				{Name: "reboot", Object: `{}`},
+			},
+			ExpectedError: "reboot: fPort must be between 1 and 223",
+		},
+		{
+			Name: "unused parameter",
+			Catalog: Catalog{
+				{Name: "reboot", FPort: 11, Parameters: []Parameter{{Name: "delay", Type: Integer}}, Object: `{}`},
+			},
+			ExpectedError: "reboot: parameter delay: not used by the object",
+		},
+		{
+			Name: "undefined placeholder",
+			Catalog: Catalog{
+				{Name: "reboot", FPort: 11, Object: `{"delay": "{{ delay }}"}`},
+			},
+			ExpectedError: "reboot: object: placeholder delay is not a parameter",
+		},
+		{
+			Name: "invalid type",
+			Catalog: Catalog{
+				{Name: "reboot", FPort: 11, Parameters: []Parameter{{Name: "delay", Type: "DURATION"}}, Object: `{"delay": "{{ delay }}"}`},
+			},
+			ExpectedError: "reboot: parameter delay: invalid type: 'DURATION'",
+		},
+		{
+			Name: "invalid default",
+			Catalog: Catalog{
+				{Name: "reboot", FPort: 11, Parameters: []Parameter{{Name: "delay", Type: Integer, Max: float(10), Default: json.RawMessage(`20`)}}, Object: `{"delay": "{{ delay }}"}`},
+			},
+			ExpectedError: "reboot: parameter delay: default: value must be less than or equal to 10",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			err := tst.Catalog.Validate()
+			if tst.ExpectedError != "" {
+				assert.EqualError(err, tst.ExpectedError)
+				return
+			}
+			assert.NoError(err)
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	cmd := Command{
+		Name:  "set-interval",
+		FPort: 10,
+		Parameters: []Parameter{
+			{Name: "interval", Type: Integer, Min: float(60), Default: json.RawMessage(`3600`)},
+			{Name: "mode", Type: Enum, Values: []string{"eco", "normal"}},
+			{Name: "led", Type: Boolean, Default: json.RawMessage(`false`)},
+		},
+		Object: `{"interval": "{{ interval }}", "mode": "{{ mode }}", "led": "{{ led }}"}`,
+	}
+	require.NoError(t, cmd.Validate())
+
+	tests := []struct {
+		Name          string
+		Values        map[string]json.RawMessage
+		Expected      string
+		ExpectedError string
+	}{
+		{
+			Name:     "defaults",
+			Values:   map[string]json.RawMessage{"mode": json.RawMessage(`"eco"`)},
+			Expected: `{"interval": 3600, "mode": "eco", "led": false}`,
+		},
+		{
+			Name: "all values",
+			Values: map[string]json.RawMessage{
+				"interval": json.RawMessage(`120`),
+				"mode":     json.RawMessage(`"normal"`),
+				"led":      json.RawMessage(`true`),
+			},
+			Expected: `{"interval": 120, "mode": "normal", "led": true}`,
+		},
+		{
+			Name:          "required parameter missing",
+			Values:        map[string]json.RawMessage{},
+			ExpectedError: "parameter mode is required",
+		},
+		{
+			Name: "not an integer",
+			Values: map[string]json.RawMessage{
+				"interval": json.RawMessage(`120.5`),
+				"mode":     json.RawMessage(`"eco"`),
+			},
+			ExpectedError: "parameter interval: value must be an integer",
+		},
+		{
+			Name: "below min",
+			Values: map[string]json.RawMessage{
+				"interval": json.RawMessage(`10`),
+				"mode":     json.RawMessage(`"eco"`),
+			},
+			ExpectedError: "parameter interval: value must be greater than or equal to 60",
+		},
+		{
+			Name: "invalid enum value",
+			Values: map[string]json.RawMessage{
+				"mode": json.RawMessage(`"turbo"`),
+			},
+			ExpectedError: "parameter mode: value must be one of: eco, normal",
+		},
+		{
+			Name: "unknown parameter",
+			Values: map[string]json.RawMessage{
+				"mode":  json.RawMessage(`"eco"`),
+				"color": json.RawMessage(`"red"`),
+			},
+			ExpectedError: "unknown parameter color",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			b, err := cmd.Render(tst.Values)
+			if tst.ExpectedError != "" {
+				assert.EqualError(err, tst.ExpectedError)
+				return
+			}
+			assert.NoError(err)
+			assert.JSONEq(tst.Expected, string(b))
+		})
+	}
+}
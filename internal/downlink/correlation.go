@@ -0,0 +1,117 @@
+package downlink
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	pb //"github.com/ibrahimozekici/lora-api/go/v3/as/integration"
+	"github.com/ibrahimozekici/app-server2/internal/integration"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// Integration event name and types of the downlink delivery events.
+const (
+	downlinkIntegrationName = "downlink"
+	txAckEventType          = "tx_ack"
+	ackEventType            = "ack"
+)
+
+// deliveryEvent defines the object of the downlink delivery events.
+type deliveryEvent struct {
+	CorrelationID uuid.UUID  `json:"correlationID"`
+	FCnt          uint32     `json:"fCnt"`
+	FPort         uint8      `json:"fPort"`
+	Confirmed     bool       `json:"confirmed"`
+	TxAckAt       *time.Time `json:"txAckAt"`
+	AckAt         *time.Time `json:"ackAt"`
+	Acknowledged  *bool      `json:"acknowledged"`
+}
+
+// HandleDownlinkTxAck updates the delivery status of the downlink with the
+// given frame-counter and sends a tx ack event containing its correlation ID
+// to the integrations. Downlinks without correlation ID are ignored.
+func HandleDownlinkTxAck(ctx context.Context, app storage.Application, d storage.Device, fCnt uint32) error {
+	dc, err := storage.SetDownlinkCorrelationTxAck(ctx, storage.DB(), d.DevEUI, fCnt)
+	if err != nil {
+		if errors.Cause(err) == storage.ErrDoesNotExist {
+			return nil
+		}
+		return errors.Wrap(err, "set downlink correlation tx ack error")
+	}
+
+	return sendDeliveryEvent(ctx, app, d, txAckEventType, dc)
+}
+
+// HandleDownlinkAck updates the delivery status of the confirmed downlink
+// with the given frame-counter and sends an ack event containing its
+// correlation ID to the integrations. Downlinks without correlation ID are
+// ignored.
+func HandleDownlinkAck(ctx context.Context, app storage.Application, d storage.Device, fCnt uint32, acknowledged bool) error {
+	dc, err := storage.SetDownlinkCorrelationAck(ctx, storage.DB(), d.DevEUI, fCnt, acknowledged)
+	if err != nil {
+		if errors.Cause(err) == storage.ErrDoesNotExist {
+			return nil
+		}
+		return errors.Wrap(err, "set downlink correlation ack error")
+	}
+
+	return sendDeliveryEvent(ctx, app, d, ackEventType, dc)
+}
+
+func sendDeliveryEvent(ctx context.Context, app storage.Application, d storage.Device, eventType string, dc storage.DownlinkCorrelation) error {
+	b, err := json.Marshal(deliveryEvent{
+		CorrelationID: dc.ID,
+		FCnt:          dc.FCnt,
+		FPort:         dc.FPort,
+		Confirmed:     dc.Confirmed,
+		TxAckAt:       dc.TxAckAt,
+		AckAt:         dc.AckAt,
+		Acknowledged:  dc.Acknowledged,
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshal json error")
+	}
+
+	pl := pb.IntegrationEvent{
+		ApplicationId:   uint64(app.ID),
+		ApplicationName: app.Name,
+		DeviceName:      d.Name,
+		DevEui:          d.DevEUI[:],
+		Tags:            make(map[string]string),
+		IntegrationName: downlinkIntegrationName,
+		EventType:       eventType,
+		ObjectJson:      string(b),
+	}
+
+	for k, v := range d.Tags.Map {
+		if v.Valid {
+			pl.Tags[k] = v.String
+		}
+	}
+
+	vars := make(map[string]string)
+	for k, v := range d.Variables.Map {
+		if v.Valid {
+			vars[k] = v.String
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui":        d.DevEUI,
+		"correlation_id": dc.ID,
+		"event_type":     eventType,
+		"ctx_id":         ctx.Value(logging.ContextIDKey),
+	}).Info("downlink: sending delivery event")
+
+	if err := integration.ForApplicationID(app.ID).HandleIntegrationEvent(ctx, vars, pl); err != nil {
+		return errors.Wrap(err, "send integration event error")
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package downlink
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/gofrs/uuid"
 	"github.com/jmoiron/sqlx"
@@ -11,12 +12,37 @@ import (
 
 	pb //"github.com/ibrahimozekici/lora-api/go/v3/as/integration"
 	"github.com/ibrahimozekici/app-server2/internal/codec"
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/downlink/validation"
 	"github.com/ibrahimozekici/app-server2/internal/integration"
 	"github.com/ibrahimozekici/app-server2/internal/integration/models"
 	"github.com/ibrahimozekici/app-server2/internal/logging"
 	"github.com/ibrahimozekici/app-server2/internal/storage"
 )
 
+var (
+	retryInterval  time.Duration
+	retryBatchSize int
+
+	expiryInterval  time.Duration
+	expiryBatchSize int
+)
+
+// Setup configures the package and starts the confirmed downlink retry and
+// device-queue item expiry loops.
+func Setup(conf config.Config) error {
+	retryInterval = conf.ApplicationServer.ConfirmedDownlinkRetry.Interval
+	retryBatchSize = conf.ApplicationServer.ConfirmedDownlinkRetry.BatchSize
+
+	expiryInterval = conf.ApplicationServer.DeviceQueueExpiry.Interval
+	expiryBatchSize = conf.ApplicationServer.DeviceQueueExpiry.BatchSize
+
+	go RetryConfirmedDownlinksLoop()
+	go ExpireDeviceQueueItemsLoop()
+
+	return nil
+}
+
 // HandleDataDownPayloads handles received downlink payloads to be emitted to the
 // devices.
 func HandleDataDownPayloads(downChan chan models.DataDownPayload) {
@@ -41,8 +67,16 @@ func HandleDataDownPayloads(downChan chan models.DataDownPayload) {
 	}
 }
 
+// EnqueueDataDownPayload validates, encodes (when the object is set) and
+// enqueues the given downlink payload for the device.
+func EnqueueDataDownPayload(ctx context.Context, pl models.DataDownPayload) error {
+	return handleDataDownPayload(ctx, pl)
+}
+
 func handleDataDownPayload(ctx context.Context, pl models.DataDownPayload) error {
-	return storage.Transaction(func(tx sqlx.Ext) error {
+	releaseRateLimit := func() {}
+
+	err := storage.Transaction(func(tx sqlx.Ext) error {
 		// lock the device so that a concurrent Enqueue action will block
 		// until this transaction has been completed
 		d, err := storage.GetDevice(ctx, tx, pl.DevEUI, true, true)
@@ -58,51 +92,155 @@ func handleDataDownPayload(ctx context.Context, pl models.DataDownPayload) error
 			return errors.New("enqueue downlink payload: device does not exist for given application")
 		}
 
+		dp, err := storage.GetDeviceProfile(ctx, storage.DB(), d.DeviceProfileID, false, true)
+		if err != nil {
+			return errors.Wrap(err, "get device-profile error")
+		}
+
+		app, err := storage.GetApplication(ctx, tx, d.ApplicationID)
+		if err != nil {
+			return errors.Wrap(err, "get application error")
+		}
+
 		// if Object is set, try to encode it to bytes using the application codec
 		//if pl.Object != nil && string(pl.Object) != "null" {
 		if pl.Object != nil && string(pl.Object) != "null" {
-			app, err := storage.GetApplication(ctx, tx, d.ApplicationID)
+			if err := ValidateObject(dp, pl.FPort, []byte(pl.Object)); err != nil {
+				logValidationError(ctx, app, d, err)
+				return errors.Wrap(err, "validate object error")
+			}
+
+			c, err := storage.ResolvePayloadCodec(ctx, storage.DB(), app, dp, d)
 			if err != nil {
-				return errors.Wrap(err, "get application error")
+				return errors.Wrap(err, "resolve payload codec error")
 			}
 
-			dp, err := storage.GetDeviceProfile(ctx, storage.DB(), d.DeviceProfileID, false, true)
+			pl.Data, err = codec.JSONToBinary(c.Type, pl.FPort, d.Variables, c.EncoderScript, []byte(pl.Object))
 			if err != nil {
-				return errors.Wrap(err, "get device-profile error")
+				logCodecError(ctx, app, d, err)
+				return errors.Wrap(err, "encode object error")
 			}
+		}
+
+		if err := ValidatePayload(ctx, storage.DB(), dp, d, pl.FPort, pl.Data); err != nil {
+			logValidationError(ctx, app, d, err)
+			return errors.Wrap(err, "validate payload error")
+		}
 
-			// TODO: in the next major release, remove this and always use the
-			// device-profile codec fields.
-			payloadCodec := app.PayloadCodec
-			payloadEncoderScript := app.PayloadEncoderScript
+		if pl.ExpiresAt != nil && !pl.ExpiresAt.After(time.Now()) {
+			err := fmt.Errorf("downlink expired at %s before it was enqueued (f_port: %d)", pl.ExpiresAt.Format(time.RFC3339), pl.FPort)
+			logError(ctx, app, d, pb.ErrorType_UNKNOWN, err)
+			return err
+		}
+
+		dup, err := storage.CheckDownlinkDuplicate(ctx, tx, pl.DevEUI, pl.Confirmed, pl.FPort, pl.Data)
+		if err != nil {
+			if errors.Cause(err) == storage.ErrDownlinkDuplicate {
+				logError(ctx, app, d, pb.ErrorType_UNKNOWN, err)
+			}
+			return errors.Wrap(err, "check downlink duplicate error")
+		}
+		if dup != nil {
+			log.WithFields(log.Fields{
+				"dev_eui":        pl.DevEUI,
+				"correlation_id": dup.ID,
+				"ctx_id":         ctx.Value(logging.ContextIDKey),
+			}).Info("downlink coalesced with pending downlink")
+			return nil
+		}
 
-			if dp.PayloadCodec != "" {
-				payloadCodec = dp.PayloadCodec
-				payloadEncoderScript = dp.PayloadEncoderScript
+		release, err := storage.CheckDownlinkRateLimit(ctx, d.ApplicationID, d.DevEUI)
+		if err != nil {
+			if cause := errors.Cause(err); cause == storage.ErrDeviceDownlinkRateLimit || cause == storage.ErrApplicationDownlinkRateLimit {
+				logError(ctx, app, d, pb.ErrorType_UNKNOWN, err)
 			}
+			return errors.Wrap(err, "check downlink rate limit error")
+		}
+		releaseRateLimit = release
 
-			pl.Data, err = codec.JSONToBinary(payloadCodec, pl.FPort, d.Variables, payloadEncoderScript, []byte(pl.Object))
+		// the correlation ID set by the integration must be unique
+		correlationID := pl.CorrelationID
+		if correlationID == nil {
+			id, err := uuid.NewV4()
 			if err != nil {
-				logCodecError(ctx, app, d, err)
-				return errors.Wrap(err, "encode object error")
+				return errors.Wrap(err, "new uuid error")
 			}
+			correlationID = &id
+		} else if _, err := storage.GetDownlinkCorrelation(ctx, tx, *correlationID); err == nil {
+			return fmt.Errorf("downlink with correlation id %s already exists", correlationID)
+		} else if errors.Cause(err) != storage.ErrDoesNotExist {
+			return errors.Wrap(err, "get downlink correlation error")
 		}
 
-		if _, err := storage.EnqueueDownlinkPayload(ctx, tx, pl.DevEUI, pl.Confirmed, pl.FPort, pl.Data); err != nil {
+		fCnt, err := storage.EnqueueCorrelatedDownlinkPayload(ctx, tx, pl.DevEUI, *correlationID, pl.Confirmed, pl.FPort, pl.Data)
+		if err != nil {
 			return errors.Wrap(err, "enqueue downlink device-queue item error")
 		}
 
+		if err := storage.SetDownlinkDeduplicationKey(ctx, pl.DevEUI, pl.Confirmed, pl.FPort, pl.Data, *correlationID); err != nil {
+			return errors.Wrap(err, "set downlink deduplication key error")
+		}
+
+		if pl.Object != nil && string(pl.Object) != "null" {
+			if err := storage.CreateDeviceQueueItemObject(ctx, tx, &storage.DeviceQueueItemObject{
+				DevEUI: pl.DevEUI,
+				FCnt:   fCnt,
+				FPort:  pl.FPort,
+				Object: pl.Object,
+			}); err != nil {
+				return errors.Wrap(err, "create device-queue item object error")
+			}
+		}
+
+		if pl.ExpiresAt != nil {
+			if err := storage.CreateDeviceQueueItemExpiry(ctx, tx, &storage.DeviceQueueItemExpiry{
+				DevEUI:    pl.DevEUI,
+				FCnt:      fCnt,
+				FPort:     pl.FPort,
+				ExpiresAt: *pl.ExpiresAt,
+			}); err != nil {
+				return errors.Wrap(err, "create device-queue item expiry error")
+			}
+		}
+
 		return nil
 	})
+	if err != nil {
+		// the downlink has not been enqueued
+		releaseRateLimit()
+	}
+
+	return err
 }
 
 func logCodecError(ctx context.Context, a storage.Application, d storage.Device, err error) {
+	logError(ctx, a, d, pb.ErrorType_DOWNLINK_CODEC, err)
+}
+
+// logValidationError publishes the downlink validation error to the
+// integrations, as downlinks received from the integrations are handled
+// asynchronously and there is no caller to return the error to.
+func logValidationError(ctx context.Context, a storage.Application, d storage.Device, err error) {
+	vErr, ok := errors.Cause(err).(*validation.Error)
+	if !ok {
+		return
+	}
+
+	typ := pb.ErrorType_UNKNOWN
+	if vErr.Validator == validation.MaxPayloadSize {
+		typ = pb.ErrorType_DEVICE_QUEUE_ITEM_SIZE
+	}
+
+	logError(ctx, a, d, typ, vErr)
+}
+
+func logError(ctx context.Context, a storage.Application, d storage.Device, typ pb.ErrorType, err error) {
 	errEvent := pb.ErrorEvent{
 		ApplicationId:   uint64(a.ID),
 		ApplicationName: a.Name,
 		DeviceName:      d.Name,
 		DevEui:          d.DevEUI[:],
-		Type:            pb.ErrorType_DOWNLINK_CODEC,
+		Type:            typ,
 		Error:           err.Error(),
 		Tags:            make(map[string]string),
 	}
@@ -16,6 +16,9 @@ import (
 	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
 	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
 	"github.com/ibrahimozekici/app-server2/internal/codec"
+	"github.com/ibrahimozekici/app-server2/internal/downlink/validation"
+	"github.com/ibrahimozekici/app-server2/internal/integration"
+	integrationmock "github.com/ibrahimozekici/app-server2/internal/integration/mock"
 	"github.com/ibrahimozekici/app-server2/internal/integration/models"
 	"github.com/ibrahimozekici/app-server2/internal/storage"
 	"github.com/ibrahimozekici/app-server2/internal/test"
@@ -37,6 +40,9 @@ func TestHandleDownlinkQueueItem(t *testing.T) {
 		}
 		networkserver.SetPool(mock.NewPool(nsClient))
 
+		h := integrationmock.New()
+		integration.SetMockIntegration(h)
+
 		org := storage.Organization{
 			Name: "test-org",
 		}
@@ -102,6 +108,7 @@ func TestHandleDownlinkQueueItem(t *testing.T) {
 				Payload              models.DataDownPayload
 				PayloadCodec         codec.Type
 				PayloadEncoderScript string
+				DownlinkValidation   validation.Rules
 
 				ExpectedError                        error
 				ExpectedCreateDeviceQueueItemRequest ns.CreateDeviceQueueItemRequest
@@ -220,6 +227,29 @@ func TestHandleDownlinkQueueItem(t *testing.T) {
 						},
 					},
 				},
+				{
+					Name:               "fPort not allowed",
+					DownlinkValidation: validation.Rules{FPorts: []int{10}},
+					Payload: models.DataDownPayload{
+						ApplicationID: app.ID,
+						DevEUI:        device.DevEUI,
+						FPort:         2,
+						Data:          []byte{1, 2, 3, 4},
+					},
+					ExpectedError: errors.New("validate payload error: fport: fPort 2 is not allowed, allowed fPorts are [10]"),
+				},
+				{
+					Name:               "object does not match schema",
+					PayloadCodec:       codec.CustomJSType,
+					DownlinkValidation: validation.Rules{Schema: json.RawMessage(`{"required": ["Bytes"]}`)},
+					Payload: models.DataDownPayload{
+						ApplicationID: app.ID,
+						DevEUI:        device.DevEUI,
+						FPort:         2,
+						Object:        json.RawMessage(`{"Foo": 1}`),
+					},
+					ExpectedError: errors.New("validate object error: schema: object: missing required property 'Bytes'"),
+				},
 			}
 
 			for i, test := range tests {
@@ -228,11 +258,17 @@ func TestHandleDownlinkQueueItem(t *testing.T) {
 					dp.PayloadCodec = test.PayloadCodec
 					dp.PayloadEncoderScript = test.PayloadEncoderScript
 					So(storage.UpdateDeviceProfile(context.Background(), storage.DB(), &dp), ShouldBeNil)
+					So(storage.UpdateDeviceProfileDownlinkValidation(context.Background(), storage.DB(), dpID, test.DownlinkValidation), ShouldBeNil)
 
 					err := handleDataDownPayload(context.Background(), test.Payload)
 					if test.ExpectedError != nil {
 						So(err, ShouldNotBeNil)
 						So(err.Error(), ShouldEqual, test.ExpectedError.Error())
+
+						if validation.IsError(err) {
+							So(h.SendErrorNotificationChan, ShouldHaveLength, 1)
+							<-h.SendErrorNotificationChan
+						}
 						return
 					}
 
@@ -0,0 +1,145 @@
+package downlink
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	pb //"github.com/ibrahimozekici/lora-api/go/v3/as/integration"
+	"github.com/ibrahimozekici/app-server2/internal/leader"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+// ExpireDeviceQueueItemsLoop removes the device-queue items which were not
+// sent before their expiry timestamp and sends an error event for each
+// removed item to the integrations.
+func ExpireDeviceQueueItemsLoop() {
+	for {
+		ctxID, err := uuid.NewV4()
+		if err != nil {
+			log.WithError(err).Error("new uuid error")
+		}
+
+		ctx := context.Background()
+		ctx = context.WithValue(ctx, logging.ContextIDKey, ctxID)
+
+		var expired []storage.DeviceQueueItemExpiry
+		err = leader.Do(ctx, "device_queue_expiry", func(ctx context.Context) error {
+			return storage.Transaction(func(tx sqlx.Ext) error {
+				var err error
+				expired, err = expireDeviceQueueItems(ctx, tx)
+				return err
+			})
+		})
+		if err != nil {
+			log.WithError(err).Error("expire device-queue items error")
+		} else {
+			for _, e := range expired {
+				if err := logExpiredError(ctx, e); err != nil {
+					log.WithError(err).WithField("dev_eui", e.DevEUI).Error("log device-queue item expired error")
+				}
+			}
+		}
+
+		time.Sleep(expiryInterval)
+	}
+}
+
+// expireDeviceQueueItems removes the expired items from the device-queue and
+// returns the items that were removed. Expired items which were already sent
+// are not returned, unless they were awaiting a confirmed downlink retry, in
+// which case the retry is cancelled.
+func expireDeviceQueueItems(ctx context.Context, db sqlx.Ext) ([]storage.DeviceQueueItemExpiry, error) {
+	items, err := storage.GetExpiredDeviceQueueItems(ctx, db, expiryBatchSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "get expired device-queue items error")
+	}
+
+	var devEUIs []lorawan.EUI64
+	perDevice := make(map[lorawan.EUI64][]storage.DeviceQueueItemExpiry)
+	for _, e := range items {
+		if _, ok := perDevice[e.DevEUI]; !ok {
+			devEUIs = append(devEUIs, e.DevEUI)
+		}
+		perDevice[e.DevEUI] = append(perDevice[e.DevEUI], e)
+	}
+
+	var out []storage.DeviceQueueItemExpiry
+	for _, devEUI := range devEUIs {
+		var fCnts []uint32
+		for _, e := range perDevice[devEUI] {
+			fCnts = append(fCnts, e.FCnt)
+		}
+
+		removed, err := storage.RemoveDeviceQueueItems(ctx, db, devEUI, fCnts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "remove device-queue items error, dev_eui: %s", devEUI)
+		}
+
+		isRemoved := make(map[uint32]bool)
+		for _, fCnt := range removed {
+			isRemoved[fCnt] = true
+		}
+
+		for _, e := range perDevice[devEUI] {
+			if err := storage.DeleteDeviceQueueItemExpiry(ctx, db, e.DevEUI, e.FCnt); err != nil {
+				return nil, errors.Wrap(err, "delete device-queue item expiry error")
+			}
+
+			// a confirmed downlink that is scheduled for retry must not be
+			// re-enqueued after its expiry
+			confirmed := true
+			cd, err := storage.GetConfirmedDownlink(ctx, db, e.DevEUI, e.FCnt, true)
+			if err != nil {
+				if errors.Cause(err) != storage.ErrDoesNotExist {
+					return nil, errors.Wrap(err, "get confirmed downlink error")
+				}
+				confirmed = false
+			}
+
+			if !isRemoved[e.FCnt] && !(confirmed && cd.RetryAfter != nil) {
+				continue
+			}
+
+			if confirmed {
+				if err := storage.DeleteConfirmedDownlink(ctx, db, e.DevEUI, e.FCnt); err != nil {
+					return nil, errors.Wrap(err, "delete confirmed downlink error")
+				}
+			}
+
+			out = append(out, e)
+		}
+	}
+
+	return out, nil
+}
+
+func logExpiredError(ctx context.Context, e storage.DeviceQueueItemExpiry) error {
+	d, err := storage.GetDevice(ctx, storage.DB(), e.DevEUI, false, true)
+	if err != nil {
+		return errors.Wrap(err, "get device error")
+	}
+
+	app, err := storage.GetApplication(ctx, storage.DB(), d.ApplicationID)
+	if err != nil {
+		return errors.Wrap(err, "get application error")
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui":    e.DevEUI,
+		"f_cnt":      e.FCnt,
+		"expires_at": e.ExpiresAt,
+		"ctx_id":     ctx.Value(logging.ContextIDKey),
+	}).Warning("device-queue item expired")
+
+	logError(ctx, app, d, pb.ErrorType_UNKNOWN, fmt.Errorf("device-queue item expired at %s before it was sent (f_cnt: %d, f_port: %d)", e.ExpiresAt.Format(time.RFC3339), e.FCnt, e.FPort))
+
+	return nil
+}
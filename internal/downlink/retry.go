@@ -0,0 +1,155 @@
+package downlink
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	pb //"github.com/ibrahimozekici/lora-api/go/v3/as/integration"
+	"github.com/ibrahimozekici/app-server2/internal/leader"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// RetryConfirmedDownlinksLoop re-enqueues the confirmed downlinks for which
+// the retry backoff has passed.
+func RetryConfirmedDownlinksLoop() {
+	for {
+		ctxID, err := uuid.NewV4()
+		if err != nil {
+			log.WithError(err).Error("new uuid error")
+		}
+
+		ctx := context.Background()
+		ctx = context.WithValue(ctx, logging.ContextIDKey, ctxID)
+
+		err = leader.Do(ctx, "confirmed_downlink_retry", func(ctx context.Context) error {
+			return retryConfirmedDownlinks(ctx, storage.DB())
+		})
+		if err != nil {
+			log.WithError(err).Error("retry confirmed downlinks error")
+		}
+		time.Sleep(retryInterval)
+	}
+}
+
+// retryConfirmedDownlinks re-enqueues the pending confirmed downlinks. Each
+// downlink is re-enqueued within its own transaction, as re-enqueueing
+// calls the network-server. A failing downlink is logged and does not
+// affect the other downlinks.
+func retryConfirmedDownlinks(ctx context.Context, db sqlx.Queryer) error {
+	items, err := storage.GetPendingConfirmedDownlinkRetries(ctx, db, retryBatchSize)
+	if err != nil {
+		return errors.Wrap(err, "get pending confirmed downlink retries error")
+	}
+
+	for _, cd := range items {
+		var fCnt uint32
+		var retried bool
+		err := storage.Transaction(func(tx sqlx.Ext) error {
+			// lock the downlink, it might have been retried or
+			// rescheduled in the meantime
+			locked, err := storage.GetConfirmedDownlink(ctx, tx, cd.DevEUI, cd.FCnt, true)
+			if err != nil {
+				if errors.Cause(err) == storage.ErrDoesNotExist {
+					return nil
+				}
+				return errors.Wrap(err, "get confirmed downlink error")
+			}
+			if locked.RetryAfter == nil || locked.RetryAfter.After(time.Now()) || locked.RetryUplinks != 0 {
+				return nil
+			}
+
+			fCnt, err = storage.RetryConfirmedDownlink(ctx, tx, locked)
+			retried = err == nil
+			return err
+		})
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"dev_eui": cd.DevEUI,
+				"f_cnt":   cd.FCnt,
+				"ctx_id":  ctx.Value(logging.ContextIDKey),
+			}).Error("retry confirmed downlink error")
+			continue
+		}
+		if !retried {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"dev_eui":     cd.DevEUI,
+			"f_cnt":       fCnt,
+			"prev_f_cnt":  cd.FCnt,
+			"retry_count": cd.RetryCount + 1,
+			"ctx_id":      ctx.Value(logging.ContextIDKey),
+		}).Info("confirmed downlink re-enqueued")
+	}
+
+	return nil
+}
+
+// HandleConfirmedDownlinkACK handles the acknowledgement of a confirmed
+// downlink. When acknowledged, the stored downlink is removed. Otherwise
+// the retry is scheduled according to the retry policy of the
+// device-profile, or when the max. number of retries has been reached, the
+// downlink is removed and an error event is sent to the integrations.
+func HandleConfirmedDownlinkACK(ctx context.Context, app storage.Application, d storage.Device, fCnt uint32, acknowledged bool) error {
+	var giveUp *storage.ConfirmedDownlink
+
+	err := storage.Transaction(func(tx sqlx.Ext) error {
+		cd, err := storage.GetConfirmedDownlink(ctx, tx, d.DevEUI, fCnt, true)
+		if err != nil {
+			// the downlink was not enqueued with a retry policy
+			if errors.Cause(err) == storage.ErrDoesNotExist {
+				return nil
+			}
+			return errors.Wrap(err, "get confirmed downlink error")
+		}
+
+		if acknowledged {
+			return storage.DeleteConfirmedDownlink(ctx, tx, d.DevEUI, fCnt)
+		}
+
+		dp, err := storage.GetDeviceProfile(ctx, tx, d.DeviceProfileID, false, true)
+		if err != nil {
+			return errors.Wrap(err, "get device-profile error")
+		}
+		p := dp.ConfirmedDownlinkRetry
+
+		if cd.RetryCount >= p.MaxRetries {
+			if err := storage.DeleteConfirmedDownlink(ctx, tx, d.DevEUI, fCnt); err != nil {
+				return errors.Wrap(err, "delete confirmed downlink error")
+			}
+			giveUp = &cd
+			return nil
+		}
+
+		retryAfter := time.Now().Add(time.Duration(p.BackoffSeconds) * time.Second)
+		if err := storage.ScheduleConfirmedDownlinkRetry(ctx, tx, d.DevEUI, fCnt, retryAfter, p.BackoffFrames); err != nil {
+			return errors.Wrap(err, "schedule confirmed downlink retry error")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if giveUp != nil {
+		log.WithFields(log.Fields{
+			"dev_eui":     d.DevEUI,
+			"f_cnt":       giveUp.FCnt,
+			"retry_count": giveUp.RetryCount,
+			"ctx_id":      ctx.Value(logging.ContextIDKey),
+		}).Warning("confirmed downlink not acknowledged, max. retries reached")
+
+		logError(ctx, app, d, pb.ErrorType_UNKNOWN, fmt.Errorf("confirmed downlink not acknowledged after %d retries, giving up (f_cnt: %d, f_port: %d)", giveUp.RetryCount, giveUp.FCnt, giveUp.FPort))
+	}
+
+	return nil
+}
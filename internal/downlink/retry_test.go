@@ -0,0 +1,128 @@
+package downlink
+
+import (
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+
+	// "github.com/ibrahimozekici/lora-api/go/v3/ns"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/integration"
+	integrationmock "github.com/ibrahimozekici/app-server2/internal/integration/mock"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	"github.com/ibrahimozekici/app-server2/internal/test"
+	//"github.com/brocaar/lorawan"
+)
+
+func TestHandleConfirmedDownlinkACK(t *testing.T) {
+	conf := test.GetConfig()
+	if err := storage.Setup(conf); err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("Given a clean database, a device-profile with retry policy and a device", t, func() {
+		test.MustResetDB(storage.DB().DB)
+
+		nsClient := mock.NewClient()
+		nsClient.GetNextDownlinkFCntForDevEUIResponse = ns.GetNextDownlinkFCntForDevEUIResponse{
+			FCnt: 12,
+		}
+		networkserver.SetPool(mock.NewPool(nsClient))
+
+		h := integrationmock.New()
+		integration.SetMockIntegration(h)
+
+		org := storage.Organization{
+			Name: "test-org",
+		}
+		So(storage.CreateOrganization(context.Background(), storage.DB(), &org), ShouldBeNil)
+
+		n := storage.NetworkServer{
+			Name:   "test-ns",
+			Server: "test-ns:1234",
+		}
+		So(storage.CreateNetworkServer(context.Background(), storage.DB(), &n), ShouldBeNil)
+
+		sp := storage.ServiceProfile{
+			Name:            "test-sp",
+			OrganizationID:  org.ID,
+			NetworkServerID: n.ID,
+		}
+		So(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp), ShouldBeNil)
+		spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+		So(err, ShouldBeNil)
+
+		dp := storage.DeviceProfile{
+			Name:            "test-dp",
+			OrganizationID:  org.ID,
+			NetworkServerID: n.ID,
+		}
+		So(storage.CreateDeviceProfile(context.Background(), storage.DB(), &dp), ShouldBeNil)
+		dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+		So(err, ShouldBeNil)
+		So(storage.UpdateDeviceProfileConfirmedDownlinkRetry(context.Background(), storage.DB(), dpID, storage.ConfirmedDownlinkRetryPolicy{
+			MaxRetries: 1,
+		}), ShouldBeNil)
+
+		app := storage.Application{
+			OrganizationID:   org.ID,
+			Name:             "test-app",
+			ServiceProfileID: spID,
+		}
+		So(storage.CreateApplication(context.Background(), storage.DB(), &app), ShouldBeNil)
+
+		d := storage.Device{
+			ApplicationID:   app.ID,
+			DeviceProfileID: dpID,
+			Name:            "test-node",
+			DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		}
+		So(storage.CreateDevice(context.Background(), storage.DB(), &d), ShouldBeNil)
+
+		_, err = storage.EnqueueDownlinkPayload(context.Background(), storage.DB(), d.DevEUI, true, 10, []byte{1, 2, 3})
+		So(err, ShouldBeNil)
+		<-nsClient.CreateDeviceQueueItemChan
+
+		Convey("When the downlink is acknowledged", func() {
+			So(HandleConfirmedDownlinkACK(context.Background(), app, d, 12, true), ShouldBeNil)
+
+			Convey("Then the confirmed downlink has been removed", func() {
+				_, err := storage.GetConfirmedDownlink(context.Background(), storage.DB(), d.DevEUI, 12, false)
+				So(errors.Cause(err), ShouldEqual, storage.ErrDoesNotExist)
+			})
+		})
+
+		Convey("When the downlink is not acknowledged", func() {
+			So(HandleConfirmedDownlinkACK(context.Background(), app, d, 12, false), ShouldBeNil)
+
+			Convey("Then the retry is enqueued", func() {
+				nsClient.GetNextDownlinkFCntForDevEUIResponse = ns.GetNextDownlinkFCntForDevEUIResponse{
+					FCnt: 13,
+				}
+				So(retryConfirmedDownlinks(context.Background(), storage.DB()), ShouldBeNil)
+
+				req := <-nsClient.CreateDeviceQueueItemChan
+				So(req.Item.FCnt, ShouldEqual, 13)
+				So(req.Item.Confirmed, ShouldBeTrue)
+
+				cd, err := storage.GetConfirmedDownlink(context.Background(), storage.DB(), d.DevEUI, 13, false)
+				So(err, ShouldBeNil)
+				So(cd.RetryCount, ShouldEqual, 1)
+
+				Convey("When the retry is not acknowledged, an error event is sent", func() {
+					So(HandleConfirmedDownlinkACK(context.Background(), app, d, 13, false), ShouldBeNil)
+
+					errEvent := <-h.SendErrorNotificationChan
+					So(errEvent.Error, ShouldEqual, "confirmed downlink not acknowledged after 1 retries, giving up (f_cnt: 13, f_port: 10)")
+
+					_, err := storage.GetConfirmedDownlink(context.Background(), storage.DB(), d.DevEUI, 13, false)
+					So(errors.Cause(err), ShouldEqual, storage.ErrDoesNotExist)
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,219 @@
+// Package rule implements the conditions of the downlink rules. A downlink
+// rule enqueues a configured downlink when the decoded object of an uplink
+// matches all the conditions of the rule.
+package rule
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Operator defines the condition operator.
+type Operator string
+
+// Available condition operators.
+const (
+	Equal              Operator = "EQ"
+	NotEqual           Operator = "NE"
+	GreaterThan        Operator = "GT"
+	GreaterThanOrEqual Operator = "GTE"
+	LessThan           Operator = "LT"
+	LessThanOrEqual    Operator = "LTE"
+	Exists             Operator = "EXISTS"
+)
+
+// maxConditions defines the max. number of conditions of a rule.
+const maxConditions = 32
+
+// Condition defines a condition on a field of the decoded object. Path is
+// the (dot separated) path of the field, e.g. "sensor.moisture" or "values.0"
+// for the first array item. Value is ignored by the EXISTS operator and must
+// be a number for the GT, GTE, LT and LTE operators.
+type Condition struct {
+	Path     string          `json:"path"`
+	Operator Operator        `json:"operator"`
+	Value    json.RawMessage `json:"value,omitempty"`
+}
+
+// Conditions contains the conditions of a rule, which must all match.
+type Conditions []Condition
+
+// Validate validates the conditions.
+func (c Conditions) Validate() error {
+	if len(c) == 0 {
+		return errors.New("at least one condition is required")
+	}
+	if len(c) > maxConditions {
+		return fmt.Errorf("max. number of conditions is %d", maxConditions)
+	}
+
+	for i, cond := range c {
+		if !ValidPath(cond.Path) {
+			return fmt.Errorf("condition %d: invalid path: '%s'", i, cond.Path)
+		}
+
+		switch cond.Operator {
+		case Exists:
+			continue
+		case Equal, NotEqual:
+			var v interface{}
+			if err := json.Unmarshal(cond.Value, &v); err != nil {
+				return fmt.Errorf("condition %d: invalid value: %s", i, err)
+			}
+		case GreaterThan, GreaterThanOrEqual, LessThan, LessThanOrEqual:
+			var v float64
+			if err := json.Unmarshal(cond.Value, &v); err != nil {
+				return fmt.Errorf("condition %d: value must be a number", i)
+			}
+		default:
+			return fmt.Errorf("condition %d: invalid operator: '%s'", i, cond.Operator)
+		}
+	}
+
+	return nil
+}
+
+// Match returns true when the given (JSON decoded) object matches all
+// conditions.
+func (c Conditions) Match(obj interface{}) bool {
+	if len(c) == 0 {
+		return false
+	}
+
+	for _, cond := range c {
+		if !cond.match(obj) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MatchJSON returns true when the given JSON object matches all conditions.
+func (c Conditions) MatchJSON(objectJSON []byte) (bool, error) {
+	if len(objectJSON) == 0 {
+		return false, nil
+	}
+
+	var obj interface{}
+	if err := json.Unmarshal(objectJSON, &obj); err != nil {
+		return false, err
+	}
+
+	return c.Match(obj), nil
+}
+
+// Value implements the driver.Valuer interface.
+func (c Conditions) Value() (driver.Value, error) {
+	if c == nil {
+		return "[]", nil
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (c *Conditions) Scan(src interface{}) error {
+	var b []byte
+	switch src := src.(type) {
+	case []byte:
+		b = src
+	case string:
+		b = []byte(src)
+	case nil:
+		*c = nil
+		return nil
+	default:
+		return fmt.Errorf("expected []byte, got %T", src)
+	}
+
+	return json.Unmarshal(b, c)
+}
+
+func (c Condition) match(obj interface{}) bool {
+	v, ok := lookup(obj, strings.Split(c.Path, "."))
+	if !ok {
+		return false
+	}
+
+	if c.Operator == Exists {
+		return true
+	}
+
+	var expected interface{}
+	if err := json.Unmarshal(c.Value, &expected); err != nil {
+		return false
+	}
+
+	switch c.Operator {
+	case Equal:
+		return reflect.DeepEqual(v, expected)
+	case NotEqual:
+		return !reflect.DeepEqual(v, expected)
+	}
+
+	a, ok := v.(float64)
+	if !ok {
+		return false
+	}
+	b, ok := expected.(float64)
+	if !ok {
+		return false
+	}
+
+	switch c.Operator {
+	case GreaterThan:
+		return a > b
+	case GreaterThanOrEqual:
+		return a >= b
+	case LessThan:
+		return a < b
+	case LessThanOrEqual:
+		return a <= b
+	default:
+		return false
+	}
+}
+
+// ValidPath returns true when the given (dot separated) path is valid.
+func ValidPath(path string) bool {
+	return path != "" && !strings.HasPrefix(path, ".") && !strings.HasSuffix(path, ".") && !strings.Contains(path, "..")
+}
+
+// Lookup returns the value of the given (JSON decoded) object at the given
+// (dot separated) path. It returns false when the path does not exist.
+func Lookup(obj interface{}, path string) (interface{}, bool) {
+	return lookup(obj, strings.Split(path, "."))
+}
+
+func lookup(v interface{}, path []string) (interface{}, bool) {
+	if len(path) == 0 {
+		return v, true
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		vv, ok := v[path[0]]
+		if !ok {
+			return nil, false
+		}
+		return lookup(vv, path[1:])
+	case []interface{}:
+		i, err := strconv.Atoi(path[0])
+		if err != nil || i < 0 || i >= len(v) {
+			return nil, false
+		}
+		return lookup(v[i], path[1:])
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,142 @@
+package rule
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Conditions    Conditions
+		ExpectedError string
+	}{
+		{
+			Name: "valid",
+			Conditions: Conditions{
+				{Path: "moisture", Operator: GreaterThan, Value: json.RawMessage(`40`)},
+				{Path: "valve.state", Operator: Equal, Value: json.RawMessage(`"open"`)},
+				{Path: "config_request", Operator: Exists},
+			},
+		},
+		{
+			Name:          "no conditions",
+			ExpectedError: "at least one condition is required",
+		},
+		{
+			Name: "invalid path",
+			Conditions: Conditions{
+				{Path: "valve..state", Operator: Exists},
+			},
+			ExpectedError: "condition 0: invalid path: 'valve..state'",
+		},
+		{
+			Name: "invalid operator",
+			Conditions: Conditions{
+				{Path: "moisture", Operator: "BETWEEN", Value: json.RawMessage(`40`)},
+			},
+			ExpectedError: "condition 0: invalid operator: 'BETWEEN'",
+		},
+		{
+			Name: "non numeric value",
+			Conditions: Conditions{
+				{Path: "moisture", Operator: LessThan, Value: json.RawMessage(`"40"`)},
+			},
+			ExpectedError: "condition 0: value must be a number",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			err := tst.Conditions.Validate()
+			if tst.ExpectedError != "" {
+				assert.EqualError(err, tst.ExpectedError)
+				return
+			}
+			assert.NoError(err)
+		})
+	}
+}
+
+func TestMatchJSON(t *testing.T) {
+	obj := []byte(`{"moisture": 45.5, "valve": {"state": "open"}, "values": [1, 2], "alarm": false}`)
+
+	tests := []struct {
+		Name       string
+		Conditions Conditions
+		Expected   bool
+	}{
+		{
+			Name: "greater than",
+			Conditions: Conditions{
+				{Path: "moisture", Operator: GreaterThan, Value: json.RawMessage(`40`)},
+			},
+			Expected: true,
+		},
+		{
+			Name: "less than or equal",
+			Conditions: Conditions{
+				{Path: "values.1", Operator: LessThanOrEqual, Value: json.RawMessage(`2`)},
+			},
+			Expected: true,
+		},
+		{
+			Name: "equal string",
+			Conditions: Conditions{
+				{Path: "valve.state", Operator: Equal, Value: json.RawMessage(`"open"`)},
+			},
+			Expected: true,
+		},
+		{
+			Name: "not equal bool",
+			Conditions: Conditions{
+				{Path: "alarm", Operator: NotEqual, Value: json.RawMessage(`true`)},
+			},
+			Expected: true,
+		},
+		{
+			Name: "all conditions must match",
+			Conditions: Conditions{
+				{Path: "moisture", Operator: GreaterThan, Value: json.RawMessage(`40`)},
+				{Path: "valve.state", Operator: Equal, Value: json.RawMessage(`"closed"`)},
+			},
+			Expected: false,
+		},
+		{
+			Name: "missing field",
+			Conditions: Conditions{
+				{Path: "temperature", Operator: Exists},
+			},
+			Expected: false,
+		},
+		{
+			Name: "numeric operator on string field",
+			Conditions: Conditions{
+				{Path: "valve.state", Operator: GreaterThan, Value: json.RawMessage(`1`)},
+			},
+			Expected: false,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			ok, err := tst.Conditions.MatchJSON(obj)
+			assert.NoError(err)
+			assert.Equal(tst.Expected, ok)
+		})
+	}
+
+	t.Run("no object", func(t *testing.T) {
+		assert := require.New(t)
+
+		ok, err := Conditions{{Path: "moisture", Operator: Exists}}.MatchJSON(nil)
+		assert.NoError(err)
+		assert.False(ok)
+	})
+}
@@ -0,0 +1,69 @@
+package downlink
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/integration/models"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+// HandleDownlinkRules evaluates the enabled downlink rules of the application
+// against the decoded object of the uplink and enqueues the downlink of each
+// matching rule, unless the rule is in its cooldown period for the device or
+// the max. number of rule triggered downlinks for the device was reached.
+func HandleDownlinkRules(ctx context.Context, applicationID int64, devEUI lorawan.EUI64, fPort uint8, objectJSON []byte) error {
+	if len(objectJSON) == 0 {
+		return nil
+	}
+
+	rules, err := storage.GetEnabledDownlinkRulesForUplink(ctx, storage.DB(), applicationID, fPort)
+	if err != nil {
+		return errors.Wrap(err, "get downlink rules error")
+	}
+
+	for _, r := range rules {
+		ok, err := r.Conditions.MatchJSON(objectJSON)
+		if err != nil {
+			return errors.Wrap(err, "match conditions error")
+		}
+		if !ok {
+			continue
+		}
+
+		ok, err = storage.CheckDownlinkRuleTrigger(ctx, r, devEUI)
+		if err != nil {
+			return errors.Wrap(err, "check downlink rule trigger error")
+		}
+		if !ok {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"downlink_rule_id": r.ID,
+			"dev_eui":          devEUI,
+			"ctx_id":           ctx.Value(logging.ContextIDKey),
+		}).Info("downlink rule triggered")
+
+		if err := handleDataDownPayload(ctx, models.DataDownPayload{
+			ApplicationID: applicationID,
+			DevEUI:        devEUI,
+			Confirmed:     r.Confirmed,
+			FPort:         r.FPort,
+			Object:        json.RawMessage(r.Object),
+		}); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"downlink_rule_id": r.ID,
+				"dev_eui":          devEUI,
+				"ctx_id":           ctx.Value(logging.ContextIDKey),
+			}).Error("enqueue downlink rule payload error")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+// Package template implements the rendering of downlink templates. A template
+// is a JSON object containing {{ name }} placeholders, which are replaced by
+// the given parameter values before the object is passed to the encoder.
+package template
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+var placeholderRegexp = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// Validate validates that the given template is a valid JSON object.
+func Validate(tmpl string) error {
+	v, err := decode([]byte(tmpl))
+	if err != nil {
+		return err
+	}
+
+	if _, ok := v.(map[string]interface{}); !ok {
+		return errors.New("template must be a JSON object")
+	}
+
+	return nil
+}
+
+// Placeholders returns the sorted names of the placeholders used within the
+// given template.
+func Placeholders(tmpl string) ([]string, error) {
+	v, err := decode([]byte(tmpl))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]struct{})
+	walk(v, func(s string) (interface{}, error) {
+		for _, m := range placeholderRegexp.FindAllStringSubmatch(s, -1) {
+			names[m[1]] = struct{}{}
+		}
+		return s, nil
+	})
+
+	out := make([]string, 0, len(names))
+	for name := range names {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+
+	return out, nil
+}
+
+// Render replaces the placeholders of the given template by the given
+// parameter values and returns the resulting JSON object. A string value
+// consisting of only a placeholder is replaced by the parameter value
+// including its type (e.g. a number stays a number), placeholders within
+// a longer string are replaced by the formatted value. All placeholders
+// must be set and unknown parameters are rejected.
+func Render(tmpl string, params map[string]json.RawMessage) ([]byte, error) {
+	v, err := decode([]byte(tmpl))
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := Placeholders(tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]interface{}, len(params))
+	for _, name := range names {
+		raw, ok := params[name]
+		if !ok {
+			return nil, fmt.Errorf("parameter %s is missing", name)
+		}
+
+		pv, err := decode(raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parameter %s", name)
+		}
+		values[name] = pv
+	}
+
+	for name := range params {
+		if _, ok := values[name]; !ok {
+			return nil, fmt.Errorf("parameter %s is not used by the template", name)
+		}
+	}
+
+	out, err := walk(v, func(s string) (interface{}, error) {
+		if m := placeholderRegexp.FindStringSubmatch(s); m != nil && m[0] == s {
+			return values[m[1]], nil
+		}
+
+		var err error
+		s = placeholderRegexp.ReplaceAllStringFunc(s, func(p string) string {
+			val := values[placeholderRegexp.FindStringSubmatch(p)[1]]
+			switch val.(type) {
+			case map[string]interface{}, []interface{}:
+				err = fmt.Errorf("parameter %s must be a scalar value when used within a string", p)
+			}
+			return fmt.Sprintf("%v", val)
+		})
+		return s, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal json error")
+	}
+
+	return b, nil
+}
+
+// decode decodes the given JSON, keeping numbers as json.Number so that these
+// are not altered by a float64 conversion.
+func decode(b []byte) (interface{}, error) {
+	var v interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, errors.Wrap(err, "decode json error")
+	}
+
+	return v, nil
+}
+
+// walk calls fn for every string value of v and replaces the value by the
+// returned value.
+func walk(v interface{}, fn func(string) (interface{}, error)) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, vv := range v {
+			out, err := walk(vv, fn)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = out
+		}
+		return v, nil
+	case []interface{}:
+		for i, vv := range v {
+			out, err := walk(vv, fn)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = out
+		}
+		return v, nil
+	case string:
+		return fn(v)
+	default:
+		return v, nil
+	}
+}
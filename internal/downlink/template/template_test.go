@@ -0,0 +1,104 @@
+package template
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	assert := require.New(t)
+
+	assert.NoError(Validate(`{"interval": "{{ interval }}"}`))
+	assert.Error(Validate(`[1, 2, 3]`))
+	assert.Error(Validate(`{"interval": `))
+}
+
+func TestPlaceholders(t *testing.T) {
+	assert := require.New(t)
+
+	names, err := Placeholders(`{"a": "{{ b }}", "c": ["{{a}}", "x {{ b }} y"], "d": {"e": "{{ c_1 }}"}}`)
+	assert.NoError(err)
+	assert.Equal([]string{"a", "b", "c_1"}, names)
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Template      string
+		Params        map[string]json.RawMessage
+		Expected      string
+		ExpectedError string
+	}{
+		{
+			Name:     "no placeholders",
+			Template: `{"reset": true}`,
+			Expected: `{"reset":true}`,
+		},
+		{
+			Name:     "typed values",
+			Template: `{"interval": "{{ interval }}", "enabled": "{{enabled}}", "config": "{{ config }}"}`,
+			Params: map[string]json.RawMessage{
+				"interval": json.RawMessage(`3600`),
+				"enabled":  json.RawMessage(`true`),
+				"config":   json.RawMessage(`{"a": [1, 2]}`),
+			},
+			Expected: `{"config":{"a":[1,2]},"enabled":true,"interval":3600}`,
+		},
+		{
+			Name:     "placeholder within string",
+			Template: `{"cmd": "set {{ key }}={{ value }}"}`,
+			Params: map[string]json.RawMessage{
+				"key":   json.RawMessage(`"interval"`),
+				"value": json.RawMessage(`12.5`),
+			},
+			Expected: `{"cmd":"set interval=12.5"}`,
+		},
+		{
+			Name:     "nested arrays",
+			Template: `{"relays": [{"id": 1, "on": "{{ on }}"}]}`,
+			Params: map[string]json.RawMessage{
+				"on": json.RawMessage(`false`),
+			},
+			Expected: `{"relays":[{"id":1,"on":false}]}`,
+		},
+		{
+			Name:          "missing parameter",
+			Template:      `{"interval": "{{ interval }}"}`,
+			ExpectedError: "parameter interval is missing",
+		},
+		{
+			Name:     "unknown parameter",
+			Template: `{"interval": "{{ interval }}"}`,
+			Params: map[string]json.RawMessage{
+				"interval": json.RawMessage(`10`),
+				"foo":      json.RawMessage(`10`),
+			},
+			ExpectedError: "parameter foo is not used by the template",
+		},
+		{
+			Name:     "object within string",
+			Template: `{"cmd": "set {{ value }}"}`,
+			Params: map[string]json.RawMessage{
+				"value": json.RawMessage(`{"a": 1}`),
+			},
+			ExpectedError: "parameter {{ value }} must be a scalar value when used within a string",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			b, err := Render(tst.Template, tst.Params)
+			if tst.ExpectedError != "" {
+				assert.EqualError(err, tst.ExpectedError)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(tst.Expected, string(b))
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package downlink
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+
+	"github.com/ibrahimozekici/app-server2/internal/downlink/validation"
+	"github.com/ibrahimozekici/app-server2/internal/region"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// ValidateObject validates the given object (JSON) using the downlink
+// validation rules of the given device-profile, before it is encoded.
+func ValidateObject(dp storage.DeviceProfile, fPort uint8, object []byte) error {
+	return validation.Validate(dp.DownlinkValidation, validation.Downlink{
+		FPort:  fPort,
+		Object: object,
+	})
+}
+
+// ValidatePayload validates the given (encoded) payload using the downlink
+// validation rules of the given device-profile. Unless overridden by the
+// rules, the max. payload size is based on the region of the network-server
+// and the data-rate of the last uplink of the device.
+func ValidatePayload(ctx context.Context, db sqlx.Queryer, dp storage.DeviceProfile, d storage.Device, fPort uint8, data []byte) error {
+	var maxPayloadSize int
+	if validation.IsEnabled(validation.MaxPayloadSize) && dp.DownlinkValidation.MaxPayloadSize == 0 && d.DR != nil {
+		b, err := region.GetBand(ctx, db, dp.NetworkServerID)
+		if err != nil {
+			return errors.Wrap(err, "get band error")
+		}
+
+		size, err := b.GetMaxPayloadSizeForDataRateIndex(dp.DeviceProfile.MacVersion, dp.DeviceProfile.RegParamsRevision, *d.DR)
+		if err != nil {
+			return errors.Wrap(err, "get max. payload size error")
+		}
+		maxPayloadSize = size.N
+	}
+
+	if data == nil {
+		data = []byte{}
+	}
+
+	return validation.Validate(dp.DownlinkValidation, validation.Downlink{
+		FPort:          fPort,
+		Data:           data,
+		MaxPayloadSize: maxPayloadSize,
+	})
+}
@@ -0,0 +1,223 @@
+package validation
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// schema implements a subset of JSON schema.
+type schema struct {
+	Type                 schemaType         `json:"type"`
+	Enum                 []interface{}      `json:"enum"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *schema            `json:"items"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	MinItems             *int               `json:"minItems"`
+	MaxItems             *int               `json:"maxItems"`
+}
+
+// schemaType holds one or multiple types, as the type keyword can be
+// either a string or an array of strings.
+type schemaType []string
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (t *schemaType) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*t = schemaType{s}
+		return nil
+	}
+
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return errors.New("type must be a string or an array of strings")
+	}
+	*t = ss
+	return nil
+}
+
+func parseSchema(b []byte) (*schema, error) {
+	var s schema
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// check validates the schema itself.
+func (s *schema) check() error {
+	for _, t := range s.Type {
+		switch t {
+		case "object", "array", "string", "number", "integer", "boolean", "null":
+		default:
+			return fmt.Errorf("unknown type: %s", t)
+		}
+	}
+
+	for name, p := range s.Properties {
+		if p == nil {
+			return fmt.Errorf("properties.%s: must be an object", name)
+		}
+		if err := p.check(); err != nil {
+			return errors.Wrapf(err, "properties.%s", name)
+		}
+	}
+
+	if s.Items != nil {
+		if err := s.Items.check(); err != nil {
+			return errors.Wrap(err, "items")
+		}
+	}
+
+	return nil
+}
+
+// validate validates the given value, path is used in the returned error.
+func (s *schema) validate(path string, v interface{}) error {
+	if len(s.Type) != 0 {
+		var match bool
+		for _, t := range s.Type {
+			if typeMatches(t, v) {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return fmt.Errorf("%s: expected type %s, got %s", path, joinTypes(s.Type), typeOf(v))
+		}
+	}
+
+	if len(s.Enum) != 0 {
+		var match bool
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(e, v) {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return fmt.Errorf("%s: value must be one of %v", path, s.Enum)
+		}
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing required property '%s'", path, name)
+			}
+		}
+
+		// sort the keys to return a predictable error
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			p, ok := s.Properties[k]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s: property '%s' is not allowed", path, k)
+				}
+				continue
+			}
+			if err := p.validate(path+"."+k, v[k]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			return fmt.Errorf("%s: must contain at least %d items", path, *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			return fmt.Errorf("%s: must contain at most %d items", path, *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		l := utf8.RuneCountInString(v)
+		if s.MinLength != nil && l < *s.MinLength {
+			return fmt.Errorf("%s: must be at least %d characters", path, *s.MinLength)
+		}
+		if s.MaxLength != nil && l > *s.MaxLength {
+			return fmt.Errorf("%s: must be at most %d characters", path, *s.MaxLength)
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			return fmt.Errorf("%s: must be greater than or equal to %v", path, *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			return fmt.Errorf("%s: must be less than or equal to %v", path, *s.Maximum)
+		}
+	}
+
+	return nil
+}
+
+func typeMatches(t string, v interface{}) bool {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return t == "object"
+	case []interface{}:
+		return t == "array"
+	case string:
+		return t == "string"
+	case float64:
+		return t == "number" || (t == "integer" && v == math.Trunc(v))
+	case bool:
+		return t == "boolean"
+	case nil:
+		return t == "null"
+	default:
+		return false
+	}
+}
+
+func typeOf(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func joinTypes(t schemaType) string {
+	if len(t) == 1 {
+		return t[0]
+	}
+	return fmt.Sprintf("%v", []string(t))
+}
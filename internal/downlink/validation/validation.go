@@ -0,0 +1,238 @@
+// Package validation implements the validation of downlink payloads before
+// these are enqueued, such that invalid downlinks are rejected with an
+// actionable error instead of failing later at the network-server.
+package validation
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+// Validator names.
+const (
+	FPort          = "fport"
+	Schema         = "schema"
+	MaxPayloadSize = "max_payload_size"
+)
+
+// Validator validates the given downlink using the given rules.
+type Validator func(r Rules, dl Downlink) error
+
+var validators = map[string]Validator{
+	FPort:          validateFPort,
+	Schema:         validateSchema,
+	MaxPayloadSize: validateMaxPayloadSize,
+}
+
+// enabled contains the enabled validators, in the order of execution.
+var enabled = []string{FPort, Schema, MaxPayloadSize}
+
+// Setup configures the enabled validators.
+func Setup(conf config.Config) error {
+	names := conf.ApplicationServer.DownlinkValidation.Validators
+
+	for _, name := range names {
+		if _, ok := validators[name]; !ok {
+			return fmt.Errorf("unknown downlink validator: %s", name)
+		}
+	}
+
+	enabled = names
+	return nil
+}
+
+// IsEnabled returns true when the given validator is enabled.
+func IsEnabled(name string) bool {
+	for _, n := range enabled {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Error is returned when the downlink did not pass one of the validators.
+type Error struct {
+	// Validator contains the name of the validator.
+	Validator string
+
+	// Message contains the reason why the downlink was rejected.
+	Message string
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Validator, e.Message)
+}
+
+// IsError returns true when the (cause of the) given error is a validation
+// Error.
+func IsError(err error) bool {
+	_, ok := errors.Cause(err).(*Error)
+	return ok
+}
+
+// Rules defines the downlink validation rules of a device-profile.
+type Rules struct {
+	// FPorts contains the allowed fPorts. When empty, all fPorts are allowed.
+	FPorts []int `json:"fPorts,omitempty"`
+
+	// Schema contains the JSON schema of the object to encode. Only the
+	// type, enum, properties, required, additionalProperties, items,
+	// minimum, maximum, minLength, maxLength, minItems and maxItems
+	// keywords are supported.
+	Schema json.RawMessage `json:"schema,omitempty"`
+
+	// MaxPayloadSize overrides the max. payload size (bytes) as defined
+	// by the region and data-rate of the device.
+	MaxPayloadSize int `json:"maxPayloadSize,omitempty"`
+}
+
+// Validate validates the rules.
+func (r Rules) Validate() error {
+	for _, p := range r.FPorts {
+		if p < 1 || p > 223 {
+			return fmt.Errorf("fPorts: invalid fPort: %d", p)
+		}
+	}
+
+	if len(r.Schema) != 0 {
+		if _, err := parseSchema(r.Schema); err != nil {
+			return errors.Wrap(err, "schema")
+		}
+	}
+
+	if r.MaxPayloadSize < 0 {
+		return errors.New("maxPayloadSize: must be greater than or equal to 0")
+	}
+
+	return nil
+}
+
+// Value implements the driver.Valuer interface.
+func (r Rules) Value() (driver.Value, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (r *Rules) Scan(src interface{}) error {
+	var b []byte
+	switch src := src.(type) {
+	case []byte:
+		b = src
+	case string:
+		b = []byte(src)
+	case nil:
+		*r = Rules{}
+		return nil
+	default:
+		return fmt.Errorf("expected []byte, got %T", src)
+	}
+
+	return json.Unmarshal(b, r)
+}
+
+// Downlink holds the downlink to validate. Validators are skipped when the
+// data they validate is not set, e.g. the schema validator is skipped when
+// Object is not set, such that Validate can be called before and after
+// encoding the object.
+type Downlink struct {
+	// FPort of the downlink.
+	FPort uint8
+
+	// Object to encode (JSON).
+	Object []byte
+
+	// Data contains the (encoded) payload.
+	Data []byte
+
+	// MaxPayloadSize contains the max. payload size (bytes) for the region
+	// and data-rate of the device (0 = unknown).
+	MaxPayloadSize int
+}
+
+// Validate runs the enabled validators. It returns an *Error when the
+// downlink does not pass one of the validators.
+func Validate(r Rules, dl Downlink) error {
+	for _, name := range enabled {
+		if err := validators[name](r, dl); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateFPort(r Rules, dl Downlink) error {
+	if len(r.FPorts) == 0 {
+		return nil
+	}
+
+	for _, p := range r.FPorts {
+		if p == int(dl.FPort) {
+			return nil
+		}
+	}
+
+	return &Error{
+		Validator: FPort,
+		Message:   fmt.Sprintf("fPort %d is not allowed, allowed fPorts are %v", dl.FPort, r.FPorts),
+	}
+}
+
+func validateSchema(r Rules, dl Downlink) error {
+	if len(r.Schema) == 0 || dl.Object == nil {
+		return nil
+	}
+
+	s, err := parseSchema(r.Schema)
+	if err != nil {
+		return errors.Wrap(err, "parse schema error")
+	}
+
+	var obj interface{}
+	if err := json.Unmarshal(dl.Object, &obj); err != nil {
+		return &Error{
+			Validator: Schema,
+			Message:   fmt.Sprintf("invalid JSON: %s", err),
+		}
+	}
+
+	if err := s.validate("object", obj); err != nil {
+		return &Error{
+			Validator: Schema,
+			Message:   err.Error(),
+		}
+	}
+
+	return nil
+}
+
+func validateMaxPayloadSize(r Rules, dl Downlink) error {
+	if dl.Data == nil {
+		return nil
+	}
+
+	max := dl.MaxPayloadSize
+	if r.MaxPayloadSize != 0 {
+		max = r.MaxPayloadSize
+	}
+
+	if max == 0 || len(dl.Data) <= max {
+		return nil
+	}
+
+	return &Error{
+		Validator: MaxPayloadSize,
+		Message:   fmt.Sprintf("payload size of %d bytes exceeds the max. payload size of %d bytes", len(dl.Data), max),
+	}
+}
@@ -0,0 +1,209 @@
+package validation
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+func TestValidate(t *testing.T) {
+	rules := Rules{
+		FPorts: []int{10, 20},
+		Schema: json.RawMessage(`{
+			"type": "object",
+			"required": ["mode"],
+			"additionalProperties": false,
+			"properties": {
+				"mode": {"type": "string", "enum": ["eco", "comfort"]},
+				"setpoint": {"type": "number", "minimum": 5, "maximum": 30},
+				"interval": {"type": "integer"},
+				"label": {"type": "string", "maxLength": 4},
+				"schedule": {"type": "array", "maxItems": 2, "items": {"type": "integer"}}
+			}
+		}`),
+	}
+
+	tests := []struct {
+		Name          string
+		Rules         Rules
+		Downlink      Downlink
+		ExpectedError string
+	}{
+		{
+			Name:     "valid object",
+			Rules:    rules,
+			Downlink: Downlink{FPort: 10, Object: []byte(`{"mode": "eco", "setpoint": 21.5, "interval": 60, "schedule": [1, 2]}`)},
+		},
+		{
+			Name:          "fPort not allowed",
+			Rules:         rules,
+			Downlink:      Downlink{FPort: 1, Data: []byte{1}},
+			ExpectedError: "fport: fPort 1 is not allowed, allowed fPorts are [10 20]",
+		},
+		{
+			Name:          "missing required property",
+			Rules:         rules,
+			Downlink:      Downlink{FPort: 10, Object: []byte(`{"setpoint": 21.5}`)},
+			ExpectedError: "schema: object: missing required property 'mode'",
+		},
+		{
+			Name:          "enum mismatch",
+			Rules:         rules,
+			Downlink:      Downlink{FPort: 10, Object: []byte(`{"mode": "boost"}`)},
+			ExpectedError: "schema: object.mode: value must be one of [eco comfort]",
+		},
+		{
+			Name:          "maximum exceeded",
+			Rules:         rules,
+			Downlink:      Downlink{FPort: 10, Object: []byte(`{"mode": "eco", "setpoint": 35}`)},
+			ExpectedError: "schema: object.setpoint: must be less than or equal to 30",
+		},
+		{
+			Name:          "not an integer",
+			Rules:         rules,
+			Downlink:      Downlink{FPort: 10, Object: []byte(`{"mode": "eco", "interval": 1.5}`)},
+			ExpectedError: "schema: object.interval: expected type integer, got number",
+		},
+		{
+			Name:          "max length exceeded",
+			Rules:         rules,
+			Downlink:      Downlink{FPort: 10, Object: []byte(`{"mode": "eco", "label": "living"}`)},
+			ExpectedError: "schema: object.label: must be at most 4 characters",
+		},
+		{
+			Name:          "invalid array item",
+			Rules:         rules,
+			Downlink:      Downlink{FPort: 10, Object: []byte(`{"mode": "eco", "schedule": [1, "2"]}`)},
+			ExpectedError: "schema: object.schedule[1]: expected type integer, got string",
+		},
+		{
+			Name:          "additional property",
+			Rules:         rules,
+			Downlink:      Downlink{FPort: 10, Object: []byte(`{"mode": "eco", "foo": 1}`)},
+			ExpectedError: "schema: object: property 'foo' is not allowed",
+		},
+		{
+			Name:     "schema is not used for raw payloads",
+			Rules:    rules,
+			Downlink: Downlink{FPort: 10, Data: []byte{1, 2, 3}},
+		},
+		{
+			Name:     "max payload size",
+			Downlink: Downlink{FPort: 1, Data: make([]byte, 51), MaxPayloadSize: 51},
+		},
+		{
+			Name:          "max payload size exceeded",
+			Downlink:      Downlink{FPort: 1, Data: make([]byte, 52), MaxPayloadSize: 51},
+			ExpectedError: "max_payload_size: payload size of 52 bytes exceeds the max. payload size of 51 bytes",
+		},
+		{
+			Name:          "max payload size override",
+			Rules:         Rules{MaxPayloadSize: 10},
+			Downlink:      Downlink{FPort: 1, Data: make([]byte, 11), MaxPayloadSize: 51},
+			ExpectedError: "max_payload_size: payload size of 11 bytes exceeds the max. payload size of 10 bytes",
+		},
+		{
+			Name:     "unknown max payload size",
+			Downlink: Downlink{FPort: 1, Data: make([]byte, 242)},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			err := Validate(tst.Rules, tst.Downlink)
+			if tst.ExpectedError != "" {
+				assert.EqualError(err, tst.ExpectedError)
+				assert.True(IsError(err))
+				return
+			}
+			assert.NoError(err)
+		})
+	}
+}
+
+func TestRulesValidate(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Rules         Rules
+		ExpectedError string
+	}{
+		{
+			Name: "valid",
+			Rules: Rules{
+				FPorts:         []int{1, 223},
+				Schema:         json.RawMessage(`{"type": ["object", "null"]}`),
+				MaxPayloadSize: 11,
+			},
+		},
+		{
+			Name:          "invalid fPort",
+			Rules:         Rules{FPorts: []int{0}},
+			ExpectedError: "fPorts: invalid fPort: 0",
+		},
+		{
+			Name:          "unknown schema type",
+			Rules:         Rules{Schema: json.RawMessage(`{"properties": {"a": {"type": "float"}}}`)},
+			ExpectedError: "schema: properties.a: unknown type: float",
+		},
+		{
+			Name:          "invalid max payload size",
+			Rules:         Rules{MaxPayloadSize: -1},
+			ExpectedError: "maxPayloadSize: must be greater than or equal to 0",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			err := tst.Rules.Validate()
+			if tst.ExpectedError != "" {
+				assert.EqualError(err, tst.ExpectedError)
+				return
+			}
+			assert.NoError(err)
+		})
+	}
+}
+
+func TestSetup(t *testing.T) {
+	assert := require.New(t)
+
+	defer func() {
+		enabled = []string{FPort, Schema, MaxPayloadSize}
+	}()
+
+	var conf config.Config
+	conf.ApplicationServer.DownlinkValidation.Validators = []string{FPort}
+	assert.NoError(Setup(conf))
+	assert.True(IsEnabled(FPort))
+	assert.False(IsEnabled(MaxPayloadSize))
+
+	// the disabled size validator must not reject the payload
+	assert.NoError(Validate(Rules{}, Downlink{FPort: 1, Data: make([]byte, 52), MaxPayloadSize: 51}))
+
+	conf.ApplicationServer.DownlinkValidation.Validators = []string{"foo"}
+	assert.EqualError(Setup(conf), "unknown downlink validator: foo")
+}
+
+func TestScanValue(t *testing.T) {
+	assert := require.New(t)
+
+	r := Rules{
+		FPorts:         []int{10},
+		Schema:         json.RawMessage(`{"type":"object"}`),
+		MaxPayloadSize: 11,
+	}
+
+	v, err := r.Value()
+	assert.NoError(err)
+
+	var out Rules
+	assert.NoError(out.Scan([]byte(v.(string))))
+	assert.Equal(r, out)
+}
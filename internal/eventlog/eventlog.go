@@ -3,7 +3,8 @@ package eventlog
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"sync"
+	"time"
 
 	"github.com/go-redis/redis/v7"
 	"github.com/golang/protobuf/proto"
@@ -17,6 +18,18 @@ import (
 
 const (
 	deviceEventUplinkPubSubKeyTempl = "lora:as:device:%s:pubsub:event"
+
+	// publishQueueSize defines the max. number of device events waiting to
+	// be published.
+	publishQueueSize = 1000
+
+	// publishBatchSize defines the max. number of device events published
+	// using a single pipeline.
+	publishBatchSize = 100
+
+	// publishQueueTimeout defines the max. time to wait for space in the
+	// publish queue, before the device event is dropped.
+	publishQueueTimeout = 100 * time.Millisecond
 )
 
 // Event types.
@@ -37,7 +50,91 @@ type EventLog struct {
 	Payload json.RawMessage
 }
 
-// LogEventForDevice logs an event for the given device.
+// ErrPublishQueueFull is returned when the device event could not be queued
+// within publishQueueTimeout. The event has been dropped.
+var ErrPublishQueueFull = errors.New("eventlog: publish queue is full")
+
+var (
+	pubMu sync.RWMutex
+	pub   *publisher
+)
+
+// publisher publishes the queued device events in order, using a single
+// pipeline for the events queued while publishing the previous batch.
+type publisher struct {
+	queue    chan publishMessage
+	doneChan chan struct{}
+}
+
+type publishMessage struct {
+	key     string
+	payload []byte
+
+	// flushed is set for flush requests. It receives the first publish
+	// error since the previous flush request, once all the events queued
+	// before the flush request have been published.
+	flushed chan error
+}
+
+// Setup starts the publish loop. Until Setup is called (and after Close),
+// the device events are published directly.
+func Setup() error {
+	pubMu.Lock()
+	defer pubMu.Unlock()
+
+	if pub != nil {
+		return nil
+	}
+
+	pub = &publisher{
+		queue:    make(chan publishMessage, publishQueueSize),
+		doneChan: make(chan struct{}),
+	}
+	go pub.loop()
+
+	return nil
+}
+
+// Flush blocks until the device events queued before calling Flush have
+// been published. It returns the first publish error since the previous
+// flush.
+func Flush() error {
+	pubMu.RLock()
+	defer pubMu.RUnlock()
+
+	if pub == nil {
+		return nil
+	}
+
+	return pub.flush()
+}
+
+// Close publishes the queued device events and stops the publish loop. It
+// returns the first publish error since the previous flush. Device events
+// logged while closing wait until the queue has been drained, these and the
+// events logged after Close are published directly.
+func Close() error {
+	pubMu.Lock()
+	defer pubMu.Unlock()
+
+	if pub == nil {
+		return nil
+	}
+
+	err := pub.flush()
+	close(pub.queue)
+	<-pub.doneChan
+	pub = nil
+
+	return err
+}
+
+// LogEventForDevice logs an event for the given device. When the publish
+// loop is running, the event is queued and published in order with the
+// other events. When the queue is full, this blocks for max.
+// publishQueueTimeout, after which the event is dropped and
+// ErrPublishQueueFull is returned. Use Flush to wait for the queued events
+// to be published.
 func LogEventForDevice(devEUI lorawan.EUI64, t string, msg proto.Message) error {
 	b, err := marshaler.Marshal(marshaler.ProtobufJSON, msg)
 	if err != nil {
@@ -49,15 +146,110 @@ func LogEventForDevice(devEUI lorawan.EUI64, t string, msg proto.Message) error
 		Payload: json.RawMessage(b),
 	}
 
-	key := fmt.Sprintf(deviceEventUplinkPubSubKeyTempl, devEUI)
+	key := storage.GetRedisKey(deviceEventUplinkPubSubKeyTempl, devEUI)
 	b, err = json.Marshal(el)
 	if err != nil {
 		return errors.Wrap(err, "json encode error")
 	}
 
-	err = storage.RedisClient().Publish(key, b).Err()
-	if err != nil {
-		return errors.Wrap(err, "publish device event error")
+	pubMu.RLock()
+	defer pubMu.RUnlock()
+
+	if pub == nil {
+		if err := storage.RedisClient().Publish(key, b).Err(); err != nil {
+			return errors.Wrap(err, "publish device event error")
+		}
+		return nil
+	}
+
+	return pub.enqueue(publishMessage{key: key, payload: b})
+}
+
+func (p *publisher) enqueue(msg publishMessage) error {
+	select {
+	case p.queue <- msg:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(publishQueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case p.queue <- msg:
+		return nil
+	case <-timer.C:
+		publishDroppedCounter("queue_full").Inc()
+		return ErrPublishQueueFull
+	}
+}
+
+func (p *publisher) flush() error {
+	flushed := make(chan error, 1)
+	p.queue <- publishMessage{flushed: flushed}
+	return <-flushed
+}
+
+// loop publishes the queued device events until the queue has been closed.
+// Under load, the events are published in batches, reducing the number of
+// Redis round-trips without delaying the events when there is no load.
+func (p *publisher) loop() {
+	defer close(p.doneChan)
+
+	var flushErr error
+
+	for msg := range p.queue {
+		batch := []publishMessage{msg}
+
+	batch:
+		for msg.flushed == nil && len(batch) < publishBatchSize {
+			select {
+			case m, ok := <-p.queue:
+				if !ok {
+					break batch
+				}
+				batch = append(batch, m)
+				msg = m
+			default:
+				break batch
+			}
+		}
+
+		if err := publishBatch(batch); err != nil {
+			log.WithError(err).Error("eventlog: publish device events error")
+			if flushErr == nil {
+				flushErr = err
+			}
+		}
+
+		if msg.flushed != nil {
+			msg.flushed <- flushErr
+			flushErr = nil
+		}
+	}
+}
+
+// publishBatch publishes the device events of the given batch, skipping
+// the flush requests.
+func publishBatch(batch []publishMessage) error {
+	pipe := storage.RedisClient().Pipeline()
+
+	var n int
+	for _, msg := range batch {
+		if msg.flushed != nil {
+			continue
+		}
+		pipe.Publish(msg.key, msg.payload)
+		n++
+	}
+
+	if n == 0 {
+		return nil
+	}
+
+	if _, err := pipe.Exec(); err != nil {
+		publishDroppedCounter("publish_error").Add(float64(n))
+		return errors.Wrap(err, "publish device events error")
 	}
 
 	return nil
@@ -66,7 +258,7 @@ func LogEventForDevice(devEUI lorawan.EUI64, t string, msg proto.Message) error
 // GetEventLogForDevice subscribes to the device events for the given DevEUI
 // and sends this to the given channel.
 func GetEventLogForDevice(ctx context.Context, devEUI lorawan.EUI64, eventsChan chan EventLog) error {
-	key := fmt.Sprintf(deviceEventUplinkPubSubKeyTempl, devEUI)
+	key := storage.GetRedisKey(deviceEventUplinkPubSubKeyTempl, devEUI)
 
 	sub := storage.RedisClient().Subscribe(key)
 	_, err := sub.Receive()
@@ -96,6 +288,41 @@ func GetEventLogForDevice(ctx context.Context, devEUI lorawan.EUI64, eventsChan
 	}
 }
 
+// SubscriptionStats contains the device event-log subscription statistics.
+type SubscriptionStats struct {
+	// Devices holds the number of devices with at least one subscriber.
+	Devices int
+	// Subscribers holds the total number of subscribers.
+	Subscribers int64
+}
+
+// GetSubscriptionStats returns the device event-log subscription statistics.
+// As the event-log is implemented using Redis Pub/Sub, events are not
+// buffered and the number of subscribers reflects the fan-out per event.
+func GetSubscriptionStats() (SubscriptionStats, error) {
+	var out SubscriptionStats
+
+	channels, err := storage.RedisClient().PubSubChannels(storage.GetRedisKey(deviceEventUplinkPubSubKeyTempl, "*")).Result()
+	if err != nil {
+		return out, errors.Wrap(err, "get pubsub channels error")
+	}
+	if len(channels) == 0 {
+		return out, nil
+	}
+
+	numSub, err := storage.RedisClient().PubSubNumSub(channels...).Result()
+	if err != nil {
+		return out, errors.Wrap(err, "get pubsub subscribers error")
+	}
+
+	out.Devices = len(channels)
+	for _, n := range numSub {
+		out.Subscribers += n
+	}
+
+	return out, nil
+}
+
 func redisMessageToEventLog(msg *redis.Message) (EventLog, error) {
 	var el EventLog
 	if err := json.Unmarshal([]byte(msg.Payload), &el); err != nil {
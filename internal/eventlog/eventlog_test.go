@@ -60,5 +60,62 @@ func TestEventLog(t *testing.T) {
 			assert.Equal(Uplink, el.Type)
 			assert.True(proto.Equal(&upEvent, &pl))
 		})
+
+		t.Run("Publisher", func(t *testing.T) {
+			assert := require.New(t)
+			assert.NoError(Setup())
+
+			events := []pb.UplinkEvent{
+				{Data: []byte{0x01}},
+				{Data: []byte{0x02}},
+				{Data: []byte{0x03}},
+			}
+			for i := range events {
+				assert.NoError(LogEventForDevice(devEUI, Uplink, &events[i]))
+			}
+			assert.NoError(Flush())
+
+			for i := range events {
+				el := <-logChannel
+
+				var pl pb.UplinkEvent
+				assert.NoError(jsonpb.Unmarshal(bytes.NewReader(el.Payload), &pl))
+				assert.True(proto.Equal(&events[i], &pl))
+			}
+
+			t.Run("Close", func(t *testing.T) {
+				assert := require.New(t)
+
+				assert.NoError(LogEventForDevice(devEUI, Uplink, &upEvent))
+				assert.NoError(Close())
+				assert.Nil(pub)
+
+				el := <-logChannel
+				assert.Equal(Uplink, el.Type)
+
+				// published directly after close
+				assert.NoError(LogEventForDevice(devEUI, Uplink, &upEvent))
+				el = <-logChannel
+				assert.Equal(Uplink, el.Type)
+			})
+
+			t.Run("Queue full", func(t *testing.T) {
+				assert := require.New(t)
+
+				// a publisher without publish loop and queue capacity
+				pub = &publisher{queue: make(chan publishMessage)}
+				defer func() { pub = nil }()
+
+				assert.Equal(ErrPublishQueueFull, LogEventForDevice(devEUI, Uplink, &upEvent))
+			})
+		})
+
+		t.Run("GetSubscriptionStats", func(t *testing.T) {
+			assert := require.New(t)
+
+			stats, err := GetSubscriptionStats()
+			assert.NoError(err)
+			assert.Equal(SubscriptionStats{Devices: 1, Subscribers: 1}, stats)
+		})
 	})
 }
@@ -0,0 +1,17 @@
+package eventlog
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	pd = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlog_publish_dropped_count",
+		Help: "The number of device events dropped by the event-log publisher (per reason: queue_full or publish_error).",
+	}, []string{"reason"})
+)
+
+func publishDroppedCounter(reason string) prometheus.Counter {
+	return pd.With(prometheus.Labels{"reason": reason})
+}
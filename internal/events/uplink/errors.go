@@ -4,5 +4,6 @@ import "errors"
 
 // Errors.
 var (
-	ErrAbort = errors.New("abort")
+	ErrAbort     = errors.New("abort")
+	ErrQueueFull = errors.New("uplink pipeline queue is full")
 )
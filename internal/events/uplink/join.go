@@ -0,0 +1,129 @@
+package uplink
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	pb //"github.com/ibrahimozekici/lora-api/go/v3/as/integration"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/integration"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+const (
+	joinIntegrationName  = "join"
+	joinSessionEventType = "session"
+)
+
+// joinSession contains the session metadata of a (re)activated device,
+// which is not part of the JoinEvent.
+type joinSession struct {
+	DevAddr            lorawan.DevAddr `json:"devAddr"`
+	DeviceProfileID    string          `json:"deviceProfileID"`
+	DeviceProfileName  string          `json:"deviceProfileName"`
+	MACVersion         string          `json:"macVersion"`
+	Region             string          `json:"region,omitempty"`
+	DR                 uint32          `json:"dr"`
+	Frequency          uint32          `json:"frequency,omitempty"`
+	JoinRequestAt      *time.Time      `json:"joinRequestAt,omitempty"`
+	JoinLatencySeconds *float64        `json:"joinLatencySeconds,omitempty"`
+}
+
+// sendJoinSessionEvent sends the session metadata of the activated device
+// as integration event, directly after the JoinEvent. The join latency is
+// the time between the join-request handled by the join-server and the
+// first uplink of the new session.
+func sendJoinSessionEvent(ctx *uplinkContext, vars map[string]string) error {
+	now := time.Now()
+
+	js := joinSession{
+		DevAddr:           ctx.device.DevAddr,
+		DeviceProfileID:   ctx.device.DeviceProfileID.String(),
+		DeviceProfileName: ctx.deviceProfile.Name,
+		MACVersion:        ctx.deviceProfile.DeviceProfile.MacVersion,
+		Region:            getRegion(ctx),
+		DR:                ctx.uplinkDataReq.Dr,
+	}
+
+	if txInfo := ctx.uplinkDataReq.TxInfo; txInfo != nil {
+		js.Frequency = txInfo.Frequency
+	}
+
+	joinRequestAt, err := storage.GetDeviceJoinRequestTime(ctx.ctx, ctx.device.DevEUI)
+	if err != nil && err != storage.ErrDoesNotExist {
+		log.WithError(err).WithFields(log.Fields{
+			"dev_eui": ctx.device.DevEUI,
+			"ctx_id":  ctx.ctx.Value(logging.ContextIDKey),
+		}).Error("get join-request time error")
+	}
+	if err == nil {
+		latency := now.Sub(joinRequestAt).Seconds()
+		js.JoinRequestAt = &joinRequestAt
+		js.JoinLatencySeconds = &latency
+
+		if err := storage.DeleteDeviceJoinRequestTime(ctx.ctx, ctx.device.DevEUI); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"dev_eui": ctx.device.DevEUI,
+				"ctx_id":  ctx.ctx.Value(logging.ContextIDKey),
+			}).Error("delete join-request time error")
+		}
+	}
+
+	b, err := json.Marshal(js)
+	if err != nil {
+		return errors.Wrap(err, "marshal json error")
+	}
+
+	pl := pb.IntegrationEvent{
+		ApplicationId:   uint64(ctx.device.ApplicationID),
+		ApplicationName: ctx.application.Name,
+		DeviceName:      ctx.device.Name,
+		DevEui:          ctx.device.DevEUI[:],
+		Tags:            make(map[string]string),
+		IntegrationName: joinIntegrationName,
+		EventType:       joinSessionEventType,
+		ObjectJson:      string(b),
+	}
+
+	for k, v := range ctx.device.Tags.Map {
+		if v.Valid {
+			pl.Tags[k] = v.String
+		}
+	}
+
+	if err := integration.ForApplicationID(ctx.device.ApplicationID).HandleIntegrationEvent(ctx.ctx, vars, pl); err != nil {
+		return errors.Wrap(err, "send integration event error")
+	}
+
+	return nil
+}
+
+// getRegion returns the region of the network-server of the device. An empty
+// string is returned on error, as the region is informational only.
+func getRegion(ctx *uplinkContext) string {
+	n, err := storage.GetNetworkServer(ctx.ctx, storage.DB(), ctx.deviceProfile.NetworkServerID)
+	if err != nil {
+		log.WithError(err).WithField("ctx_id", ctx.ctx.Value(logging.ContextIDKey)).Warning("get network-server error")
+		return ""
+	}
+
+	nsClient, err := networkserver.GetPool().Get(n.Server, []byte(n.CACert), []byte(n.TLSCert), []byte(n.TLSKey))
+	if err != nil {
+		log.WithError(err).WithField("ctx_id", ctx.ctx.Value(logging.ContextIDKey)).Warning("get network-server client error")
+		return ""
+	}
+
+	resp, err := nsClient.GetVersion(ctx.ctx, &empty.Empty{})
+	if err != nil {
+		log.WithError(err).WithField("ctx_id", ctx.ctx.Value(logging.ContextIDKey)).Warning("get network-server version error")
+		return ""
+	}
+
+	return resp.Region.String()
+}
@@ -0,0 +1,185 @@
+package uplink
+
+import (
+	"context"
+	"hash/fnv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	// "github.com/ibrahimozekici/lora-api/go/v3/as"
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	"github.com/ibrahimozekici/app-server2/internal/tracing"
+	//"github.com/brocaar/lorawan"
+)
+
+// pipelineStage holds the queues of the workers of a stage of the uplink
+// pipeline.
+type pipelineStage struct {
+	stage
+	queues []chan *uplinkContext
+	next   *pipelineStage
+}
+
+var (
+	// pipeline holds the first stage of the uplink pipeline. It is nil when
+	// the uplink pipeline is disabled.
+	pipeline *pipelineStage
+
+	enqueueTimeout time.Duration
+)
+
+// Setup configures the package. When the uplink pipeline is enabled, it
+// starts the workers of each stage.
+func Setup(conf config.Config) error {
+	c := conf.ApplicationServer.UplinkPipeline
+	if !c.Enabled {
+		return nil
+	}
+
+	workers := c.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	enqueueTimeout = c.EnqueueTimeout
+
+	log.WithFields(log.Fields{
+		"workers":    workers,
+		"queue_size": c.QueueSize,
+	}).Info("uplink: starting uplink pipeline")
+
+	var next *pipelineStage
+	for i := len(stages) - 1; i >= 0; i-- {
+		ps := pipelineStage{
+			stage:  stages[i],
+			queues: make([]chan *uplinkContext, workers),
+			next:   next,
+		}
+		for j := range ps.queues {
+			ps.queues[j] = make(chan *uplinkContext, c.QueueSize)
+			go worker(&ps, ps.queues[j])
+		}
+		next = &ps
+	}
+	pipeline = next
+
+	return nil
+}
+
+// Enqueue queues the uplink event for handling by the uplink pipeline and
+// returns without waiting for the result. The uplinks of a device are
+// always handled by the same worker of each stage, so that these are handled
+// in order. When the queue of the worker is full for longer than the
+// configured enqueue timeout, the uplink is dropped and ErrQueueFull is
+// returned. When the uplink pipeline is disabled, the uplink is handled
+// directly.
+func Enqueue(ctx context.Context, req as.HandleUplinkDataRequest) error {
+	p := pipeline
+	if p == nil {
+		return Handle(ctx, req)
+	}
+
+	// the context of the api request is cancelled once the api returns
+	bgCtx := context.Background()
+	bgCtx = context.WithValue(bgCtx, logging.ContextIDKey, ctx.Value(logging.ContextIDKey))
+	bgCtx = tracing.WithSpanContext(bgCtx, ctx)
+	bgCtx, span := startSpan(bgCtx, req)
+
+	h := fnv.New32a()
+	h.Write(req.DevEui)
+
+	uc := uplinkContext{
+		ctx:           bgCtx,
+		uplinkDataReq: req,
+		pipelined:     true,
+		shard:         int(h.Sum32() % uint32(len(p.queues))),
+		span:          span,
+	}
+
+	pending.Add(1)
+	if !enqueue(p, &uc) {
+		pending.Done()
+		tracing.EndSpan(span, ErrQueueFull)
+		uplinkDroppedCounter().Inc()
+
+		var devEUI lorawan.EUI64
+		copy(devEUI[:], req.DevEui)
+
+		log.WithFields(log.Fields{
+			"dev_eui": devEUI,
+			"f_cnt":   req.FCnt,
+			"ctx_id":  ctx.Value(logging.ContextIDKey),
+		}).Warning("uplink: uplink pipeline queue is full, uplink dropped")
+
+		return ErrQueueFull
+	}
+
+	return nil
+}
+
+// enqueue queues the uplink to the given stage, waiting max. the enqueue
+// timeout when the queue is full. It returns false when the uplink could
+// not be queued.
+func enqueue(s *pipelineStage, uc *uplinkContext) bool {
+	q := s.queues[uc.shard]
+
+	select {
+	case q <- uc:
+		uplinkQueueGauge(s.name).Inc()
+		return true
+	default:
+	}
+
+	if enqueueTimeout <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(enqueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case q <- uc:
+		uplinkQueueGauge(s.name).Inc()
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// worker handles the uplinks of the given queue of the given stage and
+// passes these to the next stage. Passing an uplink to the next stage
+// blocks when its queue is full, which propagates the back-pressure to the
+// first stage, where uplinks are dropped.
+func worker(s *pipelineStage, q chan *uplinkContext) {
+	for uc := range q {
+		uplinkQueueGauge(s.name).Dec()
+
+		err := s.run(uc)
+		if err == nil && s.next != nil {
+			uplinkQueueGauge(s.next.name).Inc()
+			s.next.queues[uc.shard] <- uc
+			continue
+		}
+
+		switch err {
+		case nil:
+			countUplink(uc)
+		case ErrAbort:
+			err = nil
+		default:
+			var devEUI lorawan.EUI64
+			copy(devEUI[:], uc.uplinkDataReq.DevEui)
+
+			log.WithError(err).WithFields(log.Fields{
+				"stage":   s.name,
+				"dev_eui": devEUI,
+				"f_cnt":   uc.uplinkDataReq.FCnt,
+				"ctx_id":  uc.ctx.Value(logging.ContextIDKey),
+			}).Error("uplink: handle uplink error")
+		}
+
+		tracing.EndSpan(uc.span, err)
+		pending.Done()
+	}
+}
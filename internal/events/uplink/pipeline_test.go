@@ -0,0 +1,126 @@
+package uplink
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	// "github.com/ibrahimozekici/lora-api/go/v3/as"
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+func setupTestPipeline(t *testing.T, workers, queueSize int, enqueueTimeout time.Duration, s []stage) {
+	origStages := stages
+	t.Cleanup(func() {
+		stages = origStages
+		pipeline = nil
+	})
+
+	var conf config.Config
+	conf.ApplicationServer.UplinkPipeline.Enabled = true
+	conf.ApplicationServer.UplinkPipeline.Workers = workers
+	conf.ApplicationServer.UplinkPipeline.QueueSize = queueSize
+	conf.ApplicationServer.UplinkPipeline.EnqueueTimeout = enqueueTimeout
+
+	stages = s
+	require.NoError(t, Setup(conf))
+}
+
+func TestPipeline(t *testing.T) {
+	t.Run("Order", func(t *testing.T) {
+		assert := require.New(t)
+
+		var mux sync.Mutex
+		handled := make(map[byte][]uint32)
+
+		setupTestPipeline(t, 4, 10, time.Minute, []stage{
+			{
+				name: "first",
+				tasks: []func(*uplinkContext) error{
+					func(ctx *uplinkContext) error {
+						if ctx.uplinkDataReq.FPort == 0 {
+							return ErrAbort
+						}
+						return nil
+					},
+				},
+			},
+			{
+				name: "second",
+				tasks: []func(*uplinkContext) error{
+					func(ctx *uplinkContext) error {
+						mux.Lock()
+						defer mux.Unlock()
+
+						devEUI := ctx.uplinkDataReq.DevEui[7]
+						handled[devEUI] = append(handled[devEUI], ctx.uplinkDataReq.FCnt)
+						return nil
+					},
+				},
+			},
+		})
+
+		for fCnt := uint32(0); fCnt < 10; fCnt++ {
+			for devEUI := byte(1); devEUI <= 3; devEUI++ {
+				assert.NoError(Enqueue(context.Background(), as.HandleUplinkDataRequest{
+					DevEui: []byte{1, 2, 3, 4, 5, 6, 7, devEUI},
+					FCnt:   fCnt,
+					FPort:  1,
+				}))
+			}
+		}
+
+		// aborted by the first stage
+		assert.NoError(Enqueue(context.Background(), as.HandleUplinkDataRequest{
+			DevEui: []byte{1, 2, 3, 4, 5, 6, 7, 1},
+			FCnt:   10,
+		}))
+
+		Wait()
+
+		expected := []uint32{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+		assert.Equal(map[byte][]uint32{
+			1: expected,
+			2: expected,
+			3: expected,
+		}, handled)
+	})
+
+	t.Run("Queue full", func(t *testing.T) {
+		assert := require.New(t)
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+
+		setupTestPipeline(t, 1, 1, 0, []stage{
+			{
+				name: "blocking",
+				tasks: []func(*uplinkContext) error{
+					func(ctx *uplinkContext) error {
+						started <- struct{}{}
+						<-release
+						return nil
+					},
+				},
+			},
+		})
+
+		req := as.HandleUplinkDataRequest{
+			DevEui: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+		}
+
+		// the first uplink is handled by the worker, the second is queued
+		assert.NoError(Enqueue(context.Background(), req))
+		<-started
+		assert.NoError(Enqueue(context.Background(), req))
+
+		assert.Equal(ErrQueueFull, Enqueue(context.Background(), req))
+
+		close(release)
+		<-started
+		Wait()
+	})
+}
@@ -0,0 +1,65 @@
+package uplink
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	ue = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "event_uplink_count",
+		Help: "The number of processed uplink events (per application).",
+	}, []string{"application_id"})
+
+	ur = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "event_uplink_roaming_count",
+		Help: "The number of processed uplink events received through a roaming partner (per NetID).",
+	}, []string{"net_id"})
+
+	ug = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "event_uplink_gateway_count",
+		Help:    "The number of gateways which received the uplink.",
+		Buckets: []float64{1, 2, 3, 4, 5, 6, 8, 10},
+	})
+
+	us = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "event_uplink_sole_receiver_count",
+		Help: "The number of processed uplink events received by a single gateway (per gateway).",
+	}, []string{"gateway_id"})
+
+	uq = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "event_uplink_pipeline_queue_length",
+		Help: "The number of uplink events queued in the uplink pipeline (per stage).",
+	}, []string{"stage"})
+
+	ud = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "event_uplink_pipeline_dropped_count",
+		Help: "The number of uplink events dropped because the uplink pipeline queue was full.",
+	})
+)
+
+func uplinkCounter(applicationID int64) prometheus.Counter {
+	return ue.With(prometheus.Labels{"application_id": strconv.FormatInt(applicationID, 10)})
+}
+
+func uplinkRoamingCounter(netID string) prometheus.Counter {
+	return ur.With(prometheus.Labels{"net_id": netID})
+}
+
+func uplinkGatewayHistogram() prometheus.Observer {
+	return ug
+}
+
+func uplinkSoleReceiverCounter(gatewayID string) prometheus.Counter {
+	return us.With(prometheus.Labels{"gateway_id": gatewayID})
+}
+
+func uplinkQueueGauge(stage string) prometheus.Gauge {
+	return uq.With(prometheus.Labels{"stage": stage})
+}
+
+func uplinkDroppedCounter() prometheus.Counter {
+	return ud
+}
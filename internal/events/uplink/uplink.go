@@ -3,15 +3,19 @@ package uplink
 import (
 	"context"
 	"crypto/aes"
-	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
 	keywrap "github.com/NickBall/go-aes-key-wrap"
+	"github.com/go-redis/redis/v7"
 	"github.com/golang/protobuf/ptypes"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	// "github.com/ibrahimozekici/lora-api/go/v3/as"
 	pb //"github.com/ibrahimozekici/lora-api/go/v3/as/integration"
@@ -19,11 +23,18 @@ import (
 	"github.com/ibrahimozekici/app-server2/internal/applayer/clocksync"
 	"github.com/ibrahimozekici/app-server2/internal/applayer/fragmentation"
 	"github.com/ibrahimozekici/app-server2/internal/applayer/multicastsetup"
+	"github.com/ibrahimozekici/app-server2/internal/automation"
 	"github.com/ibrahimozekici/app-server2/internal/codec"
-	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/contact"
+	"github.com/ibrahimozekici/app-server2/internal/downlink"
 	"github.com/ibrahimozekici/app-server2/internal/integration"
+	"github.com/ibrahimozekici/app-server2/internal/kek"
 	"github.com/ibrahimozekici/app-server2/internal/logging"
+	"github.com/ibrahimozekici/app-server2/internal/measurement"
+	"github.com/ibrahimozekici/app-server2/internal/metering"
+	"github.com/ibrahimozekici/app-server2/internal/roaming"
 	"github.com/ibrahimozekici/app-server2/internal/storage"
+	"github.com/ibrahimozekici/app-server2/internal/tracing"
 	//"github.com/brocaar/lorawan"
 	//"github.com/brocaar/lorawan/gps"
 )
@@ -36,31 +47,126 @@ type uplinkContext struct {
 	application   storage.Application
 	deviceProfile storage.DeviceProfile
 
-	data       []byte
-	objectJSON string
+	data         []byte
+	objectJSON   string
+	measurements []measurement.Measurement
+	storedBytes  int
+
+	// redisPipe holds the Redis commands of the storage stage, which are
+	// executed in a single round-trip by execRedisPipeline.
+	redisPipe redis.Pipeliner
+
+	// pipelined is set when the uplink is handled by the uplink pipeline,
+	// shard holds the worker handling the uplinks of the device and span
+	// the span of the uplink handling.
+	pipelined bool
+	shard     int
+	span      trace.Span
 }
 
-var tasks = []func(*uplinkContext) error{
-	getDevice,
-	getApplication,
-	getDeviceProfile,
-	updateDeviceLastSeenAndDR,
-	updateDeviceActivation,
-	decryptPayload,
-	handleApplicationLayers,
-	handleCodec,
-	handleIntegrations,
+// redisPipeline returns the Redis pipeline of the uplink, see
+// execRedisPipeline.
+func (ctx *uplinkContext) redisPipeline() redis.Pipeliner {
+	if ctx.redisPipe == nil {
+		ctx.redisPipe = storage.RedisClient().TxPipeline()
+	}
+	return ctx.redisPipe
+}
+
+// async runs the given function in a Go-routine so that the
+// as.HandleUplinkData api can return. When the uplink is handled by the
+// uplink pipeline, the api has already returned and the function is run
+// by the worker, which bounds the concurrency and keeps the order of the
+// uplinks of a device.
+func (ctx *uplinkContext) async(f func()) {
+	if ctx.pipelined {
+		f()
+		return
+	}
+
+	pending.Add(1)
+	go func() {
+		defer pending.Done()
+		f()
+	}()
+}
+
+// stage defines a stage of the uplink handling. When the uplink pipeline is
+// enabled, each stage is handled by its own pool of workers.
+type stage struct {
+	name  string
+	tasks []func(*uplinkContext) error
+}
+
+var stages = []stage{
+	{
+		name: "codec",
+		tasks: []func(*uplinkContext) error{
+			getDevice,
+			getApplication,
+			getDeviceProfile,
+			updateDeviceLastSeenAndDR,
+			updateConfirmedDownlinkRetries,
+			updateDeviceActivation,
+			decryptPayload,
+			handleApplicationLayers,
+			handleCodec,
+			extractMeasurements,
+		},
+	},
+	{
+		name: "storage",
+		tasks: []func(*uplinkContext) error{
+			storeFrameLog,
+			storeDeviceMetrics,
+			meterUsage,
+			saveApplicationMetrics,
+			execRedisPipeline,
+		},
+	},
+	{
+		name: "integrations",
+		tasks: []func(*uplinkContext) error{
+			handleIntegrations,
+			handleDownlinkRules,
+			handleAutomations,
+			handleContacts,
+			handleMeters,
+		},
+	},
+}
+
+func (s stage) run(ctx *uplinkContext) error {
+	for _, f := range s.tasks {
+		if err := f(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pending tracks the uplinks queued in the uplink pipeline and the uplink
+// handling which continues in a Go-routine after Handle has returned
+// (integrations, downlink rules, automations, contact sensors and meters).
+var pending sync.WaitGroup
+
+// Wait blocks until the handling of all uplinks has completed.
+func Wait() {
+	pending.Wait()
 }
 
 // Handle handles the uplink event.
-func Handle(ctx context.Context, req as.HandleUplinkDataRequest) error {
+func Handle(ctx context.Context, req as.HandleUplinkDataRequest) (err error) {
+	ctx, span := startSpan(ctx, req)
+	defer func() { tracing.EndSpan(span, err) }()
+
 	uc := uplinkContext{
 		ctx:           ctx,
 		uplinkDataReq: req,
 	}
 
-	for _, f := range tasks {
-		if err := f(&uc); err != nil {
+	for _, s := range stages {
+		if err := s.run(&uc); err != nil {
 			if err == ErrAbort {
 				return nil
 			}
@@ -68,15 +174,68 @@ func Handle(ctx context.Context, req as.HandleUplinkDataRequest) error {
 		}
 	}
 
+	countUplink(&uc)
+
 	return nil
 }
 
+func startSpan(ctx context.Context, req as.HandleUplinkDataRequest) (context.Context, trace.Span) {
+	var devEUI lorawan.EUI64
+	copy(devEUI[:], req.DevEui)
+
+	return tracing.StartSpan(ctx, "uplink.Handle",
+		attribute.String("dev_eui", devEUI.String()),
+		attribute.Int64("f_cnt", int64(req.FCnt)),
+		attribute.Int64("f_port", int64(req.FPort)),
+	)
+}
+
+// countUplink updates the uplink metrics of the handled uplink.
+func countUplink(ctx *uplinkContext) {
+	req := ctx.uplinkDataReq
+
+	uplinkCounter(ctx.device.ApplicationID).Inc()
+
+	// count each roaming partner once, also when the uplink was received
+	// by multiple gateways of the same partner
+	netIDs := make(map[string]struct{})
+	for _, rx := range req.RxInfo {
+		if ri := roaming.GetInfo(rx); ri != nil {
+			netIDs[ri.NetID] = struct{}{}
+		}
+	}
+	for netID := range netIDs {
+		uplinkRoamingCounter(netID).Inc()
+	}
+
+	// a gateway can report the same uplink more than once (e.g. multiple
+	// antennas)
+	gatewayIDs := make(map[lorawan.EUI64]struct{})
+	for _, rx := range req.RxInfo {
+		if rx == nil {
+			continue
+		}
+
+		var gatewayID lorawan.EUI64
+		copy(gatewayID[:], rx.GatewayId)
+		gatewayIDs[gatewayID] = struct{}{}
+	}
+	if len(gatewayIDs) != 0 {
+		uplinkGatewayHistogram().Observe(float64(len(gatewayIDs)))
+	}
+	if len(gatewayIDs) == 1 {
+		for gatewayID := range gatewayIDs {
+			uplinkSoleReceiverCounter(gatewayID.String()).Inc()
+		}
+	}
+}
+
 func getDevice(ctx *uplinkContext) error {
 	var err error
 	var devEUI lorawan.EUI64
 	copy(devEUI[:], ctx.uplinkDataReq.DevEui)
 
-	ctx.device, err = storage.GetDevice(ctx.ctx, storage.DB(), devEUI, false, true)
+	ctx.device, err = storage.GetDeviceCached(ctx.ctx, storage.DB(), devEUI)
 	if err != nil {
 		return errors.Wrap(err, "get device error")
 	}
@@ -85,7 +244,7 @@ func getDevice(ctx *uplinkContext) error {
 
 func getDeviceProfile(ctx *uplinkContext) error {
 	var err error
-	ctx.deviceProfile, err = storage.GetDeviceProfile(ctx.ctx, storage.DB(), ctx.device.DeviceProfileID, false, true)
+	ctx.deviceProfile, err = storage.GetDeviceProfileCached(ctx.ctx, storage.DB(), ctx.device.DeviceProfileID)
 	if err != nil {
 		return errors.Wrap(err, "get device-profile error")
 	}
@@ -94,7 +253,7 @@ func getDeviceProfile(ctx *uplinkContext) error {
 
 func getApplication(ctx *uplinkContext) error {
 	var err error
-	ctx.application, err = storage.GetApplication(ctx.ctx, storage.DB(), ctx.device.ApplicationID)
+	ctx.application, err = storage.GetApplicationCached(ctx.ctx, storage.DB(), ctx.device.ApplicationID)
 	if err != nil {
 		return errors.Wrap(err, "get application error")
 	}
@@ -102,13 +261,31 @@ func getApplication(ctx *uplinkContext) error {
 }
 
 func updateDeviceLastSeenAndDR(ctx *uplinkContext) error {
-	if err := storage.UpdateDeviceLastSeenAndDR(ctx.ctx, storage.DB(), ctx.device.DevEUI, time.Now(), int(ctx.uplinkDataReq.Dr)); err != nil {
+	sctx, span := tracing.StartSpan(ctx.ctx, "storage.UpdateDeviceLastSeenAndDR")
+	err := storage.UpdateDeviceLastSeenAndDR(sctx, storage.DB(), ctx.device.DevEUI, time.Now(), int(ctx.uplinkDataReq.Dr))
+	tracing.EndSpan(span, err)
+	if err != nil {
 		return errors.Wrap(err, "update device last-seen and dr error")
 	}
 
 	return nil
 }
 
+// updateConfirmedDownlinkRetries counts the uplink for the confirmed
+// downlinks waiting for the frame based backoff of the retry policy.
+func updateConfirmedDownlinkRetries(ctx *uplinkContext) error {
+	p := ctx.deviceProfile.ConfirmedDownlinkRetry
+	if p.MaxRetries == 0 || p.BackoffFrames == 0 {
+		return nil
+	}
+
+	if err := storage.DecrementConfirmedDownlinkRetryUplinks(ctx.ctx, storage.DB(), ctx.device.DevEUI); err != nil {
+		return errors.Wrap(err, "decrement confirmed downlink retry uplinks error")
+	}
+
+	return nil
+}
+
 func updateDeviceActivation(ctx *uplinkContext) error {
 	da := ctx.uplinkDataReq.DeviceActivationContext
 
@@ -173,6 +350,10 @@ func updateDeviceActivation(ctx *uplinkContext) error {
 		return errors.Wrap(err, "send join notification error")
 	}
 
+	if err := sendJoinSessionEvent(ctx, vars); err != nil {
+		return errors.Wrap(err, "send join session event error")
+	}
+
 	return nil
 }
 
@@ -213,13 +394,13 @@ func handleApplicationLayers(ctx *uplinkContext) error {
 				if rxInfo.TimeSinceGpsEpoch != nil {
 					timeSinceGPSEpoch, err = ptypes.Duration(rxInfo.TimeSinceGpsEpoch)
 					if err != nil {
-						log.WithError(err).Error("time since gps epoch to duration error")
+						log.WithError(err).WithField("ctx_id", ctx.ctx.Value(logging.ContextIDKey)).Error("time since gps epoch to duration error")
 						continue
 					}
 				} else if rxInfo.Time != nil {
 					timeField, err = ptypes.Timestamp(rxInfo.Time)
 					if err != nil {
-						log.WithError(err).Error("time to timestamp error")
+						log.WithError(err).WithField("ctx_id", ctx.ctx.Value(logging.ContextIDKey)).Error("time to timestamp error")
 						continue
 					}
 				}
@@ -243,20 +424,23 @@ func handleApplicationLayers(ctx *uplinkContext) error {
 }
 
 func handleCodec(ctx *uplinkContext) error {
-	codecType := ctx.application.PayloadCodec
-	decoderScript := ctx.application.PayloadDecoderScript
-
-	if ctx.deviceProfile.PayloadCodec != "" {
-		codecType = ctx.deviceProfile.PayloadCodec
-		decoderScript = ctx.deviceProfile.PayloadDecoderScript
+	c, err := storage.ResolvePayloadCodec(ctx.ctx, storage.DB(), ctx.application, ctx.deviceProfile, ctx.device)
+	if err != nil {
+		return errors.Wrap(err, "resolve payload codec error")
 	}
+	codecType := c.Type
+	decoderScript := c.DecoderScript
 
 	if codecType == codec.None {
 		return nil
 	}
 
+	_, span := tracing.StartSpan(ctx.ctx, "codec.BinaryToJSON",
+		attribute.String("codec", string(codecType)),
+	)
 	start := time.Now()
 	b, err := codec.BinaryToJSON(codecType, uint8(ctx.uplinkDataReq.FPort), ctx.device.Variables, decoderScript, ctx.data)
+	tracing.EndSpan(span, err)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"codec":          codecType,
@@ -264,6 +448,7 @@ func handleCodec(ctx *uplinkContext) error {
 			"f_port":         ctx.uplinkDataReq.FPort,
 			"f_cnt":          ctx.uplinkDataReq.FCnt,
 			"dev_eui":        ctx.device.DevEUI,
+			"ctx_id":         ctx.ctx.Value(logging.ContextIDKey),
 		}).WithError(err).Error("decode payload error")
 
 		errEvent := pb.ErrorEvent{
@@ -291,7 +476,7 @@ func handleCodec(ctx *uplinkContext) error {
 		}
 
 		if err := integration.ForApplicationID(ctx.device.ApplicationID).HandleErrorEvent(ctx.ctx, vars, errEvent); err != nil {
-			log.WithError(err).Error("send error event to integration error")
+			log.WithError(err).WithField("ctx_id", ctx.ctx.Value(logging.ContextIDKey)).Error("send error event to integration error")
 		}
 	}
 
@@ -299,6 +484,7 @@ func handleCodec(ctx *uplinkContext) error {
 		"application_id": ctx.application.ID,
 		"codec":          codecType,
 		"duration":       time.Since(start),
+		"ctx_id":         ctx.ctx.Value(logging.ContextIDKey),
 	}).Debug("payload codec completed Decode execution")
 
 	ctx.objectJSON = string(b)
@@ -306,6 +492,206 @@ func handleCodec(ctx *uplinkContext) error {
 	return nil
 }
 
+// extractMeasurements extracts the measurements, as configured in the
+// device-profile, from the decoded object.
+func extractMeasurements(ctx *uplinkContext) error {
+	var err error
+	ctx.measurements, err = ctx.deviceProfile.Measurements.ExtractJSON([]byte(ctx.objectJSON))
+	if err != nil {
+		return errors.Wrap(err, "extract measurements error")
+	}
+	return nil
+}
+
+func storeFrameLog(ctx *uplinkContext) error {
+	var rxInfo []storage.DeviceFrameLogRXInfo
+	for _, rx := range ctx.uplinkDataReq.RxInfo {
+		if rx == nil {
+			continue
+		}
+
+		var gatewayID lorawan.EUI64
+		copy(gatewayID[:], rx.GatewayId)
+
+		fr := storage.DeviceFrameLogRXInfo{
+			GatewayID: gatewayID,
+			RSSI:      int(rx.Rssi),
+			LoRaSNR:   rx.LoraSnr,
+		}
+		if ri := roaming.GetInfo(rx); ri != nil {
+			fr.RoamingNetID = ri.NetID
+		}
+
+		rxInfo = append(rxInfo, fr)
+	}
+
+	rxInfoB, err := json.Marshal(rxInfo)
+	if err != nil {
+		return errors.Wrap(err, "marshal rx-info error")
+	}
+
+	fl := storage.DeviceFrameLog{
+		DevEUI:          ctx.device.DevEUI,
+		ApplicationID:   ctx.device.ApplicationID,
+		ReceivedAt:      time.Now(),
+		DevAddr:         ctx.device.DevAddr,
+		FCnt:            ctx.uplinkDataReq.FCnt,
+		FPort:           uint8(ctx.uplinkDataReq.FPort),
+		DR:              int(ctx.uplinkDataReq.Dr),
+		ADR:             ctx.uplinkDataReq.Adr,
+		ConfirmedUplink: ctx.uplinkDataReq.ConfirmedUplink,
+		Data:            ctx.data,
+		RXInfo:          json.RawMessage(rxInfoB),
+	}
+	if ctx.objectJSON != "" {
+		fl.Object = json.RawMessage(ctx.objectJSON)
+	}
+
+	sctx, span := tracing.StartSpan(ctx.ctx, "storage.WriteDeviceFrameLog")
+	err = storage.WriteDeviceFrameLog(sctx, ctx.application.OrganizationID, fl)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"dev_eui": ctx.device.DevEUI,
+			"ctx_id":  ctx.ctx.Value(logging.ContextIDKey),
+		}).Error("store device frame-log error")
+	} else {
+		ctx.storedBytes += len(fl.Data) + len(fl.Object) + len(fl.RXInfo)
+	}
+
+	return nil
+}
+
+func storeDeviceMetrics(ctx *uplinkContext) error {
+	if ctx.objectJSON == "" {
+		return nil
+	}
+
+	var obj interface{}
+	if err := json.Unmarshal([]byte(ctx.objectJSON), &obj); err != nil {
+		return errors.Wrap(err, "unmarshal object error")
+	}
+
+	// when measurements are configured, only the numeric measurements are
+	// stored, using the measurement name
+	values := numericFields("", obj)
+	if len(ctx.deviceProfile.Measurements) != 0 {
+		values = make(map[string]float64)
+		for _, m := range ctx.measurements {
+			if v, ok := m.Value.(float64); ok {
+				values[m.Name] = v
+			}
+		}
+	}
+
+	now := time.Now()
+	var metrics []storage.DeviceMetric
+	for name, value := range values {
+		metrics = append(metrics, storage.DeviceMetric{
+			DevEUI:        ctx.device.DevEUI,
+			ApplicationID: ctx.device.ApplicationID,
+			Time:          now,
+			Name:          name,
+			Value:         value,
+		})
+	}
+
+	sctx, span := tracing.StartSpan(ctx.ctx, "storage.WriteDeviceMetrics")
+	err := storage.WriteDeviceMetrics(sctx, ctx.application.OrganizationID, metrics)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"dev_eui": ctx.device.DevEUI,
+			"ctx_id":  ctx.ctx.Value(logging.ContextIDKey),
+		}).Error("store device metrics error")
+	}
+
+	return nil
+}
+
+// meterUsage meters the uplink and the stored frame-log bytes for the
+// organization. The counters are incremented by execRedisPipeline.
+func meterUsage(ctx *uplinkContext) error {
+	pipe := ctx.redisPipeline()
+	storage.IncrementOrganizationUsagePipelined(ctx.ctx, pipe, ctx.application.OrganizationID, storage.UsageUplinks, 1)
+	storage.IncrementOrganizationUsagePipelined(ctx.ctx, pipe, ctx.application.OrganizationID, storage.UsageStoredBytes, int64(ctx.storedBytes))
+
+	return nil
+}
+
+// saveApplicationMetrics stores the aggregated uplink metrics of the
+// application. The metrics are stored by execRedisPipeline. Errors do not
+// abort the uplink handling.
+func saveApplicationMetrics(ctx *uplinkContext) error {
+	err := storage.SaveMetricsPipelined(ctx.ctx, ctx.redisPipeline(), storage.ApplicationMetricsName(ctx.device.ApplicationID), storage.MetricsRecord{
+		Time: time.Now(),
+		Metrics: map[string]float64{
+			storage.ApplicationMetricRXCount: 1,
+		},
+	})
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"dev_eui": ctx.device.DevEUI,
+			"ctx_id":  ctx.ctx.Value(logging.ContextIDKey),
+		}).Error("save application metrics error")
+	}
+
+	return nil
+}
+
+// execRedisPipeline executes the Redis commands of the storage stage (usage
+// counters and application metrics) in a single round-trip. Errors do not
+// abort the uplink handling.
+func execRedisPipeline(ctx *uplinkContext) error {
+	if ctx.redisPipe == nil {
+		return nil
+	}
+
+	_, span := tracing.StartSpan(ctx.ctx, "storage.ExecRedisPipeline")
+	_, err := ctx.redisPipe.Exec()
+	tracing.EndSpan(span, err)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"dev_eui": ctx.device.DevEUI,
+			"ctx_id":  ctx.ctx.Value(logging.ContextIDKey),
+		}).Error("exec redis pipeline error")
+	}
+
+	return nil
+}
+
+// numericFields returns the numeric and boolean values of the given decoded
+// object, keyed by their (dot separated) path, e.g. "sensor.temperature".
+func numericFields(prefix string, v interface{}) map[string]float64 {
+	out := make(map[string]float64)
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, vv := range v {
+			if prefix != "" {
+				k = prefix + "." + k
+			}
+			for kk, vvv := range numericFields(k, vv) {
+				out[kk] = vvv
+			}
+		}
+	case float64:
+		if prefix != "" {
+			out[prefix] = v
+		}
+	case bool:
+		if prefix != "" {
+			if v {
+				out[prefix] = 1
+			} else {
+				out[prefix] = 0
+			}
+		}
+	}
+
+	return out
+}
+
 func handleIntegrations(ctx *uplinkContext) error {
 	pl := pb.UplinkEvent{
 		ApplicationId:   uint64(ctx.device.ApplicationID),
@@ -340,15 +726,127 @@ func handleIntegrations(ctx *uplinkContext) error {
 
 	bgCtx := context.Background()
 	bgCtx = context.WithValue(bgCtx, logging.ContextIDKey, ctx.ctx.Value(logging.ContextIDKey))
+	bgCtx = tracing.WithSpanContext(bgCtx, ctx.ctx)
+	if len(ctx.deviceProfile.Measurements) != 0 {
+		bgCtx = measurement.NewContext(bgCtx, ctx.measurements)
+	}
 
 	// Handle the actual integration handling in a Go-routine so that the
 	// as.HandleUplinkData api can return.
-	go func() {
-		err := integration.ForApplicationID(ctx.device.ApplicationID).HandleUplinkEvent(bgCtx, vars, pl)
+	applicationID := ctx.device.ApplicationID
+	ctx.async(func() {
+		err := integration.ForApplicationID(applicationID).HandleUplinkEvent(bgCtx, vars, pl)
 		if err != nil {
-			log.WithError(err).Error("send uplink event error")
+			log.WithError(err).WithField("ctx_id", bgCtx.Value(logging.ContextIDKey)).Error("send uplink event error")
 		}
-	}()
+	})
+
+	return nil
+}
+
+// handleDownlinkRules evaluates the downlink rules of the application against
+// the decoded object. This is done in a Go-routine as the rule triggered
+// downlinks lock the device, which must not block the as.HandleUplinkData api.
+func handleDownlinkRules(ctx *uplinkContext) error {
+	if ctx.objectJSON == "" {
+		return nil
+	}
+
+	bgCtx := context.Background()
+	bgCtx = context.WithValue(bgCtx, logging.ContextIDKey, ctx.ctx.Value(logging.ContextIDKey))
+
+	applicationID, devEUI, fPort, objectJSON := ctx.device.ApplicationID, ctx.device.DevEUI, uint8(ctx.uplinkDataReq.FPort), []byte(ctx.objectJSON)
+	ctx.async(func() {
+		if err := downlink.HandleDownlinkRules(bgCtx, applicationID, devEUI, fPort, objectJSON); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"dev_eui": devEUI,
+				"ctx_id":  bgCtx.Value(logging.ContextIDKey),
+			}).Error("handle downlink rules error")
+		}
+	})
+
+	return nil
+}
+
+// handleAutomations executes the automations of the application of which
+// the uplink trigger matches the decoded object. Like the downlink rules,
+// this is done in a Go-routine. This is also done for uplinks without
+// decoded object, as any uplink resolves the DEVICE_OFFLINE alarms of the
+// device.
+func handleAutomations(ctx *uplinkContext) error {
+	bgCtx := context.Background()
+	bgCtx = context.WithValue(bgCtx, logging.ContextIDKey, ctx.ctx.Value(logging.ContextIDKey))
+
+	d, fPort, objectJSON := ctx.device, uint8(ctx.uplinkDataReq.FPort), []byte(ctx.objectJSON)
+	ctx.async(func() {
+		if err := automation.HandleUplink(bgCtx, d, fPort, objectJSON); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"dev_eui": d.DevEUI,
+				"ctx_id":  bgCtx.Value(logging.ContextIDKey),
+			}).Error("handle automations error")
+		}
+	})
+
+	return nil
+}
+
+// handleContacts updates the states of the contact sensors of the device
+// with the CONTACT measurements of the uplink. As closing a contact resolves
+// the CONTACT_OPEN alarms, this is done in a Go-routine like the
+// automations.
+func handleContacts(ctx *uplinkContext) error {
+	var contacts []measurement.Measurement
+	for _, m := range ctx.measurements {
+		if m.Kind == measurement.Contact {
+			contacts = append(contacts, m)
+		}
+	}
+	if len(contacts) == 0 {
+		return nil
+	}
+
+	bgCtx := context.Background()
+	bgCtx = context.WithValue(bgCtx, logging.ContextIDKey, ctx.ctx.Value(logging.ContextIDKey))
+
+	d, t := ctx.device, time.Now()
+	ctx.async(func() {
+		if err := contact.HandleUplink(bgCtx, d, contacts, t); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"dev_eui": d.DevEUI,
+				"ctx_id":  bgCtx.Value(logging.ContextIDKey),
+			}).Error("handle contacts error")
+		}
+	})
+
+	return nil
+}
+
+// handleMeters adds the readings of the meters (COUNTER measurements) of the
+// device to the consumption. Like the contact sensors, this is done in a
+// Go-routine.
+func handleMeters(ctx *uplinkContext) error {
+	var counters []measurement.Measurement
+	for _, m := range ctx.measurements {
+		if m.Kind == measurement.Counter {
+			counters = append(counters, m)
+		}
+	}
+	if len(counters) == 0 {
+		return nil
+	}
+
+	bgCtx := context.Background()
+	bgCtx = context.WithValue(bgCtx, logging.ContextIDKey, ctx.ctx.Value(logging.ContextIDKey))
+
+	d, t := ctx.device, time.Now()
+	ctx.async(func() {
+		if err := metering.HandleUplink(bgCtx, d, counters, t); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"dev_eui": d.DevEUI,
+				"ctx_id":  bgCtx.Value(logging.ContextIDKey),
+			}).Error("handle meters error")
+		}
+	})
 
 	return nil
 }
@@ -361,26 +859,19 @@ func unwrapASKey(ke *common.KeyEnvelope) (lorawan.AES128Key, error) {
 		return key, nil
 	}
 
-	for i := range config.C.JoinServer.KEK.Set {
-		if config.C.JoinServer.KEK.Set[i].Label == ke.KekLabel {
-			kek, err := hex.DecodeString(config.C.JoinServer.KEK.Set[i].KEK)
-			if err != nil {
-				return key, errors.Wrap(err, "decode kek error")
-			}
-
-			block, err := aes.NewCipher(kek)
-			if err != nil {
-				return key, errors.Wrap(err, "new cipher error")
-			}
-
-			b, err := keywrap.Unwrap(block, ke.AesKey)
-			if err != nil {
-				return key, errors.Wrap(err, "key unwrap error")
-			}
+	if b, ok := kek.GetKEK(ke.KekLabel); ok {
+		block, err := aes.NewCipher(b)
+		if err != nil {
+			return key, errors.Wrap(err, "new cipher error")
+		}
 
-			copy(key[:], b)
-			return key, nil
+		b, err := keywrap.Unwrap(block, ke.AesKey)
+		if err != nil {
+			return key, errors.Wrap(err, "key unwrap error")
 		}
+
+		copy(key[:], b)
+		return key, nil
 	}
 
 	return key, fmt.Errorf("unknown kek label: %s", ke.KekLabel)
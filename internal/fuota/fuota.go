@@ -14,8 +14,10 @@ import (
 
 	// "github.com/ibrahimozekici/lora-api/go/v3/ns"
 	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/leader"
 	"github.com/ibrahimozekici/app-server2/internal/logging"
 	"github.com/ibrahimozekici/app-server2/internal/multicast"
+	"github.com/ibrahimozekici/app-server2/internal/multicast/gwselect"
 	"github.com/ibrahimozekici/app-server2/internal/storage"
 	//"github.com/brocaar/lorawan"
 	//"github.com/brocaar/lorawan/applayer/fragmentation"
@@ -30,6 +32,7 @@ var (
 	remoteMulticastSetupRetries       int
 	remoteFragmentationSessionRetries int
 	routingProfileID                  uuid.UUID
+	gatewaySelectionWindow            time.Duration
 )
 
 // Setup configures the package.
@@ -45,6 +48,7 @@ func Setup(conf config.Config) error {
 	fragIndex = conf.ApplicationServer.FUOTADeployment.FragIndex
 	remoteMulticastSetupRetries = conf.ApplicationServer.RemoteMulticastSetup.SyncRetries
 	remoteFragmentationSessionRetries = conf.ApplicationServer.FragmentationSession.SyncRetries
+	gatewaySelectionWindow = conf.ApplicationServer.FUOTADeployment.GatewaySelectionWindow
 
 	go fuotaDeploymentLoop()
 
@@ -61,8 +65,10 @@ func fuotaDeploymentLoop() {
 		ctx := context.Background()
 		ctx = context.WithValue(ctx, logging.ContextIDKey, ctxID)
 
-		err = storage.Transaction(func(tx sqlx.Ext) error {
-			return fuotaDeployments(ctx, tx)
+		err = leader.Do(ctx, "fuota", func(ctx context.Context) error {
+			return storage.Transaction(func(tx sqlx.Ext) error {
+				return fuotaDeployments(ctx, tx)
+			})
 		})
 		if err != nil {
 			log.WithError(err).Error("fuota deployment error")
@@ -210,6 +216,10 @@ func stepMulticastSetup(ctx context.Context, db sqlx.Ext, item storage.FUOTADepl
 	}
 
 	for _, dk := range deviceKeys {
+		if err := storage.DecryptDeviceKeys(ctx, db, &dk); err != nil {
+			return errors.Wrap(err, "decrypt device-keys error")
+		}
+
 		var nullKey lorawan.AES128Key
 
 		// get the encrypted McKey.
@@ -433,6 +443,8 @@ func stepEnqueue(ctx context.Context, db sqlx.Ext, item storage.FUOTADeployment)
 		payloads = append(payloads, b)
 	}
 
+	logGatewaySelection(ctx, db, item)
+
 	// enqueue the payloads
 	_, err = multicast.EnqueueMultiple(ctx, db, *item.MulticastGroupID, fragmentation.DefaultFPort, payloads)
 	if err != nil {
@@ -456,6 +468,40 @@ func stepEnqueue(ctx context.Context, db sqlx.Ext, item storage.FUOTADeployment)
 	return nil
 }
 
+// logGatewaySelection logs the minimal set of gateways covering the devices
+// of the deployment and warns about the devices which have not been received
+// by any gateway within the gateway selection window, as these are unlikely
+// to receive the fragments.
+func logGatewaySelection(ctx context.Context, db sqlx.Ext, item storage.FUOTADeployment) {
+	sel, err := multicast.SelectGateways(ctx, db, *item.MulticastGroupID, time.Now().Add(-gatewaySelectionWindow), gwselect.Filter{})
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"fuota_deployment_id": item.ID,
+			"ctx_id":              ctx.Value(logging.ContextIDKey),
+		}).Error("fuota: select gateways error")
+		return
+	}
+
+	var gatewayIDs []lorawan.EUI64
+	for _, id := range sel.GatewayIDs() {
+		gatewayIDs = append(gatewayIDs, id)
+	}
+
+	log.WithFields(log.Fields{
+		"fuota_deployment_id": item.ID,
+		"gateway_ids":         gatewayIDs,
+		"ctx_id":              ctx.Value(logging.ContextIDKey),
+	}).Info("fuota: gateways selected for multicast-group")
+
+	for _, devEUI := range sel.Uncovered {
+		log.WithFields(log.Fields{
+			"fuota_deployment_id": item.ID,
+			"dev_eui":             lorawan.EUI64(devEUI),
+			"ctx_id":              ctx.Value(logging.ContextIDKey),
+		}).Warning("fuota: device not covered by any gateway within gateway selection window")
+	}
+}
+
 func stepStatusRequest(ctx context.Context, db sqlx.Ext, item storage.FUOTADeployment) error {
 	if item.MulticastGroupID == nil {
 		return errors.New("MulticastGroupID must not be nil")
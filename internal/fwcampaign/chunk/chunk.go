@@ -0,0 +1,93 @@
+// Package chunk implements the chunking and airtime based pacing of the
+// firmware images sent by a firmware campaign.
+package chunk
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// headerSize is the size of the header prepended to every chunk:
+	// the chunk index and the chunk count, both as uint16 (big endian).
+	headerSize = 4
+
+	// phyPayloadOverhead is the LoRaWAN overhead in bytes of a downlink
+	// containing a FRMPayload: MHDR (1), FHDR without FOpts (7), FPort (1)
+	// and MIC (4).
+	phyPayloadOverhead = 13
+
+	// preambleSymbols is the number of LoRa preamble symbols.
+	preambleSymbols = 8
+)
+
+// Count returns the number of chunks needed to send the payload using
+// the given chunk size (which includes the chunk header).
+func Count(payloadSize, chunkSize int) int {
+	dataSize := chunkSize - headerSize
+	if dataSize <= 0 {
+		return 0
+	}
+	return (payloadSize + dataSize - 1) / dataSize
+}
+
+// Get returns the chunk with the given (zero-based) index of the payload.
+// The chunk starts with the chunk index and the chunk count (both uint16, big
+// endian) so that the device can reassemble the image and detect missing
+// chunks.
+func Get(payload []byte, chunkSize, index int) ([]byte, error) {
+	count := Count(len(payload), chunkSize)
+	if index < 0 || index >= count {
+		return nil, fmt.Errorf("chunk index %d out of range (chunk count: %d)", index, count)
+	}
+	if count > math.MaxUint16 {
+		return nil, fmt.Errorf("chunk count %d exceeds max. chunk count", count)
+	}
+
+	dataSize := chunkSize - headerSize
+	start := index * dataSize
+	end := start + dataSize
+	if end > len(payload) {
+		end = len(payload)
+	}
+
+	b := make([]byte, headerSize, headerSize+end-start)
+	binary.BigEndian.PutUint16(b[0:2], uint16(index))
+	binary.BigEndian.PutUint16(b[2:4], uint16(count))
+	return append(b, payload[start:end]...), nil
+}
+
+// Airtime returns the LoRa time-on-air of a downlink with the given
+// FRMPayload size, spreading-factor and bandwidth (kHz). Downlinks use the
+// explicit header mode, coding-rate 4/5 and have no payload CRC.
+func Airtime(frmPayloadSize, spreadingFactor, bandwidth int) time.Duration {
+	if spreadingFactor == 0 || bandwidth == 0 {
+		return 0
+	}
+
+	sf := float64(spreadingFactor)
+	tSym := math.Pow(2, sf) / float64(bandwidth*1000)
+	tPreamble := (preambleSymbols + 4.25) * tSym
+
+	// low data-rate optimization is mandated for SF11 and SF12 at 125kHz
+	var de float64
+	if spreadingFactor >= 11 && bandwidth == 125 {
+		de = 1
+	}
+
+	pl := float64(frmPayloadSize + phyPayloadOverhead)
+	payloadSymbols := 8 + math.Max(math.Ceil((8*pl-4*sf+28)/(4*(sf-2*de)))*5, 0)
+
+	return time.Duration((tPreamble + payloadSymbols*tSym) * float64(time.Second))
+}
+
+// Interval returns the minimum interval between two chunks sent to the
+// same device, so that the given duty-cycle (e.g. 0.01 for 1%) is respected.
+func Interval(chunkSize, spreadingFactor, bandwidth int, dutyCycle float64) time.Duration {
+	if dutyCycle <= 0 || dutyCycle > 1 {
+		return 0
+	}
+	return time.Duration(float64(Airtime(chunkSize, spreadingFactor, bandwidth)) / dutyCycle)
+}
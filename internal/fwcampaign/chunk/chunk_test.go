@@ -0,0 +1,68 @@
+package chunk
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunk(t *testing.T) {
+	assert := require.New(t)
+
+	payload := []byte{1, 2, 3, 4, 5, 6, 7}
+
+	assert.Equal(0, Count(len(payload), 4))
+	assert.Equal(3, Count(len(payload), 7))
+	assert.Equal(1, Count(len(payload), 11))
+
+	b, err := Get(payload, 7, 0)
+	assert.NoError(err)
+	assert.Equal([]byte{0, 0, 0, 3, 1, 2, 3}, b)
+
+	b, err = Get(payload, 7, 2)
+	assert.NoError(err)
+	assert.Equal([]byte{0, 2, 0, 3, 7}, b)
+
+	_, err = Get(payload, 7, 3)
+	assert.EqualError(err, "chunk index 3 out of range (chunk count: 3)")
+}
+
+func TestAirtime(t *testing.T) {
+	tests := []struct {
+		Name            string
+		FRMPayloadSize  int
+		SpreadingFactor int
+		Bandwidth       int
+		Expected        time.Duration
+	}{
+		{
+			Name:            "SF7 BW125",
+			FRMPayloadSize:  51,
+			SpreadingFactor: 7,
+			Bandwidth:       125,
+			Expected:        118016 * time.Microsecond,
+		},
+		{
+			Name:            "SF12 BW125",
+			FRMPayloadSize:  51,
+			SpreadingFactor: 12,
+			Bandwidth:       125,
+			Expected:        2793472 * time.Microsecond,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(tst.Expected, Airtime(tst.FRMPayloadSize, tst.SpreadingFactor, tst.Bandwidth))
+		})
+	}
+}
+
+func TestInterval(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal(11801600*time.Microsecond, Interval(51, 7, 125, 0.01))
+	assert.Equal(time.Duration(0), Interval(51, 7, 125, 0))
+}
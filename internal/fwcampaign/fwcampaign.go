@@ -0,0 +1,255 @@
+// Package fwcampaign implements the firmware update campaigns for unicast
+// devices. The firmware image is sent in chunks to each device of the
+// campaign, one chunk at a time, paced by the chunk interval of the campaign.
+package fwcampaign
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	pb //"github.com/ibrahimozekici/lora-api/go/v3/as/integration"
+	// "github.com/ibrahimozekici/lora-api/go/v3/ns"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/fwcampaign/chunk"
+	"github.com/ibrahimozekici/app-server2/internal/integration"
+	"github.com/ibrahimozekici/app-server2/internal/leader"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+// Integration event name and type of the firmware campaign events.
+const (
+	integrationName      = "firmware_campaign"
+	deviceCompletedEvent = "device_completed"
+)
+
+var (
+	syncInterval  = time.Second
+	syncBatchSize = 100
+)
+
+// deviceCompleted defines the object of the device completed event.
+type deviceCompleted struct {
+	FirmwareCampaignID uuid.UUID                           `json:"firmwareCampaignID"`
+	State              storage.FirmwareCampaignDeviceState `json:"state"`
+	ChunksSent         int                                 `json:"chunksSent"`
+	ChunkCount         int                                 `json:"chunkCount"`
+	ErrorMessage       string                              `json:"errorMessage,omitempty"`
+}
+
+// Setup configures the package and starts the firmware campaign loop.
+func Setup(conf config.Config) error {
+	if conf.ApplicationServer.FirmwareCampaign.SyncInterval > 0 {
+		syncInterval = conf.ApplicationServer.FirmwareCampaign.SyncInterval
+	}
+	if conf.ApplicationServer.FirmwareCampaign.SyncBatchSize > 0 {
+		syncBatchSize = conf.ApplicationServer.FirmwareCampaign.SyncBatchSize
+	}
+
+	go firmwareCampaignLoop()
+
+	return nil
+}
+
+func firmwareCampaignLoop() {
+	for {
+		ctxID, err := uuid.NewV4()
+		if err != nil {
+			log.WithError(err).Error("new uuid error")
+		}
+
+		ctx := context.Background()
+		ctx = context.WithValue(ctx, logging.ContextIDKey, ctxID)
+
+		err = leader.Do(ctx, "fwcampaign", func(ctx context.Context) error {
+			var completed []storage.FirmwareCampaignDevice
+			err := storage.Transaction(func(tx sqlx.Ext) error {
+				var err error
+				completed, err = SyncFirmwareCampaigns(ctx, tx)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, fcd := range completed {
+				if err := sendDeviceCompletedEvent(ctx, fcd); err != nil {
+					log.WithError(err).WithField("dev_eui", fcd.DevEUI).Error("send firmware campaign event error")
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.WithError(err).Error("firmware campaign error")
+		}
+
+		time.Sleep(syncInterval)
+	}
+}
+
+// SyncFirmwareCampaigns enqueues the next chunk for each pending firmware
+// campaign device for which the chunk interval has passed and of which the
+// device-queue is empty. It returns the devices which completed the
+// campaign.
+func SyncFirmwareCampaigns(ctx context.Context, db sqlx.Ext) ([]storage.FirmwareCampaignDevice, error) {
+	items, err := storage.GetPendingFirmwareCampaignDevices(ctx, db, syncBatchSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "get pending firmware campaign devices error")
+	}
+
+	campaigns := make(map[uuid.UUID]storage.FirmwareCampaign)
+	var out []storage.FirmwareCampaignDevice
+
+	for i := range items {
+		fcd := &items[i]
+
+		fc, ok := campaigns[fcd.FirmwareCampaignID]
+		if !ok {
+			fc, err = storage.GetFirmwareCampaign(ctx, db, fcd.FirmwareCampaignID)
+			if err != nil {
+				return nil, errors.Wrap(err, "get firmware campaign error")
+			}
+			campaigns[fc.ID] = fc
+		}
+
+		if err := syncDevice(ctx, db, fc, fcd); err != nil {
+			return nil, errors.Wrapf(err, "sync firmware campaign device error, dev_eui: %s", fcd.DevEUI)
+		}
+
+		if fcd.State != storage.FirmwareCampaignDevicePending {
+			out = append(out, *fcd)
+
+			if _, err := storage.CompleteFirmwareCampaign(ctx, db, fc.ID); err != nil {
+				return nil, errors.Wrap(err, "complete firmware campaign error")
+			}
+		}
+	}
+
+	return out, nil
+}
+
+func syncDevice(ctx context.Context, db sqlx.Ext, fc storage.FirmwareCampaign, fcd *storage.FirmwareCampaignDevice) error {
+	now := time.Now()
+
+	// the next chunk is only enqueued once the previous one has been sent
+	queueSize, err := getDeviceQueueSize(ctx, db, fcd.DevEUI)
+	if err != nil {
+		return errors.Wrap(err, "get device-queue size error")
+	}
+	if queueSize > 0 {
+		fcd.NextChunkAfter = now.Add(fc.ChunkInterval)
+		return storage.UpdateFirmwareCampaignDevice(ctx, db, fcd)
+	}
+
+	if fcd.NextChunk >= fcd.ChunkCount {
+		fcd.State = storage.FirmwareCampaignDeviceSuccess
+		fcd.CompletedAt = &now
+		return storage.UpdateFirmwareCampaignDevice(ctx, db, fcd)
+	}
+
+	b, err := chunk.Get(fc.Payload, fc.ChunkSize, fcd.NextChunk)
+	if err != nil {
+		return errors.Wrap(err, "get chunk error")
+	}
+
+	if _, err := storage.EnqueueDownlinkPayload(ctx, db, fcd.DevEUI, false, fc.FPort, b); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"firmware_campaign_id": fc.ID,
+			"dev_eui":              fcd.DevEUI,
+			"chunk":                fcd.NextChunk,
+			"ctx_id":               ctx.Value(logging.ContextIDKey),
+		}).Error("fwcampaign: enqueue chunk error")
+
+		fcd.State = storage.FirmwareCampaignDeviceError
+		fcd.ErrorMessage = err.Error()
+		fcd.CompletedAt = &now
+		return storage.UpdateFirmwareCampaignDevice(ctx, db, fcd)
+	}
+
+	fcd.NextChunk++
+	fcd.NextChunkAfter = now.Add(fc.ChunkInterval)
+	return storage.UpdateFirmwareCampaignDevice(ctx, db, fcd)
+}
+
+func getDeviceQueueSize(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64) (int, error) {
+	n, err := storage.GetNetworkServerForDevEUI(ctx, db, devEUI)
+	if err != nil {
+		return 0, errors.Wrap(err, "get network-server error")
+	}
+
+	nsClient, err := networkserver.GetPool().Get(n.Server, []byte(n.CACert), []byte(n.TLSCert), []byte(n.TLSKey))
+	if err != nil {
+		return 0, errors.Wrap(err, "get network-server client error")
+	}
+
+	resp, err := nsClient.GetDeviceQueueItemsForDevEUI(ctx, &ns.GetDeviceQueueItemsForDevEUIRequest{
+		DevEui:    devEUI[:],
+		CountOnly: true,
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "get device-queue items error")
+	}
+
+	return int(resp.TotalCount), nil
+}
+
+func sendDeviceCompletedEvent(ctx context.Context, fcd storage.FirmwareCampaignDevice) error {
+	d, err := storage.GetDevice(ctx, storage.DB(), fcd.DevEUI, false, true)
+	if err != nil {
+		return errors.Wrap(err, "get device error")
+	}
+
+	app, err := storage.GetApplication(ctx, storage.DB(), d.ApplicationID)
+	if err != nil {
+		return errors.Wrap(err, "get application error")
+	}
+
+	b, err := json.Marshal(deviceCompleted{
+		FirmwareCampaignID: fcd.FirmwareCampaignID,
+		State:              fcd.State,
+		ChunksSent:         fcd.NextChunk,
+		ChunkCount:         fcd.ChunkCount,
+		ErrorMessage:       fcd.ErrorMessage,
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshal json error")
+	}
+
+	pl := pb.IntegrationEvent{
+		ApplicationId:   uint64(app.ID),
+		ApplicationName: app.Name,
+		DeviceName:      d.Name,
+		DevEui:          d.DevEUI[:],
+		Tags:            make(map[string]string),
+		IntegrationName: integrationName,
+		EventType:       deviceCompletedEvent,
+		ObjectJson:      string(b),
+	}
+
+	for k, v := range d.Tags.Map {
+		if v.Valid {
+			pl.Tags[k] = v.String
+		}
+	}
+
+	vars := make(map[string]string)
+	for k, v := range d.Variables.Map {
+		if v.Valid {
+			vars[k] = v.String
+		}
+	}
+
+	if err := integration.ForApplicationID(app.ID).HandleIntegrationEvent(ctx, vars, pl); err != nil {
+		return errors.Wrap(err, "send integration event error")
+	}
+
+	return nil
+}
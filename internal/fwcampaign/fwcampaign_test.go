@@ -0,0 +1,177 @@
+package fwcampaign
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	//"github.com/ibrahimozekici/lora-api/go/v3/ns"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	nsmock "github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	"github.com/ibrahimozekici/app-server2/internal/test"
+	//"github.com/brocaar/lorawan"
+)
+
+type FirmwareCampaignTestSuite struct {
+	suite.Suite
+
+	tx       *storage.TxLogger
+	nsClient *nsmock.Client
+
+	Device storage.Device
+}
+
+func (ts *FirmwareCampaignTestSuite) SetupSuite() {
+	assert := require.New(ts.T())
+	conf := test.GetConfig()
+	assert.NoError(storage.Setup(conf))
+	test.MustResetDB(storage.DB().DB)
+}
+
+func (ts *FirmwareCampaignTestSuite) TearDownTest() {
+	ts.tx.Rollback()
+}
+
+func (ts *FirmwareCampaignTestSuite) SetupTest() {
+	assert := require.New(ts.T())
+	var err error
+	ts.tx, err = storage.DB().Beginx()
+	assert.NoError(err)
+
+	ts.nsClient = nsmock.NewClient()
+	networkserver.SetPool(nsmock.NewPool(ts.nsClient))
+
+	n := storage.NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), ts.tx, &n))
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), ts.tx, &org))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), ts.tx, &sp))
+	var spID uuid.UUID
+	copy(spID[:], sp.ServiceProfile.Id)
+
+	dp := storage.DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(storage.CreateDeviceProfile(context.Background(), ts.tx, &dp))
+	var dpID uuid.UUID
+	copy(dpID[:], dp.DeviceProfile.Id)
+
+	app := storage.Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), ts.tx, &app))
+
+	ts.Device = storage.Device{
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "test-device",
+		DevAddr:         lorawan.DevAddr{1, 2, 3, 4},
+		AppSKey:         lorawan.AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 1, 2, 3, 4, 5, 6, 7, 8},
+	}
+	assert.NoError(storage.CreateDevice(context.Background(), ts.tx, &ts.Device))
+}
+
+func (ts *FirmwareCampaignTestSuite) TestSyncFirmwareCampaigns() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	fc := storage.FirmwareCampaign{
+		ApplicationID:   ts.Device.ApplicationID,
+		Name:            "test-campaign",
+		FPort:           10,
+		Payload:         []byte{1, 2, 3, 4, 5},
+		ChunkSize:       7,
+		SpreadingFactor: 7,
+		Bandwidth:       125,
+		DutyCycle:       1,
+	}
+	assert.NoError(storage.CreateFirmwareCampaign(ctx, ts.tx, &fc, []lorawan.EUI64{ts.Device.DevEUI}))
+
+	// makes the next chunk of all devices due
+	due := func() {
+		_, err := ts.tx.Exec("update firmware_campaign_device set next_chunk_after = now()")
+		assert.NoError(err)
+	}
+
+	ts.T().Run("First chunk", func(t *testing.T) {
+		assert := require.New(t)
+
+		completed, err := SyncFirmwareCampaigns(ctx, ts.tx)
+		assert.NoError(err)
+		assert.Len(completed, 0)
+
+		req := <-ts.nsClient.CreateDeviceQueueItemChan
+		assert.EqualValues(10, req.Item.FPort)
+		assert.Len(req.Item.FrmPayload, 7)
+
+		devices, err := storage.GetFirmwareCampaignDevices(ctx, ts.tx, fc.ID, 10, 0)
+		assert.NoError(err)
+		assert.Equal(1, devices[0].NextChunk)
+		assert.Equal(2, devices[0].ChunkCount)
+	})
+
+	ts.T().Run("Device-queue not empty", func(t *testing.T) {
+		assert := require.New(t)
+		due()
+
+		ts.nsClient.GetDeviceQueueItemsForDevEUIResponse = ns.GetDeviceQueueItemsForDevEUIResponse{
+			TotalCount: 1,
+		}
+		completed, err := SyncFirmwareCampaigns(ctx, ts.tx)
+		assert.NoError(err)
+		assert.Len(completed, 0)
+		assert.Len(ts.nsClient.CreateDeviceQueueItemChan, 0)
+	})
+
+	ts.T().Run("Last chunk", func(t *testing.T) {
+		assert := require.New(t)
+		due()
+
+		ts.nsClient.GetDeviceQueueItemsForDevEUIResponse = ns.GetDeviceQueueItemsForDevEUIResponse{}
+		completed, err := SyncFirmwareCampaigns(ctx, ts.tx)
+		assert.NoError(err)
+		assert.Len(completed, 0)
+
+		req := <-ts.nsClient.CreateDeviceQueueItemChan
+		assert.Len(req.Item.FrmPayload, 6)
+	})
+
+	ts.T().Run("Completed", func(t *testing.T) {
+		assert := require.New(t)
+		due()
+
+		completed, err := SyncFirmwareCampaigns(ctx, ts.tx)
+		assert.NoError(err)
+		assert.Len(completed, 1)
+		assert.Equal(storage.FirmwareCampaignDeviceSuccess, completed[0].State)
+
+		fcGet, err := storage.GetFirmwareCampaign(ctx, ts.tx, fc.ID)
+		assert.NoError(err)
+		assert.Equal(storage.FirmwareCampaignDone, fcGet.State)
+	})
+}
+
+func TestFirmwareCampaign(t *testing.T) {
+	suite.Run(t, new(FirmwareCampaignTestSuite))
+}
@@ -0,0 +1,236 @@
+// Package geojson implements the GeoJSON types returned by the map layer
+// API and the geometry of the geofences, which are stored as polygon rings.
+// All positions are in longitude, latitude order as defined by GeoJSON.
+package geojson
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// maxRingPositions defines the max. number of positions of a ring.
+const maxRingPositions = 1000
+
+// FeatureCollection defines a GeoJSON feature collection.
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+// Feature defines a GeoJSON feature.
+type Feature struct {
+	Type       string      `json:"type"`
+	ID         string      `json:"id,omitempty"`
+	Geometry   Geometry    `json:"geometry"`
+	Properties interface{} `json:"properties"`
+}
+
+// Geometry defines a GeoJSON geometry.
+type Geometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// Position defines a position as longitude, latitude pair.
+type Position [2]float64
+
+// Ring defines a polygon ring. The ring does not need to be closed, the
+// first position is repeated when the ring is used as polygon.
+type Ring []Position
+
+// NewFeatureCollection returns an empty feature collection.
+func NewFeatureCollection() FeatureCollection {
+	return FeatureCollection{
+		Type:     "FeatureCollection",
+		Features: []Feature{},
+	}
+}
+
+// Add adds a feature with the given id, geometry and properties.
+func (fc *FeatureCollection) Add(id string, g Geometry, properties interface{}) {
+	fc.Features = append(fc.Features, Feature{
+		Type:       "Feature",
+		ID:         id,
+		Geometry:   g,
+		Properties: properties,
+	})
+}
+
+// NewPosition returns the position for the given latitude and longitude.
+func NewPosition(latitude, longitude float64) Position {
+	return Position{longitude, latitude}
+}
+
+// Longitude returns the longitude of the position.
+func (p Position) Longitude() float64 {
+	return p[0]
+}
+
+// Latitude returns the latitude of the position.
+func (p Position) Latitude() float64 {
+	return p[1]
+}
+
+// Valid returns true when the latitude and longitude are within range.
+func (p Position) Valid() bool {
+	return p.Latitude() >= -90 && p.Latitude() <= 90 && p.Longitude() >= -180 && p.Longitude() <= 180
+}
+
+// Point returns the point geometry of the given position.
+func Point(p Position) Geometry {
+	return Geometry{
+		Type:        "Point",
+		Coordinates: p,
+	}
+}
+
+// Polygon returns the polygon geometry of the given ring.
+func Polygon(r Ring) Geometry {
+	return Geometry{
+		Type:        "Polygon",
+		Coordinates: []Ring{r.Closed()},
+	}
+}
+
+// Validate validates the ring. A ring must have at least three distinct
+// positions, all within range.
+func (r Ring) Validate() error {
+	open := r.open()
+	if len(open) < 3 {
+		return errors.New("a ring must have at least 3 positions")
+	}
+	if len(open) > maxRingPositions {
+		return fmt.Errorf("a ring can have max. %d positions", maxRingPositions)
+	}
+
+	distinct := make(map[Position]struct{}, len(open))
+	for i, p := range open {
+		if !p.Valid() {
+			return fmt.Errorf("position %d is out of range", i)
+		}
+		distinct[p] = struct{}{}
+	}
+	if len(distinct) < 3 {
+		return errors.New("a ring must have at least 3 distinct positions")
+	}
+
+	return nil
+}
+
+// Closed returns the ring with the first position repeated at the end.
+func (r Ring) Closed() Ring {
+	if len(r) == 0 || r[0] == r[len(r)-1] {
+		return r
+	}
+
+	out := make(Ring, 0, len(r)+1)
+	out = append(out, r...)
+	return append(out, r[0])
+}
+
+// Contains returns true when the given position is inside the ring (using
+// the even-odd rule).
+func (r Ring) Contains(p Position) bool {
+	open := r.open()
+	inside := false
+
+	for i, j := 0, len(open)-1; i < len(open); j, i = i, i+1 {
+		a, b := open[i], open[j]
+		if (a.Latitude() > p.Latitude()) != (b.Latitude() > p.Latitude()) &&
+			p.Longitude() < (b.Longitude()-a.Longitude())*(p.Latitude()-a.Latitude())/(b.Latitude()-a.Latitude())+a.Longitude() {
+			inside = !inside
+		}
+	}
+
+	return inside
+}
+
+// Value implements the driver.Valuer interface.
+func (r Ring) Value() (driver.Value, error) {
+	if r == nil {
+		return "[]", nil
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (r *Ring) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, r)
+	case string:
+		return json.Unmarshal([]byte(src), r)
+	case nil:
+		*r = nil
+		return nil
+	default:
+		return fmt.Errorf("expected []byte, got %T", src)
+	}
+}
+
+// open returns the ring without the closing position.
+func (r Ring) open() Ring {
+	if len(r) > 1 && r[0] == r[len(r)-1] {
+		return r[:len(r)-1]
+	}
+	return r
+}
+
+// ConvexHull returns the convex hull of the given positions as (open) ring
+// in counter-clockwise order, using the monotone chain algorithm. Nil is
+// returned when the positions do not span an area, e.g. when there are less
+// than three distinct positions or when all positions are on a line.
+func ConvexHull(positions []Position) Ring {
+	points := make([]Position, len(positions))
+	copy(points, positions)
+
+	sort.Slice(points, func(i, j int) bool {
+		if points[i][0] != points[j][0] {
+			return points[i][0] < points[j][0]
+		}
+		return points[i][1] < points[j][1]
+	})
+
+	cross := func(o, a, b Position) float64 {
+		return (a[0]-o[0])*(b[1]-o[1]) - (a[1]-o[1])*(b[0]-o[0])
+	}
+
+	hull := make(Ring, 0, 2*len(points))
+
+	// lower hull
+	for _, p := range points {
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+
+	// upper hull
+	lower := len(hull) + 1
+	for i := len(points) - 2; i >= 0; i-- {
+		p := points[i]
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+
+	// the last position equals the first one
+	if len(hull) > 0 {
+		hull = hull[:len(hull)-1]
+	}
+
+	if len(hull) < 3 {
+		return nil
+	}
+
+	return hull
+}
@@ -0,0 +1,138 @@
+package geojson
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRingValidate(t *testing.T) {
+	tests := []struct {
+		Name  string
+		Ring  Ring
+		Valid bool
+	}{
+		{
+			Name:  "triangle",
+			Ring:  Ring{{0, 0}, {1, 0}, {0, 1}},
+			Valid: true,
+		},
+		{
+			Name:  "closed triangle",
+			Ring:  Ring{{0, 0}, {1, 0}, {0, 1}, {0, 0}},
+			Valid: true,
+		},
+		{
+			Name: "two positions",
+			Ring: Ring{{0, 0}, {1, 0}, {0, 0}},
+		},
+		{
+			Name: "duplicate positions",
+			Ring: Ring{{0, 0}, {1, 0}, {1, 0}, {0, 0}, {0, 0}},
+		},
+		{
+			Name: "latitude out of range",
+			Ring: Ring{{0, 0}, {1, 0}, {0, 91}},
+		},
+		{
+			Name: "longitude out of range",
+			Ring: Ring{{0, 0}, {-181, 0}, {0, 1}},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			err := tst.Ring.Validate()
+			if tst.Valid {
+				assert.NoError(err)
+			} else {
+				assert.Error(err)
+			}
+		})
+	}
+}
+
+func TestRingContains(t *testing.T) {
+	assert := require.New(t)
+
+	// L-shaped ring
+	r := Ring{{0, 0}, {2, 0}, {2, 1}, {1, 1}, {1, 2}, {0, 2}}
+
+	assert.True(r.Contains(NewPosition(0.5, 0.5)))
+	assert.True(r.Contains(NewPosition(1.5, 0.5)))
+	assert.True(r.Contains(NewPosition(0.5, 1.5)))
+	assert.False(r.Contains(NewPosition(1.5, 1.5)))
+	assert.False(r.Contains(NewPosition(-0.5, 0.5)))
+	assert.False(r.Contains(NewPosition(0.5, 3)))
+
+	// closing the ring does not change the result
+	assert.True(r.Closed().Contains(NewPosition(0.5, 0.5)))
+	assert.False(r.Closed().Contains(NewPosition(1.5, 1.5)))
+}
+
+func TestConvexHull(t *testing.T) {
+	t.Run("Square with inner positions", func(t *testing.T) {
+		assert := require.New(t)
+
+		hull := ConvexHull([]Position{
+			{1, 1}, {0, 0}, {2, 2}, {0, 2}, {2, 0}, {1, 0}, {0.5, 1.5}, {2, 2},
+		})
+		assert.Equal(Ring{{0, 0}, {2, 0}, {2, 2}, {0, 2}}, hull)
+	})
+
+	t.Run("Not enough positions", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.Nil(ConvexHull(nil))
+		assert.Nil(ConvexHull([]Position{{1, 1}}))
+		assert.Nil(ConvexHull([]Position{{1, 1}, {2, 2}, {1, 1}}))
+	})
+
+	t.Run("Collinear positions", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.Nil(ConvexHull([]Position{{0, 0}, {1, 1}, {2, 2}, {3, 3}}))
+	})
+}
+
+func TestFeatureCollection(t *testing.T) {
+	assert := require.New(t)
+
+	fc := NewFeatureCollection()
+	fc.Add("gw", Point(NewPosition(51.5, 4.25)), map[string]string{"kind": "gateway"})
+	fc.Add("", Polygon(Ring{{0, 0}, {1, 0}, {0, 1}}), nil)
+
+	b, err := json.Marshal(fc)
+	assert.NoError(err)
+	assert.JSONEq(`{
+		"type": "FeatureCollection",
+		"features": [
+			{
+				"type": "Feature",
+				"id": "gw",
+				"geometry": {"type": "Point", "coordinates": [4.25, 51.5]},
+				"properties": {"kind": "gateway"}
+			},
+			{
+				"type": "Feature",
+				"geometry": {"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [0, 1], [0, 0]]]},
+				"properties": null
+			}
+		]
+	}`, string(b))
+}
+
+func TestRingValueScan(t *testing.T) {
+	assert := require.New(t)
+
+	r := Ring{{4.25, 51.5}, {4.5, 51.5}, {4.5, 51.75}}
+	v, err := r.Value()
+	assert.NoError(err)
+	assert.Equal("[[4.25,51.5],[4.5,51.5],[4.5,51.75]]", v)
+
+	var out Ring
+	assert.NoError(out.Scan([]byte(v.(string))))
+	assert.Equal(r, out)
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"crypto/rand"
 	"database/sql"
-	"fmt"
 	"time"
 
 	"github.com/gofrs/uuid"
@@ -16,6 +15,7 @@ import (
 	// "github.com/ibrahimozekici/lora-api/go/v3/as"
 	// "github.com/ibrahimozekici/lora-api/go/v3/ns"
 	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/leader"
 	"github.com/ibrahimozekici/app-server2/internal/logging"
 	"github.com/ibrahimozekici/app-server2/internal/storage"
 	//"github.com/brocaar/lorawan"
@@ -37,7 +37,8 @@ func SendPingLoop() {
 		ctx := context.Background()
 		ctx = context.WithValue(ctx, logging.ContextIDKey, ctxID)
 
-		if err := sendGatewayPing(ctx); err != nil {
+		err = leader.Do(ctx, "gwping", sendGatewayPing)
+		if err != nil {
 			log.Errorf("send gateway ping error: %s", err)
 		}
 		time.Sleep(time.Second)
@@ -222,7 +223,7 @@ func sendPing(mic lorawan.MIC, n storage.NetworkServer, ping storage.GatewayPing
 
 // CreatePingLookup creates an automatically expiring MIC to ping id lookup.
 func CreatePingLookup(mic lorawan.MIC, id int64) error {
-	key := fmt.Sprintf(micLookupTempl, mic)
+	key := storage.GetRedisKey(micLookupTempl, mic)
 
 	err := storage.RedisClient().Set(key, id, micLookupExpire).Err()
 	if err != nil {
@@ -232,7 +233,7 @@ func CreatePingLookup(mic lorawan.MIC, id int64) error {
 }
 
 func getPingLookup(mic lorawan.MIC) (int64, error) {
-	key := fmt.Sprintf(micLookupTempl, mic)
+	key := storage.GetRedisKey(micLookupTempl, mic)
 
 	id, err := storage.RedisClient().Get(key).Int64()
 	if err != nil {
@@ -243,7 +244,7 @@ func getPingLookup(mic lorawan.MIC) (int64, error) {
 }
 
 func deletePingLookup(mic lorawan.MIC) error {
-	key := fmt.Sprintf(micLookupTempl, mic)
+	key := storage.GetRedisKey(micLookupTempl, mic)
 
 	err := storage.RedisClient().Del(key).Err()
 	if err != nil {
@@ -0,0 +1,89 @@
+// Package hsm implements the storage of device root keys in a hardware
+// security module (HSM). Keys imported into the HSM can not be extracted,
+// all cryptographic operations using these keys are performed by the HSM.
+package hsm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	//"github.com/brocaar/lorawan"
+)
+
+// Key types.
+const (
+	NwkKey = "nwk_key"
+	AppKey = "app_key"
+)
+
+var (
+	ks             KeyStore
+	keyLabelPrefix = "lora-as"
+)
+
+// KeyStore defines the interface for storing and using AES-128 keys, which
+// are referenced by their label.
+type KeyStore interface {
+	// ImportKey imports the given key under the given label. An existing key
+	// with the same label is replaced.
+	ImportKey(ctx context.Context, label string, key []byte) error
+
+	// DeleteKey deletes the key with the given label. It does not return an
+	// error when the key does not exist.
+	DeleteKey(ctx context.Context, label string) error
+
+	// Encrypt encrypts the given blocks using AES-ECB.
+	Encrypt(ctx context.Context, label string, b []byte) ([]byte, error)
+
+	// Decrypt decrypts the given blocks using AES-ECB.
+	Decrypt(ctx context.Context, label string, b []byte) ([]byte, error)
+
+	// CMAC returns the AES-CMAC of the given data.
+	CMAC(ctx context.Context, label string, b []byte) ([]byte, error)
+}
+
+// Setup configures the hsm package.
+func Setup(conf config.Config) error {
+	c := conf.JoinServer.HSM
+	if c.Module == "" {
+		ks = nil
+		return nil
+	}
+
+	if c.KeyLabelPrefix != "" {
+		keyLabelPrefix = c.KeyLabelPrefix
+	}
+
+	var err error
+	ks, err = newPKCS11(conf)
+	if err != nil {
+		return errors.Wrap(err, "setup pkcs#11 error")
+	}
+
+	log.WithFields(log.Fields{
+		"module":      c.Module,
+		"token_label": c.TokenLabel,
+	}).Info("hsm: pkcs#11 key-store configured")
+
+	return nil
+}
+
+// GetKeyStore returns the configured key-store. It returns nil when no HSM
+// has been configured.
+func GetKeyStore() KeyStore {
+	return ks
+}
+
+// SetKeyStore sets the key-store.
+func SetKeyStore(s KeyStore) {
+	ks = s
+}
+
+// KeyLabel returns the label of the given key type for the given DevEUI.
+func KeyLabel(devEUI lorawan.EUI64, keyType string) string {
+	return fmt.Sprintf("%s-%s-%s", keyLabelPrefix, devEUI, keyType)
+}
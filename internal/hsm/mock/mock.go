@@ -0,0 +1,150 @@
+// Package mock implements a software key-store for testing.
+package mock
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"sync"
+)
+
+// KeyStore is a software (in-memory) implementation of the hsm.KeyStore
+// interface.
+type KeyStore struct {
+	sync.RWMutex
+
+	Keys map[string][]byte
+}
+
+// New returns a new KeyStore.
+func New() *KeyStore {
+	return &KeyStore{
+		Keys: make(map[string][]byte),
+	}
+}
+
+// ImportKey imports the given key under the given label.
+func (s *KeyStore) ImportKey(ctx context.Context, label string, key []byte) error {
+	if _, err := aes.NewCipher(key); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.Keys[label] = append([]byte{}, key...)
+	return nil
+}
+
+// DeleteKey deletes the key with the given label.
+func (s *KeyStore) DeleteKey(ctx context.Context, label string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.Keys, label)
+	return nil
+}
+
+// Encrypt encrypts the given blocks using AES-ECB.
+func (s *KeyStore) Encrypt(ctx context.Context, label string, b []byte) ([]byte, error) {
+	block, err := s.getBlock(label, b)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, len(b))
+	for i := 0; i < len(b); i += aes.BlockSize {
+		block.Encrypt(out[i:i+aes.BlockSize], b[i:i+aes.BlockSize])
+	}
+	return out, nil
+}
+
+// Decrypt decrypts the given blocks using AES-ECB.
+func (s *KeyStore) Decrypt(ctx context.Context, label string, b []byte) ([]byte, error) {
+	block, err := s.getBlock(label, b)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, len(b))
+	for i := 0; i < len(b); i += aes.BlockSize {
+		block.Decrypt(out[i:i+aes.BlockSize], b[i:i+aes.BlockSize])
+	}
+	return out, nil
+}
+
+// CMAC returns the AES-CMAC (RFC 4493) of the given data.
+func (s *KeyStore) CMAC(ctx context.Context, label string, b []byte) ([]byte, error) {
+	block, err := s.getBlock(label, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// subkeys
+	k1 := make([]byte, aes.BlockSize)
+	block.Encrypt(k1, k1)
+	k1 = shiftLeft(k1)
+	k2 := shiftLeft(k1)
+
+	n := (len(b) + aes.BlockSize - 1) / aes.BlockSize
+	complete := n > 0 && len(b)%aes.BlockSize == 0
+	if n == 0 {
+		n = 1
+	}
+
+	last := make([]byte, aes.BlockSize)
+	if complete {
+		copy(last, b[(n-1)*aes.BlockSize:])
+		xor(last, k1)
+	} else {
+		copy(last, b[(n-1)*aes.BlockSize:])
+		last[len(b)-(n-1)*aes.BlockSize] = 0x80
+		xor(last, k2)
+	}
+
+	x := make([]byte, aes.BlockSize)
+	for i := 0; i < n-1; i++ {
+		xor(x, b[i*aes.BlockSize:(i+1)*aes.BlockSize])
+		block.Encrypt(x, x)
+	}
+	xor(x, last)
+	block.Encrypt(x, x)
+
+	return x, nil
+}
+
+func (s *KeyStore) getBlock(label string, b []byte) (cipher.Block, error) {
+	if len(b)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("data must be a multiple of %d bytes", aes.BlockSize)
+	}
+
+	s.RLock()
+	key, ok := s.Keys[label]
+	s.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("key not found, label: %s", label)
+	}
+
+	return aes.NewCipher(key)
+}
+
+// shiftLeft returns the given block shifted left by one bit, xor-ed with
+// Rb when the most significant bit was set.
+func shiftLeft(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i := 0; i < len(b)-1; i++ {
+		out[i] = b[i]<<1 | b[i+1]>>7
+	}
+	out[len(b)-1] = b[len(b)-1] << 1
+	if b[0]&0x80 != 0 {
+		out[len(b)-1] ^= 0x87
+	}
+	return out
+}
+
+func xor(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
@@ -0,0 +1,64 @@
+package mock
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCMAC(t *testing.T) {
+	// test vectors from RFC 4493
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	msg, _ := hex.DecodeString("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710")
+
+	tests := []struct {
+		Length   int
+		Expected string
+	}{
+		{0, "bb1d6929e95937287fa37d129b756746"},
+		{16, "070a16b46b4d4144f79bdd9dd04a287c"},
+		{40, "dfa66747de9ae63030ca32611497c827"},
+		{64, "51f0bebf7e3b9d92fc49741779363cfe"},
+	}
+
+	ks := New()
+	require.NoError(t, ks.ImportKey(context.Background(), "test", key))
+
+	for _, tst := range tests {
+		t.Run(hex.EncodeToString(msg[:tst.Length]), func(t *testing.T) {
+			assert := require.New(t)
+
+			mac, err := ks.CMAC(context.Background(), "test", msg[:tst.Length])
+			assert.NoError(err)
+			assert.Equal(tst.Expected, hex.EncodeToString(mac))
+		})
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	assert := require.New(t)
+
+	// test vector from FIPS 197
+	key, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	plain, _ := hex.DecodeString("00112233445566778899aabbccddeeff")
+
+	ks := New()
+	assert.NoError(ks.ImportKey(context.Background(), "test", key))
+
+	b, err := ks.Encrypt(context.Background(), "test", plain)
+	assert.NoError(err)
+	assert.Equal("69c4e0d86a7b0430d8cdb78070b4c55a", hex.EncodeToString(b))
+
+	b, err = ks.Decrypt(context.Background(), "test", b)
+	assert.NoError(err)
+	assert.Equal(plain, b)
+
+	_, err = ks.Encrypt(context.Background(), "test", plain[:5])
+	assert.Error(err)
+
+	assert.NoError(ks.DeleteKey(context.Background(), "test"))
+	_, err = ks.Encrypt(context.Background(), "test", plain)
+	assert.Error(err)
+}
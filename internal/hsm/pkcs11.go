@@ -0,0 +1,220 @@
+// +build pkcs11
+
+package hsm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+// pkcs11KeyStore implements the KeyStore interface using a PKCS#11 module.
+// A single session is used, access to it is serialized.
+type pkcs11KeyStore struct {
+	sync.Mutex
+
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+}
+
+func newPKCS11(conf config.Config) (KeyStore, error) {
+	c := conf.JoinServer.HSM
+
+	p := pkcs11.New(c.Module)
+	if p == nil {
+		return nil, fmt.Errorf("load pkcs#11 module error, module: %s", c.Module)
+	}
+
+	if err := p.Initialize(); err != nil {
+		return nil, errors.Wrap(err, "initialize error")
+	}
+
+	slots, err := p.GetSlotList(true)
+	if err != nil {
+		return nil, errors.Wrap(err, "get slot list error")
+	}
+
+	var slot uint
+	var found bool
+	for _, s := range slots {
+		ti, err := p.GetTokenInfo(s)
+		if err != nil {
+			return nil, errors.Wrap(err, "get token info error")
+		}
+
+		if c.TokenLabel == "" || ti.Label == c.TokenLabel {
+			slot = s
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("token not found, token_label: %s", c.TokenLabel)
+	}
+
+	session, err := p.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		return nil, errors.Wrap(err, "open session error")
+	}
+
+	if err := p.Login(session, pkcs11.CKU_USER, c.PIN); err != nil {
+		return nil, errors.Wrap(err, "login error")
+	}
+
+	return &pkcs11KeyStore{
+		ctx:     p,
+		session: session,
+	}, nil
+}
+
+func (s *pkcs11KeyStore) ImportKey(ctx context.Context, label string, key []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.deleteKey(label); err != nil {
+		return err
+	}
+
+	_, err := s.ctx.CreateObject(s.session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, key),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, true),
+		pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+	})
+	if err != nil {
+		return errors.Wrap(err, "create object error")
+	}
+
+	return nil
+}
+
+func (s *pkcs11KeyStore) DeleteKey(ctx context.Context, label string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.deleteKey(label)
+}
+
+func (s *pkcs11KeyStore) Encrypt(ctx context.Context, label string, b []byte) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	obj, err := s.findKey(label)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.ctx.EncryptInit(s.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_ECB, nil)}, obj); err != nil {
+		return nil, errors.Wrap(err, "encrypt init error")
+	}
+
+	out, err := s.ctx.Encrypt(s.session, b)
+	if err != nil {
+		return nil, errors.Wrap(err, "encrypt error")
+	}
+
+	return out, nil
+}
+
+func (s *pkcs11KeyStore) Decrypt(ctx context.Context, label string, b []byte) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	obj, err := s.findKey(label)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.ctx.DecryptInit(s.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_ECB, nil)}, obj); err != nil {
+		return nil, errors.Wrap(err, "decrypt init error")
+	}
+
+	out, err := s.ctx.Decrypt(s.session, b)
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypt error")
+	}
+
+	return out, nil
+}
+
+func (s *pkcs11KeyStore) CMAC(ctx context.Context, label string, b []byte) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	obj, err := s.findKey(label)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.ctx.SignInit(s.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_CMAC, nil)}, obj); err != nil {
+		return nil, errors.Wrap(err, "sign init error")
+	}
+
+	out, err := s.ctx.Sign(s.session, b)
+	if err != nil {
+		return nil, errors.Wrap(err, "sign error")
+	}
+
+	return out, nil
+}
+
+func (s *pkcs11KeyStore) findObjects(label string) ([]pkcs11.ObjectHandle, error) {
+	if err := s.ctx.FindObjectsInit(s.session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}); err != nil {
+		return nil, errors.Wrap(err, "find objects init error")
+	}
+
+	objs, _, err := s.ctx.FindObjects(s.session, 10)
+	if err != nil {
+		s.ctx.FindObjectsFinal(s.session)
+		return nil, errors.Wrap(err, "find objects error")
+	}
+
+	if err := s.ctx.FindObjectsFinal(s.session); err != nil {
+		return nil, errors.Wrap(err, "find objects final error")
+	}
+
+	return objs, nil
+}
+
+func (s *pkcs11KeyStore) findKey(label string) (pkcs11.ObjectHandle, error) {
+	objs, err := s.findObjects(label)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(objs) == 0 {
+		return 0, fmt.Errorf("key not found, label: %s", label)
+	}
+
+	return objs[0], nil
+}
+
+func (s *pkcs11KeyStore) deleteKey(label string) error {
+	objs, err := s.findObjects(label)
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objs {
+		if err := s.ctx.DestroyObject(s.session, obj); err != nil {
+			return errors.Wrap(err, "destroy object error")
+		}
+	}
+
+	return nil
+}
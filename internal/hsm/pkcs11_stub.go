@@ -0,0 +1,13 @@
+// +build !pkcs11
+
+package hsm
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+func newPKCS11(conf config.Config) (KeyStore, error) {
+	return nil, errors.New("pkcs#11 support is not compiled in, build with the pkcs11 tag")
+}
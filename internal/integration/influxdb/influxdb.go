@@ -22,6 +22,7 @@ import (
 	pb //"github.com/ibrahimozekici/lora-api/go/v3/as/integration"
 	"github.com/ibrahimozekici/app-server2/internal/integration/models"
 	"github.com/ibrahimozekici/app-server2/internal/logging"
+	meas "github.com/ibrahimozekici/app-server2/internal/measurement"
 	//"github.com/brocaar/lorawan"
 )
 
@@ -217,8 +218,13 @@ func (i *Integration) HandleUplinkEvent(ctx context.Context, _ models.Integratio
 		measurements[0].Values["snr"] = snr
 	}
 
-	// parse object to measurements
-	measurements = append(measurements, objectToMeasurements(pl, "device_frmpayload_data", obj)...)
+	// parse object to measurements, when measurements are configured in the
+	// device-profile, only these are written
+	if mapped, ok := meas.FromContext(ctx); ok {
+		measurements = append(measurements, mappedToMeasurements(pl, "device_frmpayload_data", mapped)...)
+	} else {
+		measurements = append(measurements, objectToMeasurements(pl, "device_frmpayload_data", obj)...)
+	}
 
 	if len(measurements) == 0 {
 		return nil
@@ -406,6 +412,40 @@ func objectToMeasurements(pl pb.UplinkEvent, prefix string, obj interface{}) []m
 	return out
 }
 
+// mappedToMeasurements returns the measurements for the measurements
+// extracted using the device-profile measurement definitions.
+func mappedToMeasurements(pl pb.UplinkEvent, prefix string, mapped []meas.Measurement) []measurement {
+	var out []measurement
+
+	var devEUI lorawan.EUI64
+	copy(devEUI[:], pl.DevEui)
+
+	for _, m := range mapped {
+		tags := map[string]string{
+			"application_name": pl.ApplicationName,
+			"device_name":      pl.DeviceName,
+			"dev_eui":          devEUI.String(),
+			"f_port":           strconv.FormatInt(int64(pl.FPort), 10),
+		}
+		for k, v := range pl.Tags {
+			tags[k] = v
+		}
+		if m.Unit != "" {
+			tags["unit"] = m.Unit
+		}
+
+		out = append(out, measurement{
+			Name: prefix + "_" + m.Name,
+			Tags: tags,
+			Values: map[string]interface{}{
+				"value": m.Value,
+			},
+		})
+	}
+
+	return out
+}
+
 func mapToLocation(pl pb.UplinkEvent, prefix string, obj reflect.Value) []measurement {
 	var latFloat, longFloat float64
 
@@ -16,6 +16,7 @@ import (
 	pb //"github.com/ibrahimozekici/lora-api/go/v3/as/integration"
 	// "github.com/ibrahimozekici/lora-api/go/v3/gw"
 	"github.com/ibrahimozekici/app-server2/internal/integration/models"
+	meas "github.com/ibrahimozekici/app-server2/internal/measurement"
 )
 
 func init() {
@@ -119,6 +120,7 @@ device_status_margin,application_name=test-app,dev_eui=0102030405060708,device_n
 func (ts *HandlerTestSuite) TestUplink() {
 	tests := []struct {
 		Name         string
+		Context      context.Context
 		Payload      pb.UplinkEvent
 		ExpectedBody string
 	}{
@@ -304,6 +306,35 @@ device_uplink,application_name=test-app,dev_eui=0102030405060708,device_name=tes
 			ExpectedBody: `device_frmpayload_data_active,application_name=test-app,dev_eui=0102030405060708,device_name=test-dev,f_port=20,foo=bar value=true
 device_frmpayload_data_location,application_name=test-app,dev_eui=0102030405060708,device_name=test-dev,f_port=20,foo=bar geohash="s01w2k3vvqre",latitude=1.123000,longitude=2.123000
 device_frmpayload_data_status,application_name=test-app,dev_eui=0102030405060708,device_name=test-dev,f_port=20,foo=bar value="on"
+device_uplink,application_name=test-app,dev_eui=0102030405060708,device_name=test-dev,dr=2,foo=bar,frequency=868100000 f_cnt=10i,value=1i`,
+		},
+		{
+			Name: "Measurement definitions",
+			Context: meas.NewContext(context.Background(), []meas.Measurement{
+				{Path: "sensor.temp", Name: "temperature", Kind: meas.Gauge, Unit: "C", Value: 25.4},
+				{Path: "status", Name: "status", Kind: meas.String, Value: "on"},
+			}),
+			Payload: pb.UplinkEvent{
+				ApplicationName: "test-app",
+				DeviceName:      "test-dev",
+				DevEui:          []byte{1, 2, 3, 4, 5, 6, 7, 8},
+				FCnt:            10,
+				FPort:           20,
+				Dr:              2,
+				TxInfo: &gw.UplinkTXInfo{
+					Frequency: 868100000,
+				},
+				ObjectJson: `{
+					"sensor": {"temp": 25.4},
+					"humidity": 20,
+					"status": "on"
+				}`,
+				Tags: map[string]string{
+					"foo": "bar",
+				},
+			},
+			ExpectedBody: `device_frmpayload_data_status,application_name=test-app,dev_eui=0102030405060708,device_name=test-dev,f_port=20,foo=bar value="on"
+device_frmpayload_data_temperature,application_name=test-app,dev_eui=0102030405060708,device_name=test-dev,f_port=20,foo=bar,unit=C value=25.400000
 device_uplink,application_name=test-app,dev_eui=0102030405060708,device_name=test-dev,dr=2,foo=bar,frequency=868100000 f_cnt=10i,value=1i`,
 		},
 	}
@@ -311,7 +342,13 @@ device_uplink,application_name=test-app,dev_eui=0102030405060708,device_name=tes
 	for _, tst := range tests {
 		ts.T().Run(tst.Name, func(t *testing.T) {
 			assert := require.New(t)
-			assert.NoError(ts.Handler.HandleUplinkEvent(context.Background(), nil, nil, tst.Payload))
+
+			ctx := tst.Context
+			if ctx == nil {
+				ctx = context.Background()
+			}
+
+			assert.NoError(ts.Handler.HandleUplinkEvent(ctx, nil, nil, tst.Payload))
 			req := <-ts.Requests
 			assert.Equal("/write", req.URL.Path)
 			assert.Equal(url.Values{
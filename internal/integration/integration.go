@@ -53,6 +53,8 @@ var (
 func Setup(conf config.Config) error {
 	log.Info("integration: configuring global integrations")
 
+	multi.SetSlowThreshold(conf.Monitoring.SlowIntegrationThreshold)
+
 	var ints []models.IntegrationHandler
 
 	// setup marshaler
@@ -107,6 +109,61 @@ func Setup(conf config.Config) error {
 	return nil
 }
 
+// RetentionHandlers returns the global integrations which persist device
+// events and / or locations.
+func RetentionHandlers() []models.RetentionHandler {
+	var out []models.RetentionHandler
+	for _, i := range globalIntegrations {
+		if h, ok := i.(models.RetentionHandler); ok {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// ArchiveHandlers returns the global integrations which persist device
+// events which can be archived.
+func ArchiveHandlers() []models.ArchiveHandler {
+	var out []models.ArchiveHandler
+	for _, i := range globalIntegrations {
+		if h, ok := i.(models.ArchiveHandler); ok {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// HealthCheckers returns the global integrations which depend on an external
+// service.
+func HealthCheckers() []models.HealthChecker {
+	var out []models.HealthChecker
+	for _, i := range globalIntegrations {
+		if h, ok := i.(models.HealthChecker); ok {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// Close waits for the pending integration deliveries and then closes the
+// global integrations, after these have handled their queued events.
+func Close() error {
+	multi.Wait()
+
+	var failed bool
+	for _, i := range globalIntegrations {
+		if err := i.Close(); err != nil {
+			failed = true
+			log.WithError(err).WithField("integration", fmt.Sprintf("%T", i)).Error("integration: close integration error")
+		}
+	}
+
+	if failed {
+		return errors.New("close integrations error")
+	}
+	return nil
+}
+
 // ForApplicationID returns the integration handler for the given application ID.
 // The returned handler will be a "multi-handler", containing both the global
 // integrations and the integrations setup specifically for the given
@@ -265,7 +322,7 @@ func ForApplicationID(id int64) models.Integration {
 		ints = append(ints, i)
 	}
 
-	return multi.New(globalIntegrations, ints)
+	return multi.NewForApplication(id, globalIntegrations, ints)
 }
 
 // SetMockIntegration mocks the integration.
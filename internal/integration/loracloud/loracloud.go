@@ -239,6 +239,7 @@ func (i *Integration) geolocation(ctx context.Context, devEUI lorawan.EUI64, geo
 				"payload_field": i.config.GeolocationGNSSPayloadField,
 			}).Debug("integration/loracloud: no gnss bytes found in object")
 		} else {
+			meterGeolocationCall(ctx, devEUI, pl.ApplicationId)
 			loc, err := i.gnssLR1110Geolocation(ctx, devEUI, pl.RxInfo, gnssPL)
 			return nil, loc, err
 		}
@@ -262,6 +263,7 @@ func (i *Integration) geolocation(ctx context.Context, devEUI lorawan.EUI64, geo
 				"payload_field": i.config.GeolocationWifiPayloadField,
 			}).Debug("integration/loracloud: no wifi access-points found in object")
 		} else {
+			meterGeolocationCall(ctx, devEUI, pl.ApplicationId)
 			loc, err := i.wifiTDOAGeolocation(ctx, devEUI, pl.RxInfo, wifiAPs)
 			return nil, loc, err
 		}
@@ -283,6 +285,7 @@ func (i *Integration) geolocation(ctx context.Context, devEUI lorawan.EUI64, geo
 				}
 			}
 
+			meterGeolocationCall(ctx, devEUI, pl.ApplicationId)
 			loc, err := i.tdoaGeolocation(ctx, devEUI, tdoaFiltered)
 			return uplinkIDs, loc, err
 		}
@@ -302,6 +305,7 @@ func (i *Integration) geolocation(ctx context.Context, devEUI lorawan.EUI64, geo
 					uplinkIDs = append(uplinkIDs, geolocBuffer[i][j].GetUplinkId())
 				}
 			}
+			meterGeolocationCall(ctx, devEUI, pl.ApplicationId)
 			loc, err := i.rssiGeolocation(ctx, devEUI, geolocBuffer)
 			return uplinkIDs, loc, err
 		}
@@ -310,6 +314,17 @@ func (i *Integration) geolocation(ctx context.Context, devEUI lorawan.EUI64, geo
 	return nil, nil, nil
 }
 
+// meterGeolocationCall meters the geolocation API call for the organization
+// of the application.
+func meterGeolocationCall(ctx context.Context, devEUI lorawan.EUI64, applicationID uint64) {
+	if err := storage.IncrementApplicationUsage(ctx, int64(applicationID), storage.UsageGeolocationCalls, 1); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"dev_eui": devEUI,
+			"ctx_id":  ctx.Value(logging.ContextIDKey),
+		}).Error("integration/loracloud: meter geolocation call error")
+	}
+}
+
 func (i *Integration) tdoaGeolocation(ctx context.Context, devEUI lorawan.EUI64, geolocBuffer [][]*gw.UplinkRXInfo) (*common.Location, error) {
 	client := geolocation.New(i.geolocationURI, i.config.GeolocationToken)
 	start := time.Now()
@@ -14,6 +14,7 @@ import (
 
 	// "github.com/ibrahimozekici/lora-api/go/v3/as/integration"
 	"github.com/ibrahimozekici/app-server2/internal/integration/models"
+	"github.com/ibrahimozekici/app-server2/internal/roaming"
 	"github.com/ibrahimozekici/app-server2/internal/storage"
 	//"github.com/brocaar/lorawan"
 )
@@ -117,6 +118,7 @@ func jsonv3MarshalUplinkEvent(msg *integration.UplinkEvent) ([]byte, error) {
 		rxInfo := models.RXInfo{
 			RSSI:    int(msg.RxInfo[i].Rssi),
 			LoRaSNR: float64(msg.RxInfo[i].LoraSnr),
+			Roaming: roaming.GetInfo(msg.RxInfo[i]),
 		}
 
 		copy(rxInfo.GatewayID[:], msg.RxInfo[i].GatewayId)
@@ -174,6 +176,7 @@ func jsonv3MarshalJoinEvent(msg *integration.JoinEvent) ([]byte, error) {
 		rxInfo := models.RXInfo{
 			RSSI:    int(msg.RxInfo[i].Rssi),
 			LoRaSNR: float64(msg.RxInfo[i].LoraSnr),
+			Roaming: roaming.GetInfo(msg.RxInfo[i]),
 		}
 
 		copy(rxInfo.GatewayID[:], msg.RxInfo[i].GatewayId)
@@ -2,6 +2,7 @@ package models
 
 import (
 	"context"
+	"time"
 
 	// "github.com/ibrahimozekici/lora-api/go/v3/as/integration"
 )
@@ -36,3 +37,38 @@ type IntegrationHandler interface {
 	DataDownChan() chan DataDownPayload
 	Close() error
 }
+
+// RetentionHandler defines the interface implemented by integrations which
+// persist the device events and locations, so that the per application
+// retention policies can be enforced.
+type RetentionHandler interface {
+	DeleteApplicationEvents(ctx context.Context, applicationID int64, before time.Time) (int64, error)
+	DeleteApplicationLocations(ctx context.Context, applicationID int64, before time.Time) (int64, error)
+}
+
+// ArchiveHandler defines the interface implemented by integrations which
+// persist the device events, so that the events can be archived to object
+// storage.
+type ArchiveHandler interface {
+	// ArchiveEventTables returns the tables containing the events.
+	ArchiveEventTables() []string
+
+	// GetOldestEventTime returns the timestamp of the oldest event in the
+	// given table, or nil when the table is empty.
+	GetOldestEventTime(ctx context.Context, table string) (*time.Time, error)
+
+	// GetArchiveEvents returns the events in the given table and time range.
+	GetArchiveEvents(ctx context.Context, table string, start, end time.Time) ([]ArchiveEvent, error)
+
+	// DeleteEvents deletes the events in the given table and time range.
+	DeleteEvents(ctx context.Context, table string, start, end time.Time) (int64, error)
+}
+
+// HealthChecker defines the interface implemented by integrations which
+// depend on an external service (e.g. a MQTT broker), so that the readiness
+// of the application-server can take this service into account.
+type HealthChecker interface {
+	// HealthCheck returns an error when the external service is not
+	// reachable.
+	HealthCheck(ctx context.Context) error
+}
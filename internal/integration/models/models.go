@@ -6,6 +6,8 @@ import (
 
 	//"github.com/brocaar/lorawan"
 	"github.com/gofrs/uuid"
+
+	"github.com/ibrahimozekici/app-server2/internal/roaming"
 )
 
 // Location details.
@@ -24,6 +26,7 @@ type RXInfo struct {
 	RSSI      int           `json:"rssi"`
 	LoRaSNR   float64       `json:"loRaSNR"`
 	Location  *Location     `json:"location"`
+	Roaming   *roaming.Info `json:"roaming,omitempty"`
 }
 
 // TXInfo contains the TX information.
@@ -49,7 +52,8 @@ type DataUpPayload struct {
 	Variables       map[string]string `json:"-"`
 }
 
-// DataDownPayload represents a data-down payload.
+// DataDownPayload represents a data-down payload. When CorrelationID is not
+// set, a correlation ID is assigned to the downlink.
 type DataDownPayload struct {
 	ApplicationID int64           `json:"applicationID,string"`
 	DevEUI        lorawan.EUI64   `json:"devEUI"`
@@ -57,6 +61,8 @@ type DataDownPayload struct {
 	FPort         uint8           `json:"fPort"`
 	Data          []byte          `json:"data"`
 	Object        json.RawMessage `json:"object"`
+	ExpiresAt     *time.Time      `json:"expiresAt,omitempty"`
+	CorrelationID *uuid.UUID      `json:"correlationID,omitempty"`
 }
 
 // JoinNotification defines the payload sent to the application on
@@ -148,3 +154,12 @@ type IntegrationNotification struct {
 	Tags            map[string]string `json:"tags,omitempty"`
 	Object          interface{}       `json:"object"`
 }
+
+// ArchiveEvent defines an event row for archival. The data contains the
+// complete row encoded as JSON.
+type ArchiveEvent struct {
+	DevEUI        lorawan.EUI64   `db:"dev_eui"`
+	ApplicationID int64           `db:"application_id"`
+	ReceivedAt    time.Time       `db:"received_at"`
+	Data          json.RawMessage `db:"data"`
+}
@@ -194,6 +194,37 @@ func New(m marshaler.Type, conf config.IntegrationMQTTConfig) (*Integration, err
 	return &i, nil
 }
 
+// CheckConnection tests the connection to the MQTT broker once, using the
+// given timeout. Unlike New, this does not retry.
+func CheckConnection(conf config.IntegrationMQTTConfig, timeout time.Duration) error {
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(conf.Server)
+	opts.SetUsername(conf.Username)
+	opts.SetPassword(conf.Password)
+	opts.SetConnectTimeout(timeout)
+	opts.SetAutoReconnect(false)
+
+	tlsconfig, err := newTLSConfig(conf.CACert, conf.TLSCert, conf.TLSKey)
+	if err != nil {
+		return errors.Wrap(err, "load mqtt certificate files error")
+	}
+	if tlsconfig != nil {
+		opts.SetTLSConfig(tlsconfig)
+	}
+
+	conn := mqtt.NewClient(opts)
+	token := conn.Connect()
+	if !token.WaitTimeout(timeout) {
+		return errors.New("connect to mqtt broker timeout")
+	}
+	if err := token.Error(); err != nil {
+		return errors.Wrap(err, "connect to mqtt broker error")
+	}
+	conn.Disconnect(0)
+
+	return nil
+}
+
 func newTLSConfig(cafile, certFile, certKeyFile string) (*tls.Config, error) {
 	// Here are three valid options:
 	//   - Only CA
@@ -246,6 +277,15 @@ func (i *Integration) Close() error {
 	return nil
 }
 
+// HealthCheck returns an error when the connection to the MQTT broker is
+// not open. Note that the client reconnects automatically.
+func (i *Integration) HealthCheck(ctx context.Context) error {
+	if !i.conn.IsConnectionOpen() {
+		return errors.New("not connected to mqtt broker")
+	}
+	return nil
+}
+
 // HandleUplinkEvent sends an UplinkEvent.
 func (i *Integration) HandleUplinkEvent(ctx context.Context, _ models.Integration, vars map[string]string, payload pb.UplinkEvent) error {
 	return i.publish(ctx, payload.ApplicationId, payload.DevEui, "up", &payload)
@@ -393,7 +433,7 @@ func (i *Integration) txPayloadHandler(mqttc mqtt.Client, msg mqtt.Message) {
 	// Since with MQTT all subscribers will receive the downlink messages sent
 	// by the application, the first instance receiving the message must lock it,
 	// so that other instances can ignore the message.
-	key := fmt.Sprintf("lora:as:downlink:lock:%d:%s", pl.ApplicationID, pl.DevEUI)
+	key := storage.GetRedisKey("lora:as:downlink:lock:%d:%s", pl.ApplicationID, pl.DevEUI)
 	set, err := storage.RedisClient().SetNX(key, "lock", downlinkLockTTL).Result()
 	if err != nil {
 		log.WithError(err).Error("integration/mqtt: acquire lock error")
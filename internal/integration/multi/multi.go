@@ -4,16 +4,52 @@ package multi
 import (
 	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 
 	pb //"github.com/ibrahimozekici/lora-api/go/v3/as/integration"
 	"github.com/ibrahimozekici/app-server2/internal/integration/models"
 	"github.com/ibrahimozekici/app-server2/internal/logging"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	"github.com/ibrahimozekici/app-server2/internal/tracing"
 )
 
+// slowThreshold holds the duration above which integration deliveries are
+// logged as slow delivery. A threshold of 0 disables the slow delivery
+// logging.
+var slowThreshold time.Duration
+
+// SetSlowThreshold sets the slow delivery threshold.
+func SetSlowThreshold(d time.Duration) {
+	slowThreshold = d
+}
+
+// failedDeliveries holds the total number of events which could not be
+// delivered to an integration. These events are not retried.
+var failedDeliveries uint64
+
+// FailedDeliveries returns the total number of events which could not be
+// delivered to an integration since the start of the process.
+func FailedDeliveries() uint64 {
+	return atomic.LoadUint64(&failedDeliveries)
+}
+
+// pending tracks the integration deliveries which have not yet completed.
+var pending sync.WaitGroup
+
+// Wait blocks until all pending integration deliveries (including the
+// storing of the application delivery metrics) have completed.
+func Wait() {
+	pending.Wait()
+}
+
 // Integration implements the multi integration.
 type Integration struct {
+	applicationID      int64
 	globalIntegrations []models.IntegrationHandler
 	appIntegrations    []models.IntegrationHandler
 }
@@ -26,132 +62,85 @@ func New(global, app []models.IntegrationHandler) *Integration {
 	}
 }
 
+// NewForApplication creates a new multi-integration for the given
+// application. Next to delivering the events, the number of delivered and
+// failed events are stored as aggregated metrics of the application.
+func NewForApplication(applicationID int64, global, app []models.IntegrationHandler) *Integration {
+	return &Integration{
+		applicationID:      applicationID,
+		globalIntegrations: global,
+		appIntegrations:    app,
+	}
+}
+
 // HandleUplinkEvent sends an UplinkEvent.
 func (i *Integration) HandleUplinkEvent(ctx context.Context, vars map[string]string, pl pb.UplinkEvent) error {
-	for _, ii := range i.integrations() {
-
-		go func(ii models.IntegrationHandler) {
-			if err := ii.HandleUplinkEvent(ctx, i, vars, pl); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"integration": fmt.Sprintf("%T", ii),
-					"ctx_id":      ctx.Value(logging.ContextIDKey),
-				}).Error("integration/multi: integration error")
-			}
-		}(ii)
-	}
+	i.handle(ctx, "up", func(ctx context.Context, ii models.IntegrationHandler) error {
+		return ii.HandleUplinkEvent(ctx, i, vars, pl)
+	})
 
 	return nil
 }
 
 // HandleJoinEvent sends a JoinEvent.
 func (i *Integration) HandleJoinEvent(ctx context.Context, vars map[string]string, pl pb.JoinEvent) error {
-	for _, ii := range i.integrations() {
-		go func(ii models.IntegrationHandler) {
-			if err := ii.HandleJoinEvent(ctx, i, vars, pl); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"integration": fmt.Sprintf("%T", ii),
-					"ctx_id":      ctx.Value(logging.ContextIDKey),
-				}).Error("integration/multi: integration error")
-			}
-		}(ii)
-	}
+	i.handle(ctx, "join", func(ctx context.Context, ii models.IntegrationHandler) error {
+		return ii.HandleJoinEvent(ctx, i, vars, pl)
+	})
 
 	return nil
 }
 
 // HandleAckEvent sends an AckEvent.
 func (i *Integration) HandleAckEvent(ctx context.Context, vars map[string]string, pl pb.AckEvent) error {
-	for _, ii := range i.integrations() {
-		go func(ii models.IntegrationHandler) {
-			if err := ii.HandleAckEvent(ctx, i, vars, pl); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"integration": fmt.Sprintf("%T", ii),
-					"ctx_id":      ctx.Value(logging.ContextIDKey),
-				}).Error("integration/multi: integration error")
-			}
-		}(ii)
-	}
+	i.handle(ctx, "ack", func(ctx context.Context, ii models.IntegrationHandler) error {
+		return ii.HandleAckEvent(ctx, i, vars, pl)
+	})
 
 	return nil
 }
 
 // HandleErrorEvent sends an ErrorEvent.
 func (i *Integration) HandleErrorEvent(ctx context.Context, vars map[string]string, pl pb.ErrorEvent) error {
-
-	for _, ii := range i.integrations() {
-		go func(ii models.IntegrationHandler) {
-			if err := ii.HandleErrorEvent(ctx, i, vars, pl); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"integration": fmt.Sprintf("%T", ii),
-					"ctx_id":      ctx.Value(logging.ContextIDKey),
-				}).Error("integration/multi: integration error")
-			}
-		}(ii)
-	}
+	i.handle(ctx, "error", func(ctx context.Context, ii models.IntegrationHandler) error {
+		return ii.HandleErrorEvent(ctx, i, vars, pl)
+	})
 
 	return nil
 }
 
 // HandleStatusEvent sends a StatusEvent.
 func (i *Integration) HandleStatusEvent(ctx context.Context, vars map[string]string, pl pb.StatusEvent) error {
-	for _, ii := range i.integrations() {
-		go func(ii models.IntegrationHandler) {
-			if err := ii.HandleStatusEvent(ctx, i, vars, pl); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"integration": fmt.Sprintf("%T", ii),
-					"ctx_id":      ctx.Value(logging.ContextIDKey),
-				}).Error("integration/multi: integration error")
-			}
-		}(ii)
-	}
+	i.handle(ctx, "status", func(ctx context.Context, ii models.IntegrationHandler) error {
+		return ii.HandleStatusEvent(ctx, i, vars, pl)
+	})
 
 	return nil
 }
 
 // HandleLocationEvent sends a LocationEvent.
 func (i *Integration) HandleLocationEvent(ctx context.Context, vars map[string]string, pl pb.LocationEvent) error {
-	for _, ii := range i.integrations() {
-		go func(ii models.IntegrationHandler) {
-			if err := ii.HandleLocationEvent(ctx, i, vars, pl); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"integration": fmt.Sprintf("%T", ii),
-					"ctx_id":      ctx.Value(logging.ContextIDKey),
-				}).Error("integration/multi: integration error")
-			}
-		}(ii)
-	}
+	i.handle(ctx, "location", func(ctx context.Context, ii models.IntegrationHandler) error {
+		return ii.HandleLocationEvent(ctx, i, vars, pl)
+	})
 
 	return nil
 }
 
 // HandleTxAckEvent sends a TxAckEvent.
 func (i *Integration) HandleTxAckEvent(ctx context.Context, vars map[string]string, pl pb.TxAckEvent) error {
-	for _, ii := range i.integrations() {
-		go func(ii models.IntegrationHandler) {
-			if err := ii.HandleTxAckEvent(ctx, i, vars, pl); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"integration": fmt.Sprintf("%T", ii),
-					"ctx_id":      ctx.Value(logging.ContextIDKey),
-				}).Error("integration/multi: integration error")
-			}
-		}(ii)
-	}
+	i.handle(ctx, "txack", func(ctx context.Context, ii models.IntegrationHandler) error {
+		return ii.HandleTxAckEvent(ctx, i, vars, pl)
+	})
 
 	return nil
 }
 
 // HandleIntegrationEvent sends an IntegrationEvent.
 func (i *Integration) HandleIntegrationEvent(ctx context.Context, vars map[string]string, pl pb.IntegrationEvent) error {
-	for _, ii := range i.integrations() {
-		go func(ii models.IntegrationHandler) {
-			if err := ii.HandleIntegrationEvent(ctx, i, vars, pl); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"integration": fmt.Sprintf("%T", ii),
-					"ctx_id":      ctx.Value(logging.ContextIDKey),
-				}).Error("integration/multi: integration error")
-			}
-		}(ii)
-	}
+	i.handle(ctx, "integration", func(ctx context.Context, ii models.IntegrationHandler) error {
+		return ii.HandleIntegrationEvent(ctx, i, vars, pl)
+	})
 
 	return nil
 }
@@ -167,6 +156,96 @@ func (i *Integration) DataDownChan() chan models.DataDownPayload {
 	return nil
 }
 
+// handle calls f for each integration in a separate Go-routine, such that a
+// slow integration does not block the other integrations. For each
+// integration the delivery is traced and its duration and errors are
+// measured. Once delivered to all integrations, the delivery statistics are
+// stored for the application (if set).
+func (i *Integration) handle(ctx context.Context, event string, f func(context.Context, models.IntegrationHandler) error) {
+	ints := i.integrations()
+
+	var wg sync.WaitGroup
+	var failed int64
+	wg.Add(len(ints))
+	pending.Add(len(ints))
+
+	for _, ii := range ints {
+		go func(ii models.IntegrationHandler) {
+			defer pending.Done()
+			defer wg.Done()
+
+			name := fmt.Sprintf("%T", ii)
+
+			inFlight := integrationInFlight(name)
+			inFlight.Inc()
+			defer inFlight.Dec()
+
+			st := integrationStats(name)
+			atomic.AddInt64(&st.InFlight, 1)
+			defer atomic.AddInt64(&st.InFlight, -1)
+
+			ctx, span := tracing.StartSpan(ctx, "integration."+event,
+				attribute.String("integration", name),
+			)
+
+			start := time.Now()
+			err := f(ctx, ii)
+			duration := time.Since(start)
+			integrationEventDuration(name, event).Observe(float64(duration) / float64(time.Second))
+			tracing.EndSpan(span, err)
+
+			if slowThreshold > 0 && duration >= slowThreshold {
+				log.WithFields(log.Fields{
+					"integration": name,
+					"event":       event,
+					"duration":    duration,
+					"threshold":   slowThreshold,
+					"ctx_id":      ctx.Value(logging.ContextIDKey),
+				}).Warning("integration/multi: slow integration delivery")
+			}
+
+			if err != nil {
+				integrationEventErrorCounter(name, event).Inc()
+				atomic.AddUint64(&failedDeliveries, 1)
+				atomic.AddInt64(&failed, 1)
+				atomic.AddInt64(&st.Failed, 1)
+
+				log.WithError(err).WithFields(log.Fields{
+					"integration": name,
+					"event":       event,
+					"ctx_id":      ctx.Value(logging.ContextIDKey),
+				}).Error("integration/multi: integration error")
+			} else {
+				atomic.AddInt64(&st.Delivered, 1)
+			}
+		}(ii)
+	}
+
+	if i.applicationID == 0 || len(ints) == 0 {
+		return
+	}
+
+	pending.Add(1)
+	go func() {
+		defer pending.Done()
+		wg.Wait()
+
+		err := storage.SaveMetrics(ctx, storage.ApplicationMetricsName(i.applicationID), storage.MetricsRecord{
+			Time: time.Now(),
+			Metrics: map[string]float64{
+				storage.ApplicationMetricIntegrationEventCount:      float64(len(ints)),
+				storage.ApplicationMetricIntegrationEventErrorCount: float64(atomic.LoadInt64(&failed)),
+			},
+		})
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"application_id": i.applicationID,
+				"ctx_id":         ctx.Value(logging.ContextIDKey),
+			}).Error("integration/multi: save application metrics error")
+		}
+	}()
+}
+
 // integrations returns a slice with the global and application-integrations
 // combined.
 func (i *Integration) integrations() []models.IntegrationHandler {
@@ -0,0 +1,36 @@
+package multi
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	ed = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "integration_event_duration_seconds",
+		Help:    "The duration of delivering events to the integrations (per integration and event type).",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"integration", "event"})
+
+	ee = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "integration_event_error_count",
+		Help: "The number of events which failed to deliver to the integrations (per integration and event type).",
+	}, []string{"integration", "event"})
+
+	ef = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "integration_event_in_flight",
+		Help: "The number of events currently being delivered to the integrations (per integration).",
+	}, []string{"integration"})
+)
+
+func integrationEventDuration(integration, event string) prometheus.Observer {
+	return ed.With(prometheus.Labels{"integration": integration, "event": event})
+}
+
+func integrationEventErrorCounter(integration, event string) prometheus.Counter {
+	return ee.With(prometheus.Labels{"integration": integration, "event": event})
+}
+
+func integrationInFlight(integration string) prometheus.Gauge {
+	return ef.With(prometheus.Labels{"integration": integration})
+}
@@ -0,0 +1,58 @@
+package multi
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// IntegrationStats contains the delivery statistics of an integration since
+// the start of the process.
+type IntegrationStats struct {
+	InFlight  int64
+	Delivered int64
+	Failed    int64
+}
+
+var (
+	statsMux sync.RWMutex
+	stats    = make(map[string]*IntegrationStats)
+)
+
+// Stats returns the delivery statistics per integration (type).
+func Stats() map[string]IntegrationStats {
+	statsMux.RLock()
+	defer statsMux.RUnlock()
+
+	out := make(map[string]IntegrationStats, len(stats))
+	for name, s := range stats {
+		out[name] = IntegrationStats{
+			InFlight:  atomic.LoadInt64(&s.InFlight),
+			Delivered: atomic.LoadInt64(&s.Delivered),
+			Failed:    atomic.LoadInt64(&s.Failed),
+		}
+	}
+
+	return out
+}
+
+// integrationStats returns the delivery statistics of the given integration,
+// which must be updated atomically.
+func integrationStats(name string) *IntegrationStats {
+	statsMux.RLock()
+	s, ok := stats[name]
+	statsMux.RUnlock()
+	if ok {
+		return s
+	}
+
+	statsMux.Lock()
+	defer statsMux.Unlock()
+
+	if s, ok := stats[name]; ok {
+		return s
+	}
+
+	s = &IntegrationStats{}
+	stats[name] = s
+	return s
+}
@@ -0,0 +1,21 @@
+package multi
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	assert := require.New(t)
+
+	s := integrationStats("test")
+	assert.True(s == integrationStats("test"))
+
+	atomic.AddInt64(&s.InFlight, 2)
+	atomic.AddInt64(&s.Delivered, 10)
+	atomic.AddInt64(&s.Failed, 1)
+
+	assert.Equal(IntegrationStats{InFlight: 2, Delivered: 10, Failed: 1}, Stats()["test"])
+}
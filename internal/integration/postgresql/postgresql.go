@@ -420,6 +420,120 @@ func (i *Integration) DataDownChan() chan models.DataDownPayload {
 	return nil
 }
 
+// eventTables contains the tables in which the device events are stored.
+var eventTables = []string{
+	"device_up",
+	"device_status",
+	"device_join",
+	"device_ack",
+	"device_error",
+}
+
+// DeleteApplicationEvents deletes the events of the given application which
+// were received before the given timestamp.
+func (i *Integration) DeleteApplicationEvents(ctx context.Context, applicationID int64, before time.Time) (int64, error) {
+	var count int64
+	for _, table := range eventTables {
+		ra, err := i.deleteBefore(table, applicationID, before)
+		if err != nil {
+			return count, errors.Wrapf(err, "delete from %s error", table)
+		}
+		count += ra
+	}
+
+	return count, nil
+}
+
+// DeleteApplicationLocations deletes the locations of the given application
+// which were received before the given timestamp.
+func (i *Integration) DeleteApplicationLocations(ctx context.Context, applicationID int64, before time.Time) (int64, error) {
+	ra, err := i.deleteBefore("device_location", applicationID, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "delete from device_location error")
+	}
+	return ra, nil
+}
+
+func (i *Integration) deleteBefore(table string, applicationID int64, before time.Time) (int64, error) {
+	res, err := i.db.Exec(`
+		delete from `+table+`
+		where
+			application_id = $1
+			and received_at < $2`,
+		applicationID,
+		before,
+	)
+	if err != nil {
+		return 0, errors.Wrap(err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "get rows affected error")
+	}
+	return ra, nil
+}
+
+// ArchiveEventTables returns the tables containing the device events.
+func (i *Integration) ArchiveEventTables() []string {
+	return eventTables
+}
+
+// GetOldestEventTime returns the received timestamp of the oldest event in
+// the given table.
+func (i *Integration) GetOldestEventTime(ctx context.Context, table string) (*time.Time, error) {
+	var ts *time.Time
+	if err := sqlx.Get(i.db, &ts, "select min(received_at) from "+table); err != nil {
+		return nil, errors.Wrap(err, "select error")
+	}
+	return ts, nil
+}
+
+// GetArchiveEvents returns the events in the given table and time range,
+// sorted by received timestamp.
+func (i *Integration) GetArchiveEvents(ctx context.Context, table string, start, end time.Time) ([]models.ArchiveEvent, error) {
+	var out []models.ArchiveEvent
+	err := sqlx.Select(i.db, &out, `
+		select
+			dev_eui,
+			application_id,
+			received_at,
+			row_to_json(e)::text as data
+		from
+			`+table+` e
+		where
+			received_at >= $1
+			and received_at < $2
+		order by
+			received_at`,
+		start,
+		end,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "select error")
+	}
+	return out, nil
+}
+
+// DeleteEvents deletes the events in the given table and time range.
+func (i *Integration) DeleteEvents(ctx context.Context, table string, start, end time.Time) (int64, error) {
+	res, err := i.db.Exec(`
+		delete from `+table+`
+		where
+			received_at >= $1
+			and received_at < $2`,
+		start,
+		end,
+	)
+	if err != nil {
+		return 0, errors.Wrap(err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "get rows affected error")
+	}
+	return ra, nil
+}
+
 func getRXInfoJSON(rxInfo []*gw.UplinkRXInfo) (json.RawMessage, error) {
 	var out []models.RXInfo
 	var gatewayIDs []lorawan.EUI64
@@ -731,6 +731,49 @@ func (ts *PostgreSQLTestSuite) TestLocationEvent() {
 	}, loc)
 }
 
+func (ts *PostgreSQLTestSuite) TestDeleteApplicationData() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	for _, appID := range []uint64{1, 2} {
+		assert.NoError(ts.integration.HandleJoinEvent(ctx, nil, nil, pb.JoinEvent{
+			ApplicationId:   appID,
+			ApplicationName: "test-app",
+			DeviceName:      "test-device",
+			DevEui:          []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			DevAddr:         []byte{1, 2, 3, 4},
+			Tags:            map[string]string{"foo": "bar"},
+		}))
+
+		assert.NoError(ts.integration.HandleLocationEvent(ctx, nil, nil, pb.LocationEvent{
+			ApplicationId:   appID,
+			ApplicationName: "test-app",
+			DeviceName:      "test-device",
+			DevEui:          []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			Location:        &common.Location{},
+			Tags:            map[string]string{"foo": "bar"},
+		}))
+	}
+
+	count, err := ts.integration.DeleteApplicationEvents(ctx, 1, time.Now().Add(-time.Hour))
+	assert.NoError(err)
+	assert.EqualValues(0, count)
+
+	count, err = ts.integration.DeleteApplicationEvents(ctx, 1, time.Now())
+	assert.NoError(err)
+	assert.EqualValues(1, count)
+
+	count, err = ts.integration.DeleteApplicationLocations(ctx, 1, time.Now())
+	assert.NoError(err)
+	assert.EqualValues(1, count)
+
+	var joins, locations int
+	assert.NoError(ts.db.Get(&joins, "select count(*) from device_join"))
+	assert.NoError(ts.db.Get(&locations, "select count(*) from device_location"))
+	assert.Equal(1, joins)
+	assert.Equal(1, locations)
+}
+
 func TestPostgreSQL(t *testing.T) {
 	suite.Run(t, new(PostgreSQLTestSuite))
 }
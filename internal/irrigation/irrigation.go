@@ -0,0 +1,270 @@
+// Package irrigation implements the irrigation (relay) schedules. It
+// periodically enqueues the start downlink of the due schedules and the stop
+// downlink of the schedules of which the irrigation duration has passed to
+// the actuator devices of the schedule (e.g. valves or relays). Each enqueued
+// downlink is stored in the execution log of the schedule. Start and Stop
+// implement the manual override of a schedule.
+package irrigation
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/downlink"
+	"github.com/ibrahimozekici/app-server2/internal/integration/models"
+	"github.com/ibrahimozekici/app-server2/internal/leader"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+var (
+	interval  = time.Minute
+	batchSize = 100
+
+	// enqueueDownlink enqueues the downlink, this can be overwritten for
+	// testing.
+	enqueueDownlink = downlink.EnqueueDataDownPayload
+)
+
+// Setup configures the package and starts the loop executing the due
+// irrigation schedules.
+func Setup(conf config.Config) error {
+	c := conf.ApplicationServer.Irrigation
+
+	if c.Interval > 0 {
+		interval = c.Interval
+	}
+	if c.BatchSize > 0 {
+		batchSize = c.BatchSize
+	}
+
+	go loop()
+
+	return nil
+}
+
+func loop() {
+	for {
+		time.Sleep(interval)
+
+		ctxID, err := uuid.NewV4()
+		if err != nil {
+			log.WithError(err).Error("new uuid error")
+		}
+
+		ctx := context.Background()
+		ctx = context.WithValue(ctx, logging.ContextIDKey, ctxID)
+
+		err = leader.Do(ctx, "irrigation", func(ctx context.Context) error {
+			return Run(ctx, time.Now())
+		})
+		if err != nil {
+			log.WithError(err).Error("irrigation: run error")
+		}
+	}
+}
+
+// Run enqueues the due stop downlinks, followed by the start downlinks of
+// the schedules which are due. The activations of paused schedules are
+// skipped.
+func Run(ctx context.Context, now time.Time) error {
+	if err := runStops(ctx, now); err != nil {
+		return errors.Wrap(err, "run stops error")
+	}
+
+	if err := runStarts(ctx, now); err != nil {
+		return errors.Wrap(err, "run starts error")
+	}
+
+	return nil
+}
+
+func runStops(ctx context.Context, now time.Time) error {
+	for {
+		var items []storage.IrrigationSchedule
+
+		// the pending stop is cleared within the transaction, the downlinks
+		// are enqueued afterwards so that these do not hold the locks
+		err := storage.Transaction(func(tx sqlx.Ext) error {
+			var err error
+			items, err = storage.GetDueIrrigationStops(ctx, tx, now, batchSize)
+			if err != nil {
+				return errors.Wrap(err, "get due irrigation stops error")
+			}
+
+			for _, s := range items {
+				if err := storage.SetIrrigationScheduleStopAt(ctx, tx, s.ID, nil); err != nil {
+					return errors.Wrap(err, "set irrigation schedule stop error")
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, s := range items {
+			// the stop downlink might have been removed while irrigating
+			if s.StopDownlink == nil {
+				continue
+			}
+
+			if err := execute(ctx, s, storage.IrrigationStop, storage.IrrigationSourceSchedule); err != nil {
+				return err
+			}
+		}
+
+		if len(items) < batchSize {
+			return nil
+		}
+	}
+}
+
+func runStarts(ctx context.Context, now time.Time) error {
+	for {
+		var items, started []storage.IrrigationSchedule
+
+		// the next activation and the stop are scheduled within the
+		// transaction, the downlinks are enqueued afterwards so that these
+		// do not hold the locks
+		err := storage.Transaction(func(tx sqlx.Ext) error {
+			var err error
+			items, err = storage.GetDueIrrigationSchedules(ctx, tx, now, batchSize)
+			if err != nil {
+				return errors.Wrap(err, "get due irrigation schedules error")
+			}
+
+			for _, s := range items {
+				if err := storage.SetIrrigationScheduleNextRunAt(ctx, tx, s.ID, s.NextRun(now)); err != nil {
+					return errors.Wrap(err, "set irrigation schedule next run error")
+				}
+
+				if s.Paused(now) {
+					log.WithFields(log.Fields{
+						"id":           s.ID,
+						"paused_until": s.PausedUntil,
+						"ctx_id":       ctx.Value(logging.ContextIDKey),
+					}).Info("irrigation: schedule is paused, activation skipped")
+					continue
+				}
+
+				if s.StopDownlink != nil {
+					stopAt := now.Add(s.Duration)
+					if err := storage.SetIrrigationScheduleStopAt(ctx, tx, s.ID, &stopAt); err != nil {
+						return errors.Wrap(err, "set irrigation schedule stop error")
+					}
+				}
+
+				started = append(started, s)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, s := range started {
+			if err := execute(ctx, s, storage.IrrigationStart, storage.IrrigationSourceSchedule); err != nil {
+				return err
+			}
+		}
+
+		if len(items) < batchSize {
+			return nil
+		}
+	}
+}
+
+// Start starts the irrigation of the given schedule (manual override),
+// regardless of its schedule, pause and enabled state. When the schedule has
+// a stop downlink, the stop is scheduled after the duration of the given
+// schedule.
+func Start(ctx context.Context, s storage.IrrigationSchedule) error {
+	if s.StopDownlink != nil {
+		stopAt := time.Now().Add(s.Duration)
+		if err := storage.SetIrrigationScheduleStopAt(ctx, storage.DB(), s.ID, &stopAt); err != nil {
+			return errors.Wrap(err, "set irrigation schedule stop error")
+		}
+	}
+
+	return execute(ctx, s, storage.IrrigationStart, storage.IrrigationSourceManual)
+}
+
+// Stop stops the irrigation of the given schedule (manual override) and
+// clears its pending stop. It returns storage.ErrIrrigationNoStopDownlink
+// when the schedule does not have a stop downlink.
+func Stop(ctx context.Context, s storage.IrrigationSchedule) error {
+	if s.StopDownlink == nil {
+		return storage.ErrIrrigationNoStopDownlink
+	}
+
+	if err := storage.SetIrrigationScheduleStopAt(ctx, storage.DB(), s.ID, nil); err != nil {
+		return errors.Wrap(err, "set irrigation schedule stop error")
+	}
+
+	return execute(ctx, s, storage.IrrigationStop, storage.IrrigationSourceManual)
+}
+
+// execute enqueues the start or stop downlink of the given schedule to each
+// of its devices and stores the result in the execution log. A failing
+// downlink does not prevent the downlinks to the other devices from being
+// enqueued.
+func execute(ctx context.Context, s storage.IrrigationSchedule, action storage.IrrigationAction, source storage.IrrigationSource) error {
+	dl := s.StartDownlink
+	if action == storage.IrrigationStop {
+		dl = *s.StopDownlink
+	}
+
+	devices, err := storage.GetIrrigationScheduleDevices(ctx, storage.DB(), s.ID)
+	if err != nil {
+		return errors.Wrap(err, "get irrigation schedule devices error")
+	}
+
+	object, renderErr := dl.Render(s.Duration)
+
+	for _, d := range devices {
+		e := storage.IrrigationExecution{
+			IrrigationScheduleID: s.ID,
+			DevEUI:               d.DevEUI,
+			Action:               action,
+			Source:               source,
+			Success:              true,
+		}
+
+		err := renderErr
+		if err == nil {
+			err = enqueueDownlink(ctx, models.DataDownPayload{
+				ApplicationID: d.ApplicationID,
+				DevEUI:        d.DevEUI,
+				Confirmed:     dl.Confirmed,
+				FPort:         dl.FPort,
+				Object:        object,
+			})
+		}
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"id":      s.ID,
+				"dev_eui": d.DevEUI,
+				"action":  action,
+				"ctx_id":  ctx.Value(logging.ContextIDKey),
+			}).Error("irrigation: enqueue downlink error")
+
+			e.Success = false
+			e.Error = err.Error()
+		}
+
+		if err := storage.CreateIrrigationExecution(ctx, storage.DB(), &e); err != nil {
+			return errors.Wrap(err, "create irrigation execution error")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,223 @@
+package irrigation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	nsmock "github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/downlink"
+	"github.com/ibrahimozekici/app-server2/internal/integration/models"
+	"github.com/ibrahimozekici/app-server2/internal/irrigation/schedule"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	"github.com/ibrahimozekici/app-server2/internal/test"
+	//"github.com/brocaar/lorawan"
+)
+
+type IrrigationTestSuite struct {
+	suite.Suite
+
+	Devices  []storage.Device
+	Schedule storage.IrrigationSchedule
+}
+
+func (ts *IrrigationTestSuite) SetupSuite() {
+	assert := require.New(ts.T())
+	conf := test.GetConfig()
+	assert.NoError(storage.Setup(conf))
+}
+
+func (ts *IrrigationTestSuite) SetupTest() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	test.MustResetDB(storage.DB().DB)
+	networkserver.SetPool(nsmock.NewPool(nsmock.NewClient()))
+
+	n := storage.NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(ctx, storage.DB(), &n))
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(ctx, storage.DB(), &org))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(ctx, storage.DB(), &sp))
+	var spID uuid.UUID
+	copy(spID[:], sp.ServiceProfile.Id)
+
+	dp := storage.DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(storage.CreateDeviceProfile(ctx, storage.DB(), &dp))
+	var dpID uuid.UUID
+	copy(dpID[:], dp.DeviceProfile.Id)
+
+	app := storage.Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(storage.CreateApplication(ctx, storage.DB(), &app))
+
+	ts.Devices = []storage.Device{
+		{DevEUI: lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 1}, ApplicationID: app.ID, DeviceProfileID: dpID, Name: "valve-1"},
+		{DevEUI: lorawan.EUI64{2, 2, 2, 2, 2, 2, 2, 2}, ApplicationID: app.ID, DeviceProfileID: dpID, Name: "valve-2"},
+	}
+	for i := range ts.Devices {
+		assert.NoError(storage.CreateDevice(ctx, storage.DB(), &ts.Devices[i]))
+	}
+
+	ts.Schedule = storage.IrrigationSchedule{
+		ApplicationID: app.ID,
+		Name:          "field 1",
+		Enabled:       true,
+		Schedule:      schedule.Schedule{Type: schedule.CronSchedule, Cron: "0 6 * * *"},
+		StartDownlink: schedule.Downlink{FPort: 10, Object: `{"valve": "open", "seconds": "{{ duration }}"}`},
+		StopDownlink:  &schedule.Downlink{FPort: 10, Confirmed: true, Object: `{"valve": "close"}`},
+		Duration:      30 * time.Minute,
+	}
+	assert.NoError(storage.Transaction(func(tx sqlx.Ext) error {
+		if err := storage.CreateIrrigationSchedule(ctx, tx, &ts.Schedule); err != nil {
+			return err
+		}
+		return storage.SetIrrigationScheduleDevices(ctx, tx, ts.Schedule, []lorawan.EUI64{ts.Devices[0].DevEUI, ts.Devices[1].DevEUI})
+	}))
+}
+
+func (ts *IrrigationTestSuite) TestRun() {
+	ctx := context.Background()
+
+	var payloads []models.DataDownPayload
+	var enqueueErr error
+	enqueueDownlink = func(ctx context.Context, pl models.DataDownPayload) error {
+		payloads = append(payloads, pl)
+		return enqueueErr
+	}
+	defer func() {
+		enqueueDownlink = downlink.EnqueueDataDownPayload
+	}()
+
+	getSchedule := func(t *testing.T) storage.IrrigationSchedule {
+		s, err := storage.GetIrrigationSchedule(ctx, storage.DB(), ts.Schedule.ID, false)
+		require.NoError(t, err)
+		return s
+	}
+
+	ts.T().Run("Not due", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(Run(ctx, time.Now()))
+		assert.Len(payloads, 0)
+	})
+
+	ts.T().Run("Start", func(t *testing.T) {
+		assert := require.New(t)
+
+		now := *ts.Schedule.NextRunAt
+		assert.NoError(Run(ctx, now))
+		assert.Len(payloads, 2)
+		assert.Equal(ts.Devices[0].DevEUI, payloads[0].DevEUI)
+		assert.Equal(uint8(10), payloads[0].FPort)
+		assert.False(payloads[0].Confirmed)
+		assert.JSONEq(`{"valve": "open", "seconds": 1800}`, string(payloads[0].Object))
+
+		s := getSchedule(t)
+		assert.True(s.NextRunAt.Equal(*s.NextRun(now)))
+		assert.True(s.StopAt.Equal(now.Add(30 * time.Minute)))
+		ts.Schedule = s
+	})
+
+	ts.T().Run("Stop", func(t *testing.T) {
+		assert := require.New(t)
+
+		payloads = nil
+		assert.NoError(Run(ctx, ts.Schedule.StopAt.Add(-time.Minute)))
+		assert.Len(payloads, 0)
+
+		enqueueErr = errors.New("device queue is full")
+		assert.NoError(Run(ctx, *ts.Schedule.StopAt))
+		assert.Len(payloads, 2)
+		assert.True(payloads[0].Confirmed)
+		assert.Equal(json.RawMessage(`{"valve":"close"}`), payloads[0].Object)
+		enqueueErr = nil
+
+		s := getSchedule(t)
+		assert.Nil(s.StopAt)
+
+		executions, err := storage.GetIrrigationExecutions(ctx, storage.DB(), s.ID, 10, 0)
+		assert.NoError(err)
+		assert.Len(executions, 4)
+		assert.Equal(storage.IrrigationStop, executions[0].Action)
+		assert.Equal(storage.IrrigationSourceSchedule, executions[0].Source)
+		assert.False(executions[0].Success)
+		assert.Equal("device queue is full", executions[0].Error)
+		assert.Equal(storage.IrrigationStart, executions[3].Action)
+		assert.True(executions[3].Success)
+	})
+
+	ts.T().Run("Paused", func(t *testing.T) {
+		assert := require.New(t)
+
+		payloads = nil
+		now := *ts.Schedule.NextRunAt
+		until := now.Add(time.Hour)
+		assert.NoError(storage.SetIrrigationSchedulePausedUntil(ctx, storage.DB(), ts.Schedule.ID, &until))
+
+		assert.NoError(Run(ctx, now))
+		assert.Len(payloads, 0)
+
+		s := getSchedule(t)
+		assert.True(s.NextRunAt.Equal(*s.NextRun(now)))
+		assert.Nil(s.StopAt)
+	})
+
+	ts.T().Run("Manual override", func(t *testing.T) {
+		assert := require.New(t)
+
+		payloads = nil
+		s := getSchedule(t)
+		s.Duration = 5 * time.Minute
+		assert.NoError(Start(ctx, s))
+		assert.Len(payloads, 2)
+		assert.JSONEq(`{"valve": "open", "seconds": 300}`, string(payloads[0].Object))
+
+		sGet := getSchedule(t)
+		assert.NotNil(sGet.StopAt)
+		assert.WithinDuration(time.Now().Add(5*time.Minute), *sGet.StopAt, time.Minute)
+
+		assert.NoError(Stop(ctx, sGet))
+		assert.Len(payloads, 4)
+		assert.Nil(getSchedule(t).StopAt)
+
+		executions, err := storage.GetIrrigationExecutions(ctx, storage.DB(), s.ID, 1, 0)
+		assert.NoError(err)
+		assert.Equal(storage.IrrigationStop, executions[0].Action)
+		assert.Equal(storage.IrrigationSourceManual, executions[0].Source)
+
+		s.StopDownlink = nil
+		assert.Equal(storage.ErrIrrigationNoStopDownlink, Stop(ctx, s))
+	})
+}
+
+func TestIrrigation(t *testing.T) {
+	suite.Run(t, new(IrrigationTestSuite))
+}
@@ -0,0 +1,191 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronMaxDays defines the max. number of days searched for the next
+// activation of a cron expression.
+const cronMaxDays = 5 * 366
+
+// cronField defines the range of a cron expression field.
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// Cron defines a parsed cron expression with the five standard fields
+// (minute, hour, day of month, month and day of week). Each field is either
+// *, a value, a range (e.g. 1-5) or a comma separated list of these, each
+// optionally with a step (e.g. */15 or 8-18/2). Day of week 0 and 7 are both
+// Sunday. Like cron, when both the day of month and the day of week are
+// restricted, a day matching either of these matches.
+type Cron struct {
+	minutes    [60]bool
+	hours      [24]bool
+	daysOfMon  [32]bool
+	months     [13]bool
+	daysOfWeek [7]bool
+
+	domAny bool
+	dowAny bool
+}
+
+// ParseCron parses the given cron expression.
+func ParseCron(expr string) (Cron, error) {
+	var c Cron
+
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return c, fmt.Errorf("expected %d fields, got %d", len(cronFields), len(fields))
+	}
+
+	for i, f := range fields {
+		values, err := parseCronField(f, cronFields[i])
+		if err != nil {
+			return c, fmt.Errorf("%s: %s", cronFields[i].name, err)
+		}
+
+		for _, v := range values {
+			switch i {
+			case 0:
+				c.minutes[v] = true
+			case 1:
+				c.hours[v] = true
+			case 2:
+				c.daysOfMon[v] = true
+			case 3:
+				c.months[v] = true
+			case 4:
+				c.daysOfWeek[v%7] = true
+			}
+		}
+	}
+
+	c.domAny = fields[2] == "*"
+	c.dowAny = fields[4] == "*"
+
+	return c, nil
+}
+
+// Next returns the first activation of the cron expression after the given
+// time, in the location of the given time. It returns the zero time when
+// there is no activation within the next five years (e.g. February 30).
+func (c Cron) Next(after time.Time) time.Time {
+	loc := after.Location()
+	t := after.Truncate(time.Minute).Add(time.Minute)
+
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	for i := 0; i < cronMaxDays; i, day = i+1, day.AddDate(0, 0, 1) {
+		if !c.matchDay(day) {
+			continue
+		}
+
+		for h := 0; h < 24; h++ {
+			if !c.hours[h] {
+				continue
+			}
+
+			for m := 0; m < 60; m++ {
+				if !c.minutes[m] {
+					continue
+				}
+
+				next := time.Date(day.Year(), day.Month(), day.Day(), h, m, 0, 0, loc)
+				if !next.Before(t) {
+					return next
+				}
+			}
+		}
+	}
+
+	return time.Time{}
+}
+
+func (c Cron) matchDay(day time.Time) bool {
+	if !c.months[day.Month()] {
+		return false
+	}
+
+	dom := c.daysOfMon[day.Day()]
+	dow := c.daysOfWeek[day.Weekday()]
+
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// parseCronField returns the values of the given cron expression field.
+func parseCronField(f string, field cronField) ([]int, error) {
+	var out []int
+
+	for _, part := range strings.Split(f, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i != -1 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step: '%s'", part[i+1:])
+			}
+			part = part[:i]
+		}
+
+		start, end := field.min, field.max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			i := strings.Index(part, "-")
+			var err error
+			if start, err = cronValue(part[:i], field); err != nil {
+				return nil, err
+			}
+			if end, err = cronValue(part[i+1:], field); err != nil {
+				return nil, err
+			}
+			if end < start {
+				return nil, fmt.Errorf("invalid range: '%s'", part)
+			}
+		default:
+			v, err := cronValue(part, field)
+			if err != nil {
+				return nil, err
+			}
+			start = v
+			if step == 1 {
+				end = v
+			}
+		}
+
+		for v := start; v <= end; v += step {
+			out = append(out, v)
+		}
+	}
+
+	return out, nil
+}
+
+func cronValue(s string, field cronField) (int, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil || v < field.min || v > field.max {
+		return 0, fmt.Errorf("value must be between %d and %d: '%s'", field.min, field.max, s)
+	}
+	return v, nil
+}
@@ -0,0 +1,81 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		Expr          string
+		ExpectedError string
+	}{
+		{Expr: "0 6 * * *"},
+		{Expr: "*/15 6-18/2 1,15 1-12 0-7"},
+		{Expr: "0 6 * *", ExpectedError: "expected 5 fields, got 4"},
+		{Expr: "60 6 * * *", ExpectedError: "minute: value must be between 0 and 59: '60'"},
+		{Expr: "0 18-6 * * *", ExpectedError: "hour: invalid range: '18-6'"},
+		{Expr: "0 6 0 * *", ExpectedError: "day of month: value must be between 1 and 31: '0'"},
+		{Expr: "0 6 * * */0", ExpectedError: "day of week: invalid step: '0'"},
+		{Expr: "0 6 * jan *", ExpectedError: "month: value must be between 1 and 12: 'jan'"},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Expr, func(t *testing.T) {
+			assert := require.New(t)
+
+			_, err := ParseCron(tst.Expr)
+			if tst.ExpectedError != "" {
+				assert.EqualError(err, tst.ExpectedError)
+			} else {
+				assert.NoError(err)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// Friday
+	after := time.Date(2021, 6, 4, 6, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		Expr     string
+		After    time.Time
+		Expected time.Time
+	}{
+		{"0 6 * * *", after, time.Date(2021, 6, 5, 6, 0, 0, 0, time.UTC)},
+		{"30 6 * * *", after, time.Date(2021, 6, 5, 6, 30, 0, 0, time.UTC)},
+		{"*/15 * * * *", after, time.Date(2021, 6, 4, 6, 45, 0, 0, time.UTC)},
+		{"*/15 * * * *", after.Add(10 * time.Second), time.Date(2021, 6, 4, 6, 45, 0, 0, time.UTC)},
+		{"0 6 * * 1-5", after, time.Date(2021, 6, 7, 6, 0, 0, 0, time.UTC)},
+		{"0 6 * * 7", after, time.Date(2021, 6, 6, 6, 0, 0, 0, time.UTC)},
+		{"0 6 1 * *", after, time.Date(2021, 7, 1, 6, 0, 0, 0, time.UTC)},
+		// day of month or day of week
+		{"0 6 1 * 0", after, time.Date(2021, 6, 6, 6, 0, 0, 0, time.UTC)},
+		{"0 6 29 2 *", after, time.Date(2024, 2, 29, 6, 0, 0, 0, time.UTC)},
+		{"0 6 30 2 *", after, time.Time{}},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Expr, func(t *testing.T) {
+			assert := require.New(t)
+
+			c, err := ParseCron(tst.Expr)
+			assert.NoError(err)
+			assert.Equal(tst.Expected, c.Next(tst.After))
+		})
+	}
+
+	t.Run("location", func(t *testing.T) {
+		assert := require.New(t)
+
+		loc, err := time.LoadLocation("Europe/Amsterdam")
+		assert.NoError(err)
+
+		c, err := ParseCron("0 6 * * *")
+		assert.NoError(err)
+		assert.Equal(time.Date(2021, 6, 5, 4, 0, 0, 0, time.UTC), c.Next(after.In(loc)).UTC())
+	})
+}
@@ -0,0 +1,224 @@
+// Package schedule implements the timing and downlinks of the irrigation
+// schedules. A schedule either activates on a cron expression or relative to
+// the sunrise or sunset at a location, and enqueues a start (and optionally a
+// stop) downlink to the actuator devices (e.g. valves or relays).
+package schedule
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ibrahimozekici/app-server2/internal/downlink/template"
+)
+
+// Type defines the schedule type.
+type Type string
+
+// Available schedule types.
+const (
+	CronSchedule Type = "CRON"
+	SunSchedule  Type = "SUN"
+)
+
+// SunEvent defines the sun event of a SUN schedule.
+type SunEvent string
+
+// Available sun events.
+const (
+	Sunrise SunEvent = "SUNRISE"
+	Sunset  SunEvent = "SUNSET"
+)
+
+const (
+	// maxOffset defines the max. offset (before or after) of a sun event.
+	maxOffset = 12 * 60
+
+	// maxSunDays defines the max. number of days searched for the next
+	// sun event, covering the polar night and day.
+	maxSunDays = 366
+
+	// DurationParameter defines the name of the placeholder within the
+	// downlink object, which is replaced by the duration in seconds.
+	DurationParameter = "duration"
+)
+
+// Schedule defines when an irrigation schedule activates. Only the fields of
+// the schedule type are used:
+//
+// CRON activates on the five field cron expression Cron (minute, hour, day
+// of month, month and day of week, e.g. "0 6 * * 1-5"), evaluated in the
+// configured timezone.
+//
+// SUN activates OffsetMinutes (negative is before) relative to the sunrise or
+// sunset at the location given by Latitude and Longitude. On days without
+// this event (polar day or night) it does not activate.
+type Schedule struct {
+	Type Type `json:"type"`
+
+	Cron string `json:"cron,omitempty"`
+
+	Event         SunEvent `json:"event,omitempty"`
+	OffsetMinutes int      `json:"offsetMinutes,omitempty"`
+	Latitude      float64  `json:"latitude,omitempty"`
+	Longitude     float64  `json:"longitude,omitempty"`
+}
+
+// Validate validates the schedule.
+func (s Schedule) Validate() error {
+	switch s.Type {
+	case CronSchedule:
+		if _, err := ParseCron(s.Cron); err != nil {
+			return fmt.Errorf("cron: %s", err)
+		}
+	case SunSchedule:
+		if s.Event != Sunrise && s.Event != Sunset {
+			return fmt.Errorf("event must be %s or %s", Sunrise, Sunset)
+		}
+		if s.OffsetMinutes < -maxOffset || s.OffsetMinutes > maxOffset {
+			return fmt.Errorf("offset must be between -%d and %d minutes", maxOffset, maxOffset)
+		}
+		if s.Latitude < -90 || s.Latitude > 90 {
+			return errors.New("latitude must be between -90 and 90")
+		}
+		if s.Longitude < -180 || s.Longitude > 180 {
+			return errors.New("longitude must be between -180 and 180")
+		}
+	default:
+		return fmt.Errorf("invalid schedule type: '%s'", s.Type)
+	}
+
+	return nil
+}
+
+// Next returns the first activation of the schedule after the given time.
+// The cron expression and the dates of the sun events are evaluated in the
+// given location. It returns the zero time when the schedule does not
+// activate within the search range (e.g. a cron expression for February 30).
+func (s Schedule) Next(after time.Time, loc *time.Location) time.Time {
+	after = after.In(loc)
+
+	switch s.Type {
+	case CronSchedule:
+		c, err := ParseCron(s.Cron)
+		if err != nil {
+			return time.Time{}
+		}
+		return c.Next(after)
+	case SunSchedule:
+		offset := time.Duration(s.OffsetMinutes) * time.Minute
+
+		// the offset might move the activation of the previous day past
+		// the given time
+		for i := -1; i < maxSunDays; i++ {
+			t, ok := SunTime(after.AddDate(0, 0, i), s.Latitude, s.Longitude, s.Event == Sunrise)
+			if !ok {
+				continue
+			}
+
+			t = t.Add(offset).Truncate(time.Minute)
+			if t.After(after) {
+				return t.In(loc)
+			}
+		}
+	}
+
+	return time.Time{}
+}
+
+// Value implements the driver.Valuer interface.
+func (s Schedule) Value() (driver.Value, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (s *Schedule) Scan(src interface{}) error {
+	return scanJSON(src, s)
+}
+
+// Downlink defines a downlink enqueued to the devices of an irrigation
+// schedule. The Object (JSON object encoded by the codec) may contain the
+// {{ duration }} placeholder, which is replaced by the irrigation duration
+// in seconds.
+type Downlink struct {
+	FPort     uint8  `json:"fPort"`
+	Confirmed bool   `json:"confirmed,omitempty"`
+	Object    string `json:"object"`
+}
+
+// Validate validates the downlink.
+func (d Downlink) Validate() error {
+	if d.FPort == 0 || d.FPort > 223 {
+		return errors.New("fPort must be between 1 and 223")
+	}
+	if err := template.Validate(d.Object); err != nil {
+		return errors.New("object must be a valid JSON object")
+	}
+
+	names, err := template.Placeholders(d.Object)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if name != DurationParameter {
+			return fmt.Errorf("unknown placeholder: '%s'", name)
+		}
+	}
+
+	return nil
+}
+
+// Render returns the object of the downlink, with the duration placeholder
+// replaced by the given duration.
+func (d Downlink) Render(duration time.Duration) (json.RawMessage, error) {
+	names, err := template.Placeholders(d.Object)
+	if err != nil {
+		return nil, err
+	}
+
+	params := make(map[string]json.RawMessage)
+	for _, name := range names {
+		if name == DurationParameter {
+			params[name] = json.RawMessage(strconv.FormatInt(int64(duration/time.Second), 10))
+		}
+	}
+
+	return template.Render(d.Object, params)
+}
+
+// Value implements the driver.Valuer interface.
+func (d Downlink) Value() (driver.Value, error) {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (d *Downlink) Scan(src interface{}) error {
+	return scanJSON(src, d)
+}
+
+func scanJSON(src interface{}, v interface{}) error {
+	var b []byte
+	switch src := src.(type) {
+	case []byte:
+		b = src
+	case string:
+		b = []byte(src)
+	case nil:
+		return nil
+	default:
+		return fmt.Errorf("expected []byte, got %T", src)
+	}
+
+	return json.Unmarshal(b, v)
+}
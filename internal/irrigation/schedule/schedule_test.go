@@ -0,0 +1,133 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduleValidate(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Schedule      Schedule
+		ExpectedError string
+	}{
+		{
+			Name:     "valid cron",
+			Schedule: Schedule{Type: CronSchedule, Cron: "0 6 * * *"},
+		},
+		{
+			Name:          "invalid cron",
+			Schedule:      Schedule{Type: CronSchedule, Cron: "0 25 * * *"},
+			ExpectedError: "cron: hour: value must be between 0 and 23: '25'",
+		},
+		{
+			Name:     "valid sun",
+			Schedule: Schedule{Type: SunSchedule, Event: Sunrise, OffsetMinutes: -30, Latitude: 52.37, Longitude: 4.89},
+		},
+		{
+			Name:          "invalid sun event",
+			Schedule:      Schedule{Type: SunSchedule, Event: "NOON"},
+			ExpectedError: "event must be SUNRISE or SUNSET",
+		},
+		{
+			Name:          "sun offset too large",
+			Schedule:      Schedule{Type: SunSchedule, Event: Sunset, OffsetMinutes: 721},
+			ExpectedError: "offset must be between -720 and 720 minutes",
+		},
+		{
+			Name:          "invalid latitude",
+			Schedule:      Schedule{Type: SunSchedule, Event: Sunset, Latitude: 91},
+			ExpectedError: "latitude must be between -90 and 90",
+		},
+		{
+			Name:          "invalid type",
+			Schedule:      Schedule{Type: "HOURLY"},
+			ExpectedError: "invalid schedule type: 'HOURLY'",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			err := tst.Schedule.Validate()
+			if tst.ExpectedError != "" {
+				assert.EqualError(err, tst.ExpectedError)
+			} else {
+				assert.NoError(err)
+			}
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	assert := require.New(t)
+
+	loc, err := time.LoadLocation("Europe/Amsterdam")
+	assert.NoError(err)
+
+	after := time.Date(2021, 6, 21, 12, 0, 0, 0, loc)
+
+	t.Run("cron", func(t *testing.T) {
+		assert := require.New(t)
+
+		s := Schedule{Type: CronSchedule, Cron: "0 6 * * *"}
+		assert.Equal(time.Date(2021, 6, 22, 6, 0, 0, 0, loc), s.Next(after, loc))
+	})
+
+	t.Run("sunset", func(t *testing.T) {
+		assert := require.New(t)
+
+		s := Schedule{Type: SunSchedule, Event: Sunset, Latitude: 52.37, Longitude: 4.89}
+		assert.WithinDuration(time.Date(2021, 6, 21, 22, 6, 0, 0, loc), s.Next(after, loc), 5*time.Minute)
+	})
+
+	t.Run("sunrise with offset", func(t *testing.T) {
+		assert := require.New(t)
+
+		s := Schedule{Type: SunSchedule, Event: Sunrise, OffsetMinutes: -60, Latitude: 52.37, Longitude: 4.89}
+		next := s.Next(after, loc)
+		assert.WithinDuration(time.Date(2021, 6, 22, 4, 18, 0, 0, loc), next, 5*time.Minute)
+		assert.Equal(0, next.Second())
+	})
+
+	t.Run("offset past midnight", func(t *testing.T) {
+		assert := require.New(t)
+
+		s := Schedule{Type: SunSchedule, Event: Sunset, OffsetMinutes: 180, Latitude: 52.37, Longitude: 4.89}
+		assert.WithinDuration(time.Date(2021, 6, 22, 1, 6, 0, 0, loc), s.Next(time.Date(2021, 6, 22, 0, 30, 0, 0, loc), loc), 5*time.Minute)
+	})
+
+	t.Run("polar day", func(t *testing.T) {
+		assert := require.New(t)
+
+		s := Schedule{Type: SunSchedule, Event: Sunset, Latitude: 78.22, Longitude: 15.65}
+		next := s.Next(time.Date(2021, 6, 21, 0, 0, 0, 0, time.UTC), time.UTC)
+		assert.True(next.After(time.Date(2021, 8, 1, 0, 0, 0, 0, time.UTC)))
+	})
+}
+
+func TestDownlink(t *testing.T) {
+	t.Run("validate", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(Downlink{FPort: 10, Object: `{"valve": "open", "seconds": "{{ duration }}"}`}.Validate())
+		assert.EqualError(Downlink{FPort: 0, Object: `{}`}.Validate(), "fPort must be between 1 and 223")
+		assert.EqualError(Downlink{FPort: 10, Object: `[]`}.Validate(), "object must be a valid JSON object")
+		assert.EqualError(Downlink{FPort: 10, Object: `{"valve": "{{ state }}"}`}.Validate(), "unknown placeholder: 'state'")
+	})
+
+	t.Run("render", func(t *testing.T) {
+		assert := require.New(t)
+
+		b, err := Downlink{FPort: 10, Object: `{"valve": "open", "seconds": "{{ duration }}"}`}.Render(15 * time.Minute)
+		assert.NoError(err)
+		assert.JSONEq(`{"valve": "open", "seconds": 900}`, string(b))
+
+		b, err = Downlink{FPort: 10, Object: `{"valve": "close"}`}.Render(0)
+		assert.NoError(err)
+		assert.JSONEq(`{"valve": "close"}`, string(b))
+	})
+}
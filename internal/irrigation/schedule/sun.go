@@ -0,0 +1,78 @@
+package schedule
+
+import (
+	"math"
+	"time"
+)
+
+// sunZenith defines the zenith (in degrees) of the official sunrise and
+// sunset, taking the atmospheric refraction and the radius of the sun into
+// account.
+const sunZenith = 90.833
+
+// SunTime returns the time (UTC) of the sunrise (rise is true) or sunset of
+// the given date (year, month and day) at the given location, using the
+// algorithm of the Almanac for Computers (accurate within a few minutes).
+// It returns false when the sun does not rise or set on this date (polar
+// day or night).
+func SunTime(date time.Time, latitude, longitude float64, rise bool) (time.Time, bool) {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	lngHour := longitude / 15
+
+	// approximate time of the event
+	t := float64(day.YearDay()) + (18-lngHour)/24
+	if rise {
+		t = float64(day.YearDay()) + (6-lngHour)/24
+	}
+
+	// mean anomaly and true longitude of the sun
+	m := 0.9856*t - 3.289
+	l := normalize(m+1.916*sin(m)+0.020*sin(2*m)+282.634, 360)
+
+	// right ascension, in the same quadrant as the true longitude
+	ra := normalize(deg(math.Atan(0.91764*tan(l))), 360)
+	ra += math.Floor(l/90)*90 - math.Floor(ra/90)*90
+	ra /= 15
+
+	// declination and local hour angle
+	sinDec := 0.39782 * sin(l)
+	cosDec := math.Cos(math.Asin(sinDec))
+	cosH := (cos(sunZenith) - sinDec*sin(latitude)) / (cosDec * cos(latitude))
+	if cosH > 1 || cosH < -1 {
+		return time.Time{}, false
+	}
+
+	h := deg(math.Acos(cosH))
+	if rise {
+		h = 360 - h
+	}
+	h /= 15
+
+	ut := normalize(h+ra-0.06571*t-6.622-lngHour, 24)
+	out := day.Add(time.Duration(ut * float64(time.Hour)))
+
+	// the event must be within 12 hours of the solar noon of the date, this
+	// corrects the date for locations far east or west of Greenwich
+	noon := day.Add(time.Duration((12 - lngHour) * float64(time.Hour)))
+	if out.Sub(noon) > 12*time.Hour {
+		out = out.Add(-24 * time.Hour)
+	} else if noon.Sub(out) > 12*time.Hour {
+		out = out.Add(24 * time.Hour)
+	}
+
+	return out, true
+}
+
+func normalize(v, max float64) float64 {
+	v = math.Mod(v, max)
+	if v < 0 {
+		v += max
+	}
+	return v
+}
+
+func rad(d float64) float64 { return d * math.Pi / 180 }
+func deg(r float64) float64 { return r * 180 / math.Pi }
+func sin(d float64) float64 { return math.Sin(rad(d)) }
+func cos(d float64) float64 { return math.Cos(rad(d)) }
+func tan(d float64) float64 { return math.Tan(rad(d)) }
@@ -0,0 +1,66 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSunTime(t *testing.T) {
+	tests := []struct {
+		Name       string
+		Date       time.Time
+		Latitude   float64
+		Longitude  float64
+		Rise       bool
+		Expected   time.Time
+		ExpectedOK bool
+	}{
+		{
+			Name:       "amsterdam sunrise",
+			Date:       time.Date(2021, 6, 21, 0, 0, 0, 0, time.UTC),
+			Latitude:   52.37,
+			Longitude:  4.89,
+			Rise:       true,
+			Expected:   time.Date(2021, 6, 21, 3, 18, 0, 0, time.UTC),
+			ExpectedOK: true,
+		},
+		{
+			Name:       "amsterdam sunset",
+			Date:       time.Date(2021, 6, 21, 0, 0, 0, 0, time.UTC),
+			Latitude:   52.37,
+			Longitude:  4.89,
+			Expected:   time.Date(2021, 6, 21, 20, 6, 0, 0, time.UTC),
+			ExpectedOK: true,
+		},
+		{
+			Name:       "sydney sunrise",
+			Date:       time.Date(2021, 6, 21, 0, 0, 0, 0, time.UTC),
+			Latitude:   -33.87,
+			Longitude:  151.21,
+			Rise:       true,
+			Expected:   time.Date(2021, 6, 20, 21, 0, 0, 0, time.UTC),
+			ExpectedOK: true,
+		},
+		{
+			Name:      "polar day",
+			Date:      time.Date(2021, 6, 21, 0, 0, 0, 0, time.UTC),
+			Latitude:  78.22,
+			Longitude: 15.65,
+			Rise:      true,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			out, ok := SunTime(tst.Date, tst.Latitude, tst.Longitude, tst.Rise)
+			assert.Equal(tst.ExpectedOK, ok)
+			if ok {
+				assert.WithinDuration(tst.Expected, out, 5*time.Minute)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+// Package kek manages the key encryption keys (KEKs) used by the join-server
+// to encrypt the session-keys. KEKs are read from the configuration, an
+// optional KEK set file and an optional HashiCorp Vault KV secret. KEKs can
+// be stored wrapped by the configured kms provider. The KEK set file and the
+// Vault secret are periodically reloaded, so that KEKs can be added or
+// rotated without restart.
+package kek
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/kms"
+)
+
+var (
+	mux  sync.RWMutex
+	keks map[string][]byte
+
+	conf            config.Config
+	refreshInterval = 5 * time.Minute
+)
+
+type vaultKVResponse struct {
+	Data struct {
+		Data map[string]string `json:"data"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+// Setup configures the package, loads the KEKs and starts the refresh loop
+// when KEKs are loaded from the KEK set file or Vault.
+func Setup(c config.Config) error {
+	conf = c
+	if c.JoinServer.KEK.RefreshInterval > 0 {
+		refreshInterval = c.JoinServer.KEK.RefreshInterval
+	}
+
+	if err := Refresh(context.Background()); err != nil {
+		return errors.Wrap(err, "load keks error")
+	}
+
+	if c.JoinServer.KEK.SetFile != "" || c.JoinServer.KEK.Vault.Path != "" {
+		go refreshLoop()
+	}
+
+	return nil
+}
+
+// Refresh (re)loads the KEKs. On error, the current KEKs are kept.
+func Refresh(ctx context.Context) error {
+	m, err := load(ctx, conf)
+	if err != nil {
+		return err
+	}
+
+	mux.Lock()
+	keks = m
+	mux.Unlock()
+
+	log.WithField("count", len(m)).Debug("kek: keks loaded")
+
+	return nil
+}
+
+// GetKEK returns the KEK for the given label. It returns false when the
+// label does not exist.
+func GetKEK(label string) ([]byte, bool) {
+	mux.RLock()
+	defer mux.RUnlock()
+
+	b, ok := keks[label]
+	return b, ok
+}
+
+// SetKEKs sets the KEKs by label.
+func SetKEKs(m map[string][]byte) {
+	mux.Lock()
+	keks = m
+	mux.Unlock()
+}
+
+func refreshLoop() {
+	for {
+		time.Sleep(refreshInterval)
+
+		if err := Refresh(context.Background()); err != nil {
+			log.WithError(err).Error("kek: refresh keks error")
+		}
+	}
+}
+
+func load(ctx context.Context, c config.Config) (map[string][]byte, error) {
+	kekConf := c.JoinServer.KEK
+	items := append([]config.KEK{}, kekConf.Set...)
+
+	if kekConf.SetFile != "" {
+		b, err := ioutil.ReadFile(kekConf.SetFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read kek set file error")
+		}
+
+		var fileItems []config.KEK
+		if err := json.Unmarshal(b, &fileItems); err != nil {
+			return nil, errors.Wrap(err, "unmarshal kek set file error")
+		}
+		items = append(items, fileItems...)
+	}
+
+	if kekConf.Vault.Path != "" {
+		vaultItems, err := getVaultKEKs(ctx, c)
+		if err != nil {
+			return nil, errors.Wrap(err, "get keks from vault error")
+		}
+		items = append(items, vaultItems...)
+	}
+
+	out := make(map[string][]byte)
+	for _, item := range items {
+		b, err := decodeKEK(ctx, item)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decode kek error, label: %s", item.Label)
+		}
+		out[item.Label] = b
+	}
+
+	return out, nil
+}
+
+func decodeKEK(ctx context.Context, item config.KEK) ([]byte, error) {
+	var b []byte
+
+	if item.WrappedKEK != "" {
+		kw := kms.GetKeyWrapper()
+		if kw == nil {
+			return nil, errors.New("kek is wrapped but no kms provider is configured")
+		}
+
+		wrapped, err := base64.StdEncoding.DecodeString(item.WrappedKEK)
+		if err != nil {
+			return nil, errors.Wrap(err, "decode base64 encoded wrapped kek error")
+		}
+
+		b, err = kw.Unwrap(ctx, wrapped)
+		if err != nil {
+			return nil, errors.Wrap(err, "unwrap kek error")
+		}
+	} else {
+		var err error
+		b, err = hex.DecodeString(item.KEK)
+		if err != nil {
+			return nil, errors.Wrap(err, "decode hex encoded kek error")
+		}
+	}
+
+	switch len(b) {
+	case 16, 24, 32:
+		return b, nil
+	default:
+		return nil, fmt.Errorf("kek must be 16, 24 or 32 bytes, got: %d", len(b))
+	}
+}
+
+// getVaultKEKs returns the KEKs stored in the Vault KV (version 2) secret.
+// The secret maps the KEK labels to the hex encoded KEKs.
+func getVaultKEKs(ctx context.Context, c config.Config) ([]config.KEK, error) {
+	vc := c.JoinServer.KEK.Vault
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+
+	if vc.CACert != "" {
+		rawCACert, err := ioutil.ReadFile(vc.CACert)
+		if err != nil {
+			return nil, errors.Wrap(err, "read ca cert error")
+		}
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(rawCACert) {
+			return nil, errors.New("append ca cert to pool error")
+		}
+		client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: caCertPool,
+			},
+		}
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/v1/%s", strings.TrimRight(vc.Address, "/"), strings.Trim(vc.Path, "/")), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "new request error")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Vault-Token", vc.Token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "http request error")
+	}
+	defer resp.Body.Close()
+
+	var out vaultKVResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, errors.Wrap(err, "decode response error")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("expected 200, got: %d (%s)", resp.StatusCode, strings.Join(out.Errors, ", "))
+	}
+
+	var items []config.KEK
+	for label, kek := range out.Data.Data {
+		items = append(items, config.KEK{
+			Label: label,
+			KEK:   kek,
+		})
+	}
+
+	return items, nil
+}
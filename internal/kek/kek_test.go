@@ -0,0 +1,134 @@
+package kek
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/kms"
+)
+
+type testKeyWrapper struct{}
+
+func (w testKeyWrapper) Provider() string {
+	return "test"
+}
+
+func (w testKeyWrapper) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	return append([]byte{0xff}, key...), nil
+}
+
+func (w testKeyWrapper) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	return wrapped[1:], nil
+}
+
+func TestKEK(t *testing.T) {
+	assert := require.New(t)
+
+	kms.SetKeyWrapper(testKeyWrapper{})
+	defer kms.SetKeyWrapper(nil)
+
+	var vaultKEKs map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "secret" || r.URL.Path != "/v1/secret/data/kek" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+
+		var resp vaultKVResponse
+		resp.Data.Data = vaultKEKs
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	f, err := ioutil.TempFile("", "kek")
+	assert.NoError(err)
+	defer os.Remove(f.Name())
+	_, err = f.Write([]byte(`[{"label": "030303", "kek": "03030303030303030303030303030303"}]`))
+	assert.NoError(err)
+	assert.NoError(f.Close())
+
+	var c config.Config
+	c.JoinServer.KEK.Set = []config.KEK{
+		{
+			Label: "010101",
+			KEK:   "01010101010101010101010101010101",
+		},
+		{
+			Label:      "020202",
+			WrappedKEK: base64.StdEncoding.EncodeToString(append([]byte{0xff}, make([]byte, 16)...)),
+		},
+	}
+	c.JoinServer.KEK.SetFile = f.Name()
+	c.JoinServer.KEK.Vault.Address = server.URL
+	c.JoinServer.KEK.Vault.Token = "secret"
+	c.JoinServer.KEK.Vault.Path = "secret/data/kek"
+
+	vaultKEKs = map[string]string{
+		"040404": "04040404040404040404040404040404",
+	}
+
+	conf = c
+	assert.NoError(Refresh(context.Background()))
+
+	b, ok := GetKEK("010101")
+	assert.True(ok)
+	assert.Equal([]byte{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}, b)
+
+	b, ok = GetKEK("020202")
+	assert.True(ok)
+	assert.Equal(make([]byte, 16), b)
+
+	b, ok = GetKEK("030303")
+	assert.True(ok)
+	assert.Equal([]byte{3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3}, b)
+
+	b, ok = GetKEK("040404")
+	assert.True(ok)
+	assert.Equal([]byte{4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4}, b)
+
+	_, ok = GetKEK("050505")
+	assert.False(ok)
+
+	t.Run("Rotate", func(t *testing.T) {
+		assert := require.New(t)
+
+		vaultKEKs = map[string]string{
+			"040404": "05050505050505050505050505050505",
+		}
+		assert.NoError(Refresh(context.Background()))
+
+		b, ok := GetKEK("040404")
+		assert.True(ok)
+		assert.Equal([]byte{5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5}, b)
+	})
+
+	t.Run("Invalid KEK keeps current KEKs", func(t *testing.T) {
+		assert := require.New(t)
+
+		vaultKEKs = map[string]string{
+			"040404": "0505",
+		}
+		assert.EqualError(Refresh(context.Background()), "decode kek error, label: 040404: kek must be 16, 24 or 32 bytes, got: 2")
+
+		b, ok := GetKEK("040404")
+		assert.True(ok)
+		assert.Len(b, 16)
+	})
+
+	t.Run("Vault error", func(t *testing.T) {
+		assert := require.New(t)
+
+		conf.JoinServer.KEK.Vault.Token = "invalid"
+		assert.EqualError(Refresh(context.Background()), "get keks from vault error: expected 200, got: 403 (permission denied)")
+	})
+}
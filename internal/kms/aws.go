@@ -0,0 +1,75 @@
+package kms
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/pkg/errors"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+type awsKMS struct {
+	client *kms.KMS
+	keyID  string
+}
+
+func newAWSKMS(conf config.Config) (KeyWrapper, error) {
+	c := conf.KMS.AWS
+	if c.KeyID == "" {
+		return nil, errors.New("key_id must be set")
+	}
+
+	awsConf := aws.Config{
+		Region: aws.String(c.Region),
+	}
+	if c.Endpoint != "" {
+		awsConf.Endpoint = aws.String(c.Endpoint)
+	}
+	if c.AccessKeyID != "" || c.SecretAccessKey != "" {
+		awsConf.Credentials = credentials.NewStaticCredentials(c.AccessKeyID, c.SecretAccessKey, "")
+	}
+
+	sess, err := session.NewSession(&awsConf)
+	if err != nil {
+		return nil, errors.Wrap(err, "new session error")
+	}
+
+	return &awsKMS{
+		client: kms.New(sess),
+		keyID:  c.KeyID,
+	}, nil
+}
+
+func (a *awsKMS) Provider() string {
+	return ProviderAWSKMS
+}
+
+func (a *awsKMS) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	out, err := a.client.EncryptWithContext(ctx, &kms.EncryptInput{
+		KeyId:     aws.String(a.keyID),
+		Plaintext: key,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "encrypt error")
+	}
+
+	return out.CiphertextBlob, nil
+}
+
+func (a *awsKMS) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	// The ciphertext blob contains the reference to the (version of the)
+	// key that was used, this makes it possible to unwrap data-keys after
+	// the key has been rotated.
+	out, err := a.client.DecryptWithContext(ctx, &kms.DecryptInput{
+		CiphertextBlob: wrapped,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypt error")
+	}
+
+	return out.Plaintext, nil
+}
@@ -0,0 +1,154 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2/clientcredentials"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+const (
+	azureAPIVersion  = "7.3"
+	azureTokenURL    = "https://login.microsoftonline.com/%s/oauth2/v2.0/token"
+	azureVaultScope  = "https://vault.azure.net/.default"
+	azureDefaultAlgo = "RSA-OAEP-256"
+)
+
+type azureKeyRequest struct {
+	Algorithm string `json:"alg"`
+	Value     string `json:"value"`
+}
+
+type azureKeyResponse struct {
+	KID   string `json:"kid"`
+	Value string `json:"value"`
+}
+
+// azureWrappedKey defines the format in which the wrapped key is stored.
+// The key ID includes the key version used for wrapping the key, so that
+// keys can be unwrapped after key rotation.
+type azureWrappedKey struct {
+	KID   string `json:"kid"`
+	Value string `json:"value"`
+}
+
+type azureKeyVault struct {
+	keyURL    string
+	algorithm string
+	client    *http.Client
+}
+
+func newAzureKeyVault(conf config.Config) (KeyWrapper, error) {
+	c := conf.KMS.Azure
+	if c.VaultURL == "" || c.KeyName == "" {
+		return nil, errors.New("vault_url and key_name must be set")
+	}
+	if c.TenantID == "" || c.ClientID == "" || c.ClientSecret == "" {
+		return nil, errors.New("tenant_id, client_id and client_secret must be set")
+	}
+
+	keyURL := fmt.Sprintf("%s/keys/%s", strings.TrimRight(c.VaultURL, "/"), c.KeyName)
+	if c.KeyVersion != "" {
+		keyURL = keyURL + "/" + c.KeyVersion
+	}
+
+	algorithm := c.Algorithm
+	if algorithm == "" {
+		algorithm = azureDefaultAlgo
+	}
+
+	cc := clientcredentials.Config{
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		TokenURL:     fmt.Sprintf(azureTokenURL, c.TenantID),
+		Scopes:       []string{azureVaultScope},
+	}
+
+	return &azureKeyVault{
+		keyURL:    keyURL,
+		algorithm: algorithm,
+		client:    cc.Client(context.Background()),
+	}, nil
+}
+
+func (a *azureKeyVault) Provider() string {
+	return ProviderAzureKeyVault
+}
+
+func (a *azureKeyVault) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	resp, err := a.do(ctx, a.keyURL+"/wrapkey", azureKeyRequest{
+		Algorithm: a.algorithm,
+		Value:     base64.RawURLEncoding.EncodeToString(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(azureWrappedKey{
+		KID:   resp.KID,
+		Value: resp.Value,
+	})
+}
+
+func (a *azureKeyVault) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	var wk azureWrappedKey
+	if err := json.Unmarshal(wrapped, &wk); err != nil {
+		return nil, errors.Wrap(err, "unmarshal wrapped key error")
+	}
+
+	resp, err := a.do(ctx, wk.KID+"/unwrapkey", azureKeyRequest{
+		Algorithm: a.algorithm,
+		Value:     wk.Value,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(resp.Value)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode key error")
+	}
+	return b, nil
+}
+
+func (a *azureKeyVault) do(ctx context.Context, url string, r azureKeyRequest) (azureKeyResponse, error) {
+	var out azureKeyResponse
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return out, errors.Wrap(err, "marshal request error")
+	}
+
+	req, err := http.NewRequest("POST", url+"?api-version="+azureAPIVersion, bytes.NewReader(b))
+	if err != nil {
+		return out, errors.Wrap(err, "new request error")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return out, errors.Wrap(err, "http request error")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return out, fmt.Errorf("expected 200, got: %d (%s)", resp.StatusCode, string(body))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return out, errors.Wrap(err, "decode response error")
+	}
+
+	return out, nil
+}
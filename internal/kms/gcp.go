@@ -0,0 +1,112 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+const gcpKMSEndpoint = "https://cloudkms.googleapis.com/v1"
+
+type gcpCryptRequest struct {
+	Plaintext  []byte `json:"plaintext,omitempty"`
+	Ciphertext []byte `json:"ciphertext,omitempty"`
+}
+
+type gcpCryptResponse struct {
+	Plaintext  []byte `json:"plaintext"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+type gcpKMS struct {
+	endpoint string
+	keyName  string
+	client   *http.Client
+}
+
+func newGCPKMS(conf config.Config) (KeyWrapper, error) {
+	c := conf.KMS.GCP
+	if c.KeyName == "" {
+		return nil, errors.New("key_name must be set")
+	}
+
+	b, err := ioutil.ReadFile(c.CredentialsFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "read credentials file error")
+	}
+
+	creds, err := google.CredentialsFromJSON(context.Background(), b, "https://www.googleapis.com/auth/cloudkms")
+	if err != nil {
+		return nil, errors.Wrap(err, "credentials from json error")
+	}
+
+	return &gcpKMS{
+		endpoint: gcpKMSEndpoint,
+		keyName:  c.KeyName,
+		client:   oauth2.NewClient(context.Background(), creds.TokenSource),
+	}, nil
+}
+
+func (g *gcpKMS) Provider() string {
+	return ProviderGCPKMS
+}
+
+func (g *gcpKMS) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	resp, err := g.do(ctx, "encrypt", gcpCryptRequest{Plaintext: key})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Ciphertext, nil
+}
+
+func (g *gcpKMS) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	// Cloud KMS selects the key version used for encryption from the
+	// ciphertext, so that data-keys can be unwrapped after key rotation.
+	resp, err := g.do(ctx, "decrypt", gcpCryptRequest{Ciphertext: wrapped})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
+
+func (g *gcpKMS) do(ctx context.Context, method string, r gcpCryptRequest) (gcpCryptResponse, error) {
+	var out gcpCryptResponse
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return out, errors.Wrap(err, "marshal request error")
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/%s:%s", g.endpoint, g.keyName, method), bytes.NewReader(b))
+	if err != nil {
+		return out, errors.Wrap(err, "new request error")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return out, errors.Wrap(err, "http request error")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return out, fmt.Errorf("expected 200, got: %d (%s)", resp.StatusCode, string(body))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return out, errors.Wrap(err, "decode response error")
+	}
+
+	return out, nil
+}
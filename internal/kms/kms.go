@@ -0,0 +1,74 @@
+// Package kms implements the wrapping of data-keys by an external key
+// management service (envelope encryption).
+package kms
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+// Supported providers.
+const (
+	ProviderAWSKMS        = "aws_kms"
+	ProviderGCPKMS        = "gcp_kms"
+	ProviderVaultTransit  = "vault_transit"
+	ProviderAzureKeyVault = "azure_key_vault"
+)
+
+var w KeyWrapper
+
+// KeyWrapper defines the interface for wrapping and unwrapping data-keys.
+type KeyWrapper interface {
+	// Provider returns the name of the provider.
+	Provider() string
+
+	// Wrap encrypts the given data-key.
+	Wrap(ctx context.Context, key []byte) ([]byte, error)
+
+	// Unwrap decrypts the given wrapped data-key.
+	Unwrap(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// Setup configures the kms package.
+func Setup(conf config.Config) error {
+	var err error
+
+	switch conf.KMS.Provider {
+	case "":
+		w = nil
+		return nil
+	case ProviderAWSKMS:
+		w, err = newAWSKMS(conf)
+	case ProviderGCPKMS:
+		w, err = newGCPKMS(conf)
+	case ProviderVaultTransit:
+		w, err = newVaultTransit(conf)
+	case ProviderAzureKeyVault:
+		w, err = newAzureKeyVault(conf)
+	default:
+		return fmt.Errorf("unknown kms provider: %s", conf.KMS.Provider)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "setup %s error", conf.KMS.Provider)
+	}
+
+	log.WithField("provider", conf.KMS.Provider).Info("kms: key wrapper configured")
+
+	return nil
+}
+
+// GetKeyWrapper returns the configured key wrapper. It returns nil when no
+// provider has been configured.
+func GetKeyWrapper() KeyWrapper {
+	return w
+}
+
+// SetKeyWrapper sets the key wrapper.
+func SetKeyWrapper(kw KeyWrapper) {
+	w = kw
+}
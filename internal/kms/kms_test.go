@@ -0,0 +1,173 @@
+package kms
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+func TestSetup(t *testing.T) {
+	assert := require.New(t)
+
+	var conf config.Config
+	assert.NoError(Setup(conf))
+	assert.Nil(GetKeyWrapper())
+
+	conf.KMS.Provider = "foo"
+	assert.Error(Setup(conf))
+
+	conf.KMS.Provider = ProviderVaultTransit
+	assert.Error(Setup(conf))
+
+	conf.KMS.Vault.Address = "http://localhost:8200"
+	conf.KMS.Vault.Mount = "transit"
+	conf.KMS.Vault.KeyName = "chirpstack"
+	assert.NoError(Setup(conf))
+	assert.Equal(ProviderVaultTransit, GetKeyWrapper().Provider())
+
+	SetKeyWrapper(nil)
+}
+
+func TestVaultTransit(t *testing.T) {
+	assert := require.New(t)
+
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+
+		if r.Header.Get("X-Vault-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+
+		var req vaultRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var resp vaultResponse
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/chirpstack":
+			resp.Data.Ciphertext = "vault:v1:" + req.Plaintext
+		case "/v1/transit/decrypt/chirpstack":
+			resp.Data.Plaintext = req.Ciphertext[len("vault:v1:"):]
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	var conf config.Config
+	conf.KMS.Vault.Address = server.URL + "/"
+	conf.KMS.Vault.Token = "secret"
+	conf.KMS.Vault.Mount = "transit"
+	conf.KMS.Vault.KeyName = "chirpstack"
+
+	kw, err := newVaultTransit(conf)
+	assert.NoError(err)
+
+	key := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	wrapped, err := kw.Wrap(context.Background(), key)
+	assert.NoError(err)
+	assert.Equal("vault:v1:"+base64.StdEncoding.EncodeToString(key), string(wrapped))
+
+	unwrapped, err := kw.Unwrap(context.Background(), wrapped)
+	assert.NoError(err)
+	assert.Equal(key, unwrapped)
+	assert.Equal([]string{"/v1/transit/encrypt/chirpstack", "/v1/transit/decrypt/chirpstack"}, paths)
+
+	kw.(*vaultTransit).token = "invalid"
+	_, err = kw.Wrap(context.Background(), key)
+	assert.EqualError(err, "expected 200, got: 403 (permission denied)")
+}
+
+func TestGCPKMS(t *testing.T) {
+	assert := require.New(t)
+
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+
+		var req gcpCryptRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var resp gcpCryptResponse
+		switch r.URL.Path {
+		case "/projects/p/locations/l/keyRings/r/cryptoKeys/k:encrypt":
+			resp.Ciphertext = append([]byte{0xff}, req.Plaintext...)
+		case "/projects/p/locations/l/keyRings/r/cryptoKeys/k:decrypt":
+			resp.Plaintext = req.Ciphertext[1:]
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	kw := gcpKMS{
+		endpoint: server.URL,
+		keyName:  "projects/p/locations/l/keyRings/r/cryptoKeys/k",
+		client:   http.DefaultClient,
+	}
+
+	key := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	wrapped, err := kw.Wrap(context.Background(), key)
+	assert.NoError(err)
+	assert.Equal(append([]byte{0xff}, key...), wrapped)
+
+	unwrapped, err := kw.Unwrap(context.Background(), wrapped)
+	assert.NoError(err)
+	assert.Equal(key, unwrapped)
+	assert.Len(paths, 2)
+}
+
+func TestAzureKeyVault(t *testing.T) {
+	assert := require.New(t)
+
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+
+		var req azureKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Algorithm != "RSA-OAEP-256" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var resp azureKeyResponse
+		switch r.URL.Path {
+		case "/keys/k/wrapkey":
+			resp.KID = "http://" + r.Host + "/keys/k/v1"
+			resp.Value = "v1." + req.Value
+		case "/keys/k/v1/unwrapkey":
+			resp.KID = "http://" + r.Host + "/keys/k/v1"
+			resp.Value = req.Value[len("v1."):]
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	kw := azureKeyVault{
+		keyURL:    server.URL + "/keys/k",
+		algorithm: azureDefaultAlgo,
+		client:    http.DefaultClient,
+	}
+
+	key := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	wrapped, err := kw.Wrap(context.Background(), key)
+	assert.NoError(err)
+
+	unwrapped, err := kw.Unwrap(context.Background(), wrapped)
+	assert.NoError(err)
+	assert.Equal(key, unwrapped)
+	assert.Equal([]string{"/keys/k/wrapkey", "/keys/k/v1/unwrapkey"}, paths)
+}
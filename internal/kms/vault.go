@@ -0,0 +1,136 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+type vaultRequest struct {
+	Plaintext  string `json:"plaintext,omitempty"`
+	Ciphertext string `json:"ciphertext,omitempty"`
+}
+
+type vaultResponse struct {
+	Data struct {
+		Plaintext  string `json:"plaintext"`
+		Ciphertext string `json:"ciphertext"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+type vaultTransit struct {
+	address string
+	token   string
+	mount   string
+	keyName string
+	client  *http.Client
+}
+
+func newVaultTransit(conf config.Config) (KeyWrapper, error) {
+	c := conf.KMS.Vault
+	if c.Address == "" || c.KeyName == "" {
+		return nil, errors.New("address and key_name must be set")
+	}
+
+	v := vaultTransit{
+		address: strings.TrimRight(c.Address, "/"),
+		token:   c.Token,
+		mount:   strings.Trim(c.Mount, "/"),
+		keyName: c.KeyName,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+
+	if c.CACert != "" {
+		rawCACert, err := ioutil.ReadFile(c.CACert)
+		if err != nil {
+			return nil, errors.Wrap(err, "read ca cert error")
+		}
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(rawCACert) {
+			return nil, errors.New("append ca cert to pool error")
+		}
+		v.client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: caCertPool,
+			},
+		}
+	}
+
+	return &v, nil
+}
+
+func (v *vaultTransit) Provider() string {
+	return ProviderVaultTransit
+}
+
+func (v *vaultTransit) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	resp, err := v.do(ctx, "encrypt", vaultRequest{Plaintext: base64.StdEncoding.EncodeToString(key)})
+	if err != nil {
+		return nil, err
+	}
+
+	// The ciphertext has the format vault:v<version>:<base64>, it is stored
+	// as-is so that Vault is able to select the key version on decrypt.
+	return []byte(resp.Data.Ciphertext), nil
+}
+
+func (v *vaultTransit) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	resp, err := v.do(ctx, "decrypt", vaultRequest{Ciphertext: string(wrapped)})
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode plaintext error")
+	}
+	return b, nil
+}
+
+func (v *vaultTransit) do(ctx context.Context, method string, r vaultRequest) (vaultResponse, error) {
+	var out vaultResponse
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return out, errors.Wrap(err, "marshal request error")
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/v1/%s/%s/%s", v.address, v.mount, method, v.keyName), bytes.NewReader(b))
+	if err != nil {
+		return out, errors.Wrap(err, "new request error")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", v.token)
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return out, errors.Wrap(err, "http request error")
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return out, errors.Wrap(err, "decode response error")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return out, fmt.Errorf("expected 200, got: %d (%s)", resp.StatusCode, strings.Join(out.Errors, ", "))
+	}
+
+	return out, nil
+}
@@ -0,0 +1,243 @@
+// Package leader implements the leader election of the background jobs.
+// When multiple application-server instances are deployed, the instances
+// compete for a lock in Redis and only the instance holding this lock (the
+// leader) runs the background jobs, e.g. the data retention, the evaluation
+// of the automations and the FUOTA deployments. The leader renews its lock
+// periodically, when it stops or becomes unavailable an other instance takes
+// over after the lock TTL.
+//
+// In addition, each job run holds a lock with the name of the job, so that
+// a run started by a previous leader and a run started by the new leader do
+// not overlap.
+package leader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// leaderLock defines the name of the lock held by the leader.
+const leaderLock = "leader"
+
+var (
+	enabled    bool
+	lockTTL    = 30 * time.Second
+	instanceID string
+
+	mux         sync.RWMutex
+	leaderUntil time.Time
+	resigned    bool
+)
+
+// Setup configures the package. When leader election is enabled, it tries
+// to become the leader and starts the loop renewing (or acquiring) the
+// leadership.
+func Setup(conf config.Config) error {
+	c := conf.ApplicationServer.LeaderElection
+
+	enabled = c.Enabled
+	if c.LockTTL > 0 {
+		lockTTL = c.LockTTL
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return errors.Wrap(err, "get hostname error")
+	}
+	id, err := uuid.NewV4()
+	if err != nil {
+		return errors.Wrap(err, "new uuid error")
+	}
+	instanceID = fmt.Sprintf("%s-%s", hostname, id)
+
+	if !enabled {
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"instance_id": instanceID,
+		"lock_ttl":    lockTTL,
+	}).Info("leader: leader election enabled")
+
+	if err := campaign(context.Background()); err != nil {
+		return errors.Wrap(err, "campaign error")
+	}
+
+	go loop()
+
+	return nil
+}
+
+// InstanceID returns the ID of this application-server instance.
+func InstanceID() string {
+	return instanceID
+}
+
+// IsLeader returns true when this instance is the leader. It always returns
+// true when leader election is disabled.
+func IsLeader() bool {
+	if !enabled {
+		return true
+	}
+
+	mux.RLock()
+	defer mux.RUnlock()
+
+	return time.Now().Before(leaderUntil)
+}
+
+// Do runs the given job when this instance is the leader, while holding the
+// lock of the job. It returns without running the job when this instance is
+// not the leader or when the job is still running on an other instance
+// (e.g. the previous leader). The context passed to the job is cancelled
+// when the job lock has been taken over by an other instance.
+func Do(ctx context.Context, job string, f func(ctx context.Context) error) error {
+	if !IsLeader() {
+		log.WithFields(log.Fields{
+			"job":    job,
+			"ctx_id": ctx.Value(logging.ContextIDKey),
+		}).Debug("leader: not the leader, job skipped")
+		return nil
+	}
+
+	if !enabled {
+		return f(ctx)
+	}
+
+	ok, err := storage.AcquireJobLock(ctx, job, instanceID, lockTTL)
+	if err != nil {
+		return errors.Wrap(err, "acquire job lock error")
+	}
+	if !ok {
+		log.WithFields(log.Fields{
+			"job":    job,
+			"ctx_id": ctx.Value(logging.ContextIDKey),
+		}).Warning("leader: job is still running on an other instance, job skipped")
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		cancel()
+
+		if err := storage.ReleaseJobLock(context.Background(), job, instanceID); err != nil {
+			log.WithError(err).WithField("job", job).Error("leader: release job lock error")
+		}
+	}()
+
+	go keepAlive(ctx, job, cancel, done)
+
+	return f(ctx)
+}
+
+// Resign releases the leadership, so that an other instance can take over
+// without waiting for the lock TTL. It must be called on shutdown, after
+// which this instance no longer runs the background jobs.
+func Resign(ctx context.Context) error {
+	if !enabled {
+		return nil
+	}
+
+	mux.Lock()
+	resigned = true
+	leaderUntil = time.Time{}
+	mux.Unlock()
+
+	return storage.ReleaseJobLock(ctx, leaderLock, instanceID)
+}
+
+// keepAlive extends the lock of the running job until done is closed. It
+// cancels the job when the lock has been taken over by an other instance.
+func keepAlive(ctx context.Context, job string, cancel context.CancelFunc, done chan struct{}) {
+	ticker := time.NewTicker(lockTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			ok, err := storage.AcquireJobLock(ctx, job, instanceID, lockTTL)
+			if err != nil {
+				log.WithError(err).WithField("job", job).Error("leader: extend job lock error")
+				continue
+			}
+			if !ok {
+				log.WithFields(log.Fields{
+					"job":    job,
+					"ctx_id": ctx.Value(logging.ContextIDKey),
+				}).Error("leader: job lock lost, job cancelled")
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+func loop() {
+	for {
+		time.Sleep(lockTTL / 3)
+
+		if err := campaign(context.Background()); err != nil {
+			log.WithError(err).Error("leader: campaign error")
+		}
+	}
+}
+
+// campaign acquires or renews the leadership. When the leadership can not
+// be renewed because of an error, this instance remains the leader until the
+// lock expires.
+func campaign(ctx context.Context) error {
+	mux.RLock()
+	r := resigned
+	mux.RUnlock()
+	if r {
+		return nil
+	}
+
+	start := time.Now()
+	ok, err := storage.AcquireJobLock(ctx, leaderLock, instanceID, lockTTL)
+	if err != nil {
+		return err
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+
+	// the leadership acquired while resigning must not be used
+	if resigned {
+		if ok {
+			return storage.ReleaseJobLock(ctx, leaderLock, instanceID)
+		}
+		return nil
+	}
+
+	leader := start.Before(leaderUntil)
+	if ok {
+		leaderUntil = start.Add(lockTTL)
+	} else {
+		leaderUntil = time.Time{}
+	}
+
+	if ok != leader {
+		log.WithFields(log.Fields{
+			"instance_id": instanceID,
+			"leader":      ok,
+		}).Info("leader: leadership changed")
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package leader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	"github.com/ibrahimozekici/app-server2/internal/test"
+)
+
+type LeaderTestSuite struct {
+	suite.Suite
+}
+
+func (ts *LeaderTestSuite) SetupSuite() {
+	assert := require.New(ts.T())
+	conf := test.GetConfig()
+	assert.NoError(storage.Setup(conf))
+}
+
+func (ts *LeaderTestSuite) SetupTest() {
+	storage.RedisClient().FlushAll()
+
+	enabled = true
+	lockTTL = time.Minute
+	instanceID = "instance-a"
+	leaderUntil = time.Time{}
+	resigned = false
+}
+
+func (ts *LeaderTestSuite) TearDownSuite() {
+	enabled = false
+}
+
+func (ts *LeaderTestSuite) TestCampaign() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	assert.False(IsLeader())
+	assert.NoError(campaign(ctx))
+	assert.True(IsLeader())
+
+	// an other instance does not become the leader
+	instanceID = "instance-b"
+	leaderUntil = time.Time{}
+	assert.NoError(campaign(ctx))
+	assert.False(IsLeader())
+
+	// the leader resigns, after which the other instance takes over
+	instanceID = "instance-a"
+	assert.NoError(Resign(ctx))
+	assert.False(IsLeader())
+
+	resigned = false
+	instanceID = "instance-b"
+	assert.NoError(campaign(ctx))
+	assert.True(IsLeader())
+}
+
+func (ts *LeaderTestSuite) TestResigned() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	assert.NoError(campaign(ctx))
+	assert.NoError(Resign(ctx))
+
+	// a resigned instance does not become the leader again
+	assert.NoError(campaign(ctx))
+	assert.False(IsLeader())
+
+	owner, err := storage.GetJobLockOwner(ctx, leaderLock)
+	assert.NoError(err)
+	assert.Equal("", owner)
+}
+
+func (ts *LeaderTestSuite) TestDo() {
+	ctx := context.Background()
+
+	ts.T().Run("Disabled", func(t *testing.T) {
+		assert := require.New(t)
+		enabled = false
+		defer func() { enabled = true }()
+
+		var called bool
+		assert.NoError(Do(ctx, "test", func(ctx context.Context) error {
+			called = true
+			return nil
+		}))
+		assert.True(called)
+	})
+
+	ts.T().Run("Not leader", func(t *testing.T) {
+		assert := require.New(t)
+
+		var called bool
+		assert.NoError(Do(ctx, "test", func(ctx context.Context) error {
+			called = true
+			return nil
+		}))
+		assert.False(called)
+	})
+
+	ts.T().Run("Leader", func(t *testing.T) {
+		assert := require.New(t)
+		assert.NoError(campaign(ctx))
+
+		var owner string
+		assert.NoError(Do(ctx, "test", func(ctx context.Context) error {
+			var err error
+			owner, err = storage.GetJobLockOwner(ctx, "test")
+			return err
+		}))
+		assert.Equal("instance-a", owner)
+
+		// the job lock is released after the run
+		owner, err := storage.GetJobLockOwner(ctx, "test")
+		assert.NoError(err)
+		assert.Equal("", owner)
+	})
+
+	ts.T().Run("Job running on other instance", func(t *testing.T) {
+		assert := require.New(t)
+		assert.NoError(campaign(ctx))
+
+		ok, err := storage.AcquireJobLock(ctx, "test", "instance-b", time.Minute)
+		assert.NoError(err)
+		assert.True(ok)
+
+		var called bool
+		assert.NoError(Do(ctx, "test", func(ctx context.Context) error {
+			called = true
+			return nil
+		}))
+		assert.False(called)
+	})
+}
+
+func TestLeader(t *testing.T) {
+	suite.Run(t, new(LeaderTestSuite))
+}
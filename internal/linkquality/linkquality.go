@@ -0,0 +1,313 @@
+// Package linkquality implements the link-quality analysis of a device,
+// based on the data-rate and RX metadata of the received uplink frames. It
+// aggregates the data-rate, RSSI and SNR trends and the ADR changes of the
+// device and derives recommendations, giving installers actionable RF
+// feedback.
+package linkquality
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// Recommendation codes.
+const (
+	StuckAtDR0      = "stuck_at_dr0"
+	ADRDisabled     = "adr_disabled"
+	LowMargin       = "low_margin"
+	DROscillation   = "dr_oscillation"
+	SingleGateway   = "single_gateway"
+	SignalDegrading = "signal_degrading"
+)
+
+// Recommendation severities.
+const (
+	SeverityInfo    = "info"
+	SeverityWarning = "warning"
+)
+
+// Thresholds used for the recommendations.
+const (
+	// minFrames defines the min. number of frames needed for the
+	// recommendations.
+	minFrames = 10
+
+	// goodMargin defines the link margin (dB) above which ADR is expected to
+	// increase the data-rate.
+	goodMargin = 10.0
+
+	// lowMargin defines the link margin (dB) below which frames are likely
+	// to get lost.
+	lowMargin = 3.0
+
+	// maxDRChangeRatio defines the max. ratio of ADR changes to frames before
+	// the data-rate is considered to oscillate.
+	maxDRChangeRatio = 0.2
+
+	// degradingTrend defines the SNR trend (dB) below which the signal is
+	// considered to be degrading.
+	degradingTrend = -3.0
+)
+
+// requiredSNR defines the demodulation floor (dB) per LoRa spreading-factor.
+var requiredSNR = map[int]float64{
+	7:  -7.5,
+	8:  -10,
+	9:  -12.5,
+	10: -15,
+	11: -17.5,
+	12: -20,
+}
+
+// Options defines the analysis options.
+type Options struct {
+	// SpreadFactors maps the data-rate to the LoRa spreading-factor of the
+	// region. When not set, the link margin can not be calculated.
+	SpreadFactors map[int]int
+}
+
+// Report defines the link-quality report of a device.
+type Report struct {
+	Frames          int              `json:"frames"`
+	Start           *time.Time       `json:"start,omitempty"`
+	End             *time.Time       `json:"end,omitempty"`
+	ADR             bool             `json:"adr"`
+	DR              DRStats          `json:"dr"`
+	RSSI            Stats            `json:"rssi"`
+	SNR             Stats            `json:"snr"`
+	Margin          *float64         `json:"margin,omitempty"`
+	Gateways        int              `json:"gateways"`
+	ADRChanges      []DRChange       `json:"adrChanges"`
+	Recommendations []Recommendation `json:"recommendations"`
+}
+
+// DRStats defines the data-rate statistics. Distribution contains the
+// number of frames per data-rate.
+type DRStats struct {
+	Current      int         `json:"current"`
+	Min          int         `json:"min"`
+	Max          int         `json:"max"`
+	Distribution map[int]int `json:"distribution"`
+}
+
+// Stats defines the RSSI or SNR statistics of the best gateway of each
+// frame. Trend is the difference between the average of the second and the
+// first half of the frames.
+type Stats struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Avg   float64 `json:"avg"`
+	Trend float64 `json:"trend"`
+}
+
+// DRChange defines a data-rate change between two consecutive frames.
+type DRChange struct {
+	ReceivedAt time.Time `json:"receivedAt"`
+	FCnt       uint32    `json:"fCnt"`
+	From       int       `json:"from"`
+	To         int       `json:"to"`
+}
+
+// Recommendation defines a recommendation based on the link-quality.
+type Recommendation struct {
+	Code     string `json:"code"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// Analyze returns the link-quality report for the given frames, which must
+// be sorted by received timestamp.
+func Analyze(frames []storage.DeviceFrameLog, opts Options) (Report, error) {
+	r := Report{
+		Frames:          len(frames),
+		ADRChanges:      []DRChange{},
+		Recommendations: []Recommendation{},
+		DR: DRStats{
+			Distribution: make(map[int]int),
+		},
+	}
+
+	if len(frames) == 0 {
+		return r, nil
+	}
+
+	start := frames[0].ReceivedAt
+	end := frames[len(frames)-1].ReceivedAt
+	r.Start = &start
+	r.End = &end
+	r.ADR = frames[len(frames)-1].ADR
+	r.DR.Current = frames[len(frames)-1].DR
+	r.DR.Min = frames[0].DR
+	r.DR.Max = frames[0].DR
+
+	var rssi, snr []float64
+	gateways := make(map[string]struct{})
+
+	for i, f := range frames {
+		r.DR.Distribution[f.DR]++
+		if f.DR < r.DR.Min {
+			r.DR.Min = f.DR
+		}
+		if f.DR > r.DR.Max {
+			r.DR.Max = f.DR
+		}
+
+		if i != 0 && f.DR != frames[i-1].DR {
+			r.ADRChanges = append(r.ADRChanges, DRChange{
+				ReceivedAt: f.ReceivedAt,
+				FCnt:       f.FCnt,
+				From:       frames[i-1].DR,
+				To:         f.DR,
+			})
+		}
+
+		if len(f.RXInfo) == 0 {
+			continue
+		}
+
+		var rxInfo []storage.DeviceFrameLogRXInfo
+		if err := json.Unmarshal(f.RXInfo, &rxInfo); err != nil {
+			return Report{}, errors.Wrap(err, "unmarshal rx-info error")
+		}
+		if len(rxInfo) == 0 {
+			continue
+		}
+
+		best := rxInfo[0]
+		for _, rx := range rxInfo {
+			gateways[rx.GatewayID.String()] = struct{}{}
+			if rx.LoRaSNR > best.LoRaSNR {
+				best = rx
+			}
+		}
+
+		rssi = append(rssi, float64(best.RSSI))
+		snr = append(snr, best.LoRaSNR)
+	}
+
+	r.Gateways = len(gateways)
+	r.RSSI = newStats(rssi)
+	r.SNR = newStats(snr)
+
+	if sf, ok := opts.SpreadFactors[r.DR.Current]; ok && len(snr) != 0 {
+		if floor, ok := requiredSNR[sf]; ok {
+			margin := math.Round((r.SNR.Avg-floor)*10) / 10
+			r.Margin = &margin
+		}
+	}
+
+	if len(frames) >= minFrames {
+		r.Recommendations = recommendations(r)
+	}
+
+	return r, nil
+}
+
+func recommendations(r Report) []Recommendation {
+	out := []Recommendation{}
+
+	if r.DR.Max == 0 {
+		switch {
+		case !r.ADR:
+			out = append(out, Recommendation{
+				Code:     StuckAtDR0,
+				Severity: SeverityWarning,
+				Message:  "The device is stuck at DR0 and ADR is disabled by the device, enable ADR to reduce the airtime and battery consumption.",
+			})
+		case r.Margin != nil && *r.Margin >= goodMargin:
+			out = append(out, Recommendation{
+				Code:     StuckAtDR0,
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("The device is stuck at DR0 with a link margin of %.1f dB, check that the device receives and applies the ADR (LinkADRReq) downlinks.", *r.Margin),
+			})
+		default:
+			out = append(out, Recommendation{
+				Code:     StuckAtDR0,
+				Severity: SeverityWarning,
+				Message:  "The device is stuck at DR0, improve the gateway coverage or the antenna placement of the device.",
+			})
+		}
+	} else if !r.ADR && r.Margin != nil && *r.Margin >= goodMargin {
+		out = append(out, Recommendation{
+			Code:     ADRDisabled,
+			Severity: SeverityInfo,
+			Message:  fmt.Sprintf("ADR is disabled by the device while the link margin is %.1f dB, enable ADR to use a higher data-rate.", *r.Margin),
+		})
+	}
+
+	if r.Margin != nil && *r.Margin < lowMargin {
+		out = append(out, Recommendation{
+			Code:     LowMargin,
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf("The link margin is %.1f dB at DR%d, uplinks are likely to get lost. Improve the gateway coverage or the antenna placement of the device.", *r.Margin, r.DR.Current),
+		})
+	}
+
+	if float64(len(r.ADRChanges)) > float64(r.Frames)*maxDRChangeRatio {
+		out = append(out, Recommendation{
+			Code:     DROscillation,
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf("The data-rate changed %d times in %d uplinks, the link is unstable (e.g. a moving device or changing obstructions).", len(r.ADRChanges), r.Frames),
+		})
+	}
+
+	if r.Gateways == 1 {
+		out = append(out, Recommendation{
+			Code:     SingleGateway,
+			Severity: SeverityInfo,
+			Message:  "The uplinks are received by a single gateway, an additional gateway in range would improve the reliability.",
+		})
+	}
+
+	if r.SNR.Trend <= degradingTrend {
+		out = append(out, Recommendation{
+			Code:     SignalDegrading,
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf("The SNR decreased by %.1f dB over the analyzed period, check the device for changes in placement or new obstructions.", -r.SNR.Trend),
+		})
+	}
+
+	return out
+}
+
+func newStats(values []float64) Stats {
+	if len(values) == 0 {
+		return Stats{}
+	}
+
+	s := Stats{
+		Min: values[0],
+		Max: values[0],
+		Avg: round(avg(values)),
+	}
+
+	for _, v := range values {
+		s.Min = math.Min(s.Min, v)
+		s.Max = math.Max(s.Max, v)
+	}
+
+	if len(values) >= 2 {
+		half := len(values) / 2
+		s.Trend = round(avg(values[len(values)-half:]) - avg(values[:half]))
+	}
+
+	return s
+}
+
+func avg(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func round(v float64) float64 {
+	return math.Round(v*10) / 10
+}
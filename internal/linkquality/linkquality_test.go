@@ -0,0 +1,190 @@
+package linkquality
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+// euSpreadFactors maps the EU868 data-rates to the spreading-factor.
+var euSpreadFactors = map[int]int{0: 12, 1: 11, 2: 10, 3: 9, 4: 8, 5: 7}
+
+func frames(t *testing.T, dr []int, adr bool, snr func(i int) float64, gateways ...lorawan.EUI64) []storage.DeviceFrameLog {
+	start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	var out []storage.DeviceFrameLog
+	for i, d := range dr {
+		var rxInfo []storage.DeviceFrameLogRXInfo
+		for j, gatewayID := range gateways {
+			rxInfo = append(rxInfo, storage.DeviceFrameLogRXInfo{
+				GatewayID: gatewayID,
+				RSSI:      -100 - j,
+				LoRaSNR:   snr(i) - float64(j),
+			})
+		}
+
+		b, err := json.Marshal(rxInfo)
+		require.NoError(t, err)
+
+		out = append(out, storage.DeviceFrameLog{
+			ReceivedAt: start.Add(time.Duration(i) * time.Minute),
+			FCnt:       uint32(i),
+			DR:         d,
+			ADR:        adr,
+			RXInfo:     b,
+		})
+	}
+
+	return out
+}
+
+func repeat(dr, n int) []int {
+	out := make([]int, n)
+	for i := range out {
+		out[i] = dr
+	}
+	return out
+}
+
+func codes(r Report) []string {
+	out := []string{}
+	for _, rec := range r.Recommendations {
+		out = append(out, rec.Code)
+	}
+	return out
+}
+
+func TestAnalyze(t *testing.T) {
+	gw1 := lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 1}
+	gw2 := lorawan.EUI64{2, 2, 2, 2, 2, 2, 2, 2}
+	opts := Options{SpreadFactors: euSpreadFactors}
+	constant := func(v float64) func(int) float64 {
+		return func(int) float64 { return v }
+	}
+
+	t.Run("No frames", func(t *testing.T) {
+		assert := require.New(t)
+
+		r, err := Analyze(nil, opts)
+		assert.NoError(err)
+		assert.Equal(0, r.Frames)
+		assert.Nil(r.Start)
+		assert.Empty(r.Recommendations)
+	})
+
+	t.Run("Statistics", func(t *testing.T) {
+		assert := require.New(t)
+
+		r, err := Analyze(frames(t, []int{0, 0, 2, 5}, true, func(i int) float64 { return float64(i) }, gw1, gw2), opts)
+		assert.NoError(err)
+
+		assert.Equal(4, r.Frames)
+		assert.True(r.ADR)
+		assert.Equal(DRStats{
+			Current:      5,
+			Min:          0,
+			Max:          5,
+			Distribution: map[int]int{0: 2, 2: 1, 5: 1},
+		}, r.DR)
+		assert.Equal(Stats{Min: 0, Max: 3, Avg: 1.5, Trend: 2}, r.SNR)
+		assert.Equal(Stats{Min: -100, Max: -100, Avg: -100}, r.RSSI)
+		assert.Equal(2, r.Gateways)
+		assert.Len(r.ADRChanges, 2)
+		assert.Equal(DRChange{
+			ReceivedAt: r.ADRChanges[0].ReceivedAt,
+			FCnt:       2,
+			From:       0,
+			To:         2,
+		}, r.ADRChanges[0])
+
+		// SF7 demodulation floor is -7.5 dB
+		assert.NotNil(r.Margin)
+		assert.Equal(9.0, *r.Margin)
+
+		// not enough frames for recommendations
+		assert.Empty(r.Recommendations)
+	})
+
+	tests := []struct {
+		Name          string
+		Frames        []storage.DeviceFrameLog
+		Options       Options
+		ExpectedCodes []string
+	}{
+		{
+			Name:          "healthy link",
+			Frames:        frames(t, repeat(5, 20), true, constant(5), gw1, gw2),
+			Options:       opts,
+			ExpectedCodes: []string{},
+		},
+		{
+			Name:          "stuck at DR0 with good margin",
+			Frames:        frames(t, repeat(0, 20), true, constant(5), gw1, gw2),
+			Options:       opts,
+			ExpectedCodes: []string{StuckAtDR0},
+		},
+		{
+			Name:          "stuck at DR0 with ADR disabled",
+			Frames:        frames(t, repeat(0, 20), false, constant(5), gw1, gw2),
+			Options:       opts,
+			ExpectedCodes: []string{StuckAtDR0},
+		},
+		{
+			Name:          "stuck at DR0 without region",
+			Frames:        frames(t, repeat(0, 20), true, constant(5), gw1, gw2),
+			ExpectedCodes: []string{StuckAtDR0},
+		},
+		{
+			Name:          "ADR disabled",
+			Frames:        frames(t, repeat(2, 20), false, constant(5), gw1, gw2),
+			Options:       opts,
+			ExpectedCodes: []string{ADRDisabled},
+		},
+		{
+			Name:          "low margin",
+			Frames:        frames(t, repeat(5, 20), true, constant(-6), gw1, gw2),
+			Options:       opts,
+			ExpectedCodes: []string{LowMargin},
+		},
+		{
+			Name:          "data-rate oscillation",
+			Frames:        frames(t, []int{5, 3, 5, 3, 5, 3, 5, 5, 5, 5, 5, 5}, true, constant(5), gw1, gw2),
+			Options:       opts,
+			ExpectedCodes: []string{DROscillation},
+		},
+		{
+			Name:          "single gateway",
+			Frames:        frames(t, repeat(5, 20), true, constant(5), gw1),
+			Options:       opts,
+			ExpectedCodes: []string{SingleGateway},
+		},
+		{
+			Name:          "signal degrading",
+			Frames:        frames(t, repeat(5, 20), true, func(i int) float64 { return 10 - float64(i)/2 }, gw1, gw2),
+			Options:       opts,
+			ExpectedCodes: []string{SignalDegrading},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			r, err := Analyze(tst.Frames, tst.Options)
+			assert.NoError(err)
+			assert.Equal(tst.ExpectedCodes, codes(r))
+		})
+	}
+
+	t.Run("Invalid rx-info", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := Analyze([]storage.DeviceFrameLog{{RXInfo: []byte("{")}}, opts)
+		assert.Error(err)
+	})
+}
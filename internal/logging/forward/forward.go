@@ -0,0 +1,135 @@
+// Package forward implements the forwarding of the log messages to remote
+// log targets (syslog over TCP / TLS and Grafana Loki).
+package forward
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+// queueSize defines the number of log messages that can be queued per
+// target. When the queue is full, log messages are dropped.
+const queueSize = 10000
+
+// moduleRegexp matches the module prefix of a log message, e.g. "api/js"
+// for "api/js: join-request refused".
+var moduleRegexp = regexp.MustCompile(`^([a-z][a-z0-9_\-]*(?:/[a-z0-9_\-]+)*): `)
+
+// Setup adds the configured log forwarding hooks to the standard logger.
+func Setup(conf config.Config) error {
+	c := conf.General.LogForwarding
+
+	if c.Syslog.Server != "" {
+		var tlsConfig *tls.Config
+		if c.Syslog.TLS {
+			var err error
+			tlsConfig, err = newTLSConfig(c.Syslog.CACert, c.Syslog.TLSCert, c.Syslog.TLSKey)
+			if err != nil {
+				return errors.Wrap(err, "syslog tls config error")
+			}
+		}
+
+		hostname, _ := os.Hostname()
+		log.AddHook(newSyslogHook(c.Syslog.Server, tlsConfig, hostname, c.Syslog.AppName, c.Syslog.Facility))
+
+		log.WithFields(log.Fields{
+			"server": c.Syslog.Server,
+			"tls":    c.Syslog.TLS,
+		}).Info("logging: forwarding log messages to syslog server")
+	}
+
+	if c.Loki.URL != "" {
+		log.AddHook(newLokiHook(lokiConfig{
+			url:           c.Loki.URL,
+			username:      c.Loki.Username,
+			password:      c.Loki.Password,
+			tenantID:      c.Loki.TenantID,
+			labels:        c.Loki.Labels,
+			batchSize:     c.Loki.BatchSize,
+			batchInterval: c.Loki.BatchInterval,
+		}))
+
+		log.WithFields(log.Fields{
+			"url": c.Loki.URL,
+		}).Info("logging: forwarding log messages to loki")
+	}
+
+	return nil
+}
+
+// entryModule returns the module which logged the given entry, based on the
+// prefix of the log message. An empty string is returned when the message
+// has no module prefix.
+func entryModule(e *log.Entry) string {
+	m := moduleRegexp.FindStringSubmatch(e.Message)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// entryApplicationID returns the application_id field of the given entry
+// as string. An empty string is returned when the entry has no
+// application_id field.
+func entryApplicationID(e *log.Entry) string {
+	switch v := e.Data["application_id"].(type) {
+	case nil:
+		return ""
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case string:
+		return v
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// dropCounter counts and periodically reports the dropped log messages of a
+// target. As these can not be logged through the logger itself (the target
+// is unable to keep up), the report is written to stderr.
+type dropCounter struct {
+	target  string
+	dropped uint64
+}
+
+func (c *dropCounter) inc() {
+	if n := atomic.AddUint64(&c.dropped, 1); n == 1 || n%1000 == 0 {
+		fmt.Fprintf(os.Stderr, "logging: %s queue is full, %d log messages dropped\n", c.target, n)
+	}
+}
+
+func newTLSConfig(caCert, tlsCert, tlsKey string) (*tls.Config, error) {
+	var tlsConfig tls.Config
+
+	if caCert != "" {
+		b, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, errors.Wrap(err, "read ca certificate error")
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(b) {
+			return nil, errors.New("append ca certificate error")
+		}
+	}
+
+	if tlsCert != "" || tlsKey != "" {
+		cert, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "load x509 keypair error")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &tlsConfig, nil
+}
@@ -0,0 +1,37 @@
+package forward
+
+import (
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntryModule(t *testing.T) {
+	tests := []struct {
+		Message  string
+		Expected string
+	}{
+		{"api/js: join-request refused", "api/js"},
+		{"integration/multi: slow integration delivery", "integration/multi"},
+		{"storage: PostgreSQL data migrations applied", "storage"},
+		{"starting ChirpStack Application Server", ""},
+		{"metrics saved", ""},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Message, func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(tst.Expected, entryModule(&log.Entry{Message: tst.Message}))
+		})
+	}
+}
+
+func TestEntryApplicationID(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("", entryApplicationID(&log.Entry{Data: log.Fields{}}))
+	assert.Equal("10", entryApplicationID(&log.Entry{Data: log.Fields{"application_id": int64(10)}}))
+	assert.Equal("11", entryApplicationID(&log.Entry{Data: log.Fields{"application_id": "11"}}))
+	assert.Equal("12", entryApplicationID(&log.Entry{Data: log.Fields{"application_id": uint64(12)}}))
+}
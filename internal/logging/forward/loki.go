@@ -0,0 +1,208 @@
+package forward
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	lokiPushPath    = "/loki/api/v1/push"
+	lokiPushTimeout = 10 * time.Second
+)
+
+type lokiConfig struct {
+	url           string
+	username      string
+	password      string
+	tenantID      string
+	labels        map[string]string
+	batchSize     int
+	batchInterval time.Duration
+}
+
+// lokiEntry defines a queued log message.
+type lokiEntry struct {
+	labels map[string]string
+	time   time.Time
+	line   string
+}
+
+// lokiStream defines a stream of the Loki push request.
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// lokiPushRequest defines the Loki push request.
+type lokiPushRequest struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+// lokiHook forwards the log messages in batches to the Loki push API.
+type lokiHook struct {
+	config lokiConfig
+	client http.Client
+
+	queue chan lokiEntry
+	drops dropCounter
+}
+
+func newLokiHook(c lokiConfig) *lokiHook {
+	if c.batchSize <= 0 {
+		c.batchSize = 1000
+	}
+	if c.batchInterval <= 0 {
+		c.batchInterval = time.Second
+	}
+	if !strings.HasSuffix(c.url, lokiPushPath) {
+		c.url = strings.TrimSuffix(c.url, "/") + lokiPushPath
+	}
+
+	h := &lokiHook{
+		config: c,
+		client: http.Client{Timeout: lokiPushTimeout},
+		queue:  make(chan lokiEntry, queueSize),
+		drops:  dropCounter{target: "loki"},
+	}
+
+	go h.loop()
+
+	return h
+}
+
+// Levels implements the logrus.Hook interface.
+func (h *lokiHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire implements the logrus.Hook interface.
+func (h *lokiHook) Fire(e *log.Entry) error {
+	line, err := e.String()
+	if err != nil {
+		return err
+	}
+
+	labels := make(map[string]string, len(h.config.labels)+3)
+	for k, v := range h.config.labels {
+		labels[k] = v
+	}
+	labels["level"] = e.Level.String()
+	if m := entryModule(e); m != "" {
+		labels["module"] = m
+	}
+	if id := entryApplicationID(e); id != "" {
+		labels["application_id"] = id
+	}
+
+	select {
+	case h.queue <- lokiEntry{labels: labels, time: e.Time, line: strings.TrimRight(line, "\n")}:
+	default:
+		h.drops.inc()
+	}
+
+	return nil
+}
+
+func (h *lokiHook) loop() {
+	ticker := time.NewTicker(h.config.batchInterval)
+	defer ticker.Stop()
+
+	var batch []lokiEntry
+
+	for {
+		select {
+		case e := <-h.queue:
+			batch = append(batch, e)
+			if len(batch) < h.config.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+
+		if err := h.push(batch); err != nil {
+			fmt.Fprintf(os.Stderr, "logging: push %d log messages to loki error: %s\n", len(batch), err)
+		}
+		batch = nil
+	}
+}
+
+// push sends the given log messages to Loki, grouped by their label set.
+func (h *lokiHook) push(batch []lokiEntry) error {
+	b, err := json.Marshal(lokiRequest(batch))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", h.config.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.config.username != "" || h.config.password != "" {
+		req.SetBasicAuth(h.config.username, h.config.password)
+	}
+	if h.config.tenantID != "" {
+		req.Header.Set("X-Scope-OrgID", h.config.tenantID)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("expected 2xx response, got: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// lokiRequest returns the push request of the given log messages. The
+// streams are returned in the order of their first log message.
+func lokiRequest(batch []lokiEntry) lokiPushRequest {
+	var out lokiPushRequest
+	streams := make(map[string]int)
+
+	for _, e := range batch {
+		key := lokiLabelsKey(e.labels)
+
+		i, ok := streams[key]
+		if !ok {
+			i = len(out.Streams)
+			streams[key] = i
+			out.Streams = append(out.Streams, lokiStream{Stream: e.labels})
+		}
+
+		out.Streams[i].Values = append(out.Streams[i].Values, [2]string{strconv.FormatInt(e.time.UnixNano(), 10), e.line})
+	}
+
+	return out
+}
+
+// lokiLabelsKey returns a key which is unique for the given label set.
+func lokiLabelsKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s=%q,", k, labels[k])
+	}
+	return b.String()
+}
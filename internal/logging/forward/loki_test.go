@@ -0,0 +1,72 @@
+package forward
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLokiHook(t *testing.T) {
+	assert := require.New(t)
+
+	type request struct {
+		path     string
+		tenantID string
+		body     lokiPushRequest
+	}
+	received := make(chan request, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := request{
+			path:     r.URL.Path,
+			tenantID: r.Header.Get("X-Scope-OrgID"),
+		}
+		json.NewDecoder(r.Body).Decode(&req.body)
+		w.WriteHeader(http.StatusNoContent)
+		received <- req
+	}))
+	defer server.Close()
+
+	logger := log.New()
+	logger.SetFormatter(&log.JSONFormatter{DisableTimestamp: true})
+	logger.AddHook(newLokiHook(lokiConfig{
+		url:           server.URL,
+		tenantID:      "tenant",
+		labels:        map[string]string{"job": "as"},
+		batchSize:     3,
+		batchInterval: time.Hour,
+	}))
+
+	logger.WithField("application_id", int64(1)).Info("integration/multi: integration error")
+	logger.WithField("application_id", int64(1)).Info("integration/multi: integration error")
+	logger.Error("something went wrong")
+
+	select {
+	case req := <-received:
+		assert.Equal("/loki/api/v1/push", req.path)
+		assert.Equal("tenant", req.tenantID)
+		assert.Len(req.body.Streams, 2)
+
+		assert.Equal(map[string]string{
+			"job":            "as",
+			"level":          "info",
+			"module":         "integration/multi",
+			"application_id": "1",
+		}, req.body.Streams[0].Stream)
+		assert.Len(req.body.Streams[0].Values, 2)
+		assert.Equal(`{"application_id":1,"level":"info","msg":"integration/multi: integration error"}`, req.body.Streams[0].Values[0][1])
+
+		assert.Equal(map[string]string{
+			"job":   "as",
+			"level": "error",
+		}, req.body.Streams[1].Stream)
+		assert.Len(req.body.Streams[1].Values, 1)
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+}
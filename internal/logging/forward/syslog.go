@@ -0,0 +1,156 @@
+package forward
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	syslogDialTimeout   = 5 * time.Second
+	syslogWriteTimeout  = 5 * time.Second
+	syslogRetryInterval = 5 * time.Second
+
+	// syslogTimeFormat is the RFC 5424 timestamp format (max. microsecond
+	// precision).
+	syslogTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// syslogHook forwards the log messages to a syslog server over TCP,
+// optionally using TLS.
+type syslogHook struct {
+	server    string
+	tlsConfig *tls.Config
+	hostname  string
+	appName   string
+	facility  int
+
+	queue chan []byte
+	drops dropCounter
+}
+
+func newSyslogHook(server string, tlsConfig *tls.Config, hostname, appName string, facility int) *syslogHook {
+	h := &syslogHook{
+		server:    server,
+		tlsConfig: tlsConfig,
+		hostname:  hostname,
+		appName:   appName,
+		facility:  facility,
+		queue:     make(chan []byte, queueSize),
+		drops:     dropCounter{target: "syslog"},
+	}
+
+	go h.loop()
+
+	return h
+}
+
+// Levels implements the logrus.Hook interface.
+func (h *syslogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire implements the logrus.Hook interface.
+func (h *syslogHook) Fire(e *log.Entry) error {
+	line, err := e.String()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case h.queue <- syslogFrame(h.message(e, line)):
+	default:
+		h.drops.inc()
+	}
+
+	return nil
+}
+
+// message returns the RFC 5424 syslog message of the given entry.
+func (h *syslogHook) message(e *log.Entry, line string) string {
+	hostname := h.hostname
+	if hostname == "" {
+		hostname = "-"
+	}
+	appName := h.appName
+	if appName == "" {
+		appName = "-"
+	}
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d - - %s",
+		h.facility*8+syslogSeverity(e.Level),
+		e.Time.Format(syslogTimeFormat),
+		hostname,
+		appName,
+		os.Getpid(),
+		strings.TrimRight(line, "\n"),
+	)
+}
+
+func (h *syslogHook) loop() {
+	var conn net.Conn
+	var retryAt time.Time
+
+	for msg := range h.queue {
+		if conn == nil {
+			if time.Now().Before(retryAt) {
+				h.drops.inc()
+				continue
+			}
+
+			var err error
+			conn, err = h.dial()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "logging: connect to syslog server error: %s\n", err)
+				retryAt = time.Now().Add(syslogRetryInterval)
+				h.drops.inc()
+				continue
+			}
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+		if _, err := conn.Write(msg); err != nil {
+			fmt.Fprintf(os.Stderr, "logging: write to syslog server error: %s\n", err)
+			conn.Close()
+			conn = nil
+			h.drops.inc()
+		}
+	}
+}
+
+func (h *syslogHook) dial() (net.Conn, error) {
+	dialer := net.Dialer{Timeout: syslogDialTimeout}
+
+	if h.tlsConfig != nil {
+		return tls.DialWithDialer(&dialer, "tcp", h.server, h.tlsConfig)
+	}
+	return dialer.Dial("tcp", h.server)
+}
+
+// syslogFrame frames the given message using octet counting (RFC 6587).
+func syslogFrame(msg string) []byte {
+	return []byte(fmt.Sprintf("%d %s", len(msg), msg))
+}
+
+// syslogSeverity returns the syslog severity of the given log level.
+func syslogSeverity(l log.Level) int {
+	switch l {
+	case log.PanicLevel:
+		return 0 // emergency
+	case log.FatalLevel:
+		return 2 // critical
+	case log.ErrorLevel:
+		return 3 // error
+	case log.WarnLevel:
+		return 4 // warning
+	case log.InfoLevel:
+		return 6 // informational
+	default:
+		return 7 // debug
+	}
+}
@@ -0,0 +1,64 @@
+package forward
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyslogHook(t *testing.T) {
+	assert := require.New(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	defer ln.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		var n int
+		if _, err := fmt.Fscanf(r, "%d ", &n); err != nil {
+			return
+		}
+		b := make([]byte, n)
+		if _, err := r.Read(b); err != nil {
+			return
+		}
+		received <- string(b)
+	}()
+
+	logger := log.New()
+	logger.SetFormatter(&log.TextFormatter{DisableTimestamp: true})
+	logger.AddHook(newSyslogHook(ln.Addr().String(), nil, "test-host", "test-app", 16))
+	logger.Warning("api/js: join-request refused")
+
+	select {
+	case msg := <-received:
+		// local0 (16) * 8 + warning (4)
+		assert.True(strings.HasPrefix(msg, "<132>1 "))
+		assert.True(strings.HasSuffix(msg, fmt.Sprintf(` test-host test-app %d - - level=warning msg="api/js: join-request refused"`, os.Getpid())))
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func TestSyslogSeverity(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal(3, syslogSeverity(log.ErrorLevel))
+	assert.Equal(6, syslogSeverity(log.InfoLevel))
+	assert.Equal(7, syslogSeverity(log.DebugLevel))
+}
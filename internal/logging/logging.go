@@ -28,12 +28,17 @@ type contextIDGetter interface {
 }
 
 // UnaryServerCtxIDInterceptor adds the ContextIDKey to the context and sets
-// it as a log field.
+// it as a log field. When the caller sent a (valid) ctx-id in the request
+// meta-data, this id is used as correlation id, else a new id is generated.
 func UnaryServerCtxIDInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	// generate unique id
-	ctxID, err := uuid.NewV4()
-	if err != nil {
-		return nil, errors.Wrap(err, "new uuid error")
+	ctxID, ok := incomingCtxID(ctx)
+	if !ok {
+		// generate unique id
+		var err error
+		ctxID, err = uuid.NewV4()
+		if err != nil {
+			return nil, errors.Wrap(err, "new uuid error")
+		}
 	}
 
 	// set id to context and add as logrus field
@@ -50,6 +55,26 @@ func UnaryServerCtxIDInterceptor(ctx context.Context, req interface{}, info *grp
 	return handler(ctx, req)
 }
 
+// incomingCtxID returns the ctx-id from the incoming request meta-data.
+func incomingCtxID(ctx context.Context) (uuid.UUID, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return uuid.Nil, false
+	}
+
+	values := md.Get("ctx-id")
+	if len(values) == 0 {
+		return uuid.Nil, false
+	}
+
+	ctxID, err := uuid.FromString(values[0])
+	if err != nil || ctxID == uuid.Nil {
+		return uuid.Nil, false
+	}
+
+	return ctxID, true
+}
+
 // UnaryClientCtxIDInterceptor logs the context id from a RPC response.
 func UnaryClientCtxIDInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
 	// read reasponse meta-data (set by remote server)
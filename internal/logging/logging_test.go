@@ -0,0 +1,60 @@
+package logging
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestUnaryServerCtxIDInterceptor(t *testing.T) {
+	ctxID, err := uuid.FromString("0cdac2d0-5b1f-4b6a-9c1f-4e8c59b1d7f1")
+	require.NoError(t, err)
+
+	tests := []struct {
+		Name     string
+		MD       metadata.MD
+		Expected uuid.UUID
+	}{
+		{
+			Name:     "ctx-id from meta-data",
+			MD:       metadata.Pairs("ctx-id", ctxID.String()),
+			Expected: ctxID,
+		},
+		{
+			Name: "invalid ctx-id",
+			MD:   metadata.Pairs("ctx-id", "foo"),
+		},
+		{
+			Name: "no ctx-id",
+			MD:   metadata.MD{},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			var out interface{}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				out = ctx.Value(ContextIDKey)
+				return nil, nil
+			}
+
+			ctx := metadata.NewIncomingContext(context.Background(), tst.MD)
+			_, err := UnaryServerCtxIDInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+			assert.NoError(err)
+
+			id, ok := out.(uuid.UUID)
+			assert.True(ok)
+			assert.NotEqual(uuid.Nil, id)
+
+			if tst.Expected != uuid.Nil {
+				assert.Equal(tst.Expected, id)
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+// Package measurement implements the mapping of decoded payload fields to
+// named measurements, as configured per device-profile.
+package measurement
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kind defines the measurement kind. A CONTACT measurement holds the state
+// of a binary contact sensor (e.g. a door or window), 1 when open and 0 when
+// closed.
+type Kind string
+
+// Available measurement kinds.
+const (
+	Gauge   Kind = "GAUGE"
+	Counter Kind = "COUNTER"
+	String  Kind = "STRING"
+	Contact Kind = "CONTACT"
+)
+
+// maxDefinitions defines the max. number of measurement definitions of a
+// device-profile.
+const maxDefinitions = 256
+
+var nameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]{0,99}$`)
+
+type contextKey struct{}
+
+// Definition defines a measurement. Rollover and Factor only apply to
+// COUNTER measurements (e.g. pulse, water and energy meters). Rollover is the
+// value at which the counter wraps around to 0 (e.g. 65536 for a 16 bit
+// counter) and Factor the consumption per counter increment (e.g. 0.001 for
+// a water meter counting liters, reported in m³), by default 1.
+type Definition struct {
+	Name     string  `json:"name"`
+	Kind     Kind    `json:"kind"`
+	Unit     string  `json:"unit,omitempty"`
+	Rollover float64 `json:"rollover,omitempty"`
+	Factor   float64 `json:"factor,omitempty"`
+}
+
+// Definitions contains the measurement definitions, keyed by the (dot
+// separated) path of the field in the decoded object, e.g.
+// "sensor.temperature" or "values.0" for the first array item.
+type Definitions map[string]Definition
+
+// Measurement holds a measurement value extracted from a decoded object.
+// Value is a float64 for gauges, counters and contacts and a string
+// otherwise.
+type Measurement struct {
+	Path     string
+	Name     string
+	Kind     Kind
+	Unit     string
+	Rollover float64
+	Factor   float64
+	Value    interface{}
+}
+
+// Validate validates the measurement definitions.
+func (d Definitions) Validate() error {
+	if len(d) > maxDefinitions {
+		return fmt.Errorf("max. number of measurements is %d", maxDefinitions)
+	}
+
+	names := make(map[string]string)
+	for path, def := range d {
+		if path == "" || strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") || strings.Contains(path, "..") {
+			return fmt.Errorf("invalid path: '%s'", path)
+		}
+
+		if !nameRegexp.MatchString(def.Name) {
+			return fmt.Errorf("%s: invalid name: '%s'", path, def.Name)
+		}
+
+		if other, ok := names[def.Name]; ok {
+			return fmt.Errorf("%s: name '%s' is also used by %s", path, def.Name, other)
+		}
+		names[def.Name] = path
+
+		switch def.Kind {
+		case Gauge, Counter, String, Contact:
+		default:
+			return fmt.Errorf("%s: invalid kind: '%s'", path, def.Kind)
+		}
+
+		if def.Kind != Counter && (def.Rollover != 0 || def.Factor != 0) {
+			return fmt.Errorf("%s: rollover and factor only apply to %s measurements", path, Counter)
+		}
+
+		if def.Rollover < 0 || def.Factor < 0 {
+			return fmt.Errorf("%s: rollover and factor must not be negative", path)
+		}
+	}
+
+	return nil
+}
+
+// Value implements the driver.Valuer interface.
+func (d Definitions) Value() (driver.Value, error) {
+	if d == nil {
+		return "{}", nil
+	}
+
+	b, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (d *Definitions) Scan(src interface{}) error {
+	var b []byte
+	switch src := src.(type) {
+	case []byte:
+		b = src
+	case string:
+		b = []byte(src)
+	case nil:
+		*d = nil
+		return nil
+	default:
+		return fmt.Errorf("expected []byte, got %T", src)
+	}
+
+	return json.Unmarshal(b, d)
+}
+
+// Extract returns the measurements of the given (JSON decoded) object,
+// sorted by name. Fields which are not present or of which the type does not
+// match the kind of measurement are skipped.
+func (d Definitions) Extract(obj interface{}) []Measurement {
+	var out []Measurement
+
+	for path, def := range d {
+		v, ok := lookup(obj, strings.Split(path, "."))
+		if !ok {
+			continue
+		}
+
+		m := Measurement{
+			Path: path,
+			Name: def.Name,
+			Kind: def.Kind,
+			Unit: def.Unit,
+		}
+
+		if def.Kind == Counter {
+			m.Rollover = def.Rollover
+			m.Factor = def.Factor
+		}
+
+		switch def.Kind {
+		case Gauge, Counter:
+			switch v := v.(type) {
+			case float64:
+				m.Value = v
+			case bool:
+				if v {
+					m.Value = float64(1)
+				} else {
+					m.Value = float64(0)
+				}
+			default:
+				continue
+			}
+		case String:
+			switch v := v.(type) {
+			case string:
+				m.Value = v
+			case float64:
+				m.Value = strconv.FormatFloat(v, 'f', -1, 64)
+			case bool:
+				m.Value = strconv.FormatBool(v)
+			default:
+				continue
+			}
+		case Contact:
+			open, ok := contactOpen(v)
+			if !ok {
+				continue
+			}
+			m.Value = float64(0)
+			if open {
+				m.Value = float64(1)
+			}
+		default:
+			continue
+		}
+
+		out = append(out, m)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+
+	return out
+}
+
+// ExtractJSON is equal to Extract, but takes a JSON encoded object.
+func (d Definitions) ExtractJSON(objectJSON []byte) ([]Measurement, error) {
+	if len(d) == 0 || len(objectJSON) == 0 {
+		return nil, nil
+	}
+
+	var obj interface{}
+	if err := json.Unmarshal(objectJSON, &obj); err != nil {
+		return nil, err
+	}
+
+	return d.Extract(obj), nil
+}
+
+// contactOpen returns true when the given contact value is open: true, a
+// non-zero number or "open". The returned bool is false when the value is
+// not a contact state.
+func contactOpen(v interface{}) (bool, bool) {
+	switch v := v.(type) {
+	case bool:
+		return v, true
+	case float64:
+		return v != 0, true
+	case string:
+		switch strings.ToLower(v) {
+		case "open", "opened":
+			return true, true
+		case "closed", "close":
+			return false, true
+		}
+	}
+
+	return false, false
+}
+
+// IsOpen returns true when the CONTACT measurement is open.
+func (m Measurement) IsOpen() bool {
+	v, _ := m.Value.(float64)
+	return m.Kind == Contact && v != 0
+}
+
+// Consumption returns the consumption of the COUNTER measurement since the
+// given previous counter value, multiplied by the factor. When the counter
+// has decreased, it either rolled over (when the previous value was in the
+// upper half of the rollover range) or it has been reset (e.g. by a restart
+// of the device), in which case the counter value is the consumption since
+// the reset.
+func (m Measurement) Consumption(prev float64) float64 {
+	v, _ := m.Value.(float64)
+
+	delta := v - prev
+	if delta < 0 {
+		if m.Rollover > 0 && prev >= m.Rollover/2 {
+			delta = m.Rollover - prev + v
+		} else {
+			delta = v
+		}
+	}
+
+	if m.Factor != 0 {
+		delta *= m.Factor
+	}
+
+	return delta
+}
+
+func lookup(v interface{}, path []string) (interface{}, bool) {
+	if len(path) == 0 {
+		return v, true
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		vv, ok := v[path[0]]
+		if !ok {
+			return nil, false
+		}
+		return lookup(vv, path[1:])
+	case []interface{}:
+		i, err := strconv.Atoi(path[0])
+		if err != nil || i < 0 || i >= len(v) {
+			return nil, false
+		}
+		return lookup(v[i], path[1:])
+	default:
+		return nil, false
+	}
+}
+
+// NewContext returns a new context containing the given measurements.
+func NewContext(ctx context.Context, measurements []Measurement) context.Context {
+	return context.WithValue(ctx, contextKey{}, measurements)
+}
+
+// FromContext returns the measurements stored in the context. The returned
+// bool is false when the context does not contain measurements, in which
+// case no measurement definitions are configured.
+func FromContext(ctx context.Context) ([]Measurement, bool) {
+	m, ok := ctx.Value(contextKey{}).([]Measurement)
+	return m, ok
+}
@@ -0,0 +1,248 @@
+package measurement
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Definitions   Definitions
+		ExpectedError string
+	}{
+		{
+			Name: "valid",
+			Definitions: Definitions{
+				"temperature":   {Name: "temperature", Kind: Gauge, Unit: "°C"},
+				"sensor.count":  {Name: "pulse_count", Kind: Counter},
+				"status.0.mode": {Name: "mode", Kind: String},
+				"door":          {Name: "door", Kind: Contact},
+			},
+		},
+		{
+			Name: "invalid path",
+			Definitions: Definitions{
+				"sensor..temperature": {Name: "temperature", Kind: Gauge},
+			},
+			ExpectedError: "invalid path: 'sensor..temperature'",
+		},
+		{
+			Name: "invalid name",
+			Definitions: Definitions{
+				"temperature": {Name: "temp erature", Kind: Gauge},
+			},
+			ExpectedError: "temperature: invalid name: 'temp erature'",
+		},
+		{
+			Name: "invalid kind",
+			Definitions: Definitions{
+				"temperature": {Name: "temperature", Kind: "HISTOGRAM"},
+			},
+			ExpectedError: "temperature: invalid kind: 'HISTOGRAM'",
+		},
+		{
+			Name: "meter",
+			Definitions: Definitions{
+				"water": {Name: "water", Kind: Counter, Unit: "m³", Rollover: 65536, Factor: 0.001},
+			},
+		},
+		{
+			Name: "rollover of gauge",
+			Definitions: Definitions{
+				"temperature": {Name: "temperature", Kind: Gauge, Rollover: 100},
+			},
+			ExpectedError: "temperature: rollover and factor only apply to COUNTER measurements",
+		},
+		{
+			Name: "negative factor",
+			Definitions: Definitions{
+				"water": {Name: "water", Kind: Counter, Factor: -1},
+			},
+			ExpectedError: "water: rollover and factor must not be negative",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			err := tst.Definitions.Validate()
+			if tst.ExpectedError != "" {
+				assert.EqualError(err, tst.ExpectedError)
+				return
+			}
+			assert.NoError(err)
+		})
+	}
+
+	t.Run("duplicate name", func(t *testing.T) {
+		assert := require.New(t)
+
+		err := Definitions{
+			"a": {Name: "temperature", Kind: Gauge},
+			"b": {Name: "temperature", Kind: Gauge},
+		}.Validate()
+		assert.Error(err)
+		assert.Contains(err.Error(), "name 'temperature' is also used by")
+	})
+}
+
+func TestExtractJSON(t *testing.T) {
+	assert := require.New(t)
+
+	defs := Definitions{
+		"temperature":     {Name: "temperature", Kind: Gauge, Unit: "°C"},
+		"sensor.count":    {Name: "pulse_count", Kind: Counter},
+		"sensor.door":     {Name: "door_open", Kind: Gauge},
+		"status.1":        {Name: "mode", Kind: String},
+		"firmware":        {Name: "firmware", Kind: String},
+		"missing":         {Name: "missing", Kind: Gauge},
+		"sensor.label":    {Name: "label", Kind: Gauge},
+		"sensor.count.x":  {Name: "nested", Kind: Gauge},
+		"status.5":        {Name: "out_of_range", Kind: String},
+		"status.one":      {Name: "not_an_index", Kind: String},
+		"sensor.interval": {Name: "interval", Kind: String},
+	}
+
+	out, err := defs.ExtractJSON([]byte(`{
+		"temperature": 21.5,
+		"firmware": "1.2.3",
+		"sensor": {"count": 10, "door": true, "label": "a", "interval": 60},
+		"status": ["ok", "eco"]
+	}`))
+	assert.NoError(err)
+	assert.Equal([]Measurement{
+		{Path: "sensor.door", Name: "door_open", Kind: Gauge, Value: float64(1)},
+		{Path: "firmware", Name: "firmware", Kind: String, Value: "1.2.3"},
+		{Path: "sensor.interval", Name: "interval", Kind: String, Value: "60"},
+		{Path: "status.1", Name: "mode", Kind: String, Value: "eco"},
+		{Path: "sensor.count", Name: "pulse_count", Kind: Counter, Value: float64(10)},
+		{Path: "temperature", Name: "temperature", Kind: Gauge, Unit: "°C", Value: 21.5},
+	}, out)
+
+	t.Run("contact", func(t *testing.T) {
+		assert := require.New(t)
+
+		defs := Definitions{
+			"door":    {Name: "door", Kind: Contact},
+			"window":  {Name: "window", Kind: Contact},
+			"gate":    {Name: "gate", Kind: Contact},
+			"hatch":   {Name: "hatch", Kind: Contact},
+			"unknown": {Name: "unknown", Kind: Contact},
+		}
+
+		out, err := defs.ExtractJSON([]byte(`{"door": true, "window": 0, "gate": "OPEN", "hatch": "closed", "unknown": "ajar"}`))
+		assert.NoError(err)
+		assert.Equal([]Measurement{
+			{Path: "door", Name: "door", Kind: Contact, Value: float64(1)},
+			{Path: "gate", Name: "gate", Kind: Contact, Value: float64(1)},
+			{Path: "hatch", Name: "hatch", Kind: Contact, Value: float64(0)},
+			{Path: "window", Name: "window", Kind: Contact, Value: float64(0)},
+		}, out)
+		assert.True(out[0].IsOpen())
+		assert.False(out[2].IsOpen())
+	})
+
+	t.Run("counter", func(t *testing.T) {
+		assert := require.New(t)
+
+		defs := Definitions{
+			"water": {Name: "water", Kind: Counter, Unit: "m³", Rollover: 65536, Factor: 0.001},
+		}
+
+		out, err := defs.ExtractJSON([]byte(`{"water": 1200}`))
+		assert.NoError(err)
+		assert.Equal([]Measurement{
+			{Path: "water", Name: "water", Kind: Counter, Unit: "m³", Rollover: 65536, Factor: 0.001, Value: float64(1200)},
+		}, out)
+	})
+
+	t.Run("no definitions", func(t *testing.T) {
+		assert := require.New(t)
+
+		out, err := Definitions{}.ExtractJSON([]byte(`{"temperature": 21.5}`))
+		assert.NoError(err)
+		assert.Nil(out)
+	})
+}
+
+func TestScanValue(t *testing.T) {
+	assert := require.New(t)
+
+	defs := Definitions{
+		"temperature": {Name: "temperature", Kind: Gauge, Unit: "°C"},
+	}
+
+	v, err := defs.Value()
+	assert.NoError(err)
+
+	var out Definitions
+	assert.NoError(out.Scan([]byte(v.(string))))
+	assert.Equal(defs, out)
+
+	v, err = Definitions(nil).Value()
+	assert.NoError(err)
+	assert.Equal("{}", v)
+}
+
+func TestContext(t *testing.T) {
+	assert := require.New(t)
+
+	_, ok := FromContext(context.Background())
+	assert.False(ok)
+
+	m := []Measurement{{Name: "temperature", Kind: Gauge, Value: 21.5}}
+	out, ok := FromContext(NewContext(context.Background(), m))
+	assert.True(ok)
+	assert.Equal(m, out)
+}
+
+func TestConsumption(t *testing.T) {
+	tests := []struct {
+		Name        string
+		Measurement Measurement
+		Prev        float64
+		Expected    float64
+	}{
+		{
+			Name:        "increment",
+			Measurement: Measurement{Kind: Counter, Value: float64(150)},
+			Prev:        100,
+			Expected:    50,
+		},
+		{
+			Name:        "factor",
+			Measurement: Measurement{Kind: Counter, Factor: 0.5, Value: float64(150)},
+			Prev:        100,
+			Expected:    25,
+		},
+		{
+			Name:        "rollover",
+			Measurement: Measurement{Kind: Counter, Rollover: 65536, Value: float64(10)},
+			Prev:        65530,
+			Expected:    16,
+		},
+		{
+			Name:        "reset",
+			Measurement: Measurement{Kind: Counter, Rollover: 65536, Value: float64(10)},
+			Prev:        1000,
+			Expected:    10,
+		},
+		{
+			Name:        "reset without rollover",
+			Measurement: Measurement{Kind: Counter, Value: float64(10)},
+			Prev:        65530,
+			Expected:    10,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(tst.Expected, tst.Measurement.Consumption(tst.Prev))
+		})
+	}
+}
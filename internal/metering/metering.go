@@ -0,0 +1,39 @@
+// Package metering implements the consumption metering of pulse, water and
+// energy meters, configured as COUNTER measurements of the device-profile.
+// As the raw counter values are of little use to end customers, each
+// reading is converted into the consumption since the previous reading,
+// taking counter rollovers and resets into account, which is aggregated per
+// day (see storage.AddMeterReading).
+package metering
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ibrahimozekici/app-server2/internal/measurement"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// HandleUplink adds the readings of the COUNTER measurements of the uplink
+// of the device, received at the given time. Other measurements are
+// ignored.
+func HandleUplink(ctx context.Context, d storage.Device, measurements []measurement.Measurement, t time.Time) error {
+	for _, m := range measurements {
+		if m.Kind != measurement.Counter {
+			continue
+		}
+
+		err := storage.Transaction(func(tx sqlx.Ext) error {
+			_, err := storage.AddMeterReading(ctx, tx, d.DevEUI, m, t)
+			return err
+		})
+		if err != nil {
+			return errors.Wrap(err, "add meter reading error")
+		}
+	}
+
+	return nil
+}
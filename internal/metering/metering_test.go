@@ -0,0 +1,123 @@
+package metering
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	nsmock "github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/measurement"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	"github.com/ibrahimozekici/app-server2/internal/test"
+	//"github.com/brocaar/lorawan"
+)
+
+type MeteringTestSuite struct {
+	suite.Suite
+
+	Device storage.Device
+}
+
+func (ts *MeteringTestSuite) SetupSuite() {
+	assert := require.New(ts.T())
+	conf := test.GetConfig()
+	assert.NoError(storage.Setup(conf))
+}
+
+func (ts *MeteringTestSuite) SetupTest() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	test.MustResetDB(storage.DB().DB)
+	storage.RedisClient().FlushAll()
+
+	networkserver.SetPool(nsmock.NewPool(nsmock.NewClient()))
+
+	n := storage.NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(ctx, storage.DB(), &n))
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(ctx, storage.DB(), &org))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(ctx, storage.DB(), &sp))
+	var spID uuid.UUID
+	copy(spID[:], sp.ServiceProfile.Id)
+
+	dp := storage.DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(storage.CreateDeviceProfile(ctx, storage.DB(), &dp))
+	var dpID uuid.UUID
+	copy(dpID[:], dp.DeviceProfile.Id)
+
+	app := storage.Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(storage.CreateApplication(ctx, storage.DB(), &app))
+
+	ts.Device = storage.Device{
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "energy-meter",
+	}
+	assert.NoError(storage.CreateDevice(ctx, storage.DB(), &ts.Device))
+}
+
+func (ts *MeteringTestSuite) TestHandleUplink() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+	now := time.Now()
+
+	reading := func(v float64) []measurement.Measurement {
+		return []measurement.Measurement{
+			{Path: "energy", Name: "energy", Kind: measurement.Counter, Unit: "kWh", Factor: 0.1, Value: v},
+			{Path: "temperature", Name: "temperature", Kind: measurement.Gauge, Value: 21.5},
+		}
+	}
+
+	assert.NoError(HandleUplink(ctx, ts.Device, reading(1000), now.Add(-time.Minute)))
+	assert.NoError(HandleUplink(ctx, ts.Device, reading(1250), now))
+
+	states, err := storage.GetMeterStates(ctx, storage.DB(), ts.Device.DevEUI)
+	assert.NoError(err)
+	assert.Len(states, 1)
+	assert.Equal("energy", states[0].Name)
+	assert.Equal(1250.0, states[0].Value)
+
+	consumption, err := storage.GetMeterConsumption(ctx, storage.DB(), storage.MeterConsumptionFilters{
+		DevEUI: ts.Device.DevEUI,
+		Start:  now.Add(-24 * time.Hour),
+		End:    now.Add(24 * time.Hour),
+	}, storage.AggregationMonth)
+	assert.NoError(err)
+
+	var total float64
+	for _, c := range consumption {
+		total += c.Consumption
+	}
+	assert.InDelta(25, total, 0.0001)
+}
+
+func TestMetering(t *testing.T) {
+	suite.Run(t, new(MeteringTestSuite))
+}
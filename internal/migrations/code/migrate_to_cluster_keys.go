@@ -16,7 +16,7 @@ import (
 // MigrateToClusterKeys migrates the keys to Redis Cluster compatible keys.
 func MigrateToClusterKeys(conf config.Config) error {
 
-	keys, err := storage.RedisClient().Keys("lora:as:metrics:*").Result()
+	keys, err := storage.RedisClient().Keys(storage.GetRedisKey("lora:as:metrics:*")).Result()
 	if err != nil {
 		return errors.Wrap(err, "get keys error")
 	}
@@ -38,7 +38,8 @@ func MigrateToClusterKeys(conf config.Config) error {
 }
 
 func migrateKey(conf config.Config, key string) error {
-	keyParts := strings.Split(key, ":")
+	// the key prefix might contain colons, strip it before splitting the key
+	keyParts := strings.Split(strings.TrimPrefix(key, storage.GetRedisKey("")), ":")
 	if len(keyParts) < 6 {
 		return fmt.Errorf("key %s is invalid", key)
 	}
@@ -55,7 +56,7 @@ func migrateKey(conf config.Config, key string) error {
 		return fmt.Errorf("key %s is invalid", key)
 	}
 
-	newKey := fmt.Sprintf("lora:as:metrics:{%s}:%s", strings.Join(keyParts[3:len(keyParts)-2], ":"), strings.Join(keyParts[len(keyParts)-2:], ":"))
+	newKey := storage.GetRedisKey("lora:as:metrics:{%s}:%s", strings.Join(keyParts[3:len(keyParts)-2], ":"), strings.Join(keyParts[len(keyParts)-2:], ":"))
 
 	val, err := storage.RedisClient().HGetAll(key).Result()
 	if err != nil {
@@ -0,0 +1,126 @@
+package monitoring
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+var startTime = time.Now()
+
+// runtimeStats defines the runtime statistics served by the diagnostics
+// endpoint.
+type runtimeStats struct {
+	GoVersion     string    `json:"goVersion"`
+	StartedAt     time.Time `json:"startedAt"`
+	Uptime        string    `json:"uptime"`
+	NumCPU        int       `json:"numCPU"`
+	GOMAXPROCS    int       `json:"gomaxprocs"`
+	NumGoroutine  int       `json:"numGoroutine"`
+	HeapAlloc     uint64    `json:"heapAlloc"`
+	HeapInuse     uint64    `json:"heapInuse"`
+	HeapObjects   uint64    `json:"heapObjects"`
+	Sys           uint64    `json:"sys"`
+	NumGC         uint32    `json:"numGC"`
+	LastGC        time.Time `json:"lastGC"`
+	PauseTotal    string    `json:"pauseTotal"`
+	GCCPUFraction float64   `json:"gcCPUFraction"`
+}
+
+// setupDiagnostics starts the diagnostics server, serving the pprof profiles,
+// runtime statistics and network-server health, when a bind is configured.
+func setupDiagnostics(c config.Config) error {
+	conf := c.Monitoring.Diagnostics
+	if conf.Bind == "" {
+		return nil
+	}
+
+	if conf.Token == "" {
+		return errors.New("monitoring.diagnostics.token must be set when the diagnostics endpoint is enabled")
+	}
+
+	log.WithFields(log.Fields{
+		"bind": conf.Bind,
+	}).Info("monitoring: setting up diagnostics endpoint")
+
+	server := http.Server{
+		Handler: diagnosticsHandler(conf.Token),
+		Addr:    conf.Bind,
+	}
+
+	go func() {
+		err := server.ListenAndServe()
+		log.WithError(err).Error("monitoring: diagnostics server error")
+	}()
+
+	return nil
+}
+
+// diagnosticsHandler returns the diagnostics handler, which requires the
+// given token as bearer token.
+func diagnosticsHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/runtime", runtimeStatsHandlerFunc)
+	mux.HandleFunc("/debug/network-servers", networkServerHealthHandlerFunc)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			log.WithField("remote_addr", r.RemoteAddr).Warning("monitoring: unauthorized diagnostics request")
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func runtimeStatsHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	stats := runtimeStats{
+		GoVersion:     runtime.Version(),
+		StartedAt:     startTime,
+		Uptime:        time.Since(startTime).String(),
+		NumCPU:        runtime.NumCPU(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+		NumGoroutine:  runtime.NumGoroutine(),
+		HeapAlloc:     ms.HeapAlloc,
+		HeapInuse:     ms.HeapInuse,
+		HeapObjects:   ms.HeapObjects,
+		Sys:           ms.Sys,
+		NumGC:         ms.NumGC,
+		PauseTotal:    time.Duration(ms.PauseTotalNs).String(),
+		GCCPUFraction: ms.GCCPUFraction,
+	}
+	if ms.LastGC != 0 {
+		stats.LastGC = time.Unix(0, int64(ms.LastGC))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// networkServerHealthHandlerFunc serves the health of the network-servers,
+// based on the outcome of the API requests to each network-server.
+func networkServerHealthHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(networkserver.GetHealthAll())
+}
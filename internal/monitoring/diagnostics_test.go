@@ -0,0 +1,73 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiagnosticsHandler(t *testing.T) {
+	h := diagnosticsHandler("secret")
+
+	tests := []struct {
+		Name           string
+		Path           string
+		Authorization  string
+		ExpectedStatus int
+	}{
+		{
+			Name:           "no token",
+			Path:           "/debug/runtime",
+			ExpectedStatus: http.StatusUnauthorized,
+		},
+		{
+			Name:           "invalid token",
+			Path:           "/debug/pprof/",
+			Authorization:  "Bearer foo",
+			ExpectedStatus: http.StatusUnauthorized,
+		},
+		{
+			Name:           "valid token, pprof index",
+			Path:           "/debug/pprof/",
+			Authorization:  "Bearer secret",
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name:           "valid token, runtime stats",
+			Path:           "/debug/runtime",
+			Authorization:  "Bearer secret",
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name:           "valid token, network-server health",
+			Path:           "/debug/network-servers",
+			Authorization:  "Bearer secret",
+			ExpectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			r := httptest.NewRequest("GET", tst.Path, nil)
+			if tst.Authorization != "" {
+				r.Header.Set("Authorization", tst.Authorization)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			assert.Equal(tst.ExpectedStatus, w.Code)
+
+			if tst.Path == "/debug/runtime" && w.Code == http.StatusOK {
+				var stats runtimeStats
+				assert.NoError(json.NewDecoder(w.Body).Decode(&stats))
+				assert.NotZero(stats.NumGoroutine)
+				assert.NotEmpty(stats.GoVersion)
+			}
+		})
+	}
+}
@@ -12,6 +12,10 @@ import (
 
 // Setup setsup the metrics server.
 func Setup(c config.Config) error {
+	if err := setupDiagnostics(c); err != nil {
+		return err
+	}
+
 	if c.Monitoring.Bind != "" {
 		return setupNew(c)
 	}
@@ -46,6 +50,16 @@ func setupNew(c config.Config) error {
 			"endpoint": "/health",
 		}).Info("monitoring: registering healthcheck endpoint")
 		mux.HandleFunc("/health", healthCheckHandlerFunc)
+
+		log.WithFields(log.Fields{
+			"liveness":  "/healthz",
+			"readiness": "/readyz",
+		}).Info("monitoring: registering liveness and readiness endpoints")
+		mux.HandleFunc("/healthz", livenessHandlerFunc)
+		mux.Handle("/readyz", &readinessHandler{
+			timeout: c.Monitoring.HealthcheckTimeout,
+			checks:  readinessChecks,
+		})
 	}
 
 	server := http.Server{
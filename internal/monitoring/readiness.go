@@ -0,0 +1,200 @@
+package monitoring
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/integration"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// readinessMaxNetworkServers defines the max. number of network-servers
+// checked by the readiness endpoint.
+const readinessMaxNetworkServers = 100
+
+// draining is set to 1 once the application-server is shutting down.
+var draining int32
+
+// SetDraining marks the application-server as shutting down. From then on,
+// the readiness endpoint reports the application-server as not ready, such
+// that no new traffic is routed to this instance.
+func SetDraining() {
+	atomic.StoreInt32(&draining, 1)
+}
+
+// readinessCheck defines a named dependency check.
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// readinessCheckResult defines the result of a readiness check.
+type readinessCheckResult struct {
+	OK       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// readinessResponse defines the response of the readiness endpoint.
+type readinessResponse struct {
+	Ready  bool                            `json:"ready"`
+	Checks map[string]readinessCheckResult `json:"checks"`
+}
+
+// livenessHandlerFunc reports that the process is alive. It does not check
+// the dependencies, as a failing dependency must not cause a restart.
+func livenessHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// readinessHandler performs the readiness checks concurrently, each with the
+// given timeout. It responds with 503 when one of the checks fails, such that
+// no traffic is routed to this instance.
+type readinessHandler struct {
+	timeout time.Duration
+	checks  func(ctx context.Context) []readinessCheck
+}
+
+func (h *readinessHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&draining) == 1 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(readinessResponse{
+			Ready:  false,
+			Checks: map[string]readinessCheckResult{},
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	checks := h.checks(ctx)
+	resp := readinessResponse{
+		Ready:  true,
+		Checks: make(map[string]readinessCheckResult),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c readinessCheck) {
+			defer wg.Done()
+
+			start := time.Now()
+			err := runReadinessCheck(ctx, c)
+			res := readinessCheckResult{
+				OK:       err == nil,
+				Duration: time.Since(start).String(),
+			}
+			if err != nil {
+				res.Error = err.Error()
+			}
+
+			mu.Lock()
+			resp.Checks[c.name] = res
+			if err != nil {
+				resp.Ready = false
+			}
+			mu.Unlock()
+		}(c)
+	}
+
+	wg.Wait()
+
+	if !resp.Ready {
+		log.WithField("checks", resp.Checks).Warning("monitoring: readiness check failed")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// runReadinessCheck runs the given check and returns the context error when
+// the check does not return before the context is done.
+func runReadinessCheck(ctx context.Context, c readinessCheck) error {
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- c.check(ctx)
+	}()
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "check timeout")
+	}
+}
+
+// readinessChecks returns the dependency checks of the application-server.
+func readinessChecks(ctx context.Context) []readinessCheck {
+	checks := []readinessCheck{
+		{
+			name: "postgresql",
+			check: func(ctx context.Context) error {
+				return storage.DB().PingContext(ctx)
+			},
+		},
+		{
+			name: "redis",
+			check: func(ctx context.Context) error {
+				return storage.RedisClient().DoContext(ctx, "ping").Err()
+			},
+		},
+	}
+
+	for _, h := range integration.HealthCheckers() {
+		checks = append(checks, readinessCheck{
+			name:  fmt.Sprintf("integration %T", h),
+			check: h.HealthCheck,
+		})
+	}
+
+	nss, err := storage.GetNetworkServers(ctx, storage.DB(), storage.NetworkServerFilters{
+		Limit: readinessMaxNetworkServers,
+	})
+	if err != nil {
+		return append(checks, readinessCheck{
+			name: "network-server",
+			check: func(ctx context.Context) error {
+				return errors.Wrap(err, "get network-servers error")
+			},
+		})
+	}
+
+	for _, n := range nss {
+		n := n
+		checks = append(checks, readinessCheck{
+			name: fmt.Sprintf("network-server %s", n.Name),
+			check: func(ctx context.Context) error {
+				nsClient, err := networkserver.GetPool().Get(n.Server, []byte(n.CACert), []byte(n.TLSCert), []byte(n.TLSKey))
+				if err != nil {
+					return errors.Wrap(err, "get network-server client error")
+				}
+
+				if _, err := nsClient.GetVersion(ctx, &empty.Empty{}); err != nil {
+					return errors.Wrap(err, "get version error")
+				}
+				return nil
+			},
+		})
+	}
+
+	return checks
+}
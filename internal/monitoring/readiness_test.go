@@ -0,0 +1,108 @@
+package monitoring
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadinessHandler(t *testing.T) {
+	tests := []struct {
+		Name           string
+		Checks         []readinessCheck
+		ExpectedStatus int
+		ExpectedReady  bool
+		ExpectedErrors map[string]bool
+	}{
+		{
+			Name: "all checks pass",
+			Checks: []readinessCheck{
+				{name: "a", check: func(ctx context.Context) error { return nil }},
+				{name: "b", check: func(ctx context.Context) error { return nil }},
+			},
+			ExpectedStatus: http.StatusOK,
+			ExpectedReady:  true,
+			ExpectedErrors: map[string]bool{"a": false, "b": false},
+		},
+		{
+			Name: "one check fails",
+			Checks: []readinessCheck{
+				{name: "a", check: func(ctx context.Context) error { return nil }},
+				{name: "b", check: func(ctx context.Context) error { return errors.New("connection refused") }},
+			},
+			ExpectedStatus: http.StatusServiceUnavailable,
+			ExpectedReady:  false,
+			ExpectedErrors: map[string]bool{"a": false, "b": true},
+		},
+		{
+			Name: "check timeout",
+			Checks: []readinessCheck{
+				{name: "a", check: func(ctx context.Context) error {
+					time.Sleep(time.Second)
+					return nil
+				}},
+			},
+			ExpectedStatus: http.StatusServiceUnavailable,
+			ExpectedReady:  false,
+			ExpectedErrors: map[string]bool{"a": true},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			h := readinessHandler{
+				timeout: 50 * time.Millisecond,
+				checks: func(ctx context.Context) []readinessCheck {
+					return tst.Checks
+				},
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+			assert.Equal(tst.ExpectedStatus, w.Code)
+
+			var resp readinessResponse
+			assert.NoError(json.NewDecoder(w.Body).Decode(&resp))
+			assert.Equal(tst.ExpectedReady, resp.Ready)
+			assert.Len(resp.Checks, len(tst.ExpectedErrors))
+
+			for name, hasErr := range tst.ExpectedErrors {
+				assert.Equal(!hasErr, resp.Checks[name].OK, name)
+				assert.Equal(hasErr, resp.Checks[name].Error != "", name)
+			}
+		})
+	}
+
+	t.Run("draining", func(t *testing.T) {
+		assert := require.New(t)
+
+		SetDraining()
+		defer atomic.StoreInt32(&draining, 0)
+
+		h := readinessHandler{
+			timeout: 50 * time.Millisecond,
+			checks: func(ctx context.Context) []readinessCheck {
+				return []readinessCheck{
+					{name: "a", check: func(ctx context.Context) error { return nil }},
+				}
+			},
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+		assert.Equal(http.StatusServiceUnavailable, w.Code)
+
+		var resp readinessResponse
+		assert.NoError(json.NewDecoder(w.Body).Decode(&resp))
+		assert.False(resp.Ready)
+	})
+}
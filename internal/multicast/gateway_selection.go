@@ -0,0 +1,61 @@
+package multicast
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ibrahimozekici/app-server2/internal/multicast/gwselect"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// SelectGateways returns the minimal set of gateways covering all the devices
+// of the given multicast-group, based on the RX metadata of the uplinks
+// received since the given timestamp.
+func SelectGateways(ctx context.Context, db sqlx.Ext, multicastGroupID uuid.UUID, since time.Time, filter gwselect.Filter) (gwselect.Selection, error) {
+	devEUIs, err := storage.GetDevEUIsForMulticastGroup(ctx, db, multicastGroupID)
+	if err != nil {
+		return gwselect.Selection{}, errors.Wrap(err, "get multicast-group devices error")
+	}
+
+	mg, err := storage.GetMulticastGroup(ctx, db, multicastGroupID, false, true)
+	if err != nil {
+		return gwselect.Selection{}, errors.Wrap(err, "get multicast-group error")
+	}
+
+	sp, err := storage.GetServiceProfile(ctx, db, mg.ServiceProfileID, true)
+	if err != nil {
+		return gwselect.Selection{}, errors.Wrap(err, "get service-profile error")
+	}
+
+	// the frame-logs might be stored in the organization schema
+	var receptions []storage.MulticastGroupDeviceReception
+	err = storage.ForOrganization(ctx, db, sp.OrganizationID, func(db sqlx.Ext) error {
+		var err error
+		receptions, err = storage.GetMulticastGroupDeviceReceptions(ctx, db, multicastGroupID, since)
+		return err
+	})
+	if err != nil {
+		return gwselect.Selection{}, errors.Wrap(err, "get multicast-group device receptions error")
+	}
+
+	var devs [][8]byte
+	for _, devEUI := range devEUIs {
+		devs = append(devs, devEUI)
+	}
+
+	var rx []gwselect.Reception
+	for _, r := range receptions {
+		rx = append(rx, gwselect.Reception{
+			DevEUI:    r.DevEUI,
+			GatewayID: r.GatewayID,
+			LoRaSNR:   r.MaxLoRaSNR,
+			RSSI:      r.MaxRSSI,
+		})
+	}
+
+	return gwselect.Select(devs, rx, filter), nil
+}
@@ -0,0 +1,157 @@
+// Package gwselect implements the selection of the minimal set of gateways
+// covering all the devices of a multicast-group, based on the gateways which
+// recently received uplinks of these devices.
+package gwselect
+
+import (
+	"bytes"
+	"sort"
+)
+
+// Reception defines that an uplink of the device was received by the
+// gateway.
+type Reception struct {
+	DevEUI    [8]byte
+	GatewayID [8]byte
+	LoRaSNR   float64
+	RSSI      int
+}
+
+// Filter defines a reception filter. Receptions not matching the filter are
+// ignored, e.g. to ignore gateways which barely received the device.
+type Filter struct {
+	MinLoRaSNR *float64
+	MinRSSI    *int
+}
+
+// Gateway defines a selected gateway and the devices it covers. A device is
+// assigned to the first gateway in the selection covering it.
+type Gateway struct {
+	GatewayID [8]byte
+	DevEUIs   [][8]byte
+}
+
+// Selection defines the result of the gateway selection.
+type Selection struct {
+	// Gateways contains the selected gateways, ordered by the number of
+	// devices covered.
+	Gateways []Gateway
+
+	// Uncovered contains the devices which were not received by any gateway
+	// and which can't be reached by the selection.
+	Uncovered [][8]byte
+}
+
+// GatewayIDs returns the IDs of the selected gateways.
+func (s Selection) GatewayIDs() [][8]byte {
+	out := make([][8]byte, 0, len(s.Gateways))
+	for _, gw := range s.Gateways {
+		out = append(out, gw.GatewayID)
+	}
+	return out
+}
+
+type candidate struct {
+	gatewayID [8]byte
+	devices   map[[8]byte]float64 // device => best snr
+}
+
+// Select returns the minimal set of gateways covering the given devices.
+// As the minimal set-cover problem is NP-hard, this uses the greedy
+// approximation: on each iteration the gateway covering the most of the
+// remaining devices is selected. Ties are broken by the summed SNR of the
+// newly covered devices and then by gateway ID, so that the selection is
+// deterministic.
+func Select(devEUIs [][8]byte, receptions []Reception, filter Filter) Selection {
+	uncovered := make(map[[8]byte]struct{}, len(devEUIs))
+	for _, devEUI := range devEUIs {
+		uncovered[devEUI] = struct{}{}
+	}
+
+	candidates := make(map[[8]byte]*candidate)
+	for _, r := range receptions {
+		if _, ok := uncovered[r.DevEUI]; !ok {
+			continue
+		}
+		if filter.MinLoRaSNR != nil && r.LoRaSNR < *filter.MinLoRaSNR {
+			continue
+		}
+		if filter.MinRSSI != nil && r.RSSI < *filter.MinRSSI {
+			continue
+		}
+
+		c, ok := candidates[r.GatewayID]
+		if !ok {
+			c = &candidate{
+				gatewayID: r.GatewayID,
+				devices:   make(map[[8]byte]float64),
+			}
+			candidates[r.GatewayID] = c
+		}
+
+		if snr, ok := c.devices[r.DevEUI]; !ok || r.LoRaSNR > snr {
+			c.devices[r.DevEUI] = r.LoRaSNR
+		}
+	}
+
+	var out Selection
+
+	for len(uncovered) > 0 {
+		var best *candidate
+		var bestCount int
+		var bestSNR float64
+
+		for _, c := range candidates {
+			var count int
+			var snr float64
+			for devEUI, s := range c.devices {
+				if _, ok := uncovered[devEUI]; ok {
+					count++
+					snr += s
+				}
+			}
+
+			if count == 0 {
+				continue
+			}
+
+			if best == nil || count > bestCount || (count == bestCount && snr > bestSNR) || (count == bestCount && snr == bestSNR && bytes.Compare(c.gatewayID[:], best.gatewayID[:]) < 0) {
+				best = c
+				bestCount = count
+				bestSNR = snr
+			}
+		}
+
+		// the remaining devices are not covered by any gateway
+		if best == nil {
+			break
+		}
+
+		gw := Gateway{
+			GatewayID: best.gatewayID,
+		}
+		for devEUI := range best.devices {
+			if _, ok := uncovered[devEUI]; ok {
+				gw.DevEUIs = append(gw.DevEUIs, devEUI)
+				delete(uncovered, devEUI)
+			}
+		}
+		sortEUIs(gw.DevEUIs)
+
+		out.Gateways = append(out.Gateways, gw)
+		delete(candidates, best.gatewayID)
+	}
+
+	for devEUI := range uncovered {
+		out.Uncovered = append(out.Uncovered, devEUI)
+	}
+	sortEUIs(out.Uncovered)
+
+	return out
+}
+
+func sortEUIs(s [][8]byte) {
+	sort.Slice(s, func(i, j int) bool {
+		return bytes.Compare(s[i][:], s[j][:]) < 0
+	})
+}
@@ -0,0 +1,132 @@
+package gwselect
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelect(t *testing.T) {
+	dev1 := [8]byte{1}
+	dev2 := [8]byte{2}
+	dev3 := [8]byte{3}
+	dev4 := [8]byte{4}
+
+	gw1 := [8]byte{0, 1}
+	gw2 := [8]byte{0, 2}
+	gw3 := [8]byte{0, 3}
+
+	minSNR := -5.0
+
+	tests := []struct {
+		Name       string
+		DevEUIs    [][8]byte
+		Receptions []Reception
+		Filter     Filter
+		Expected   Selection
+	}{
+		{
+			Name:    "no receptions",
+			DevEUIs: [][8]byte{dev2, dev1},
+			Expected: Selection{
+				Uncovered: [][8]byte{dev1, dev2},
+			},
+		},
+		{
+			Name:    "single gateway covers all devices",
+			DevEUIs: [][8]byte{dev1, dev2},
+			Receptions: []Reception{
+				{DevEUI: dev1, GatewayID: gw1},
+				{DevEUI: dev2, GatewayID: gw1},
+				{DevEUI: dev1, GatewayID: gw2},
+			},
+			Expected: Selection{
+				Gateways: []Gateway{
+					{GatewayID: gw1, DevEUIs: [][8]byte{dev1, dev2}},
+				},
+			},
+		},
+		{
+			Name:    "greedy selection",
+			DevEUIs: [][8]byte{dev1, dev2, dev3, dev4},
+			Receptions: []Reception{
+				{DevEUI: dev1, GatewayID: gw1},
+				{DevEUI: dev2, GatewayID: gw1},
+				{DevEUI: dev3, GatewayID: gw1},
+				{DevEUI: dev3, GatewayID: gw2},
+				{DevEUI: dev4, GatewayID: gw2},
+				{DevEUI: dev4, GatewayID: gw3},
+			},
+			Expected: Selection{
+				Gateways: []Gateway{
+					{GatewayID: gw1, DevEUIs: [][8]byte{dev1, dev2, dev3}},
+					{GatewayID: gw2, DevEUIs: [][8]byte{dev4}},
+				},
+			},
+		},
+		{
+			Name:    "tie is broken by snr",
+			DevEUIs: [][8]byte{dev1},
+			Receptions: []Reception{
+				{DevEUI: dev1, GatewayID: gw1, LoRaSNR: -10},
+				{DevEUI: dev1, GatewayID: gw2, LoRaSNR: 5},
+				{DevEUI: dev1, GatewayID: gw1, LoRaSNR: 2},
+			},
+			Expected: Selection{
+				Gateways: []Gateway{
+					{GatewayID: gw2, DevEUIs: [][8]byte{dev1}},
+				},
+			},
+		},
+		{
+			Name:    "receptions of other devices are ignored",
+			DevEUIs: [][8]byte{dev1},
+			Receptions: []Reception{
+				{DevEUI: dev2, GatewayID: gw1},
+				{DevEUI: dev3, GatewayID: gw1},
+				{DevEUI: dev1, GatewayID: gw2},
+			},
+			Expected: Selection{
+				Gateways: []Gateway{
+					{GatewayID: gw2, DevEUIs: [][8]byte{dev1}},
+				},
+			},
+		},
+		{
+			Name:    "filter",
+			DevEUIs: [][8]byte{dev1, dev2},
+			Receptions: []Reception{
+				{DevEUI: dev1, GatewayID: gw1, LoRaSNR: -10},
+				{DevEUI: dev2, GatewayID: gw1, LoRaSNR: 0},
+				{DevEUI: dev1, GatewayID: gw2, LoRaSNR: 0},
+			},
+			Filter: Filter{MinLoRaSNR: &minSNR},
+			Expected: Selection{
+				Gateways: []Gateway{
+					{GatewayID: gw1, DevEUIs: [][8]byte{dev2}},
+					{GatewayID: gw2, DevEUIs: [][8]byte{dev1}},
+				},
+			},
+		},
+		{
+			Name:    "uncovered devices",
+			DevEUIs: [][8]byte{dev1, dev2},
+			Receptions: []Reception{
+				{DevEUI: dev1, GatewayID: gw1},
+			},
+			Expected: Selection{
+				Gateways: []Gateway{
+					{GatewayID: gw1, DevEUIs: [][8]byte{dev1}},
+				},
+				Uncovered: [][8]byte{dev2},
+			},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(tst.Expected, Select(tst.DevEUIs, tst.Receptions, tst.Filter))
+		})
+	}
+}
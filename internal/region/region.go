@@ -0,0 +1,288 @@
+// Package region implements the synchronization of the region metadata of
+// the network-servers. The region of each network-server is periodically
+// retrieved and the channel-plan, data-rates and max. payload sizes of this
+// region are cached, such that these do not have to be hardcoded for payload
+// validation and UI hints.
+package region
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	// "github.com/ibrahimozekici/lora-api/go/v3/common"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/leader"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+	//"github.com/brocaar/lorawan/band"
+)
+
+// maxDataRates defines the number of data-rate indices which are looked up
+// in the band.
+const maxDataRates = 16
+
+var syncInterval time.Duration
+
+// regionBands maps the network-server region to the band name.
+var regionBands = map[common.Region]band.Name{
+	common.Region_EU868: band.EU868,
+	common.Region_US915: band.US915,
+	common.Region_CN779: band.CN779,
+	common.Region_EU433: band.EU433,
+	common.Region_AU915: band.AU915,
+	common.Region_CN470: band.CN470,
+	common.Region_AS923: band.AS923,
+	common.Region_KR920: band.KR920,
+	common.Region_IN865: band.IN865,
+	common.Region_RU864: band.RU864,
+}
+
+var regions = struct {
+	sync.RWMutex
+	byNetworkServerID map[int64]Region
+}{
+	byNetworkServerID: make(map[int64]Region),
+}
+
+// Region defines the region metadata of a network-server.
+type Region struct {
+	NetworkServerID int64      `json:"networkServerID"`
+	Region          string     `json:"region"`
+	Version         string     `json:"version"`
+	UplinkChannels  []Channel  `json:"uplinkChannels"`
+	DataRates       []DataRate `json:"dataRates"`
+	RX2Frequency    uint32     `json:"rx2Frequency"`
+	RX2DataRate     int        `json:"rx2DataRate"`
+	SyncedAt        time.Time  `json:"syncedAt"`
+
+	band band.Band
+}
+
+// Band returns the band of the region.
+func (r Region) Band() band.Band {
+	return r.band
+}
+
+// Channel defines an (enabled) uplink channel of the channel-plan.
+type Channel struct {
+	Frequency uint32 `json:"frequency"`
+	MinDR     int    `json:"minDR"`
+	MaxDR     int    `json:"maxDR"`
+}
+
+// DataRate defines a data-rate of the region. The max. payload size is the
+// max. FRMPayload size (N) of the latest regional parameters revision.
+type DataRate struct {
+	DR             int    `json:"dr"`
+	Modulation     string `json:"modulation"`
+	SpreadFactor   int    `json:"spreadFactor,omitempty"`
+	Bandwidth      int    `json:"bandwidth"`
+	BitRate        int    `json:"bitRate,omitempty"`
+	MaxPayloadSize int    `json:"maxPayloadSize"`
+}
+
+// Setup configures the package and starts the region sync loop. When the
+// sync interval is 0, the region of a network-server is only retrieved on
+// first use.
+func Setup(conf config.Config) error {
+	syncInterval = conf.NetworkServer.RegionSyncInterval
+	if syncInterval == 0 {
+		return nil
+	}
+
+	go syncLoop()
+
+	return nil
+}
+
+func syncLoop() {
+	for {
+		ctxID, err := uuid.NewV4()
+		if err != nil {
+			log.WithError(err).Error("new uuid error")
+		}
+
+		ctx := context.Background()
+		ctx = context.WithValue(ctx, logging.ContextIDKey, ctxID)
+
+		err = leader.Do(ctx, "region_sync", func(ctx context.Context) error {
+			return SyncAll(ctx, storage.DB())
+		})
+		if err != nil {
+			log.WithError(err).Error("region: sync network-server regions error")
+		}
+
+		time.Sleep(syncInterval)
+	}
+}
+
+// SyncAll synchronizes the region metadata of all network-servers. A
+// network-server which can not be reached keeps its last synchronized
+// region metadata.
+func SyncAll(ctx context.Context, db sqlx.Queryer) error {
+	count, err := storage.GetNetworkServerCount(ctx, db, storage.NetworkServerFilters{})
+	if err != nil {
+		return errors.Wrap(err, "get network-server count error")
+	}
+
+	nss, err := storage.GetNetworkServers(ctx, db, storage.NetworkServerFilters{
+		Limit: count,
+	})
+	if err != nil {
+		return errors.Wrap(err, "get network-servers error")
+	}
+
+	for _, n := range nss {
+		if _, err := Sync(ctx, db, n.ID); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"network_server_id": n.ID,
+				"ctx_id":            ctx.Value(logging.ContextIDKey),
+			}).Error("region: sync network-server region error")
+		}
+	}
+
+	return nil
+}
+
+// Sync retrieves and caches the region metadata of the given network-server.
+func Sync(ctx context.Context, db sqlx.Queryer, networkServerID int64) (Region, error) {
+	n, err := storage.GetNetworkServer(ctx, db, networkServerID)
+	if err != nil {
+		return Region{}, errors.Wrap(err, "get network-server error")
+	}
+
+	nsClient, err := networkserver.GetPool().Get(n.Server, []byte(n.CACert), []byte(n.TLSCert), []byte(n.TLSKey))
+	if err != nil {
+		return Region{}, errors.Wrap(err, "get network-server client error")
+	}
+
+	resp, err := nsClient.GetVersion(ctx, &empty.Empty{})
+	if err != nil {
+		return Region{}, errors.Wrap(err, "get network-server version error")
+	}
+
+	r, err := newRegion(networkServerID, resp.Region, resp.Version)
+	if err != nil {
+		return Region{}, err
+	}
+
+	regions.Lock()
+	regions.byNetworkServerID[networkServerID] = r
+	regions.Unlock()
+
+	return r, nil
+}
+
+// Get returns the cached region metadata of the given network-server. It
+// returns false when the region has not been synchronized (yet).
+func Get(networkServerID int64) (Region, bool) {
+	regions.RLock()
+	defer regions.RUnlock()
+
+	r, ok := regions.byNetworkServerID[networkServerID]
+	return r, ok
+}
+
+// GetOrSync returns the cached region metadata of the given network-server,
+// or synchronizes it when not cached. As the sync loop only runs on the
+// leader instance, the cached region metadata is synchronized too when it
+// is older than twice the sync interval. When this fails, the cached region
+// metadata is returned.
+func GetOrSync(ctx context.Context, db sqlx.Queryer, networkServerID int64) (Region, error) {
+	cached, ok := Get(networkServerID)
+	if ok && (syncInterval == 0 || time.Since(cached.SyncedAt) < 2*syncInterval) {
+		return cached, nil
+	}
+
+	r, err := Sync(ctx, db, networkServerID)
+	if err != nil && ok {
+		log.WithError(err).WithFields(log.Fields{
+			"network_server_id": networkServerID,
+			"ctx_id":            ctx.Value(logging.ContextIDKey),
+		}).Warning("region: sync network-server region error, using cached region")
+		return cached, nil
+	}
+	return r, err
+}
+
+// GetBand returns the band of the given network-server.
+func GetBand(ctx context.Context, db sqlx.Queryer, networkServerID int64) (band.Band, error) {
+	r, err := GetOrSync(ctx, db, networkServerID)
+	if err != nil {
+		return nil, err
+	}
+	return r.band, nil
+}
+
+// newRegion returns the region metadata for the given network-server region.
+func newRegion(networkServerID int64, region common.Region, version string) (Region, error) {
+	name, ok := regionBands[region]
+	if !ok {
+		return Region{}, errors.Errorf("region %s is not implemented", region)
+	}
+
+	b, err := band.GetConfig(name, false, lorawan.DwellTimeNoLimit)
+	if err != nil {
+		return Region{}, errors.Wrap(err, "get band config error")
+	}
+
+	defaults := b.GetDefaults()
+
+	r := Region{
+		NetworkServerID: networkServerID,
+		Region:          region.String(),
+		Version:         version,
+		UplinkChannels:  []Channel{},
+		DataRates:       []DataRate{},
+		RX2Frequency:    uint32(defaults.RX2Frequency),
+		RX2DataRate:     defaults.RX2DataRate,
+		SyncedAt:        time.Now(),
+		band:            b,
+	}
+
+	for _, i := range b.GetUplinkChannelIndices() {
+		c, err := b.GetUplinkChannel(i)
+		if err != nil {
+			return Region{}, errors.Wrap(err, "get uplink channel error")
+		}
+
+		r.UplinkChannels = append(r.UplinkChannels, Channel{
+			Frequency: uint32(c.Frequency),
+			MinDR:     c.MinDR,
+			MaxDR:     c.MaxDR,
+		})
+	}
+
+	for i := 0; i < maxDataRates; i++ {
+		dr, err := b.GetDataRate(i)
+		if err != nil {
+			// the data-rate is not defined for this band
+			continue
+		}
+
+		dataRate := DataRate{
+			DR:           i,
+			Modulation:   string(dr.Modulation),
+			SpreadFactor: dr.SpreadFactor,
+			Bandwidth:    dr.Bandwidth,
+			BitRate:      dr.BitRate,
+		}
+
+		if size, err := b.GetMaxPayloadSizeForDataRateIndex("", "", i); err == nil {
+			dataRate.MaxPayloadSize = size.N
+		}
+
+		r.DataRates = append(r.DataRates, dataRate)
+	}
+
+	return r, nil
+}
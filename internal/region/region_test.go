@@ -0,0 +1,53 @@
+package region
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	// "github.com/ibrahimozekici/lora-api/go/v3/common"
+)
+
+func TestNewRegion(t *testing.T) {
+	t.Run("EU868", func(t *testing.T) {
+		assert := require.New(t)
+
+		r, err := newRegion(1, common.Region_EU868, "3.14.0")
+		assert.NoError(err)
+
+		assert.EqualValues(1, r.NetworkServerID)
+		assert.Equal("EU868", r.Region)
+		assert.Equal("3.14.0", r.Version)
+		assert.NotNil(r.Band())
+		assert.EqualValues(869525000, r.RX2Frequency)
+		assert.Equal(0, r.RX2DataRate)
+
+		assert.Equal([]Channel{
+			{Frequency: 868100000, MinDR: 0, MaxDR: 5},
+			{Frequency: 868300000, MinDR: 0, MaxDR: 5},
+			{Frequency: 868500000, MinDR: 0, MaxDR: 5},
+		}, r.UplinkChannels)
+
+		assert.True(len(r.DataRates) >= 8)
+		assert.Equal(DataRate{
+			DR:             0,
+			Modulation:     "LORA",
+			SpreadFactor:   12,
+			Bandwidth:      125,
+			MaxPayloadSize: 51,
+		}, r.DataRates[0])
+		assert.Equal(DataRate{
+			DR:             5,
+			Modulation:     "LORA",
+			SpreadFactor:   7,
+			Bandwidth:      125,
+			MaxPayloadSize: 222,
+		}, r.DataRates[5])
+	})
+
+	t.Run("Unknown region", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := newRegion(1, common.Region(-1), "3.14.0")
+		assert.Error(err)
+	})
+}
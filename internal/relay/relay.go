@@ -0,0 +1,177 @@
+// Package relay implements the configuration of LoRaWAN relays (TS011) and
+// relay capable end-devices. The configuration is sent to the devices as
+// MAC-commands, which are enqueued at the network-server.
+//
+// The end-devices attached to a relay are only stored for management, the
+// relay uplink list itself (UpdateUplinkListReq) contains the session keys
+// of the end-devices and must be provisioned by the network-server.
+package relay
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	//"github.com/ibrahimozekici/lora-api/go/v3/ns"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+// MAC-command identifiers (LoRaWAN TS011).
+const (
+	RelayConfReq     byte = 0x40
+	EndDeviceConfReq byte = 0x41
+	FilterListReq    byte = 0x42
+)
+
+// RelayConfReqPayload returns the RelayConfReq MAC-command (including the
+// CID) for the given relay.
+func RelayConfReqPayload(r storage.Relay) []byte {
+	var secondChIdx uint8
+	if r.SecondChannelFreq != 0 {
+		secondChIdx = 1
+	}
+
+	var startStop uint8
+	if r.Enabled {
+		startStop = 1
+	}
+
+	b := make([]byte, 6)
+	b[0] = RelayConfReq
+	b[1] = (r.SecondChannelACKOffset & 0x07) | (r.SecondChannelDR&0x0f)<<3 | (secondChIdx&0x01)<<7
+	b[2] = (secondChIdx&0x02)>>1 | (r.DefaultChannelIndex&0x01)<<1 | (r.CADPeriodicity&0x07)<<2 | startStop<<5
+	putFreq(b[3:], r.SecondChannelFreq)
+
+	return b
+}
+
+// EndDeviceConfReqPayload returns the EndDeviceConfReq MAC-command
+// (including the CID) for the given relay capable end-device.
+func EndDeviceConfReqPayload(d storage.RelayEndDevice) []byte {
+	var secondChIdx uint8
+	if d.SecondChannelFreq != 0 {
+		secondChIdx = 1
+	}
+
+	b := make([]byte, 7)
+	b[0] = EndDeviceConfReq
+	b[1] = (d.SmartEnableLevel & 0x03) | (d.Mode&0x03)<<2
+	b[2] = (d.SecondChannelACKOffset & 0x07) | (d.SecondChannelDR&0x0f)<<3 | (secondChIdx&0x01)<<7
+	b[3] = (secondChIdx&0x02)>>1 | (d.Backoff&0x3f)<<1
+	putFreq(b[4:], d.SecondChannelFreq)
+
+	return b
+}
+
+// FilterListReqPayload returns the FilterListReq MAC-command (including the
+// CID) for the given relay filter. The filter EUI is the JoinEUI, followed
+// by the DevEUI, both in LoRaWAN (little-endian) byte order.
+func FilterListReqPayload(f storage.RelayFilter) ([]byte, error) {
+	b := []byte{FilterListReq, (f.Index & 0x0f) | (f.Action&0x03)<<4}
+
+	for _, eui := range []*lorawan.EUI64{f.JoinEUI, f.DevEUI} {
+		if eui == nil {
+			break
+		}
+
+		euiB, err := eui.MarshalBinary()
+		if err != nil {
+			return nil, errors.Wrap(err, "marshal eui error")
+		}
+		b = append(b, euiB...)
+	}
+
+	return b, nil
+}
+
+// SendRelayConf enqueues the RelayConfReq MAC-command for the given relay.
+func SendRelayConf(ctx context.Context, db sqlx.Queryer, r storage.Relay) error {
+	if err := enqueue(ctx, db, r.DevEUI, RelayConfReq, RelayConfReqPayload(r)); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": r.DevEUI,
+		"enabled": r.Enabled,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("relay: RelayConfReq enqueued")
+
+	return nil
+}
+
+// SendEndDeviceConf enqueues the EndDeviceConfReq MAC-command for the given
+// relay capable end-device.
+func SendEndDeviceConf(ctx context.Context, db sqlx.Queryer, d storage.RelayEndDevice) error {
+	if err := enqueue(ctx, db, d.DevEUI, EndDeviceConfReq, EndDeviceConfReqPayload(d)); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": d.DevEUI,
+		"mode":    d.Mode,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("relay: EndDeviceConfReq enqueued")
+
+	return nil
+}
+
+// SendFilter enqueues the FilterListReq MAC-command for the given relay
+// filter. To clear a filter rule, a filter with the RelayFilterNoRule action
+// and without EUIs must be sent.
+func SendFilter(ctx context.Context, db sqlx.Queryer, f storage.RelayFilter) error {
+	b, err := FilterListReqPayload(f)
+	if err != nil {
+		return err
+	}
+
+	if err := enqueue(ctx, db, f.RelayDevEUI, FilterListReq, b); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": f.RelayDevEUI,
+		"index":   f.Index,
+		"action":  f.Action,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("relay: FilterListReq enqueued")
+
+	return nil
+}
+
+// enqueue enqueues the given MAC-command at the network-server of the
+// device.
+func enqueue(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64, cid byte, b []byte) error {
+	n, err := storage.GetNetworkServerForDevEUI(ctx, db, devEUI)
+	if err != nil {
+		return errors.Wrap(err, "get network-server error")
+	}
+
+	nsClient, err := networkserver.GetPool().Get(n.Server, []byte(n.CACert), []byte(n.TLSCert), []byte(n.TLSKey))
+	if err != nil {
+		return errors.Wrap(err, "get network-server client error")
+	}
+
+	_, err = nsClient.CreateMACCommandQueueItem(ctx, &ns.CreateMACCommandQueueItemRequest{
+		DevEui:   devEUI[:],
+		Cid:      uint32(cid),
+		Commands: [][]byte{b},
+	})
+	if err != nil {
+		return errors.Wrap(err, "create mac-command queue item error")
+	}
+
+	return nil
+}
+
+// putFreq writes the frequency in steps of 100 Hz as 3 bytes (little-endian).
+func putFreq(b []byte, freq uint32) {
+	freq = freq / 100
+	b[0] = byte(freq)
+	b[1] = byte(freq >> 8)
+	b[2] = byte(freq >> 16)
+}
@@ -0,0 +1,129 @@
+package relay
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	//"github.com/brocaar/lorawan"
+)
+
+func TestRelayConfReqPayload(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Relay    storage.Relay
+		Expected []byte
+	}{
+		{
+			Name:     "disabled",
+			Relay:    storage.Relay{},
+			Expected: []byte{0x40, 0x00, 0x00, 0x00, 0x00, 0x00},
+		},
+		{
+			Name: "enabled with second channel",
+			Relay: storage.Relay{
+				Enabled:                true,
+				CADPeriodicity:         2,
+				DefaultChannelIndex:    1,
+				SecondChannelFreq:      868100000,
+				SecondChannelDR:        3,
+				SecondChannelACKOffset: 2,
+			},
+			Expected: []byte{0x40, 0x9a, 0x2a, 0x28, 0x76, 0x84},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(tst.Expected, RelayConfReqPayload(tst.Relay))
+		})
+	}
+}
+
+func TestEndDeviceConfReqPayload(t *testing.T) {
+	tests := []struct {
+		Name      string
+		EndDevice storage.RelayEndDevice
+		Expected  []byte
+	}{
+		{
+			Name:      "disabled",
+			EndDevice: storage.RelayEndDevice{},
+			Expected:  []byte{0x41, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		},
+		{
+			Name: "dynamic",
+			EndDevice: storage.RelayEndDevice{
+				Mode:             storage.RelayEndDeviceDynamic,
+				SmartEnableLevel: 1,
+				Backoff:          5,
+			},
+			Expected: []byte{0x41, 0x09, 0x00, 0x0a, 0x00, 0x00, 0x00},
+		},
+		{
+			Name: "enabled with second channel",
+			EndDevice: storage.RelayEndDevice{
+				Mode:                   storage.RelayEndDeviceEnabled,
+				SecondChannelFreq:      868100000,
+				SecondChannelDR:        3,
+				SecondChannelACKOffset: 2,
+			},
+			Expected: []byte{0x41, 0x04, 0x9a, 0x00, 0x28, 0x76, 0x84},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(tst.Expected, EndDeviceConfReqPayload(tst.EndDevice))
+		})
+	}
+}
+
+func TestFilterListReqPayload(t *testing.T) {
+	joinEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	devEUI := lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1}
+
+	tests := []struct {
+		Name     string
+		Filter   storage.RelayFilter
+		Expected []byte
+	}{
+		{
+			Name:     "no rule",
+			Filter:   storage.RelayFilter{Index: 3},
+			Expected: []byte{0x42, 0x03},
+		},
+		{
+			Name: "forward JoinEUI",
+			Filter: storage.RelayFilter{
+				Index:   1,
+				Action:  storage.RelayFilterForward,
+				JoinEUI: &joinEUI,
+			},
+			Expected: []byte{0x42, 0x11, 8, 7, 6, 5, 4, 3, 2, 1},
+		},
+		{
+			Name: "filter JoinEUI and DevEUI",
+			Filter: storage.RelayFilter{
+				Index:   15,
+				Action:  storage.RelayFilterFilter,
+				JoinEUI: &joinEUI,
+				DevEUI:  &devEUI,
+			},
+			Expected: []byte{0x42, 0x2f, 8, 7, 6, 5, 4, 3, 2, 1, 1, 2, 3, 4, 5, 6, 7, 8},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			b, err := FilterListReqPayload(tst.Filter)
+			assert.NoError(err)
+			assert.Equal(tst.Expected, b)
+		})
+	}
+}
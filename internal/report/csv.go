@@ -0,0 +1,99 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// csvAttachments returns the CSV attachments of the report, one per section.
+func csvAttachments(r Report) ([]attachment, error) {
+	var out []attachment
+
+	for _, section := range []struct {
+		name string
+		rows [][]string
+	}{
+		{"measurements.csv", measurementRows(r)},
+		{"alarms.csv", alarmRows(r)},
+		{"availability.csv", availabilityRows(r)},
+	} {
+		var b bytes.Buffer
+		w := csv.NewWriter(&b)
+		if err := w.WriteAll(section.rows); err != nil {
+			return nil, errors.Wrap(err, "write csv error")
+		}
+
+		out = append(out, attachment{
+			name:        section.name,
+			contentType: "text/csv",
+			data:        b.Bytes(),
+		})
+	}
+
+	return out, nil
+}
+
+// measurementRows returns the measurements section of the report, the first
+// row contains the column names.
+func measurementRows(r Report) [][]string {
+	rows := [][]string{{"dev_eui", "device", "measurement", "count", "min", "max", "avg"}}
+	for _, m := range r.Measurements {
+		rows = append(rows, []string{
+			m.DevEUI.String(),
+			m.DeviceName,
+			m.Name,
+			strconv.Itoa(m.Count),
+			formatFloat(m.Min),
+			formatFloat(m.Max),
+			formatFloat(m.Avg),
+		})
+	}
+	return rows
+}
+
+// alarmRows returns the alarms section of the report, the first row contains
+// the column names.
+func alarmRows(r Report) [][]string {
+	rows := [][]string{{"dev_eui", "device", "raised", "resolved", "acknowledged", "open"}}
+	for _, a := range r.Alarms {
+		rows = append(rows, []string{
+			a.DevEUI.String(),
+			a.DeviceName,
+			strconv.Itoa(a.Raised),
+			strconv.Itoa(a.Resolved),
+			strconv.Itoa(a.Acknowledged),
+			strconv.Itoa(a.Open),
+		})
+	}
+	return rows
+}
+
+// availabilityRows returns the availability section of the report, the
+// first row contains the column names.
+func availabilityRows(r Report) [][]string {
+	rows := [][]string{{"dev_eui", "device", "uplinks", "active_hours", "availability_pct", "last_seen_at"}}
+	for _, a := range r.Availability {
+		var lastSeenAt string
+		if a.LastSeenAt != nil {
+			lastSeenAt = a.LastSeenAt.Format(time.RFC3339)
+		}
+
+		rows = append(rows, []string{
+			a.DevEUI.String(),
+			a.DeviceName,
+			strconv.Itoa(a.Uplinks),
+			strconv.Itoa(a.ActiveHours),
+			strconv.FormatFloat(r.AvailabilityPercent(a), 'f', 1, 64),
+			lastSeenAt,
+		})
+	}
+	return rows
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
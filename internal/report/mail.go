@@ -0,0 +1,96 @@
+package report
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// base64LineLength defines the max. line length of the base64 encoded
+// attachments (RFC 2045).
+const base64LineLength = 76
+
+// attachment defines an e-mail attachment.
+type attachment struct {
+	name        string
+	contentType string
+	data        []byte
+}
+
+// attachments returns the attachments of the report, based on the format of
+// the subscription.
+func attachments(r Report) ([]attachment, error) {
+	switch r.Subscription.Format {
+	case storage.ReportFormatCSV:
+		return csvAttachments(r)
+	case storage.ReportFormatPDF:
+		return []attachment{pdfAttachment(r)}, nil
+	default:
+		return nil, fmt.Errorf("unexpected report format: %s", r.Subscription.Format)
+	}
+}
+
+// message returns the e-mail message (headers and multipart body) of the
+// report.
+func message(from, to string, r Report) ([]byte, error) {
+	atts, err := attachments(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "get attachments error")
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	pw, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"text/plain; charset=utf-8"},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "create part error")
+	}
+	fmt.Fprintf(pw, "%s\r\n\r\n", r.Title())
+	fmt.Fprintf(pw, "Period: %s - %s\r\n", r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339))
+	fmt.Fprintf(pw, "Measurements: %d\r\n", len(r.Measurements))
+	fmt.Fprintf(pw, "Devices with alarms: %d\r\n", len(r.Alarms))
+	fmt.Fprintf(pw, "Devices: %d\r\n", len(r.Availability))
+
+	for _, att := range atts {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(att.contentType, map[string]string{"name": att.name})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": att.name})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "create part error")
+		}
+
+		enc := base64.StdEncoding.EncodeToString(att.data)
+		for len(enc) > base64LineLength {
+			fmt.Fprintf(pw, "%s\r\n", enc[:base64LineLength])
+			enc = enc[base64LineLength:]
+		}
+		fmt.Fprintf(pw, "%s\r\n", enc)
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, errors.Wrap(err, "close multipart writer error")
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", r.Title()))
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=%s\r\n", mw.Boundary())
+	fmt.Fprintf(&b, "\r\n")
+	b.Write(body.Bytes())
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,145 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// The PDF report is a plain-text rendering of the report using the
+// (built-in) Courier font on A4 pages, so that no external fonts or
+// libraries are needed.
+const (
+	pdfPageWidth    = 595
+	pdfPageHeight   = 842
+	pdfMargin       = 40
+	pdfFontSize     = 9
+	pdfLeading      = 11
+	pdfLinesPerPage = 68
+	pdfLineLength   = 95
+)
+
+// pdfAttachment returns the PDF attachment of the report.
+func pdfAttachment(r Report) attachment {
+	return attachment{
+		name:        "report.pdf",
+		contentType: "application/pdf",
+		data:        renderPDF(textLines(r)),
+	}
+}
+
+// textLines returns the plain-text lines of the report, with each section
+// rendered as a table.
+func textLines(r Report) []string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s\n", r.Title())
+	fmt.Fprintf(&b, "Period: %s - %s\n", r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339))
+
+	for _, section := range []struct {
+		title string
+		rows  [][]string
+	}{
+		{"Measurements", measurementRows(r)},
+		{"Alarms", alarmRows(r)},
+		{"Availability", availabilityRows(r)},
+	} {
+		fmt.Fprintf(&b, "\n%s\n\n", section.title)
+
+		if len(section.rows) < 2 {
+			fmt.Fprintf(&b, "No data.\n")
+			continue
+		}
+
+		w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+		for _, row := range section.rows {
+			fmt.Fprintf(w, "%s\n", strings.Join(row, "\t"))
+		}
+		w.Flush()
+	}
+
+	return strings.Split(strings.TrimRight(b.String(), "\n"), "\n")
+}
+
+// renderPDF returns the PDF document containing the given lines, split over
+// as many pages as needed. Lines exceeding the page width are truncated.
+func renderPDF(lines []string) []byte {
+	var pages [][]string
+	for len(lines) > pdfLinesPerPage {
+		pages = append(pages, lines[:pdfLinesPerPage])
+		lines = lines[pdfLinesPerPage:]
+	}
+	pages = append(pages, lines)
+
+	var b bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, b.Len())
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// objects 1 - 3 are the catalog, pages and font, followed by the page
+	// and content object of each page
+	var kids []string
+	for i := range pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 4+2*i))
+	}
+
+	b.WriteString("%PDF-1.4\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier >>")
+
+	for i, page := range pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", pdfPageWidth, pdfPageHeight, 5+2*i))
+
+		content := pdfPageContent(page)
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content))
+	}
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return b.Bytes()
+}
+
+// pdfPageContent returns the content stream of a page.
+func pdfPageContent(lines []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", pdfFontSize, pdfLeading, pdfMargin, pdfPageHeight-pdfMargin)
+	for _, line := range lines {
+		fmt.Fprintf(&b, "(%s) Tj T*\n", pdfEscape(line))
+	}
+	b.WriteString("ET")
+	return b.String()
+}
+
+// pdfEscape returns the line as PDF string literal content. Characters
+// outside the printable ASCII range are replaced by '?', as these are not
+// covered by the standard encoding of the font.
+func pdfEscape(line string) string {
+	var b strings.Builder
+	var n int
+	for _, r := range line {
+		if n == pdfLineLength {
+			break
+		}
+		n++
+
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r < 32 || r > 126:
+			b.WriteRune('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
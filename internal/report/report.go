@@ -0,0 +1,274 @@
+// Package report implements the scheduled (e-mail) reports. It periodically
+// generates the due reports of the report subscriptions, summarizing the
+// measurements, alarm counts and device availability of an application (or
+// a zone of an application) over the report period, and e-mails these as
+// CSV or PDF attachments to the subscribed user.
+package report
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/leader"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+// emailConfig holds the SMTP configuration used to send the reports.
+type emailConfig struct {
+	server   string
+	username string
+	password string
+	from     string
+}
+
+var (
+	interval  = 5 * time.Minute
+	batchSize = 10
+	email     emailConfig
+
+	// sendMail sends the e-mail, this can be overwritten for testing.
+	sendMail = smtp.SendMail
+)
+
+// Report holds the data of a generated report.
+type Report struct {
+	Subscription storage.ReportSubscription
+	Application  storage.Application
+	Start        time.Time
+	End          time.Time
+	Measurements []storage.ReportMeasurement
+	Alarms       []storage.ReportAlarmCount
+	Availability []storage.ReportAvailability
+}
+
+// Setup configures the package and starts the loop generating the due
+// reports. When no SMTP server is configured, no reports are generated.
+func Setup(conf config.Config) error {
+	c := conf.ApplicationServer.Reports
+
+	if c.Interval > 0 {
+		interval = c.Interval
+	}
+	if c.BatchSize > 0 {
+		batchSize = c.BatchSize
+	}
+
+	email = emailConfig{
+		server:   c.Email.Server,
+		username: c.Email.Username,
+		password: c.Email.Password,
+		from:     c.Email.From,
+	}
+
+	if email.server == "" {
+		log.Info("report: e-mail is not configured, scheduled reports are disabled")
+		return nil
+	}
+
+	go loop()
+
+	return nil
+}
+
+func loop() {
+	for {
+		time.Sleep(interval)
+
+		ctxID, err := uuid.NewV4()
+		if err != nil {
+			log.WithError(err).Error("new uuid error")
+		}
+
+		ctx := context.Background()
+		ctx = context.WithValue(ctx, logging.ContextIDKey, ctxID)
+
+		err = leader.Do(ctx, "report", func(ctx context.Context) error {
+			return Run(ctx, time.Now())
+		})
+		if err != nil {
+			log.WithError(err).Error("report: run error")
+		}
+	}
+}
+
+// Run generates and sends the reports of the subscriptions which are due.
+// The result of each report is stored with the subscription, a failing
+// report does not prevent the other reports from being sent.
+func Run(ctx context.Context, now time.Time) error {
+	for {
+		var subs []storage.ReportSubscription
+
+		// the next run is scheduled within the transaction, the reports are
+		// generated afterwards so that these do not hold the locks
+		err := storage.Transaction(func(tx sqlx.Ext) error {
+			var err error
+			subs, err = storage.GetDueReportSubscriptions(ctx, tx, now, batchSize)
+			if err != nil {
+				return errors.Wrap(err, "get due report subscriptions error")
+			}
+
+			for _, s := range subs {
+				if err := storage.SetReportSubscriptionNextRunAt(ctx, tx, s.ID, s.NextRun(now)); err != nil {
+					return errors.Wrap(err, "set report subscription next run error")
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, s := range subs {
+			var lastError string
+			if err := send(ctx, s); err != nil {
+				log.WithError(err).WithFields(log.Fields{
+					"id":     s.ID,
+					"ctx_id": ctx.Value(logging.ContextIDKey),
+				}).Error("report: send report error")
+				lastError = err.Error()
+			}
+
+			if err := storage.SetReportSubscriptionResult(ctx, storage.DB(), s.ID, now, lastError); err != nil {
+				return errors.Wrap(err, "set report subscription result error")
+			}
+		}
+
+		if len(subs) < batchSize {
+			return nil
+		}
+	}
+}
+
+// send generates the report of the given subscription and e-mails it to
+// the subscribed user. The report covers the period ending at the scheduled
+// run of the subscription.
+func send(ctx context.Context, s storage.ReportSubscription) error {
+	if email.server == "" {
+		return errors.New("e-mail is not configured")
+	}
+
+	user, err := storage.GetUser(ctx, storage.DB(), s.UserID)
+	if err != nil {
+		return errors.Wrap(err, "get user error")
+	}
+	if !user.IsActive {
+		return errors.New("user is not active")
+	}
+
+	r, err := Generate(ctx, s, s.PeriodStart(s.NextRunAt), s.NextRunAt)
+	if err != nil {
+		return errors.Wrap(err, "generate report error")
+	}
+
+	msg, err := message(email.from, user.Email, r)
+	if err != nil {
+		return errors.Wrap(err, "create message error")
+	}
+
+	var auth smtp.Auth
+	if email.username != "" {
+		host, _, err := net.SplitHostPort(email.server)
+		if err != nil {
+			return errors.Wrap(err, "split host port error")
+		}
+		auth = smtp.PlainAuth("", email.username, email.password, host)
+	}
+
+	if err := sendMail(email.server, auth, email.from, []string{user.Email}, msg); err != nil {
+		return errors.Wrap(err, "send mail error")
+	}
+
+	log.WithFields(log.Fields{
+		"id":      s.ID,
+		"user_id": s.UserID,
+		"start":   r.Start,
+		"end":     r.End,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("report: report sent")
+
+	return nil
+}
+
+// Generate returns the report of the given subscription for the given
+// period.
+func Generate(ctx context.Context, s storage.ReportSubscription, start, end time.Time) (Report, error) {
+	r := Report{
+		Subscription: s,
+		Start:        start,
+		End:          end,
+	}
+
+	var err error
+	r.Application, err = storage.GetApplication(ctx, storage.DB(), s.ApplicationID)
+	if err != nil {
+		return r, errors.Wrap(err, "get application error")
+	}
+
+	filters := storage.ReportFilters{
+		ApplicationID: s.ApplicationID,
+		TagKey:        s.ZoneTagKey,
+		Zone:          s.Zone,
+		Start:         start,
+		End:           end,
+		Measurements:  s.Measurements,
+	}
+
+	err = storage.ForOrganization(ctx, storage.DB(), r.Application.OrganizationID, func(db sqlx.Ext) error {
+		var err error
+		r.Measurements, err = storage.GetReportMeasurements(ctx, db, filters)
+		if err != nil {
+			return errors.Wrap(err, "get report measurements error")
+		}
+
+		r.Availability, err = storage.GetReportAvailability(ctx, db, filters)
+		if err != nil {
+			return errors.Wrap(err, "get report availability error")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return r, err
+	}
+
+	r.Alarms, err = storage.GetReportAlarmCounts(ctx, storage.DB(), filters)
+	if err != nil {
+		return r, errors.Wrap(err, "get report alarm counts error")
+	}
+
+	return r, nil
+}
+
+// AvailabilityPercent returns the percentage of the report period in which
+// an uplink was received from the device.
+func (r Report) AvailabilityPercent(a storage.ReportAvailability) float64 {
+	hours := r.End.Sub(r.Start).Hours()
+	if hours <= 0 {
+		return 0
+	}
+
+	v := float64(a.ActiveHours) / hours * 100
+	if v > 100 {
+		return 100
+	}
+	return v
+}
+
+// Title returns the title of the report.
+func (r Report) Title() string {
+	title := r.Application.Name
+	if r.Subscription.Zone != "" {
+		title += " / " + r.Subscription.Zone
+	}
+	return r.Subscription.Name + " - " + title
+}
@@ -0,0 +1,292 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	nsmock "github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	"github.com/ibrahimozekici/app-server2/internal/test"
+	//"github.com/brocaar/lorawan"
+)
+
+func testReport(format storage.ReportFormat) Report {
+	end := time.Date(2020, 1, 9, 0, 0, 0, 0, time.UTC)
+	lastSeenAt := end.Add(-time.Hour)
+
+	return Report{
+		Subscription: storage.ReportSubscription{
+			Name:     "Daily report",
+			Schedule: storage.ReportScheduleDaily,
+			Format:   format,
+			Zone:     "greenhouse",
+		},
+		Application: storage.Application{
+			Name: "farm",
+		},
+		Start: end.AddDate(0, 0, -1),
+		End:   end,
+		Measurements: []storage.ReportMeasurement{
+			{DevEUI: lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}, DeviceName: "sensor-1", Name: "temperature", Count: 2, Min: 18, Max: 22.5, Avg: 20.25},
+		},
+		Alarms: []storage.ReportAlarmCount{
+			{DevEUI: lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}, DeviceName: "sensor-1", Raised: 3, Resolved: 2, Acknowledged: 1, Open: 0},
+		},
+		Availability: []storage.ReportAvailability{
+			{DevEUI: lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}, DeviceName: "sensor-1", Uplinks: 40, ActiveHours: 12, LastSeenAt: &lastSeenAt},
+			{DevEUI: lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1}, DeviceName: "sensor-(2)", Uplinks: 0, ActiveHours: 0},
+		},
+	}
+}
+
+// parseMessage returns the attachments (by filename) of the given message.
+func parseMessage(t *testing.T, msg []byte) (*mail.Message, map[string][]byte) {
+	assert := require.New(t)
+
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	assert.NoError(err)
+
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	assert.NoError(err)
+	assert.Equal("multipart/mixed", mediaType)
+
+	out := make(map[string][]byte)
+	r := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if err != nil {
+			break
+		}
+
+		if p.FileName() == "" {
+			continue
+		}
+
+		assert.Equal("base64", p.Header.Get("Content-Transfer-Encoding"))
+		b, err := ioutil.ReadAll(p)
+		assert.NoError(err)
+		b, err = base64.StdEncoding.DecodeString(strings.Replace(string(b), "\r\n", "", -1))
+		assert.NoError(err)
+		out[p.FileName()] = b
+	}
+
+	return m, out
+}
+
+func TestMessage(t *testing.T) {
+	t.Run("CSV", func(t *testing.T) {
+		assert := require.New(t)
+
+		msg, err := message("reports@example.com", "farmer@example.com", testReport(storage.ReportFormatCSV))
+		assert.NoError(err)
+
+		m, atts := parseMessage(t, msg)
+		assert.Equal("farmer@example.com", m.Header.Get("To"))
+		assert.Len(atts, 3)
+
+		assert.Equal("dev_eui,device,measurement,count,min,max,avg\n0102030405060708,sensor-1,temperature,2,18,22.5,20.25\n", string(atts["measurements.csv"]))
+		assert.Equal("dev_eui,device,raised,resolved,acknowledged,open\n0102030405060708,sensor-1,3,2,1,0\n", string(atts["alarms.csv"]))
+		assert.Equal("dev_eui,device,uplinks,active_hours,availability_pct,last_seen_at\n0102030405060708,sensor-1,40,12,50.0,2020-01-08T23:00:00Z\n0807060504030201,sensor-(2),0,0,0.0,\n", string(atts["availability.csv"]))
+	})
+
+	t.Run("PDF", func(t *testing.T) {
+		assert := require.New(t)
+
+		msg, err := message("reports@example.com", "farmer@example.com", testReport(storage.ReportFormatPDF))
+		assert.NoError(err)
+
+		_, atts := parseMessage(t, msg)
+		assert.Len(atts, 1)
+
+		pdf := string(atts["report.pdf"])
+		assert.True(strings.HasPrefix(pdf, "%PDF-1.4\n"))
+		assert.True(strings.HasSuffix(pdf, "%%EOF\n"))
+		assert.True(strings.Contains(pdf, "(Daily report - farm / greenhouse) Tj T*"))
+		assert.True(strings.Contains(pdf, `sensor-\(2\)`))
+	})
+}
+
+func TestRenderPDF(t *testing.T) {
+	assert := require.New(t)
+
+	var lines []string
+	for i := 0; i < pdfLinesPerPage*2+1; i++ {
+		lines = append(lines, "line")
+	}
+	lines = append(lines, strings.Repeat("x", pdfLineLength+10), "temperature 20°C")
+
+	pdf := string(renderPDF(lines))
+	assert.True(strings.Contains(pdf, "/Count 3"))
+	assert.True(strings.Contains(pdf, "("+strings.Repeat("x", pdfLineLength)+") Tj"))
+	assert.True(strings.Contains(pdf, "(temperature 20?C) Tj"))
+
+	// the xref offsets must point to the objects
+	xref := strings.Index(pdf, "xref\n")
+	assert.True(strings.HasSuffix(pdf, fmt.Sprintf("startxref\n%d\n%%%%EOF\n", xref)))
+
+	entries := strings.Split(pdf[xref:], "\n")[3:]
+	for i, entry := range entries {
+		if !strings.HasSuffix(entry, " n ") {
+			break
+		}
+
+		offset, err := strconv.Atoi(entry[:10])
+		assert.NoError(err)
+		assert.True(strings.HasPrefix(pdf[offset:], fmt.Sprintf("%d 0 obj\n", i+1)))
+	}
+}
+
+type ReportTestSuite struct {
+	suite.Suite
+
+	User         storage.User
+	Application  storage.Application
+	Subscription storage.ReportSubscription
+}
+
+func (ts *ReportTestSuite) SetupSuite() {
+	assert := require.New(ts.T())
+	conf := test.GetConfig()
+	assert.NoError(storage.Setup(conf))
+}
+
+func (ts *ReportTestSuite) SetupTest() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	test.MustResetDB(storage.DB().DB)
+	networkserver.SetPool(nsmock.NewPool(nsmock.NewClient()))
+
+	n := storage.NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(ctx, storage.DB(), &n))
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(ctx, storage.DB(), &org))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(ctx, storage.DB(), &sp))
+	var spID uuid.UUID
+	copy(spID[:], sp.ServiceProfile.Id)
+
+	ts.Application = storage.Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(storage.CreateApplication(ctx, storage.DB(), &ts.Application))
+
+	ts.User = storage.User{
+		IsActive: true,
+		Email:    "farmer@example.com",
+	}
+	assert.NoError(storage.CreateUser(ctx, storage.DB(), &ts.User))
+
+	ts.Subscription = storage.ReportSubscription{
+		UserID:        ts.User.ID,
+		ApplicationID: ts.Application.ID,
+		Name:          "daily",
+		Enabled:       true,
+		Schedule:      storage.ReportScheduleDaily,
+		Format:        storage.ReportFormatCSV,
+	}
+	assert.NoError(storage.CreateReportSubscription(ctx, storage.DB(), &ts.Subscription))
+}
+
+func (ts *ReportTestSuite) TestRun() {
+	ctx := context.Background()
+
+	defer func() {
+		email = emailConfig{}
+		sendMail = smtp.SendMail
+	}()
+
+	var to [][]string
+	var sendErr error
+	sendMail = func(addr string, a smtp.Auth, from string, t []string, m []byte) error {
+		to = append(to, t)
+		return sendErr
+	}
+
+	ts.T().Run("Not due", func(t *testing.T) {
+		assert := require.New(t)
+
+		email = emailConfig{server: "localhost:25", from: "reports@example.com"}
+		assert.NoError(Run(ctx, time.Now()))
+		assert.Len(to, 0)
+	})
+
+	ts.T().Run("Not configured", func(t *testing.T) {
+		assert := require.New(t)
+
+		email = emailConfig{}
+		now := ts.Subscription.NextRunAt
+		assert.NoError(Run(ctx, now))
+		assert.Len(to, 0)
+
+		s, err := storage.GetReportSubscription(ctx, storage.DB(), ts.Subscription.ID)
+		assert.NoError(err)
+		assert.Equal("e-mail is not configured", s.LastError)
+		assert.True(now.Equal(*s.LastRunAt))
+		assert.True(s.NextRun(now).Equal(s.NextRunAt))
+		ts.Subscription = s
+	})
+
+	ts.T().Run("Send", func(t *testing.T) {
+		assert := require.New(t)
+
+		email = emailConfig{server: "localhost:25", from: "reports@example.com"}
+		now := ts.Subscription.NextRunAt
+		assert.NoError(Run(ctx, now))
+		assert.Equal([][]string{{"farmer@example.com"}}, to)
+
+		s, err := storage.GetReportSubscription(ctx, storage.DB(), ts.Subscription.ID)
+		assert.NoError(err)
+		assert.Equal("", s.LastError)
+		assert.True(now.Equal(*s.LastRunAt))
+		ts.Subscription = s
+	})
+
+	ts.T().Run("Send error", func(t *testing.T) {
+		assert := require.New(t)
+
+		to = nil
+		sendErr = errors.New("connection refused")
+		now := ts.Subscription.NextRunAt
+		assert.NoError(Run(ctx, now))
+		assert.Len(to, 1)
+
+		s, err := storage.GetReportSubscription(ctx, storage.DB(), ts.Subscription.ID)
+		assert.NoError(err)
+		assert.Equal("send mail error: connection refused", s.LastError)
+	})
+}
+
+func TestReport(t *testing.T) {
+	suite.Run(t, new(ReportTestSuite))
+}
@@ -0,0 +1,149 @@
+// Package retention enforces the per application data-retention policies.
+package retention
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/integration"
+	"github.com/ibrahimozekici/app-server2/internal/leader"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+var (
+	cleanupInterval = time.Hour
+
+	// retentionHandlers returns the integrations storing events and
+	// locations, this can be overwritten for testing.
+	retentionHandlers = integration.RetentionHandlers
+)
+
+// Setup configures the package and starts the cleanup loop.
+func Setup(conf config.Config) error {
+	if conf.ApplicationServer.Retention.CleanupInterval > 0 {
+		cleanupInterval = conf.ApplicationServer.Retention.CleanupInterval
+	}
+
+	go cleanupLoop()
+
+	return nil
+}
+
+func cleanupLoop() {
+	for {
+		ctxID, err := uuid.NewV4()
+		if err != nil {
+			log.WithError(err).Error("new uuid error")
+		}
+
+		ctx := context.Background()
+		ctx = context.WithValue(ctx, logging.ContextIDKey, ctxID)
+
+		err = leader.Do(ctx, "retention", func(ctx context.Context) error {
+			return Cleanup(ctx, storage.DB(), time.Now())
+		})
+		if err != nil {
+			log.WithError(err).Error("retention: cleanup error")
+		}
+		time.Sleep(cleanupInterval)
+	}
+}
+
+// Cleanup removes for every application with a retention policy the data
+// which is older than the configured retention.
+func Cleanup(ctx context.Context, db sqlx.Ext, now time.Time) error {
+	items, err := storage.GetApplicationRetentions(ctx, db)
+	if err != nil {
+		return errors.Wrap(err, "get application retentions error")
+	}
+
+	for _, item := range items {
+		if err := cleanupApplication(ctx, db, item, now); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"application_id": item.ApplicationID,
+				"ctx_id":         ctx.Value(logging.ContextIDKey),
+			}).Error("retention: cleanup application error")
+		}
+	}
+
+	return nil
+}
+
+func cleanupApplication(ctx context.Context, db sqlx.Ext, item storage.ApplicationRetention, now time.Time) error {
+	fields := log.Fields{
+		"application_id": item.ApplicationID,
+		"ctx_id":         ctx.Value(logging.ContextIDKey),
+	}
+
+	if item.FramesDays > 0 || item.MetricsDays > 0 {
+		app, err := storage.GetApplication(ctx, db, item.ApplicationID)
+		if err != nil {
+			return errors.Wrap(err, "get application error")
+		}
+
+		// the frame-logs and metrics might be stored in the organization schema
+		err = storage.ForOrganization(ctx, db, app.OrganizationID, func(db sqlx.Ext) error {
+			if item.FramesDays > 0 {
+				count, err := storage.DeleteDeviceFrameLogsBefore(ctx, db, item.ApplicationID, before(now, item.FramesDays))
+				if err != nil {
+					return errors.Wrap(err, "delete device frame-logs error")
+				}
+				fields["frames"] = count
+			}
+
+			if item.MetricsDays > 0 {
+				count, err := storage.DeleteDeviceMetricsBefore(ctx, db, item.ApplicationID, before(now, item.MetricsDays))
+				if err != nil {
+					return errors.Wrap(err, "delete device metrics error")
+				}
+				fields["metrics"] = count
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var events, locations int64
+	for _, h := range retentionHandlers() {
+		if item.EventsDays > 0 {
+			count, err := h.DeleteApplicationEvents(ctx, item.ApplicationID, before(now, item.EventsDays))
+			if err != nil {
+				return errors.Wrap(err, "delete application events error")
+			}
+			events += count
+		}
+
+		if item.LocationsDays > 0 {
+			count, err := h.DeleteApplicationLocations(ctx, item.ApplicationID, before(now, item.LocationsDays))
+			if err != nil {
+				return errors.Wrap(err, "delete application locations error")
+			}
+			locations += count
+		}
+	}
+	if item.EventsDays > 0 {
+		fields["events"] = events
+	}
+	if item.LocationsDays > 0 {
+		fields["locations"] = locations
+	}
+
+	log.WithFields(fields).Info("retention: application data cleaned up")
+
+	return nil
+}
+
+// before returns the timestamp before which the data must be removed.
+func before(now time.Time, days int) time.Time {
+	return now.AddDate(0, 0, -days)
+}
@@ -0,0 +1,158 @@
+package retention
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	nsmock "github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/integration/models"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+	"github.com/ibrahimozekici/app-server2/internal/test"
+	//"github.com/brocaar/lorawan"
+)
+
+type deleteRequest struct {
+	ApplicationID int64
+	Before        time.Time
+}
+
+type testRetentionHandler struct {
+	events    []deleteRequest
+	locations []deleteRequest
+}
+
+func (h *testRetentionHandler) DeleteApplicationEvents(ctx context.Context, applicationID int64, before time.Time) (int64, error) {
+	h.events = append(h.events, deleteRequest{applicationID, before})
+	return 1, nil
+}
+
+func (h *testRetentionHandler) DeleteApplicationLocations(ctx context.Context, applicationID int64, before time.Time) (int64, error) {
+	h.locations = append(h.locations, deleteRequest{applicationID, before})
+	return 1, nil
+}
+
+type RetentionTestSuite struct {
+	suite.Suite
+
+	tx *storage.TxLogger
+
+	Application storage.Application
+}
+
+func (ts *RetentionTestSuite) SetupSuite() {
+	assert := require.New(ts.T())
+	conf := test.GetConfig()
+	assert.NoError(storage.Setup(conf))
+	test.MustResetDB(storage.DB().DB)
+}
+
+func (ts *RetentionTestSuite) TearDownTest() {
+	ts.tx.Rollback()
+}
+
+func (ts *RetentionTestSuite) SetupTest() {
+	assert := require.New(ts.T())
+	var err error
+	ts.tx, err = storage.DB().Beginx()
+	assert.NoError(err)
+
+	networkserver.SetPool(nsmock.NewPool(nsmock.NewClient()))
+
+	n := storage.NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), ts.tx, &n))
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), ts.tx, &org))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), ts.tx, &sp))
+	var spID uuid.UUID
+	copy(spID[:], sp.ServiceProfile.Id)
+
+	ts.Application = storage.Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), ts.tx, &ts.Application))
+}
+
+func (ts *RetentionTestSuite) TestCleanup() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	assert.NoError(storage.MaintainPartitions(ctx, ts.tx, now.AddDate(0, 0, -3)))
+
+	devEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	for _, d := range []int{0, 2} {
+		assert.NoError(storage.CreateDeviceFrameLog(ctx, ts.tx, storage.DeviceFrameLog{
+			DevEUI:        devEUI,
+			ApplicationID: ts.Application.ID,
+			ReceivedAt:    now.AddDate(0, 0, -d),
+		}))
+		assert.NoError(storage.CreateDeviceMetrics(ctx, ts.tx, []storage.DeviceMetric{
+			{
+				DevEUI:        devEUI,
+				ApplicationID: ts.Application.ID,
+				Time:          now.AddDate(0, 0, -d),
+				Name:          "temperature",
+				Value:         21.5,
+			},
+		}))
+	}
+
+	h := testRetentionHandler{}
+	retentionHandlers = func() []models.RetentionHandler {
+		return []models.RetentionHandler{&h}
+	}
+
+	assert.NoError(storage.UpdateApplicationRetention(ctx, ts.tx, &storage.ApplicationRetention{
+		ApplicationID: ts.Application.ID,
+		FramesDays:    1,
+		EventsDays:    7,
+	}))
+
+	assert.NoError(Cleanup(ctx, ts.tx, now))
+
+	ts.T().Run("Frames removed", func(t *testing.T) {
+		assert := require.New(t)
+		frames, err := storage.GetDeviceFrameLogs(ctx, ts.tx, devEUI, now.AddDate(0, 0, -3), now.Add(time.Second), 10)
+		assert.NoError(err)
+		assert.Len(frames, 1)
+	})
+
+	ts.T().Run("Metrics kept", func(t *testing.T) {
+		assert := require.New(t)
+		metrics, err := storage.GetDeviceMetrics(ctx, ts.tx, devEUI, "temperature", now.AddDate(0, 0, -3), now.Add(time.Second))
+		assert.NoError(err)
+		assert.Len(metrics, 2)
+	})
+
+	ts.T().Run("Integration events", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Equal([]deleteRequest{
+			{ts.Application.ID, now.AddDate(0, 0, -7)},
+		}, h.events)
+		assert.Len(h.locations, 0)
+	})
+}
+
+func TestRetention(t *testing.T) {
+	suite.Run(t, new(RetentionTestSuite))
+}
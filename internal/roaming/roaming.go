@@ -0,0 +1,128 @@
+// Package roaming extracts the passive-roaming meta-data, as set by the
+// network-server, from the uplink rx-info.
+package roaming
+
+import (
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protowire"
+	//"github.com/ibrahimozekici/lora-api/go/v3/gw"
+)
+
+// metadataFieldNumber is the field number of the meta-data map of the
+// UplinkRXInfo message. This field is set by network-server versions
+// supporting passive-roaming, but is not part of the UplinkRXInfo message
+// compiled into the application-server. Therefore it is decoded from the
+// unknown fields.
+const metadataFieldNumber = 18
+
+// Meta-data keys set by the network-server for uplinks received through a
+// roaming partner.
+const (
+	NetIDKey = "roaming_net_id"
+)
+
+// Info contains the roaming information of an uplink rx-info.
+type Info struct {
+	// NetID of the roaming partner (hex encoded).
+	NetID string `json:"netID"`
+}
+
+// GetInfo returns the roaming information of the given rx-info. It returns
+// nil when the uplink was not received through a roaming partner.
+func GetInfo(rxInfo *gw.UplinkRXInfo) *Info {
+	if rxInfo == nil {
+		return nil
+	}
+
+	md := GetMetadata(rxInfo)
+	if md[NetIDKey] == "" {
+		return nil
+	}
+
+	return &Info{
+		NetID: md[NetIDKey],
+	}
+}
+
+// GetMetadata returns the meta-data of the given rx-info.
+func GetMetadata(rxInfo *gw.UplinkRXInfo) map[string]string {
+	return parseMetadata(proto.MessageV2(rxInfo).ProtoReflect().GetUnknown())
+}
+
+// parseMetadata parses the meta-data map from the given unknown fields.
+// Invalid data is ignored.
+func parseMetadata(b []byte) map[string]string {
+	var out map[string]string
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return out
+		}
+		b = b[n:]
+
+		if num != metadataFieldNumber || typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return out
+			}
+			b = b[n:]
+			continue
+		}
+
+		entry, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return out
+		}
+		b = b[n:]
+
+		k, v, ok := parseMapEntry(entry)
+		if !ok {
+			continue
+		}
+
+		if out == nil {
+			out = make(map[string]string)
+		}
+		out[k] = v
+	}
+
+	return out
+}
+
+// parseMapEntry parses a map<string, string> entry.
+func parseMapEntry(b []byte) (string, string, bool) {
+	var k, v string
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return "", "", false
+		}
+		b = b[n:]
+
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return "", "", false
+			}
+			b = b[n:]
+			continue
+		}
+
+		s, n := protowire.ConsumeString(b)
+		if n < 0 {
+			return "", "", false
+		}
+		b = b[n:]
+
+		switch num {
+		case 1:
+			k = s
+		case 2:
+			v = s
+		}
+	}
+
+	return k, v, true
+}
@@ -0,0 +1,88 @@
+package roaming
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	//"github.com/ibrahimozekici/lora-api/go/v3/gw"
+)
+
+func metadataEntry(k, v string) []byte {
+	var entry []byte
+	entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+	entry = protowire.AppendString(entry, k)
+	entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+	entry = protowire.AppendString(entry, v)
+
+	var b []byte
+	b = protowire.AppendTag(b, metadataFieldNumber, protowire.BytesType)
+	return protowire.AppendBytes(b, entry)
+}
+
+func TestParseMetadata(t *testing.T) {
+	// other unknown field
+	var other []byte
+	other = protowire.AppendTag(other, 19, protowire.VarintType)
+	other = protowire.AppendVarint(other, 10)
+
+	tests := []struct {
+		Name     string
+		Data     []byte
+		Expected map[string]string
+	}{
+		{
+			Name: "no unknown fields",
+		},
+		{
+			Name: "other unknown field",
+			Data: other,
+		},
+		{
+			Name: "meta-data",
+			Data: append(append(metadataEntry(NetIDKey, "000001"), other...), metadataEntry("region", "eu868")...),
+			Expected: map[string]string{
+				NetIDKey: "000001",
+				"region": "eu868",
+			},
+		},
+		{
+			Name: "truncated",
+			Data: metadataEntry(NetIDKey, "000001")[:5],
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			require.Equal(t, tst.Expected, parseMetadata(tst.Data))
+		})
+	}
+}
+
+func TestGetInfo(t *testing.T) {
+	assert := require.New(t)
+
+	b, err := proto.Marshal(&gw.UplinkRXInfo{
+		GatewayId: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+		Rssi:      -60,
+	})
+	assert.NoError(err)
+
+	var rxInfo gw.UplinkRXInfo
+	assert.NoError(proto.Unmarshal(b, &rxInfo))
+	assert.Nil(GetInfo(&rxInfo))
+	assert.Nil(GetInfo(nil))
+
+	b = append(b, metadataEntry(NetIDKey, "000001")...)
+	assert.NoError(proto.Unmarshal(b, &rxInfo))
+	assert.Equal(&Info{NetID: "000001"}, GetInfo(&rxInfo))
+
+	// the meta-data is retained when forwarding the rx-info
+	b, err = proto.Marshal(&rxInfo)
+	assert.NoError(err)
+
+	var forwarded gw.UplinkRXInfo
+	assert.NoError(proto.Unmarshal(b, &forwarded))
+	assert.Equal(&Info{NetID: "000001"}, GetInfo(&forwarded))
+}
@@ -0,0 +1,79 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/pkg/errors"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+type awsSecretsManagerProvider struct {
+	client *secretsmanager.SecretsManager
+}
+
+func newAWSSecretsManagerProvider(conf config.Config) (provider, error) {
+	c := conf.Secrets.AWS
+
+	awsConf := aws.Config{
+		Region: aws.String(c.Region),
+	}
+	if c.Endpoint != "" {
+		awsConf.Endpoint = aws.String(c.Endpoint)
+	}
+	if c.AccessKeyID != "" || c.SecretAccessKey != "" {
+		awsConf.Credentials = credentials.NewStaticCredentials(c.AccessKeyID, c.SecretAccessKey, "")
+	}
+
+	sess, err := session.NewSession(&awsConf)
+	if err != nil {
+		return nil, errors.Wrap(err, "new session error")
+	}
+
+	return &awsSecretsManagerProvider{
+		client: secretsmanager.New(sess),
+	}, nil
+}
+
+func (a *awsSecretsManagerProvider) Get(ctx context.Context, path, key string) (string, error) {
+	out, err := a.client.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(path),
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "get secret value error")
+	}
+
+	var s string
+	if out.SecretString != nil {
+		s = *out.SecretString
+	} else {
+		s = string(out.SecretBinary)
+	}
+
+	if key == "" {
+		return s, nil
+	}
+
+	// When a key is given, the secret is expected to be a JSON object
+	// (e.g. created using the key/value editor of the AWS console).
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &data); err != nil {
+		return "", errors.Wrap(err, "decode json secret error")
+	}
+
+	val, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("key %s does not exist in secret %s", key, path)
+	}
+
+	if s, ok := val.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprintf("%v", val), nil
+}
@@ -0,0 +1,274 @@
+// Package secrets implements the resolution of configuration values which
+// reference a secret stored in an external secret store, such that
+// credentials do not need to be stored in plaintext in the configuration file.
+//
+// A reference has one of the following formats:
+//
+//	vault:<path>#<key>       HashiCorp Vault (KV v1 or v2 secrets engine)
+//	aws-sm:<secret-id>[#key] AWS Secrets Manager (key of a JSON secret)
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+// Reference prefixes.
+const (
+	PrefixVault             = "vault:"
+	PrefixAWSSecretsManager = "aws-sm:"
+)
+
+// resolveTimeout defines the timeout for resolving all references.
+const resolveTimeout = 30 * time.Second
+
+// provider defines the interface of a secret store.
+type provider interface {
+	// Get returns the value of the given key of the secret stored under the
+	// given path. When key is empty, the complete secret is returned.
+	Get(ctx context.Context, path, key string) (string, error)
+}
+
+// reference defines a resolved reference.
+type reference struct {
+	// field is the configuration field, e.g. postgresql.dsn.
+	field string
+	ref   string
+	value string
+}
+
+var (
+	mux        sync.Mutex
+	providers  = make(map[string]provider)
+	references []reference
+	handlers   = make(map[string]func(string) error)
+)
+
+// Resolve replaces the secret references in the given configuration by the
+// value of the referenced secret.
+func Resolve(c *config.Config) error {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+
+	mux.Lock()
+	defer mux.Unlock()
+
+	references = nil
+
+	return walk(reflect.ValueOf(c).Elem(), "", func(field, s string, set func(string)) error {
+		prefix, path, key, ok := parseReference(s)
+		if !ok {
+			return nil
+		}
+
+		p, err := getProvider(*c, prefix)
+		if err != nil {
+			return errors.Wrapf(err, "%s: setup provider error", field)
+		}
+
+		v, err := p.Get(ctx, path, key)
+		if err != nil {
+			return errors.Wrapf(err, "%s: resolve secret error", field)
+		}
+
+		set(v)
+		references = append(references, reference{
+			field: field,
+			ref:   s,
+			value: v,
+		})
+
+		log.WithField("field", field).Info("secrets: resolved configuration secret")
+		return nil
+	})
+}
+
+// OnRotate registers the function which is called with the new value when
+// the secret referenced by the given configuration field has been rotated.
+// Rotated secrets of fields without handler are applied after a restart.
+func OnRotate(field string, f func(value string) error) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	handlers[field] = f
+}
+
+// Setup starts the refresh loop, which periodically re-resolves the secret
+// references to detect rotated secrets.
+func Setup(conf config.Config) error {
+	mux.Lock()
+	n := len(references)
+	mux.Unlock()
+
+	if conf.Secrets.RefreshInterval == 0 || n == 0 {
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"references": n,
+		"interval":   conf.Secrets.RefreshInterval,
+	}).Info("secrets: starting secrets refresh loop")
+
+	go func() {
+		for {
+			time.Sleep(conf.Secrets.RefreshInterval)
+			refresh()
+		}
+	}()
+
+	return nil
+}
+
+// refresh re-resolves the references and calls the rotation handler for
+// each rotated secret.
+func refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+
+	mux.Lock()
+	defer mux.Unlock()
+
+	for i := range references {
+		r := &references[i]
+
+		prefix, path, key, _ := parseReference(r.ref)
+		v, err := providers[prefix].Get(ctx, path, key)
+		if err != nil {
+			log.WithError(err).WithField("field", r.field).Error("secrets: refresh secret error")
+			continue
+		}
+
+		if v == r.value {
+			continue
+		}
+
+		f, ok := handlers[r.field]
+		if !ok {
+			log.WithField("field", r.field).Warning("secrets: secret has been rotated, restart to apply")
+			r.value = v
+			continue
+		}
+
+		if err := f(v); err != nil {
+			// the value is not updated, such that this is retried on the
+			// next refresh
+			log.WithError(err).WithField("field", r.field).Error("secrets: apply rotated secret error")
+			continue
+		}
+
+		r.value = v
+		log.WithField("field", r.field).Info("secrets: rotated secret applied")
+	}
+}
+
+// parseReference parses the given value as secret reference. It returns
+// false when the value is not a secret reference.
+func parseReference(s string) (string, string, string, bool) {
+	for _, prefix := range []string{PrefixVault, PrefixAWSSecretsManager} {
+		if !strings.HasPrefix(s, prefix) {
+			continue
+		}
+
+		path, key := strings.TrimPrefix(s, prefix), ""
+		if i := strings.LastIndex(path, "#"); i != -1 {
+			path, key = path[:i], path[i+1:]
+		}
+
+		// A key is required for Vault references, this also avoids
+		// that Vault Transit ciphertexts (vault:v1:...) are interpreted
+		// as reference.
+		if path == "" || (prefix == PrefixVault && key == "") {
+			return "", "", "", false
+		}
+
+		return prefix, path, key, true
+	}
+
+	return "", "", "", false
+}
+
+// getProvider returns the provider for the given prefix. It must be called
+// with the mux locked.
+func getProvider(c config.Config, prefix string) (provider, error) {
+	if p, ok := providers[prefix]; ok {
+		return p, nil
+	}
+
+	var p provider
+	var err error
+
+	switch prefix {
+	case PrefixVault:
+		p, err = newVaultProvider(c)
+	case PrefixAWSSecretsManager:
+		p, err = newAWSSecretsManagerProvider(c)
+	default:
+		return nil, fmt.Errorf("unknown secret reference prefix: %s", prefix)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	providers[prefix] = p
+	return p, nil
+}
+
+// walk calls f for each string (also within slices and maps) of the given
+// value. The field name is based on the mapstructure tags.
+func walk(v reflect.Value, field string, f func(field, s string, set func(string)) error) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				continue
+			}
+
+			name, ok := t.Field(i).Tag.Lookup("mapstructure")
+			if !ok {
+				name = strings.ToLower(t.Field(i).Name)
+			}
+			if name == "-" {
+				continue
+			}
+			if field != "" {
+				name = field + "." + name
+			}
+
+			if err := walk(v.Field(i), name, f); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := walk(v.Index(i), fmt.Sprintf("%s[%d]", field, i), f); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		for _, k := range v.MapKeys() {
+			k := k
+			if err := f(fmt.Sprintf("%s.%v", field, k), v.MapIndex(k).String(), func(s string) {
+				v.SetMapIndex(k, reflect.ValueOf(s).Convert(v.Type().Elem()))
+			}); err != nil {
+				return err
+			}
+		}
+	case reflect.String:
+		return f(field, v.String(), v.SetString)
+	}
+
+	return nil
+}
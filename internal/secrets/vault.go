@@ -0,0 +1,114 @@
+package secrets
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+type vaultResponse struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []string               `json:"errors"`
+}
+
+type vaultProvider struct {
+	address string
+	token   string
+	client  *http.Client
+}
+
+// newVaultProvider creates a new Vault provider. When not configured, the
+// address and token are read from the VAULT_ADDR and VAULT_TOKEN
+// environment variables.
+func newVaultProvider(conf config.Config) (provider, error) {
+	c := conf.Secrets.Vault
+	if c.Address == "" {
+		c.Address = os.Getenv("VAULT_ADDR")
+	}
+	if c.Token == "" {
+		c.Token = os.Getenv("VAULT_TOKEN")
+	}
+	if c.Address == "" {
+		return nil, errors.New("secrets.vault.address must be set")
+	}
+
+	v := vaultProvider{
+		address: strings.TrimRight(c.Address, "/"),
+		token:   c.Token,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+
+	if c.CACert != "" {
+		rawCACert, err := ioutil.ReadFile(c.CACert)
+		if err != nil {
+			return nil, errors.Wrap(err, "read ca cert error")
+		}
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(rawCACert) {
+			return nil, errors.New("append ca cert to pool error")
+		}
+		v.client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: caCertPool,
+			},
+		}
+	}
+
+	return &v, nil
+}
+
+func (v *vaultProvider) Get(ctx context.Context, path, key string) (string, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/v1/%s", v.address, strings.Trim(path, "/")), nil)
+	if err != nil {
+		return "", errors.Wrap(err, "new request error")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Vault-Token", v.token)
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "http request error")
+	}
+	defer resp.Body.Close()
+
+	var out vaultResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", errors.Wrapf(err, "decode response error (status: %d)", resp.StatusCode)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned status %d: %s", resp.StatusCode, strings.Join(out.Errors, ", "))
+	}
+
+	data := out.Data
+	// The KV v2 secrets engine returns the secret under data.data, next to
+	// the metadata.
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner
+		}
+	}
+
+	val, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("key %s does not exist in secret %s", key, path)
+	}
+
+	if s, ok := val.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprintf("%v", val), nil
+}
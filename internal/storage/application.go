@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	uuid "github.com/gofrs/uuid"
 	"github.com/jmoiron/sqlx"
@@ -20,6 +21,8 @@ var applicationNameRegexp = regexp.MustCompile(`^[\w-]+$`)
 // Application represents an application.
 type Application struct {
 	ID                   int64      `db:"id"`
+	CreatedAt            time.Time  `db:"created_at"`
+	UpdatedAt            time.Time  `db:"updated_at"`
 	Name                 string     `db:"name"`
 	Description          string     `db:"description"`
 	OrganizationID       int64      `db:"organization_id"`
@@ -50,8 +53,14 @@ func CreateApplication(ctx context.Context, db sqlx.Queryer, item *Application)
 		return errors.Wrap(err, "validate error")
 	}
 
+	now := time.Now()
+	item.CreatedAt = now
+	item.UpdatedAt = now
+
 	err := sqlx.Get(db, &item.ID, `
 		insert into application (
+			created_at,
+			updated_at,
 			name,
 			description,
 			organization_id,
@@ -59,7 +68,9 @@ func CreateApplication(ctx context.Context, db sqlx.Queryer, item *Application)
 			payload_codec,
 			payload_encoder_script,
 			payload_decoder_script
-		) values ($1, $2, $3, $4, $5, $6, $7) returning id`,
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`,
+		item.CreatedAt,
+		item.UpdatedAt,
 		item.Name,
 		item.Description,
 		item.OrganizationID,
@@ -83,6 +94,8 @@ func CreateApplication(ctx context.Context, db sqlx.Queryer, item *Application)
 
 // GetApplication returns the Application for the given id.
 func GetApplication(ctx context.Context, db sqlx.Queryer, id int64) (Application, error) {
+	defer observeQueryDuration("application_get", time.Now())
+
 	var app Application
 	err := sqlx.Get(db, &app, "select * from application where id = $1", id)
 	if err != nil {
@@ -92,6 +105,18 @@ func GetApplication(ctx context.Context, db sqlx.Queryer, id int64) (Application
 	return app, nil
 }
 
+// GetApplicationForUpdate returns the Application for the given id and
+// locks it for update until the end of the transaction.
+func GetApplicationForUpdate(ctx context.Context, db sqlx.Queryer, id int64) (Application, error) {
+	var app Application
+	err := sqlx.Get(db, &app, "select * from application where id = $1 for update", id)
+	if err != nil {
+		return app, handlePSQLError(Select, err, "select error")
+	}
+
+	return app, nil
+}
+
 // ApplicationFilters provides filters for filtering applications.
 type ApplicationFilters struct {
 	UserID         int64  `db:"user_id"`
@@ -198,23 +223,27 @@ func GetApplications(ctx context.Context, db sqlx.Queryer, filters ApplicationFi
 }
 
 // UpdateApplication updates the given Application.
-func UpdateApplication(ctx context.Context, db sqlx.Execer, item Application) error {
+func UpdateApplication(ctx context.Context, db sqlx.Execer, item *Application) error {
 	if err := item.Validate(); err != nil {
 		return fmt.Errorf("validate application error: %s", err)
 	}
 
+	item.UpdatedAt = time.Now()
+
 	res, err := db.Exec(`
 		update application
 		set
-			name = $2,
-			description = $3,
-			organization_id = $4,
-			service_profile_id = $5,
-			payload_codec = $6,
-			payload_encoder_script = $7,
-			payload_decoder_script = $8
+			updated_at = $2,
+			name = $3,
+			description = $4,
+			organization_id = $5,
+			service_profile_id = $6,
+			payload_codec = $7,
+			payload_encoder_script = $8,
+			payload_decoder_script = $9
 		where id = $1`,
 		item.ID,
+		item.UpdatedAt,
 		item.Name,
 		item.Description,
 		item.OrganizationID,
@@ -234,6 +263,8 @@ func UpdateApplication(ctx context.Context, db sqlx.Execer, item Application) er
 		return ErrDoesNotExist
 	}
 
+	invalidateApplicationCache(ctx, item.ID)
+
 	log.WithFields(log.Fields{
 		"id":     item.ID,
 		"name":   item.Name,
@@ -262,6 +293,8 @@ func DeleteApplication(ctx context.Context, db sqlx.Ext, id int64) error {
 		return ErrDoesNotExist
 	}
 
+	invalidateApplicationCache(ctx, id)
+
 	log.WithFields(log.Fields{
 		"id":     id,
 		"ctx_id": ctx.Value(logging.ContextIDKey),
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Application metrics, stored as aggregated metrics (see SaveMetrics) under
+// the ApplicationMetricsName of the application.
+const (
+	ApplicationMetricRXCount          = "rx_count"
+	ApplicationMetricJoinRequestCount = "join_request_count"
+	ApplicationMetricJoinAcceptCount  = "join_accept_count"
+
+	ApplicationMetricIntegrationEventCount      = "integration_event_count"
+	ApplicationMetricIntegrationEventErrorCount = "integration_event_error_count"
+)
+
+// GatewayRSSIDistribution defines the RSSI distribution of the frames
+// received by a gateway.
+type GatewayRSSIDistribution struct {
+	GatewayID string  `db:"gateway_id"`
+	Count     int64   `db:"count"`
+	Min       int     `db:"min"`
+	P10       float64 `db:"p10"`
+	P50       float64 `db:"p50"`
+	P90       float64 `db:"p90"`
+	Max       int     `db:"max"`
+	Avg       float64 `db:"avg"`
+}
+
+// ApplicationMetricsName returns the name under which the aggregated
+// metrics of the given application are stored.
+func ApplicationMetricsName(applicationID int64) string {
+	return fmt.Sprintf("app:%d", applicationID)
+}
+
+// GetGatewayRSSIDistribution returns the per gateway RSSI distribution of
+// the frames of the given application, received within the given time range.
+// This is based on the RX metadata stored in the device frame-log.
+func GetGatewayRSSIDistribution(ctx context.Context, db sqlx.Queryer, applicationID int64, start, end time.Time) ([]GatewayRSSIDistribution, error) {
+	defer observeQueryDuration("gateway_rssi_distribution_get", time.Now())
+
+	var out []GatewayRSSIDistribution
+	err := sqlx.Select(db, &out, `
+		select
+			rx.gateway_id,
+			count(*) as count,
+			min(rx.rssi) as min,
+			percentile_cont(0.1) within group (order by rx.rssi) as p10,
+			percentile_cont(0.5) within group (order by rx.rssi) as p50,
+			percentile_cont(0.9) within group (order by rx.rssi) as p90,
+			max(rx.rssi) as max,
+			avg(rx.rssi) as avg
+		from
+			device_frame_log fl
+		cross join lateral (
+			select
+				e->>'gatewayID' as gateway_id,
+				(e->>'rssi')::integer as rssi
+			from
+				jsonb_array_elements(case when jsonb_typeof(fl.rx_info) = 'array' then fl.rx_info else '[]'::jsonb end) e
+		) rx
+		where
+			fl.application_id = $1
+			and fl.received_at >= $2
+			and fl.received_at < $3
+		group by
+			rx.gateway_id
+		order by
+			rx.gateway_id`,
+		applicationID,
+		start,
+		end,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// ApplicationUplinkExpectation defines the number of expected uplinks of
+// the devices of an application.
+type ApplicationUplinkExpectation struct {
+	Devices         int64 `db:"devices"`
+	ExpectedUplinks int64 `db:"expected_uplinks"`
+}
+
+// GetApplicationUplinkExpectation returns the number of uplinks expected
+// from the devices of the given application within the given time range,
+// based on the uplink interval of the device-profile. For devices created
+// within the time range, the expectation starts at the creation time.
+func GetApplicationUplinkExpectation(ctx context.Context, db sqlx.Queryer, applicationID int64, start, end time.Time) (ApplicationUplinkExpectation, error) {
+	defer observeQueryDuration("application_uplink_expectation_get", time.Now())
+
+	var out ApplicationUplinkExpectation
+	err := sqlx.Get(db, &out, `
+		select
+			count(*) as devices,
+			coalesce(sum(floor(
+				extract(epoch from ($3::timestamptz - greatest($2::timestamptz, d.created_at)))
+				/ (dp.uplink_interval / 1000000000.0)
+			)), 0)::bigint as expected_uplinks
+		from
+			device d
+		inner join device_profile dp
+			on dp.device_profile_id = d.device_profile_id
+		where
+			d.application_id = $1
+			and d.created_at < $3
+			and dp.uplink_interval > 0`,
+		applicationID,
+		start,
+		end,
+	)
+	if err != nil {
+		return out, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
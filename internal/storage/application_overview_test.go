@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	// "github.com/ibrahimozekici/lora-api/go/v3/ns"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestGetGatewayRSSIDistribution() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+	now := time.Now()
+
+	gw1 := lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 1}
+	gw2 := lorawan.EUI64{2, 2, 2, 2, 2, 2, 2, 2}
+
+	for i, rxInfo := range [][]DeviceFrameLogRXInfo{
+		{{GatewayID: gw1, RSSI: -100}, {GatewayID: gw2, RSSI: -50}},
+		{{GatewayID: gw1, RSSI: -80}},
+		{{GatewayID: gw1, RSSI: -90}},
+		nil,
+	} {
+		b, err := json.Marshal(rxInfo)
+		assert.NoError(err)
+
+		assert.NoError(CreateDeviceFrameLog(ctx, ts.Tx(), DeviceFrameLog{
+			DevEUI:        lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+			ApplicationID: 1,
+			ReceivedAt:    now,
+			FCnt:          uint32(i),
+			RXInfo:        b,
+		}))
+	}
+
+	dist, err := GetGatewayRSSIDistribution(ctx, ts.Tx(), 1, now.Add(-time.Minute), now.Add(time.Minute))
+	assert.NoError(err)
+	assert.Len(dist, 2)
+
+	assert.Equal(gw1.String(), dist[0].GatewayID)
+	assert.EqualValues(3, dist[0].Count)
+	assert.Equal(-100, dist[0].Min)
+	assert.Equal(-80, dist[0].Max)
+	assert.Equal(-90.0, dist[0].P50)
+	assert.Equal(-90.0, dist[0].Avg)
+
+	assert.Equal(gw2.String(), dist[1].GatewayID)
+	assert.EqualValues(1, dist[1].Count)
+
+	dist, err = GetGatewayRSSIDistribution(ctx, ts.Tx(), 2, now.Add(-time.Minute), now.Add(time.Minute))
+	assert.NoError(err)
+	assert.Len(dist, 0)
+}
+
+func (ts *StorageTestSuite) TestGetApplicationUplinkExpectation() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	org := Organization{Name: "test-org"}
+	assert.NoError(CreateOrganization(ctx, ts.Tx(), &org))
+
+	n := NetworkServer{Name: "test-ns", Server: "test-ns:1234"}
+	assert.NoError(CreateNetworkServer(ctx, ts.Tx(), &n))
+
+	sp := ServiceProfile{OrganizationID: org.ID, NetworkServerID: n.ID, Name: "test-sp"}
+	assert.NoError(CreateServiceProfile(ctx, ts.Tx(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	dp := DeviceProfile{OrganizationID: org.ID, NetworkServerID: n.ID, Name: "test-dp", UplinkInterval: time.Hour}
+	assert.NoError(CreateDeviceProfile(ctx, ts.Tx(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	app := Application{OrganizationID: org.ID, Name: "test-app", ServiceProfileID: spID}
+	assert.NoError(CreateApplication(ctx, ts.Tx(), &app))
+
+	for _, devEUI := range []lorawan.EUI64{{1, 2, 3, 4, 5, 6, 7, 8}, {8, 7, 6, 5, 4, 3, 2, 1}} {
+		assert.NoError(CreateDevice(ctx, ts.Tx(), &Device{
+			DevEUI:          devEUI,
+			ApplicationID:   app.ID,
+			DeviceProfileID: dpID,
+			Name:            devEUI.String(),
+		}))
+	}
+
+	// the devices have been created just now
+	now := time.Now()
+	exp, err := GetApplicationUplinkExpectation(ctx, ts.Tx(), app.ID, now.Add(-24*time.Hour), now.Add(10*time.Hour+time.Minute))
+	assert.NoError(err)
+	assert.Equal(ApplicationUplinkExpectation{Devices: 2, ExpectedUplinks: 20}, exp)
+
+	exp, err = GetApplicationUplinkExpectation(ctx, ts.Tx(), app.ID, now.Add(-24*time.Hour), now.Add(-time.Hour))
+	assert.NoError(err)
+	assert.Equal(ApplicationUplinkExpectation{}, exp)
+}
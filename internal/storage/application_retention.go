@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+)
+
+// ApplicationRetention defines the data-retention policy of an application.
+// The values are in days, 0 means that the data is kept (or is only removed
+// by the global partition retention).
+type ApplicationRetention struct {
+	ApplicationID int64     `db:"application_id"`
+	CreatedAt     time.Time `db:"created_at"`
+	UpdatedAt     time.Time `db:"updated_at"`
+	EventsDays    int       `db:"events_days"`
+	MetricsDays   int       `db:"metrics_days"`
+	FramesDays    int       `db:"frames_days"`
+	LocationsDays int       `db:"locations_days"`
+}
+
+// Validate validates the application retention data.
+func (r ApplicationRetention) Validate() error {
+	for _, d := range []int{r.EventsDays, r.MetricsDays, r.FramesDays, r.LocationsDays} {
+		if d < 0 {
+			return ErrApplicationRetentionInvalidDays
+		}
+	}
+	return nil
+}
+
+// UpdateApplicationRetention creates or updates the retention policy of the
+// given application.
+func UpdateApplicationRetention(ctx context.Context, db sqlx.Execer, r *ApplicationRetention) error {
+	if err := r.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	now := time.Now()
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = now
+	}
+	r.UpdatedAt = now
+
+	_, err := db.Exec(`
+		insert into application_retention (
+			application_id,
+			created_at,
+			updated_at,
+			events_days,
+			metrics_days,
+			frames_days,
+			locations_days
+		) values ($1, $2, $3, $4, $5, $6, $7)
+		on conflict (application_id) do update
+		set
+			updated_at = excluded.updated_at,
+			events_days = excluded.events_days,
+			metrics_days = excluded.metrics_days,
+			frames_days = excluded.frames_days,
+			locations_days = excluded.locations_days`,
+		r.ApplicationID,
+		r.CreatedAt,
+		r.UpdatedAt,
+		r.EventsDays,
+		r.MetricsDays,
+		r.FramesDays,
+		r.LocationsDays,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+
+	log.WithFields(log.Fields{
+		"application_id": r.ApplicationID,
+		"ctx_id":         ctx.Value(logging.ContextIDKey),
+	}).Info("application retention updated")
+
+	return nil
+}
+
+// GetApplicationRetention returns the retention policy of the given
+// application. When no policy has been configured, ErrDoesNotExist is
+// returned.
+func GetApplicationRetention(ctx context.Context, db sqlx.Queryer, applicationID int64) (ApplicationRetention, error) {
+	var r ApplicationRetention
+	err := sqlx.Get(db, &r, `
+		select
+			*
+		from
+			application_retention
+		where
+			application_id = $1`,
+		applicationID,
+	)
+	if err != nil {
+		return r, handlePSQLError(Select, err, "select error")
+	}
+
+	return r, nil
+}
+
+// GetApplicationRetentions returns all the retention policies which have at
+// least one retention value set.
+func GetApplicationRetentions(ctx context.Context, db sqlx.Queryer) ([]ApplicationRetention, error) {
+	var out []ApplicationRetention
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			application_retention
+		where
+			events_days > 0
+			or metrics_days > 0
+			or frames_days > 0
+			or locations_days > 0
+		order by
+			application_id`,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// DeleteApplicationRetention deletes the retention policy of the given
+// application.
+func DeleteApplicationRetention(ctx context.Context, db sqlx.Execer, applicationID int64) error {
+	res, err := db.Exec(`
+		delete from application_retention
+		where
+			application_id = $1`,
+		applicationID,
+	)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"application_id": applicationID,
+		"ctx_id":         ctx.Value(logging.ContextIDKey),
+	}).Info("application retention deleted")
+
+	return nil
+}
+
+// DeleteDeviceFrameLogsBefore deletes the frame-logs of the given application
+// received before the given timestamp. It returns the number of deleted rows.
+func DeleteDeviceFrameLogsBefore(ctx context.Context, db sqlx.Execer, applicationID int64, before time.Time) (int64, error) {
+	res, err := db.Exec(`
+		delete from device_frame_log
+		where
+			application_id = $1
+			and received_at < $2`,
+		applicationID,
+		before,
+	)
+	if err != nil {
+		return 0, handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "get rows affected error")
+	}
+
+	return ra, nil
+}
+
+// DeleteDeviceMetricsBefore deletes the metrics of the given application
+// before the given timestamp. It returns the number of deleted rows.
+func DeleteDeviceMetricsBefore(ctx context.Context, db sqlx.Execer, applicationID int64, before time.Time) (int64, error) {
+	res, err := db.Exec(`
+		delete from device_metric
+		where
+			application_id = $1
+			and time < $2`,
+		applicationID,
+		before,
+	)
+	if err != nil {
+		return 0, handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "get rows affected error")
+	}
+
+	return ra, nil
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	uuid "github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+)
+
+func (ts *StorageTestSuite) TestApplicationRetention() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	n := NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(CreateNetworkServer(ctx, ts.Tx(), &n))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(ctx, ts.Tx(), &org))
+
+	sp := ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateServiceProfile(ctx, ts.Tx(), &sp))
+	var spID uuid.UUID
+	copy(spID[:], sp.ServiceProfile.Id)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(ctx, ts.Tx(), &app))
+
+	ts.T().Run("Get not configured", func(t *testing.T) {
+		assert := require.New(t)
+		_, err := GetApplicationRetention(ctx, ts.Tx(), app.ID)
+		assert.Equal(ErrDoesNotExist, err)
+	})
+
+	ts.T().Run("Invalid", func(t *testing.T) {
+		assert := require.New(t)
+		err := UpdateApplicationRetention(ctx, ts.Tx(), &ApplicationRetention{
+			ApplicationID: app.ID,
+			FramesDays:    -1,
+		})
+		assert.Error(err)
+	})
+
+	ts.T().Run("Update", func(t *testing.T) {
+		assert := require.New(t)
+
+		r := ApplicationRetention{
+			ApplicationID: app.ID,
+			FramesDays:    7,
+		}
+		assert.NoError(UpdateApplicationRetention(ctx, ts.Tx(), &r))
+
+		r.FramesDays = 14
+		r.MetricsDays = 30
+		assert.NoError(UpdateApplicationRetention(ctx, ts.Tx(), &r))
+
+		rGet, err := GetApplicationRetention(ctx, ts.Tx(), app.ID)
+		assert.NoError(err)
+		assert.Equal(14, rGet.FramesDays)
+		assert.Equal(30, rGet.MetricsDays)
+		assert.Equal(0, rGet.EventsDays)
+
+		items, err := GetApplicationRetentions(ctx, ts.Tx())
+		assert.NoError(err)
+		assert.Len(items, 1)
+		assert.Equal(app.ID, items[0].ApplicationID)
+	})
+
+	ts.T().Run("Delete", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(DeleteApplicationRetention(ctx, ts.Tx(), app.ID))
+		assert.Equal(ErrDoesNotExist, DeleteApplicationRetention(ctx, ts.Tx(), app.ID))
+
+		items, err := GetApplicationRetentions(ctx, ts.Tx())
+		assert.NoError(err)
+		assert.Len(items, 0)
+	})
+}
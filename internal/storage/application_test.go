@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/gofrs/uuid"
 	"github.com/pkg/errors"
@@ -94,9 +95,21 @@ func (ts *StorageTestSuite) TestApplication() {
 
 			app2, err := GetApplication(context.Background(), ts.Tx(), app.ID)
 			assert.NoError(err)
+			assert.Equal(app.UpdatedAt.UTC().Truncate(time.Millisecond), app2.UpdatedAt.UTC().Truncate(time.Millisecond))
+
+			app2.CreatedAt = app.CreatedAt
+			app2.UpdatedAt = app.UpdatedAt
 			assert.Equal(app, app2)
 		})
 
+		t.Run("Get for update", func(t *testing.T) {
+			assert := require.New(t)
+
+			app2, err := GetApplicationForUpdate(context.Background(), ts.Tx(), app.ID)
+			assert.NoError(err)
+			assert.Equal(app.ID, app2.ID)
+		})
+
 		t.Run("Get applications", func(t *testing.T) {
 			assert := require.New(t)
 
@@ -134,10 +147,14 @@ func (ts *StorageTestSuite) TestApplication() {
 
 			app.Description = "some new description"
 
-			assert.NoError(UpdateApplication(context.Background(), ts.Tx(), app))
+			assert.NoError(UpdateApplication(context.Background(), ts.Tx(), &app))
 
 			app2, err := GetApplication(context.Background(), ts.Tx(), app.ID)
 			assert.NoError(err)
+			assert.Equal(app.UpdatedAt.UTC().Truncate(time.Millisecond), app2.UpdatedAt.UTC().Truncate(time.Millisecond))
+
+			app2.CreatedAt = app.CreatedAt
+			app2.UpdatedAt = app.UpdatedAt
 			assert.Equal(app, app2)
 		})
 
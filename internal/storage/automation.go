@@ -0,0 +1,611 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq/hstore"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+const (
+	automationCooldownKeyTempl = "lora:as:automation:{%s}:%s"            // (dev_eui | automation_id)
+	automationOfflineKeyTempl  = "lora:as:automation:offline:{%s}:%s:%d" // (dev_eui | automation_id | last_seen_at)
+)
+
+var automationMinCooldown time.Duration
+
+// Automation defines an automation which executes its actions for a device
+// of the application when its trigger fires for that device. When
+// DeviceTags is set, the automation only applies to the devices having all
+// of these tags (with the same value). The UPLINK and ALARM triggers fire at
+// most once per Cooldown for each device. ScheduledAt holds the last time
+// a SCHEDULE trigger fired. When Alarm or Escalation is set, each execution
+// raises an alarm for the device, which is recorded in the alarm history.
+// When Escalation is set, the alarm is escalated until it is acknowledged.
+// Severity is the severity of the raised alarms, which selects the
+// notification routes of the alarms (see NotificationRoute). Dampening
+// prevents notification storms (see spec.Dampening).
+type Automation struct {
+	ID            uuid.UUID       `db:"id"`
+	ApplicationID int64           `db:"application_id"`
+	CreatedAt     time.Time       `db:"created_at"`
+	UpdatedAt     time.Time       `db:"updated_at"`
+	Name          string          `db:"name"`
+	Enabled       bool            `db:"enabled"`
+	DeviceTags    hstore.Hstore   `db:"device_tags"`
+	Trigger       spec.Trigger    `db:"trigger"`
+	Actions       spec.Actions    `db:"actions"`
+	Alarm         bool            `db:"alarm"`
+	Escalation    spec.Escalation `db:"escalation"`
+	Severity      spec.Severity   `db:"severity"`
+	Dampening     spec.Dampening  `db:"dampening"`
+	Cooldown      time.Duration   `db:"cooldown"`
+	ScheduledAt   *time.Time      `db:"scheduled_at"`
+}
+
+// Validate validates the automation data.
+func (a Automation) Validate() error {
+	if strings.TrimSpace(a.Name) == "" || len(a.Name) > 100 {
+		return ErrAutomationInvalidName
+	}
+
+	if err := a.Trigger.Validate(); err != nil {
+		return errors.Wrap(ErrAutomationInvalidTrigger, err.Error())
+	}
+
+	if err := a.Actions.Validate(); err != nil {
+		return errors.Wrap(ErrAutomationInvalidActions, err.Error())
+	}
+
+	if err := a.Escalation.Validate(); err != nil {
+		return errors.Wrap(ErrAutomationInvalidEscalation, err.Error())
+	}
+
+	if err := a.Severity.Validate(); err != nil {
+		return errors.Wrap(ErrAutomationInvalidSeverity, err.Error())
+	}
+
+	if err := a.Dampening.Validate(a.Trigger.Type); err != nil {
+		return errors.Wrap(ErrAutomationInvalidDampening, err.Error())
+	}
+
+	if a.Cooldown < automationMinCooldown {
+		return ErrAutomationInvalidCooldown
+	}
+
+	return nil
+}
+
+// RaisesAlarm returns true when the executions of the automation raise an
+// alarm.
+func (a Automation) RaisesAlarm() bool {
+	return a.Alarm || len(a.Escalation) != 0
+}
+
+// MatchDevice returns true when the given device has all the device tags of
+// the automation.
+func (a Automation) MatchDevice(d Device) bool {
+	for k, v := range a.DeviceTags.Map {
+		dv, ok := d.Tags.Map[k]
+		if !ok || dv.Valid != v.Valid || dv.String != v.String {
+			return false
+		}
+	}
+
+	return true
+}
+
+// AutomationExecution defines an execution of the actions of an automation
+// for a device. Success is true when all actions succeeded.
+type AutomationExecution struct {
+	ID           int64            `db:"id"`
+	AutomationID uuid.UUID        `db:"automation_id"`
+	CreatedAt    time.Time        `db:"created_at"`
+	DevEUI       lorawan.EUI64    `db:"dev_eui"`
+	TriggerType  spec.TriggerType `db:"trigger_type"`
+	Success      bool             `db:"success"`
+	Results      spec.Results     `db:"results"`
+}
+
+// SetAutomationMinCooldown sets the min. cooldown of an automation.
+func SetAutomationMinCooldown(minCooldown time.Duration) {
+	automationMinCooldown = minCooldown
+}
+
+// CreateAutomation creates the given automation. When not set, the
+// severity defaults to WARNING.
+func CreateAutomation(ctx context.Context, db sqlx.Execer, a *Automation) error {
+	if a.Severity == "" {
+		a.Severity = spec.WarningSeverity
+	}
+
+	if err := a.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	var err error
+	a.ID, err = uuid.NewV4()
+	if err != nil {
+		return errors.Wrap(err, "new uuid v4 error")
+	}
+
+	now := time.Now()
+	a.CreatedAt = now
+	a.UpdatedAt = now
+
+	if a.DeviceTags.Map == nil {
+		a.DeviceTags.Map = make(map[string]sql.NullString)
+	}
+
+	_, err = db.Exec(`
+		insert into automation (
+			id,
+			application_id,
+			created_at,
+			updated_at,
+			name,
+			enabled,
+			device_tags,
+			trigger,
+			actions,
+			alarm,
+			escalation,
+			severity,
+			dampening,
+			cooldown,
+			scheduled_at
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+		a.ID,
+		a.ApplicationID,
+		a.CreatedAt,
+		a.UpdatedAt,
+		a.Name,
+		a.Enabled,
+		a.DeviceTags,
+		a.Trigger,
+		a.Actions,
+		a.Alarm,
+		a.Escalation,
+		a.Severity,
+		a.Dampening,
+		a.Cooldown,
+		a.ScheduledAt,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"id":             a.ID,
+		"application_id": a.ApplicationID,
+		"ctx_id":         ctx.Value(logging.ContextIDKey),
+	}).Info("automation created")
+
+	return nil
+}
+
+// GetAutomation returns the automation for the given id.
+func GetAutomation(ctx context.Context, db sqlx.Queryer, id uuid.UUID) (Automation, error) {
+	var a Automation
+	if err := sqlx.Get(db, &a, "select * from automation where id = $1", id); err != nil {
+		return a, handlePSQLError(Select, err, "select error")
+	}
+
+	return a, nil
+}
+
+// GetAutomations returns the automations of the given application, sorted
+// by name.
+func GetAutomations(ctx context.Context, db sqlx.Queryer, applicationID int64) ([]Automation, error) {
+	var out []Automation
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			automation
+		where
+			application_id = $1
+		order by
+			name`,
+		applicationID,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetEnabledAutomationsForTrigger returns the enabled automations of the
+// given application with the given trigger type, sorted by name.
+func GetEnabledAutomationsForTrigger(ctx context.Context, db sqlx.Queryer, applicationID int64, triggerType spec.TriggerType) ([]Automation, error) {
+	var out []Automation
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			automation
+		where
+			application_id = $1
+			and enabled = true
+			and trigger->>'type' = $2
+		order by
+			name`,
+		applicationID,
+		triggerType,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetAllEnabledAutomationsForTrigger returns the enabled automations of all
+// applications with the given trigger type.
+func GetAllEnabledAutomationsForTrigger(ctx context.Context, db sqlx.Queryer, triggerType spec.TriggerType) ([]Automation, error) {
+	var out []Automation
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			automation
+		where
+			enabled = true
+			and trigger->>'type' = $1
+		order by
+			id`,
+		triggerType,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetScheduledAutomations returns at most limit enabled automations with a
+// SCHEDULE trigger for which the schedule interval has passed. The
+// automations are locked, this function must be called within a
+// transaction. Automations locked by an other transaction are skipped.
+func GetScheduledAutomations(ctx context.Context, db sqlx.Queryer, now time.Time, limit int) ([]Automation, error) {
+	var out []Automation
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			automation
+		where
+			enabled = true
+			and trigger->>'type' = $1
+			and (
+				scheduled_at is null
+				or scheduled_at + (trigger->>'intervalSeconds')::bigint * interval '1 second' <= $2
+			)
+		order by
+			scheduled_at nulls first
+		limit $3
+		for update
+		skip locked`,
+		spec.ScheduleTrigger,
+		now,
+		limit,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// SetAutomationScheduledAt sets the last time the SCHEDULE trigger of the
+// given automation fired.
+func SetAutomationScheduledAt(ctx context.Context, db sqlx.Execer, id uuid.UUID, scheduledAt time.Time) error {
+	res, err := db.Exec("update automation set scheduled_at = $2 where id = $1", id, scheduledAt)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	return nil
+}
+
+// UpdateAutomation updates the given automation. When not set, the
+// severity defaults to WARNING.
+func UpdateAutomation(ctx context.Context, db sqlx.Execer, a *Automation) error {
+	if a.Severity == "" {
+		a.Severity = spec.WarningSeverity
+	}
+
+	if err := a.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	a.UpdatedAt = time.Now()
+
+	if a.DeviceTags.Map == nil {
+		a.DeviceTags.Map = make(map[string]sql.NullString)
+	}
+
+	res, err := db.Exec(`
+		update automation
+		set
+			updated_at = $2,
+			name = $3,
+			enabled = $4,
+			device_tags = $5,
+			trigger = $6,
+			actions = $7,
+			alarm = $8,
+			escalation = $9,
+			severity = $10,
+			dampening = $11,
+			cooldown = $12
+		where
+			id = $1`,
+		a.ID,
+		a.UpdatedAt,
+		a.Name,
+		a.Enabled,
+		a.DeviceTags,
+		a.Trigger,
+		a.Actions,
+		a.Alarm,
+		a.Escalation,
+		a.Severity,
+		a.Dampening,
+		a.Cooldown,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"id":     a.ID,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("automation updated")
+
+	return nil
+}
+
+// DeleteAutomation deletes the automation, its execution history and its
+// alarms.
+func DeleteAutomation(ctx context.Context, db sqlx.Execer, id uuid.UUID) error {
+	res, err := db.Exec("delete from automation where id = $1", id)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"id":     id,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("automation deleted")
+
+	return nil
+}
+
+// GetAutomationDevices returns the devices to which the given automation
+// applies, sorted by DevEUI.
+func GetAutomationDevices(ctx context.Context, db sqlx.Queryer, a Automation) ([]Device, error) {
+	var out []Device
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			device
+		where
+			application_id = $1
+			and coalesce(tags, ''::hstore) @> $2
+		order by
+			dev_eui`,
+		a.ApplicationID,
+		a.DeviceTags,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetAutomationOfflineDevices returns the devices to which the given
+// automation applies which have not been seen within the timeout of its
+// DEVICE_OFFLINE trigger and for which the automation has not been executed
+// since they were last seen. Devices last seen before since are ignored,
+// e.g. so that enabling an automation does not fire for all devices that
+// were decommissioned long ago.
+func GetAutomationOfflineDevices(ctx context.Context, db sqlx.Queryer, a Automation, now, since time.Time) ([]Device, error) {
+	var out []Device
+	err := sqlx.Select(db, &out, `
+		select
+			d.*
+		from
+			device d
+		where
+			d.application_id = $1
+			and coalesce(d.tags, ''::hstore) @> $2
+			and d.last_seen_at < $3
+			and d.last_seen_at >= $4
+			and not exists (
+				select
+					1
+				from
+					automation_execution e
+				where
+					e.automation_id = $5
+					and e.dev_eui = d.dev_eui
+					and e.created_at > d.last_seen_at
+			)
+		order by
+			d.dev_eui`,
+		a.ApplicationID,
+		a.DeviceTags,
+		now.Add(-a.Trigger.Timeout()),
+		since,
+		a.ID,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// CheckAutomationCooldown registers a trigger of the given automation for
+// the given device and returns false when the automation is still in its
+// cooldown period for the device.
+func CheckAutomationCooldown(ctx context.Context, a Automation, devEUI lorawan.EUI64) (bool, error) {
+	if a.Cooldown <= 0 {
+		return true, nil
+	}
+
+	ok, err := RedisClient().SetNX(GetRedisKey(automationCooldownKeyTempl, devEUI, a.ID), time.Now().Unix(), a.Cooldown).Result()
+	if err != nil {
+		return false, errors.Wrap(err, "set cooldown key error")
+	}
+	if !ok {
+		log.WithFields(log.Fields{
+			"automation_id": a.ID,
+			"dev_eui":       devEUI,
+			"cooldown":      a.Cooldown,
+			"ctx_id":        ctx.Value(logging.ContextIDKey),
+		}).Info("automation in cooldown period")
+	}
+
+	return ok, nil
+}
+
+// CheckAutomationOffline registers the DEVICE_OFFLINE trigger of the given
+// automation for the given device, last seen at the given time. It returns
+// false when this was already registered, e.g. by an other instance
+// evaluating the same trigger.
+func CheckAutomationOffline(ctx context.Context, a Automation, devEUI lorawan.EUI64, lastSeenAt time.Time) (bool, error) {
+	ok, err := RedisClient().SetNX(GetRedisKey(automationOfflineKeyTempl, devEUI, a.ID, lastSeenAt.UnixNano()), time.Now().Unix(), a.Trigger.Timeout()).Result()
+	if err != nil {
+		return false, errors.Wrap(err, "set offline key error")
+	}
+
+	return ok, nil
+}
+
+// CreateAutomationExecution creates the given automation execution.
+func CreateAutomationExecution(ctx context.Context, db sqlx.Queryer, e *AutomationExecution) error {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+
+	err := sqlx.Get(db, &e.ID, `
+		insert into automation_execution (
+			automation_id,
+			created_at,
+			dev_eui,
+			trigger_type,
+			success,
+			results
+		) values ($1, $2, $3, $4, $5, $6)
+		returning id`,
+		e.AutomationID,
+		e.CreatedAt,
+		e.DevEUI[:],
+		e.TriggerType,
+		e.Success,
+		e.Results,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"id":            e.ID,
+		"automation_id": e.AutomationID,
+		"dev_eui":       e.DevEUI,
+		"success":       e.Success,
+		"ctx_id":        ctx.Value(logging.ContextIDKey),
+	}).Info("automation execution created")
+
+	return nil
+}
+
+// GetAutomationExecutionCount returns the number of executions of the given
+// automation.
+func GetAutomationExecutionCount(ctx context.Context, db sqlx.Queryer, automationID uuid.UUID) (int, error) {
+	var count int
+	err := sqlx.Get(db, &count, "select count(*) from automation_execution where automation_id = $1", automationID)
+	if err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	return count, nil
+}
+
+// GetAutomationExecutions returns the executions of the given automation,
+// most recent first.
+func GetAutomationExecutions(ctx context.Context, db sqlx.Queryer, automationID uuid.UUID, limit, offset int) ([]AutomationExecution, error) {
+	var out []AutomationExecution
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			automation_execution
+		where
+			automation_id = $1
+		order by
+			created_at desc,
+			id desc
+		limit $2
+		offset $3`,
+		automationID,
+		limit,
+		offset,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// DeleteAutomationExecutionsBefore deletes the automation executions created
+// before the given time. It returns the number of deleted executions.
+func DeleteAutomationExecutionsBefore(ctx context.Context, db sqlx.Execer, before time.Time) (int64, error) {
+	res, err := db.Exec("delete from automation_execution where created_at < $1", before)
+	if err != nil {
+		return 0, handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "get rows affected error")
+	}
+
+	return ra, nil
+}
@@ -0,0 +1,546 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+// AutomationAlarmState defines an automation alarm state, used for
+// filtering the alarm history.
+type AutomationAlarmState string
+
+// Available automation alarm states.
+const (
+	AutomationAlarmActive         AutomationAlarmState = "ACTIVE"
+	AutomationAlarmResolved       AutomationAlarmState = "RESOLVED"
+	AutomationAlarmUnacknowledged AutomationAlarmState = "UNACKNOWLEDGED"
+	AutomationAlarmAcknowledged   AutomationAlarmState = "ACKNOWLEDGED"
+)
+
+// AutomationAlarm defines an alarm raised by the execution of an automation
+// (see Automation.RaisesAlarm). TriggerValue holds the value which fired the
+// trigger, e.g. the decoded uplink object. The alarm is resolved when the
+// trigger condition no longer holds for the device, ResolveValue then holds
+// the value which resolved the alarm. Severity holds the severity of the
+// automation at the time the alarm was raised.
+//
+// EscalationLevel holds the number of executed escalation steps and
+// NextEscalationAt the time the next step is due. The escalation stops once
+// the alarm has been acknowledged or resolved or when all steps have been
+// executed. There is at most one open (unacknowledged and unresolved) alarm
+// per automation and device.
+type AutomationAlarm struct {
+	ID                    uuid.UUID       `db:"id"`
+	AutomationID          uuid.UUID       `db:"automation_id"`
+	DevEUI                lorawan.EUI64   `db:"dev_eui"`
+	CreatedAt             time.Time       `db:"created_at"`
+	UpdatedAt             time.Time       `db:"updated_at"`
+	TriggerValue          json.RawMessage `db:"trigger_value"`
+	Severity              spec.Severity   `db:"severity"`
+	EscalationLevel       int             `db:"escalation_level"`
+	EscalatedAt           *time.Time      `db:"escalated_at"`
+	NextEscalationAt      *time.Time      `db:"next_escalation_at"`
+	ResolvedAt            *time.Time      `db:"resolved_at"`
+	ResolveValue          json.RawMessage `db:"resolve_value"`
+	AcknowledgedAt        *time.Time      `db:"acknowledged_at"`
+	AcknowledgedBy        string          `db:"acknowledged_by"`
+	AcknowledgmentComment string          `db:"acknowledgment_comment"`
+}
+
+// AutomationAlarmListItem defines an automation alarm with the names of its
+// automation and device.
+type AutomationAlarmListItem struct {
+	AutomationAlarm
+	AutomationName string `db:"automation_name"`
+	DeviceName     string `db:"device_name"`
+}
+
+// AutomationAlarmNotification defines a notification (e-mail, webhook, SMS
+// or in-app) sent for an automation alarm, either by the execution which
+// raised the alarm (escalation level 0) or by an escalation step.
+// Recipients holds the e-mail addresses, the webhook URL or the phone
+// numbers.
+type AutomationAlarmNotification struct {
+	ID              int64           `db:"id"`
+	AlarmID         uuid.UUID       `db:"alarm_id"`
+	CreatedAt       time.Time       `db:"created_at"`
+	EscalationLevel int             `db:"escalation_level"`
+	ActionType      spec.ActionType `db:"action_type"`
+	Recipients      pq.StringArray  `db:"recipients"`
+	Error           string          `db:"error"`
+}
+
+// AutomationAlarmFilters provides filters for filtering automation alarms.
+// Note that empty values are not used as filters.
+type AutomationAlarmFilters struct {
+	ApplicationID int64                `db:"application_id"`
+	AutomationID  uuid.UUID            `db:"automation_id"`
+	DevEUI        lorawan.EUI64        `db:"dev_eui"`
+	OpenOnly      bool                 `db:"open_only"`
+	State         AutomationAlarmState `db:"state"`
+	Severity      spec.Severity        `db:"severity"`
+
+	// Start and End filter on the time the alarm was raised.
+	Start time.Time `db:"start"`
+	End   time.Time `db:"end"`
+
+	// Limit and Offset are added for convenience so that this struct can
+	// be given as the arguments.
+	Limit  int `db:"limit"`
+	Offset int `db:"offset"`
+}
+
+// SQL returns the SQL filters.
+func (f AutomationAlarmFilters) SQL() string {
+	var filters []string
+	var nullDevEUI lorawan.EUI64
+
+	if f.ApplicationID != 0 {
+		filters = append(filters, "a.application_id = :application_id")
+	}
+
+	if f.AutomationID != uuid.Nil {
+		filters = append(filters, "al.automation_id = :automation_id")
+	}
+
+	if f.DevEUI != nullDevEUI {
+		filters = append(filters, "al.dev_eui = :dev_eui")
+	}
+
+	if f.OpenOnly {
+		filters = append(filters, "al.acknowledged_at is null and al.resolved_at is null")
+	}
+
+	switch f.State {
+	case AutomationAlarmActive:
+		filters = append(filters, "al.resolved_at is null")
+	case AutomationAlarmResolved:
+		filters = append(filters, "al.resolved_at is not null")
+	case AutomationAlarmUnacknowledged:
+		filters = append(filters, "al.acknowledged_at is null")
+	case AutomationAlarmAcknowledged:
+		filters = append(filters, "al.acknowledged_at is not null")
+	}
+
+	if f.Severity != "" {
+		filters = append(filters, "al.severity = :severity")
+	}
+
+	if !f.Start.IsZero() {
+		filters = append(filters, "al.created_at >= :start")
+	}
+
+	if !f.End.IsZero() {
+		filters = append(filters, "al.created_at < :end")
+	}
+
+	if len(filters) == 0 {
+		return ""
+	}
+
+	return "where " + strings.Join(filters, " and ")
+}
+
+// CreateAutomationAlarm creates the given automation alarm. It returns
+// ErrAlreadyExists when an open alarm already exists for the automation and
+// device.
+func CreateAutomationAlarm(ctx context.Context, db sqlx.Execer, a *AutomationAlarm) error {
+	var err error
+	a.ID, err = uuid.NewV4()
+	if err != nil {
+		return errors.Wrap(err, "new uuid v4 error")
+	}
+
+	now := time.Now()
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = now
+	}
+	a.UpdatedAt = now
+
+	if a.Severity == "" {
+		a.Severity = spec.WarningSeverity
+	}
+
+	res, err := db.Exec(`
+		insert into automation_alarm (
+			id,
+			automation_id,
+			dev_eui,
+			created_at,
+			updated_at,
+			trigger_value,
+			severity,
+			escalation_level,
+			escalated_at,
+			next_escalation_at
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		on conflict (automation_id, dev_eui) where acknowledged_at is null and resolved_at is null
+			do nothing`,
+		a.ID,
+		a.AutomationID,
+		a.DevEUI[:],
+		a.CreatedAt,
+		a.UpdatedAt,
+		jsonObject(a.TriggerValue),
+		a.Severity,
+		a.EscalationLevel,
+		a.EscalatedAt,
+		a.NextEscalationAt,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrAlreadyExists
+	}
+
+	log.WithFields(log.Fields{
+		"id":            a.ID,
+		"automation_id": a.AutomationID,
+		"dev_eui":       a.DevEUI,
+		"ctx_id":        ctx.Value(logging.ContextIDKey),
+	}).Info("automation alarm created")
+
+	return nil
+}
+
+// GetAutomationAlarm returns the automation alarm for the given id.
+func GetAutomationAlarm(ctx context.Context, db sqlx.Queryer, id uuid.UUID) (AutomationAlarm, error) {
+	var a AutomationAlarm
+	if err := sqlx.Get(db, &a, "select * from automation_alarm where id = $1", id); err != nil {
+		return a, handlePSQLError(Select, err, "select error")
+	}
+
+	return a, nil
+}
+
+// GetAutomationAlarmCount returns the number of automation alarms matching
+// the given filters.
+func GetAutomationAlarmCount(ctx context.Context, db sqlx.Queryer, filters AutomationAlarmFilters) (int, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			count(*)
+		from
+			automation_alarm al
+		inner join automation a
+			on a.id = al.automation_id
+		`+filters.SQL(), filters)
+	if err != nil {
+		return 0, errors.Wrap(err, "named query error")
+	}
+
+	var count int
+	if err := sqlx.Get(db, &count, query, args...); err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	return count, nil
+}
+
+// GetAutomationAlarms returns the automation alarms matching the given
+// filters, most recent first.
+func GetAutomationAlarms(ctx context.Context, db sqlx.Queryer, filters AutomationAlarmFilters) ([]AutomationAlarmListItem, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			al.*,
+			a.name as automation_name,
+			d.name as device_name
+		from
+			automation_alarm al
+		inner join automation a
+			on a.id = al.automation_id
+		inner join device d
+			on d.dev_eui = al.dev_eui
+		`+filters.SQL()+`
+		order by
+			al.created_at desc,
+			al.id
+		limit :limit
+		offset :offset`, filters)
+	if err != nil {
+		return nil, errors.Wrap(err, "named query error")
+	}
+
+	var out []AutomationAlarmListItem
+	if err := sqlx.Select(db, &out, query, args...); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetDueAutomationAlarms returns at most limit open automation alarms for
+// which the next escalation step is due. The alarms are locked,
+// this function must be called within a transaction. Alarms locked by an
+// other transaction are skipped.
+func GetDueAutomationAlarms(ctx context.Context, db sqlx.Queryer, now time.Time, limit int) ([]AutomationAlarm, error) {
+	var out []AutomationAlarm
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			automation_alarm
+		where
+			acknowledged_at is null
+			and resolved_at is null
+			and next_escalation_at <= $1
+		order by
+			next_escalation_at
+		limit $2
+		for update
+		skip locked`,
+		now,
+		limit,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// UpdateAutomationAlarmEscalation updates the escalation state of the
+// given automation alarm.
+func UpdateAutomationAlarmEscalation(ctx context.Context, db sqlx.Execer, a *AutomationAlarm) error {
+	a.UpdatedAt = time.Now()
+
+	res, err := db.Exec(`
+		update automation_alarm
+		set
+			updated_at = $2,
+			escalation_level = $3,
+			escalated_at = $4,
+			next_escalation_at = $5
+		where
+			id = $1`,
+		a.ID,
+		a.UpdatedAt,
+		a.EscalationLevel,
+		a.EscalatedAt,
+		a.NextEscalationAt,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	return nil
+}
+
+// AcknowledgeAutomationAlarm acknowledges the given automation alarm, with
+// an optional comment, which stops its escalation. It returns
+// ErrAutomationAlarmAcknowledged when the alarm has already been
+// acknowledged.
+func AcknowledgeAutomationAlarm(ctx context.Context, db sqlx.Ext, id uuid.UUID, acknowledgedBy, comment string) error {
+	now := time.Now()
+
+	res, err := db.Exec(`
+		update automation_alarm
+		set
+			updated_at = $2,
+			acknowledged_at = $2,
+			acknowledged_by = $3,
+			acknowledgment_comment = $4,
+			next_escalation_at = null
+		where
+			id = $1
+			and acknowledged_at is null`,
+		id,
+		now,
+		acknowledgedBy,
+		comment,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		if _, err := GetAutomationAlarm(ctx, db, id); err != nil {
+			return err
+		}
+		return ErrAutomationAlarmAcknowledged
+	}
+
+	log.WithFields(log.Fields{
+		"id":              id,
+		"acknowledged_by": acknowledgedBy,
+		"ctx_id":          ctx.Value(logging.ContextIDKey),
+	}).Info("automation alarm acknowledged")
+
+	return nil
+}
+
+// ResolveAutomationAlarms resolves the unresolved alarms of the given
+// automation and device, as the trigger condition no longer holds for the
+// device. This stops the escalation of these alarms. It returns the number
+// of resolved alarms.
+func ResolveAutomationAlarms(ctx context.Context, db sqlx.Execer, automationID uuid.UUID, devEUI lorawan.EUI64, resolvedAt time.Time, value json.RawMessage) (int64, error) {
+	res, err := db.Exec(`
+		update automation_alarm
+		set
+			updated_at = $3,
+			resolved_at = $3,
+			resolve_value = $4,
+			next_escalation_at = null
+		where
+			automation_id = $1
+			and dev_eui = $2
+			and resolved_at is null`,
+		automationID,
+		devEUI[:],
+		resolvedAt,
+		jsonObject(value),
+	)
+	if err != nil {
+		return 0, handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "get rows affected error")
+	}
+
+	if ra != 0 {
+		log.WithFields(log.Fields{
+			"automation_id": automationID,
+			"dev_eui":       devEUI,
+			"count":         ra,
+			"ctx_id":        ctx.Value(logging.ContextIDKey),
+		}).Info("automation alarms resolved")
+	}
+
+	return ra, nil
+}
+
+// ResolveDeviceAutomationAlarms resolves the unresolved alarms of the given
+// device raised by the automations with the given trigger type, e.g. the
+// DEVICE_OFFLINE alarms once the device is seen again. It returns the number
+// of resolved alarms.
+func ResolveDeviceAutomationAlarms(ctx context.Context, db sqlx.Execer, devEUI lorawan.EUI64, triggerType spec.TriggerType, resolvedAt time.Time, value json.RawMessage) (int64, error) {
+	res, err := db.Exec(`
+		update automation_alarm al
+		set
+			updated_at = $3,
+			resolved_at = $3,
+			resolve_value = $4,
+			next_escalation_at = null
+		from
+			automation a
+		where
+			a.id = al.automation_id
+			and al.dev_eui = $1
+			and al.resolved_at is null
+			and a.trigger->>'type' = $2`,
+		devEUI[:],
+		triggerType,
+		resolvedAt,
+		jsonObject(value),
+	)
+	if err != nil {
+		return 0, handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "get rows affected error")
+	}
+
+	if ra != 0 {
+		log.WithFields(log.Fields{
+			"dev_eui": devEUI,
+			"trigger": triggerType,
+			"count":   ra,
+			"ctx_id":  ctx.Value(logging.ContextIDKey),
+		}).Info("automation alarms resolved")
+	}
+
+	return ra, nil
+}
+
+// CreateAutomationAlarmNotification stores the given automation alarm
+// notification.
+func CreateAutomationAlarmNotification(ctx context.Context, db sqlx.Queryer, n *AutomationAlarmNotification) error {
+	if n.CreatedAt.IsZero() {
+		n.CreatedAt = time.Now()
+	}
+	if n.Recipients == nil {
+		n.Recipients = pq.StringArray{}
+	}
+
+	err := sqlx.Get(db, &n.ID, `
+		insert into automation_alarm_notification (
+			alarm_id,
+			created_at,
+			escalation_level,
+			action_type,
+			recipients,
+			error
+		) values ($1, $2, $3, $4, $5, $6)
+		returning id`,
+		n.AlarmID,
+		n.CreatedAt,
+		n.EscalationLevel,
+		n.ActionType,
+		n.Recipients,
+		n.Error,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	return nil
+}
+
+// GetAutomationAlarmNotifications returns the notifications sent for the
+// given automation alarm, sorted by time.
+func GetAutomationAlarmNotifications(ctx context.Context, db sqlx.Queryer, alarmID uuid.UUID) ([]AutomationAlarmNotification, error) {
+	var out []AutomationAlarmNotification
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			automation_alarm_notification
+		where
+			alarm_id = $1
+		order by
+			created_at,
+			id`,
+		alarmID,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// jsonObject returns an empty JSON object in case the given JSON is empty.
+func jsonObject(b json.RawMessage) []byte {
+	if len(b) == 0 {
+		return []byte("{}")
+	}
+	return []byte(b)
+}
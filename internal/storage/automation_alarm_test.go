@@ -0,0 +1,300 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestAutomationAlarm() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(ctx, ts.Tx(), &org))
+
+	n := NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(CreateNetworkServer(ctx, ts.Tx(), &n))
+
+	sp := ServiceProfile{
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+		Name:            "test-sp",
+	}
+	assert.NoError(CreateServiceProfile(ctx, ts.Tx(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(ctx, ts.Tx(), &app))
+
+	dp := DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateDeviceProfile(ctx, ts.Tx(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	d := Device{
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "pump-1",
+	}
+	assert.NoError(CreateDevice(ctx, ts.Tx(), &d))
+
+	notification := spec.Action{
+		Type:    spec.NotificationAction,
+		To:      []string{"operator@example.com"},
+		Subject: "Pump alarm",
+	}
+
+	a := Automation{
+		ApplicationID: app.ID,
+		Name:          "pump-offline",
+		Enabled:       true,
+		Trigger: spec.Trigger{
+			Type:           spec.DeviceOfflineTrigger,
+			TimeoutSeconds: 3600,
+		},
+		Actions: spec.Actions{notification},
+		Escalation: spec.Escalation{
+			{DelaySeconds: 900, Action: notification},
+		},
+	}
+
+	ts.T().Run("Create automation invalid escalation", func(t *testing.T) {
+		assert := require.New(t)
+
+		invalid := a
+		invalid.Escalation = spec.Escalation{
+			{DelaySeconds: 10, Action: notification},
+		}
+		assert.Equal(ErrAutomationInvalidEscalation, errors.Cause(CreateAutomation(ctx, ts.Tx(), &invalid)))
+	})
+
+	assert.NoError(CreateAutomation(ctx, ts.Tx(), &a))
+
+	aGet, err := GetAutomation(ctx, ts.Tx(), a.ID)
+	assert.NoError(err)
+	assert.Equal(a.Escalation, aGet.Escalation)
+
+	now := time.Now().Round(time.Millisecond)
+	next := now.Add(15 * time.Minute)
+
+	alarm := AutomationAlarm{
+		AutomationID:     a.ID,
+		DevEUI:           d.DevEUI,
+		CreatedAt:        now,
+		NextEscalationAt: &next,
+	}
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(CreateAutomationAlarm(ctx, ts.Tx(), &alarm))
+
+		alarmGet, err := GetAutomationAlarm(ctx, ts.Tx(), alarm.ID)
+		assert.NoError(err)
+		assert.Equal(d.DevEUI, alarmGet.DevEUI)
+		assert.Equal(0, alarmGet.EscalationLevel)
+		assert.True(alarmGet.NextEscalationAt.Equal(next))
+		assert.Nil(alarmGet.AcknowledgedAt)
+
+		// at most one unacknowledged alarm per automation and device
+		assert.Equal(ErrAlreadyExists, errors.Cause(CreateAutomationAlarm(ctx, ts.Tx(), &AutomationAlarm{
+			AutomationID: a.ID,
+			DevEUI:       d.DevEUI,
+		})))
+	})
+
+	ts.T().Run("Due", func(t *testing.T) {
+		assert := require.New(t)
+
+		alarms, err := GetDueAutomationAlarms(ctx, ts.Tx(), now, 10)
+		assert.NoError(err)
+		assert.Len(alarms, 0)
+
+		alarms, err = GetDueAutomationAlarms(ctx, ts.Tx(), next, 10)
+		assert.NoError(err)
+		assert.Len(alarms, 1)
+
+		alarm.EscalationLevel = 1
+		alarm.EscalatedAt = &next
+		alarm.NextEscalationAt = nil
+		assert.NoError(UpdateAutomationAlarmEscalation(ctx, ts.Tx(), &alarm))
+
+		alarms, err = GetDueAutomationAlarms(ctx, ts.Tx(), next.Add(time.Hour), 10)
+		assert.NoError(err)
+		assert.Len(alarms, 0)
+
+		alarmGet, err := GetAutomationAlarm(ctx, ts.Tx(), alarm.ID)
+		assert.NoError(err)
+		assert.Equal(1, alarmGet.EscalationLevel)
+		assert.True(alarmGet.EscalatedAt.Equal(next))
+	})
+
+	ts.T().Run("Acknowledge", func(t *testing.T) {
+		assert := require.New(t)
+
+		filters := AutomationAlarmFilters{
+			AutomationID: a.ID,
+			OpenOnly:     true,
+			Limit:        10,
+		}
+
+		count, err := GetAutomationAlarmCount(ctx, ts.Tx(), filters)
+		assert.NoError(err)
+		assert.Equal(1, count)
+
+		assert.NoError(AcknowledgeAutomationAlarm(ctx, ts.Tx(), alarm.ID, "operator@example.com", "probe replaced"))
+		assert.Equal(ErrAutomationAlarmAcknowledged, errors.Cause(AcknowledgeAutomationAlarm(ctx, ts.Tx(), alarm.ID, "operator@example.com", "")))
+		assert.Equal(ErrDoesNotExist, errors.Cause(AcknowledgeAutomationAlarm(ctx, ts.Tx(), uuid.Must(uuid.NewV4()), "operator@example.com", "")))
+
+		alarmGet, err := GetAutomationAlarm(ctx, ts.Tx(), alarm.ID)
+		assert.NoError(err)
+		assert.NotNil(alarmGet.AcknowledgedAt)
+		assert.Equal("operator@example.com", alarmGet.AcknowledgedBy)
+		assert.Equal("probe replaced", alarmGet.AcknowledgmentComment)
+
+		count, err = GetAutomationAlarmCount(ctx, ts.Tx(), filters)
+		assert.NoError(err)
+		assert.Equal(0, count)
+
+		filters.OpenOnly = false
+		alarms, err := GetAutomationAlarms(ctx, ts.Tx(), filters)
+		assert.NoError(err)
+		assert.Len(alarms, 1)
+		assert.Equal("pump-offline", alarms[0].AutomationName)
+		assert.Equal("pump-1", alarms[0].DeviceName)
+	})
+
+	ts.T().Run("Resolve", func(t *testing.T) {
+		assert := require.New(t)
+
+		// a new alarm can be raised once acknowledged
+		second := AutomationAlarm{
+			AutomationID: a.ID,
+			DevEUI:       d.DevEUI,
+			CreatedAt:    now.Add(time.Hour),
+			TriggerValue: json.RawMessage(`{"lastSeenAt":"2020-01-01T00:00:00Z"}`),
+		}
+		assert.NoError(CreateAutomationAlarm(ctx, ts.Tx(), &second))
+
+		count, err := ResolveAutomationAlarms(ctx, ts.Tx(), uuid.Must(uuid.NewV4()), d.DevEUI, now, nil)
+		assert.NoError(err)
+		assert.EqualValues(0, count)
+
+		resolvedAt := now.Add(2 * time.Hour)
+		count, err = ResolveDeviceAutomationAlarms(ctx, ts.Tx(), d.DevEUI, spec.UplinkTrigger, resolvedAt, nil)
+		assert.NoError(err)
+		assert.EqualValues(0, count)
+
+		// both the acknowledged and the new alarm are resolved
+		count, err = ResolveDeviceAutomationAlarms(ctx, ts.Tx(), d.DevEUI, spec.DeviceOfflineTrigger, resolvedAt, json.RawMessage(`{"lastSeenAt":"2020-01-01T02:00:00Z"}`))
+		assert.NoError(err)
+		assert.EqualValues(2, count)
+
+		alarmGet, err := GetAutomationAlarm(ctx, ts.Tx(), second.ID)
+		assert.NoError(err)
+		assert.True(alarmGet.ResolvedAt.Equal(resolvedAt))
+		assert.JSONEq(`{"lastSeenAt":"2020-01-01T00:00:00Z"}`, string(alarmGet.TriggerValue))
+		assert.JSONEq(`{"lastSeenAt":"2020-01-01T02:00:00Z"}`, string(alarmGet.ResolveValue))
+		assert.Nil(alarmGet.AcknowledgedAt)
+
+		// resolving a resolved alarm is a no-op
+		count, err = ResolveAutomationAlarms(ctx, ts.Tx(), a.ID, d.DevEUI, now, nil)
+		assert.NoError(err)
+		assert.EqualValues(0, count)
+
+		// a resolved alarm can be acknowledged afterwards
+		assert.NoError(AcknowledgeAutomationAlarm(ctx, ts.Tx(), second.ID, "operator@example.com", ""))
+	})
+
+	ts.T().Run("History", func(t *testing.T) {
+		assert := require.New(t)
+
+		filters := AutomationAlarmFilters{
+			ApplicationID: app.ID,
+			DevEUI:        d.DevEUI,
+			Limit:         10,
+		}
+
+		alarms, err := GetAutomationAlarms(ctx, ts.Tx(), filters)
+		assert.NoError(err)
+		assert.Len(alarms, 2)
+		assert.True(alarms[0].CreatedAt.After(alarms[1].CreatedAt))
+
+		tests := []struct {
+			name  string
+			f     func(f *AutomationAlarmFilters)
+			count int
+		}{
+			{"active", func(f *AutomationAlarmFilters) { f.State = AutomationAlarmActive }, 0},
+			{"resolved", func(f *AutomationAlarmFilters) { f.State = AutomationAlarmResolved }, 2},
+			{"unacknowledged", func(f *AutomationAlarmFilters) { f.State = AutomationAlarmUnacknowledged }, 0},
+			{"acknowledged", func(f *AutomationAlarmFilters) { f.State = AutomationAlarmAcknowledged }, 2},
+			{"start", func(f *AutomationAlarmFilters) { f.Start = now.Add(time.Minute) }, 1},
+			{"end", func(f *AutomationAlarmFilters) { f.End = now.Add(time.Minute) }, 1},
+			{"other application", func(f *AutomationAlarmFilters) { f.ApplicationID = app.ID + 1 }, 0},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.name, func(t *testing.T) {
+				assert := require.New(t)
+
+				f := filters
+				tst.f(&f)
+
+				count, err := GetAutomationAlarmCount(ctx, ts.Tx(), f)
+				assert.NoError(err)
+				assert.Equal(tst.count, count)
+			})
+		}
+	})
+
+	ts.T().Run("Notifications", func(t *testing.T) {
+		assert := require.New(t)
+
+		items := []AutomationAlarmNotification{
+			{AlarmID: alarm.ID, CreatedAt: now, ActionType: spec.NotificationAction, Recipients: []string{"operator@example.com"}},
+			{AlarmID: alarm.ID, CreatedAt: next, EscalationLevel: 1, ActionType: spec.WebhookAction, Recipients: []string{"http://localhost/hook"}, Error: "timeout"},
+		}
+		for i := range items {
+			assert.NoError(CreateAutomationAlarmNotification(ctx, ts.Tx(), &items[i]))
+			assert.NotZero(items[i].ID)
+		}
+
+		out, err := GetAutomationAlarmNotifications(ctx, ts.Tx(), alarm.ID)
+		assert.NoError(err)
+		assert.Len(out, 2)
+		assert.Equal(spec.NotificationAction, out[0].ActionType)
+		assert.Equal([]string{"operator@example.com"}, []string(out[0].Recipients))
+		assert.Equal(1, out[1].EscalationLevel)
+		assert.Equal("timeout", out[1].Error)
+	})
+}
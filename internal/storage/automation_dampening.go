@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+const (
+	automationViolationsKeyTempl = "lora:as:automation:violations:{%s}:%s" // (dev_eui | automation_id)
+	automationRenotifyKeyTempl   = "lora:as:automation:renotify:{%s}:%s"   // (dev_eui | automation_id)
+)
+
+// automationViolationsTTL defines the expiration of the consecutive
+// violations counter, such that the counters of devices which stopped
+// reporting are removed.
+const automationViolationsTTL = 24 * time.Hour
+
+// AutomationNotificationGroup defines the pending grouped notification of
+// an automation for a zone. Events holds the JSON array of the events which
+// are collapsed into the notification, which is sent at SendAt.
+type AutomationNotificationGroup struct {
+	ID           uuid.UUID       `db:"id"`
+	AutomationID uuid.UUID       `db:"automation_id"`
+	Zone         string          `db:"zone"`
+	CreatedAt    time.Time       `db:"created_at"`
+	SendAt       time.Time       `db:"send_at"`
+	Events       json.RawMessage `db:"events"`
+}
+
+// CheckAutomationViolations registers a violation of the trigger of the
+// given automation for the given device and returns false until the number
+// of consecutive violations of the dampening has been reached.
+func CheckAutomationViolations(ctx context.Context, a Automation, devEUI lorawan.EUI64) (bool, error) {
+	if a.Dampening.ConsecutiveViolations <= 1 {
+		return true, nil
+	}
+
+	key := GetRedisKey(automationViolationsKeyTempl, devEUI, a.ID)
+	pipe := RedisClient().TxPipeline()
+	count := pipe.Incr(key)
+	pipe.PExpire(key, automationViolationsTTL)
+	if _, err := pipe.Exec(); err != nil {
+		return false, errors.Wrap(err, "exec error")
+	}
+
+	if count.Val() < int64(a.Dampening.ConsecutiveViolations) {
+		log.WithFields(log.Fields{
+			"automation_id": a.ID,
+			"dev_eui":       devEUI,
+			"violations":    count.Val(),
+			"ctx_id":        ctx.Value(logging.ContextIDKey),
+		}).Info("automation trigger violation registered")
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// ResetAutomationViolations resets the consecutive violations of the
+// trigger of the given automation for the given device.
+func ResetAutomationViolations(ctx context.Context, a Automation, devEUI lorawan.EUI64) error {
+	if a.Dampening.ConsecutiveViolations <= 1 {
+		return nil
+	}
+
+	if err := RedisClient().Del(GetRedisKey(automationViolationsKeyTempl, devEUI, a.ID)).Err(); err != nil {
+		return errors.Wrap(err, "delete violations key error")
+	}
+
+	return nil
+}
+
+// CheckAutomationRenotify registers a notification of the given automation
+// for the given device and returns false when the device has already been
+// notified within the renotify interval of the dampening.
+func CheckAutomationRenotify(ctx context.Context, a Automation, devEUI lorawan.EUI64) (bool, error) {
+	if a.Dampening.Renotify() <= 0 {
+		return true, nil
+	}
+
+	ok, err := RedisClient().SetNX(GetRedisKey(automationRenotifyKeyTempl, devEUI, a.ID), time.Now().Unix(), a.Dampening.Renotify()).Result()
+	if err != nil {
+		return false, errors.Wrap(err, "set renotify key error")
+	}
+	if !ok {
+		log.WithFields(log.Fields{
+			"automation_id": a.ID,
+			"dev_eui":       devEUI,
+			"renotify":      a.Dampening.Renotify(),
+			"ctx_id":        ctx.Value(logging.ContextIDKey),
+		}).Info("automation notification suppressed by renotify interval")
+	}
+
+	return ok, nil
+}
+
+// AddAutomationNotificationGroupEvent adds the given event (JSON object) to
+// the pending grouped notification of the automation for the given zone.
+// When the zone does not have a pending grouped notification, it is created
+// and sent at sendAt.
+func AddAutomationNotificationGroupEvent(ctx context.Context, db sqlx.Execer, automationID uuid.UUID, zone string, sendAt time.Time, event json.RawMessage) error {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return errors.Wrap(err, "new uuid v4 error")
+	}
+
+	events, err := json.Marshal([]json.RawMessage{event})
+	if err != nil {
+		return errors.Wrap(err, "marshal json error")
+	}
+
+	_, err = db.Exec(`
+		insert into automation_notification_group (
+			id,
+			automation_id,
+			zone,
+			created_at,
+			send_at,
+			events
+		) values ($1, $2, $3, $4, $5, $6)
+		on conflict (automation_id, zone)
+			do update set events = automation_notification_group.events || excluded.events`,
+		id,
+		automationID,
+		zone,
+		time.Now(),
+		sendAt,
+		string(events),
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"automation_id": automationID,
+		"zone":          zone,
+		"ctx_id":        ctx.Value(logging.ContextIDKey),
+	}).Info("automation notification grouped")
+
+	return nil
+}
+
+// GetDueAutomationNotificationGroups returns at most limit grouped
+// notifications which are due at the given time. The groups are locked,
+// this function must be called within a transaction. Groups locked by an
+// other transaction are skipped.
+func GetDueAutomationNotificationGroups(ctx context.Context, db sqlx.Queryer, now time.Time, limit int) ([]AutomationNotificationGroup, error) {
+	var out []AutomationNotificationGroup
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			automation_notification_group
+		where
+			send_at <= $1
+		order by
+			send_at
+		limit $2
+		for update
+		skip locked`,
+		now,
+		limit,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// DeleteAutomationNotificationGroup deletes the grouped notification.
+func DeleteAutomationNotificationGroup(ctx context.Context, db sqlx.Execer, id uuid.UUID) error {
+	res, err := db.Exec("delete from automation_notification_group where id = $1", id)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	return nil
+}
@@ -0,0 +1,421 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/lib/pq/hstore"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/downlink/rule"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestAutomation() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	defer SetAutomationMinCooldown(0)
+	SetAutomationMinCooldown(time.Minute)
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(ctx, ts.Tx(), &org))
+
+	n := NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(CreateNetworkServer(ctx, ts.Tx(), &n))
+
+	sp := ServiceProfile{
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+		Name:            "test-sp",
+	}
+	assert.NoError(CreateServiceProfile(ctx, ts.Tx(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(ctx, ts.Tx(), &app))
+
+	dp := DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateDeviceProfile(ctx, ts.Tx(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	d1 := Device{
+		DevEUI:          lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 1},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "greenhouse-1",
+		Tags: hstore.Hstore{
+			Map: map[string]sql.NullString{
+				"site": {String: "greenhouse", Valid: true},
+			},
+		},
+	}
+	assert.NoError(CreateDevice(ctx, ts.Tx(), &d1))
+
+	d2 := Device{
+		DevEUI:          lorawan.EUI64{2, 2, 2, 2, 2, 2, 2, 2},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "field-1",
+	}
+	assert.NoError(CreateDevice(ctx, ts.Tx(), &d2))
+
+	uplinkTrigger := spec.Trigger{
+		Type: spec.UplinkTrigger,
+		Conditions: rule.Conditions{
+			{Path: "moisture", Operator: rule.LessThan, Value: json.RawMessage(`20`)},
+		},
+	}
+	actions := spec.Actions{
+		{Type: spec.TagAction, Key: "state", Value: "dry"},
+	}
+
+	ts.T().Run("Create invalid", func(t *testing.T) {
+		assert := require.New(t)
+
+		tests := []struct {
+			Automation Automation
+			Error      error
+		}{
+			{Automation{ApplicationID: app.ID, Trigger: uplinkTrigger, Actions: actions, Cooldown: time.Minute}, ErrAutomationInvalidName},
+			{Automation{ApplicationID: app.ID, Name: "test", Trigger: spec.Trigger{Type: spec.ScheduleTrigger}, Actions: actions, Cooldown: time.Minute}, ErrAutomationInvalidTrigger},
+			{Automation{ApplicationID: app.ID, Name: "test", Trigger: uplinkTrigger, Cooldown: time.Minute}, ErrAutomationInvalidActions},
+			{Automation{ApplicationID: app.ID, Name: "test", Trigger: uplinkTrigger, Actions: actions, Cooldown: time.Second}, ErrAutomationInvalidCooldown},
+			{Automation{ApplicationID: app.ID, Name: "test", Trigger: uplinkTrigger, Actions: actions, Dampening: spec.Dampening{GroupSeconds: 60}, Cooldown: time.Minute}, ErrAutomationInvalidDampening},
+		}
+
+		for _, tst := range tests {
+			assert.Equal(tst.Error, errors.Cause(CreateAutomation(ctx, ts.Tx(), &tst.Automation)))
+		}
+	})
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		a := Automation{
+			ApplicationID: app.ID,
+			Name:          "dry-soil",
+			Enabled:       true,
+			DeviceTags: hstore.Hstore{
+				Map: map[string]sql.NullString{
+					"site": {String: "greenhouse", Valid: true},
+				},
+			},
+			Trigger:  uplinkTrigger,
+			Actions:  actions,
+			Cooldown: time.Hour,
+		}
+		assert.NoError(CreateAutomation(ctx, ts.Tx(), &a))
+
+		aGet, err := GetAutomation(ctx, ts.Tx(), a.ID)
+		assert.NoError(err)
+		assert.Equal(a.Name, aGet.Name)
+		assert.Equal(a.DeviceTags, aGet.DeviceTags)
+		assert.Equal(a.Trigger, aGet.Trigger)
+		assert.Equal(a.Actions, aGet.Actions)
+		assert.Equal(a.Cooldown, aGet.Cooldown)
+		assert.Equal(spec.WarningSeverity, aGet.Severity)
+		assert.Nil(aGet.ScheduledAt)
+
+		t.Run("List", func(t *testing.T) {
+			assert := require.New(t)
+
+			items, err := GetAutomations(ctx, ts.Tx(), app.ID)
+			assert.NoError(err)
+			assert.Len(items, 1)
+		})
+
+		t.Run("Get enabled for trigger", func(t *testing.T) {
+			assert := require.New(t)
+
+			items, err := GetEnabledAutomationsForTrigger(ctx, ts.Tx(), app.ID, spec.UplinkTrigger)
+			assert.NoError(err)
+			assert.Len(items, 1)
+
+			items, err = GetEnabledAutomationsForTrigger(ctx, ts.Tx(), app.ID, spec.AlarmTrigger)
+			assert.NoError(err)
+			assert.Len(items, 0)
+
+			items, err = GetAllEnabledAutomationsForTrigger(ctx, ts.Tx(), spec.UplinkTrigger)
+			assert.NoError(err)
+			assert.Len(items, 1)
+		})
+
+		t.Run("Match device", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.True(a.MatchDevice(d1))
+			assert.False(a.MatchDevice(d2))
+
+			devices, err := GetAutomationDevices(ctx, ts.Tx(), a)
+			assert.NoError(err)
+			assert.Len(devices, 1)
+			assert.Equal(d1.DevEUI, devices[0].DevEUI)
+		})
+
+		t.Run("Cooldown", func(t *testing.T) {
+			assert := require.New(t)
+			RedisClient().FlushAll()
+
+			ok, err := CheckAutomationCooldown(ctx, a, d1.DevEUI)
+			assert.NoError(err)
+			assert.True(ok)
+
+			ok, err = CheckAutomationCooldown(ctx, a, d1.DevEUI)
+			assert.NoError(err)
+			assert.False(ok)
+
+			// other devices are not affected
+			ok, err = CheckAutomationCooldown(ctx, a, d2.DevEUI)
+			assert.NoError(err)
+			assert.True(ok)
+		})
+
+		t.Run("Dampening", func(t *testing.T) {
+			assert := require.New(t)
+			RedisClient().FlushAll()
+
+			a := a
+			a.Dampening = spec.Dampening{
+				ConsecutiveViolations: 2,
+				RenotifySeconds:       3600,
+				GroupTagKey:           "site",
+				GroupSeconds:          300,
+			}
+			assert.NoError(UpdateAutomation(ctx, ts.Tx(), &a))
+
+			aGet, err := GetAutomation(ctx, ts.Tx(), a.ID)
+			assert.NoError(err)
+			assert.Equal(a.Dampening, aGet.Dampening)
+
+			t.Run("Violations", func(t *testing.T) {
+				assert := require.New(t)
+
+				ok, err := CheckAutomationViolations(ctx, a, d1.DevEUI)
+				assert.NoError(err)
+				assert.False(ok)
+
+				assert.NoError(ResetAutomationViolations(ctx, a, d1.DevEUI))
+
+				ok, err = CheckAutomationViolations(ctx, a, d1.DevEUI)
+				assert.NoError(err)
+				assert.False(ok)
+
+				ok, err = CheckAutomationViolations(ctx, a, d1.DevEUI)
+				assert.NoError(err)
+				assert.True(ok)
+			})
+
+			t.Run("Renotify", func(t *testing.T) {
+				assert := require.New(t)
+
+				ok, err := CheckAutomationRenotify(ctx, a, d1.DevEUI)
+				assert.NoError(err)
+				assert.True(ok)
+
+				ok, err = CheckAutomationRenotify(ctx, a, d1.DevEUI)
+				assert.NoError(err)
+				assert.False(ok)
+			})
+
+			t.Run("Notification groups", func(t *testing.T) {
+				assert := require.New(t)
+				now := time.Now().Round(time.Second)
+
+				assert.NoError(AddAutomationNotificationGroupEvent(ctx, ts.Tx(), a.ID, "greenhouse", now, json.RawMessage(`{"deviceName": "greenhouse-1"}`)))
+				assert.NoError(AddAutomationNotificationGroupEvent(ctx, ts.Tx(), a.ID, "greenhouse", now.Add(time.Minute), json.RawMessage(`{"deviceName": "greenhouse-2"}`)))
+				assert.NoError(AddAutomationNotificationGroupEvent(ctx, ts.Tx(), a.ID, "field", now.Add(time.Minute), json.RawMessage(`{"deviceName": "field-1"}`)))
+
+				groups, err := GetDueAutomationNotificationGroups(ctx, ts.Tx(), now, 10)
+				assert.NoError(err)
+				assert.Len(groups, 1)
+				assert.Equal("greenhouse", groups[0].Zone)
+				assert.True(now.Equal(groups[0].SendAt))
+				assert.JSONEq(`[{"deviceName": "greenhouse-1"}, {"deviceName": "greenhouse-2"}]`, string(groups[0].Events))
+
+				assert.NoError(DeleteAutomationNotificationGroup(ctx, ts.Tx(), groups[0].ID))
+				assert.Equal(ErrDoesNotExist, errors.Cause(DeleteAutomationNotificationGroup(ctx, ts.Tx(), groups[0].ID)))
+
+				groups, err = GetDueAutomationNotificationGroups(ctx, ts.Tx(), now.Add(time.Minute), 10)
+				assert.NoError(err)
+				assert.Len(groups, 1)
+				assert.Equal("field", groups[0].Zone)
+			})
+		})
+
+		t.Run("Executions", func(t *testing.T) {
+			assert := require.New(t)
+			now := time.Now().Round(time.Millisecond)
+
+			for i, success := range []bool{true, false} {
+				e := AutomationExecution{
+					AutomationID: a.ID,
+					CreatedAt:    now.Add(time.Duration(i) * time.Second),
+					DevEUI:       d1.DevEUI,
+					TriggerType:  spec.UplinkTrigger,
+					Success:      success,
+					Results: spec.Results{
+						{Type: spec.TagAction},
+					},
+				}
+				if !success {
+					e.Results[0].Error = "device does not exist"
+				}
+				assert.NoError(CreateAutomationExecution(ctx, ts.Tx(), &e))
+			}
+
+			count, err := GetAutomationExecutionCount(ctx, ts.Tx(), a.ID)
+			assert.NoError(err)
+			assert.Equal(2, count)
+
+			items, err := GetAutomationExecutions(ctx, ts.Tx(), a.ID, 10, 0)
+			assert.NoError(err)
+			assert.Len(items, 2)
+			assert.False(items[0].Success)
+			assert.Equal(spec.Results{{Type: spec.TagAction, Error: "device does not exist"}}, items[0].Results)
+			assert.True(items[1].Success)
+
+			n, err := DeleteAutomationExecutionsBefore(ctx, ts.Tx(), now.Add(time.Second))
+			assert.NoError(err)
+			assert.EqualValues(1, n)
+
+			count, err = GetAutomationExecutionCount(ctx, ts.Tx(), a.ID)
+			assert.NoError(err)
+			assert.Equal(1, count)
+		})
+
+		t.Run("Update", func(t *testing.T) {
+			assert := require.New(t)
+
+			a.Enabled = false
+			assert.NoError(UpdateAutomation(ctx, ts.Tx(), &a))
+
+			items, err := GetEnabledAutomationsForTrigger(ctx, ts.Tx(), app.ID, spec.UplinkTrigger)
+			assert.NoError(err)
+			assert.Len(items, 0)
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(DeleteAutomation(ctx, ts.Tx(), a.ID))
+			assert.Equal(ErrDoesNotExist, errors.Cause(DeleteAutomation(ctx, ts.Tx(), a.ID)))
+		})
+	})
+
+	ts.T().Run("Scheduled", func(t *testing.T) {
+		assert := require.New(t)
+		now := time.Now()
+
+		a := Automation{
+			ApplicationID: app.ID,
+			Name:          "hourly-report",
+			Enabled:       true,
+			Trigger: spec.Trigger{
+				Type:            spec.ScheduleTrigger,
+				IntervalSeconds: 3600,
+			},
+			Actions:  actions,
+			Cooldown: time.Minute,
+		}
+		assert.NoError(CreateAutomation(ctx, ts.Tx(), &a))
+
+		items, err := GetScheduledAutomations(ctx, ts.Tx(), now, 10)
+		assert.NoError(err)
+		assert.Len(items, 1)
+
+		assert.NoError(SetAutomationScheduledAt(ctx, ts.Tx(), a.ID, now))
+
+		items, err = GetScheduledAutomations(ctx, ts.Tx(), now.Add(59*time.Minute), 10)
+		assert.NoError(err)
+		assert.Len(items, 0)
+
+		items, err = GetScheduledAutomations(ctx, ts.Tx(), now.Add(time.Hour), 10)
+		assert.NoError(err)
+		assert.Len(items, 1)
+
+		devices, err := GetAutomationDevices(ctx, ts.Tx(), a)
+		assert.NoError(err)
+		assert.Len(devices, 2)
+	})
+
+	ts.T().Run("Offline", func(t *testing.T) {
+		assert := require.New(t)
+		RedisClient().FlushAll()
+		now := time.Now()
+
+		a := Automation{
+			ApplicationID: app.ID,
+			Name:          "offline",
+			Enabled:       true,
+			Trigger: spec.Trigger{
+				Type:           spec.DeviceOfflineTrigger,
+				TimeoutSeconds: 3600,
+			},
+			Actions:  actions,
+			Cooldown: time.Minute,
+		}
+		assert.NoError(CreateAutomation(ctx, ts.Tx(), &a))
+
+		assert.NoError(UpdateDeviceLastSeenAndDR(ctx, ts.Tx(), d1.DevEUI, now.Add(-2*time.Hour), 0))
+		assert.NoError(UpdateDeviceLastSeenAndDR(ctx, ts.Tx(), d2.DevEUI, now.Add(-10*time.Minute), 0))
+
+		devices, err := GetAutomationOfflineDevices(ctx, ts.Tx(), a, now, now.Add(-24*time.Hour))
+		assert.NoError(err)
+		assert.Len(devices, 1)
+		assert.Equal(d1.DevEUI, devices[0].DevEUI)
+
+		// devices last seen before since are ignored
+		devices, err = GetAutomationOfflineDevices(ctx, ts.Tx(), a, now, now.Add(-time.Hour))
+		assert.NoError(err)
+		assert.Len(devices, 0)
+
+		// registered once per device and last seen timestamp
+		ok, err := CheckAutomationOffline(ctx, a, d1.DevEUI, now.Add(-2*time.Hour))
+		assert.NoError(err)
+		assert.True(ok)
+
+		ok, err = CheckAutomationOffline(ctx, a, d1.DevEUI, now.Add(-2*time.Hour))
+		assert.NoError(err)
+		assert.False(ok)
+
+		// the device is no longer returned once the automation was executed
+		assert.NoError(CreateAutomationExecution(ctx, ts.Tx(), &AutomationExecution{
+			AutomationID: a.ID,
+			CreatedAt:    now,
+			DevEUI:       d1.DevEUI,
+			TriggerType:  spec.DeviceOfflineTrigger,
+			Success:      true,
+		}))
+
+		devices, err = GetAutomationOfflineDevices(ctx, ts.Tx(), a, now, now.Add(-24*time.Hour))
+		assert.NoError(err)
+		assert.Len(devices, 0)
+	})
+}
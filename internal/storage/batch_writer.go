@@ -0,0 +1,302 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+// maxInsertRows defines the max. number of rows inserted by a single
+// multi-row insert statement. PostgreSQL limits the number of parameters
+// of a statement to 65535.
+const maxInsertRows = 1000
+
+// batchWriterMaxAttempts defines the max. number of times the batch writer
+// attempts to write the same rows, before these are dropped.
+const batchWriterMaxAttempts = 3
+
+var bw *batchWriter
+
+// batchWriter buffers the device frame-log and metric writes and flushes
+// them periodically, or when the configured batch size has been reached.
+// Rows which fail to be written are retried on the next flush. When the
+// buffer is full, new rows are dropped.
+type batchWriter struct {
+	sync.Mutex
+
+	size        int
+	interval    time.Duration
+	maxBuffered int
+
+	count   int
+	frames  map[int64][]DeviceFrameLog
+	metrics map[int64][]DeviceMetric
+
+	retryCount int
+	retries    []batchWriterRetry
+
+	// dropped contains the number of rows dropped since the last flush
+	dropped int
+
+	flushChan chan struct{}
+	stopChan  chan struct{}
+	doneChan  chan struct{}
+}
+
+func setupBatchWriter(c config.Config) error {
+	conf := c.PostgreSQL.BatchWrites
+	if conf.Size <= 0 {
+		bw = nil
+		return nil
+	}
+
+	if conf.Interval <= 0 {
+		return errors.New("batch_writes.interval must be greater than 0")
+	}
+
+	maxBuffered := conf.MaxBuffered
+	if maxBuffered <= 0 {
+		maxBuffered = 10 * conf.Size
+	}
+	if maxBuffered < conf.Size {
+		return errors.New("batch_writes.max_buffered must be greater than or equal to batch_writes.size")
+	}
+
+	bw = &batchWriter{
+		size:        conf.Size,
+		interval:    conf.Interval,
+		maxBuffered: maxBuffered,
+		frames:      make(map[int64][]DeviceFrameLog),
+		metrics:     make(map[int64][]DeviceMetric),
+		flushChan:   make(chan struct{}, 1),
+		stopChan:    make(chan struct{}),
+		doneChan:    make(chan struct{}),
+	}
+	go bw.loop()
+
+	log.WithFields(log.Fields{
+		"size":         conf.Size,
+		"interval":     conf.Interval,
+		"max_buffered": maxBuffered,
+	}).Info("storage: batched writes enabled")
+
+	return nil
+}
+
+// WriteDeviceFrameLog stores the given frame for the given organization.
+// When batched writes are enabled, the frame is buffered and written on the
+// next flush, or dropped when the buffer is full.
+func WriteDeviceFrameLog(ctx context.Context, organizationID int64, fl DeviceFrameLog) error {
+	if bw == nil {
+		return ForOrganization(ctx, DB(), organizationID, func(db sqlx.Ext) error {
+			return CreateDeviceFrameLog(ctx, db, fl)
+		})
+	}
+
+	bw.Lock()
+	if bw.reserve(1) {
+		bw.frames[organizationID] = append(bw.frames[organizationID], fl)
+		bw.added(1)
+	}
+	bw.Unlock()
+
+	return nil
+}
+
+// WriteDeviceMetrics stores the given metrics for the given organization.
+// When batched writes are enabled, the metrics are buffered and written on
+// the next flush, or dropped when the buffer is full.
+func WriteDeviceMetrics(ctx context.Context, organizationID int64, metrics []DeviceMetric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	if bw == nil {
+		return ForOrganization(ctx, DB(), organizationID, func(db sqlx.Ext) error {
+			return CreateDeviceMetrics(ctx, db, metrics)
+		})
+	}
+
+	bw.Lock()
+	if bw.reserve(len(metrics)) {
+		bw.metrics[organizationID] = append(bw.metrics[organizationID], metrics...)
+		bw.added(len(metrics))
+	}
+	bw.Unlock()
+
+	return nil
+}
+
+// CloseBatchWriter stops the batch writer and flushes the buffered writes.
+// It is a no-op when batched writes are disabled.
+func CloseBatchWriter() {
+	if bw == nil {
+		return
+	}
+
+	close(bw.stopChan)
+	<-bw.doneChan
+}
+
+// reserve returns true when n rows can be added to the buffer. Otherwise the
+// rows are counted as dropped. This must be called with the lock held.
+func (w *batchWriter) reserve(n int) bool {
+	if w.count+w.retryCount+n <= w.maxBuffered {
+		return true
+	}
+
+	w.dropped += n
+	batchWriterDroppedCounter("buffer_full").Add(float64(n))
+	return false
+}
+
+// added must be called with the lock held.
+func (w *batchWriter) added(n int) {
+	w.count += n
+	if w.count >= w.size {
+		select {
+		case w.flushChan <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (w *batchWriter) loop() {
+	defer close(w.doneChan)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.flushChan:
+		case <-w.stopChan:
+			w.flush()
+			return
+		}
+
+		w.flush()
+	}
+}
+
+// batchWriterRetry contains the rows of an organization which failed to be
+// written.
+type batchWriterRetry struct {
+	organizationID int64
+	frames         []DeviceFrameLog
+	metrics        []DeviceMetric
+	attempts       int
+}
+
+func (r batchWriterRetry) count() int {
+	return len(r.frames) + len(r.metrics)
+}
+
+func (w *batchWriter) flush() {
+	w.Lock()
+	frames, metrics, retries, count, dropped := w.frames, w.metrics, w.retries, w.count+w.retryCount, w.dropped
+	w.frames = make(map[int64][]DeviceFrameLog)
+	w.metrics = make(map[int64][]DeviceMetric)
+	w.retries = nil
+	w.count = 0
+	w.retryCount = 0
+	w.dropped = 0
+	w.Unlock()
+
+	if dropped > 0 {
+		log.WithField("dropped", dropped).Warning("storage: batch writer buffer full, rows dropped")
+	}
+
+	if count == 0 {
+		return
+	}
+
+	start := time.Now()
+	ctx := context.Background()
+
+	for orgID, items := range frames {
+		retries = append(retries, batchWriterRetry{organizationID: orgID, frames: items})
+	}
+	for orgID, items := range metrics {
+		retries = append(retries, batchWriterRetry{organizationID: orgID, metrics: items})
+	}
+
+	for _, r := range retries {
+		if len(r.frames) != 0 {
+			err := ForOrganization(ctx, DB(), r.organizationID, func(db sqlx.Ext) error {
+				return CreateDeviceFrameLogs(ctx, db, r.frames)
+			})
+			if err != nil {
+				w.requeue(batchWriterRetry{organizationID: r.organizationID, frames: r.frames, attempts: r.attempts + 1}, err)
+			}
+		}
+
+		if len(r.metrics) != 0 {
+			err := ForOrganization(ctx, DB(), r.organizationID, func(db sqlx.Ext) error {
+				return CreateDeviceMetrics(ctx, db, r.metrics)
+			})
+			if err != nil {
+				w.requeue(batchWriterRetry{organizationID: r.organizationID, metrics: r.metrics, attempts: r.attempts + 1}, err)
+			}
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"count":    count,
+		"duration": time.Since(start),
+	}).Debug("storage: batched writes flushed")
+}
+
+// requeue adds the rows which failed to be written to the retries of the
+// next flush, or drops these when the max. number of attempts has been
+// reached.
+func (w *batchWriter) requeue(r batchWriterRetry, err error) {
+	fields := log.Fields{
+		"organization_id": r.organizationID,
+		"frames":          len(r.frames),
+		"metrics":         len(r.metrics),
+		"attempts":        r.attempts,
+	}
+
+	if r.attempts >= batchWriterMaxAttempts {
+		batchWriterDroppedCounter("write_error").Add(float64(r.count()))
+		log.WithError(err).WithFields(fields).Error("storage: batch write error, rows dropped")
+		return
+	}
+
+	w.Lock()
+	w.retries = append(w.retries, r)
+	w.retryCount += r.count()
+	w.Unlock()
+
+	log.WithError(err).WithFields(fields).Warning("storage: batch write error, rows will be retried")
+}
+
+// insertValues returns the values list of a multi-row insert statement for
+// the given number of rows and columns, e.g. ($1, $2), ($3, $4).
+func insertValues(rows, cols int) string {
+	var sb strings.Builder
+	for i := 0; i < rows; i++ {
+		if i != 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString("(")
+		for j := 0; j < cols; j++ {
+			if j != 0 {
+				sb.WriteString(", ")
+			}
+			fmt.Fprintf(&sb, "$%d", i*cols+j+1)
+		}
+		sb.WriteString(")")
+	}
+	return sb.String()
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	//"github.com/brocaar/lorawan"
+)
+
+func TestInsertValues(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("($1, $2, $3)", insertValues(1, 3))
+	assert.Equal("($1, $2), ($3, $4), ($5, $6)", insertValues(3, 2))
+}
+
+func TestBatchWriterLimits(t *testing.T) {
+	t.Run("Dropped when buffer is full", func(t *testing.T) {
+		assert := require.New(t)
+
+		w := batchWriter{
+			maxBuffered: 3,
+			count:       1,
+			retryCount:  1,
+		}
+		assert.True(w.reserve(1))
+		assert.False(w.reserve(2))
+		assert.Equal(2, w.dropped)
+	})
+
+	t.Run("Requeued until max. attempts", func(t *testing.T) {
+		assert := require.New(t)
+
+		var w batchWriter
+		r := batchWriterRetry{
+			organizationID: 1,
+			frames:         make([]DeviceFrameLog, 2),
+			attempts:       batchWriterMaxAttempts - 1,
+		}
+		w.requeue(r, errors.New("write error"))
+		assert.Len(w.retries, 1)
+		assert.Equal(2, w.retryCount)
+
+		r.attempts = batchWriterMaxAttempts
+		w.requeue(r, errors.New("write error"))
+		assert.Len(w.retries, 1)
+		assert.Equal(2, w.retryCount)
+	})
+}
+
+func (ts *StorageTestSuite) TestBatchWriter() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+	devEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	now := time.Now()
+
+	var conf config.Config
+	conf.PostgreSQL.BatchWrites.Size = 3
+	conf.PostgreSQL.BatchWrites.Interval = time.Hour
+	assert.NoError(setupBatchWriter(conf))
+	defer func() { bw = nil }()
+
+	ts.T().Run("Buffered until size is reached", func(t *testing.T) {
+		assert := require.New(t)
+
+		for i := 0; i < 2; i++ {
+			assert.NoError(WriteDeviceFrameLog(ctx, 1, DeviceFrameLog{
+				DevEUI:     devEUI,
+				ReceivedAt: now.Add(time.Duration(i) * time.Second),
+				FCnt:       uint32(i),
+			}))
+		}
+
+		logs, err := GetDeviceFrameLogs(ctx, DB(), devEUI, now.Add(-time.Minute), now.Add(time.Minute), 10)
+		assert.NoError(err)
+		assert.Len(logs, 0)
+
+		assert.NoError(WriteDeviceMetrics(ctx, 1, []DeviceMetric{
+			{DevEUI: devEUI, Time: now, Name: "temperature", Value: 21.5},
+		}))
+
+		assert.Eventually(func() bool {
+			logs, err := GetDeviceFrameLogs(ctx, DB(), devEUI, now.Add(-time.Minute), now.Add(time.Minute), 10)
+			return err == nil && len(logs) == 2
+		}, time.Second, 10*time.Millisecond)
+
+		assert.Eventually(func() bool {
+			metrics, err := GetDeviceMetrics(ctx, DB(), devEUI, "temperature", now.Add(-time.Minute), now.Add(time.Minute))
+			return err == nil && len(metrics) == 1
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	ts.T().Run("Flushed on close", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(WriteDeviceMetrics(ctx, 1, []DeviceMetric{
+			{DevEUI: devEUI, Time: now, Name: "humidity", Value: 60},
+		}))
+
+		CloseBatchWriter()
+
+		metrics, err := GetDeviceMetrics(ctx, DB(), devEUI, "humidity", now.Add(-time.Minute), now.Add(time.Minute))
+		assert.NoError(err)
+		assert.Len(metrics, 1)
+	})
+}
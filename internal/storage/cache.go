@@ -0,0 +1,306 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	//"github.com/brocaar/lorawan"
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+)
+
+// cacheInvalidatePubSubKey defines the Redis pub/sub channel used to notify
+// all application-server instances about invalidated cache keys.
+const cacheInvalidatePubSubKey = "lora:as:cache:invalidate"
+
+const (
+	deviceCacheKeyTempl        = "device:%s"
+	deviceProfileCacheKeyTempl = "dp:%s"
+	applicationCacheKeyTempl   = "app:%d"
+	codecCacheKeyTempl         = "codec:%s"
+)
+
+// cacheInvalidateHoldOff defines the duration during which an invalidated
+// item is not cached again. This prevents that an item read by a concurrent
+// lookup before the update has been committed is cached until the TTL.
+const cacheInvalidateHoldOff = 5 * time.Second
+
+// localCache holds the cached device, device-profile, application and
+// codec lookups. It is nil when the cache is disabled.
+var localCache *lruCache
+
+// lruCache implements a size-bounded least recently used cache, of which
+// the items expire after the configured TTL.
+type lruCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	holdOff time.Duration
+	ll      *list.List
+	items   map[string]*list.Element
+
+	// invalidated holds the time until which the invalidated keys must not
+	// be cached again.
+	invalidated map[string]time.Time
+}
+
+type lruCacheEntry struct {
+	key       string
+	value     interface{}
+	expiresAt time.Time
+}
+
+func newLRUCache(size int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		size:        size,
+		ttl:         ttl,
+		ll:          list.New(),
+		items:       make(map[string]*list.Element),
+		invalidated: make(map[string]time.Time),
+	}
+}
+
+// get returns the value for the given key, if present and not expired.
+func (c *lruCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := el.Value.(*lruCacheEntry)
+	if c.ttl != 0 && time.Now().After(entry.expiresAt) {
+		c.removeElement(el)
+		return nil, false
+	}
+
+	c.ll.MoveToFront(el)
+	return entry.value, true
+}
+
+// set stores the given value, evicting the least recently used item when
+// the cache is full. The value is not stored when the key has been
+// invalidated within the hold-off duration.
+func (c *lruCache) set(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if until, ok := c.invalidated[key]; ok {
+		if now.Before(until) {
+			return
+		}
+		delete(c.invalidated, key)
+	}
+
+	expiresAt := now.Add(c.ttl)
+
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*lruCacheEntry)
+		entry.value = value
+		entry.expiresAt = expiresAt
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&lruCacheEntry{
+		key:       key,
+		value:     value,
+		expiresAt: expiresAt,
+	})
+
+	for c.ll.Len() > c.size {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// remove removes the given key from the cache and holds off caching it
+// again.
+func (c *lruCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+
+	if c.holdOff == 0 {
+		return
+	}
+
+	now := time.Now()
+	c.invalidated[key] = now.Add(c.holdOff)
+
+	// the keys which are not looked up again are removed once the
+	// number of invalidated keys exceeds the size of the cache
+	if len(c.invalidated) > c.size {
+		for k, until := range c.invalidated {
+			if now.After(until) {
+				delete(c.invalidated, k)
+			}
+		}
+	}
+}
+
+// len returns the number of cached items.
+func (c *lruCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// getCached returns the cached value for the given key and counts the
+// lookup as hit or miss for the given kind of item.
+func getCached(kind, key string) (interface{}, bool) {
+	v, ok := localCache.get(key)
+	if ok {
+		cacheLookupCounter(kind, "hit").Inc()
+	} else {
+		cacheLookupCounter(kind, "miss").Inc()
+	}
+	return v, ok
+}
+
+func (c *lruCache) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*lruCacheEntry).key)
+}
+
+// setupCache configures the local cache and subscribes to the cache
+// invalidation channel. This must be called after the Redis client has been
+// setup.
+func setupCache(c config.Config) error {
+	conf := c.ApplicationServer.Cache
+	if conf.Size <= 0 {
+		localCache = nil
+		return nil
+	}
+
+	localCache = newLRUCache(conf.Size, conf.TTL)
+	localCache.holdOff = cacheInvalidateHoldOff
+
+	sub := RedisClient().Subscribe(GetRedisKey(cacheInvalidatePubSubKey))
+	if _, err := sub.Receive(); err != nil {
+		return errors.Wrap(err, "subscribe error")
+	}
+
+	go func(cache *lruCache) {
+		for msg := range sub.Channel() {
+			cache.remove(msg.Payload)
+		}
+	}(localCache)
+
+	log.WithFields(log.Fields{
+		"size": conf.Size,
+		"ttl":  conf.TTL,
+	}).Info("storage: local cache enabled")
+
+	return nil
+}
+
+// invalidateCache removes the given key from the local cache and notifies
+// the other application-server instances to do the same. The key is not
+// cached again during the hold-off, as the invalidation is usually made
+// before the ongoing transaction has been committed. In case the transaction
+// takes longer, the TTL bounds the time an item might be stale.
+func invalidateCache(ctx context.Context, key string) {
+	if localCache == nil {
+		return
+	}
+
+	localCache.remove(key)
+
+	if err := RedisClient().Publish(GetRedisKey(cacheInvalidatePubSubKey), key).Err(); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"key":    key,
+			"ctx_id": ctx.Value(logging.ContextIDKey),
+		}).Error("storage: publish cache invalidation error")
+	}
+}
+
+// GetDeviceCached returns the device for the given DevEUI, using the local
+// cache when enabled. The returned device must not be modified and might
+// contain outdated last-seen and data-rate values.
+func GetDeviceCached(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64) (Device, error) {
+	if localCache == nil {
+		return GetDevice(ctx, db, devEUI, false, true)
+	}
+
+	key := fmt.Sprintf(deviceCacheKeyTempl, devEUI)
+	if v, ok := getCached("device", key); ok {
+		return v.(Device), nil
+	}
+
+	d, err := GetDevice(ctx, db, devEUI, false, true)
+	if err != nil {
+		return d, err
+	}
+	localCache.set(key, d)
+
+	return d, nil
+}
+
+// GetDeviceProfileCached returns the device-profile for the given ID, using
+// the local cache when enabled. Only the local device-profile data is
+// returned. The returned device-profile must not be modified.
+func GetDeviceProfileCached(ctx context.Context, db sqlx.Queryer, id uuid.UUID) (DeviceProfile, error) {
+	if localCache == nil {
+		return GetDeviceProfile(ctx, db, id, false, true)
+	}
+
+	key := fmt.Sprintf(deviceProfileCacheKeyTempl, id)
+	if v, ok := getCached("device_profile", key); ok {
+		return v.(DeviceProfile), nil
+	}
+
+	dp, err := GetDeviceProfile(ctx, db, id, false, true)
+	if err != nil {
+		return dp, err
+	}
+	localCache.set(key, dp)
+
+	return dp, nil
+}
+
+// GetApplicationCached returns the application for the given ID, using the
+// local cache when enabled. The returned application must not be modified.
+func GetApplicationCached(ctx context.Context, db sqlx.Queryer, id int64) (Application, error) {
+	if localCache == nil {
+		return GetApplication(ctx, db, id)
+	}
+
+	key := fmt.Sprintf(applicationCacheKeyTempl, id)
+	if v, ok := getCached("application", key); ok {
+		return v.(Application), nil
+	}
+
+	app, err := GetApplication(ctx, db, id)
+	if err != nil {
+		return app, err
+	}
+	localCache.set(key, app)
+
+	return app, nil
+}
+
+func invalidateDeviceCache(ctx context.Context, devEUI lorawan.EUI64) {
+	invalidateCache(ctx, fmt.Sprintf(deviceCacheKeyTempl, devEUI))
+}
+
+func invalidateDeviceProfileCache(ctx context.Context, id uuid.UUID) {
+	invalidateCache(ctx, fmt.Sprintf(deviceProfileCacheKeyTempl, id))
+}
+
+func invalidateApplicationCache(ctx context.Context, id int64) {
+	invalidateCache(ctx, fmt.Sprintf(applicationCacheKeyTempl, id))
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRUCache(t *testing.T) {
+	t.Run("Evict least recently used", func(t *testing.T) {
+		assert := require.New(t)
+		c := newLRUCache(2, time.Minute)
+
+		c.set("a", 1)
+		c.set("b", 2)
+
+		// a is now the most recently used item
+		_, ok := c.get("a")
+		assert.True(ok)
+
+		c.set("c", 3)
+		assert.Equal(2, c.len())
+
+		_, ok = c.get("b")
+		assert.False(ok)
+
+		v, ok := c.get("a")
+		assert.True(ok)
+		assert.Equal(1, v)
+
+		v, ok = c.get("c")
+		assert.True(ok)
+		assert.Equal(3, v)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		assert := require.New(t)
+		c := newLRUCache(2, time.Minute)
+
+		c.set("a", 1)
+		c.set("a", 2)
+		assert.Equal(1, c.len())
+
+		v, ok := c.get("a")
+		assert.True(ok)
+		assert.Equal(2, v)
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		assert := require.New(t)
+		c := newLRUCache(2, time.Minute)
+
+		c.set("a", 1)
+		c.remove("a")
+		c.remove("b")
+
+		_, ok := c.get("a")
+		assert.False(ok)
+		assert.Equal(0, c.len())
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		assert := require.New(t)
+		c := newLRUCache(2, time.Millisecond)
+
+		c.set("a", 1)
+		time.Sleep(5 * time.Millisecond)
+
+		_, ok := c.get("a")
+		assert.False(ok)
+		assert.Equal(0, c.len())
+	})
+
+	t.Run("Hold-off after invalidation", func(t *testing.T) {
+		assert := require.New(t)
+		c := newLRUCache(2, time.Minute)
+		c.holdOff = 10 * time.Millisecond
+
+		c.set("a", 1)
+		c.remove("a")
+
+		// a value read before the update has been committed is not cached
+		c.set("a", 1)
+		_, ok := c.get("a")
+		assert.False(ok)
+
+		time.Sleep(20 * time.Millisecond)
+
+		c.set("a", 2)
+		v, ok := c.get("a")
+		assert.True(ok)
+		assert.Equal(2, v)
+		assert.Len(c.invalidated, 0)
+	})
+
+	t.Run("Expired invalidations are removed", func(t *testing.T) {
+		assert := require.New(t)
+		c := newLRUCache(2, time.Minute)
+		c.holdOff = time.Millisecond
+
+		c.remove("a")
+		c.remove("b")
+		time.Sleep(5 * time.Millisecond)
+		c.remove("c")
+
+		assert.Len(c.invalidated, 1)
+	})
+}
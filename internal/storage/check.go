@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+// CheckPostgreSQL validates the PostgreSQL configuration and tests the
+// connection to the database once. Unlike SetupPostgreSQL, this does not
+// retry and does not alter the package state.
+func CheckPostgreSQL(ctx context.Context, c config.Config) error {
+	if c.PostgreSQL.DSN == "" {
+		return errors.New("postgresql.dsn must be set")
+	}
+
+	dsn, dialHost, err := postgreSQLConnectionDSN(c)
+	if err != nil {
+		return err
+	}
+
+	d, err := openPostgreSQL(dsn, dialHost)
+	if err != nil {
+		return errors.Wrap(err, "open postgresql connection error")
+	}
+	defer d.Close()
+
+	if err := d.PingContext(ctx); err != nil {
+		return errors.Wrap(err, "ping postgresql error")
+	}
+
+	return nil
+}
+
+// CheckRedis validates the Redis configuration and tests the connection to
+// Redis once. Unlike Setup, this does not alter the package state.
+func CheckRedis(ctx context.Context, c config.Config) error {
+	if len(c.Redis.Servers) == 0 {
+		return errors.New("at least one redis server must be configured")
+	}
+
+	client, err := newRedisClient(c)
+	if err != nil {
+		return errors.Wrap(err, "redis tls config error")
+	}
+	defer client.Close()
+
+	if err := client.DoContext(ctx, "ping").Err(); err != nil {
+		return errors.Wrap(err, "ping redis error")
+	}
+
+	return nil
+}
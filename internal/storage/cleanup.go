@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	//"github.com/brocaar/lorawan"
+)
+
+// GetInactiveDevices returns the DevEUIs of the devices which have not been
+// seen since the given timestamp. Devices which have never been seen are
+// returned when these have been created before the given timestamp.
+func GetInactiveDevices(ctx context.Context, db sqlx.Queryer, before time.Time) ([]lorawan.EUI64, error) {
+	defer observeQueryDuration("device_inactive_list", time.Now())
+
+	var out []lorawan.EUI64
+	err := sqlx.Select(db, &out, `
+		select
+			dev_eui
+		from
+			device
+		where
+			coalesce(last_seen_at, created_at) < $1
+		order by
+			dev_eui`,
+		before,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetDevicesWithDeviceQueueItemData returns the DevEUIs of the devices for
+// which device-queue item objects and / or expiries are stored.
+func GetDevicesWithDeviceQueueItemData(ctx context.Context, db sqlx.Queryer) ([]lorawan.EUI64, error) {
+	var out []lorawan.EUI64
+	err := sqlx.Select(db, &out, `
+		select dev_eui from device_queue_item_object
+		union
+		select dev_eui from device_queue_item_expiry
+		order by
+			dev_eui`,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// DeleteDeviceQueueItemDataExcept deletes the device-queue item objects and
+// expiries of the given device, except for the given frame-counters (the
+// items which are still in the device-queue). It returns the number of
+// deleted rows.
+func DeleteDeviceQueueItemDataExcept(ctx context.Context, db sqlx.Execer, devEUI lorawan.EUI64, fCnts []uint32) (int64, error) {
+	fc := make([]int64, 0, len(fCnts))
+	for _, f := range fCnts {
+		fc = append(fc, int64(f))
+	}
+
+	var count int64
+	for _, table := range []string{"device_queue_item_object", "device_queue_item_expiry"} {
+		res, err := db.Exec(`
+			delete from `+table+`
+			where
+				dev_eui = $1
+				and not f_cnt = any($2)`,
+			devEUI[:],
+			pq.Array(fc),
+		)
+		if err != nil {
+			return 0, handlePSQLError(Delete, err, "delete error")
+		}
+		ra, err := res.RowsAffected()
+		if err != nil {
+			return 0, errors.Wrap(err, "get rows affected error")
+		}
+		count += ra
+	}
+
+	return count, nil
+}
+
+// DeleteDeviceSessionsBefore deletes the device-sessions created before the
+// given timestamp. The last session of each device is never deleted, as
+// this is the active session. It returns the number of deleted sessions.
+func DeleteDeviceSessionsBefore(ctx context.Context, db sqlx.Execer, before time.Time) (int64, error) {
+	res, err := db.Exec(`
+		delete from device_session ds
+		where
+			ds.created_at < $1
+			and exists (
+				select
+					1
+				from
+					device_session l
+				where
+					l.dev_eui = ds.dev_eui
+					and l.id > ds.id
+			)`,
+		before,
+	)
+	if err != nil {
+		return 0, handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "get rows affected error")
+	}
+
+	return ra, nil
+}
+
+// DeleteExpiredRemoteMulticastClassCSessions deletes the remote multicast
+// Class-C sessions which ended before the given timestamp. A session ends
+// 2^SessionTimeOut seconds after the session start. It returns the number
+// of deleted sessions.
+func DeleteExpiredRemoteMulticastClassCSessions(ctx context.Context, db sqlx.Execer, before time.Time) (int64, error) {
+	res, err := db.Exec(`
+		delete from remote_multicast_class_c_session
+		where
+			session_time + interval '1 second' * power(2, session_time_out) < $1`,
+		before,
+	)
+	if err != nil {
+		return 0, handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "get rows affected error")
+	}
+
+	return ra, nil
+}
+
+// DeleteAllDeviceFrameLogsBefore deletes the frame-logs of all devices
+// received before the given timestamp. It returns the number of deleted
+// rows.
+func DeleteAllDeviceFrameLogsBefore(ctx context.Context, db sqlx.Execer, before time.Time) (int64, error) {
+	res, err := db.Exec(`
+		delete from device_frame_log
+		where
+			received_at < $1`,
+		before,
+	)
+	if err != nil {
+		return 0, handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "get rows affected error")
+	}
+
+	return ra, nil
+}
+
+// DeleteAllDeviceMetricsBefore deletes the metrics of all devices before
+// the given timestamp. It returns the number of deleted rows.
+func DeleteAllDeviceMetricsBefore(ctx context.Context, db sqlx.Execer, before time.Time) (int64, error) {
+	res, err := db.Exec(`
+		delete from device_metric
+		where
+			time < $1`,
+		before,
+	)
+	if err != nil {
+		return 0, handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "get rows affected error")
+	}
+
+	return ra, nil
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	uuid "github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	nsmock "github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestCleanup() {
+	assert := require.New(ts.T())
+
+	nsClient := nsmock.NewClient()
+	networkserver.SetPool(nsmock.NewPool(nsClient))
+
+	n := NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(CreateNetworkServer(context.Background(), ts.tx, &n))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(context.Background(), ts.tx, &org))
+
+	sp := ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateServiceProfile(context.Background(), ts.tx, &sp))
+	var spID uuid.UUID
+	copy(spID[:], sp.ServiceProfile.Id)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(context.Background(), ts.tx, &app))
+
+	dp := DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateDeviceProfile(context.Background(), ts.tx, &dp))
+	var dpID uuid.UUID
+	copy(dpID[:], dp.DeviceProfile.Id)
+
+	lastSeen := time.Now().Add(-48 * time.Hour)
+	devices := []Device{
+		{DevEUI: lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}, Name: "active"},
+		{DevEUI: lorawan.EUI64{2, 2, 3, 4, 5, 6, 7, 8}, Name: "inactive", LastSeenAt: &lastSeen},
+	}
+	for i := range devices {
+		devices[i].ApplicationID = app.ID
+		devices[i].DeviceProfileID = dpID
+		assert.NoError(CreateDevice(context.Background(), ts.tx, &devices[i]))
+	}
+	assert.NoError(UpdateDeviceLastSeenAndDR(context.Background(), ts.tx, devices[0].DevEUI, time.Now(), 3))
+
+	ts.T().Run("GetInactiveDevices", func(t *testing.T) {
+		assert := require.New(t)
+
+		euis, err := GetInactiveDevices(context.Background(), ts.tx, time.Now().Add(-24*time.Hour))
+		assert.NoError(err)
+		assert.Equal([]lorawan.EUI64{devices[1].DevEUI}, euis)
+	})
+
+	ts.T().Run("DeleteDeviceQueueItemDataExcept", func(t *testing.T) {
+		assert := require.New(t)
+
+		for _, fCnt := range []uint32{1, 2, 3} {
+			assert.NoError(CreateDeviceQueueItemObject(context.Background(), ts.tx, &DeviceQueueItemObject{
+				DevEUI: devices[0].DevEUI,
+				FCnt:   fCnt,
+				FPort:  10,
+				Object: json.RawMessage(`{"on": true}`),
+			}))
+		}
+		assert.NoError(CreateDeviceQueueItemExpiry(context.Background(), ts.tx, &DeviceQueueItemExpiry{
+			DevEUI:    devices[0].DevEUI,
+			FCnt:      1,
+			FPort:     10,
+			ExpiresAt: time.Now(),
+		}))
+
+		euis, err := GetDevicesWithDeviceQueueItemData(context.Background(), ts.tx)
+		assert.NoError(err)
+		assert.Equal([]lorawan.EUI64{devices[0].DevEUI}, euis)
+
+		count, err := DeleteDeviceQueueItemDataExcept(context.Background(), ts.tx, devices[0].DevEUI, []uint32{3})
+		assert.NoError(err)
+		assert.EqualValues(3, count)
+
+		objects, err := GetDeviceQueueItemObjects(context.Background(), ts.tx, devices[0].DevEUI)
+		assert.NoError(err)
+		assert.Len(objects, 1)
+		assert.EqualValues(3, objects[0].FCnt)
+
+		count, err = DeleteDeviceQueueItemDataExcept(context.Background(), ts.tx, devices[0].DevEUI, nil)
+		assert.NoError(err)
+		assert.EqualValues(1, count)
+	})
+
+	ts.T().Run("DeleteDeviceSessionsBefore", func(t *testing.T) {
+		assert := require.New(t)
+
+		for i := 0; i < 3; i++ {
+			assert.NoError(CreateDeviceSession(context.Background(), ts.tx, &DeviceSession{
+				DevEUI:     devices[0].DevEUI,
+				JoinType:   JoinTypeJoin,
+				SenderID:   "010203",
+				MACVersion: "1.0.3",
+				DevNonce:   i,
+			}))
+		}
+
+		count, err := DeleteDeviceSessionsBefore(context.Background(), ts.tx, time.Now().Add(-time.Hour))
+		assert.NoError(err)
+		assert.EqualValues(0, count)
+
+		count, err = DeleteDeviceSessionsBefore(context.Background(), ts.tx, time.Now().Add(time.Hour))
+		assert.NoError(err)
+		assert.EqualValues(2, count)
+
+		sessions, err := GetDeviceSessions(context.Background(), ts.tx, devices[0].DevEUI, 10)
+		assert.NoError(err)
+		assert.Len(sessions, 1)
+		assert.Equal(2, sessions[0].DevNonce)
+	})
+
+	ts.T().Run("DeleteAllDeviceFrameLogsAndMetricsBefore", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := DeleteAllDeviceFrameLogsBefore(context.Background(), ts.tx, time.Now())
+		assert.NoError(err)
+
+		_, err = DeleteAllDeviceMetricsBefore(context.Background(), ts.tx, time.Now())
+		assert.NoError(err)
+	})
+}
@@ -0,0 +1,457 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/codec"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+)
+
+// Codec defines a named codec of the codec library. Device-profiles
+// referencing a codec use its current version, so that publishing a new
+// version (or rolling back to a previous one) applies to all of them.
+type Codec struct {
+	ID             uuid.UUID  `db:"id"`
+	OrganizationID int64      `db:"organization_id"`
+	CreatedAt      time.Time  `db:"created_at"`
+	UpdatedAt      time.Time  `db:"updated_at"`
+	Name           string     `db:"name"`
+	Description    string     `db:"description"`
+	PayloadCodec   codec.Type `db:"payload_codec"`
+	CurrentVersion int        `db:"current_version"`
+	LatestVersion  int        `db:"latest_version"`
+}
+
+// Validate validates the codec data.
+func (c Codec) Validate() error {
+	if strings.TrimSpace(c.Name) == "" || len(c.Name) > 100 {
+		return ErrCodecInvalidName
+	}
+
+	if c.PayloadCodec == codec.None {
+		return ErrDeviceInvalidPayloadCodec
+	}
+	return DeviceCodec{Type: c.PayloadCodec}.Validate()
+}
+
+// CodecVersion defines a published version of a codec.
+type CodecVersion struct {
+	CodecID       uuid.UUID `db:"codec_id"`
+	Version       int       `db:"version"`
+	CreatedAt     time.Time `db:"created_at"`
+	Description   string    `db:"description"`
+	EncoderScript string    `db:"encoder_script"`
+	DecoderScript string    `db:"decoder_script"`
+}
+
+// CreateCodec creates the given codec. The codec does not have a version
+// until the first version has been published.
+func CreateCodec(ctx context.Context, db sqlx.Execer, c *Codec) error {
+	defer observeQueryDuration("codec_create", time.Now())
+
+	if err := c.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	var err error
+	c.ID, err = uuid.NewV4()
+	if err != nil {
+		return errors.Wrap(err, "new uuid v4 error")
+	}
+
+	now := time.Now()
+	c.CreatedAt = now
+	c.UpdatedAt = now
+	c.CurrentVersion = 0
+	c.LatestVersion = 0
+
+	_, err = db.Exec(`
+		insert into codec (
+			id,
+			organization_id,
+			created_at,
+			updated_at,
+			name,
+			description,
+			payload_codec,
+			current_version,
+			latest_version
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		c.ID,
+		c.OrganizationID,
+		c.CreatedAt,
+		c.UpdatedAt,
+		c.Name,
+		c.Description,
+		c.PayloadCodec,
+		c.CurrentVersion,
+		c.LatestVersion,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"id":              c.ID,
+		"organization_id": c.OrganizationID,
+		"ctx_id":          ctx.Value(logging.ContextIDKey),
+	}).Info("codec created")
+
+	return nil
+}
+
+// GetCodec returns the codec for the given id.
+func GetCodec(ctx context.Context, db sqlx.Queryer, id uuid.UUID) (Codec, error) {
+	defer observeQueryDuration("codec_get", time.Now())
+
+	var c Codec
+	if err := sqlx.Get(db, &c, "select * from codec where id = $1", id); err != nil {
+		return c, handlePSQLError(Select, err, "select error")
+	}
+
+	return c, nil
+}
+
+// GetCodecCount returns the number of codecs of the given organization.
+func GetCodecCount(ctx context.Context, db sqlx.Queryer, organizationID int64) (int, error) {
+	var count int
+	if err := sqlx.Get(db, &count, "select count(*) from codec where organization_id = $1", organizationID); err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	return count, nil
+}
+
+// GetCodecs returns a slice of codecs of the given organization, sorted by
+// name.
+func GetCodecs(ctx context.Context, db sqlx.Queryer, organizationID int64, limit, offset int) ([]Codec, error) {
+	var codecs []Codec
+	err := sqlx.Select(db, &codecs, `
+		select
+			*
+		from
+			codec
+		where
+			organization_id = $1
+		order by
+			name
+		limit $2
+		offset $3`,
+		organizationID,
+		limit,
+		offset,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return codecs, nil
+}
+
+// UpdateCodec updates the name, description and payload codec of the given
+// codec. The versions are managed using PublishCodecVersion and
+// RollbackCodec.
+func UpdateCodec(ctx context.Context, db sqlx.Execer, c *Codec) error {
+	defer observeQueryDuration("codec_update", time.Now())
+
+	if err := c.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	c.UpdatedAt = time.Now()
+
+	res, err := db.Exec(`
+		update codec
+		set
+			updated_at = $2,
+			name = $3,
+			description = $4,
+			payload_codec = $5
+		where
+			id = $1`,
+		c.ID,
+		c.UpdatedAt,
+		c.Name,
+		c.Description,
+		c.PayloadCodec,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	invalidateCodecCache(ctx, c.ID)
+
+	log.WithFields(log.Fields{
+		"id":     c.ID,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("codec updated")
+
+	return nil
+}
+
+// DeleteCodec deletes the codec and all its versions. A codec which is
+// still referenced by device-profiles can not be deleted.
+func DeleteCodec(ctx context.Context, db sqlx.Execer, id uuid.UUID) error {
+	defer observeQueryDuration("codec_delete", time.Now())
+
+	res, err := db.Exec("delete from codec where id = $1", id)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	invalidateCodecCache(ctx, id)
+
+	log.WithFields(log.Fields{
+		"id":     id,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("codec deleted")
+
+	return nil
+}
+
+// PublishCodecVersion publishes the given scripts as the next version of
+// the codec, which becomes the current version. The version number is set
+// on the given CodecVersion.
+func PublishCodecVersion(ctx context.Context, db sqlx.Queryer, v *CodecVersion) error {
+	defer observeQueryDuration("codec_version_publish", time.Now())
+
+	v.CreatedAt = time.Now()
+
+	// the update locks the codec row, so that concurrent publishes get
+	// consecutive version numbers
+	err := sqlx.Get(db, &v.Version, `
+		with c as (
+			update codec
+			set
+				updated_at = $2,
+				latest_version = latest_version + 1,
+				current_version = latest_version + 1
+			where
+				id = $1
+			returning latest_version
+		)
+		insert into codec_version (
+			codec_id,
+			version,
+			created_at,
+			description,
+			encoder_script,
+			decoder_script
+		)
+		select $1, c.latest_version, $2, $3, $4, $5 from c
+		returning version`,
+		v.CodecID,
+		v.CreatedAt,
+		v.Description,
+		v.EncoderScript,
+		v.DecoderScript,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	invalidateCodecCache(ctx, v.CodecID)
+
+	log.WithFields(log.Fields{
+		"codec_id": v.CodecID,
+		"version":  v.Version,
+		"ctx_id":   ctx.Value(logging.ContextIDKey),
+	}).Info("codec version published")
+
+	return nil
+}
+
+// RollbackCodec sets the current version of the codec to the given
+// (previously published) version.
+func RollbackCodec(ctx context.Context, db sqlx.Execer, codecID uuid.UUID, version int) error {
+	defer observeQueryDuration("codec_rollback", time.Now())
+
+	res, err := db.Exec(`
+		update codec
+		set
+			updated_at = $3,
+			current_version = $2
+		where
+			id = $1
+			and exists (
+				select 1 from codec_version where codec_id = $1 and version = $2
+			)`,
+		codecID,
+		version,
+		time.Now(),
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	invalidateCodecCache(ctx, codecID)
+
+	log.WithFields(log.Fields{
+		"codec_id": codecID,
+		"version":  version,
+		"ctx_id":   ctx.Value(logging.ContextIDKey),
+	}).Info("codec rolled back")
+
+	return nil
+}
+
+// GetCodecVersion returns the given version of the codec.
+func GetCodecVersion(ctx context.Context, db sqlx.Queryer, codecID uuid.UUID, version int) (CodecVersion, error) {
+	var v CodecVersion
+	err := sqlx.Get(db, &v, "select * from codec_version where codec_id = $1 and version = $2", codecID, version)
+	if err != nil {
+		return v, handlePSQLError(Select, err, "select error")
+	}
+
+	return v, nil
+}
+
+// GetCodecVersions returns the versions of the codec, newest first.
+func GetCodecVersions(ctx context.Context, db sqlx.Queryer, codecID uuid.UUID) ([]CodecVersion, error) {
+	var versions []CodecVersion
+	err := sqlx.Select(db, &versions, `
+		select
+			*
+		from
+			codec_version
+		where
+			codec_id = $1
+		order by
+			version desc`,
+		codecID,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return versions, nil
+}
+
+// UpdateDeviceProfileCodecID sets the codec of the codec library which must
+// be used by the given device-profile. A nil codec id removes the reference,
+// in which case the scripts of the device-profile itself are used.
+func UpdateDeviceProfileCodecID(ctx context.Context, db sqlx.Execer, deviceProfileID uuid.UUID, codecID *uuid.UUID) error {
+	defer observeQueryDuration("device_profile_codec_id_update", time.Now())
+
+	res, err := db.Exec(`
+		update device_profile
+		set
+			updated_at = $2,
+			codec_id = $3
+		where
+			device_profile_id = $1`,
+		deviceProfileID,
+		time.Now(),
+		codecID,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	invalidateDeviceProfileCache(ctx, deviceProfileID)
+
+	log.WithFields(log.Fields{
+		"device_profile_id": deviceProfileID,
+		"codec_id":          codecID,
+		"ctx_id":            ctx.Value(logging.ContextIDKey),
+	}).Info("device-profile codec updated")
+
+	return nil
+}
+
+// ResolvePayloadCodec returns the codec which must be used for the given
+// device. It is equal to GetPayloadCodec, except that when the
+// device-profile references a codec of the codec library, the current
+// version of that codec is used instead of the device-profile scripts.
+// A device codec override still takes precedence. When the referenced
+// codec has no published version yet, the device-profile scripts are used.
+func ResolvePayloadCodec(ctx context.Context, db sqlx.Queryer, app Application, dp DeviceProfile, d Device) (DeviceCodec, error) {
+	if d.PayloadCodec != codec.None || dp.CodecID == nil {
+		return GetPayloadCodec(app, dp, d), nil
+	}
+
+	c, err := getLibraryCodecCached(ctx, db, *dp.CodecID)
+	if err != nil {
+		if errors.Cause(err) == ErrDoesNotExist {
+			return GetPayloadCodec(app, dp, d), nil
+		}
+		return DeviceCodec{}, errors.Wrap(err, "get codec error")
+	}
+
+	return c, nil
+}
+
+// getLibraryCodecCached returns the current version of the given codec as
+// DeviceCodec, using the local cache when enabled.
+func getLibraryCodecCached(ctx context.Context, db sqlx.Queryer, id uuid.UUID) (DeviceCodec, error) {
+	key := fmt.Sprintf(codecCacheKeyTempl, id)
+	if localCache != nil {
+		if v, ok := getCached("codec", key); ok {
+			return v.(DeviceCodec), nil
+		}
+	}
+
+	var c DeviceCodec
+	err := db.QueryRowx(`
+		select
+			c.payload_codec,
+			v.encoder_script,
+			v.decoder_script
+		from
+			codec c
+		inner join codec_version v
+			on v.codec_id = c.id and v.version = c.current_version
+		where
+			c.id = $1`,
+		id,
+	).Scan(&c.Type, &c.EncoderScript, &c.DecoderScript)
+	if err != nil {
+		return c, handlePSQLError(Select, err, "select error")
+	}
+
+	if localCache != nil {
+		localCache.set(key, c)
+	}
+
+	return c, nil
+}
+
+func invalidateCodecCache(ctx context.Context, id uuid.UUID) {
+	invalidateCache(ctx, fmt.Sprintf(codecCacheKeyTempl, id))
+}
@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/codec"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestCodecLibrary() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(context.Background(), ts.Tx(), &org))
+
+	n := NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(CreateNetworkServer(context.Background(), ts.Tx(), &n))
+
+	sp := ServiceProfile{
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+		Name:            "test-sp",
+	}
+	assert.NoError(CreateServiceProfile(context.Background(), ts.Tx(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	dp := DeviceProfile{
+		NetworkServerID:      n.ID,
+		OrganizationID:       org.ID,
+		Name:                 "test-dp",
+		PayloadCodec:         codec.CustomJSType,
+		PayloadDecoderScript: "dp-decoder",
+	}
+	assert.NoError(CreateDeviceProfile(context.Background(), ts.Tx(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(context.Background(), ts.Tx(), &app))
+
+	d := Device{
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "test-device",
+	}
+	assert.NoError(CreateDevice(context.Background(), ts.Tx(), &d))
+
+	ts.T().Run("Create invalid", func(t *testing.T) {
+		assert := require.New(t)
+
+		c := Codec{
+			OrganizationID: org.ID,
+			PayloadCodec:   codec.CustomJSType,
+		}
+		assert.Equal(ErrCodecInvalidName, errors.Cause(CreateCodec(context.Background(), ts.Tx(), &c)))
+
+		c.Name = "test-codec"
+		c.PayloadCodec = codec.None
+		assert.Equal(ErrDeviceInvalidPayloadCodec, errors.Cause(CreateCodec(context.Background(), ts.Tx(), &c)))
+	})
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		c := Codec{
+			OrganizationID: org.ID,
+			Name:           "test-codec",
+			Description:    "test codec",
+			PayloadCodec:   codec.CustomJSType,
+		}
+		assert.NoError(CreateCodec(context.Background(), ts.Tx(), &c))
+		c.CreatedAt = c.CreatedAt.Round(time.Second).UTC()
+		c.UpdatedAt = c.UpdatedAt.Round(time.Second).UTC()
+
+		t.Run("Get", func(t *testing.T) {
+			assert := require.New(t)
+
+			cGet, err := GetCodec(context.Background(), ts.Tx(), c.ID)
+			assert.NoError(err)
+			cGet.CreatedAt = cGet.CreatedAt.Round(time.Second).UTC()
+			cGet.UpdatedAt = cGet.UpdatedAt.Round(time.Second).UTC()
+			assert.Equal(c, cGet)
+		})
+
+		t.Run("List", func(t *testing.T) {
+			assert := require.New(t)
+
+			count, err := GetCodecCount(context.Background(), ts.Tx(), org.ID)
+			assert.NoError(err)
+			assert.Equal(1, count)
+
+			codecs, err := GetCodecs(context.Background(), ts.Tx(), org.ID, 10, 0)
+			assert.NoError(err)
+			assert.Len(codecs, 1)
+			assert.Equal(c.ID, codecs[0].ID)
+		})
+
+		t.Run("Update", func(t *testing.T) {
+			assert := require.New(t)
+
+			c.Name = "test-codec-updated"
+			c.PayloadCodec = codec.CustomLuaType
+			assert.NoError(UpdateCodec(context.Background(), ts.Tx(), &c))
+
+			cGet, err := GetCodec(context.Background(), ts.Tx(), c.ID)
+			assert.NoError(err)
+			assert.Equal("test-codec-updated", cGet.Name)
+			assert.Equal(codec.CustomLuaType, cGet.PayloadCodec)
+		})
+
+		t.Run("No version uses device-profile scripts", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(UpdateDeviceProfileCodecID(context.Background(), ts.Tx(), dpID, &c.ID))
+			dp, err := GetDeviceProfile(context.Background(), ts.Tx(), dpID, false, true)
+			assert.NoError(err)
+			assert.Equal(&c.ID, dp.CodecID)
+
+			dc, err := ResolvePayloadCodec(context.Background(), ts.Tx(), app, dp, d)
+			assert.NoError(err)
+			assert.Equal(DeviceCodec{Type: codec.CustomJSType, DecoderScript: "dp-decoder"}, dc)
+		})
+
+		t.Run("Publish", func(t *testing.T) {
+			assert := require.New(t)
+
+			for i, script := range []string{"decoder-v1", "decoder-v2"} {
+				v := CodecVersion{
+					CodecID:       c.ID,
+					DecoderScript: script,
+				}
+				assert.NoError(PublishCodecVersion(context.Background(), ts.Tx(), &v))
+				assert.Equal(i+1, v.Version)
+			}
+
+			cGet, err := GetCodec(context.Background(), ts.Tx(), c.ID)
+			assert.NoError(err)
+			assert.Equal(2, cGet.CurrentVersion)
+			assert.Equal(2, cGet.LatestVersion)
+
+			versions, err := GetCodecVersions(context.Background(), ts.Tx(), c.ID)
+			assert.NoError(err)
+			assert.Len(versions, 2)
+			assert.Equal(2, versions[0].Version)
+			assert.Equal(1, versions[1].Version)
+
+			dp, err := GetDeviceProfile(context.Background(), ts.Tx(), dpID, false, true)
+			assert.NoError(err)
+			dc, err := ResolvePayloadCodec(context.Background(), ts.Tx(), app, dp, d)
+			assert.NoError(err)
+			assert.Equal(DeviceCodec{Type: codec.CustomLuaType, DecoderScript: "decoder-v2"}, dc)
+		})
+
+		t.Run("Rollback", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(RollbackCodec(context.Background(), ts.Tx(), c.ID, 1))
+			assert.Equal(ErrDoesNotExist, RollbackCodec(context.Background(), ts.Tx(), c.ID, 3))
+
+			dp, err := GetDeviceProfile(context.Background(), ts.Tx(), dpID, false, true)
+			assert.NoError(err)
+			dc, err := ResolvePayloadCodec(context.Background(), ts.Tx(), app, dp, d)
+			assert.NoError(err)
+			assert.Equal(DeviceCodec{Type: codec.CustomLuaType, DecoderScript: "decoder-v1"}, dc)
+
+			t.Run("Publish after rollback", func(t *testing.T) {
+				assert := require.New(t)
+
+				v := CodecVersion{
+					CodecID:       c.ID,
+					DecoderScript: "decoder-v3",
+				}
+				assert.NoError(PublishCodecVersion(context.Background(), ts.Tx(), &v))
+				assert.Equal(3, v.Version)
+			})
+		})
+
+		t.Run("Device override", func(t *testing.T) {
+			assert := require.New(t)
+
+			dp, err := GetDeviceProfile(context.Background(), ts.Tx(), dpID, false, true)
+			assert.NoError(err)
+
+			d := d
+			d.PayloadCodec = codec.CayenneLPPType
+			dc, err := ResolvePayloadCodec(context.Background(), ts.Tx(), app, dp, d)
+			assert.NoError(err)
+			assert.Equal(DeviceCodec{Type: codec.CayenneLPPType}, dc)
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(UpdateDeviceProfileCodecID(context.Background(), ts.Tx(), dpID, nil))
+			assert.NoError(DeleteCodec(context.Background(), ts.Tx(), c.ID))
+
+			_, err := GetCodec(context.Background(), ts.Tx(), c.ID)
+			assert.Equal(ErrDoesNotExist, err)
+
+			_, err = GetCodecVersions(context.Background(), ts.Tx(), c.ID)
+			assert.NoError(err)
+		})
+	})
+}
@@ -0,0 +1,324 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+// ConfirmedDownlinkRetryPolicy defines the retry policy for confirmed
+// downlinks which were not acknowledged by the device.
+type ConfirmedDownlinkRetryPolicy struct {
+	// MaxRetries defines the max. number of retries (0 = disabled).
+	MaxRetries int `json:"maxRetries"`
+
+	// BackoffFrames defines the number of uplinks to wait for before the
+	// downlink is re-enqueued.
+	BackoffFrames int `json:"backoffFrames"`
+
+	// BackoffSeconds defines the time to wait before the downlink is
+	// re-enqueued.
+	BackoffSeconds int `json:"backoffSeconds"`
+}
+
+// Validate validates the retry policy.
+func (p ConfirmedDownlinkRetryPolicy) Validate() error {
+	if p.MaxRetries < 0 || p.MaxRetries > 100 {
+		return errors.New("maxRetries must be between 0 and 100")
+	}
+	if p.BackoffFrames < 0 {
+		return errors.New("backoffFrames must be greater than or equal to 0")
+	}
+	if p.BackoffSeconds < 0 {
+		return errors.New("backoffSeconds must be greater than or equal to 0")
+	}
+	return nil
+}
+
+// Value implements the driver.Valuer interface.
+func (p ConfirmedDownlinkRetryPolicy) Value() (driver.Value, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (p *ConfirmedDownlinkRetryPolicy) Scan(src interface{}) error {
+	var b []byte
+	switch src := src.(type) {
+	case []byte:
+		b = src
+	case string:
+		b = []byte(src)
+	case nil:
+		*p = ConfirmedDownlinkRetryPolicy{}
+		return nil
+	default:
+		return fmt.Errorf("expected []byte, got %T", src)
+	}
+
+	return json.Unmarshal(b, p)
+}
+
+// ConfirmedDownlink defines a confirmed downlink which is awaiting its
+// acknowledgement, or which is scheduled for retry when RetryAfter is set.
+type ConfirmedDownlink struct {
+	DevEUI       lorawan.EUI64 `db:"dev_eui"`
+	FCnt         uint32        `db:"f_cnt"`
+	CreatedAt    time.Time     `db:"created_at"`
+	UpdatedAt    time.Time     `db:"updated_at"`
+	FPort        uint8         `db:"f_port"`
+	Data         []byte        `db:"data"`
+	RetryCount   int           `db:"retry_count"`
+	RetryAfter   *time.Time    `db:"retry_after"`
+	RetryUplinks int           `db:"retry_uplinks"`
+}
+
+// CreateConfirmedDownlink creates the given confirmed downlink.
+func CreateConfirmedDownlink(ctx context.Context, db sqlx.Execer, cd *ConfirmedDownlink) error {
+	now := time.Now()
+	cd.CreatedAt = now
+	cd.UpdatedAt = now
+
+	_, err := db.Exec(`
+		insert into confirmed_downlink (
+			dev_eui,
+			f_cnt,
+			created_at,
+			updated_at,
+			f_port,
+			data,
+			retry_count,
+			retry_after,
+			retry_uplinks
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		cd.DevEUI[:],
+		cd.FCnt,
+		cd.CreatedAt,
+		cd.UpdatedAt,
+		cd.FPort,
+		cd.Data,
+		cd.RetryCount,
+		cd.RetryAfter,
+		cd.RetryUplinks,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui":     cd.DevEUI,
+		"f_cnt":       cd.FCnt,
+		"retry_count": cd.RetryCount,
+		"ctx_id":      ctx.Value(logging.ContextIDKey),
+	}).Info("confirmed downlink created")
+
+	return nil
+}
+
+// GetConfirmedDownlink returns the confirmed downlink for the given DevEUI
+// and frame-counter.
+func GetConfirmedDownlink(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64, fCnt uint32, forUpdate bool) (ConfirmedDownlink, error) {
+	var fu string
+	if forUpdate {
+		fu = " for update"
+	}
+
+	var cd ConfirmedDownlink
+	err := sqlx.Get(db, &cd, `
+		select
+			*
+		from
+			confirmed_downlink
+		where
+			dev_eui = $1
+			and f_cnt = $2`+fu,
+		devEUI[:],
+		fCnt,
+	)
+	if err != nil {
+		return cd, handlePSQLError(Select, err, "select error")
+	}
+
+	return cd, nil
+}
+
+// ScheduleConfirmedDownlinkRetry schedules the retry of the given confirmed
+// downlink, after the given number of uplinks and time.
+func ScheduleConfirmedDownlinkRetry(ctx context.Context, db sqlx.Execer, devEUI lorawan.EUI64, fCnt uint32, retryAfter time.Time, retryUplinks int) error {
+	res, err := db.Exec(`
+		update
+			confirmed_downlink
+		set
+			updated_at = $3,
+			retry_after = $4,
+			retry_uplinks = $5
+		where
+			dev_eui = $1
+			and f_cnt = $2`,
+		devEUI[:],
+		fCnt,
+		time.Now(),
+		retryAfter,
+		retryUplinks,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui":       devEUI,
+		"f_cnt":         fCnt,
+		"retry_after":   retryAfter,
+		"retry_uplinks": retryUplinks,
+		"ctx_id":        ctx.Value(logging.ContextIDKey),
+	}).Info("confirmed downlink retry scheduled")
+
+	return nil
+}
+
+// DecrementConfirmedDownlinkRetryUplinks decrements the number of uplinks to
+// wait for, of the confirmed downlinks of the given device which are
+// scheduled for retry. This must be called on each uplink.
+func DecrementConfirmedDownlinkRetryUplinks(ctx context.Context, db sqlx.Execer, devEUI lorawan.EUI64) error {
+	_, err := db.Exec(`
+		update
+			confirmed_downlink
+		set
+			retry_uplinks = retry_uplinks - 1
+		where
+			dev_eui = $1
+			and retry_after is not null
+			and retry_uplinks > 0`,
+		devEUI[:],
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+
+	return nil
+}
+
+// GetPendingConfirmedDownlinkRetries returns the confirmed downlinks for which
+// the backoff has passed. The returned records are locked, other
+// transactions skip these.
+func GetPendingConfirmedDownlinkRetries(ctx context.Context, db sqlx.Queryer, limit int) ([]ConfirmedDownlink, error) {
+	var items []ConfirmedDownlink
+	err := sqlx.Select(db, &items, `
+		select
+			*
+		from
+			confirmed_downlink
+		where
+			retry_after <= $1
+			and retry_uplinks = 0
+		order by
+			retry_after
+		limit $2
+		for update
+		skip locked`,
+		time.Now(),
+		limit,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return items, nil
+}
+
+// DeleteConfirmedDownlink deletes the confirmed downlink for the given DevEUI
+// and frame-counter.
+func DeleteConfirmedDownlink(ctx context.Context, db sqlx.Execer, devEUI lorawan.EUI64, fCnt uint32) error {
+	res, err := db.Exec(`
+		delete from
+			confirmed_downlink
+		where
+			dev_eui = $1
+			and f_cnt = $2`,
+		devEUI[:],
+		fCnt,
+	)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": devEUI,
+		"f_cnt":   fCnt,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("confirmed downlink deleted")
+
+	return nil
+}
+
+// DeleteConfirmedDownlinksForDevice deletes all the confirmed downlinks of
+// the given device, e.g. when the device-queue is flushed.
+func DeleteConfirmedDownlinksForDevice(ctx context.Context, db sqlx.Execer, devEUI lorawan.EUI64) error {
+	_, err := db.Exec(`
+		delete from
+			confirmed_downlink
+		where
+			dev_eui = $1`,
+		devEUI[:],
+	)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+
+	return nil
+}
+
+// RetryConfirmedDownlink re-enqueues the given confirmed downlink and
+// replaces it by the confirmed downlink for the new frame-counter, with an
+// incremented retry count.
+func RetryConfirmedDownlink(ctx context.Context, db sqlx.Ext, cd ConfirmedDownlink) (uint32, error) {
+	if err := DeleteConfirmedDownlink(ctx, db, cd.DevEUI, cd.FCnt); err != nil {
+		return 0, errors.Wrap(err, "delete confirmed downlink error")
+	}
+
+	fCnt, err := enqueueDownlinkPayload(ctx, db, cd.DevEUI, true, cd.FPort, cd.Data, cd.RetryCount+1)
+	if err != nil {
+		return 0, err
+	}
+
+	// the retry expires at the same time as the original downlink
+	if err := UpdateDeviceQueueItemExpiryFCnt(ctx, db, cd.DevEUI, cd.FCnt, fCnt); err != nil {
+		return 0, errors.Wrap(err, "update device-queue item expiry error")
+	}
+
+	if err := UpdateDeviceQueueItemObjectFCnt(ctx, db, cd.DevEUI, cd.FCnt, fCnt); err != nil {
+		return 0, errors.Wrap(err, "update device-queue item object error")
+	}
+
+	if err := UpdateDownlinkCorrelationFCnt(ctx, db, cd.DevEUI, cd.FCnt, fCnt); err != nil {
+		return 0, errors.Wrap(err, "update downlink correlation error")
+	}
+
+	return fCnt, nil
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	uuid "github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	// "github.com/ibrahimozekici/lora-api/go/v3/ns"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	nsmock "github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestConfirmedDownlink() {
+	assert := require.New(ts.T())
+
+	nsClient := nsmock.NewClient()
+	networkserver.SetPool(nsmock.NewPool(nsClient))
+
+	n := NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(CreateNetworkServer(context.Background(), ts.tx, &n))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(context.Background(), ts.tx, &org))
+
+	sp := ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateServiceProfile(context.Background(), ts.tx, &sp))
+	var spID uuid.UUID
+	copy(spID[:], sp.ServiceProfile.Id)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(context.Background(), ts.tx, &app))
+
+	dp := DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateDeviceProfile(context.Background(), ts.tx, &dp))
+	var dpID uuid.UUID
+	copy(dpID[:], dp.DeviceProfile.Id)
+
+	d := Device{
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "test-device",
+	}
+	assert.NoError(CreateDevice(context.Background(), ts.tx, &d))
+
+	ts.T().Run("Without retry policy", func(t *testing.T) {
+		assert := require.New(t)
+
+		nsClient.GetNextDownlinkFCntForDevEUIResponse = ns.GetNextDownlinkFCntForDevEUIResponse{FCnt: 9}
+		_, err := EnqueueDownlinkPayload(context.Background(), ts.tx, d.DevEUI, true, 10, []byte{1, 2, 3})
+		assert.NoError(err)
+		<-nsClient.CreateDeviceQueueItemChan
+
+		_, err = GetConfirmedDownlink(context.Background(), ts.tx, d.DevEUI, 9, false)
+		assert.Equal(ErrDoesNotExist, errors.Cause(err))
+	})
+
+	ts.T().Run("With retry policy", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(UpdateDeviceProfileConfirmedDownlinkRetry(context.Background(), ts.tx, dpID, ConfirmedDownlinkRetryPolicy{
+			MaxRetries:    2,
+			BackoffFrames: 1,
+		}))
+
+		nsClient.GetNextDownlinkFCntForDevEUIResponse = ns.GetNextDownlinkFCntForDevEUIResponse{FCnt: 10}
+		fCnt, err := EnqueueDownlinkPayload(context.Background(), ts.tx, d.DevEUI, true, 10, []byte{1, 2, 3})
+		assert.NoError(err)
+		assert.EqualValues(10, fCnt)
+		<-nsClient.CreateDeviceQueueItemChan
+
+		cd, err := GetConfirmedDownlink(context.Background(), ts.tx, d.DevEUI, 10, false)
+		assert.NoError(err)
+		assert.Equal(uint8(10), cd.FPort)
+		assert.Equal([]byte{1, 2, 3}, cd.Data)
+		assert.Equal(0, cd.RetryCount)
+		assert.Nil(cd.RetryAfter)
+
+		t.Run("Schedule retry", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(ScheduleConfirmedDownlinkRetry(context.Background(), ts.tx, d.DevEUI, 10, time.Now(), 1))
+
+			// one uplink is pending
+			items, err := GetPendingConfirmedDownlinkRetries(context.Background(), ts.tx, 10)
+			assert.NoError(err)
+			assert.Len(items, 0)
+
+			assert.NoError(DecrementConfirmedDownlinkRetryUplinks(context.Background(), ts.tx, d.DevEUI))
+
+			items, err = GetPendingConfirmedDownlinkRetries(context.Background(), ts.tx, 10)
+			assert.NoError(err)
+			assert.Len(items, 1)
+			assert.EqualValues(10, items[0].FCnt)
+
+			t.Run("Retry", func(t *testing.T) {
+				assert := require.New(t)
+
+				nsClient.GetNextDownlinkFCntForDevEUIResponse = ns.GetNextDownlinkFCntForDevEUIResponse{FCnt: 11}
+				fCnt, err := RetryConfirmedDownlink(context.Background(), ts.tx, items[0])
+				assert.NoError(err)
+				assert.EqualValues(11, fCnt)
+
+				req := <-nsClient.CreateDeviceQueueItemChan
+				assert.True(req.Item.Confirmed)
+				assert.EqualValues(10, req.Item.FPort)
+
+				_, err = GetConfirmedDownlink(context.Background(), ts.tx, d.DevEUI, 10, false)
+				assert.Equal(ErrDoesNotExist, errors.Cause(err))
+
+				cd, err := GetConfirmedDownlink(context.Background(), ts.tx, d.DevEUI, 11, false)
+				assert.NoError(err)
+				assert.Equal(1, cd.RetryCount)
+				assert.Nil(cd.RetryAfter)
+			})
+		})
+
+		t.Run("Delete for device", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(DeleteConfirmedDownlinksForDevice(context.Background(), ts.tx, d.DevEUI))
+			_, err := GetConfirmedDownlink(context.Background(), ts.tx, d.DevEUI, 11, false)
+			assert.Equal(ErrDoesNotExist, errors.Cause(err))
+		})
+	})
+}
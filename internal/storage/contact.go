@@ -0,0 +1,533 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+const automationContactOpenKeyTempl = "lora:as:automation:contact:{%s}:%s:%s:%d" // (dev_eui | automation_id | name | changed_at)
+
+// automationContactOpenTTL defines how long a fired CONTACT_OPEN trigger is
+// registered. A contact which is still open by then fires again.
+const automationContactOpenTTL = 7 * 24 * time.Hour
+
+// ContactState defines the current state of a contact sensor (a CONTACT
+// measurement of the device-profile, e.g. a door) of a device. ChangedAt
+// holds the time the contact was last opened or closed and UpdatedAt the
+// time the state was last reported.
+type ContactState struct {
+	DevEUI    lorawan.EUI64 `db:"dev_eui"`
+	Name      string        `db:"name"`
+	Open      bool          `db:"open"`
+	ChangedAt time.Time     `db:"changed_at"`
+	UpdatedAt time.Time     `db:"updated_at"`
+}
+
+// OpenContact defines an open contact sensor with its device.
+type OpenContact struct {
+	Device    Device
+	Name      string
+	ChangedAt time.Time
+}
+
+// ContactEvent defines the opening or closing of a contact sensor. For a
+// close event, OpenSeconds holds the duration the contact has been open.
+type ContactEvent struct {
+	ID          int64         `db:"id"`
+	DevEUI      lorawan.EUI64 `db:"dev_eui"`
+	Name        string        `db:"name"`
+	Time        time.Time     `db:"time"`
+	Open        bool          `db:"open"`
+	OpenSeconds *float64      `db:"open_seconds"`
+}
+
+// ContactEventListItem defines the contact event as list item.
+type ContactEventListItem struct {
+	ContactEvent
+	DeviceName string `db:"device_name"`
+}
+
+// ContactEventFilters provides filters for filtering contact events. Note
+// that empty values are not used as filters.
+type ContactEventFilters struct {
+	ApplicationID int64         `db:"application_id"`
+	DevEUI        lorawan.EUI64 `db:"dev_eui"`
+	Name          string        `db:"name"`
+
+	// Start and End filter on the time of the event.
+	Start time.Time `db:"start"`
+	End   time.Time `db:"end"`
+
+	// Limit and Offset are added for convenience so that this struct can
+	// be given as the arguments.
+	Limit  int `db:"limit"`
+	Offset int `db:"offset"`
+}
+
+// SQL returns the SQL filters.
+func (f ContactEventFilters) SQL() string {
+	var filters []string
+	var nullDevEUI lorawan.EUI64
+
+	if f.ApplicationID != 0 {
+		filters = append(filters, "d.application_id = :application_id")
+	}
+
+	if f.DevEUI != nullDevEUI {
+		filters = append(filters, "e.dev_eui = :dev_eui")
+	}
+
+	if f.Name != "" {
+		filters = append(filters, "e.name = :name")
+	}
+
+	if !f.Start.IsZero() {
+		filters = append(filters, "e.time >= :start")
+	}
+
+	if !f.End.IsZero() {
+		filters = append(filters, "e.time < :end")
+	}
+
+	if len(filters) == 0 {
+		return ""
+	}
+
+	return "where " + strings.Join(filters, " and ")
+}
+
+// ContactDailyStats holds the statistics of a contact sensor for a single
+// day (in the configured metrics timezone): the number of times the contact
+// was opened, the total open duration and the longest open duration within
+// the day.
+type ContactDailyStats struct {
+	Name               string
+	Date               time.Time
+	OpenCount          int
+	OpenSeconds        float64
+	LongestOpenSeconds float64
+}
+
+// UpdateContactState updates the state of the contact sensor of the device,
+// reported at the given time. When the contact has been opened or closed, a
+// contact event is created and returned, else it returns nil. The first
+// reported state is only recorded as event when the contact is open. States
+// reported before the last change are ignored. This must be called within a
+// transaction, as the state is locked for update.
+func UpdateContactState(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, name string, open bool, t time.Time) (*ContactEvent, error) {
+	var s ContactState
+	exists := true
+
+	err := sqlx.Get(db, &s, `
+		select
+			*
+		from
+			contact_state
+		where
+			dev_eui = $1
+			and name = $2
+		for update`,
+		devEUI,
+		name,
+	)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			return nil, handlePSQLError(Select, err, "select error")
+		}
+		exists = false
+	}
+
+	if exists && t.Before(s.ChangedAt) {
+		return nil, nil
+	}
+
+	if exists && s.Open == open {
+		_, err := db.Exec(`
+			update contact_state
+			set
+				updated_at = $3
+			where
+				dev_eui = $1
+				and name = $2`,
+			devEUI,
+			name,
+			t,
+		)
+		if err != nil {
+			return nil, handlePSQLError(Update, err, "update error")
+		}
+		return nil, nil
+	}
+
+	_, err = db.Exec(`
+		insert into contact_state (
+			dev_eui,
+			name,
+			open,
+			changed_at,
+			updated_at
+		) values ($1, $2, $3, $4, $4)
+		on conflict (dev_eui, name)
+			do update set
+				open = excluded.open,
+				changed_at = excluded.changed_at,
+				updated_at = excluded.updated_at`,
+		devEUI,
+		name,
+		open,
+		t,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Insert, err, "insert error")
+	}
+
+	if !exists && !open {
+		return nil, nil
+	}
+
+	ev := ContactEvent{
+		DevEUI: devEUI,
+		Name:   name,
+		Time:   t,
+		Open:   open,
+	}
+	if exists && s.Open && !open {
+		openSeconds := t.Sub(s.ChangedAt).Seconds()
+		ev.OpenSeconds = &openSeconds
+	}
+
+	err = sqlx.Get(db, &ev.ID, `
+		insert into contact_event (
+			dev_eui,
+			name,
+			time,
+			open,
+			open_seconds
+		) values ($1, $2, $3, $4, $5)
+		returning id`,
+		ev.DevEUI,
+		ev.Name,
+		ev.Time,
+		ev.Open,
+		ev.OpenSeconds,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": devEUI,
+		"name":    name,
+		"open":    open,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("contact state changed")
+
+	return &ev, nil
+}
+
+// GetContactStates returns the states of the contact sensors of the device,
+// sorted by name.
+func GetContactStates(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64) ([]ContactState, error) {
+	var out []ContactState
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			contact_state
+		where
+			dev_eui = $1
+		order by
+			name`,
+		devEUI,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetAutomationOpenContacts returns the contacts of the devices to which the
+// given automation applies which have been open for the duration of its
+// CONTACT_OPEN trigger. See CheckAutomationContactOpen for registering the
+// contacts for which the trigger has fired.
+func GetAutomationOpenContacts(ctx context.Context, db sqlx.Queryer, a Automation, now time.Time) ([]OpenContact, error) {
+	var rows []struct {
+		Device
+		ContactName      string    `db:"contact_name"`
+		ContactChangedAt time.Time `db:"contact_changed_at"`
+	}
+	err := sqlx.Select(db, &rows, `
+		select
+			d.*,
+			s.name as contact_name,
+			s.changed_at as contact_changed_at
+		from
+			contact_state s
+		inner join device d
+			on d.dev_eui = s.dev_eui
+		where
+			d.application_id = $1
+			and coalesce(d.tags, ''::hstore) @> $2
+			and s.open = true
+			and s.changed_at < $3
+			and ($4 = '' or s.name = $4)
+		order by
+			s.dev_eui,
+			s.name`,
+		a.ApplicationID,
+		a.DeviceTags,
+		now.Add(-a.Trigger.Timeout()),
+		a.Trigger.ContactName,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	var out []OpenContact
+	for _, r := range rows {
+		out = append(out, OpenContact{
+			Device:    r.Device,
+			Name:      r.ContactName,
+			ChangedAt: r.ContactChangedAt,
+		})
+	}
+
+	return out, nil
+}
+
+// CheckAutomationContactOpen registers the CONTACT_OPEN trigger of the given
+// automation for the contact of the device, opened at the given time. It
+// returns false when this was already registered, i.e. the trigger already
+// fired for this opening of the contact (or an other instance is evaluating
+// the same trigger).
+func CheckAutomationContactOpen(ctx context.Context, a Automation, devEUI lorawan.EUI64, name string, openedAt time.Time) (bool, error) {
+	ok, err := RedisClient().SetNX(GetRedisKey(automationContactOpenKeyTempl, devEUI, a.ID, name, openedAt.UnixNano()), time.Now().Unix(), automationContactOpenTTL).Result()
+	if err != nil {
+		return false, errors.Wrap(err, "set contact open key error")
+	}
+
+	return ok, nil
+}
+
+// GetContactEventCount returns the number of contact events matching the
+// given filters.
+func GetContactEventCount(ctx context.Context, db sqlx.Queryer, filters ContactEventFilters) (int, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			count(*)
+		from
+			contact_event e
+		inner join device d
+			on d.dev_eui = e.dev_eui
+		`+filters.SQL(), filters)
+	if err != nil {
+		return 0, errors.Wrap(err, "named query error")
+	}
+
+	var count int
+	if err := sqlx.Get(db, &count, query, args...); err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	return count, nil
+}
+
+// GetContactEvents returns the contact events matching the given filters,
+// most recent first.
+func GetContactEvents(ctx context.Context, db sqlx.Queryer, filters ContactEventFilters) ([]ContactEventListItem, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			e.*,
+			d.name as device_name
+		from
+			contact_event e
+		inner join device d
+			on d.dev_eui = e.dev_eui
+		`+filters.SQL()+`
+		order by
+			e.time desc,
+			e.id desc
+		limit :limit
+		offset :offset`, filters)
+	if err != nil {
+		return nil, errors.Wrap(err, "named query error")
+	}
+
+	var out []ContactEventListItem
+	if err := sqlx.Select(db, &out, query, args...); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetContactDailyStats returns the daily statistics of the contact sensors
+// of the device for the days (in the configured metrics timezone) from
+// start until end, sorted by name and date. The open duration of a contact
+// which is still open is counted until now.
+func GetContactDailyStats(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64, start, end time.Time) ([]ContactDailyStats, error) {
+	start = start.In(timeLocation)
+	start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, timeLocation)
+
+	// the state of each contact at the start of the first day
+	var prev []ContactEvent
+	err := sqlx.Select(db, &prev, `
+		select distinct on (name)
+			*
+		from
+			contact_event
+		where
+			dev_eui = $1
+			and time < $2
+		order by
+			name,
+			time desc,
+			id desc`,
+		devEUI,
+		start,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	var events []ContactEvent
+	err = sqlx.Select(db, &events, `
+		select
+			*
+		from
+			contact_event
+		where
+			dev_eui = $1
+			and time >= $2
+			and time < $3
+		order by
+			time,
+			id`,
+		devEUI,
+		start,
+		end,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return contactDailyStats(prev, events, start, end, time.Now()), nil
+}
+
+// contactDailyStats returns the daily statistics of the given events (sorted
+// by time) of the days from start until end. Prev contains the last event of
+// each contact before start.
+func contactDailyStats(prev, events []ContactEvent, start, end, now time.Time) []ContactDailyStats {
+	var days []time.Time
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		days = append(days, d)
+	}
+	if len(days) == 0 {
+		return nil
+	}
+
+	until := end
+	if now.Before(until) {
+		until = now
+	}
+
+	type contact struct {
+		open  bool
+		since time.Time
+		stats []ContactDailyStats
+	}
+
+	contacts := make(map[string]*contact)
+	get := func(name string) *contact {
+		c, ok := contacts[name]
+		if !ok {
+			c = &contact{
+				since: start,
+				stats: make([]ContactDailyStats, len(days)),
+			}
+			for i, d := range days {
+				c.stats[i] = ContactDailyStats{Name: name, Date: d}
+			}
+			contacts[name] = c
+		}
+		return c
+	}
+
+	dayIndex := func(t time.Time) int {
+		return sort.Search(len(days), func(i int) bool { return days[i].After(t) }) - 1
+	}
+
+	// addOpen adds the open duration from - to to the days it spans
+	addOpen := func(c *contact, from, to time.Time) {
+		for i := dayIndex(from); i >= 0 && i < len(days) && days[i].Before(to); i++ {
+			dayFrom, dayTo := days[i], end
+			if i+1 < len(days) {
+				dayTo = days[i+1]
+			}
+			if from.After(dayFrom) {
+				dayFrom = from
+			}
+			if to.Before(dayTo) {
+				dayTo = to
+			}
+
+			seconds := dayTo.Sub(dayFrom).Seconds()
+			if seconds <= 0 {
+				continue
+			}
+			c.stats[i].OpenSeconds += seconds
+			if seconds > c.stats[i].LongestOpenSeconds {
+				c.stats[i].LongestOpenSeconds = seconds
+			}
+		}
+	}
+
+	for _, ev := range prev {
+		get(ev.Name).open = ev.Open
+	}
+
+	for _, ev := range events {
+		c := get(ev.Name)
+		if ev.Open == c.open {
+			continue
+		}
+
+		if ev.Open {
+			if i := dayIndex(ev.Time); i >= 0 {
+				c.stats[i].OpenCount++
+			}
+		} else {
+			addOpen(c, c.since, ev.Time)
+		}
+
+		c.open = ev.Open
+		c.since = ev.Time
+	}
+
+	var names []string
+	for name, c := range contacts {
+		if c.open {
+			addOpen(c, c.since, until)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out []ContactDailyStats
+	for _, name := range names {
+		out = append(out, contacts[name].stats...)
+	}
+
+	return out
+}
@@ -0,0 +1,314 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	//"github.com/brocaar/lorawan"
+)
+
+func TestContactDailyStats(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2020, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+	at := func(d, h, m int) time.Time {
+		return time.Date(2020, 1, d, h, m, 0, 0, time.UTC)
+	}
+	event := func(name string, t time.Time, open bool) ContactEvent {
+		return ContactEvent{Name: name, Time: t, Open: open}
+	}
+
+	tests := []struct {
+		Name     string
+		Prev     []ContactEvent
+		Events   []ContactEvent
+		Now      time.Time
+		Expected []ContactDailyStats
+	}{
+		{
+			Name: "no events",
+			Now:  day(10),
+		},
+		{
+			Name: "open and closed within a day",
+			Events: []ContactEvent{
+				event("door", at(1, 8, 0), true),
+				event("door", at(1, 8, 10), false),
+				event("door", at(1, 12, 0), true),
+				event("door", at(1, 12, 30), false),
+			},
+			Now: day(10),
+			Expected: []ContactDailyStats{
+				{Name: "door", Date: day(1), OpenCount: 2, OpenSeconds: 2400, LongestOpenSeconds: 1800},
+				{Name: "door", Date: day(2)},
+			},
+		},
+		{
+			Name: "open over midnight",
+			Events: []ContactEvent{
+				event("door", at(1, 23, 0), true),
+				event("door", at(2, 1, 0), false),
+			},
+			Now: day(10),
+			Expected: []ContactDailyStats{
+				{Name: "door", Date: day(1), OpenCount: 1, OpenSeconds: 3600, LongestOpenSeconds: 3600},
+				{Name: "door", Date: day(2), OpenSeconds: 3600, LongestOpenSeconds: 3600},
+			},
+		},
+		{
+			Name: "open before start",
+			Prev: []ContactEvent{
+				event("door", at(0, 22, 0), true),
+			},
+			Events: []ContactEvent{
+				event("door", at(1, 0, 30), false),
+			},
+			Now: day(10),
+			Expected: []ContactDailyStats{
+				{Name: "door", Date: day(1), OpenSeconds: 1800, LongestOpenSeconds: 1800},
+				{Name: "door", Date: day(2)},
+			},
+		},
+		{
+			Name: "still open",
+			Events: []ContactEvent{
+				event("door", at(2, 10, 0), true),
+			},
+			Now: at(2, 11, 0),
+			Expected: []ContactDailyStats{
+				{Name: "door", Date: day(1)},
+				{Name: "door", Date: day(2), OpenCount: 1, OpenSeconds: 3600, LongestOpenSeconds: 3600},
+			},
+		},
+		{
+			Name: "repeated state and multiple contacts",
+			Prev: []ContactEvent{
+				event("window", at(0, 10, 0), false),
+			},
+			Events: []ContactEvent{
+				event("window", at(1, 10, 0), true),
+				event("door", at(1, 10, 0), true),
+				event("window", at(1, 10, 5), true),
+				event("window", at(1, 10, 10), false),
+				event("door", at(1, 10, 20), false),
+			},
+			Now: day(10),
+			Expected: []ContactDailyStats{
+				{Name: "door", Date: day(1), OpenCount: 1, OpenSeconds: 1200, LongestOpenSeconds: 1200},
+				{Name: "door", Date: day(2)},
+				{Name: "window", Date: day(1), OpenCount: 1, OpenSeconds: 600, LongestOpenSeconds: 600},
+				{Name: "window", Date: day(2)},
+			},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(tst.Expected, contactDailyStats(tst.Prev, tst.Events, day(1), day(3), tst.Now))
+		})
+	}
+}
+
+func (ts *StorageTestSuite) TestContact() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(ctx, ts.Tx(), &org))
+
+	n := NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(CreateNetworkServer(ctx, ts.Tx(), &n))
+
+	sp := ServiceProfile{
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+		Name:            "test-sp",
+	}
+	assert.NoError(CreateServiceProfile(ctx, ts.Tx(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(ctx, ts.Tx(), &app))
+
+	dp := DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateDeviceProfile(ctx, ts.Tx(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	d := Device{
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "warehouse-door",
+	}
+	assert.NoError(CreateDevice(ctx, ts.Tx(), &d))
+
+	now := time.Now().Truncate(time.Millisecond)
+
+	ts.T().Run("UpdateContactState", func(t *testing.T) {
+		assert := require.New(t)
+
+		// the first closed state is not an event
+		ev, err := UpdateContactState(ctx, ts.Tx(), d.DevEUI, "door", false, now.Add(-2*time.Hour))
+		assert.NoError(err)
+		assert.Nil(ev)
+
+		ev, err = UpdateContactState(ctx, ts.Tx(), d.DevEUI, "door", true, now.Add(-90*time.Minute))
+		assert.NoError(err)
+		assert.NotNil(ev)
+		assert.True(ev.Open)
+		assert.Nil(ev.OpenSeconds)
+
+		// unchanged
+		ev, err = UpdateContactState(ctx, ts.Tx(), d.DevEUI, "door", true, now.Add(-80*time.Minute))
+		assert.NoError(err)
+		assert.Nil(ev)
+
+		// reported before the last change
+		ev, err = UpdateContactState(ctx, ts.Tx(), d.DevEUI, "door", false, now.Add(-100*time.Minute))
+		assert.NoError(err)
+		assert.Nil(ev)
+
+		ev, err = UpdateContactState(ctx, ts.Tx(), d.DevEUI, "door", false, now.Add(-60*time.Minute))
+		assert.NoError(err)
+		assert.NotNil(ev)
+		assert.False(ev.Open)
+		assert.NotNil(ev.OpenSeconds)
+		assert.Equal(1800.0, *ev.OpenSeconds)
+
+		ev, err = UpdateContactState(ctx, ts.Tx(), d.DevEUI, "hatch", true, now.Add(-30*time.Minute))
+		assert.NoError(err)
+		assert.NotNil(ev)
+
+		states, err := GetContactStates(ctx, ts.Tx(), d.DevEUI)
+		assert.NoError(err)
+		assert.Len(states, 2)
+		assert.Equal("door", states[0].Name)
+		assert.False(states[0].Open)
+		assert.True(states[0].ChangedAt.Equal(now.Add(-60 * time.Minute)))
+		assert.Equal("hatch", states[1].Name)
+		assert.True(states[1].Open)
+	})
+
+	ts.T().Run("GetContactEvents", func(t *testing.T) {
+		assert := require.New(t)
+
+		tests := []struct {
+			Name    string
+			Filters ContactEventFilters
+			Count   int
+		}{
+			{"application", ContactEventFilters{ApplicationID: app.ID}, 3},
+			{"device", ContactEventFilters{DevEUI: d.DevEUI}, 3},
+			{"name", ContactEventFilters{DevEUI: d.DevEUI, Name: "door"}, 2},
+			{"start", ContactEventFilters{DevEUI: d.DevEUI, Start: now.Add(-time.Hour)}, 2},
+			{"end", ContactEventFilters{DevEUI: d.DevEUI, End: now.Add(-time.Hour)}, 1},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.Name, func(t *testing.T) {
+				assert := require.New(t)
+
+				tst.Filters.Limit = 10
+
+				count, err := GetContactEventCount(ctx, ts.Tx(), tst.Filters)
+				assert.NoError(err)
+				assert.Equal(tst.Count, count)
+
+				items, err := GetContactEvents(ctx, ts.Tx(), tst.Filters)
+				assert.NoError(err)
+				assert.Len(items, tst.Count)
+			})
+		}
+
+		items, err := GetContactEvents(ctx, ts.Tx(), ContactEventFilters{DevEUI: d.DevEUI, Limit: 10})
+		assert.NoError(err)
+		assert.Equal("hatch", items[0].Name)
+		assert.Equal(d.Name, items[0].DeviceName)
+	})
+
+	ts.T().Run("GetContactDailyStats", func(t *testing.T) {
+		assert := require.New(t)
+
+		stats, err := GetContactDailyStats(ctx, ts.Tx(), d.DevEUI, now.Add(-3*time.Hour), now.Add(time.Hour))
+		assert.NoError(err)
+		assert.NotEmpty(stats)
+
+		var openCount int
+		for _, s := range stats {
+			openCount += s.OpenCount
+		}
+		assert.Equal(2, openCount)
+	})
+
+	ts.T().Run("GetAutomationOpenContacts", func(t *testing.T) {
+		assert := require.New(t)
+		RedisClient().FlushAll()
+
+		a := Automation{
+			ApplicationID: app.ID,
+			Name:          "door-open",
+			Enabled:       true,
+			Trigger: spec.Trigger{
+				Type:           spec.ContactOpenTrigger,
+				TimeoutSeconds: 600,
+			},
+			Actions: spec.Actions{
+				{Type: spec.TagAction, Key: "door", Value: "open"},
+			},
+		}
+		assert.NoError(CreateAutomation(ctx, ts.Tx(), &a))
+
+		contacts, err := GetAutomationOpenContacts(ctx, ts.Tx(), a, now)
+		assert.NoError(err)
+		assert.Len(contacts, 1)
+		assert.Equal(d.DevEUI, contacts[0].Device.DevEUI)
+		assert.Equal("hatch", contacts[0].Name)
+
+		ok, err := CheckAutomationContactOpen(ctx, a, d.DevEUI, contacts[0].Name, contacts[0].ChangedAt)
+		assert.NoError(err)
+		assert.True(ok)
+
+		ok, err = CheckAutomationContactOpen(ctx, a, d.DevEUI, contacts[0].Name, contacts[0].ChangedAt)
+		assert.NoError(err)
+		assert.False(ok)
+
+		// open for less than the trigger duration
+		a.Trigger.TimeoutSeconds = 3600
+		contacts, err = GetAutomationOpenContacts(ctx, ts.Tx(), a, now)
+		assert.NoError(err)
+		assert.Len(contacts, 0)
+
+		// other contact
+		a.Trigger.TimeoutSeconds = 600
+		a.Trigger.ContactName = "door"
+		contacts, err = GetAutomationOpenContacts(ctx, ts.Tx(), a, now)
+		assert.NoError(err)
+		assert.Len(contacts, 0)
+	})
+}
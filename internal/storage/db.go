@@ -1,7 +1,12 @@
 package storage
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v7"
@@ -16,8 +21,15 @@ import (
 // redisClient holds the Redis client.
 var redisClient redis.UniversalClient
 
-// db holds the PostgreSQL connection pool.
-var db *DBLogger
+// redisKeyPrefix holds the prefix applied to all Redis keys.
+var redisKeyPrefix string
+
+// db holds the PostgreSQL connection pool. It is replaced when the
+// credentials are rotated (see RotatePostgreSQLDSN).
+var (
+	dbMux sync.RWMutex
+	db    *DBLogger
+)
 
 // DBLogger is a DB wrapper which logs the executed sql queries and their
 // duration.
@@ -100,16 +112,48 @@ func (q *TxLogger) Exec(query string, args ...interface{}) (sql.Result, error) {
 	return res, err
 }
 
+// slowQueryThreshold holds the duration above which queries are logged as
+// slow query. A threshold of 0 disables the slow query logging.
+var slowQueryThreshold time.Duration
+
+// SetSlowQueryThreshold sets the slow query threshold.
+func SetSlowQueryThreshold(d time.Duration) {
+	slowQueryThreshold = d
+}
+
 func logQuery(query string, duration time.Duration, args ...interface{}) {
 	log.WithFields(log.Fields{
 		"query":    query,
 		"args":     args,
 		"duration": duration,
 	}).Debug("sql query executed")
+
+	if slowQueryThreshold > 0 && duration >= slowQueryThreshold {
+		// the args are not logged as these may contain secrets (e.g. keys),
+		// the hash makes it possible to correlate slow executions with the
+		// same arguments
+		log.WithFields(log.Fields{
+			"query":     strings.Join(strings.Fields(query), " "),
+			"args_hash": queryArgsHash(args...),
+			"duration":  duration,
+			"threshold": slowQueryThreshold,
+		}).Warning("slow sql query")
+	}
+}
+
+// queryArgsHash returns a short hash of the given query arguments.
+func queryArgsHash(args ...interface{}) string {
+	h := sha256.New()
+	for _, arg := range args {
+		fmt.Fprintf(h, "%T:%v\x00", arg, arg)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
 }
 
 // DB returns the PostgreSQL database object.
 func DB() *DBLogger {
+	dbMux.RLock()
+	defer dbMux.RUnlock()
 	return db
 }
 
@@ -118,9 +162,44 @@ func RedisClient() redis.UniversalClient {
 	return redisClient
 }
 
+// GetRedisKey returns the Redis key given a template and parameters,
+// prefixed with the configured key prefix.
+func GetRedisKey(templ string, params ...interface{}) string {
+	return redisKeyPrefix + fmt.Sprintf(templ, params...)
+}
+
 // Transaction wraps the given function in a transaction. In case the given
 // functions returns an error, the transaction will be rolled back.
 func Transaction(f func(tx sqlx.Ext) error) error {
+	return transaction(f)
+}
+
+// TransactionWithRetry wraps the given function in a transaction, like
+// Transaction. When the transaction fails because of a serialization failure
+// or deadlock (e.g. caused by concurrent updates of the same device), the
+// transaction is retried with backoff, executing the given function again.
+// Therefore the given function must only execute database queries, e.g. it
+// must not call the network-server API or have other side effects which
+// can't be repeated (invalidating a cache is fine). The function must
+// also return the storage errors as-is (not converted into gRPC errors), as
+// these are used to detect the conflict.
+func TransactionWithRetry(f func(tx sqlx.Ext) error) error {
+	for attempt := 1; ; attempt++ {
+		err := transaction(f)
+		if err == nil || !isRetryableTxError(err) {
+			return err
+		}
+
+		if attempt > transactionMaxRetries {
+			log.WithError(err).WithField("attempts", attempt).Error("storage: transaction failed after retries")
+			return ErrTransactionConflict
+		}
+
+		time.Sleep(transactionRetryDelay(attempt))
+	}
+}
+
+func transaction(f func(tx sqlx.Ext) error) error {
 	tx, err := db.Beginx()
 	if err != nil {
 		return errors.Wrap(err, "storage: begin transaction error")
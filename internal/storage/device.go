@@ -15,6 +15,7 @@ import (
 
 	// "github.com/ibrahimozekici/lora-api/go/v3/ns"
 	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/codec"
 	"github.com/ibrahimozekici/app-server2/internal/config"
 	"github.com/ibrahimozekici/app-server2/internal/logging"
 	//"github.com/brocaar/lorawan"
@@ -44,6 +45,12 @@ type Device struct {
 	Variables                 hstore.Hstore     `db:"variables"`
 	Tags                      hstore.Hstore     `db:"tags"`
 	IsDisabled                bool              `db:"-"`
+
+	// PayloadCodec, PayloadEncoderScript and PayloadDecoderScript override
+	// the device-profile codec when PayloadCodec is set.
+	PayloadCodec         codec.Type `db:"payload_codec"`
+	PayloadEncoderScript string     `db:"payload_encoder_script"`
+	PayloadDecoderScript string     `db:"payload_decoder_script"`
 }
 
 // DeviceListItem defines the Device as list item.
@@ -66,6 +73,16 @@ type DeviceKeys struct {
 	AppKey    lorawan.AES128Key `db:"app_key"`
 	GenAppKey lorawan.AES128Key `db:"gen_app_key"`
 	JoinNonce int               `db:"join_nonce"`
+
+	// DataKeyID and EncryptedKeys are set when the root keys are encrypted
+	// at rest, in which case the NwkKey, AppKey and GenAppKey columns are
+	// stored as null keys.
+	DataKeyID     *int64 `db:"data_key_id"`
+	EncryptedKeys []byte `db:"encrypted_keys"`
+
+	// HSM is set when the NwkKey and AppKey are stored in the HSM, in which
+	// case these are stored as null keys.
+	HSM bool `db:"hsm"`
 }
 
 // DevicesActiveInactive holds the active and inactive counts.
@@ -80,6 +97,8 @@ type DevicesDataRates map[uint32]uint32
 
 // CreateDevice creates the given device.
 func CreateDevice(ctx context.Context, db sqlx.Ext, d *Device) error {
+	defer observeQueryDuration("device_create", time.Now())
+
 	if err := d.Validate(); err != nil {
 		return errors.Wrap(err, "validate error")
 	}
@@ -139,12 +158,7 @@ func CreateDevice(ctx context.Context, db sqlx.Ext, d *Device) error {
 		return errors.Wrap(err, "get application error")
 	}
 
-	n, err := GetNetworkServerForDevEUI(ctx, db, d.DevEUI)
-	if err != nil {
-		return errors.Wrap(err, "get network-server error")
-	}
-
-	nsClient, err := networkserver.GetPool().Get(n.Server, []byte(n.CACert), []byte(n.TLSCert), []byte(n.TLSKey))
+	nsClient, err := getNSClientForApplication(ctx, db, d.ApplicationID)
 	if err != nil {
 		return errors.Wrap(err, "get network-server client error")
 	}
@@ -177,6 +191,8 @@ func CreateDevice(ctx context.Context, db sqlx.Ext, d *Device) error {
 // When localOnly is set to true, no call to the network-server is made to
 // retrieve additional device data.
 func GetDevice(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64, forUpdate, localOnly bool) (Device, error) {
+	defer observeQueryDuration("device_get", time.Now())
+
 	var fu string
 	if forUpdate {
 		fu = " for update"
@@ -299,6 +315,8 @@ func GetDeviceCount(ctx context.Context, db sqlx.Queryer, filters DeviceFilters)
 
 // GetDevices returns a slice of devices.
 func GetDevices(ctx context.Context, db sqlx.Queryer, filters DeviceFilters) ([]DeviceListItem, error) {
+	defer observeQueryDuration("device_list", time.Now())
+
 	if filters.Search != "" {
 		filters.Search = "%" + filters.Search + "%"
 	}
@@ -337,6 +355,8 @@ func GetDevices(ctx context.Context, db sqlx.Queryer, filters DeviceFilters) ([]
 // UpdateDevice updates the given device.
 // When localOnly is set, it will not update the device on the network-server.
 func UpdateDevice(ctx context.Context, db sqlx.Ext, d *Device, localOnly bool) error {
+	defer observeQueryDuration("device_update", time.Now())
+
 	if err := d.Validate(); err != nil {
 		return errors.Wrap(err, "validate error")
 	}
@@ -397,42 +417,13 @@ func UpdateDevice(ctx context.Context, db sqlx.Ext, d *Device, localOnly bool) e
 
 	// update the device on the network-server
 	if !localOnly {
-		app, err := GetApplication(ctx, db, d.ApplicationID)
-		if err != nil {
-			return errors.Wrap(err, "get application error")
-		}
-
-		n, err := GetNetworkServerForDevEUI(ctx, db, d.DevEUI)
-		if err != nil {
-			return errors.Wrap(err, "get network-server error")
-		}
-
-		nsClient, err := networkserver.GetPool().Get(n.Server, []byte(n.CACert), []byte(n.TLSCert), []byte(n.TLSKey))
-		if err != nil {
-			return errors.Wrap(err, "get network-server client error")
-		}
-
-		rpID, err := uuid.FromString(config.C.ApplicationServer.ID)
-		if err != nil {
-			return errors.Wrap(err, "uuid from string error")
-		}
-
-		_, err = nsClient.UpdateDevice(ctx, &ns.UpdateDeviceRequest{
-			Device: &ns.Device{
-				DevEui:            d.DevEUI[:],
-				DeviceProfileId:   d.DeviceProfileID.Bytes(),
-				ServiceProfileId:  app.ServiceProfileID.Bytes(),
-				RoutingProfileId:  rpID.Bytes(),
-				SkipFCntCheck:     d.SkipFCntCheck,
-				ReferenceAltitude: d.ReferenceAltitude,
-				IsDisabled:        d.IsDisabled,
-			},
-		})
-		if err != nil {
-			return errors.Wrap(err, "update device error")
+		if err := UpdateDeviceOnNetworkServer(ctx, db, *d); err != nil {
+			return err
 		}
 	}
 
+	invalidateDeviceCache(ctx, d.DevEUI)
+
 	log.WithFields(log.Fields{
 		"dev_eui": d.DevEUI,
 		"ctx_id":  ctx.Value(logging.ContextIDKey),
@@ -443,6 +434,8 @@ func UpdateDevice(ctx context.Context, db sqlx.Ext, d *Device, localOnly bool) e
 
 // UpdateDeviceLastSeenAndDR updates the device last-seen timestamp and data-rate.
 func UpdateDeviceLastSeenAndDR(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, ts time.Time, dr int) error {
+	defer observeQueryDuration("device_update_last_seen", time.Now())
+
 	res, err := db.Exec(`
 		update device
 		set
@@ -475,6 +468,8 @@ func UpdateDeviceLastSeenAndDR(ctx context.Context, db sqlx.Ext, devEUI lorawan.
 
 // UpdateDeviceActivation updates the device address and the AppSKey.
 func UpdateDeviceActivation(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, devAddr lorawan.DevAddr, appSKey lorawan.AES128Key) error {
+	defer observeQueryDuration("device_update_activation", time.Now())
+
 	res, err := db.Exec(`
 		update device
 		set
@@ -497,6 +492,8 @@ func UpdateDeviceActivation(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI
 		return ErrDoesNotExist
 	}
 
+	invalidateDeviceCache(ctx, devEUI)
+
 	log.WithFields(log.Fields{
 		"dev_eui":  devEUI,
 		"dev_addr": devAddr,
@@ -506,13 +503,68 @@ func UpdateDeviceActivation(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI
 	return nil
 }
 
-// DeleteDevice deletes the device matching the given DevEUI.
+// UpdateDeviceOnNetworkServer updates the given device on the
+// network-server. This is called by UpdateDevice when localOnly is false.
+func UpdateDeviceOnNetworkServer(ctx context.Context, db sqlx.Queryer, d Device) error {
+	app, err := GetApplication(ctx, db, d.ApplicationID)
+	if err != nil {
+		return errors.Wrap(err, "get application error")
+	}
+
+	n, err := GetNetworkServerForDevEUI(ctx, db, d.DevEUI)
+	if err != nil {
+		return errors.Wrap(err, "get network-server error")
+	}
+
+	nsClient, err := networkserver.GetPool().Get(n.Server, []byte(n.CACert), []byte(n.TLSCert), []byte(n.TLSKey))
+	if err != nil {
+		return errors.Wrap(err, "get network-server client error")
+	}
+
+	rpID, err := uuid.FromString(config.C.ApplicationServer.ID)
+	if err != nil {
+		return errors.Wrap(err, "uuid from string error")
+	}
+
+	_, err = nsClient.UpdateDevice(ctx, &ns.UpdateDeviceRequest{
+		Device: &ns.Device{
+			DevEui:            d.DevEUI[:],
+			DeviceProfileId:   d.DeviceProfileID.Bytes(),
+			ServiceProfileId:  app.ServiceProfileID.Bytes(),
+			RoutingProfileId:  rpID.Bytes(),
+			SkipFCntCheck:     d.SkipFCntCheck,
+			ReferenceAltitude: d.ReferenceAltitude,
+			IsDisabled:        d.IsDisabled,
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "update device error")
+	}
+
+	return nil
+}
+
+// DeleteDevice deletes the device matching the given DevEUI, including the
+// device on the network-server.
 func DeleteDevice(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64) error {
 	n, err := GetNetworkServerForDevEUI(ctx, db, devEUI)
 	if err != nil {
 		return errors.Wrap(err, "get network-server error")
 	}
 
+	if err := DeleteDeviceLocal(ctx, db, devEUI); err != nil {
+		return err
+	}
+
+	return DeleteDeviceOnNetworkServer(ctx, n, devEUI)
+}
+
+// DeleteDeviceLocal deletes the device matching the given DevEUI from the
+// database only. Use DeleteDeviceOnNetworkServer to delete the device from
+// its network-server.
+func DeleteDeviceLocal(ctx context.Context, db sqlx.Execer, devEUI lorawan.EUI64) error {
+	defer observeQueryDuration("device_delete", time.Now())
+
 	res, err := db.Exec("delete from device where dev_eui = $1", devEUI[:])
 	if err != nil {
 		return handlePSQLError(Delete, err, "delete error")
@@ -525,6 +577,20 @@ func DeleteDevice(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64) error
 		return ErrDoesNotExist
 	}
 
+	invalidateDeviceCache(ctx, devEUI)
+
+	log.WithFields(log.Fields{
+		"dev_eui": devEUI,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("device deleted")
+
+	return nil
+}
+
+// DeleteDeviceOnNetworkServer deletes the device matching the given DevEUI
+// from the given network-server. A device which does not exist on the
+// network-server is not considered an error.
+func DeleteDeviceOnNetworkServer(ctx context.Context, n NetworkServer, devEUI lorawan.EUI64) error {
 	nsClient, err := networkserver.GetPool().Get(n.Server, []byte(n.CACert), []byte(n.TLSCert), []byte(n.TLSKey))
 	if err != nil {
 		return errors.Wrap(err, "get network-server client error")
@@ -537,20 +603,23 @@ func DeleteDevice(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64) error
 		return errors.Wrap(err, "delete device error")
 	}
 
-	log.WithFields(log.Fields{
-		"dev_eui": devEUI,
-		"ctx_id":  ctx.Value(logging.ContextIDKey),
-	}).Info("device deleted")
-
 	return nil
 }
 
 // CreateDeviceKeys creates the keys for the given device.
-func CreateDeviceKeys(ctx context.Context, db sqlx.Execer, dc *DeviceKeys) error {
+func CreateDeviceKeys(ctx context.Context, db sqlx.Ext, dc *DeviceKeys) error {
 	now := time.Now()
 	dc.CreatedAt = now
 	dc.UpdatedAt = now
 
+	if err := storeDeviceKeysInHSM(ctx, dc); err != nil {
+		return errors.Wrap(err, "store device-keys in hsm error")
+	}
+	if err := encryptDeviceKeys(ctx, db, dc); err != nil {
+		return errors.Wrap(err, "encrypt device-keys error")
+	}
+	nwkKey, appKey, genAppKey := dc.storedKeys()
+
 	_, err := db.Exec(`
         insert into device_keys (
             created_at,
@@ -559,15 +628,21 @@ func CreateDeviceKeys(ctx context.Context, db sqlx.Execer, dc *DeviceKeys) error
 			nwk_key,
 			app_key,
 			join_nonce,
-			gen_app_key
-        ) values ($1, $2, $3, $4, $5, $6, $7)`,
+			gen_app_key,
+			data_key_id,
+			encrypted_keys,
+			hsm
+        ) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
 		dc.CreatedAt,
 		dc.UpdatedAt,
 		dc.DevEUI[:],
-		dc.NwkKey[:],
-		dc.AppKey[:],
+		nwkKey[:],
+		appKey[:],
 		dc.JoinNonce,
-		dc.GenAppKey[:],
+		genAppKey[:],
+		dc.DataKeyID,
+		dc.EncryptedKeys,
+		dc.HSM,
 	)
 	if err != nil {
 		return handlePSQLError(Insert, err, "insert error")
@@ -583,6 +658,8 @@ func CreateDeviceKeys(ctx context.Context, db sqlx.Execer, dc *DeviceKeys) error
 
 // GetDeviceKeys returns the device-keys for the given DevEUI.
 func GetDeviceKeys(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64) (DeviceKeys, error) {
+	defer observeQueryDuration("device_keys_get", time.Now())
+
 	var dc DeviceKeys
 
 	err := sqlx.Get(db, &dc, "select * from device_keys where dev_eui = $1", devEUI[:])
@@ -590,13 +667,25 @@ func GetDeviceKeys(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64) (
 		return dc, handlePSQLError(Select, err, "select error")
 	}
 
+	if err := DecryptDeviceKeys(ctx, db, &dc); err != nil {
+		return dc, errors.Wrap(err, "decrypt device-keys error")
+	}
+
 	return dc, nil
 }
 
 // UpdateDeviceKeys updates the given device-keys.
-func UpdateDeviceKeys(ctx context.Context, db sqlx.Execer, dc *DeviceKeys) error {
+func UpdateDeviceKeys(ctx context.Context, db sqlx.Ext, dc *DeviceKeys) error {
 	dc.UpdatedAt = time.Now()
 
+	if err := storeDeviceKeysInHSM(ctx, dc); err != nil {
+		return errors.Wrap(err, "store device-keys in hsm error")
+	}
+	if err := encryptDeviceKeys(ctx, db, dc); err != nil {
+		return errors.Wrap(err, "encrypt device-keys error")
+	}
+	nwkKey, appKey, genAppKey := dc.storedKeys()
+
 	res, err := db.Exec(`
         update device_keys
         set
@@ -604,15 +693,21 @@ func UpdateDeviceKeys(ctx context.Context, db sqlx.Execer, dc *DeviceKeys) error
 			nwk_key = $3,
 			app_key = $4,
 			join_nonce = $5,
-			gen_app_key = $6
+			gen_app_key = $6,
+			data_key_id = $7,
+			encrypted_keys = $8,
+			hsm = $9
         where
             dev_eui = $1`,
 		dc.DevEUI[:],
 		dc.UpdatedAt,
-		dc.NwkKey[:],
-		dc.AppKey[:],
+		nwkKey[:],
+		appKey[:],
 		dc.JoinNonce,
-		dc.GenAppKey[:],
+		genAppKey[:],
+		dc.DataKeyID,
+		dc.EncryptedKeys,
+		dc.HSM,
 	)
 	if err != nil {
 		return handlePSQLError(Update, err, "update error")
@@ -647,6 +742,10 @@ func DeleteDeviceKeys(ctx context.Context, db sqlx.Execer, devEUI lorawan.EUI64)
 		return ErrDoesNotExist
 	}
 
+	if err := deleteDeviceKeysFromHSM(ctx, devEUI); err != nil {
+		return errors.Wrap(err, "delete device-keys from hsm error")
+	}
+
 	log.WithFields(log.Fields{
 		"dev_eui": devEUI,
 		"ctx_id":  ctx.Value(logging.ContextIDKey),
@@ -674,8 +773,45 @@ func DeleteAllDevicesForApplicationID(ctx context.Context, db sqlx.Ext, applicat
 }
 
 // EnqueueDownlinkPayload adds the downlink payload to the network-server
-// device-queue.
+// device-queue. When the downlink is confirmed and the device-profile has a
+// confirmed downlink retry policy, the downlink is stored such that it can be
+// retried when it is not acknowledged. A new correlation ID is assigned to
+// the downlink.
 func EnqueueDownlinkPayload(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, confirmed bool, fPort uint8, data []byte) (uint32, error) {
+	correlationID, err := uuid.NewV4()
+	if err != nil {
+		return 0, errors.Wrap(err, "new uuid error")
+	}
+
+	return EnqueueCorrelatedDownlinkPayload(ctx, db, devEUI, correlationID, confirmed, fPort, data)
+}
+
+// EnqueueCorrelatedDownlinkPayload adds the downlink payload to the
+// network-server device-queue, like EnqueueDownlinkPayload, using the given
+// correlation ID. The correlation ID is used to report the tx ack and ack of
+// the downlink.
+func EnqueueCorrelatedDownlinkPayload(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, correlationID uuid.UUID, confirmed bool, fPort uint8, data []byte) (uint32, error) {
+	fCnt, err := enqueueDownlinkPayload(ctx, db, devEUI, confirmed, fPort, data, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := CreateDownlinkCorrelation(ctx, db, &DownlinkCorrelation{
+		ID:        correlationID,
+		DevEUI:    devEUI,
+		FCnt:      fCnt,
+		FPort:     fPort,
+		Confirmed: confirmed,
+	}); err != nil {
+		return 0, errors.Wrap(err, "create downlink correlation error")
+	}
+
+	return fCnt, nil
+}
+
+func enqueueDownlinkPayload(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, confirmed bool, fPort uint8, data []byte, retryCount int) (uint32, error) {
+	defer observeQueryDuration("device_queue_enqueue", time.Now())
+
 	// get network-server and network-server api client
 	n, err := GetNetworkServerForDevEUI(ctx, db, devEUI)
 	if err != nil {
@@ -721,6 +857,25 @@ func EnqueueDownlinkPayload(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI
 		return 0, errors.Wrap(err, "create device-queue item error")
 	}
 
+	if confirmed {
+		dp, err := GetDeviceProfile(ctx, db, d.DeviceProfileID, false, true)
+		if err != nil {
+			return 0, errors.Wrap(err, "get device-profile error")
+		}
+
+		if dp.ConfirmedDownlinkRetry.MaxRetries > 0 {
+			if err := CreateConfirmedDownlink(ctx, db, &ConfirmedDownlink{
+				DevEUI:     devEUI,
+				FCnt:       resp.FCnt,
+				FPort:      fPort,
+				Data:       data,
+				RetryCount: retryCount,
+			}); err != nil {
+				return 0, errors.Wrap(err, "create confirmed downlink error")
+			}
+		}
+	}
+
 	log.WithFields(log.Fields{
 		"f_cnt":     resp.FCnt,
 		"dev_eui":   devEUI,
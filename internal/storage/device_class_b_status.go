@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+// DeviceClassBStatus defines the last reported Class-B beacon-lock status of
+// a device.
+type DeviceClassBStatus struct {
+	DevEUI       lorawan.EUI64 `db:"dev_eui"`
+	CreatedAt    time.Time     `db:"created_at"`
+	UpdatedAt    time.Time     `db:"updated_at"`
+	BeaconLocked bool          `db:"beacon_locked"`
+}
+
+// SetDeviceClassBStatus creates or updates the Class-B status of the device.
+// It returns true when the beacon-lock status has changed compared to the
+// previously stored status (or when there was no previous status).
+func SetDeviceClassBStatus(ctx context.Context, db sqlx.Queryer, s *DeviceClassBStatus) (bool, error) {
+	now := time.Now()
+	s.CreatedAt = now
+	s.UpdatedAt = now
+
+	var changed bool
+	err := sqlx.Get(db, &changed, `
+		with previous as (
+			select
+				beacon_locked
+			from
+				device_class_b_status
+			where
+				dev_eui = $1
+		), upsert as (
+			insert into device_class_b_status (
+				dev_eui,
+				created_at,
+				updated_at,
+				beacon_locked
+			) values ($1, $2, $3, $4)
+			on conflict (dev_eui) do update
+			set
+				updated_at = excluded.updated_at,
+				beacon_locked = excluded.beacon_locked
+		)
+		select
+			coalesce((select beacon_locked from previous) <> $4, true)`,
+		s.DevEUI[:],
+		s.CreatedAt,
+		s.UpdatedAt,
+		s.BeaconLocked,
+	)
+	if err != nil {
+		return false, handlePSQLError(Update, err, "update error")
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui":       s.DevEUI,
+		"beacon_locked": s.BeaconLocked,
+		"changed":       changed,
+		"ctx_id":        ctx.Value(logging.ContextIDKey),
+	}).Info("device class-b status updated")
+
+	return changed, nil
+}
+
+// GetDeviceClassBStatus returns the Class-B status of the given device. When
+// the device never reported its status, ErrDoesNotExist is returned.
+func GetDeviceClassBStatus(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64) (DeviceClassBStatus, error) {
+	var s DeviceClassBStatus
+	err := sqlx.Get(db, &s, `
+		select
+			*
+		from
+			device_class_b_status
+		where
+			dev_eui = $1`,
+		devEUI[:],
+	)
+	if err != nil {
+		return s, handlePSQLError(Select, err, "select error")
+	}
+
+	return s, nil
+}
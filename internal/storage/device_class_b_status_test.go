@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+
+	uuid "github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	nsmock "github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestDeviceClassBStatus() {
+	assert := require.New(ts.T())
+
+	nsClient := nsmock.NewClient()
+	networkserver.SetPool(nsmock.NewPool(nsClient))
+
+	n := NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(CreateNetworkServer(context.Background(), ts.tx, &n))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(context.Background(), ts.tx, &org))
+
+	sp := ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateServiceProfile(context.Background(), ts.tx, &sp))
+	var spID uuid.UUID
+	copy(spID[:], sp.ServiceProfile.Id)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(context.Background(), ts.tx, &app))
+
+	dp := DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateDeviceProfile(context.Background(), ts.tx, &dp))
+	var dpID uuid.UUID
+	copy(dpID[:], dp.DeviceProfile.Id)
+
+	d := Device{
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "test-device",
+	}
+	assert.NoError(CreateDevice(context.Background(), ts.tx, &d))
+
+	_, err := GetDeviceClassBStatus(context.Background(), ts.tx, d.DevEUI)
+	assert.Equal(ErrDoesNotExist, errors.Cause(err))
+
+	changed, err := SetDeviceClassBStatus(context.Background(), ts.tx, &DeviceClassBStatus{
+		DevEUI:       d.DevEUI,
+		BeaconLocked: true,
+	})
+	assert.NoError(err)
+	assert.True(changed)
+
+	changed, err = SetDeviceClassBStatus(context.Background(), ts.tx, &DeviceClassBStatus{
+		DevEUI:       d.DevEUI,
+		BeaconLocked: true,
+	})
+	assert.NoError(err)
+	assert.False(changed)
+
+	changed, err = SetDeviceClassBStatus(context.Background(), ts.tx, &DeviceClassBStatus{
+		DevEUI:       d.DevEUI,
+		BeaconLocked: false,
+	})
+	assert.NoError(err)
+	assert.True(changed)
+
+	s, err := GetDeviceClassBStatus(context.Background(), ts.tx, d.DevEUI)
+	assert.NoError(err)
+	assert.Equal(d.DevEUI, s.DevEUI)
+	assert.False(s.BeaconLocked)
+}
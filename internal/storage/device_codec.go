@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/codec"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+// DeviceCodec defines the codec of a device. When Type is set, it overrides
+// the codec of the device-profile.
+type DeviceCodec struct {
+	Type          codec.Type
+	EncoderScript string
+	DecoderScript string
+}
+
+// Validate validates the device codec.
+func (c DeviceCodec) Validate() error {
+	switch c.Type {
+	case codec.None, codec.CayenneLPPType, codec.CustomJSType, codec.CustomJSES2015Type, codec.CustomLuaType, codec.ExternalGRPCType, codec.DeclarativeType:
+		return nil
+	default:
+		return ErrDeviceInvalidPayloadCodec
+	}
+}
+
+// UpdateDeviceCodec sets the codec of the given device. Setting an empty
+// codec type removes the override.
+func UpdateDeviceCodec(ctx context.Context, db sqlx.Execer, devEUI lorawan.EUI64, c DeviceCodec) error {
+	defer observeQueryDuration("device_codec_update", time.Now())
+
+	if err := c.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	res, err := db.Exec(`
+		update device
+		set
+			updated_at = $2,
+			payload_codec = $3,
+			payload_encoder_script = $4,
+			payload_decoder_script = $5
+		where
+			dev_eui = $1`,
+		devEUI[:],
+		time.Now(),
+		c.Type,
+		c.EncoderScript,
+		c.DecoderScript,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	invalidateDeviceCache(ctx, devEUI)
+
+	log.WithFields(log.Fields{
+		"dev_eui": devEUI,
+		"codec":   c.Type,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("device codec updated")
+
+	return nil
+}
+
+// GetPayloadCodec returns the codec type and encoder and decoder scripts
+// which must be used for the given device. The device codec overrides the
+// device-profile codec, which overrides the application codec.
+func GetPayloadCodec(app Application, dp DeviceProfile, d Device) DeviceCodec {
+	// TODO: in the next major release, remove the application codec fields
+	// and always use the device-profile codec fields.
+	c := DeviceCodec{
+		Type:          app.PayloadCodec,
+		EncoderScript: app.PayloadEncoderScript,
+		DecoderScript: app.PayloadDecoderScript,
+	}
+
+	if dp.PayloadCodec != codec.None {
+		c = DeviceCodec{
+			Type:          dp.PayloadCodec,
+			EncoderScript: dp.PayloadEncoderScript,
+			DecoderScript: dp.PayloadDecoderScript,
+		}
+	}
+
+	if d.PayloadCodec != codec.None {
+		c = DeviceCodec{
+			Type:          d.PayloadCodec,
+			EncoderScript: d.PayloadEncoderScript,
+			DecoderScript: d.PayloadDecoderScript,
+		}
+	}
+
+	return c
+}
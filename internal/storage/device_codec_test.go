@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/codec"
+	//"github.com/brocaar/lorawan"
+)
+
+func TestGetPayloadCodec(t *testing.T) {
+	app := Application{
+		PayloadCodec:         codec.CustomJSType,
+		PayloadDecoderScript: "app-decoder",
+		PayloadEncoderScript: "app-encoder",
+	}
+	dp := DeviceProfile{
+		PayloadCodec:         codec.CustomLuaType,
+		PayloadDecoderScript: "dp-decoder",
+		PayloadEncoderScript: "dp-encoder",
+	}
+	d := Device{
+		PayloadCodec:         codec.CustomJSType,
+		PayloadDecoderScript: "device-decoder",
+		PayloadEncoderScript: "device-encoder",
+	}
+
+	tests := []struct {
+		Name          string
+		Application   Application
+		DeviceProfile DeviceProfile
+		Device        Device
+		Expected      DeviceCodec
+	}{
+		{
+			Name:     "no codec",
+			Expected: DeviceCodec{},
+		},
+		{
+			Name:        "application codec",
+			Application: app,
+			Expected:    DeviceCodec{Type: codec.CustomJSType, DecoderScript: "app-decoder", EncoderScript: "app-encoder"},
+		},
+		{
+			Name:          "device-profile codec",
+			Application:   app,
+			DeviceProfile: dp,
+			Expected:      DeviceCodec{Type: codec.CustomLuaType, DecoderScript: "dp-decoder", EncoderScript: "dp-encoder"},
+		},
+		{
+			Name:          "device codec",
+			Application:   app,
+			DeviceProfile: dp,
+			Device:        d,
+			Expected:      DeviceCodec{Type: codec.CustomJSType, DecoderScript: "device-decoder", EncoderScript: "device-encoder"},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(tst.Expected, GetPayloadCodec(tst.Application, tst.DeviceProfile, tst.Device))
+		})
+	}
+}
+
+func (ts *StorageTestSuite) TestDeviceCodec() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(context.Background(), ts.Tx(), &org))
+
+	n := NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(CreateNetworkServer(context.Background(), ts.Tx(), &n))
+
+	sp := ServiceProfile{
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+		Name:            "test-sp",
+	}
+	assert.NoError(CreateServiceProfile(context.Background(), ts.Tx(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	dp := DeviceProfile{
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+		Name:            "test-dp",
+	}
+	assert.NoError(CreateDeviceProfile(context.Background(), ts.Tx(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(context.Background(), ts.Tx(), &app))
+
+	d := Device{
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "test-device",
+	}
+	assert.NoError(CreateDevice(context.Background(), ts.Tx(), &d))
+
+	ts.T().Run("Update", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(UpdateDeviceCodec(context.Background(), ts.Tx(), d.DevEUI, DeviceCodec{
+			Type:          codec.CustomJSType,
+			DecoderScript: "function Decode() {}",
+			EncoderScript: "function Encode() {}",
+		}))
+
+		d, err := GetDevice(context.Background(), ts.Tx(), d.DevEUI, false, true)
+		assert.NoError(err)
+		assert.Equal(codec.CustomJSType, d.PayloadCodec)
+		assert.Equal("function Decode() {}", d.PayloadDecoderScript)
+		assert.Equal("function Encode() {}", d.PayloadEncoderScript)
+	})
+
+	ts.T().Run("Remove", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(UpdateDeviceCodec(context.Background(), ts.Tx(), d.DevEUI, DeviceCodec{}))
+
+		d, err := GetDevice(context.Background(), ts.Tx(), d.DevEUI, false, true)
+		assert.NoError(err)
+		assert.Equal(codec.Type(codec.None), d.PayloadCodec)
+	})
+
+	ts.T().Run("Invalid codec", func(t *testing.T) {
+		assert := require.New(t)
+
+		err := UpdateDeviceCodec(context.Background(), ts.Tx(), d.DevEUI, DeviceCodec{Type: "FOO"})
+		assert.Equal(ErrDeviceInvalidPayloadCodec, errors.Cause(err))
+	})
+
+	ts.T().Run("Unknown device", func(t *testing.T) {
+		assert := require.New(t)
+
+		err := UpdateDeviceCodec(context.Background(), ts.Tx(), lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1}, DeviceCodec{})
+		assert.Equal(ErrDoesNotExist, err)
+	})
+}
@@ -0,0 +1,260 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+// DeviceFrameLog defines a received (uplink) frame of a device.
+type DeviceFrameLog struct {
+	DevEUI          lorawan.EUI64   `db:"dev_eui"`
+	ApplicationID   int64           `db:"application_id"`
+	ReceivedAt      time.Time       `db:"received_at"`
+	DevAddr         lorawan.DevAddr `db:"dev_addr"`
+	FCnt            uint32          `db:"f_cnt"`
+	FPort           uint8           `db:"f_port"`
+	DR              int             `db:"dr"`
+	ADR             bool            `db:"adr"`
+	ConfirmedUplink bool            `db:"confirmed_uplink"`
+	Data            []byte          `db:"data"`
+	Object          json.RawMessage `db:"object"`
+	RXInfo          json.RawMessage `db:"rx_info"`
+}
+
+// DeviceFrameLogRXInfo defines the per gateway RX metadata which is stored
+// as part of the frame-log.
+type DeviceFrameLogRXInfo struct {
+	GatewayID    lorawan.EUI64 `json:"gatewayID"`
+	RSSI         int           `json:"rssi"`
+	LoRaSNR      float64       `json:"loRaSNR"`
+	RoamingNetID string        `json:"roamingNetID,omitempty"`
+}
+
+// CreateDeviceFrameLog stores the given frame.
+func CreateDeviceFrameLog(ctx context.Context, db sqlx.Execer, fl DeviceFrameLog) error {
+	defer observeQueryDuration("device_frame_log_write", time.Now())
+
+	_, err := db.Exec(`
+		insert into device_frame_log (
+			dev_eui,
+			application_id,
+			received_at,
+			dev_addr,
+			f_cnt,
+			f_port,
+			dr,
+			adr,
+			confirmed_uplink,
+			data,
+			object,
+			rx_info
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		fl.DevEUI[:],
+		fl.ApplicationID,
+		fl.ReceivedAt,
+		fl.DevAddr[:],
+		fl.FCnt,
+		fl.FPort,
+		fl.DR,
+		fl.ADR,
+		fl.ConfirmedUplink,
+		fl.Data,
+		nullJSON(fl.Object),
+		nullJSON(fl.RXInfo),
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": fl.DevEUI,
+		"f_cnt":   fl.FCnt,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Debug("device frame-log created")
+
+	return nil
+}
+
+// CreateDeviceFrameLogs stores the given frames using multi-row inserts.
+func CreateDeviceFrameLogs(ctx context.Context, db sqlx.Execer, items []DeviceFrameLog) error {
+	defer observeQueryDuration("device_frame_log_write_batch", time.Now())
+
+	count := len(items)
+	for len(items) > 0 {
+		n := len(items)
+		if n > maxInsertRows {
+			n = maxInsertRows
+		}
+
+		args := make([]interface{}, 0, n*12)
+		for _, fl := range items[:n] {
+			args = append(args,
+				fl.DevEUI[:],
+				fl.ApplicationID,
+				fl.ReceivedAt,
+				fl.DevAddr[:],
+				fl.FCnt,
+				fl.FPort,
+				fl.DR,
+				fl.ADR,
+				fl.ConfirmedUplink,
+				fl.Data,
+				nullJSON(fl.Object),
+				nullJSON(fl.RXInfo),
+			)
+		}
+
+		_, err := db.Exec(`
+			insert into device_frame_log (
+				dev_eui,
+				application_id,
+				received_at,
+				dev_addr,
+				f_cnt,
+				f_port,
+				dr,
+				adr,
+				confirmed_uplink,
+				data,
+				object,
+				rx_info
+			) values `+insertValues(n, 12), args...)
+		if err != nil {
+			return handlePSQLError(Insert, err, "insert error")
+		}
+
+		items = items[n:]
+	}
+
+	log.WithFields(log.Fields{
+		"count":  count,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Debug("device frame-logs created")
+
+	return nil
+}
+
+// GetDeviceFrameLogs returns the frames received for the given DevEUI
+// within the given time range, sorted by received timestamp.
+func GetDeviceFrameLogs(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64, start, end time.Time, limit int) ([]DeviceFrameLog, error) {
+	defer observeQueryDuration("device_frame_log_get", time.Now())
+
+	var out []DeviceFrameLog
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			device_frame_log
+		where
+			dev_eui = $1
+			and received_at >= $2
+			and received_at < $3
+		order by
+			received_at
+		limit $4`,
+		devEUI[:],
+		start,
+		end,
+		limit,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// nullJSON returns nil in case the given JSON is empty, so that it is stored
+// as NULL instead of an invalid (empty) jsonb value.
+func nullJSON(b json.RawMessage) interface{} {
+	if len(b) == 0 {
+		return nil
+	}
+	return []byte(b)
+}
+
+// DeviceFrameLogArchiveRow defines a frame-log row for archival. The data
+// contains the complete row encoded as JSON.
+type DeviceFrameLogArchiveRow struct {
+	DevEUI        lorawan.EUI64   `db:"dev_eui"`
+	ApplicationID int64           `db:"application_id"`
+	ReceivedAt    time.Time       `db:"received_at"`
+	Data          json.RawMessage `db:"data"`
+}
+
+// GetOldestDeviceFrameLogTime returns the received timestamp of the oldest
+// frame-log. It returns ErrDoesNotExist when there are no frame-logs.
+func GetOldestDeviceFrameLogTime(ctx context.Context, db sqlx.Queryer) (time.Time, error) {
+	var ts *time.Time
+	if err := sqlx.Get(db, &ts, "select min(received_at) from device_frame_log"); err != nil {
+		return time.Time{}, handlePSQLError(Select, err, "select error")
+	}
+	if ts == nil {
+		return time.Time{}, ErrDoesNotExist
+	}
+	return *ts, nil
+}
+
+// GetDeviceFrameLogArchiveRows returns the frame-logs of all devices within
+// the given time range, sorted by received timestamp.
+func GetDeviceFrameLogArchiveRows(ctx context.Context, db sqlx.Queryer, start, end time.Time) ([]DeviceFrameLogArchiveRow, error) {
+	var out []DeviceFrameLogArchiveRow
+	err := sqlx.Select(db, &out, `
+		select
+			dev_eui,
+			application_id,
+			received_at,
+			row_to_json(fl)::text as data
+		from
+			device_frame_log fl
+		where
+			received_at >= $1
+			and received_at < $2
+		order by
+			received_at`,
+		start,
+		end,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// DeleteDeviceFrameLogsForRange deletes the frame-logs of all devices within
+// the given time range. It returns the number of deleted frame-logs.
+func DeleteDeviceFrameLogsForRange(ctx context.Context, db sqlx.Execer, start, end time.Time) (int64, error) {
+	res, err := db.Exec(`
+		delete from device_frame_log
+		where
+			received_at >= $1
+			and received_at < $2`,
+		start,
+		end,
+	)
+	if err != nil {
+		return 0, handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "get rows affected error")
+	}
+
+	log.WithFields(log.Fields{
+		"start":  start,
+		"end":    end,
+		"count":  ra,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("device frame-logs deleted")
+
+	return ra, nil
+}
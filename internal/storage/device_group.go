@@ -0,0 +1,527 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/lib/pq/hstore"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+// DeviceGroupType defines the type of a device group.
+type DeviceGroupType string
+
+// Available device group types.
+const (
+	DeviceGroupTypeManual DeviceGroupType = "MANUAL"
+	DeviceGroupTypeTag    DeviceGroupType = "TAG"
+)
+
+// DeviceGroup defines a named group of devices of an application. The
+// devices of a MANUAL group are added and removed explicitly, a TAG group
+// contains all the devices of the application having all the DeviceTags.
+type DeviceGroup struct {
+	ID            uuid.UUID       `db:"id"`
+	ApplicationID int64           `db:"application_id"`
+	CreatedAt     time.Time       `db:"created_at"`
+	UpdatedAt     time.Time       `db:"updated_at"`
+	Name          string          `db:"name"`
+	Description   string          `db:"description"`
+	Type          DeviceGroupType `db:"type"`
+	DeviceTags    hstore.Hstore   `db:"device_tags"`
+}
+
+// Validate validates the device group data.
+func (g DeviceGroup) Validate() error {
+	if strings.TrimSpace(g.Name) == "" || len(g.Name) > 100 {
+		return ErrDeviceGroupInvalidName
+	}
+
+	switch g.Type {
+	case DeviceGroupTypeManual:
+		if len(g.DeviceTags.Map) != 0 {
+			return ErrDeviceGroupInvalidTags
+		}
+	case DeviceGroupTypeTag:
+		if len(g.DeviceTags.Map) == 0 {
+			return ErrDeviceGroupInvalidTags
+		}
+	default:
+		return ErrDeviceGroupInvalidType
+	}
+
+	return nil
+}
+
+// devicesSQL returns the SQL filter on the devices (aliased as d) of the
+// group, using the named arguments returned by devicesArgs.
+func (g DeviceGroup) devicesSQL() string {
+	// as a TAG group has at least one tag, devices without tags never match
+	if g.Type == DeviceGroupTypeTag {
+		return "d.application_id = :application_id and d.tags @> :device_tags"
+	}
+
+	return `d.application_id = :application_id and exists (
+		select
+			1
+		from
+			device_group_device dgd
+		where
+			dgd.device_group_id = :id
+			and dgd.dev_eui = d.dev_eui
+	)`
+}
+
+// devicesArgs returns the named arguments of the devicesSQL filter.
+func (g DeviceGroup) devicesArgs() map[string]interface{} {
+	return map[string]interface{}{
+		"id":             g.ID,
+		"application_id": g.ApplicationID,
+		"device_tags":    g.DeviceTags,
+	}
+}
+
+// DeviceGroupFilters provides filters for filtering device groups.
+type DeviceGroupFilters struct {
+	ApplicationID int64 `db:"application_id"`
+
+	// Limit and Offset are added for convenience so that this struct can
+	// be given as the arguments.
+	Limit  int `db:"limit"`
+	Offset int `db:"offset"`
+}
+
+// SQL returns the SQL filters.
+func (f DeviceGroupFilters) SQL() string {
+	var filters []string
+
+	if f.ApplicationID != 0 {
+		filters = append(filters, "application_id = :application_id")
+	}
+
+	if len(filters) == 0 {
+		return ""
+	}
+
+	return "where " + strings.Join(filters, " and ")
+}
+
+// DeviceGroupMeasurement defines the aggregated values of a measurement of
+// the devices of a group. Latest holds the most recent value of any device
+// of the group.
+type DeviceGroupMeasurement struct {
+	Name     string    `db:"name"`
+	Devices  int       `db:"devices"`
+	Count    int       `db:"count"`
+	Min      float64   `db:"min"`
+	Max      float64   `db:"max"`
+	Avg      float64   `db:"avg"`
+	Latest   float64   `db:"latest"`
+	LatestAt time.Time `db:"latest_at"`
+}
+
+// DeviceGroupStatus defines the status summary of the devices of a group.
+// ActiveDevices holds the number of devices seen since the given time. The
+// battery levels are nil when none of the devices reported its battery
+// level.
+type DeviceGroupStatus struct {
+	Devices         int        `db:"devices"`
+	ActiveDevices   int        `db:"active_devices"`
+	LastSeenAt      *time.Time `db:"last_seen_at"`
+	MinBatteryLevel *float64   `db:"min_battery_level"`
+	AvgBatteryLevel *float64   `db:"avg_battery_level"`
+}
+
+// CreateDeviceGroup creates the given device group.
+func CreateDeviceGroup(ctx context.Context, db sqlx.Execer, g *DeviceGroup) error {
+	if err := g.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	var err error
+	g.ID, err = uuid.NewV4()
+	if err != nil {
+		return errors.Wrap(err, "new uuid v4 error")
+	}
+
+	now := time.Now()
+	g.CreatedAt = now
+	g.UpdatedAt = now
+
+	if g.DeviceTags.Map == nil {
+		g.DeviceTags.Map = make(map[string]sql.NullString)
+	}
+
+	_, err = db.Exec(`
+		insert into device_group (
+			id,
+			application_id,
+			created_at,
+			updated_at,
+			name,
+			description,
+			type,
+			device_tags
+		) values ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		g.ID,
+		g.ApplicationID,
+		g.CreatedAt,
+		g.UpdatedAt,
+		g.Name,
+		g.Description,
+		g.Type,
+		g.DeviceTags,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"id":             g.ID,
+		"application_id": g.ApplicationID,
+		"ctx_id":         ctx.Value(logging.ContextIDKey),
+	}).Info("device group created")
+
+	return nil
+}
+
+// GetDeviceGroup returns the device group for the given id.
+func GetDeviceGroup(ctx context.Context, db sqlx.Queryer, id uuid.UUID) (DeviceGroup, error) {
+	var g DeviceGroup
+	if err := sqlx.Get(db, &g, "select * from device_group where id = $1", id); err != nil {
+		return g, handlePSQLError(Select, err, "select error")
+	}
+
+	return g, nil
+}
+
+// GetDeviceGroupCount returns the number of device groups matching the
+// given filters.
+func GetDeviceGroupCount(ctx context.Context, db sqlx.Queryer, filters DeviceGroupFilters) (int, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			count(*)
+		from
+			device_group
+		`+filters.SQL(), filters)
+	if err != nil {
+		return 0, errors.Wrap(err, "named query error")
+	}
+
+	var count int
+	if err := sqlx.Get(db, &count, query, args...); err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	return count, nil
+}
+
+// GetDeviceGroups returns the device groups matching the given filters,
+// sorted by name.
+func GetDeviceGroups(ctx context.Context, db sqlx.Queryer, filters DeviceGroupFilters) ([]DeviceGroup, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			*
+		from
+			device_group
+		`+filters.SQL()+`
+		order by
+			name,
+			id
+		limit :limit
+		offset :offset`, filters)
+	if err != nil {
+		return nil, errors.Wrap(err, "named query error")
+	}
+
+	var out []DeviceGroup
+	if err := sqlx.Select(db, &out, query, args...); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// UpdateDeviceGroup updates the given device group. When the group is
+// changed into a TAG group, its manually added devices are removed.
+func UpdateDeviceGroup(ctx context.Context, db sqlx.Execer, g *DeviceGroup) error {
+	if err := g.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	g.UpdatedAt = time.Now()
+
+	if g.DeviceTags.Map == nil {
+		g.DeviceTags.Map = make(map[string]sql.NullString)
+	}
+
+	res, err := db.Exec(`
+		update device_group
+		set
+			updated_at = $2,
+			name = $3,
+			description = $4,
+			type = $5,
+			device_tags = $6
+		where
+			id = $1`,
+		g.ID,
+		g.UpdatedAt,
+		g.Name,
+		g.Description,
+		g.Type,
+		g.DeviceTags,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	if g.Type == DeviceGroupTypeTag {
+		if _, err := db.Exec("delete from device_group_device where device_group_id = $1", g.ID); err != nil {
+			return handlePSQLError(Delete, err, "delete error")
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"id":     g.ID,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("device group updated")
+
+	return nil
+}
+
+// DeleteDeviceGroup deletes the device group.
+func DeleteDeviceGroup(ctx context.Context, db sqlx.Execer, id uuid.UUID) error {
+	res, err := db.Exec("delete from device_group where id = $1", id)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"id":     id,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("device group deleted")
+
+	return nil
+}
+
+// AddDeviceGroupDevice adds the device to the given (MANUAL) device group.
+// The caller must validate that the device belongs to the application of
+// the group.
+func AddDeviceGroupDevice(ctx context.Context, db sqlx.Execer, g DeviceGroup, devEUI lorawan.EUI64) error {
+	if g.Type != DeviceGroupTypeManual {
+		return ErrDeviceGroupNotManual
+	}
+
+	_, err := db.Exec(`
+		insert into device_group_device (
+			device_group_id,
+			dev_eui,
+			created_at
+		) values ($1, $2, $3)`,
+		g.ID,
+		devEUI[:],
+		time.Now(),
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"id":      g.ID,
+		"dev_eui": devEUI,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("device added to device group")
+
+	return nil
+}
+
+// RemoveDeviceGroupDevice removes the device from the given (MANUAL) device
+// group.
+func RemoveDeviceGroupDevice(ctx context.Context, db sqlx.Execer, g DeviceGroup, devEUI lorawan.EUI64) error {
+	if g.Type != DeviceGroupTypeManual {
+		return ErrDeviceGroupNotManual
+	}
+
+	res, err := db.Exec("delete from device_group_device where device_group_id = $1 and dev_eui = $2", g.ID, devEUI[:])
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"id":      g.ID,
+		"dev_eui": devEUI,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("device removed from device group")
+
+	return nil
+}
+
+// GetDeviceGroupDeviceCount returns the number of devices of the given
+// device group.
+func GetDeviceGroupDeviceCount(ctx context.Context, db sqlx.Queryer, g DeviceGroup) (int, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			count(*)
+		from
+			device d
+		where
+			`+g.devicesSQL(), g.devicesArgs())
+	if err != nil {
+		return 0, errors.Wrap(err, "named query error")
+	}
+
+	var count int
+	if err := sqlx.Get(db, &count, query, args...); err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	return count, nil
+}
+
+// GetDeviceGroupDevices returns the devices of the given device group,
+// sorted by name.
+func GetDeviceGroupDevices(ctx context.Context, db sqlx.Queryer, g DeviceGroup, limit, offset int) ([]Device, error) {
+	args := g.devicesArgs()
+	args["limit"] = limit
+	args["offset"] = offset
+
+	query, queryArgs, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			d.*
+		from
+			device d
+		where
+			`+g.devicesSQL()+`
+		order by
+			d.name,
+			d.dev_eui
+		limit :limit
+		offset :offset`, args)
+	if err != nil {
+		return nil, errors.Wrap(err, "named query error")
+	}
+
+	var out []Device
+	if err := sqlx.Select(db, &out, query, queryArgs...); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetDeviceGroupStatus returns the status summary of the devices of the
+// given device group. Devices seen since the given time are counted as
+// active.
+func GetDeviceGroupStatus(ctx context.Context, db sqlx.Queryer, g DeviceGroup, since time.Time) (DeviceGroupStatus, error) {
+	defer observeQueryDuration("device_group_status_get", time.Now())
+
+	args := g.devicesArgs()
+	args["since"] = since
+
+	query, queryArgs, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			count(*) as devices,
+			count(*) filter (where d.last_seen_at >= :since) as active_devices,
+			max(d.last_seen_at) as last_seen_at,
+			min(d.device_status_battery) as min_battery_level,
+			avg(d.device_status_battery) as avg_battery_level
+		from
+			device d
+		where
+			`+g.devicesSQL(), args)
+	if err != nil {
+		return DeviceGroupStatus{}, errors.Wrap(err, "named query error")
+	}
+
+	var out DeviceGroupStatus
+	if err := sqlx.Get(db, &out, query, queryArgs...); err != nil {
+		return out, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetDeviceGroupMeasurements returns the aggregated measurement values of
+// the devices of the given device group, received within the given time
+// range and sorted by measurement name. When measurements is empty, all
+// measurements are aggregated. When the schema-per-organization mode is
+// enabled this must be called using ForOrganization.
+func GetDeviceGroupMeasurements(ctx context.Context, db sqlx.Queryer, g DeviceGroup, start, end time.Time, measurements []string) ([]DeviceGroupMeasurement, error) {
+	defer observeQueryDuration("device_group_measurements_get", time.Now())
+
+	args := g.devicesArgs()
+	args["start"] = start
+	args["end"] = end
+	args["measurements"] = pq.StringArray(measurements)
+
+	measurementsSQL := ""
+	if len(measurements) != 0 {
+		measurementsSQL = "and m.name = any(:measurements)"
+	}
+
+	query, queryArgs, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			m.name,
+			count(distinct m.dev_eui) as devices,
+			count(*) as count,
+			min(m.value) as min,
+			max(m.value) as max,
+			avg(m.value) as avg,
+			(array_agg(m.value order by m.time desc))[1] as latest,
+			max(m.time) as latest_at
+		from
+			device_metric m
+		inner join device d
+			on d.dev_eui = m.dev_eui
+		where
+			`+g.devicesSQL()+`
+			and m.application_id = :application_id
+			and m.time >= :start
+			and m.time < :end
+			`+measurementsSQL+`
+		group by
+			m.name
+		order by
+			m.name`, args)
+	if err != nil {
+		return nil, errors.Wrap(err, "named query error")
+	}
+
+	var out []DeviceGroupMeasurement
+	if err := sqlx.Select(db, &out, query, queryArgs...); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
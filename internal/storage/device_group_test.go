@@ -0,0 +1,275 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/lib/pq/hstore"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestDeviceGroup() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(ctx, ts.Tx(), &org))
+
+	n := NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(CreateNetworkServer(ctx, ts.Tx(), &n))
+
+	sp := ServiceProfile{
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+		Name:            "test-sp",
+	}
+	assert.NoError(CreateServiceProfile(ctx, ts.Tx(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(ctx, ts.Tx(), &app))
+
+	dp := DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateDeviceProfile(ctx, ts.Tx(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	tags := func(kv ...string) hstore.Hstore {
+		h := hstore.Hstore{Map: make(map[string]sql.NullString)}
+		for i := 0; i < len(kv); i += 2 {
+			h.Map[kv[i]] = sql.NullString{String: kv[i+1], Valid: true}
+		}
+		return h
+	}
+
+	battery := func(f float32) *float32 { return &f }
+	now := time.Now().Round(time.Second)
+	lastSeen := now.Add(-time.Hour)
+	longAgo := now.Add(-48 * time.Hour)
+
+	devices := []Device{
+		{DevEUI: lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 1}, Name: "valve-1", Tags: tags("type", "valve", "zone", "north"), DeviceStatusBattery: battery(80), LastSeenAt: &lastSeen},
+		{DevEUI: lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 2}, Name: "valve-2", Tags: tags("type", "valve", "zone", "south"), DeviceStatusBattery: battery(40), LastSeenAt: &longAgo},
+		{DevEUI: lorawan.EUI64{2, 2, 2, 2, 2, 2, 2, 1}, Name: "sensor-1", Tags: tags("type", "sensor")},
+		{DevEUI: lorawan.EUI64{3, 3, 3, 3, 3, 3, 3, 1}, Name: "no-tags"},
+	}
+	for i := range devices {
+		devices[i].ApplicationID = app.ID
+		devices[i].DeviceProfileID = dpID
+		assert.NoError(CreateDevice(ctx, ts.Tx(), &devices[i]))
+	}
+
+	ts.T().Run("Create invalid", func(t *testing.T) {
+		assert := require.New(t)
+
+		tests := []struct {
+			Name          string
+			Group         DeviceGroup
+			ExpectedError error
+		}{
+			{
+				Name:          "no name",
+				Group:         DeviceGroup{Type: DeviceGroupTypeManual},
+				ExpectedError: ErrDeviceGroupInvalidName,
+			},
+			{
+				Name:          "invalid type",
+				Group:         DeviceGroup{Name: "group", Type: "DYNAMIC"},
+				ExpectedError: ErrDeviceGroupInvalidType,
+			},
+			{
+				Name:          "tag group without tags",
+				Group:         DeviceGroup{Name: "group", Type: DeviceGroupTypeTag},
+				ExpectedError: ErrDeviceGroupInvalidTags,
+			},
+			{
+				Name:          "manual group with tags",
+				Group:         DeviceGroup{Name: "group", Type: DeviceGroupTypeManual, DeviceTags: tags("type", "valve")},
+				ExpectedError: ErrDeviceGroupInvalidTags,
+			},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.Name, func(t *testing.T) {
+				g := tst.Group
+				g.ApplicationID = app.ID
+				assert.Equal(tst.ExpectedError, errors.Cause(CreateDeviceGroup(ctx, ts.Tx(), &g)))
+			})
+		}
+	})
+
+	ts.T().Run("Manual group", func(t *testing.T) {
+		assert := require.New(t)
+
+		g := DeviceGroup{
+			ApplicationID: app.ID,
+			Name:          "pilot devices",
+			Description:   "Devices of the pilot",
+			Type:          DeviceGroupTypeManual,
+		}
+		assert.NoError(CreateDeviceGroup(ctx, ts.Tx(), &g))
+
+		g.CreatedAt = g.CreatedAt.Round(time.Second).UTC()
+		g.UpdatedAt = g.UpdatedAt.Round(time.Second).UTC()
+
+		gGet, err := GetDeviceGroup(ctx, ts.Tx(), g.ID)
+		assert.NoError(err)
+		gGet.CreatedAt = gGet.CreatedAt.Round(time.Second).UTC()
+		gGet.UpdatedAt = gGet.UpdatedAt.Round(time.Second).UTC()
+		assert.Equal(g, gGet)
+
+		assert.NoError(AddDeviceGroupDevice(ctx, ts.Tx(), g, devices[0].DevEUI))
+		assert.NoError(AddDeviceGroupDevice(ctx, ts.Tx(), g, devices[2].DevEUI))
+		assert.Equal(ErrAlreadyExists, AddDeviceGroupDevice(ctx, ts.Tx(), g, devices[2].DevEUI))
+
+		count, err := GetDeviceGroupDeviceCount(ctx, ts.Tx(), g)
+		assert.NoError(err)
+		assert.Equal(2, count)
+
+		items, err := GetDeviceGroupDevices(ctx, ts.Tx(), g, 10, 0)
+		assert.NoError(err)
+		assert.Len(items, 2)
+		assert.Equal("sensor-1", items[0].Name)
+		assert.Equal("valve-1", items[1].Name)
+
+		assert.NoError(RemoveDeviceGroupDevice(ctx, ts.Tx(), g, devices[2].DevEUI))
+		assert.Equal(ErrDoesNotExist, RemoveDeviceGroupDevice(ctx, ts.Tx(), g, devices[2].DevEUI))
+
+		count, err = GetDeviceGroupDeviceCount(ctx, ts.Tx(), g)
+		assert.NoError(err)
+		assert.Equal(1, count)
+
+		t.Run("Change into tag group", func(t *testing.T) {
+			assert := require.New(t)
+
+			g.Type = DeviceGroupTypeTag
+			g.DeviceTags = tags("type", "valve")
+			assert.NoError(UpdateDeviceGroup(ctx, ts.Tx(), &g))
+
+			count, err := GetDeviceGroupDeviceCount(ctx, ts.Tx(), g)
+			assert.NoError(err)
+			assert.Equal(2, count)
+
+			// the manually added device has been removed
+			g.Type = DeviceGroupTypeManual
+			g.DeviceTags = hstore.Hstore{}
+			assert.NoError(UpdateDeviceGroup(ctx, ts.Tx(), &g))
+
+			count, err = GetDeviceGroupDeviceCount(ctx, ts.Tx(), g)
+			assert.NoError(err)
+			assert.Equal(0, count)
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(DeleteDeviceGroup(ctx, ts.Tx(), g.ID))
+			assert.Equal(ErrDoesNotExist, DeleteDeviceGroup(ctx, ts.Tx(), g.ID))
+
+			_, err := GetDeviceGroup(ctx, ts.Tx(), g.ID)
+			assert.Equal(ErrDoesNotExist, errors.Cause(err))
+		})
+	})
+
+	ts.T().Run("Tag group", func(t *testing.T) {
+		assert := require.New(t)
+
+		g := DeviceGroup{
+			ApplicationID: app.ID,
+			Name:          "valves",
+			Type:          DeviceGroupTypeTag,
+			DeviceTags:    tags("type", "valve"),
+		}
+		assert.NoError(CreateDeviceGroup(ctx, ts.Tx(), &g))
+		assert.Equal(ErrDeviceGroupNotManual, AddDeviceGroupDevice(ctx, ts.Tx(), g, devices[2].DevEUI))
+
+		items, err := GetDeviceGroupDevices(ctx, ts.Tx(), g, 10, 0)
+		assert.NoError(err)
+		assert.Len(items, 2)
+		assert.Equal("valve-1", items[0].Name)
+		assert.Equal("valve-2", items[1].Name)
+
+		items, err = GetDeviceGroupDevices(ctx, ts.Tx(), g, 1, 1)
+		assert.NoError(err)
+		assert.Len(items, 1)
+		assert.Equal("valve-2", items[0].Name)
+
+		count, err := GetDeviceGroupCount(ctx, ts.Tx(), DeviceGroupFilters{ApplicationID: app.ID})
+		assert.NoError(err)
+		assert.Equal(1, count)
+
+		groups, err := GetDeviceGroups(ctx, ts.Tx(), DeviceGroupFilters{ApplicationID: app.ID, Limit: 10})
+		assert.NoError(err)
+		assert.Len(groups, 1)
+		assert.Equal(g.ID, groups[0].ID)
+
+		t.Run("Status", func(t *testing.T) {
+			assert := require.New(t)
+
+			status, err := GetDeviceGroupStatus(ctx, ts.Tx(), g, now.Add(-24*time.Hour))
+			assert.NoError(err)
+			assert.Equal(2, status.Devices)
+			assert.Equal(1, status.ActiveDevices)
+			assert.True(lastSeen.Equal(*status.LastSeenAt))
+			assert.Equal(float64(40), *status.MinBatteryLevel)
+			assert.Equal(float64(60), *status.AvgBatteryLevel)
+		})
+
+		t.Run("Measurements", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(CreateDeviceMetrics(ctx, ts.Tx(), []DeviceMetric{
+				{DevEUI: devices[0].DevEUI, ApplicationID: app.ID, Time: now.Add(-2 * time.Hour), Name: "pressure", Value: 2},
+				{DevEUI: devices[1].DevEUI, ApplicationID: app.ID, Time: now.Add(-time.Hour), Name: "pressure", Value: 4},
+				{DevEUI: devices[0].DevEUI, ApplicationID: app.ID, Time: now.Add(-time.Hour), Name: "flow", Value: 10},
+				{DevEUI: devices[2].DevEUI, ApplicationID: app.ID, Time: now.Add(-time.Hour), Name: "pressure", Value: 8},
+				{DevEUI: devices[0].DevEUI, ApplicationID: app.ID, Time: now.Add(-48 * time.Hour), Name: "pressure", Value: 1},
+			}))
+
+			items, err := GetDeviceGroupMeasurements(ctx, ts.Tx(), g, now.Add(-24*time.Hour), now, nil)
+			assert.NoError(err)
+			assert.Len(items, 2)
+			assert.Equal("flow", items[0].Name)
+
+			pressure := items[1]
+			assert.Equal("pressure", pressure.Name)
+			assert.Equal(2, pressure.Devices)
+			assert.Equal(2, pressure.Count)
+			assert.Equal(float64(2), pressure.Min)
+			assert.Equal(float64(4), pressure.Max)
+			assert.Equal(float64(3), pressure.Avg)
+			assert.Equal(float64(4), pressure.Latest)
+			assert.True(now.Add(-time.Hour).Equal(pressure.LatestAt))
+
+			items, err = GetDeviceGroupMeasurements(ctx, ts.Tx(), g, now.Add(-24*time.Hour), now, []string{"flow"})
+			assert.NoError(err)
+			assert.Len(items, 1)
+			assert.Equal("flow", items[0].Name)
+		})
+	})
+}
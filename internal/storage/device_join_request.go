@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+const (
+	deviceJoinRequestKeyTempl = "lora:as:device:{%s}:join-request" // dev_eui
+	deviceJoinRequestTTL      = time.Hour
+)
+
+// SetDeviceJoinRequestTime stores the time on which the join-server handled
+// the last join-request of the given device. It is used to calculate the
+// join latency once the device is activated.
+func SetDeviceJoinRequestTime(ctx context.Context, devEUI lorawan.EUI64, t time.Time) error {
+	key := GetRedisKey(deviceJoinRequestKeyTempl, devEUI)
+
+	if err := RedisClient().Set(key, t.UnixNano(), deviceJoinRequestTTL).Err(); err != nil {
+		return errors.Wrap(err, "set join-request time error")
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": devEUI,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Debug("storage: device join-request time set")
+
+	return nil
+}
+
+// GetDeviceJoinRequestTime returns the time on which the join-server handled
+// the last join-request of the given device. ErrDoesNotExist is returned when
+// no join-request was recorded or when it has expired.
+func GetDeviceJoinRequestTime(ctx context.Context, devEUI lorawan.EUI64) (time.Time, error) {
+	key := GetRedisKey(deviceJoinRequestKeyTempl, devEUI)
+
+	ns, err := RedisClient().Get(key).Int64()
+	if err != nil {
+		if err == redis.Nil {
+			return time.Time{}, ErrDoesNotExist
+		}
+		return time.Time{}, errors.Wrap(err, "get join-request time error")
+	}
+
+	return time.Unix(0, ns), nil
+}
+
+// DeleteDeviceJoinRequestTime deletes the join-request time of the given
+// device.
+func DeleteDeviceJoinRequestTime(ctx context.Context, devEUI lorawan.EUI64) error {
+	key := GetRedisKey(deviceJoinRequestKeyTempl, devEUI)
+
+	if err := RedisClient().Del(key).Err(); err != nil {
+		return errors.Wrap(err, "delete join-request time error")
+	}
+
+	return nil
+}
@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestDeviceJoinRequestTime() {
+	assert := require.New(ts.T())
+	RedisClient().FlushAll()
+
+	devEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+
+	_, err := GetDeviceJoinRequestTime(context.Background(), devEUI)
+	assert.Equal(ErrDoesNotExist, err)
+
+	now := time.Now()
+	assert.NoError(SetDeviceJoinRequestTime(context.Background(), devEUI, now))
+
+	t, err := GetDeviceJoinRequestTime(context.Background(), devEUI)
+	assert.NoError(err)
+	assert.True(now.Equal(t))
+
+	assert.NoError(DeleteDeviceJoinRequestTime(context.Background(), devEUI))
+	_, err = GetDeviceJoinRequestTime(context.Background(), devEUI)
+	assert.Equal(ErrDoesNotExist, err)
+}
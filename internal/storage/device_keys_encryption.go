@@ -0,0 +1,433 @@
+package storage
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/kms"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+// dataKeySize defines the size of the data-key (AES-256).
+const dataKeySize = 32
+
+// rotateBatchSize defines the number of device-keys re-encrypted per
+// iteration when rotating the data-key.
+const rotateBatchSize = 100
+
+// dataKeys caches the unwrapped data-keys by their id.
+var dataKeys sync.Map
+
+type deviceKeysDataKey struct {
+	ID         int64     `db:"id"`
+	CreatedAt  time.Time `db:"created_at"`
+	UpdatedAt  time.Time `db:"updated_at"`
+	Provider   string    `db:"provider"`
+	WrappedKey []byte    `db:"wrapped_key"`
+}
+
+// RotateDeviceKeysDataKey creates a new data-key and re-encrypts all
+// device-keys using this new data-key. Device-keys that are stored
+// unencrypted are encrypted. Data-keys that are no longer used are removed.
+// It returns the number of re-encrypted device-keys.
+func RotateDeviceKeysDataKey(ctx context.Context, db sqlx.Ext) (int, error) {
+	kw := kms.GetKeyWrapper()
+	if kw == nil {
+		return 0, errors.New("no kms provider configured")
+	}
+
+	dataKeyID, _, err := createDeviceKeysDataKey(ctx, db, kw)
+	if err != nil {
+		return 0, errors.Wrap(err, "create data-key error")
+	}
+
+	var count int
+	for {
+		var devEUIs []lorawan.EUI64
+		err := sqlx.Select(db, &devEUIs, `
+			select
+				dev_eui
+			from
+				device_keys
+			where
+				data_key_id is null
+				or data_key_id < $1
+			limit $2`,
+			dataKeyID,
+			rotateBatchSize,
+		)
+		if err != nil {
+			return count, handlePSQLError(Select, err, "select error")
+		}
+
+		if len(devEUIs) == 0 {
+			break
+		}
+
+		for _, devEUI := range devEUIs {
+			err := Transaction(func(tx sqlx.Ext) error {
+				return reEncryptDeviceKeys(ctx, tx, devEUI)
+			})
+			if err != nil {
+				return count, errors.Wrap(err, "re-encrypt device-keys error")
+			}
+			count++
+		}
+	}
+
+	// staged and previous keys of device-keys rotations
+	for {
+		var items []struct {
+			DevEUI lorawan.EUI64 `db:"dev_eui"`
+			Kind   string        `db:"kind"`
+		}
+		err := sqlx.Select(db, &items, `
+			select
+				dev_eui,
+				kind
+			from
+				device_keys_rotation
+			where
+				data_key_id is null
+				or data_key_id < $1
+			limit $2`,
+			dataKeyID,
+			rotateBatchSize,
+		)
+		if err != nil {
+			return count, handlePSQLError(Select, err, "select error")
+		}
+
+		if len(items) == 0 {
+			break
+		}
+
+		for _, item := range items {
+			if err := reEncryptDeviceKeysRotation(ctx, db, item.DevEUI, item.Kind); err != nil {
+				return count, errors.Wrap(err, "re-encrypt device-keys rotation error")
+			}
+		}
+	}
+
+	_, err = db.Exec(`
+		delete from
+			device_keys_data_key dk
+		where
+			dk.id < $1
+			and not exists (
+				select 1 from device_keys where data_key_id = dk.id
+			)
+			and not exists (
+				select 1 from device_keys_rotation where data_key_id = dk.id
+			)`,
+		dataKeyID,
+	)
+	if err != nil {
+		return count, handlePSQLError(Delete, err, "delete error")
+	}
+
+	log.WithFields(log.Fields{
+		"data_key_id": dataKeyID,
+		"count":       count,
+		"ctx_id":      ctx.Value(logging.ContextIDKey),
+	}).Info("device-keys data-key rotated")
+
+	return count, nil
+}
+
+// RewrapDeviceKeysDataKeys re-wraps all data-keys using the current key of
+// the configured kms provider. This must be used after rotating the key
+// of the kms provider, to be able to disable or remove older key versions.
+// It returns the number of re-wrapped data-keys.
+func RewrapDeviceKeysDataKeys(ctx context.Context, db sqlx.Ext) (int, error) {
+	kw := kms.GetKeyWrapper()
+	if kw == nil {
+		return 0, errors.New("no kms provider configured")
+	}
+
+	var items []deviceKeysDataKey
+	if err := sqlx.Select(db, &items, "select * from device_keys_data_key order by id"); err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	for _, item := range items {
+		if item.Provider != kw.Provider() {
+			return 0, fmt.Errorf("data-key %d is wrapped by provider %s, configured provider is %s", item.ID, item.Provider, kw.Provider())
+		}
+	}
+
+	for _, item := range items {
+		key, err := kw.Unwrap(ctx, item.WrappedKey)
+		if err != nil {
+			return 0, errors.Wrap(err, "unwrap data-key error")
+		}
+
+		wrapped, err := kw.Wrap(ctx, key)
+		if err != nil {
+			return 0, errors.Wrap(err, "wrap data-key error")
+		}
+
+		_, err = db.Exec(`
+			update device_keys_data_key
+			set
+				updated_at = $2,
+				wrapped_key = $3
+			where
+				id = $1`,
+			item.ID,
+			time.Now(),
+			wrapped,
+		)
+		if err != nil {
+			return 0, handlePSQLError(Update, err, "update error")
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"count":  len(items),
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("device-keys data-keys re-wrapped")
+
+	return len(items), nil
+}
+
+// DecryptDeviceKeys decrypts the root keys of the given device-keys in
+// case these are stored encrypted. This must be used when the device-keys
+// are directly selected from the database.
+func DecryptDeviceKeys(ctx context.Context, db sqlx.Queryer, dk *DeviceKeys) error {
+	if dk.DataKeyID == nil {
+		return nil
+	}
+
+	key, err := getDeviceKeysDataKey(ctx, db, *dk.DataKeyID)
+	if err != nil {
+		return errors.Wrap(err, "get data-key error")
+	}
+
+	aead, err := newDeviceKeysAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	if len(dk.EncryptedKeys) < aead.NonceSize() {
+		return errors.New("encrypted keys too short")
+	}
+	nonce := dk.EncryptedKeys[:aead.NonceSize()]
+
+	b, err := aead.Open(nil, nonce, dk.EncryptedKeys[aead.NonceSize():], dk.DevEUI[:])
+	if err != nil {
+		return errors.Wrap(err, "decrypt keys error")
+	}
+	if len(b) != 3*len(dk.NwkKey) {
+		return fmt.Errorf("expected %d bytes, got: %d", 3*len(dk.NwkKey), len(b))
+	}
+
+	copy(dk.NwkKey[:], b[0:16])
+	copy(dk.AppKey[:], b[16:32])
+	copy(dk.GenAppKey[:], b[32:48])
+
+	return nil
+}
+
+// encryptDeviceKeys encrypts the root keys of the given device-keys using
+// the active data-key and sets the DataKeyID and EncryptedKeys fields. When
+// no kms provider is configured, these fields are cleared.
+func encryptDeviceKeys(ctx context.Context, db sqlx.Queryer, dk *DeviceKeys) error {
+	kw := kms.GetKeyWrapper()
+	if kw == nil {
+		dk.DataKeyID = nil
+		dk.EncryptedKeys = nil
+		return nil
+	}
+
+	dataKeyID, key, err := getActiveDeviceKeysDataKey(ctx, db, kw)
+	if err != nil {
+		return errors.Wrap(err, "get active data-key error")
+	}
+
+	aead, err := newDeviceKeysAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return errors.Wrap(err, "read random bytes error")
+	}
+
+	var b []byte
+	b = append(b, dk.NwkKey[:]...)
+	b = append(b, dk.AppKey[:]...)
+	b = append(b, dk.GenAppKey[:]...)
+
+	dk.DataKeyID = &dataKeyID
+	dk.EncryptedKeys = aead.Seal(nonce, nonce, b, dk.DevEUI[:])
+
+	return nil
+}
+
+// storedKeys returns the root keys as they must be stored in the plain
+// columns. When the keys are encrypted, these are stored as null keys.
+func (dk DeviceKeys) storedKeys() (lorawan.AES128Key, lorawan.AES128Key, lorawan.AES128Key) {
+	if dk.DataKeyID != nil {
+		var null lorawan.AES128Key
+		return null, null, null
+	}
+	return dk.NwkKey, dk.AppKey, dk.GenAppKey
+}
+
+func reEncryptDeviceKeys(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64) error {
+	var dk DeviceKeys
+	err := sqlx.Get(db, &dk, "select * from device_keys where dev_eui = $1 for update", devEUI[:])
+	if err != nil {
+		return handlePSQLError(Select, err, "select error")
+	}
+
+	if err := DecryptDeviceKeys(ctx, db, &dk); err != nil {
+		return errors.Wrap(err, "decrypt device-keys error")
+	}
+
+	if err := encryptDeviceKeys(ctx, db, &dk); err != nil {
+		return errors.Wrap(err, "encrypt device-keys error")
+	}
+
+	nwkKey, appKey, genAppKey := dk.storedKeys()
+
+	_, err = db.Exec(`
+		update device_keys
+		set
+			nwk_key = $2,
+			app_key = $3,
+			gen_app_key = $4,
+			data_key_id = $5,
+			encrypted_keys = $6
+		where
+			dev_eui = $1`,
+		dk.DevEUI[:],
+		nwkKey[:],
+		appKey[:],
+		genAppKey[:],
+		dk.DataKeyID,
+		dk.EncryptedKeys,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+
+	return nil
+}
+
+func newDeviceKeysAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "new cipher error")
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "new gcm error")
+	}
+
+	return aead, nil
+}
+
+// getActiveDeviceKeysDataKey returns the most recent data-key. When no
+// data-key exists yet, it will be created.
+func getActiveDeviceKeysDataKey(ctx context.Context, db sqlx.Queryer, kw kms.KeyWrapper) (int64, []byte, error) {
+	var id int64
+	err := sqlx.Get(db, &id, "select id from device_keys_data_key order by id desc limit 1")
+	if err != nil {
+		if err := handlePSQLError(Select, err, "select error"); err != ErrDoesNotExist {
+			return 0, nil, err
+		}
+
+		return createDeviceKeysDataKey(ctx, db, kw)
+	}
+
+	key, err := getDeviceKeysDataKey(ctx, db, id)
+	return id, key, err
+}
+
+// getDeviceKeysDataKey returns the unwrapped data-key for the given id.
+func getDeviceKeysDataKey(ctx context.Context, db sqlx.Queryer, id int64) ([]byte, error) {
+	if v, ok := dataKeys.Load(id); ok {
+		return v.([]byte), nil
+	}
+
+	kw := kms.GetKeyWrapper()
+	if kw == nil {
+		return nil, errors.New("device-keys are encrypted but no kms provider is configured")
+	}
+
+	var item deviceKeysDataKey
+	if err := sqlx.Get(db, &item, "select * from device_keys_data_key where id = $1", id); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	if item.Provider != kw.Provider() {
+		return nil, fmt.Errorf("data-key %d is wrapped by provider %s, configured provider is %s", item.ID, item.Provider, kw.Provider())
+	}
+
+	key, err := kw.Unwrap(ctx, item.WrappedKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "unwrap data-key error")
+	}
+	if len(key) != dataKeySize {
+		return nil, fmt.Errorf("expected data-key of %d bytes, got: %d", dataKeySize, len(key))
+	}
+
+	dataKeys.Store(id, key)
+
+	return key, nil
+}
+
+func createDeviceKeysDataKey(ctx context.Context, db sqlx.Queryer, kw kms.KeyWrapper) (int64, []byte, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return 0, nil, errors.Wrap(err, "read random bytes error")
+	}
+
+	wrapped, err := kw.Wrap(ctx, key)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "wrap data-key error")
+	}
+
+	now := time.Now()
+	var id int64
+	err = sqlx.Get(db, &id, `
+		insert into device_keys_data_key (
+			created_at,
+			updated_at,
+			provider,
+			wrapped_key
+		) values ($1, $2, $3, $4)
+		returning id`,
+		now,
+		now,
+		kw.Provider(),
+		wrapped,
+	)
+	if err != nil {
+		return 0, nil, handlePSQLError(Insert, err, "insert error")
+	}
+
+	dataKeys.Store(id, key)
+
+	log.WithFields(log.Fields{
+		"data_key_id": id,
+		"provider":    kw.Provider(),
+		"ctx_id":      ctx.Value(logging.ContextIDKey),
+	}).Info("device-keys data-key created")
+
+	return id, key, nil
+}
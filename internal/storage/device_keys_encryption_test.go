@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/kms"
+	// "github.com/ibrahimozekici/lora-api/go/v3/ns"
+	//"github.com/brocaar/lorawan"
+)
+
+// testKeyWrapper implements a key wrapper XOR-ing the data-key with the
+// version of the key.
+type testKeyWrapper struct {
+	version byte
+}
+
+func (w *testKeyWrapper) Provider() string {
+	return "test"
+}
+
+func (w *testKeyWrapper) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	out := []byte{w.version}
+	for _, b := range key {
+		out = append(out, b^w.version)
+	}
+	return out, nil
+}
+
+func (w *testKeyWrapper) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	var out []byte
+	for _, b := range wrapped[1:] {
+		out = append(out, b^wrapped[0])
+	}
+	return out, nil
+}
+
+func (ts *StorageTestSuite) TestDeviceKeysEncryption() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(ctx, DB(), &org))
+
+	n := NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(CreateNetworkServer(ctx, DB(), &n))
+
+	sp := ServiceProfile{
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+		Name:            "test-service-profile",
+	}
+	assert.NoError(CreateServiceProfile(ctx, DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	dp := DeviceProfile{
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+		Name:            "device-profile",
+	}
+	assert.NoError(CreateDeviceProfile(ctx, DB(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	app := Application{
+		OrganizationID:   org.ID,
+		Name:             "test-app",
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(ctx, DB(), &app))
+
+	var devEUIs []lorawan.EUI64
+	for i := byte(1); i <= 2; i++ {
+		d := Device{
+			DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, i},
+			ApplicationID:   app.ID,
+			DeviceProfileID: dpID,
+			Name:            "test-device",
+		}
+		assert.NoError(CreateDevice(ctx, DB(), &d))
+		devEUIs = append(devEUIs, d.DevEUI)
+	}
+
+	nwkKey := lorawan.AES128Key{8, 7, 6, 5, 4, 3, 2, 1, 8, 7, 6, 5, 4, 3, 2, 1}
+	appKey := lorawan.AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 1, 2, 3, 4, 5, 6, 7, 8}
+	genAppKey := lorawan.AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 8, 7, 6, 5, 4, 3, 2, 1}
+
+	// the first device-keys are stored before encryption was enabled
+	assert.NoError(CreateDeviceKeys(ctx, DB(), &DeviceKeys{
+		DevEUI:    devEUIs[0],
+		NwkKey:    nwkKey,
+		AppKey:    appKey,
+		GenAppKey: genAppKey,
+	}))
+
+	kw := testKeyWrapper{version: 1}
+	kms.SetKeyWrapper(&kw)
+	defer kms.SetKeyWrapper(nil)
+
+	// data-key ids are re-used after the database reset
+	dataKeys = sync.Map{}
+
+	ts.T().Run("CreateDeviceKeys", func(t *testing.T) {
+		assert := require.New(t)
+
+		dk := DeviceKeys{
+			DevEUI:    devEUIs[1],
+			NwkKey:    nwkKey,
+			AppKey:    appKey,
+			GenAppKey: genAppKey,
+		}
+		assert.NoError(CreateDeviceKeys(ctx, DB(), &dk))
+		assert.NotNil(dk.DataKeyID)
+		assert.Len(dk.EncryptedKeys, 12+48+16)
+
+		var stored DeviceKeys
+		assert.NoError(DB().Get(&stored, "select * from device_keys where dev_eui = $1", devEUIs[1][:]))
+		assert.Equal(lorawan.AES128Key{}, stored.NwkKey)
+		assert.Equal(lorawan.AES128Key{}, stored.AppKey)
+		assert.Equal(lorawan.AES128Key{}, stored.GenAppKey)
+		assert.Equal(dk.EncryptedKeys, stored.EncryptedKeys)
+
+		t.Run("GetDeviceKeys", func(t *testing.T) {
+			assert := require.New(t)
+
+			dkGet, err := GetDeviceKeys(ctx, DB(), devEUIs[1])
+			assert.NoError(err)
+			assert.Equal(nwkKey, dkGet.NwkKey)
+			assert.Equal(appKey, dkGet.AppKey)
+			assert.Equal(genAppKey, dkGet.GenAppKey)
+		})
+
+		t.Run("Unencrypted keys are returned as-is", func(t *testing.T) {
+			assert := require.New(t)
+
+			dkGet, err := GetDeviceKeys(ctx, DB(), devEUIs[0])
+			assert.NoError(err)
+			assert.Nil(dkGet.DataKeyID)
+			assert.Equal(nwkKey, dkGet.NwkKey)
+		})
+
+		t.Run("RotateDeviceKeysDataKey", func(t *testing.T) {
+			assert := require.New(t)
+
+			count, err := RotateDeviceKeysDataKey(ctx, DB())
+			assert.NoError(err)
+			assert.Equal(2, count)
+
+			var dataKeyCount int
+			assert.NoError(DB().Get(&dataKeyCount, "select count(*) from device_keys_data_key"))
+			assert.Equal(1, dataKeyCount)
+
+			for _, devEUI := range devEUIs {
+				dkGet, err := GetDeviceKeys(ctx, DB(), devEUI)
+				assert.NoError(err)
+				assert.NotNil(dkGet.DataKeyID)
+				assert.NotEqual(*dk.DataKeyID, *dkGet.DataKeyID)
+				assert.Equal(nwkKey, dkGet.NwkKey)
+				assert.Equal(appKey, dkGet.AppKey)
+				assert.Equal(genAppKey, dkGet.GenAppKey)
+			}
+		})
+
+		t.Run("RewrapDeviceKeysDataKeys", func(t *testing.T) {
+			assert := require.New(t)
+
+			kw.version = 2
+			count, err := RewrapDeviceKeysDataKeys(ctx, DB())
+			assert.NoError(err)
+			assert.Equal(1, count)
+
+			var wrapped []byte
+			assert.NoError(DB().Get(&wrapped, "select wrapped_key from device_keys_data_key"))
+			assert.Equal(byte(2), wrapped[0])
+
+			// flush the cached data-keys to make sure the re-wrapped
+			// data-key is unwrapped
+			dataKeys = sync.Map{}
+
+			dkGet, err := GetDeviceKeys(ctx, DB(), devEUIs[0])
+			assert.NoError(err)
+			assert.Equal(appKey, dkGet.AppKey)
+		})
+
+		t.Run("Provider not configured", func(t *testing.T) {
+			assert := require.New(t)
+
+			dataKeys = sync.Map{}
+			kms.SetKeyWrapper(nil)
+			defer kms.SetKeyWrapper(&kw)
+
+			_, err := GetDeviceKeys(ctx, DB(), devEUIs[0])
+			assert.Error(err)
+		})
+	})
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/hsm"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+// storeDeviceKeysInHSM imports the NwkKey and AppKey of the given device-keys
+// into the HSM, when configured. As the keys can not be read back from the
+// HSM, these are replaced by null keys and the HSM field is set. When both
+// keys are null keys (e.g. the device-keys were read from the database),
+// the device-keys are not modified.
+func storeDeviceKeysInHSM(ctx context.Context, dk *DeviceKeys) error {
+	ks := hsm.GetKeyStore()
+	if ks == nil {
+		return nil
+	}
+
+	var null lorawan.AES128Key
+	if dk.NwkKey == null && dk.AppKey == null {
+		return nil
+	}
+
+	if err := ks.ImportKey(ctx, hsm.KeyLabel(dk.DevEUI, hsm.NwkKey), dk.NwkKey[:]); err != nil {
+		return errors.Wrap(err, "import nwk_key error")
+	}
+	if err := ks.ImportKey(ctx, hsm.KeyLabel(dk.DevEUI, hsm.AppKey), dk.AppKey[:]); err != nil {
+		return errors.Wrap(err, "import app_key error")
+	}
+
+	dk.NwkKey = null
+	dk.AppKey = null
+	dk.HSM = true
+
+	log.WithFields(log.Fields{
+		"dev_eui": dk.DevEUI,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("device-keys imported into hsm")
+
+	return nil
+}
+
+// deleteDeviceKeysFromHSM deletes the NwkKey and AppKey of the given DevEUI
+// from the HSM, when configured.
+func deleteDeviceKeysFromHSM(ctx context.Context, devEUI lorawan.EUI64) error {
+	ks := hsm.GetKeyStore()
+	if ks == nil {
+		return nil
+	}
+
+	for _, keyType := range []string{hsm.NwkKey, hsm.AppKey} {
+		if err := ks.DeleteKey(ctx, hsm.KeyLabel(devEUI, keyType)); err != nil {
+			return errors.Wrapf(err, "delete %s error", keyType)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,390 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+// Device-keys rotation kinds.
+const (
+	deviceKeysRotationStaged   = "staged"
+	deviceKeysRotationPrevious = "previous"
+)
+
+// Device-keys rotation actions, recorded in the rotation log.
+const (
+	DeviceKeysRotationActionStaged     = "staged"
+	DeviceKeysRotationActionCancelled  = "cancelled"
+	DeviceKeysRotationActionActivated  = "activated"
+	DeviceKeysRotationActionRolledBack = "rolled_back"
+)
+
+// deviceKeysRotationInvokedByJoinServer is recorded as invoked by when the
+// staged keys are activated by a join-request.
+const deviceKeysRotationInvokedByJoinServer = "join-server"
+
+// DeviceKeysRotationLogEntry defines a device-keys rotation log entry.
+type DeviceKeysRotationLogEntry struct {
+	ID        int64         `db:"id"`
+	DevEUI    lorawan.EUI64 `db:"dev_eui"`
+	CreatedAt time.Time     `db:"created_at"`
+	Action    string        `db:"action"`
+	InvokedBy string        `db:"invoked_by"`
+}
+
+// DeviceKeysRotationStatus defines the rotation status of the device-keys.
+// StagedAt is set when keys are staged, which become effective on the next
+// join of the device using these keys. PreviousAt is set when the keys that
+// were replaced by the last rotation can be rolled back.
+type DeviceKeysRotationStatus struct {
+	StagedAt   *time.Time
+	PreviousAt *time.Time
+}
+
+type deviceKeysRotation struct {
+	DevEUI        lorawan.EUI64     `db:"dev_eui"`
+	Kind          string            `db:"kind"`
+	CreatedAt     time.Time         `db:"created_at"`
+	NwkKey        lorawan.AES128Key `db:"nwk_key"`
+	AppKey        lorawan.AES128Key `db:"app_key"`
+	GenAppKey     lorawan.AES128Key `db:"gen_app_key"`
+	DataKeyID     *int64            `db:"data_key_id"`
+	EncryptedKeys []byte            `db:"encrypted_keys"`
+}
+
+// StageDeviceKeys stages the NwkKey, AppKey and GenAppKey of the given
+// device-keys. The staged keys become effective on the next join-request
+// of the device which is signed using the staged NwkKey, until then the
+// current keys remain in use. Previously staged keys are replaced.
+func StageDeviceKeys(ctx context.Context, db sqlx.Ext, dk DeviceKeys, invokedBy string) error {
+	// the device-keys must exist
+	if _, err := GetDeviceKeys(ctx, db, dk.DevEUI); err != nil {
+		return errors.Wrap(err, "get device-keys error")
+	}
+
+	if err := saveDeviceKeysRotation(ctx, db, deviceKeysRotationStaged, dk); err != nil {
+		return err
+	}
+
+	if err := createDeviceKeysRotationLogEntry(ctx, db, dk.DevEUI, DeviceKeysRotationActionStaged, invokedBy); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": dk.DevEUI,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("device-keys staged")
+
+	return nil
+}
+
+// GetStagedDeviceKeys returns the staged keys of the given device.
+// ErrDoesNotExist is returned when no keys are staged.
+func GetStagedDeviceKeys(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64) (DeviceKeys, error) {
+	return getDeviceKeysRotation(ctx, db, devEUI, deviceKeysRotationStaged)
+}
+
+// CancelStagedDeviceKeys deletes the staged keys of the given device.
+func CancelStagedDeviceKeys(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, invokedBy string) error {
+	if err := deleteDeviceKeysRotation(db, devEUI, deviceKeysRotationStaged); err != nil {
+		return err
+	}
+
+	if err := createDeviceKeysRotationLogEntry(ctx, db, devEUI, DeviceKeysRotationActionCancelled, invokedBy); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": devEUI,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("staged device-keys cancelled")
+
+	return nil
+}
+
+// ActivateStagedDeviceKeys replaces the keys of the given device by the
+// staged keys. The replaced keys are stored such that these can be rolled
+// back, unless these are stored in the HSM. This must be called within a
+// transaction.
+func ActivateStagedDeviceKeys(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64) error {
+	var dk DeviceKeys
+	err := sqlx.Get(db, &dk, "select * from device_keys where dev_eui = $1 for update", devEUI[:])
+	if err != nil {
+		return handlePSQLError(Select, err, "select error")
+	}
+	if err := DecryptDeviceKeys(ctx, db, &dk); err != nil {
+		return errors.Wrap(err, "decrypt device-keys error")
+	}
+
+	staged, err := GetStagedDeviceKeys(ctx, db, devEUI)
+	if err != nil {
+		return errors.Wrap(err, "get staged device-keys error")
+	}
+
+	if err := deleteDeviceKeysRotation(db, devEUI, deviceKeysRotationPrevious); err != nil && err != ErrDoesNotExist {
+		return err
+	}
+	// keys stored in the hsm can not be read back
+	if !dk.HSM {
+		if err := saveDeviceKeysRotation(ctx, db, deviceKeysRotationPrevious, dk); err != nil {
+			return err
+		}
+	}
+
+	dk.NwkKey = staged.NwkKey
+	dk.AppKey = staged.AppKey
+	dk.GenAppKey = staged.GenAppKey
+	if err := UpdateDeviceKeys(ctx, db, &dk); err != nil {
+		return errors.Wrap(err, "update device-keys error")
+	}
+
+	if err := deleteDeviceKeysRotation(db, devEUI, deviceKeysRotationStaged); err != nil {
+		return err
+	}
+
+	if err := createDeviceKeysRotationLogEntry(ctx, db, devEUI, DeviceKeysRotationActionActivated, deviceKeysRotationInvokedByJoinServer); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": devEUI,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("staged device-keys activated")
+
+	return nil
+}
+
+// RollbackDeviceKeys restores the keys that were replaced by the last
+// activated rotation and deletes the staged keys. ErrDoesNotExist is returned
+// when there are no keys to roll back. This must be called within a
+// transaction.
+func RollbackDeviceKeys(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, invokedBy string) error {
+	var dk DeviceKeys
+	err := sqlx.Get(db, &dk, "select * from device_keys where dev_eui = $1 for update", devEUI[:])
+	if err != nil {
+		return handlePSQLError(Select, err, "select error")
+	}
+
+	previous, err := getDeviceKeysRotation(ctx, db, devEUI, deviceKeysRotationPrevious)
+	if err != nil {
+		return err
+	}
+
+	dk.NwkKey = previous.NwkKey
+	dk.AppKey = previous.AppKey
+	dk.GenAppKey = previous.GenAppKey
+	if err := UpdateDeviceKeys(ctx, db, &dk); err != nil {
+		return errors.Wrap(err, "update device-keys error")
+	}
+
+	for _, kind := range []string{deviceKeysRotationPrevious, deviceKeysRotationStaged} {
+		if err := deleteDeviceKeysRotation(db, devEUI, kind); err != nil && err != ErrDoesNotExist {
+			return err
+		}
+	}
+
+	if err := createDeviceKeysRotationLogEntry(ctx, db, devEUI, DeviceKeysRotationActionRolledBack, invokedBy); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": devEUI,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("device-keys rolled back")
+
+	return nil
+}
+
+// GetDeviceKeysRotationStatus returns the rotation status of the keys of the
+// given device.
+func GetDeviceKeysRotationStatus(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64) (DeviceKeysRotationStatus, error) {
+	var status DeviceKeysRotationStatus
+	var items []deviceKeysRotation
+
+	err := sqlx.Select(db, &items, "select * from device_keys_rotation where dev_eui = $1", devEUI[:])
+	if err != nil {
+		return status, handlePSQLError(Select, err, "select error")
+	}
+
+	for i := range items {
+		switch items[i].Kind {
+		case deviceKeysRotationStaged:
+			status.StagedAt = &items[i].CreatedAt
+		case deviceKeysRotationPrevious:
+			status.PreviousAt = &items[i].CreatedAt
+		}
+	}
+
+	return status, nil
+}
+
+// GetDeviceKeysRotationLog returns the most recent rotation log entries of
+// the given device, newest first.
+func GetDeviceKeysRotationLog(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64, limit int) ([]DeviceKeysRotationLogEntry, error) {
+	var out []DeviceKeysRotationLogEntry
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			device_keys_rotation_log
+		where
+			dev_eui = $1
+		order by
+			created_at desc,
+			id desc
+		limit $2`,
+		devEUI[:],
+		limit,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+func saveDeviceKeysRotation(ctx context.Context, db sqlx.Ext, kind string, dk DeviceKeys) error {
+	if err := encryptDeviceKeys(ctx, db, &dk); err != nil {
+		return errors.Wrap(err, "encrypt device-keys error")
+	}
+	nwkKey, appKey, genAppKey := dk.storedKeys()
+
+	_, err := db.Exec(`
+		insert into device_keys_rotation (
+			dev_eui,
+			kind,
+			created_at,
+			nwk_key,
+			app_key,
+			gen_app_key,
+			data_key_id,
+			encrypted_keys
+		) values ($1, $2, $3, $4, $5, $6, $7, $8)
+		on conflict (dev_eui, kind) do update
+		set
+			created_at = excluded.created_at,
+			nwk_key = excluded.nwk_key,
+			app_key = excluded.app_key,
+			gen_app_key = excluded.gen_app_key,
+			data_key_id = excluded.data_key_id,
+			encrypted_keys = excluded.encrypted_keys`,
+		dk.DevEUI[:],
+		kind,
+		time.Now(),
+		nwkKey[:],
+		appKey[:],
+		genAppKey[:],
+		dk.DataKeyID,
+		dk.EncryptedKeys,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	return nil
+}
+
+func getDeviceKeysRotation(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64, kind string) (DeviceKeys, error) {
+	var item deviceKeysRotation
+	err := sqlx.Get(db, &item, "select * from device_keys_rotation where dev_eui = $1 and kind = $2", devEUI[:], kind)
+	if err != nil {
+		return DeviceKeys{}, handlePSQLError(Select, err, "select error")
+	}
+
+	dk := DeviceKeys{
+		CreatedAt:     item.CreatedAt,
+		UpdatedAt:     item.CreatedAt,
+		DevEUI:        item.DevEUI,
+		NwkKey:        item.NwkKey,
+		AppKey:        item.AppKey,
+		GenAppKey:     item.GenAppKey,
+		DataKeyID:     item.DataKeyID,
+		EncryptedKeys: item.EncryptedKeys,
+	}
+
+	if err := DecryptDeviceKeys(ctx, db, &dk); err != nil {
+		return DeviceKeys{}, errors.Wrap(err, "decrypt device-keys error")
+	}
+
+	return dk, nil
+}
+
+func reEncryptDeviceKeysRotation(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, kind string) error {
+	dk, err := getDeviceKeysRotation(ctx, db, devEUI, kind)
+	if err != nil {
+		return err
+	}
+
+	if err := encryptDeviceKeys(ctx, db, &dk); err != nil {
+		return errors.Wrap(err, "encrypt device-keys error")
+	}
+	nwkKey, appKey, genAppKey := dk.storedKeys()
+
+	_, err = db.Exec(`
+		update device_keys_rotation
+		set
+			nwk_key = $3,
+			app_key = $4,
+			gen_app_key = $5,
+			data_key_id = $6,
+			encrypted_keys = $7
+		where
+			dev_eui = $1
+			and kind = $2`,
+		devEUI[:],
+		kind,
+		nwkKey[:],
+		appKey[:],
+		genAppKey[:],
+		dk.DataKeyID,
+		dk.EncryptedKeys,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+
+	return nil
+}
+
+func deleteDeviceKeysRotation(db sqlx.Execer, devEUI lorawan.EUI64, kind string) error {
+	res, err := db.Exec("delete from device_keys_rotation where dev_eui = $1 and kind = $2", devEUI[:], kind)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	return nil
+}
+
+func createDeviceKeysRotationLogEntry(ctx context.Context, db sqlx.Execer, devEUI lorawan.EUI64, action, invokedBy string) error {
+	_, err := db.Exec(`
+		insert into device_keys_rotation_log (
+			dev_eui,
+			created_at,
+			action,
+			invoked_by
+		) values ($1, $2, $3, $4)`,
+		devEUI[:],
+		time.Now(),
+		action,
+		invokedBy,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	uuid "github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	nsmock "github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestDeviceKeysRotation() {
+	assert := require.New(ts.T())
+
+	nsClient := nsmock.NewClient()
+	networkserver.SetPool(nsmock.NewPool(nsClient))
+
+	n := NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(CreateNetworkServer(context.Background(), ts.tx, &n))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(context.Background(), ts.tx, &org))
+
+	sp := ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateServiceProfile(context.Background(), ts.tx, &sp))
+	var spID uuid.UUID
+	copy(spID[:], sp.ServiceProfile.Id)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(context.Background(), ts.tx, &app))
+
+	dp := DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateDeviceProfile(context.Background(), ts.tx, &dp))
+	var dpID uuid.UUID
+	copy(dpID[:], dp.DeviceProfile.Id)
+
+	d := Device{
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "test-device",
+	}
+	assert.NoError(CreateDevice(context.Background(), ts.tx, &d))
+
+	oldKey := lorawan.AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 1, 2, 3, 4, 5, 6, 7, 8}
+	newKey := lorawan.AES128Key{8, 7, 6, 5, 4, 3, 2, 1, 8, 7, 6, 5, 4, 3, 2, 1}
+
+	ts.T().Run("Stage without device-keys", func(t *testing.T) {
+		assert := require.New(t)
+
+		err := StageDeviceKeys(context.Background(), ts.tx, DeviceKeys{DevEUI: d.DevEUI, NwkKey: newKey}, "admin")
+		assert.Equal(ErrDoesNotExist, errors.Cause(err))
+	})
+
+	assert.NoError(CreateDeviceKeys(context.Background(), ts.tx, &DeviceKeys{
+		DevEUI:    d.DevEUI,
+		NwkKey:    oldKey,
+		JoinNonce: 10,
+	}))
+
+	ts.T().Run("Stage and cancel", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(StageDeviceKeys(context.Background(), ts.tx, DeviceKeys{DevEUI: d.DevEUI, NwkKey: newKey}, "admin"))
+
+		staged, err := GetStagedDeviceKeys(context.Background(), ts.tx, d.DevEUI)
+		assert.NoError(err)
+		assert.Equal(newKey, staged.NwkKey)
+
+		status, err := GetDeviceKeysRotationStatus(context.Background(), ts.tx, d.DevEUI)
+		assert.NoError(err)
+		assert.NotNil(status.StagedAt)
+		assert.Nil(status.PreviousAt)
+
+		// the current keys are not modified
+		dk, err := GetDeviceKeys(context.Background(), ts.tx, d.DevEUI)
+		assert.NoError(err)
+		assert.Equal(oldKey, dk.NwkKey)
+
+		assert.NoError(CancelStagedDeviceKeys(context.Background(), ts.tx, d.DevEUI, "admin"))
+		_, err = GetStagedDeviceKeys(context.Background(), ts.tx, d.DevEUI)
+		assert.Equal(ErrDoesNotExist, err)
+	})
+
+	ts.T().Run("Rollback without rotation", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.Equal(ErrDoesNotExist, RollbackDeviceKeys(context.Background(), ts.tx, d.DevEUI, "admin"))
+	})
+
+	ts.T().Run("Stage, activate and rollback", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(StageDeviceKeys(context.Background(), ts.tx, DeviceKeys{DevEUI: d.DevEUI, NwkKey: newKey}, "admin"))
+		assert.NoError(ActivateStagedDeviceKeys(context.Background(), ts.tx, d.DevEUI))
+
+		dk, err := GetDeviceKeys(context.Background(), ts.tx, d.DevEUI)
+		assert.NoError(err)
+		assert.Equal(newKey, dk.NwkKey)
+		assert.Equal(10, dk.JoinNonce)
+
+		status, err := GetDeviceKeysRotationStatus(context.Background(), ts.tx, d.DevEUI)
+		assert.NoError(err)
+		assert.Nil(status.StagedAt)
+		assert.NotNil(status.PreviousAt)
+
+		assert.NoError(RollbackDeviceKeys(context.Background(), ts.tx, d.DevEUI, "admin"))
+
+		dk, err = GetDeviceKeys(context.Background(), ts.tx, d.DevEUI)
+		assert.NoError(err)
+		assert.Equal(oldKey, dk.NwkKey)
+
+		status, err = GetDeviceKeysRotationStatus(context.Background(), ts.tx, d.DevEUI)
+		assert.NoError(err)
+		assert.Nil(status.StagedAt)
+		assert.Nil(status.PreviousAt)
+	})
+
+	ts.T().Run("Rotation log", func(t *testing.T) {
+		assert := require.New(t)
+
+		entries, err := GetDeviceKeysRotationLog(context.Background(), ts.tx, d.DevEUI, 10)
+		assert.NoError(err)
+
+		var actions []string
+		for _, e := range entries {
+			actions = append(actions, e.Action)
+		}
+		assert.Equal([]string{
+			DeviceKeysRotationActionRolledBack,
+			DeviceKeysRotationActionActivated,
+			DeviceKeysRotationActionStaged,
+			DeviceKeysRotationActionCancelled,
+			DeviceKeysRotationActionStaged,
+		}, actions)
+		assert.Equal("join-server", entries[1].InvokedBy)
+		assert.Equal("admin", entries[0].InvokedBy)
+	})
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+// DeviceMetric defines a single (decoded) measurement value of a device.
+type DeviceMetric struct {
+	DevEUI        lorawan.EUI64 `db:"dev_eui"`
+	ApplicationID int64         `db:"application_id"`
+	Time          time.Time     `db:"time"`
+	Name          string        `db:"name"`
+	Value         float64       `db:"value"`
+}
+
+// CreateDeviceMetrics stores the given device metrics.
+func CreateDeviceMetrics(ctx context.Context, db sqlx.Ext, metrics []DeviceMetric) error {
+	defer observeQueryDuration("device_metrics_write", time.Now())
+
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	count := len(metrics)
+	devEUI := metrics[0].DevEUI
+
+	for len(metrics) > 0 {
+		n := len(metrics)
+		if n > maxInsertRows {
+			n = maxInsertRows
+		}
+
+		args := make([]interface{}, 0, n*5)
+		for _, m := range metrics[:n] {
+			args = append(args,
+				m.DevEUI[:],
+				m.ApplicationID,
+				m.Time,
+				m.Name,
+				m.Value,
+			)
+		}
+
+		_, err := db.Exec(`
+			insert into device_metric (
+				dev_eui,
+				application_id,
+				time,
+				name,
+				value
+			) values `+insertValues(n, 5), args...)
+		if err != nil {
+			return handlePSQLError(Insert, err, "insert error")
+		}
+
+		metrics = metrics[n:]
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": devEUI,
+		"count":   count,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Debug("device metrics created")
+
+	return nil
+}
+
+// GetDeviceMetrics returns the metrics for the given DevEUI and metric name
+// within the given time range, sorted by time.
+func GetDeviceMetrics(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64, name string, start, end time.Time) ([]DeviceMetric, error) {
+	defer observeQueryDuration("device_metrics_get", time.Now())
+
+	var out []DeviceMetric
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			device_metric
+		where
+			dev_eui = $1
+			and name = $2
+			and time >= $3
+			and time < $4
+		order by
+			time`,
+		devEUI[:],
+		name,
+		start,
+		end,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
@@ -16,22 +16,30 @@ import (
 	// "github.com/ibrahimozekici/lora-api/go/v3/ns"
 	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
 	"github.com/ibrahimozekici/app-server2/internal/codec"
+	"github.com/ibrahimozekici/app-server2/internal/downlink/command"
+	"github.com/ibrahimozekici/app-server2/internal/downlink/validation"
 	"github.com/ibrahimozekici/app-server2/internal/logging"
+	"github.com/ibrahimozekici/app-server2/internal/measurement"
 )
 
 // DeviceProfile defines the device-profile.
 type DeviceProfile struct {
-	NetworkServerID      int64            `db:"network_server_id"`
-	OrganizationID       int64            `db:"organization_id"`
-	CreatedAt            time.Time        `db:"created_at"`
-	UpdatedAt            time.Time        `db:"updated_at"`
-	Name                 string           `db:"name"`
-	PayloadCodec         codec.Type       `db:"payload_codec"`
-	PayloadEncoderScript string           `db:"payload_encoder_script"`
-	PayloadDecoderScript string           `db:"payload_decoder_script"`
-	Tags                 hstore.Hstore    `db:"tags"`
-	UplinkInterval       time.Duration    `db:"uplink_interval"`
-	DeviceProfile        ns.DeviceProfile `db:"-"`
+	NetworkServerID        int64                        `db:"network_server_id"`
+	OrganizationID         int64                        `db:"organization_id"`
+	CreatedAt              time.Time                    `db:"created_at"`
+	UpdatedAt              time.Time                    `db:"updated_at"`
+	Name                   string                       `db:"name"`
+	PayloadCodec           codec.Type                   `db:"payload_codec"`
+	PayloadEncoderScript   string                       `db:"payload_encoder_script"`
+	PayloadDecoderScript   string                       `db:"payload_decoder_script"`
+	Tags                   hstore.Hstore                `db:"tags"`
+	UplinkInterval         time.Duration                `db:"uplink_interval"`
+	CodecID                *uuid.UUID                   `db:"codec_id"`
+	Measurements           measurement.Definitions      `db:"measurements"`
+	DownlinkValidation     validation.Rules             `db:"downlink_validation"`
+	ConfirmedDownlinkRetry ConfirmedDownlinkRetryPolicy `db:"confirmed_downlink_retry"`
+	Commands               command.Catalog              `db:"commands"`
+	DeviceProfile          ns.DeviceProfile             `db:"-"`
 }
 
 // DeviceProfileMeta defines the device-profile meta record.
@@ -131,6 +139,8 @@ func CreateDeviceProfile(ctx context.Context, db sqlx.Ext, dp *DeviceProfile) er
 // When localOnly is set to true, no call to the network-server is made to
 // retrieve additional device data.
 func GetDeviceProfile(ctx context.Context, db sqlx.Queryer, id uuid.UUID, forUpdate, localOnly bool) (DeviceProfile, error) {
+	defer observeQueryDuration("device_profile_get", time.Now())
+
 	var fu string
 	if forUpdate {
 		fu = " for update"
@@ -149,7 +159,12 @@ func GetDeviceProfile(ctx context.Context, db sqlx.Queryer, id uuid.UUID, forUpd
 			payload_encoder_script,
 			payload_decoder_script,
 			tags,
-			uplink_interval
+			uplink_interval,
+			codec_id,
+			measurements,
+			downlink_validation,
+			confirmed_downlink_retry,
+			commands
 		from device_profile
 		where
 			device_profile_id = $1`+fu,
@@ -170,6 +185,11 @@ func GetDeviceProfile(ctx context.Context, db sqlx.Queryer, id uuid.UUID, forUpd
 		&dp.PayloadDecoderScript,
 		&dp.Tags,
 		&dp.UplinkInterval,
+		&dp.CodecID,
+		&dp.Measurements,
+		&dp.DownlinkValidation,
+		&dp.ConfirmedDownlinkRetry,
+		&dp.Commands,
 	)
 	if err != nil {
 		return dp, handlePSQLError(Scan, err, "scan error")
@@ -265,6 +285,8 @@ func UpdateDeviceProfile(ctx context.Context, db sqlx.Ext, dp *DeviceProfile) er
 		return ErrDoesNotExist
 	}
 
+	invalidateDeviceProfileCache(ctx, dpID)
+
 	log.WithFields(log.Fields{
 		"id":     dpID,
 		"ctx_id": ctx.Value(logging.ContextIDKey),
@@ -273,6 +295,173 @@ func UpdateDeviceProfile(ctx context.Context, db sqlx.Ext, dp *DeviceProfile) er
 	return nil
 }
 
+// UpdateDeviceProfileMeasurements updates the measurement definitions of
+// the given device-profile.
+func UpdateDeviceProfileMeasurements(ctx context.Context, db sqlx.Execer, id uuid.UUID, m measurement.Definitions) error {
+	defer observeQueryDuration("device_profile_measurements_update", time.Now())
+
+	if err := m.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	res, err := db.Exec(`
+		update device_profile
+		set
+			updated_at = $2,
+			measurements = $3
+		where
+			device_profile_id = $1`,
+		id,
+		time.Now(),
+		m,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	invalidateDeviceProfileCache(ctx, id)
+
+	log.WithFields(log.Fields{
+		"id":     id,
+		"count":  len(m),
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("device-profile measurements updated")
+
+	return nil
+}
+
+// UpdateDeviceProfileDownlinkValidation updates the downlink validation
+// rules of the given device-profile.
+func UpdateDeviceProfileDownlinkValidation(ctx context.Context, db sqlx.Execer, id uuid.UUID, r validation.Rules) error {
+	defer observeQueryDuration("device_profile_downlink_validation_update", time.Now())
+
+	if err := r.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	res, err := db.Exec(`
+		update device_profile
+		set
+			updated_at = $2,
+			downlink_validation = $3
+		where
+			device_profile_id = $1`,
+		id,
+		time.Now(),
+		r,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	invalidateDeviceProfileCache(ctx, id)
+
+	log.WithFields(log.Fields{
+		"id":     id,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("device-profile downlink validation updated")
+
+	return nil
+}
+
+// UpdateDeviceProfileConfirmedDownlinkRetry updates the confirmed downlink
+// retry policy of the given device-profile.
+func UpdateDeviceProfileConfirmedDownlinkRetry(ctx context.Context, db sqlx.Execer, id uuid.UUID, p ConfirmedDownlinkRetryPolicy) error {
+	defer observeQueryDuration("device_profile_confirmed_downlink_retry_update", time.Now())
+
+	if err := p.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	res, err := db.Exec(`
+		update device_profile
+		set
+			updated_at = $2,
+			confirmed_downlink_retry = $3
+		where
+			device_profile_id = $1`,
+		id,
+		time.Now(),
+		p,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	invalidateDeviceProfileCache(ctx, id)
+
+	log.WithFields(log.Fields{
+		"id":          id,
+		"max_retries": p.MaxRetries,
+		"ctx_id":      ctx.Value(logging.ContextIDKey),
+	}).Info("device-profile confirmed downlink retry policy updated")
+
+	return nil
+}
+
+// UpdateDeviceProfileCommands updates the command catalog of the given
+// device-profile.
+func UpdateDeviceProfileCommands(ctx context.Context, db sqlx.Execer, id uuid.UUID, c command.Catalog) error {
+	defer observeQueryDuration("device_profile_commands_update", time.Now())
+
+	if err := c.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	res, err := db.Exec(`
+		update device_profile
+		set
+			updated_at = $2,
+			commands = $3
+		where
+			device_profile_id = $1`,
+		id,
+		time.Now(),
+		c,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	invalidateDeviceProfileCache(ctx, id)
+
+	log.WithFields(log.Fields{
+		"id":     id,
+		"count":  len(c),
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("device-profile commands updated")
+
+	return nil
+}
+
 // DeleteDeviceProfile deletes the device-profile matching the given id.
 func DeleteDeviceProfile(ctx context.Context, db sqlx.Ext, id uuid.UUID) error {
 	n, err := GetNetworkServerForDeviceProfileID(ctx, db, id)
@@ -304,6 +493,8 @@ func DeleteDeviceProfile(ctx context.Context, db sqlx.Ext, id uuid.UUID) error {
 		return errors.Wrap(err, "delete device-profile error")
 	}
 
+	invalidateDeviceProfileCache(ctx, id)
+
 	log.WithFields(log.Fields{
 		"id":     id,
 		"ctx_id": ctx.Value(logging.ContextIDKey),
@@ -0,0 +1,239 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	//"github.com/ibrahimozekici/lora-api/go/v3/ns"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+// DeviceQueueItemExpiry defines the expiry of a device-queue item. When the
+// item has not been sent before ExpiresAt, it is removed from the queue.
+type DeviceQueueItemExpiry struct {
+	DevEUI    lorawan.EUI64 `db:"dev_eui"`
+	FCnt      uint32        `db:"f_cnt"`
+	CreatedAt time.Time     `db:"created_at"`
+	FPort     uint8         `db:"f_port"`
+	ExpiresAt time.Time     `db:"expires_at"`
+}
+
+// CreateDeviceQueueItemExpiry creates the given device-queue item expiry.
+func CreateDeviceQueueItemExpiry(ctx context.Context, db sqlx.Execer, e *DeviceQueueItemExpiry) error {
+	e.CreatedAt = time.Now()
+
+	_, err := db.Exec(`
+		insert into device_queue_item_expiry (
+			dev_eui,
+			f_cnt,
+			created_at,
+			f_port,
+			expires_at
+		) values ($1, $2, $3, $4, $5)`,
+		e.DevEUI[:],
+		e.FCnt,
+		e.CreatedAt,
+		e.FPort,
+		e.ExpiresAt,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui":    e.DevEUI,
+		"f_cnt":      e.FCnt,
+		"expires_at": e.ExpiresAt,
+		"ctx_id":     ctx.Value(logging.ContextIDKey),
+	}).Info("device-queue item expiry created")
+
+	return nil
+}
+
+// GetExpiredDeviceQueueItems returns the device-queue item expiries for
+// which the expiry timestamp has passed. The returned records are locked,
+// other transactions skip these.
+func GetExpiredDeviceQueueItems(ctx context.Context, db sqlx.Queryer, limit int) ([]DeviceQueueItemExpiry, error) {
+	var items []DeviceQueueItemExpiry
+	err := sqlx.Select(db, &items, `
+		select
+			*
+		from
+			device_queue_item_expiry
+		where
+			expires_at <= $1
+		order by
+			expires_at
+		limit $2
+		for update
+		skip locked`,
+		time.Now(),
+		limit,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return items, nil
+}
+
+// UpdateDeviceQueueItemExpiryFCnt updates the frame-counter of the
+// device-queue item expiry, e.g. when the item has been re-enqueued using
+// a new frame-counter.
+func UpdateDeviceQueueItemExpiryFCnt(ctx context.Context, db sqlx.Execer, devEUI lorawan.EUI64, fCnt, newFCnt uint32) error {
+	_, err := db.Exec(`
+		update
+			device_queue_item_expiry
+		set
+			f_cnt = $3
+		where
+			dev_eui = $1
+			and f_cnt = $2`,
+		devEUI[:],
+		fCnt,
+		newFCnt,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+
+	return nil
+}
+
+// DeleteDeviceQueueItemExpiry deletes the device-queue item expiry for the
+// given DevEUI and frame-counter.
+func DeleteDeviceQueueItemExpiry(ctx context.Context, db sqlx.Execer, devEUI lorawan.EUI64, fCnt uint32) error {
+	res, err := db.Exec(`
+		delete from
+			device_queue_item_expiry
+		where
+			dev_eui = $1
+			and f_cnt = $2`,
+		devEUI[:],
+		fCnt,
+	)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	return nil
+}
+
+// DeleteDeviceQueueItemExpiriesForDevice deletes all the device-queue item
+// expiries of the given device, e.g. when the device-queue is flushed.
+func DeleteDeviceQueueItemExpiriesForDevice(ctx context.Context, db sqlx.Execer, devEUI lorawan.EUI64) error {
+	_, err := db.Exec(`
+		delete from
+			device_queue_item_expiry
+		where
+			dev_eui = $1`,
+		devEUI[:],
+	)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+
+	return nil
+}
+
+// RemoveDeviceQueueItems removes the items with the given frame-counters
+// from the device-queue of the network-server. As the network-server does
+// not support removing a single item, the queue is flushed and the remaining
+// items are enqueued again. It returns the frame-counters of the removed
+// items, items which were not in the queue (e.g. as these were already
+// sent) are not returned.
+//
+// This must be called within a transaction, as the device is locked so that
+// items which are enqueued concurrently are not lost by the flush.
+func RemoveDeviceQueueItems(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64, fCnts []uint32) ([]uint32, error) {
+	if _, err := GetDevice(ctx, db, devEUI, true, true); err != nil {
+		return nil, errors.Wrap(err, "get device error")
+	}
+
+	n, err := GetNetworkServerForDevEUI(ctx, db, devEUI)
+	if err != nil {
+		return nil, errors.Wrap(err, "get network-server error")
+	}
+	nsClient, err := networkserver.GetPool().Get(n.Server, []byte(n.CACert), []byte(n.TLSCert), []byte(n.TLSKey))
+	if err != nil {
+		return nil, errors.Wrap(err, "get network-server client error")
+	}
+
+	resp, err := nsClient.GetDeviceQueueItemsForDevEUI(ctx, &ns.GetDeviceQueueItemsForDevEUIRequest{
+		DevEui: devEUI[:],
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "get device-queue items error")
+	}
+
+	remove := make(map[uint32]struct{})
+	for _, fCnt := range fCnts {
+		remove[fCnt] = struct{}{}
+	}
+
+	var removed []uint32
+	var keep []*ns.DeviceQueueItem
+	for _, qi := range resp.Items {
+		if _, ok := remove[qi.FCnt]; ok {
+			removed = append(removed, qi.FCnt)
+		} else {
+			keep = append(keep, qi)
+		}
+	}
+
+	if len(removed) == 0 {
+		return nil, nil
+	}
+
+	_, err = nsClient.FlushDeviceQueueForDevEUI(ctx, &ns.FlushDeviceQueueForDevEUIRequest{
+		DevEui: devEUI[:],
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "flush device-queue error")
+	}
+
+	// an item which fails to be re-enqueued must not cause the loss of the
+	// remaining items
+	var enqueueErr error
+	for _, qi := range keep {
+		_, err = nsClient.CreateDeviceQueueItem(ctx, &ns.CreateDeviceQueueItemRequest{
+			Item: qi,
+		})
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"dev_eui": devEUI,
+				"f_cnt":   qi.FCnt,
+				"ctx_id":  ctx.Value(logging.ContextIDKey),
+			}).Error("re-enqueue device-queue item error")
+
+			if enqueueErr == nil {
+				enqueueErr = errors.Wrapf(err, "re-enqueue device-queue item error, f_cnt: %d", qi.FCnt)
+			}
+		}
+	}
+	if enqueueErr != nil {
+		return nil, enqueueErr
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": devEUI,
+		"removed": removed,
+		"kept":    len(keep),
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("device-queue items removed")
+
+	return removed, nil
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	uuid "github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	// "github.com/ibrahimozekici/lora-api/go/v3/ns"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	nsmock "github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestDeviceQueueItemExpiry() {
+	assert := require.New(ts.T())
+
+	nsClient := nsmock.NewClient()
+	networkserver.SetPool(nsmock.NewPool(nsClient))
+
+	n := NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(CreateNetworkServer(context.Background(), ts.tx, &n))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(context.Background(), ts.tx, &org))
+
+	sp := ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateServiceProfile(context.Background(), ts.tx, &sp))
+	var spID uuid.UUID
+	copy(spID[:], sp.ServiceProfile.Id)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(context.Background(), ts.tx, &app))
+
+	dp := DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateDeviceProfile(context.Background(), ts.tx, &dp))
+	var dpID uuid.UUID
+	copy(dpID[:], dp.DeviceProfile.Id)
+
+	d := Device{
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "test-device",
+	}
+	assert.NoError(CreateDevice(context.Background(), ts.tx, &d))
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(CreateDeviceQueueItemExpiry(context.Background(), ts.tx, &DeviceQueueItemExpiry{
+			DevEUI:    d.DevEUI,
+			FCnt:      10,
+			FPort:     1,
+			ExpiresAt: time.Now().Add(-time.Second),
+		}))
+		assert.NoError(CreateDeviceQueueItemExpiry(context.Background(), ts.tx, &DeviceQueueItemExpiry{
+			DevEUI:    d.DevEUI,
+			FCnt:      11,
+			FPort:     1,
+			ExpiresAt: time.Now().Add(time.Hour),
+		}))
+
+		t.Run("Get expired", func(t *testing.T) {
+			assert := require.New(t)
+
+			items, err := GetExpiredDeviceQueueItems(context.Background(), ts.tx, 10)
+			assert.NoError(err)
+			assert.Len(items, 1)
+			assert.EqualValues(10, items[0].FCnt)
+		})
+
+		t.Run("Get scheduler backlog", func(t *testing.T) {
+			assert := require.New(t)
+
+			backlog, err := GetSchedulerBacklog(context.Background(), ts.tx)
+			assert.NoError(err)
+			assert.Equal(SchedulerBacklog{ExpiredDeviceQueueItems: 1}, backlog)
+		})
+
+		t.Run("Update frame-counter", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(UpdateDeviceQueueItemExpiryFCnt(context.Background(), ts.tx, d.DevEUI, 10, 12))
+
+			items, err := GetExpiredDeviceQueueItems(context.Background(), ts.tx, 10)
+			assert.NoError(err)
+			assert.Len(items, 1)
+			assert.EqualValues(12, items[0].FCnt)
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(DeleteDeviceQueueItemExpiry(context.Background(), ts.tx, d.DevEUI, 12))
+			assert.Equal(ErrDoesNotExist, errors.Cause(DeleteDeviceQueueItemExpiry(context.Background(), ts.tx, d.DevEUI, 12)))
+		})
+
+		t.Run("Delete for device", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(DeleteDeviceQueueItemExpiriesForDevice(context.Background(), ts.tx, d.DevEUI))
+			assert.Equal(ErrDoesNotExist, errors.Cause(DeleteDeviceQueueItemExpiry(context.Background(), ts.tx, d.DevEUI, 11)))
+		})
+	})
+
+	ts.T().Run("RemoveDeviceQueueItems", func(t *testing.T) {
+		assert := require.New(t)
+
+		items := []*ns.DeviceQueueItem{
+			{DevEui: d.DevEUI[:], FCnt: 10, FPort: 1},
+			{DevEui: d.DevEUI[:], FCnt: 11, FPort: 2},
+		}
+		nsClient.GetDeviceQueueItemsForDevEUIResponse = ns.GetDeviceQueueItemsForDevEUIResponse{
+			Items: items,
+		}
+
+		t.Run("Item not in queue", func(t *testing.T) {
+			assert := require.New(t)
+
+			removed, err := RemoveDeviceQueueItems(context.Background(), ts.tx, d.DevEUI, []uint32{9})
+			assert.NoError(err)
+			assert.Len(removed, 0)
+			<-nsClient.GetDeviceQueueItemsForDevEUIChan
+			assert.Len(nsClient.FlushDeviceQueueForDevEUIChan, 0)
+		})
+
+		t.Run("Item in queue", func(t *testing.T) {
+			assert := require.New(t)
+
+			removed, err := RemoveDeviceQueueItems(context.Background(), ts.tx, d.DevEUI, []uint32{10})
+			assert.NoError(err)
+			assert.Equal([]uint32{10}, removed)
+			<-nsClient.GetDeviceQueueItemsForDevEUIChan
+			<-nsClient.FlushDeviceQueueForDevEUIChan
+
+			req := <-nsClient.CreateDeviceQueueItemChan
+			assert.Equal(items[1], req.Item)
+			assert.Len(nsClient.CreateDeviceQueueItemChan, 0)
+		})
+	})
+}
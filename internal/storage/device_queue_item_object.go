@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	//"github.com/brocaar/lorawan"
+)
+
+// DeviceQueueItemObject defines the (JSON) source object from which the
+// payload of a device-queue item was encoded. It is used to show what a
+// pending downlink does when inspecting the device-queue.
+type DeviceQueueItemObject struct {
+	DevEUI    lorawan.EUI64   `db:"dev_eui"`
+	FCnt      uint32          `db:"f_cnt"`
+	CreatedAt time.Time       `db:"created_at"`
+	FPort     uint8           `db:"f_port"`
+	Object    json.RawMessage `db:"object"`
+}
+
+// CreateDeviceQueueItemObject stores the source object of the device-queue
+// item.
+func CreateDeviceQueueItemObject(ctx context.Context, db sqlx.Execer, o *DeviceQueueItemObject) error {
+	o.CreatedAt = time.Now()
+
+	_, err := db.Exec(`
+		insert into device_queue_item_object (
+			dev_eui,
+			f_cnt,
+			created_at,
+			f_port,
+			object
+		) values ($1, $2, $3, $4, $5)
+		on conflict (dev_eui, f_cnt) do update
+		set
+			created_at = excluded.created_at,
+			f_port = excluded.f_port,
+			object = excluded.object`,
+		o.DevEUI[:],
+		o.FCnt,
+		o.CreatedAt,
+		o.FPort,
+		[]byte(o.Object),
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	return nil
+}
+
+// GetDeviceQueueItemObjects returns the stored source objects of the
+// device-queue items of the given device.
+func GetDeviceQueueItemObjects(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64) ([]DeviceQueueItemObject, error) {
+	var out []DeviceQueueItemObject
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			device_queue_item_object
+		where
+			dev_eui = $1
+		order by
+			f_cnt`,
+		devEUI[:],
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// UpdateDeviceQueueItemObjectFCnt updates the frame-counter of the stored
+// source object, e.g. when the item has been re-enqueued using a new
+// frame-counter.
+func UpdateDeviceQueueItemObjectFCnt(ctx context.Context, db sqlx.Execer, devEUI lorawan.EUI64, fCnt, newFCnt uint32) error {
+	_, err := db.Exec(`
+		update
+			device_queue_item_object
+		set
+			f_cnt = $3
+		where
+			dev_eui = $1
+			and f_cnt = $2`,
+		devEUI[:],
+		fCnt,
+		newFCnt,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+
+	return nil
+}
+
+// DeleteDeviceQueueItemObjectsExcept deletes the stored source objects of the
+// given device, except for the given frame-counters. This is used to remove
+// the objects of the items which are no longer in the device-queue (e.g. as
+// these have been sent).
+func DeleteDeviceQueueItemObjectsExcept(ctx context.Context, db sqlx.Execer, devEUI lorawan.EUI64, fCnts []uint32) error {
+	keep := make([]int64, 0, len(fCnts))
+	for _, fCnt := range fCnts {
+		keep = append(keep, int64(fCnt))
+	}
+
+	_, err := db.Exec(`
+		delete from
+			device_queue_item_object
+		where
+			dev_eui = $1
+			and not (f_cnt = any($2))`,
+		devEUI[:],
+		pq.Array(keep),
+	)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+
+	return nil
+}
+
+// DeleteDeviceQueueItemObjectsForDevice deletes all the stored source objects
+// of the given device, e.g. when the device-queue is flushed.
+func DeleteDeviceQueueItemObjectsForDevice(ctx context.Context, db sqlx.Execer, devEUI lorawan.EUI64) error {
+	return DeleteDeviceQueueItemObjectsExcept(ctx, db, devEUI, nil)
+}
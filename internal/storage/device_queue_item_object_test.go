@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+
+	uuid "github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	nsmock "github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestDeviceQueueItemObject() {
+	assert := require.New(ts.T())
+
+	nsClient := nsmock.NewClient()
+	networkserver.SetPool(nsmock.NewPool(nsClient))
+
+	n := NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(CreateNetworkServer(context.Background(), ts.tx, &n))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(context.Background(), ts.tx, &org))
+
+	sp := ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateServiceProfile(context.Background(), ts.tx, &sp))
+	var spID uuid.UUID
+	copy(spID[:], sp.ServiceProfile.Id)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(context.Background(), ts.tx, &app))
+
+	dp := DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateDeviceProfile(context.Background(), ts.tx, &dp))
+	var dpID uuid.UUID
+	copy(dpID[:], dp.DeviceProfile.Id)
+
+	d := Device{
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "test-device",
+	}
+	assert.NoError(CreateDevice(context.Background(), ts.tx, &d))
+
+	for _, fCnt := range []uint32{10, 11, 12} {
+		assert.NoError(CreateDeviceQueueItemObject(context.Background(), ts.tx, &DeviceQueueItemObject{
+			DevEUI: d.DevEUI,
+			FCnt:   fCnt,
+			FPort:  2,
+			Object: json.RawMessage(`{"interval": 60}`),
+		}))
+	}
+
+	objects, err := GetDeviceQueueItemObjects(context.Background(), ts.tx, d.DevEUI)
+	assert.NoError(err)
+	assert.Len(objects, 3)
+	assert.EqualValues(2, objects[0].FPort)
+	assert.JSONEq(`{"interval": 60}`, string(objects[0].Object))
+
+	assert.NoError(UpdateDeviceQueueItemObjectFCnt(context.Background(), ts.tx, d.DevEUI, 12, 13))
+	assert.NoError(DeleteDeviceQueueItemObjectsExcept(context.Background(), ts.tx, d.DevEUI, []uint32{11, 13}))
+
+	objects, err = GetDeviceQueueItemObjects(context.Background(), ts.tx, d.DevEUI)
+	assert.NoError(err)
+	assert.Len(objects, 2)
+	assert.EqualValues(11, objects[0].FCnt)
+	assert.EqualValues(13, objects[1].FCnt)
+
+	assert.NoError(DeleteDeviceQueueItemObjectsForDevice(context.Background(), ts.tx, d.DevEUI))
+
+	objects, err = GetDeviceQueueItemObjects(context.Background(), ts.tx, d.DevEUI)
+	assert.NoError(err)
+	assert.Len(objects, 0)
+}
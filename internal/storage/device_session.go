@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+// JoinType defines how a device session was established.
+type JoinType string
+
+// Available join types.
+const (
+	JoinTypeJoin    JoinType = "join"
+	JoinTypeRejoin0 JoinType = "rejoin_0"
+	JoinTypeRejoin1 JoinType = "rejoin_1"
+	JoinTypeRejoin2 JoinType = "rejoin_2"
+)
+
+// DeviceSession defines a session established by the join-server through a
+// join-request or one of the rejoin-request types. For join-requests DevNonce
+// is set, for rejoin-requests RJCount (RJcount0 for type 0 and 2, RJcount1
+// for type 1).
+type DeviceSession struct {
+	ID         int64           `db:"id"`
+	DevEUI     lorawan.EUI64   `db:"dev_eui"`
+	CreatedAt  time.Time       `db:"created_at"`
+	JoinType   JoinType        `db:"join_type"`
+	SenderID   string          `db:"sender_id"`
+	MACVersion string          `db:"mac_version"`
+	DevAddr    lorawan.DevAddr `db:"dev_addr"`
+	JoinNonce  int             `db:"join_nonce"`
+	DevNonce   int             `db:"dev_nonce"`
+	RJCount    int             `db:"rj_count"`
+}
+
+// DeviceSessionCount defines the number of sessions of a device for a
+// join type.
+type DeviceSessionCount struct {
+	JoinType JoinType `db:"join_type"`
+	Count    int      `db:"count"`
+}
+
+// CreateDeviceSession creates the given device session.
+func CreateDeviceSession(ctx context.Context, db sqlx.Queryer, ds *DeviceSession) error {
+	defer observeQueryDuration("device_session_create", time.Now())
+
+	ds.CreatedAt = time.Now()
+
+	err := sqlx.Get(db, &ds.ID, `
+		insert into device_session (
+			dev_eui,
+			created_at,
+			join_type,
+			sender_id,
+			mac_version,
+			dev_addr,
+			join_nonce,
+			dev_nonce,
+			rj_count
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		returning id`,
+		ds.DevEUI[:],
+		ds.CreatedAt,
+		ds.JoinType,
+		ds.SenderID,
+		ds.MACVersion,
+		ds.DevAddr[:],
+		ds.JoinNonce,
+		ds.DevNonce,
+		ds.RJCount,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui":   ds.DevEUI,
+		"join_type": ds.JoinType,
+		"dev_addr":  ds.DevAddr,
+		"ctx_id":    ctx.Value(logging.ContextIDKey),
+	}).Info("device session created")
+
+	return nil
+}
+
+// GetDeviceSessions returns the most recent sessions of the given device,
+// newest first.
+func GetDeviceSessions(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64, limit int) ([]DeviceSession, error) {
+	defer observeQueryDuration("device_session_list", time.Now())
+
+	var out []DeviceSession
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			device_session
+		where
+			dev_eui = $1
+		order by
+			created_at desc,
+			id desc
+		limit $2`,
+		devEUI[:],
+		limit,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetDeviceSessionCounts returns the number of sessions of the given device
+// per join type.
+func GetDeviceSessionCounts(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64) ([]DeviceSessionCount, error) {
+	defer observeQueryDuration("device_session_count", time.Now())
+
+	var out []DeviceSessionCount
+	err := sqlx.Select(db, &out, `
+		select
+			join_type,
+			count(*) as count
+		from
+			device_session
+		where
+			dev_eui = $1
+		group by
+			join_type
+		order by
+			join_type`,
+		devEUI[:],
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetLastRJCount1 returns the RJcount1 of the last rejoin-request type 1
+// since the last join-request of the given device. As RJcount1 is reset
+// by the device on each join, ErrDoesNotExist is returned when the device
+// did not rejoin using type 1 since its last join.
+func GetLastRJCount1(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64) (int, error) {
+	defer observeQueryDuration("device_session_last_rj_count_1", time.Now())
+
+	var ds DeviceSession
+	err := sqlx.Get(db, &ds, `
+		select
+			*
+		from
+			device_session
+		where
+			dev_eui = $1
+			and join_type in ($2, $3)
+		order by
+			created_at desc,
+			id desc
+		limit 1`,
+		devEUI[:],
+		JoinTypeJoin,
+		JoinTypeRejoin1,
+	)
+	if err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	if ds.JoinType != JoinTypeRejoin1 {
+		return 0, ErrDoesNotExist
+	}
+
+	return ds.RJCount, nil
+}
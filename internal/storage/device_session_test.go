@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	uuid "github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	nsmock "github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestDeviceSession() {
+	assert := require.New(ts.T())
+
+	nsClient := nsmock.NewClient()
+	networkserver.SetPool(nsmock.NewPool(nsClient))
+
+	n := NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(CreateNetworkServer(context.Background(), ts.tx, &n))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(context.Background(), ts.tx, &org))
+
+	sp := ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateServiceProfile(context.Background(), ts.tx, &sp))
+	var spID uuid.UUID
+	copy(spID[:], sp.ServiceProfile.Id)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(context.Background(), ts.tx, &app))
+
+	dp := DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateDeviceProfile(context.Background(), ts.tx, &dp))
+	var dpID uuid.UUID
+	copy(dpID[:], dp.DeviceProfile.Id)
+
+	d := Device{
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "test-device",
+	}
+	assert.NoError(CreateDevice(context.Background(), ts.tx, &d))
+
+	ts.T().Run("No sessions", func(t *testing.T) {
+		assert := require.New(t)
+
+		sessions, err := GetDeviceSessions(context.Background(), ts.tx, d.DevEUI, 10)
+		assert.NoError(err)
+		assert.Len(sessions, 0)
+
+		_, err = GetLastRJCount1(context.Background(), ts.tx, d.DevEUI)
+		assert.Equal(ErrDoesNotExist, err)
+	})
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		for _, ds := range []DeviceSession{
+			{JoinType: JoinTypeJoin, DevAddr: lorawan.DevAddr{1, 2, 3, 4}, JoinNonce: 1, DevNonce: 10},
+			{JoinType: JoinTypeRejoin0, DevAddr: lorawan.DevAddr{1, 2, 3, 5}, JoinNonce: 2, RJCount: 0},
+			{JoinType: JoinTypeRejoin1, DevAddr: lorawan.DevAddr{1, 2, 3, 6}, JoinNonce: 3, RJCount: 5},
+		} {
+			ds.DevEUI = d.DevEUI
+			ds.SenderID = "010203"
+			ds.MACVersion = "1.1.0"
+			assert.NoError(CreateDeviceSession(context.Background(), ts.tx, &ds))
+		}
+
+		t.Run("Get sessions", func(t *testing.T) {
+			assert := require.New(t)
+
+			sessions, err := GetDeviceSessions(context.Background(), ts.tx, d.DevEUI, 2)
+			assert.NoError(err)
+			assert.Len(sessions, 2)
+			assert.Equal(JoinTypeRejoin1, sessions[0].JoinType)
+			assert.Equal(lorawan.DevAddr{1, 2, 3, 6}, sessions[0].DevAddr)
+			assert.Equal(JoinTypeRejoin0, sessions[1].JoinType)
+		})
+
+		t.Run("Get session counts", func(t *testing.T) {
+			assert := require.New(t)
+
+			counts, err := GetDeviceSessionCounts(context.Background(), ts.tx, d.DevEUI)
+			assert.NoError(err)
+			assert.Equal([]DeviceSessionCount{
+				{JoinType: JoinTypeJoin, Count: 1},
+				{JoinType: JoinTypeRejoin0, Count: 1},
+				{JoinType: JoinTypeRejoin1, Count: 1},
+			}, counts)
+		})
+
+		t.Run("Get last RJcount1", func(t *testing.T) {
+			assert := require.New(t)
+
+			rjCount, err := GetLastRJCount1(context.Background(), ts.tx, d.DevEUI)
+			assert.NoError(err)
+			assert.Equal(5, rjCount)
+		})
+
+		t.Run("RJcount1 is reset by join", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(CreateDeviceSession(context.Background(), ts.tx, &DeviceSession{
+				DevEUI:     d.DevEUI,
+				JoinType:   JoinTypeJoin,
+				SenderID:   "010203",
+				MACVersion: "1.1.0",
+				DevAddr:    lorawan.DevAddr{1, 2, 3, 7},
+				JoinNonce:  4,
+				DevNonce:   11,
+			}))
+
+			_, err := GetLastRJCount1(context.Background(), ts.tx, d.DevEUI)
+			assert.Equal(ErrDoesNotExist, err)
+		})
+	})
+}
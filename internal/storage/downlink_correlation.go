@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+// DownlinkCorrelation defines the correlation ID of an enqueued downlink
+// and its delivery status. TxAckAt is set when the downlink has been sent by
+// the gateway, AckAt when a confirmed downlink has been (negatively)
+// acknowledged by the device.
+type DownlinkCorrelation struct {
+	ID           uuid.UUID     `db:"id"`
+	DevEUI       lorawan.EUI64 `db:"dev_eui"`
+	FCnt         uint32        `db:"f_cnt"`
+	CreatedAt    time.Time     `db:"created_at"`
+	UpdatedAt    time.Time     `db:"updated_at"`
+	FPort        uint8         `db:"f_port"`
+	Confirmed    bool          `db:"confirmed"`
+	TxAckAt      *time.Time    `db:"tx_ack_at"`
+	AckAt        *time.Time    `db:"ack_at"`
+	Acknowledged *bool         `db:"acknowledged"`
+}
+
+// CreateDownlinkCorrelation creates the given downlink correlation.
+func CreateDownlinkCorrelation(ctx context.Context, db sqlx.Execer, dc *DownlinkCorrelation) error {
+	now := time.Now()
+	dc.CreatedAt = now
+	dc.UpdatedAt = now
+
+	_, err := db.Exec(`
+		insert into downlink_correlation (
+			id,
+			dev_eui,
+			f_cnt,
+			created_at,
+			updated_at,
+			f_port,
+			confirmed
+		) values ($1, $2, $3, $4, $5, $6, $7)`,
+		dc.ID,
+		dc.DevEUI[:],
+		dc.FCnt,
+		dc.CreatedAt,
+		dc.UpdatedAt,
+		dc.FPort,
+		dc.Confirmed,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"correlation_id": dc.ID,
+		"dev_eui":        dc.DevEUI,
+		"f_cnt":          dc.FCnt,
+		"ctx_id":         ctx.Value(logging.ContextIDKey),
+	}).Info("downlink correlation created")
+
+	return nil
+}
+
+// GetDownlinkCorrelation returns the downlink correlation for the given
+// correlation ID.
+func GetDownlinkCorrelation(ctx context.Context, db sqlx.Queryer, id uuid.UUID) (DownlinkCorrelation, error) {
+	var dc DownlinkCorrelation
+	if err := sqlx.Get(db, &dc, "select * from downlink_correlation where id = $1", id); err != nil {
+		return dc, handlePSQLError(Select, err, "select error")
+	}
+
+	return dc, nil
+}
+
+// UpdateDownlinkCorrelationFCnt updates the frame-counter of the downlink
+// correlation, e.g. when the downlink has been re-enqueued using a new
+// frame-counter. The delivery status is reset, the correlation ID is kept.
+func UpdateDownlinkCorrelationFCnt(ctx context.Context, db sqlx.Execer, devEUI lorawan.EUI64, fCnt, newFCnt uint32) error {
+	_, err := db.Exec(`
+		update
+			downlink_correlation
+		set
+			f_cnt = $3,
+			updated_at = $4,
+			tx_ack_at = null
+		where
+			id = (
+				select
+					id
+				from
+					downlink_correlation
+				where
+					dev_eui = $1
+					and f_cnt = $2
+				order by
+					created_at desc
+				limit 1
+			)`,
+		devEUI[:],
+		fCnt,
+		newFCnt,
+		time.Now(),
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+
+	return nil
+}
+
+// SetDownlinkCorrelationTxAck marks the downlink with the given DevEUI and
+// frame-counter as sent and returns the updated downlink correlation. As the
+// frame-counter can be re-used (e.g. after a re-join), the most recent
+// downlink is updated.
+func SetDownlinkCorrelationTxAck(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64, fCnt uint32) (DownlinkCorrelation, error) {
+	now := time.Now()
+
+	var dc DownlinkCorrelation
+	err := sqlx.Get(db, &dc, `
+		update
+			downlink_correlation
+		set
+			updated_at = $3,
+			tx_ack_at = $3
+		where
+			id = (
+				select
+					id
+				from
+					downlink_correlation
+				where
+					dev_eui = $1
+					and f_cnt = $2
+				order by
+					created_at desc
+				limit 1
+			)
+		returning *`,
+		devEUI[:],
+		fCnt,
+		now,
+	)
+	if err != nil {
+		return dc, handlePSQLError(Update, err, "update error")
+	}
+
+	return dc, nil
+}
+
+// SetDownlinkCorrelationAck sets the acknowledgement of the confirmed
+// downlink with the given DevEUI and frame-counter and returns the updated
+// downlink correlation.
+func SetDownlinkCorrelationAck(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64, fCnt uint32, acknowledged bool) (DownlinkCorrelation, error) {
+	now := time.Now()
+
+	var dc DownlinkCorrelation
+	err := sqlx.Get(db, &dc, `
+		update
+			downlink_correlation
+		set
+			updated_at = $3,
+			ack_at = $3,
+			acknowledged = $4
+		where
+			id = (
+				select
+					id
+				from
+					downlink_correlation
+				where
+					dev_eui = $1
+					and f_cnt = $2
+				order by
+					created_at desc
+				limit 1
+			)
+		returning *`,
+		devEUI[:],
+		fCnt,
+		now,
+		acknowledged,
+	)
+	if err != nil {
+		return dc, handlePSQLError(Update, err, "update error")
+	}
+
+	return dc, nil
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	uuid "github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	nsmock "github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestDownlinkCorrelation() {
+	assert := require.New(ts.T())
+
+	nsClient := nsmock.NewClient()
+	networkserver.SetPool(nsmock.NewPool(nsClient))
+
+	n := NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(CreateNetworkServer(context.Background(), ts.tx, &n))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(context.Background(), ts.tx, &org))
+
+	sp := ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateServiceProfile(context.Background(), ts.tx, &sp))
+	var spID uuid.UUID
+	copy(spID[:], sp.ServiceProfile.Id)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(context.Background(), ts.tx, &app))
+
+	dp := DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateDeviceProfile(context.Background(), ts.tx, &dp))
+	var dpID uuid.UUID
+	copy(dpID[:], dp.DeviceProfile.Id)
+
+	d := Device{
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "test-device",
+	}
+	assert.NoError(CreateDevice(context.Background(), ts.tx, &d))
+
+	ts.T().Run("Not correlated", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := SetDownlinkCorrelationTxAck(context.Background(), ts.tx, d.DevEUI, 10)
+		assert.Equal(ErrDoesNotExist, err)
+	})
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		dc := DownlinkCorrelation{
+			ID:        uuid.Must(uuid.NewV4()),
+			DevEUI:    d.DevEUI,
+			FCnt:      10,
+			FPort:     2,
+			Confirmed: true,
+		}
+		assert.NoError(CreateDownlinkCorrelation(context.Background(), ts.tx, &dc))
+
+		t.Run("Get", func(t *testing.T) {
+			assert := require.New(t)
+
+			dcGet, err := GetDownlinkCorrelation(context.Background(), ts.tx, dc.ID)
+			assert.NoError(err)
+			assert.Equal(d.DevEUI, dcGet.DevEUI)
+			assert.EqualValues(10, dcGet.FCnt)
+			assert.Nil(dcGet.TxAckAt)
+			assert.Nil(dcGet.Acknowledged)
+		})
+
+		t.Run("Tx ack", func(t *testing.T) {
+			assert := require.New(t)
+
+			dcGet, err := SetDownlinkCorrelationTxAck(context.Background(), ts.tx, d.DevEUI, 10)
+			assert.NoError(err)
+			assert.Equal(dc.ID, dcGet.ID)
+			assert.NotNil(dcGet.TxAckAt)
+		})
+
+		t.Run("Retry", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(UpdateDownlinkCorrelationFCnt(context.Background(), ts.tx, d.DevEUI, 10, 11))
+
+			dcGet, err := GetDownlinkCorrelation(context.Background(), ts.tx, dc.ID)
+			assert.NoError(err)
+			assert.EqualValues(11, dcGet.FCnt)
+			assert.Nil(dcGet.TxAckAt)
+		})
+
+		t.Run("Ack", func(t *testing.T) {
+			assert := require.New(t)
+
+			dcGet, err := SetDownlinkCorrelationAck(context.Background(), ts.tx, d.DevEUI, 11, true)
+			assert.NoError(err)
+			assert.Equal(dc.ID, dcGet.ID)
+			assert.NotNil(dcGet.AckAt)
+			assert.NotNil(dcGet.Acknowledged)
+			assert.True(*dcGet.Acknowledged)
+		})
+	})
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	// "github.com/ibrahimozekici/lora-api/go/v3/ns"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+const downlinkDeduplicationKeyTempl = "lora:as:downlink:dedup:{%s}:%x" // (dev_eui | payload hash)
+
+// DownlinkDeduplicationMode defines how identical downlinks are handled.
+type DownlinkDeduplicationMode string
+
+// Available downlink deduplication modes.
+const (
+	DownlinkDeduplicationDisabled DownlinkDeduplicationMode = ""
+	DownlinkDeduplicationReject   DownlinkDeduplicationMode = "reject"
+	DownlinkDeduplicationCoalesce DownlinkDeduplicationMode = "coalesce"
+)
+
+var (
+	downlinkDeduplicationMode   DownlinkDeduplicationMode
+	downlinkDeduplicationWindow time.Duration
+)
+
+// SetDownlinkDeduplication sets the downlink deduplication mode and the
+// window in which identical downlinks are considered duplicates.
+func SetDownlinkDeduplication(mode DownlinkDeduplicationMode, window time.Duration) error {
+	switch mode {
+	case DownlinkDeduplicationDisabled, DownlinkDeduplicationReject, DownlinkDeduplicationCoalesce:
+	default:
+		return fmt.Errorf("invalid downlink deduplication mode: %s", mode)
+	}
+
+	downlinkDeduplicationMode = mode
+	downlinkDeduplicationWindow = window
+	return nil
+}
+
+// CheckDownlinkDuplicate checks if an identical downlink (same fPort,
+// confirmed flag and payload) was enqueued for the given device within the
+// deduplication window and is still pending in the device-queue. In reject
+// mode ErrDownlinkDuplicate is returned, in coalesce mode the downlink
+// correlation of the pending downlink is returned, in which case the caller
+// must not enqueue the downlink. It returns nil when the downlink is not
+// a duplicate.
+func CheckDownlinkDuplicate(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64, confirmed bool, fPort uint8, data []byte) (*DownlinkCorrelation, error) {
+	if downlinkDeduplicationMode == DownlinkDeduplicationDisabled || downlinkDeduplicationWindow == 0 {
+		return nil, nil
+	}
+
+	val, err := RedisClient().Get(downlinkDeduplicationKey(devEUI, confirmed, fPort, data)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "get deduplication key error")
+	}
+
+	id, err := uuid.FromString(val)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse correlation id error")
+	}
+
+	dc, err := GetDownlinkCorrelation(ctx, db, id)
+	if err != nil {
+		if errors.Cause(err) == ErrDoesNotExist {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "get downlink correlation error")
+	}
+
+	pending, err := isDeviceQueueItemPending(ctx, db, devEUI, dc.FCnt)
+	if err != nil {
+		return nil, errors.Wrap(err, "get device-queue items error")
+	}
+	if !pending {
+		return nil, nil
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui":        devEUI,
+		"f_port":         fPort,
+		"f_cnt":          dc.FCnt,
+		"correlation_id": dc.ID,
+		"mode":           downlinkDeduplicationMode,
+		"ctx_id":         ctx.Value(logging.ContextIDKey),
+	}).Warning("duplicate downlink detected")
+
+	if downlinkDeduplicationMode == DownlinkDeduplicationReject {
+		return nil, ErrDownlinkDuplicate
+	}
+
+	return &dc, nil
+}
+
+// SetDownlinkDeduplicationKey registers the enqueued downlink with the given
+// correlation ID for deduplication.
+func SetDownlinkDeduplicationKey(ctx context.Context, devEUI lorawan.EUI64, confirmed bool, fPort uint8, data []byte, correlationID uuid.UUID) error {
+	if downlinkDeduplicationMode == DownlinkDeduplicationDisabled || downlinkDeduplicationWindow == 0 {
+		return nil
+	}
+
+	key := downlinkDeduplicationKey(devEUI, confirmed, fPort, data)
+	if err := RedisClient().Set(key, correlationID.String(), downlinkDeduplicationWindow).Err(); err != nil {
+		return errors.Wrap(err, "set deduplication key error")
+	}
+
+	return nil
+}
+
+func downlinkDeduplicationKey(devEUI lorawan.EUI64, confirmed bool, fPort uint8, data []byte) string {
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, confirmed)
+	binary.Write(h, binary.BigEndian, fPort)
+	h.Write(data)
+
+	return GetRedisKey(downlinkDeduplicationKeyTempl, devEUI, h.Sum(nil))
+}
+
+func isDeviceQueueItemPending(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64, fCnt uint32) (bool, error) {
+	n, err := GetNetworkServerForDevEUI(ctx, db, devEUI)
+	if err != nil {
+		return false, errors.Wrap(err, "get network-server error")
+	}
+
+	nsClient, err := networkserver.GetPool().Get(n.Server, []byte(n.CACert), []byte(n.TLSCert), []byte(n.TLSKey))
+	if err != nil {
+		return false, errors.Wrap(err, "get network-server client error")
+	}
+
+	resp, err := nsClient.GetDeviceQueueItemsForDevEUI(ctx, &ns.GetDeviceQueueItemsForDevEUIRequest{
+		DevEui: devEUI[:],
+	})
+	if err != nil {
+		return false, errors.Wrap(err, "get device-queue items error")
+	}
+
+	for _, qi := range resp.Items {
+		if qi.FCnt == fCnt {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
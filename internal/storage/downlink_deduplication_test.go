@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+
+	// "github.com/ibrahimozekici/lora-api/go/v3/ns"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	nsmock "github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestDownlinkDeduplication() {
+	assert := require.New(ts.T())
+
+	nsClient := nsmock.NewClient()
+	networkserver.SetPool(nsmock.NewPool(nsClient))
+
+	n := NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(CreateNetworkServer(context.Background(), ts.tx, &n))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(context.Background(), ts.tx, &org))
+
+	sp := ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateServiceProfile(context.Background(), ts.tx, &sp))
+	var spID uuid.UUID
+	copy(spID[:], sp.ServiceProfile.Id)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(context.Background(), ts.tx, &app))
+
+	dp := DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateDeviceProfile(context.Background(), ts.tx, &dp))
+	var dpID uuid.UUID
+	copy(dpID[:], dp.DeviceProfile.Id)
+
+	d := Device{
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "test-device",
+	}
+	assert.NoError(CreateDevice(context.Background(), ts.tx, &d))
+
+	dc := DownlinkCorrelation{
+		ID:     uuid.Must(uuid.NewV4()),
+		DevEUI: d.DevEUI,
+		FCnt:   10,
+		FPort:  2,
+	}
+	assert.NoError(CreateDownlinkCorrelation(context.Background(), ts.tx, &dc))
+
+	defer SetDownlinkDeduplication(DownlinkDeduplicationDisabled, 0)
+
+	ts.T().Run("Invalid mode", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Error(SetDownlinkDeduplication("drop", time.Hour))
+	})
+
+	ts.T().Run("Disabled", func(t *testing.T) {
+		assert := require.New(t)
+		RedisClient().FlushAll()
+		assert.NoError(SetDownlinkDeduplication(DownlinkDeduplicationDisabled, time.Hour))
+
+		assert.NoError(SetDownlinkDeduplicationKey(context.Background(), d.DevEUI, false, 2, []byte{1}, dc.ID))
+		dup, err := CheckDownlinkDuplicate(context.Background(), ts.tx, d.DevEUI, false, 2, []byte{1})
+		assert.NoError(err)
+		assert.Nil(dup)
+	})
+
+	ts.T().Run("Reject", func(t *testing.T) {
+		RedisClient().FlushAll()
+		require.NoError(t, SetDownlinkDeduplication(DownlinkDeduplicationReject, time.Hour))
+
+		t.Run("Not enqueued before", func(t *testing.T) {
+			assert := require.New(t)
+
+			dup, err := CheckDownlinkDuplicate(context.Background(), ts.tx, d.DevEUI, false, 2, []byte{1})
+			assert.NoError(err)
+			assert.Nil(dup)
+		})
+
+		require.NoError(t, SetDownlinkDeduplicationKey(context.Background(), d.DevEUI, false, 2, []byte{1}, dc.ID))
+
+		t.Run("Pending", func(t *testing.T) {
+			assert := require.New(t)
+			nsClient.GetDeviceQueueItemsForDevEUIResponse = ns.GetDeviceQueueItemsForDevEUIResponse{
+				Items: []*ns.DeviceQueueItem{
+					{DevEui: d.DevEUI[:], FCnt: 10, FPort: 2},
+				},
+			}
+
+			_, err := CheckDownlinkDuplicate(context.Background(), ts.tx, d.DevEUI, false, 2, []byte{1})
+			assert.Equal(ErrDownlinkDuplicate, err)
+			<-nsClient.GetDeviceQueueItemsForDevEUIChan
+		})
+
+		t.Run("Different payload", func(t *testing.T) {
+			assert := require.New(t)
+
+			for _, args := range []struct {
+				confirmed bool
+				fPort     uint8
+				data      []byte
+			}{
+				{true, 2, []byte{1}},
+				{false, 3, []byte{1}},
+				{false, 2, []byte{2}},
+			} {
+				dup, err := CheckDownlinkDuplicate(context.Background(), ts.tx, d.DevEUI, args.confirmed, args.fPort, args.data)
+				assert.NoError(err)
+				assert.Nil(dup)
+			}
+		})
+
+		t.Run("Sent", func(t *testing.T) {
+			assert := require.New(t)
+			nsClient.GetDeviceQueueItemsForDevEUIResponse = ns.GetDeviceQueueItemsForDevEUIResponse{}
+
+			dup, err := CheckDownlinkDuplicate(context.Background(), ts.tx, d.DevEUI, false, 2, []byte{1})
+			assert.NoError(err)
+			assert.Nil(dup)
+			<-nsClient.GetDeviceQueueItemsForDevEUIChan
+		})
+	})
+
+	ts.T().Run("Coalesce", func(t *testing.T) {
+		assert := require.New(t)
+		RedisClient().FlushAll()
+		assert.NoError(SetDownlinkDeduplication(DownlinkDeduplicationCoalesce, time.Hour))
+		assert.NoError(SetDownlinkDeduplicationKey(context.Background(), d.DevEUI, false, 2, []byte{1}, dc.ID))
+
+		nsClient.GetDeviceQueueItemsForDevEUIResponse = ns.GetDeviceQueueItemsForDevEUIResponse{
+			Items: []*ns.DeviceQueueItem{
+				{DevEui: d.DevEUI[:], FCnt: 10, FPort: 2},
+			},
+		}
+
+		dup, err := CheckDownlinkDuplicate(context.Background(), ts.tx, d.DevEUI, false, 2, []byte{1})
+		assert.NoError(err)
+		assert.NotNil(dup)
+		assert.Equal(dc.ID, dup.ID)
+		assert.EqualValues(10, dup.FCnt)
+		<-nsClient.GetDeviceQueueItemsForDevEUIChan
+	})
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+const (
+	deviceDownlinkRateKeyTempl      = "lora:as:downlink:rate:device:{%s}:%d"      // (dev_eui | window)
+	applicationDownlinkRateKeyTempl = "lora:as:downlink:rate:application:{%d}:%d" // (application_id | window)
+)
+
+// DownlinkRateLimit defines the max. number of downlinks that can be enqueued
+// within the given interval. A MaxDownlinks of 0 disables the rate limit.
+type DownlinkRateLimit struct {
+	MaxDownlinks int
+	Interval     time.Duration
+}
+
+var (
+	deviceDownlinkRateLimit      DownlinkRateLimit
+	applicationDownlinkRateLimit DownlinkRateLimit
+)
+
+// SetDownlinkRateLimits sets the per device and per application downlink
+// rate limits.
+func SetDownlinkRateLimits(device, application DownlinkRateLimit) {
+	deviceDownlinkRateLimit = device
+	applicationDownlinkRateLimit = application
+}
+
+// CheckDownlinkRateLimit registers an enqueue attempt for the given device
+// and application and returns ErrDeviceDownlinkRateLimit or
+// ErrApplicationDownlinkRateLimit when the rate limit has been exceeded.
+// A rejected attempt is not counted. As the attempt is counted before the
+// downlink has been enqueued, the returned release function must be called
+// when the enqueue fails afterwards (e.g. when the transaction is rolled back).
+func CheckDownlinkRateLimit(ctx context.Context, applicationID int64, devEUI lorawan.EUI64) (func(), error) {
+	var devCount, appCount *redis.IntCmd
+	var limits []downlinkRateLimitKey
+	now := time.Now()
+
+	pipe := RedisClient().TxPipeline()
+	if l := deviceDownlinkRateLimit; l.MaxDownlinks > 0 && l.Interval > 0 {
+		key := GetRedisKey(deviceDownlinkRateKeyTempl, devEUI, now.UnixNano()/int64(l.Interval))
+		devCount = pipe.Incr(key)
+		pipe.PExpire(key, l.Interval)
+		limits = append(limits, downlinkRateLimitKey{key: key, interval: l.Interval})
+	}
+	if l := applicationDownlinkRateLimit; l.MaxDownlinks > 0 && l.Interval > 0 {
+		key := GetRedisKey(applicationDownlinkRateKeyTempl, applicationID, now.UnixNano()/int64(l.Interval))
+		appCount = pipe.Incr(key)
+		pipe.PExpire(key, l.Interval)
+		limits = append(limits, downlinkRateLimitKey{key: key, interval: l.Interval})
+	}
+
+	if len(limits) == 0 {
+		return func() {}, nil
+	}
+
+	if _, err := pipe.Exec(); err != nil {
+		return nil, errors.Wrap(err, "exec error")
+	}
+
+	release := func() {
+		releaseDownlinkRateLimit(ctx, limits)
+	}
+
+	if devCount != nil && devCount.Val() > int64(deviceDownlinkRateLimit.MaxDownlinks) {
+		release()
+		log.WithFields(log.Fields{
+			"dev_eui":       devEUI,
+			"max_downlinks": deviceDownlinkRateLimit.MaxDownlinks,
+			"interval":      deviceDownlinkRateLimit.Interval,
+			"ctx_id":        ctx.Value(logging.ContextIDKey),
+		}).Warning("device downlink rate limit exceeded")
+		return nil, ErrDeviceDownlinkRateLimit
+	}
+
+	if appCount != nil && appCount.Val() > int64(applicationDownlinkRateLimit.MaxDownlinks) {
+		release()
+		log.WithFields(log.Fields{
+			"application_id": applicationID,
+			"max_downlinks":  applicationDownlinkRateLimit.MaxDownlinks,
+			"interval":       applicationDownlinkRateLimit.Interval,
+			"ctx_id":         ctx.Value(logging.ContextIDKey),
+		}).Warning("application downlink rate limit exceeded")
+		return nil, ErrApplicationDownlinkRateLimit
+	}
+
+	return release, nil
+}
+
+type downlinkRateLimitKey struct {
+	key      string
+	interval time.Duration
+}
+
+// releaseDownlinkRateLimit decrements the given rate-limit counters. The
+// expiration is set again, as the counter might have expired in the meantime.
+func releaseDownlinkRateLimit(ctx context.Context, limits []downlinkRateLimitKey) {
+	pipe := RedisClient().TxPipeline()
+	for _, l := range limits {
+		pipe.Decr(l.key)
+		pipe.PExpire(l.key, l.interval)
+	}
+
+	if _, err := pipe.Exec(); err != nil {
+		log.WithError(err).WithField("ctx_id", ctx.Value(logging.ContextIDKey)).Error("storage: release downlink rate limit error")
+	}
+}
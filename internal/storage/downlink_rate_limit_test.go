@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestDownlinkRateLimit() {
+	devEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+
+	defer SetDownlinkRateLimits(DownlinkRateLimit{}, DownlinkRateLimit{})
+
+	check := func(applicationID int64, devEUI lorawan.EUI64) error {
+		_, err := CheckDownlinkRateLimit(context.Background(), applicationID, devEUI)
+		return err
+	}
+
+	ts.T().Run("Disabled", func(t *testing.T) {
+		assert := require.New(t)
+		SetDownlinkRateLimits(DownlinkRateLimit{}, DownlinkRateLimit{})
+
+		for i := 0; i < 10; i++ {
+			assert.NoError(check(1, devEUI))
+		}
+	})
+
+	ts.T().Run("Device limit", func(t *testing.T) {
+		assert := require.New(t)
+		RedisClient().FlushAll()
+		SetDownlinkRateLimits(DownlinkRateLimit{MaxDownlinks: 2, Interval: time.Hour}, DownlinkRateLimit{})
+
+		assert.NoError(check(1, devEUI))
+		assert.NoError(check(1, devEUI))
+		assert.Equal(ErrDeviceDownlinkRateLimit, check(1, devEUI))
+
+		// other devices are not affected
+		assert.NoError(check(1, lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1}))
+	})
+
+	ts.T().Run("Application limit", func(t *testing.T) {
+		assert := require.New(t)
+		RedisClient().FlushAll()
+		SetDownlinkRateLimits(DownlinkRateLimit{}, DownlinkRateLimit{MaxDownlinks: 2, Interval: time.Hour})
+
+		assert.NoError(check(1, devEUI))
+		assert.NoError(check(1, lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1}))
+		assert.Equal(ErrApplicationDownlinkRateLimit, check(1, devEUI))
+
+		// other applications are not affected
+		assert.NoError(check(2, devEUI))
+	})
+
+	ts.T().Run("Release", func(t *testing.T) {
+		assert := require.New(t)
+		RedisClient().FlushAll()
+		SetDownlinkRateLimits(DownlinkRateLimit{MaxDownlinks: 1, Interval: time.Hour}, DownlinkRateLimit{MaxDownlinks: 1, Interval: time.Hour})
+
+		release, err := CheckDownlinkRateLimit(context.Background(), 1, devEUI)
+		assert.NoError(err)
+
+		// the rejected attempt is not counted
+		assert.Equal(ErrDeviceDownlinkRateLimit, check(1, devEUI))
+
+		// e.g. the enqueue transaction was rolled back
+		release()
+		assert.NoError(check(1, devEUI))
+	})
+}
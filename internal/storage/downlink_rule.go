@@ -0,0 +1,320 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/downlink/rule"
+	"github.com/ibrahimozekici/app-server2/internal/downlink/template"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+const (
+	downlinkRuleCooldownKeyTempl = "lora:as:downlink:rule:{%s}:%s"        // (dev_eui | rule_id)
+	downlinkRuleTriggerKeyTempl  = "lora:as:downlink:rule:device:{%s}:%d" // (dev_eui | window)
+)
+
+var (
+	downlinkRuleMinCooldown     = time.Minute
+	downlinkRuleMaxTriggers     int
+	downlinkRuleTriggerInterval time.Duration
+)
+
+// DownlinkRule defines a rule which enqueues the configured downlink when the
+// decoded object of an uplink matches all the conditions of the rule. An
+// UplinkFPort of 0 matches uplinks on any fPort. To protect against loops
+// (e.g. the device responding to the downlink with an uplink matching the
+// rule), a rule is triggered at most once per Cooldown for each device.
+type DownlinkRule struct {
+	ID            uuid.UUID       `db:"id"`
+	ApplicationID int64           `db:"application_id"`
+	CreatedAt     time.Time       `db:"created_at"`
+	UpdatedAt     time.Time       `db:"updated_at"`
+	Name          string          `db:"name"`
+	Enabled       bool            `db:"enabled"`
+	UplinkFPort   uint8           `db:"uplink_f_port"`
+	Conditions    rule.Conditions `db:"conditions"`
+	FPort         uint8           `db:"f_port"`
+	Confirmed     bool            `db:"confirmed"`
+	Object        string          `db:"object"`
+	Cooldown      time.Duration   `db:"cooldown"`
+}
+
+// Validate validates the downlink rule data.
+func (r DownlinkRule) Validate() error {
+	if strings.TrimSpace(r.Name) == "" || len(r.Name) > 100 {
+		return ErrDownlinkRuleInvalidName
+	}
+
+	if r.UplinkFPort > 223 {
+		return ErrDownlinkRuleInvalidUplinkFPort
+	}
+
+	if err := r.Conditions.Validate(); err != nil {
+		return ErrDownlinkRuleInvalidConditions
+	}
+
+	if r.FPort == 0 || r.FPort > 223 {
+		return ErrDownlinkRuleInvalidFPort
+	}
+
+	if err := template.Validate(r.Object); err != nil {
+		return ErrDownlinkRuleInvalidObject
+	}
+
+	if r.Cooldown < downlinkRuleMinCooldown {
+		return ErrDownlinkRuleInvalidCooldown
+	}
+
+	return nil
+}
+
+// SetDownlinkRuleLimits sets the min. cooldown of a downlink rule and the
+// max. number of downlinks that can be triggered by rules for a single
+// device within the given interval. A maxTriggers of 0 disables the latter.
+func SetDownlinkRuleLimits(minCooldown time.Duration, maxTriggers int, interval time.Duration) {
+	downlinkRuleMinCooldown = minCooldown
+	downlinkRuleMaxTriggers = maxTriggers
+	downlinkRuleTriggerInterval = interval
+}
+
+// CreateDownlinkRule creates the given downlink rule.
+func CreateDownlinkRule(ctx context.Context, db sqlx.Execer, r *DownlinkRule) error {
+	if err := r.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	var err error
+	r.ID, err = uuid.NewV4()
+	if err != nil {
+		return errors.Wrap(err, "new uuid v4 error")
+	}
+
+	now := time.Now()
+	r.CreatedAt = now
+	r.UpdatedAt = now
+
+	_, err = db.Exec(`
+		insert into downlink_rule (
+			id,
+			application_id,
+			created_at,
+			updated_at,
+			name,
+			enabled,
+			uplink_f_port,
+			conditions,
+			f_port,
+			confirmed,
+			object,
+			cooldown
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		r.ID,
+		r.ApplicationID,
+		r.CreatedAt,
+		r.UpdatedAt,
+		r.Name,
+		r.Enabled,
+		r.UplinkFPort,
+		r.Conditions,
+		r.FPort,
+		r.Confirmed,
+		r.Object,
+		r.Cooldown,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"id":             r.ID,
+		"application_id": r.ApplicationID,
+		"ctx_id":         ctx.Value(logging.ContextIDKey),
+	}).Info("downlink rule created")
+
+	return nil
+}
+
+// GetDownlinkRule returns the downlink rule for the given id.
+func GetDownlinkRule(ctx context.Context, db sqlx.Queryer, id uuid.UUID) (DownlinkRule, error) {
+	var r DownlinkRule
+	if err := sqlx.Get(db, &r, "select * from downlink_rule where id = $1", id); err != nil {
+		return r, handlePSQLError(Select, err, "select error")
+	}
+
+	return r, nil
+}
+
+// GetDownlinkRules returns the downlink rules of the given application,
+// sorted by name.
+func GetDownlinkRules(ctx context.Context, db sqlx.Queryer, applicationID int64) ([]DownlinkRule, error) {
+	var out []DownlinkRule
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			downlink_rule
+		where
+			application_id = $1
+		order by
+			name`,
+		applicationID,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetEnabledDownlinkRulesForUplink returns the enabled downlink rules of the
+// given application which apply to uplinks on the given fPort, sorted by
+// name.
+func GetEnabledDownlinkRulesForUplink(ctx context.Context, db sqlx.Queryer, applicationID int64, fPort uint8) ([]DownlinkRule, error) {
+	var out []DownlinkRule
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			downlink_rule
+		where
+			application_id = $1
+			and enabled = true
+			and (uplink_f_port = 0 or uplink_f_port = $2)
+		order by
+			name`,
+		applicationID,
+		fPort,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// UpdateDownlinkRule updates the given downlink rule.
+func UpdateDownlinkRule(ctx context.Context, db sqlx.Execer, r *DownlinkRule) error {
+	if err := r.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	r.UpdatedAt = time.Now()
+
+	res, err := db.Exec(`
+		update downlink_rule
+		set
+			updated_at = $2,
+			name = $3,
+			enabled = $4,
+			uplink_f_port = $5,
+			conditions = $6,
+			f_port = $7,
+			confirmed = $8,
+			object = $9,
+			cooldown = $10
+		where
+			id = $1`,
+		r.ID,
+		r.UpdatedAt,
+		r.Name,
+		r.Enabled,
+		r.UplinkFPort,
+		r.Conditions,
+		r.FPort,
+		r.Confirmed,
+		r.Object,
+		r.Cooldown,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"id":     r.ID,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("downlink rule updated")
+
+	return nil
+}
+
+// DeleteDownlinkRule deletes the downlink rule.
+func DeleteDownlinkRule(ctx context.Context, db sqlx.Execer, id uuid.UUID) error {
+	res, err := db.Exec("delete from downlink_rule where id = $1", id)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"id":     id,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("downlink rule deleted")
+
+	return nil
+}
+
+// CheckDownlinkRuleTrigger registers a trigger of the given rule for the
+// given device and returns false when the rule is still in its cooldown
+// period for the device, or when the max. number of rule triggered downlinks
+// for the device has been reached.
+func CheckDownlinkRuleTrigger(ctx context.Context, r DownlinkRule, devEUI lorawan.EUI64) (bool, error) {
+	ok, err := RedisClient().SetNX(GetRedisKey(downlinkRuleCooldownKeyTempl, devEUI, r.ID), time.Now().Unix(), r.Cooldown).Result()
+	if err != nil {
+		return false, errors.Wrap(err, "set cooldown key error")
+	}
+	if !ok {
+		log.WithFields(log.Fields{
+			"downlink_rule_id": r.ID,
+			"dev_eui":          devEUI,
+			"cooldown":         r.Cooldown,
+			"ctx_id":           ctx.Value(logging.ContextIDKey),
+		}).Info("downlink rule in cooldown period")
+		return false, nil
+	}
+
+	if downlinkRuleMaxTriggers <= 0 || downlinkRuleTriggerInterval <= 0 {
+		return true, nil
+	}
+
+	key := GetRedisKey(downlinkRuleTriggerKeyTempl, devEUI, time.Now().UnixNano()/int64(downlinkRuleTriggerInterval))
+	pipe := RedisClient().TxPipeline()
+	count := pipe.Incr(key)
+	pipe.PExpire(key, downlinkRuleTriggerInterval)
+	if _, err := pipe.Exec(); err != nil {
+		return false, errors.Wrap(err, "exec error")
+	}
+
+	if count.Val() > int64(downlinkRuleMaxTriggers) {
+		log.WithFields(log.Fields{
+			"downlink_rule_id": r.ID,
+			"dev_eui":          devEUI,
+			"max_triggers":     downlinkRuleMaxTriggers,
+			"interval":         downlinkRuleTriggerInterval,
+			"ctx_id":           ctx.Value(logging.ContextIDKey),
+		}).Warning("downlink rule max. triggers exceeded")
+		return false, nil
+	}
+
+	return true, nil
+}
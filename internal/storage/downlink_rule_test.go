@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/downlink/rule"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestDownlinkRule() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	defer SetDownlinkRuleLimits(0, 0, 0)
+	SetDownlinkRuleLimits(time.Minute, 0, 0)
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(context.Background(), ts.Tx(), &org))
+
+	n := NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(CreateNetworkServer(context.Background(), ts.Tx(), &n))
+
+	sp := ServiceProfile{
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+		Name:            "test-sp",
+	}
+	assert.NoError(CreateServiceProfile(context.Background(), ts.Tx(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(context.Background(), ts.Tx(), &app))
+
+	conditions := rule.Conditions{
+		{Path: "moisture", Operator: rule.GreaterThan, Value: json.RawMessage(`40`)},
+	}
+
+	ts.T().Run("Create invalid", func(t *testing.T) {
+		assert := require.New(t)
+
+		tests := []struct {
+			Rule  DownlinkRule
+			Error error
+		}{
+			{DownlinkRule{ApplicationID: app.ID, Conditions: conditions, FPort: 1, Object: "{}", Cooldown: time.Minute}, ErrDownlinkRuleInvalidName},
+			{DownlinkRule{ApplicationID: app.ID, Name: "test", UplinkFPort: 224, Conditions: conditions, FPort: 1, Object: "{}", Cooldown: time.Minute}, ErrDownlinkRuleInvalidUplinkFPort},
+			{DownlinkRule{ApplicationID: app.ID, Name: "test", FPort: 1, Object: "{}", Cooldown: time.Minute}, ErrDownlinkRuleInvalidConditions},
+			{DownlinkRule{ApplicationID: app.ID, Name: "test", Conditions: conditions, Object: "{}", Cooldown: time.Minute}, ErrDownlinkRuleInvalidFPort},
+			{DownlinkRule{ApplicationID: app.ID, Name: "test", Conditions: conditions, FPort: 1, Object: "[]", Cooldown: time.Minute}, ErrDownlinkRuleInvalidObject},
+			{DownlinkRule{ApplicationID: app.ID, Name: "test", Conditions: conditions, FPort: 1, Object: "{}", Cooldown: time.Second}, ErrDownlinkRuleInvalidCooldown},
+		}
+
+		for _, tst := range tests {
+			assert.Equal(tst.Error, errors.Cause(CreateDownlinkRule(context.Background(), ts.Tx(), &tst.Rule)))
+		}
+	})
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		r := DownlinkRule{
+			ApplicationID: app.ID,
+			Name:          "close-valve",
+			Enabled:       true,
+			UplinkFPort:   2,
+			Conditions:    conditions,
+			FPort:         10,
+			Confirmed:     true,
+			Object:        `{"valve": "closed"}`,
+			Cooldown:      time.Hour,
+		}
+		assert.NoError(CreateDownlinkRule(context.Background(), ts.Tx(), &r))
+
+		rGet, err := GetDownlinkRule(context.Background(), ts.Tx(), r.ID)
+		assert.NoError(err)
+		assert.Equal(r.Name, rGet.Name)
+		assert.Equal(r.Conditions, rGet.Conditions)
+		assert.Equal(r.Cooldown, rGet.Cooldown)
+		assert.EqualValues(2, rGet.UplinkFPort)
+
+		t.Run("List", func(t *testing.T) {
+			assert := require.New(t)
+
+			rules, err := GetDownlinkRules(context.Background(), ts.Tx(), app.ID)
+			assert.NoError(err)
+			assert.Len(rules, 1)
+		})
+
+		t.Run("Get enabled for uplink", func(t *testing.T) {
+			assert := require.New(t)
+
+			rules, err := GetEnabledDownlinkRulesForUplink(context.Background(), ts.Tx(), app.ID, 2)
+			assert.NoError(err)
+			assert.Len(rules, 1)
+
+			rules, err = GetEnabledDownlinkRulesForUplink(context.Background(), ts.Tx(), app.ID, 3)
+			assert.NoError(err)
+			assert.Len(rules, 0)
+		})
+
+		t.Run("Update", func(t *testing.T) {
+			assert := require.New(t)
+
+			r.Enabled = false
+			r.UplinkFPort = 0
+			assert.NoError(UpdateDownlinkRule(context.Background(), ts.Tx(), &r))
+
+			rules, err := GetEnabledDownlinkRulesForUplink(context.Background(), ts.Tx(), app.ID, 3)
+			assert.NoError(err)
+			assert.Len(rules, 0)
+
+			r.Enabled = true
+			assert.NoError(UpdateDownlinkRule(context.Background(), ts.Tx(), &r))
+
+			rules, err = GetEnabledDownlinkRulesForUplink(context.Background(), ts.Tx(), app.ID, 3)
+			assert.NoError(err)
+			assert.Len(rules, 1)
+		})
+
+		t.Run("Check trigger", func(t *testing.T) {
+			devEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+
+			t.Run("Cooldown", func(t *testing.T) {
+				assert := require.New(t)
+				RedisClient().FlushAll()
+
+				ok, err := CheckDownlinkRuleTrigger(context.Background(), r, devEUI)
+				assert.NoError(err)
+				assert.True(ok)
+
+				ok, err = CheckDownlinkRuleTrigger(context.Background(), r, devEUI)
+				assert.NoError(err)
+				assert.False(ok)
+
+				// other devices are not affected
+				ok, err = CheckDownlinkRuleTrigger(context.Background(), r, lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1})
+				assert.NoError(err)
+				assert.True(ok)
+			})
+
+			t.Run("Max triggers", func(t *testing.T) {
+				assert := require.New(t)
+				RedisClient().FlushAll()
+				SetDownlinkRuleLimits(time.Minute, 1, time.Hour)
+
+				ok, err := CheckDownlinkRuleTrigger(context.Background(), r, devEUI)
+				assert.NoError(err)
+				assert.True(ok)
+
+				r2 := r
+				r2.ID = uuid.Must(uuid.NewV4())
+				ok, err = CheckDownlinkRuleTrigger(context.Background(), r2, devEUI)
+				assert.NoError(err)
+				assert.False(ok)
+			})
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(DeleteDownlinkRule(context.Background(), ts.Tx(), r.ID))
+			assert.Equal(ErrDoesNotExist, errors.Cause(DeleteDownlinkRule(context.Background(), ts.Tx(), r.ID)))
+		})
+	})
+}
@@ -0,0 +1,288 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/downlink/template"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+// DownlinkTemplate defines a named downlink of an application. The
+// ObjectTemplate is a JSON object containing placeholders, which are set on
+// invocation and passed to the payload encoder.
+type DownlinkTemplate struct {
+	ID             uuid.UUID `db:"id"`
+	ApplicationID  int64     `db:"application_id"`
+	CreatedAt      time.Time `db:"created_at"`
+	UpdatedAt      time.Time `db:"updated_at"`
+	Name           string    `db:"name"`
+	Description    string    `db:"description"`
+	FPort          uint8     `db:"f_port"`
+	Confirmed      bool      `db:"confirmed"`
+	ObjectTemplate string    `db:"object_template"`
+}
+
+// Validate validates the downlink template data.
+func (t DownlinkTemplate) Validate() error {
+	if strings.TrimSpace(t.Name) == "" || len(t.Name) > 100 {
+		return ErrDownlinkTemplateInvalidName
+	}
+
+	if t.FPort == 0 || t.FPort > 223 {
+		return ErrDownlinkTemplateInvalidFPort
+	}
+
+	if err := template.Validate(t.ObjectTemplate); err != nil {
+		return ErrDownlinkTemplateInvalidObject
+	}
+
+	return nil
+}
+
+// DownlinkTemplateInvocation defines the (audit) record of a downlink
+// template invocation.
+type DownlinkTemplateInvocation struct {
+	ID                 int64           `db:"id"`
+	DownlinkTemplateID uuid.UUID       `db:"downlink_template_id"`
+	CreatedAt          time.Time       `db:"created_at"`
+	DevEUI             lorawan.EUI64   `db:"dev_eui"`
+	FCnt               uint32          `db:"f_cnt"`
+	Parameters         json.RawMessage `db:"parameters"`
+	InvokedBy          string          `db:"invoked_by"`
+}
+
+// CreateDownlinkTemplate creates the given downlink template.
+func CreateDownlinkTemplate(ctx context.Context, db sqlx.Execer, t *DownlinkTemplate) error {
+	if err := t.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	var err error
+	t.ID, err = uuid.NewV4()
+	if err != nil {
+		return errors.Wrap(err, "new uuid v4 error")
+	}
+
+	now := time.Now()
+	t.CreatedAt = now
+	t.UpdatedAt = now
+
+	_, err = db.Exec(`
+		insert into downlink_template (
+			id,
+			application_id,
+			created_at,
+			updated_at,
+			name,
+			description,
+			f_port,
+			confirmed,
+			object_template
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		t.ID,
+		t.ApplicationID,
+		t.CreatedAt,
+		t.UpdatedAt,
+		t.Name,
+		t.Description,
+		t.FPort,
+		t.Confirmed,
+		t.ObjectTemplate,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"id":             t.ID,
+		"application_id": t.ApplicationID,
+		"ctx_id":         ctx.Value(logging.ContextIDKey),
+	}).Info("downlink template created")
+
+	return nil
+}
+
+// GetDownlinkTemplate returns the downlink template for the given id.
+func GetDownlinkTemplate(ctx context.Context, db sqlx.Queryer, id uuid.UUID) (DownlinkTemplate, error) {
+	var t DownlinkTemplate
+	if err := sqlx.Get(db, &t, "select * from downlink_template where id = $1", id); err != nil {
+		return t, handlePSQLError(Select, err, "select error")
+	}
+
+	return t, nil
+}
+
+// GetDownlinkTemplates returns the downlink templates of the given
+// application, sorted by name.
+func GetDownlinkTemplates(ctx context.Context, db sqlx.Queryer, applicationID int64) ([]DownlinkTemplate, error) {
+	var out []DownlinkTemplate
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			downlink_template
+		where
+			application_id = $1
+		order by
+			name`,
+		applicationID,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// UpdateDownlinkTemplate updates the given downlink template.
+func UpdateDownlinkTemplate(ctx context.Context, db sqlx.Execer, t *DownlinkTemplate) error {
+	if err := t.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	t.UpdatedAt = time.Now()
+
+	res, err := db.Exec(`
+		update downlink_template
+		set
+			updated_at = $2,
+			name = $3,
+			description = $4,
+			f_port = $5,
+			confirmed = $6,
+			object_template = $7
+		where
+			id = $1`,
+		t.ID,
+		t.UpdatedAt,
+		t.Name,
+		t.Description,
+		t.FPort,
+		t.Confirmed,
+		t.ObjectTemplate,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"id":     t.ID,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("downlink template updated")
+
+	return nil
+}
+
+// DeleteDownlinkTemplate deletes the downlink template and its invocation
+// records.
+func DeleteDownlinkTemplate(ctx context.Context, db sqlx.Execer, id uuid.UUID) error {
+	res, err := db.Exec("delete from downlink_template where id = $1", id)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"id":     id,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("downlink template deleted")
+
+	return nil
+}
+
+// CreateDownlinkTemplateInvocation records the given downlink template
+// invocation.
+func CreateDownlinkTemplateInvocation(ctx context.Context, db sqlx.Queryer, i *DownlinkTemplateInvocation) error {
+	i.CreatedAt = time.Now()
+
+	err := sqlx.Get(db, &i.ID, `
+		insert into downlink_template_invocation (
+			downlink_template_id,
+			created_at,
+			dev_eui,
+			f_cnt,
+			parameters,
+			invoked_by
+		) values ($1, $2, $3, $4, $5, $6)
+		returning id`,
+		i.DownlinkTemplateID,
+		i.CreatedAt,
+		i.DevEUI[:],
+		i.FCnt,
+		[]byte(i.Parameters),
+		i.InvokedBy,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"downlink_template_id": i.DownlinkTemplateID,
+		"dev_eui":              i.DevEUI,
+		"f_cnt":                i.FCnt,
+		"invoked_by":           i.InvokedBy,
+		"ctx_id":               ctx.Value(logging.ContextIDKey),
+	}).Info("downlink template invoked")
+
+	return nil
+}
+
+// GetDownlinkTemplateInvocationCount returns the number of invocations of
+// the given downlink template.
+func GetDownlinkTemplateInvocationCount(ctx context.Context, db sqlx.Queryer, templateID uuid.UUID) (int, error) {
+	var count int
+	if err := sqlx.Get(db, &count, "select count(*) from downlink_template_invocation where downlink_template_id = $1", templateID); err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	return count, nil
+}
+
+// GetDownlinkTemplateInvocations returns the invocations of the given
+// downlink template, newest first.
+func GetDownlinkTemplateInvocations(ctx context.Context, db sqlx.Queryer, templateID uuid.UUID, limit, offset int) ([]DownlinkTemplateInvocation, error) {
+	var out []DownlinkTemplateInvocation
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			downlink_template_invocation
+		where
+			downlink_template_id = $1
+		order by
+			created_at desc,
+			id desc
+		limit $2
+		offset $3`,
+		templateID,
+		limit,
+		offset,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestDownlinkTemplate() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(context.Background(), ts.Tx(), &org))
+
+	n := NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(CreateNetworkServer(context.Background(), ts.Tx(), &n))
+
+	sp := ServiceProfile{
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+		Name:            "test-sp",
+	}
+	assert.NoError(CreateServiceProfile(context.Background(), ts.Tx(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(context.Background(), ts.Tx(), &app))
+
+	ts.T().Run("Create invalid", func(t *testing.T) {
+		assert := require.New(t)
+
+		tests := []struct {
+			Template DownlinkTemplate
+			Error    error
+		}{
+			{DownlinkTemplate{ApplicationID: app.ID, FPort: 1, ObjectTemplate: "{}"}, ErrDownlinkTemplateInvalidName},
+			{DownlinkTemplate{ApplicationID: app.ID, Name: "test", ObjectTemplate: "{}"}, ErrDownlinkTemplateInvalidFPort},
+			{DownlinkTemplate{ApplicationID: app.ID, Name: "test", FPort: 1, ObjectTemplate: "[]"}, ErrDownlinkTemplateInvalidObject},
+		}
+
+		for _, tst := range tests {
+			assert.Equal(tst.Error, errors.Cause(CreateDownlinkTemplate(context.Background(), ts.Tx(), &tst.Template)))
+		}
+	})
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		tmpl := DownlinkTemplate{
+			ApplicationID:  app.ID,
+			Name:           "set-interval",
+			Description:    "Set the reporting interval",
+			FPort:          10,
+			Confirmed:      true,
+			ObjectTemplate: `{"interval": "{{ interval }}"}`,
+		}
+		assert.NoError(CreateDownlinkTemplate(context.Background(), ts.Tx(), &tmpl))
+
+		tmplGet, err := GetDownlinkTemplate(context.Background(), ts.Tx(), tmpl.ID)
+		assert.NoError(err)
+		assert.Equal(tmpl.Name, tmplGet.Name)
+		assert.Equal(tmpl.FPort, tmplGet.FPort)
+		assert.True(tmplGet.Confirmed)
+		assert.Equal(tmpl.ObjectTemplate, tmplGet.ObjectTemplate)
+
+		t.Run("Name must be unique within application", func(t *testing.T) {
+			assert := require.New(t)
+
+			dup := tmpl
+			assert.Equal(ErrAlreadyExists, errors.Cause(CreateDownlinkTemplate(context.Background(), ts.Tx(), &dup)))
+		})
+
+		t.Run("List", func(t *testing.T) {
+			assert := require.New(t)
+
+			templates, err := GetDownlinkTemplates(context.Background(), ts.Tx(), app.ID)
+			assert.NoError(err)
+			assert.Len(templates, 1)
+			assert.Equal(tmpl.ID, templates[0].ID)
+		})
+
+		t.Run("Update", func(t *testing.T) {
+			assert := require.New(t)
+
+			tmpl.FPort = 11
+			tmpl.ObjectTemplate = `{"interval": "{{ interval }}", "unit": "s"}`
+			assert.NoError(UpdateDownlinkTemplate(context.Background(), ts.Tx(), &tmpl))
+
+			tmplGet, err := GetDownlinkTemplate(context.Background(), ts.Tx(), tmpl.ID)
+			assert.NoError(err)
+			assert.EqualValues(11, tmplGet.FPort)
+			assert.Equal(tmpl.ObjectTemplate, tmplGet.ObjectTemplate)
+		})
+
+		t.Run("Invocations", func(t *testing.T) {
+			assert := require.New(t)
+
+			for i := 0; i < 2; i++ {
+				assert.NoError(CreateDownlinkTemplateInvocation(context.Background(), ts.Tx(), &DownlinkTemplateInvocation{
+					DownlinkTemplateID: tmpl.ID,
+					DevEUI:             lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+					FCnt:               uint32(10 + i),
+					Parameters:         json.RawMessage(`{"interval": 60}`),
+					InvokedBy:          "admin",
+				}))
+			}
+
+			count, err := GetDownlinkTemplateInvocationCount(context.Background(), ts.Tx(), tmpl.ID)
+			assert.NoError(err)
+			assert.Equal(2, count)
+
+			invocations, err := GetDownlinkTemplateInvocations(context.Background(), ts.Tx(), tmpl.ID, 10, 0)
+			assert.NoError(err)
+			assert.Len(invocations, 2)
+			assert.EqualValues(11, invocations[0].FCnt)
+			assert.Equal("admin", invocations[0].InvokedBy)
+			assert.JSONEq(`{"interval": 60}`, string(invocations[0].Parameters))
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(DeleteDownlinkTemplate(context.Background(), ts.Tx(), tmpl.ID))
+			assert.Equal(ErrDoesNotExist, errors.Cause(DeleteDownlinkTemplate(context.Background(), ts.Tx(), tmpl.ID)))
+
+			count, err := GetDownlinkTemplateInvocationCount(context.Background(), ts.Tx(), tmpl.ID)
+			assert.NoError(err)
+			assert.Equal(0, count)
+		})
+	})
+}
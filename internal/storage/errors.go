@@ -44,6 +44,77 @@ var (
 	ErrOrganizationMaxGatewayCount     = errors.New("organization reached max. gateway count")
 	ErrNetworkServerInvalidName        = errors.New("invalid network-server name")
 	ErrAPIKeyInvalidName               = errors.New("invalid API Key name")
+	ErrApplicationRetentionInvalidDays = errors.New("retention days must be greater than or equal to 0")
+	ErrTransactionConflict             = errors.New("transaction conflicts with a concurrent transaction, please retry")
+	ErrObjectModified                  = errors.New("object has been modified since it was retrieved, reload it and try again")
+	ErrDeviceInvalidPayloadCodec       = errors.New("invalid payload codec")
+	ErrCodecInvalidName                = errors.New("invalid codec name")
+	ErrDeviceDownlinkRateLimit         = errors.New("device downlink rate limit exceeded, please retry later")
+	ErrApplicationDownlinkRateLimit    = errors.New("application downlink rate limit exceeded, please retry later")
+	ErrDownlinkDuplicate               = errors.New("an identical downlink is already pending in the device-queue")
+	ErrDownlinkTemplateInvalidName     = errors.New("invalid downlink template name")
+	ErrDownlinkTemplateInvalidFPort    = errors.New("downlink template fPort must be between 1 and 223")
+	ErrDownlinkTemplateInvalidObject   = errors.New("downlink template object must be a valid JSON object")
+	ErrFirmwareCampaignInvalidName     = errors.New("invalid firmware campaign name")
+	ErrFirmwareCampaignInvalidFPort    = errors.New("firmware campaign fPort must be between 1 and 223")
+	ErrFirmwareCampaignNullPayload     = errors.New("firmware campaign payload must not be empty")
+	ErrFirmwareCampaignInvalidChunk    = errors.New("firmware campaign chunk size must be between 5 and 242")
+	ErrFirmwareCampaignInvalidDataRate = errors.New("invalid firmware campaign spreading-factor or bandwidth")
+	ErrFirmwareCampaignInvalidDuty     = errors.New("firmware campaign duty-cycle must be greater than 0 and less than or equal to 1")
+	ErrFirmwareCampaignNoDevices       = errors.New("firmware campaign must contain at least one device")
+	ErrDownlinkRuleInvalidName         = errors.New("invalid downlink rule name")
+	ErrDownlinkRuleInvalidUplinkFPort  = errors.New("downlink rule uplink fPort must be between 0 (any) and 223")
+	ErrDownlinkRuleInvalidConditions   = errors.New("downlink rule must contain between 1 and 32 valid conditions")
+	ErrDownlinkRuleInvalidFPort        = errors.New("downlink rule fPort must be between 1 and 223")
+	ErrDownlinkRuleInvalidObject       = errors.New("downlink rule object must be a valid JSON object")
+	ErrDownlinkRuleInvalidCooldown     = errors.New("downlink rule cooldown is below the configured minimum")
+	ErrRelayInvalidCADPeriodicity      = errors.New("relay CAD periodicity must be between 0 and 5")
+	ErrRelayInvalidDefaultChannel      = errors.New("relay default channel index must be 0 or 1")
+	ErrRelayInvalidSecondChannel       = errors.New("relay second channel frequency must be a multiple of 100 Hz, data-rate between 0 and 15 and ack offset between 0 and 5")
+	ErrRelayInvalidDevice              = errors.New("a relay can not be attached to itself")
+	ErrRelayMaxDevices                 = errors.New("relay reached max. number of attached devices")
+	ErrRelayInvalidFilterIndex         = errors.New("relay filter index must be between 0 and 15")
+	ErrRelayInvalidFilterAction        = errors.New("relay filter action must be between 0 and 2")
+	ErrRelayInvalidFilterEUI           = errors.New("relay filter with DevEUI must also contain the JoinEUI")
+	ErrRelayEndDeviceInvalidMode       = errors.New("relay end-device mode must be between 0 and 3")
+	ErrRelayEndDeviceInvalidSmartLevel = errors.New("relay end-device smart enable level must be between 0 and 3")
+	ErrRelayEndDeviceInvalidBackoff    = errors.New("relay end-device backoff must be between 0 and 63")
+	ErrAutomationInvalidName           = errors.New("invalid automation name")
+	ErrAutomationInvalidTrigger        = errors.New("invalid automation trigger")
+	ErrAutomationInvalidActions        = errors.New("invalid automation actions")
+	ErrAutomationInvalidCooldown       = errors.New("automation cooldown is below the configured minimum")
+	ErrAutomationInvalidEscalation     = errors.New("invalid automation escalation")
+	ErrAutomationInvalidDampening      = errors.New("invalid automation dampening")
+	ErrAutomationInvalidSeverity       = errors.New("invalid automation severity")
+	ErrAutomationAlarmAcknowledged     = errors.New("automation alarm has already been acknowledged")
+	ErrMaintenanceInvalidName          = errors.New("invalid maintenance window name")
+	ErrMaintenanceInvalidScope         = errors.New("maintenance window can not have both a device and device tags")
+	ErrMaintenanceInvalidDuration      = errors.New("maintenance window duration must be positive and can not exceed the recurrence interval")
+	ErrMaintenanceInvalidRecurrence    = errors.New("invalid maintenance window recurrence")
+	ErrThresholdProfileInvalidName     = errors.New("invalid threshold profile name")
+	ErrThresholdProfileInvalidLimits   = errors.New("invalid threshold profile thresholds")
+	ErrThresholdProfileInUse           = errors.New("threshold profile is used by one or more automations")
+	ErrNotificationRouteInvalidName    = errors.New("invalid notification route name")
+	ErrNotificationRouteInvalidLevel   = errors.New("invalid notification route min. severity")
+	ErrNotificationRouteInvalidChannel = errors.New("invalid notification route channel or recipients")
+	ErrReportInvalidName               = errors.New("invalid report subscription name")
+	ErrReportInvalidSchedule           = errors.New("invalid report schedule")
+	ErrReportInvalidFormat             = errors.New("invalid report format")
+	ErrReportInvalidZone               = errors.New("report zone requires a zone tag key")
+	ErrReportInvalidMeasurements       = errors.New("invalid report measurements")
+	ErrDeviceGroupInvalidName          = errors.New("invalid device group name")
+	ErrDeviceGroupInvalidType          = errors.New("invalid device group type")
+	ErrDeviceGroupInvalidTags          = errors.New("a tag device group requires device tags, a manual device group can not have device tags")
+	ErrDeviceGroupNotManual            = errors.New("devices can only be added to or removed from a manual device group")
+	ErrGeofenceInvalidName             = errors.New("invalid geofence name")
+	ErrGeofenceInvalidZone             = errors.New("invalid geofence zone")
+	ErrGeofenceInvalidBoundary         = errors.New("invalid geofence boundary")
+	ErrIrrigationInvalidName           = errors.New("invalid irrigation schedule name")
+	ErrIrrigationInvalidSchedule       = errors.New("invalid irrigation schedule")
+	ErrIrrigationInvalidDownlink       = errors.New("invalid irrigation downlink")
+	ErrIrrigationInvalidDuration       = errors.New("irrigation duration must be between 1 minute and 24 hours")
+	ErrIrrigationInvalidDevices        = errors.New("irrigation devices must belong to the application of the schedule")
+	ErrIrrigationNoStopDownlink        = errors.New("irrigation schedule has no stop downlink")
 )
 
 func handlePSQLError(action Action, err error, description string) error {
@@ -53,6 +124,10 @@ func handlePSQLError(action Action, err error, description string) error {
 
 	switch err := err.(type) {
 	case *pq.Error:
+		if isRetryablePQError(err) {
+			return ErrTransactionConflict
+		}
+
 		switch err.Code.Name() {
 		case "unique_violation":
 			return ErrAlreadyExists
@@ -0,0 +1,500 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/fwcampaign/chunk"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+// FirmwareCampaignState defines the firmware campaign state.
+type FirmwareCampaignState string
+
+// Firmware campaign states.
+const (
+	FirmwareCampaignRunning FirmwareCampaignState = "RUNNING"
+	FirmwareCampaignDone    FirmwareCampaignState = "DONE"
+)
+
+// FirmwareCampaignDeviceState defines the firmware campaign device state.
+type FirmwareCampaignDeviceState string
+
+// Firmware campaign device states.
+const (
+	FirmwareCampaignDevicePending FirmwareCampaignDeviceState = "PENDING"
+	FirmwareCampaignDeviceSuccess FirmwareCampaignDeviceState = "SUCCESS"
+	FirmwareCampaignDeviceError   FirmwareCampaignDeviceState = "ERROR"
+)
+
+// FirmwareCampaign defines a firmware update campaign, sending the payload
+// in chunks over unicast downlinks to each device of the campaign. The
+// ChunkInterval defines the min. interval between two chunks sent to the
+// same device.
+type FirmwareCampaign struct {
+	ID              uuid.UUID             `db:"id"`
+	ApplicationID   int64                 `db:"application_id"`
+	CreatedAt       time.Time             `db:"created_at"`
+	UpdatedAt       time.Time             `db:"updated_at"`
+	Name            string                `db:"name"`
+	FPort           uint8                 `db:"f_port"`
+	Payload         []byte                `db:"payload"`
+	ChunkSize       int                   `db:"chunk_size"`
+	SpreadingFactor int                   `db:"spreading_factor"`
+	Bandwidth       int                   `db:"bandwidth"`
+	DutyCycle       float64               `db:"duty_cycle"`
+	ChunkInterval   time.Duration         `db:"chunk_interval"`
+	State           FirmwareCampaignState `db:"state"`
+	CompletedAt     *time.Time            `db:"completed_at"`
+}
+
+// Validate validates the firmware campaign data.
+func (fc FirmwareCampaign) Validate() error {
+	if strings.TrimSpace(fc.Name) == "" || len(fc.Name) > 100 {
+		return ErrFirmwareCampaignInvalidName
+	}
+	if fc.FPort == 0 || fc.FPort > 223 {
+		return ErrFirmwareCampaignInvalidFPort
+	}
+	if len(fc.Payload) == 0 {
+		return ErrFirmwareCampaignNullPayload
+	}
+	if fc.ChunkSize < 5 || fc.ChunkSize > 242 {
+		return ErrFirmwareCampaignInvalidChunk
+	}
+	if fc.SpreadingFactor < 7 || fc.SpreadingFactor > 12 {
+		return ErrFirmwareCampaignInvalidDataRate
+	}
+	if fc.Bandwidth != 125 && fc.Bandwidth != 250 && fc.Bandwidth != 500 {
+		return ErrFirmwareCampaignInvalidDataRate
+	}
+	if fc.DutyCycle <= 0 || fc.DutyCycle > 1 {
+		return ErrFirmwareCampaignInvalidDuty
+	}
+	return nil
+}
+
+// FirmwareCampaignDevice defines the device record of a firmware campaign.
+type FirmwareCampaignDevice struct {
+	FirmwareCampaignID uuid.UUID                   `db:"firmware_campaign_id"`
+	DevEUI             lorawan.EUI64               `db:"dev_eui"`
+	CreatedAt          time.Time                   `db:"created_at"`
+	UpdatedAt          time.Time                   `db:"updated_at"`
+	State              FirmwareCampaignDeviceState `db:"state"`
+	NextChunk          int                         `db:"next_chunk"`
+	ChunkCount         int                         `db:"chunk_count"`
+	NextChunkAfter     time.Time                   `db:"next_chunk_after"`
+	ErrorMessage       string                      `db:"error_message"`
+	CompletedAt        *time.Time                  `db:"completed_at"`
+}
+
+// FirmwareCampaignDeviceListItem defines the firmware campaign device record
+// for listing.
+type FirmwareCampaignDeviceListItem struct {
+	FirmwareCampaignDevice
+	DeviceName string `db:"device_name"`
+}
+
+// FirmwareCampaignProgress defines the progress of a firmware campaign.
+type FirmwareCampaignProgress struct {
+	DevicePendingCount int `db:"device_pending_count"`
+	DeviceSuccessCount int `db:"device_success_count"`
+	DeviceErrorCount   int `db:"device_error_count"`
+	ChunksSent         int `db:"chunks_sent"`
+	ChunksTotal        int `db:"chunks_total"`
+}
+
+// CreateFirmwareCampaign creates the given firmware campaign for the given
+// devices. The chunk interval is raised to the min. interval required by
+// the duty-cycle of the campaign.
+func CreateFirmwareCampaign(ctx context.Context, db sqlx.Execer, fc *FirmwareCampaign, devEUIs []lorawan.EUI64) error {
+	if err := fc.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+	if len(devEUIs) == 0 {
+		return errors.Wrap(ErrFirmwareCampaignNoDevices, "validate error")
+	}
+
+	var err error
+	fc.ID, err = uuid.NewV4()
+	if err != nil {
+		return errors.Wrap(err, "new uuid v4 error")
+	}
+
+	now := time.Now()
+	fc.CreatedAt = now
+	fc.UpdatedAt = now
+	fc.State = FirmwareCampaignRunning
+	fc.CompletedAt = nil
+
+	if minInterval := chunk.Interval(fc.ChunkSize, fc.SpreadingFactor, fc.Bandwidth, fc.DutyCycle); fc.ChunkInterval < minInterval {
+		fc.ChunkInterval = minInterval
+	}
+
+	_, err = db.Exec(`
+		insert into firmware_campaign (
+			id,
+			application_id,
+			created_at,
+			updated_at,
+			name,
+			f_port,
+			payload,
+			chunk_size,
+			spreading_factor,
+			bandwidth,
+			duty_cycle,
+			chunk_interval,
+			state,
+			completed_at
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		fc.ID,
+		fc.ApplicationID,
+		fc.CreatedAt,
+		fc.UpdatedAt,
+		fc.Name,
+		fc.FPort,
+		fc.Payload,
+		fc.ChunkSize,
+		fc.SpreadingFactor,
+		fc.Bandwidth,
+		fc.DutyCycle,
+		fc.ChunkInterval,
+		fc.State,
+		fc.CompletedAt,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	chunkCount := chunk.Count(len(fc.Payload), fc.ChunkSize)
+	for _, devEUI := range devEUIs {
+		_, err = db.Exec(`
+			insert into firmware_campaign_device (
+				firmware_campaign_id,
+				dev_eui,
+				created_at,
+				updated_at,
+				state,
+				next_chunk,
+				chunk_count,
+				next_chunk_after,
+				error_message
+			) values ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			fc.ID,
+			devEUI[:],
+			now,
+			now,
+			FirmwareCampaignDevicePending,
+			0,
+			chunkCount,
+			now,
+			"",
+		)
+		if err != nil {
+			return handlePSQLError(Insert, err, "insert error")
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"id":             fc.ID,
+		"application_id": fc.ApplicationID,
+		"device_count":   len(devEUIs),
+		"chunk_count":    chunkCount,
+		"chunk_interval": fc.ChunkInterval,
+		"ctx_id":         ctx.Value(logging.ContextIDKey),
+	}).Info("firmware campaign created")
+
+	return nil
+}
+
+// GetFirmwareCampaign returns the firmware campaign for the given id.
+func GetFirmwareCampaign(ctx context.Context, db sqlx.Queryer, id uuid.UUID) (FirmwareCampaign, error) {
+	var fc FirmwareCampaign
+	if err := sqlx.Get(db, &fc, "select * from firmware_campaign where id = $1", id); err != nil {
+		return fc, handlePSQLError(Select, err, "select error")
+	}
+
+	return fc, nil
+}
+
+// GetFirmwareCampaignCount returns the number of firmware campaigns of the
+// given application.
+func GetFirmwareCampaignCount(ctx context.Context, db sqlx.Queryer, applicationID int64) (int, error) {
+	var count int
+	err := sqlx.Get(db, &count, `
+		select
+			count(*)
+		from
+			firmware_campaign
+		where
+			application_id = $1`,
+		applicationID,
+	)
+	if err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	return count, nil
+}
+
+// GetFirmwareCampaigns returns the firmware campaigns of the given
+// application, most recent first.
+func GetFirmwareCampaigns(ctx context.Context, db sqlx.Queryer, applicationID int64, limit, offset int) ([]FirmwareCampaign, error) {
+	var out []FirmwareCampaign
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			firmware_campaign
+		where
+			application_id = $1
+		order by
+			created_at desc
+		limit $2
+		offset $3`,
+		applicationID,
+		limit,
+		offset,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// DeleteFirmwareCampaign deletes the firmware campaign for the given id.
+// Chunks which are already enqueued are not removed from the device-queue.
+func DeleteFirmwareCampaign(ctx context.Context, db sqlx.Execer, id uuid.UUID) error {
+	res, err := db.Exec("delete from firmware_campaign where id = $1", id)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"id":     id,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("firmware campaign deleted")
+
+	return nil
+}
+
+// GetFirmwareCampaignProgress returns the progress of the given firmware
+// campaign.
+func GetFirmwareCampaignProgress(ctx context.Context, db sqlx.Queryer, id uuid.UUID) (FirmwareCampaignProgress, error) {
+	var p FirmwareCampaignProgress
+	err := sqlx.Get(db, &p, `
+		select
+			count(*) filter (where state = $2) as device_pending_count,
+			count(*) filter (where state = $3) as device_success_count,
+			count(*) filter (where state = $4) as device_error_count,
+			coalesce(sum(next_chunk), 0) as chunks_sent,
+			coalesce(sum(chunk_count), 0) as chunks_total
+		from
+			firmware_campaign_device
+		where
+			firmware_campaign_id = $1`,
+		id,
+		FirmwareCampaignDevicePending,
+		FirmwareCampaignDeviceSuccess,
+		FirmwareCampaignDeviceError,
+	)
+	if err != nil {
+		return p, handlePSQLError(Select, err, "select error")
+	}
+
+	return p, nil
+}
+
+// GetFirmwareCampaignDeviceCount returns the number of devices of the given
+// firmware campaign.
+func GetFirmwareCampaignDeviceCount(ctx context.Context, db sqlx.Queryer, id uuid.UUID) (int, error) {
+	var count int
+	err := sqlx.Get(db, &count, `
+		select
+			count(*)
+		from
+			firmware_campaign_device
+		where
+			firmware_campaign_id = $1`,
+		id,
+	)
+	if err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	return count, nil
+}
+
+// GetFirmwareCampaignDevices returns the devices of the given firmware
+// campaign, sorted by device name.
+func GetFirmwareCampaignDevices(ctx context.Context, db sqlx.Queryer, id uuid.UUID, limit, offset int) ([]FirmwareCampaignDeviceListItem, error) {
+	var out []FirmwareCampaignDeviceListItem
+	err := sqlx.Select(db, &out, `
+		select
+			fcd.*,
+			d.name as device_name
+		from
+			firmware_campaign_device fcd
+		inner join
+			device d
+		on
+			fcd.dev_eui = d.dev_eui
+		where
+			fcd.firmware_campaign_id = $1
+		order by
+			d.name
+		limit $2
+		offset $3`,
+		id,
+		limit,
+		offset,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetPendingFirmwareCampaignDevices returns the pending firmware campaign
+// devices for which the next chunk is due. The returned records are locked,
+// other transactions skip these.
+func GetPendingFirmwareCampaignDevices(ctx context.Context, db sqlx.Queryer, limit int) ([]FirmwareCampaignDevice, error) {
+	var out []FirmwareCampaignDevice
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			firmware_campaign_device
+		where
+			state = $1
+			and next_chunk_after <= $2
+		order by
+			next_chunk_after
+		limit $3
+		for update
+		skip locked`,
+		FirmwareCampaignDevicePending,
+		time.Now(),
+		limit,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// UpdateFirmwareCampaignDevice updates the given firmware campaign device.
+func UpdateFirmwareCampaignDevice(ctx context.Context, db sqlx.Execer, fcd *FirmwareCampaignDevice) error {
+	fcd.UpdatedAt = time.Now()
+
+	res, err := db.Exec(`
+		update
+			firmware_campaign_device
+		set
+			updated_at = $3,
+			state = $4,
+			next_chunk = $5,
+			next_chunk_after = $6,
+			error_message = $7,
+			completed_at = $8
+		where
+			firmware_campaign_id = $1
+			and dev_eui = $2`,
+		fcd.FirmwareCampaignID,
+		fcd.DevEUI[:],
+		fcd.UpdatedAt,
+		fcd.State,
+		fcd.NextChunk,
+		fcd.NextChunkAfter,
+		fcd.ErrorMessage,
+		fcd.CompletedAt,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"firmware_campaign_id": fcd.FirmwareCampaignID,
+		"dev_eui":              fcd.DevEUI,
+		"state":                fcd.State,
+		"next_chunk":           fcd.NextChunk,
+		"ctx_id":               ctx.Value(logging.ContextIDKey),
+	}).Info("firmware campaign device updated")
+
+	return nil
+}
+
+// CompleteFirmwareCampaign sets the given firmware campaign to done when
+// none of its devices are pending. It returns true when the campaign has
+// been completed by this call.
+func CompleteFirmwareCampaign(ctx context.Context, db sqlx.Execer, id uuid.UUID) (bool, error) {
+	now := time.Now()
+
+	res, err := db.Exec(`
+		update
+			firmware_campaign
+		set
+			updated_at = $2,
+			state = $3,
+			completed_at = $2
+		where
+			id = $1
+			and state = $4
+			and not exists (
+				select
+					1
+				from
+					firmware_campaign_device
+				where
+					firmware_campaign_id = $1
+					and state = $5
+			)`,
+		id,
+		now,
+		FirmwareCampaignDone,
+		FirmwareCampaignRunning,
+		FirmwareCampaignDevicePending,
+	)
+	if err != nil {
+		return false, handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return false, nil
+	}
+
+	log.WithFields(log.Fields{
+		"id":     id,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("firmware campaign completed")
+
+	return true, nil
+}
@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	uuid "github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	nsmock "github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestFirmwareCampaign() {
+	assert := require.New(ts.T())
+
+	nsClient := nsmock.NewClient()
+	networkserver.SetPool(nsmock.NewPool(nsClient))
+
+	n := NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(CreateNetworkServer(context.Background(), ts.tx, &n))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(context.Background(), ts.tx, &org))
+
+	sp := ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateServiceProfile(context.Background(), ts.tx, &sp))
+	var spID uuid.UUID
+	copy(spID[:], sp.ServiceProfile.Id)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(context.Background(), ts.tx, &app))
+
+	dp := DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateDeviceProfile(context.Background(), ts.tx, &dp))
+	var dpID uuid.UUID
+	copy(dpID[:], dp.DeviceProfile.Id)
+
+	devices := []Device{
+		{
+			DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+			ApplicationID:   app.ID,
+			DeviceProfileID: dpID,
+			Name:            "test-device-1",
+		},
+		{
+			DevEUI:          lorawan.EUI64{2, 2, 3, 4, 5, 6, 7, 8},
+			ApplicationID:   app.ID,
+			DeviceProfileID: dpID,
+			Name:            "test-device-2",
+		},
+	}
+	for i := range devices {
+		assert.NoError(CreateDevice(context.Background(), ts.tx, &devices[i]))
+	}
+	devEUIs := []lorawan.EUI64{devices[0].DevEUI, devices[1].DevEUI}
+
+	ts.T().Run("Create invalid", func(t *testing.T) {
+		assert := require.New(t)
+
+		fc := FirmwareCampaign{
+			ApplicationID:   app.ID,
+			Name:            "test-campaign",
+			FPort:           10,
+			Payload:         []byte{1, 2, 3, 4, 5},
+			ChunkSize:       4,
+			SpreadingFactor: 7,
+			Bandwidth:       125,
+			DutyCycle:       0.01,
+		}
+		assert.Equal(ErrFirmwareCampaignInvalidChunk, errors.Cause(CreateFirmwareCampaign(context.Background(), ts.tx, &fc, devEUIs)))
+
+		fc.ChunkSize = 6
+		fc.DutyCycle = 0
+		assert.Equal(ErrFirmwareCampaignInvalidDuty, errors.Cause(CreateFirmwareCampaign(context.Background(), ts.tx, &fc, devEUIs)))
+
+		fc.DutyCycle = 0.01
+		assert.Equal(ErrFirmwareCampaignNoDevices, errors.Cause(CreateFirmwareCampaign(context.Background(), ts.tx, &fc, nil)))
+	})
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		fc := FirmwareCampaign{
+			ApplicationID:   app.ID,
+			Name:            "test-campaign",
+			FPort:           10,
+			Payload:         []byte{1, 2, 3, 4, 5},
+			ChunkSize:       6,
+			SpreadingFactor: 7,
+			Bandwidth:       125,
+			DutyCycle:       0.01,
+			ChunkInterval:   time.Second,
+		}
+		assert.NoError(CreateFirmwareCampaign(context.Background(), ts.tx, &fc, devEUIs))
+		assert.Equal(FirmwareCampaignRunning, fc.State)
+		assert.True(fc.ChunkInterval > time.Second)
+
+		t.Run("Get", func(t *testing.T) {
+			assert := require.New(t)
+
+			fcGet, err := GetFirmwareCampaign(context.Background(), ts.tx, fc.ID)
+			assert.NoError(err)
+			assert.Equal(fc.Name, fcGet.Name)
+			assert.Equal(fc.Payload, fcGet.Payload)
+			assert.Equal(fc.ChunkInterval, fcGet.ChunkInterval)
+			assert.Equal(FirmwareCampaignRunning, fcGet.State)
+		})
+
+		t.Run("List", func(t *testing.T) {
+			assert := require.New(t)
+
+			count, err := GetFirmwareCampaignCount(context.Background(), ts.tx, app.ID)
+			assert.NoError(err)
+			assert.Equal(1, count)
+
+			items, err := GetFirmwareCampaigns(context.Background(), ts.tx, app.ID, 10, 0)
+			assert.NoError(err)
+			assert.Len(items, 1)
+			assert.Equal(fc.ID, items[0].ID)
+
+			count, err = GetFirmwareCampaignDeviceCount(context.Background(), ts.tx, fc.ID)
+			assert.NoError(err)
+			assert.Equal(2, count)
+
+			fcDevices, err := GetFirmwareCampaignDevices(context.Background(), ts.tx, fc.ID, 10, 0)
+			assert.NoError(err)
+			assert.Len(fcDevices, 2)
+			assert.Equal("test-device-1", fcDevices[0].DeviceName)
+			assert.Equal(3, fcDevices[0].ChunkCount)
+		})
+
+		t.Run("Progress", func(t *testing.T) {
+			assert := require.New(t)
+
+			pending, err := GetPendingFirmwareCampaignDevices(context.Background(), ts.tx, 10)
+			assert.NoError(err)
+			assert.Len(pending, 2)
+
+			pending[0].NextChunk = 3
+			pending[0].State = FirmwareCampaignDeviceSuccess
+			assert.NoError(UpdateFirmwareCampaignDevice(context.Background(), ts.tx, &pending[0]))
+
+			pending[1].NextChunk = 1
+			pending[1].NextChunkAfter = time.Now().Add(time.Hour)
+			assert.NoError(UpdateFirmwareCampaignDevice(context.Background(), ts.tx, &pending[1]))
+
+			p, err := GetFirmwareCampaignProgress(context.Background(), ts.tx, fc.ID)
+			assert.NoError(err)
+			assert.Equal(FirmwareCampaignProgress{
+				DevicePendingCount: 1,
+				DeviceSuccessCount: 1,
+				ChunksSent:         4,
+				ChunksTotal:        6,
+			}, p)
+
+			pending, err = GetPendingFirmwareCampaignDevices(context.Background(), ts.tx, 10)
+			assert.NoError(err)
+			assert.Len(pending, 0)
+
+			completed, err := CompleteFirmwareCampaign(context.Background(), ts.tx, fc.ID)
+			assert.NoError(err)
+			assert.False(completed)
+		})
+
+		t.Run("Complete", func(t *testing.T) {
+			assert := require.New(t)
+
+			fcd := FirmwareCampaignDevice{
+				FirmwareCampaignID: fc.ID,
+				DevEUI:             devices[1].DevEUI,
+				State:              FirmwareCampaignDeviceError,
+				NextChunk:          1,
+				ErrorMessage:       "enqueue error",
+			}
+			assert.NoError(UpdateFirmwareCampaignDevice(context.Background(), ts.tx, &fcd))
+
+			completed, err := CompleteFirmwareCampaign(context.Background(), ts.tx, fc.ID)
+			assert.NoError(err)
+			assert.True(completed)
+
+			fcGet, err := GetFirmwareCampaign(context.Background(), ts.tx, fc.ID)
+			assert.NoError(err)
+			assert.Equal(FirmwareCampaignDone, fcGet.State)
+			assert.NotNil(fcGet.CompletedAt)
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(DeleteFirmwareCampaign(context.Background(), ts.tx, fc.ID))
+			assert.Equal(ErrDoesNotExist, DeleteFirmwareCampaign(context.Background(), ts.tx, fc.ID))
+		})
+	})
+}
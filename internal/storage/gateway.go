@@ -292,6 +292,8 @@ func DeleteGateway(ctx context.Context, db sqlx.Ext, mac lorawan.EUI64) error {
 
 // GetGateway returns the gateway for the given mac.
 func GetGateway(ctx context.Context, db sqlx.Queryer, mac lorawan.EUI64, forUpdate bool) (Gateway, error) {
+	defer observeQueryDuration("gateway_get", time.Now())
+
 	var fu string
 	if forUpdate {
 		fu = " for update"
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	//"github.com/brocaar/lorawan"
+)
+
+// GatewayCoverageSample defines the (aggregated) reception by a gateway of
+// the uplinks of a located device.
+type GatewayCoverageSample struct {
+	DevEUI     lorawan.EUI64 `db:"dev_eui"`
+	Latitude   float64       `db:"latitude"`
+	Longitude  float64       `db:"longitude"`
+	Frames     int           `db:"frames"`
+	AvgRSSI    float64       `db:"avg_rssi"`
+	AvgLoRaSNR float64       `db:"avg_lora_snr"`
+}
+
+// GetGatewayCoverageSamples returns per device the reception by the given
+// gateway of the uplinks received within the given time range, based on the
+// RX metadata of the device frame-log. Only the devices of the given
+// organization which have a location are returned. As the current location
+// of the device is used, the samples of moving devices are not accurate.
+func GetGatewayCoverageSamples(ctx context.Context, db sqlx.Queryer, gatewayID lorawan.EUI64, organizationID int64, start, end time.Time) ([]GatewayCoverageSample, error) {
+	defer observeQueryDuration("gateway_coverage_samples_get", time.Now())
+
+	var out []GatewayCoverageSample
+	err := sqlx.Select(db, &out, `
+		select
+			d.dev_eui,
+			d.latitude,
+			d.longitude,
+			count(*) as frames,
+			avg(rx.rssi) as avg_rssi,
+			avg(rx.lora_snr) as avg_lora_snr
+		from
+			device_frame_log fl
+		inner join device d
+			on d.dev_eui = fl.dev_eui
+		inner join application a
+			on a.id = d.application_id
+		cross join lateral (
+			select
+				(e->>'rssi')::integer as rssi,
+				(e->>'loRaSNR')::float as lora_snr
+			from
+				jsonb_array_elements(case when jsonb_typeof(fl.rx_info) = 'array' then fl.rx_info else '[]'::jsonb end) e
+			where
+				e->>'gatewayID' = $1
+		) rx
+		where
+			a.organization_id = $2
+			and fl.received_at >= $3
+			and fl.received_at < $4
+			and d.latitude is not null
+			and d.longitude is not null
+		group by
+			d.dev_eui
+		order by
+			d.dev_eui`,
+		gatewayID.String(),
+		organizationID,
+		start,
+		end,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
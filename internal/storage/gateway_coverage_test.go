@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestGetGatewayCoverageSamples() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(ctx, ts.Tx(), &org))
+
+	n := NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(CreateNetworkServer(ctx, ts.Tx(), &n))
+
+	sp := ServiceProfile{
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+		Name:            "test-sp",
+	}
+	assert.NoError(CreateServiceProfile(ctx, ts.Tx(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(ctx, ts.Tx(), &app))
+
+	dp := DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateDeviceProfile(ctx, ts.Tx(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	lat := 52.1
+	lon := 4.2
+	located := Device{
+		DevEUI:          lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 1},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "located",
+		Latitude:        &lat,
+		Longitude:       &lon,
+	}
+	assert.NoError(CreateDevice(ctx, ts.Tx(), &located))
+
+	unlocated := Device{
+		DevEUI:          lorawan.EUI64{2, 2, 2, 2, 2, 2, 2, 2},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "unlocated",
+	}
+	assert.NoError(CreateDevice(ctx, ts.Tx(), &unlocated))
+
+	gw1 := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	gw2 := lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1}
+	now := time.Now()
+
+	for i, f := range []struct {
+		Device Device
+		RXInfo []DeviceFrameLogRXInfo
+	}{
+		{located, []DeviceFrameLogRXInfo{{GatewayID: gw1, RSSI: -100, LoRaSNR: 2}, {GatewayID: gw2, RSSI: -50, LoRaSNR: 10}}},
+		{located, []DeviceFrameLogRXInfo{{GatewayID: gw1, RSSI: -110, LoRaSNR: -4}}},
+		{located, nil},
+		{unlocated, []DeviceFrameLogRXInfo{{GatewayID: gw1, RSSI: -90, LoRaSNR: 5}}},
+	} {
+		b, err := json.Marshal(f.RXInfo)
+		assert.NoError(err)
+
+		assert.NoError(CreateDeviceFrameLog(ctx, ts.Tx(), DeviceFrameLog{
+			DevEUI:        f.Device.DevEUI,
+			ApplicationID: app.ID,
+			ReceivedAt:    now,
+			FCnt:          uint32(i),
+			RXInfo:        b,
+		}))
+	}
+
+	samples, err := GetGatewayCoverageSamples(ctx, ts.Tx(), gw1, org.ID, now.Add(-time.Minute), now.Add(time.Minute))
+	assert.NoError(err)
+	assert.Equal([]GatewayCoverageSample{
+		{
+			DevEUI:     located.DevEUI,
+			Latitude:   lat,
+			Longitude:  lon,
+			Frames:     2,
+			AvgRSSI:    -105,
+			AvgLoRaSNR: -1,
+		},
+	}, samples)
+
+	// other organization
+	samples, err = GetGatewayCoverageSamples(ctx, ts.Tx(), gw1, org.ID+1, now.Add(-time.Minute), now.Add(time.Minute))
+	assert.NoError(err)
+	assert.Len(samples, 0)
+
+	// outside time range
+	samples, err = GetGatewayCoverageSamples(ctx, ts.Tx(), gw1, org.ID, now.Add(time.Minute), now.Add(time.Hour))
+	assert.NoError(err)
+	assert.Len(samples, 0)
+}
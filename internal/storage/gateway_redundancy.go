@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	//"github.com/brocaar/lorawan"
+)
+
+// GatewayRedundancyDevice defines the number of frames of a device received
+// by a gateway and the number of frames for which the gateway was the sole
+// receiver.
+type GatewayRedundancyDevice struct {
+	DevEUI             lorawan.EUI64 `db:"dev_eui"`
+	Frames             int           `db:"frames"`
+	SoleReceiverFrames int           `db:"sole_receiver_frames"`
+}
+
+// GetGatewayRedundancyDevices returns per device of the given organization
+// the frames received by the given gateway within the given time range, and
+// how many of these were received by this gateway only. This is based on
+// the RX metadata of the device frame-log.
+func GetGatewayRedundancyDevices(ctx context.Context, db sqlx.Queryer, gatewayID lorawan.EUI64, organizationID int64, start, end time.Time) ([]GatewayRedundancyDevice, error) {
+	defer observeQueryDuration("gateway_redundancy_devices_get", time.Now())
+
+	var out []GatewayRedundancyDevice
+	err := sqlx.Select(db, &out, `
+		select
+			fl.dev_eui,
+			count(*) as frames,
+			count(*) filter (where rx.gateways = 1) as sole_receiver_frames
+		from
+			device_frame_log fl
+		inner join device d
+			on d.dev_eui = fl.dev_eui
+		inner join application a
+			on a.id = d.application_id
+		cross join lateral (
+			select
+				count(distinct e->>'gatewayID') as gateways
+			from
+				jsonb_array_elements(case when jsonb_typeof(fl.rx_info) = 'array' then fl.rx_info else '[]'::jsonb end) e
+		) rx
+		where
+			a.organization_id = $2
+			and fl.received_at >= $3
+			and fl.received_at < $4
+			and fl.rx_info @> jsonb_build_array(jsonb_build_object('gatewayID', $1::text))
+		group by
+			fl.dev_eui
+		order by
+			fl.dev_eui`,
+		gatewayID.String(),
+		organizationID,
+		start,
+		end,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
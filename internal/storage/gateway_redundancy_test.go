@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestGetGatewayRedundancyDevices() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(ctx, ts.Tx(), &org))
+
+	n := NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(CreateNetworkServer(ctx, ts.Tx(), &n))
+
+	sp := ServiceProfile{
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+		Name:            "test-sp",
+	}
+	assert.NoError(CreateServiceProfile(ctx, ts.Tx(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(ctx, ts.Tx(), &app))
+
+	dp := DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateDeviceProfile(ctx, ts.Tx(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	d1 := Device{
+		DevEUI:          lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 1},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "device-1",
+	}
+	assert.NoError(CreateDevice(ctx, ts.Tx(), &d1))
+
+	d2 := Device{
+		DevEUI:          lorawan.EUI64{2, 2, 2, 2, 2, 2, 2, 2},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "device-2",
+	}
+	assert.NoError(CreateDevice(ctx, ts.Tx(), &d2))
+
+	gw1 := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	gw2 := lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1}
+	now := time.Now()
+
+	for i, f := range []struct {
+		Device Device
+		RXInfo []DeviceFrameLogRXInfo
+	}{
+		{d1, []DeviceFrameLogRXInfo{{GatewayID: gw1}, {GatewayID: gw2}}},
+		{d1, []DeviceFrameLogRXInfo{{GatewayID: gw1}}},
+		{d1, []DeviceFrameLogRXInfo{{GatewayID: gw1}, {GatewayID: gw1}}},
+		{d1, []DeviceFrameLogRXInfo{{GatewayID: gw2}}},
+		{d2, []DeviceFrameLogRXInfo{{GatewayID: gw2}}},
+		{d2, nil},
+	} {
+		b, err := json.Marshal(f.RXInfo)
+		assert.NoError(err)
+
+		assert.NoError(CreateDeviceFrameLog(ctx, ts.Tx(), DeviceFrameLog{
+			DevEUI:        f.Device.DevEUI,
+			ApplicationID: app.ID,
+			ReceivedAt:    now,
+			FCnt:          uint32(i),
+			RXInfo:        b,
+		}))
+	}
+
+	items, err := GetGatewayRedundancyDevices(ctx, ts.Tx(), gw1, org.ID, now.Add(-time.Minute), now.Add(time.Minute))
+	assert.NoError(err)
+	assert.Equal([]GatewayRedundancyDevice{
+		{DevEUI: d1.DevEUI, Frames: 3, SoleReceiverFrames: 2},
+	}, items)
+
+	items, err = GetGatewayRedundancyDevices(ctx, ts.Tx(), gw2, org.ID, now.Add(-time.Minute), now.Add(time.Minute))
+	assert.NoError(err)
+	assert.Equal([]GatewayRedundancyDevice{
+		{DevEUI: d1.DevEUI, Frames: 2, SoleReceiverFrames: 1},
+		{DevEUI: d2.DevEUI, Frames: 1, SoleReceiverFrames: 1},
+	}, items)
+
+	// other organization
+	items, err = GetGatewayRedundancyDevices(ctx, ts.Tx(), gw1, org.ID+1, now.Add(-time.Minute), now.Add(time.Minute))
+	assert.NoError(err)
+	assert.Len(items, 0)
+}
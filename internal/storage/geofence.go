@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/geojson"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+)
+
+// Geofence defines a named area of an application. When Zone is set, the
+// geofence defines the boundary of the zone (see GetZones), which is then
+// used instead of the area spanned by the devices of the zone.
+type Geofence struct {
+	ID            uuid.UUID    `db:"id"`
+	ApplicationID int64        `db:"application_id"`
+	CreatedAt     time.Time    `db:"created_at"`
+	UpdatedAt     time.Time    `db:"updated_at"`
+	Name          string       `db:"name"`
+	Zone          string       `db:"zone"`
+	Boundary      geojson.Ring `db:"boundary"`
+}
+
+// Validate validates the geofence data.
+func (g Geofence) Validate() error {
+	if strings.TrimSpace(g.Name) == "" || len(g.Name) > 100 {
+		return ErrGeofenceInvalidName
+	}
+
+	if len(g.Zone) > 100 {
+		return ErrGeofenceInvalidZone
+	}
+
+	if err := g.Boundary.Validate(); err != nil {
+		return errors.Wrap(ErrGeofenceInvalidBoundary, err.Error())
+	}
+
+	return nil
+}
+
+// GeofenceFilters provides filters for filtering geofences.
+type GeofenceFilters struct {
+	ApplicationID int64 `db:"application_id"`
+
+	// Limit and Offset are added for convenience so that this struct can
+	// be given as the arguments.
+	Limit  int `db:"limit"`
+	Offset int `db:"offset"`
+}
+
+// SQL returns the SQL filters.
+func (f GeofenceFilters) SQL() string {
+	return "where application_id = :application_id"
+}
+
+// CreateGeofence creates the given geofence.
+func CreateGeofence(ctx context.Context, db sqlx.Execer, g *Geofence) error {
+	if err := g.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	var err error
+	g.ID, err = uuid.NewV4()
+	if err != nil {
+		return errors.Wrap(err, "new uuid v4 error")
+	}
+
+	now := time.Now()
+	g.CreatedAt = now
+	g.UpdatedAt = now
+
+	_, err = db.Exec(`
+		insert into geofence (
+			id,
+			application_id,
+			created_at,
+			updated_at,
+			name,
+			zone,
+			boundary
+		) values ($1, $2, $3, $4, $5, $6, $7)`,
+		g.ID,
+		g.ApplicationID,
+		g.CreatedAt,
+		g.UpdatedAt,
+		g.Name,
+		g.Zone,
+		g.Boundary,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"id":             g.ID,
+		"application_id": g.ApplicationID,
+		"ctx_id":         ctx.Value(logging.ContextIDKey),
+	}).Info("geofence created")
+
+	return nil
+}
+
+// GetGeofence returns the geofence for the given id.
+func GetGeofence(ctx context.Context, db sqlx.Queryer, id uuid.UUID) (Geofence, error) {
+	var g Geofence
+	if err := sqlx.Get(db, &g, "select * from geofence where id = $1", id); err != nil {
+		return g, handlePSQLError(Select, err, "select error")
+	}
+
+	return g, nil
+}
+
+// GetGeofenceCount returns the number of geofences matching the given
+// filters.
+func GetGeofenceCount(ctx context.Context, db sqlx.Queryer, filters GeofenceFilters) (int, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			count(*)
+		from
+			geofence
+		`+filters.SQL(), filters)
+	if err != nil {
+		return 0, errors.Wrap(err, "named query error")
+	}
+
+	var count int
+	if err := sqlx.Get(db, &count, query, args...); err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	return count, nil
+}
+
+// GetGeofences returns the geofences matching the given filters, sorted by
+// name.
+func GetGeofences(ctx context.Context, db sqlx.Queryer, filters GeofenceFilters) ([]Geofence, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			*
+		from
+			geofence
+		`+filters.SQL()+`
+		order by
+			name,
+			id
+		limit :limit
+		offset :offset`, filters)
+	if err != nil {
+		return nil, errors.Wrap(err, "named query error")
+	}
+
+	var out []Geofence
+	if err := sqlx.Select(db, &out, query, args...); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// UpdateGeofence updates the given geofence.
+func UpdateGeofence(ctx context.Context, db sqlx.Execer, g *Geofence) error {
+	if err := g.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	g.UpdatedAt = time.Now()
+
+	res, err := db.Exec(`
+		update geofence
+		set
+			updated_at = $2,
+			name = $3,
+			zone = $4,
+			boundary = $5
+		where
+			id = $1`,
+		g.ID,
+		g.UpdatedAt,
+		g.Name,
+		g.Zone,
+		g.Boundary,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"id":     g.ID,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("geofence updated")
+
+	return nil
+}
+
+// DeleteGeofence deletes the geofence.
+func DeleteGeofence(ctx context.Context, db sqlx.Execer, id uuid.UUID) error {
+	res, err := db.Exec("delete from geofence where id = $1", id)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"id":     id,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("geofence deleted")
+
+	return nil
+}
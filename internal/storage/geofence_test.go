@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/geojson"
+)
+
+func (ts *StorageTestSuite) TestGeofence() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(ctx, ts.Tx(), &org))
+
+	n := NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(CreateNetworkServer(ctx, ts.Tx(), &n))
+
+	sp := ServiceProfile{
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+		Name:            "test-sp",
+	}
+	assert.NoError(CreateServiceProfile(ctx, ts.Tx(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(ctx, ts.Tx(), &app))
+
+	boundary := geojson.Ring{{4.25, 51.5}, {4.5, 51.5}, {4.5, 51.75}, {4.25, 51.75}}
+
+	ts.T().Run("Create invalid", func(t *testing.T) {
+		assert := require.New(t)
+
+		tests := []struct {
+			Name          string
+			Geofence      Geofence
+			ExpectedError error
+		}{
+			{
+				Name:          "no name",
+				Geofence:      Geofence{Boundary: boundary},
+				ExpectedError: ErrGeofenceInvalidName,
+			},
+			{
+				Name:          "no boundary",
+				Geofence:      Geofence{Name: "farm"},
+				ExpectedError: ErrGeofenceInvalidBoundary,
+			},
+			{
+				Name:          "out of range",
+				Geofence:      Geofence{Name: "farm", Boundary: geojson.Ring{{0, 0}, {1, 0}, {0, 95}}},
+				ExpectedError: ErrGeofenceInvalidBoundary,
+			},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.Name, func(t *testing.T) {
+				g := tst.Geofence
+				g.ApplicationID = app.ID
+				assert.Equal(tst.ExpectedError, errors.Cause(CreateGeofence(ctx, ts.Tx(), &g)))
+			})
+		}
+	})
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		g := Geofence{
+			ApplicationID: app.ID,
+			Name:          "greenhouse",
+			Zone:          "greenhouse",
+			Boundary:      boundary,
+		}
+		assert.NoError(CreateGeofence(ctx, ts.Tx(), &g))
+
+		g.CreatedAt = g.CreatedAt.Round(time.Second).UTC()
+		g.UpdatedAt = g.UpdatedAt.Round(time.Second).UTC()
+
+		t.Run("Get", func(t *testing.T) {
+			assert := require.New(t)
+
+			gGet, err := GetGeofence(ctx, ts.Tx(), g.ID)
+			assert.NoError(err)
+			gGet.CreatedAt = gGet.CreatedAt.Round(time.Second).UTC()
+			gGet.UpdatedAt = gGet.UpdatedAt.Round(time.Second).UTC()
+			assert.Equal(g, gGet)
+		})
+
+		t.Run("List", func(t *testing.T) {
+			assert := require.New(t)
+
+			filters := GeofenceFilters{
+				ApplicationID: app.ID,
+				Limit:         10,
+			}
+
+			count, err := GetGeofenceCount(ctx, ts.Tx(), filters)
+			assert.NoError(err)
+			assert.Equal(1, count)
+
+			items, err := GetGeofences(ctx, ts.Tx(), filters)
+			assert.NoError(err)
+			assert.Len(items, 1)
+			assert.Equal(g.ID, items[0].ID)
+		})
+
+		t.Run("Update", func(t *testing.T) {
+			assert := require.New(t)
+
+			g.Name = "north field"
+			g.Zone = ""
+			g.Boundary = geojson.Ring{{4.25, 51.5}, {4.5, 51.5}, {4.5, 51.75}}
+			assert.NoError(UpdateGeofence(ctx, ts.Tx(), &g))
+
+			gGet, err := GetGeofence(ctx, ts.Tx(), g.ID)
+			assert.NoError(err)
+			assert.Equal("north field", gGet.Name)
+			assert.Equal("", gGet.Zone)
+			assert.Equal(g.Boundary, gGet.Boundary)
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(DeleteGeofence(ctx, ts.Tx(), g.ID))
+			assert.Equal(ErrDoesNotExist, DeleteGeofence(ctx, ts.Tx(), g.ID))
+
+			_, err := GetGeofence(ctx, ts.Tx(), g.ID)
+			assert.Equal(ErrDoesNotExist, errors.Cause(err))
+		})
+	})
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+// InAppNotification defines a notification shown within the application
+// (e.g. a notification bell), stored by the IN_APP automation action.
+// AlarmID and DevEUI are set when the notification relates to an alarm or
+// device. ReadAt holds the time the notification was marked as read.
+type InAppNotification struct {
+	ID            int64          `db:"id"`
+	ApplicationID int64          `db:"application_id"`
+	AlarmID       *uuid.UUID     `db:"alarm_id"`
+	DevEUI        *lorawan.EUI64 `db:"dev_eui"`
+	CreatedAt     time.Time      `db:"created_at"`
+	Severity      spec.Severity  `db:"severity"`
+	Subject       string         `db:"subject"`
+	Message       string         `db:"message"`
+	ReadAt        *time.Time     `db:"read_at"`
+}
+
+// InAppNotificationFilters provides filters for filtering in-app
+// notifications. Note that empty values are not used as filters.
+type InAppNotificationFilters struct {
+	ApplicationID int64         `db:"application_id"`
+	UnreadOnly    bool          `db:"unread_only"`
+	Severity      spec.Severity `db:"severity"`
+
+	// Limit and Offset are added for convenience so that this struct can
+	// be given as the arguments.
+	Limit  int `db:"limit"`
+	Offset int `db:"offset"`
+}
+
+// SQL returns the SQL filters.
+func (f InAppNotificationFilters) SQL() string {
+	filters := []string{"application_id = :application_id"}
+
+	if f.UnreadOnly {
+		filters = append(filters, "read_at is null")
+	}
+
+	if f.Severity != "" {
+		filters = append(filters, "severity = :severity")
+	}
+
+	return "where " + strings.Join(filters, " and ")
+}
+
+// CreateInAppNotification creates the given in-app notification.
+func CreateInAppNotification(ctx context.Context, db sqlx.Queryer, n *InAppNotification) error {
+	if n.CreatedAt.IsZero() {
+		n.CreatedAt = time.Now()
+	}
+
+	var devEUI []byte
+	if n.DevEUI != nil {
+		devEUI = n.DevEUI[:]
+	}
+
+	err := sqlx.Get(db, &n.ID, `
+		insert into in_app_notification (
+			application_id,
+			alarm_id,
+			dev_eui,
+			created_at,
+			severity,
+			subject,
+			message
+		) values ($1, $2, $3, $4, $5, $6, $7)
+		returning id`,
+		n.ApplicationID,
+		n.AlarmID,
+		devEUI,
+		n.CreatedAt,
+		n.Severity,
+		n.Subject,
+		n.Message,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"id":             n.ID,
+		"application_id": n.ApplicationID,
+		"ctx_id":         ctx.Value(logging.ContextIDKey),
+	}).Info("in-app notification created")
+
+	return nil
+}
+
+// GetInAppNotification returns the in-app notification for the given id.
+func GetInAppNotification(ctx context.Context, db sqlx.Queryer, id int64) (InAppNotification, error) {
+	var n InAppNotification
+	if err := sqlx.Get(db, &n, "select * from in_app_notification where id = $1", id); err != nil {
+		return n, handlePSQLError(Select, err, "select error")
+	}
+
+	return n, nil
+}
+
+// GetInAppNotificationCount returns the number of in-app notifications
+// matching the given filters.
+func GetInAppNotificationCount(ctx context.Context, db sqlx.Queryer, filters InAppNotificationFilters) (int, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			count(*)
+		from
+			in_app_notification
+		`+filters.SQL(), filters)
+	if err != nil {
+		return 0, errors.Wrap(err, "named query error")
+	}
+
+	var count int
+	if err := sqlx.Get(db, &count, query, args...); err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	return count, nil
+}
+
+// GetInAppNotifications returns the in-app notifications matching the
+// given filters, most recent first.
+func GetInAppNotifications(ctx context.Context, db sqlx.Queryer, filters InAppNotificationFilters) ([]InAppNotification, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			*
+		from
+			in_app_notification
+		`+filters.SQL()+`
+		order by
+			created_at desc,
+			id desc
+		limit :limit
+		offset :offset`, filters)
+	if err != nil {
+		return nil, errors.Wrap(err, "named query error")
+	}
+
+	var out []InAppNotification
+	if err := sqlx.Select(db, &out, query, args...); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// MarkInAppNotificationsRead marks the given in-app notifications of the
+// application as read, or all the notifications of the application when
+// ids is empty. Notifications which were already read keep their read time.
+// It returns the number of notifications marked as read.
+func MarkInAppNotificationsRead(ctx context.Context, db sqlx.Execer, applicationID int64, ids []int64, readAt time.Time) (int64, error) {
+	// a nil array is stored as null
+	if ids == nil {
+		ids = []int64{}
+	}
+
+	res, err := db.Exec(`
+		update in_app_notification
+		set
+			read_at = $3
+		where
+			application_id = $1
+			and (cardinality($2::bigint[]) = 0 or id = any($2))
+			and read_at is null`,
+		applicationID,
+		pq.Int64Array(ids),
+		readAt,
+	)
+	if err != nil {
+		return 0, handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "get rows affected error")
+	}
+
+	return ra, nil
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestInAppNotification() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(ctx, ts.Tx(), &org))
+
+	n := NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(CreateNetworkServer(ctx, ts.Tx(), &n))
+
+	sp := ServiceProfile{
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+		Name:            "test-sp",
+	}
+	assert.NoError(CreateServiceProfile(ctx, ts.Tx(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(ctx, ts.Tx(), &app))
+
+	now := time.Now().Truncate(time.Millisecond)
+	devEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+
+	notifications := []InAppNotification{
+		{
+			ApplicationID: app.ID,
+			DevEUI:        &devEUI,
+			CreatedAt:     now.Add(-time.Minute),
+			Severity:      spec.InfoSeverity,
+			Subject:       "[INFO] door opened",
+		},
+		{
+			ApplicationID: app.ID,
+			CreatedAt:     now,
+			Severity:      spec.CriticalSeverity,
+			Subject:       "[CRITICAL] cold room",
+			Message:       "3 devices in zone cold-room",
+		},
+	}
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		for i := range notifications {
+			assert.NoError(CreateInAppNotification(ctx, ts.Tx(), &notifications[i]))
+		}
+
+		nGet, err := GetInAppNotification(ctx, ts.Tx(), notifications[0].ID)
+		assert.NoError(err)
+		assert.Equal(devEUI, *nGet.DevEUI)
+		assert.Nil(nGet.AlarmID)
+		assert.Nil(nGet.ReadAt)
+		assert.Equal(spec.InfoSeverity, nGet.Severity)
+		assert.Equal("[INFO] door opened", nGet.Subject)
+		assert.True(now.Add(-time.Minute).Equal(nGet.CreatedAt))
+
+		_, err = GetInAppNotification(ctx, ts.Tx(), notifications[1].ID+1)
+		assert.Equal(ErrDoesNotExist, errors.Cause(err))
+	})
+
+	ts.T().Run("List", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			filters  InAppNotificationFilters
+			expected []int64
+		}{
+			{"all", InAppNotificationFilters{}, []int64{notifications[1].ID, notifications[0].ID}},
+			{"severity", InAppNotificationFilters{Severity: spec.InfoSeverity}, []int64{notifications[0].ID}},
+			{"unread", InAppNotificationFilters{UnreadOnly: true}, []int64{notifications[1].ID, notifications[0].ID}},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.name, func(t *testing.T) {
+				assert := require.New(t)
+
+				tst.filters.ApplicationID = app.ID
+				tst.filters.Limit = 10
+
+				count, err := GetInAppNotificationCount(ctx, ts.Tx(), tst.filters)
+				assert.NoError(err)
+				assert.Equal(len(tst.expected), count)
+
+				items, err := GetInAppNotifications(ctx, ts.Tx(), tst.filters)
+				assert.NoError(err)
+
+				var ids []int64
+				for _, item := range items {
+					ids = append(ids, item.ID)
+				}
+				assert.Equal(tst.expected, ids)
+			})
+		}
+	})
+
+	ts.T().Run("Mark read", func(t *testing.T) {
+		assert := require.New(t)
+
+		count, err := MarkInAppNotificationsRead(ctx, ts.Tx(), app.ID, []int64{notifications[0].ID}, now)
+		assert.NoError(err)
+		assert.EqualValues(1, count)
+
+		// already read
+		count, err = MarkInAppNotificationsRead(ctx, ts.Tx(), app.ID, []int64{notifications[0].ID}, now.Add(time.Minute))
+		assert.NoError(err)
+		assert.EqualValues(0, count)
+
+		nGet, err := GetInAppNotification(ctx, ts.Tx(), notifications[0].ID)
+		assert.NoError(err)
+		assert.True(now.Equal(*nGet.ReadAt))
+
+		unread, err := GetInAppNotificationCount(ctx, ts.Tx(), InAppNotificationFilters{ApplicationID: app.ID, UnreadOnly: true})
+		assert.NoError(err)
+		assert.Equal(1, unread)
+
+		// all notifications of the application
+		count, err = MarkInAppNotificationsRead(ctx, ts.Tx(), app.ID, nil, now)
+		assert.NoError(err)
+		assert.EqualValues(1, count)
+
+		unread, err = GetInAppNotificationCount(ctx, ts.Tx(), InAppNotificationFilters{ApplicationID: app.ID, UnreadOnly: true})
+		assert.NoError(err)
+		assert.Equal(0, unread)
+	})
+}
@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+)
+
+// searchTables contains the tables which are used by the (global) search
+// and the list filters.
+var searchTables = []string{
+	"application",
+	"device",
+	"device_profile",
+	"gateway",
+	"multicast_group",
+	"organization",
+	`"user"`,
+}
+
+// searchIndexes contains the trigram and tag GIN indexes of the search
+// tables.
+var searchIndexes = []string{
+	"idx_application_name_trgm",
+	"idx_device_dev_eui_trgm",
+	"idx_device_name_trgm",
+	"idx_device_tags",
+	"idx_device_profile_tags",
+	"idx_gateway_mac_trgm",
+	"idx_gateway_name_trgm",
+	"idx_gateway_tags",
+	"idx_multicast_group_name_trgm",
+	"idx_organization_name_trgm",
+	"idx_organization_display_name_trgm",
+	"idx_user_username_trgm",
+}
+
+var (
+	indexAnalyzeInterval time.Duration
+	indexReindexInterval time.Duration
+)
+
+// IndexMaintenanceLoop periodically analyzes the search tables and rebuilds
+// the search indexes, using the configured intervals. Each run is executed
+// using the given job function (e.g. leader.Do).
+func IndexMaintenanceLoop(do JobFunc) {
+	var analyzeChan, reindexChan <-chan time.Time
+
+	if indexAnalyzeInterval > 0 {
+		ticker := time.NewTicker(indexAnalyzeInterval)
+		defer ticker.Stop()
+		analyzeChan = ticker.C
+	}
+
+	if indexReindexInterval > 0 {
+		ticker := time.NewTicker(indexReindexInterval)
+		defer ticker.Stop()
+		reindexChan = ticker.C
+	}
+
+	if analyzeChan == nil && reindexChan == nil {
+		return
+	}
+
+	for {
+		var reindex bool
+
+		select {
+		case <-analyzeChan:
+		case <-reindexChan:
+			reindex = true
+		}
+
+		ctxID, err := uuid.NewV4()
+		if err != nil {
+			log.WithError(err).Error("new uuid error")
+		}
+
+		ctx := context.Background()
+		ctx = context.WithValue(ctx, logging.ContextIDKey, ctxID)
+
+		err = do(ctx, "index_maintenance", func(ctx context.Context) error {
+			if reindex {
+				if err := ReindexSearchIndexes(ctx, DB()); err != nil {
+					log.WithError(err).Error("storage: reindex search indexes error")
+				}
+			}
+
+			// statistics are updated after a reindex too, as these are not
+			// updated by rebuilding the index
+			return AnalyzeSearchTables(ctx, DB())
+		})
+		if err != nil {
+			log.WithError(err).Error("storage: analyze search tables error")
+		}
+	}
+}
+
+// AnalyzeSearchTables updates the planner statistics of the search tables.
+func AnalyzeSearchTables(ctx context.Context, db sqlx.Execer) error {
+	start := time.Now()
+
+	for _, table := range searchTables {
+		if _, err := db.Exec("analyze " + table); err != nil {
+			return handlePSQLError(Select, err, "analyze "+table+" error")
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"tables":   len(searchTables),
+		"duration": time.Since(start),
+		"ctx_id":   ctx.Value(logging.ContextIDKey),
+	}).Info("storage: search tables analyzed")
+
+	return nil
+}
+
+// ReindexSearchIndexes rebuilds the search indexes concurrently, so that
+// writes to the search tables are not blocked. As REINDEX CONCURRENTLY
+// can't be executed within a transaction block, db must not be a
+// transaction. On PostgreSQL versions prior to 12, this is a no-op.
+func ReindexSearchIndexes(ctx context.Context, db sqlx.Ext) error {
+	version, err := getServerVersionNum(db)
+	if err != nil {
+		return errors.Wrap(err, "get server version error")
+	}
+
+	if version < 120000 {
+		log.WithFields(log.Fields{
+			"server_version_num": version,
+			"ctx_id":             ctx.Value(logging.ContextIDKey),
+		}).Warning("storage: reindex concurrently requires PostgreSQL 12+, skipping reindex")
+		return nil
+	}
+
+	start := time.Now()
+
+	for _, index := range searchIndexes {
+		if _, err := db.Exec("reindex index concurrently " + index); err != nil {
+			return handlePSQLError(Update, err, "reindex "+index+" error")
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"indexes":  len(searchIndexes),
+		"duration": time.Since(start),
+		"ctx_id":   ctx.Value(logging.ContextIDKey),
+	}).Info("storage: search indexes rebuilt")
+
+	return nil
+}
+
+func getServerVersionNum(db sqlx.Queryer) (int, error) {
+	var s string
+	if err := sqlx.Get(db, &s, "show server_version_num"); err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	return strconv.Atoi(s)
+}
@@ -0,0 +1,22 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+func (ts *StorageTestSuite) TestSearchIndexes() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	// all search indexes must exist, else the reindex fails
+	var count int
+	assert.NoError(sqlx.Get(DB(), &count, "select count(*) from pg_indexes where indexname = any($1)", pq.StringArray(searchIndexes)))
+	assert.Equal(len(searchIndexes), count)
+
+	assert.NoError(AnalyzeSearchTables(ctx, DB()))
+	assert.NoError(ReindexSearchIndexes(ctx, DB()))
+}
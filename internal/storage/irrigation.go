@@ -0,0 +1,633 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/irrigation/schedule"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+const (
+	// irrigationMinDuration defines the min. irrigation duration.
+	irrigationMinDuration = time.Minute
+
+	// irrigationMaxDuration defines the max. irrigation duration.
+	irrigationMaxDuration = 24 * time.Hour
+)
+
+// IrrigationAction defines the action of an irrigation execution.
+type IrrigationAction string
+
+// Available irrigation actions.
+const (
+	IrrigationStart IrrigationAction = "START"
+	IrrigationStop  IrrigationAction = "STOP"
+)
+
+// IrrigationSource defines what caused an irrigation execution.
+type IrrigationSource string
+
+// Available irrigation sources.
+const (
+	IrrigationSourceSchedule IrrigationSource = "SCHEDULE"
+	IrrigationSourceManual   IrrigationSource = "MANUAL"
+)
+
+// IrrigationSchedule defines an irrigation schedule of an application. At
+// each activation of the Schedule (cron or sun-relative), the StartDownlink
+// is enqueued to the devices of the schedule (e.g. valves or relays). When
+// StopDownlink is set, it is enqueued Duration after the start, else the
+// devices are expected to stop by themselves (the duration can be passed
+// using the {{ duration }} placeholder).
+//
+// NextRunAt holds the next activation, it is nil when the schedule does not
+// activate anymore. StopAt holds the time of the pending stop downlink, it is
+// set while irrigating. Activations before PausedUntil are skipped.
+type IrrigationSchedule struct {
+	ID            uuid.UUID          `db:"id"`
+	ApplicationID int64              `db:"application_id"`
+	CreatedAt     time.Time          `db:"created_at"`
+	UpdatedAt     time.Time          `db:"updated_at"`
+	Name          string             `db:"name"`
+	Enabled       bool               `db:"enabled"`
+	Schedule      schedule.Schedule  `db:"schedule"`
+	StartDownlink schedule.Downlink  `db:"start_downlink"`
+	StopDownlink  *schedule.Downlink `db:"stop_downlink"`
+	Duration      time.Duration      `db:"duration"`
+	NextRunAt     *time.Time         `db:"next_run_at"`
+	StopAt        *time.Time         `db:"stop_at"`
+	PausedUntil   *time.Time         `db:"paused_until"`
+}
+
+// Validate validates the irrigation schedule data.
+func (s IrrigationSchedule) Validate() error {
+	if strings.TrimSpace(s.Name) == "" || len(s.Name) > 100 {
+		return ErrIrrigationInvalidName
+	}
+
+	if err := s.Schedule.Validate(); err != nil {
+		return errors.Wrap(ErrIrrigationInvalidSchedule, err.Error())
+	}
+
+	if err := s.StartDownlink.Validate(); err != nil {
+		return errors.Wrap(ErrIrrigationInvalidDownlink, "start: "+err.Error())
+	}
+
+	if s.StopDownlink != nil {
+		if err := s.StopDownlink.Validate(); err != nil {
+			return errors.Wrap(ErrIrrigationInvalidDownlink, "stop: "+err.Error())
+		}
+	}
+
+	if s.Duration < irrigationMinDuration || s.Duration > irrigationMaxDuration {
+		return ErrIrrigationInvalidDuration
+	}
+
+	return nil
+}
+
+// NextRun returns the first activation of the schedule after the given time,
+// evaluated in the configured timezone. It returns nil when the schedule does
+// not activate anymore.
+func (s IrrigationSchedule) NextRun(after time.Time) *time.Time {
+	t := s.Schedule.Next(after, timeLocation)
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// Paused returns true when the schedule is paused at the given time.
+func (s IrrigationSchedule) Paused(now time.Time) bool {
+	return s.PausedUntil != nil && s.PausedUntil.After(now)
+}
+
+// IrrigationExecution defines a start or stop downlink enqueued for a device
+// of an irrigation schedule. Error holds the enqueue error when Success is
+// false.
+type IrrigationExecution struct {
+	ID                   int64            `db:"id"`
+	IrrigationScheduleID uuid.UUID        `db:"irrigation_schedule_id"`
+	CreatedAt            time.Time        `db:"created_at"`
+	DevEUI               lorawan.EUI64    `db:"dev_eui"`
+	Action               IrrigationAction `db:"action"`
+	Source               IrrigationSource `db:"source"`
+	Success              bool             `db:"success"`
+	Error                string           `db:"error"`
+}
+
+// IrrigationScheduleFilters provides filters for filtering irrigation
+// schedules.
+type IrrigationScheduleFilters struct {
+	ApplicationID int64         `db:"application_id"`
+	DevEUI        lorawan.EUI64 `db:"dev_eui"`
+
+	// Limit and Offset are added for convenience so that this struct can
+	// be given as the arguments.
+	Limit  int `db:"limit"`
+	Offset int `db:"offset"`
+}
+
+// SQL returns the SQL filters.
+func (f IrrigationScheduleFilters) SQL() string {
+	var nullDevEUI lorawan.EUI64
+	var filters []string
+
+	if f.ApplicationID != 0 {
+		filters = append(filters, "s.application_id = :application_id")
+	}
+
+	if f.DevEUI != nullDevEUI {
+		filters = append(filters, "exists (select 1 from irrigation_schedule_device sd where sd.irrigation_schedule_id = s.id and sd.dev_eui = :dev_eui)")
+	}
+
+	if len(filters) == 0 {
+		return ""
+	}
+
+	return "where " + strings.Join(filters, " and ")
+}
+
+// CreateIrrigationSchedule creates the given irrigation schedule. The first
+// activation is scheduled based on the schedule.
+func CreateIrrigationSchedule(ctx context.Context, db sqlx.Execer, s *IrrigationSchedule) error {
+	if err := s.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	var err error
+	s.ID, err = uuid.NewV4()
+	if err != nil {
+		return errors.Wrap(err, "new uuid v4 error")
+	}
+
+	now := time.Now()
+	s.CreatedAt = now
+	s.UpdatedAt = now
+	s.NextRunAt = s.NextRun(now)
+
+	_, err = db.Exec(`
+		insert into irrigation_schedule (
+			id,
+			application_id,
+			created_at,
+			updated_at,
+			name,
+			enabled,
+			schedule,
+			start_downlink,
+			stop_downlink,
+			duration,
+			next_run_at,
+			paused_until
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		s.ID,
+		s.ApplicationID,
+		s.CreatedAt,
+		s.UpdatedAt,
+		s.Name,
+		s.Enabled,
+		s.Schedule,
+		s.StartDownlink,
+		s.StopDownlink,
+		s.Duration,
+		s.NextRunAt,
+		s.PausedUntil,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"id":             s.ID,
+		"application_id": s.ApplicationID,
+		"ctx_id":         ctx.Value(logging.ContextIDKey),
+	}).Info("irrigation schedule created")
+
+	return nil
+}
+
+// GetIrrigationSchedule returns the irrigation schedule for the given id.
+// When forUpdate is set, the schedule is locked.
+func GetIrrigationSchedule(ctx context.Context, db sqlx.Queryer, id uuid.UUID, forUpdate bool) (IrrigationSchedule, error) {
+	var fu string
+	if forUpdate {
+		fu = " for update"
+	}
+
+	var s IrrigationSchedule
+	if err := sqlx.Get(db, &s, "select * from irrigation_schedule where id = $1"+fu, id); err != nil {
+		return s, handlePSQLError(Select, err, "select error")
+	}
+
+	return s, nil
+}
+
+// GetIrrigationScheduleCount returns the number of irrigation schedules
+// matching the given filters.
+func GetIrrigationScheduleCount(ctx context.Context, db sqlx.Queryer, filters IrrigationScheduleFilters) (int, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			count(*)
+		from
+			irrigation_schedule s
+		`+filters.SQL(), filters)
+	if err != nil {
+		return 0, errors.Wrap(err, "named query error")
+	}
+
+	var count int
+	if err := sqlx.Get(db, &count, query, args...); err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	return count, nil
+}
+
+// GetIrrigationSchedules returns the irrigation schedules matching the given
+// filters, sorted by name.
+func GetIrrigationSchedules(ctx context.Context, db sqlx.Queryer, filters IrrigationScheduleFilters) ([]IrrigationSchedule, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			s.*
+		from
+			irrigation_schedule s
+		`+filters.SQL()+`
+		order by
+			s.name,
+			s.id
+		limit :limit
+		offset :offset`, filters)
+	if err != nil {
+		return nil, errors.Wrap(err, "named query error")
+	}
+
+	var out []IrrigationSchedule
+	if err := sqlx.Select(db, &out, query, args...); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetDueIrrigationSchedules returns at most limit enabled irrigation
+// schedules of which the next activation is due. The schedules are locked,
+// this function must be called within a transaction. Schedules locked by an
+// other transaction are skipped.
+func GetDueIrrigationSchedules(ctx context.Context, db sqlx.Queryer, now time.Time, limit int) ([]IrrigationSchedule, error) {
+	var out []IrrigationSchedule
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			irrigation_schedule
+		where
+			enabled = true
+			and next_run_at <= $1
+		order by
+			next_run_at
+		limit $2
+		for update
+		skip locked`,
+		now,
+		limit,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetDueIrrigationStops returns at most limit irrigation schedules of which
+// the stop downlink is due. The schedules are locked, this function must be
+// called within a transaction. Schedules locked by an other transaction are
+// skipped. Note that the stop of a disabled schedule is still executed.
+func GetDueIrrigationStops(ctx context.Context, db sqlx.Queryer, now time.Time, limit int) ([]IrrigationSchedule, error) {
+	var out []IrrigationSchedule
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			irrigation_schedule
+		where
+			stop_at <= $1
+		order by
+			stop_at
+		limit $2
+		for update
+		skip locked`,
+		now,
+		limit,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// UpdateIrrigationSchedule updates the given irrigation schedule. The next
+// activation is re-scheduled as the schedule might have changed. A pending
+// stop is not changed.
+func UpdateIrrigationSchedule(ctx context.Context, db sqlx.Execer, s *IrrigationSchedule) error {
+	if err := s.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	now := time.Now()
+	s.UpdatedAt = now
+	s.NextRunAt = s.NextRun(now)
+
+	res, err := db.Exec(`
+		update irrigation_schedule
+		set
+			updated_at = $2,
+			name = $3,
+			enabled = $4,
+			schedule = $5,
+			start_downlink = $6,
+			stop_downlink = $7,
+			duration = $8,
+			next_run_at = $9,
+			paused_until = $10
+		where
+			id = $1`,
+		s.ID,
+		s.UpdatedAt,
+		s.Name,
+		s.Enabled,
+		s.Schedule,
+		s.StartDownlink,
+		s.StopDownlink,
+		s.Duration,
+		s.NextRunAt,
+		s.PausedUntil,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"id":     s.ID,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("irrigation schedule updated")
+
+	return nil
+}
+
+// SetIrrigationScheduleNextRunAt sets the next activation of the given
+// irrigation schedule.
+func SetIrrigationScheduleNextRunAt(ctx context.Context, db sqlx.Execer, id uuid.UUID, nextRunAt *time.Time) error {
+	return setIrrigationScheduleTime(db, id, "next_run_at", nextRunAt)
+}
+
+// SetIrrigationScheduleStopAt sets (or clears when nil) the pending stop of
+// the given irrigation schedule.
+func SetIrrigationScheduleStopAt(ctx context.Context, db sqlx.Execer, id uuid.UUID, stopAt *time.Time) error {
+	return setIrrigationScheduleTime(db, id, "stop_at", stopAt)
+}
+
+// SetIrrigationSchedulePausedUntil pauses the given irrigation schedule
+// until the given time, or resumes it when nil.
+func SetIrrigationSchedulePausedUntil(ctx context.Context, db sqlx.Execer, id uuid.UUID, pausedUntil *time.Time) error {
+	if err := setIrrigationScheduleTime(db, id, "paused_until", pausedUntil); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"id":           id,
+		"paused_until": pausedUntil,
+		"ctx_id":       ctx.Value(logging.ContextIDKey),
+	}).Info("irrigation schedule pause updated")
+
+	return nil
+}
+
+func setIrrigationScheduleTime(db sqlx.Execer, id uuid.UUID, column string, t *time.Time) error {
+	res, err := db.Exec(`
+		update irrigation_schedule
+		set
+			`+column+` = $2
+		where
+			id = $1`,
+		id,
+		t,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	return nil
+}
+
+// DeleteIrrigationSchedule deletes the irrigation schedule. Note that a
+// pending stop downlink is not enqueued.
+func DeleteIrrigationSchedule(ctx context.Context, db sqlx.Execer, id uuid.UUID) error {
+	res, err := db.Exec("delete from irrigation_schedule where id = $1", id)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"id":     id,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("irrigation schedule deleted")
+
+	return nil
+}
+
+// SetIrrigationScheduleDevices replaces the devices of the given irrigation
+// schedule. All devices must belong to the application of the schedule.
+// This must be called within a transaction.
+func SetIrrigationScheduleDevices(ctx context.Context, db sqlx.Execer, s IrrigationSchedule, devEUIs []lorawan.EUI64) error {
+	_, err := db.Exec("delete from irrigation_schedule_device where irrigation_schedule_id = $1", s.ID)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+
+	if len(devEUIs) == 0 {
+		return nil
+	}
+
+	unique := make(map[lorawan.EUI64]struct{})
+	var euis pq.ByteaArray
+	for _, devEUI := range devEUIs {
+		if _, ok := unique[devEUI]; ok {
+			continue
+		}
+		unique[devEUI] = struct{}{}
+		euis = append(euis, devEUI[:])
+	}
+
+	res, err := db.Exec(`
+		insert into irrigation_schedule_device (
+			irrigation_schedule_id,
+			dev_eui
+		)
+		select
+			$1,
+			dev_eui
+		from
+			device
+		where
+			application_id = $2
+			and dev_eui = any($3)`,
+		s.ID,
+		s.ApplicationID,
+		euis,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if int(ra) != len(euis) {
+		return ErrIrrigationInvalidDevices
+	}
+
+	log.WithFields(log.Fields{
+		"id":      s.ID,
+		"devices": len(euis),
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("irrigation schedule devices updated")
+
+	return nil
+}
+
+// GetIrrigationScheduleDevices returns the devices of the given irrigation
+// schedule, sorted by DevEUI.
+func GetIrrigationScheduleDevices(ctx context.Context, db sqlx.Queryer, id uuid.UUID) ([]Device, error) {
+	var out []Device
+	err := sqlx.Select(db, &out, `
+		select
+			d.*
+		from
+			device d
+		inner join irrigation_schedule_device sd
+			on sd.dev_eui = d.dev_eui
+		where
+			sd.irrigation_schedule_id = $1
+		order by
+			d.dev_eui`,
+		id,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// CreateIrrigationExecution creates the given irrigation execution.
+func CreateIrrigationExecution(ctx context.Context, db sqlx.Queryer, e *IrrigationExecution) error {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+
+	err := sqlx.Get(db, &e.ID, `
+		insert into irrigation_execution (
+			irrigation_schedule_id,
+			created_at,
+			dev_eui,
+			action,
+			source,
+			success,
+			error
+		) values ($1, $2, $3, $4, $5, $6, $7)
+		returning id`,
+		e.IrrigationScheduleID,
+		e.CreatedAt,
+		e.DevEUI[:],
+		e.Action,
+		e.Source,
+		e.Success,
+		e.Error,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"id":                     e.ID,
+		"irrigation_schedule_id": e.IrrigationScheduleID,
+		"dev_eui":                e.DevEUI,
+		"action":                 e.Action,
+		"source":                 e.Source,
+		"success":                e.Success,
+		"ctx_id":                 ctx.Value(logging.ContextIDKey),
+	}).Info("irrigation execution created")
+
+	return nil
+}
+
+// GetIrrigationExecutionCount returns the number of executions of the given
+// irrigation schedule.
+func GetIrrigationExecutionCount(ctx context.Context, db sqlx.Queryer, id uuid.UUID) (int, error) {
+	var count int
+	err := sqlx.Get(db, &count, "select count(*) from irrigation_execution where irrigation_schedule_id = $1", id)
+	if err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	return count, nil
+}
+
+// GetIrrigationExecutions returns the executions of the given irrigation
+// schedule, most recent first.
+func GetIrrigationExecutions(ctx context.Context, db sqlx.Queryer, id uuid.UUID, limit, offset int) ([]IrrigationExecution, error) {
+	var out []IrrigationExecution
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			irrigation_execution
+		where
+			irrigation_schedule_id = $1
+		order by
+			created_at desc,
+			id desc
+		limit $2
+		offset $3`,
+		id,
+		limit,
+		offset,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
@@ -0,0 +1,287 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/irrigation/schedule"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestIrrigationSchedule() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	loc := timeLocation
+	timeLocation = time.UTC
+	defer func() { timeLocation = loc }()
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(ctx, ts.Tx(), &org))
+
+	n := NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(CreateNetworkServer(ctx, ts.Tx(), &n))
+
+	sp := ServiceProfile{
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+		Name:            "test-sp",
+	}
+	assert.NoError(CreateServiceProfile(ctx, ts.Tx(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	apps := []Application{
+		{Name: "test-app", OrganizationID: org.ID, ServiceProfileID: spID},
+		{Name: "test-app-2", OrganizationID: org.ID, ServiceProfileID: spID},
+	}
+	for i := range apps {
+		assert.NoError(CreateApplication(ctx, ts.Tx(), &apps[i]))
+	}
+	app := apps[0]
+
+	dp := DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateDeviceProfile(ctx, ts.Tx(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	devices := []Device{
+		{DevEUI: lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 1}, ApplicationID: apps[0].ID, DeviceProfileID: dpID, Name: "valve-1"},
+		{DevEUI: lorawan.EUI64{2, 2, 2, 2, 2, 2, 2, 2}, ApplicationID: apps[0].ID, DeviceProfileID: dpID, Name: "valve-2"},
+		{DevEUI: lorawan.EUI64{3, 3, 3, 3, 3, 3, 3, 3}, ApplicationID: apps[1].ID, DeviceProfileID: dpID, Name: "valve-3"},
+	}
+	for i := range devices {
+		assert.NoError(CreateDevice(ctx, ts.Tx(), &devices[i]))
+	}
+
+	start := schedule.Downlink{FPort: 10, Object: `{"valve": "open", "seconds": "{{ duration }}"}`}
+	stop := schedule.Downlink{FPort: 10, Object: `{"valve": "close"}`}
+
+	ts.T().Run("Create invalid", func(t *testing.T) {
+		tests := []struct {
+			Name          string
+			Schedule      IrrigationSchedule
+			ExpectedError error
+		}{
+			{
+				Name:          "no name",
+				Schedule:      IrrigationSchedule{Schedule: schedule.Schedule{Type: schedule.CronSchedule, Cron: "0 6 * * *"}, StartDownlink: start, Duration: time.Hour},
+				ExpectedError: ErrIrrigationInvalidName,
+			},
+			{
+				Name:          "invalid schedule",
+				Schedule:      IrrigationSchedule{Name: "field 1", Schedule: schedule.Schedule{Type: schedule.CronSchedule, Cron: "0 6 * *"}, StartDownlink: start, Duration: time.Hour},
+				ExpectedError: ErrIrrigationInvalidSchedule,
+			},
+			{
+				Name:          "invalid stop downlink",
+				Schedule:      IrrigationSchedule{Name: "field 1", Schedule: schedule.Schedule{Type: schedule.CronSchedule, Cron: "0 6 * * *"}, StartDownlink: start, StopDownlink: &schedule.Downlink{}, Duration: time.Hour},
+				ExpectedError: ErrIrrigationInvalidDownlink,
+			},
+			{
+				Name:          "duration too long",
+				Schedule:      IrrigationSchedule{Name: "field 1", Schedule: schedule.Schedule{Type: schedule.CronSchedule, Cron: "0 6 * * *"}, StartDownlink: start, Duration: 25 * time.Hour},
+				ExpectedError: ErrIrrigationInvalidDuration,
+			},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.Name, func(t *testing.T) {
+				assert := require.New(t)
+
+				s := tst.Schedule
+				s.ApplicationID = app.ID
+				assert.Equal(tst.ExpectedError, errors.Cause(CreateIrrigationSchedule(ctx, ts.Tx(), &s)))
+			})
+		}
+	})
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		s := IrrigationSchedule{
+			ApplicationID: app.ID,
+			Name:          "field 1",
+			Enabled:       true,
+			Schedule:      schedule.Schedule{Type: schedule.CronSchedule, Cron: "0 6 * * *"},
+			StartDownlink: start,
+			StopDownlink:  &stop,
+			Duration:      30 * time.Minute,
+		}
+		assert.NoError(CreateIrrigationSchedule(ctx, ts.Tx(), &s))
+		assert.NotNil(s.NextRunAt)
+		assert.Equal(6, s.NextRunAt.Hour())
+
+		s.CreatedAt = s.CreatedAt.Round(time.Second).UTC()
+		s.UpdatedAt = s.UpdatedAt.Round(time.Second).UTC()
+		nextRunAt := s.NextRunAt.UTC()
+		s.NextRunAt = &nextRunAt
+
+		t.Run("Get", func(t *testing.T) {
+			assert := require.New(t)
+
+			sGet, err := GetIrrigationSchedule(ctx, ts.Tx(), s.ID, false)
+			assert.NoError(err)
+
+			sGet.CreatedAt = sGet.CreatedAt.Round(time.Second).UTC()
+			sGet.UpdatedAt = sGet.UpdatedAt.Round(time.Second).UTC()
+			nextRunAt := sGet.NextRunAt.UTC()
+			sGet.NextRunAt = &nextRunAt
+			assert.Equal(s, sGet)
+		})
+
+		t.Run("Devices", func(t *testing.T) {
+			assert := require.New(t)
+
+			err := SetIrrigationScheduleDevices(ctx, ts.Tx(), s, []lorawan.EUI64{devices[0].DevEUI, devices[2].DevEUI})
+			assert.Equal(ErrIrrigationInvalidDevices, err)
+
+			assert.NoError(SetIrrigationScheduleDevices(ctx, ts.Tx(), s, []lorawan.EUI64{devices[1].DevEUI, devices[0].DevEUI, devices[0].DevEUI}))
+
+			items, err := GetIrrigationScheduleDevices(ctx, ts.Tx(), s.ID)
+			assert.NoError(err)
+			assert.Len(items, 2)
+			assert.Equal(devices[0].DevEUI, items[0].DevEUI)
+			assert.Equal(devices[1].DevEUI, items[1].DevEUI)
+		})
+
+		t.Run("List", func(t *testing.T) {
+			assert := require.New(t)
+
+			count, err := GetIrrigationScheduleCount(ctx, ts.Tx(), IrrigationScheduleFilters{ApplicationID: app.ID})
+			assert.NoError(err)
+			assert.Equal(1, count)
+
+			items, err := GetIrrigationSchedules(ctx, ts.Tx(), IrrigationScheduleFilters{DevEUI: devices[1].DevEUI, Limit: 10})
+			assert.NoError(err)
+			assert.Len(items, 1)
+			assert.Equal(s.ID, items[0].ID)
+
+			count, err = GetIrrigationScheduleCount(ctx, ts.Tx(), IrrigationScheduleFilters{DevEUI: devices[2].DevEUI})
+			assert.NoError(err)
+			assert.Equal(0, count)
+		})
+
+		t.Run("Due", func(t *testing.T) {
+			assert := require.New(t)
+
+			items, err := GetDueIrrigationSchedules(ctx, ts.Tx(), time.Now(), 10)
+			assert.NoError(err)
+			assert.Len(items, 0)
+
+			items, err = GetDueIrrigationSchedules(ctx, ts.Tx(), *s.NextRunAt, 10)
+			assert.NoError(err)
+			assert.Len(items, 1)
+
+			next := s.NextRun(*s.NextRunAt)
+			assert.NoError(SetIrrigationScheduleNextRunAt(ctx, ts.Tx(), s.ID, next))
+
+			items, err = GetDueIrrigationSchedules(ctx, ts.Tx(), *s.NextRunAt, 10)
+			assert.NoError(err)
+			assert.Len(items, 0)
+
+			stopAt := s.NextRunAt.Add(s.Duration)
+			assert.NoError(SetIrrigationScheduleStopAt(ctx, ts.Tx(), s.ID, &stopAt))
+
+			items, err = GetDueIrrigationStops(ctx, ts.Tx(), *s.NextRunAt, 10)
+			assert.NoError(err)
+			assert.Len(items, 0)
+
+			items, err = GetDueIrrigationStops(ctx, ts.Tx(), stopAt, 10)
+			assert.NoError(err)
+			assert.Len(items, 1)
+
+			assert.NoError(SetIrrigationScheduleStopAt(ctx, ts.Tx(), s.ID, nil))
+			items, err = GetDueIrrigationStops(ctx, ts.Tx(), stopAt, 10)
+			assert.NoError(err)
+			assert.Len(items, 0)
+		})
+
+		t.Run("Pause", func(t *testing.T) {
+			assert := require.New(t)
+
+			until := time.Now().Add(24 * time.Hour)
+			assert.NoError(SetIrrigationSchedulePausedUntil(ctx, ts.Tx(), s.ID, &until))
+
+			sGet, err := GetIrrigationSchedule(ctx, ts.Tx(), s.ID, false)
+			assert.NoError(err)
+			assert.True(sGet.Paused(time.Now()))
+			assert.False(sGet.Paused(until))
+
+			assert.NoError(SetIrrigationSchedulePausedUntil(ctx, ts.Tx(), s.ID, nil))
+			sGet, err = GetIrrigationSchedule(ctx, ts.Tx(), s.ID, false)
+			assert.NoError(err)
+			assert.False(sGet.Paused(time.Now()))
+		})
+
+		t.Run("Executions", func(t *testing.T) {
+			assert := require.New(t)
+
+			for _, action := range []IrrigationAction{IrrigationStart, IrrigationStop} {
+				assert.NoError(CreateIrrigationExecution(ctx, ts.Tx(), &IrrigationExecution{
+					IrrigationScheduleID: s.ID,
+					DevEUI:               devices[0].DevEUI,
+					Action:               action,
+					Source:               IrrigationSourceManual,
+					Success:              true,
+				}))
+			}
+
+			count, err := GetIrrigationExecutionCount(ctx, ts.Tx(), s.ID)
+			assert.NoError(err)
+			assert.Equal(2, count)
+
+			items, err := GetIrrigationExecutions(ctx, ts.Tx(), s.ID, 10, 0)
+			assert.NoError(err)
+			assert.Len(items, 2)
+			assert.Equal(IrrigationStop, items[0].Action)
+			assert.Equal(devices[0].DevEUI, items[0].DevEUI)
+			assert.Equal(IrrigationSourceManual, items[0].Source)
+		})
+
+		t.Run("Update", func(t *testing.T) {
+			assert := require.New(t)
+
+			s.Name = "field 1 at sunrise"
+			s.Schedule = schedule.Schedule{Type: schedule.SunSchedule, Event: schedule.Sunrise, OffsetMinutes: -30, Latitude: 52.37, Longitude: 4.89}
+			s.StopDownlink = nil
+			assert.NoError(UpdateIrrigationSchedule(ctx, ts.Tx(), &s))
+
+			sGet, err := GetIrrigationSchedule(ctx, ts.Tx(), s.ID, false)
+			assert.NoError(err)
+			assert.Equal(s.Name, sGet.Name)
+			assert.Equal(s.Schedule, sGet.Schedule)
+			assert.Nil(sGet.StopDownlink)
+			assert.True(s.NextRunAt.Equal(*sGet.NextRunAt))
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(DeleteIrrigationSchedule(ctx, ts.Tx(), s.ID))
+			assert.Equal(ErrDoesNotExist, DeleteIrrigationSchedule(ctx, ts.Tx(), s.ID))
+
+			_, err := GetIrrigationSchedule(ctx, ts.Tx(), s.ID, false)
+			assert.Equal(ErrDoesNotExist, errors.Cause(err))
+		})
+	})
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/pkg/errors"
+)
+
+const jobLockKeyTempl = "lora:as:job:{%s}:lock" // (name)
+
+// acquireJobLockScript sets the lock when it is not set or extends the lock
+// when it is held by the given owner.
+var acquireJobLockScript = redis.NewScript(`
+local owner = redis.call("get", KEYS[1])
+if owner == false then
+	redis.call("set", KEYS[1], ARGV[1], "px", ARGV[2])
+	return 1
+end
+if owner == ARGV[1] then
+	redis.call("pexpire", KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// releaseJobLockScript removes the lock when it is held by the given owner.
+var releaseJobLockScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0
+`)
+
+// AcquireJobLock acquires the lock with the given name for the given owner
+// or extends the lock when it is already held by this owner. The lock
+// expires after the given TTL, unless it is extended. It returns false when
+// the lock is held by an other owner.
+func AcquireJobLock(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	n, err := acquireJobLockScript.Run(RedisClient(), []string{GetRedisKey(jobLockKeyTempl, name)}, owner, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, errors.Wrap(err, "acquire job lock error")
+	}
+	return n == 1, nil
+}
+
+// ReleaseJobLock releases the lock with the given name when it is held by
+// the given owner.
+func ReleaseJobLock(ctx context.Context, name, owner string) error {
+	if err := releaseJobLockScript.Run(RedisClient(), []string{GetRedisKey(jobLockKeyTempl, name)}, owner).Err(); err != nil {
+		return errors.Wrap(err, "release job lock error")
+	}
+	return nil
+}
+
+// GetJobLockOwner returns the owner of the lock with the given name. It
+// returns an empty string when the lock is not held.
+func GetJobLockOwner(ctx context.Context, name string) (string, error) {
+	owner, err := RedisClient().Get(GetRedisKey(jobLockKeyTempl, name)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return "", nil
+		}
+		return "", errors.Wrap(err, "get job lock owner error")
+	}
+	return owner, nil
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func (ts *StorageTestSuite) TestJobLock() {
+	ctx := context.Background()
+
+	ts.T().Run("Acquire", func(t *testing.T) {
+		assert := require.New(t)
+
+		ok, err := AcquireJobLock(ctx, "test", "instance-a", time.Minute)
+		assert.NoError(err)
+		assert.True(ok)
+
+		owner, err := GetJobLockOwner(ctx, "test")
+		assert.NoError(err)
+		assert.Equal("instance-a", owner)
+
+		t.Run("Extend by owner", func(t *testing.T) {
+			assert := require.New(t)
+
+			ok, err := AcquireJobLock(ctx, "test", "instance-a", time.Hour)
+			assert.NoError(err)
+			assert.True(ok)
+
+			ttl, err := RedisClient().PTTL(GetRedisKey(jobLockKeyTempl, "test")).Result()
+			assert.NoError(err)
+			assert.True(ttl > time.Minute)
+		})
+
+		t.Run("Acquire by other owner", func(t *testing.T) {
+			assert := require.New(t)
+
+			ok, err := AcquireJobLock(ctx, "test", "instance-b", time.Minute)
+			assert.NoError(err)
+			assert.False(ok)
+		})
+
+		t.Run("Other lock", func(t *testing.T) {
+			assert := require.New(t)
+
+			ok, err := AcquireJobLock(ctx, "other", "instance-b", time.Minute)
+			assert.NoError(err)
+			assert.True(ok)
+		})
+
+		t.Run("Release by other owner", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(ReleaseJobLock(ctx, "test", "instance-b"))
+
+			owner, err := GetJobLockOwner(ctx, "test")
+			assert.NoError(err)
+			assert.Equal("instance-a", owner)
+		})
+
+		t.Run("Release", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(ReleaseJobLock(ctx, "test", "instance-a"))
+
+			owner, err := GetJobLockOwner(ctx, "test")
+			assert.NoError(err)
+			assert.Equal("", owner)
+
+			ok, err := AcquireJobLock(ctx, "test", "instance-b", time.Minute)
+			assert.NoError(err)
+			assert.True(ok)
+		})
+	})
+
+	ts.T().Run("Expire", func(t *testing.T) {
+		assert := require.New(t)
+
+		ok, err := AcquireJobLock(ctx, "expire", "instance-a", 10*time.Millisecond)
+		assert.NoError(err)
+		assert.True(ok)
+
+		time.Sleep(50 * time.Millisecond)
+
+		ok, err = AcquireJobLock(ctx, "expire", "instance-b", time.Minute)
+		assert.NoError(err)
+		assert.True(ok)
+	})
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+)
+
+const (
+	joinFailureKeyTempl = "lora:as:js:join-failure:%s:{%s}:%d" // (scope | id | window)
+	joinBlockKeyTempl   = "lora:as:js:join-block:%s:{%s}"      // (scope | id)
+)
+
+// JoinFailureScope defines the scope in which failed join-requests are
+// counted.
+type JoinFailureScope string
+
+// Available join failure scopes.
+const (
+	JoinFailureScopeDevice  JoinFailureScope = "dev_eui"
+	JoinFailureScopeJoinEUI JoinFailureScope = "join_eui"
+	JoinFailureScopeSender  JoinFailureScope = "sender"
+)
+
+// JoinFailureKey identifies the DevEUI, JoinEUI or sender for which failed
+// join-requests are counted.
+type JoinFailureKey struct {
+	Scope JoinFailureScope
+	ID    string
+}
+
+// JoinFailureLimit defines the max. number of failed join-requests within
+// the given interval, after which join-requests are refused for the block
+// duration. A MaxFailures of 0 disables the limit.
+type JoinFailureLimit struct {
+	MaxFailures   int
+	Interval      time.Duration
+	BlockDuration time.Duration
+}
+
+// JoinBlock defines a blocked DevEUI, JoinEUI or sender.
+type JoinBlock struct {
+	JoinFailureKey
+	Until time.Time
+}
+
+var joinFailureLimits = make(map[JoinFailureScope]JoinFailureLimit)
+
+// SetJoinFailureLimit sets the join failure limit for the given scope.
+func SetJoinFailureLimit(scope JoinFailureScope, l JoinFailureLimit) {
+	joinFailureLimits[scope] = l
+}
+
+func getJoinFailureLimit(scope JoinFailureScope) (JoinFailureLimit, bool) {
+	l := joinFailureLimits[scope]
+	return l, l.MaxFailures > 0 && l.Interval > 0 && l.BlockDuration > 0
+}
+
+// GetJoinBlock returns the first of the given keys which is blocked. It
+// returns false when none of the keys is blocked.
+func GetJoinBlock(ctx context.Context, keys []JoinFailureKey) (JoinBlock, bool, error) {
+	var cmds []*redis.DurationCmd
+	var blockKeys []JoinFailureKey
+
+	pipe := RedisClient().Pipeline()
+	for _, k := range keys {
+		if _, ok := getJoinFailureLimit(k.Scope); !ok {
+			continue
+		}
+		cmds = append(cmds, pipe.PTTL(GetRedisKey(joinBlockKeyTempl, k.Scope, k.ID)))
+		blockKeys = append(blockKeys, k)
+	}
+
+	if len(cmds) == 0 {
+		return JoinBlock{}, false, nil
+	}
+
+	if _, err := pipe.Exec(); err != nil {
+		return JoinBlock{}, false, errors.Wrap(err, "exec error")
+	}
+
+	for i, cmd := range cmds {
+		// a negative ttl is returned when the key does not exist
+		if ttl := cmd.Val(); ttl > 0 {
+			return JoinBlock{
+				JoinFailureKey: blockKeys[i],
+				Until:          time.Now().Add(ttl),
+			}, true, nil
+		}
+	}
+
+	return JoinBlock{}, false, nil
+}
+
+// RegisterJoinFailure registers a failed join-request for the given keys.
+// It returns the keys which became blocked by this failure.
+func RegisterJoinFailure(ctx context.Context, keys []JoinFailureKey) ([]JoinBlock, error) {
+	var cmds []*redis.IntCmd
+	var countKeys []JoinFailureKey
+	now := time.Now()
+
+	pipe := RedisClient().TxPipeline()
+	for _, k := range keys {
+		l, ok := getJoinFailureLimit(k.Scope)
+		if !ok {
+			continue
+		}
+
+		key := GetRedisKey(joinFailureKeyTempl, k.Scope, k.ID, now.UnixNano()/int64(l.Interval))
+		cmds = append(cmds, pipe.Incr(key))
+		pipe.PExpire(key, l.Interval)
+		countKeys = append(countKeys, k)
+	}
+
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+
+	if _, err := pipe.Exec(); err != nil {
+		return nil, errors.Wrap(err, "exec error")
+	}
+
+	var out []JoinBlock
+	for i, cmd := range cmds {
+		k := countKeys[i]
+		l, _ := getJoinFailureLimit(k.Scope)
+
+		// only the failure exceeding the limit blocks the key, such that the
+		// block is not extended by subsequent failures within the interval
+		if cmd.Val() != int64(l.MaxFailures)+1 {
+			continue
+		}
+
+		if err := RedisClient().Set(GetRedisKey(joinBlockKeyTempl, k.Scope, k.ID), now.Unix(), l.BlockDuration).Err(); err != nil {
+			return nil, errors.Wrap(err, "set block error")
+		}
+
+		log.WithFields(log.Fields{
+			"scope":          k.Scope,
+			"id":             k.ID,
+			"max_failures":   l.MaxFailures,
+			"interval":       l.Interval,
+			"block_duration": l.BlockDuration,
+			"ctx_id":         ctx.Value(logging.ContextIDKey),
+		}).Warning("join failure limit exceeded, join-requests are blocked")
+
+		out = append(out, JoinBlock{
+			JoinFailureKey: k,
+			Until:          now.Add(l.BlockDuration),
+		})
+	}
+
+	return out, nil
+}
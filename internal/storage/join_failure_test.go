@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func (ts *StorageTestSuite) TestJoinFailure() {
+	devKey := JoinFailureKey{Scope: JoinFailureScopeDevice, ID: "0102030405060708"}
+	senderKey := JoinFailureKey{Scope: JoinFailureScopeSender, ID: "010203"}
+	keys := []JoinFailureKey{devKey, senderKey}
+
+	defer func() {
+		SetJoinFailureLimit(JoinFailureScopeDevice, JoinFailureLimit{})
+		SetJoinFailureLimit(JoinFailureScopeSender, JoinFailureLimit{})
+	}()
+
+	ts.T().Run("Disabled", func(t *testing.T) {
+		assert := require.New(t)
+		RedisClient().FlushAll()
+
+		for i := 0; i < 10; i++ {
+			blocks, err := RegisterJoinFailure(context.Background(), keys)
+			assert.NoError(err)
+			assert.Len(blocks, 0)
+		}
+
+		_, blocked, err := GetJoinBlock(context.Background(), keys)
+		assert.NoError(err)
+		assert.False(blocked)
+	})
+
+	ts.T().Run("Device limit", func(t *testing.T) {
+		assert := require.New(t)
+		RedisClient().FlushAll()
+		SetJoinFailureLimit(JoinFailureScopeDevice, JoinFailureLimit{MaxFailures: 2, Interval: time.Hour, BlockDuration: time.Hour})
+		SetJoinFailureLimit(JoinFailureScopeSender, JoinFailureLimit{MaxFailures: 10, Interval: time.Hour, BlockDuration: time.Hour})
+
+		for i := 0; i < 2; i++ {
+			blocks, err := RegisterJoinFailure(context.Background(), keys)
+			assert.NoError(err)
+			assert.Len(blocks, 0)
+		}
+
+		_, blocked, err := GetJoinBlock(context.Background(), keys)
+		assert.NoError(err)
+		assert.False(blocked)
+
+		blocks, err := RegisterJoinFailure(context.Background(), keys)
+		assert.NoError(err)
+		assert.Len(blocks, 1)
+		assert.Equal(devKey, blocks[0].JoinFailureKey)
+
+		block, blocked, err := GetJoinBlock(context.Background(), keys)
+		assert.NoError(err)
+		assert.True(blocked)
+		assert.Equal(devKey, block.JoinFailureKey)
+		assert.True(block.Until.After(time.Now()))
+
+		// other devices of the same sender are not blocked
+		_, blocked, err = GetJoinBlock(context.Background(), []JoinFailureKey{
+			{Scope: JoinFailureScopeDevice, ID: "0807060504030201"},
+			senderKey,
+		})
+		assert.NoError(err)
+		assert.False(blocked)
+
+		// subsequent failures do not block again
+		blocks, err = RegisterJoinFailure(context.Background(), keys)
+		assert.NoError(err)
+		assert.Len(blocks, 0)
+	})
+}
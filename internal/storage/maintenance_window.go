@@ -0,0 +1,429 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq/hstore"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+// MaintenanceRecurrence defines the recurrence of a maintenance window.
+type MaintenanceRecurrence string
+
+// Available maintenance window recurrences.
+const (
+	MaintenanceRecurrenceNone   MaintenanceRecurrence = "NONE"
+	MaintenanceRecurrenceDaily  MaintenanceRecurrence = "DAILY"
+	MaintenanceRecurrenceWeekly MaintenanceRecurrence = "WEEKLY"
+)
+
+// MaintenanceWindow defines a maintenance window during which the
+// automations (and the escalation of their alarms) are suppressed for the
+// devices of the window. The window applies to the device with the given
+// DevEUI, to the devices having all the DeviceTags (a zone) or else to all
+// the devices of the application.
+//
+// The window starts at StartsAt and lasts for Duration. A recurring window
+// repeats every day or week (in the configured metrics timezone) until the
+// optional Until time.
+type MaintenanceWindow struct {
+	ID            uuid.UUID             `db:"id"`
+	ApplicationID int64                 `db:"application_id"`
+	DevEUI        *lorawan.EUI64        `db:"dev_eui"`
+	CreatedAt     time.Time             `db:"created_at"`
+	UpdatedAt     time.Time             `db:"updated_at"`
+	Name          string                `db:"name"`
+	DeviceTags    hstore.Hstore         `db:"device_tags"`
+	StartsAt      time.Time             `db:"starts_at"`
+	Duration      time.Duration         `db:"duration"`
+	Recurrence    MaintenanceRecurrence `db:"recurrence"`
+	Until         *time.Time            `db:"until"`
+}
+
+// Validate validates the maintenance window data.
+func (w MaintenanceWindow) Validate() error {
+	if strings.TrimSpace(w.Name) == "" || len(w.Name) > 100 {
+		return ErrMaintenanceInvalidName
+	}
+
+	if w.DevEUI != nil && len(w.DeviceTags.Map) != 0 {
+		return ErrMaintenanceInvalidScope
+	}
+
+	if w.Duration <= 0 || w.Duration > w.period() {
+		return ErrMaintenanceInvalidDuration
+	}
+
+	switch w.Recurrence {
+	case MaintenanceRecurrenceNone:
+	case MaintenanceRecurrenceDaily, MaintenanceRecurrenceWeekly:
+		if w.Until != nil && !w.Until.After(w.StartsAt) {
+			return ErrMaintenanceInvalidRecurrence
+		}
+	default:
+		return ErrMaintenanceInvalidRecurrence
+	}
+
+	return nil
+}
+
+// period returns the max. duration of the window, which is the recurrence
+// interval for a recurring window.
+func (w MaintenanceWindow) period() time.Duration {
+	switch w.Recurrence {
+	case MaintenanceRecurrenceDaily:
+		return 24 * time.Hour
+	case MaintenanceRecurrenceWeekly:
+		return 7 * 24 * time.Hour
+	default:
+		return 365 * 24 * time.Hour
+	}
+}
+
+// ActiveUntil returns the end of the occurrence of the window which is
+// active at the given time. It returns false when the window is not active
+// at the given time.
+func (w MaintenanceWindow) ActiveUntil(t time.Time) (time.Time, bool) {
+	if t.Before(w.StartsAt) {
+		return time.Time{}, false
+	}
+
+	start := w.StartsAt
+
+	if w.Recurrence == MaintenanceRecurrenceDaily || w.Recurrence == MaintenanceRecurrenceWeekly {
+		days := int(w.period() / (24 * time.Hour))
+		first := w.StartsAt.In(timeLocation)
+
+		// the occurrences follow the wall-clock time of the first
+		// occurrence, a DST change might shift the computed occurrence by
+		// an hour
+		n := int(t.Sub(first) / w.period())
+		start = first.AddDate(0, 0, n*days)
+		if start.After(t) {
+			start = first.AddDate(0, 0, (n-1)*days)
+		} else if next := first.AddDate(0, 0, (n+1)*days); !next.After(t) {
+			start = next
+		}
+
+		if w.Until != nil && !start.Before(*w.Until) {
+			return time.Time{}, false
+		}
+	}
+
+	end := start.Add(w.Duration)
+	if !t.Before(end) {
+		return time.Time{}, false
+	}
+
+	return end, true
+}
+
+// MatchDevice returns true when the window applies to the given device.
+func (w MaintenanceWindow) MatchDevice(d Device) bool {
+	if d.ApplicationID != w.ApplicationID {
+		return false
+	}
+
+	if w.DevEUI != nil {
+		return *w.DevEUI == d.DevEUI
+	}
+
+	for k, v := range w.DeviceTags.Map {
+		dv, ok := d.Tags.Map[k]
+		if !ok || dv.Valid != v.Valid || dv.String != v.String {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MaintenanceWindowFilters provides filters for filtering maintenance
+// windows.
+type MaintenanceWindowFilters struct {
+	ApplicationID int64 `db:"application_id"`
+
+	// Limit and Offset are added for convenience so that this struct can
+	// be given as the arguments.
+	Limit  int `db:"limit"`
+	Offset int `db:"offset"`
+}
+
+// SQL returns the SQL filters.
+func (f MaintenanceWindowFilters) SQL() string {
+	return "where application_id = :application_id"
+}
+
+// CreateMaintenanceWindow creates the given maintenance window.
+func CreateMaintenanceWindow(ctx context.Context, db sqlx.Execer, w *MaintenanceWindow) error {
+	if err := w.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	var err error
+	w.ID, err = uuid.NewV4()
+	if err != nil {
+		return errors.Wrap(err, "new uuid v4 error")
+	}
+
+	now := time.Now()
+	w.CreatedAt = now
+	w.UpdatedAt = now
+
+	if w.DeviceTags.Map == nil {
+		w.DeviceTags.Map = make(map[string]sql.NullString)
+	}
+
+	_, err = db.Exec(`
+		insert into maintenance_window (
+			id,
+			application_id,
+			dev_eui,
+			created_at,
+			updated_at,
+			name,
+			device_tags,
+			starts_at,
+			duration,
+			recurrence,
+			until
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		w.ID,
+		w.ApplicationID,
+		w.devEUIBytes(),
+		w.CreatedAt,
+		w.UpdatedAt,
+		w.Name,
+		w.DeviceTags,
+		w.StartsAt,
+		w.Duration,
+		w.Recurrence,
+		w.Until,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"id":             w.ID,
+		"application_id": w.ApplicationID,
+		"ctx_id":         ctx.Value(logging.ContextIDKey),
+	}).Info("maintenance window created")
+
+	return nil
+}
+
+// GetMaintenanceWindow returns the maintenance window for the given id.
+func GetMaintenanceWindow(ctx context.Context, db sqlx.Queryer, id uuid.UUID) (MaintenanceWindow, error) {
+	var w MaintenanceWindow
+	if err := sqlx.Get(db, &w, "select * from maintenance_window where id = $1", id); err != nil {
+		return w, handlePSQLError(Select, err, "select error")
+	}
+
+	return w, nil
+}
+
+// GetMaintenanceWindowCount returns the number of maintenance windows
+// matching the given filters.
+func GetMaintenanceWindowCount(ctx context.Context, db sqlx.Queryer, filters MaintenanceWindowFilters) (int, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			count(*)
+		from
+			maintenance_window
+		`+filters.SQL(), filters)
+	if err != nil {
+		return 0, errors.Wrap(err, "named query error")
+	}
+
+	var count int
+	if err := sqlx.Get(db, &count, query, args...); err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	return count, nil
+}
+
+// GetMaintenanceWindows returns the maintenance windows matching the given
+// filters, most recent start first.
+func GetMaintenanceWindows(ctx context.Context, db sqlx.Queryer, filters MaintenanceWindowFilters) ([]MaintenanceWindow, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			*
+		from
+			maintenance_window
+		`+filters.SQL()+`
+		order by
+			starts_at desc,
+			id
+		limit :limit
+		offset :offset`, filters)
+	if err != nil {
+		return nil, errors.Wrap(err, "named query error")
+	}
+
+	var out []MaintenanceWindow
+	if err := sqlx.Select(db, &out, query, args...); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetActiveMaintenanceWindows returns the maintenance windows of the given
+// application which are active at the given time, sorted by name.
+func GetActiveMaintenanceWindows(ctx context.Context, db sqlx.Queryer, applicationID int64, t time.Time) ([]MaintenanceWindow, error) {
+	var windows []MaintenanceWindow
+
+	// windows which did not start yet or of which the (last) occurrence
+	// has ended are filtered out, the recurrence is evaluated by
+	// ActiveUntil
+	err := sqlx.Select(db, &windows, `
+		select
+			*
+		from
+			maintenance_window
+		where
+			application_id = $1
+			and starts_at <= $2
+			and (
+				(recurrence = $3 and starts_at + (duration / 1000) * interval '1 microsecond' > $2)
+				or (recurrence != $3 and (until is null or until + (duration / 1000) * interval '1 microsecond' > $2))
+			)
+		order by
+			name`,
+		applicationID,
+		t,
+		MaintenanceRecurrenceNone,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	var out []MaintenanceWindow
+	for _, w := range windows {
+		if _, ok := w.ActiveUntil(t); ok {
+			out = append(out, w)
+		}
+	}
+
+	return out, nil
+}
+
+// GetDeviceMaintenanceEnd returns the end of the active maintenance
+// window(s) of the given device. It returns false when the device is not
+// in maintenance at the given time.
+func GetDeviceMaintenanceEnd(ctx context.Context, db sqlx.Queryer, d Device, t time.Time) (time.Time, bool, error) {
+	windows, err := GetActiveMaintenanceWindows(ctx, db, d.ApplicationID, t)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	var end time.Time
+	var active bool
+
+	for _, w := range windows {
+		if !w.MatchDevice(d) {
+			continue
+		}
+
+		if e, ok := w.ActiveUntil(t); ok && e.After(end) {
+			end = e
+			active = true
+		}
+	}
+
+	return end, active, nil
+}
+
+// UpdateMaintenanceWindow updates the given maintenance window.
+func UpdateMaintenanceWindow(ctx context.Context, db sqlx.Execer, w *MaintenanceWindow) error {
+	if err := w.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	w.UpdatedAt = time.Now()
+
+	if w.DeviceTags.Map == nil {
+		w.DeviceTags.Map = make(map[string]sql.NullString)
+	}
+
+	res, err := db.Exec(`
+		update maintenance_window
+		set
+			updated_at = $2,
+			dev_eui = $3,
+			name = $4,
+			device_tags = $5,
+			starts_at = $6,
+			duration = $7,
+			recurrence = $8,
+			until = $9
+		where
+			id = $1`,
+		w.ID,
+		w.UpdatedAt,
+		w.devEUIBytes(),
+		w.Name,
+		w.DeviceTags,
+		w.StartsAt,
+		w.Duration,
+		w.Recurrence,
+		w.Until,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"id":     w.ID,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("maintenance window updated")
+
+	return nil
+}
+
+// DeleteMaintenanceWindow deletes the maintenance window.
+func DeleteMaintenanceWindow(ctx context.Context, db sqlx.Execer, id uuid.UUID) error {
+	res, err := db.Exec("delete from maintenance_window where id = $1", id)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"id":     id,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("maintenance window deleted")
+
+	return nil
+}
+
+func (w MaintenanceWindow) devEUIBytes() []byte {
+	if w.DevEUI == nil {
+		return nil
+	}
+	return w.DevEUI[:]
+}
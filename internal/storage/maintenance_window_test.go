@@ -0,0 +1,309 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/lib/pq/hstore"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	//"github.com/brocaar/lorawan"
+)
+
+func TestMaintenanceWindowActiveUntil(t *testing.T) {
+	startsAt := time.Date(2020, 1, 6, 8, 0, 0, 0, time.UTC) // monday
+	until := time.Date(2020, 1, 20, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		Name           string
+		Recurrence     MaintenanceRecurrence
+		Until          *time.Time
+		Time           time.Time
+		ExpectedActive bool
+		ExpectedEnd    time.Time
+	}{
+		{
+			Name:       "one-off before start",
+			Recurrence: MaintenanceRecurrenceNone,
+			Time:       startsAt.Add(-time.Second),
+		},
+		{
+			Name:           "one-off active",
+			Recurrence:     MaintenanceRecurrenceNone,
+			Time:           startsAt.Add(time.Hour),
+			ExpectedActive: true,
+			ExpectedEnd:    startsAt.Add(2 * time.Hour),
+		},
+		{
+			Name:       "one-off ended",
+			Recurrence: MaintenanceRecurrenceNone,
+			Time:       startsAt.Add(2 * time.Hour),
+		},
+		{
+			Name:       "one-off next day",
+			Recurrence: MaintenanceRecurrenceNone,
+			Time:       startsAt.Add(25 * time.Hour),
+		},
+		{
+			Name:           "daily next day",
+			Recurrence:     MaintenanceRecurrenceDaily,
+			Time:           startsAt.Add(25 * time.Hour),
+			ExpectedActive: true,
+			ExpectedEnd:    startsAt.Add(26 * time.Hour),
+		},
+		{
+			Name:       "daily between occurrences",
+			Recurrence: MaintenanceRecurrenceDaily,
+			Time:       startsAt.Add(27 * time.Hour),
+		},
+		{
+			Name:       "weekly next day",
+			Recurrence: MaintenanceRecurrenceWeekly,
+			Time:       startsAt.Add(25 * time.Hour),
+		},
+		{
+			Name:           "weekly next week",
+			Recurrence:     MaintenanceRecurrenceWeekly,
+			Time:           startsAt.AddDate(0, 0, 7).Add(time.Minute),
+			ExpectedActive: true,
+			ExpectedEnd:    startsAt.AddDate(0, 0, 7).Add(2 * time.Hour),
+		},
+		{
+			Name:           "weekly before until",
+			Recurrence:     MaintenanceRecurrenceWeekly,
+			Until:          &until,
+			Time:           startsAt.AddDate(0, 0, 7).Add(time.Minute),
+			ExpectedActive: true,
+			ExpectedEnd:    startsAt.AddDate(0, 0, 7).Add(2 * time.Hour),
+		},
+		{
+			Name:       "weekly after until",
+			Recurrence: MaintenanceRecurrenceWeekly,
+			Until:      &until,
+			Time:       startsAt.AddDate(0, 0, 14).Add(time.Minute),
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			w := MaintenanceWindow{
+				StartsAt:   startsAt,
+				Duration:   2 * time.Hour,
+				Recurrence: tst.Recurrence,
+				Until:      tst.Until,
+			}
+
+			end, ok := w.ActiveUntil(tst.Time)
+			assert.Equal(tst.ExpectedActive, ok)
+			if tst.ExpectedActive {
+				assert.True(tst.ExpectedEnd.Equal(end), "expected: %s, got: %s", tst.ExpectedEnd, end)
+			}
+		})
+	}
+}
+
+func (ts *StorageTestSuite) TestMaintenanceWindow() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(ctx, ts.Tx(), &org))
+
+	n := NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(CreateNetworkServer(ctx, ts.Tx(), &n))
+
+	sp := ServiceProfile{
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+		Name:            "test-sp",
+	}
+	assert.NoError(CreateServiceProfile(ctx, ts.Tx(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(ctx, ts.Tx(), &app))
+
+	dp := DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateDeviceProfile(ctx, ts.Tx(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	d1 := Device{
+		DevEUI:          lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 1},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "greenhouse-1",
+		Tags: hstore.Hstore{
+			Map: map[string]sql.NullString{
+				"zone": {String: "greenhouse", Valid: true},
+			},
+		},
+	}
+	assert.NoError(CreateDevice(ctx, ts.Tx(), &d1))
+
+	d2 := Device{
+		DevEUI:          lorawan.EUI64{2, 2, 2, 2, 2, 2, 2, 2},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "field-1",
+	}
+	assert.NoError(CreateDevice(ctx, ts.Tx(), &d2))
+
+	now := time.Now().Round(time.Second)
+
+	ts.T().Run("Create invalid", func(t *testing.T) {
+		assert := require.New(t)
+
+		tests := []struct {
+			Window MaintenanceWindow
+			Error  error
+		}{
+			{MaintenanceWindow{ApplicationID: app.ID, StartsAt: now, Duration: time.Hour, Recurrence: MaintenanceRecurrenceNone}, ErrMaintenanceInvalidName},
+			{MaintenanceWindow{ApplicationID: app.ID, Name: "test", DevEUI: &d1.DevEUI, DeviceTags: d1.Tags, StartsAt: now, Duration: time.Hour, Recurrence: MaintenanceRecurrenceNone}, ErrMaintenanceInvalidScope},
+			{MaintenanceWindow{ApplicationID: app.ID, Name: "test", StartsAt: now, Recurrence: MaintenanceRecurrenceNone}, ErrMaintenanceInvalidDuration},
+			{MaintenanceWindow{ApplicationID: app.ID, Name: "test", StartsAt: now, Duration: 25 * time.Hour, Recurrence: MaintenanceRecurrenceDaily}, ErrMaintenanceInvalidDuration},
+			{MaintenanceWindow{ApplicationID: app.ID, Name: "test", StartsAt: now, Duration: time.Hour, Recurrence: "MONTHLY"}, ErrMaintenanceInvalidRecurrence},
+		}
+
+		for _, tst := range tests {
+			assert.Equal(tst.Error, errors.Cause(CreateMaintenanceWindow(ctx, ts.Tx(), &tst.Window)))
+		}
+	})
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		device := MaintenanceWindow{
+			ApplicationID: app.ID,
+			Name:          "replace-battery",
+			DevEUI:        &d2.DevEUI,
+			StartsAt:      now.Add(-time.Hour),
+			Duration:      3 * time.Hour,
+			Recurrence:    MaintenanceRecurrenceNone,
+		}
+		assert.NoError(CreateMaintenanceWindow(ctx, ts.Tx(), &device))
+
+		zone := MaintenanceWindow{
+			ApplicationID: app.ID,
+			Name:          "greenhouse-cleaning",
+			DeviceTags: hstore.Hstore{
+				Map: map[string]sql.NullString{
+					"zone": {String: "greenhouse", Valid: true},
+				},
+			},
+			StartsAt:   now.AddDate(0, 0, -7).Add(-time.Minute),
+			Duration:   time.Hour,
+			Recurrence: MaintenanceRecurrenceDaily,
+		}
+		assert.NoError(CreateMaintenanceWindow(ctx, ts.Tx(), &zone))
+
+		wGet, err := GetMaintenanceWindow(ctx, ts.Tx(), device.ID)
+		assert.NoError(err)
+		assert.Equal(&d2.DevEUI, wGet.DevEUI)
+		assert.Equal(3*time.Hour, wGet.Duration)
+		assert.True(device.StartsAt.Equal(wGet.StartsAt))
+
+		wGet, err = GetMaintenanceWindow(ctx, ts.Tx(), zone.ID)
+		assert.NoError(err)
+		assert.Nil(wGet.DevEUI)
+		assert.Equal(zone.DeviceTags, wGet.DeviceTags)
+
+		t.Run("List", func(t *testing.T) {
+			assert := require.New(t)
+
+			filters := MaintenanceWindowFilters{
+				ApplicationID: app.ID,
+				Limit:         10,
+			}
+
+			count, err := GetMaintenanceWindowCount(ctx, ts.Tx(), filters)
+			assert.NoError(err)
+			assert.Equal(2, count)
+
+			items, err := GetMaintenanceWindows(ctx, ts.Tx(), filters)
+			assert.NoError(err)
+			assert.Len(items, 2)
+			assert.Equal(device.ID, items[0].ID)
+		})
+
+		t.Run("Active", func(t *testing.T) {
+			assert := require.New(t)
+
+			items, err := GetActiveMaintenanceWindows(ctx, ts.Tx(), app.ID, now)
+			assert.NoError(err)
+			assert.Len(items, 2)
+
+			items, err = GetActiveMaintenanceWindows(ctx, ts.Tx(), app.ID, now.Add(90*time.Minute))
+			assert.NoError(err)
+			assert.Len(items, 1)
+			assert.Equal(device.ID, items[0].ID)
+
+			items, err = GetActiveMaintenanceWindows(ctx, ts.Tx(), app.ID, now.Add(24*time.Hour))
+			assert.NoError(err)
+			assert.Len(items, 1)
+			assert.Equal(zone.ID, items[0].ID)
+		})
+
+		t.Run("Device maintenance end", func(t *testing.T) {
+			assert := require.New(t)
+
+			end, ok, err := GetDeviceMaintenanceEnd(ctx, ts.Tx(), d1, now)
+			assert.NoError(err)
+			assert.True(ok)
+			assert.True(end.Equal(now.Add(59 * time.Minute)))
+
+			end, ok, err = GetDeviceMaintenanceEnd(ctx, ts.Tx(), d2, now)
+			assert.NoError(err)
+			assert.True(ok)
+			assert.True(end.Equal(now.Add(2 * time.Hour)))
+
+			_, ok, err = GetDeviceMaintenanceEnd(ctx, ts.Tx(), d2, now.Add(2*time.Hour))
+			assert.NoError(err)
+			assert.False(ok)
+		})
+
+		t.Run("Update", func(t *testing.T) {
+			assert := require.New(t)
+
+			until := now.Add(-time.Hour)
+			zone.Until = &until
+			assert.NoError(UpdateMaintenanceWindow(ctx, ts.Tx(), &zone))
+
+			_, ok, err := GetDeviceMaintenanceEnd(ctx, ts.Tx(), d1, now)
+			assert.NoError(err)
+			assert.False(ok)
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(DeleteMaintenanceWindow(ctx, ts.Tx(), device.ID))
+			assert.Equal(ErrDoesNotExist, errors.Cause(DeleteMaintenanceWindow(ctx, ts.Tx(), device.ID)))
+		})
+	})
+}
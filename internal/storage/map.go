@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq/hstore"
+	//"github.com/brocaar/lorawan"
+)
+
+// MapDevice defines a located device as shown on the map. OpenAlarms holds
+// the number of open (unacknowledged and unresolved) automation alarms of
+// the device.
+type MapDevice struct {
+	DevEUI       lorawan.EUI64 `db:"dev_eui"`
+	Name         string        `db:"name"`
+	Latitude     float64       `db:"latitude"`
+	Longitude    float64       `db:"longitude"`
+	Tags         hstore.Hstore `db:"tags"`
+	LastSeenAt   *time.Time    `db:"last_seen_at"`
+	BatteryLevel *float32      `db:"battery_level"`
+	OpenAlarms   int           `db:"open_alarms"`
+}
+
+// MapGateway defines a located gateway as shown on the map. A gateway is
+// online when it has sent its stats within 1.5 times the stats interval of
+// its gateway-profile (by default 30 seconds).
+type MapGateway struct {
+	MAC        lorawan.EUI64 `db:"mac"`
+	Name       string        `db:"name"`
+	Latitude   float64       `db:"latitude"`
+	Longitude  float64       `db:"longitude"`
+	LastSeenAt *time.Time    `db:"last_seen_at"`
+	Online     bool          `db:"online"`
+}
+
+// GetMapDevices returns the located devices of the given application,
+// sorted by name. Devices without location are omitted.
+func GetMapDevices(ctx context.Context, db sqlx.Queryer, applicationID int64, limit int) ([]MapDevice, error) {
+	defer observeQueryDuration("map_devices_get", time.Now())
+
+	var out []MapDevice
+	err := sqlx.Select(db, &out, `
+		select
+			d.dev_eui,
+			d.name,
+			d.latitude,
+			d.longitude,
+			d.tags,
+			d.last_seen_at,
+			d.device_status_battery as battery_level,
+			(
+				select
+					count(*)
+				from
+					automation_alarm a
+				where
+					a.dev_eui = d.dev_eui
+					and a.acknowledged_at is null
+					and a.resolved_at is null
+			) as open_alarms
+		from
+			device d
+		where
+			d.application_id = $1
+			and d.latitude is not null
+			and d.longitude is not null
+		order by
+			d.name,
+			d.dev_eui
+		limit $2`,
+		applicationID,
+		limit,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetMapGateways returns the located gateways of the given organization,
+// sorted by name. Gateways without location (0, 0) are omitted.
+func GetMapGateways(ctx context.Context, db sqlx.Queryer, organizationID int64, limit int) ([]MapGateway, error) {
+	defer observeQueryDuration("map_gateways_get", time.Now())
+
+	var out []MapGateway
+	err := sqlx.Select(db, &out, `
+		select
+			g.mac,
+			g.name,
+			g.latitude,
+			g.longitude,
+			g.last_seen_at,
+			coalesce(g.last_seen_at >= now() - make_interval(secs => coalesce(gp.stats_interval / 1000000000, 30)) * 1.5, false) as online
+		from
+			gateway g
+		left join gateway_profile gp
+			on g.gateway_profile_id = gp.gateway_profile_id
+		where
+			g.organization_id = $1
+			and (g.latitude <> 0 or g.longitude <> 0)
+		order by
+			g.name,
+			g.mac
+		limit $2`,
+		organizationID,
+		limit,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestMap() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(ctx, ts.Tx(), &org))
+
+	n := NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(CreateNetworkServer(ctx, ts.Tx(), &n))
+
+	sp := ServiceProfile{
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+		Name:            "test-sp",
+	}
+	assert.NoError(CreateServiceProfile(ctx, ts.Tx(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(ctx, ts.Tx(), &app))
+
+	dp := DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateDeviceProfile(ctx, ts.Tx(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	float := func(f float64) *float64 { return &f }
+	now := time.Now().Round(time.Second)
+
+	devices := []Device{
+		{DevEUI: lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 1}, Name: "b-located", Latitude: float(51.5), Longitude: float(4.25)},
+		{DevEUI: lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 2}, Name: "a-located", Latitude: float(51.6), Longitude: float(4.35), LastSeenAt: &now},
+		{DevEUI: lorawan.EUI64{2, 2, 2, 2, 2, 2, 2, 1}, Name: "not-located"},
+	}
+	for i := range devices {
+		devices[i].ApplicationID = app.ID
+		devices[i].DeviceProfileID = dpID
+		assert.NoError(CreateDevice(ctx, ts.Tx(), &devices[i]))
+	}
+
+	a := Automation{
+		ApplicationID: app.ID,
+		Name:          "offline",
+		Enabled:       true,
+		Trigger: spec.Trigger{
+			Type:           spec.DeviceOfflineTrigger,
+			TimeoutSeconds: 3600,
+		},
+		Actions: spec.Actions{
+			{Type: spec.WebhookAction, URL: "http://localhost/hook"},
+		},
+	}
+	assert.NoError(CreateAutomation(ctx, ts.Tx(), &a))
+	assert.NoError(CreateAutomationAlarm(ctx, ts.Tx(), &AutomationAlarm{
+		AutomationID: a.ID,
+		DevEUI:       devices[0].DevEUI,
+		CreatedAt:    now,
+	}))
+
+	gateways := []Gateway{
+		{MAC: lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 1}, Name: "gw-online", Latitude: 51.55, Longitude: 4.3, LastSeenAt: &now},
+		{MAC: lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 2}, Name: "gw-offline", Latitude: 51.45, Longitude: 4.2},
+		{MAC: lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 3}, Name: "gw-not-located"},
+	}
+	for i := range gateways {
+		gateways[i].OrganizationID = org.ID
+		gateways[i].NetworkServerID = n.ID
+		assert.NoError(CreateGateway(ctx, ts.Tx(), &gateways[i]))
+	}
+
+	ts.T().Run("Devices", func(t *testing.T) {
+		assert := require.New(t)
+
+		items, err := GetMapDevices(ctx, ts.Tx(), app.ID, 10)
+		assert.NoError(err)
+		assert.Len(items, 2)
+
+		assert.Equal(devices[1].DevEUI, items[0].DevEUI)
+		assert.Equal(51.6, items[0].Latitude)
+		assert.Equal(4.35, items[0].Longitude)
+		assert.True(now.Equal(*items[0].LastSeenAt))
+		assert.Equal(0, items[0].OpenAlarms)
+
+		assert.Equal(devices[0].DevEUI, items[1].DevEUI)
+		assert.Nil(items[1].LastSeenAt)
+		assert.Equal(1, items[1].OpenAlarms)
+
+		items, err = GetMapDevices(ctx, ts.Tx(), app.ID, 1)
+		assert.NoError(err)
+		assert.Len(items, 1)
+	})
+
+	ts.T().Run("Gateways", func(t *testing.T) {
+		assert := require.New(t)
+
+		items, err := GetMapGateways(ctx, ts.Tx(), org.ID, 10)
+		assert.NoError(err)
+		assert.Len(items, 2)
+
+		assert.Equal(gateways[1].MAC, items[0].MAC)
+		assert.False(items[0].Online)
+
+		assert.Equal(gateways[0].MAC, items[1].MAC)
+		assert.Equal(51.55, items[1].Latitude)
+		assert.True(items[1].Online)
+	})
+}
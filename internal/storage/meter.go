@@ -0,0 +1,249 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	"github.com/ibrahimozekici/app-server2/internal/measurement"
+	//"github.com/brocaar/lorawan"
+)
+
+// MeterState defines the last reading of a meter (a COUNTER measurement of
+// the device-profile) of a device.
+type MeterState struct {
+	DevEUI lorawan.EUI64 `db:"dev_eui"`
+	Name   string        `db:"name"`
+	Value  float64       `db:"value"`
+	Time   time.Time     `db:"time"`
+}
+
+// MeterConsumption defines the consumption of a meter of a device within a
+// day or month (in the configured metrics timezone), starting at Date.
+// Readings holds the number of readings within this period.
+type MeterConsumption struct {
+	DevEUI      lorawan.EUI64 `db:"dev_eui"`
+	DeviceName  string        `db:"device_name"`
+	Name        string        `db:"name"`
+	Date        time.Time     `db:"date"`
+	Consumption float64       `db:"consumption"`
+	Readings    int           `db:"readings"`
+}
+
+// MeterConsumptionFilters provides filters for the meter consumption. Note
+// that empty values are not used as filters. Start and End select the days
+// (in the configured metrics timezone) from the day of Start until the day
+// of End (excluding).
+type MeterConsumptionFilters struct {
+	ApplicationID int64         `db:"application_id"`
+	DevEUI        lorawan.EUI64 `db:"dev_eui"`
+	Name          string        `db:"name"`
+	Start         time.Time     `db:"-"`
+	End           time.Time     `db:"-"`
+}
+
+// SQL returns the SQL filters.
+func (f MeterConsumptionFilters) SQL() string {
+	var nullDevEUI lorawan.EUI64
+	filters := []string{"c.date >= :start_date", "c.date < :end_date"}
+
+	if f.ApplicationID != 0 {
+		filters = append(filters, "d.application_id = :application_id")
+	}
+
+	if f.DevEUI != nullDevEUI {
+		filters = append(filters, "c.dev_eui = :dev_eui")
+	}
+
+	if f.Name != "" {
+		filters = append(filters, "c.name = :name")
+	}
+
+	return "where " + strings.Join(filters, " and ")
+}
+
+// AddMeterReading adds the reading of the meter (COUNTER measurement) of the
+// device, received at the given time. The consumption since the previous
+// reading (see measurement.Measurement.Consumption) is added to the
+// consumption of the day of the reading and returned. The first reading of a
+// meter does not have a consumption. Readings older than the last reading
+// are ignored. This must be called within a transaction, as the state is
+// locked for update.
+func AddMeterReading(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, m measurement.Measurement, t time.Time) (float64, error) {
+	value, ok := m.Value.(float64)
+	if !ok || m.Kind != measurement.Counter {
+		return 0, errors.New("measurement is not a counter")
+	}
+
+	var s MeterState
+	exists := true
+
+	err := sqlx.Get(db, &s, `
+		select
+			*
+		from
+			meter_state
+		where
+			dev_eui = $1
+			and name = $2
+		for update`,
+		devEUI,
+		m.Name,
+	)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			return 0, handlePSQLError(Select, err, "select error")
+		}
+		exists = false
+	}
+
+	if exists && t.Before(s.Time) {
+		return 0, nil
+	}
+
+	_, err = db.Exec(`
+		insert into meter_state (
+			dev_eui,
+			name,
+			value,
+			time
+		) values ($1, $2, $3, $4)
+		on conflict (dev_eui, name)
+			do update set
+				value = excluded.value,
+				time = excluded.time`,
+		devEUI,
+		m.Name,
+		value,
+		t,
+	)
+	if err != nil {
+		return 0, handlePSQLError(Insert, err, "insert error")
+	}
+
+	var consumption float64
+	if exists {
+		consumption = m.Consumption(s.Value)
+
+		if value < s.Value {
+			log.WithFields(log.Fields{
+				"dev_eui":  devEUI,
+				"name":     m.Name,
+				"value":    value,
+				"previous": s.Value,
+				"ctx_id":   ctx.Value(logging.ContextIDKey),
+			}).Info("meter counter rolled over or reset")
+		}
+	}
+
+	_, err = db.Exec(`
+		insert into meter_consumption (
+			dev_eui,
+			name,
+			date,
+			consumption,
+			readings
+		) values ($1, $2, $3, $4, 1)
+		on conflict (dev_eui, name, date)
+			do update set
+				consumption = meter_consumption.consumption + excluded.consumption,
+				readings = meter_consumption.readings + 1`,
+		devEUI,
+		m.Name,
+		t.In(timeLocation).Format("2006-01-02"),
+		consumption,
+	)
+	if err != nil {
+		return 0, handlePSQLError(Insert, err, "insert error")
+	}
+
+	return consumption, nil
+}
+
+// GetMeterStates returns the last readings of the meters of the device,
+// sorted by name.
+func GetMeterStates(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64) ([]MeterState, error) {
+	var out []MeterState
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			meter_state
+		where
+			dev_eui = $1
+		order by
+			name`,
+		devEUI,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetMeterConsumption returns the consumption matching the given filters,
+// aggregated by day or month (AggregationDay or AggregationMonth). It is
+// sorted by device name, meter name and date.
+func GetMeterConsumption(ctx context.Context, db sqlx.Queryer, filters MeterConsumptionFilters, interval AggregationInterval) ([]MeterConsumption, error) {
+	var trunc string
+	switch interval {
+	case AggregationDay:
+		trunc = "day"
+	case AggregationMonth:
+		trunc = "month"
+	default:
+		return nil, errors.Errorf("unexpected aggregation interval: %s", interval)
+	}
+
+	args := struct {
+		MeterConsumptionFilters
+		Trunc     string `db:"trunc"`
+		StartDate string `db:"start_date"`
+		EndDate   string `db:"end_date"`
+	}{
+		MeterConsumptionFilters: filters,
+		Trunc:                   trunc,
+		StartDate:               filters.Start.In(timeLocation).Format("2006-01-02"),
+		EndDate:                 filters.End.In(timeLocation).Format("2006-01-02"),
+	}
+
+	// the selected date depends on the trunc argument, therefore the
+	// columns are grouped by position
+	query, queryArgs, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			c.dev_eui,
+			d.name as device_name,
+			c.name,
+			cast(date_trunc(:trunc, c.date) as date) as date,
+			sum(c.consumption) as consumption,
+			sum(c.readings) as readings
+		from
+			meter_consumption c
+		inner join device d
+			on d.dev_eui = c.dev_eui
+		`+filters.SQL()+`
+		group by
+			1, 2, 3, 4
+		order by
+			device_name,
+			c.dev_eui,
+			c.name,
+			date`, args)
+	if err != nil {
+		return nil, errors.Wrap(err, "named query error")
+	}
+
+	var out []MeterConsumption
+	if err := sqlx.Select(db, &out, query, queryArgs...); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
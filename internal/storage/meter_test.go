@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	"github.com/ibrahimozekici/app-server2/internal/measurement"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestMeter() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	loc := timeLocation
+	timeLocation = time.UTC
+	defer func() { timeLocation = loc }()
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(ctx, ts.Tx(), &org))
+
+	n := NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(CreateNetworkServer(ctx, ts.Tx(), &n))
+
+	sp := ServiceProfile{
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+		Name:            "test-sp",
+	}
+	assert.NoError(CreateServiceProfile(ctx, ts.Tx(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(ctx, ts.Tx(), &app))
+
+	dp := DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateDeviceProfile(ctx, ts.Tx(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	d := Device{
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "water-meter",
+	}
+	assert.NoError(CreateDevice(ctx, ts.Tx(), &d))
+
+	reading := func(v float64) measurement.Measurement {
+		return measurement.Measurement{
+			Name:     "water",
+			Kind:     measurement.Counter,
+			Rollover: 1000,
+			Factor:   0.001,
+			Value:    v,
+		}
+	}
+	at := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2021, month, day, hour, 0, 0, 0, time.UTC)
+	}
+
+	ts.T().Run("AddMeterReading", func(t *testing.T) {
+		assert := require.New(t)
+
+		tests := []struct {
+			Value       float64
+			Time        time.Time
+			Consumption float64
+		}{
+			// the first reading does not have a consumption
+			{100, at(1, 30, 10), 0},
+			{300, at(1, 31, 10), 0.2},
+			{500, at(2, 1, 10), 0.2},
+			// ignored, as it is older than the last reading
+			{400, at(2, 1, 9), 0},
+			// rollover
+			{100, at(2, 1, 12), 0.6},
+		}
+
+		for _, tst := range tests {
+			c, err := AddMeterReading(ctx, ts.Tx(), d.DevEUI, reading(tst.Value), tst.Time)
+			assert.NoError(err)
+			assert.InDelta(tst.Consumption, c, 0.0001)
+		}
+
+		_, err := AddMeterReading(ctx, ts.Tx(), d.DevEUI, measurement.Measurement{Name: "temperature", Kind: measurement.Gauge, Value: 21.5}, at(2, 1, 12))
+		assert.Error(err)
+
+		states, err := GetMeterStates(ctx, ts.Tx(), d.DevEUI)
+		assert.NoError(err)
+		assert.Len(states, 1)
+		assert.Equal("water", states[0].Name)
+		assert.Equal(100.0, states[0].Value)
+		assert.True(states[0].Time.Equal(at(2, 1, 12)))
+	})
+
+	ts.T().Run("GetMeterConsumption", func(t *testing.T) {
+		assert := require.New(t)
+
+		days, err := GetMeterConsumption(ctx, ts.Tx(), MeterConsumptionFilters{
+			DevEUI: d.DevEUI,
+			Start:  at(1, 1, 0),
+			End:    at(3, 1, 0),
+		}, AggregationDay)
+		assert.NoError(err)
+		assert.Len(days, 3)
+		assert.Equal("water-meter", days[0].DeviceName)
+		assert.Equal(time.Date(2021, 1, 30, 0, 0, 0, 0, time.UTC), days[0].Date.UTC())
+		assert.Equal(1, days[0].Readings)
+		assert.InDelta(0, days[0].Consumption, 0.0001)
+		assert.InDelta(0.2, days[1].Consumption, 0.0001)
+		assert.Equal(2, days[2].Readings)
+		assert.InDelta(0.8, days[2].Consumption, 0.0001)
+
+		months, err := GetMeterConsumption(ctx, ts.Tx(), MeterConsumptionFilters{
+			ApplicationID: app.ID,
+			Name:          "water",
+			Start:         at(1, 1, 0),
+			End:           at(3, 1, 0),
+		}, AggregationMonth)
+		assert.NoError(err)
+		assert.Len(months, 2)
+		assert.Equal(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), months[0].Date.UTC())
+		assert.InDelta(0.2, months[0].Consumption, 0.0001)
+		assert.InDelta(0.8, months[1].Consumption, 0.0001)
+
+		// outside the time range
+		days, err = GetMeterConsumption(ctx, ts.Tx(), MeterConsumptionFilters{
+			DevEUI: d.DevEUI,
+			Start:  at(2, 2, 0),
+			End:    at(3, 1, 0),
+		}, AggregationDay)
+		assert.NoError(err)
+		assert.Len(days, 0)
+
+		_, err = GetMeterConsumption(ctx, ts.Tx(), MeterConsumptionFilters{DevEUI: d.DevEUI}, AggregationHour)
+		assert.Error(err)
+	})
+}
@@ -67,12 +67,17 @@ func SetMetricsTTL(minute, hour, day, month time.Duration) {
 	metricsMonthTTL = month
 }
 
-// SaveMetrics stores the given metrics into Redis.
+// SaveMetrics stores the given metrics into Redis, for all the configured
+// aggregation intervals using a single pipeline.
 func SaveMetrics(ctx context.Context, name string, metrics MetricsRecord) error {
-	for _, agg := range aggregationIntervals {
-		if err := SaveMetricsForInterval(ctx, agg, name, metrics); err != nil {
-			return errors.Wrap(err, "save metrics for interval error")
-		}
+	defer observeQueryDuration("metrics_write", time.Now())
+
+	pipe := RedisClient().TxPipeline()
+	if err := SaveMetricsPipelined(ctx, pipe, name, metrics); err != nil {
+		return err
+	}
+	if _, err := pipe.Exec(); err != nil {
+		return errors.Wrap(err, "exec error")
 	}
 
 	log.WithFields(log.Fields{
@@ -84,8 +89,44 @@ func SaveMetrics(ctx context.Context, name string, metrics MetricsRecord) error
 	return nil
 }
 
+// SaveMetricsPipelined adds the commands storing the given metrics for all
+// the configured aggregation intervals to the given pipeline. The metrics
+// are stored when the pipeline is executed by the caller, this makes it
+// possible to combine these with other commands in a single round-trip.
+func SaveMetricsPipelined(ctx context.Context, pipe redis.Pipeliner, name string, metrics MetricsRecord) error {
+	for _, agg := range aggregationIntervals {
+		if err := saveMetricsForInterval(pipe, agg, name, metrics); err != nil {
+			return errors.Wrap(err, "save metrics for interval error")
+		}
+	}
+
+	return nil
+}
+
 // SaveMetricsForInterval aggregates and stores the given metrics.
 func SaveMetricsForInterval(ctx context.Context, agg AggregationInterval, name string, metrics MetricsRecord) error {
+	defer observeQueryDuration("metrics_write", time.Now())
+
+	pipe := RedisClient().TxPipeline()
+	if err := saveMetricsForInterval(pipe, agg, name, metrics); err != nil {
+		return err
+	}
+	if _, err := pipe.Exec(); err != nil {
+		return errors.Wrap(err, "exec error")
+	}
+
+	log.WithFields(log.Fields{
+		"name":        name,
+		"aggregation": agg,
+		"ctx_id":      ctx.Value(logging.ContextIDKey),
+	}).Debug("metrics saved")
+
+	return nil
+}
+
+// saveMetricsForInterval adds the commands aggregating the given metrics for
+// the given interval to the given pipeline.
+func saveMetricsForInterval(pipe redis.Pipeliner, agg AggregationInterval, name string, metrics MetricsRecord) error {
 	if len(metrics.Metrics) == 0 {
 		return nil
 	}
@@ -115,28 +156,20 @@ func SaveMetricsForInterval(ctx context.Context, agg AggregationInterval, name s
 		return fmt.Errorf("unexepcted aggregation interval: %s", agg)
 	}
 
-	key := fmt.Sprintf(metricsKeyTempl, name, agg, ts.Unix())
+	key := GetRedisKey(metricsKeyTempl, name, agg, ts.Unix())
 
-	pipe := RedisClient().TxPipeline()
 	for k, v := range metrics.Metrics {
 		pipe.HIncrByFloat(key, k, v)
 	}
 	pipe.PExpire(key, exp)
-	if _, err := pipe.Exec(); err != nil {
-		return errors.Wrap(err, "exec error")
-	}
-
-	log.WithFields(log.Fields{
-		"name":        name,
-		"aggregation": agg,
-		"ctx_id":      ctx.Value(logging.ContextIDKey),
-	}).Debug("metrics saved")
 
 	return nil
 }
 
 // GetMetrics returns the metrics for the requested aggregation interval.
 func GetMetrics(ctx context.Context, agg AggregationInterval, name string, start, end time.Time) ([]MetricsRecord, error) {
+	defer observeQueryDuration("metrics_get", time.Now())
+
 	var keys []string
 	var timestamps []time.Time
 
@@ -153,7 +186,7 @@ func GetMetrics(ctx context.Context, agg AggregationInterval, name string, start
 				break
 			}
 			timestamps = append(timestamps, ts)
-			keys = append(keys, fmt.Sprintf(metricsKeyTempl, name, agg, ts.Unix()))
+			keys = append(keys, GetRedisKey(metricsKeyTempl, name, agg, ts.Unix()))
 		}
 	case AggregationHour:
 		end = time.Date(end.Year(), end.Month(), end.Day(), end.Hour(), 0, 0, 0, timeLocation)
@@ -163,7 +196,7 @@ func GetMetrics(ctx context.Context, agg AggregationInterval, name string, start
 				break
 			}
 			timestamps = append(timestamps, ts)
-			keys = append(keys, fmt.Sprintf(metricsKeyTempl, name, agg, ts.Unix()))
+			keys = append(keys, GetRedisKey(metricsKeyTempl, name, agg, ts.Unix()))
 		}
 	case AggregationDay:
 		end = time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, timeLocation)
@@ -173,7 +206,7 @@ func GetMetrics(ctx context.Context, agg AggregationInterval, name string, start
 				break
 			}
 			timestamps = append(timestamps, ts)
-			keys = append(keys, fmt.Sprintf(metricsKeyTempl, name, agg, ts.Unix()))
+			keys = append(keys, GetRedisKey(metricsKeyTempl, name, agg, ts.Unix()))
 		}
 	case AggregationMonth:
 		end = time.Date(end.Year(), end.Month(), 1, 0, 0, 0, 0, timeLocation)
@@ -183,7 +216,7 @@ func GetMetrics(ctx context.Context, agg AggregationInterval, name string, start
 				break
 			}
 			timestamps = append(timestamps, ts)
-			keys = append(keys, fmt.Sprintf(metricsKeyTempl, name, agg, ts.Unix()))
+			keys = append(keys, GetRedisKey(metricsKeyTempl, name, agg, ts.Unix()))
 		}
 	default:
 		return nil, fmt.Errorf("unexepcted aggregation interval: %s", agg)
@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/test"
 )
 
 func (ts *StorageTestSuite) TestMetrics() {
@@ -374,3 +376,98 @@ func (ts *StorageTestSuite) TestMetrics() {
 		})
 	}
 }
+
+func (ts *StorageTestSuite) TestSaveMetricsPipelined() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+	RedisClient().FlushAll()
+
+	defer SetAggregationIntervals(aggregationIntervals)
+	assert.NoError(SetTimeLocation("UTC"))
+	assert.NoError(SetAggregationIntervals([]AggregationInterval{AggregationMinute, AggregationDay}))
+	SetMetricsTTL(time.Minute, time.Minute, time.Minute, time.Minute)
+
+	now := time.Now().UTC()
+
+	pipe := RedisClient().TxPipeline()
+	assert.NoError(SaveMetricsPipelined(ctx, pipe, "metrics_test", MetricsRecord{
+		Time:    now,
+		Metrics: map[string]float64{"foo": 1},
+	}))
+	IncrementOrganizationUsagePipelined(ctx, pipe, 1, UsageUplinks, 1)
+
+	// nothing is stored until the pipeline has been executed
+	metrics, err := GetMetrics(ctx, AggregationMinute, "metrics_test", now, now)
+	assert.NoError(err)
+	assert.Len(metrics, 1)
+	assert.Len(metrics[0].Metrics, 0)
+
+	_, err = pipe.Exec()
+	assert.NoError(err)
+
+	for _, agg := range []AggregationInterval{AggregationMinute, AggregationDay} {
+		metrics, err := GetMetrics(ctx, agg, "metrics_test", now, now)
+		assert.NoError(err)
+		assert.Len(metrics, 1)
+		assert.Equal(map[string]float64{"foo": 1}, metrics[0].Metrics)
+	}
+
+	n, err := RedisClient().HGet(GetRedisKey(usageKeyTempl, 1, now.Format(usageDateFormat)), string(UsageUplinks)).Int64()
+	assert.NoError(err)
+	assert.EqualValues(1, n)
+}
+
+// BenchmarkUplinkRedisWrites compares the Redis writes of an uplink (the
+// application metrics for each aggregation interval and the usage counters)
+// executed one by one, with the same writes executed using a single
+// pipeline.
+func BenchmarkUplinkRedisWrites(b *testing.B) {
+	conf := test.GetConfig()
+	if err := Setup(conf); err != nil {
+		b.Fatal(err)
+	}
+
+	ctx := context.Background()
+	intervals := []AggregationInterval{AggregationMinute, AggregationHour, AggregationDay, AggregationMonth}
+	if err := SetAggregationIntervals(intervals); err != nil {
+		b.Fatal(err)
+	}
+	SetMetricsTTL(time.Minute, time.Hour, 24*time.Hour, 31*24*time.Hour)
+
+	record := MetricsRecord{
+		Time: time.Now(),
+		Metrics: map[string]float64{
+			ApplicationMetricRXCount: 1,
+		},
+	}
+
+	b.Run("Sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, agg := range intervals {
+				if err := SaveMetricsForInterval(ctx, agg, "benchmark", record); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if err := IncrementOrganizationUsage(ctx, 1, UsageUplinks, 1); err != nil {
+				b.Fatal(err)
+			}
+			if err := IncrementOrganizationUsage(ctx, 1, UsageStoredBytes, 100); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Pipelined", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			pipe := RedisClient().TxPipeline()
+			if err := SaveMetricsPipelined(ctx, pipe, "benchmark", record); err != nil {
+				b.Fatal(err)
+			}
+			IncrementOrganizationUsagePipelined(ctx, pipe, 1, UsageUplinks, 1)
+			IncrementOrganizationUsagePipelined(ctx, pipe, 1, UsageStoredBytes, 100)
+			if _, err := pipe.Exec(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	migrate "github.com/rubenv/sql-migrate"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/migrations"
+)
+
+// MigrationStatus defines the status of a single schema migration.
+type MigrationStatus struct {
+	ID        string
+	AppliedAt *time.Time
+}
+
+// migrationSource returns the source containing the schema migrations.
+func migrationSource() migrate.MigrationSource {
+	return &migrate.AssetMigrationSource{
+		Asset:    migrations.Asset,
+		AssetDir: migrations.AssetDir,
+		Dir:      "",
+	}
+}
+
+// GetMigrationStatus returns the status of all the known schema migrations
+// of the given namespace (see RegisterMigrationHook), in the order in which
+// they are applied. Migrations that have been applied but are unknown to
+// this version, are returned at the end. Use an empty namespace for the
+// ChirpStack Application Server migrations.
+func GetMigrationStatus(db *sql.DB, namespace string) ([]MigrationStatus, error) {
+	ms, source, err := getMigrationSet(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := source.FindMigrations()
+	if err != nil {
+		return nil, errors.Wrap(err, "find migrations error")
+	}
+
+	records, err := ms.GetMigrationRecords(db, "postgres")
+	if err != nil {
+		return nil, errors.Wrap(err, "get migration records error")
+	}
+
+	applied := make(map[string]time.Time)
+	for _, r := range records {
+		applied[r.Id] = r.AppliedAt
+	}
+
+	var out []MigrationStatus
+	for _, m := range items {
+		s := MigrationStatus{ID: m.Id}
+		if t, ok := applied[m.Id]; ok {
+			s.AppliedAt = &t
+			delete(applied, m.Id)
+		}
+		out = append(out, s)
+	}
+
+	for _, r := range records {
+		if _, ok := applied[r.Id]; ok {
+			t := r.AppliedAt
+			out = append(out, MigrationStatus{ID: r.Id, AppliedAt: &t})
+		}
+	}
+
+	return out, nil
+}
+
+// PlanMigrations returns the IDs of the migrations of the given namespace
+// which would be applied (up) or rolled back (down). When max is 0, all
+// migrations are planned.
+func PlanMigrations(db *sql.DB, namespace string, dir migrate.MigrationDirection, max int) ([]string, error) {
+	ms, source, err := getMigrationSet(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	planned, _, err := ms.PlanMigration(db, "postgres", source, dir, max)
+	if err != nil {
+		return nil, errors.Wrap(err, "plan migration error")
+	}
+
+	var out []string
+	for _, m := range planned {
+		out = append(out, m.Id)
+	}
+	return out, nil
+}
+
+// MigrateUp applies the pending migrations of the given namespace. When max
+// is 0, all pending migrations are applied. It returns the number of applied
+// migrations.
+func MigrateUp(db *sql.DB, namespace string, max int) (int, error) {
+	ms, source, err := getMigrationSet(namespace)
+	if err != nil {
+		return 0, err
+	}
+	return ms.ExecMax(db, "postgres", source, migrate.Up, max)
+}
+
+// MigrateDown rolls back the given number of migrations of the given
+// namespace (most recent first). It returns the number of migrations that
+// were rolled back.
+func MigrateDown(db *sql.DB, namespace string, steps int) (int, error) {
+	if steps <= 0 {
+		return 0, errors.New("the number of steps must be greater than 0")
+	}
+
+	ms, source, err := getMigrationSet(namespace)
+	if err != nil {
+		return 0, err
+	}
+	return ms.ExecMax(db, "postgres", source, migrate.Down, steps)
+}
+
+// DryRunMigrations executes the planned migrations within a single
+// transaction which is always rolled back, so that errors in the migrations
+// are detected without modifying the database. It returns the IDs of the
+// migrations that were executed.
+//
+// Note that the migrations are executed against the actual database, thus
+// locks are taken on the affected tables until the rollback.
+func DryRunMigrations(db *sql.DB, namespace string, dir migrate.MigrationDirection, max int) ([]string, error) {
+	ms, source, err := getMigrationSet(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	planned, _, err := ms.PlanMigration(db, "postgres", source, dir, max)
+	if err != nil {
+		return nil, errors.Wrap(err, "plan migration error")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction error")
+	}
+	defer tx.Rollback()
+
+	var out []string
+	for _, m := range planned {
+		for _, q := range m.Queries {
+			if _, err := tx.Exec(q); err != nil {
+				return out, errors.Wrapf(err, "migration %s error", m.Id)
+			}
+		}
+		out = append(out, m.Id)
+	}
+
+	return out, nil
+}
+
+// DryRunMigrationsOnCopy creates a copy of the current database (using it
+// as template), applies the planned migrations to the copy and drops the
+// copy afterwards. It returns the IDs of the migrations that were applied.
+//
+// Note that PostgreSQL requires that there are no other sessions connected
+// to the template database while it is being copied.
+func DryRunMigrationsOnCopy(namespace string, dir migrate.MigrationDirection, max int) ([]string, error) {
+	if db == nil {
+		return nil, errors.New("storage has not been setup")
+	}
+
+	ms, source, err := getMigrationSet(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var current string
+	if err := db.Get(&current, "select current_database()"); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+	copyName := fmt.Sprintf("%s_migrate_dry_run_%d", current, time.Now().Unix())
+
+	// the copy is created through the maintenance database, as the
+	// template database must not have any connected sessions
+	maintDSN, err := dsnSetParam(postgreSQLDSN, "dbname", "postgres")
+	if err != nil {
+		return nil, errors.Wrap(err, "set dbname error")
+	}
+	maint, err := openPostgreSQL(maintDSN, postgreSQLDialHost)
+	if err != nil {
+		return nil, errors.Wrap(err, "open maintenance database error")
+	}
+	defer maint.Close()
+
+	// close the idle connections of the pool
+	db.SetMaxIdleConns(0)
+
+	if _, err := maint.Exec(fmt.Sprintf("create database %s template %s", pq.QuoteIdentifier(copyName), pq.QuoteIdentifier(current))); err != nil {
+		return nil, handlePSQLError(Insert, err, "create database copy error")
+	}
+	log.WithField("database", copyName).Info("storage: database copy created")
+
+	defer func() {
+		if _, err := maint.Exec(fmt.Sprintf("drop database if exists %s", pq.QuoteIdentifier(copyName))); err != nil {
+			log.WithError(err).WithField("database", copyName).Error("storage: drop database copy error")
+			return
+		}
+		log.WithField("database", copyName).Info("storage: database copy dropped")
+	}()
+
+	dsn, err := dsnSetParam(postgreSQLDSN, "dbname", copyName)
+	if err != nil {
+		return nil, errors.Wrap(err, "set dbname error")
+	}
+	d, err := openPostgreSQL(dsn, postgreSQLDialHost)
+	if err != nil {
+		return nil, errors.Wrap(err, "open database copy error")
+	}
+	defer d.Close()
+
+	planned, err := PlanMigrations(d.DB, namespace, dir, max)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := ms.ExecMax(d.DB, "postgres", source, dir, max); err != nil {
+		return nil, errors.Wrap(err, "apply migrations error")
+	}
+
+	return planned, nil
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	migrate "github.com/rubenv/sql-migrate"
+	log "github.com/sirupsen/logrus"
+)
+
+// migrationsTable defines the table in which the applied migrations of the
+// ChirpStack Application Server are stored.
+const migrationsTable = "gorp_migrations"
+
+var migrationNamespaceRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+var (
+	migrationHooksMux sync.RWMutex
+	migrationHooks    []MigrationHook
+)
+
+// MigrationHook defines the schema migrations and the (optional) seed of a
+// module which is not part of the upstream ChirpStack Application Server,
+// e.g. a fork-specific module.
+//
+// The migrations of each namespace are numbered independently from the
+// upstream migrations and are tracked in their own migrations table, so that
+// these never conflict with upstream schema changes. The migrations of all
+// namespaces are applied after the upstream migrations, in the order in
+// which the hooks were registered.
+type MigrationHook struct {
+	// Namespace of the migrations, e.g. "zones". This must be lowercase
+	// and may only contain letters, digits and underscores.
+	Namespace string
+
+	// Source containing the migrations.
+	Source migrate.MigrationSource
+
+	// Seed is executed within a transaction after the migrations have
+	// been (automatically) applied. As it is executed on every start,
+	// this must be idempotent.
+	Seed func(ctx context.Context, db sqlx.Ext) error
+}
+
+// RegisterMigrationHook registers the given migration hook. This must be
+// called before Setup, typically from the init function of the module
+// package. It panics when the namespace is invalid or when it has already
+// been registered.
+func RegisterMigrationHook(h MigrationHook) {
+	if !migrationNamespaceRegexp.MatchString(h.Namespace) {
+		panic(fmt.Sprintf("storage: invalid migration namespace: %s", h.Namespace))
+	}
+	if h.Source == nil {
+		panic(fmt.Sprintf("storage: migration namespace %s has no source", h.Namespace))
+	}
+
+	migrationHooksMux.Lock()
+	defer migrationHooksMux.Unlock()
+
+	for _, registered := range migrationHooks {
+		if registered.Namespace == h.Namespace {
+			panic(fmt.Sprintf("storage: migration namespace %s is already registered", h.Namespace))
+		}
+	}
+
+	migrationHooks = append(migrationHooks, h)
+}
+
+// GetMigrationNamespaces returns the namespaces of the registered migration
+// hooks, in the order in which these were registered.
+func GetMigrationNamespaces() []string {
+	migrationHooksMux.RLock()
+	defer migrationHooksMux.RUnlock()
+
+	var out []string
+	for _, h := range migrationHooks {
+		out = append(out, h.Namespace)
+	}
+	return out
+}
+
+// MigrateHooksUp applies the pending migrations of all registered hooks,
+// followed by their seed. It returns the number of applied migrations.
+func MigrateHooksUp(ctx context.Context, db *sql.DB) (int, error) {
+	migrationHooksMux.RLock()
+	hooks := append([]MigrationHook(nil), migrationHooks...)
+	migrationHooksMux.RUnlock()
+
+	var count int
+	for _, h := range hooks {
+		n, err := MigrateUp(db, h.Namespace, 0)
+		if err != nil {
+			return count, errors.Wrapf(err, "apply %s migrations error", h.Namespace)
+		}
+		count += n
+
+		log.WithFields(log.Fields{
+			"namespace": h.Namespace,
+			"count":     n,
+		}).Info("storage: namespace migrations applied")
+
+		if err := SeedHook(ctx, h.Namespace); err != nil {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
+
+// SeedHook executes the seed of the given namespace.
+func SeedHook(ctx context.Context, namespace string) error {
+	h, err := getMigrationHook(namespace)
+	if err != nil {
+		return err
+	}
+
+	if h.Seed == nil {
+		return nil
+	}
+
+	err = Transaction(func(tx sqlx.Ext) error {
+		return h.Seed(ctx, tx)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "seed %s error", namespace)
+	}
+
+	return nil
+}
+
+// getMigrationSet returns the migration set and source for the given
+// namespace. The empty namespace refers to the upstream migrations.
+func getMigrationSet(namespace string) (migrate.MigrationSet, migrate.MigrationSource, error) {
+	if namespace == "" {
+		return migrate.MigrationSet{TableName: migrationsTable}, migrationSource(), nil
+	}
+
+	h, err := getMigrationHook(namespace)
+	if err != nil {
+		return migrate.MigrationSet{}, nil, err
+	}
+
+	return migrate.MigrationSet{TableName: migrationsTable + "_" + h.Namespace}, h.Source, nil
+}
+
+func getMigrationHook(namespace string) (MigrationHook, error) {
+	migrationHooksMux.RLock()
+	defer migrationHooksMux.RUnlock()
+
+	for _, h := range migrationHooks {
+		if h.Namespace == namespace {
+			return h, nil
+		}
+	}
+
+	return MigrationHook{}, fmt.Errorf("unknown migration namespace: %s", namespace)
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterMigrationHook(t *testing.T) {
+	defer func() { migrationHooks = nil }()
+
+	source := &migrate.MemoryMigrationSource{}
+
+	t.Run("Valid", func(t *testing.T) {
+		assert := require.New(t)
+		RegisterMigrationHook(MigrationHook{Namespace: "zones", Source: source})
+		assert.Equal([]string{"zones"}, GetMigrationNamespaces())
+	})
+
+	t.Run("Duplicate", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Panics(func() {
+			RegisterMigrationHook(MigrationHook{Namespace: "zones", Source: source})
+		})
+	})
+
+	t.Run("Invalid namespace", func(t *testing.T) {
+		assert := require.New(t)
+		for _, ns := range []string{"", "Zones", "zones-v2", "1zones"} {
+			assert.Panics(func() {
+				RegisterMigrationHook(MigrationHook{Namespace: ns, Source: source})
+			}, ns)
+		}
+	})
+
+	t.Run("Unknown namespace", func(t *testing.T) {
+		assert := require.New(t)
+		_, _, err := getMigrationSet("alarms")
+		assert.Error(err)
+	})
+}
+
+func (ts *StorageTestSuite) TestMigrationHook() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	var seeded int
+	RegisterMigrationHook(MigrationHook{
+		Namespace: "test_hook",
+		Source: &migrate.MemoryMigrationSource{
+			Migrations: []*migrate.Migration{
+				{
+					Id:   "0001_initial.sql",
+					Up:   []string{"create table test_hook_item (id bigserial primary key, name varchar(100) not null unique)"},
+					Down: []string{"drop table test_hook_item"},
+				},
+			},
+		},
+		Seed: func(ctx context.Context, db sqlx.Ext) error {
+			seeded++
+			_, err := db.Exec("insert into test_hook_item (name) values ('default') on conflict (name) do nothing")
+			return err
+		},
+	})
+	defer func() {
+		migrationHooks = nil
+		DB().Exec("drop table if exists test_hook_item")
+		DB().Exec("drop table if exists gorp_migrations_test_hook")
+	}()
+
+	ts.T().Run("Up", func(t *testing.T) {
+		assert := require.New(t)
+
+		n, err := MigrateHooksUp(ctx, DB().DB.DB)
+		assert.NoError(err)
+		assert.Equal(1, n)
+		assert.Equal(1, seeded)
+
+		var count int
+		assert.NoError(sqlx.Get(DB(), &count, "select count(*) from test_hook_item"))
+		assert.Equal(1, count)
+
+		t.Run("Status", func(t *testing.T) {
+			assert := require.New(t)
+
+			items, err := GetMigrationStatus(DB().DB.DB, "test_hook")
+			assert.NoError(err)
+			assert.Len(items, 1)
+			assert.Equal("0001_initial.sql", items[0].ID)
+			assert.NotNil(items[0].AppliedAt)
+
+			// the upstream migrations are not affected
+			ids, err := PlanMigrations(DB().DB.DB, "", migrate.Up, 0)
+			assert.NoError(err)
+			assert.Len(ids, 0)
+		})
+
+		t.Run("Seed is idempotent", func(t *testing.T) {
+			assert := require.New(t)
+
+			n, err := MigrateHooksUp(ctx, DB().DB.DB)
+			assert.NoError(err)
+			assert.Equal(0, n)
+			assert.Equal(2, seeded)
+
+			var count int
+			assert.NoError(sqlx.Get(DB(), &count, "select count(*) from test_hook_item"))
+			assert.Equal(1, count)
+		})
+
+		t.Run("Down", func(t *testing.T) {
+			assert := require.New(t)
+
+			n, err := MigrateDown(DB().DB.DB, "test_hook", 1)
+			assert.NoError(err)
+			assert.Equal(1, n)
+
+			items, err := GetMigrationStatus(DB().DB.DB, "test_hook")
+			assert.NoError(err)
+			assert.Nil(items[0].AppliedAt)
+		})
+	})
+
+	assert.Len(GetMigrationNamespaces(), 1)
+}
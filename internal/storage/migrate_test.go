@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"testing"
+
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/stretchr/testify/require"
+)
+
+func (ts *StorageTestSuite) TestMigrations() {
+	ts.T().Run("Status", func(t *testing.T) {
+		assert := require.New(t)
+
+		items, err := GetMigrationStatus(DB().DB.DB, "")
+		assert.NoError(err)
+		assert.True(len(items) > 0)
+		assert.Equal("0001_initial.sql", items[0].ID)
+
+		for _, item := range items {
+			assert.NotNil(item.AppliedAt, item.ID)
+		}
+	})
+
+	ts.T().Run("Plan up", func(t *testing.T) {
+		assert := require.New(t)
+
+		ids, err := PlanMigrations(DB().DB.DB, "", migrate.Up, 0)
+		assert.NoError(err)
+		assert.Len(ids, 0)
+	})
+
+	ts.T().Run("Dry-run down", func(t *testing.T) {
+		assert := require.New(t)
+
+		items, err := GetMigrationStatus(DB().DB.DB, "")
+		assert.NoError(err)
+		last := items[len(items)-1].ID
+
+		ids, err := DryRunMigrations(DB().DB.DB, "", migrate.Down, 1)
+		assert.NoError(err)
+		assert.Equal([]string{last}, ids)
+
+		// nothing has been rolled back
+		ids, err = PlanMigrations(DB().DB.DB, "", migrate.Up, 0)
+		assert.NoError(err)
+		assert.Len(ids, 0)
+	})
+
+	ts.T().Run("Down requires steps", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := MigrateDown(DB().DB.DB, "", 0)
+		assert.Error(err)
+	})
+}
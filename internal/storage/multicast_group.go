@@ -497,3 +497,73 @@ func GetDevicesForMulticastGroup(ctx context.Context, db sqlx.Queryer, multicast
 
 	return devices, nil
 }
+
+// GetDevEUIsForMulticastGroup returns the DevEUIs of the devices of the given
+// multicast-group.
+func GetDevEUIsForMulticastGroup(ctx context.Context, db sqlx.Queryer, multicastGroupID uuid.UUID) ([]lorawan.EUI64, error) {
+	var devEUIs []lorawan.EUI64
+
+	err := sqlx.Select(db, &devEUIs, `
+		select
+			dev_eui
+		from
+			device_multicast_group
+		where
+			multicast_group_id = $1
+		order by
+			dev_eui
+	`, multicastGroupID)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return devEUIs, nil
+}
+
+// MulticastGroupDeviceReception defines the (aggregated) reception of the
+// uplinks of a multicast-group device by a gateway.
+type MulticastGroupDeviceReception struct {
+	DevEUI     lorawan.EUI64 `db:"dev_eui"`
+	GatewayID  lorawan.EUI64 `db:"gateway_id"`
+	Frames     int           `db:"frames"`
+	MaxLoRaSNR float64       `db:"max_lora_snr"`
+	MaxRSSI    int           `db:"max_rssi"`
+}
+
+// GetMulticastGroupDeviceReceptions returns per device and gateway the
+// receptions of the uplinks of the multicast-group devices since the given
+// timestamp, based on the RX metadata of the device frame-log.
+func GetMulticastGroupDeviceReceptions(ctx context.Context, db sqlx.Queryer, multicastGroupID uuid.UUID, since time.Time) ([]MulticastGroupDeviceReception, error) {
+	defer observeQueryDuration("multicast_group_device_receptions_get", time.Now())
+
+	var out []MulticastGroupDeviceReception
+
+	err := sqlx.Select(db, &out, `
+		select
+			fl.dev_eui,
+			decode(rx->>'gatewayID', 'hex') as gateway_id,
+			count(*) as frames,
+			max((rx->>'loRaSNR')::float) as max_lora_snr,
+			max((rx->>'rssi')::integer) as max_rssi
+		from
+			device_frame_log fl
+		inner join device_multicast_group dmg
+			on dmg.dev_eui = fl.dev_eui
+		cross join lateral jsonb_array_elements(fl.rx_info) rx
+		where
+			dmg.multicast_group_id = $1
+			and fl.received_at >= $2
+			and fl.rx_info is not null
+		group by
+			fl.dev_eui,
+			rx->>'gatewayID'
+		order by
+			fl.dev_eui,
+			gateway_id
+	`, multicastGroupID, since)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
@@ -404,6 +404,29 @@ func GetNetworkServerForServiceProfileID(ctx context.Context, db sqlx.Queryer, i
 	return n, nil
 }
 
+// GetNetworkServerForApplicationID returns the network-server for the given
+// application id (through the service-profile of the application).
+func GetNetworkServerForApplicationID(ctx context.Context, db sqlx.Queryer, id int64) (NetworkServer, error) {
+	var n NetworkServer
+	err := sqlx.Get(db, &n, `
+		select
+			ns.*
+		from
+			network_server ns
+		inner join service_profile sp
+			on sp.network_server_id = ns.id
+		inner join application a
+			on a.service_profile_id = sp.service_profile_id
+		where
+			a.id = $1`,
+		id,
+	)
+	if err != nil {
+		return n, handlePSQLError(Select, err, "select error")
+	}
+	return n, nil
+}
+
 // GetNetworkServerForGatewayMAC returns the network-server for a given
 // gateway mac.
 func GetNetworkServerForGatewayMAC(ctx context.Context, db sqlx.Queryer, mac lorawan.EUI64) (NetworkServer, error) {
@@ -20,6 +20,14 @@ func getNSClientForServiceProfile(ctx context.Context, db sqlx.Queryer, id uuid.
 	return getNSClient(n)
 }
 
+func getNSClientForApplication(ctx context.Context, db sqlx.Queryer, id int64) (ns.NetworkServerServiceClient, error) {
+	n, err := GetNetworkServerForApplicationID(ctx, db, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "get network-server error")
+	}
+	return getNSClient(n)
+}
+
 func getNSClientForMulticastGroup(ctx context.Context, db sqlx.Queryer, id uuid.UUID) (ns.NetworkServerServiceClient, error) {
 	n, err := GetNetworkServerForMulticastGroupID(ctx, db, id)
 	if err != nil {
@@ -155,6 +155,36 @@ func (ts *StorageTestSuite) TestNetworkServer() {
 			assert.NoError(DeleteServiceProfile(context.Background(), ts.Tx(), spID))
 		})
 
+		t.Run("Get for ApplicationID", func(t *testing.T) {
+			assert := require.New(t)
+
+			sp := ServiceProfile{
+				Name:            "test-sp",
+				OrganizationID:  org.ID,
+				NetworkServerID: n.ID,
+			}
+			assert.NoError(CreateServiceProfile(context.Background(), ts.Tx(), &sp))
+			var spID uuid.UUID
+			copy(spID[:], sp.ServiceProfile.Id)
+
+			app := Application{
+				Name:             "test-app",
+				OrganizationID:   org.ID,
+				ServiceProfileID: spID,
+			}
+			assert.NoError(CreateApplication(context.Background(), ts.Tx(), &app))
+
+			nsGet, err := GetNetworkServerForApplicationID(context.Background(), ts.Tx(), app.ID)
+			assert.NoError(err)
+			assert.Equal(n.ID, nsGet.ID)
+
+			_, err = GetNetworkServerForApplicationID(context.Background(), ts.Tx(), app.ID+1)
+			assert.Equal(ErrDoesNotExist, err)
+
+			assert.NoError(DeleteApplication(context.Background(), ts.Tx(), app.ID))
+			assert.NoError(DeleteServiceProfile(context.Background(), ts.Tx(), spID))
+		})
+
 		t.Run("Update", func(t *testing.T) {
 			assert := require.New(t)
 
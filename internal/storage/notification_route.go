@@ -0,0 +1,293 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+)
+
+// NotificationRoute defines a notification route of an organization. When
+// an automation raises an alarm with a severity of at least MinSeverity,
+// the alarm is notified over the Channel to the Recipients, e.g. critical
+// alarms by SMS to the on-call phone and all alarms in-app. When
+// ApplicationID is set, the route only applies to the alarms of that
+// application, else to the alarms of all the applications of the
+// organization.
+type NotificationRoute struct {
+	ID             uuid.UUID      `db:"id"`
+	OrganizationID int64          `db:"organization_id"`
+	ApplicationID  *int64         `db:"application_id"`
+	CreatedAt      time.Time      `db:"created_at"`
+	UpdatedAt      time.Time      `db:"updated_at"`
+	Name           string         `db:"name"`
+	MinSeverity    spec.Severity  `db:"min_severity"`
+	Channel        spec.Channel   `db:"channel"`
+	Recipients     pq.StringArray `db:"recipients"`
+}
+
+// Validate validates the notification route data.
+func (r NotificationRoute) Validate() error {
+	if strings.TrimSpace(r.Name) == "" || len(r.Name) > 100 {
+		return ErrNotificationRouteInvalidName
+	}
+
+	if err := r.MinSeverity.Validate(); err != nil {
+		return errors.Wrap(ErrNotificationRouteInvalidLevel, err.Error())
+	}
+
+	if err := r.Channel.Validate(r.Recipients); err != nil {
+		return errors.Wrap(ErrNotificationRouteInvalidChannel, err.Error())
+	}
+
+	return nil
+}
+
+// Actions returns the actions delivering the notification with the given
+// subject over the channel of the route.
+func (r NotificationRoute) Actions(subject string) spec.Actions {
+	return r.Channel.Actions(r.Recipients, subject)
+}
+
+// NotificationRouteFilters provides filters for filtering notification
+// routes.
+type NotificationRouteFilters struct {
+	OrganizationID int64 `db:"organization_id"`
+
+	// Limit and Offset are added for convenience so that this struct can
+	// be given as the arguments.
+	Limit  int `db:"limit"`
+	Offset int `db:"offset"`
+}
+
+// SQL returns the SQL filters.
+func (f NotificationRouteFilters) SQL() string {
+	return "where organization_id = :organization_id"
+}
+
+// CreateNotificationRoute creates the given notification route.
+func CreateNotificationRoute(ctx context.Context, db sqlx.Execer, r *NotificationRoute) error {
+	if err := r.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	var err error
+	r.ID, err = uuid.NewV4()
+	if err != nil {
+		return errors.Wrap(err, "new uuid v4 error")
+	}
+
+	now := time.Now()
+	r.CreatedAt = now
+	r.UpdatedAt = now
+
+	if r.Recipients == nil {
+		r.Recipients = pq.StringArray{}
+	}
+
+	_, err = db.Exec(`
+		insert into notification_route (
+			id,
+			organization_id,
+			application_id,
+			created_at,
+			updated_at,
+			name,
+			min_severity,
+			channel,
+			recipients
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		r.ID,
+		r.OrganizationID,
+		r.ApplicationID,
+		r.CreatedAt,
+		r.UpdatedAt,
+		r.Name,
+		r.MinSeverity,
+		r.Channel,
+		r.Recipients,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"id":              r.ID,
+		"organization_id": r.OrganizationID,
+		"ctx_id":          ctx.Value(logging.ContextIDKey),
+	}).Info("notification route created")
+
+	return nil
+}
+
+// GetNotificationRoute returns the notification route for the given id.
+func GetNotificationRoute(ctx context.Context, db sqlx.Queryer, id uuid.UUID) (NotificationRoute, error) {
+	var r NotificationRoute
+	if err := sqlx.Get(db, &r, "select * from notification_route where id = $1", id); err != nil {
+		return r, handlePSQLError(Select, err, "select error")
+	}
+
+	return r, nil
+}
+
+// GetNotificationRouteCount returns the number of notification routes
+// matching the given filters.
+func GetNotificationRouteCount(ctx context.Context, db sqlx.Queryer, filters NotificationRouteFilters) (int, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			count(*)
+		from
+			notification_route
+		`+filters.SQL(), filters)
+	if err != nil {
+		return 0, errors.Wrap(err, "named query error")
+	}
+
+	var count int
+	if err := sqlx.Get(db, &count, query, args...); err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	return count, nil
+}
+
+// GetNotificationRoutes returns the notification routes matching the given
+// filters, sorted by name.
+func GetNotificationRoutes(ctx context.Context, db sqlx.Queryer, filters NotificationRouteFilters) ([]NotificationRoute, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			*
+		from
+			notification_route
+		`+filters.SQL()+`
+		order by
+			name,
+			id
+		limit :limit
+		offset :offset`, filters)
+	if err != nil {
+		return nil, errors.Wrap(err, "named query error")
+	}
+
+	var out []NotificationRoute
+	if err := sqlx.Select(db, &out, query, args...); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetNotificationRoutesForAlarm returns the notification routes applying to
+// an alarm of the given application and severity, sorted by name. These are
+// the routes of the application and the routes of its organization without
+// application, of which the min. severity is met.
+func GetNotificationRoutesForAlarm(ctx context.Context, db sqlx.Queryer, applicationID int64, severity spec.Severity) ([]NotificationRoute, error) {
+	var routes []NotificationRoute
+	err := sqlx.Select(db, &routes, `
+		select
+			r.*
+		from
+			notification_route r
+		inner join application a
+			on a.organization_id = r.organization_id
+		where
+			a.id = $1
+			and (r.application_id is null or r.application_id = a.id)
+		order by
+			r.name,
+			r.id`,
+		applicationID,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	var out []NotificationRoute
+	for _, r := range routes {
+		if severity.AtLeast(r.MinSeverity) {
+			out = append(out, r)
+		}
+	}
+
+	return out, nil
+}
+
+// UpdateNotificationRoute updates the given notification route.
+func UpdateNotificationRoute(ctx context.Context, db sqlx.Execer, r *NotificationRoute) error {
+	if err := r.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	r.UpdatedAt = time.Now()
+
+	if r.Recipients == nil {
+		r.Recipients = pq.StringArray{}
+	}
+
+	res, err := db.Exec(`
+		update notification_route
+		set
+			application_id = $2,
+			updated_at = $3,
+			name = $4,
+			min_severity = $5,
+			channel = $6,
+			recipients = $7
+		where
+			id = $1`,
+		r.ID,
+		r.ApplicationID,
+		r.UpdatedAt,
+		r.Name,
+		r.MinSeverity,
+		r.Channel,
+		r.Recipients,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"id":     r.ID,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("notification route updated")
+
+	return nil
+}
+
+// DeleteNotificationRoute deletes the notification route.
+func DeleteNotificationRoute(ctx context.Context, db sqlx.Execer, id uuid.UUID) error {
+	res, err := db.Exec("delete from notification_route where id = $1", id)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"id":     id,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("notification route deleted")
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+)
+
+func (ts *StorageTestSuite) TestNotificationRoute() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(ctx, ts.Tx(), &org))
+
+	n := NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(CreateNetworkServer(ctx, ts.Tx(), &n))
+
+	sp := ServiceProfile{
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+		Name:            "test-sp",
+	}
+	assert.NoError(CreateServiceProfile(ctx, ts.Tx(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	apps := []Application{
+		{Name: "test-app-1", OrganizationID: org.ID, ServiceProfileID: spID},
+		{Name: "test-app-2", OrganizationID: org.ID, ServiceProfileID: spID},
+	}
+	for i := range apps {
+		assert.NoError(CreateApplication(ctx, ts.Tx(), &apps[i]))
+	}
+
+	routes := []NotificationRoute{
+		{
+			OrganizationID: org.ID,
+			Name:           "in-app",
+			MinSeverity:    spec.InfoSeverity,
+			Channel:        spec.InAppChannel,
+		},
+		{
+			OrganizationID: org.ID,
+			ApplicationID:  &apps[0].ID,
+			Name:           "on-call",
+			MinSeverity:    spec.CriticalSeverity,
+			Channel:        spec.SMSChannel,
+			Recipients:     []string{"+31612345678"},
+		},
+	}
+
+	ts.T().Run("Create invalid", func(t *testing.T) {
+		assert := require.New(t)
+
+		invalid := routes[1]
+		invalid.Name = ""
+		assert.Equal(ErrNotificationRouteInvalidName, errors.Cause(CreateNotificationRoute(ctx, ts.Tx(), &invalid)))
+
+		invalid = routes[1]
+		invalid.MinSeverity = "FATAL"
+		assert.Equal(ErrNotificationRouteInvalidLevel, errors.Cause(CreateNotificationRoute(ctx, ts.Tx(), &invalid)))
+
+		invalid = routes[1]
+		invalid.Recipients = []string{"0612345678"}
+		assert.Equal(ErrNotificationRouteInvalidChannel, errors.Cause(CreateNotificationRoute(ctx, ts.Tx(), &invalid)))
+
+		invalid = routes[0]
+		invalid.Recipients = []string{"+31612345678"}
+		assert.Equal(ErrNotificationRouteInvalidChannel, errors.Cause(CreateNotificationRoute(ctx, ts.Tx(), &invalid)))
+	})
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		for i := range routes {
+			assert.NoError(CreateNotificationRoute(ctx, ts.Tx(), &routes[i]))
+		}
+
+		rGet, err := GetNotificationRoute(ctx, ts.Tx(), routes[1].ID)
+		assert.NoError(err)
+		assert.Equal(routes[1].Name, rGet.Name)
+		assert.Equal(apps[0].ID, *rGet.ApplicationID)
+		assert.Equal(spec.CriticalSeverity, rGet.MinSeverity)
+		assert.Equal(spec.SMSChannel, rGet.Channel)
+		assert.EqualValues([]string{"+31612345678"}, rGet.Recipients)
+
+		filters := NotificationRouteFilters{
+			OrganizationID: org.ID,
+			Limit:          10,
+		}
+
+		count, err := GetNotificationRouteCount(ctx, ts.Tx(), filters)
+		assert.NoError(err)
+		assert.Equal(2, count)
+
+		items, err := GetNotificationRoutes(ctx, ts.Tx(), filters)
+		assert.NoError(err)
+		assert.Len(items, 2)
+		assert.Equal(routes[0].ID, items[0].ID)
+		assert.Equal(routes[1].ID, items[1].ID)
+	})
+
+	ts.T().Run("For alarm", func(t *testing.T) {
+		tests := []struct {
+			name          string
+			applicationID int64
+			severity      spec.Severity
+			expected      []uuid.UUID
+		}{
+			{"info", apps[0].ID, spec.InfoSeverity, []uuid.UUID{routes[0].ID}},
+			{"critical", apps[0].ID, spec.CriticalSeverity, []uuid.UUID{routes[0].ID, routes[1].ID}},
+			{"critical other application", apps[1].ID, spec.CriticalSeverity, []uuid.UUID{routes[0].ID}},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.name, func(t *testing.T) {
+				assert := require.New(t)
+
+				items, err := GetNotificationRoutesForAlarm(ctx, ts.Tx(), tst.applicationID, tst.severity)
+				assert.NoError(err)
+
+				var ids []uuid.UUID
+				for _, r := range items {
+					ids = append(ids, r.ID)
+				}
+				assert.Equal(tst.expected, ids)
+			})
+		}
+	})
+
+	ts.T().Run("Update", func(t *testing.T) {
+		assert := require.New(t)
+
+		routes[1].ApplicationID = nil
+		routes[1].MinSeverity = spec.WarningSeverity
+		assert.NoError(UpdateNotificationRoute(ctx, ts.Tx(), &routes[1]))
+
+		rGet, err := GetNotificationRoute(ctx, ts.Tx(), routes[1].ID)
+		assert.NoError(err)
+		assert.Nil(rGet.ApplicationID)
+		assert.Equal(spec.WarningSeverity, rGet.MinSeverity)
+
+		items, err := GetNotificationRoutesForAlarm(ctx, ts.Tx(), apps[1].ID, spec.WarningSeverity)
+		assert.NoError(err)
+		assert.Len(items, 2)
+	})
+
+	ts.T().Run("Delete", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(DeleteNotificationRoute(ctx, ts.Tx(), routes[1].ID))
+
+		_, err := GetNotificationRoute(ctx, ts.Tx(), routes[1].ID)
+		assert.Equal(ErrDoesNotExist, errors.Cause(err))
+		assert.Equal(ErrDoesNotExist, errors.Cause(DeleteNotificationRoute(ctx, ts.Tx(), routes[1].ID)))
+	})
+}
@@ -259,6 +259,12 @@ func DeleteOrganization(ctx context.Context, db sqlx.Ext, id int64) error {
 		return errors.Wrap(err, "delete all device-profiles error")
 	}
 
+	if schemaPerOrganization {
+		if err := deleteOrganizationSchema(ctx, db, id); err != nil {
+			return errors.Wrap(err, "delete organization schema error")
+		}
+	}
+
 	res, err := db.Exec("delete from organization where id = $1", id)
 	if err != nil {
 		return handlePSQLError(Delete, err, "delete error")
@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	//"github.com/brocaar/lorawan"
+
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+)
+
+// organizationSchemaPrefix defines the prefix of the organization schemas.
+const organizationSchemaPrefix = "org_"
+
+// organizationSchemaTables contains the organization scoped tables which are
+// created within the schema of each organization when the
+// schema-per-organization mode is enabled.
+var organizationSchemaTables = []struct {
+	Name         string
+	PartitionKey string
+	Indexes      [][]string
+}{
+	{
+		Name:         "device_frame_log",
+		PartitionKey: "received_at",
+		Indexes: [][]string{
+			{"dev_eui", "received_at"},
+			{"application_id", "received_at"},
+		},
+	},
+	{
+		Name:         "device_metric",
+		PartitionKey: "time",
+		Indexes: [][]string{
+			{"dev_eui", "name", "time"},
+			{"application_id", "time"},
+		},
+	},
+}
+
+var (
+	schemaPerOrganization bool
+
+	// organizationSchemas contains the schemas which are known to exist.
+	organizationSchemas sync.Map
+)
+
+// OrganizationSchema returns the PostgreSQL schema name of the given
+// organization.
+func OrganizationSchema(organizationID int64) string {
+	return fmt.Sprintf("%s%d", organizationSchemaPrefix, organizationID)
+}
+
+// ForOrganization routes the queries executed by f to the schema of the
+// given organization, by setting the search_path to the organization schema
+// followed by the public schema. Tables which are not organization scoped are
+// therefore still resolved to the public schema.
+//
+// When the schema-per-organization mode is disabled, f is called with the
+// given db. When db is not a transaction, a transaction is started as the
+// search_path is set for the duration of the transaction only.
+func ForOrganization(ctx context.Context, db sqlx.Ext, organizationID int64, f func(db sqlx.Ext) error) error {
+	if !schemaPerOrganization {
+		return f(db)
+	}
+
+	if err := ensureOrganizationSchema(ctx, organizationID); err != nil {
+		return errors.Wrap(err, "ensure organization schema error")
+	}
+
+	return forSchema(db, OrganizationSchema(organizationID), f)
+}
+
+// ForDeviceOrganization calls ForOrganization with the organization of the
+// given device. The organization is only looked up when the
+// schema-per-organization mode is enabled.
+func ForDeviceOrganization(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, f func(db sqlx.Ext) error) error {
+	if !schemaPerOrganization {
+		return f(db)
+	}
+
+	var organizationID int64
+	err := sqlx.Get(db, &organizationID, `
+		select
+			a.organization_id
+		from
+			device d
+		inner join application a
+			on a.id = d.application_id
+		where
+			d.dev_eui = $1`,
+		devEUI[:],
+	)
+	if err != nil {
+		return handlePSQLError(Select, err, "select error")
+	}
+
+	return ForOrganization(ctx, db, organizationID, f)
+}
+
+// ForEachOrganization calls f for the public schema and for the schema of
+// each organization, for jobs which operate on the organization scoped
+// tables of all organizations. The public schema is included as it might
+// still contain the data stored before the schema-per-organization mode was
+// enabled.
+//
+// When the schema-per-organization mode is disabled, f is called once with
+// the given db.
+func ForEachOrganization(ctx context.Context, db sqlx.Ext, f func(db sqlx.Ext) error) error {
+	if !schemaPerOrganization {
+		return f(db)
+	}
+
+	schemas, err := getOrganizationSchemas(db)
+	if err != nil {
+		return errors.Wrap(err, "get organization schemas error")
+	}
+
+	if err := f(db); err != nil {
+		return err
+	}
+
+	for _, schema := range schemas {
+		if err := forSchema(db, schema, f); err != nil {
+			return errors.Wrapf(err, "schema %s error", schema)
+		}
+	}
+
+	return nil
+}
+
+// forSchema calls f with the search_path set to the given schema followed
+// by the public schema.
+func forSchema(db sqlx.Ext, schema string, f func(db sqlx.Ext) error) error {
+	if _, ok := db.(*DBLogger); ok {
+		return Transaction(func(tx sqlx.Ext) error {
+			if _, err := tx.Exec("select set_config('search_path', $1, true)", schema+", public"); err != nil {
+				return handlePSQLError(Update, err, "set search_path error")
+			}
+			return f(tx)
+		})
+	}
+
+	// db is a transaction, restore the search_path afterwards as the
+	// transaction might be used for other organizations
+	var searchPath string
+	if err := sqlx.Get(db, &searchPath, "select current_setting('search_path')"); err != nil {
+		return handlePSQLError(Select, err, "select search_path error")
+	}
+	if _, err := db.Exec("select set_config('search_path', $1, true)", schema+", public"); err != nil {
+		return handlePSQLError(Update, err, "set search_path error")
+	}
+	defer func() {
+		if _, err := db.Exec("select set_config('search_path', $1, true)", searchPath); err != nil {
+			log.WithError(err).Error("storage: restore search_path error")
+		}
+	}()
+
+	return f(db)
+}
+
+// ensureOrganizationSchema creates the schema of the given organization,
+// including the organization scoped tables and their partitions, when it does
+// not yet exist. This is executed outside the transaction of the caller, so
+// that a rollback of the caller does not leave the cache in an invalid state.
+func ensureOrganizationSchema(ctx context.Context, organizationID int64) error {
+	schema := OrganizationSchema(organizationID)
+	if _, ok := organizationSchemas.Load(schema); ok {
+		return nil
+	}
+
+	err := Transaction(func(tx sqlx.Ext) error {
+		// serialize the creation of the same schema by multiple instances
+		if _, err := tx.Exec("select pg_advisory_xact_lock(hashtext($1))", schema); err != nil {
+			return handlePSQLError(Select, err, "advisory lock error")
+		}
+
+		if _, err := tx.Exec("create schema if not exists " + schema); err != nil {
+			return handlePSQLError(Insert, err, "create schema error")
+		}
+
+		for _, t := range organizationSchemaTables {
+			_, err := tx.Exec(fmt.Sprintf("create table if not exists %s.%s (like public.%s including defaults including constraints) partition by range (%s)",
+				schema,
+				t.Name,
+				t.Name,
+				t.PartitionKey,
+			))
+			if err != nil {
+				return handlePSQLError(Insert, err, "create table error")
+			}
+
+			for _, columns := range t.Indexes {
+				_, err := tx.Exec(fmt.Sprintf("create index if not exists idx_%s_%s on %s.%s(%s)",
+					t.Name,
+					strings.Join(columns, "_"),
+					schema,
+					t.Name,
+					strings.Join(columns, ", "),
+				))
+				if err != nil {
+					return handlePSQLError(Insert, err, "create index error")
+				}
+			}
+		}
+
+		return maintainSchemaPartitions(ctx, tx, schema, time.Now())
+	})
+	if err != nil {
+		return err
+	}
+
+	organizationSchemas.Store(schema, struct{}{})
+
+	log.WithFields(log.Fields{
+		"organization_id": organizationID,
+		"schema":          schema,
+		"ctx_id":          ctx.Value(logging.ContextIDKey),
+	}).Info("storage: organization schema ready")
+
+	return nil
+}
+
+// deleteOrganizationSchema drops the schema of the given organization,
+// including all its data.
+func deleteOrganizationSchema(ctx context.Context, db sqlx.Execer, organizationID int64) error {
+	schema := OrganizationSchema(organizationID)
+
+	if _, err := db.Exec("drop schema if exists " + schema + " cascade"); err != nil {
+		return handlePSQLError(Delete, err, "drop schema error")
+	}
+	organizationSchemas.Delete(schema)
+
+	log.WithFields(log.Fields{
+		"organization_id": organizationID,
+		"schema":          schema,
+		"ctx_id":          ctx.Value(logging.ContextIDKey),
+	}).Info("storage: organization schema deleted")
+
+	return nil
+}
+
+// getOrganizationSchemas returns the names of the organization schemas.
+func getOrganizationSchemas(db sqlx.Queryer) ([]string, error) {
+	var schemas []string
+	err := sqlx.Select(db, &schemas, `
+		select
+			nspname
+		from
+			pg_namespace
+		where
+			nspname ~ $1
+		order by
+			nspname`,
+		"^"+organizationSchemaPrefix+"[0-9]+$",
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return schemas, nil
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestOrganizationSchema() {
+	ctx := context.Background()
+	devEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	now := time.Now()
+
+	// the organization schemas are created outside the test transaction,
+	// use a separate transaction so that it can be rolled back before
+	// dropping the schemas
+	tx, err := DB().Beginx()
+	if err != nil {
+		panic(err)
+	}
+
+	schemaPerOrganization = true
+	defer func() {
+		schemaPerOrganization = false
+		tx.Rollback()
+		for _, id := range []int64{1, 2} {
+			deleteOrganizationSchema(ctx, DB(), id)
+		}
+	}()
+
+	ts.T().Run("Organization schema name", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Equal("org_1", OrganizationSchema(1))
+	})
+
+	ts.T().Run("Create frame-log in organization schema", func(t *testing.T) {
+		assert := require.New(t)
+
+		err := ForOrganization(ctx, tx, 1, func(db sqlx.Ext) error {
+			return CreateDeviceFrameLog(ctx, db, DeviceFrameLog{
+				DevEUI:     devEUI,
+				ReceivedAt: now,
+			})
+		})
+		assert.NoError(err)
+
+		schemas, err := getOrganizationSchemas(tx)
+		assert.NoError(err)
+		assert.Contains(schemas, "org_1")
+
+		t.Run("Visible within organization", func(t *testing.T) {
+			assert := require.New(t)
+			var logs []DeviceFrameLog
+			err := ForOrganization(ctx, tx, 1, func(db sqlx.Ext) error {
+				var err error
+				logs, err = GetDeviceFrameLogs(ctx, db, devEUI, now.Add(-time.Minute), now.Add(time.Minute), 10)
+				return err
+			})
+			assert.NoError(err)
+			assert.Len(logs, 1)
+		})
+
+		t.Run("Not visible within other organization", func(t *testing.T) {
+			assert := require.New(t)
+			var logs []DeviceFrameLog
+			err := ForOrganization(ctx, tx, 2, func(db sqlx.Ext) error {
+				var err error
+				logs, err = GetDeviceFrameLogs(ctx, db, devEUI, now.Add(-time.Minute), now.Add(time.Minute), 10)
+				return err
+			})
+			assert.NoError(err)
+			assert.Len(logs, 0)
+		})
+
+		t.Run("Not visible within public schema", func(t *testing.T) {
+			assert := require.New(t)
+			logs, err := GetDeviceFrameLogs(ctx, tx, devEUI, now.Add(-time.Minute), now.Add(time.Minute), 10)
+			assert.NoError(err)
+			assert.Len(logs, 0)
+		})
+
+		t.Run("Visible for each organization", func(t *testing.T) {
+			assert := require.New(t)
+			var count int
+			err := ForEachOrganization(ctx, tx, func(db sqlx.Ext) error {
+				logs, err := GetDeviceFrameLogs(ctx, db, devEUI, now.Add(-time.Minute), now.Add(time.Minute), 10)
+				count += len(logs)
+				return err
+			})
+			assert.NoError(err)
+			assert.Equal(1, count)
+		})
+	})
+
+	ts.T().Run("Delete organization schema", func(t *testing.T) {
+		assert := require.New(t)
+		assert.NoError(deleteOrganizationSchema(ctx, tx, 1))
+
+		schemas, err := getOrganizationSchemas(tx)
+		assert.NoError(err)
+		assert.NotContains(schemas, "org_1")
+	})
+}
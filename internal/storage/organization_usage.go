@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+)
+
+const (
+	usageKeyTempl     = "lora:as:usage:{%d}:%s" // (organization id | date)
+	usageOrgsKeyTempl = "lora:as:usage:orgs:%s" // (date)
+	usageDateFormat   = "2006-01-02"
+)
+
+// usageTTL defines how long the usage counters are kept in Redis. The
+// counters are flushed to PostgreSQL periodically, this only needs to cover
+// the flushing of the previous day.
+const usageTTL = time.Hour * 24 * 7
+
+// UsageKind defines the kind of metered usage.
+type UsageKind string
+
+// Available usage kinds. The values equal the organization_usage columns.
+const (
+	UsageUplinks          UsageKind = "uplinks"
+	UsageDownlinks        UsageKind = "downlinks"
+	UsageStoredBytes      UsageKind = "stored_bytes"
+	UsageGeolocationCalls UsageKind = "geolocation_calls"
+)
+
+// OrganizationUsage defines the usage of an organization for a (UTC) day.
+type OrganizationUsage struct {
+	OrganizationID   int64     `db:"organization_id"`
+	Date             time.Time `db:"date"`
+	Uplinks          int64     `db:"uplinks"`
+	Downlinks        int64     `db:"downlinks"`
+	StoredBytes      int64     `db:"stored_bytes"`
+	GeolocationCalls int64     `db:"geolocation_calls"`
+}
+
+// IncrementOrganizationUsage increments the usage counter of the given kind
+// for the given organization and the current (UTC) day.
+func IncrementOrganizationUsage(ctx context.Context, organizationID int64, kind UsageKind, n int64) error {
+	pipe := RedisClient().TxPipeline()
+	IncrementOrganizationUsagePipelined(ctx, pipe, organizationID, kind, n)
+
+	if _, err := pipe.Exec(); err != nil {
+		return errors.Wrap(err, "exec error")
+	}
+
+	return nil
+}
+
+// IncrementOrganizationUsagePipelined adds the commands incrementing the
+// usage counter of the given kind for the given organization to the given
+// pipeline. The counter is incremented when the pipeline is executed by the
+// caller.
+func IncrementOrganizationUsagePipelined(ctx context.Context, pipe redis.Pipeliner, organizationID int64, kind UsageKind, n int64) {
+	if n == 0 {
+		return
+	}
+
+	date := time.Now().UTC().Format(usageDateFormat)
+	key := GetRedisKey(usageKeyTempl, organizationID, date)
+	orgsKey := GetRedisKey(usageOrgsKeyTempl, date)
+
+	pipe.HIncrBy(key, string(kind), n)
+	pipe.PExpire(key, usageTTL)
+	pipe.SAdd(orgsKey, organizationID)
+	pipe.PExpire(orgsKey, usageTTL)
+}
+
+// IncrementApplicationUsage increments the usage counter of the given kind
+// for the organization of the given application.
+func IncrementApplicationUsage(ctx context.Context, applicationID int64, kind UsageKind, n int64) error {
+	app, err := GetApplicationCached(ctx, DB(), applicationID)
+	if err != nil {
+		return errors.Wrap(err, "get application error")
+	}
+
+	return IncrementOrganizationUsage(ctx, app.OrganizationID, kind, n)
+}
+
+// FlushOrganizationUsage stores the usage counters of the given (UTC) day
+// from Redis into the organization_usage table. As the counters hold the
+// totals of the day, flushing the same day multiple times is safe.
+func FlushOrganizationUsage(ctx context.Context, db sqlx.Execer, day time.Time) error {
+	defer observeQueryDuration("organization_usage_flush", time.Now())
+
+	date := day.UTC().Format(usageDateFormat)
+
+	orgIDs, err := RedisClient().SMembers(GetRedisKey(usageOrgsKeyTempl, date)).Result()
+	if err != nil {
+		return errors.Wrap(err, "get organization ids error")
+	}
+
+	for _, s := range orgIDs {
+		orgID, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return errors.Wrap(err, "parse organization id error")
+		}
+
+		counters, err := RedisClient().HGetAll(GetRedisKey(usageKeyTempl, orgID, date)).Result()
+		if err != nil {
+			return errors.Wrap(err, "get usage counters error")
+		}
+
+		var values [4]int64
+		for i, kind := range []UsageKind{UsageUplinks, UsageDownlinks, UsageStoredBytes, UsageGeolocationCalls} {
+			if v, ok := counters[string(kind)]; ok {
+				values[i], err = strconv.ParseInt(v, 10, 64)
+				if err != nil {
+					return errors.Wrapf(err, "parse %s counter error", kind)
+				}
+			}
+		}
+
+		_, err = db.Exec(`
+			insert into organization_usage (
+				organization_id,
+				date,
+				uplinks,
+				downlinks,
+				stored_bytes,
+				geolocation_calls
+			) values ($1, $2, $3, $4, $5, $6)
+			on conflict (organization_id, date) do update
+			set
+				uplinks = excluded.uplinks,
+				downlinks = excluded.downlinks,
+				stored_bytes = excluded.stored_bytes,
+				geolocation_calls = excluded.geolocation_calls`,
+			orgID,
+			date,
+			values[0],
+			values[1],
+			values[2],
+			values[3],
+		)
+		if err != nil {
+			err = handlePSQLError(Insert, err, "insert error")
+			// the organization might have been deleted in the meantime
+			if err == ErrDoesNotExist {
+				continue
+			}
+			return err
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"date":          date,
+		"organizations": len(orgIDs),
+		"ctx_id":        ctx.Value(logging.ContextIDKey),
+	}).Debug("organization usage flushed")
+
+	return nil
+}
+
+// GetOrganizationUsage returns the daily usage records between start and end
+// (inclusive). When organizationID is 0, the records of all organizations
+// are returned.
+func GetOrganizationUsage(ctx context.Context, db sqlx.Queryer, organizationID int64, start, end time.Time) ([]OrganizationUsage, error) {
+	defer observeQueryDuration("organization_usage_get", time.Now())
+
+	var out []OrganizationUsage
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			organization_usage
+		where
+			($1::bigint = 0 or organization_id = $1)
+			and date >= $2
+			and date <= $3
+		order by
+			organization_id,
+			date`,
+		organizationID,
+		start.UTC().Format(usageDateFormat),
+		end.UTC().Format(usageDateFormat),
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func (ts *StorageTestSuite) TestOrganizationUsage() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+	RedisClient().FlushAll()
+
+	org1 := Organization{Name: "org-1"}
+	assert.NoError(CreateOrganization(ctx, ts.Tx(), &org1))
+	org2 := Organization{Name: "org-2"}
+	assert.NoError(CreateOrganization(ctx, ts.Tx(), &org2))
+
+	assert.NoError(IncrementOrganizationUsage(ctx, org1.ID, UsageUplinks, 1))
+	assert.NoError(IncrementOrganizationUsage(ctx, org1.ID, UsageUplinks, 1))
+	assert.NoError(IncrementOrganizationUsage(ctx, org1.ID, UsageStoredBytes, 120))
+	assert.NoError(IncrementOrganizationUsage(ctx, org2.ID, UsageDownlinks, 3))
+	assert.NoError(IncrementOrganizationUsage(ctx, org2.ID, UsageGeolocationCalls, 1))
+
+	now := time.Now()
+
+	// flushing twice must not double the counters
+	assert.NoError(FlushOrganizationUsage(ctx, ts.Tx(), now))
+	assert.NoError(FlushOrganizationUsage(ctx, ts.Tx(), now))
+
+	ts.T().Run("Organization", func(t *testing.T) {
+		assert := require.New(t)
+
+		usage, err := GetOrganizationUsage(ctx, ts.Tx(), org1.ID, now, now)
+		assert.NoError(err)
+		assert.Len(usage, 1)
+		assert.Equal(org1.ID, usage[0].OrganizationID)
+		assert.EqualValues(2, usage[0].Uplinks)
+		assert.EqualValues(0, usage[0].Downlinks)
+		assert.EqualValues(120, usage[0].StoredBytes)
+		assert.EqualValues(0, usage[0].GeolocationCalls)
+	})
+
+	ts.T().Run("All organizations", func(t *testing.T) {
+		assert := require.New(t)
+
+		usage, err := GetOrganizationUsage(ctx, ts.Tx(), 0, now, now)
+		assert.NoError(err)
+		assert.Len(usage, 2)
+		assert.Equal(org2.ID, usage[1].OrganizationID)
+		assert.EqualValues(3, usage[1].Downlinks)
+		assert.EqualValues(1, usage[1].GeolocationCalls)
+	})
+
+	ts.T().Run("Out of range", func(t *testing.T) {
+		assert := require.New(t)
+
+		usage, err := GetOrganizationUsage(ctx, ts.Tx(), 0, now.AddDate(0, 0, -10), now.AddDate(0, 0, -1))
+		assert.NoError(err)
+		assert.Len(usage, 0)
+	})
+}
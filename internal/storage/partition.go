@@ -0,0 +1,314 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+)
+
+// PartitionInterval defines the time-range covered by a single partition.
+type PartitionInterval string
+
+// Partition intervals.
+const (
+	PartitionDay   PartitionInterval = "DAY"
+	PartitionMonth PartitionInterval = "MONTH"
+)
+
+// partitionedTables contains the tables which are partitioned by time.
+var partitionedTables = []string{
+	"device_frame_log",
+	"device_metric",
+}
+
+var (
+	partitionInterval      = PartitionDay
+	partitionPremake       = 3
+	partitionRetention     time.Duration
+	partitionCheckInterval = time.Hour
+)
+
+// partition defines a single time-range partition of a table.
+type partition struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+func setupPartitioning(c config.Config) error {
+	conf := c.PostgreSQL.Partitioning
+
+	switch PartitionInterval(strings.ToUpper(conf.Interval)) {
+	case "", PartitionDay:
+		partitionInterval = PartitionDay
+	case PartitionMonth:
+		partitionInterval = PartitionMonth
+	default:
+		return fmt.Errorf("invalid partition interval: %s", conf.Interval)
+	}
+
+	if conf.Premake > 0 {
+		partitionPremake = conf.Premake
+	}
+	if conf.CheckInterval > 0 {
+		partitionCheckInterval = conf.CheckInterval
+	}
+	partitionRetention = conf.Retention
+
+	return nil
+}
+
+// PartitionMaintenanceLoop periodically creates the upcoming partitions and
+// drops the partitions which are beyond the configured retention. Each run
+// is executed using the given job function (e.g. leader.Do).
+func PartitionMaintenanceLoop(do JobFunc) {
+	for {
+		time.Sleep(partitionCheckInterval)
+
+		ctxID, err := uuid.NewV4()
+		if err != nil {
+			log.WithError(err).Error("new uuid error")
+		}
+
+		ctx := context.Background()
+		ctx = context.WithValue(ctx, logging.ContextIDKey, ctxID)
+
+		err = do(ctx, "partition_maintenance", func(ctx context.Context) error {
+			return MaintainPartitions(ctx, DB(), time.Now())
+		})
+		if err != nil {
+			log.WithError(err).Error("storage: maintain partitions error")
+		}
+	}
+}
+
+// MaintainPartitions makes sure that the partitions for the current and the
+// upcoming periods exist for all partitioned tables and drops the partitions
+// of which all data is older than the configured retention. When the
+// schema-per-organization mode is enabled, this includes the partitioned
+// tables within the organization schemas.
+func MaintainPartitions(ctx context.Context, db sqlx.Ext, now time.Time) error {
+	schemas := []string{"public"}
+	if schemaPerOrganization {
+		orgSchemas, err := getOrganizationSchemas(db)
+		if err != nil {
+			return errors.Wrap(err, "get organization schemas error")
+		}
+		schemas = append(schemas, orgSchemas...)
+	}
+
+	for _, schema := range schemas {
+		if err := maintainSchemaPartitions(ctx, db, schema, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func maintainSchemaPartitions(ctx context.Context, db sqlx.Ext, schema string, now time.Time) error {
+	for _, table := range partitionedTables {
+		if err := createPartitions(ctx, db, schema, table, now); err != nil {
+			return errors.Wrapf(err, "create partitions for %s.%s error", schema, table)
+		}
+
+		if partitionRetention != 0 {
+			if err := dropPartitions(ctx, db, schema, table, now.Add(-partitionRetention)); err != nil {
+				return errors.Wrapf(err, "drop partitions for %s.%s error", schema, table)
+			}
+		}
+	}
+
+	return nil
+}
+
+func createPartitions(ctx context.Context, db sqlx.Ext, schema, table string, now time.Time) error {
+	existing, err := getPartitions(db, schema, table)
+	if err != nil {
+		return errors.Wrap(err, "get partitions error")
+	}
+
+	start := partitionStart(partitionInterval, now)
+	for i := 0; i <= partitionPremake; i++ {
+		p := newPartition(table, partitionInterval, start)
+		start = p.End
+
+		// skip the ranges covered by an existing partition, e.g. after
+		// the partition interval has been changed
+		if partitionOverlaps(existing, p) {
+			continue
+		}
+
+		_, err := db.Exec(fmt.Sprintf(`create table if not exists %s.%s partition of %s.%s for values from ('%s') to ('%s')`,
+			schema,
+			p.Name,
+			schema,
+			table,
+			p.Start.Format(time.RFC3339),
+			p.End.Format(time.RFC3339),
+		))
+		if err != nil {
+			return handlePSQLError(Insert, err, "create partition error")
+		}
+
+		log.WithFields(log.Fields{
+			"schema":    schema,
+			"table":     table,
+			"partition": p.Name,
+			"ctx_id":    ctx.Value(logging.ContextIDKey),
+		}).Info("storage: partition created")
+	}
+
+	return nil
+}
+
+func dropPartitions(ctx context.Context, db sqlx.Ext, schema, table string, before time.Time) error {
+	existing, err := getPartitions(db, schema, table)
+	if err != nil {
+		return errors.Wrap(err, "get partitions error")
+	}
+
+	for _, p := range existing {
+		if p.End.After(before) {
+			continue
+		}
+
+		if _, err := db.Exec(fmt.Sprintf("drop table if exists %s.%s", schema, p.Name)); err != nil {
+			return handlePSQLError(Delete, err, "drop partition error")
+		}
+
+		log.WithFields(log.Fields{
+			"schema":    schema,
+			"table":     table,
+			"partition": p.Name,
+			"ctx_id":    ctx.Value(logging.ContextIDKey),
+		}).Info("storage: partition dropped")
+	}
+
+	return nil
+}
+
+// getPartitions returns the partitions of the given table which have been
+// created by createPartitions. Partitions which have been created by hand
+// (not matching the naming convention) are ignored.
+func getPartitions(db sqlx.Queryer, schema, table string) ([]partition, error) {
+	var names []string
+	err := sqlx.Select(db, &names, `
+		select
+			c.relname
+		from
+			pg_inherits i
+		inner join pg_class c
+			on c.oid = i.inhrelid
+		inner join pg_class p
+			on p.oid = i.inhparent
+		inner join pg_namespace n
+			on n.oid = p.relnamespace
+		where
+			n.nspname = $1
+			and p.relname = $2
+		order by
+			c.relname`,
+		schema,
+		table,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	var out []partition
+	for _, name := range names {
+		p, ok := parsePartitionName(table, name)
+		if !ok {
+			continue
+		}
+		out = append(out, p)
+	}
+
+	return out, nil
+}
+
+// partitionStart returns the start of the period containing t.
+func partitionStart(interval PartitionInterval, t time.Time) time.Time {
+	t = t.UTC()
+	switch interval {
+	case PartitionMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// newPartition returns the partition for the given table and period start.
+// The period is encoded in the partition name so that existing partitions
+// can be mapped back to their time-range, e.g. device_metric_d20200130 or
+// device_metric_m202001.
+func newPartition(table string, interval PartitionInterval, start time.Time) partition {
+	switch interval {
+	case PartitionMonth:
+		return partition{
+			Name:  fmt.Sprintf("%s_m%s", table, start.Format("200601")),
+			Start: start,
+			End:   start.AddDate(0, 1, 0),
+		}
+	default:
+		return partition{
+			Name:  fmt.Sprintf("%s_d%s", table, start.Format("20060102")),
+			Start: start,
+			End:   start.AddDate(0, 0, 1),
+		}
+	}
+}
+
+func parsePartitionName(table, name string) (partition, bool) {
+	if !strings.HasPrefix(name, table+"_") {
+		return partition{}, false
+	}
+	suffix := strings.TrimPrefix(name, table+"_")
+	if len(suffix) < 2 {
+		return partition{}, false
+	}
+
+	var interval PartitionInterval
+	var layout string
+	switch suffix[0] {
+	case 'd':
+		interval = PartitionDay
+		layout = "20060102"
+	case 'm':
+		interval = PartitionMonth
+		layout = "200601"
+	default:
+		return partition{}, false
+	}
+
+	start, err := time.ParseInLocation(layout, suffix[1:], time.UTC)
+	if err != nil {
+		return partition{}, false
+	}
+
+	p := newPartition(table, interval, start)
+	if p.Name != name {
+		return partition{}, false
+	}
+
+	return p, true
+}
+
+func partitionOverlaps(existing []partition, p partition) bool {
+	for _, e := range existing {
+		if p.Start.Before(e.End) && e.Start.Before(p.End) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePartitionName(t *testing.T) {
+	tests := []struct {
+		Name      string
+		Partition partition
+		OK        bool
+	}{
+		{
+			Name: "device_metric_d20200130",
+			Partition: partition{
+				Name:  "device_metric_d20200130",
+				Start: time.Date(2020, 1, 30, 0, 0, 0, 0, time.UTC),
+				End:   time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC),
+			},
+			OK: true,
+		},
+		{
+			Name: "device_metric_m202012",
+			Partition: partition{
+				Name:  "device_metric_m202012",
+				Start: time.Date(2020, 12, 1, 0, 0, 0, 0, time.UTC),
+				End:   time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+			},
+			OK: true,
+		},
+		{
+			Name: "device_metric_default",
+		},
+		{
+			Name: "device_frame_log_d20200130",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			p, ok := parsePartitionName("device_metric", tst.Name)
+			assert.Equal(tst.OK, ok)
+			assert.Equal(tst.Partition, p)
+		})
+	}
+}
+
+func TestPartitionOverlaps(t *testing.T) {
+	assert := require.New(t)
+
+	month := newPartition("device_metric", PartitionMonth, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	existing := []partition{month}
+
+	assert.True(partitionOverlaps(existing, newPartition("device_metric", PartitionDay, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))))
+	assert.True(partitionOverlaps(existing, newPartition("device_metric", PartitionDay, time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC))))
+	assert.False(partitionOverlaps(existing, newPartition("device_metric", PartitionDay, time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC))))
+}
+
+func (ts *StorageTestSuite) TestMaintainPartitions() {
+	ctx := context.Background()
+	now := time.Date(2020, 1, 30, 12, 0, 0, 0, time.UTC)
+
+	partitionInterval = PartitionDay
+	partitionPremake = 2
+	partitionRetention = 0
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+		assert.NoError(MaintainPartitions(ctx, ts.Tx(), now))
+
+		parts, err := getPartitions(ts.Tx(), "public", "device_metric")
+		assert.NoError(err)
+		assert.Len(parts, 3)
+		assert.Equal("device_metric_d20200130", parts[0].Name)
+		assert.Equal("device_metric_d20200201", parts[2].Name)
+	})
+
+	ts.T().Run("Drop", func(t *testing.T) {
+		assert := require.New(t)
+		partitionRetention = 24 * time.Hour
+		defer func() { partitionRetention = 0 }()
+
+		assert.NoError(MaintainPartitions(ctx, ts.Tx(), now.Add(48*time.Hour)))
+
+		parts, err := getPartitions(ts.Tx(), "public", "device_metric")
+		assert.NoError(err)
+		assert.Equal("device_metric_d20200131", parts[0].Name)
+	})
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+
+	"github.com/lib/pq/hstore"
+)
+
+// dsnWithPgBouncerMode enables the binary_parameters connection option of
+// the lib/pq driver when PgBouncer mode is enabled. With this option, a query
+// with parameters is sent as a single Parse / Bind / Execute / Sync sequence
+// using the unnamed statement, instead of first preparing the statement in
+// a separate round-trip. Within a PgBouncer transaction-pooling setup, the
+// latter fails when the server connection changes between the two
+// round-trips.
+func dsnWithPgBouncerMode(dsn string, enabled bool) (string, error) {
+	if !enabled {
+		return dsn, nil
+	}
+
+	return dsnSetParam(dsn, "binary_parameters", "yes")
+}
+
+// pgBouncerConn wraps the lib/pq connection when PgBouncer mode is enabled.
+//
+// With the binary_parameters option, lib/pq sends all []byte parameters in
+// the binary format. This is correct for bytea columns, but not for values
+// that are encoded as text and which are also passed as []byte, like hstore
+// and (raw) JSON values. These are converted to string before they are
+// passed to the driver, so that they are sent in the text format.
+type pgBouncerConn struct {
+	driver.Conn
+}
+
+// CheckNamedValue implements the driver.NamedValueChecker interface.
+func (c pgBouncerConn) CheckNamedValue(nv *driver.NamedValue) error {
+	switch v := nv.Value.(type) {
+	case hstore.Hstore:
+		return pgBouncerTextValue(nv, v)
+	case *hstore.Hstore:
+		if v == nil {
+			break
+		}
+		return pgBouncerTextValue(nv, v)
+	case json.RawMessage:
+		if v == nil {
+			nv.Value = nil
+		} else {
+			nv.Value = string(v)
+		}
+		return nil
+	}
+
+	// fallback to the default conversion
+	return driver.ErrSkip
+}
+
+// QueryContext implements the driver.QueryerContext interface.
+func (c pgBouncerConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+}
+
+// ExecContext implements the driver.ExecerContext interface.
+func (c pgBouncerConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+// BeginTx implements the driver.ConnBeginTx interface.
+func (c pgBouncerConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+// Ping implements the driver.Pinger interface.
+func (c pgBouncerConn) Ping(ctx context.Context) error {
+	return c.Conn.(driver.Pinger).Ping(ctx)
+}
+
+func pgBouncerTextValue(nv *driver.NamedValue, v driver.Valuer) error {
+	val, err := v.Value()
+	if err != nil {
+		return err
+	}
+
+	if b, ok := val.([]byte); ok {
+		val = string(b)
+	}
+	nv.Value = val
+
+	return nil
+}
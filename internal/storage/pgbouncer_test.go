@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"testing"
+
+	"github.com/lib/pq/hstore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDSNWithPgBouncerMode(t *testing.T) {
+	tests := []struct {
+		Name    string
+		DSN     string
+		Enabled bool
+		Out     string
+	}{
+		{
+			Name: "disabled",
+			DSN:  "postgres://localhost/chirpstack_as?sslmode=disable",
+			Out:  "postgres://localhost/chirpstack_as?sslmode=disable",
+		},
+		{
+			Name:    "url format",
+			DSN:     "postgres://localhost/chirpstack_as?sslmode=disable",
+			Enabled: true,
+			Out:     "postgres://localhost/chirpstack_as?binary_parameters=yes&sslmode=disable",
+		},
+		{
+			Name:    "key=value format",
+			DSN:     "user=chirpstack_as dbname=chirpstack_as sslmode=disable",
+			Enabled: true,
+			Out:     "user=chirpstack_as dbname=chirpstack_as sslmode=disable binary_parameters=yes",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			out, err := dsnWithPgBouncerMode(tst.DSN, tst.Enabled)
+			assert.NoError(err)
+			assert.Equal(tst.Out, out)
+		})
+	}
+}
+
+func TestPgBouncerConnCheckNamedValue(t *testing.T) {
+	tags := hstore.Hstore{
+		Map: map[string]sql.NullString{
+			"foo": {String: "bar", Valid: true},
+		},
+	}
+
+	tests := []struct {
+		Name  string
+		Value interface{}
+		Out   interface{}
+		Err   error
+	}{
+		{"hstore", tags, `"foo"=>"bar"`, nil},
+		{"hstore pointer", &tags, `"foo"=>"bar"`, nil},
+		{"null hstore", hstore.Hstore{}, nil, nil},
+		{"raw json", json.RawMessage(`{"foo":"bar"}`), `{"foo":"bar"}`, nil},
+		{"null raw json", json.RawMessage(nil), nil, nil},
+		{"bytea", []byte{1, 2, 3}, []byte{1, 2, 3}, driver.ErrSkip},
+		{"string", "foo", "foo", driver.ErrSkip},
+	}
+
+	var c pgBouncerConn
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			nv := driver.NamedValue{Value: tst.Value}
+			assert.Equal(tst.Err, c.CheckNamedValue(&nv))
+			if tst.Err == nil {
+				assert.Equal(tst.Out, nv.Value)
+			}
+		})
+	}
+}
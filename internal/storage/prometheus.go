@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	qd = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "storage_query_duration_seconds",
+		Help:    "The duration of the storage queries (per query name).",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"query"})
+
+	cl = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_cache_lookup_count",
+		Help: "The number of local cache lookups (per kind of item and result: hit or miss). Each miss results in a PostgreSQL query.",
+	}, []string{"kind", "result"})
+
+	bwd = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_batch_writer_dropped_count",
+		Help: "The number of device frame-logs and metrics dropped by the batch writer (per reason: buffer_full or write_error).",
+	}, []string{"reason"})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "storage_cache_size",
+		Help: "The number of items in the local cache.",
+	}, func() float64 {
+		return float64(cacheSize())
+	})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "storage_postgresql_pool_max_open_connections",
+		Help: "The maximum number of open connections to the PostgreSQL database.",
+	}, func() float64 {
+		return float64(dbStats().MaxOpenConnections)
+	})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "storage_postgresql_pool_open_connections",
+		Help: "The number of established connections (in use and idle) to the PostgreSQL database.",
+	}, func() float64 {
+		return float64(dbStats().OpenConnections)
+	})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "storage_postgresql_pool_in_use_connections",
+		Help: "The number of PostgreSQL connections currently in use.",
+	}, func() float64 {
+		return float64(dbStats().InUse)
+	})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "storage_postgresql_pool_idle_connections",
+		Help: "The number of idle PostgreSQL connections.",
+	}, func() float64 {
+		return float64(dbStats().Idle)
+	})
+
+	_ = promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "storage_postgresql_pool_wait_count",
+		Help: "The total number of PostgreSQL connections waited for.",
+	}, func() float64 {
+		return float64(dbStats().WaitCount)
+	})
+
+	_ = promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "storage_postgresql_pool_wait_duration_seconds",
+		Help: "The total time blocked waiting for a new PostgreSQL connection.",
+	}, func() float64 {
+		return dbStats().WaitDuration.Seconds()
+	})
+
+	_ = promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "storage_postgresql_pool_max_idle_closed_count",
+		Help: "The total number of PostgreSQL connections closed due to the max. idle connections setting.",
+	}, func() float64 {
+		return float64(dbStats().MaxIdleClosed)
+	})
+
+	_ = promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "storage_postgresql_pool_max_lifetime_closed_count",
+		Help: "The total number of PostgreSQL connections closed due to the connection max. lifetime setting.",
+	}, func() float64 {
+		return float64(dbStats().MaxLifetimeClosed)
+	})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "storage_batch_writer_queue_size",
+		Help: "The number of device frame-logs and metrics buffered by the batch writer.",
+	}, func() float64 {
+		return float64(batchWriterQueueSize())
+	})
+)
+
+// queryDuration returns the observer for the duration of the given (named)
+// query.
+func queryDuration(q string) prometheus.Observer {
+	return qd.With(prometheus.Labels{"query": q})
+}
+
+// cacheLookupCounter returns the counter for the local cache lookups of the
+// given kind of item and result.
+func cacheLookupCounter(kind, result string) prometheus.Counter {
+	return cl.With(prometheus.Labels{"kind": kind, "result": result})
+}
+
+// batchWriterDroppedCounter returns the counter for the rows dropped by the
+// batch writer for the given reason.
+func batchWriterDroppedCounter(reason string) prometheus.Counter {
+	return bwd.With(prometheus.Labels{"reason": reason})
+}
+
+// observeQueryDuration observes the duration of the given (named) query,
+// which was started at the given time. This is intended to be deferred.
+func observeQueryDuration(q string, start time.Time) {
+	queryDuration(q).Observe(float64(time.Since(start)) / float64(time.Second))
+}
+
+// dbStats returns the PostgreSQL connection pool statistics. It returns
+// empty statistics when the storage package has not been setup yet.
+func dbStats() sql.DBStats {
+	if db == nil {
+		return sql.DBStats{}
+	}
+	return db.Stats()
+}
+
+// batchWriterQueueSize returns the number of buffered batch writer items.
+// It returns 0 when batched writes are disabled.
+func batchWriterQueueSize() int {
+	if bw == nil {
+		return 0
+	}
+
+	bw.Lock()
+	defer bw.Unlock()
+	return bw.count + bw.retryCount
+}
+
+// cacheSize returns the number of items in the local cache. It returns 0
+// when the cache is disabled.
+func cacheSize() int {
+	c := localCache
+	if c == nil {
+		return 0
+	}
+	return c.len()
+}
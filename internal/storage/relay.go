@@ -0,0 +1,541 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+// RelayMaxDevices defines the max. number of end-devices which can be
+// attached to a relay (the size of the relay uplink list).
+const RelayMaxDevices = 16
+
+// RelayMaxFilters defines the max. number of filter rules of a relay (the
+// size of the relay join-request filter list).
+const RelayMaxFilters = 16
+
+// Relay filter actions.
+const (
+	RelayFilterNoRule  uint8 = 0
+	RelayFilterForward uint8 = 1
+	RelayFilterFilter  uint8 = 2
+)
+
+// Relay end-device modes (relay mode activation).
+const (
+	RelayEndDeviceDisabled uint8 = 0
+	RelayEndDeviceEnabled  uint8 = 1
+	RelayEndDeviceDynamic  uint8 = 2
+	RelayEndDeviceSmart    uint8 = 3
+)
+
+// Relay defines the configuration of a relay (LoRaWAN TS011), an end-device
+// which forwards the uplinks of the end-devices in its range. The second
+// channel is disabled when SecondChannelFreq is 0.
+type Relay struct {
+	DevEUI                 lorawan.EUI64 `db:"dev_eui"`
+	CreatedAt              time.Time     `db:"created_at"`
+	UpdatedAt              time.Time     `db:"updated_at"`
+	Enabled                bool          `db:"enabled"`
+	CADPeriodicity         uint8         `db:"cad_periodicity"`
+	DefaultChannelIndex    uint8         `db:"default_channel_index"`
+	SecondChannelFreq      uint32        `db:"second_channel_freq"`
+	SecondChannelDR        uint8         `db:"second_channel_dr"`
+	SecondChannelACKOffset uint8         `db:"second_channel_ack_offset"`
+}
+
+// Validate validates the relay data.
+func (r Relay) Validate() error {
+	if r.CADPeriodicity > 5 {
+		return ErrRelayInvalidCADPeriodicity
+	}
+
+	if r.DefaultChannelIndex > 1 {
+		return ErrRelayInvalidDefaultChannel
+	}
+
+	return validateRelaySecondChannel(r.SecondChannelFreq, r.SecondChannelDR, r.SecondChannelACKOffset)
+}
+
+// RelayDevice defines an end-device attached to a relay.
+type RelayDevice struct {
+	RelayDevEUI lorawan.EUI64 `db:"relay_dev_eui"`
+	DevEUI      lorawan.EUI64 `db:"dev_eui"`
+	CreatedAt   time.Time     `db:"created_at"`
+	DeviceName  string        `db:"device_name"`
+}
+
+// RelayFilter defines a join-request filter rule of a relay. The rule
+// matches the join-requests of which the JoinEUI and DevEUI start with the
+// configured JoinEUI and DevEUI. A rule without JoinEUI matches all
+// join-requests, the rule at index 0 is the default rule.
+type RelayFilter struct {
+	RelayDevEUI lorawan.EUI64  `db:"relay_dev_eui"`
+	Index       uint8          `db:"filter_index"`
+	CreatedAt   time.Time      `db:"created_at"`
+	UpdatedAt   time.Time      `db:"updated_at"`
+	Action      uint8          `db:"action"`
+	JoinEUI     *lorawan.EUI64 `db:"join_eui"`
+	DevEUI      *lorawan.EUI64 `db:"dev_eui"`
+}
+
+// Validate validates the relay filter data.
+func (f RelayFilter) Validate() error {
+	if f.Index >= RelayMaxFilters {
+		return ErrRelayInvalidFilterIndex
+	}
+
+	if f.Action > RelayFilterFilter {
+		return ErrRelayInvalidFilterAction
+	}
+
+	if f.DevEUI != nil && f.JoinEUI == nil {
+		return ErrRelayInvalidFilterEUI
+	}
+
+	return nil
+}
+
+// RelayEndDevice defines the relay configuration of a relay capable
+// end-device. The second channel is disabled when SecondChannelFreq is 0.
+type RelayEndDevice struct {
+	DevEUI                 lorawan.EUI64 `db:"dev_eui"`
+	CreatedAt              time.Time     `db:"created_at"`
+	UpdatedAt              time.Time     `db:"updated_at"`
+	Mode                   uint8         `db:"mode"`
+	SmartEnableLevel       uint8         `db:"smart_enable_level"`
+	Backoff                uint8         `db:"backoff"`
+	SecondChannelFreq      uint32        `db:"second_channel_freq"`
+	SecondChannelDR        uint8         `db:"second_channel_dr"`
+	SecondChannelACKOffset uint8         `db:"second_channel_ack_offset"`
+}
+
+// Validate validates the relay end-device data.
+func (d RelayEndDevice) Validate() error {
+	if d.Mode > RelayEndDeviceSmart {
+		return ErrRelayEndDeviceInvalidMode
+	}
+
+	if d.SmartEnableLevel > 3 {
+		return ErrRelayEndDeviceInvalidSmartLevel
+	}
+
+	if d.Backoff > 63 {
+		return ErrRelayEndDeviceInvalidBackoff
+	}
+
+	return validateRelaySecondChannel(d.SecondChannelFreq, d.SecondChannelDR, d.SecondChannelACKOffset)
+}
+
+// validateRelaySecondChannel validates the second channel settings. The
+// frequency is sent in steps of 100 Hz using 3 bytes.
+func validateRelaySecondChannel(freq uint32, dr, ackOffset uint8) error {
+	if freq%100 != 0 || freq/100 >= 1<<24 || dr > 15 || ackOffset > 5 {
+		return ErrRelayInvalidSecondChannel
+	}
+	return nil
+}
+
+// CreateRelay creates the given relay.
+func CreateRelay(ctx context.Context, db sqlx.Execer, r *Relay) error {
+	if err := r.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	now := time.Now()
+	r.CreatedAt = now
+	r.UpdatedAt = now
+
+	_, err := db.Exec(`
+		insert into relay (
+			dev_eui,
+			created_at,
+			updated_at,
+			enabled,
+			cad_periodicity,
+			default_channel_index,
+			second_channel_freq,
+			second_channel_dr,
+			second_channel_ack_offset
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		r.DevEUI[:],
+		r.CreatedAt,
+		r.UpdatedAt,
+		r.Enabled,
+		r.CADPeriodicity,
+		r.DefaultChannelIndex,
+		r.SecondChannelFreq,
+		r.SecondChannelDR,
+		r.SecondChannelACKOffset,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": r.DevEUI,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("relay created")
+
+	return nil
+}
+
+// GetRelay returns the relay for the given DevEUI.
+func GetRelay(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64) (Relay, error) {
+	var r Relay
+	if err := sqlx.Get(db, &r, "select * from relay where dev_eui = $1", devEUI[:]); err != nil {
+		return r, handlePSQLError(Select, err, "select error")
+	}
+
+	return r, nil
+}
+
+// UpdateRelay updates the given relay.
+func UpdateRelay(ctx context.Context, db sqlx.Execer, r *Relay) error {
+	if err := r.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	r.UpdatedAt = time.Now()
+
+	res, err := db.Exec(`
+		update relay
+		set
+			updated_at = $2,
+			enabled = $3,
+			cad_periodicity = $4,
+			default_channel_index = $5,
+			second_channel_freq = $6,
+			second_channel_dr = $7,
+			second_channel_ack_offset = $8
+		where
+			dev_eui = $1`,
+		r.DevEUI[:],
+		r.UpdatedAt,
+		r.Enabled,
+		r.CADPeriodicity,
+		r.DefaultChannelIndex,
+		r.SecondChannelFreq,
+		r.SecondChannelDR,
+		r.SecondChannelACKOffset,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": r.DevEUI,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("relay updated")
+
+	return nil
+}
+
+// DeleteRelay deletes the relay, its attached end-devices and filters.
+func DeleteRelay(ctx context.Context, db sqlx.Execer, devEUI lorawan.EUI64) error {
+	res, err := db.Exec("delete from relay where dev_eui = $1", devEUI[:])
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": devEUI,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("relay deleted")
+
+	return nil
+}
+
+// AddRelayDevice attaches the given end-device to the relay. It returns
+// ErrRelayMaxDevices when the relay already has the max. number of attached
+// end-devices.
+func AddRelayDevice(ctx context.Context, db sqlx.Execer, relayDevEUI, devEUI lorawan.EUI64) error {
+	if relayDevEUI == devEUI {
+		return ErrRelayInvalidDevice
+	}
+
+	res, err := db.Exec(`
+		insert into relay_device (
+			relay_dev_eui,
+			dev_eui,
+			created_at
+		)
+		select
+			$1, $2, $3
+		where
+			(select count(*) from relay_device where relay_dev_eui = $1) < $4`,
+		relayDevEUI[:],
+		devEUI[:],
+		time.Now(),
+		RelayMaxDevices,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrRelayMaxDevices
+	}
+
+	log.WithFields(log.Fields{
+		"relay_dev_eui": relayDevEUI,
+		"dev_eui":       devEUI,
+		"ctx_id":        ctx.Value(logging.ContextIDKey),
+	}).Info("relay device added")
+
+	return nil
+}
+
+// GetRelayDevices returns the end-devices attached to the given relay,
+// sorted by DevEUI.
+func GetRelayDevices(ctx context.Context, db sqlx.Queryer, relayDevEUI lorawan.EUI64) ([]RelayDevice, error) {
+	var out []RelayDevice
+	err := sqlx.Select(db, &out, `
+		select
+			rd.relay_dev_eui,
+			rd.dev_eui,
+			rd.created_at,
+			d.name as device_name
+		from
+			relay_device rd
+		inner join device d
+			on d.dev_eui = rd.dev_eui
+		where
+			rd.relay_dev_eui = $1
+		order by
+			rd.dev_eui`,
+		relayDevEUI[:],
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// RemoveRelayDevice detaches the given end-device from the relay.
+func RemoveRelayDevice(ctx context.Context, db sqlx.Execer, relayDevEUI, devEUI lorawan.EUI64) error {
+	res, err := db.Exec("delete from relay_device where relay_dev_eui = $1 and dev_eui = $2", relayDevEUI[:], devEUI[:])
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"relay_dev_eui": relayDevEUI,
+		"dev_eui":       devEUI,
+		"ctx_id":        ctx.Value(logging.ContextIDKey),
+	}).Info("relay device removed")
+
+	return nil
+}
+
+// SetRelayFilter creates or updates the filter rule at the index of the
+// given filter.
+func SetRelayFilter(ctx context.Context, db sqlx.Execer, f *RelayFilter) error {
+	if err := f.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	now := time.Now()
+	f.CreatedAt = now
+	f.UpdatedAt = now
+
+	_, err := db.Exec(`
+		insert into relay_filter (
+			relay_dev_eui,
+			filter_index,
+			created_at,
+			updated_at,
+			action,
+			join_eui,
+			dev_eui
+		) values ($1, $2, $3, $4, $5, $6, $7)
+		on conflict (relay_dev_eui, filter_index) do update
+		set
+			updated_at = excluded.updated_at,
+			action = excluded.action,
+			join_eui = excluded.join_eui,
+			dev_eui = excluded.dev_eui`,
+		f.RelayDevEUI[:],
+		f.Index,
+		f.CreatedAt,
+		f.UpdatedAt,
+		f.Action,
+		f.JoinEUI,
+		f.DevEUI,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"relay_dev_eui": f.RelayDevEUI,
+		"index":         f.Index,
+		"ctx_id":        ctx.Value(logging.ContextIDKey),
+	}).Info("relay filter set")
+
+	return nil
+}
+
+// GetRelayFilters returns the filter rules of the given relay, sorted by
+// index.
+func GetRelayFilters(ctx context.Context, db sqlx.Queryer, relayDevEUI lorawan.EUI64) ([]RelayFilter, error) {
+	var out []RelayFilter
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			relay_filter
+		where
+			relay_dev_eui = $1
+		order by
+			filter_index`,
+		relayDevEUI[:],
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// DeleteRelayFilter deletes the filter rule at the given index.
+func DeleteRelayFilter(ctx context.Context, db sqlx.Execer, relayDevEUI lorawan.EUI64, index uint8) error {
+	res, err := db.Exec("delete from relay_filter where relay_dev_eui = $1 and filter_index = $2", relayDevEUI[:], index)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"relay_dev_eui": relayDevEUI,
+		"index":         index,
+		"ctx_id":        ctx.Value(logging.ContextIDKey),
+	}).Info("relay filter deleted")
+
+	return nil
+}
+
+// SetRelayEndDevice creates or updates the relay configuration of the given
+// end-device.
+func SetRelayEndDevice(ctx context.Context, db sqlx.Execer, d *RelayEndDevice) error {
+	if err := d.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	now := time.Now()
+	d.CreatedAt = now
+	d.UpdatedAt = now
+
+	_, err := db.Exec(`
+		insert into relay_end_device (
+			dev_eui,
+			created_at,
+			updated_at,
+			mode,
+			smart_enable_level,
+			backoff,
+			second_channel_freq,
+			second_channel_dr,
+			second_channel_ack_offset
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		on conflict (dev_eui) do update
+		set
+			updated_at = excluded.updated_at,
+			mode = excluded.mode,
+			smart_enable_level = excluded.smart_enable_level,
+			backoff = excluded.backoff,
+			second_channel_freq = excluded.second_channel_freq,
+			second_channel_dr = excluded.second_channel_dr,
+			second_channel_ack_offset = excluded.second_channel_ack_offset`,
+		d.DevEUI[:],
+		d.CreatedAt,
+		d.UpdatedAt,
+		d.Mode,
+		d.SmartEnableLevel,
+		d.Backoff,
+		d.SecondChannelFreq,
+		d.SecondChannelDR,
+		d.SecondChannelACKOffset,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": d.DevEUI,
+		"mode":    d.Mode,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("relay end-device set")
+
+	return nil
+}
+
+// GetRelayEndDevice returns the relay configuration of the given end-device.
+func GetRelayEndDevice(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64) (RelayEndDevice, error) {
+	var d RelayEndDevice
+	if err := sqlx.Get(db, &d, "select * from relay_end_device where dev_eui = $1", devEUI[:]); err != nil {
+		return d, handlePSQLError(Select, err, "select error")
+	}
+
+	return d, nil
+}
+
+// DeleteRelayEndDevice deletes the relay configuration of the given
+// end-device.
+func DeleteRelayEndDevice(ctx context.Context, db sqlx.Execer, devEUI lorawan.EUI64) error {
+	res, err := db.Exec("delete from relay_end_device where dev_eui = $1", devEUI[:])
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": devEUI,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("relay end-device deleted")
+
+	return nil
+}
@@ -0,0 +1,270 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestRelay() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(context.Background(), ts.Tx(), &org))
+
+	n := NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(CreateNetworkServer(context.Background(), ts.Tx(), &n))
+
+	sp := ServiceProfile{
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+		Name:            "test-sp",
+	}
+	assert.NoError(CreateServiceProfile(context.Background(), ts.Tx(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(context.Background(), ts.Tx(), &app))
+
+	dp := DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateDeviceProfile(context.Background(), ts.Tx(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	var devices []Device
+	for i := 0; i < RelayMaxDevices+2; i++ {
+		d := Device{
+			DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, byte(i)},
+			ApplicationID:   app.ID,
+			DeviceProfileID: dpID,
+			Name:            fmt.Sprintf("test-device-%d", i),
+		}
+		assert.NoError(CreateDevice(context.Background(), ts.Tx(), &d))
+		devices = append(devices, d)
+	}
+	relayDevEUI := devices[0].DevEUI
+
+	ts.T().Run("Create invalid", func(t *testing.T) {
+		assert := require.New(t)
+
+		tests := []struct {
+			Relay Relay
+			Error error
+		}{
+			{Relay{DevEUI: relayDevEUI, CADPeriodicity: 6}, ErrRelayInvalidCADPeriodicity},
+			{Relay{DevEUI: relayDevEUI, DefaultChannelIndex: 2}, ErrRelayInvalidDefaultChannel},
+			{Relay{DevEUI: relayDevEUI, SecondChannelFreq: 868100050}, ErrRelayInvalidSecondChannel},
+			{Relay{DevEUI: relayDevEUI, SecondChannelDR: 16}, ErrRelayInvalidSecondChannel},
+			{Relay{DevEUI: relayDevEUI, SecondChannelACKOffset: 6}, ErrRelayInvalidSecondChannel},
+		}
+
+		for _, tst := range tests {
+			assert.Equal(tst.Error, errors.Cause(CreateRelay(context.Background(), ts.Tx(), &tst.Relay)))
+		}
+	})
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		r := Relay{
+			DevEUI:                 relayDevEUI,
+			Enabled:                true,
+			CADPeriodicity:         1,
+			SecondChannelFreq:      868100000,
+			SecondChannelDR:        3,
+			SecondChannelACKOffset: 2,
+		}
+		assert.NoError(CreateRelay(context.Background(), ts.Tx(), &r))
+		r.CreatedAt = r.CreatedAt.Round(time.Second).UTC()
+		r.UpdatedAt = r.UpdatedAt.Round(time.Second).UTC()
+
+		t.Run("Get", func(t *testing.T) {
+			assert := require.New(t)
+
+			rGet, err := GetRelay(context.Background(), ts.Tx(), relayDevEUI)
+			assert.NoError(err)
+			rGet.CreatedAt = rGet.CreatedAt.Round(time.Second).UTC()
+			rGet.UpdatedAt = rGet.UpdatedAt.Round(time.Second).UTC()
+			assert.Equal(r, rGet)
+		})
+
+		t.Run("Update", func(t *testing.T) {
+			assert := require.New(t)
+
+			r.Enabled = false
+			r.SecondChannelFreq = 0
+			assert.NoError(UpdateRelay(context.Background(), ts.Tx(), &r))
+
+			rGet, err := GetRelay(context.Background(), ts.Tx(), relayDevEUI)
+			assert.NoError(err)
+			assert.False(rGet.Enabled)
+			assert.EqualValues(0, rGet.SecondChannelFreq)
+		})
+
+		t.Run("Devices", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.Equal(ErrRelayInvalidDevice, AddRelayDevice(context.Background(), ts.Tx(), relayDevEUI, relayDevEUI))
+
+			for _, d := range devices[1 : RelayMaxDevices+1] {
+				assert.NoError(AddRelayDevice(context.Background(), ts.Tx(), relayDevEUI, d.DevEUI))
+			}
+			assert.Equal(ErrRelayMaxDevices, AddRelayDevice(context.Background(), ts.Tx(), relayDevEUI, devices[RelayMaxDevices+1].DevEUI))
+
+			items, err := GetRelayDevices(context.Background(), ts.Tx(), relayDevEUI)
+			assert.NoError(err)
+			assert.Len(items, RelayMaxDevices)
+			assert.Equal(devices[1].DevEUI, items[0].DevEUI)
+			assert.Equal(devices[1].Name, items[0].DeviceName)
+
+			assert.NoError(RemoveRelayDevice(context.Background(), ts.Tx(), relayDevEUI, devices[1].DevEUI))
+			assert.Equal(ErrDoesNotExist, RemoveRelayDevice(context.Background(), ts.Tx(), relayDevEUI, devices[1].DevEUI))
+
+			items, err = GetRelayDevices(context.Background(), ts.Tx(), relayDevEUI)
+			assert.NoError(err)
+			assert.Len(items, RelayMaxDevices-1)
+		})
+
+		t.Run("Filters", func(t *testing.T) {
+			assert := require.New(t)
+
+			joinEUI := lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1}
+			devEUI := lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 1}
+
+			tests := []struct {
+				Filter RelayFilter
+				Error  error
+			}{
+				{RelayFilter{RelayDevEUI: relayDevEUI, Index: RelayMaxFilters}, ErrRelayInvalidFilterIndex},
+				{RelayFilter{RelayDevEUI: relayDevEUI, Action: 3}, ErrRelayInvalidFilterAction},
+				{RelayFilter{RelayDevEUI: relayDevEUI, DevEUI: &devEUI}, ErrRelayInvalidFilterEUI},
+			}
+			for _, tst := range tests {
+				assert.Equal(tst.Error, errors.Cause(SetRelayFilter(context.Background(), ts.Tx(), &tst.Filter)))
+			}
+
+			assert.NoError(SetRelayFilter(context.Background(), ts.Tx(), &RelayFilter{
+				RelayDevEUI: relayDevEUI,
+				Index:       1,
+				Action:      RelayFilterForward,
+				JoinEUI:     &joinEUI,
+			}))
+			assert.NoError(SetRelayFilter(context.Background(), ts.Tx(), &RelayFilter{
+				RelayDevEUI: relayDevEUI,
+				Index:       0,
+				Action:      RelayFilterFilter,
+			}))
+
+			filters, err := GetRelayFilters(context.Background(), ts.Tx(), relayDevEUI)
+			assert.NoError(err)
+			assert.Len(filters, 2)
+			assert.EqualValues(0, filters[0].Index)
+			assert.Nil(filters[0].JoinEUI)
+			assert.EqualValues(1, filters[1].Index)
+			assert.Equal(&joinEUI, filters[1].JoinEUI)
+			assert.Nil(filters[1].DevEUI)
+
+			// update
+			assert.NoError(SetRelayFilter(context.Background(), ts.Tx(), &RelayFilter{
+				RelayDevEUI: relayDevEUI,
+				Index:       1,
+				Action:      RelayFilterFilter,
+				JoinEUI:     &joinEUI,
+				DevEUI:      &devEUI,
+			}))
+			filters, err = GetRelayFilters(context.Background(), ts.Tx(), relayDevEUI)
+			assert.NoError(err)
+			assert.Len(filters, 2)
+			assert.Equal(RelayFilterFilter, filters[1].Action)
+			assert.Equal(&devEUI, filters[1].DevEUI)
+
+			assert.NoError(DeleteRelayFilter(context.Background(), ts.Tx(), relayDevEUI, 1))
+			assert.Equal(ErrDoesNotExist, DeleteRelayFilter(context.Background(), ts.Tx(), relayDevEUI, 1))
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(DeleteRelay(context.Background(), ts.Tx(), relayDevEUI))
+
+			_, err := GetRelay(context.Background(), ts.Tx(), relayDevEUI)
+			assert.Equal(ErrDoesNotExist, errors.Cause(err))
+
+			items, err := GetRelayDevices(context.Background(), ts.Tx(), relayDevEUI)
+			assert.NoError(err)
+			assert.Len(items, 0)
+
+			filters, err := GetRelayFilters(context.Background(), ts.Tx(), relayDevEUI)
+			assert.NoError(err)
+			assert.Len(filters, 0)
+		})
+	})
+
+	ts.T().Run("End-device", func(t *testing.T) {
+		assert := require.New(t)
+
+		devEUI := devices[1].DevEUI
+
+		tests := []struct {
+			EndDevice RelayEndDevice
+			Error     error
+		}{
+			{RelayEndDevice{DevEUI: devEUI, Mode: 4}, ErrRelayEndDeviceInvalidMode},
+			{RelayEndDevice{DevEUI: devEUI, SmartEnableLevel: 4}, ErrRelayEndDeviceInvalidSmartLevel},
+			{RelayEndDevice{DevEUI: devEUI, Backoff: 64}, ErrRelayEndDeviceInvalidBackoff},
+			{RelayEndDevice{DevEUI: devEUI, SecondChannelDR: 16}, ErrRelayInvalidSecondChannel},
+		}
+		for _, tst := range tests {
+			assert.Equal(tst.Error, errors.Cause(SetRelayEndDevice(context.Background(), ts.Tx(), &tst.EndDevice)))
+		}
+
+		_, err := GetRelayEndDevice(context.Background(), ts.Tx(), devEUI)
+		assert.Equal(ErrDoesNotExist, errors.Cause(err))
+
+		assert.NoError(SetRelayEndDevice(context.Background(), ts.Tx(), &RelayEndDevice{
+			DevEUI:  devEUI,
+			Mode:    RelayEndDeviceDynamic,
+			Backoff: 5,
+		}))
+		assert.NoError(SetRelayEndDevice(context.Background(), ts.Tx(), &RelayEndDevice{
+			DevEUI:           devEUI,
+			Mode:             RelayEndDeviceSmart,
+			SmartEnableLevel: 2,
+		}))
+
+		d, err := GetRelayEndDevice(context.Background(), ts.Tx(), devEUI)
+		assert.NoError(err)
+		assert.Equal(RelayEndDeviceSmart, d.Mode)
+		assert.EqualValues(2, d.SmartEnableLevel)
+		assert.EqualValues(0, d.Backoff)
+
+		assert.NoError(DeleteRelayEndDevice(context.Background(), ts.Tx(), devEUI))
+		assert.Equal(ErrDoesNotExist, DeleteRelayEndDevice(context.Background(), ts.Tx(), devEUI))
+	})
+}
@@ -0,0 +1,616 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	//"github.com/brocaar/lorawan"
+)
+
+// reportMaxMeasurements defines the max. number of measurements which can
+// be selected by a report subscription.
+const reportMaxMeasurements = 32
+
+// ReportSchedule defines the schedule of a report.
+type ReportSchedule string
+
+// Available report schedules.
+const (
+	ReportScheduleDaily  ReportSchedule = "DAILY"
+	ReportScheduleWeekly ReportSchedule = "WEEKLY"
+)
+
+// ReportFormat defines the format of the report attachments.
+type ReportFormat string
+
+// Available report formats.
+const (
+	ReportFormatCSV ReportFormat = "CSV"
+	ReportFormatPDF ReportFormat = "PDF"
+)
+
+// ReportSubscription defines the subscription of a user to the scheduled
+// (e-mail) report of an application. When Zone is set, the report only
+// covers the devices of which the ZoneTagKey device tag equals Zone. When
+// Measurements is set, only these measurements are reported, else all.
+//
+// A DAILY report is sent at midnight (in the configured metrics timezone)
+// and covers the previous day, a WEEKLY report is sent on Monday at
+// midnight and covers the previous week.
+type ReportSubscription struct {
+	ID            uuid.UUID      `db:"id"`
+	UserID        int64          `db:"user_id"`
+	ApplicationID int64          `db:"application_id"`
+	CreatedAt     time.Time      `db:"created_at"`
+	UpdatedAt     time.Time      `db:"updated_at"`
+	Name          string         `db:"name"`
+	Enabled       bool           `db:"enabled"`
+	Schedule      ReportSchedule `db:"schedule"`
+	Format        ReportFormat   `db:"format"`
+	ZoneTagKey    string         `db:"zone_tag_key"`
+	Zone          string         `db:"zone"`
+	Measurements  pq.StringArray `db:"measurements"`
+	NextRunAt     time.Time      `db:"next_run_at"`
+	LastRunAt     *time.Time     `db:"last_run_at"`
+	LastError     string         `db:"last_error"`
+}
+
+// Validate validates the report subscription data.
+func (s ReportSubscription) Validate() error {
+	if strings.TrimSpace(s.Name) == "" || len(s.Name) > 100 {
+		return ErrReportInvalidName
+	}
+
+	switch s.Schedule {
+	case ReportScheduleDaily, ReportScheduleWeekly:
+	default:
+		return ErrReportInvalidSchedule
+	}
+
+	switch s.Format {
+	case ReportFormatCSV, ReportFormatPDF:
+	default:
+		return ErrReportInvalidFormat
+	}
+
+	if s.Zone != "" && s.ZoneTagKey == "" {
+		return ErrReportInvalidZone
+	}
+
+	if len(s.Measurements) > reportMaxMeasurements {
+		return ErrReportInvalidMeasurements
+	}
+	for _, m := range s.Measurements {
+		if strings.TrimSpace(m) == "" {
+			return ErrReportInvalidMeasurements
+		}
+	}
+
+	return nil
+}
+
+// PeriodStart returns the start of the period covered by the report which
+// runs at the given time.
+func (s ReportSubscription) PeriodStart(end time.Time) time.Time {
+	end = end.In(timeLocation)
+	if s.Schedule == ReportScheduleWeekly {
+		return end.AddDate(0, 0, -7)
+	}
+	return end.AddDate(0, 0, -1)
+}
+
+// NextRun returns the first run of the report after the given time.
+func (s ReportSubscription) NextRun(after time.Time) time.Time {
+	t := after.In(timeLocation)
+	next := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, timeLocation).AddDate(0, 0, 1)
+
+	if s.Schedule == ReportScheduleWeekly {
+		next = next.AddDate(0, 0, (int(time.Monday)-int(next.Weekday())+7)%7)
+	}
+
+	return next
+}
+
+// ReportSubscriptionFilters provides filters for filtering report
+// subscriptions.
+type ReportSubscriptionFilters struct {
+	UserID        int64 `db:"user_id"`
+	ApplicationID int64 `db:"application_id"`
+
+	// Limit and Offset are added for convenience so that this struct can
+	// be given as the arguments.
+	Limit  int `db:"limit"`
+	Offset int `db:"offset"`
+}
+
+// SQL returns the SQL filters.
+func (f ReportSubscriptionFilters) SQL() string {
+	var filters []string
+
+	if f.UserID != 0 {
+		filters = append(filters, "user_id = :user_id")
+	}
+
+	if f.ApplicationID != 0 {
+		filters = append(filters, "application_id = :application_id")
+	}
+
+	if len(filters) == 0 {
+		return ""
+	}
+
+	return "where " + strings.Join(filters, " and ")
+}
+
+// CreateReportSubscription creates the given report subscription. The
+// first run is scheduled based on the schedule of the subscription.
+func CreateReportSubscription(ctx context.Context, db sqlx.Execer, s *ReportSubscription) error {
+	if err := s.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	var err error
+	s.ID, err = uuid.NewV4()
+	if err != nil {
+		return errors.Wrap(err, "new uuid v4 error")
+	}
+
+	now := time.Now()
+	s.CreatedAt = now
+	s.UpdatedAt = now
+	s.NextRunAt = s.NextRun(now)
+
+	if s.Measurements == nil {
+		s.Measurements = pq.StringArray{}
+	}
+
+	_, err = db.Exec(`
+		insert into report_subscription (
+			id,
+			user_id,
+			application_id,
+			created_at,
+			updated_at,
+			name,
+			enabled,
+			schedule,
+			format,
+			zone_tag_key,
+			zone,
+			measurements,
+			next_run_at
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+		s.ID,
+		s.UserID,
+		s.ApplicationID,
+		s.CreatedAt,
+		s.UpdatedAt,
+		s.Name,
+		s.Enabled,
+		s.Schedule,
+		s.Format,
+		s.ZoneTagKey,
+		s.Zone,
+		s.Measurements,
+		s.NextRunAt,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"id":             s.ID,
+		"user_id":        s.UserID,
+		"application_id": s.ApplicationID,
+		"ctx_id":         ctx.Value(logging.ContextIDKey),
+	}).Info("report subscription created")
+
+	return nil
+}
+
+// GetReportSubscription returns the report subscription for the given id.
+func GetReportSubscription(ctx context.Context, db sqlx.Queryer, id uuid.UUID) (ReportSubscription, error) {
+	var s ReportSubscription
+	if err := sqlx.Get(db, &s, "select * from report_subscription where id = $1", id); err != nil {
+		return s, handlePSQLError(Select, err, "select error")
+	}
+
+	return s, nil
+}
+
+// GetReportSubscriptionCount returns the number of report subscriptions
+// matching the given filters.
+func GetReportSubscriptionCount(ctx context.Context, db sqlx.Queryer, filters ReportSubscriptionFilters) (int, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			count(*)
+		from
+			report_subscription
+		`+filters.SQL(), filters)
+	if err != nil {
+		return 0, errors.Wrap(err, "named query error")
+	}
+
+	var count int
+	if err := sqlx.Get(db, &count, query, args...); err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	return count, nil
+}
+
+// GetReportSubscriptions returns the report subscriptions matching the
+// given filters, sorted by name.
+func GetReportSubscriptions(ctx context.Context, db sqlx.Queryer, filters ReportSubscriptionFilters) ([]ReportSubscription, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			*
+		from
+			report_subscription
+		`+filters.SQL()+`
+		order by
+			name,
+			id
+		limit :limit
+		offset :offset`, filters)
+	if err != nil {
+		return nil, errors.Wrap(err, "named query error")
+	}
+
+	var out []ReportSubscription
+	if err := sqlx.Select(db, &out, query, args...); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetDueReportSubscriptions returns at most limit enabled report
+// subscriptions of which the next run is due. The subscriptions are locked,
+// this function must be called within a transaction. Subscriptions locked
+// by an other transaction are skipped.
+func GetDueReportSubscriptions(ctx context.Context, db sqlx.Queryer, now time.Time, limit int) ([]ReportSubscription, error) {
+	var out []ReportSubscription
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			report_subscription
+		where
+			enabled = true
+			and next_run_at <= $1
+		order by
+			next_run_at
+		limit $2
+		for update
+		skip locked`,
+		now,
+		limit,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// UpdateReportSubscription updates the given report subscription. The next
+// run is re-scheduled as the schedule might have changed.
+func UpdateReportSubscription(ctx context.Context, db sqlx.Execer, s *ReportSubscription) error {
+	if err := s.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	now := time.Now()
+	s.UpdatedAt = now
+	s.NextRunAt = s.NextRun(now)
+
+	if s.Measurements == nil {
+		s.Measurements = pq.StringArray{}
+	}
+
+	res, err := db.Exec(`
+		update report_subscription
+		set
+			updated_at = $2,
+			name = $3,
+			enabled = $4,
+			schedule = $5,
+			format = $6,
+			zone_tag_key = $7,
+			zone = $8,
+			measurements = $9,
+			next_run_at = $10
+		where
+			id = $1`,
+		s.ID,
+		s.UpdatedAt,
+		s.Name,
+		s.Enabled,
+		s.Schedule,
+		s.Format,
+		s.ZoneTagKey,
+		s.Zone,
+		s.Measurements,
+		s.NextRunAt,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"id":     s.ID,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("report subscription updated")
+
+	return nil
+}
+
+// SetReportSubscriptionNextRunAt sets the next run of the given report
+// subscription.
+func SetReportSubscriptionNextRunAt(ctx context.Context, db sqlx.Execer, id uuid.UUID, nextRunAt time.Time) error {
+	res, err := db.Exec(`
+		update report_subscription
+		set
+			next_run_at = $2
+		where
+			id = $1`,
+		id,
+		nextRunAt,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	return nil
+}
+
+// SetReportSubscriptionResult stores the result of the last run of the
+// given report subscription. The lastError is empty when the report was
+// sent.
+func SetReportSubscriptionResult(ctx context.Context, db sqlx.Execer, id uuid.UUID, lastRunAt time.Time, lastError string) error {
+	res, err := db.Exec(`
+		update report_subscription
+		set
+			last_run_at = $2,
+			last_error = $3
+		where
+			id = $1`,
+		id,
+		lastRunAt,
+		lastError,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	return nil
+}
+
+// DeleteReportSubscription deletes the report subscription.
+func DeleteReportSubscription(ctx context.Context, db sqlx.Execer, id uuid.UUID) error {
+	res, err := db.Exec("delete from report_subscription where id = $1", id)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"id":     id,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("report subscription deleted")
+
+	return nil
+}
+
+// ReportMeasurement defines the aggregated values of a measurement of a
+// device over the report period.
+type ReportMeasurement struct {
+	DevEUI     lorawan.EUI64 `db:"dev_eui"`
+	DeviceName string        `db:"device_name"`
+	Name       string        `db:"name"`
+	Count      int           `db:"count"`
+	Min        float64       `db:"min"`
+	Max        float64       `db:"max"`
+	Avg        float64       `db:"avg"`
+}
+
+// ReportAlarmCount defines the number of automation alarms raised for a
+// device within the report period and their current state.
+type ReportAlarmCount struct {
+	DevEUI       lorawan.EUI64 `db:"dev_eui"`
+	DeviceName   string        `db:"device_name"`
+	Raised       int           `db:"raised"`
+	Resolved     int           `db:"resolved"`
+	Acknowledged int           `db:"acknowledged"`
+	Open         int           `db:"open"`
+}
+
+// ReportAvailability defines the availability of a device over the report
+// period. ActiveHours holds the number of (clock) hours in which at least
+// one uplink was received.
+type ReportAvailability struct {
+	DevEUI      lorawan.EUI64 `db:"dev_eui"`
+	DeviceName  string        `db:"device_name"`
+	Uplinks     int           `db:"uplinks"`
+	ActiveHours int           `db:"active_hours"`
+	LastSeenAt  *time.Time    `db:"last_seen_at"`
+}
+
+// ReportFilters provides the filters of the report data. When Zone is set,
+// only the devices of which the TagKey device tag equals Zone are included.
+// When Measurements is empty, all measurements are included.
+type ReportFilters struct {
+	ApplicationID int64          `db:"application_id"`
+	TagKey        string         `db:"tag_key"`
+	Zone          string         `db:"zone"`
+	Start         time.Time      `db:"start"`
+	End           time.Time      `db:"end"`
+	Measurements  pq.StringArray `db:"measurements"`
+}
+
+// SQL returns the SQL filters on the devices (aliased as d).
+func (f ReportFilters) SQL() string {
+	filters := []string{"d.application_id = :application_id"}
+
+	if f.Zone != "" {
+		filters = append(filters, "d.tags -> :tag_key = :zone")
+	}
+
+	return "where " + strings.Join(filters, " and ")
+}
+
+// GetReportMeasurements returns per device the aggregated measurement
+// values received within the report period, sorted by device name and
+// measurement name. When the schema-per-organization mode is enabled this
+// must be called using ForOrganization.
+func GetReportMeasurements(ctx context.Context, db sqlx.Queryer, filters ReportFilters) ([]ReportMeasurement, error) {
+	defer observeQueryDuration("report_measurements_get", time.Now())
+
+	measurements := ""
+	if len(filters.Measurements) != 0 {
+		measurements = "and m.name = any(:measurements)"
+	}
+
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			d.dev_eui,
+			d.name as device_name,
+			m.name,
+			count(*) as count,
+			min(m.value) as min,
+			max(m.value) as max,
+			avg(m.value) as avg
+		from
+			device_metric m
+		inner join device d
+			on d.dev_eui = m.dev_eui
+		`+filters.SQL()+`
+			and m.application_id = :application_id
+			and m.time >= :start
+			and m.time < :end
+			`+measurements+`
+		group by
+			d.dev_eui, d.name, m.name
+		order by
+			d.name, m.name`, filters)
+	if err != nil {
+		return nil, errors.Wrap(err, "named query error")
+	}
+
+	var out []ReportMeasurement
+	if err := sqlx.Select(db, &out, query, args...); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetReportAlarmCounts returns per device the number of automation alarms
+// raised within the report period, sorted by device name. Devices without
+// alarms are omitted.
+func GetReportAlarmCounts(ctx context.Context, db sqlx.Queryer, filters ReportFilters) ([]ReportAlarmCount, error) {
+	defer observeQueryDuration("report_alarm_counts_get", time.Now())
+
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			d.dev_eui,
+			d.name as device_name,
+			count(*) as raised,
+			count(*) filter (where al.resolved_at is not null) as resolved,
+			count(*) filter (where al.acknowledged_at is not null) as acknowledged,
+			count(*) filter (where al.resolved_at is null and al.acknowledged_at is null) as open
+		from
+			automation_alarm al
+		inner join device d
+			on d.dev_eui = al.dev_eui
+		`+filters.SQL()+`
+			and al.created_at >= :start
+			and al.created_at < :end
+		group by
+			d.dev_eui, d.name
+		order by
+			d.name`, filters)
+	if err != nil {
+		return nil, errors.Wrap(err, "named query error")
+	}
+
+	var out []ReportAlarmCount
+	if err := sqlx.Select(db, &out, query, args...); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetReportAvailability returns the availability of every device over the
+// report period, sorted by device name. This is based on the uplinks stored
+// in the device frame-log, when the schema-per-organization mode is enabled
+// this must be called using ForOrganization.
+func GetReportAvailability(ctx context.Context, db sqlx.Queryer, filters ReportFilters) ([]ReportAvailability, error) {
+	defer observeQueryDuration("report_availability_get", time.Now())
+
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			d.dev_eui,
+			d.name as device_name,
+			count(fl.received_at) as uplinks,
+			count(distinct date_trunc('hour', fl.received_at)) as active_hours,
+			d.last_seen_at
+		from
+			device d
+		left join device_frame_log fl
+			on fl.dev_eui = d.dev_eui
+			and fl.received_at >= :start
+			and fl.received_at < :end
+		`+filters.SQL()+`
+		group by
+			d.dev_eui, d.name, d.last_seen_at
+		order by
+			d.name`, filters)
+	if err != nil {
+		return nil, errors.Wrap(err, "named query error")
+	}
+
+	var out []ReportAvailability
+	if err := sqlx.Select(db, &out, query, args...); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
@@ -0,0 +1,427 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/lib/pq/hstore"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	//"github.com/brocaar/lorawan"
+)
+
+func TestReportSubscriptionSchedule(t *testing.T) {
+	loc := timeLocation
+	timeLocation = time.UTC
+	defer func() { timeLocation = loc }()
+
+	wednesday := time.Date(2020, 1, 8, 14, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		Name          string
+		Schedule      ReportSchedule
+		Time          time.Time
+		ExpectedNext  time.Time
+		ExpectedStart time.Time
+	}{
+		{
+			Name:          "daily",
+			Schedule:      ReportScheduleDaily,
+			Time:          wednesday,
+			ExpectedNext:  time.Date(2020, 1, 9, 0, 0, 0, 0, time.UTC),
+			ExpectedStart: time.Date(2020, 1, 7, 14, 30, 0, 0, time.UTC),
+		},
+		{
+			Name:          "daily at midnight",
+			Schedule:      ReportScheduleDaily,
+			Time:          time.Date(2020, 1, 9, 0, 0, 0, 0, time.UTC),
+			ExpectedNext:  time.Date(2020, 1, 10, 0, 0, 0, 0, time.UTC),
+			ExpectedStart: time.Date(2020, 1, 8, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			Name:          "weekly",
+			Schedule:      ReportScheduleWeekly,
+			Time:          wednesday,
+			ExpectedNext:  time.Date(2020, 1, 13, 0, 0, 0, 0, time.UTC),
+			ExpectedStart: time.Date(2020, 1, 1, 14, 30, 0, 0, time.UTC),
+		},
+		{
+			Name:          "weekly on sunday",
+			Schedule:      ReportScheduleWeekly,
+			Time:          time.Date(2020, 1, 12, 23, 0, 0, 0, time.UTC),
+			ExpectedNext:  time.Date(2020, 1, 13, 0, 0, 0, 0, time.UTC),
+			ExpectedStart: time.Date(2020, 1, 5, 23, 0, 0, 0, time.UTC),
+		},
+		{
+			Name:          "weekly on monday",
+			Schedule:      ReportScheduleWeekly,
+			Time:          time.Date(2020, 1, 13, 0, 0, 0, 0, time.UTC),
+			ExpectedNext:  time.Date(2020, 1, 20, 0, 0, 0, 0, time.UTC),
+			ExpectedStart: time.Date(2020, 1, 6, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			s := ReportSubscription{Schedule: tst.Schedule}
+			next := s.NextRun(tst.Time)
+			assert.True(tst.ExpectedNext.Equal(next), "expected: %s, got: %s", tst.ExpectedNext, next)
+			start := s.PeriodStart(tst.Time)
+			assert.True(tst.ExpectedStart.Equal(start), "expected: %s, got: %s", tst.ExpectedStart, start)
+		})
+	}
+}
+
+func (ts *StorageTestSuite) TestReportSubscription() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(ctx, ts.Tx(), &org))
+
+	user := User{
+		IsActive: true,
+		Email:    "foo@bar.com",
+	}
+	assert.NoError(CreateUser(ctx, ts.Tx(), &user))
+
+	n := NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(CreateNetworkServer(ctx, ts.Tx(), &n))
+
+	sp := ServiceProfile{
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+		Name:            "test-sp",
+	}
+	assert.NoError(CreateServiceProfile(ctx, ts.Tx(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(ctx, ts.Tx(), &app))
+
+	ts.T().Run("Create invalid", func(t *testing.T) {
+		assert := require.New(t)
+
+		tests := []struct {
+			Name          string
+			Subscription  ReportSubscription
+			ExpectedError error
+		}{
+			{
+				Name:          "no name",
+				Subscription:  ReportSubscription{Schedule: ReportScheduleDaily, Format: ReportFormatCSV},
+				ExpectedError: ErrReportInvalidName,
+			},
+			{
+				Name:          "invalid schedule",
+				Subscription:  ReportSubscription{Name: "report", Schedule: "HOURLY", Format: ReportFormatCSV},
+				ExpectedError: ErrReportInvalidSchedule,
+			},
+			{
+				Name:          "invalid format",
+				Subscription:  ReportSubscription{Name: "report", Schedule: ReportScheduleDaily, Format: "XLS"},
+				ExpectedError: ErrReportInvalidFormat,
+			},
+			{
+				Name:          "zone without tag key",
+				Subscription:  ReportSubscription{Name: "report", Schedule: ReportScheduleDaily, Format: ReportFormatCSV, Zone: "greenhouse"},
+				ExpectedError: ErrReportInvalidZone,
+			},
+			{
+				Name:          "empty measurement",
+				Subscription:  ReportSubscription{Name: "report", Schedule: ReportScheduleDaily, Format: ReportFormatCSV, Measurements: []string{""}},
+				ExpectedError: ErrReportInvalidMeasurements,
+			},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.Name, func(t *testing.T) {
+				s := tst.Subscription
+				s.UserID = user.ID
+				s.ApplicationID = app.ID
+				assert.Equal(tst.ExpectedError, errors.Cause(CreateReportSubscription(ctx, ts.Tx(), &s)))
+			})
+		}
+	})
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		s := ReportSubscription{
+			UserID:        user.ID,
+			ApplicationID: app.ID,
+			Name:          "daily report",
+			Enabled:       true,
+			Schedule:      ReportScheduleDaily,
+			Format:        ReportFormatCSV,
+		}
+		assert.NoError(CreateReportSubscription(ctx, ts.Tx(), &s))
+		assert.True(s.NextRunAt.After(time.Now()))
+
+		s.CreatedAt = s.CreatedAt.Round(time.Second).UTC()
+		s.UpdatedAt = s.UpdatedAt.Round(time.Second).UTC()
+		s.NextRunAt = s.NextRunAt.UTC()
+
+		t.Run("Get", func(t *testing.T) {
+			assert := require.New(t)
+
+			sGet, err := GetReportSubscription(ctx, ts.Tx(), s.ID)
+			assert.NoError(err)
+
+			sGet.CreatedAt = sGet.CreatedAt.Round(time.Second).UTC()
+			sGet.UpdatedAt = sGet.UpdatedAt.Round(time.Second).UTC()
+			sGet.NextRunAt = sGet.NextRunAt.UTC()
+			assert.Equal(s, sGet)
+		})
+
+		t.Run("List", func(t *testing.T) {
+			assert := require.New(t)
+
+			count, err := GetReportSubscriptionCount(ctx, ts.Tx(), ReportSubscriptionFilters{UserID: user.ID})
+			assert.NoError(err)
+			assert.Equal(1, count)
+
+			items, err := GetReportSubscriptions(ctx, ts.Tx(), ReportSubscriptionFilters{ApplicationID: app.ID, Limit: 10})
+			assert.NoError(err)
+			assert.Len(items, 1)
+			assert.Equal(s.ID, items[0].ID)
+
+			count, err = GetReportSubscriptionCount(ctx, ts.Tx(), ReportSubscriptionFilters{UserID: user.ID + 1})
+			assert.NoError(err)
+			assert.Equal(0, count)
+		})
+
+		t.Run("Due", func(t *testing.T) {
+			assert := require.New(t)
+
+			items, err := GetDueReportSubscriptions(ctx, ts.Tx(), time.Now(), 10)
+			assert.NoError(err)
+			assert.Len(items, 0)
+
+			items, err = GetDueReportSubscriptions(ctx, ts.Tx(), s.NextRunAt, 10)
+			assert.NoError(err)
+			assert.Len(items, 1)
+
+			next := s.NextRun(s.NextRunAt)
+			assert.NoError(SetReportSubscriptionNextRunAt(ctx, ts.Tx(), s.ID, next))
+
+			items, err = GetDueReportSubscriptions(ctx, ts.Tx(), s.NextRunAt, 10)
+			assert.NoError(err)
+			assert.Len(items, 0)
+
+			assert.NoError(SetReportSubscriptionResult(ctx, ts.Tx(), s.ID, s.NextRunAt, "send error"))
+			sGet, err := GetReportSubscription(ctx, ts.Tx(), s.ID)
+			assert.NoError(err)
+			assert.True(next.Equal(sGet.NextRunAt))
+			assert.True(s.NextRunAt.Equal(*sGet.LastRunAt))
+			assert.Equal("send error", sGet.LastError)
+		})
+
+		t.Run("Update", func(t *testing.T) {
+			assert := require.New(t)
+
+			s.Name = "weekly report"
+			s.Schedule = ReportScheduleWeekly
+			s.Format = ReportFormatPDF
+			s.ZoneTagKey = "zone"
+			s.Zone = "greenhouse"
+			s.Measurements = []string{"temperature"}
+			assert.NoError(UpdateReportSubscription(ctx, ts.Tx(), &s))
+			assert.Equal(time.Monday, s.NextRunAt.In(timeLocation).Weekday())
+
+			sGet, err := GetReportSubscription(ctx, ts.Tx(), s.ID)
+			assert.NoError(err)
+			assert.Equal(s.Name, sGet.Name)
+			assert.Equal(ReportScheduleWeekly, sGet.Schedule)
+			assert.Equal(ReportFormatPDF, sGet.Format)
+			assert.Equal("greenhouse", sGet.Zone)
+			assert.Equal([]string{"temperature"}, []string(sGet.Measurements))
+			assert.True(s.NextRunAt.Equal(sGet.NextRunAt))
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(DeleteReportSubscription(ctx, ts.Tx(), s.ID))
+			assert.Equal(ErrDoesNotExist, DeleteReportSubscription(ctx, ts.Tx(), s.ID))
+
+			_, err := GetReportSubscription(ctx, ts.Tx(), s.ID)
+			assert.Equal(ErrDoesNotExist, errors.Cause(err))
+		})
+	})
+
+	ts.T().Run("Report data", func(t *testing.T) {
+		assert := require.New(t)
+
+		dp := DeviceProfile{
+			Name:            "test-dp",
+			OrganizationID:  org.ID,
+			NetworkServerID: n.ID,
+		}
+		assert.NoError(CreateDeviceProfile(ctx, ts.Tx(), &dp))
+		dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+		assert.NoError(err)
+
+		zoneTags := func(zone string) hstore.Hstore {
+			return hstore.Hstore{
+				Map: map[string]sql.NullString{
+					"zone": {String: zone, Valid: true},
+				},
+			}
+		}
+
+		devices := []Device{
+			{DevEUI: lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 1}, Name: "device-1", Tags: zoneTags("greenhouse")},
+			{DevEUI: lorawan.EUI64{2, 2, 2, 2, 2, 2, 2, 2}, Name: "device-2", Tags: zoneTags("field")},
+		}
+		for i := range devices {
+			devices[i].ApplicationID = app.ID
+			devices[i].DeviceProfileID = dpID
+			assert.NoError(CreateDevice(ctx, ts.Tx(), &devices[i]))
+		}
+
+		end := time.Now().Round(time.Second)
+		start := end.Add(-24 * time.Hour)
+
+		assert.NoError(CreateDeviceMetrics(ctx, ts.Tx(), []DeviceMetric{
+			{DevEUI: devices[0].DevEUI, ApplicationID: app.ID, Time: end.Add(-2 * time.Hour), Name: "temperature", Value: 18},
+			{DevEUI: devices[0].DevEUI, ApplicationID: app.ID, Time: end.Add(-time.Hour), Name: "temperature", Value: 22},
+			{DevEUI: devices[0].DevEUI, ApplicationID: app.ID, Time: end.Add(-time.Hour), Name: "humidity", Value: 80},
+			{DevEUI: devices[0].DevEUI, ApplicationID: app.ID, Time: start.Add(-time.Hour), Name: "temperature", Value: 30},
+			{DevEUI: devices[1].DevEUI, ApplicationID: app.ID, Time: end.Add(-time.Hour), Name: "temperature", Value: 12},
+		}))
+
+		for i, receivedAt := range []time.Time{
+			end.Add(-3 * time.Hour),
+			end.Add(-3*time.Hour + time.Minute),
+			end.Add(-time.Hour),
+			start.Add(-time.Hour),
+		} {
+			assert.NoError(CreateDeviceFrameLog(ctx, ts.Tx(), DeviceFrameLog{
+				DevEUI:        devices[0].DevEUI,
+				ApplicationID: app.ID,
+				ReceivedAt:    receivedAt,
+				FCnt:          uint32(i),
+			}))
+		}
+
+		a := Automation{
+			ApplicationID: app.ID,
+			Name:          "offline",
+			Enabled:       true,
+			Trigger: spec.Trigger{
+				Type:           spec.DeviceOfflineTrigger,
+				TimeoutSeconds: 3600,
+			},
+			Actions: spec.Actions{
+				{Type: spec.WebhookAction, URL: "http://localhost/hook"},
+			},
+		}
+		assert.NoError(CreateAutomation(ctx, ts.Tx(), &a))
+
+		alarms := []AutomationAlarm{
+			{AutomationID: a.ID, DevEUI: devices[0].DevEUI, CreatedAt: end.Add(-2 * time.Hour)},
+			{AutomationID: a.ID, DevEUI: devices[1].DevEUI, CreatedAt: start.Add(-time.Hour)},
+		}
+		for i := range alarms {
+			assert.NoError(CreateAutomationAlarm(ctx, ts.Tx(), &alarms[i]))
+		}
+		assert.NoError(AcknowledgeAutomationAlarm(ctx, ts.Tx(), alarms[0].ID, "operator@example.com", ""))
+
+		filters := ReportFilters{
+			ApplicationID: app.ID,
+			TagKey:        "zone",
+			Start:         start,
+			End:           end,
+		}
+
+		t.Run("Measurements", func(t *testing.T) {
+			assert := require.New(t)
+
+			items, err := GetReportMeasurements(ctx, ts.Tx(), filters)
+			assert.NoError(err)
+			assert.Len(items, 3)
+
+			assert.Equal("device-1", items[0].DeviceName)
+			assert.Equal("humidity", items[0].Name)
+
+			// the reading before the start of the period is ignored
+			temp := items[1]
+			assert.Equal(devices[0].DevEUI, temp.DevEUI)
+			assert.Equal("temperature", temp.Name)
+			assert.Equal(2, temp.Count)
+			assert.Equal(float64(18), temp.Min)
+			assert.Equal(float64(22), temp.Max)
+			assert.Equal(float64(20), temp.Avg)
+
+			assert.Equal("device-2", items[2].DeviceName)
+
+			f := filters
+			f.Zone = "greenhouse"
+			f.Measurements = []string{"temperature"}
+			items, err = GetReportMeasurements(ctx, ts.Tx(), f)
+			assert.NoError(err)
+			assert.Len(items, 1)
+			assert.Equal("device-1", items[0].DeviceName)
+			assert.Equal("temperature", items[0].Name)
+		})
+
+		t.Run("Alarm counts", func(t *testing.T) {
+			assert := require.New(t)
+
+			items, err := GetReportAlarmCounts(ctx, ts.Tx(), filters)
+			assert.NoError(err)
+			assert.Len(items, 1)
+			assert.Equal(devices[0].DevEUI, items[0].DevEUI)
+			assert.Equal(1, items[0].Raised)
+			assert.Equal(0, items[0].Resolved)
+			assert.Equal(1, items[0].Acknowledged)
+			assert.Equal(0, items[0].Open)
+		})
+
+		t.Run("Availability", func(t *testing.T) {
+			assert := require.New(t)
+
+			items, err := GetReportAvailability(ctx, ts.Tx(), filters)
+			assert.NoError(err)
+			assert.Len(items, 2)
+
+			assert.Equal("device-1", items[0].DeviceName)
+			assert.Equal(3, items[0].Uplinks)
+			assert.True(items[0].ActiveHours >= 2 && items[0].ActiveHours <= 3)
+
+			assert.Equal("device-2", items[1].DeviceName)
+			assert.Equal(0, items[1].Uplinks)
+			assert.Equal(0, items[1].ActiveHours)
+
+			f := filters
+			f.Zone = "field"
+			items, err = GetReportAvailability(ctx, ts.Tx(), f)
+			assert.NoError(err)
+			assert.Len(items, 1)
+			assert.Equal("device-2", items[0].DeviceName)
+		})
+	})
+}
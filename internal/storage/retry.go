@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// transactionMaxRetries defines the max. number of times a transaction
+	// is retried on a serialization failure or deadlock.
+	transactionMaxRetries = 3
+
+	// transactionRetryBackoff defines the (base) delay between the retries,
+	// the delay is doubled on every next attempt.
+	transactionRetryBackoff = 50 * time.Millisecond
+)
+
+// isRetryableTxError returns true when the error indicates that the
+// transaction was aborted because of a conflict with a concurrent
+// transaction, meaning that the transaction can be retried.
+func isRetryableTxError(err error) bool {
+	cause := errors.Cause(err)
+	if cause == ErrTransactionConflict {
+		return true
+	}
+
+	if e, ok := cause.(*pq.Error); ok {
+		return isRetryablePQError(e)
+	}
+	return false
+}
+
+// isRetryablePQError returns true for the serialization_failure and
+// deadlock_detected errors.
+func isRetryablePQError(err *pq.Error) bool {
+	switch err.Code.Name() {
+	case "serialization_failure", "deadlock_detected":
+		return true
+	default:
+		return false
+	}
+}
+
+// transactionRetryDelay returns the delay before the given retry attempt,
+// using an exponential backoff with jitter so that the conflicting
+// transactions are not retried at the same time.
+func transactionRetryDelay(attempt int) time.Duration {
+	d := transactionRetryBackoff << uint(attempt-1)
+	if d <= 0 {
+		return 0
+	}
+	d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+
+	log.WithFields(log.Fields{
+		"attempt": attempt,
+		"delay":   d,
+	}).Warning("storage: transaction conflict, retrying transaction")
+
+	return d
+}
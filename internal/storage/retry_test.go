@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRetryableTxError(t *testing.T) {
+	tests := []struct {
+		Name      string
+		Err       error
+		Retryable bool
+	}{
+		{"serialization failure", &pq.Error{Code: "40001"}, true},
+		{"deadlock", errors.Wrap(&pq.Error{Code: "40P01"}, "storage: transaction commit error"), true},
+		{"conflict", errors.Wrap(ErrTransactionConflict, "update device error"), true},
+		{"unique violation", &pq.Error{Code: "23505"}, false},
+		{"other", ErrDoesNotExist, false},
+		{"nil", nil, false},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(tst.Retryable, isRetryableTxError(tst.Err))
+		})
+	}
+}
+
+func TestTransactionRetryDelay(t *testing.T) {
+	assert := require.New(t)
+
+	transactionRetryBackoff = 100 * time.Millisecond
+	defer func() { transactionRetryBackoff = 50 * time.Millisecond }()
+
+	for attempt, max := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond} {
+		d := transactionRetryDelay(attempt + 1)
+		assert.True(d >= max/2 && d <= max, "attempt %d: %s", attempt+1, d)
+	}
+}
+
+func (ts *StorageTestSuite) TestTransactionRetry() {
+	transactionMaxRetries = 2
+	transactionRetryBackoff = time.Millisecond
+	defer func() {
+		transactionMaxRetries = 3
+		transactionRetryBackoff = 50 * time.Millisecond
+	}()
+
+	ts.T().Run("Retried", func(t *testing.T) {
+		assert := require.New(t)
+
+		var calls int
+		err := TransactionWithRetry(func(tx sqlx.Ext) error {
+			calls++
+			if calls == 1 {
+				return &pq.Error{Code: "40001"}
+			}
+			return nil
+		})
+		assert.NoError(err)
+		assert.Equal(2, calls)
+	})
+
+	ts.T().Run("Max retries", func(t *testing.T) {
+		assert := require.New(t)
+
+		var calls int
+		err := TransactionWithRetry(func(tx sqlx.Ext) error {
+			calls++
+			return handlePSQLError(Update, &pq.Error{Code: "40P01"}, "update error")
+		})
+		assert.Equal(ErrTransactionConflict, err)
+		assert.Equal(3, calls)
+	})
+
+	ts.T().Run("Not retried", func(t *testing.T) {
+		assert := require.New(t)
+
+		var calls int
+		err := TransactionWithRetry(func(tx sqlx.Ext) error {
+			calls++
+			return ErrDoesNotExist
+		})
+		assert.Equal(ErrDoesNotExist, err)
+		assert.Equal(1, calls)
+	})
+
+	ts.T().Run("Deadlock", func(t *testing.T) {
+		assert := require.New(t)
+
+		// both transactions take the advisory locks in opposite order, after
+		// the first attempts have taken their first lock, so that PostgreSQL
+		// aborts one of them with a deadlock_detected error
+		var calls int32
+		var firstLocked sync.WaitGroup
+		firstLocked.Add(2)
+
+		lock := func(first, second int64) error {
+			return TransactionWithRetry(func(tx sqlx.Ext) error {
+				attempt := atomic.AddInt32(&calls, 1)
+
+				if _, err := tx.Exec("select pg_advisory_xact_lock($1)", first); err != nil {
+					return handlePSQLError(Select, err, "advisory lock error")
+				}
+
+				if attempt <= 2 {
+					firstLocked.Done()
+					firstLocked.Wait()
+				}
+
+				if _, err := tx.Exec("select pg_advisory_xact_lock($1)", second); err != nil {
+					return handlePSQLError(Select, err, "advisory lock error")
+				}
+				return nil
+			})
+		}
+
+		var wg sync.WaitGroup
+		errs := make([]error, 2)
+		for i, keys := range [][2]int64{{625001, 625002}, {625002, 625001}} {
+			wg.Add(1)
+			go func(i int, keys [2]int64) {
+				defer wg.Done()
+				errs[i] = lock(keys[0], keys[1])
+			}(i, keys)
+		}
+		wg.Wait()
+
+		assert.NoError(errs[0])
+		assert.NoError(errs[1])
+		assert.Equal(int32(3), atomic.LoadInt32(&calls))
+	})
+
+	ts.T().Run("Transaction is not retried", func(t *testing.T) {
+		assert := require.New(t)
+
+		var calls int
+		err := Transaction(func(tx sqlx.Ext) error {
+			calls++
+			return &pq.Error{Code: "40001"}
+		})
+		assert.Error(err)
+		assert.Equal(1, calls)
+	})
+}
@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// SchedulerBacklog contains the number of items which are due to be
+// processed by the downlink schedulers, but have not yet been processed.
+type SchedulerBacklog struct {
+	ConfirmedDownlinkRetries int64 `db:"confirmed_downlink_retries"`
+	ExpiredDeviceQueueItems  int64 `db:"expired_device_queue_items"`
+	FirmwareCampaignDevices  int64 `db:"firmware_campaign_devices"`
+	FUOTADeployments         int64 `db:"fuota_deployments"`
+}
+
+// GetSchedulerBacklog returns the downlink scheduler backlog. The conditions
+// match the queries used by the schedulers to fetch the pending items
+// (e.g. GetPendingConfirmedDownlinkRetries).
+func GetSchedulerBacklog(ctx context.Context, db sqlx.Queryer) (SchedulerBacklog, error) {
+	defer observeQueryDuration("scheduler_backlog_get", time.Now())
+
+	var out SchedulerBacklog
+	err := sqlx.Get(db, &out, `
+		select
+			(select count(*) from confirmed_downlink where retry_after <= $1 and retry_uplinks = 0) as confirmed_downlink_retries,
+			(select count(*) from device_queue_item_expiry where expires_at <= $1) as expired_device_queue_items,
+			(select count(*) from firmware_campaign_device where state = $2 and next_chunk_after <= $1) as firmware_campaign_devices,
+			(select count(*) from fuota_deployment where state != $3 and next_step_after <= $1) as fuota_deployments`,
+		time.Now(),
+		FirmwareCampaignDevicePending,
+		FUOTADeploymentDone,
+	)
+	if err != nil {
+		return out, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
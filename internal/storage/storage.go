@@ -1,19 +1,19 @@
 package storage
 
 import (
-	"crypto/tls"
+	"context"
+	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v7"
 	uuid "github.com/gofrs/uuid"
-	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
-	migrate "github.com/rubenv/sql-migrate"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/ibrahimozekici/app-server2/internal/config"
-	"github.com/ibrahimozekici/app-server2/internal/migrations"
 )
 
 var (
@@ -21,8 +21,27 @@ var (
 	// HashIterations denfines the number of times a password is hashed.
 	HashIterations      = 100000
 	applicationServerID uuid.UUID
+
+	// the DSN and dial host used to connect to PostgreSQL, used to connect
+	// to a copy of the database (see DryRunMigrations)
+	postgreSQLDSN      string
+	postgreSQLDialHost string
+
+	// the configuration used to connect to PostgreSQL, used to re-connect
+	// when the DSN has been rotated (see RotatePostgreSQLDSN)
+	postgreSQLConfig config.Config
+
+	// pgBouncerMode makes the connections compatible with PgBouncer in
+	// transaction-pooling mode (see pgBouncerConn)
+	pgBouncerMode bool
 )
 
+// JobFunc runs the given background job, e.g. leader.Do which only runs the
+// job on the leader instance. As the leader package depends on the storage
+// package, the background loops of the storage package take this function
+// as argument.
+type JobFunc func(ctx context.Context, job string, f func(ctx context.Context) error) error
+
 // Setup configures the storage package.
 func Setup(c config.Config) error {
 	log.Info("storage: setting up storage package")
@@ -57,52 +76,117 @@ func Setup(c config.Config) error {
 		c.Metrics.Redis.MonthAggregationTTL,
 	)
 
+	// setup partitioning
+	if err := setupPartitioning(c); err != nil {
+		return errors.Wrap(err, "setup partitioning error")
+	}
+
+	// setup downlink rate limits
+	SetDownlinkRateLimits(
+		DownlinkRateLimit{
+			MaxDownlinks: c.ApplicationServer.DownlinkRateLimit.Device.MaxDownlinks,
+			Interval:     c.ApplicationServer.DownlinkRateLimit.Device.Interval,
+		},
+		DownlinkRateLimit{
+			MaxDownlinks: c.ApplicationServer.DownlinkRateLimit.Application.MaxDownlinks,
+			Interval:     c.ApplicationServer.DownlinkRateLimit.Application.Interval,
+		},
+	)
+
+	// setup slow query logging
+	SetSlowQueryThreshold(c.Monitoring.SlowQueryThreshold)
+
+	// setup join brute-force protection
+	for scope, l := range map[JoinFailureScope]config.JoinFailureLimit{
+		JoinFailureScopeDevice:  c.JoinServer.BruteForceProtection.Device,
+		JoinFailureScopeJoinEUI: c.JoinServer.BruteForceProtection.JoinEUI,
+		JoinFailureScopeSender:  c.JoinServer.BruteForceProtection.Sender,
+	} {
+		SetJoinFailureLimit(scope, JoinFailureLimit{
+			MaxFailures:   l.MaxFailures,
+			Interval:      l.Interval,
+			BlockDuration: l.BlockDuration,
+		})
+	}
+
+	// setup downlink deduplication
+	if err := SetDownlinkDeduplication(
+		DownlinkDeduplicationMode(c.ApplicationServer.DownlinkDeduplication.Mode),
+		c.ApplicationServer.DownlinkDeduplication.Window,
+	); err != nil {
+		return errors.Wrap(err, "setup downlink deduplication error")
+	}
+
+	// setup downlink rule limits
+	SetDownlinkRuleLimits(
+		c.ApplicationServer.DownlinkRule.MinCooldown,
+		c.ApplicationServer.DownlinkRule.MaxTriggers,
+		c.ApplicationServer.DownlinkRule.TriggerInterval,
+	)
+
+	// setup automation limits
+	SetAutomationMinCooldown(c.ApplicationServer.Automation.MinCooldown)
+
+	// setup search index maintenance
+	indexAnalyzeInterval = c.PostgreSQL.IndexMaintenance.AnalyzeInterval
+	indexReindexInterval = c.PostgreSQL.IndexMaintenance.ReindexInterval
+
 	log.Info("storage: setting up Redis client")
+	redisKeyPrefix = c.Redis.KeyPrefix
 	if len(c.Redis.Servers) == 0 {
 		return errors.New("at least one redis server must be configured")
 	}
 
-	var tlsConfig *tls.Config
-	if c.Redis.TLSEnabled {
-		tlsConfig = &tls.Config{
-			InsecureSkipVerify: true,
+	var err error
+	redisClient, err = newRedisClient(c)
+	if err != nil {
+		return errors.Wrap(err, "storage: redis tls config error")
+	}
+
+	if err := setupCache(c); err != nil {
+		return errors.Wrap(err, "storage: setup cache error")
+	}
+
+	if err := SetupPostgreSQL(c); err != nil {
+		return err
+	}
+
+	if c.PostgreSQL.Automigrate {
+		log.Info("storage: applying PostgreSQL data migrations")
+		n, err := MigrateUp(db.DB.DB, "", 0)
+		if err != nil {
+			return errors.Wrap(err, "storage: applying PostgreSQL data migrations error")
+		}
+		log.WithField("count", n).Info("storage: PostgreSQL data migrations applied")
+
+		if _, err := MigrateHooksUp(context.Background(), db.DB.DB); err != nil {
+			return errors.Wrap(err, "storage: applying namespace migrations error")
 		}
 	}
 
-	if c.Redis.Cluster {
-		redisClient = redis.NewClusterClient(&redis.ClusterOptions{
-			Addrs:     c.Redis.Servers,
-			PoolSize:  c.Redis.PoolSize,
-			Password:  c.Redis.Password,
-			TLSConfig: tlsConfig,
-		})
-	} else if c.Redis.MasterName != "" {
-		redisClient = redis.NewFailoverClient(&redis.FailoverOptions{
-			MasterName:       c.Redis.MasterName,
-			SentinelAddrs:    c.Redis.Servers,
-			SentinelPassword: c.Redis.Password,
-			DB:               c.Redis.Database,
-			PoolSize:         c.Redis.PoolSize,
-			Password:         c.Redis.Password,
-			TLSConfig:        tlsConfig,
-		})
-	} else {
-		redisClient = redis.NewClient(&redis.Options{
-			Addr:      c.Redis.Servers[0],
-			DB:        c.Redis.Database,
-			Password:  c.Redis.Password,
-			PoolSize:  c.Redis.PoolSize,
-			TLSConfig: tlsConfig,
-		})
+	if err := setupBatchWriter(c); err != nil {
+		return errors.Wrap(err, "storage: setup batch writer error")
 	}
 
+	return nil
+}
+
+// SetupPostgreSQL connects to the PostgreSQL database. Unlike Setup, this
+// does not setup the Redis client and does not apply the migrations.
+func SetupPostgreSQL(c config.Config) error {
 	log.Info("storage: connecting to PostgreSQL database")
-	d, err := sqlx.Open("postgres", c.PostgreSQL.DSN)
+	pgBouncerMode = c.PostgreSQL.PgBouncerMode
+	dsn, dialHost, err := postgreSQLConnectionDSN(c)
+	if err != nil {
+		return err
+	}
+	d, err := openPostgreSQL(dsn, dialHost)
 	if err != nil {
 		return errors.Wrap(err, "storage: PostgreSQL connection error")
 	}
 	d.SetMaxOpenConns(c.PostgreSQL.MaxOpenConnections)
 	d.SetMaxIdleConns(c.PostgreSQL.MaxIdleConnections)
+	d.SetConnMaxLifetime(c.PostgreSQL.ConnMaxLifetime)
 	for {
 		if err := d.Ping(); err != nil {
 			log.WithError(err).Warning("storage: ping PostgreSQL database error, will retry in 2s")
@@ -112,21 +196,189 @@ func Setup(c config.Config) error {
 		}
 	}
 
+	dbMux.Lock()
 	db = &DBLogger{d}
+	dbMux.Unlock()
+	postgreSQLDSN = dsn
+	postgreSQLDialHost = dialHost
+	postgreSQLConfig = c
+	schemaPerOrganization = c.PostgreSQL.SchemaPerOrganization
+	transactionMaxRetries = c.PostgreSQL.TransactionMaxRetries
+	transactionRetryBackoff = c.PostgreSQL.TransactionRetryBackoff
 
-	if c.PostgreSQL.Automigrate {
-		log.Info("storage: applying PostgreSQL data migrations")
-		m := &migrate.AssetMigrationSource{
-			Asset:    migrations.Asset,
-			AssetDir: migrations.AssetDir,
-			Dir:      "",
+	return nil
+}
+
+// RotatePostgreSQLDSN re-connects to the PostgreSQL database using the given
+// DSN, e.g. after the database credentials have been rotated. New queries use
+// the new connection pool, the previous pool is closed after the given grace
+// period such that in-flight queries and transactions can complete.
+func RotatePostgreSQLDSN(newDSN string, gracePeriod time.Duration) error {
+	c := postgreSQLConfig
+	c.PostgreSQL.DSN = newDSN
+
+	dsn, dialHost, err := postgreSQLConnectionDSN(c)
+	if err != nil {
+		return err
+	}
+	d, err := openPostgreSQL(dsn, dialHost)
+	if err != nil {
+		return errors.Wrap(err, "storage: PostgreSQL connection error")
+	}
+	d.SetMaxOpenConns(c.PostgreSQL.MaxOpenConnections)
+	d.SetMaxIdleConns(c.PostgreSQL.MaxIdleConnections)
+	d.SetConnMaxLifetime(c.PostgreSQL.ConnMaxLifetime)
+	if err := d.Ping(); err != nil {
+		d.Close()
+		return errors.Wrap(err, "storage: ping PostgreSQL database error")
+	}
+
+	dbMux.Lock()
+	old := db
+	db = &DBLogger{d}
+	dbMux.Unlock()
+	postgreSQLDSN = dsn
+	postgreSQLDialHost = dialHost
+	postgreSQLConfig = c
+
+	log.Info("storage: re-connected to PostgreSQL database using rotated dsn")
+
+	if old != nil {
+		go func() {
+			time.Sleep(gracePeriod)
+			if err := old.Close(); err != nil {
+				log.WithError(err).Error("storage: close previous PostgreSQL connection pool error")
+			}
+		}()
+	}
+
+	return nil
+}
+
+// postgreSQLConnectionDSN returns the DSN and (optional) dial host used to
+// connect to the PostgreSQL database.
+func postgreSQLConnectionDSN(c config.Config) (string, string, error) {
+	dsn, err := dsnWithStatementTimeout(c.PostgreSQL.DSN, c.PostgreSQL.StatementTimeout)
+	if err != nil {
+		return "", "", errors.Wrap(err, "storage: set statement timeout error")
+	}
+	dsn, err = dsnWithPgBouncerMode(dsn, c.PostgreSQL.PgBouncerMode)
+	if err != nil {
+		return "", "", errors.Wrap(err, "storage: set pgbouncer mode error")
+	}
+	dsn, dialHost, err := dsnWithTLS(dsn, c)
+	if err != nil {
+		return "", "", errors.Wrap(err, "storage: postgresql tls config error")
+	}
+	return dsn, dialHost, nil
+}
+
+// newRedisClient returns a new Redis client for the given configuration.
+func newRedisClient(c config.Config) (redis.UniversalClient, error) {
+	tlsConfig, err := redisTLSConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Redis.Cluster {
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     c.Redis.Servers,
+			PoolSize:  c.Redis.PoolSize,
+			Password:  c.Redis.Password,
+			TLSConfig: tlsConfig,
+		}), nil
+	}
+
+	if c.Redis.MasterName != "" {
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       c.Redis.MasterName,
+			SentinelAddrs:    c.Redis.Servers,
+			SentinelPassword: c.Redis.Password,
+			DB:               c.Redis.Database,
+			PoolSize:         c.Redis.PoolSize,
+			Password:         c.Redis.Password,
+			TLSConfig:        tlsConfig,
+		}), nil
+	}
+
+	return redis.NewClient(&redis.Options{
+		Addr:      c.Redis.Servers[0],
+		DB:        c.Redis.Database,
+		Password:  c.Redis.Password,
+		PoolSize:  c.Redis.PoolSize,
+		TLSConfig: tlsConfig,
+	}), nil
+}
+
+// dsnWithStatementTimeout adds the statement_timeout run-time parameter to
+// the given DSN, supporting both the URL and the key=value format. The DSN
+// is returned unchanged when the timeout is 0.
+func dsnWithStatementTimeout(dsn string, timeout time.Duration) (string, error) {
+	if timeout == 0 {
+		return dsn, nil
+	}
+
+	return dsnSetParam(dsn, "statement_timeout", strconv.FormatInt(int64(timeout/time.Millisecond), 10))
+}
+
+// dsnSetParam sets the given connection parameter in the DSN, supporting
+// both the URL and the key=value format. An existing value is replaced.
+func dsnSetParam(dsn, key, value string) (string, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", errors.Wrap(err, "parse dsn error")
 		}
-		n, err := migrate.Exec(db.DB.DB, "postgres", m, migrate.Up)
+		q := u.Query()
+		q.Set(key, value)
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	}
+
+	kv := key + "=" + dsnQuoteValue(value)
+	if loc := dsnParamRegexp(key).FindStringSubmatchIndex(dsn); loc != nil {
+		return dsn[:loc[3]] + kv + dsn[loc[1]:], nil
+	}
+
+	return strings.TrimSpace(dsn + " " + kv), nil
+}
+
+// dsnGetParam returns the value of the given connection parameter from the
+// DSN, supporting both the URL (query parameters only) and the key=value
+// format.
+func dsnGetParam(dsn, key string) (string, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
 		if err != nil {
-			return errors.Wrap(err, "storage: applying PostgreSQL data migrations error")
+			return "", errors.Wrap(err, "parse dsn error")
 		}
-		log.WithField("count", n).Info("storage: PostgreSQL data migrations applied")
+		return u.Query().Get(key), nil
 	}
 
-	return nil
+	match := dsnParamRegexp(key).FindStringSubmatch(dsn)
+	if match == nil {
+		return "", nil
+	}
+
+	v := match[2]
+	if strings.HasPrefix(v, "'") && strings.HasSuffix(v, "'") && len(v) >= 2 {
+		v = v[1 : len(v)-1]
+		v = strings.NewReplacer(`\'`, `'`, `\\`, `\`).Replace(v)
+	}
+	return v, nil
+}
+
+// dsnParamRegexp returns the regexp matching the given parameter in the
+// key=value DSN format, the value can be single-quoted.
+func dsnParamRegexp(key string) *regexp.Regexp {
+	return regexp.MustCompile(`(^|\s)` + regexp.QuoteMeta(key) + `=('(?:[^'\\]|\\.)*'|\S*)`)
+}
+
+// dsnQuoteValue quotes the given value for the key=value DSN format when
+// needed.
+func dsnQuoteValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, ` '\`) {
+		return v
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
 }
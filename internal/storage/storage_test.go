@@ -2,8 +2,10 @@ package storage
 
 import (
 	"testing"
+	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 
 	"github.com/ibrahimozekici/app-server2/internal/test"
@@ -56,3 +58,113 @@ type StorageTestSuite struct {
 func TestStorage(t *testing.T) {
 	suite.Run(t, new(StorageTestSuite))
 }
+
+func TestDSNWithStatementTimeout(t *testing.T) {
+	tests := []struct {
+		Name    string
+		DSN     string
+		Timeout time.Duration
+		Out     string
+	}{
+		{
+			Name: "no timeout",
+			DSN:  "postgres://localhost/chirpstack_as?sslmode=disable",
+			Out:  "postgres://localhost/chirpstack_as?sslmode=disable",
+		},
+		{
+			Name:    "url format",
+			DSN:     "postgres://localhost/chirpstack_as?sslmode=disable",
+			Timeout: 5 * time.Second,
+			Out:     "postgres://localhost/chirpstack_as?sslmode=disable&statement_timeout=5000",
+		},
+		{
+			Name:    "key=value format",
+			DSN:     "user=chirpstack_as dbname=chirpstack_as sslmode=disable",
+			Timeout: 1500 * time.Millisecond,
+			Out:     "user=chirpstack_as dbname=chirpstack_as sslmode=disable statement_timeout=1500",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			out, err := dsnWithStatementTimeout(tst.DSN, tst.Timeout)
+			assert.NoError(err)
+			assert.Equal(tst.Out, out)
+		})
+	}
+}
+
+func TestDSNSetParam(t *testing.T) {
+	tests := []struct {
+		Name  string
+		DSN   string
+		Key   string
+		Value string
+		Out   string
+	}{
+		{
+			Name:  "url format",
+			DSN:   "postgres://localhost/chirpstack_as?sslmode=disable",
+			Key:   "sslmode",
+			Value: "verify-full",
+			Out:   "postgres://localhost/chirpstack_as?sslmode=verify-full",
+		},
+		{
+			Name:  "key=value format append",
+			DSN:   "user=chirpstack_as dbname=chirpstack_as",
+			Key:   "sslmode",
+			Value: "require",
+			Out:   "user=chirpstack_as dbname=chirpstack_as sslmode=require",
+		},
+		{
+			Name:  "key=value format replace",
+			DSN:   "sslmode=disable user=chirpstack_as",
+			Key:   "sslmode",
+			Value: "require",
+			Out:   "sslmode=require user=chirpstack_as",
+		},
+		{
+			Name:  "key=value format quoted",
+			DSN:   "user=chirpstack_as sslrootcert='/etc/my certs/ca.pem'",
+			Key:   "sslrootcert",
+			Value: "/etc/other certs/ca.pem",
+			Out:   "user=chirpstack_as sslrootcert='/etc/other certs/ca.pem'",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			out, err := dsnSetParam(tst.DSN, tst.Key, tst.Value)
+			assert.NoError(err)
+			assert.Equal(tst.Out, out)
+
+			v, err := dsnGetParam(out, tst.Key)
+			assert.NoError(err)
+			assert.Equal(tst.Value, v)
+		})
+	}
+}
+
+func TestGetRedisKey(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("lora:as:gwping:0102", GetRedisKey("lora:as:gwping:%s", "0102"))
+
+	redisKeyPrefix = "staging:"
+	defer func() { redisKeyPrefix = "" }()
+
+	assert.Equal("staging:lora:as:gwping:0102", GetRedisKey("lora:as:gwping:%s", "0102"))
+	assert.Equal("staging:lora:as:cache:invalidate", GetRedisKey(cacheInvalidatePubSubKey))
+}
+
+func TestQueryArgsHash(t *testing.T) {
+	assert := require.New(t)
+
+	h := queryArgsHash("0102030405060708", 10)
+	assert.Len(h, 16)
+	assert.Equal(h, queryArgsHash("0102030405060708", 10))
+	assert.NotEqual(h, queryArgsHash("0102030405060708", 11))
+	assert.NotEqual(h, queryArgsHash("0102030405060708", "10"))
+}
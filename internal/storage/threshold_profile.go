@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+)
+
+// ThresholdProfile defines a named set of thresholds of an organization,
+// e.g. "cold room: temperature between 2 and 8, humidity below 70". The
+// profile is attached to devices and zones by the automations with a
+// THRESHOLD trigger referring to it, changing the thresholds of the profile
+// changes the limits of all these automations.
+type ThresholdProfile struct {
+	ID             uuid.UUID       `db:"id"`
+	OrganizationID int64           `db:"organization_id"`
+	CreatedAt      time.Time       `db:"created_at"`
+	UpdatedAt      time.Time       `db:"updated_at"`
+	Name           string          `db:"name"`
+	Description    string          `db:"description"`
+	Thresholds     spec.Thresholds `db:"thresholds"`
+}
+
+// Validate validates the threshold profile data.
+func (p ThresholdProfile) Validate() error {
+	if strings.TrimSpace(p.Name) == "" || len(p.Name) > 100 {
+		return ErrThresholdProfileInvalidName
+	}
+
+	if err := p.Thresholds.Validate(); err != nil {
+		return errors.Wrap(ErrThresholdProfileInvalidLimits, err.Error())
+	}
+
+	return nil
+}
+
+// ThresholdProfileFilters provides filters for filtering threshold
+// profiles.
+type ThresholdProfileFilters struct {
+	OrganizationID int64 `db:"organization_id"`
+
+	// Limit and Offset are added for convenience so that this struct can
+	// be given as the arguments.
+	Limit  int `db:"limit"`
+	Offset int `db:"offset"`
+}
+
+// SQL returns the SQL filters.
+func (f ThresholdProfileFilters) SQL() string {
+	return "where organization_id = :organization_id"
+}
+
+// CreateThresholdProfile creates the given threshold profile.
+func CreateThresholdProfile(ctx context.Context, db sqlx.Execer, p *ThresholdProfile) error {
+	if err := p.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	var err error
+	p.ID, err = uuid.NewV4()
+	if err != nil {
+		return errors.Wrap(err, "new uuid v4 error")
+	}
+
+	now := time.Now()
+	p.CreatedAt = now
+	p.UpdatedAt = now
+
+	_, err = db.Exec(`
+		insert into threshold_profile (
+			id,
+			organization_id,
+			created_at,
+			updated_at,
+			name,
+			description,
+			thresholds
+		) values ($1, $2, $3, $4, $5, $6, $7)`,
+		p.ID,
+		p.OrganizationID,
+		p.CreatedAt,
+		p.UpdatedAt,
+		p.Name,
+		p.Description,
+		p.Thresholds,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"id":              p.ID,
+		"organization_id": p.OrganizationID,
+		"ctx_id":          ctx.Value(logging.ContextIDKey),
+	}).Info("threshold profile created")
+
+	return nil
+}
+
+// GetThresholdProfile returns the threshold profile for the given id.
+func GetThresholdProfile(ctx context.Context, db sqlx.Queryer, id uuid.UUID) (ThresholdProfile, error) {
+	var p ThresholdProfile
+	if err := sqlx.Get(db, &p, "select * from threshold_profile where id = $1", id); err != nil {
+		return p, handlePSQLError(Select, err, "select error")
+	}
+
+	return p, nil
+}
+
+// GetThresholdProfileCount returns the number of threshold profiles
+// matching the given filters.
+func GetThresholdProfileCount(ctx context.Context, db sqlx.Queryer, filters ThresholdProfileFilters) (int, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			count(*)
+		from
+			threshold_profile
+		`+filters.SQL(), filters)
+	if err != nil {
+		return 0, errors.Wrap(err, "named query error")
+	}
+
+	var count int
+	if err := sqlx.Get(db, &count, query, args...); err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	return count, nil
+}
+
+// GetThresholdProfiles returns the threshold profiles matching the given
+// filters, sorted by name.
+func GetThresholdProfiles(ctx context.Context, db sqlx.Queryer, filters ThresholdProfileFilters) ([]ThresholdProfile, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			*
+		from
+			threshold_profile
+		`+filters.SQL()+`
+		order by
+			name,
+			id
+		limit :limit
+		offset :offset`, filters)
+	if err != nil {
+		return nil, errors.Wrap(err, "named query error")
+	}
+
+	var out []ThresholdProfile
+	if err := sqlx.Select(db, &out, query, args...); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetThresholdProfileAutomations returns the automations using the given
+// threshold profile, sorted by name.
+func GetThresholdProfileAutomations(ctx context.Context, db sqlx.Queryer, id uuid.UUID) ([]Automation, error) {
+	var out []Automation
+	err := sqlx.Select(db, &out, `
+		select
+			*
+		from
+			automation
+		where
+			trigger->>'thresholdProfileID' = $1
+		order by
+			name,
+			id`,
+		id.String(),
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// UpdateThresholdProfile updates the given threshold profile.
+func UpdateThresholdProfile(ctx context.Context, db sqlx.Execer, p *ThresholdProfile) error {
+	if err := p.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	p.UpdatedAt = time.Now()
+
+	res, err := db.Exec(`
+		update threshold_profile
+		set
+			updated_at = $2,
+			name = $3,
+			description = $4,
+			thresholds = $5
+		where
+			id = $1`,
+		p.ID,
+		p.UpdatedAt,
+		p.Name,
+		p.Description,
+		p.Thresholds,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"id":     p.ID,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("threshold profile updated")
+
+	return nil
+}
+
+// DeleteThresholdProfile deletes the threshold profile. It returns
+// ErrThresholdProfileInUse when the profile is used by an automation.
+func DeleteThresholdProfile(ctx context.Context, db sqlx.Ext, id uuid.UUID) error {
+	res, err := db.Exec(`
+		delete from threshold_profile p
+		where
+			p.id = $1
+			and not exists (
+				select
+					1
+				from
+					automation a
+				where
+					a.trigger->>'thresholdProfileID' = p.id::text
+			)`,
+		id,
+	)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		if _, err := GetThresholdProfile(ctx, db, id); err != nil {
+			return err
+		}
+		return ErrThresholdProfileInUse
+	}
+
+	log.WithFields(log.Fields{
+		"id":     id,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("threshold profile deleted")
+
+	return nil
+}
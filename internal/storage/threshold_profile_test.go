@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+)
+
+func (ts *StorageTestSuite) TestThresholdProfile() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(ctx, ts.Tx(), &org))
+
+	n := NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(CreateNetworkServer(ctx, ts.Tx(), &n))
+
+	sp := ServiceProfile{
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+		Name:            "test-sp",
+	}
+	assert.NoError(CreateServiceProfile(ctx, ts.Tx(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(ctx, ts.Tx(), &app))
+
+	min := float64(2)
+	max := float64(8)
+	humidity := float64(70)
+
+	p := ThresholdProfile{
+		OrganizationID: org.ID,
+		Name:           "cold room",
+		Description:    "Cold storage of vaccines",
+		Thresholds: spec.Thresholds{
+			{Path: "temperature", Min: &min, Max: &max},
+			{Path: "humidity", Max: &humidity},
+		},
+	}
+
+	ts.T().Run("Create invalid", func(t *testing.T) {
+		assert := require.New(t)
+
+		invalid := p
+		invalid.Name = ""
+		assert.Equal(ErrThresholdProfileInvalidName, errors.Cause(CreateThresholdProfile(ctx, ts.Tx(), &invalid)))
+
+		invalid = p
+		invalid.Thresholds = spec.Thresholds{{Path: "temperature"}}
+		assert.Equal(ErrThresholdProfileInvalidLimits, errors.Cause(CreateThresholdProfile(ctx, ts.Tx(), &invalid)))
+	})
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(CreateThresholdProfile(ctx, ts.Tx(), &p))
+
+		pGet, err := GetThresholdProfile(ctx, ts.Tx(), p.ID)
+		assert.NoError(err)
+		assert.Equal(p.Name, pGet.Name)
+		assert.Equal(p.Description, pGet.Description)
+		assert.Equal(p.Thresholds, pGet.Thresholds)
+
+		filters := ThresholdProfileFilters{
+			OrganizationID: org.ID,
+			Limit:          10,
+		}
+
+		count, err := GetThresholdProfileCount(ctx, ts.Tx(), filters)
+		assert.NoError(err)
+		assert.Equal(1, count)
+
+		items, err := GetThresholdProfiles(ctx, ts.Tx(), filters)
+		assert.NoError(err)
+		assert.Len(items, 1)
+		assert.Equal(p.ID, items[0].ID)
+	})
+
+	ts.T().Run("Update", func(t *testing.T) {
+		assert := require.New(t)
+
+		max := float64(6)
+		p.Thresholds[0].Max = &max
+		assert.NoError(UpdateThresholdProfile(ctx, ts.Tx(), &p))
+
+		pGet, err := GetThresholdProfile(ctx, ts.Tx(), p.ID)
+		assert.NoError(err)
+		assert.Equal(float64(6), *pGet.Thresholds[0].Max)
+	})
+
+	ts.T().Run("Delete", func(t *testing.T) {
+		assert := require.New(t)
+
+		a := Automation{
+			ApplicationID: app.ID,
+			Name:          "cold-room",
+			Enabled:       true,
+			Trigger: spec.Trigger{
+				Type:               spec.ThresholdTrigger,
+				ThresholdProfileID: &p.ID,
+			},
+			Actions: spec.Actions{
+				{Type: spec.WebhookAction, URL: "http://localhost/hook"},
+			},
+		}
+		assert.NoError(CreateAutomation(ctx, ts.Tx(), &a))
+
+		automations, err := GetThresholdProfileAutomations(ctx, ts.Tx(), p.ID)
+		assert.NoError(err)
+		assert.Len(automations, 1)
+		assert.Equal(a.ID, automations[0].ID)
+
+		assert.Equal(ErrThresholdProfileInUse, errors.Cause(DeleteThresholdProfile(ctx, ts.Tx(), p.ID)))
+
+		assert.NoError(DeleteAutomation(ctx, ts.Tx(), a.ID))
+		assert.NoError(DeleteThresholdProfile(ctx, ts.Tx(), p.ID))
+
+		_, err = GetThresholdProfile(ctx, ts.Tx(), p.ID)
+		assert.Equal(ErrDoesNotExist, errors.Cause(err))
+		assert.Equal(ErrDoesNotExist, errors.Cause(DeleteThresholdProfile(ctx, ts.Tx(), p.ID)))
+	})
+}
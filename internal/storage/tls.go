@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+// redisTLSConfig returns the TLS configuration for the Redis client or nil
+// when TLS is disabled. For backwards compatibility, the server certificate
+// is only validated when a CA certificate has been configured.
+func redisTLSConfig(c config.Config) (*tls.Config, error) {
+	if !c.Redis.TLSEnabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: c.Redis.TLSServerName,
+	}
+
+	if c.Redis.CACert == "" {
+		tlsConfig.InsecureSkipVerify = true
+	} else {
+		b, err := ioutil.ReadFile(c.Redis.CACert)
+		if err != nil {
+			return nil, errors.Wrap(err, "read ca cert error")
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(b) {
+			return nil, errors.New("append ca cert to pool error")
+		}
+	}
+
+	if c.Redis.TLSCert != "" || c.Redis.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(c.Redis.TLSCert, c.Redis.TLSKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "load x509 keypair error")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// dsnWithTLS adds the configured TLS parameters to the given PostgreSQL DSN.
+// When a TLS server-name is configured, the DSN host is replaced by this
+// server-name (which is used by lib/pq to validate the server certificate)
+// and the original host is returned as dial host.
+func dsnWithTLS(dsn string, c config.Config) (string, string, error) {
+	conf := c.PostgreSQL
+	mode := conf.SSLMode
+
+	if conf.TLSServerName != "" {
+		if mode == "" {
+			mode = "verify-full"
+		}
+		if mode != "verify-full" {
+			return "", "", fmt.Errorf("tls_server_name requires ssl_mode verify-full, got: %s", mode)
+		}
+	}
+
+	params := []struct {
+		key   string
+		value string
+	}{
+		{"sslmode", mode},
+		{"sslrootcert", conf.CACert},
+		{"sslcert", conf.TLSCert},
+		{"sslkey", conf.TLSKey},
+	}
+
+	var err error
+	for _, p := range params {
+		if p.value == "" {
+			continue
+		}
+		dsn, err = dsnSetParam(dsn, p.key, p.value)
+		if err != nil {
+			return "", "", errors.Wrapf(err, "set %s error", p.key)
+		}
+	}
+
+	if conf.TLSServerName == "" {
+		return dsn, "", nil
+	}
+
+	host, err := dsnHost(dsn)
+	if err != nil {
+		return "", "", errors.Wrap(err, "get host error")
+	}
+	if strings.HasPrefix(host, "/") {
+		return "", "", errors.New("tls_server_name can not be used with unix domain sockets")
+	}
+
+	dsn, err = dsnSetParam(dsn, "host", conf.TLSServerName)
+	if err != nil {
+		return "", "", errors.Wrap(err, "set host error")
+	}
+
+	return dsn, host, nil
+}
+
+// dsnHost returns the host of the given DSN.
+func dsnHost(dsn string) (string, error) {
+	host, err := dsnGetParam(dsn, "host")
+	if err != nil {
+		return "", err
+	}
+
+	if host == "" && (strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")) {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", errors.Wrap(err, "parse dsn error")
+		}
+		host = u.Hostname()
+	}
+
+	if host == "" {
+		host = "localhost"
+	}
+
+	return host, nil
+}
+
+// openPostgreSQL opens the PostgreSQL database. When the dial host is set,
+// connections are made to this host instead of the host in the DSN. When
+// PgBouncer mode is enabled, the connections are wrapped by pgBouncerConn.
+func openPostgreSQL(dsn, dialHost string) (*sqlx.DB, error) {
+	if dialHost == "" && !pgBouncerMode {
+		return sqlx.Open("postgres", dsn)
+	}
+
+	c := pgConnector{
+		dsn:       dsn,
+		pgBouncer: pgBouncerMode,
+	}
+	if dialHost != "" {
+		c.dialer = pgDialer{host: dialHost}
+	}
+
+	return sqlx.NewDb(sql.OpenDB(c), "postgres"), nil
+}
+
+// pgConnector implements the driver.Connector using an (optional) custom
+// lib/pq dialer.
+type pgConnector struct {
+	dsn       string
+	dialer    pq.Dialer
+	pgBouncer bool
+}
+
+// Connect returns a new connection to the database.
+func (c pgConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	var cn driver.Conn
+	var err error
+
+	if c.dialer != nil {
+		cn, err = pq.DialOpen(c.dialer, c.dsn)
+	} else {
+		cn, err = pq.Open(c.dsn)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.pgBouncer {
+		return pgBouncerConn{cn}, nil
+	}
+	return cn, nil
+}
+
+// Driver returns the underlying driver.
+func (c pgConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
+
+// pgDialer dials the given host, keeping the port of the dialed address.
+type pgDialer struct {
+	host string
+}
+
+// Dial connects to the given address.
+func (d pgDialer) Dial(network, address string) (net.Conn, error) {
+	return net.Dial(network, d.address(address))
+}
+
+// DialTimeout connects to the given address using a timeout.
+func (d pgDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout(network, d.address(address), timeout)
+}
+
+func (d pgDialer) address(address string) string {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return net.JoinHostPort(d.host, port)
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+func TestDSNWithTLS(t *testing.T) {
+	tests := []struct {
+		Name          string
+		DSN           string
+		SSLMode       string
+		CACert        string
+		TLSCert       string
+		TLSKey        string
+		TLSServerName string
+
+		Out      string
+		DialHost string
+		Error    bool
+	}{
+		{
+			Name: "no tls config",
+			DSN:  "postgres://localhost/chirpstack_as?sslmode=disable",
+			Out:  "postgres://localhost/chirpstack_as?sslmode=disable",
+		},
+		{
+			Name:    "mutual tls",
+			DSN:     "postgres://db.example.com/chirpstack_as",
+			SSLMode: "verify-full",
+			CACert:  "/etc/ca.pem",
+			TLSCert: "/etc/cert.pem",
+			TLSKey:  "/etc/key.pem",
+			Out:     "postgres://db.example.com/chirpstack_as?sslcert=%2Fetc%2Fcert.pem&sslkey=%2Fetc%2Fkey.pem&sslmode=verify-full&sslrootcert=%2Fetc%2Fca.pem",
+		},
+		{
+			Name:          "server name url format",
+			DSN:           "postgres://10.0.0.1:5433/chirpstack_as",
+			TLSServerName: "db.example.com",
+			Out:           "postgres://10.0.0.1:5433/chirpstack_as?host=db.example.com&sslmode=verify-full",
+			DialHost:      "10.0.0.1",
+		},
+		{
+			Name:          "server name key=value format",
+			DSN:           "host=10.0.0.1 dbname=chirpstack_as",
+			TLSServerName: "db.example.com",
+			Out:           "host=db.example.com dbname=chirpstack_as sslmode=verify-full",
+			DialHost:      "10.0.0.1",
+		},
+		{
+			Name:          "server name invalid ssl mode",
+			DSN:           "host=10.0.0.1 dbname=chirpstack_as",
+			SSLMode:       "require",
+			TLSServerName: "db.example.com",
+			Error:         true,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			var conf config.Config
+			conf.PostgreSQL.SSLMode = tst.SSLMode
+			conf.PostgreSQL.CACert = tst.CACert
+			conf.PostgreSQL.TLSCert = tst.TLSCert
+			conf.PostgreSQL.TLSKey = tst.TLSKey
+			conf.PostgreSQL.TLSServerName = tst.TLSServerName
+
+			out, dialHost, err := dsnWithTLS(tst.DSN, conf)
+			if tst.Error {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tst.Out, out)
+			assert.Equal(tst.DialHost, dialHost)
+		})
+	}
+}
+
+func TestRedisTLSConfig(t *testing.T) {
+	assert := require.New(t)
+
+	var conf config.Config
+	tlsConfig, err := redisTLSConfig(conf)
+	assert.NoError(err)
+	assert.Nil(tlsConfig)
+
+	conf.Redis.TLSEnabled = true
+	conf.Redis.TLSServerName = "redis.example.com"
+	tlsConfig, err = redisTLSConfig(conf)
+	assert.NoError(err)
+	assert.True(tlsConfig.InsecureSkipVerify)
+	assert.Equal("redis.example.com", tlsConfig.ServerName)
+
+	conf.Redis.CACert = "/does/not/exist.pem"
+	_, err = redisTLSConfig(conf)
+	assert.Error(err)
+}
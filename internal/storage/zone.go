@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// A zone is the group of devices of an application sharing the same value
+// for a given device tag, e.g. all devices having the tag zone=greenhouse-1.
+
+// Zone defines a zone and the number of devices within the zone.
+type Zone struct {
+	Zone       string     `db:"zone"`
+	Devices    int        `db:"devices"`
+	LastSeenAt *time.Time `db:"last_seen_at"`
+}
+
+// ZoneMeasurement defines the aggregated values of a measurement of the
+// devices within a zone. Latest holds the most recent value of any device
+// within the zone.
+type ZoneMeasurement struct {
+	Zone     string    `db:"zone"`
+	Name     string    `db:"name"`
+	Count    int       `db:"count"`
+	Min      float64   `db:"min"`
+	Max      float64   `db:"max"`
+	Avg      float64   `db:"avg"`
+	Latest   float64   `db:"latest"`
+	LatestAt time.Time `db:"latest_at"`
+}
+
+// ZoneAlarmSummary defines the summary of the open (unacknowledged and
+// unresolved) automation alarms of the devices within a zone.
+type ZoneAlarmSummary struct {
+	Zone            string     `db:"zone"`
+	OpenAlarms      int        `db:"open_alarms"`
+	EscalatedAlarms int        `db:"escalated_alarms"`
+	OldestOpenAt    *time.Time `db:"oldest_open_at"`
+}
+
+// ZoneFilters provides filters for filtering the zones of an application.
+// The zones are defined by the values of the TagKey device tag. When Zone is
+// set, only this zone is returned.
+type ZoneFilters struct {
+	ApplicationID int64  `db:"application_id"`
+	TagKey        string `db:"tag_key"`
+	Zone          string `db:"zone"`
+
+	// Start, End and Measurements only apply to the zone measurements. When
+	// Measurements is empty, all measurements are aggregated.
+	Start        time.Time      `db:"start"`
+	End          time.Time      `db:"end"`
+	Measurements pq.StringArray `db:"measurements"`
+}
+
+// SQL returns the SQL filters on the devices (aliased as d).
+func (f ZoneFilters) SQL() string {
+	filters := []string{
+		"d.application_id = :application_id",
+		"d.tags ? :tag_key",
+	}
+
+	if f.Zone != "" {
+		filters = append(filters, "d.tags -> :tag_key = :zone")
+	}
+
+	return "where " + strings.Join(filters, " and ")
+}
+
+// GetZones returns the zones matching the given filters, sorted by zone.
+func GetZones(ctx context.Context, db sqlx.Queryer, filters ZoneFilters) ([]Zone, error) {
+	defer observeQueryDuration("zones_get", time.Now())
+
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			d.tags -> :tag_key as zone,
+			count(*) as devices,
+			max(d.last_seen_at) as last_seen_at
+		from
+			device d
+		`+filters.SQL()+`
+		group by
+			1
+		order by
+			1`, filters)
+	if err != nil {
+		return nil, errors.Wrap(err, "named query error")
+	}
+
+	var out []Zone
+	if err := sqlx.Select(db, &out, query, args...); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetZoneMeasurements returns per zone the aggregated measurement values
+// received within the time range of the given filters, sorted by zone and
+// measurement name. This is based on the stored device metrics, when the
+// schema-per-organization mode is enabled this must be called using
+// ForOrganization.
+func GetZoneMeasurements(ctx context.Context, db sqlx.Queryer, filters ZoneFilters) ([]ZoneMeasurement, error) {
+	defer observeQueryDuration("zone_measurements_get", time.Now())
+
+	measurements := ""
+	if len(filters.Measurements) != 0 {
+		measurements = "and m.name = any(:measurements)"
+	}
+
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			d.tags -> :tag_key as zone,
+			m.name,
+			count(*) as count,
+			min(m.value) as min,
+			max(m.value) as max,
+			avg(m.value) as avg,
+			(array_agg(m.value order by m.time desc))[1] as latest,
+			max(m.time) as latest_at
+		from
+			device_metric m
+		inner join device d
+			on d.dev_eui = m.dev_eui
+		`+filters.SQL()+`
+			and m.application_id = :application_id
+			and m.time >= :start
+			and m.time < :end
+			`+measurements+`
+		group by
+			1, 2
+		order by
+			1, 2`, filters)
+	if err != nil {
+		return nil, errors.Wrap(err, "named query error")
+	}
+
+	var out []ZoneMeasurement
+	if err := sqlx.Select(db, &out, query, args...); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// GetZoneAlarmSummaries returns per zone the summary of the open automation
+// alarms, sorted by zone. Zones without open alarms are omitted.
+func GetZoneAlarmSummaries(ctx context.Context, db sqlx.Queryer, filters ZoneFilters) ([]ZoneAlarmSummary, error) {
+	defer observeQueryDuration("zone_alarm_summaries_get", time.Now())
+
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			d.tags -> :tag_key as zone,
+			count(*) as open_alarms,
+			count(*) filter (where a.escalation_level > 0) as escalated_alarms,
+			min(a.created_at) as oldest_open_at
+		from
+			automation_alarm a
+		inner join device d
+			on d.dev_eui = a.dev_eui
+		`+filters.SQL()+`
+			and a.acknowledged_at is null
+			and a.resolved_at is null
+		group by
+			1
+		order by
+			1`, filters)
+	if err != nil {
+		return nil, errors.Wrap(err, "named query error")
+	}
+
+	var out []ZoneAlarmSummary
+	if err := sqlx.Select(db, &out, query, args...); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/lib/pq/hstore"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ibrahimozekici/app-server2/internal/automation/spec"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver"
+	"github.com/ibrahimozekici/app-server2/internal/backend/networkserver/mock"
+	//"github.com/brocaar/lorawan"
+)
+
+func (ts *StorageTestSuite) TestZone() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(ctx, ts.Tx(), &org))
+
+	n := NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(CreateNetworkServer(ctx, ts.Tx(), &n))
+
+	sp := ServiceProfile{
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+		Name:            "test-sp",
+	}
+	assert.NoError(CreateServiceProfile(ctx, ts.Tx(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(ctx, ts.Tx(), &app))
+
+	dp := DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateDeviceProfile(ctx, ts.Tx(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	zoneTags := func(zone string) hstore.Hstore {
+		return hstore.Hstore{
+			Map: map[string]sql.NullString{
+				"zone": {String: zone, Valid: true},
+			},
+		}
+	}
+
+	devices := []Device{
+		{DevEUI: lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 1}, Name: "greenhouse-1", Tags: zoneTags("greenhouse")},
+		{DevEUI: lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 2}, Name: "greenhouse-2", Tags: zoneTags("greenhouse")},
+		{DevEUI: lorawan.EUI64{2, 2, 2, 2, 2, 2, 2, 1}, Name: "field-1", Tags: zoneTags("field")},
+		{DevEUI: lorawan.EUI64{3, 3, 3, 3, 3, 3, 3, 1}, Name: "no-zone"},
+	}
+	for i := range devices {
+		devices[i].ApplicationID = app.ID
+		devices[i].DeviceProfileID = dpID
+		assert.NoError(CreateDevice(ctx, ts.Tx(), &devices[i]))
+	}
+
+	now := time.Now().Round(time.Second)
+	assert.NoError(CreateDeviceMetrics(ctx, ts.Tx(), []DeviceMetric{
+		{DevEUI: devices[0].DevEUI, ApplicationID: app.ID, Time: now.Add(-2 * time.Hour), Name: "temperature", Value: 18},
+		{DevEUI: devices[1].DevEUI, ApplicationID: app.ID, Time: now.Add(-time.Hour), Name: "temperature", Value: 24},
+		{DevEUI: devices[0].DevEUI, ApplicationID: app.ID, Time: now.Add(-30 * time.Minute), Name: "temperature", Value: 21},
+		{DevEUI: devices[0].DevEUI, ApplicationID: app.ID, Time: now.Add(-30 * time.Minute), Name: "humidity", Value: 80},
+		{DevEUI: devices[2].DevEUI, ApplicationID: app.ID, Time: now.Add(-time.Hour), Name: "temperature", Value: 12},
+		{DevEUI: devices[3].DevEUI, ApplicationID: app.ID, Time: now.Add(-time.Hour), Name: "temperature", Value: 30},
+	}))
+
+	a := Automation{
+		ApplicationID: app.ID,
+		Name:          "offline",
+		Enabled:       true,
+		Trigger: spec.Trigger{
+			Type:           spec.DeviceOfflineTrigger,
+			TimeoutSeconds: 3600,
+		},
+		Actions: spec.Actions{
+			{Type: spec.WebhookAction, URL: "http://localhost/hook"},
+		},
+	}
+	assert.NoError(CreateAutomation(ctx, ts.Tx(), &a))
+
+	alarms := []AutomationAlarm{
+		{AutomationID: a.ID, DevEUI: devices[0].DevEUI, CreatedAt: now.Add(-time.Hour), EscalationLevel: 1},
+		{AutomationID: a.ID, DevEUI: devices[1].DevEUI, CreatedAt: now.Add(-time.Minute)},
+	}
+	for i := range alarms {
+		assert.NoError(CreateAutomationAlarm(ctx, ts.Tx(), &alarms[i]))
+	}
+
+	filters := ZoneFilters{
+		ApplicationID: app.ID,
+		TagKey:        "zone",
+		Start:         now.Add(-90 * time.Minute),
+		End:           now,
+	}
+
+	ts.T().Run("Zones", func(t *testing.T) {
+		assert := require.New(t)
+
+		zones, err := GetZones(ctx, ts.Tx(), filters)
+		assert.NoError(err)
+		assert.Len(zones, 2)
+		assert.Equal("field", zones[0].Zone)
+		assert.Equal(1, zones[0].Devices)
+		assert.Equal("greenhouse", zones[1].Zone)
+		assert.Equal(2, zones[1].Devices)
+
+		f := filters
+		f.Zone = "greenhouse"
+		zones, err = GetZones(ctx, ts.Tx(), f)
+		assert.NoError(err)
+		assert.Len(zones, 1)
+
+		f.TagKey = "site"
+		zones, err = GetZones(ctx, ts.Tx(), f)
+		assert.NoError(err)
+		assert.Len(zones, 0)
+	})
+
+	ts.T().Run("Measurements", func(t *testing.T) {
+		assert := require.New(t)
+
+		items, err := GetZoneMeasurements(ctx, ts.Tx(), filters)
+		assert.NoError(err)
+		assert.Len(items, 3)
+
+		assert.Equal("field", items[0].Zone)
+		assert.Equal("greenhouse", items[1].Zone)
+		assert.Equal("humidity", items[1].Name)
+
+		// the reading of 2 hours ago is outside the window
+		temp := items[2]
+		assert.Equal("greenhouse", temp.Zone)
+		assert.Equal("temperature", temp.Name)
+		assert.Equal(2, temp.Count)
+		assert.Equal(float64(21), temp.Min)
+		assert.Equal(float64(24), temp.Max)
+		assert.Equal(22.5, temp.Avg)
+		assert.Equal(float64(21), temp.Latest)
+		assert.True(now.Add(-30 * time.Minute).Equal(temp.LatestAt))
+
+		f := filters
+		f.Measurements = []string{"humidity"}
+		items, err = GetZoneMeasurements(ctx, ts.Tx(), f)
+		assert.NoError(err)
+		assert.Len(items, 1)
+		assert.Equal("humidity", items[0].Name)
+	})
+
+	ts.T().Run("Alarm summaries", func(t *testing.T) {
+		assert := require.New(t)
+
+		items, err := GetZoneAlarmSummaries(ctx, ts.Tx(), filters)
+		assert.NoError(err)
+		assert.Len(items, 1)
+		assert.Equal("greenhouse", items[0].Zone)
+		assert.Equal(2, items[0].OpenAlarms)
+		assert.Equal(1, items[0].EscalatedAlarms)
+		assert.True(now.Add(-time.Hour).Equal(*items[0].OldestOpenAt))
+
+		assert.NoError(AcknowledgeAutomationAlarm(ctx, ts.Tx(), alarms[0].ID, "operator@example.com", ""))
+
+		items, err = GetZoneAlarmSummaries(ctx, ts.Tx(), filters)
+		assert.NoError(err)
+		assert.Len(items, 1)
+		assert.Equal(1, items[0].OpenAlarms)
+		assert.Equal(0, items[0].EscalatedAlarms)
+	})
+}
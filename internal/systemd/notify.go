@@ -0,0 +1,47 @@
+// Package systemd implements the sd_notify protocol, used to inform systemd
+// about the state of the application-server when running as a service with
+// Type=notify.
+package systemd
+
+import (
+	"net"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Notification states.
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+)
+
+// Notify sends the given state to the socket set by the NOTIFY_SOCKET
+// environment variable. It returns false when the environment variable is
+// not set, e.g. when not started by systemd.
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+
+	// an abstract socket is prefixed with @
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{
+		Name: socket,
+		Net:  "unixgram",
+	})
+	if err != nil {
+		return false, errors.Wrap(err, "dial notify socket error")
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, errors.Wrap(err, "write notify socket error")
+	}
+
+	return true, nil
+}
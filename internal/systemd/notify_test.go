@@ -0,0 +1,47 @@
+package systemd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotify(t *testing.T) {
+	t.Run("Not set", func(t *testing.T) {
+		assert := require.New(t)
+		os.Unsetenv("NOTIFY_SOCKET")
+
+		ok, err := Notify(Ready)
+		assert.NoError(err)
+		assert.False(ok)
+	})
+
+	t.Run("Set", func(t *testing.T) {
+		assert := require.New(t)
+
+		dir, err := ioutil.TempDir("", "systemd")
+		assert.NoError(err)
+		defer os.RemoveAll(dir)
+
+		socket := filepath.Join(dir, "notify.sock")
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+		assert.NoError(err)
+		defer conn.Close()
+
+		os.Setenv("NOTIFY_SOCKET", socket)
+		defer os.Unsetenv("NOTIFY_SOCKET")
+
+		ok, err := Notify(Stopping)
+		assert.NoError(err)
+		assert.True(ok)
+
+		b := make([]byte, 64)
+		n, err := conn.Read(b)
+		assert.NoError(err)
+		assert.Equal(Stopping, string(b[:n]))
+	})
+}
@@ -0,0 +1,39 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestMetadataCarrier(t *testing.T) {
+	assert := require.New(t)
+
+	traceID, err := trace.TraceIDFromHex("0102030405060708090a0b0c0d0e0f10")
+	assert.NoError(err)
+	spanID, err := trace.SpanIDFromHex("0102030405060708")
+	assert.NoError(err)
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	})
+
+	md := metadata.MD{}
+	p := propagation.TraceContext{}
+	p.Inject(trace.ContextWithSpanContext(context.Background(), sc), metadataCarrier(md))
+
+	assert.Equal([]string{"00-0102030405060708090a0b0c0d0e0f10-0102030405060708-01"}, md.Get("traceparent"))
+	assert.Equal([]string{"traceparent"}, metadataCarrier(md).Keys())
+
+	ctx := p.Extract(context.Background(), metadataCarrier(md))
+	out := trace.SpanContextFromContext(ctx)
+	assert.Equal(traceID, out.TraceID())
+	assert.Equal(spanID, out.SpanID())
+	assert.True(out.IsRemote())
+}
@@ -0,0 +1,96 @@
+// Package tracing implements the OpenTelemetry tracing setup and helpers.
+package tracing
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+)
+
+const tracerName = "github.com/ibrahimozekici/app-server2"
+
+var tracerProvider *sdktrace.TracerProvider
+
+// Setup configures the tracing package. Tracing is disabled when no OTLP
+// endpoint is configured.
+func Setup(c config.Config) error {
+	conf := c.Monitoring.Tracing
+	if conf.OTLPEndpoint == "" {
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"otlp_endpoint": conf.OTLPEndpoint,
+		"sample_ratio":  conf.SampleRatio,
+	}).Info("tracing: setting up opentelemetry tracing")
+
+	opts := []otlptracegrpc.Option{
+		otlptracegrpc.WithEndpoint(conf.OTLPEndpoint),
+	}
+	if conf.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+
+	exp, err := otlptracegrpc.New(context.Background(), opts...)
+	if err != nil {
+		return errors.Wrap(err, "new otlp exporter error")
+	}
+
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(conf.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", conf.ServiceName),
+		)),
+	)
+
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return nil
+}
+
+// Shutdown flushes the pending spans and stops the exporter.
+func Shutdown(ctx context.Context) error {
+	if tracerProvider == nil {
+		return nil
+	}
+
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		return errors.Wrap(err, "shutdown tracer-provider error")
+	}
+	return nil
+}
+
+// StartSpan starts a new span as child of the span in the given context.
+// When tracing is disabled, a no-op span is returned.
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// EndSpan records the given error (if any) and ends the span.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// WithSpanContext returns a copy of ctx which holds the span-context of the
+// span in parent. This is used to continue the trace in a context which
+// outlives parent, e.g. in Go-routines handling the integrations.
+func WithSpanContext(ctx, parent context.Context) context.Context {
+	return trace.ContextWithSpanContext(ctx, trace.SpanContextFromContext(parent))
+}
@@ -0,0 +1,59 @@
+// Package usage periodically stores the metered per organization usage.
+package usage
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ibrahimozekici/app-server2/internal/config"
+	"github.com/ibrahimozekici/app-server2/internal/logging"
+	"github.com/ibrahimozekici/app-server2/internal/storage"
+)
+
+var flushInterval = 5 * time.Minute
+
+// Setup configures the package and starts the flush loop.
+func Setup(conf config.Config) error {
+	if conf.ApplicationServer.Usage.FlushInterval > 0 {
+		flushInterval = conf.ApplicationServer.Usage.FlushInterval
+	}
+
+	go flushLoop()
+
+	return nil
+}
+
+func flushLoop() {
+	for {
+		time.Sleep(flushInterval)
+
+		ctxID, err := uuid.NewV4()
+		if err != nil {
+			log.WithError(err).Error("new uuid error")
+		}
+
+		ctx := context.Background()
+		ctx = context.WithValue(ctx, logging.ContextIDKey, ctxID)
+
+		if err := Flush(ctx, time.Now()); err != nil {
+			log.WithError(err).WithField("ctx_id", ctxID).Error("usage: flush error")
+		}
+	}
+}
+
+// Flush stores the usage counters of the current and the previous (UTC) day.
+// The previous day is included so that the usage metered between the last
+// flush and midnight is not lost.
+func Flush(ctx context.Context, now time.Time) error {
+	for _, day := range []time.Time{now.AddDate(0, 0, -1), now} {
+		if err := storage.FlushOrganizationUsage(ctx, storage.DB(), day); err != nil {
+			return errors.Wrapf(err, "flush organization usage error, date: %s", day.UTC().Format("2006-01-02"))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+-- +migrate Up
+create table device_frame_log (
+	dev_eui bytea not null,
+	application_id bigint not null,
+	received_at timestamp with time zone not null,
+	dev_addr bytea not null,
+	f_cnt bigint not null,
+	f_port smallint not null,
+	dr smallint not null,
+	adr boolean not null,
+	confirmed_uplink boolean not null,
+	data bytea,
+	object jsonb,
+	rx_info jsonb
+) partition by range (received_at);
+
+create index idx_device_frame_log_dev_eui_received_at on device_frame_log(dev_eui, received_at);
+create index idx_device_frame_log_application_id_received_at on device_frame_log(application_id, received_at);
+
+create table device_metric (
+	dev_eui bytea not null,
+	application_id bigint not null,
+	time timestamp with time zone not null,
+	name varchar(100) not null,
+	value double precision not null
+) partition by range (time);
+
+create index idx_device_metric_dev_eui_name_time on device_metric(dev_eui, name, time);
+create index idx_device_metric_application_id_time on device_metric(application_id, time);
+
+-- +migrate Down
+drop table device_metric;
+drop table device_frame_log;
@@ -0,0 +1,13 @@
+-- +migrate Up
+create table application_retention (
+	application_id bigint primary key references application on delete cascade,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	events_days integer not null default 0,
+	metrics_days integer not null default 0,
+	frames_days integer not null default 0,
+	locations_days integer not null default 0
+);
+
+-- +migrate Down
+drop table application_retention;
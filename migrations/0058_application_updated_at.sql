@@ -0,0 +1,13 @@
+-- +migrate Up
+alter table application
+	add column created_at timestamp with time zone not null default now(),
+	add column updated_at timestamp with time zone not null default now();
+
+alter table application
+	alter column created_at drop default,
+	alter column updated_at drop default;
+
+-- +migrate Down
+alter table application
+	drop column updated_at,
+	drop column created_at;
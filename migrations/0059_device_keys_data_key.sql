@@ -0,0 +1,23 @@
+-- +migrate Up
+create table device_keys_data_key (
+	id bigserial primary key,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	provider varchar(20) not null,
+	wrapped_key bytea not null
+);
+
+alter table device_keys
+	add column data_key_id bigint references device_keys_data_key on delete restrict,
+	add column encrypted_keys bytea;
+
+create index idx_device_keys_data_key_id on device_keys(data_key_id);
+
+-- +migrate Down
+drop index idx_device_keys_data_key_id;
+
+alter table device_keys
+	drop column encrypted_keys,
+	drop column data_key_id;
+
+drop table device_keys_data_key;
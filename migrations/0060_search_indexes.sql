@@ -0,0 +1,5 @@
+-- +migrate Up
+create index idx_organization_display_name_trgm on organization using gin (display_name gin_trgm_ops);
+
+-- +migrate Down
+drop index idx_organization_display_name_trgm;
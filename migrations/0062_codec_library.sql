@@ -0,0 +1,37 @@
+-- +migrate Up
+create table codec (
+	id uuid primary key,
+	organization_id bigint not null references organization on delete cascade,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	name varchar(100) not null,
+	description text not null default '',
+	payload_codec text not null,
+	current_version integer not null default 0,
+	latest_version integer not null default 0,
+	unique (organization_id, name)
+);
+
+create table codec_version (
+	codec_id uuid not null references codec on delete cascade,
+	version integer not null,
+	created_at timestamp with time zone not null,
+	description text not null default '',
+	encoder_script text not null default '',
+	decoder_script text not null default '',
+	primary key (codec_id, version)
+);
+
+alter table device_profile
+	add column codec_id uuid null references codec;
+
+create index idx_device_profile_codec_id on device_profile(codec_id);
+
+-- +migrate Down
+drop index idx_device_profile_codec_id;
+
+alter table device_profile
+	drop column codec_id;
+
+drop table codec_version;
+drop table codec;
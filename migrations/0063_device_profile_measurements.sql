@@ -0,0 +1,7 @@
+-- +migrate Up
+alter table device_profile
+	add column measurements jsonb not null default '{}';
+
+-- +migrate Down
+alter table device_profile
+	drop column measurements;
@@ -0,0 +1,26 @@
+-- +migrate Up
+alter table device_profile
+	add column confirmed_downlink_retry jsonb not null default '{}';
+
+create table confirmed_downlink (
+	dev_eui bytea not null references device on delete cascade,
+	f_cnt bigint not null,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	f_port smallint not null,
+	data bytea not null,
+	retry_count smallint not null default 0,
+	retry_after timestamp with time zone null,
+	retry_uplinks integer not null default 0,
+
+	primary key (dev_eui, f_cnt)
+);
+
+create index idx_confirmed_downlink_retry_after on confirmed_downlink(retry_after) where retry_after is not null;
+
+-- +migrate Down
+drop index idx_confirmed_downlink_retry_after;
+drop table confirmed_downlink;
+
+alter table device_profile
+	drop column confirmed_downlink_retry;
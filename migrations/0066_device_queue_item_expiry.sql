@@ -0,0 +1,16 @@
+-- +migrate Up
+create table device_queue_item_expiry (
+	dev_eui bytea not null references device on delete cascade,
+	f_cnt bigint not null,
+	created_at timestamp with time zone not null,
+	f_port smallint not null,
+	expires_at timestamp with time zone not null,
+
+	primary key (dev_eui, f_cnt)
+);
+
+create index idx_device_queue_item_expiry_expires_at on device_queue_item_expiry(expires_at);
+
+-- +migrate Down
+drop index idx_device_queue_item_expiry_expires_at;
+drop table device_queue_item_expiry;
@@ -0,0 +1,10 @@
+-- +migrate Up
+create table device_class_b_status (
+	dev_eui bytea primary key references device on delete cascade,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	beacon_locked boolean not null
+);
+
+-- +migrate Down
+drop table device_class_b_status;
@@ -0,0 +1,30 @@
+-- +migrate Up
+create table downlink_template (
+	id uuid primary key,
+	application_id bigint not null references application on delete cascade,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	name varchar(100) not null,
+	description text not null default '',
+	f_port smallint not null,
+	confirmed boolean not null default false,
+	object_template text not null,
+	unique (application_id, name)
+);
+
+create table downlink_template_invocation (
+	id bigserial primary key,
+	downlink_template_id uuid not null references downlink_template on delete cascade,
+	created_at timestamp with time zone not null,
+	dev_eui bytea not null,
+	f_cnt bigint not null,
+	parameters jsonb not null,
+	invoked_by text not null default ''
+);
+
+create index idx_downlink_template_invocation_template_created_at on downlink_template_invocation(downlink_template_id, created_at);
+
+-- +migrate Down
+drop index idx_downlink_template_invocation_template_created_at;
+drop table downlink_template_invocation;
+drop table downlink_template;
@@ -0,0 +1,13 @@
+-- +migrate Up
+create table device_queue_item_object (
+	dev_eui bytea not null references device on delete cascade,
+	f_cnt bigint not null,
+	created_at timestamp with time zone not null,
+	f_port smallint not null,
+	object jsonb not null,
+
+	primary key (dev_eui, f_cnt)
+);
+
+-- +migrate Down
+drop table device_queue_item_object;
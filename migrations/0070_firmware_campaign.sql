@@ -0,0 +1,44 @@
+-- +migrate Up
+create table firmware_campaign (
+	id uuid primary key,
+	application_id bigint not null references application on delete cascade,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	name varchar(100) not null,
+	f_port smallint not null,
+	payload bytea not null,
+	chunk_size smallint not null,
+	spreading_factor smallint not null,
+	bandwidth integer not null,
+	duty_cycle double precision not null,
+	chunk_interval bigint not null,
+	state varchar(20) not null,
+	completed_at timestamp with time zone null
+);
+
+create index idx_firmware_campaign_application_id on firmware_campaign(application_id);
+
+create table firmware_campaign_device (
+	firmware_campaign_id uuid not null references firmware_campaign on delete cascade,
+	dev_eui bytea not null references device on delete cascade,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	state varchar(20) not null,
+	next_chunk integer not null default 0,
+	chunk_count integer not null,
+	next_chunk_after timestamp with time zone not null,
+	error_message text not null default '',
+	completed_at timestamp with time zone null,
+
+	primary key (firmware_campaign_id, dev_eui)
+);
+
+create index idx_firmware_campaign_device_state_next_chunk_after on firmware_campaign_device(state, next_chunk_after);
+create index idx_firmware_campaign_device_dev_eui on firmware_campaign_device(dev_eui);
+
+-- +migrate Down
+drop index idx_firmware_campaign_device_dev_eui;
+drop index idx_firmware_campaign_device_state_next_chunk_after;
+drop table firmware_campaign_device;
+drop index idx_firmware_campaign_application_id;
+drop table firmware_campaign;
@@ -0,0 +1,19 @@
+-- +migrate Up
+create table downlink_correlation (
+	id uuid primary key,
+	dev_eui bytea not null references device on delete cascade,
+	f_cnt bigint not null,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	f_port smallint not null,
+	confirmed boolean not null,
+	tx_ack_at timestamp with time zone null,
+	ack_at timestamp with time zone null,
+	acknowledged boolean null
+);
+
+create index idx_downlink_correlation_dev_eui_f_cnt on downlink_correlation(dev_eui, f_cnt, created_at);
+
+-- +migrate Down
+drop index idx_downlink_correlation_dev_eui_f_cnt;
+drop table downlink_correlation;
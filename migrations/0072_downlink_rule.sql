@@ -0,0 +1,19 @@
+-- +migrate Up
+create table downlink_rule (
+	id uuid primary key,
+	application_id bigint not null references application on delete cascade,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	name varchar(100) not null,
+	enabled boolean not null default true,
+	uplink_f_port smallint not null default 0,
+	conditions jsonb not null,
+	f_port smallint not null,
+	confirmed boolean not null default false,
+	object text not null,
+	cooldown bigint not null,
+	unique (application_id, name)
+);
+
+-- +migrate Down
+drop table downlink_rule;
@@ -0,0 +1,7 @@
+-- +migrate Up
+alter table device_keys
+	add column hsm boolean not null default false;
+
+-- +migrate Down
+alter table device_keys
+	drop column hsm;
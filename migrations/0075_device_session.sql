@@ -0,0 +1,19 @@
+-- +migrate Up
+create table device_session (
+	id bigserial primary key,
+	dev_eui bytea not null references device on delete cascade,
+	created_at timestamp with time zone not null,
+	join_type varchar(10) not null,
+	sender_id varchar(20) not null,
+	mac_version varchar(10) not null,
+	dev_addr bytea not null,
+	join_nonce integer not null,
+	dev_nonce integer not null default 0,
+	rj_count integer not null default 0
+);
+
+create index idx_device_session_dev_eui_created_at on device_session(dev_eui, created_at);
+
+-- +migrate Down
+drop index idx_device_session_dev_eui_created_at;
+drop table device_session;
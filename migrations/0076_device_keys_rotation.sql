@@ -0,0 +1,30 @@
+-- +migrate Up
+create table device_keys_rotation (
+	dev_eui bytea not null references device on delete cascade,
+	kind varchar(10) not null,
+	created_at timestamp with time zone not null,
+	nwk_key bytea not null,
+	app_key bytea not null,
+	gen_app_key bytea not null,
+	data_key_id bigint references device_keys_data_key on delete restrict,
+	encrypted_keys bytea,
+	primary key (dev_eui, kind)
+);
+
+create index idx_device_keys_rotation_data_key_id on device_keys_rotation(data_key_id);
+
+create table device_keys_rotation_log (
+	id bigserial primary key,
+	dev_eui bytea not null references device on delete cascade,
+	created_at timestamp with time zone not null,
+	action varchar(20) not null,
+	invoked_by text not null default ''
+);
+
+create index idx_device_keys_rotation_log_dev_eui_created_at on device_keys_rotation_log(dev_eui, created_at);
+
+-- +migrate Down
+drop index idx_device_keys_rotation_log_dev_eui_created_at;
+drop table device_keys_rotation_log;
+drop index idx_device_keys_rotation_data_key_id;
+drop table device_keys_rotation;
@@ -0,0 +1,16 @@
+-- +migrate Up
+create table organization_usage (
+	organization_id bigint not null references organization on delete cascade,
+	date date not null,
+	uplinks bigint not null default 0,
+	downlinks bigint not null default 0,
+	stored_bytes bigint not null default 0,
+	geolocation_calls bigint not null default 0,
+	primary key (organization_id, date)
+);
+
+create index idx_organization_usage_date on organization_usage(date);
+
+-- +migrate Down
+drop index idx_organization_usage_date;
+drop table organization_usage;
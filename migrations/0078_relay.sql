@@ -0,0 +1,51 @@
+-- +migrate Up
+create table relay (
+	dev_eui bytea primary key references device on delete cascade,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	enabled boolean not null default true,
+	cad_periodicity smallint not null default 0,
+	default_channel_index smallint not null default 0,
+	second_channel_freq bigint not null default 0,
+	second_channel_dr smallint not null default 0,
+	second_channel_ack_offset smallint not null default 0
+);
+
+create table relay_device (
+	relay_dev_eui bytea not null references relay on delete cascade,
+	dev_eui bytea not null references device on delete cascade,
+	created_at timestamp with time zone not null,
+	primary key (relay_dev_eui, dev_eui)
+);
+
+create index idx_relay_device_dev_eui on relay_device(dev_eui);
+
+create table relay_filter (
+	relay_dev_eui bytea not null references relay on delete cascade,
+	filter_index smallint not null,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	action smallint not null,
+	join_eui bytea,
+	dev_eui bytea,
+	primary key (relay_dev_eui, filter_index)
+);
+
+create table relay_end_device (
+	dev_eui bytea primary key references device on delete cascade,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	mode smallint not null,
+	smart_enable_level smallint not null default 0,
+	backoff smallint not null default 0,
+	second_channel_freq bigint not null default 0,
+	second_channel_dr smallint not null default 0,
+	second_channel_ack_offset smallint not null default 0
+);
+
+-- +migrate Down
+drop table relay_end_device;
+drop table relay_filter;
+drop index idx_relay_device_dev_eui;
+drop table relay_device;
+drop table relay;
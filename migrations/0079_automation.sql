@@ -0,0 +1,39 @@
+-- +migrate Up
+create table automation (
+	id uuid primary key,
+	application_id bigint not null references application on delete cascade,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	name varchar(100) not null,
+	enabled boolean not null default true,
+	device_tags hstore not null default '',
+	trigger jsonb not null,
+	actions jsonb not null,
+	cooldown bigint not null,
+	scheduled_at timestamp with time zone,
+	unique (application_id, name)
+);
+
+create index idx_automation_trigger_type on automation((trigger->>'type'));
+
+create table automation_execution (
+	id bigserial primary key,
+	automation_id uuid not null references automation on delete cascade,
+	created_at timestamp with time zone not null,
+	dev_eui bytea not null,
+	trigger_type varchar(20) not null,
+	success boolean not null,
+	results jsonb not null
+);
+
+create index idx_automation_execution_automation_id_dev_eui_created_at on automation_execution(automation_id, dev_eui, created_at);
+create index idx_automation_execution_automation_id_created_at on automation_execution(automation_id, created_at);
+create index idx_automation_execution_created_at on automation_execution(created_at);
+
+-- +migrate Down
+drop index idx_automation_execution_created_at;
+drop index idx_automation_execution_automation_id_created_at;
+drop index idx_automation_execution_automation_id_dev_eui_created_at;
+drop table automation_execution;
+drop index idx_automation_trigger_type;
+drop table automation;
@@ -0,0 +1,31 @@
+-- +migrate Up
+alter table automation
+	add column escalation jsonb not null default '[]';
+
+create table automation_alarm (
+	id uuid primary key,
+	automation_id uuid not null references automation on delete cascade,
+	dev_eui bytea not null references device on delete cascade,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	escalation_level smallint not null default 0,
+	escalated_at timestamp with time zone,
+	next_escalation_at timestamp with time zone,
+	acknowledged_at timestamp with time zone,
+	acknowledged_by varchar(100) not null default ''
+);
+
+create unique index idx_automation_alarm_automation_id_dev_eui_open on automation_alarm(automation_id, dev_eui) where acknowledged_at is null;
+create index idx_automation_alarm_automation_id_created_at on automation_alarm(automation_id, created_at);
+create index idx_automation_alarm_next_escalation_at on automation_alarm(next_escalation_at) where acknowledged_at is null;
+create index idx_automation_alarm_dev_eui on automation_alarm(dev_eui);
+
+-- +migrate Down
+drop index idx_automation_alarm_dev_eui;
+drop index idx_automation_alarm_next_escalation_at;
+drop index idx_automation_alarm_automation_id_created_at;
+drop index idx_automation_alarm_automation_id_dev_eui_open;
+drop table automation_alarm;
+
+alter table automation
+	drop column escalation;
@@ -0,0 +1,22 @@
+-- +migrate Up
+create table maintenance_window (
+	id uuid primary key,
+	application_id bigint not null references application on delete cascade,
+	dev_eui bytea references device on delete cascade,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	name varchar(100) not null,
+	device_tags hstore not null default '',
+	starts_at timestamp with time zone not null,
+	duration bigint not null,
+	recurrence varchar(10) not null,
+	until timestamp with time zone
+);
+
+create index idx_maintenance_window_application_id_starts_at on maintenance_window(application_id, starts_at);
+create index idx_maintenance_window_dev_eui on maintenance_window(dev_eui);
+
+-- +migrate Down
+drop index idx_maintenance_window_dev_eui;
+drop index idx_maintenance_window_application_id_starts_at;
+drop table maintenance_window;
@@ -0,0 +1,42 @@
+-- +migrate Up
+alter table automation
+	add column alarm boolean not null default false;
+
+alter table automation_alarm
+	add column trigger_value jsonb not null default '{}',
+	add column resolved_at timestamp with time zone,
+	add column resolve_value jsonb not null default '{}',
+	add column acknowledgment_comment text not null default '';
+
+drop index idx_automation_alarm_automation_id_dev_eui_open;
+create unique index idx_automation_alarm_automation_id_dev_eui_open on automation_alarm(automation_id, dev_eui) where acknowledged_at is null and resolved_at is null;
+create index idx_automation_alarm_created_at on automation_alarm(created_at);
+
+create table automation_alarm_notification (
+	id bigserial primary key,
+	alarm_id uuid not null references automation_alarm on delete cascade,
+	created_at timestamp with time zone not null,
+	escalation_level smallint not null,
+	action_type varchar(20) not null,
+	recipients text[] not null,
+	error text not null default ''
+);
+
+create index idx_automation_alarm_notification_alarm_id on automation_alarm_notification(alarm_id);
+
+-- +migrate Down
+drop index idx_automation_alarm_notification_alarm_id;
+drop table automation_alarm_notification;
+
+drop index idx_automation_alarm_created_at;
+drop index idx_automation_alarm_automation_id_dev_eui_open;
+create unique index idx_automation_alarm_automation_id_dev_eui_open on automation_alarm(automation_id, dev_eui) where acknowledged_at is null;
+
+alter table automation_alarm
+	drop column acknowledgment_comment,
+	drop column resolve_value,
+	drop column resolved_at,
+	drop column trigger_value;
+
+alter table automation
+	drop column alarm;
@@ -0,0 +1,18 @@
+-- +migrate Up
+create table threshold_profile (
+	id uuid primary key,
+	organization_id bigint not null references organization on delete cascade,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	name varchar(100) not null,
+	description text not null default '',
+	thresholds jsonb not null
+);
+
+create index idx_threshold_profile_organization_id_name on threshold_profile(organization_id, name);
+create index idx_automation_trigger_threshold_profile_id on automation((trigger->>'thresholdProfileID'));
+
+-- +migrate Down
+drop index idx_automation_trigger_threshold_profile_id;
+drop index idx_threshold_profile_organization_id_name;
+drop table threshold_profile;
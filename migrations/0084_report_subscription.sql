@@ -0,0 +1,28 @@
+-- +migrate Up
+create table report_subscription (
+	id uuid primary key,
+	user_id bigint not null references "user" on delete cascade,
+	application_id bigint not null references application on delete cascade,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	name varchar(100) not null,
+	enabled boolean not null,
+	schedule varchar(10) not null,
+	format varchar(10) not null,
+	zone_tag_key varchar(100) not null default '',
+	zone varchar(100) not null default '',
+	measurements text[] not null default '{}',
+	next_run_at timestamp with time zone not null,
+	last_run_at timestamp with time zone,
+	last_error text not null default ''
+);
+
+create index idx_report_subscription_user_id on report_subscription(user_id);
+create index idx_report_subscription_application_id on report_subscription(application_id);
+create index idx_report_subscription_next_run_at on report_subscription(next_run_at) where enabled = true;
+
+-- +migrate Down
+drop index idx_report_subscription_next_run_at;
+drop index idx_report_subscription_application_id;
+drop index idx_report_subscription_user_id;
+drop table report_subscription;
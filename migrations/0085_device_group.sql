@@ -0,0 +1,28 @@
+-- +migrate Up
+create table device_group (
+	id uuid primary key,
+	application_id bigint not null references application on delete cascade,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	name varchar(100) not null,
+	description text not null default '',
+	type varchar(10) not null,
+	device_tags hstore not null default ''
+);
+
+create index idx_device_group_application_id on device_group(application_id);
+
+create table device_group_device (
+	device_group_id uuid not null references device_group on delete cascade,
+	dev_eui bytea not null references device on delete cascade,
+	created_at timestamp with time zone not null,
+	primary key (device_group_id, dev_eui)
+);
+
+create index idx_device_group_device_dev_eui on device_group_device(dev_eui);
+
+-- +migrate Down
+drop index idx_device_group_device_dev_eui;
+drop table device_group_device;
+drop index idx_device_group_application_id;
+drop table device_group;
@@ -0,0 +1,16 @@
+-- +migrate Up
+create table geofence (
+	id uuid primary key,
+	application_id bigint not null references application on delete cascade,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	name varchar(100) not null,
+	zone varchar(100) not null default '',
+	boundary jsonb not null
+);
+
+create index idx_geofence_application_id on geofence(application_id);
+
+-- +migrate Down
+drop index idx_geofence_application_id;
+drop table geofence;
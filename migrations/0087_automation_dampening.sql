@@ -0,0 +1,22 @@
+-- +migrate Up
+alter table automation
+	add column dampening jsonb not null default '{}';
+
+create table automation_notification_group (
+	id uuid primary key,
+	automation_id uuid not null references automation on delete cascade,
+	zone text not null,
+	created_at timestamp with time zone not null,
+	send_at timestamp with time zone not null,
+	events jsonb not null,
+	unique (automation_id, zone)
+);
+
+create index idx_automation_notification_group_send_at on automation_notification_group(send_at);
+
+-- +migrate Down
+drop index idx_automation_notification_group_send_at;
+drop table automation_notification_group;
+
+alter table automation
+	drop column dampening;
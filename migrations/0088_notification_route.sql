@@ -0,0 +1,55 @@
+-- +migrate Up
+alter table automation
+	add column severity varchar(10) not null default 'WARNING';
+
+alter table automation_alarm
+	add column severity varchar(10) not null default 'WARNING';
+
+create index idx_automation_alarm_severity on automation_alarm(severity);
+
+create table notification_route (
+	id uuid primary key,
+	organization_id bigint not null references organization on delete cascade,
+	application_id bigint references application on delete cascade,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	name varchar(100) not null,
+	min_severity varchar(10) not null,
+	channel varchar(10) not null,
+	recipients text[] not null
+);
+
+create index idx_notification_route_organization_id on notification_route(organization_id);
+create index idx_notification_route_application_id on notification_route(application_id);
+
+create table in_app_notification (
+	id bigserial primary key,
+	application_id bigint not null references application on delete cascade,
+	alarm_id uuid references automation_alarm on delete set null,
+	dev_eui bytea,
+	created_at timestamp with time zone not null,
+	severity varchar(10) not null,
+	subject varchar(200) not null,
+	message text not null,
+	read_at timestamp with time zone
+);
+
+create index idx_in_app_notification_application_id_created_at on in_app_notification(application_id, created_at);
+create index idx_in_app_notification_application_id_unread on in_app_notification(application_id) where read_at is null;
+
+-- +migrate Down
+drop index idx_in_app_notification_application_id_unread;
+drop index idx_in_app_notification_application_id_created_at;
+drop table in_app_notification;
+
+drop index idx_notification_route_application_id;
+drop index idx_notification_route_organization_id;
+drop table notification_route;
+
+drop index idx_automation_alarm_severity;
+
+alter table automation_alarm
+	drop column severity;
+
+alter table automation
+	drop column severity;
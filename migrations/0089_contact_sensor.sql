@@ -0,0 +1,29 @@
+-- +migrate Up
+create table contact_state (
+	dev_eui bytea not null references device on delete cascade,
+	name varchar(100) not null,
+	open boolean not null,
+	changed_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	primary key (dev_eui, name)
+);
+
+create index idx_contact_state_changed_at_open on contact_state(changed_at) where open;
+
+create table contact_event (
+	id bigserial primary key,
+	dev_eui bytea not null references device on delete cascade,
+	name varchar(100) not null,
+	time timestamp with time zone not null,
+	open boolean not null,
+	open_seconds double precision
+);
+
+create index idx_contact_event_dev_eui_time on contact_event(dev_eui, time);
+
+-- +migrate Down
+drop index idx_contact_event_dev_eui_time;
+drop table contact_event;
+
+drop index idx_contact_state_changed_at_open;
+drop table contact_state;
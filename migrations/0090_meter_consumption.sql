@@ -0,0 +1,24 @@
+-- +migrate Up
+create table meter_state (
+	dev_eui bytea not null references device on delete cascade,
+	name varchar(100) not null,
+	value double precision not null,
+	time timestamp with time zone not null,
+	primary key (dev_eui, name)
+);
+
+create table meter_consumption (
+	dev_eui bytea not null references device on delete cascade,
+	name varchar(100) not null,
+	date date not null,
+	consumption double precision not null,
+	readings integer not null,
+	primary key (dev_eui, name, date)
+);
+
+create index idx_meter_consumption_date on meter_consumption(date);
+
+-- +migrate Down
+drop index idx_meter_consumption_date;
+drop table meter_consumption;
+drop table meter_state;
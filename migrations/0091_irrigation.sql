@@ -0,0 +1,50 @@
+-- +migrate Up
+create table irrigation_schedule (
+	id uuid primary key,
+	application_id bigint not null references application on delete cascade,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	name varchar(100) not null,
+	enabled boolean not null default true,
+	schedule jsonb not null,
+	start_downlink jsonb not null,
+	stop_downlink jsonb,
+	duration bigint not null,
+	next_run_at timestamp with time zone,
+	stop_at timestamp with time zone,
+	paused_until timestamp with time zone,
+	unique (application_id, name)
+);
+
+create index idx_irrigation_schedule_next_run_at on irrigation_schedule(next_run_at) where enabled = true;
+create index idx_irrigation_schedule_stop_at on irrigation_schedule(stop_at);
+
+create table irrigation_schedule_device (
+	irrigation_schedule_id uuid not null references irrigation_schedule on delete cascade,
+	dev_eui bytea not null references device on delete cascade,
+	primary key (irrigation_schedule_id, dev_eui)
+);
+
+create index idx_irrigation_schedule_device_dev_eui on irrigation_schedule_device(dev_eui);
+
+create table irrigation_execution (
+	id bigserial primary key,
+	irrigation_schedule_id uuid not null references irrigation_schedule on delete cascade,
+	created_at timestamp with time zone not null,
+	dev_eui bytea not null,
+	action varchar(10) not null,
+	source varchar(10) not null,
+	success boolean not null,
+	error text not null default ''
+);
+
+create index idx_irrigation_execution_irrigation_schedule_id_created_at on irrigation_execution(irrigation_schedule_id, created_at);
+
+-- +migrate Down
+drop index idx_irrigation_execution_irrigation_schedule_id_created_at;
+drop table irrigation_execution;
+drop index idx_irrigation_schedule_device_dev_eui;
+drop table irrigation_schedule_device;
+drop index idx_irrigation_schedule_stop_at;
+drop index idx_irrigation_schedule_next_run_at;
+drop table irrigation_schedule;